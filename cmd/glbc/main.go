@@ -23,36 +23,60 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/golang/glog"
-
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+	gcfg "gopkg.in/gcfg.v1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
+	"k8s.io/ingress-gce/pkg/admission"
+	"k8s.io/ingress-gce/pkg/backendconfig"
 	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/cleanup"
+	"k8s.io/ingress-gce/pkg/cloudconfig"
+	"k8s.io/ingress-gce/pkg/clusteruid"
 	"k8s.io/ingress-gce/pkg/context"
 	"k8s.io/ingress-gce/pkg/controller"
+	"k8s.io/ingress-gce/pkg/dns"
+	"k8s.io/ingress-gce/pkg/dynamicconfig"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/frontendconfig"
+	"k8s.io/ingress-gce/pkg/gateway"
+	"k8s.io/ingress-gce/pkg/healthchecks"
+	"k8s.io/ingress-gce/pkg/l4"
+	"k8s.io/ingress-gce/pkg/leaderelection"
 	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
 	neg "k8s.io/ingress-gce/pkg/networkendpointgroup"
+	"k8s.io/ingress-gce/pkg/ratelimit"
+	"k8s.io/ingress-gce/pkg/regional"
+	"k8s.io/ingress-gce/pkg/serviceattachment"
 	"k8s.io/ingress-gce/pkg/storage"
+	"k8s.io/ingress-gce/pkg/tls"
+	"k8s.io/ingress-gce/pkg/tracing"
 	"k8s.io/ingress-gce/pkg/utils"
 
-	"k8s.io/kubernetes/pkg/cloudprovider"
 	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
 )
 
@@ -90,6 +114,13 @@ var (
 		`glbc: glbc --running-in-cluster=false`,
 		flag.ExitOnError)
 
+	resourcePrefix = flags.String("resource-prefix", utils.DefaultPrefix,
+		`Prefix of the names of all the GCE resources created by the
+		controller, eg: to tell apart the resources of several controllers
+		in one project, or to follow a naming policy. A lowercase letter
+		followed by at most 7 lowercase letters or digits. Changing it orphans
+		the resources named with the previous prefix, run --cleanup first.`)
+
 	clusterName = flags.String("cluster-uid", controller.DefaultClusterUID,
 		`Optional, used to tag cluster wide, shared loadbalancer resources such
 		 as instance groups. Use this flag if you'd like to continue using the
@@ -105,7 +136,11 @@ var (
 		"to connect to in the format of protocol://address:port, e.g., "+
 		"http://localhost:8080. If not specified, the assumption is that the binary runs inside a "+
 		"Kubernetes cluster and local discovery is attempted.")
-	kubeConfigFile = flags.String("kubeconfig", "", "Path to kubeconfig file with authorization and master location information.")
+	kubeConfigFile = flags.String("kubeconfig", "",
+		`Path to kubeconfig file with authorization and master location
+		information. If set, the controller runs out of the cluster, eg: on a
+		management cluster or a laptop, and --apiserver-host overrides the
+		master of the kubeconfig.`)
 
 	// TODO: Consolidate this flag and running-in-cluster. People already use
 	// the first one to mean "running in dev", unfortunately.
@@ -117,6 +152,44 @@ var (
 	resyncPeriod = flags.Duration("sync-period", 30*time.Second,
 		`Relist and confirm cloud resources this often.`)
 
+	configMap = flags.String("config-map", "",
+		`ConfigMap, as namespace/name, with settings applied without a restart
+		when it changes: full-sync-period, firewall-src-ranges, gce-ratelimits,
+		enable-finalizer and exclude-unready-nodes. Its settings take
+		precedence over the flags of the same name, the rate limits replace the
+		flag ones of their API groups only. Empty disables it.`)
+
+	multiClusterConfigUID = flags.String("multi-cluster-config-uid", "",
+		`UID of the config cluster of the multi-cluster Ingresses, see
+		--cluster-uid. If set, the instance groups or NEGs of the cluster are
+		added to the backend services the config cluster created for the
+		Services of the gce-multi-cluster Ingresses, with the same node ports.`)
+
+	concurrentIngressSyncs = flags.Int("concurrent-ingress-syncs", 1,
+		`Number of Ingresses synced concurrently. The resources shared by the
+		load balancers, eg: the instance groups and firewall rules, are still
+		synced by one Ingress at a time.`)
+
+	fullSyncPeriod = flags.Duration("full-sync-period", 5*time.Minute,
+		`Sync all the cloud resources of the load balancers this often. In
+		between, syncs skip the instance groups, backend services, instances,
+		load balancers and firewall rules whose inputs didn't change since
+		their last successful sync. If 0, all the resources are synced every
+		time.`)
+
+	cleanupMode = flags.Bool("cleanup", false,
+		`If true, the controller deletes all the GCE resources owned by the
+		cluster, eg: forwarding rules, target proxies, certificates, url maps,
+		backend services, health checks, NEGs, instance groups and firewall
+		rules, and exits instead of syncing Ingresses. The L4 load balancers,
+		PSC service attachments and regional HTTP(S) load balancers are
+		deleted too, in the region of the cluster, unless the cluster has no
+		UID. Used to tear a cluster down, or to migrate off the controller.
+		Other controllers of the cluster must be stopped first.`)
+
+	cleanupDryRun = flags.Bool("cleanup-dry-run", false,
+		`If true, --cleanup only logs the GCE resources it would delete.`)
+
 	deleteAllOnQuit = flags.Bool("delete-all-on-quit", false,
 		`If true, the controller will delete all Ingress and the associated
 		external cloud resources as it's shutting down. Mostly used for
@@ -126,65 +199,518 @@ var (
 	defaultSvc = flags.String("default-backend-service", "kube-system/default-http-backend",
 		`Service used to serve a 404 page for the default backend. Takes the form
 		namespace/name. The controller uses the first node port of this Service for
-		the default backend.`)
+		the default backend. If empty, the cluster has no default backend and
+		Ingresses without spec.backend are ignored.`)
 
 	healthCheckPath = flags.String("health-check-path", "/",
 		`Path used to health-check a backend service. All Services must serve
 		a 200 page on this path. Currently this is only configurable globally.`)
 
+	resetHealthChecks = flags.Bool("reset-health-checks", false,
+		`If true, health checks updated by the controller, eg: for a protocol
+		change, get the interval, timeout, thresholds and path of the
+		controller back, as in earlier versions. By default, the settings
+		tuned outside of the controller are kept.`)
+
+	allowDisruptiveBackendMigrations = flags.Bool("allow-disruptive-backend-migrations", false,
+		`Switch the backend services which GCE can't switch between balancing
+		modes in place, eg: from instance groups shared with UTILIZATION
+		backend services to NEGs, by removing their backends before adding the
+		new ones. The backend services serve no traffic in between, 502s, for
+		the duration of the two updates. By default, they keep their backends
+		and their syncs fail until the conflict is resolved.`)
+
 	watchNamespace = flags.String("watch-namespace", v1.NamespaceAll,
 		`Namespace to watch for Ingress/Services/Endpoints.`)
 
 	verbose = flags.Bool("verbose", false,
 		`If true, logs are displayed at V(4), otherwise V(2).`)
 
+	logFormat = flags.String("log-format", logging.TextFormat,
+		`Format of the logs: "text", the format of glog, or "json", an object
+		per line with the message, severity, time and caller, and the
+		"ingress", "resource" and "operation" the entry is about, if any.`)
+
 	configFilePath = flags.String("config-file-path", "",
 		`Path to a file containing the gce config. If left unspecified this
 		controller only works with default zones.`)
 
+	gceAuth = flags.String("gce-auth", cloudconfig.AuthMetadata,
+		`How the controller authenticates to GCE: "metadata", with the
+		token-url of the gce config or else the service account of the node,
+		or "adc", with the Application Default Credentials, eg: the key file of
+		$GOOGLE_APPLICATION_CREDENTIALS, or with Workload Identity, the Google
+		service account bound to the Kubernetes service account of the
+		controller.`)
+
+	gceProject = flags.String("gce-project", "",
+		`GCE project of the cluster. Defaults to the project-id of the gce
+		config, of the credentials, or else of the metadata server.`)
+
+	gceZone = flags.String("gce-zone", "",
+		`GCE zone of the controller. Defaults to the local-zone of the gce config
+		or else the zone of the metadata server.`)
+
+	gceNetwork = flags.String("gce-network", "",
+		`Name or URL of the GCE network of the cluster. Defaults to the
+		network-name of the gce config or else the network of the metadata
+		server, which the GKE metadata server of Workload Identity doesn't
+		serve.`)
+
+	gceSubnetwork = flags.String("gce-subnetwork", "",
+		`Name or URL of the GCE subnetwork of the nodes. Defaults to the
+		subnetwork-name of the gce config or else the subnetwork of the network
+		in the region of --gce-zone.`)
+
 	healthzPort = flags.Int("healthz-port", lbAPIPort,
 		`Port to run healthz server. Must match the health check port in yaml.`)
+
+	otlpEndpoint = flags.String("otlp-endpoint", "",
+		`OTLP/HTTP traces endpoint of an OpenTelemetry collector, eg:
+		http://otel-collector:4318/v1/traces. If set, every sync of an Ingress
+		is traced, with a span per GCE API call and per wait for a GCE
+		operation, and the spans are exported to the collector.`)
+
+	debugPort = flags.Int("debug-port", 0,
+		`Port to serve the net/http/pprof profiles, at /debug/pprof/, and the
+		values of the flags, at /debug/flags, on. Only listens on the loopback
+		interface, reach it with kubectl port-forward. Zero disables it.`)
+
+	syncStallTimeout = flags.Duration("sync-stall-timeout", 30*time.Minute,
+		`/healthz fails once a sync of an Ingress or of the nodes has been
+		running this long, or queued items have waited this long for a
+		worker, so that the liveness probe restarts a wedged controller. Zero
+		disables the check.`)
+
+	admissionWebhookPort = flags.Int("admission-webhook-port", 0,
+		`Port to serve the validating admission webhook of Ingresses and
+		BackendConfigs on, over HTTPS, at /validate. Zero disables it.`)
+
+	admissionWebhookCertFile = flags.String("admission-webhook-cert-file", "",
+		`Path to the certificate of the admission webhook server, required by
+		--admission-webhook-port.`)
+
+	admissionWebhookKeyFile = flags.String("admission-webhook-key-file", "",
+		`Path to the private key of the admission webhook server, required by
+		--admission-webhook-port.`)
+
+	firewallSrcRanges = flags.StringSlice("firewall-src-ranges", []string{},
+		`Comma separated list of CIDRs allowed by the L7 firewall rule. If left
+		unspecified, the GCE L7 health check and proxy ranges are used. Ranges
+		requested by individual Ingresses through annotations are allowed in
+		addition to these.`)
+
+	firewallTargetServiceAccounts = flags.StringSlice("firewall-target-service-accounts", []string{},
+		`Comma separated list of node service accounts targeted by the L7 firewall
+		rule. If set, the rule targets these service accounts instead of the node
+		tags. Can also be specified through node-service-accounts in the gce
+		config. The flag takes precedence.`)
+
+	windowsNodeTags = flags.StringSlice("windows-node-tags", []string{},
+		`Comma separated list of the network tags of the Windows nodes, targeted
+		by the L7 firewall rule. Windows node pools may not have a tag prefixing
+		their instance names, from which the tags of the other nodes are
+		derived.`)
+
+	manageFirewall = flags.Bool("manage-firewall", true,
+		`If false, the controller does not create, update or delete the L7
+		firewall rules. They need to be managed externally.`)
+
+	dualStackFirewall = flags.Bool("dual-stack-firewall", false,
+		`If true, the GCE L7 IPv6 health check and proxy ranges are allowed by
+		default as well, through separate IPv6 firewall rules. Has no effect
+		if --firewall-src-ranges is set, IPv6 ranges can be listed there.`)
+
+	firewallLogging = flags.Bool("firewall-logging", false,
+		`If true, firewall rules logging is enabled on the L7 firewall rules, so
+		that health check and proxied traffic to the nodes can be audited.`)
+
+	firewallLoggingExcludeMetadata = flags.Bool("firewall-logging-exclude-metadata", false,
+		`If true, the logs of --firewall-logging exclude the metadata, eg: the
+		instance and VPC details, to reduce their size.`)
+
+	dryRunFirewall = flags.Bool("dry-run-firewall", false,
+		`If true, the L7 firewall rules are never created, updated or deleted.
+		The changes the controller would make are logged along with their diff
+		against the live rules, and raised as events on the Ingresses. Useful
+		to validate an upgrade before granting firewall write permissions.`)
+
+	firewallResyncPeriod = flags.Duration("firewall-resync-period", 10*time.Minute,
+		`Check the L7 firewall rules for manual changes this often, and repair
+		them. Zero disables the check.`)
+
+	backendHealthPeriod = flags.Duration("backend-health-period", time.Minute,
+		`Publish the health of the backend services on the Ingresses this often,
+		and raise an event when all endpoints of a backend service are
+		unhealthy. Zero disables it.`)
+
+	orphanGCPeriod = flags.Duration("orphan-gc-period", 30*time.Minute,
+		`Delete the GCE resources whose Ingress or Service, recorded in their
+		description, no longer exists this often, eg: resources leaked by a
+		crash of the controller. Zero disables it. Disabled with
+		--watch-namespace, since the owners in other namespaces are not known.`)
+
+	certExpiryWarningPeriod = flags.Duration("cert-expiry-warning-period", 30*24*time.Hour,
+		`Raise an event on the Ingresses serving a TLS secret whose certificate
+		expires within this period. Zero disables it.`)
+
+	deletionProtection = flags.Bool("deletion-protection", false,
+		`Keep the load balancer of a deleted Ingress while any of its backend
+		services had healthy endpoints at the last health check, until the
+		deletion is confirmed with the ingress.gcp.kubernetes.io/confirm-deletion
+		annotation. Places the finalizer on all the GCE Ingresses. Requires a
+		non-zero --backend-health-period.`)
+
+	defaultSslPolicy = flags.String("default-ssl-policy", "",
+		`Name of an existing SSL policy attached to the target HTTPS proxies of
+		all the Ingresses whose FrontendConfig doesn't set sslPolicy. Proxies
+		whose policy drifted are reset at the next full sync.`)
+
+	minTLSVersion = flags.String("min-tls-version", "",
+		`Lowest minimum TLS version of the SSL policies of the target HTTPS
+		proxies: TLS_1_0, TLS_1_1 or TLS_1_2. SSL policies of FrontendConfigs
+		below it, or no policy, are refused in favor of --default-ssl-policy.
+		Empty allows any.`)
+
+	dnsZone = flags.String("dns-zone", "",
+		`Name of a Cloud DNS managed zone in which the A and AAAA records of the
+		hosts of the Ingresses are pointed to their load balancer, along with
+		ownership TXT records. Empty disables the management of DNS records.`)
+
+	dnsProject = flags.String("dns-project", "",
+		`Project of the --dns-zone. Defaults to the project of the cluster.`)
+
+	dnsOwnerID = flags.String("dns-owner-id", "",
+		`Owner recorded in the ownership TXT records of the DNS records. Must be
+		unique among the controllers managing the --dns-zone. Defaults to the
+		cluster UID.`)
+
+	dnsTTL = flags.Duration("dns-ttl", 5*time.Minute,
+		`TTL of the DNS records of the hosts of the Ingresses.`)
+
+	enableGateway = flags.Bool("enable-gateway", false,
+		`Serve the Gateways of the GatewayClasses with controllerName
+		networking.gke.io/ingress-gce, and their HTTPRoutes, through managed
+		Ingresses. Requires the Gateway API CRDs and a default backend.`)
+
+	gatewaySyncPeriod = flags.Duration("gateway-sync-period", 30*time.Second,
+		`Period of the syncs of the Gateways and HTTPRoutes.`)
+
+	ingressClassSyncPeriod = flags.Duration("ingress-class-sync-period", 30*time.Second,
+		`Period of the syncs of the networking.k8s.io/v1 IngressClasses, which
+		pick the Ingresses of the controller along with the
+		kubernetes.io/ingress.class annotation. Zero ignores the
+		IngressClasses.`)
+
+	enableL4ILB = flags.Bool("enable-l4-ilb", false,
+		`Manage the internal TCP/UDP load balancers of the Services of type
+		LoadBalancer with the cloud.google.com/load-balancer-type: Internal
+		annotation. The service controller of the cloud provider must not
+		also manage them.`)
+
+	enableL4ILBSubsetting = flags.Bool("enable-l4-ilb-subsetting", false,
+		`Back the internal TCP/UDP load balancers with GCE_VM_IP NEGs of
+		subsets of the nodes, rather than with the instance groups of all
+		the nodes, past the limit of 250 backend instances. Requires
+		--enable-l4-ilb and the NetworkEndpointGroup alpha feature of the gce
+		config.`)
+
+	enableL4NetLB = flags.Bool("enable-l4-netlb", false,
+		`Manage the external network load balancers of the other Services of
+		type LoadBalancer, as regional backend services of NEGs of the nodes.
+		Requires the NetworkEndpointGroup alpha feature of the gce config. The
+		service controller of the cloud provider must not also manage them.`)
+
+	enableRegionalL7 = flags.Bool("enable-regional-l7", false,
+		`Manage regional HTTP(S) load balancers for the Ingresses of the
+		gce-internal class, internal, and of the gce-regional-external
+		class, external. Their Services need NEGs, so this requires the
+		NetworkEndpointGroup alpha feature of the gce config, and the network
+		of the cluster needs a proxy-only subnet in its region.`)
+
+	enableServiceAttachments = flags.Bool("enable-service-attachments", false,
+		`Publish the internal load balancers of the Services referenced by the
+		networking.gke.io/v1 ServiceAttachments over Private Service Connect.
+		Requires --enable-l4-ilb and the ServiceAttachment CRD.`)
+
+	serviceAttachmentSyncPeriod = flags.Duration("service-attachment-sync-period", 30*time.Second,
+		`Period of the syncs of the ServiceAttachments.`)
+
+	nodeExclusionSelector = flags.String("node-exclusion-selector", "",
+		`Label selector of the nodes kept out of the instance groups, eg: of
+		dedicated GPU node pools. Nodes with the
+		node.kubernetes.io/exclude-from-external-load-balancers label are always
+		kept out.`)
+
+	enableFinalizer = flags.Bool("enable-finalizer", true,
+		`Place a finalizer on the Ingresses, so that their GCE resources are
+		deleted even if an Ingress is deleted while the controller is down.
+		If false, the finalizer is removed from the Ingresses, eg: before
+		downgrading to a controller which does not remove it.`)
+
+	excludeWindowsNodes = flags.Bool("exclude-windows-nodes", false,
+		`Keep the Windows nodes out of the instance groups, eg: if the health
+		checks of the load balancers can't reach them.`)
+
+	excludeUnreadyNodes = flags.Bool("exclude-unready-nodes", true,
+		`Remove NotReady and cordoned nodes from the instance groups, so that
+		traffic is not routed through draining nodes.`)
+
+	unreadyNodeGracePeriod = flags.Duration("unready-node-grace-period", 0,
+		`How long the readiness of a node must be stable before the node leaves
+		or joins the instance groups, to avoid churn from flapping nodes. Only
+		used with --exclude-unready-nodes.`)
+
+	gceRateLimits = flags.StringArray("gce-ratelimit", []string{},
+		`Rate limit of the GCE API calls of an API group, formatted as
+		<API group>,qps,<qps>,<burst>, eg: compute.backendServices,qps,5,10.
+		Can be repeated for several groups. Also read from the ratelimit
+		entries of the gce config, the flag takes precedence.`)
+
+	leaderElect = flags.Bool("leader-elect", false,
+		`If true, replicas of the controller elect a leader through a lease held
+		in a ConfigMap, and only the leader syncs the Ingresses. The others
+		wait as standbys to take over.`)
+
+	leaderElectLeaseDuration = flags.Duration("leader-elect-lease-duration", 15*time.Second,
+		`How long standbys wait before taking over a lease which is not
+		renewed.`)
+
+	leaderElectRenewDeadline = flags.Duration("leader-elect-renew-deadline", 10*time.Second,
+		`How long the leader retries renewing its lease before it exits. Must be
+		less than the lease duration.`)
+
+	leaderElectRetryPeriod = flags.Duration("leader-elect-retry-period", 2*time.Second,
+		`How long candidates wait between attempts to acquire or renew the
+		lease.`)
+
+	leaderElectResourceNamespace = flags.String("leader-elect-resource-namespace", metav1.NamespaceSystem,
+		`Namespace of the ConfigMap holding the lease.`)
+
+	leaderElectResourceName = flags.String("leader-elect-resource-name", "ingress-gce-lock",
+		`Name of the ConfigMap holding the lease.`)
+
+	negMaxEndpointsPerZone = flags.Int("neg-max-endpoints-per-zone", 0,
+		`Program at most this many endpoints in each NEG, the same subset of the
+		endpoints of the zone for a given NEG, to stay under the limits of the
+		load balancer. Zero programs all endpoints.`)
+
+	enableReadinessReflector = flags.Bool("enable-readiness-reflector", false,
+		`Set the cloud.google.com/load-balancer-neg-ready readiness gate of
+		the Pods served through NEGs once their endpoints are healthy in their
+		NEGs, so that rolling updates don't outpace the load balancer. With
+		--admission-webhook-port, the webhook also injects the readiness gate
+		into the Pods of the services with NEGs, at /mutate.`)
+
+	negEndpointsSource = flags.String("neg-endpoints-source", neg.EndpointsSourceEndpoints,
+		`Source of the endpoints of the NEGs: "endpoints", the Endpoints of the
+		services, truncated at 1000 addresses, "endpointslices", their
+		EndpointSlices, or "dual", the EndpointSlices falling back to the
+		Endpoints if they can't be read, reporting the differences between
+		both, to transition from the Endpoints.`)
+
+	enableIPv6NEGEndpoints = flags.Bool("enable-ipv6-neg-endpoints", false,
+		`Attach the IPv6 addresses of the dual-stack Pods to the NEGs along
+		their IPv4 addresses, when the subnetwork of the cluster is dual-stack.
+		The IPv6 addresses are read from the EndpointSlices of the services,
+		so this requires --neg-endpoints-source=endpointslices or dual. The
+		existing IPv4 only endpoints are detached and attached again with both
+		addresses.`)
+)
+
+var (
+	// runningLBCLock guards runningLBC.
+	runningLBCLock sync.Mutex
+	// runningLBC is the running controller, nil while a standby replica waits
+	// for the leader lease.
+	runningLBC *controller.LoadBalancerController
+)
+
+// setRunningLBC records the running controller served by the handlers.
+func setRunningLBC(lbc *controller.LoadBalancerController) {
+	runningLBCLock.Lock()
+	defer runningLBCLock.Unlock()
+	runningLBC = lbc
+}
+
+// getRunningLBC returns the running controller, nil if none.
+func getRunningLBC() *controller.LoadBalancerController {
+	runningLBCLock.Lock()
+	defer runningLBCLock.Unlock()
+	return runningLBC
+}
+
+// healthCheck is a named check of the running controller.
+type healthCheck struct {
+	name  string
+	check func(lbc *controller.LoadBalancerController) error
+}
+
+var (
+	// livenessChecks fail when the controller should be restarted.
+	livenessChecks = []healthCheck{
+		{"gce", gceReachable},
+		{"sync-loop", func(lbc *controller.LoadBalancerController) error {
+			if *syncStallTimeout <= 0 {
+				return nil
+			}
+			return lbc.CheckSyncProgress(*syncStallTimeout)
+		}},
+	}
+	// readinessChecks fail while the controller can't sync load balancers.
+	readinessChecks = []healthCheck{
+		{"informers", func(lbc *controller.LoadBalancerController) error {
+			if !lbc.InformersSynced() {
+				return fmt.Errorf("the informer caches have not synced yet")
+			}
+			return nil
+		}},
+		{"gce", gceReachable},
+	}
 )
 
-func registerHandlers(lbc *controller.LoadBalancerController) {
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		if err := lbc.CloudClusterManager.IsHealthy(); err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(fmt.Sprintf("Cluster unhealthy: %v", err)))
-			return
+// gceReachable returns an error if the GCE API isn't reachable.
+func gceReachable(lbc *controller.LoadBalancerController) error {
+	return lbc.CloudClusterManager.IsHealthy()
+}
+
+// serveHealthChecks runs the given checks against the running controller, and
+// serves their results, one per line, with a 500 if any failed. Standby
+// replicas pass all the checks.
+func serveHealthChecks(w http.ResponseWriter, checks []healthCheck) {
+	lbc := getRunningLBC()
+	if lbc == nil {
+		w.WriteHeader(200)
+		w.Write([]byte("ok: standby\n"))
+		return
+	}
+	var out bytes.Buffer
+	failed := false
+	for _, c := range checks {
+		if err := c.check(lbc); err != nil {
+			failed = true
+			fmt.Fprintf(&out, "[-]%v failed: %v\n", c.name, err)
+			logging.Warningf("Health check %v failed: %v", c.name, err)
+		} else {
+			fmt.Fprintf(&out, "[+]%v ok\n", c.name)
 		}
+	}
+	if failed {
+		w.WriteHeader(500)
+	} else {
 		w.WriteHeader(200)
-		w.Write([]byte("ok"))
+	}
+	w.Write(out.Bytes())
+}
+
+// registerHandlers serves the api of the controller. Standby replicas are
+// healthy as long as they serve it.
+func registerHandlers() {
+	// The api has its own mux, the default one serves the profiles of
+	// net/http/pprof, which are only served on the debug port.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		serveHealthChecks(w, livenessChecks)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		serveHealthChecks(w, readinessChecks)
 	})
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/delete-all-and-quit", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/delete-all-and-quit", func(w http.ResponseWriter, r *http.Request) {
 		// TODO: Retry failures during shutdown.
-		lbc.Stop(true)
+		if lbc := getRunningLBC(); lbc != nil {
+			lbc.Stop(true)
+		}
 	})
 
-	glog.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", *healthzPort), nil))
+	logging.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", *healthzPort), mux))
+}
+
+// runDebugServer serves the profiles of net/http/pprof at /debug/pprof/, and
+// the values of the flags at /debug/flags, on the loopback interface only.
+func runDebugServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/flags", func(w http.ResponseWriter, r *http.Request) {
+		flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "--%v=%v\n", f.Name, f.Value)
+		})
+	})
+	addr := fmt.Sprintf("127.0.0.1:%v", *debugPort)
+	logging.Infof("Serving the debug endpoints on %v", addr)
+	logging.Fatal(http.ListenAndServe(addr, mux))
 }
 
-func handleSigterm(lbc *controller.LoadBalancerController, deleteAll bool) {
+// runAdmissionWebhook serves the validating admission webhook, and the
+// mutating one of Pods with --enable-readiness-reflector. All replicas serve
+// them, not only the leader.
+func runAdmissionWebhook(kubeClient kubernetes.Interface) {
+	if *admissionWebhookCertFile == "" || *admissionWebhookKeyFile == "" {
+		logging.Fatalf("--admission-webhook-port requires --admission-webhook-cert-file and --admission-webhook-key-file")
+	}
+	validator := admission.NewValidator(kubeClient,
+		&backendconfig.APIServerBackendConfigGetter{Client: kubeClient},
+		&frontendconfig.APIServerFrontendConfigGetter{Client: kubeClient})
+	mux := http.NewServeMux()
+	mux.Handle(admission.Path, admission.NewHandler(validator))
+	if *enableReadinessReflector {
+		mux.Handle(admission.MutatePath, admission.NewMutatingHandler(admission.NewPodMutator(kubeClient)))
+	}
+	logging.Infof("Serving the admission webhook on port %v", *admissionWebhookPort)
+	logging.Fatal(http.ListenAndServeTLS(fmt.Sprintf(":%v", *admissionWebhookPort), *admissionWebhookCertFile, *admissionWebhookKeyFile, mux))
+}
+
+// acquireLeaderLease blocks until this replica holds the leader lease, and
+// keeps renewing it in the background. The controller exits if the lease is
+// lost, to be restarted as a standby.
+func acquireLeaderLease(kubeClient kubernetes.Interface) *leaderelection.LeaderElector {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logging.Fatalf("Failed to get hostname for leader election: %v", err)
+	}
+	identity := hostname + "_" + string(uuid.NewUUID())
+	lock := leaderelection.NewConfigMapLock(kubeClient, *leaderElectResourceNamespace, *leaderElectResourceName, identity)
+	le, err := leaderelection.NewLeaderElector(lock, *leaderElectLeaseDuration, *leaderElectRenewDeadline, *leaderElectRetryPeriod)
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
+	le.Acquire()
+	go func() {
+		le.Renew()
+		logging.Fatalf("Lost leader lease %v", lock.Describe())
+	}()
+	return le
+}
+
+func handleSigterm(lbc *controller.LoadBalancerController, le *leaderelection.LeaderElector, deleteAll bool) {
 	// Multiple SIGTERMs will get dropped
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGTERM)
 	<-signalChan
-	glog.Infof("Received SIGTERM, shutting down")
+	logging.Infof("Received SIGTERM, shutting down")
 
 	// TODO: Better retires than relying on restartPolicy.
 	exitCode := 0
 	if err := lbc.Stop(deleteAll); err != nil {
-		glog.Infof("Error during shutdown %v", err)
+		logging.Infof("Error during shutdown %v", err)
 		exitCode = 1
 	}
-	glog.Infof("Exiting with %v", exitCode)
+	// Let a standby take over right away.
+	if le != nil {
+		if err := le.Release(); err != nil {
+			logging.Warningf("Failed to release leader lease: %v", err)
+		}
+	}
+	logging.Infof("Exiting with %v", exitCode)
 	os.Exit(exitCode)
 }
 
 // main function for GLBC.
 func main() {
-	// TODO: Add a healthz endpoint
 	var err error
 	var clusterManager *controller.ClusterManager
 
@@ -200,20 +726,19 @@ func main() {
 	if *verbose {
 		go_flag.Set("v", "4")
 	}
-	glog.Infof("Starting GLBC image: %v, cluster name %v", imageVersion, *clusterName)
-	if *defaultSvc == "" {
-		glog.Fatalf("Please specify --default-backend")
+	if err := logging.SetFormat(*logFormat); err != nil {
+		logging.Fatalf("%v", err)
 	}
-
+	logging.Infof("Starting GLBC image: %v, cluster name %v", imageVersion, *clusterName)
 	var config *rest.Config
 	// Create kubeclient
-	if *inCluster {
+	if *inCluster && *kubeConfigFile == "" {
 		if config, err = rest.InClusterConfig(); err != nil {
-			glog.Fatalf("error creating client configuration: %v", err)
+			logging.Fatalf("error creating client configuration: %v", err)
 		}
 	} else {
-		if *apiServerHost == "" {
-			glog.Fatalf("please specify the api server address using the flag --apiserver-host")
+		if *apiServerHost == "" && *kubeConfigFile == "" {
+			logging.Fatalf("please specify the api server address using the flag --apiserver-host, or a kubeconfig with --kubeconfig")
 		}
 
 		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
@@ -224,104 +749,425 @@ func main() {
 				},
 			}).ClientConfig()
 		if err != nil {
-			glog.Fatalf("error creating client configuration: %v", err)
+			logging.Fatalf("error creating client configuration: %v", err)
 		}
 	}
 
 	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		glog.Fatalf("Failed to create client: %v.", err)
+		logging.Fatalf("Failed to create client: %v.", err)
 	}
 
-	// Wait for the default backend Service. There's no pretty way to do this.
-	parts := strings.Split(*defaultSvc, "/")
-	if len(parts) != 2 {
-		glog.Fatalf("Default backend should take the form namespace/name: %v",
-			*defaultSvc)
+	if *otlpEndpoint != "" {
+		exporter := tracing.NewOTLPExporter(*otlpEndpoint, "glbc")
+		tracing.SetExporter(exporter)
+		go exporter.Run(5*time.Second, wait.NeverStop)
+		logging.Infof("Exporting the traces of the syncs to %v", *otlpEndpoint)
 	}
-	port, nodePort, err := getNodePort(kubeClient, parts[0], parts[1])
-	if err != nil {
-		glog.Fatalf("Could not configure default backend %v: %v",
-			*defaultSvc, err)
+	go registerHandlers()
+	if *debugPort != 0 {
+		go runDebugServer()
+	}
+	if *admissionWebhookPort != 0 {
+		go runAdmissionWebhook(kubeClient)
 	}
-	// The default backend is known to be HTTP
-	defaultBackendNodePort := backends.ServicePort{
-		Port:     int64(nodePort),
-		Protocol: utils.ProtocolHTTP,
-		SvcName:  types.NamespacedName{Namespace: parts[0], Name: parts[1]},
-		SvcPort:  intstr.FromInt(int(port)),
+	var le *leaderelection.LeaderElector
+	if *leaderElect {
+		le = acquireLeaderLease(kubeClient)
 	}
 
-	var namer *utils.Namer
-	var cloud *gce.GCECloud
-	if *inCluster || *useRealCloud {
-		// Create cluster manager
-		namer, err = newNamer(kubeClient, *clusterName, controller.DefaultFirewallName)
+	var defaultBackendNodePort *backends.ServicePort
+	if *defaultSvc == "" {
+		logging.Infof("No default backend, Ingresses must have a default backend")
+	} else if *cleanupMode {
+		// The cleanup deletes the default backend service by its name, the
+		// Service may be gone already.
+	} else {
+		// Wait for the default backend Service. There's no pretty way to do this.
+		parts := strings.Split(*defaultSvc, "/")
+		if len(parts) != 2 {
+			logging.Fatalf("Default backend should take the form namespace/name: %v",
+				*defaultSvc)
+		}
+		port, nodePort, err := getNodePort(kubeClient, parts[0], parts[1])
 		if err != nil {
-			glog.Fatalf("%v", err)
+			logging.Fatalf("Could not configure default backend %v: %v",
+				*defaultSvc, err)
+		}
+		// The default backend is known to be HTTP
+		defaultBackendNodePort = &backends.ServicePort{
+			Port:     int64(nodePort),
+			Protocol: utils.ProtocolHTTP,
+			SvcName:  types.NamespacedName{Namespace: parts[0], Name: parts[1]},
+			SvcPort:  intstr.FromInt(int(port)),
 		}
+	}
 
+	var namer *utils.Namer
+	var cloud *gce.GCECloud
+	// l4LoadBalancers and l4Firewalls are the clients of the L4 load
+	// balancers, with --enable-l4-ilb or --enable-l4-netlb.
+	var l4LoadBalancers l4.LoadBalancers
+	var l4Firewalls firewalls.Firewall
+	// regionalLoadBalancers and regionalFirewalls are the clients of the
+	// regional L7 load balancers, with --enable-regional-l7.
+	var regionalLoadBalancers regional.LoadBalancers
+	// negDualStackEndpoints attaches the IPv6 addresses of the Pods to the
+	// NEGs, nil unless --enable-ipv6-neg-endpoints.
+	var negDualStackEndpoints neg.DualStackEndpoints
+	var regionalFirewalls firewalls.Firewall
+	// rateLimits are the GCE rate limits of the flags and the gce config,
+	// enforced by rateLimitTransport.
+	var rateLimits []string
+	var rateLimitTransport *ratelimit.Transport
+	if *inCluster || *useRealCloud {
 		// TODO: Make this more resilient. Currently we create the cloud client
 		// and pass it through to all the pools. This makes unit testing easier.
 		// However if the cloud client suddenly fails, we should try to re-create it
 		// and continue.
+		ctrlConfig := &controllerConfig{}
+		var cloudConfig []byte
 		if *configFilePath != "" {
-			glog.Infof("Reading config from path %v", *configFilePath)
-			config, err := os.Open(*configFilePath)
-			if err != nil {
-				glog.Fatalf("%v", err)
+			logging.Infof("Reading config from path %v", *configFilePath)
+			if cloudConfig, err = ioutil.ReadFile(*configFilePath); err != nil {
+				logging.Fatalf("%v", err)
+			}
+			if ctrlConfig, err = readControllerConfig(bytes.NewReader(cloudConfig)); err != nil {
+				logging.Fatalf("%v", err)
+			}
+		}
+		// The GCE clients send their requests through rateLimitTransport, the
+		// other HTTP clients are not limited. Rate limits of the flag take
+		// precedence over the gce config.
+		rateLimits = append(ctrlConfig.Global.RateLimits, *gceRateLimits...)
+		limiters, err := ratelimit.ParseRateLimits(rateLimits)
+		if err != nil {
+			logging.Fatalf("%v", err)
+		}
+		ratelimit.RegisterMetrics()
+		rateLimitTransport = ratelimit.NewTransport(http.DefaultTransport, limiters)
+		var configFile *gce.ConfigFile
+		if cloudConfig != nil {
+			logging.V(2).Infof("Using cloudprovider config file:\n%v ", string(cloudConfig))
+			if configFile, err = cloudconfig.ReadConfigFile(bytes.NewReader(cloudConfig)); err != nil {
+				logging.Fatalf("%v", err)
 			}
-			defer config.Close()
-			cloud = getGCEClient(config)
-			glog.Infof("Successfully loaded cloudprovider using config %q", *configFilePath)
 		} else {
-			// While you might be tempted to refactor so we simply assing nil to the
-			// config and only invoke getGCEClient once, that will not do the right
-			// thing because a nil check against an interface isn't true in golang.
-			cloud = getGCEClient(nil)
-			glog.Infof("Created GCE client without a config file")
+			logging.V(2).Infoln("No cloudprovider config file provided. Continuing with default values.")
+		}
+		var creds *google.DefaultCredentials
+		switch *gceAuth {
+		case cloudconfig.AuthMetadata:
+		case cloudconfig.AuthADC:
+			if creds, err = google.FindDefaultCredentials(oauth2.NoContext, compute.CloudPlatformScope, compute.ComputeScope); err != nil {
+				logging.Fatalf("Failed to find the Application Default Credentials: %v", err)
+			}
+			// The other GCE clients default to these credentials too.
+			ctrlConfig.Global.TokenURL = ""
+			logging.Infof("Authenticating to GCE with the Application Default Credentials")
+		default:
+			logging.Fatalf("Invalid --gce-auth %q, want %q or %q", *gceAuth, cloudconfig.AuthMetadata, cloudconfig.AuthADC)
+		}
+		cloud = getGCEClient(configFile, creds, cloudconfig.Overrides{Project: *gceProject, Zone: *gceZone, Network: *gceNetwork, Subnetwork: *gceSubnetwork}, rateLimitTransport)
+		logging.Infof("Created GCE client of project %q, network %q", cloud.ProjectID(), cloud.NetworkURL())
+
+		// Create cluster manager. The cluster UID may be recovered from the
+		// existing GCE resources, so the namer needs the cloud.
+		namer, err = newNamer(kubeClient, cloud, *clusterName, controller.DefaultFirewallName, *resourcePrefix)
+		if err != nil {
+			logging.Fatalf("%v", err)
 		}
 
-		clusterManager, err = controller.NewClusterManager(cloud, namer, defaultBackendNodePort, *healthCheckPath)
+		var tokenSource oauth2.TokenSource
+		if ctrlConfig.Global.TokenURL != "" {
+			tokenSource = gce.NewAltTokenSource(ctrlConfig.Global.TokenURL, ctrlConfig.Global.TokenBody)
+		}
+		fwProvider, err := firewalls.NewGCEFirewallProvider(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint)
+		if err != nil {
+			logging.Fatalf("Failed to create firewall provider: %v", err)
+		}
+		securityPolicies, err := backends.NewGCESecurityPolicies(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint)
+		if err != nil {
+			logging.Fatalf("Failed to create security policy provider: %v", err)
+		}
+		extendedBackendServices, err := backends.NewGCEExtendedBackendServices(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint)
 		if err != nil {
-			glog.Fatalf("%v", err)
+			logging.Fatalf("Failed to create backend service provider: %v", err)
+		}
+		grpcHealthChecks, err := healthchecks.NewGCEGRPCHealthChecks(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint)
+		if err != nil {
+			logging.Fatalf("Failed to create gRPC health check provider: %v", err)
+		}
+		httpsProxies, err := loadbalancers.NewGCETargetHttpsProxies(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint)
+		if err != nil {
+			logging.Fatalf("Failed to create SSL policy provider: %v", err)
+		}
+		urlMaps, err := loadbalancers.NewGCEExtendedUrlMaps(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint)
+		if err != nil {
+			logging.Fatalf("Failed to create url map provider: %v", err)
+		}
+		if *enableL4ILB || *enableL4NetLB {
+			if l4LoadBalancers, err = l4.NewGCELoadBalancers(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint); err != nil {
+				logging.Fatalf("Failed to create L4 load balancer provider: %v", err)
+			}
+			l4Firewalls = fwProvider
 		}
+		if *enableIPv6NEGEndpoints {
+			if negDualStackEndpoints, err = neg.NewGCEDualStackEndpoints(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint); err != nil {
+				logging.Fatalf("Failed to create dual-stack NEG endpoint provider: %v", err)
+			}
+		}
+		if *enableRegionalL7 {
+			if regionalLoadBalancers, err = regional.NewGCELoadBalancers(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint); err != nil {
+				logging.Fatalf("Failed to create regional load balancer provider: %v", err)
+			}
+			regionalFirewalls = fwProvider
+		}
+		fwServiceAccounts := *firewallTargetServiceAccounts
+		if len(fwServiceAccounts) == 0 {
+			fwServiceAccounts = ctrlConfig.Global.NodeServiceAccounts
+		}
+		if len(fwServiceAccounts) > 0 {
+			logging.Infof("L7 firewall rule targets service accounts %v", fwServiceAccounts)
+		}
+		fwOptions := firewalls.PoolOptions{
+			SrcRanges:              *firewallSrcRanges,
+			TargetServiceAccounts:  fwServiceAccounts,
+			WindowsNodeTags:        *windowsNodeTags,
+			Manage:                 *manageFirewall,
+			DualStack:              *dualStackFirewall,
+			EnableLogging:          *firewallLogging,
+			ExcludeLoggingMetadata: *firewallLoggingExcludeMetadata,
+			DryRun:                 *dryRunFirewall,
+		}
+		var dnsRecords *dns.Records
+		if *dnsZone != "" {
+			project, owner := *dnsProject, *dnsOwnerID
+			if project == "" {
+				project = cloud.ProjectID()
+			}
+			if owner == "" {
+				owner = namer.UID()
+			}
+			zones, err := dns.NewGCEManagedZones(project, tokenSource, rateLimitTransport, "")
+			if err != nil {
+				logging.Fatalf("Failed to create Cloud DNS client: %v", err)
+			}
+			dnsRecords = dns.NewRecords(zones, *dnsZone, owner, *dnsTTL)
+			logging.Infof("Managing the DNS records of the Ingress hosts in zone %v of project %v as %q", *dnsZone, project, owner)
+		}
+		if *cleanupMode {
+			extendedCloud, err := cleanup.NewGCEExtendedCloud(cloud, tokenSource, rateLimitTransport, ctrlConfig.Global.ApiEndpoint)
+			if err != nil {
+				logging.Fatalf("Failed to create cleanup provider: %v", err)
+			}
+			runCleanup(cloud, extendedCloud, fwProvider, namer, fwOptions, dnsRecords)
+		}
+		sslPolicyDefaults := loadbalancers.SslPolicyDefaults{Name: *defaultSslPolicy, MinTLSVersion: *minTLSVersion}
+		if err := checkSslPolicyDefaults(httpsProxies, sslPolicyDefaults); err != nil {
+			logging.Fatalf("%v", err)
+		}
+		clusterManager, err = controller.NewClusterManager(cloud, fwProvider, securityPolicies, extendedBackendServices, grpcHealthChecks, httpsProxies, urlMaps, namer, defaultBackendNodePort, *healthCheckPath, *resetHealthChecks, fwOptions, *fullSyncPeriod, *multiClusterConfigUID, sslPolicyDefaults, dnsRecords)
+		if err != nil {
+			logging.Fatalf("%v", err)
+		}
+		clusterManager.SetDisruptiveBackendMigrations(*allowDisruptiveBackendMigrations)
 	} else {
+		if *cleanupMode {
+			logging.Fatalf("--cleanup requires a real cloud")
+		}
 		// Create fake cluster manager
 		clusterManager = controller.NewFakeClusterManager(*clusterName, controller.DefaultFirewallName).ClusterManager
 	}
+	// The metrics provider of the work queues is set before the controller
+	// creates them.
+	controller.RegisterMetrics()
 	enableNEG := cloud.AlphaFeatureGate.Enabled(gce.AlphaFeatureNetworkEndpointGroup)
 	ctx := context.NewControllerContext(kubeClient, *watchNamespace, *resyncPeriod, enableNEG)
 	// Start loadbalancer controller
-	lbc, err := controller.NewLoadBalancerController(kubeClient, ctx, clusterManager, enableNEG)
+	excludedNodes, err := labels.Parse(*nodeExclusionSelector)
+	if err != nil {
+		logging.Fatalf("Invalid --node-exclusion-selector %q: %v", *nodeExclusionSelector, err)
+	}
+	if *orphanGCPeriod > 0 && *watchNamespace != v1.NamespaceAll {
+		logging.Warningf("Disabling the garbage collection of orphaned resources, the controller only watches namespace %v", *watchNamespace)
+		*orphanGCPeriod = 0
+	}
+	if *deletionProtection && *backendHealthPeriod == 0 {
+		logging.Fatalf("--deletion-protection requires a non-zero --backend-health-period")
+	}
+	lbc, err := controller.NewLoadBalancerController(kubeClient, ctx, clusterManager, controller.ControllerConfig{
+		NEGEnabled:              enableNEG,
+		FirewallResyncPeriod:    *firewallResyncPeriod,
+		BackendHealthPeriod:     *backendHealthPeriod,
+		NodeExclusionSelector:   excludedNodes,
+		ExcludeWindowsNodes:     *excludeWindowsNodes,
+		ExcludeUnreadyNodes:     *excludeUnreadyNodes,
+		UnreadyNodeGracePeriod:  *unreadyNodeGracePeriod,
+		EnableFinalizer:         *enableFinalizer,
+		SyncWorkers:             *concurrentIngressSyncs,
+		OrphanGCPeriod:          *orphanGCPeriod,
+		CertExpiryWarningPeriod: *certExpiryWarningPeriod,
+		DeletionProtection:      *deletionProtection,
+		IngressClassSyncPeriod:  *ingressClassSyncPeriod,
+	})
 	if err != nil {
-		glog.Fatalf("%v", err)
+		logging.Fatalf("%v", err)
 	}
 
 	if clusterManager.ClusterNamer.UID() != "" {
-		glog.V(3).Infof("Cluster name %+v", clusterManager.ClusterNamer.UID())
+		logging.V(3).Infof("Cluster name %+v", clusterManager.ClusterNamer.UID())
 	}
 	clusterManager.Init(&controller.GCETranslator{LoadBalancerController: lbc})
 
 	// Start NEG controller
 	if enableNEG {
-		negController, _ := neg.NewController(kubeClient, cloud, ctx, lbc.Translator, namer, *resyncPeriod)
+		neg.RegisterMetrics()
+		negController, err := neg.NewController(kubeClient, cloud, ctx, lbc.Translator, namer, *resyncPeriod, *negMaxEndpointsPerZone, *enableReadinessReflector, *negEndpointsSource, negDualStackEndpoints)
+		if err != nil {
+			logging.Fatalf("Failed to create the NEG controller: %v", err)
+		}
 		go negController.Run(ctx.StopCh)
+	} else if *enableReadinessReflector {
+		logging.Fatalf("--enable-readiness-reflector requires the %v alpha feature", gce.AlphaFeatureNetworkEndpointGroup)
+	} else if *enableIPv6NEGEndpoints {
+		logging.Fatalf("--enable-ipv6-neg-endpoints requires the %v alpha feature", gce.AlphaFeatureNetworkEndpointGroup)
+	}
+
+	// Start L4 controller
+	if *enableL4ILB || *enableL4NetLB {
+		if l4LoadBalancers == nil {
+			logging.Fatalf("--enable-l4-ilb and --enable-l4-netlb require a real cloud")
+		}
+		if *enableL4NetLB && !enableNEG {
+			logging.Fatalf("--enable-l4-netlb requires the %v alpha feature", gce.AlphaFeatureNetworkEndpointGroup)
+		}
+		if *enableL4ILBSubsetting && (!*enableL4ILB || !enableNEG) {
+			logging.Fatalf("--enable-l4-ilb-subsetting requires --enable-l4-ilb and the %v alpha feature", gce.AlphaFeatureNetworkEndpointGroup)
+		}
+		l4Pool := l4.NewPool(l4LoadBalancers, l4Firewalls, lbc, namer)
+		l4Controller := l4.NewController(kubeClient, ctx, l4Pool, *enableL4ILB, *enableL4NetLB, *enableL4ILBSubsetting)
+		go l4Controller.Run(ctx.StopCh)
+		if *enableServiceAttachments {
+			if !*enableL4ILB {
+				logging.Fatalf("--enable-service-attachments requires --enable-l4-ilb")
+			}
+			saController := serviceattachment.NewController(&serviceattachment.APIServerClient{Client: kubeClient}, kubeClient, l4Pool, namer, *watchNamespace)
+			go saController.Run(*serviceAttachmentSyncPeriod, ctx.StopCh)
+		}
+	} else if *enableServiceAttachments {
+		logging.Fatalf("--enable-service-attachments requires --enable-l4-ilb")
+	} else if *enableL4ILBSubsetting {
+		logging.Fatalf("--enable-l4-ilb-subsetting requires --enable-l4-ilb")
 	}
 
-	go registerHandlers(lbc)
-	go handleSigterm(lbc, *deleteAllOnQuit)
+	// Start regional L7 controller
+	if *enableRegionalL7 {
+		if regionalLoadBalancers == nil {
+			logging.Fatalf("--enable-regional-l7 requires a real cloud")
+		}
+		if !enableNEG {
+			logging.Fatalf("--enable-regional-l7 requires the %v alpha feature", gce.AlphaFeatureNetworkEndpointGroup)
+		}
+		tlsLoader := &tls.TLSCertsFromSecretsLoader{
+			Client:          kubeClient,
+			ReferenceGrants: &tls.APIServerReferenceGrantGetter{Client: kubeClient},
+		}
+		regionalPool := regional.NewPool(regionalLoadBalancers, regionalFirewalls, lbc.Translator, tlsLoader, ctx.ServiceInformer.GetIndexer(), namer)
+		go regional.NewController(kubeClient, ctx, regionalPool).Run(ctx.StopCh)
+	}
+
+	setRunningLBC(lbc)
+	go handleSigterm(lbc, le, *deleteAllOnQuit)
 
 	ctx.Start()
+	if *configMap != "" {
+		go runConfigWatcher(kubeClient, lbc, rateLimits, rateLimitTransport, ctx.StopCh)
+	}
+	if *enableGateway {
+		if defaultBackendNodePort == nil {
+			logging.Fatalf("--enable-gateway requires a --default-backend-service")
+		}
+		gatewayController := gateway.NewController(&gateway.APIServerClient{Client: kubeClient}, kubeClient, *watchNamespace)
+		go gatewayController.Run(*gatewaySyncPeriod, ctx.StopCh)
+	}
 	lbc.Run()
 	for {
-		glog.Infof("Handled quit, awaiting pod deletion.")
+		logging.Infof("Handled quit, awaiting pod deletion.")
 		time.Sleep(30 * time.Second)
 	}
 }
 
-func newNamer(kubeClient kubernetes.Interface, clusterName string, fwName string) (*utils.Namer, error) {
-	name, err := getClusterUID(kubeClient, clusterName)
+// runConfigWatcher applies the settings of the ConfigMap of --config-map to
+// the given controller and rate limit transport, if any, whenever it changes.
+// rateLimits are the GCE rate limits configured at startup.
+func runConfigWatcher(kubeClient kubernetes.Interface, lbc *controller.LoadBalancerController, rateLimits []string, rateLimitTransport *ratelimit.Transport, stopCh <-chan struct{}) {
+	parts := strings.Split(*configMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		logging.Fatalf("Invalid --config-map %q, expected namespace/name", *configMap)
+	}
+	defaults := dynamicconfig.Config{
+		FullSyncPeriod:      *fullSyncPeriod,
+		FirewallSrcRanges:   *firewallSrcRanges,
+		GCERateLimits:       rateLimits,
+		EnableFinalizer:     *enableFinalizer,
+		ExcludeUnreadyNodes: *excludeUnreadyNodes,
+	}
+	watcher := dynamicconfig.NewWatcher(kubeClient, parts[0], parts[1], defaults, func(config dynamicconfig.Config) {
+		if rateLimitTransport != nil {
+			limiters, err := ratelimit.ParseRateLimits(config.GCERateLimits)
+			if err != nil {
+				logging.Warningf("Failed to apply the GCE rate limits: %v", err)
+			} else {
+				rateLimitTransport.SetLimiters(limiters)
+			}
+		}
+		lbc.ApplyConfig(config)
+	})
+	watcher.Run(stopCh)
+}
+
+// runCleanup deletes the GCE resources owned by the cluster of the given
+// namer, the global ones and the ones of the region of the cluster and of its
+// zones, and exits.
+func runCleanup(cloud *gce.GCECloud, extendedCloud cleanup.ExtendedCloud, fwProvider firewalls.Firewall, namer *utils.Namer, fwOptions firewalls.PoolOptions, dnsRecords *dns.Records) {
+	if namer.UID() == "" {
+		logging.Warningf("The cluster has no UID, deleting the resources without cluster UID")
+	}
+	zones, err := cloud.ListZonesInRegion(cloud.Region())
+	if err != nil {
+		logging.Fatalf("Failed to list the zones of region %v: %v", cloud.Region(), err)
+	}
+	zoneNames := []string{}
+	for _, zone := range zones {
+		zoneNames = append(zoneNames, zone.Name)
+	}
+	fwPool := firewalls.NewFirewallPool(fwProvider, namer, fwOptions)
+	enableNEG := cloud.AlphaFeatureGate.Enabled(gce.AlphaFeatureNetworkEndpointGroup)
+	deleted, err := cleanup.NewCleaner(cloud, extendedCloud, fwPool, fwProvider, namer, zoneNames, enableNEG, *cleanupDryRun).Cleanup()
+	if *cleanupDryRun {
+		logging.Infof("Cleanup would delete %d resources", len(deleted))
+	} else {
+		logging.Infof("Cleanup deleted %d resources", len(deleted))
+	}
+	if err != nil {
+		logging.Fatalf("Failed to clean up: %v", err)
+	}
+	// The DNS records of all the Ingresses go with their load balancers.
+	if dnsRecords != nil && !*cleanupDryRun {
+		if err := dnsRecords.GC(sets.NewString()); err != nil {
+			logging.Fatalf("Failed to clean up the DNS records: %v", err)
+		}
+	}
+	logging.Flush()
+	os.Exit(0)
+}
+
+func newNamer(kubeClient kubernetes.Interface, cloud clusteruid.Cloud, clusterName string, fwName string, prefix string) (*utils.Namer, error) {
+	if err := utils.ValidatePrefix(prefix); err != nil {
+		return nil, err
+	}
+	name, err := getClusterUID(kubeClient, cloud, clusterName, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -330,7 +1176,7 @@ func newNamer(kubeClient kubernetes.Interface, clusterName string, fwName string
 		return nil, err
 	}
 
-	namer := utils.NewNamer(name, fw_name)
+	namer := utils.NewNamerWithPrefix(name, fw_name, prefix)
 	uidVault := storage.NewConfigMapVault(kubeClient, metav1.NamespaceSystem, uidConfigMapName)
 
 	// Start a goroutine to poll the cluster UID config map
@@ -341,25 +1187,25 @@ func newNamer(kubeClient kubernetes.Interface, clusterName string, fwName string
 		for _, key := range [...]string{storage.UidDataKey, storage.ProviderDataKey} {
 			val, found, err := uidVault.Get(key)
 			if err != nil {
-				glog.Errorf("Can't read uidConfigMap %v", uidConfigMapName)
+				logging.Errorf("Can't read uidConfigMap %v", uidConfigMapName)
 			} else if !found {
 				errmsg := fmt.Sprintf("Can't read %v from uidConfigMap %v", key, uidConfigMapName)
 				if key == storage.UidDataKey {
-					glog.Errorf(errmsg)
+					logging.Errorf("%v", errmsg)
 				} else {
-					glog.V(4).Infof(errmsg)
+					logging.V(4).Infof("%v", errmsg)
 				}
 			} else {
 
 				switch key {
 				case storage.UidDataKey:
 					if uid := namer.UID(); uid != val {
-						glog.Infof("Cluster uid changed from %v -> %v", uid, val)
+						logging.Infof("Cluster uid changed from %v -> %v", uid, val)
 						namer.SetUID(val)
 					}
 				case storage.ProviderDataKey:
 					if fw_name := namer.Firewall(); fw_name != val {
-						glog.Infof("Cluster firewall name changed from %v -> %v", fw_name, val)
+						logging.Infof("Cluster firewall name changed from %v -> %v", fw_name, val)
 						namer.SetFirewall(val)
 					}
 				}
@@ -377,7 +1223,7 @@ func newNamer(kubeClient kubernetes.Interface, clusterName string, fwName string
 // else, return an empty 'name' and pass along an error iff the configmap lookup is erroneous.
 func useDefaultOrLookupVault(cfgVault *storage.ConfigMapVault, cm_key, default_name string) (string, error) {
 	if default_name != "" {
-		glog.Infof("Using user provided %v %v", cm_key, default_name)
+		logging.Infof("Using user provided %v %v", cm_key, default_name)
 		// Don't save the uid in the vault, so users can rollback through
 		// setting the accompany flag to ""
 		return default_name, nil
@@ -394,7 +1240,7 @@ func useDefaultOrLookupVault(cfgVault *storage.ConfigMapVault, cm_key, default_n
 		// Not found but safe to proceed.
 		return "", nil
 	}
-	glog.Infof("Using %v = %q saved in ConfigMap", cm_key, val)
+	logging.Infof("Using %v = %q saved in ConfigMap", cm_key, val)
 	return val, nil
 }
 
@@ -409,7 +1255,7 @@ func getFirewallName(kubeClient kubernetes.Interface, name, cluster_uid string)
 	} else if fw_name != "" {
 		return fw_name, cfgVault.Put(storage.ProviderDataKey, fw_name)
 	} else {
-		glog.Infof("Using cluster UID %v as firewall name", cluster_uid)
+		logging.Infof("Using cluster UID %v as firewall name", cluster_uid)
 		return cluster_uid, cfgVault.Put(storage.ProviderDataKey, cluster_uid)
 	}
 }
@@ -417,10 +1263,12 @@ func getFirewallName(kubeClient kubernetes.Interface, name, cluster_uid string)
 // getClusterUID returns the cluster UID. Rules for UID generation:
 // If the user specifies a --cluster-uid param it overwrites everything
 // else, check UID config map for a previously recorded uid
-// else, check if there are any working Ingresses
+// else, recover the uid from the GCE resources of the working Ingresses,
+// failing if they are tagged with several uids
 //	- remember that "" is the cluster uid
 // else, allocate a new uid
-func getClusterUID(kubeClient kubernetes.Interface, name string) (string, error) {
+// A recovered or allocated uid is recorded in the UID config map.
+func getClusterUID(kubeClient kubernetes.Interface, cloud clusteruid.Cloud, name, prefix string) (string, error) {
 	cfgVault := storage.NewConfigMapVault(kubeClient, metav1.NamespaceSystem, uidConfigMapName)
 	if name, err := useDefaultOrLookupVault(cfgVault, storage.UidDataKey, name); err != nil {
 		return "", err
@@ -435,19 +1283,15 @@ func getClusterUID(kubeClient kubernetes.Interface, name string) (string, error)
 	if err != nil {
 		return "", err
 	}
-	namer := utils.Namer{}
-	for _, ing := range ings.Items {
-		if len(ing.Status.LoadBalancer.Ingress) != 0 {
-			c := namer.ParseName(loadbalancers.GCEResourceName(ing.Annotations, "forwarding-rule"))
-			if c.ClusterName != "" {
-				return c.ClusterName, cfgVault.Put(storage.UidDataKey, c.ClusterName)
-			}
-			glog.Infof("Found a working Ingress, assuming uid is empty string")
-			return "", cfgVault.Put(storage.UidDataKey, "")
-		}
+	uid, found, err := clusteruid.Recover(cloud, prefix, ings.Items)
+	if err != nil {
+		return "", fmt.Errorf("failed to recover the cluster uid, missing from ConfigMap %v/%v: %v", metav1.NamespaceSystem, uidConfigMapName, err)
+	}
+	if found {
+		return uid, cfgVault.Put(storage.UidDataKey, uid)
 	}
 
-	// Allocate new uid
+	// No Ingress was served yet, allocate new uid
 	f, err := os.Open("/dev/urandom")
 	if err != nil {
 		return "", err
@@ -457,14 +1301,14 @@ func getClusterUID(kubeClient kubernetes.Interface, name string) (string, error)
 	if _, err := f.Read(b); err != nil {
 		return "", err
 	}
-	uid := fmt.Sprintf("%x", b)
+	uid = fmt.Sprintf("%x", b)
 	return uid, cfgVault.Put(storage.UidDataKey, uid)
 }
 
 // getNodePort waits for the Service, and returns it's first node port.
 func getNodePort(client kubernetes.Interface, ns, name string) (port, nodePort int32, err error) {
 	var svc *v1.Service
-	glog.V(3).Infof("Waiting for %v/%v", ns, name)
+	logging.V(3).Infof("Waiting for %v/%v", ns, name)
 	wait.Poll(1*time.Second, 5*time.Minute, func() (bool, error) {
 		svc, err = client.Core().Services(ns).Get(name, metav1.GetOptions{})
 		if err != nil {
@@ -474,7 +1318,7 @@ func getNodePort(client kubernetes.Interface, ns, name string) (port, nodePort i
 			if p.NodePort != 0 {
 				port = p.Port
 				nodePort = p.NodePort
-				glog.V(3).Infof("Node port %v", nodePort)
+				logging.V(3).Infof("Node port %v", nodePort)
 				break
 			}
 		}
@@ -483,32 +1327,72 @@ func getNodePort(client kubernetes.Interface, ns, name string) (port, nodePort i
 	return
 }
 
-func getGCEClient(config io.Reader) *gce.GCECloud {
-	getConfigReader := func() io.Reader { return nil }
+// checkSslPolicyDefaults returns an error if the minimum TLS version is
+// invalid, or if the default SSL policy doesn't exist or is below it.
+func checkSslPolicyDefaults(httpsProxies loadbalancers.TargetHttpsProxies, defaults loadbalancers.SslPolicyDefaults) error {
+	switch defaults.MinTLSVersion {
+	case "", loadbalancers.MinTLSVersion10, loadbalancers.MinTLSVersion11, loadbalancers.MinTLSVersion12:
+	default:
+		return fmt.Errorf("invalid --min-tls-version %q, want one of %v, %v or %v", defaults.MinTLSVersion, loadbalancers.MinTLSVersion10, loadbalancers.MinTLSVersion11, loadbalancers.MinTLSVersion12)
+	}
+	if defaults.Name == "" {
+		return nil
+	}
+	policy, err := httpsProxies.GetSslPolicy(defaults.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get the default SSL policy %v: %v", defaults.Name, err)
+	}
+	if err := loadbalancers.CheckMinTLSVersion(policy, defaults.MinTLSVersion); err != nil {
+		return err
+	}
+	logging.Infof("Enforcing SSL policy %v, with minimum TLS version %v, on the target HTTPS proxies", policy.Name, policy.MinTlsVersion)
+	return nil
+}
 
-	if config != nil {
-		allConfig, err := ioutil.ReadAll(config)
-		if err != nil {
-			glog.Fatalf("Error while reading entire config: %v", err)
-		}
-		glog.V(2).Infof("Using cloudprovider config file:\n%v ", string(allConfig))
+// controllerConfig holds the settings of the gce config that are consumed by
+// this controller in addition to the cloudprovider.
+type controllerConfig struct {
+	Global struct {
+		TokenURL    string `gcfg:"token-url"`
+		TokenBody   string `gcfg:"token-body"`
+		ApiEndpoint string `gcfg:"api-endpoint"`
+		// NodeServiceAccounts are the service accounts of the nodes, targeted
+		// by the L7 firewall rule instead of the node tags.
+		NodeServiceAccounts []string `gcfg:"node-service-accounts"`
+		// RateLimits are the rate limits of GCE API groups, in the format of
+		// the --gce-ratelimit flag.
+		RateLimits []string `gcfg:"ratelimit"`
+	}
+}
 
-		getConfigReader = func() io.Reader {
-			return bytes.NewReader(allConfig)
-		}
-	} else {
-		glog.V(2).Infoln("No cloudprovider config file provided. Continuing with default values.")
+// readControllerConfig parses the settings of the given gce config consumed
+// by this controller. Settings only used by the cloudprovider are ignored.
+func readControllerConfig(config io.Reader) (*controllerConfig, error) {
+	cfg := &controllerConfig{}
+	if err := gcfg.FatalOnly(gcfg.ReadInto(cfg, config)); err != nil {
+		return nil, fmt.Errorf("couldn't read config: %v", err)
 	}
+	return cfg, nil
+}
 
+// getGCEClient returns the GCE client of the given gce config, nil if none,
+// credentials, nil to authenticate as set by the gce config, and overrides.
+// Its requests are sent through the given transport.
+func getGCEClient(configFile *gce.ConfigFile, creds *google.DefaultCredentials, overrides cloudconfig.Overrides, transport http.RoundTripper) *gce.GCECloud {
 	// Creating the cloud interface involves resolving the metadata server to get
 	// an oauth token. If this fails, the token provider assumes it's not on GCE.
 	// No errors are thrown. So we need to keep retrying till it works because
 	// we know we're on GCE.
 	for {
-		cloudInterface, err := cloudprovider.GetCloudProvider("gce", getConfigReader())
+		var cloud *gce.GCECloud
+		cloudConfig, err := cloudconfig.New(configFile, creds, overrides, cloudconfig.GCEMetadata{})
+		if _, ok := err.(*cloudconfig.MissingSettingError); ok {
+			logging.Fatalf("%v", err)
+		}
+		if err == nil {
+			cloud, err = createGCECloud(cloudConfig, transport)
+		}
 		if err == nil {
-			cloud := cloudInterface.(*gce.GCECloud)
-
 			// If this controller is scheduled on a node without compute/rw
 			// it won't be allowed to list backends. We can assume that the
 			// user has no need for Ingress in this case. If they grant
@@ -517,10 +1401,22 @@ func getGCEClient(config io.Reader) *gce.GCECloud {
 			if _, err = cloud.ListGlobalBackendServices(); err == nil || utils.IsHTTPErrorCode(err, http.StatusForbidden) {
 				return cloud
 			}
-			glog.Warningf("Failed to list backend services, retrying: %v", err)
+			logging.Warningf("Failed to list backend services, retrying: %v", err)
 		} else {
-			glog.Warningf("Failed to retrieve cloud interface, retrying: %v", err)
+			logging.Warningf("Failed to retrieve cloud interface, retrying: %v", err)
 		}
 		time.Sleep(cloudClientRetryInterval)
 	}
 }
+
+// createGCECloud creates the cloud provider, sending its requests through the
+// given transport. The cloud provider builds its clients on the transport of
+// http.DefaultClient, which they capture when they are created, so it is only
+// replaced for the creation. Nothing else uses http.DefaultClient while the
+// controller starts.
+func createGCECloud(cloudConfig *gce.CloudConfig, transport http.RoundTripper) (*gce.GCECloud, error) {
+	defaultTransport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = transport
+	defer func() { http.DefaultClient.Transport = defaultTransport }()
+	return gce.CreateGCECloud(cloudConfig)
+}
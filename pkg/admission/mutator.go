@@ -0,0 +1,112 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	neg "k8s.io/ingress-gce/pkg/networkendpointgroup"
+)
+
+// PodMutator injects the NEG readiness gate into the Pods served through
+// NEGs, so that the controller holds them unready until they are healthy in
+// their NEGs.
+type PodMutator struct {
+	client kubernetes.Interface
+}
+
+// NewPodMutator returns a PodMutator.
+//   - client: lists the Services selecting the Pods.
+func NewPodMutator(client kubernetes.Interface) *PodMutator {
+	return &PodMutator{client: client}
+}
+
+// jsonPatchOperation is an operation of a JSON patch.
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// readinessGate is a readiness gate of a Pod, which the vendored Pod lacks.
+type readinessGate struct {
+	ConditionType string `json:"conditionType"`
+}
+
+// MutatePod returns the JSON patch adding the NEG readiness gate to the
+// given Pod, encoded as JSON, in the given namespace, or nil if the Pod
+// already has it or is selected by no Service with NEGs.
+func (m *PodMutator) MutatePod(data []byte, namespace string) ([]byte, error) {
+	pod := &api_v1.Pod{}
+	if err := json.Unmarshal(data, pod); err != nil {
+		return nil, fmt.Errorf("failed to decode Pod: %v", err)
+	}
+	gates := struct {
+		Spec struct {
+			ReadinessGates []readinessGate `json:"readinessGates"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(data, &gates); err != nil {
+		return nil, fmt.Errorf("failed to decode Pod: %v", err)
+	}
+	for _, gate := range gates.Spec.ReadinessGates {
+		if gate.ConditionType == neg.ReadinessGate {
+			return nil, nil
+		}
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = namespace
+	}
+	served, err := m.servedByNEG(pod)
+	if err != nil || !served {
+		return nil, err
+	}
+	op := jsonPatchOperation{Op: "add", Path: "/spec/readinessGates/-", Value: readinessGate{ConditionType: neg.ReadinessGate}}
+	if len(gates.Spec.ReadinessGates) == 0 {
+		op = jsonPatchOperation{Op: "add", Path: "/spec/readinessGates", Value: []readinessGate{{ConditionType: neg.ReadinessGate}}}
+	}
+	return json.Marshal([]jsonPatchOperation{op})
+}
+
+// servedByNEG returns true if the given Pod is selected by a Service with
+// NEGs, used by Ingresses or exposed as standalone NEGs.
+func (m *PodMutator) servedByNEG(pod *api_v1.Pod) (bool, error) {
+	svcs, err := m.client.Core().Services(pod.Namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, svc := range svcs.Items {
+		if len(svc.Spec.Selector) == 0 || !labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		svcAnnotations := annotations.SvcAnnotations(svc.Annotations)
+		if svcAnnotations.NEGEnabled() {
+			return true, nil
+		}
+		if negAnnotation, err := svcAnnotations.NEGAnnotation(); err == nil && negAnnotation != nil && len(negAnnotation.ExposedPorts) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
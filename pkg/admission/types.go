@@ -0,0 +1,57 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The admission API isn't vendored, these are the fields of the
+// admission.k8s.io/v1beta1 AdmissionReview used by the webhook.
+
+// AdmissionReview is a request sent by the apiserver to the webhook, and the
+// response of the webhook.
+type AdmissionReview struct {
+	meta_v1.TypeMeta `json:",inline"`
+	Request          *AdmissionRequest  `json:"request,omitempty"`
+	Response         *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest is the object under admission.
+type AdmissionRequest struct {
+	UID       types.UID                `json:"uid"`
+	Kind      meta_v1.GroupVersionKind `json:"kind"`
+	Namespace string                   `json:"namespace,omitempty"`
+	Operation string                   `json:"operation"`
+	Object    json.RawMessage          `json:"object,omitempty"`
+}
+
+// AdmissionResponse tells whether the object is admitted, and why not, and
+// the JSON patch mutating it, if any.
+type AdmissionResponse struct {
+	UID       types.UID       `json:"uid"`
+	Allowed   bool            `json:"allowed"`
+	Result    *meta_v1.Status `json:"status,omitempty"`
+	Patch     []byte          `json:"patch,omitempty"`
+	PatchType *string         `json:"patchType,omitempty"`
+}
+
+// PatchTypeJSONPatch is the type of the patches of the webhook.
+const PatchTypeJSONPatch = "JSONPatch"
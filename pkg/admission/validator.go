@@ -0,0 +1,258 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
+	"k8s.io/ingress-gce/pkg/frontendconfig"
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// Validator validates Ingresses and BackendConfigs.
+type Validator struct {
+	client          kubernetes.Interface
+	backendConfigs  backendconfig.BackendConfigGetter
+	frontendConfigs frontendconfig.FrontendConfigGetter
+}
+
+// NewValidator returns a Validator.
+//   - client: retrieves the Services of the Ingresses.
+//   - backendConfigs: retrieves the BackendConfigs referenced by the Services.
+//   - frontendConfigs: retrieves the FrontendConfigs referenced by the
+//     Ingresses.
+func NewValidator(client kubernetes.Interface, backendConfigs backendconfig.BackendConfigGetter, frontendConfigs frontendconfig.FrontendConfigGetter) *Validator {
+	return &Validator{client: client, backendConfigs: backendConfigs, frontendConfigs: frontendConfigs}
+}
+
+// ValidateIngress returns an error listing the reasons the controller can't
+// implement the given Ingress, encoded as JSON, in the given namespace.
+// Ingresses of other classes are always valid. Errors retrieving the objects
+// the Ingress references, other than not found, are only logged, so that an
+// unavailable apiserver doesn't block Ingresses.
+func (v *Validator) ValidateIngress(data []byte, namespace string) error {
+	ing := &extensions.Ingress{}
+	if err := json.Unmarshal(data, ing); err != nil {
+		return fmt.Errorf("failed to decode Ingress: %v", err)
+	}
+	class := annotations.IngAnnotations(ing.Annotations).IngressClass()
+	if class != "" && class != annotations.GceIngressClass {
+		return nil
+	}
+	if ing.Namespace == "" {
+		ing.Namespace = namespace
+	}
+	paths, err := annotations.IngressPaths(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode Ingress: %v", err)
+	}
+
+	var errs []error
+	for _, p := range paths {
+		if _, err := utils.GCEPaths(p.Path, p.PathType); err != nil {
+			errs = append(errs, fmt.Errorf("path %q of host %q: %v", p.Path, p.Host, err))
+		}
+	}
+	errs = append(errs, v.validateIngressAnnotations(ing)...)
+	// The pre-shared certificates, the managed certificate and the TLS
+	// Secrets share the certificates of the target HTTPS proxy.
+	secrets, certs := tlsSecrets(ing), preSharedCerts(annotations.IngAnnotations(ing.Annotations))
+	desc, count := fmt.Sprintf("%d TLS Secrets and %d pre-shared certificates", secrets.Len(), certs.Len()), secrets.Len()+certs.Len()
+	if annotations.IngAnnotations(ing.Annotations).ManagedCertificates() {
+		desc, count = fmt.Sprintf("%d TLS Secrets, %d pre-shared certificates and a managed certificate", secrets.Len(), certs.Len()), count+1
+	}
+	if count > loadbalancers.MaxSSLCerts {
+		errs = append(errs, fmt.Errorf("%v, GCE allows at most %d certificates per load balancer", desc, loadbalancers.MaxSSLCerts))
+	}
+	for _, be := range ingressBackends(ing) {
+		if err := v.validateBackendConfig(ing.Namespace, be); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateIngressAnnotations returns the errors of the annotations of the
+// given Ingress. The controller ignores invalid annotations.
+func (v *Validator) validateIngressAnnotations(ing *extensions.Ingress) []error {
+	var errs []error
+	ingAnnotations := annotations.IngAnnotations(ing.Annotations)
+	for _, key := range []string{annotations.AllowHTTPKey, annotations.IPv6Key, annotations.ManagedCertificatesKey} {
+		if val, ok := ingAnnotations[key]; ok {
+			if _, err := strconv.ParseBool(val); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %v annotation value %q, must be true or false", key, val))
+			}
+		}
+	}
+	switch scheme := ingAnnotations.NamingScheme(); scheme {
+	case "", string(utils.NamingSchemeV1), string(utils.NamingSchemeV2):
+	default:
+		errs = append(errs, fmt.Errorf("invalid %v annotation value %q, must be %v or %v", annotations.NamingSchemeKey, scheme, utils.NamingSchemeV1, utils.NamingSchemeV2))
+	}
+	if _, err := ingAnnotations.FirewallSrcRanges(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ingAnnotations.Redirects(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ingAnnotations.TrafficSplits(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ingAnnotations.RouteRules(); err != nil {
+		errs = append(errs, err)
+	}
+	if name := ingAnnotations.FrontendConfig(); name != "" {
+		config, err := v.frontendConfigs.Get(ing.Namespace, name)
+		if isRetrievalError(err) {
+			logging.Warningf("Not validating FrontendConfig %v/%v: %v", ing.Namespace, name, err)
+		} else if err != nil {
+			errs = append(errs, err)
+		} else if certMap := config.Spec.CertificateMap; certMap != nil && *certMap != "" && (len(ing.Spec.TLS) > 0 || ingAnnotations.UseNamedTLS() != "" || ingAnnotations.ManagedCertificates()) {
+			errs = append(errs, fmt.Errorf("FrontendConfig %v/%v attaches certificate map %v, which is exclusive with spec.tls, the pre-shared certificates and the managed certificates", ing.Namespace, name, *certMap))
+		}
+	}
+	return errs
+}
+
+// validateBackendConfig returns an error if the Service port of the given
+// backend references an invalid or nonexistent BackendConfig. Services which
+// don't exist yet are valid.
+func (v *Validator) validateBackendConfig(namespace string, be extensions.IngressBackend) error {
+	svc, err := v.client.Core().Services(namespace).Get(be.ServiceName, meta_v1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			logging.Warningf("Not validating the BackendConfig of Service %v/%v: %v", namespace, be.ServiceName, err)
+		}
+		return nil
+	}
+	configs, err := annotations.SvcAnnotations(svc.Annotations).BackendConfigs()
+	if err != nil {
+		return fmt.Errorf("Service %v/%v: %v", namespace, be.ServiceName, err)
+	}
+	if configs == nil {
+		return nil
+	}
+	port := servicePort(svc, be.ServicePort)
+	if port == nil {
+		return nil
+	}
+	name, ok := configs.Ports[port.Name]
+	if !ok || port.Name == "" {
+		name, ok = configs.Ports[strconv.Itoa(int(port.Port))]
+	}
+	if !ok {
+		name = configs.Default
+	}
+	if name == "" {
+		return nil
+	}
+	if _, err := v.backendConfigs.Get(namespace, name); isRetrievalError(err) {
+		logging.Warningf("Not validating BackendConfig %v/%v of Service %v/%v: %v", namespace, name, namespace, be.ServiceName, err)
+	} else if err != nil {
+		return fmt.Errorf("Service %v/%v port %v: %v", namespace, be.ServiceName, be.ServicePort.String(), err)
+	}
+	return nil
+}
+
+// isRetrievalError returns true if the given error is a failure to retrieve
+// a BackendConfig or FrontendConfig, as opposed to it not existing or being
+// invalid.
+func isRetrievalError(err error) bool {
+	switch err.(type) {
+	case *backendconfig.RetrievalError, *frontendconfig.RetrievalError:
+		return true
+	}
+	return false
+}
+
+// ValidateBackendConfig returns an error if the given BackendConfig, encoded
+// as JSON, is invalid.
+func (v *Validator) ValidateBackendConfig(data []byte) error {
+	config := &backendconfig.BackendConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to decode BackendConfig: %v", err)
+	}
+	return backendconfig.Validate(config)
+}
+
+// ingressBackends returns the backends of the given Ingress.
+func ingressBackends(ing *extensions.Ingress) []extensions.IngressBackend {
+	var backends []extensions.IngressBackend
+	if ing.Spec.Backend != nil {
+		backends = append(backends, *ing.Spec.Backend)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			backends = append(backends, p.Backend)
+		}
+	}
+	return backends
+}
+
+// servicePort returns the port of the given Service referenced by an Ingress
+// backend, by number or name. Nil if the Service has no such port.
+func servicePort(svc *api_v1.Service, port intstr.IntOrString) *api_v1.ServicePort {
+	for i, p := range svc.Spec.Ports {
+		if (port.Type == intstr.Int && p.Port == port.IntVal) || (port.Type == intstr.String && p.Name == port.StrVal) {
+			return &svc.Spec.Ports[i]
+		}
+	}
+	return nil
+}
+
+// tlsSecrets returns the names of the TLS Secrets of the given Ingress, one
+// certificate is uploaded for each.
+func tlsSecrets(ing *extensions.Ingress) sets.String {
+	secrets := sets.NewString()
+	for _, tls := range ing.Spec.TLS {
+		if tls.SecretName != "" {
+			secrets.Insert(tls.SecretName)
+		}
+	}
+	return secrets
+}
+
+// preSharedCerts returns the names of the pre-shared certificates of the
+// given Ingress annotations.
+func preSharedCerts(ingAnnotations annotations.IngAnnotations) sets.String {
+	certs := sets.NewString()
+	for _, name := range strings.Split(ingAnnotations.UseNamedTLS(), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			certs.Insert(name)
+		}
+	}
+	return certs
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// Path is the path the webhook serves admission reviews on.
+const Path = "/validate"
+
+// MutatePath is the path the mutating webhook serves admission reviews on.
+const MutatePath = "/mutate"
+
+// NewHandler returns the http handler of the webhook, validating the
+// Ingresses and BackendConfigs of the admission reviews with the given
+// Validator. Other kinds of objects are admitted.
+func NewHandler(validator *Validator) http.Handler {
+	return reviewHandler(func(req *AdmissionRequest) *AdmissionResponse {
+		return admit(validator, req)
+	})
+}
+
+// NewMutatingHandler returns the http handler of the mutating webhook,
+// injecting the NEG readiness gate into the Pods of the admission reviews
+// with the given PodMutator. Other kinds of objects are admitted as is.
+func NewMutatingHandler(mutator *PodMutator) http.Handler {
+	return reviewHandler(func(req *AdmissionRequest) *AdmissionResponse {
+		return mutate(mutator, req)
+	})
+}
+
+// reviewHandler returns an http handler answering the admission reviews
+// with the responses of respond.
+func reviewHandler(respond func(*AdmissionRequest) *AdmissionResponse) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := &AdmissionReview{}
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil || review.Request == nil {
+			http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+		review.Response = respond(review.Request)
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			logging.Errorf("Failed to write admission review: %v", err)
+		}
+	})
+}
+
+// admit returns the response to the given admission request.
+func admit(validator *Validator, req *AdmissionRequest) *AdmissionResponse {
+	resp := &AdmissionResponse{UID: req.UID, Allowed: true}
+	if len(req.Object) == 0 {
+		// Deletions have no object.
+		return resp
+	}
+	var err error
+	switch req.Kind.Kind {
+	case "Ingress":
+		err = validator.ValidateIngress(req.Object, req.Namespace)
+	case "BackendConfig":
+		err = validator.ValidateBackendConfig(req.Object)
+	}
+	if err != nil {
+		logging.V(2).Infof("Rejecting %v %v in namespace %v: %v", req.Operation, req.Kind.Kind, req.Namespace, err)
+		resp.Allowed = false
+		resp.Result = &meta_v1.Status{
+			Status:  meta_v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  meta_v1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+	return resp
+}
+
+// mutate returns the response to the given admission request, patching the
+// Pods which need the NEG readiness gate. Pods are always admitted.
+func mutate(mutator *PodMutator, req *AdmissionRequest) *AdmissionResponse {
+	resp := &AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Kind != "Pod" || req.Operation != "CREATE" || len(req.Object) == 0 {
+		return resp
+	}
+	patch, err := mutator.MutatePod(req.Object, req.Namespace)
+	if err != nil {
+		logging.Errorf("Failed to inject the NEG readiness gate into a pod in namespace %v: %v", req.Namespace, err)
+		return resp
+	}
+	if patch != nil {
+		patchType := PatchTypeJSONPatch
+		resp.Patch, resp.PatchType = patch, &patchType
+	}
+	return resp
+}
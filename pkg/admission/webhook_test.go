@@ -0,0 +1,320 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
+	"k8s.io/ingress-gce/pkg/frontendconfig"
+)
+
+func TestWebhook(t *testing.T) {
+	svc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "svc",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.BackendConfigKey: `{"ports": {"http": "config"}}`},
+		},
+		Spec: api_v1.ServiceSpec{Ports: []api_v1.ServicePort{{Name: "http", Port: 80}, {Name: "other", Port: 81}}},
+	}
+	badSvc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "bad-svc",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.BackendConfigKey: `{"ports": {"http": "missing"}}`},
+		},
+		Spec: api_v1.ServiceSpec{Ports: []api_v1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	unavailableSvc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "unavailable-svc",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.BackendConfigKey: `{"default": "unavailable"}`},
+		},
+		Spec: api_v1.ServiceSpec{Ports: []api_v1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	certMap := "my-map"
+	validator := NewValidator(
+		fake.NewSimpleClientset(svc, badSvc, unavailableSvc),
+		&backendconfig.FakeBackendConfigGetter{
+			Configs: map[string]*backendconfig.BackendConfig{
+				"default/config": {ObjectMeta: meta_v1.ObjectMeta{Name: "config", Namespace: "default"}},
+			},
+			Errs: map[string]error{
+				"default/unavailable": &backendconfig.RetrievalError{Namespace: "default", Name: "unavailable", Err: fmt.Errorf("apiserver unavailable")},
+			},
+		},
+		&frontendconfig.FakeFrontendConfigGetter{
+			Configs: map[string]*frontendconfig.FrontendConfig{
+				"default/frontend": {ObjectMeta: meta_v1.ObjectMeta{Name: "frontend", Namespace: "default"}},
+				"default/cert-map": {
+					ObjectMeta: meta_v1.ObjectMeta{Name: "cert-map", Namespace: "default"},
+					Spec:       frontendconfig.FrontendConfigSpec{CertificateMap: &certMap},
+				},
+			},
+			Errs: map[string]error{
+				"default/unavailable": &frontendconfig.RetrievalError{Namespace: "default", Name: "unavailable", Err: fmt.Errorf("apiserver unavailable")},
+			},
+		},
+	)
+	server := httptest.NewServer(NewHandler(validator))
+	defer server.Close()
+
+	tls := func(n int) string {
+		list := `[`
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				list += ","
+			}
+			list += fmt.Sprintf(`{"secretName": "s%d"}`, i)
+		}
+		return list + `]`
+	}
+	manyTLS := tls(16)
+
+	for _, tc := range []struct {
+		desc    string
+		kind    string
+		object  string
+		wantErr string
+	}{
+		{
+			desc:   "valid Ingress",
+			kind:   "Ingress",
+			object: `{"metadata": {"name": "ing", "annotations": {"kubernetes.io/ingress.allow-http": "false", "beta.cloud.google.com/frontend-config": "frontend"}}, "spec": {"rules": [{"http": {"paths": [{"path": "/foo/*", "pathType": "ImplementationSpecific", "backend": {"serviceName": "svc", "servicePort": "http"}}]}}]}}`,
+		},
+		{
+			desc:   "Ingress of another class",
+			kind:   "Ingress",
+			object: `{"metadata": {"name": "ing", "annotations": {"kubernetes.io/ingress.class": "nginx"}}, "spec": {"rules": [{"http": {"paths": [{"path": "/foo(.*)", "backend": {"serviceName": "svc", "servicePort": 80}}]}}]}}`,
+		},
+		{
+			desc:   "Service which doesn't exist yet",
+			kind:   "Ingress",
+			object: `{"metadata": {"name": "ing"}, "spec": {"backend": {"serviceName": "later", "servicePort": 80}}}`,
+		},
+		{
+			desc:    "regex path",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing"}, "spec": {"rules": [{"http": {"paths": [{"path": "/foo/.*", "backend": {"serviceName": "svc", "servicePort": 81}}]}}]}}`,
+			wantErr: "regexes are not supported",
+		},
+		{
+			desc:   "Prefix and Exact pathTypes",
+			kind:   "Ingress",
+			object: `{"metadata": {"name": "ing"}, "spec": {"rules": [{"http": {"paths": [{"path": "/foo", "pathType": "Prefix", "backend": {"serviceName": "svc", "servicePort": 81}}, {"path": "/bar", "pathType": "Exact", "backend": {"serviceName": "svc", "servicePort": 81}}]}}]}}`,
+		},
+		{
+			desc:    "Prefix path with a wildcard",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing"}, "spec": {"rules": [{"http": {"paths": [{"path": "/foo/*", "pathType": "Prefix", "backend": {"serviceName": "svc", "servicePort": 81}}]}}]}}`,
+			wantErr: "Prefix paths can't contain *",
+		},
+		{
+			desc:    "too many certificates",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing"}, "spec": {"tls": ` + manyTLS + `, "backend": {"serviceName": "svc", "servicePort": 81}}}`,
+			wantErr: "16 TLS Secrets",
+		},
+		{
+			desc:    "too many certificates with pre-shared certificates",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing", "annotations": {"ingress.gcp.kubernetes.io/pre-shared-cert": "c1,c2,c3,c4,c5,c6"}}, "spec": {"tls": ` + tls(10) + `, "backend": {"serviceName": "svc", "servicePort": 81}}}`,
+			wantErr: "10 TLS Secrets and 6 pre-shared certificates",
+		},
+		{
+			desc:    "too many certificates with a managed certificate",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing", "annotations": {"ingress.gcp.kubernetes.io/pre-shared-cert": "c1,c2,c3,c4,c5", "ingress.gcp.kubernetes.io/managed-certificates": "true"}}, "spec": {"tls": ` + tls(10) + `, "backend": {"serviceName": "svc", "servicePort": 81}}}`,
+			wantErr: "10 TLS Secrets, 5 pre-shared certificates and a managed certificate",
+		},
+		{
+			desc:    "invalid annotation",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing", "annotations": {"ingress.gcp.kubernetes.io/firewall-src-ranges": "10.0.0.0/33"}}, "spec": {"backend": {"serviceName": "svc", "servicePort": 81}}}`,
+			wantErr: "firewall-src-ranges",
+		},
+		{
+			desc:    "invalid naming scheme",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing", "annotations": {"ingress.gcp.kubernetes.io/naming-scheme": "v3"}}, "spec": {"backend": {"serviceName": "svc", "servicePort": 81}}}`,
+			wantErr: "naming-scheme",
+		},
+		{
+			desc:    "nonexistent FrontendConfig",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing", "annotations": {"beta.cloud.google.com/frontend-config": "missing"}}, "spec": {"backend": {"serviceName": "svc", "servicePort": 81}}}`,
+			wantErr: "FrontendConfig default/missing not found",
+		},
+		{
+			desc:   "certificate map",
+			kind:   "Ingress",
+			object: `{"metadata": {"name": "ing", "annotations": {"beta.cloud.google.com/frontend-config": "cert-map"}}, "spec": {"backend": {"serviceName": "svc", "servicePort": 81}}}`,
+		},
+		{
+			desc:    "certificate map and TLS Secrets",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing", "annotations": {"beta.cloud.google.com/frontend-config": "cert-map"}}, "spec": {"tls": ` + tls(1) + `, "backend": {"serviceName": "svc", "servicePort": 81}}}`,
+			wantErr: "exclusive with spec.tls",
+		},
+		{
+			desc:    "nonexistent BackendConfig",
+			kind:    "Ingress",
+			object:  `{"metadata": {"name": "ing"}, "spec": {"backend": {"serviceName": "bad-svc", "servicePort": 80}}}`,
+			wantErr: "BackendConfig default/missing not found",
+		},
+		{
+			desc:   "unavailable BackendConfig and FrontendConfig",
+			kind:   "Ingress",
+			object: `{"metadata": {"name": "ing", "annotations": {"beta.cloud.google.com/frontend-config": "unavailable"}}, "spec": {"backend": {"serviceName": "unavailable-svc", "servicePort": 80}}}`,
+		},
+		{
+			desc:   "valid BackendConfig",
+			kind:   "BackendConfig",
+			object: `{"metadata": {"name": "config"}, "spec": {"cdn": {"enabled": true}}}`,
+		},
+		{
+			desc:    "invalid BackendConfig",
+			kind:    "BackendConfig",
+			object:  `{"metadata": {"name": "config"}, "spec": {"sessionAffinity": {"affinityType": "RANDOM"}}}`,
+			wantErr: "invalid sessionAffinity.affinityType",
+		},
+	} {
+		review := AdmissionReview{Request: &AdmissionRequest{
+			UID:       "uid",
+			Kind:      meta_v1.GroupVersionKind{Kind: tc.kind},
+			Namespace: "default",
+			Operation: "CREATE",
+			Object:    json.RawMessage(tc.object),
+		}}
+		body, _ := json.Marshal(review)
+		resp, err := http.Post(server.URL+Path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%v: %v", tc.desc, err)
+		}
+		got := AdmissionReview{}
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil || got.Response == nil {
+			t.Fatalf("%v: invalid response %+v: %v", tc.desc, got, err)
+		}
+		if got.Response.UID != "uid" {
+			t.Errorf("%v: got response UID %q, want %q", tc.desc, got.Response.UID, "uid")
+		}
+		if tc.wantErr == "" {
+			if !got.Response.Allowed {
+				t.Errorf("%v: rejected: %+v", tc.desc, got.Response.Result)
+			}
+			continue
+		}
+		if got.Response.Allowed || got.Response.Result == nil || !strings.Contains(got.Response.Result.Message, tc.wantErr) {
+			t.Errorf("%v: got response %+v, want a rejection with %q", tc.desc, got.Response, tc.wantErr)
+		}
+	}
+}
+
+func TestMutatingWebhook(t *testing.T) {
+	negSvc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "neg",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.NetworkEndpointGroupAlphaAnnotation: "true"},
+		},
+		Spec: api_v1.ServiceSpec{Selector: map[string]string{"app": "neg"}},
+	}
+	otherSvc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "other", Namespace: "default"},
+		Spec:       api_v1.ServiceSpec{Selector: map[string]string{"app": "other"}},
+	}
+	server := httptest.NewServer(NewMutatingHandler(NewPodMutator(fake.NewSimpleClientset(negSvc, otherSvc))))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		desc      string
+		kind      string
+		object    string
+		wantPatch string
+	}{
+		{
+			desc:      "pod of a service with NEGs",
+			kind:      "Pod",
+			object:    `{"metadata": {"name": "pod", "labels": {"app": "neg"}}}`,
+			wantPatch: `[{"op":"add","path":"/spec/readinessGates","value":[{"conditionType":"cloud.google.com/load-balancer-neg-ready"}]}]`,
+		},
+		{
+			desc:      "pod with other readiness gates",
+			kind:      "Pod",
+			object:    `{"metadata": {"name": "pod", "labels": {"app": "neg"}}, "spec": {"readinessGates": [{"conditionType": "other"}]}}`,
+			wantPatch: `[{"op":"add","path":"/spec/readinessGates/-","value":{"conditionType":"cloud.google.com/load-balancer-neg-ready"}}]`,
+		},
+		{
+			desc:   "pod with the readiness gate",
+			kind:   "Pod",
+			object: `{"metadata": {"name": "pod", "labels": {"app": "neg"}}, "spec": {"readinessGates": [{"conditionType": "cloud.google.com/load-balancer-neg-ready"}]}}`,
+		},
+		{
+			desc:   "pod of a service without NEGs",
+			kind:   "Pod",
+			object: `{"metadata": {"name": "pod", "labels": {"app": "other"}}}`,
+		},
+		{
+			desc:   "other kind",
+			kind:   "Service",
+			object: `{"metadata": {"name": "svc", "labels": {"app": "neg"}}}`,
+		},
+	} {
+		review := AdmissionReview{Request: &AdmissionRequest{
+			UID:       "uid",
+			Kind:      meta_v1.GroupVersionKind{Kind: tc.kind},
+			Namespace: "default",
+			Operation: "CREATE",
+			Object:    json.RawMessage(tc.object),
+		}}
+		body, _ := json.Marshal(review)
+		resp, err := http.Post(server.URL+MutatePath, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%v: %v", tc.desc, err)
+		}
+		got := AdmissionReview{}
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil || got.Response == nil {
+			t.Fatalf("%v: invalid response %+v: %v", tc.desc, got, err)
+		}
+		if !got.Response.Allowed {
+			t.Errorf("%v: rejected: %+v", tc.desc, got.Response.Result)
+		}
+		if string(got.Response.Patch) != tc.wantPatch {
+			t.Errorf("%v: got patch %s, want %s", tc.desc, got.Response.Patch, tc.wantPatch)
+		}
+		if tc.wantPatch != "" && (got.Response.PatchType == nil || *got.Response.PatchType != PatchTypeJSONPatch) {
+			t.Errorf("%v: got patch type %v, want %v", tc.desc, got.Response.PatchType, PatchTypeJSONPatch)
+		}
+	}
+}
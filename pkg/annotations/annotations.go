@@ -19,7 +19,12 @@ package annotations
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"regexp"
 	"strconv"
+	"strings"
+
+	extensions "k8s.io/api/extensions/v1beta1"
 
 	"k8s.io/ingress-gce/pkg/utils"
 )
@@ -39,36 +44,277 @@ const (
 	// responsibility to create/delete it.
 	StaticIPNameKey = "kubernetes.io/ingress.global-static-ip-name"
 
+	// IPv6Key tells the Ingress controller to also create IPv6 forwarding
+	// rules for the Ingress, with a global IPv6 address reserved by the
+	// controller. The IPv4 forwarding rules are left as is.
+	IPv6Key = "ingress.gcp.kubernetes.io/ipv6"
+
+	// StaticIPv6NameKey names an existing global IPv6 address the Ingress
+	// controller assigns to the IPv6 forwarding rules of the Ingress, instead
+	// of reserving one. Implies IPv6Key. The controller *does not* manage this
+	// address, it is the users responsibility to create/delete it.
+	StaticIPv6NameKey = "ingress.gcp.kubernetes.io/global-static-ipv6-name"
+
 	// PreSharedCertKey represents the specific pre-shared SSL
-	// certicate for the Ingress controller to use. The controller *does not*
-	// manage this certificate, it is the users responsibility to create/delete it.
-	// In GCP, the Ingress controller assigns the SSL certificate with this name
-	// to the target proxies of the Ingress.
+	// certicates for the Ingress controller to use, as a comma separated list
+	// of names. The controller *does not* manage these certificates, it is the
+	// users responsibility to create/delete them. In GCP, the Ingress
+	// controller assigns the SSL certificates with these names, in order, to
+	// the target proxies of the Ingress.
 	PreSharedCertKey = "ingress.gcp.kubernetes.io/pre-shared-cert"
+	// ManagedCertificatesKey, if "true", makes the controller provision a
+	// Google-managed certificate for the hosts of the rules of the Ingress,
+	// served after the pre-shared certificates. Its provisioning status is
+	// raised as events on the Ingress.
+	ManagedCertificatesKey = "ingress.gcp.kubernetes.io/managed-certificates"
+
+	// FirewallSrcRangesKey is a comma separated list of CIDRs the Ingress
+	// wants to allow through the L7 firewall rule, in addition to the ranges
+	// configured through --firewall-src-ranges. Since the firewall rule is
+	// shared by all Ingresses in the cluster, the rule allows the union of
+	// all requested ranges.
+	// Example:
+	// '10.0.0.0/8,192.168.0.0/16'
+	FirewallSrcRangesKey = "ingress.gcp.kubernetes.io/firewall-src-ranges"
+
+	// FirewallNetworksKey is a comma separated list of networks, other than
+	// the cluster network, on which the Ingress wants the L7 firewall rules
+	// to be created, e.g for backends reached through an additional NIC.
+	// Networks are either names, in the project of the cluster network, or
+	// URLs. As with the source ranges, the rules are created on the union of
+	// all requested networks.
+	// Example:
+	// 'backend-network,projects/my-host-project/global/networks/other'
+	FirewallNetworksKey = "ingress.gcp.kubernetes.io/firewall-networks"
+
+	// RedirectsKey is a JSON list of paths the Ingress answers with a
+	// redirect instead of a backend, with the redirect fields of the GCE API.
+	// An empty host is the hostless rule. The redirect takes precedence over
+	// a backend of the same path in the rules of the Ingress.
+	// Example:
+	// '[{"host": "old.example.com", "path": "/old/*", "hostRedirect": "new.example.com", "prefixRedirect": "/", "httpsRedirect": true}]'
+	RedirectsKey = "ingress.gcp.kubernetes.io/redirects"
+
+	// TrafficSplitKey is a JSON list of paths the Ingress splits between
+	// several Services, each getting a share of the requests proportional to
+	// its weight, eg: for canary rollouts. An empty host is the hostless
+	// rule. The split takes precedence over a backend of the same path in the
+	// rules of the Ingress, but not over a redirect.
+	// Example:
+	// '[{"host": "foo.example.com", "path": "/*", "backends": [{"serviceName": "app", "servicePort": 80, "weight": 90}, {"serviceName": "app-canary", "servicePort": 80, "weight": 10}]}]'
+	TrafficSplitKey = "ingress.gcp.kubernetes.io/traffic-split"
+
+	// RouteRulesKey is a JSON list of rules routing the requests of a host
+	// of the Ingress on their path, headers and query parameters, with the
+	// match fields of the GCE API, eg: for A/B tests. An empty host is the
+	// hostless rule. The rules take precedence over the paths of their host,
+	// in priority order, the lowest first.
+	// Example:
+	// '[{"host": "foo.example.com", "priority": 1, "matches": [{"prefixMatch": "/", "headerMatches": [{"headerName": "x-canary", "exactMatch": "true"}]}], "backends": [{"serviceName": "app-canary", "servicePort": 80}]}]'
+	RouteRulesKey = "ingress.gcp.kubernetes.io/route-rules"
+
+	// FrontendConfigKey is the name of the FrontendConfig, in the namespace
+	// of the Ingress, applied to the target proxies of the Ingress.
+	// Example:
+	// 'my-frontendconfig'
+	FrontendConfigKey = "beta.cloud.google.com/frontend-config"
 
 	// ServiceApplicationProtocolKey is a stringified JSON map of port names to
-	// protocol strings. Possible values are HTTP, HTTPS
+	// protocol strings. Possible values are HTTP, HTTPS, HTTP2 and GRPC, the
+	// latter being served as HTTP2.
 	// Example:
 	// '{"my-https-port":"HTTPS","my-http-port":"HTTP"}'
 	ServiceApplicationProtocolKey = "service.alpha.kubernetes.io/app-protocols"
 
+	// GoogleServiceApplicationProtocolKey is the same as
+	// ServiceApplicationProtocolKey, and takes precedence over it.
+	GoogleServiceApplicationProtocolKey = "cloud.google.com/app-protocols"
+
+	// appProtocolGRPC is the application protocol of gRPC ports. gRPC is
+	// served by HTTP2 backend services.
+	appProtocolGRPC = "GRPC"
+
+	// BackendConfigKey is a stringified JSON object naming the BackendConfigs,
+	// in the namespace of the Service, applied to the backend services of
+	// the Service ports. Ports are referenced by name or number, the default
+	// BackendConfig applies to all other ports.
+	// Example:
+	// '{"ports": {"my-https-port": "https-config"}, "default": "config"}'
+	BackendConfigKey = "beta.cloud.google.com/backend-config"
+
+	// ServerlessNEGKey is a stringified JSON object referencing an existing
+	// serverless network endpoint group, eg: of a Cloud Run service. Ingress
+	// paths pointing at a Service with this annotation are served by a
+	// backend service whose backend is the NEG, rather than by the node ports
+	// of the Service. The controller *does not* manage the NEG.
+	// Example:
+	// '{"region": "us-central1", "name": "my-cloud-run-neg"}'
+	ServerlessNEGKey = "cloud.google.com/serverless-neg"
+	// NEGKey is a stringified JSON object of the Service ports exposed as
+	// standalone network endpoint groups. The NEG controller manages these
+	// NEGs without an Ingress, so they can be attached to backend services
+	// managed by other tools. A port may set the name of its NEGs, otherwise
+	// the name is generated.
+	// Example:
+	// '{"exposed_ports": {"80": {"name": "my-neg"}, "443": {}}}'
+	NEGKey = "cloud.google.com/neg"
+
+	// NEGStatusKey is the annotation key used by the NEG controller to
+	// publish the standalone NEGs of a Service: a JSON object of the NEG
+	// names by Service port, and the zones of the NEGs. This is read only
+	// for users.
+	// Example:
+	// '{"network_endpoint_groups": {"80": "my-neg"}, "zones": ["us-central1-a"]}'
+	NEGStatusKey = "cloud.google.com/neg-status"
+	// HybridNEGKey is a stringified JSON object declaring the endpoints of a
+	// Service without selector as endpoints outside of GCP, eg: on-premises
+	// servers reached through Cloud VPN or Interconnect. The NEG controller
+	// programs the addresses of the Endpoints of the Service, maintained by
+	// the user, into NON_GCP_PRIVATE_IP_PORT NEGs in the given zone, which
+	// serve the Ingress paths pointing at the Service.
+	// Example:
+	// '{"zone": "us-central1-a"}'
+	HybridNEGKey = "cloud.google.com/hybrid-neg"
+
+	// ILBTypeKey set to "Internal" on a Service of type LoadBalancer asks
+	// for an internal TCP/UDP load balancer, in the region of the cluster.
+	ILBTypeKey = "cloud.google.com/load-balancer-type"
+	// ILBTypeInternal is the value of ILBTypeKey of the internal load
+	// balancers.
+	ILBTypeInternal = "Internal"
+	// ILBAllowGlobalAccessKey set to "true" lets the clients of all the
+	// regions of the network reach the internal load balancer of the
+	// Service, not only the clients of its region.
+	ILBAllowGlobalAccessKey = "networking.gke.io/internal-load-balancer-allow-global-access"
+	// ILBSubnetKey is the name of the subnet, in the region of the cluster,
+	// from which the IP of the internal load balancer of the Service is
+	// allocated. Defaults to the subnet of the cluster.
+	ILBSubnetKey = "networking.gke.io/internal-load-balancer-subnet"
+	// ILBFinalizerKey is the finalizer the controller places on the Services
+	// whose internal load balancer it manages, removed once their GCE
+	// resources are deleted.
+	ILBFinalizerKey = "networking.gke.io/l4-ilb-v1"
+	// NetLBFinalizerKey is the finalizer the controller places on the
+	// Services whose external network load balancer it manages, removed once
+	// their GCE resources are deleted.
+	NetLBFinalizerKey = "networking.gke.io/l4-netlb-v1"
+	// ConnectionTrackingPolicyKey is a stringified JSON object of the
+	// connection tracking of the backend service of the external network
+	// load balancer of a Service, with the fields of the GCE API.
+	// Example:
+	// '{"trackingMode": "PER_SESSION", "connectionPersistenceOnUnhealthyBackends": "NEVER_PERSIST", "idleTimeoutSec": 300}'
+	ConnectionTrackingPolicyKey = "networking.gke.io/connection-tracking-policy"
+	// FailoverPolicyKey is a stringified JSON object of the failover policy
+	// of the backend service of the external network load balancer of a
+	// Service, with the fields of the GCE API.
+	// Example:
+	// '{"dropTrafficIfUnhealthy": true, "failoverRatio": 0.5}'
+	FailoverPolicyKey = "networking.gke.io/failover-policy"
+
+	// IngressFinalizerKey is the finalizer the controller places on the
+	// Ingresses it manages, removed once their GCE resources are deleted.
+	IngressFinalizerKey = "networking.gke.io/ingress-finalizer"
+
 	// IngressClassKey picks a specific "class" for the Ingress. The controller
 	// only processes Ingresses with this annotation either unset, or set
 	// to either gceIngessClass or the empty string.
 	IngressClassKey      = "kubernetes.io/ingress.class"
 	GceIngressClass      = "gce"
 	GceMultiIngressClass = "gce-multi-cluster"
+	// GceInternalIngressClass picks a regional internal HTTP(S) load
+	// balancer, reachable from the network of the cluster, whose backends
+	// are the NEGs of the Services.
+	GceInternalIngressClass = "gce-internal"
+	// GceRegionalExternalIngressClass picks a regional external HTTP(S) load
+	// balancer, whose forwarding rules and backends stay in the region of
+	// the cluster, eg: for data residency requirements.
+	GceRegionalExternalIngressClass = "gce-regional-external"
+
+	// RegionalStaticIPNameKey tells the Ingress controller to use a specific
+	// GCE regional static ip for the forwarding rules of a regional load
+	// balancer. The controller *does not* manage this ip, it is the users
+	// responsibility to create/delete it.
+	RegionalStaticIPNameKey = "kubernetes.io/ingress.regional-static-ip-name"
+
+	// RegionalIngressFinalizerKey is the finalizer the controller places on
+	// the Ingresses of the regional classes, removed once their regional
+	// load balancer is deleted.
+	RegionalIngressFinalizerKey = "networking.gke.io/regional-ingress-finalizer"
 
 	// Label key to denote which GCE zone a Kubernetes node is in.
 	ZoneKey     = "failure-domain.beta.kubernetes.io/zone"
 	DefaultZone = ""
 
+	// ExcludeFromLBLabelKey is the standard node label, whatever its value,
+	// keeping a node out of the backends of load balancers.
+	ExcludeFromLBLabelKey = "node.kubernetes.io/exclude-from-external-load-balancers"
+
+	// OSLabelKey and BetaOSLabelKey are the node labels with the operating
+	// system of the node, eg: "windows".
+	OSLabelKey     = "kubernetes.io/os"
+	BetaOSLabelKey = "beta.kubernetes.io/os"
+	// WindowsOS is the operating system of Windows nodes.
+	WindowsOS = "windows"
+
 	// InstanceGroupsAnnotationKey is the annotation key used by controller to
 	// specify the name and zone of instance groups created for the ingress.
 	// This is read only for users. Controller will overrite any user updates.
 	// This is only set for ingresses with ingressClass = "gce-multi-cluster"
 	InstanceGroupsAnnotationKey = "ingress.gcp.kubernetes.io/instance-groups"
 
+	// FirewallChangeRequiredKey is the annotation key used by the controller to
+	// record a firewall change it could not apply itself, eg: on a Shared VPC
+	// cluster without firewall permissions in the host project. The value is
+	// a JSON description of the change, including the gcloud command a
+	// network admin needs to run. The controller removes the annotation once
+	// the change is no longer required.
+	FirewallChangeRequiredKey = "ingress.gcp.kubernetes.io/firewall-change-required"
+
+	// BackendHealthKey is the annotation key used by the controller to
+	// publish the health of the backend services of an Ingress: a JSON map of
+	// backend service names to the number of healthy and unhealthy
+	// endpoints. This is read only for users.
+	// Example:
+	// '{"k8s-be-30080--uid": {"healthy": 3, "unhealthy": 0}}'
+	BackendHealthKey = "ingress.gcp.kubernetes.io/backend-health"
+
+	// ConditionsKey is the annotation key used by the controller to publish
+	// the conditions of an Ingress, since the Ingress status has no
+	// conditions: a JSON list of conditions with their status, reason and
+	// last transition time. This is read only for users.
+	// Example:
+	// '[{"type": "Synced", "status": "True", "reason": "Synced", "lastTransitionTime": "2018-05-01T10:00:00Z"}]'
+	ConditionsKey = "ingress.gcp.kubernetes.io/conditions"
+
+	// NamingSchemeKey is the annotation key used by the controller to
+	// persist the naming scheme of the GCE resources of an Ingress, "v1" or
+	// "v2". It is set on the first sync: Ingresses already served by the
+	// controller keep "v1", new Ingresses get "v2". Changing it recreates
+	// the load balancer under the names of the other scheme.
+	NamingSchemeKey = "ingress.gcp.kubernetes.io/naming-scheme"
+
+	// DeletionProtectionKey is the annotation key of the Ingresses whose load
+	// balancer is only deleted once the deletion is confirmed with
+	// ConfirmDeletionKey, whether it serves traffic or not. Requires the
+	// finalizer, which the controller places on these Ingresses.
+	// Example:
+	// 'ingress.gcp.kubernetes.io/deletion-protection: "true"'
+	DeletionProtectionKey = "ingress.gcp.kubernetes.io/deletion-protection"
+
+	// ConfirmDeletionKey is the annotation key confirming the deletion of the
+	// protected load balancer of an Ingress being deleted, with the value
+	// "true".
+	ConfirmDeletionKey = "ingress.gcp.kubernetes.io/confirm-deletion"
+
+	// LBGroupKey is the annotation key of the Ingresses served by the single
+	// load balancer of an LB group: their rules are merged into one url map
+	// behind one forwarding rule. The value is the name of the group, a
+	// DNS-1123 label, of the namespace of the Ingress, or namespace/group
+	// for a group of another namespace whose ReferenceGrants allow it.
+	// Example:
+	// 'ingress.gcp.kubernetes.io/lb-group: shared'
+	LBGroupKey = "ingress.gcp.kubernetes.io/lb-group"
+
 	// NetworkEndpointGroupAlphaAnnotation is the annotation key to enable GCE NEG feature for ingress backend services.
 	// To enable this feature, the value of the annotation must be "true".
 	// This annotation should be specified on services that are backing ingresses.
@@ -95,7 +341,27 @@ func (ing IngAnnotations) AllowHTTP() bool {
 	return v
 }
 
-// UseNamedTLS returns the name of the GCE SSL certificate. Empty by default.
+// DeletionProtection returns true if the load balancer of the Ingress is
+// protected from deletion. False by default.
+func (ing IngAnnotations) DeletionProtection() bool {
+	v, _ := strconv.ParseBool(ing[DeletionProtectionKey])
+	return v
+}
+
+// DeletionConfirmed returns true if the deletion of the protected load
+// balancer of the Ingress is confirmed. False by default.
+func (ing IngAnnotations) DeletionConfirmed() bool {
+	v, _ := strconv.ParseBool(ing[ConfirmDeletionKey])
+	return v
+}
+
+// LBGroup returns the name of the LB group of the Ingress. Empty by default.
+func (ing IngAnnotations) LBGroup() string {
+	return ing[LBGroupKey]
+}
+
+// UseNamedTLS returns the comma separated names of the GCE SSL certificates.
+// Empty by default.
 func (ing IngAnnotations) UseNamedTLS() string {
 	val, ok := ing[PreSharedCertKey]
 	if !ok {
@@ -113,6 +379,363 @@ func (ing IngAnnotations) StaticIPName() string {
 	return val
 }
 
+// RegionalStaticIPName returns the name of the regional static ip of the
+// regional load balancer of the Ingress. Empty by default.
+func (ing IngAnnotations) RegionalStaticIPName() string {
+	return ing[RegionalStaticIPNameKey]
+}
+
+// ManagedCertificates returns true if the Ingress requests a Google-managed
+// certificate for its hosts. False by default.
+func (ing IngAnnotations) ManagedCertificates() bool {
+	val, ok := ing[ManagedCertificatesKey]
+	if !ok {
+		return false
+	}
+	v, err := strconv.ParseBool(val)
+	if err != nil {
+		return false
+	}
+	return v
+}
+
+// IPv6 returns true if the Ingress requests IPv6 forwarding rules. False by
+// default.
+func (ing IngAnnotations) IPv6() bool {
+	if ing.StaticIPv6Name() != "" {
+		return true
+	}
+	val, ok := ing[IPv6Key]
+	if !ok {
+		return false
+	}
+	v, err := strconv.ParseBool(val)
+	if err != nil {
+		return false
+	}
+	return v
+}
+
+// NamingScheme returns the naming scheme of the resources of the Ingress,
+// empty if the controller didn't persist it yet.
+func (ing IngAnnotations) NamingScheme() string {
+	return ing[NamingSchemeKey]
+}
+
+// StaticIPv6Name returns the name of the global IPv6 address of the Ingress.
+// Empty by default.
+func (ing IngAnnotations) StaticIPv6Name() string {
+	return ing[StaticIPv6NameKey]
+}
+
+// FirewallSrcRanges returns the source ranges requested for the firewall
+// rule. Empty by default. An error is returned if any range is not a valid
+// CIDR.
+func (ing IngAnnotations) FirewallSrcRanges() ([]string, error) {
+	val, ok := ing[FirewallSrcRangesKey]
+	if !ok {
+		return nil, nil
+	}
+	var ranges []string
+	for _, r := range strings.Split(val, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return nil, fmt.Errorf("invalid %v annotation value %q: %v", FirewallSrcRangesKey, val, err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// FirewallNetworks returns the additional networks requested for the
+// firewall rules. Empty by default.
+func (ing IngAnnotations) FirewallNetworks() []string {
+	val, ok := ing[FirewallNetworksKey]
+	if !ok {
+		return nil
+	}
+	var networks []string
+	for _, n := range strings.Split(val, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// PathRedirect is a path answered with a redirect, see RedirectsKey.
+type PathRedirect struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+	utils.URLRedirect
+}
+
+// redirectResponseCodes are the redirect response codes of the GCE API.
+var redirectResponseCodes = map[string]bool{
+	"MOVED_PERMANENTLY_DEFAULT": true,
+	"FOUND":                     true,
+	"SEE_OTHER":                 true,
+	"TEMPORARY_REDIRECT":        true,
+	"PERMANENT_REDIRECT":        true,
+}
+
+// Redirects returns the paths of the Ingress answered with a redirect. None by
+// default.
+func (ing IngAnnotations) Redirects() ([]PathRedirect, error) {
+	val, ok := ing[RedirectsKey]
+	if !ok {
+		return nil, nil
+	}
+	var redirects []PathRedirect
+	if err := json.Unmarshal([]byte(val), &redirects); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", RedirectsKey, val, err)
+	}
+	for _, r := range redirects {
+		if err := validateRedirect(r); err != nil {
+			return nil, fmt.Errorf("invalid %v annotation, redirect of %v%v: %v", RedirectsKey, r.Host, r.Path, err)
+		}
+	}
+	return redirects, nil
+}
+
+func validateRedirect(r PathRedirect) error {
+	if r.Path == "" {
+		return fmt.Errorf("no path")
+	}
+	if err := utils.ValidatePath(r.Path); err != nil {
+		return err
+	}
+	if r.PrefixRedirect != "" && !strings.HasSuffix(r.Path, "/*") {
+		return fmt.Errorf("prefixRedirect needs a path ending with /*")
+	}
+	return validateURLRedirect(r.URLRedirect)
+}
+
+func validateURLRedirect(r utils.URLRedirect) error {
+	if r.PathRedirect != "" && r.PrefixRedirect != "" {
+		return fmt.Errorf("pathRedirect and prefixRedirect are exclusive")
+	}
+	if c := r.RedirectResponseCode; c != "" && !redirectResponseCodes[c] {
+		return fmt.Errorf("unknown redirectResponseCode %q", c)
+	}
+	return nil
+}
+
+// TrafficSplit is a path split between weighted backends, see
+// TrafficSplitKey.
+type TrafficSplit struct {
+	Host     string            `json:"host"`
+	Path     string            `json:"path"`
+	Backends []WeightedBackend `json:"backends"`
+}
+
+// WeightedBackend is a backend of a TrafficSplit.
+type WeightedBackend struct {
+	extensions.IngressBackend `json:",inline"`
+	// Weight is between 0 and 1000.
+	Weight int64 `json:"weight"`
+}
+
+// maxBackendWeight is the maximum weight of a backend allowed by GCE.
+const maxBackendWeight = 1000
+
+// TrafficSplits returns the paths of the Ingress split between weighted
+// backends. None by default.
+func (ing IngAnnotations) TrafficSplits() ([]TrafficSplit, error) {
+	val, ok := ing[TrafficSplitKey]
+	if !ok {
+		return nil, nil
+	}
+	var splits []TrafficSplit
+	if err := json.Unmarshal([]byte(val), &splits); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", TrafficSplitKey, val, err)
+	}
+	for _, s := range splits {
+		if err := validateTrafficSplit(s); err != nil {
+			return nil, fmt.Errorf("invalid %v annotation, split of %v%v: %v", TrafficSplitKey, s.Host, s.Path, err)
+		}
+	}
+	return splits, nil
+}
+
+// Backends returns the backends of the traffic splits and the route rules of
+// the Ingress, skipping the invalid annotations.
+func (ing IngAnnotations) Backends() []extensions.IngressBackend {
+	var backends []extensions.IngressBackend
+	splits, _ := ing.TrafficSplits()
+	for _, s := range splits {
+		for _, b := range s.Backends {
+			backends = append(backends, b.IngressBackend)
+		}
+	}
+	rules, _ := ing.RouteRules()
+	for _, r := range rules {
+		for _, b := range r.Backends {
+			backends = append(backends, b.IngressBackend)
+		}
+	}
+	return backends
+}
+
+func validateTrafficSplit(s TrafficSplit) error {
+	if s.Path == "" {
+		return fmt.Errorf("no path")
+	}
+	if err := utils.ValidatePath(s.Path); err != nil {
+		return err
+	}
+	if len(s.Backends) == 0 {
+		return fmt.Errorf("no backends")
+	}
+	return validateWeightedBackends(s.Backends)
+}
+
+// validateWeightedBackends returns an error if the given backends are invalid,
+// or if all their weights are 0.
+func validateWeightedBackends(backends []WeightedBackend) error {
+	var total int64
+	for _, b := range backends {
+		if b.ServiceName == "" {
+			return fmt.Errorf("backend without serviceName")
+		}
+		if b.ServicePort.IntVal == 0 && b.ServicePort.StrVal == "" {
+			return fmt.Errorf("backend %v without servicePort", b.ServiceName)
+		}
+		if b.Weight < 0 || b.Weight > maxBackendWeight {
+			return fmt.Errorf("the weight of backend %v must be between 0 and %v", b.ServiceName, maxBackendWeight)
+		}
+		total += b.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("all the weights are 0")
+	}
+	return nil
+}
+
+// RouteRule is a rule routing requests of a host, see RouteRulesKey. The
+// requests matching any of its matches are answered with its redirect, or
+// split between its backends, with their weights if there are several.
+type RouteRule struct {
+	Host     string             `json:"host"`
+	Priority int64              `json:"priority"`
+	Matches  []utils.RouteMatch `json:"matches"`
+	Backends []WeightedBackend  `json:"backends,omitempty"`
+	Redirect *utils.URLRedirect `json:"redirect,omitempty"`
+	// Rewrite rewrites the requests before they are forwarded to the
+	// backends.
+	Rewrite      *utils.URLRewrite   `json:"rewrite,omitempty"`
+	HeaderAction *utils.HeaderAction `json:"headerAction,omitempty"`
+}
+
+// RouteRules returns the route rules of the Ingress. None by default.
+func (ing IngAnnotations) RouteRules() ([]RouteRule, error) {
+	val, ok := ing[RouteRulesKey]
+	if !ok {
+		return nil, nil
+	}
+	var rules []RouteRule
+	if err := json.Unmarshal([]byte(val), &rules); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", RouteRulesKey, val, err)
+	}
+	for i, r := range rules {
+		if err := validateRouteRule(r); err != nil {
+			return nil, fmt.Errorf("invalid %v annotation, rule %d: %v", RouteRulesKey, i, err)
+		}
+	}
+	return rules, nil
+}
+
+func validateRouteRule(r RouteRule) error {
+	if r.Priority < 0 {
+		return fmt.Errorf("negative priority")
+	}
+	if len(r.Matches) == 0 {
+		return fmt.Errorf("no matches")
+	}
+	for _, m := range r.Matches {
+		if err := validateRouteMatch(m); err != nil {
+			return err
+		}
+	}
+	switch {
+	case r.Redirect != nil && len(r.Backends) > 0:
+		return fmt.Errorf("redirect and backends are exclusive")
+	case r.Redirect != nil:
+		if r.Rewrite != nil {
+			return fmt.Errorf("a redirect can't be rewritten")
+		}
+		if r.Redirect.PrefixRedirect != "" {
+			for _, m := range r.Matches {
+				if m.PrefixMatch == "" {
+					return fmt.Errorf("prefixRedirect needs prefix matches")
+				}
+			}
+		}
+		return validateURLRedirect(*r.Redirect)
+	case len(r.Backends) == 1:
+		// The weight of a single backend doesn't matter.
+		b := r.Backends[0]
+		b.Weight = 1
+		return validateWeightedBackends([]WeightedBackend{b})
+	case len(r.Backends) > 0:
+		return validateWeightedBackends(r.Backends)
+	}
+	return fmt.Errorf("no redirect or backends")
+}
+
+func validateRouteMatch(m utils.RouteMatch) error {
+	path := m.PrefixMatch
+	if (m.PrefixMatch == "") == (m.FullPathMatch == "") {
+		return fmt.Errorf("a match needs either a prefixMatch or a fullPathMatch")
+	}
+	if path == "" {
+		path = m.FullPathMatch
+	}
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path %q must start with /", path)
+	}
+	for _, h := range m.HeaderMatches {
+		set := 0
+		for _, v := range []string{h.ExactMatch, h.PrefixMatch, h.SuffixMatch, h.RegexMatch} {
+			if v != "" {
+				set++
+			}
+		}
+		if h.PresentMatch {
+			set++
+		}
+		if h.HeaderName == "" || set != 1 {
+			return fmt.Errorf("header match %q needs a headerName and exactly one match", h.HeaderName)
+		}
+	}
+	for _, q := range m.QueryParameterMatches {
+		set := 0
+		for _, v := range []string{q.ExactMatch, q.RegexMatch} {
+			if v != "" {
+				set++
+			}
+		}
+		if q.PresentMatch {
+			set++
+		}
+		if q.Name == "" || set != 1 {
+			return fmt.Errorf("query parameter match %q needs a name and exactly one match", q.Name)
+		}
+	}
+	return nil
+}
+
+// FrontendConfig returns the name of the FrontendConfig of the Ingress. Empty
+// by default.
+func (ing IngAnnotations) FrontendConfig() string {
+	return strings.TrimSpace(ing[FrontendConfigKey])
+}
+
 func (ing IngAnnotations) IngressClass() string {
 	val, ok := ing[IngressClassKey]
 	if !ok {
@@ -124,8 +747,15 @@ func (ing IngAnnotations) IngressClass() string {
 // SvcAnnotations represents Service annotations.
 type SvcAnnotations map[string]string
 
+// ApplicationProtocols returns the application protocols of the Service
+// ports, keyed by port name. gRPC ports are returned as HTTP2. They take
+// precedence over the appProtocol field of the ports, see
+// PortApplicationProtocols.
 func (svc SvcAnnotations) ApplicationProtocols() (map[string]utils.AppProtocol, error) {
-	val, ok := svc[ServiceApplicationProtocolKey]
+	val, ok := svc[GoogleServiceApplicationProtocolKey]
+	if !ok {
+		val, ok = svc[ServiceApplicationProtocolKey]
+	}
 	if !ok {
 		return map[string]utils.AppProtocol{}, nil
 	}
@@ -134,9 +764,11 @@ func (svc SvcAnnotations) ApplicationProtocols() (map[string]utils.AppProtocol,
 	err := json.Unmarshal([]byte(val), &portToProtos)
 
 	// Verify protocol is an accepted value
-	for _, proto := range portToProtos {
+	for port, proto := range portToProtos {
 		switch proto {
-		case utils.ProtocolHTTP, utils.ProtocolHTTPS:
+		case utils.ProtocolHTTP, utils.ProtocolHTTPS, utils.ProtocolHTTP2:
+		case appProtocolGRPC:
+			portToProtos[port] = utils.ProtocolHTTP2
 		default:
 			return nil, fmt.Errorf("invalid port application protocol: %v", proto)
 		}
@@ -145,7 +777,292 @@ func (svc SvcAnnotations) ApplicationProtocols() (map[string]utils.AppProtocol,
 	return portToProtos, err
 }
 
+// servicePorts holds the appProtocol field of the ports of a Service, which
+// the vendored Service API doesn't expose.
+type servicePorts struct {
+	Spec struct {
+		Ports []struct {
+			Name        string `json:"name"`
+			AppProtocol string `json:"appProtocol"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+// PortApplicationProtocols returns the application protocols set by the
+// appProtocol field of the ports of the given Service JSON, keyed by port
+// name. The field is free form, the protocols load balancers don't serve, eg:
+// kubernetes.io/h2c or tcp, are ignored. gRPC ports are returned as HTTP2.
+func PortApplicationProtocols(data []byte) (map[string]utils.AppProtocol, error) {
+	svc := &servicePorts{}
+	if err := json.Unmarshal(data, svc); err != nil {
+		return nil, err
+	}
+	protocols := map[string]utils.AppProtocol{}
+	for _, p := range svc.Spec.Ports {
+		switch proto := utils.AppProtocol(strings.ToUpper(p.AppProtocol)); proto {
+		case utils.ProtocolHTTP, utils.ProtocolHTTPS, utils.ProtocolHTTP2:
+			protocols[p.Name] = proto
+		case appProtocolGRPC:
+			protocols[p.Name] = utils.ProtocolHTTP2
+		}
+	}
+	return protocols, nil
+}
+
+// ingressPathTypes holds the pathTypes of the paths of an Ingress, which the
+// vendored Ingress API doesn't expose.
+type ingressPathTypes struct {
+	Spec struct {
+		Rules []struct {
+			Host string `json:"host"`
+			HTTP *struct {
+				Paths []IngressPath `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// IngressPath is a path of the rules of an Ingress, with its pathType.
+type IngressPath struct {
+	Host     string `json:"-"`
+	Path     string `json:"path"`
+	PathType string `json:"pathType"`
+}
+
+// IngressPaths returns the paths of the rules of the given Ingress JSON, with
+// their pathType.
+func IngressPaths(data []byte) ([]IngressPath, error) {
+	ing := &ingressPathTypes{}
+	if err := json.Unmarshal(data, ing); err != nil {
+		return nil, err
+	}
+	var paths []IngressPath
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			p.Host = rule.Host
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// BackendConfigs are the BackendConfigs referenced by a Service.
+type BackendConfigs struct {
+	// Default is the BackendConfig of the ports without one.
+	Default string `json:"default,omitempty"`
+	// Ports maps port names or numbers to BackendConfigs.
+	Ports map[string]string `json:"ports,omitempty"`
+}
+
+// BackendConfigs returns the BackendConfigs referenced by the Service, or nil
+// if it references none.
+func (svc SvcAnnotations) BackendConfigs() (*BackendConfigs, error) {
+	val, ok := svc[BackendConfigKey]
+	if !ok {
+		return nil, nil
+	}
+	configs := &BackendConfigs{}
+	if err := json.Unmarshal([]byte(val), configs); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", BackendConfigKey, val, err)
+	}
+	if configs.Default == "" && len(configs.Ports) == 0 {
+		return nil, fmt.Errorf("invalid %v annotation value %q: no BackendConfig referenced", BackendConfigKey, val)
+	}
+	return configs, nil
+}
+
+// ServerlessNEG references a serverless network endpoint group.
+type ServerlessNEG struct {
+	// Region is the region of the NEG.
+	Region string `json:"region"`
+	// Name is the name of the NEG.
+	Name string `json:"name"`
+}
+
+// ServerlessNEG returns the serverless NEG referenced by the Service, or nil
+// if it references none.
+func (svc SvcAnnotations) ServerlessNEG() (*ServerlessNEG, error) {
+	val, ok := svc[ServerlessNEGKey]
+	if !ok {
+		return nil, nil
+	}
+	neg := &ServerlessNEG{}
+	if err := json.Unmarshal([]byte(val), neg); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", ServerlessNEGKey, val, err)
+	}
+	if neg.Region == "" || neg.Name == "" {
+		return nil, fmt.Errorf("invalid %v annotation value %q: region and name are required", ServerlessNEGKey, val)
+	}
+	return neg, nil
+}
+
+// HybridNEG declares the endpoints of a Service as endpoints outside of GCP.
+type HybridNEG struct {
+	// Zone is the zone of the NEGs, the zone the endpoints are reached from.
+	Zone string `json:"zone"`
+}
+
+// HybridNEG returns the hybrid NEG settings of the Service, or nil if its
+// endpoints are not declared as outside of GCP.
+func (svc SvcAnnotations) HybridNEG() (*HybridNEG, error) {
+	val, ok := svc[HybridNEGKey]
+	if !ok {
+		return nil, nil
+	}
+	neg := &HybridNEG{}
+	if err := json.Unmarshal([]byte(val), neg); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", HybridNEGKey, val, err)
+	}
+	if neg.Zone == "" {
+		return nil, fmt.Errorf("invalid %v annotation value %q: zone is required", HybridNEGKey, val)
+	}
+	return neg, nil
+}
+
+// NEGAnnotation is the value of the NEG annotation of a Service.
+type NEGAnnotation struct {
+	// ExposedPorts are the Service ports exposed as standalone NEGs.
+	ExposedPorts map[int32]NEGAttributes `json:"exposed_ports"`
+}
+
+// NEGAttributes are the settings of the NEGs of an exposed Service port.
+type NEGAttributes struct {
+	// Name is the name of the NEGs. Generated if empty.
+	Name string `json:"name,omitempty"`
+}
+
+// NEGStatus is the value of the NEG status annotation of a Service.
+type NEGStatus struct {
+	// NetworkEndpointGroups are the names of the NEGs by Service port.
+	NetworkEndpointGroups map[int32]string `json:"network_endpoint_groups"`
+	// Zones are the zones of the NEGs.
+	Zones []string `json:"zones"`
+}
+
+// negNameRegexp matches valid GCE resource names.
+var negNameRegexp = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// NEGAnnotation returns the standalone NEGs requested by the Service, or nil
+// if it requests none.
+func (svc SvcAnnotations) NEGAnnotation() (*NEGAnnotation, error) {
+	val, ok := svc[NEGKey]
+	if !ok {
+		return nil, nil
+	}
+	neg := &NEGAnnotation{}
+	if err := json.Unmarshal([]byte(val), neg); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", NEGKey, val, err)
+	}
+	names := map[string]bool{}
+	for port, attrs := range neg.ExposedPorts {
+		if attrs.Name == "" {
+			continue
+		}
+		if !negNameRegexp.MatchString(attrs.Name) {
+			return nil, fmt.Errorf("invalid %v annotation value %q: NEG name %q of port %v is not a valid GCE resource name", NEGKey, val, attrs.Name, port)
+		}
+		if names[attrs.Name] {
+			return nil, fmt.Errorf("invalid %v annotation value %q: NEG name %q is used by several ports", NEGKey, val, attrs.Name)
+		}
+		names[attrs.Name] = true
+	}
+	return neg, nil
+}
+
 func (svc SvcAnnotations) NEGEnabled() bool {
 	v, ok := svc[NetworkEndpointGroupAlphaAnnotation]
 	return ok && v == "true"
 }
+
+// ILB returns true if the Service asks for an internal load balancer. The
+// lowercase "internal" spelling of the cloud provider is accepted.
+func (svc SvcAnnotations) ILB() bool {
+	return strings.EqualFold(svc[ILBTypeKey], ILBTypeInternal)
+}
+
+// ILBAllowGlobalAccess returns true if the internal load balancer of the
+// Service is reachable from all the regions.
+func (svc SvcAnnotations) ILBAllowGlobalAccess() bool {
+	return svc[ILBAllowGlobalAccessKey] == "true"
+}
+
+// ILBSubnet returns the name of the subnet of the internal load balancer of
+// the Service, empty for the subnet of the cluster.
+func (svc SvcAnnotations) ILBSubnet() string {
+	return svc[ILBSubnetKey]
+}
+
+// ConnectionTrackingPolicy is the connection tracking of the backend service
+// of an external network load balancer.
+type ConnectionTrackingPolicy struct {
+	// TrackingMode is PER_CONNECTION, the default, or PER_SESSION, which
+	// tracks the connections by the hash of the session affinity.
+	TrackingMode string `json:"trackingMode,omitempty"`
+	// ConnectionPersistenceOnUnhealthyBackends is DEFAULT_FOR_PROTOCOL,
+	// NEVER_PERSIST or ALWAYS_PERSIST.
+	ConnectionPersistenceOnUnhealthyBackends string `json:"connectionPersistenceOnUnhealthyBackends,omitempty"`
+	// IdleTimeoutSec is the idle timeout of the tracked connections, the
+	// default of GCE if zero.
+	IdleTimeoutSec int64 `json:"idleTimeoutSec,omitempty"`
+}
+
+// ConnectionTrackingPolicy returns the connection tracking of the external
+// network load balancer of the Service, or nil if it sets none.
+func (svc SvcAnnotations) ConnectionTrackingPolicy() (*ConnectionTrackingPolicy, error) {
+	val, ok := svc[ConnectionTrackingPolicyKey]
+	if !ok {
+		return nil, nil
+	}
+	policy := &ConnectionTrackingPolicy{}
+	if err := json.Unmarshal([]byte(val), policy); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", ConnectionTrackingPolicyKey, val, err)
+	}
+	switch policy.TrackingMode {
+	case "", "PER_CONNECTION", "PER_SESSION":
+	default:
+		return nil, fmt.Errorf("invalid %v annotation value %q: trackingMode must be PER_CONNECTION or PER_SESSION", ConnectionTrackingPolicyKey, val)
+	}
+	switch policy.ConnectionPersistenceOnUnhealthyBackends {
+	case "", "DEFAULT_FOR_PROTOCOL", "NEVER_PERSIST", "ALWAYS_PERSIST":
+	default:
+		return nil, fmt.Errorf("invalid %v annotation value %q: connectionPersistenceOnUnhealthyBackends must be DEFAULT_FOR_PROTOCOL, NEVER_PERSIST or ALWAYS_PERSIST", ConnectionTrackingPolicyKey, val)
+	}
+	if policy.IdleTimeoutSec < 0 {
+		return nil, fmt.Errorf("invalid %v annotation value %q: idleTimeoutSec must not be negative", ConnectionTrackingPolicyKey, val)
+	}
+	return policy, nil
+}
+
+// FailoverPolicy is the failover policy of the backend service of an
+// external network load balancer.
+type FailoverPolicy struct {
+	// DisableConnectionDrainOnFailover drops the connections to the primary
+	// backends on failover.
+	DisableConnectionDrainOnFailover bool `json:"disableConnectionDrainOnFailover,omitempty"`
+	// DropTrafficIfUnhealthy drops the new connections when all the
+	// backends are unhealthy, rather than spreading them to all.
+	DropTrafficIfUnhealthy bool `json:"dropTrafficIfUnhealthy,omitempty"`
+	// FailoverRatio is the ratio of healthy primary backends, between 0 and
+	// 1, under which the load balancer fails over.
+	FailoverRatio float64 `json:"failoverRatio,omitempty"`
+}
+
+// FailoverPolicy returns the failover policy of the external network load
+// balancer of the Service, or nil if it sets none.
+func (svc SvcAnnotations) FailoverPolicy() (*FailoverPolicy, error) {
+	val, ok := svc[FailoverPolicyKey]
+	if !ok {
+		return nil, nil
+	}
+	policy := &FailoverPolicy{}
+	if err := json.Unmarshal([]byte(val), policy); err != nil {
+		return nil, fmt.Errorf("invalid %v annotation value %q: %v", FailoverPolicyKey, val, err)
+	}
+	if policy.FailoverRatio < 0 || policy.FailoverRatio > 1 {
+		return nil, fmt.Errorf("invalid %v annotation value %q: failoverRatio must be between 0 and 1", FailoverPolicyKey, val)
+	}
+	return policy, nil
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backendconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// OAuthClientIDKey is the key of the OAuth client ID in IAP Secrets.
+	OAuthClientIDKey = "client_id"
+	// OAuthClientSecretKey is the key of the OAuth client secret in IAP
+	// Secrets.
+	OAuthClientSecretKey = "client_secret"
+
+	// AffinityTypeNone disables session affinity.
+	AffinityTypeNone = "NONE"
+	// AffinityTypeClientIP routes requests from the same client IP to the
+	// same backend.
+	AffinityTypeClientIP = "CLIENT_IP"
+	// AffinityTypeGeneratedCookie routes requests carrying the same cookie,
+	// generated by the load balancer, to the same backend.
+	AffinityTypeGeneratedCookie = "GENERATED_COOKIE"
+
+	// maxAffinityCookieTtlSec is the maximum cookie lifetime allowed by GCE,
+	// one day.
+	maxAffinityCookieTtlSec = 86400
+	// maxNegativeCachingTtl is the maximum TTL of cached error responses
+	// allowed by GCE, 30 minutes.
+	maxNegativeCachingTtl = 1800
+	// maxServeWhileStale is the maximum serve-while-stale duration allowed by
+	// GCE, one year.
+	maxServeWhileStale = 31536000
+	// maxPathPrefixRewrite and maxHostRewrite are the maximum lengths of the
+	// rewrites allowed by GCE.
+	maxPathPrefixRewrite = 1024
+	maxHostRewrite       = 255
+)
+
+// gceNameRegexp matches valid GCE resource names.
+var gceNameRegexp = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// negativeCachingCodes are the status codes whose responses GCE can cache.
+var negativeCachingCodes = map[int64]bool{
+	300: true, 301: true, 302: true, 307: true, 308: true,
+	404: true, 405: true, 410: true, 421: true, 451: true, 501: true,
+}
+
+// BackendConfigGetter is the interface for retrieving BackendConfigs.
+type BackendConfigGetter interface {
+	// Get returns the BackendConfig with the given namespace and name, with
+	// the Secrets it references resolved.
+	Get(namespace, name string) (*BackendConfig, error)
+}
+
+// APIServerBackendConfigGetter retrieves BackendConfigs from the Kubernetes
+// apiserver.
+type APIServerBackendConfigGetter struct {
+	Client kubernetes.Interface
+}
+
+// Ensure that APIServerBackendConfigGetter implements BackendConfigGetter.
+var _ BackendConfigGetter = &APIServerBackendConfigGetter{}
+
+// Get retrieves the BackendConfig through the generic REST client, since
+// there is no generated client for the resource.
+// TODO: Replace this with an informer once the resource has a generated
+// client.
+func (g *APIServerBackendConfigGetter) Get(namespace, name string) (*BackendConfig, error) {
+	restClient := g.Client.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, &RetrievalError{Namespace: namespace, Name: name, Err: fmt.Errorf("no REST client")}
+	}
+	logging.V(3).Infof("Retrieving BackendConfig %v/%v", namespace, name)
+	data, err := restClient.Get().AbsPath("/apis", GroupName, Version, "namespaces", namespace, Resource, name).DoRaw()
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("BackendConfig %v/%v not found", namespace, name)
+	} else if err != nil {
+		return nil, &RetrievalError{Namespace: namespace, Name: name, Err: err}
+	}
+	config := &BackendConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode BackendConfig %v/%v: %v", namespace, name, err)
+	}
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	if err := g.loadOAuthClientCredentials(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadOAuthClientCredentials reads the OAuth client used by IAP from the
+// Secret referenced by the given BackendConfig.
+// TODO: Watch the Secret, changes are picked up on the next resync.
+func (g *APIServerBackendConfigGetter) loadOAuthClientCredentials(config *BackendConfig) error {
+	if config.Spec.Iap == nil || config.Spec.Iap.OAuthClientCredentials == nil {
+		return nil
+	}
+	creds := config.Spec.Iap.OAuthClientCredentials
+	secret, err := g.Client.Core().Secrets(config.Namespace).Get(creds.SecretName, meta_v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get IAP Secret %v/%v of BackendConfig %v: %v", config.Namespace, creds.SecretName, config.Name, err)
+	}
+	clientID, ok := secret.Data[OAuthClientIDKey]
+	if !ok {
+		return fmt.Errorf("IAP Secret %v/%v has no %q", config.Namespace, creds.SecretName, OAuthClientIDKey)
+	}
+	clientSecret, ok := secret.Data[OAuthClientSecretKey]
+	if !ok {
+		return fmt.Errorf("IAP Secret %v/%v has no %q", config.Namespace, creds.SecretName, OAuthClientSecretKey)
+	}
+	creds.ClientID = string(clientID)
+	creds.ClientSecret = string(clientSecret)
+	return nil
+}
+
+// Validate returns an error if the given BackendConfig is invalid.
+func Validate(config *BackendConfig) error {
+	if name := config.Spec.BackendServiceName; name != "" && !gceNameRegexp.MatchString(name) {
+		return fmt.Errorf("BackendConfig %v/%v: backendServiceName %q is not a valid GCE resource name", config.Namespace, config.Name, name)
+	}
+	if cdn := config.Spec.Cdn; cdn != nil && cdn.CachePolicy != nil {
+		policy := cdn.CachePolicy
+		if len(policy.QueryStringBlacklist) > 0 && len(policy.QueryStringWhitelist) > 0 {
+			return fmt.Errorf("BackendConfig %v/%v: queryStringBlacklist and queryStringWhitelist are mutually exclusive", config.Namespace, config.Name)
+		}
+		if !policy.IncludeQueryString && (len(policy.QueryStringBlacklist) > 0 || len(policy.QueryStringWhitelist) > 0) {
+			return fmt.Errorf("BackendConfig %v/%v: query string lists require includeQueryString", config.Namespace, config.Name)
+		}
+	}
+	if cdn := config.Spec.Cdn; cdn != nil {
+		if err := validateCDNCaching(cdn); err != nil {
+			return fmt.Errorf("BackendConfig %v/%v: %v", config.Namespace, config.Name, err)
+		}
+	}
+	if iap := config.Spec.Iap; iap != nil {
+		if iap.Enabled && (iap.OAuthClientCredentials == nil || iap.OAuthClientCredentials.SecretName == "") {
+			return fmt.Errorf("BackendConfig %v/%v: iap requires oauthclientCredentials.secretName", config.Namespace, config.Name)
+		}
+		// GCE rejects backend services with both IAP and CDN enabled.
+		if iap.Enabled && config.Spec.Cdn != nil && config.Spec.Cdn.Enabled {
+			return fmt.Errorf("BackendConfig %v/%v: iap and cdn can't both be enabled", config.Namespace, config.Name)
+		}
+	}
+	if affinity := config.Spec.SessionAffinity; affinity != nil {
+		switch affinity.AffinityType {
+		case "", AffinityTypeNone, AffinityTypeClientIP, AffinityTypeGeneratedCookie:
+		default:
+			return fmt.Errorf("BackendConfig %v/%v: invalid sessionAffinity.affinityType %q", config.Namespace, config.Name, affinity.AffinityType)
+		}
+		if ttl := affinity.AffinityCookieTtlSec; ttl != nil && (*ttl < 0 || *ttl > maxAffinityCookieTtlSec) {
+			return fmt.Errorf("BackendConfig %v/%v: sessionAffinity.affinityCookieTtlSec must be between 0 and %v", config.Namespace, config.Name, maxAffinityCookieTtlSec)
+		}
+	}
+	if headers := config.Spec.CustomRequestHeaders; headers != nil {
+		for _, h := range headers.Headers {
+			if strings.Index(h, ":") <= 0 {
+				return fmt.Errorf("BackendConfig %v/%v: custom request header %q is not formatted as name:value", config.Namespace, config.Name, h)
+			}
+		}
+	}
+	if headers := config.Spec.CustomResponseHeaders; headers != nil {
+		for _, h := range headers.Headers {
+			if strings.Index(h, ":") <= 0 {
+				return fmt.Errorf("BackendConfig %v/%v: custom response header %q is not formatted as name:value", config.Namespace, config.Name, h)
+			}
+		}
+	}
+	if log := config.Spec.LogConfig; log != nil && log.SampleRate != nil && (*log.SampleRate < 0 || *log.SampleRate > 1) {
+		return fmt.Errorf("BackendConfig %v/%v: logConfig.sampleRate must be between 0 and 1", config.Namespace, config.Name)
+	}
+	if hc := config.Spec.HealthCheck; hc != nil {
+		if err := validateHealthCheck(hc); err != nil {
+			return fmt.Errorf("BackendConfig %v/%v: %v", config.Namespace, config.Name, err)
+		}
+	}
+	if rewrite := config.Spec.UrlRewrite; rewrite != nil {
+		if err := validateURLRewrite(rewrite); err != nil {
+			return fmt.Errorf("BackendConfig %v/%v: %v", config.Namespace, config.Name, err)
+		}
+	}
+	if balancing := config.Spec.Balancing; balancing != nil {
+		if balancing.MaxRatePerEndpoint != nil && balancing.MaxConnectionsPerEndpoint != nil {
+			return fmt.Errorf("BackendConfig %v/%v: balancing.maxRatePerEndpoint and balancing.maxConnectionsPerEndpoint are mutually exclusive", config.Namespace, config.Name)
+		}
+		if rate := balancing.MaxRatePerEndpoint; rate != nil && *rate <= 0 {
+			return fmt.Errorf("BackendConfig %v/%v: balancing.maxRatePerEndpoint must be positive", config.Namespace, config.Name)
+		}
+		if conns := balancing.MaxConnectionsPerEndpoint; conns != nil && *conns <= 0 {
+			return fmt.Errorf("BackendConfig %v/%v: balancing.maxConnectionsPerEndpoint must be positive", config.Namespace, config.Name)
+		}
+		if scaler := balancing.CapacityScaler; scaler != nil && (*scaler < 0 || *scaler > 1) {
+			return fmt.Errorf("BackendConfig %v/%v: balancing.capacityScaler must be between 0 and 1", config.Namespace, config.Name)
+		}
+	}
+	return nil
+}
+
+// validateCDNCaching returns an error if the given negative caching or
+// serve-while-stale settings are rejected by GCE.
+func validateCDNCaching(cdn *CDNConfig) error {
+	if len(cdn.NegativeCachingPolicy) > 0 && (cdn.NegativeCaching == nil || !*cdn.NegativeCaching) {
+		return fmt.Errorf("cdn.negativeCachingPolicy requires negativeCaching")
+	}
+	codes := map[int64]bool{}
+	for _, policy := range cdn.NegativeCachingPolicy {
+		if !negativeCachingCodes[policy.Code] {
+			return fmt.Errorf("cdn.negativeCachingPolicy: status code %v can't be cached", policy.Code)
+		}
+		if codes[policy.Code] {
+			return fmt.Errorf("cdn.negativeCachingPolicy: status code %v is listed twice", policy.Code)
+		}
+		codes[policy.Code] = true
+		if policy.Ttl < 0 || policy.Ttl > maxNegativeCachingTtl {
+			return fmt.Errorf("cdn.negativeCachingPolicy: the TTL of status code %v must be between 0 and %v", policy.Code, maxNegativeCachingTtl)
+		}
+	}
+	if stale := cdn.ServeWhileStale; stale != nil && (*stale < 0 || *stale > maxServeWhileStale) {
+		return fmt.Errorf("cdn.serveWhileStale must be between 0 and %v", maxServeWhileStale)
+	}
+	return nil
+}
+
+// validateHealthCheck returns an error if the given health check settings
+// are rejected by GCE.
+func validateHealthCheck(hc *HealthCheckConfig) error {
+	switch hc.Protocol {
+	case "", "HTTP", "HTTPS", "HTTP2":
+	default:
+		return fmt.Errorf("invalid healthCheck.protocol %q", hc.Protocol)
+	}
+	if hc.RequestPath != "" && !strings.HasPrefix(hc.RequestPath, "/") {
+		return fmt.Errorf("healthCheck.requestPath %q must start with /", hc.RequestPath)
+	}
+	if hc.Port != nil && (*hc.Port < 1 || *hc.Port > 65535) {
+		return fmt.Errorf("healthCheck.port must be between 1 and 65535")
+	}
+	for name, v := range map[string]*int64{
+		"checkIntervalSec":   hc.CheckIntervalSec,
+		"timeoutSec":         hc.TimeoutSec,
+		"healthyThreshold":   hc.HealthyThreshold,
+		"unhealthyThreshold": hc.UnhealthyThreshold,
+	} {
+		if v != nil && *v <= 0 {
+			return fmt.Errorf("healthCheck.%v must be positive", name)
+		}
+	}
+	if hc.CheckIntervalSec != nil && hc.TimeoutSec != nil && *hc.TimeoutSec > *hc.CheckIntervalSec {
+		return fmt.Errorf("healthCheck.timeoutSec can't be greater than healthCheck.checkIntervalSec")
+	}
+	return nil
+}
+
+// validateURLRewrite returns an error if the given rewrites are rejected by
+// GCE.
+func validateURLRewrite(rewrite *URLRewriteConfig) error {
+	if p := rewrite.PathPrefixRewrite; p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("urlRewrite.pathPrefixRewrite %q must start with /", p)
+	}
+	if len(rewrite.PathPrefixRewrite) > maxPathPrefixRewrite {
+		return fmt.Errorf("urlRewrite.pathPrefixRewrite is longer than %v characters", maxPathPrefixRewrite)
+	}
+	if len(rewrite.HostRewrite) > maxHostRewrite {
+		return fmt.Errorf("urlRewrite.hostRewrite is longer than %v characters", maxHostRewrite)
+	}
+	if strings.ContainsAny(rewrite.HostRewrite, "/ ") {
+		return fmt.Errorf("urlRewrite.hostRewrite %q is not a host", rewrite.HostRewrite)
+	}
+	return nil
+}
+
+// RetrievalError is returned when a BackendConfig can't be retrieved, eg: because
+// the apiserver is unavailable. Unlike a BackendConfig which doesn't exist or is
+// invalid, the BackendConfig may be fine.
+type RetrievalError struct {
+	Namespace string
+	Name      string
+	Err       error
+}
+
+func (e *RetrievalError) Error() string {
+	return fmt.Sprintf("failed to get BackendConfig %v/%v: %v", e.Namespace, e.Name, e.Err)
+}
+
+// FakeBackendConfigGetter fakes out BackendConfig retrieval. The Secrets of
+// the fake BackendConfigs are expected to be resolved already.
+type FakeBackendConfigGetter struct {
+	// Configs are keyed by namespace/name.
+	Configs map[string]*BackendConfig
+	// Errs are returned by Get instead of the Configs with the same key.
+	Errs map[string]error
+}
+
+// Ensure that FakeBackendConfigGetter implements BackendConfigGetter.
+var _ BackendConfigGetter = &FakeBackendConfigGetter{}
+
+// Get returns the fake BackendConfig with the given namespace and name.
+func (f *FakeBackendConfigGetter) Get(namespace, name string) (*BackendConfig, error) {
+	if err := f.Errs[namespace+"/"+name]; err != nil {
+		return nil, err
+	}
+	config, ok := f.Configs[namespace+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("BackendConfig %v/%v not found", namespace, name)
+	}
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
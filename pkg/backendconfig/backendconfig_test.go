@@ -0,0 +1,228 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backendconfig
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	ttl := int64(60)
+	longTTL := int64(maxAffinityCookieTtlSec + 1)
+	rate, conns := 100.0, int64(10)
+	scaler, badScaler := 0.5, 1.5
+	interval, timeout := int64(5), int64(10)
+	enabled, disabled := true, false
+	stale, longStale := int64(3600), int64(maxServeWhileStale+1)
+	testCases := []struct {
+		desc    string
+		spec    BackendConfigSpec
+		wantErr bool
+	}{
+		{
+			desc: "empty spec",
+		},
+		{
+			desc: "cdn without cache policy",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{Enabled: true}},
+		},
+		{
+			desc: "query string whitelist",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{Enabled: true, CachePolicy: &CacheKeyPolicy{
+				IncludeQueryString:   true,
+				QueryStringWhitelist: []string{"q"},
+			}}},
+		},
+		{
+			desc: "query string whitelist and blacklist",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{Enabled: true, CachePolicy: &CacheKeyPolicy{
+				IncludeQueryString:   true,
+				QueryStringWhitelist: []string{"q"},
+				QueryStringBlacklist: []string{"r"},
+			}}},
+			wantErr: true,
+		},
+		{
+			desc: "query string blacklist without query string",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{Enabled: true, CachePolicy: &CacheKeyPolicy{
+				QueryStringBlacklist: []string{"r"},
+			}}},
+			wantErr: true,
+		},
+		{
+			desc: "negative caching and serve while stale",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{
+				Enabled:               true,
+				NegativeCaching:       &enabled,
+				NegativeCachingPolicy: []NegativeCachingPolicy{{Code: 404, Ttl: 60}, {Code: 410, Ttl: 1800}},
+				ServeWhileStale:       &stale,
+			}},
+		},
+		{
+			desc: "negative caching policy without negative caching",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{
+				Enabled:               true,
+				NegativeCaching:       &disabled,
+				NegativeCachingPolicy: []NegativeCachingPolicy{{Code: 404, Ttl: 60}},
+			}},
+			wantErr: true,
+		},
+		{
+			desc: "negative caching of an uncacheable code",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{
+				Enabled:               true,
+				NegativeCaching:       &enabled,
+				NegativeCachingPolicy: []NegativeCachingPolicy{{Code: 500, Ttl: 60}},
+			}},
+			wantErr: true,
+		},
+		{
+			desc: "negative caching ttl too long",
+			spec: BackendConfigSpec{Cdn: &CDNConfig{
+				Enabled:               true,
+				NegativeCaching:       &enabled,
+				NegativeCachingPolicy: []NegativeCachingPolicy{{Code: 404, Ttl: maxNegativeCachingTtl + 1}},
+			}},
+			wantErr: true,
+		},
+		{
+			desc:    "serve while stale too long",
+			spec:    BackendConfigSpec{Cdn: &CDNConfig{Enabled: true, ServeWhileStale: &longStale}},
+			wantErr: true,
+		},
+		{
+			desc: "iap",
+			spec: BackendConfigSpec{Iap: &IAPConfig{Enabled: true, OAuthClientCredentials: &OAuthClientCredentials{SecretName: "iap"}}},
+		},
+		{
+			desc:    "iap without secret",
+			spec:    BackendConfigSpec{Iap: &IAPConfig{Enabled: true}},
+			wantErr: true,
+		},
+		{
+			desc: "iap and cdn",
+			spec: BackendConfigSpec{
+				Iap: &IAPConfig{Enabled: true, OAuthClientCredentials: &OAuthClientCredentials{SecretName: "iap"}},
+				Cdn: &CDNConfig{Enabled: true},
+			},
+			wantErr: true,
+		},
+		{
+			desc: "generated cookie affinity",
+			spec: BackendConfigSpec{SessionAffinity: &SessionAffinityConfig{AffinityType: AffinityTypeGeneratedCookie, AffinityCookieTtlSec: &ttl}},
+		},
+		{
+			desc:    "invalid affinity type",
+			spec:    BackendConfigSpec{SessionAffinity: &SessionAffinityConfig{AffinityType: "HEADER"}},
+			wantErr: true,
+		},
+		{
+			desc:    "affinity cookie ttl too long",
+			spec:    BackendConfigSpec{SessionAffinity: &SessionAffinityConfig{AffinityCookieTtlSec: &longTTL}},
+			wantErr: true,
+		},
+		{
+			desc: "backend service name",
+			spec: BackendConfigSpec{BackendServiceName: "my-backend-1"},
+		},
+		{
+			desc:    "invalid backend service name",
+			spec:    BackendConfigSpec{BackendServiceName: "My_Backend"},
+			wantErr: true,
+		},
+		{
+			desc: "custom request headers",
+			spec: BackendConfigSpec{CustomRequestHeaders: &CustomRequestHeadersConfig{Headers: []string{"X-Client-Geo:{client_region}"}}},
+		},
+		{
+			desc:    "custom request header without value",
+			spec:    BackendConfigSpec{CustomRequestHeaders: &CustomRequestHeadersConfig{Headers: []string{"X-Client-Geo"}}},
+			wantErr: true,
+		},
+		{
+			desc: "custom response headers",
+			spec: BackendConfigSpec{CustomResponseHeaders: &CustomResponseHeadersConfig{Headers: []string{"Strict-Transport-Security:max-age=31536000"}}},
+		},
+		{
+			desc:    "custom response header without name",
+			spec:    BackendConfigSpec{CustomResponseHeaders: &CustomResponseHeadersConfig{Headers: []string{":max-age=31536000"}}},
+			wantErr: true,
+		},
+		{
+			desc: "sampled request logging",
+			spec: BackendConfigSpec{LogConfig: &LogConfig{Enable: true, SampleRate: &scaler}},
+		},
+		{
+			desc:    "log sample rate greater than 1",
+			spec:    BackendConfigSpec{LogConfig: &LogConfig{Enable: true, SampleRate: &badScaler}},
+			wantErr: true,
+		},
+		{
+			desc: "health check",
+			spec: BackendConfigSpec{HealthCheck: &HealthCheckConfig{RequestPath: "/healthz", Protocol: "HTTP2", TimeoutSec: &interval, CheckIntervalSec: &timeout}},
+		},
+		{
+			desc:    "invalid health check protocol",
+			spec:    BackendConfigSpec{HealthCheck: &HealthCheckConfig{Protocol: "TCP"}},
+			wantErr: true,
+		},
+		{
+			desc:    "health check path without slash",
+			spec:    BackendConfigSpec{HealthCheck: &HealthCheckConfig{RequestPath: "healthz"}},
+			wantErr: true,
+		},
+		{
+			desc:    "health check timeout greater than interval",
+			spec:    BackendConfigSpec{HealthCheck: &HealthCheckConfig{CheckIntervalSec: &interval, TimeoutSec: &timeout}},
+			wantErr: true,
+		},
+		{
+			desc: "url rewrite",
+			spec: BackendConfigSpec{UrlRewrite: &URLRewriteConfig{PathPrefixRewrite: "/", HostRewrite: "backend.example.com"}},
+		},
+		{
+			desc:    "url rewrite path without slash",
+			spec:    BackendConfigSpec{UrlRewrite: &URLRewriteConfig{PathPrefixRewrite: "api"}},
+			wantErr: true,
+		},
+		{
+			desc:    "url rewrite host with a path",
+			spec:    BackendConfigSpec{UrlRewrite: &URLRewriteConfig{HostRewrite: "example.com/api"}},
+			wantErr: true,
+		},
+		{
+			desc: "balancing",
+			spec: BackendConfigSpec{Balancing: &BalancingConfig{MaxRatePerEndpoint: &rate, CapacityScaler: &scaler}},
+		},
+		{
+			desc:    "max rate and connections per endpoint",
+			spec:    BackendConfigSpec{Balancing: &BalancingConfig{MaxRatePerEndpoint: &rate, MaxConnectionsPerEndpoint: &conns}},
+			wantErr: true,
+		},
+		{
+			desc:    "capacity scaler too large",
+			spec:    BackendConfigSpec{Balancing: &BalancingConfig{CapacityScaler: &badScaler}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		err := Validate(&BackendConfig{Spec: tc.spec})
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+	}
+}
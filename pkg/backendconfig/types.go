@@ -0,0 +1,220 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backendconfig
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GroupName is the API group of the BackendConfig resource.
+	GroupName = "cloud.google.com"
+	// Version is the API version of the BackendConfig resource.
+	Version = "v1beta1"
+	// Resource is the plural resource name of BackendConfig.
+	Resource = "backendconfigs"
+)
+
+// BackendConfig is the configuration of the GCE backend services of a
+// Service, referenced through the backend config annotation on the Service.
+type BackendConfig struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BackendConfigSpec `json:"spec,omitempty"`
+}
+
+// BackendConfigSpec is the spec of a BackendConfig. Features which are not
+// set are left untouched on the backend services.
+type BackendConfigSpec struct {
+	// BackendServiceName overrides the generated name of the backend service,
+	// eg: to reference it from Cloud Armor or monitoring. The controller only
+	// manages an existing backend service with this name if it was created
+	// by the controller for the same Service port.
+	BackendServiceName string `json:"backendServiceName,omitempty"`
+
+	Cdn *CDNConfig `json:"cdn,omitempty"`
+	Iap *IAPConfig `json:"iap,omitempty"`
+	// SecurityPolicy is the Cloud Armor security policy of the backend
+	// service.
+	SecurityPolicy *SecurityPolicyConfig `json:"securityPolicy,omitempty"`
+	// SessionAffinity is the session affinity of the backend service.
+	SessionAffinity *SessionAffinityConfig `json:"sessionAffinity,omitempty"`
+	// CustomRequestHeaders are the headers the load balancer adds to the
+	// requests it proxies to the backend service.
+	CustomRequestHeaders *CustomRequestHeadersConfig `json:"customRequestHeaders,omitempty"`
+	// CustomResponseHeaders are the headers the load balancer adds to the
+	// responses of the backend service, eg: HSTS.
+	CustomResponseHeaders *CustomResponseHeadersConfig `json:"customResponseHeaders,omitempty"`
+	// HealthCheck customizes the health check of the backend service.
+	HealthCheck *HealthCheckConfig `json:"healthCheck,omitempty"`
+	// Balancing tunes the load distribution over the endpoints of NEG
+	// backends.
+	Balancing *BalancingConfig `json:"balancing,omitempty"`
+	// LogConfig is the request logging of the backend service.
+	LogConfig *LogConfig `json:"logConfig,omitempty"`
+	// UrlRewrite rewrites the requests of the Ingress paths served by the
+	// backend service before they are forwarded to it.
+	UrlRewrite *URLRewriteConfig `json:"urlRewrite,omitempty"`
+}
+
+// CDNConfig contains the Cloud CDN configuration of a backend service.
+type CDNConfig struct {
+	Enabled     bool            `json:"enabled"`
+	CachePolicy *CacheKeyPolicy `json:"cachePolicy,omitempty"`
+	// NegativeCaching caches the error responses, eg: 404, for the TTLs of
+	// NegativeCachingPolicy, or the GCE defaults if it is empty. Left
+	// untouched if nil.
+	NegativeCaching       *bool                   `json:"negativeCaching,omitempty"`
+	NegativeCachingPolicy []NegativeCachingPolicy `json:"negativeCachingPolicy,omitempty"`
+	// ServeWhileStale is how long, in seconds, a stale response is served
+	// after it expired while it is revalidated. 0 disables it. Left
+	// untouched if nil.
+	ServeWhileStale *int64 `json:"serveWhileStale,omitempty"`
+}
+
+// NegativeCachingPolicy is the TTL of the cached error responses with a given
+// status code.
+type NegativeCachingPolicy struct {
+	// Code is one of 300, 301, 302, 307, 308, 404, 405, 410, 421, 451 or
+	// 501.
+	Code int64 `json:"code"`
+	// Ttl is the TTL in seconds, at most 1800.
+	Ttl int64 `json:"ttl"`
+}
+
+// IAPConfig contains the Identity-Aware Proxy configuration of a backend
+// service.
+type IAPConfig struct {
+	Enabled                bool                    `json:"enabled"`
+	OAuthClientCredentials *OAuthClientCredentials `json:"oauthclientCredentials,omitempty"`
+}
+
+// OAuthClientCredentials references the Secret holding the OAuth client used
+// by IAP.
+type OAuthClientCredentials struct {
+	// SecretName is the name of a Secret, in the namespace of the
+	// BackendConfig, with the client_id and client_secret keys.
+	SecretName string `json:"secretName"`
+	// ClientID and ClientSecret are read from the Secret by the controller.
+	ClientID     string `json:"-"`
+	ClientSecret string `json:"-"`
+}
+
+// SecurityPolicyConfig references a Cloud Armor security policy.
+type SecurityPolicyConfig struct {
+	// Name is the name of an existing security policy in the project of the
+	// cluster. An empty name detaches the current policy.
+	Name string `json:"name"`
+}
+
+// SessionAffinityConfig contains the session affinity configuration of a
+// backend service.
+type SessionAffinityConfig struct {
+	// AffinityType is one of NONE, CLIENT_IP or GENERATED_COOKIE.
+	AffinityType string `json:"affinityType,omitempty"`
+	// AffinityCookieTtlSec is the lifetime of the cookie of GENERATED_COOKIE
+	// affinity. The GCE default is used if nil.
+	AffinityCookieTtlSec *int64 `json:"affinityCookieTtlSec,omitempty"`
+}
+
+// CustomRequestHeadersConfig contains the custom request headers of a backend
+// service.
+type CustomRequestHeadersConfig struct {
+	// Headers are formatted as "name:value". Values may contain variables,
+	// eg: "X-Client-Geo:{client_region}". An empty list removes all custom
+	// headers.
+	Headers []string `json:"headers"`
+}
+
+// CustomResponseHeadersConfig contains the custom response headers of a
+// backend service.
+type CustomResponseHeadersConfig struct {
+	// Headers are formatted as "name:value", eg:
+	// "Strict-Transport-Security:max-age=31536000". An empty list removes
+	// all custom headers.
+	Headers []string `json:"headers"`
+}
+
+// LogConfig contains the request logging configuration of a backend service.
+type LogConfig struct {
+	// Enable logs the requests to the backend service in Cloud Logging.
+	Enable bool `json:"enable"`
+	// SampleRate is the fraction of the requests logged, between 0 and 1.
+	// All requests are logged if nil.
+	SampleRate *float64 `json:"sampleRate,omitempty"`
+}
+
+// URLRewriteConfig contains the rewrites of the requests forwarded to a
+// backend service. Empty rewrites leave the request untouched.
+type URLRewriteConfig struct {
+	// PathPrefixRewrite replaces the part of the path matched by the Ingress
+	// path, eg: "/" strips "/api/" from the requests of "/api/*".
+	PathPrefixRewrite string `json:"pathPrefixRewrite,omitempty"`
+	// HostRewrite replaces the Host header.
+	HostRewrite string `json:"hostRewrite,omitempty"`
+}
+
+// HealthCheckConfig contains the health check settings of a backend service.
+// Settings which are not set are inferred from the readiness probe of the
+// Pods, or use the defaults of the controller.
+type HealthCheckConfig struct {
+	// RequestPath is the HTTP path of the health check, eg: "/healthz".
+	RequestPath string `json:"requestPath,omitempty"`
+	// Port is the port of the health check: the node port for instance
+	// group backends, the container port for NEG backends.
+	Port *int64 `json:"port,omitempty"`
+	// Protocol is one of HTTP, HTTPS or HTTP2.
+	Protocol           string `json:"protocol,omitempty"`
+	CheckIntervalSec   *int64 `json:"checkIntervalSec,omitempty"`
+	TimeoutSec         *int64 `json:"timeoutSec,omitempty"`
+	HealthyThreshold   *int64 `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold *int64 `json:"unhealthyThreshold,omitempty"`
+}
+
+// BalancingConfig contains the capacity settings of the NEG backends of a
+// backend service. Unset settings use the defaults of the controller.
+type BalancingConfig struct {
+	// MaxRatePerEndpoint is the target rate of requests per second of each
+	// endpoint, with the RATE balancing mode.
+	MaxRatePerEndpoint *float64 `json:"maxRatePerEndpoint,omitempty"`
+	// MaxConnectionsPerEndpoint is the target number of concurrent
+	// connections of each endpoint. Switches the NEGs to the CONNECTION
+	// balancing mode, exclusive with MaxRatePerEndpoint.
+	MaxConnectionsPerEndpoint *int64 `json:"maxConnectionsPerEndpoint,omitempty"`
+	// CapacityScaler scales the capacity of the NEGs, between 0 and 1. 0
+	// drains them.
+	CapacityScaler *float64 `json:"capacityScaler,omitempty"`
+}
+
+// CacheKeyPolicy contains the configuration of the Cloud CDN cache keys.
+type CacheKeyPolicy struct {
+	// IncludeHost includes the host in the cache key.
+	IncludeHost bool `json:"includeHost,omitempty"`
+	// IncludeProtocol includes the protocol in the cache key.
+	IncludeProtocol bool `json:"includeProtocol,omitempty"`
+	// IncludeQueryString includes the query string in the cache key. If
+	// true, at most one of QueryStringBlacklist and QueryStringWhitelist may
+	// be set, otherwise the whole query string is included.
+	IncludeQueryString bool `json:"includeQueryString,omitempty"`
+	// QueryStringBlacklist are the query string parameters excluded from the
+	// cache key.
+	QueryStringBlacklist []string `json:"queryStringBlacklist,omitempty"`
+	// QueryStringWhitelist are the only query string parameters included in
+	// the cache key.
+	QueryStringWhitelist []string `json:"queryStringWhitelist,omitempty"`
+}
@@ -23,8 +23,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/golang/glog"
-
 	computealpha "google.golang.org/api/compute/v0.alpha"
 	compute "google.golang.org/api/compute/v1"
 
@@ -33,8 +31,11 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
 
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
 	"k8s.io/ingress-gce/pkg/healthchecks"
 	"k8s.io/ingress-gce/pkg/instances"
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/ingress-gce/pkg/storage"
 	"k8s.io/ingress-gce/pkg/utils"
 )
@@ -76,17 +77,23 @@ const maxRPS = 1
 
 // Backends implements BackendPool.
 type Backends struct {
-	cloud         BackendServices
-	negGetter     NEGGetter
-	nodePool      instances.NodePool
-	healthChecker healthchecks.HealthChecker
-	snapshotter   storage.Snapshotter
-	prober        probeProvider
+	cloud            BackendServices
+	negGetter        NEGGetter
+	securityPolicies SecurityPolicies
+	extended         ExtendedBackendServices
+	nodePool         instances.NodePool
+	healthChecker    healthchecks.HealthChecker
+	snapshotter      storage.Snapshotter
+	prober           probeProvider
 	// ignoredPorts are a set of ports excluded from GC, even
 	// after the Ingress has been deleted. Note that invoking
 	// a Delete() on these ports will still delete the backend.
 	ignoredPorts sets.String
 	namer        *utils.Namer
+	// disruptiveMigrations allows the backend services GCE can't switch
+	// between balancing modes in place to be switched in two steps, losing
+	// their traffic in between.
+	disruptiveMigrations bool
 }
 
 func portKey(port int64) string {
@@ -103,6 +110,36 @@ type ServicePort struct {
 	SvcPort       intstr.IntOrString
 	SvcTargetPort string
 	NEGEnabled    bool
+	// BackendConfig is the BackendConfig referenced by the Service for this
+	// port, nil if none.
+	BackendConfig *backendconfig.BackendConfig
+	// ServerlessNEG is the serverless NEG referenced by the Service, nil if
+	// none. Serverless backends have no node port and no health check.
+	ServerlessNEG *annotations.ServerlessNEG
+	// HybridNEG is set if the endpoints of the Service are outside of GCP.
+	// Hybrid ports are NEG enabled, with NEGs in the zone of HybridNEG only.
+	HybridNEG *annotations.HybridNEG
+	// InternetNEG is the origin of an ExternalName Service, nil for other
+	// Services. Internet backends have no node port and no health check.
+	InternetNEG *InternetNEG
+}
+
+// InternetNEG is an origin outside of GCP, served through a global internet
+// NEG with the origin as only endpoint.
+type InternetNEG struct {
+	// FQDN is the domain name of the origin.
+	FQDN string
+	// Port is the port of the origin.
+	Port int64
+}
+
+// GRPCProbe is a gRPC readiness probe, which the vendored Pod API predates.
+type GRPCProbe struct {
+	// Service is the gRPC service checked, empty for the overall health of
+	// the server.
+	Service        string
+	PeriodSeconds  int64
+	TimeoutSeconds int64
 }
 
 // Description returns a string describing the ServicePort.
@@ -113,8 +150,65 @@ func (sp ServicePort) Description() string {
 	return fmt.Sprintf(`{"kubernetes.io/service-name":"%s","kubernetes.io/service-port":"%s"}`, sp.SvcName.String(), sp.SvcPort.String())
 }
 
+// BackendName returns the name of the backend service of the ServicePort:
+// the name requested by its BackendConfig, or else the name generated by the
+// namer.
+func (sp ServicePort) BackendName(namer *utils.Namer) string {
+	if sp.hasCustomName() {
+		return sp.BackendConfig.Spec.BackendServiceName
+	}
+	if sp.ServerlessNEG != nil {
+		return namer.ServerlessBackend(sp.ServerlessNEG.Region, sp.ServerlessNEG.Name)
+	}
+	if sp.InternetNEG != nil {
+		return namer.InternetBackend(sp.SvcName.Namespace, sp.SvcName.Name, sp.SvcPort.String())
+	}
+	return namer.Backend(sp.Port)
+}
+
+// HasNodePort returns false if the backend of the ServicePort is not served
+// by the nodes of the cluster, but by a serverless or an internet NEG.
+func (sp ServicePort) HasNodePort() bool {
+	return sp.ServerlessNEG == nil && sp.InternetNEG == nil
+}
+
+func (sp ServicePort) hasCustomName() bool {
+	return sp.BackendConfig != nil && sp.BackendConfig.Spec.BackendServiceName != ""
+}
+
+// description returns the description of the backend service of the given
+// ServicePort. Backend services with a user-specified name, or with a
+// serverless or internet backend, also record the cluster owning them: unlike
+// generated names, their names do not identify the cluster, or not through a
+// node port.
+func (b *Backends) description(sp ServicePort) string {
+	if !sp.hasCustomName() && sp.HasNodePort() {
+		return sp.Description()
+	}
+	return utils.Description{
+		ServiceName: sp.SvcName.String(),
+		ServicePort: sp.SvcPort.String(),
+		ClusterUID:  b.namer.UID(),
+	}.String()
+}
+
+// OwnedByCluster returns true if the given backend service has a
+// user-specified name and was created by the cluster of the namer.
+func OwnedByCluster(be *compute.BackendService, namer *utils.Namer) bool {
+	desc, err := utils.ParseDescription(be.Description)
+	if err != nil {
+		return false
+	}
+	return desc.ClusterUID != "" && desc.ClusterUID == namer.UID()
+}
+
 // NewBackendPool returns a new backend pool.
 // - cloud: implements BackendServices and syncs backends with a cloud provider
+// - negGetter: retrieves the network endpoint groups of NEG backends.
+// - securityPolicies: attaches the Cloud Armor security policies requested
+//   by BackendConfigs.
+// - extended: manages the fields of the backend services, and the internet
+//   NEGs, which the vendored compute API predates.
 // - healthChecker: is capable of producing health checks for backends.
 // - nodePool: implements NodePool, used to create/delete new instance groups.
 // - namer: procudes names for backends.
@@ -123,6 +217,8 @@ func (sp ServicePort) Description() string {
 func NewBackendPool(
 	cloud BackendServices,
 	negGetter NEGGetter,
+	securityPolicies SecurityPolicies,
+	extended ExtendedBackendServices,
 	healthChecker healthchecks.HealthChecker,
 	nodePool instances.NodePool,
 	namer *utils.Namer,
@@ -134,12 +230,14 @@ func NewBackendPool(
 		ignored = append(ignored, portKey(p))
 	}
 	backendPool := &Backends{
-		cloud:         cloud,
-		negGetter:     negGetter,
-		nodePool:      nodePool,
-		healthChecker: healthChecker,
-		namer:         namer,
-		ignoredPorts:  sets.NewString(ignored...),
+		cloud:            cloud,
+		negGetter:        negGetter,
+		securityPolicies: securityPolicies,
+		extended:         extended,
+		nodePool:         nodePool,
+		healthChecker:    healthChecker,
+		namer:            namer,
+		ignoredPorts:     sets.NewString(ignored...),
 	}
 	if !resyncWithCloud {
 		backendPool.snapshotter = storage.NewInMemoryPool()
@@ -148,14 +246,16 @@ func NewBackendPool(
 	backendPool.snapshotter = storage.NewCloudListingPool(
 		func(i interface{}) (string, error) {
 			bs := i.(*compute.BackendService)
+			if OwnedByCluster(bs, namer) {
+				return bs.Name, nil
+			}
 			if !namer.NameBelongsToCluster(bs.Name) {
 				return "", fmt.Errorf("unrecognized name %v", bs.Name)
 			}
-			port, err := namer.BackendPort(bs.Name)
-			if err != nil {
+			if _, err := namer.BackendPort(bs.Name); err != nil {
 				return "", err
 			}
-			return port, nil
+			return bs.Name, nil
 		},
 		backendPool,
 		30*time.Second,
@@ -163,6 +263,11 @@ func NewBackendPool(
 	return backendPool
 }
 
+// SetDisruptiveMigrations implements BackendPool.
+func (b *Backends) SetDisruptiveMigrations(allow bool) {
+	b.disruptiveMigrations = allow
+}
+
 // Init sets the probeProvider interface value
 func (b *Backends) Init(pp probeProvider) {
 	b.prober = pp
@@ -170,11 +275,21 @@ func (b *Backends) Init(pp probeProvider) {
 
 // Get returns a single backend.
 func (b *Backends) Get(port int64) (*compute.BackendService, error) {
-	be, err := b.cloud.GetGlobalBackendService(b.namer.Backend(port))
+	return b.get(b.namer.Backend(port))
+}
+
+// GetServicePort returns the backend of the given ServicePort, which may have
+// a user-specified name.
+func (b *Backends) GetServicePort(sp ServicePort) (*compute.BackendService, error) {
+	return b.get(sp.BackendName(b.namer))
+}
+
+func (b *Backends) get(name string) (*compute.BackendService, error) {
+	be, err := b.cloud.GetGlobalBackendService(name)
 	if err != nil {
 		return nil, err
 	}
-	b.snapshotter.Add(portKey(port), be)
+	b.snapshotter.Add(name, be)
 	return be, nil
 }
 
@@ -186,7 +301,7 @@ func (b *Backends) ensureHealthCheck(sp ServicePort) (string, error) {
 	}
 
 	if existingLegacyHC != nil {
-		glog.V(4).Infof("Applying settings of existing health check to newer health check on port %+v", sp)
+		logging.V(4).Infof("Applying settings of existing health check to newer health check on port %+v", sp)
 		applyLegacyHCToHC(existingLegacyHC, hc)
 	} else if b.prober != nil {
 		probe, err := b.prober.GetProbe(sp)
@@ -194,10 +309,24 @@ func (b *Backends) ensureHealthCheck(sp ServicePort) (string, error) {
 			return "", err
 		}
 		if probe != nil {
-			glog.V(4).Infof("Applying httpGet settings of readinessProbe to health check on port %+v", sp)
+			logging.V(4).Infof("Applying httpGet settings of readinessProbe to health check on port %+v", sp)
 			applyProbeSettingsToHC(probe, hc)
+		} else if sp.Protocol == utils.ProtocolHTTP2 {
+			// HTTP2 backends may be gRPC servers without an HTTP
+			// readiness probe.
+			grpcProbe, err := b.prober.GetGRPCProbe(sp)
+			if err != nil {
+				return "", err
+			}
+			if grpcProbe != nil {
+				logging.V(4).Infof("Applying gRPC settings of readinessProbe to health check on port %+v", sp)
+				applyGRPCProbeSettingsToHC(grpcProbe, hc)
+			}
 		}
 	}
+	if sp.BackendConfig != nil {
+		hc.ApplyBackendConfig(sp.BackendConfig.Spec.HealthCheck)
+	}
 
 	return b.healthChecker.Sync(hc)
 }
@@ -205,7 +334,7 @@ func (b *Backends) ensureHealthCheck(sp ServicePort) (string, error) {
 func (b *Backends) create(namedPort *compute.NamedPort, hcLink string, sp ServicePort, name string) (*compute.BackendService, error) {
 	bs := &compute.BackendService{
 		Name:         name,
-		Description:  sp.Description(),
+		Description:  b.description(sp),
 		Protocol:     string(sp.Protocol),
 		HealthChecks: []string{hcLink},
 		Port:         namedPort.Port,
@@ -214,20 +343,22 @@ func (b *Backends) create(namedPort *compute.NamedPort, hcLink string, sp Servic
 	if err := b.cloud.CreateGlobalBackendService(bs); err != nil {
 		return nil, err
 	}
-	return b.Get(namedPort.Port)
+	return b.get(name)
 }
 
 // Ensure will update or create Backends for the given ports.
 // Uses the given instance groups if non-nil, else creates instance groups.
 func (b *Backends) Ensure(svcPorts []ServicePort, igs []*compute.InstanceGroup) error {
-	glog.V(3).Infof("Sync: backends %v", svcPorts)
+	logging.V(3).Infof("Sync: backends %v", svcPorts)
 	// Ideally callers should pass the instance groups to prevent recomputing them here.
 	// Igs can be nil in scenarios where we do not have instance groups such as
 	// while syncing default backend service.
 	if igs == nil {
 		ports := []int64{}
 		for _, p := range svcPorts {
-			ports = append(ports, p.Port)
+			if p.HasNodePort() {
+				ports = append(ports, p.Port)
+			}
 		}
 		var err error
 		igs, err = instances.EnsureInstanceGroupsAndPorts(b.nodePool, b.namer, ports)
@@ -240,6 +371,18 @@ func (b *Backends) Ensure(svcPorts []ServicePort, igs []*compute.InstanceGroup)
 		if err := b.ensureBackendService(port, igs); err != nil {
 			return err
 		}
+		if err := b.ensureCustomRequestHeaders(port); err != nil {
+			return err
+		}
+		if err := b.ensureLogConfig(port); err != nil {
+			return err
+		}
+		if err := b.ensureCustomResponseHeaders(port); err != nil {
+			return err
+		}
+		if err := b.ensureCDNPolicy(port); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -248,10 +391,21 @@ func (b *Backends) Ensure(svcPorts []ServicePort, igs []*compute.InstanceGroup)
 // It assumes that the instance groups have been created and required named port has been added.
 // If not, then Ensure should be called instead.
 func (b *Backends) ensureBackendService(p ServicePort, igs []*compute.InstanceGroup) error {
+	if p.ServerlessNEG != nil {
+		return b.ensureNEGBackendService(p, serverlessNEGLink(p.ServerlessNEG))
+	}
+	if p.InternetNEG != nil {
+		negLink, err := b.ensureInternetNEG(p)
+		if err != nil {
+			return err
+		}
+		return b.ensureNEGBackendService(p, negLink)
+	}
 	// We must track the ports even if creating the backends failed, because
 	// we might've created health-check for them.
-	be := &compute.BackendService{}
-	defer func() { b.snapshotter.Add(portKey(p.Port), be) }()
+	beName := p.BackendName(b.namer)
+	be := &compute.BackendService{Name: beName, Port: p.Port}
+	defer func() { b.snapshotter.Add(beName, be) }()
 
 	var err error
 
@@ -262,14 +416,17 @@ func (b *Backends) ensureBackendService(p ServicePort, igs []*compute.InstanceGr
 	}
 
 	// Verify existance of a backend service for the proper port, but do not specify any backends/igs
-	beName := b.namer.Backend(p.Port)
-	be, _ = b.Get(p.Port)
+	existing, _ := b.get(beName)
+	if err := b.checkOwner(existing, p); err != nil {
+		return err
+	}
+	be = existing
 	if be == nil {
 		namedPort := &compute.NamedPort{
 			Name: b.namer.NamedPort(p.Port),
 			Port: p.Port,
 		}
-		glog.V(2).Infof("Creating backend service for port %v named port %v", p.Port, namedPort)
+		logging.ForResource(beName).WithOperation("create").V(2).Infof("Creating backend service for port %v named port %v", p.Port, namedPort)
 		be, err = b.create(namedPort, hcLink, p, beName)
 		if err != nil {
 			return err
@@ -289,20 +446,30 @@ func (b *Backends) ensureBackendService(p ServicePort, igs []*compute.InstanceGr
 	// TODO (mixia): compare health check link directly once NEG is GA
 	existingHCName := retrieveObjectName(existingHCLink)
 	expectedHCName := retrieveObjectName(hcLink)
-	if be.Protocol != string(p.Protocol) || existingHCName != expectedHCName || be.Description != p.Description() {
-		glog.V(2).Infof("Updating backend protocol %v (%v) for change in protocol (%v) or health check", beName, be.Protocol, string(p.Protocol))
+	if be.Protocol != string(p.Protocol) || existingHCName != expectedHCName || be.Description != b.description(p) {
+		logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating backend protocol (%v) for change in protocol (%v) or health check", be.Protocol, string(p.Protocol))
 		be.Protocol = string(p.Protocol)
 		be.HealthChecks = []string{hcLink}
-		be.Description = p.Description()
+		be.Description = b.description(p)
 		if err = b.cloud.UpdateGlobalBackendService(be); err != nil {
 			return err
 		}
 	}
 
+	if applyBackendConfig(be, p) {
+		logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating backend service for BackendConfig %v/%v", p.BackendConfig.Namespace, p.BackendConfig.Name)
+		if err = b.cloud.UpdateGlobalBackendService(be); err != nil {
+			return fmt.Errorf("failed to apply BackendConfig %v/%v to backend service %v: %v", p.BackendConfig.Namespace, p.BackendConfig.Name, beName, err)
+		}
+	}
+	if err = b.ensureSecurityPolicy(beName, p); err != nil {
+		return err
+	}
+
 	// If previous health check was legacy type, we need to delete it.
 	if existingHCLink != hcLink && strings.Contains(existingHCLink, "/httpHealthChecks/") {
 		if err = b.healthChecker.DeleteLegacy(p.Port); err != nil {
-			glog.Warning("Failed to delete legacy HttpHealthCheck %v; Will not try again, err: %v", beName, err)
+			logging.Warningf("Failed to delete legacy HttpHealthCheck %v; Will not try again, err: %v", beName, err)
 		}
 	}
 
@@ -319,23 +486,347 @@ func (b *Backends) ensureBackendService(p ServicePort, igs []*compute.InstanceGr
 	return b.edgeHop(be, igs)
 }
 
+// checkOwner returns an error if the given existing backend service has a
+// user-specified name and was not created for the same Service port by this
+// cluster, since user-specified names may collide.
+func (b *Backends) checkOwner(existing *compute.BackendService, p ServicePort) error {
+	if existing == nil || !p.hasCustomName() || existing.Description == b.description(p) {
+		return nil
+	}
+	return fmt.Errorf("backend service %v requested by BackendConfig %v/%v already exists and is not owned by Service %v port %v", existing.Name, p.BackendConfig.Namespace, p.BackendConfig.Name, p.SvcName, p.SvcPort.String())
+}
+
+// ensureNEGBackendService will update or create the backend service of the
+// given serverless or internet port, with the NEG of the given link as only
+// backend. GCE rejects health checks, ports and balancing modes on these
+// backends.
+func (b *Backends) ensureNEGBackendService(p ServicePort, negLink string) error {
+	beName := p.BackendName(b.namer)
+	be := &compute.BackendService{Name: beName}
+	defer func() { b.snapshotter.Add(beName, be) }()
+
+	existing, _ := b.get(beName)
+	if err := b.checkOwner(existing, p); err != nil {
+		return err
+	}
+	if existing == nil {
+		logging.ForResource(beName).WithOperation("create").V(2).Infof("Creating backend service for NEG %v", negLink)
+		bs := &compute.BackendService{
+			Name:        beName,
+			Description: b.description(p),
+			Protocol:    string(p.Protocol),
+			Backends:    []*compute.Backend{{Group: negLink}},
+		}
+		if err := b.cloud.CreateGlobalBackendService(bs); err != nil {
+			return fmt.Errorf("failed to create backend service %v for NEG %v: %v", beName, negLink, err)
+		}
+		var err error
+		if existing, err = b.get(beName); err != nil {
+			return err
+		}
+	}
+	be = existing
+
+	// GCE returns the NEG as a URL, while the controller references it
+	// relatively to the project.
+	if be.Protocol != string(p.Protocol) || be.Description != b.description(p) || len(be.Backends) != 1 || !strings.HasSuffix(be.Backends[0].Group, negLink) {
+		logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating backend service for NEG %v", negLink)
+		be.Protocol = string(p.Protocol)
+		be.Description = b.description(p)
+		be.Backends = []*compute.Backend{{Group: negLink}}
+		if err := b.cloud.UpdateGlobalBackendService(be); err != nil {
+			return err
+		}
+	}
+
+	if applyBackendConfig(be, p) {
+		logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating backend service for BackendConfig %v/%v", p.BackendConfig.Namespace, p.BackendConfig.Name)
+		if err := b.cloud.UpdateGlobalBackendService(be); err != nil {
+			return fmt.Errorf("failed to apply BackendConfig %v/%v to backend service %v: %v", p.BackendConfig.Namespace, p.BackendConfig.Name, beName, err)
+		}
+	}
+	return b.ensureSecurityPolicy(beName, p)
+}
+
+// serverlessNEGLink returns the link of the given serverless NEG, relative to
+// the project of the cluster.
+func serverlessNEGLink(neg *annotations.ServerlessNEG) string {
+	return fmt.Sprintf("regions/%v/networkEndpointGroups/%v", neg.Region, neg.Name)
+}
+
+// ensureInternetNEG creates the internet NEG of the given port if it doesn't
+// exist, and replaces its endpoint with the origin of the port if it changed.
+// Returns the link of the NEG, relative to the project of the cluster.
+func (b *Backends) ensureInternetNEG(p ServicePort) (string, error) {
+	// The NEG keeps the generated name if the backend service has a custom
+	// name.
+	name := b.namer.InternetBackend(p.SvcName.Namespace, p.SvcName.Name, p.SvcPort.String())
+	negLink := fmt.Sprintf("global/networkEndpointGroups/%v", name)
+	if _, err := b.extended.GetGlobalNetworkEndpointGroup(name); utils.IsNotFoundError(err) {
+		logging.ForResource(name).WithOperation("create").V(2).Infof("Creating internet NEG for %v:%v", p.InternetNEG.FQDN, p.InternetNEG.Port)
+		neg := &NetworkEndpointGroup{
+			Name:                name,
+			Description:         b.description(p),
+			NetworkEndpointType: "INTERNET_FQDN_PORT",
+			DefaultPort:         p.InternetNEG.Port,
+		}
+		if err := b.extended.CreateGlobalNetworkEndpointGroup(neg); err != nil {
+			return "", fmt.Errorf("failed to create internet NEG %v: %v", name, err)
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	endpoints, err := b.extended.ListGlobalNetworkEndpoints(name)
+	if err != nil {
+		return "", err
+	}
+	want := &NetworkEndpoint{Fqdn: p.InternetNEG.FQDN, Port: p.InternetNEG.Port}
+	stale := []*NetworkEndpoint{}
+	found := false
+	for _, ep := range endpoints {
+		if *ep == *want {
+			found = true
+		} else {
+			stale = append(stale, ep)
+		}
+	}
+	if len(stale) > 0 {
+		logging.ForResource(name).WithOperation("update").V(2).Infof("Detaching %v stale endpoints from internet NEG", len(stale))
+		if err := b.extended.DetachGlobalNetworkEndpoints(name, stale); err != nil {
+			return "", fmt.Errorf("failed to detach endpoints of internet NEG %v: %v", name, err)
+		}
+	}
+	if !found {
+		logging.ForResource(name).WithOperation("update").V(2).Infof("Attaching %v:%v to internet NEG", want.Fqdn, want.Port)
+		if err := b.extended.AttachGlobalNetworkEndpoints(name, []*NetworkEndpoint{want}); err != nil {
+			return "", fmt.Errorf("failed to attach endpoint to internet NEG %v: %v", name, err)
+		}
+	}
+	return negLink, nil
+}
+
+// ensureSecurityPolicy attaches the Cloud Armor security policy requested by
+// the BackendConfig of the given port to the backend service. An empty policy
+// name detaches the current policy, the policy is left untouched if the
+// BackendConfig does not configure one.
+func (b *Backends) ensureSecurityPolicy(beName string, p ServicePort) error {
+	if p.BackendConfig == nil || p.BackendConfig.Spec.SecurityPolicy == nil {
+		return nil
+	}
+	existingLink, err := b.securityPolicies.GetBackendServiceSecurityPolicy(beName)
+	if err != nil {
+		return err
+	}
+	name := p.BackendConfig.Spec.SecurityPolicy.Name
+	if (existingLink == "" && name == "") || (existingLink != "" && retrieveObjectName(existingLink) == name) {
+		return nil
+	}
+
+	link := ""
+	if name != "" {
+		policy, err := b.securityPolicies.GetSecurityPolicy(name)
+		if utils.IsNotFoundError(err) {
+			return fmt.Errorf("security policy %v referenced by BackendConfig %v/%v does not exist", name, p.BackendConfig.Namespace, p.BackendConfig.Name)
+		} else if err != nil {
+			return err
+		}
+		link = policy.SelfLink
+	}
+	logging.ForResource(beName).WithOperation("update").V(2).Infof("Setting security policy of backend service to %q (was %q)", name, retrieveObjectName(existingLink))
+	if err := b.securityPolicies.SetBackendServiceSecurityPolicy(beName, link); err != nil {
+		return fmt.Errorf("failed to set security policy %q of backend service %v: %v", name, beName, err)
+	}
+	return nil
+}
+
+// ensureCustomRequestHeaders sets the custom request headers requested by the
+// BackendConfig of the given port on the backend service. The headers are
+// only supported by the alpha API, and are dropped by updates through the v1
+// API, so they are ensured after all other updates.
+func (b *Backends) ensureCustomRequestHeaders(p ServicePort) error {
+	if p.BackendConfig == nil || p.BackendConfig.Spec.CustomRequestHeaders == nil {
+		return nil
+	}
+	beName := p.BackendName(b.namer)
+	be, err := b.cloud.GetAlphaGlobalBackendService(beName)
+	if err != nil {
+		return err
+	}
+	headers := p.BackendConfig.Spec.CustomRequestHeaders.Headers
+	if len(be.CustomRequestHeaders) == len(headers) && sets.NewString(be.CustomRequestHeaders...).Equal(sets.NewString(headers...)) {
+		return nil
+	}
+	logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating custom request headers of backend service from %v to %v", be.CustomRequestHeaders, headers)
+	be.CustomRequestHeaders = headers
+	be.ForceSendFields = append(be.ForceSendFields, "CustomRequestHeaders")
+	if err := b.cloud.UpdateAlphaGlobalBackendService(be); err != nil {
+		return fmt.Errorf("failed to set custom request headers of backend service %v: %v", beName, err)
+	}
+	return nil
+}
+
+// ensureLogConfig sets the request logging requested by the BackendConfig of
+// the given port on the backend service. The log config is reset by the
+// updates through the vendored API, which doesn't know it, so it's ensured
+// after them.
+func (b *Backends) ensureLogConfig(p ServicePort) error {
+	if p.BackendConfig == nil || p.BackendConfig.Spec.LogConfig == nil {
+		return nil
+	}
+	beName := p.BackendName(b.namer)
+	be, err := b.extended.GetExtendedBackendService(beName)
+	if err != nil {
+		return err
+	}
+	want := &BackendServiceLogConfig{Enable: p.BackendConfig.Spec.LogConfig.Enable}
+	if want.Enable {
+		want.SampleRate = p.BackendConfig.Spec.LogConfig.SampleRate
+	}
+	if logConfigEqual(be.LogConfig, want) {
+		return nil
+	}
+	logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating log config of backend service to %+v", *want)
+	patch := map[string]interface{}{"logConfig": want, "fingerprint": be.Fingerprint}
+	if err := b.extended.PatchExtendedBackendService(beName, patch); err != nil {
+		return fmt.Errorf("failed to set log config of backend service %v: %v", beName, err)
+	}
+	return nil
+}
+
+// ensureCustomResponseHeaders sets the custom response headers requested by
+// the BackendConfig of the given port on the backend service. Like the log
+// config, they are reset by the updates through the vendored API, so they are
+// ensured after them.
+func (b *Backends) ensureCustomResponseHeaders(p ServicePort) error {
+	if p.BackendConfig == nil || p.BackendConfig.Spec.CustomResponseHeaders == nil {
+		return nil
+	}
+	beName := p.BackendName(b.namer)
+	be, err := b.extended.GetExtendedBackendService(beName)
+	if err != nil {
+		return err
+	}
+	headers := p.BackendConfig.Spec.CustomResponseHeaders.Headers
+	if len(be.CustomResponseHeaders) == len(headers) && sets.NewString(be.CustomResponseHeaders...).Equal(sets.NewString(headers...)) {
+		return nil
+	}
+	logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating custom response headers of backend service from %v to %v", be.CustomResponseHeaders, headers)
+	// An empty list is sent as such, so that it clears the headers.
+	patch := map[string]interface{}{"customResponseHeaders": append([]string{}, headers...), "fingerprint": be.Fingerprint}
+	if err := b.extended.PatchExtendedBackendService(beName, patch); err != nil {
+		return fmt.Errorf("failed to set custom response headers of backend service %v: %v", beName, err)
+	}
+	return nil
+}
+
+// ensureCDNPolicy sets the negative caching and serve-while-stale requested by
+// the BackendConfig of the given port on the Cloud CDN policy of the backend
+// service. Only the requested fields are patched, so the cache key policy set
+// through the vendored API is kept. A rejection names the BackendConfig, so
+// that the sync error raised on the Ingress points at it.
+func (b *Backends) ensureCDNPolicy(p ServicePort) error {
+	if p.BackendConfig == nil {
+		return nil
+	}
+	cdn := p.BackendConfig.Spec.Cdn
+	if cdn == nil || !cdn.Enabled || (cdn.NegativeCaching == nil && cdn.ServeWhileStale == nil) {
+		return nil
+	}
+	beName := p.BackendName(b.namer)
+	be, err := b.extended.GetExtendedBackendService(beName)
+	if err != nil {
+		return err
+	}
+	current := be.CdnPolicy
+	if current == nil {
+		current = &BackendServiceCdnPolicy{}
+	}
+	policy := map[string]interface{}{}
+	if cdn.NegativeCaching != nil {
+		// An empty list is sent as such, so that it clears the TTLs.
+		want := []*BackendServiceNegativeCachingPolicy{}
+		for _, c := range cdn.NegativeCachingPolicy {
+			want = append(want, &BackendServiceNegativeCachingPolicy{Code: c.Code, Ttl: c.Ttl})
+		}
+		if current.NegativeCaching != *cdn.NegativeCaching || !negativeCachingPolicyEqual(current.NegativeCachingPolicy, want) {
+			policy["negativeCaching"] = *cdn.NegativeCaching
+			policy["negativeCachingPolicy"] = want
+		}
+	}
+	if cdn.ServeWhileStale != nil && current.ServeWhileStale != *cdn.ServeWhileStale {
+		policy["serveWhileStale"] = *cdn.ServeWhileStale
+	}
+	if len(policy) == 0 {
+		return nil
+	}
+	logging.ForResource(beName).WithOperation("update").V(2).Infof("Updating CDN policy of backend service with %v", policy)
+	patch := map[string]interface{}{"cdnPolicy": policy, "fingerprint": be.Fingerprint}
+	if err := b.extended.PatchExtendedBackendService(beName, patch); err != nil {
+		return fmt.Errorf("failed to set the CDN policy of BackendConfig %v/%v on backend service %v: %v", p.BackendConfig.Namespace, p.BackendConfig.Name, beName, err)
+	}
+	return nil
+}
+
+// negativeCachingPolicyEqual returns true if both policies have the same TTLs
+// for the same status codes, in any order.
+func negativeCachingPolicyEqual(a, b []*BackendServiceNegativeCachingPolicy) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ttls := map[int64]int64{}
+	for _, c := range a {
+		ttls[c.Code] = c.Ttl
+	}
+	for _, c := range b {
+		if ttl, ok := ttls[c.Code]; !ok || ttl != c.Ttl {
+			return false
+		}
+	}
+	return true
+}
+
+// logConfigEqual returns true if both log configs log the same requests. A
+// nil config or sample rate is the GCE default, disabled and 1.
+func logConfigEqual(a, b *BackendServiceLogConfig) bool {
+	enabled := func(c *BackendServiceLogConfig) bool { return c != nil && c.Enable }
+	rate := func(c *BackendServiceLogConfig) float64 {
+		if c.SampleRate == nil {
+			return 1
+		}
+		return *c.SampleRate
+	}
+	if !enabled(a) || !enabled(b) {
+		return enabled(a) == enabled(b)
+	}
+	return rate(a) == rate(b)
+}
+
 // Delete deletes the Backend for the given port.
 func (b *Backends) Delete(port int64) (err error) {
-	name := b.namer.Backend(port)
-	glog.V(2).Infof("Deleting backend service %v", name)
+	return b.delete(b.namer.Backend(port), port, true)
+}
+
+// delete deletes the backend service with the given name, and the health
+// check of the given port if deleteHealthCheck is true. The health check is
+// kept if the port is still served by a backend service with another name.
+func (b *Backends) delete(name string, port int64, deleteHealthCheck bool) (err error) {
+	logging.ForResource(name).WithOperation("delete").V(2).Infof("Deleting backend service")
 	defer func() {
 		if utils.IsHTTPErrorCode(err, http.StatusNotFound) {
 			err = nil
 		}
 		if err == nil {
-			b.snapshotter.Delete(portKey(port))
+			b.snapshotter.Delete(name)
 		}
 	}()
 	// Try deleting health checks even if a backend is not found.
 	if err = b.cloud.DeleteGlobalBackendService(name); err != nil && !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
 		return err
 	}
-
+	if !deleteHealthCheck {
+		return nil
+	}
 	return b.healthChecker.Delete(port)
 }
 
@@ -374,19 +865,58 @@ func getBackendsForIGs(igs []*compute.InstanceGroup, bm BalancingMode) []*comput
 	return backends
 }
 
-func getBackendsForNEGs(negs []*computealpha.NetworkEndpointGroup) []*computealpha.Backend {
+// getBackendsForNEGs returns the backends of the given NEGs, with the
+// capacity settings of the given balancing configuration, if any.
+func getBackendsForNEGs(negs []*computealpha.NetworkEndpointGroup, balancing *backendconfig.BalancingConfig) []*computealpha.Backend {
 	var backends []*computealpha.Backend
 	for _, neg := range negs {
 		b := &computealpha.Backend{
 			Group:              neg.SelfLink,
 			BalancingMode:      string(Rate),
 			MaxRatePerEndpoint: maxRPS,
+			// Always send the scaler, GCE defaults it to 1 and 0 drains the
+			// NEG.
+			CapacityScaler:  1,
+			ForceSendFields: []string{"CapacityScaler"},
+		}
+		if balancing != nil {
+			if balancing.MaxRatePerEndpoint != nil {
+				b.MaxRatePerEndpoint = *balancing.MaxRatePerEndpoint
+			}
+			if balancing.MaxConnectionsPerEndpoint != nil {
+				b.BalancingMode = string(Connections)
+				b.MaxRatePerEndpoint = 0
+				b.MaxConnectionsPerEndpoint = *balancing.MaxConnectionsPerEndpoint
+			}
+			if balancing.CapacityScaler != nil {
+				b.CapacityScaler = *balancing.CapacityScaler
+			}
 		}
 		backends = append(backends, b)
 	}
 	return backends
 }
 
+// negBackendsEqual returns true if the given NEG backends link the same NEGs
+// with the same capacity settings.
+func negBackendsEqual(a, b []*computealpha.Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byGroup := map[string]*computealpha.Backend{}
+	for _, be := range a {
+		byGroup[be.Group] = be
+	}
+	for _, be := range b {
+		other, ok := byGroup[be.Group]
+		if !ok || other.BalancingMode != be.BalancingMode || other.MaxRatePerEndpoint != be.MaxRatePerEndpoint ||
+			other.MaxConnectionsPerEndpoint != be.MaxConnectionsPerEndpoint || other.CapacityScaler != be.CapacityScaler {
+			return false
+		}
+	}
+	return true
+}
+
 // edgeHop checks the links of the given backend by executing an edge hop.
 // It fixes broken links.
 func (b *Backends) edgeHop(be *compute.BackendService, igs []*compute.InstanceGroup) error {
@@ -401,7 +931,7 @@ func (b *Backends) edgeHop(be *compute.BackendService, igs []*compute.InstanceGr
 	if beIGs.IsSuperset(igLinks) {
 		return nil
 	}
-	glog.V(2).Infof("Updating backend service %v with %d backends: expected igs %+v, current igs %+v",
+	logging.V(2).Infof("Updating backend service %v with %d backends: expected igs %+v, current igs %+v",
 		be.Name, igLinks.Len(), igLinks.List(), beIGs.List())
 
 	originalIGBackends := []*compute.Backend{}
@@ -412,6 +942,8 @@ func (b *Backends) edgeHop(be *compute.BackendService, igs []*compute.InstanceGr
 			originalIGBackends = append(originalIGBackends, backend)
 		}
 	}
+	// The backend service is being migrated from NEGs to instance groups.
+	hasNEGBackends := len(originalIGBackends) != len(be.Backends)
 
 	var addIGs []*compute.InstanceGroup
 	for _, ig := range igs {
@@ -434,7 +966,7 @@ func (b *Backends) edgeHop(be *compute.BackendService, igs []*compute.InstanceGr
 
 		if err := b.cloud.UpdateGlobalBackendService(be); err != nil {
 			if utils.IsHTTPErrorCode(err, http.StatusBadRequest) {
-				glog.V(2).Infof("Updating backend service backends with balancing mode %v failed, will try another mode. err:%v", bm, err)
+				logging.V(2).Infof("Updating backend service backends with balancing mode %v failed, will try another mode. err:%v", bm, err)
 				errs = append(errs, err.Error())
 				// This is probably a failure because we tried to create the backend
 				// with balancingMode=RATE when there are already backends with
@@ -442,38 +974,98 @@ func (b *Backends) edgeHop(be *compute.BackendService, igs []*compute.InstanceGr
 				// balancingMode=UTILIZATION (b/35102911).
 				continue
 			}
-			glog.V(2).Infof("Error updating backend service backends with balancing mode %v:%v", bm, err)
+			logging.V(2).Infof("Error updating backend service backends with balancing mode %v:%v", bm, err)
 			return err
 		}
 		return nil
 	}
+	if hasNEGBackends {
+		// The instance groups may have to use a different balancing mode than
+		// the NEGs, which GCE rejects in place. Remove the NEGs first, the
+		// backend service has no backends until the next update.
+		if !b.disruptiveMigrations {
+			return fmt.Errorf("backend service %v can't switch from NEGs to instance groups in place, removing its NEGs first drops its traffic until the instance groups are added, which --allow-disruptive-backend-migrations allows: %v", be.Name, strings.Join(errs, "\n"))
+		}
+		logging.Warningf("Failed to replace NEGs of backend service %v with instance groups in place, removing NEGs first: %v", be.Name, strings.Join(errs, "\n"))
+		be.Backends = originalIGBackends
+		be.ForceSendFields = append(be.ForceSendFields, "Backends")
+		if err := b.cloud.UpdateGlobalBackendService(be); err != nil {
+			return fmt.Errorf("failed to remove NEGs of backend service %v: %v", be.Name, err)
+		}
+		be, err := b.cloud.GetGlobalBackendService(be.Name)
+		if err != nil {
+			return err
+		}
+		return b.edgeHop(be, igs)
+	}
 	return fmt.Errorf("received errors when updating backend service: %v", strings.Join(errs, "\n"))
 }
 
 // GC garbage collects services corresponding to ports in the given list.
+// Backend services of known ports are also collected if they were renamed.
 func (b *Backends) GC(svcNodePorts []ServicePort) error {
 	knownPorts := sets.NewString()
+	knownNames := sets.NewString()
 	for _, p := range svcNodePorts {
-		knownPorts.Insert(portKey(p.Port))
+		if p.HasNodePort() {
+			knownPorts.Insert(portKey(p.Port))
+		}
+		knownNames.Insert(p.BackendName(b.namer))
 	}
 	pool := b.snapshotter.Snapshot()
-	for port := range pool {
-		p, err := strconv.ParseUint(port, 10, 16)
+	for name, obj := range pool {
+		nodePort, err := b.backendPort(name, obj.(*compute.BackendService))
 		if err != nil {
 			return err
 		}
-		nodePort := int64(p)
-		if knownPorts.Has(portKey(nodePort)) || b.ignoredPorts.Has(portKey(nodePort)) {
+		if knownNames.Has(name) || b.ignoredPorts.Has(portKey(nodePort)) {
+			continue
+		}
+		logging.V(3).Infof("GCing backend %v for port %v", name, nodePort)
+		// Serverless and internet backends have no node port, nor health
+		// check.
+		deleteHealthCheck := nodePort != 0 && !knownPorts.Has(portKey(nodePort))
+		if err := b.delete(name, nodePort, deleteHealthCheck); err != nil && !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
+			return err
+		}
+		if err := b.deleteInternetNEGs(obj.(*compute.BackendService)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteInternetNEGs deletes the internet NEGs of the cluster backing the
+// given deleted backend service.
+func (b *Backends) deleteInternetNEGs(be *compute.BackendService) error {
+	for _, backend := range be.Backends {
+		name := retrieveObjectName(backend.Group)
+		if !strings.Contains(backend.Group, "global/networkEndpointGroups/") || !b.namer.IsInternetBackend(name) {
 			continue
 		}
-		glog.V(3).Infof("GCing backend for port %v", p)
-		if err := b.Delete(nodePort); err != nil && !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
+		logging.ForResource(name).WithOperation("delete").V(2).Infof("Deleting internet NEG")
+		if err := b.extended.DeleteGlobalNetworkEndpointGroup(name); err != nil && !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
 			return err
 		}
 	}
 	return nil
 }
 
+// backendPort returns the node port of the backend service with the given
+// name. Generated names contain the port, user-specified names don't. The
+// port of serverless and internet backends is 0.
+func (b *Backends) backendPort(name string, be *compute.BackendService) (int64, error) {
+	port, err := b.namer.BackendPort(name)
+	if err != nil {
+		return be.Port, nil
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int64(p), nil
+}
+
 // Shutdown deletes all backends and the default backend.
 // This will fail if one of the backends is being used by another resource.
 func (b *Backends) Shutdown() error {
@@ -483,6 +1075,48 @@ func (b *Backends) Shutdown() error {
 	return nil
 }
 
+// BackendHealth is the aggregate health of the endpoints of all backends of a
+// backend service, eg: instances or Pods.
+type BackendHealth struct {
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+}
+
+// FullyUnhealthy returns true if the backend service has endpoints, and none
+// of them is healthy. The load balancer answers 502 for all requests.
+func (h *BackendHealth) FullyUnhealthy() bool {
+	return h.Healthy == 0 && h.Unhealthy > 0
+}
+
+// Health returns the aggregate health of the endpoints of the backend service
+// with the given name. Endpoints which are neither healthy nor unhealthy, eg:
+// draining, are not counted.
+func (b *Backends) Health(name string) (*BackendHealth, error) {
+	backend, err := b.cloud.GetGlobalBackendService(name)
+	if err != nil {
+		return nil, err
+	}
+	health := &BackendHealth{}
+	for _, be := range backend.Backends {
+		hs, err := b.cloud.GetGlobalBackendServiceHealth(name, be.Group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get health of backend %v of backend service %v: %v", be.Group, name, err)
+		}
+		for _, status := range hs.HealthStatus {
+			if status == nil {
+				continue
+			}
+			switch status.HealthState {
+			case "HEALTHY":
+				health.Healthy++
+			case "UNHEALTHY":
+				health.Unhealthy++
+			}
+		}
+	}
+	return health, nil
+}
+
 // Status returns the status of the given backend by name.
 func (b *Backends) Status(name string) string {
 	backend, err := b.cloud.GetGlobalBackendService(name)
@@ -515,29 +1149,73 @@ func (b *Backends) Link(port ServicePort, zones []string) error {
 		negs = append(negs, neg)
 	}
 
-	backendService, err := b.cloud.GetAlphaGlobalBackendService(b.namer.Backend(port.Port))
+	backendService, err := b.cloud.GetAlphaGlobalBackendService(port.BackendName(b.namer))
 	if err != nil {
 		return err
 	}
 
-	targetBackends := getBackendsForNEGs(negs)
-	oldBackends := sets.NewString()
-	newBackends := sets.NewString()
+	var balancing *backendconfig.BalancingConfig
+	if port.BackendConfig != nil {
+		balancing = port.BackendConfig.Spec.Balancing
+	}
+	targetBackends := getBackendsForNEGs(negs, balancing)
+	// Keep the NEGs of other clusters, registered by the members of a
+	// multi-cluster Ingress.
+	for _, be := range backendService.Backends {
+		if b.namer.IsForeignNEG(retrieveObjectName(be.Group)) {
+			targetBackends = append(targetBackends, be)
+		}
+	}
 
 	// WARNING: the backend link includes api version.
 	// API versions has to match, otherwise backend link will be always different.
-	for _, be := range backendService.Backends {
-		oldBackends.Insert(be.Group)
+	if !negBackendsEqual(backendService.Backends, targetBackends) {
+		return b.replaceAlphaBackends(backendService, targetBackends)
+	}
+	return nil
+}
+
+// replaceAlphaBackends replaces the backends of the given backend service.
+// GCE rejects updates which switch the balancing mode of a backend service in
+// place, eg: from instance groups with UTILIZATION to NEGs with RATE. With
+// disruptiveMigrations, such updates are retried in two steps, removing the
+// old backends before adding the new ones. The backend service has no
+// backends in between. Otherwise the old backends are kept, and an error
+// is returned.
+func (b *Backends) replaceAlphaBackends(be *computealpha.BackendService, backends []*computealpha.Backend) error {
+	oldModes := balancingModes(be.Backends)
+	newModes := balancingModes(backends)
+	be.Backends = backends
+	err := b.cloud.UpdateAlphaGlobalBackendService(be)
+	if err == nil || !utils.IsHTTPErrorCode(err, http.StatusBadRequest) || oldModes.Len() == 0 || oldModes.Equal(newModes) {
+		return err
 	}
-	for _, be := range targetBackends {
-		newBackends.Insert(be.Group)
+	if !b.disruptiveMigrations {
+		return fmt.Errorf("backend service %v can't switch from balancing mode %v to %v in place, removing its backends first drops its traffic until the new backends are added, which --allow-disruptive-backend-migrations allows: %v", be.Name, oldModes.List(), newModes.List(), err)
 	}
 
-	if !oldBackends.Equal(newBackends) {
-		backendService.Backends = targetBackends
-		return b.cloud.UpdateAlphaGlobalBackendService(backendService)
+	logging.Warningf("Failed to switch backend service %v from balancing mode %v to %v in place, removing its backends first: %v", be.Name, oldModes.List(), newModes.List(), err)
+	be.Backends = nil
+	be.ForceSendFields = append(be.ForceSendFields, "Backends")
+	if err := b.cloud.UpdateAlphaGlobalBackendService(be); err != nil {
+		return fmt.Errorf("failed to remove backends of backend service %v: %v", be.Name, err)
 	}
-	return nil
+	// Refresh the fingerprint.
+	be, err = b.cloud.GetAlphaGlobalBackendService(be.Name)
+	if err != nil {
+		return err
+	}
+	be.Backends = backends
+	return b.cloud.UpdateAlphaGlobalBackendService(be)
+}
+
+// balancingModes returns the balancing modes of the given backends.
+func balancingModes(backends []*computealpha.Backend) sets.String {
+	modes := sets.NewString()
+	for _, b := range backends {
+		modes.Insert(b.BalancingMode)
+	}
+	return modes
 }
 
 func applyLegacyHCToHC(existing *compute.HttpHealthCheck, hc *healthchecks.HealthCheck) {
@@ -567,6 +1245,10 @@ func applyProbeSettingsToHC(p *v1.Probe, hc *healthchecks.HealthCheck) {
 	}
 	hc.RequestPath = healthPath
 	hc.Host = host
+	// The port only serves HTTPS if its probe does.
+	if p.Handler.HTTPGet.Scheme == v1.URISchemeHTTPS && hc.Protocol() == utils.ProtocolHTTP {
+		hc.Type = string(utils.ProtocolHTTPS)
+	}
 	hc.Description = "Kubernetes L7 health check generated with readiness probe settings."
 	hc.TimeoutSec = int64(p.TimeoutSeconds)
 	if hc.ForNEG {
@@ -578,6 +1260,20 @@ func applyProbeSettingsToHC(p *v1.Probe, hc *healthchecks.HealthCheck) {
 	}
 }
 
+// applyGRPCProbeSettingsToHC turns the health check into a gRPC health check
+// of the service of the given gRPC readiness probe.
+func applyGRPCProbeSettingsToHC(p *GRPCProbe, hc *healthchecks.HealthCheck) {
+	hc.Type = healthchecks.GRPCType
+	hc.GRPCServiceName = p.Service
+	hc.Description = "Kubernetes L7 health check generated with gRPC readiness probe settings."
+	hc.TimeoutSec = p.TimeoutSeconds
+	if hc.ForNEG {
+		hc.CheckIntervalSec = p.PeriodSeconds
+	} else {
+		hc.CheckIntervalSec = p.PeriodSeconds + int64(healthchecks.DefaultHealthCheckInterval.Seconds())
+	}
+}
+
 //retrieveObjectName takes a GCE object link and return the last part of the url as object name
 func retrieveObjectName(url string) string {
 	splited := strings.Split(url, "/")
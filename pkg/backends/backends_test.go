@@ -30,6 +30,8 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
 
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
 	"k8s.io/ingress-gce/pkg/healthchecks"
 	"k8s.io/ingress-gce/pkg/instances"
 	"k8s.io/ingress-gce/pkg/networkendpointgroup"
@@ -60,8 +62,8 @@ func newTestJig(f BackendServices, fakeIGs instances.InstanceGroups, syncWithClo
 	nodePool := instances.NewNodePool(fakeIGs, namer)
 	nodePool.Init(&instances.FakeZoneLister{Zones: []string{defaultZone}})
 	healthCheckProvider := healthchecks.NewFakeHealthCheckProvider()
-	healthChecks := healthchecks.NewHealthChecker(healthCheckProvider, "/", namer)
-	bp := NewBackendPool(f, negGetter, healthChecks, nodePool, namer, []int64{}, syncWithCloud)
+	healthChecks := healthchecks.NewHealthChecker(healthCheckProvider, healthCheckProvider, "/", namer, false)
+	bp := NewBackendPool(f, negGetter, NewFakeSecurityPolicies(), NewFakeExtendedBackendServices(), healthChecks, nodePool, namer, []int64{}, syncWithCloud)
 	probes := map[ServicePort]*api_v1.Probe{{Port: 443, Protocol: utils.ProtocolHTTPS}: existingProbe}
 	bp.Init(NewFakeProbeProvider(probes))
 
@@ -213,6 +215,561 @@ func TestBackendPoolUpdate(t *testing.T) {
 	}
 }
 
+func TestBackendPoolBackendConfig(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	namer := utils.Namer{}
+
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{
+		Cdn: &backendconfig.CDNConfig{Enabled: true},
+	}}
+	p := ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP, BackendConfig: config}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	be, err := f.GetGlobalBackendService(namer.Backend(p.Port))
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if !be.EnableCDN {
+		t.Errorf("Expected CDN to be enabled on backend service %v", be.Name)
+	}
+
+	// Features are left untouched without a BackendConfig.
+	p.BackendConfig = nil
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if be, _ = f.GetGlobalBackendService(namer.Backend(p.Port)); !be.EnableCDN {
+		t.Errorf("Expected CDN to remain enabled on backend service %v", be.Name)
+	}
+
+	config.Spec.Cdn.Enabled = false
+	p.BackendConfig = config
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if be, _ = f.GetGlobalBackendService(namer.Backend(p.Port)); be.EnableCDN {
+		t.Errorf("Expected CDN to be disabled on backend service %v", be.Name)
+	}
+}
+
+func TestBackendPoolBackendConfigIAP(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	namer := utils.Namer{}
+
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{
+		Iap: &backendconfig.IAPConfig{
+			Enabled:                true,
+			OAuthClientCredentials: &backendconfig.OAuthClientCredentials{SecretName: "iap", ClientID: "id", ClientSecret: "secret"},
+		},
+	}}
+	p := ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP, BackendConfig: config}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	be, _ := f.GetGlobalBackendService(namer.Backend(p.Port))
+	if be.Iap == nil || !be.Iap.Enabled || be.Iap.Oauth2ClientId != "id" {
+		t.Fatalf("Expected IAP to be enabled with client id on backend service %v, got %+v", be.Name, be.Iap)
+	}
+
+	// The backend service is not updated again while it matches.
+	calls := len(f.calls)
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	for _, call := range f.calls[calls:] {
+		if call == utils.Update {
+			t.Errorf("Unexpected update of backend service %v", be.Name)
+		}
+	}
+
+	// IAP disabled outside of the controller is enabled again.
+	be.Iap.Enabled = false
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if be, _ = f.GetGlobalBackendService(namer.Backend(p.Port)); be.Iap == nil || !be.Iap.Enabled {
+		t.Errorf("Expected IAP to be enabled again on backend service %v, got %+v", be.Name, be.Iap)
+	}
+}
+
+func TestBackendPoolSecurityPolicy(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	policies := NewFakeSecurityPolicies("policy-a", "policy-b")
+	pool.securityPolicies = policies
+	beName := (&utils.Namer{}).Backend(3000)
+
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{
+		SecurityPolicy: &backendconfig.SecurityPolicyConfig{Name: "policy-a"},
+	}}
+	p := ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP, BackendConfig: config}
+	for _, tc := range []struct {
+		desc     string
+		policy   string
+		wantLink string
+		wantErr  bool
+	}{
+		{desc: "attach", policy: "policy-a", wantLink: "global/securityPolicies/policy-a"},
+		{desc: "replace", policy: "policy-b", wantLink: "global/securityPolicies/policy-b"},
+		{desc: "missing policy", policy: "policy-c", wantLink: "global/securityPolicies/policy-b", wantErr: true},
+		{desc: "detach", policy: "", wantLink: ""},
+	} {
+		config.Spec.SecurityPolicy.Name = tc.policy
+		err := pool.Ensure([]ServicePort{p}, nil)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: Ensure() = %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if link := policies.attached[beName]; link != tc.wantLink {
+			t.Errorf("%s: got security policy %q, want %q", tc.desc, link, tc.wantLink)
+		}
+	}
+
+	// The policy is left untouched if the BackendConfig does not set it.
+	policies.attached[beName] = "global/securityPolicies/policy-a"
+	config.Spec.SecurityPolicy = nil
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if link := policies.attached[beName]; link != "global/securityPolicies/policy-a" {
+		t.Errorf("Expected security policy to be left untouched, got %q", link)
+	}
+}
+
+func TestBackendPoolCustomRequestHeaders(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	beName := (&utils.Namer{}).Backend(3000)
+
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{
+		CustomRequestHeaders: &backendconfig.CustomRequestHeadersConfig{},
+	}}
+	p := ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP, BackendConfig: config}
+	for _, tc := range []struct {
+		desc    string
+		headers []string
+	}{
+		{desc: "add", headers: []string{"X-Client-Geo:{client_region}"}},
+		{desc: "append", headers: []string{"X-Client-Geo:{client_region}", "X-Client-RTT:{client_rtt_msec}"}},
+		{desc: "reorder", headers: []string{"X-Client-RTT:{client_rtt_msec}", "X-Client-Geo:{client_region}"}},
+		{desc: "remove", headers: []string{}},
+	} {
+		config.Spec.CustomRequestHeaders.Headers = tc.headers
+		if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+			t.Fatalf("%s: Unexpected err: %v", tc.desc, err)
+		}
+		be, _ := f.GetAlphaGlobalBackendService(beName)
+		if !sets.NewString(be.CustomRequestHeaders...).Equal(sets.NewString(tc.headers...)) {
+			t.Errorf("%s: got headers %v, want %v", tc.desc, be.CustomRequestHeaders, tc.headers)
+		}
+	}
+
+	// Headers dropped by a v1 update are restored on the next sync.
+	config.Spec.CustomRequestHeaders.Headers = []string{"X-Client-Geo:{client_region}"}
+	pool.Ensure([]ServicePort{p}, nil)
+	v1BE, _ := f.GetGlobalBackendService(beName)
+	f.UpdateGlobalBackendService(v1BE)
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if be, _ := f.GetAlphaGlobalBackendService(beName); len(be.CustomRequestHeaders) != 1 {
+		t.Errorf("Expected custom request headers to be restored, got %v", be.CustomRequestHeaders)
+	}
+
+	// The headers are left untouched if the BackendConfig does not set them.
+	config.Spec.CustomRequestHeaders = nil
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if be, _ := f.GetAlphaGlobalBackendService(beName); len(be.CustomRequestHeaders) != 1 {
+		t.Errorf("Expected custom request headers to be left untouched, got %v", be.CustomRequestHeaders)
+	}
+}
+
+func TestBackendPoolLogConfig(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	extended := NewFakeExtendedBackendServices()
+	pool.extended = extended
+	beName := (&utils.Namer{}).Backend(3000)
+
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{
+		LogConfig: &backendconfig.LogConfig{},
+	}}
+	p := ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP, BackendConfig: config}
+	half := 0.5
+	for _, tc := range []struct {
+		desc       string
+		logConfig  backendconfig.LogConfig
+		wantEnable bool
+		wantRate   *float64
+	}{
+		{desc: "enable", logConfig: backendconfig.LogConfig{Enable: true}, wantEnable: true},
+		{desc: "sample", logConfig: backendconfig.LogConfig{Enable: true, SampleRate: &half}, wantEnable: true, wantRate: &half},
+		{desc: "disable", logConfig: backendconfig.LogConfig{SampleRate: &half}},
+	} {
+		*config.Spec.LogConfig = tc.logConfig
+		if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+			t.Fatalf("%s: Unexpected err: %v", tc.desc, err)
+		}
+		be, _ := extended.GetExtendedBackendService(beName)
+		want := &BackendServiceLogConfig{Enable: tc.wantEnable, SampleRate: tc.wantRate}
+		if !logConfigEqual(be.LogConfig, want) {
+			t.Errorf("%s: got log config %+v, want enable %v and sample rate %v", tc.desc, be.LogConfig, tc.wantEnable, tc.wantRate)
+		}
+	}
+
+	// The log config is left untouched if the BackendConfig does not set it.
+	extended.BackendServices[beName].LogConfig.Enable = true
+	config.Spec.LogConfig = nil
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if be, _ := extended.GetExtendedBackendService(beName); !be.LogConfig.Enable {
+		t.Errorf("Expected log config to be left untouched, got %+v", be.LogConfig)
+	}
+}
+
+func TestBackendPoolCustomResponseHeaders(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	extended := NewFakeExtendedBackendServices()
+	pool.extended = extended
+	beName := (&utils.Namer{}).Backend(3000)
+
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{
+		CustomResponseHeaders: &backendconfig.CustomResponseHeadersConfig{},
+	}}
+	p := ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP, BackendConfig: config}
+	for _, tc := range []struct {
+		desc      string
+		headers   []string
+		wantPatch bool
+	}{
+		{desc: "add", headers: []string{"Strict-Transport-Security:max-age=31536000", "X-Frame-Options:DENY"}, wantPatch: true},
+		{desc: "reorder", headers: []string{"X-Frame-Options:DENY", "Strict-Transport-Security:max-age=31536000"}},
+		{desc: "change", headers: []string{"X-Frame-Options:SAMEORIGIN", "Strict-Transport-Security:max-age=31536000"}, wantPatch: true},
+		{desc: "remove", headers: []string{}, wantPatch: true},
+		{desc: "already removed", headers: []string{}},
+	} {
+		config.Spec.CustomResponseHeaders.Headers = tc.headers
+		patches := extended.Patches
+		if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+			t.Fatalf("%s: Unexpected err: %v", tc.desc, err)
+		}
+		if patched := extended.Patches > patches; patched != tc.wantPatch {
+			t.Errorf("%s: patched = %v, want %v", tc.desc, patched, tc.wantPatch)
+		}
+		be, _ := extended.GetExtendedBackendService(beName)
+		if !sets.NewString(be.CustomResponseHeaders...).Equal(sets.NewString(tc.headers...)) {
+			t.Errorf("%s: got headers %v, want %v", tc.desc, be.CustomResponseHeaders, tc.headers)
+		}
+	}
+
+	// The headers are left untouched if the BackendConfig does not set them.
+	extended.BackendServices[beName].CustomResponseHeaders = []string{"X-Frame-Options:DENY"}
+	config.Spec.CustomResponseHeaders = nil
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if be, _ := extended.GetExtendedBackendService(beName); len(be.CustomResponseHeaders) != 1 {
+		t.Errorf("Expected custom response headers to be left untouched, got %v", be.CustomResponseHeaders)
+	}
+}
+
+func TestBackendPoolCDNPolicy(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	extended := NewFakeExtendedBackendServices()
+	pool.extended = extended
+	beName := (&utils.Namer{}).Backend(3000)
+
+	enabled, disabled := true, false
+	stale, longer := int64(3600), int64(86400)
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{Cdn: &backendconfig.CDNConfig{Enabled: true}}}
+	p := ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP, BackendConfig: config}
+	for _, tc := range []struct {
+		desc            string
+		negativeCaching *bool
+		policy          []backendconfig.NegativeCachingPolicy
+		serveWhileStale *int64
+		want            BackendServiceCdnPolicy
+		wantPatch       bool
+	}{
+		{
+			desc: "unset",
+		},
+		{
+			desc:            "enable",
+			negativeCaching: &enabled,
+			policy:          []backendconfig.NegativeCachingPolicy{{Code: 404, Ttl: 60}, {Code: 410, Ttl: 120}},
+			serveWhileStale: &stale,
+			want: BackendServiceCdnPolicy{
+				NegativeCaching:       true,
+				NegativeCachingPolicy: []*BackendServiceNegativeCachingPolicy{{Code: 404, Ttl: 60}, {Code: 410, Ttl: 120}},
+				ServeWhileStale:       stale,
+			},
+			wantPatch: true,
+		},
+		{
+			desc:            "reorder",
+			negativeCaching: &enabled,
+			policy:          []backendconfig.NegativeCachingPolicy{{Code: 410, Ttl: 120}, {Code: 404, Ttl: 60}},
+			serveWhileStale: &stale,
+			want: BackendServiceCdnPolicy{
+				NegativeCaching:       true,
+				NegativeCachingPolicy: []*BackendServiceNegativeCachingPolicy{{Code: 404, Ttl: 60}, {Code: 410, Ttl: 120}},
+				ServeWhileStale:       stale,
+			},
+		},
+		{
+			desc:            "change serve while stale only",
+			negativeCaching: &enabled,
+			policy:          []backendconfig.NegativeCachingPolicy{{Code: 404, Ttl: 60}, {Code: 410, Ttl: 120}},
+			serveWhileStale: &longer,
+			want: BackendServiceCdnPolicy{
+				NegativeCaching:       true,
+				NegativeCachingPolicy: []*BackendServiceNegativeCachingPolicy{{Code: 404, Ttl: 60}, {Code: 410, Ttl: 120}},
+				ServeWhileStale:       longer,
+			},
+			wantPatch: true,
+		},
+		{
+			desc:            "disable negative caching, leave serve while stale",
+			negativeCaching: &disabled,
+			want:            BackendServiceCdnPolicy{NegativeCachingPolicy: []*BackendServiceNegativeCachingPolicy{}, ServeWhileStale: longer},
+			wantPatch:       true,
+		},
+	} {
+		config.Spec.Cdn.NegativeCaching = tc.negativeCaching
+		config.Spec.Cdn.NegativeCachingPolicy = tc.policy
+		config.Spec.Cdn.ServeWhileStale = tc.serveWhileStale
+		patches := extended.Patches
+		if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+			t.Fatalf("%s: Unexpected err: %v", tc.desc, err)
+		}
+		if patched := extended.Patches > patches; patched != tc.wantPatch {
+			t.Errorf("%s: patched = %v, want %v", tc.desc, patched, tc.wantPatch)
+		}
+		be, _ := extended.GetExtendedBackendService(beName)
+		got := BackendServiceCdnPolicy{}
+		if be.CdnPolicy != nil {
+			got = *be.CdnPolicy
+		}
+		if got.NegativeCaching != tc.want.NegativeCaching || got.ServeWhileStale != tc.want.ServeWhileStale || !negativeCachingPolicyEqual(got.NegativeCachingPolicy, tc.want.NegativeCachingPolicy) {
+			t.Errorf("%s: got CDN policy %+v, want %+v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestLogConfigEqual(t *testing.T) {
+	one, half := 1.0, 0.5
+	for _, tc := range []struct {
+		desc string
+		a, b *BackendServiceLogConfig
+		want bool
+	}{
+		{desc: "unset and disabled", a: nil, b: &BackendServiceLogConfig{}, want: true},
+		{desc: "disabled with different rates", a: &BackendServiceLogConfig{SampleRate: &half}, b: &BackendServiceLogConfig{}, want: true},
+		{desc: "unset and enabled", a: nil, b: &BackendServiceLogConfig{Enable: true}},
+		{desc: "default rate", a: &BackendServiceLogConfig{Enable: true, SampleRate: &one}, b: &BackendServiceLogConfig{Enable: true}, want: true},
+		{desc: "different rates", a: &BackendServiceLogConfig{Enable: true, SampleRate: &half}, b: &BackendServiceLogConfig{Enable: true}},
+	} {
+		if got := logConfigEqual(tc.a, tc.b); got != tc.want {
+			t.Errorf("%s: logConfigEqual() = %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestBackendPoolCustomName(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	namer := &utils.Namer{}
+
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{BackendServiceName: "my-backend"}}
+	p := ServicePort{
+		Port:          3000,
+		Protocol:      utils.ProtocolHTTP,
+		SvcName:       types.NamespacedName{Namespace: "ns", Name: "svc"},
+		SvcPort:       intstr.FromInt(80),
+		BackendConfig: config,
+	}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	be, err := pool.GetServicePort(p)
+	if err != nil || be.Name != "my-backend" {
+		t.Fatalf("Expected backend service my-backend, got %+v: %v", be, err)
+	}
+	if want := `{"kubernetes.io/service-name":"ns/svc","kubernetes.io/service-port":"80","kubernetes.io/cluster-uid":""}`; be.Description != want {
+		t.Errorf("Expected description %q, got %q", want, be.Description)
+	}
+	if _, err := f.GetGlobalBackendService(namer.Backend(p.Port)); err == nil {
+		t.Errorf("Expected no backend service with the generated name")
+	}
+
+	// Another Service port can't take over the backend service.
+	other := p
+	other.Port = 3001
+	other.SvcName.Name = "other"
+	if err := pool.Ensure([]ServicePort{other}, nil); err == nil {
+		t.Errorf("Expected an error for a backend service owned by another Service")
+	}
+
+	// Renaming the backend service GCs the old one, but not the health check.
+	config.Spec.BackendServiceName = "my-renamed-backend"
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if err := pool.GC([]ServicePort{p}); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if _, err := f.GetGlobalBackendService("my-backend"); err == nil {
+		t.Errorf("Expected backend service my-backend to be GCed")
+	}
+	if _, err := f.GetGlobalBackendService("my-renamed-backend"); err != nil {
+		t.Errorf("Expected backend service my-renamed-backend to exist: %v", err)
+	}
+	if _, err := pool.healthChecker.Get(p.Port, false); err != nil {
+		t.Errorf("Expected health check of port %v to exist: %v", p.Port, err)
+	}
+
+	// Removing the port GCs the backend service and its health check.
+	if err := pool.GC(nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if _, err := f.GetGlobalBackendService("my-renamed-backend"); err == nil {
+		t.Errorf("Expected backend service my-renamed-backend to be GCed")
+	}
+	if _, err := pool.healthChecker.Get(p.Port, false); err == nil {
+		t.Errorf("Expected health check of port %v to be GCed", p.Port)
+	}
+}
+
+func TestBackendPoolServerlessNEG(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	namer := &utils.Namer{}
+
+	p := ServicePort{
+		Protocol:      utils.ProtocolHTTPS,
+		SvcName:       types.NamespacedName{Namespace: "ns", Name: "run"},
+		SvcPort:       intstr.FromInt(80),
+		ServerlessNEG: &annotations.ServerlessNEG{Region: "us-central1", Name: "run-neg"},
+	}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	beName := namer.ServerlessBackend("us-central1", "run-neg")
+	be, err := f.GetGlobalBackendService(beName)
+	if err != nil {
+		t.Fatalf("Expected backend service %v: %v", beName, err)
+	}
+	if len(be.Backends) != 1 || be.Backends[0].Group != "regions/us-central1/networkEndpointGroups/run-neg" {
+		t.Errorf("Expected the serverless NEG as only backend, got %+v", be.Backends)
+	}
+	if len(be.HealthChecks) != 0 || be.Port != 0 || be.PortName != "" || be.Protocol != "HTTPS" {
+		t.Errorf("Expected an HTTPS backend service without health check or port, got %+v", be)
+	}
+	if want := `{"kubernetes.io/service-name":"ns/run","kubernetes.io/service-port":"80","kubernetes.io/cluster-uid":""}`; be.Description != want {
+		t.Errorf("Expected description %q, got %q", want, be.Description)
+	}
+	if _, err := pool.healthChecker.Get(0, false); err == nil {
+		t.Errorf("Expected no health check for the serverless backend")
+	}
+	if ig, _ := fakeIGs.GetInstanceGroup(namer.InstanceGroup(), defaultZone); ig != nil && len(ig.NamedPorts) != 0 {
+		t.Errorf("Expected no named ports, got %+v", ig.NamedPorts)
+	}
+
+	// Backends changed outside of the controller are restored.
+	be.Backends = nil
+	f.UpdateGlobalBackendService(be)
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	be, _ = f.GetGlobalBackendService(beName)
+	if len(be.Backends) != 1 {
+		t.Errorf("Expected the serverless NEG backend to be restored, got %+v", be.Backends)
+	}
+
+	if err := pool.GC(nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if _, err := f.GetGlobalBackendService(beName); err == nil {
+		t.Errorf("Expected backend service %v to be GCed", beName)
+	}
+}
+
+func TestBackendPoolInternetNEG(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+	extended := NewFakeExtendedBackendServices()
+	pool.extended = extended
+	namer := &utils.Namer{}
+
+	p := ServicePort{
+		Protocol:    utils.ProtocolHTTPS,
+		SvcName:     types.NamespacedName{Namespace: "ns", Name: "legacy"},
+		SvcPort:     intstr.FromInt(443),
+		InternetNEG: &InternetNEG{FQDN: "legacy.example.com", Port: 443},
+	}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	name := namer.InternetBackend("ns", "legacy", "443")
+	neg, err := extended.GetGlobalNetworkEndpointGroup(name)
+	if err != nil || neg.NetworkEndpointType != "INTERNET_FQDN_PORT" {
+		t.Fatalf("Expected internet NEG %v, got %+v, %v", name, neg, err)
+	}
+	if eps := extended.Endpoints[name]; len(eps) != 1 || *eps[0] != (NetworkEndpoint{Fqdn: "legacy.example.com", Port: 443}) {
+		t.Errorf("Expected the origin as only endpoint, got %+v", eps)
+	}
+	be, err := f.GetGlobalBackendService(name)
+	if err != nil {
+		t.Fatalf("Expected backend service %v: %v", name, err)
+	}
+	if len(be.Backends) != 1 || be.Backends[0].Group != "global/networkEndpointGroups/"+name {
+		t.Errorf("Expected the internet NEG as only backend, got %+v", be.Backends)
+	}
+	if len(be.HealthChecks) != 0 || be.Port != 0 || be.Protocol != "HTTPS" {
+		t.Errorf("Expected an HTTPS backend service without health check or port, got %+v", be)
+	}
+	if ig, _ := fakeIGs.GetInstanceGroup(namer.InstanceGroup(), defaultZone); ig != nil && len(ig.NamedPorts) != 0 {
+		t.Errorf("Expected no named ports, got %+v", ig.NamedPorts)
+	}
+
+	// A new external name replaces the endpoint.
+	p.InternetNEG = &InternetNEG{FQDN: "new.example.com", Port: 443}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if eps := extended.Endpoints[name]; len(eps) != 1 || eps[0].Fqdn != "new.example.com" {
+		t.Errorf("Expected the new origin as only endpoint, got %+v", eps)
+	}
+
+	if err := pool.GC(nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if _, err := f.GetGlobalBackendService(name); err == nil {
+		t.Errorf("Expected backend service %v to be GCed", name)
+	}
+	if _, err := extended.GetGlobalNetworkEndpointGroup(name); err == nil {
+		t.Errorf("Expected internet NEG %v to be GCed", name)
+	}
+}
+
 func TestBackendPoolChaosMonkey(t *testing.T) {
 	f := NewFakeBackendServices(noOpErrFunc)
 	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
@@ -341,8 +898,8 @@ func TestBackendPoolDeleteLegacyHealthChecks(t *testing.T) {
 	nodePool := instances.NewNodePool(fakeIGs, namer)
 	nodePool.Init(&instances.FakeZoneLister{Zones: []string{defaultZone}})
 	hcp := healthchecks.NewFakeHealthCheckProvider()
-	healthChecks := healthchecks.NewHealthChecker(hcp, "/", namer)
-	bp := NewBackendPool(f, negGetter, healthChecks, nodePool, namer, []int64{}, false)
+	healthChecks := healthchecks.NewHealthChecker(hcp, hcp, "/", namer, false)
+	bp := NewBackendPool(f, negGetter, NewFakeSecurityPolicies(), NewFakeExtendedBackendServices(), healthChecks, nodePool, namer, []int64{}, false)
 	probes := map[ServicePort]*api_v1.Probe{}
 	bp.Init(NewFakeProbeProvider(probes))
 
@@ -508,6 +1065,14 @@ func TestApplyProbeSettingsToHC(t *testing.T) {
 	if hc.RequestPath != "/"+p {
 		t.Errorf("Failed to apply probe's requestpath")
 	}
+
+	// HTTPS probes switch HTTP health checks to HTTPS.
+	hc = healthchecks.DefaultHealthCheck(8080, utils.ProtocolHTTP)
+	probe.Handler.HTTPGet.Scheme = api_v1.URISchemeHTTPS
+	applyProbeSettingsToHC(probe, hc)
+	if hc.Protocol() != utils.ProtocolHTTPS || hc.RequestPath != "/"+p {
+		t.Errorf("Expected an HTTPS health check of path /%v, got %v %v", p, hc.Protocol(), hc.RequestPath)
+	}
 }
 
 func TestLinkBackendServiceToNEG(t *testing.T) {
@@ -523,8 +1088,8 @@ func TestLinkBackendServiceToNEG(t *testing.T) {
 	nodePool := instances.NewNodePool(fakeIGs, namer)
 	nodePool.Init(&instances.FakeZoneLister{Zones: []string{defaultZone}})
 	hcp := healthchecks.NewFakeHealthCheckProvider()
-	healthChecks := healthchecks.NewHealthChecker(hcp, "/", namer)
-	bp := NewBackendPool(f, fakeNEG, healthChecks, nodePool, namer, []int64{}, false)
+	healthChecks := healthchecks.NewHealthChecker(hcp, hcp, "/", namer, false)
+	bp := NewBackendPool(f, fakeNEG, NewFakeSecurityPolicies(), NewFakeExtendedBackendServices(), healthChecks, nodePool, namer, []int64{}, false)
 
 	svcPort := ServicePort{
 		Port:     30001,
@@ -568,6 +1133,211 @@ func TestLinkBackendServiceToNEG(t *testing.T) {
 	}
 }
 
+func TestLinkBackendServiceToNEGBalancing(t *testing.T) {
+	zones := []string{"zone1", "zone2"}
+	namer := utils.NewNamer("clusterid", "")
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	fakeNEG := networkendpointgroup.NewFakeNetworkEndpointGroupCloud("test-subnetwork", "test-network")
+	nodePool := instances.NewNodePool(fakeIGs, namer)
+	nodePool.Init(&instances.FakeZoneLister{Zones: []string{defaultZone}})
+	healthChecks := healthchecks.NewHealthChecker(healthchecks.NewFakeHealthCheckProvider(), nil, "/", namer, false)
+	bp := NewBackendPool(f, fakeNEG, NewFakeSecurityPolicies(), NewFakeExtendedBackendServices(), healthChecks, nodePool, namer, []int64{}, false)
+
+	conns, scaler := int64(10), 0.5
+	config := &backendconfig.BackendConfig{Spec: backendconfig.BackendConfigSpec{Balancing: &backendconfig.BalancingConfig{
+		MaxConnectionsPerEndpoint: &conns,
+		CapacityScaler:            &scaler,
+	}}}
+	svcPort := ServicePort{
+		Port:          30001,
+		Protocol:      utils.ProtocolHTTP,
+		SvcName:       types.NamespacedName{Namespace: "ns", Name: "name"},
+		SvcPort:       intstr.FromInt(80),
+		SvcTargetPort: "port",
+		NEGEnabled:    true,
+		BackendConfig: config,
+	}
+	if err := bp.Ensure([]ServicePort{svcPort}, nil); err != nil {
+		t.Fatalf("Failed to ensure backend service: %v", err)
+	}
+	for _, zone := range zones {
+		if err := fakeNEG.CreateNetworkEndpointGroup(&computealpha.NetworkEndpointGroup{Name: namer.NEG("ns", "name", "port")}, zone); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := bp.Link(svcPort, zones); err != nil {
+		t.Fatalf("Failed to link backend service to NEG: %v", err)
+	}
+	bs, err := f.GetAlphaGlobalBackendService(namer.Backend(svcPort.Port))
+	if err != nil {
+		t.Fatalf("Failed to retrieve backend service: %v", err)
+	}
+	for _, be := range bs.Backends {
+		if be.BalancingMode != string(Connections) || be.MaxConnectionsPerEndpoint != conns || be.MaxRatePerEndpoint != 0 || be.CapacityScaler != scaler {
+			t.Errorf("Expected backend with %v connections per endpoint and capacity scaler %v, got %+v", conns, scaler, be)
+		}
+	}
+
+	// Linking again does not update the backend service.
+	f.calls = []int{}
+	if err := bp.Link(svcPort, zones); err != nil {
+		t.Fatalf("Failed to link backend service to NEG: %v", err)
+	}
+	for _, call := range f.calls {
+		if call == utils.Update {
+			t.Errorf("Unexpected update of backend service with unchanged balancing, calls %v", f.calls)
+		}
+	}
+
+	// Removing the balancing configuration restores the defaults.
+	config.Spec.Balancing = nil
+	if err := bp.Link(svcPort, zones); err != nil {
+		t.Fatalf("Failed to link backend service to NEG: %v", err)
+	}
+	bs, _ = f.GetAlphaGlobalBackendService(namer.Backend(svcPort.Port))
+	for _, be := range bs.Backends {
+		if be.BalancingMode != string(Rate) || be.MaxRatePerEndpoint != maxRPS || be.CapacityScaler != 1 {
+			t.Errorf("Expected backend with the default balancing, got %+v", be)
+		}
+	}
+}
+
+func TestBalancingModeMigration(t *testing.T) {
+	zones := []string{"zone1", "zone2"}
+	namer := utils.NewNamer("clusterid", "")
+	// Reject updates switching the balancing mode of a backend service in
+	// place, like GCE does for instance groups shared with other backend
+	// services.
+	var f *FakeBackendServices
+	rejectModeSwitch := func(op int, be *compute.BackendService) error {
+		if op != utils.Update || len(be.Backends) == 0 {
+			return nil
+		}
+		existing, err := f.GetGlobalBackendService(be.Name)
+		if err != nil || len(existing.Backends) == 0 || existing.Backends[0].BalancingMode == be.Backends[0].BalancingMode {
+			return nil
+		}
+		return &googleapi.Error{Code: http.StatusBadRequest}
+	}
+	f = NewFakeBackendServices(rejectModeSwitch)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	fakeNEG := networkendpointgroup.NewFakeNetworkEndpointGroupCloud("test-subnetwork", "test-network")
+	nodePool := instances.NewNodePool(fakeIGs, namer)
+	nodePool.Init(&instances.FakeZoneLister{Zones: []string{defaultZone}})
+	healthChecks := healthchecks.NewHealthChecker(healthchecks.NewFakeHealthCheckProvider(), nil, "/", namer, false)
+	bp := NewBackendPool(f, fakeNEG, NewFakeSecurityPolicies(), NewFakeExtendedBackendServices(), healthChecks, nodePool, namer, []int64{}, false)
+
+	svcPort := ServicePort{
+		Port:          30001,
+		Protocol:      utils.ProtocolHTTP,
+		SvcName:       types.NamespacedName{Namespace: "ns", Name: "name"},
+		SvcPort:       intstr.FromInt(80),
+		SvcTargetPort: "port",
+	}
+	beName := namer.Backend(svcPort.Port)
+	for _, zone := range zones {
+		if err := fakeNEG.CreateNetworkEndpointGroup(&computealpha.NetworkEndpointGroup{Name: namer.NEG("ns", "name", "port")}, zone); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Start with instance groups in UTILIZATION mode.
+	if err := bp.Ensure([]ServicePort{svcPort}, nil); err != nil {
+		t.Fatalf("Failed to ensure backend service: %v", err)
+	}
+	be, _ := f.GetGlobalBackendService(beName)
+	for _, b := range be.Backends {
+		b.BalancingMode = string(Utilization)
+	}
+	f.backendServices.Update(be)
+
+	// The backend service keeps its instance groups, and serves traffic,
+	// unless disruptive migrations are allowed.
+	svcPort.NEGEnabled = true
+	if err := bp.Ensure([]ServicePort{svcPort}, nil); err != nil {
+		t.Fatalf("Failed to ensure backend service: %v", err)
+	}
+	if err := bp.Link(svcPort, zones); err == nil {
+		t.Fatalf("Expected linking the NEGs to fail without disruptive migrations")
+	}
+	be, _ = f.GetGlobalBackendService(beName)
+	if len(be.Backends) == 0 {
+		t.Fatalf("Expected the instance group backends to be kept, got none")
+	}
+	for _, b := range be.Backends {
+		if strings.Contains(b.Group, "NetworkEndpointGroup") || b.BalancingMode != string(Utilization) {
+			t.Errorf("Expected instance group backend with balancing mode UTILIZATION, got %+v", b)
+		}
+	}
+
+	// Migrate to NEGs with RATE.
+	bp.SetDisruptiveMigrations(true)
+	if err := bp.Link(svcPort, zones); err != nil {
+		t.Fatalf("Failed to link backend service to NEG: %v", err)
+	}
+	be, _ = f.GetGlobalBackendService(beName)
+	if len(be.Backends) != len(zones) {
+		t.Fatalf("Expected %v NEG backends, got %+v", len(zones), be.Backends)
+	}
+	for _, b := range be.Backends {
+		if !strings.Contains(b.Group, "NetworkEndpointGroup") || b.BalancingMode != string(Rate) {
+			t.Errorf("Expected NEG backend with balancing mode RATE, got %+v", b)
+		}
+	}
+
+	negBackends := append([]*compute.Backend{}, be.Backends...)
+
+	// Migrate back to instance groups, which are rejected with RATE since the
+	// instance groups are shared with UTILIZATION backend services, and
+	// rejected in place while the NEGs are attached.
+	emptied := false
+	f.errFunc = func(op int, be *compute.BackendService) error {
+		if op != utils.Update {
+			return nil
+		}
+		if len(be.Backends) == 0 {
+			emptied = true
+			return nil
+		}
+		for _, b := range be.Backends {
+			if !strings.Contains(b.Group, "NetworkEndpointGroup") && (b.BalancingMode == string(Rate) || !emptied) {
+				return &googleapi.Error{Code: http.StatusBadRequest}
+			}
+		}
+		return nil
+	}
+	svcPort.NEGEnabled = false
+	bp.SetDisruptiveMigrations(false)
+	if err := bp.Ensure([]ServicePort{svcPort}, nil); err == nil {
+		t.Fatalf("Expected switching back to instance groups to fail without disruptive migrations")
+	}
+	if emptied {
+		t.Fatalf("Expected the NEGs to be kept without disruptive migrations")
+	}
+	// The fake backend service shares the backends of the rejected updates,
+	// restore the NEGs GCE kept.
+	be, _ = f.GetGlobalBackendService(beName)
+	be.Backends = negBackends
+	bp.SetDisruptiveMigrations(true)
+	if err := bp.Ensure([]ServicePort{svcPort}, nil); err != nil {
+		t.Fatalf("Failed to ensure backend service: %v", err)
+	}
+	if !emptied {
+		t.Errorf("Expected the NEGs to be removed before the instance groups are added")
+	}
+	be, _ = f.GetGlobalBackendService(beName)
+	if len(be.Backends) == 0 {
+		t.Fatalf("Expected instance group backends, got none")
+	}
+	for _, b := range be.Backends {
+		if strings.Contains(b.Group, "NetworkEndpointGroup") || b.BalancingMode != string(Utilization) {
+			t.Errorf("Expected instance group backend with balancing mode UTILIZATION, got %+v", b)
+		}
+	}
+}
+
 func TestRetrieveObjectName(t *testing.T) {
 	testCases := []struct {
 		url    string
@@ -598,3 +1368,49 @@ func TestRetrieveObjectName(t *testing.T) {
 	}
 
 }
+
+func TestOwnedByCluster(t *testing.T) {
+	namer := utils.NewNamer("uid1", "")
+	for _, tc := range []struct {
+		desc        string
+		description string
+		want        bool
+	}{
+		{"owned", `{"kubernetes.io/service-name":"ns/svc","kubernetes.io/service-port":"80","kubernetes.io/cluster-uid":"uid1"}`, true},
+		{"other cluster", `{"kubernetes.io/service-name":"ns/svc","kubernetes.io/service-port":"80","kubernetes.io/cluster-uid":"uid2"}`, false},
+		{"generated name", `{"kubernetes.io/service-name":"ns/svc","kubernetes.io/service-port":"80"}`, false},
+		{"user created", "my backend", false},
+	} {
+		be := &compute.BackendService{Name: "my-backend", Description: tc.description}
+		if got := OwnedByCluster(be, namer); got != tc.want {
+			t.Errorf("%s: OwnedByCluster() = %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestBackendPoolGRPCProbe(t *testing.T) {
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	pool, _ := newTestJig(f, fakeIGs, false)
+
+	p := ServicePort{Port: 8080, Protocol: utils.ProtocolHTTP2}
+	pool.prober.(*FakeProbeProvider).GRPCProbes[p] = &GRPCProbe{Service: "foo", PeriodSeconds: 5, TimeoutSeconds: 2}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	hc, _ := pool.healthChecker.Get(p.Port, false)
+	wantInterval := 5 + int64(healthchecks.DefaultHealthCheckInterval.Seconds())
+	if hc.Type != healthchecks.GRPCType || hc.GRPCServiceName != "foo" || hc.Port != p.Port || hc.TimeoutSec != 2 || hc.CheckIntervalSec != wantInterval {
+		t.Errorf("got health check %+v, want a gRPC health check of service foo on port %v, timeout 2 and interval %v", hc, p.Port, wantInterval)
+	}
+
+	// Only HTTP2 ports are checked over gRPC.
+	p = ServicePort{Port: 8081, Protocol: utils.ProtocolHTTP}
+	pool.prober.(*FakeProbeProvider).GRPCProbes[p] = &GRPCProbe{}
+	if err := pool.Ensure([]ServicePort{p}, nil); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if hc, _ = pool.healthChecker.Get(p.Port, false); hc.Type != string(utils.ProtocolHTTP) {
+		t.Errorf("got health check type %v, want HTTP", hc.Type)
+	}
+}
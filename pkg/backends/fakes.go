@@ -17,7 +17,9 @@ limitations under the License.
 package backends
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 
 	computealpha "google.golang.org/api/compute/v0.alpha"
 	compute "google.golang.org/api/compute/v1"
@@ -30,7 +32,10 @@ import (
 // NewFakeBackendServices creates a new fake backend services manager.
 func NewFakeBackendServices(ef func(op int, be *compute.BackendService) error) *FakeBackendServices {
 	return &FakeBackendServices{
-		errFunc: ef,
+		errFunc:              ef,
+		customRequestHeaders: map[string][]string{},
+		alphaBackends:        map[string][]*computealpha.Backend{},
+		healthStates:         map[string]string{},
 		backendServices: cache.NewStore(func(obj interface{}) (string, error) {
 			svc := obj.(*compute.BackendService)
 			return svc.Name, nil
@@ -43,6 +48,21 @@ type FakeBackendServices struct {
 	backendServices cache.Store
 	calls           []int
 	errFunc         func(op int, be *compute.BackendService) error
+	// customRequestHeaders are only visible through the alpha API, and are
+	// dropped by v1 updates like in GCE.
+	customRequestHeaders map[string][]string
+	// alphaBackends keep the NEG capacity settings, which the v1 API does not
+	// know about, until the next v1 update.
+	alphaBackends map[string][]*computealpha.Backend
+	// healthStates are the health states of the endpoints of backend
+	// services, HEALTHY if unset.
+	healthStates map[string]string
+}
+
+// SetHealthState sets the health state of the endpoints of the backend
+// service with the given name.
+func (f *FakeBackendServices) SetHealthState(name, state string) {
+	f.healthStates[name] = state
 }
 
 // GetGlobalBackendService fakes getting a backend service from the cloud.
@@ -68,7 +88,12 @@ func (f *FakeBackendServices) GetAlphaGlobalBackendService(name string) (*comput
 	if err != nil {
 		return nil, err
 	}
-	return toAlphaBackendService(obj), nil
+	be := toAlphaBackendService(obj)
+	be.CustomRequestHeaders = f.customRequestHeaders[name]
+	if backends, ok := f.alphaBackends[name]; ok {
+		be.Backends = backends
+	}
+	return be, nil
 }
 
 // CreateGlobalBackendService fakes backend service creation.
@@ -80,6 +105,7 @@ func (f *FakeBackendServices) CreateGlobalBackendService(be *compute.BackendServ
 	}
 	f.calls = append(f.calls, utils.Create)
 	be.SelfLink = be.Name
+	hashIAPSecret(be)
 	return f.backendServices.Update(be)
 }
 
@@ -93,6 +119,8 @@ func (f *FakeBackendServices) DeleteGlobalBackendService(name string) error {
 	if err != nil {
 		return err
 	}
+	delete(f.customRequestHeaders, name)
+	delete(f.alphaBackends, name)
 	return f.backendServices.Delete(svc)
 }
 
@@ -114,12 +142,31 @@ func (f *FakeBackendServices) UpdateGlobalBackendService(be *compute.BackendServ
 		}
 	}
 	f.calls = append(f.calls, utils.Update)
+	hashIAPSecret(be)
+	delete(f.customRequestHeaders, be.Name)
+	delete(f.alphaBackends, be.Name)
 	return f.backendServices.Update(be)
 }
 
+// hashIAPSecret replaces the IAP OAuth client secret with its hash, like GCE.
+func hashIAPSecret(be *compute.BackendService) {
+	if be.Iap == nil || be.Iap.Oauth2ClientSecret == "" {
+		return
+	}
+	be.Iap.Oauth2ClientSecretSha256 = fmt.Sprintf("%x", sha256.Sum256([]byte(be.Iap.Oauth2ClientSecret)))
+	be.Iap.Oauth2ClientSecret = ""
+}
+
 // UpdateGlobalBackendService fakes updating a backend service.
 func (f *FakeBackendServices) UpdateAlphaGlobalBackendService(be *computealpha.BackendService) error {
-	return f.UpdateGlobalBackendService(toV1BackendService(be))
+	if err := f.UpdateGlobalBackendService(toV1BackendService(be)); err != nil {
+		return err
+	}
+	if len(be.CustomRequestHeaders) > 0 {
+		f.customRequestHeaders[be.Name] = be.CustomRequestHeaders
+	}
+	f.alphaBackends[be.Name] = be.Backends
+	return nil
 }
 
 // GetGlobalBackendServiceHealth fakes getting backend service health.
@@ -128,9 +175,13 @@ func (f *FakeBackendServices) GetGlobalBackendServiceHealth(name, instanceGroupL
 	if err != nil {
 		return nil, err
 	}
+	state, ok := f.healthStates[name]
+	if !ok {
+		state = "HEALTHY"
+	}
 	states := []*compute.HealthStatus{
 		{
-			HealthState: "HEALTHY",
+			HealthState: state,
 			IpAddress:   "",
 			Port:        be.Port,
 		},
@@ -142,11 +193,13 @@ func (f *FakeBackendServices) GetGlobalBackendServiceHealth(name, instanceGroupL
 // FakeProbeProvider implements the probeProvider interface for tests.
 type FakeProbeProvider struct {
 	probes map[ServicePort]*api_v1.Probe
+	// GRPCProbes are the gRPC readiness probes of the ports.
+	GRPCProbes map[ServicePort]*GRPCProbe
 }
 
 // NewFakeProbeProvider returns a struct which satisfies probeProvider interface
 func NewFakeProbeProvider(probes map[ServicePort]*api_v1.Probe) *FakeProbeProvider {
-	return &FakeProbeProvider{probes}
+	return &FakeProbeProvider{probes: probes, GRPCProbes: map[ServicePort]*GRPCProbe{}}
 }
 
 // GetProbe returns the probe for a given nodePort
@@ -157,6 +210,11 @@ func (pp *FakeProbeProvider) GetProbe(port ServicePort) (*api_v1.Probe, error) {
 	return nil, nil
 }
 
+// GetGRPCProbe returns the gRPC probe for a given nodePort
+func (pp *FakeProbeProvider) GetGRPCProbe(port ServicePort) (*GRPCProbe, error) {
+	return pp.GRPCProbes[port], nil
+}
+
 func toV1BackendService(be *computealpha.BackendService) *compute.BackendService {
 	bytes, _ := be.MarshalJSON()
 	res := &compute.BackendService{}
@@ -170,3 +228,161 @@ func toAlphaBackendService(be *compute.BackendService) *computealpha.BackendServ
 	json.Unmarshal(bytes, res)
 	return res
 }
+
+// NewFakeSecurityPolicies returns fake security policies with the given
+// existing policy names.
+func NewFakeSecurityPolicies(names ...string) *FakeSecurityPolicies {
+	f := &FakeSecurityPolicies{
+		policies: map[string]*SecurityPolicy{},
+		attached: map[string]string{},
+	}
+	for _, name := range names {
+		f.policies[name] = &SecurityPolicy{Name: name, SelfLink: "global/securityPolicies/" + name}
+	}
+	return f
+}
+
+// FakeSecurityPolicies fakes out GCE security policies.
+type FakeSecurityPolicies struct {
+	policies map[string]*SecurityPolicy
+	// attached maps backend service names to security policy links.
+	attached map[string]string
+}
+
+// GetSecurityPolicy fakes getting a security policy.
+func (f *FakeSecurityPolicies) GetSecurityPolicy(name string) (*SecurityPolicy, error) {
+	policy, ok := f.policies[name]
+	if !ok {
+		return nil, utils.FakeGoogleAPINotFoundErr()
+	}
+	return policy, nil
+}
+
+// GetBackendServiceSecurityPolicy fakes getting the security policy of a
+// backend service.
+func (f *FakeSecurityPolicies) GetBackendServiceSecurityPolicy(backendService string) (string, error) {
+	return f.attached[backendService], nil
+}
+
+// SetBackendServiceSecurityPolicy fakes setting the security policy of a
+// backend service.
+func (f *FakeSecurityPolicies) SetBackendServiceSecurityPolicy(backendService, policyLink string) error {
+	if policyLink == "" {
+		delete(f.attached, backendService)
+		return nil
+	}
+	f.attached[backendService] = policyLink
+	return nil
+}
+
+// NewFakeExtendedBackendServices returns fake extended backend services.
+func NewFakeExtendedBackendServices() *FakeExtendedBackendServices {
+	return &FakeExtendedBackendServices{
+		BackendServices: map[string]*BackendService{},
+		NEGs:            map[string]*NetworkEndpointGroup{},
+		Endpoints:       map[string][]*NetworkEndpoint{},
+	}
+}
+
+// FakeExtendedBackendServices fakes out the fields of the GCE backend
+// services, and the global NEGs, managed through the REST API.
+type FakeExtendedBackendServices struct {
+	BackendServices map[string]*BackendService
+	// Patches counts the patches of the backend services.
+	Patches int
+	NEGs    map[string]*NetworkEndpointGroup
+	// Endpoints are the endpoints of the NEGs by NEG name.
+	Endpoints map[string][]*NetworkEndpoint
+}
+
+// GetExtendedBackendService fakes getting a backend service. The backend
+// services are owned by FakeBackendServices, so all exist here.
+func (f *FakeExtendedBackendServices) GetExtendedBackendService(name string) (*BackendService, error) {
+	be, ok := f.BackendServices[name]
+	if !ok {
+		return &BackendService{Name: name}, nil
+	}
+	res := *be
+	return &res, nil
+}
+
+// PatchExtendedBackendService fakes patching a backend service, decoding the
+// JSON patch into it.
+func (f *FakeExtendedBackendServices) PatchExtendedBackendService(name string, patch map[string]interface{}) error {
+	be, _ := f.GetExtendedBackendService(name)
+	bytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes, be); err != nil {
+		return err
+	}
+	f.BackendServices[name] = be
+	f.Patches++
+	return nil
+}
+
+// GetGlobalNetworkEndpointGroup fakes getting a global NEG.
+func (f *FakeExtendedBackendServices) GetGlobalNetworkEndpointGroup(name string) (*NetworkEndpointGroup, error) {
+	neg, ok := f.NEGs[name]
+	if !ok {
+		return nil, utils.FakeGoogleAPINotFoundErr()
+	}
+	return neg, nil
+}
+
+// CreateGlobalNetworkEndpointGroup fakes creating a global NEG.
+func (f *FakeExtendedBackendServices) CreateGlobalNetworkEndpointGroup(neg *NetworkEndpointGroup) error {
+	if _, ok := f.NEGs[neg.Name]; ok {
+		return fmt.Errorf("network endpoint group %v already exists", neg.Name)
+	}
+	neg.SelfLink = "https://www.googleapis.com/compute/v1/projects/p/global/networkEndpointGroups/" + neg.Name
+	f.NEGs[neg.Name] = neg
+	return nil
+}
+
+// DeleteGlobalNetworkEndpointGroup fakes deleting a global NEG.
+func (f *FakeExtendedBackendServices) DeleteGlobalNetworkEndpointGroup(name string) error {
+	if _, ok := f.NEGs[name]; !ok {
+		return utils.FakeGoogleAPINotFoundErr()
+	}
+	delete(f.NEGs, name)
+	delete(f.Endpoints, name)
+	return nil
+}
+
+// ListGlobalNetworkEndpoints fakes listing the endpoints of a global NEG.
+func (f *FakeExtendedBackendServices) ListGlobalNetworkEndpoints(neg string) ([]*NetworkEndpoint, error) {
+	if _, ok := f.NEGs[neg]; !ok {
+		return nil, utils.FakeGoogleAPINotFoundErr()
+	}
+	return f.Endpoints[neg], nil
+}
+
+// AttachGlobalNetworkEndpoints fakes attaching endpoints to a global NEG.
+func (f *FakeExtendedBackendServices) AttachGlobalNetworkEndpoints(neg string, endpoints []*NetworkEndpoint) error {
+	if _, ok := f.NEGs[neg]; !ok {
+		return utils.FakeGoogleAPINotFoundErr()
+	}
+	f.Endpoints[neg] = append(f.Endpoints[neg], endpoints...)
+	return nil
+}
+
+// DetachGlobalNetworkEndpoints fakes detaching endpoints from a global NEG.
+func (f *FakeExtendedBackendServices) DetachGlobalNetworkEndpoints(neg string, endpoints []*NetworkEndpoint) error {
+	if _, ok := f.NEGs[neg]; !ok {
+		return utils.FakeGoogleAPINotFoundErr()
+	}
+	kept := []*NetworkEndpoint{}
+	for _, ep := range f.Endpoints[neg] {
+		detached := false
+		for _, d := range endpoints {
+			detached = detached || *d == *ep
+		}
+		if !detached {
+			kept = append(kept, ep)
+		}
+	}
+	f.Endpoints[neg] = kept
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backends
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	compute "google.golang.org/api/compute/v1"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/backendconfig"
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// applyBackendConfig applies the features configured by the BackendConfig of
+// the given port to the backend service. Features the BackendConfig does not
+// set are left untouched. Returns true if the backend service was changed.
+func applyBackendConfig(be *compute.BackendService, sp ServicePort) bool {
+	if sp.BackendConfig == nil {
+		return false
+	}
+	// Apply all features, even if an earlier one changed the backend.
+	changed := applyCDN(be, sp.BackendConfig.Spec.Cdn)
+	changed = applyIAP(be, sp.BackendConfig.Spec.Iap) || changed
+	changed = applySessionAffinity(be, sp.BackendConfig.Spec.SessionAffinity) || changed
+	return changed
+}
+
+// applyCDN applies the given Cloud CDN configuration to the backend service.
+func applyCDN(be *compute.BackendService, cdn *backendconfig.CDNConfig) bool {
+	if cdn == nil {
+		return false
+	}
+	if !cdn.Enabled {
+		// The cache key policy of a disabled CDN is irrelevant, keep it.
+		if !be.EnableCDN {
+			return false
+		}
+		be.EnableCDN = false
+		be.ForceSendFields = append(be.ForceSendFields, "EnableCDN")
+		return true
+	}
+
+	desired := cacheKeyPolicy(cdn.CachePolicy)
+	var existing *compute.CacheKeyPolicy
+	if be.CdnPolicy != nil {
+		existing = be.CdnPolicy.CacheKeyPolicy
+	}
+	if be.EnableCDN && cacheKeyPolicyEqual(existing, desired) {
+		return false
+	}
+	be.EnableCDN = true
+	if be.CdnPolicy == nil {
+		be.CdnPolicy = &compute.BackendServiceCdnPolicy{}
+	}
+	be.CdnPolicy.CacheKeyPolicy = desired
+	return true
+}
+
+// applyIAP applies the given Identity-Aware Proxy configuration to the
+// backend service. The OAuth client secret is compared through its hash, since
+// GCE does not return the secret itself. Changes made outside of the
+// controller, eg: disabling IAP in the console, are reverted.
+func applyIAP(be *compute.BackendService, iap *backendconfig.IAPConfig) bool {
+	if iap == nil {
+		return false
+	}
+	if !iap.Enabled {
+		if be.Iap == nil || !be.Iap.Enabled {
+			return false
+		}
+		// Keep the OAuth client, GCE requires it even with IAP disabled.
+		be.Iap.Enabled = false
+		be.Iap.ForceSendFields = append(be.Iap.ForceSendFields, "Enabled")
+		return true
+	}
+
+	creds := iap.OAuthClientCredentials
+	secretHash := fmt.Sprintf("%x", sha256.Sum256([]byte(creds.ClientSecret)))
+	if be.Iap != nil && be.Iap.Enabled && be.Iap.Oauth2ClientId == creds.ClientID && be.Iap.Oauth2ClientSecretSha256 == secretHash {
+		return false
+	}
+	if be.Iap != nil && !be.Iap.Enabled {
+		logging.Infof("IAP was disabled on backend service %v, enabling it", be.Name)
+	}
+	be.Iap = &compute.BackendServiceIAP{
+		Enabled:            true,
+		Oauth2ClientId:     creds.ClientID,
+		Oauth2ClientSecret: creds.ClientSecret,
+	}
+	return true
+}
+
+// applySessionAffinity applies the given session affinity configuration to the
+// backend service.
+func applySessionAffinity(be *compute.BackendService, affinity *backendconfig.SessionAffinityConfig) bool {
+	if affinity == nil {
+		return false
+	}
+	changed := false
+	if affinityType := affinity.AffinityType; affinityType != "" && be.SessionAffinity != affinityType {
+		be.SessionAffinity = affinityType
+		changed = true
+	}
+	if ttl := affinity.AffinityCookieTtlSec; ttl != nil && be.AffinityCookieTtlSec != *ttl {
+		be.AffinityCookieTtlSec = *ttl
+		be.ForceSendFields = append(be.ForceSendFields, "AffinityCookieTtlSec")
+		changed = true
+	}
+	return changed
+}
+
+// cacheKeyPolicy returns the GCE cache key policy for the given policy. The
+// GCE default, the whole URL, is used if the policy is nil.
+func cacheKeyPolicy(policy *backendconfig.CacheKeyPolicy) *compute.CacheKeyPolicy {
+	if policy == nil {
+		return &compute.CacheKeyPolicy{IncludeHost: true, IncludeProtocol: true, IncludeQueryString: true}
+	}
+	return &compute.CacheKeyPolicy{
+		IncludeHost:          policy.IncludeHost,
+		IncludeProtocol:      policy.IncludeProtocol,
+		IncludeQueryString:   policy.IncludeQueryString,
+		QueryStringBlacklist: policy.QueryStringBlacklist,
+		QueryStringWhitelist: policy.QueryStringWhitelist,
+		// Send the booleans even if false, GCE defaults them to true.
+		ForceSendFields: []string{"IncludeHost", "IncludeProtocol", "IncludeQueryString"},
+	}
+}
+
+// cacheKeyPolicyEqual returns true if the given cache key policies are
+// equivalent. A nil policy is the GCE default.
+func cacheKeyPolicyEqual(a, b *compute.CacheKeyPolicy) bool {
+	if a == nil {
+		a = cacheKeyPolicy(nil)
+	}
+	if b == nil {
+		b = cacheKeyPolicy(nil)
+	}
+	return a.IncludeHost == b.IncludeHost &&
+		a.IncludeProtocol == b.IncludeProtocol &&
+		a.IncludeQueryString == b.IncludeQueryString &&
+		sets.NewString(a.QueryStringBlacklist...).Equal(sets.NewString(b.QueryStringBlacklist...)) &&
+		sets.NewString(a.QueryStringWhitelist...).Equal(sets.NewString(b.QueryStringWhitelist...))
+}
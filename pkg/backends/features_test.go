@@ -0,0 +1,216 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backends
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+
+	"k8s.io/ingress-gce/pkg/backendconfig"
+)
+
+func TestApplyCDN(t *testing.T) {
+	defaultPolicy := &compute.CacheKeyPolicy{IncludeHost: true, IncludeProtocol: true, IncludeQueryString: true}
+	testCases := []struct {
+		desc        string
+		be          *compute.BackendService
+		cdn         *backendconfig.CDNConfig
+		wantChanged bool
+		wantEnabled bool
+		wantPolicy  *compute.CacheKeyPolicy
+	}{
+		{
+			desc: "cdn not configured",
+			be:   &compute.BackendService{EnableCDN: true},
+			// Left untouched.
+			wantEnabled: true,
+		},
+		{
+			desc:        "enable with default policy",
+			be:          &compute.BackendService{},
+			cdn:         &backendconfig.CDNConfig{Enabled: true},
+			wantChanged: true,
+			wantEnabled: true,
+			wantPolicy:  defaultPolicy,
+		},
+		{
+			desc:        "already enabled with default policy",
+			be:          &compute.BackendService{EnableCDN: true},
+			cdn:         &backendconfig.CDNConfig{Enabled: true},
+			wantEnabled: true,
+		},
+		{
+			desc: "update cache key policy",
+			be: &compute.BackendService{EnableCDN: true, CdnPolicy: &compute.BackendServiceCdnPolicy{
+				CacheKeyPolicy: defaultPolicy,
+			}},
+			cdn: &backendconfig.CDNConfig{Enabled: true, CachePolicy: &backendconfig.CacheKeyPolicy{
+				IncludeHost:          true,
+				IncludeQueryString:   true,
+				QueryStringWhitelist: []string{"q"},
+			}},
+			wantChanged: true,
+			wantEnabled: true,
+			wantPolicy:  &compute.CacheKeyPolicy{IncludeHost: true, IncludeQueryString: true, QueryStringWhitelist: []string{"q"}},
+		},
+		{
+			desc:        "disable",
+			be:          &compute.BackendService{EnableCDN: true},
+			cdn:         &backendconfig.CDNConfig{Enabled: false},
+			wantChanged: true,
+		},
+		{
+			desc: "already disabled",
+			be:   &compute.BackendService{},
+			cdn:  &backendconfig.CDNConfig{Enabled: false},
+		},
+	}
+	for _, tc := range testCases {
+		changed := applyCDN(tc.be, tc.cdn)
+		if changed != tc.wantChanged {
+			t.Errorf("%s: applyCDN() = %v, want %v", tc.desc, changed, tc.wantChanged)
+		}
+		if tc.be.EnableCDN != tc.wantEnabled {
+			t.Errorf("%s: EnableCDN = %v, want %v", tc.desc, tc.be.EnableCDN, tc.wantEnabled)
+		}
+		if tc.wantPolicy != nil && (tc.be.CdnPolicy == nil || !cacheKeyPolicyEqual(tc.be.CdnPolicy.CacheKeyPolicy, tc.wantPolicy)) {
+			t.Errorf("%s: CdnPolicy = %+v, want cache key policy %+v", tc.desc, tc.be.CdnPolicy, tc.wantPolicy)
+		}
+	}
+}
+
+func TestApplyIAP(t *testing.T) {
+	creds := &backendconfig.OAuthClientCredentials{SecretName: "iap", ClientID: "id", ClientSecret: "secret"}
+	secretHash := fmt.Sprintf("%x", sha256.Sum256([]byte("secret")))
+	testCases := []struct {
+		desc        string
+		be          *compute.BackendService
+		iap         *backendconfig.IAPConfig
+		wantChanged bool
+		wantEnabled bool
+	}{
+		{
+			desc: "iap not configured",
+			be:   &compute.BackendService{},
+		},
+		{
+			desc:        "enable",
+			be:          &compute.BackendService{},
+			iap:         &backendconfig.IAPConfig{Enabled: true, OAuthClientCredentials: creds},
+			wantChanged: true,
+			wantEnabled: true,
+		},
+		{
+			desc:        "already enabled",
+			be:          &compute.BackendService{Iap: &compute.BackendServiceIAP{Enabled: true, Oauth2ClientId: "id", Oauth2ClientSecretSha256: secretHash}},
+			iap:         &backendconfig.IAPConfig{Enabled: true, OAuthClientCredentials: creds},
+			wantEnabled: true,
+		},
+		{
+			desc:        "secret rotated",
+			be:          &compute.BackendService{Iap: &compute.BackendServiceIAP{Enabled: true, Oauth2ClientId: "id", Oauth2ClientSecretSha256: "old"}},
+			iap:         &backendconfig.IAPConfig{Enabled: true, OAuthClientCredentials: creds},
+			wantChanged: true,
+			wantEnabled: true,
+		},
+		{
+			desc:        "disabled outside of the controller",
+			be:          &compute.BackendService{Iap: &compute.BackendServiceIAP{Enabled: false, Oauth2ClientId: "id", Oauth2ClientSecretSha256: secretHash}},
+			iap:         &backendconfig.IAPConfig{Enabled: true, OAuthClientCredentials: creds},
+			wantChanged: true,
+			wantEnabled: true,
+		},
+		{
+			desc:        "disable",
+			be:          &compute.BackendService{Iap: &compute.BackendServiceIAP{Enabled: true, Oauth2ClientId: "id", Oauth2ClientSecretSha256: secretHash}},
+			iap:         &backendconfig.IAPConfig{Enabled: false},
+			wantChanged: true,
+		},
+	}
+	for _, tc := range testCases {
+		changed := applyIAP(tc.be, tc.iap)
+		if changed != tc.wantChanged {
+			t.Errorf("%s: applyIAP() = %v, want %v", tc.desc, changed, tc.wantChanged)
+		}
+		if enabled := tc.be.Iap != nil && tc.be.Iap.Enabled; enabled != tc.wantEnabled {
+			t.Errorf("%s: IAP enabled = %v, want %v", tc.desc, enabled, tc.wantEnabled)
+		}
+		if tc.wantChanged && tc.wantEnabled && (tc.be.Iap.Oauth2ClientId != "id" || tc.be.Iap.Oauth2ClientSecret != "secret") {
+			t.Errorf("%s: IAP = %+v, want the OAuth client from the BackendConfig", tc.desc, tc.be.Iap)
+		}
+	}
+}
+
+func TestApplySessionAffinity(t *testing.T) {
+	ttl := int64(60)
+	zero := int64(0)
+	testCases := []struct {
+		desc        string
+		be          *compute.BackendService
+		affinity    *backendconfig.SessionAffinityConfig
+		wantChanged bool
+		wantType    string
+		wantTTL     int64
+	}{
+		{
+			desc:     "affinity not configured",
+			be:       &compute.BackendService{SessionAffinity: "CLIENT_IP"},
+			wantType: "CLIENT_IP",
+		},
+		{
+			desc:        "generated cookie with ttl",
+			be:          &compute.BackendService{SessionAffinity: "NONE"},
+			affinity:    &backendconfig.SessionAffinityConfig{AffinityType: "GENERATED_COOKIE", AffinityCookieTtlSec: &ttl},
+			wantChanged: true,
+			wantType:    "GENERATED_COOKIE",
+			wantTTL:     60,
+		},
+		{
+			desc:     "unchanged",
+			be:       &compute.BackendService{SessionAffinity: "GENERATED_COOKIE", AffinityCookieTtlSec: 60},
+			affinity: &backendconfig.SessionAffinityConfig{AffinityType: "GENERATED_COOKIE", AffinityCookieTtlSec: &ttl},
+			wantType: "GENERATED_COOKIE",
+			wantTTL:  60,
+		},
+		{
+			desc:        "reset ttl",
+			be:          &compute.BackendService{SessionAffinity: "GENERATED_COOKIE", AffinityCookieTtlSec: 60},
+			affinity:    &backendconfig.SessionAffinityConfig{AffinityCookieTtlSec: &zero},
+			wantChanged: true,
+			wantType:    "GENERATED_COOKIE",
+		},
+		{
+			desc:        "client ip",
+			be:          &compute.BackendService{SessionAffinity: "NONE"},
+			affinity:    &backendconfig.SessionAffinityConfig{AffinityType: "CLIENT_IP"},
+			wantChanged: true,
+			wantType:    "CLIENT_IP",
+		},
+	}
+	for _, tc := range testCases {
+		changed := applySessionAffinity(tc.be, tc.affinity)
+		if changed != tc.wantChanged {
+			t.Errorf("%s: applySessionAffinity() = %v, want %v", tc.desc, changed, tc.wantChanged)
+		}
+		if tc.be.SessionAffinity != tc.wantType || tc.be.AffinityCookieTtlSec != tc.wantTTL {
+			t.Errorf("%s: got affinity %v with ttl %v, want %v with ttl %v", tc.desc, tc.be.SessionAffinity, tc.be.AffinityCookieTtlSec, tc.wantType, tc.wantTTL)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backends
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// ProjectProvider is the part of the cloud provider that knows about the
+// project of the cluster.
+type ProjectProvider interface {
+	ProjectID() string
+}
+
+// BackendService is the part of a global backend service which the vendored
+// compute API predates, managed through the REST API.
+type BackendService struct {
+	Name      string                   `json:"name"`
+	LogConfig *BackendServiceLogConfig `json:"logConfig,omitempty"`
+	// CustomResponseHeaders are formatted as "name:value".
+	CustomResponseHeaders []string `json:"customResponseHeaders,omitempty"`
+	// SecurityPolicy is the link of the attached Cloud Armor security
+	// policy, empty if none.
+	SecurityPolicy string `json:"securityPolicy,omitempty"`
+	// CdnPolicy only holds the Cloud CDN settings the vendored API lacks.
+	CdnPolicy   *BackendServiceCdnPolicy `json:"cdnPolicy,omitempty"`
+	Fingerprint string                   `json:"fingerprint,omitempty"`
+}
+
+// BackendServiceCdnPolicy is the negative caching and serve-while-stale of a
+// Cloud CDN enabled backend service.
+type BackendServiceCdnPolicy struct {
+	NegativeCaching       bool                                   `json:"negativeCaching"`
+	NegativeCachingPolicy []*BackendServiceNegativeCachingPolicy `json:"negativeCachingPolicy,omitempty"`
+	ServeWhileStale       int64                                  `json:"serveWhileStale"`
+}
+
+// BackendServiceNegativeCachingPolicy is the TTL, in seconds, of the cached
+// error responses with the given status code.
+type BackendServiceNegativeCachingPolicy struct {
+	Code int64 `json:"code"`
+	Ttl  int64 `json:"ttl"`
+}
+
+// BackendServiceLogConfig is the request logging of a backend service.
+type BackendServiceLogConfig struct {
+	Enable bool `json:"enable"`
+	// SampleRate is the fraction of the requests logged, 1 if nil.
+	SampleRate *float64 `json:"sampleRate,omitempty"`
+}
+
+// SecurityPolicy is a Cloud Armor security policy, which the vendored compute
+// API predates.
+type SecurityPolicy struct {
+	Name     string `json:"name"`
+	SelfLink string `json:"selfLink,omitempty"`
+}
+
+// NetworkEndpointGroup is a global NEG, eg: an internet NEG. The vendored
+// compute API predates the global NEGs, so they are managed through the REST
+// API.
+type NetworkEndpointGroup struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// NetworkEndpointType is INTERNET_FQDN_PORT or INTERNET_IP_PORT for
+	// internet NEGs.
+	NetworkEndpointType string `json:"networkEndpointType,omitempty"`
+	DefaultPort         int64  `json:"defaultPort,omitempty"`
+	SelfLink            string `json:"selfLink,omitempty"`
+}
+
+// NetworkEndpoint is an endpoint of a global NEG.
+type NetworkEndpoint struct {
+	Fqdn      string `json:"fqdn,omitempty"`
+	IpAddress string `json:"ipAddress,omitempty"`
+	Port      int64  `json:"port,omitempty"`
+}
+
+// gceSecurityPolicies implements SecurityPolicies through the REST API.
+type gceSecurityPolicies struct {
+	rest *utils.ComputeREST
+}
+
+// NewGCESecurityPolicies returns a SecurityPolicies that attaches the policies
+// of the project through the REST API. tokenSource, transport and apiEndpoint
+// are those of utils.NewComputeREST.
+// project: the cloud provider, used for the project of the cluster.
+func NewGCESecurityPolicies(project ProjectProvider, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (SecurityPolicies, error) {
+	rest, err := utils.NewComputeREST(project.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceSecurityPolicies{rest: rest}, nil
+}
+
+// GetSecurityPolicy returns the security policy with the given name.
+func (g *gceSecurityPolicies) GetSecurityPolicy(name string) (*SecurityPolicy, error) {
+	policy := &SecurityPolicy{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("securityPolicies", name), nil, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// GetBackendServiceSecurityPolicy returns the link of the security policy
+// attached to the given backend service, empty if none.
+func (g *gceSecurityPolicies) GetBackendServiceSecurityPolicy(backendService string) (string, error) {
+	be := &BackendService{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("backendServices", backendService), nil, be); err != nil {
+		return "", err
+	}
+	return be.SecurityPolicy, nil
+}
+
+// SetBackendServiceSecurityPolicy attaches the security policy with the given
+// link to the backend service. An empty link detaches the current policy.
+func (g *gceSecurityPolicies) SetBackendServiceSecurityPolicy(backendService, policyLink string) error {
+	req := map[string]interface{}{"securityPolicy": nil}
+	if policyLink != "" {
+		req["securityPolicy"] = policyLink
+	}
+	return g.rest.DoOp("POST", g.rest.GlobalURL("backendServices", backendService)+"/setSecurityPolicy", req)
+}
+
+// gceExtendedBackendServices implements ExtendedBackendServices through the
+// REST API.
+type gceExtendedBackendServices struct {
+	rest *utils.ComputeREST
+}
+
+// NewGCEExtendedBackendServices returns an ExtendedBackendServices managing
+// the backend services of the project through the REST API. tokenSource,
+// transport and apiEndpoint are those of utils.NewComputeREST.
+// project: the cloud provider, used for the project of the cluster.
+func NewGCEExtendedBackendServices(project ProjectProvider, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (ExtendedBackendServices, error) {
+	rest, err := utils.NewComputeREST(project.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceExtendedBackendServices{rest: rest}, nil
+}
+
+// GetExtendedBackendService returns the given backend service.
+func (g *gceExtendedBackendServices) GetExtendedBackendService(name string) (*BackendService, error) {
+	be := &BackendService{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("backendServices", name), nil, be); err != nil {
+		return nil, err
+	}
+	return be, nil
+}
+
+// PatchExtendedBackendService patches the given fields of the backend
+// service, and waits for it to be patched.
+func (g *gceExtendedBackendServices) PatchExtendedBackendService(name string, patch map[string]interface{}) error {
+	return g.rest.DoOp("PATCH", g.rest.GlobalURL("backendServices", name), patch)
+}
+
+// GetGlobalNetworkEndpointGroup returns the given global NEG.
+func (g *gceExtendedBackendServices) GetGlobalNetworkEndpointGroup(name string) (*NetworkEndpointGroup, error) {
+	neg := &NetworkEndpointGroup{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("networkEndpointGroups", name), nil, neg); err != nil {
+		return nil, err
+	}
+	return neg, nil
+}
+
+// CreateGlobalNetworkEndpointGroup creates the given global NEG, and waits
+// for it to be created.
+func (g *gceExtendedBackendServices) CreateGlobalNetworkEndpointGroup(neg *NetworkEndpointGroup) error {
+	return g.rest.DoOp("POST", g.rest.GlobalURL("networkEndpointGroups", ""), neg)
+}
+
+// DeleteGlobalNetworkEndpointGroup deletes the given global NEG, and waits
+// for it to be deleted.
+func (g *gceExtendedBackendServices) DeleteGlobalNetworkEndpointGroup(name string) error {
+	return g.rest.DoOp("DELETE", g.rest.GlobalURL("networkEndpointGroups", name), nil)
+}
+
+// ListGlobalNetworkEndpoints returns the endpoints of the given global NEG.
+func (g *gceExtendedBackendServices) ListGlobalNetworkEndpoints(neg string) ([]*NetworkEndpoint, error) {
+	endpoints := []*NetworkEndpoint{}
+	token := ""
+	for {
+		u := g.rest.GlobalURL("networkEndpointGroups", neg) + "/listNetworkEndpoints"
+		if token != "" {
+			u += "?pageToken=" + url.QueryEscape(token)
+		}
+		page := struct {
+			Items []struct {
+				NetworkEndpoint *NetworkEndpoint `json:"networkEndpoint"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		if err := g.rest.Do("POST", u, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			endpoints = append(endpoints, item.NetworkEndpoint)
+		}
+		if token = page.NextPageToken; token == "" {
+			return endpoints, nil
+		}
+	}
+}
+
+// AttachGlobalNetworkEndpoints attaches the given endpoints to the global
+// NEG, and waits for them to be attached.
+func (g *gceExtendedBackendServices) AttachGlobalNetworkEndpoints(neg string, endpoints []*NetworkEndpoint) error {
+	req := map[string]interface{}{"networkEndpoints": endpoints}
+	return g.rest.DoOp("POST", g.rest.GlobalURL("networkEndpointGroups", neg)+"/attachNetworkEndpoints", req)
+}
+
+// DetachGlobalNetworkEndpoints detaches the given endpoints from the global
+// NEG, and waits for them to be detached.
+func (g *gceExtendedBackendServices) DetachGlobalNetworkEndpoints(neg string, endpoints []*NetworkEndpoint) error {
+	req := map[string]interface{}{"networkEndpoints": endpoints}
+	return g.rest.DoOp("POST", g.rest.GlobalURL("networkEndpointGroups", neg)+"/detachNetworkEndpoints", req)
+}
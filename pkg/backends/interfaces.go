@@ -25,6 +25,9 @@ import (
 // ProbeProvider retrieves a probe struct given a nodePort
 type probeProvider interface {
 	GetProbe(sp ServicePort) (*api_v1.Probe, error)
+	// GetGRPCProbe returns the gRPC readiness probe of the given port, nil if
+	// none.
+	GetGRPCProbe(sp ServicePort) (*GRPCProbe, error)
 }
 
 // BackendPool is an interface to manage a pool of kubernetes nodePort services
@@ -33,12 +36,24 @@ type BackendPool interface {
 	Init(p probeProvider)
 	Ensure(ports []ServicePort, igs []*compute.InstanceGroup) error
 	Get(port int64) (*compute.BackendService, error)
+	// GetServicePort returns the backend of the given ServicePort, which may
+	// have a name requested by its BackendConfig.
+	GetServicePort(sp ServicePort) (*compute.BackendService, error)
 	Delete(port int64) error
 	GC(ports []ServicePort) error
 	Shutdown() error
 	Status(name string) string
+	// Health returns the aggregate health of the endpoints of the backend
+	// service with the given name.
+	Health(name string) (*BackendHealth, error)
 	List() ([]interface{}, error)
 	Link(port ServicePort, zones []string) error
+	// SetDisruptiveMigrations allows the backend services which GCE can't
+	// switch between balancing modes in place, eg: from instance groups to
+	// NEGs, to be switched by removing their backends before adding the new
+	// ones. They serve no traffic in between. Otherwise they keep their
+	// backends, and their syncs fail.
+	SetDisruptiveMigrations(allow bool)
 }
 
 // BackendServices is an interface for managing gce backend services.
@@ -53,6 +68,30 @@ type BackendServices interface {
 	GetGlobalBackendServiceHealth(name, instanceGroupLink string) (*compute.BackendServiceGroupHealth, error)
 }
 
+// SecurityPolicies is an interface for attaching Cloud Armor security
+// policies to backend services.
+type SecurityPolicies interface {
+	GetSecurityPolicy(name string) (*SecurityPolicy, error)
+	GetBackendServiceSecurityPolicy(backendService string) (string, error)
+	SetBackendServiceSecurityPolicy(backendService, policyLink string) error
+}
+
+// ExtendedBackendServices is an interface for the fields of the global
+// backend services, and the global NEGs backing them, which the vendored
+// compute API predates.
+type ExtendedBackendServices interface {
+	GetExtendedBackendService(name string) (*BackendService, error)
+	// PatchExtendedBackendService patches the given fields of the backend
+	// service, with its current fingerprint.
+	PatchExtendedBackendService(name string, patch map[string]interface{}) error
+	GetGlobalNetworkEndpointGroup(name string) (*NetworkEndpointGroup, error)
+	CreateGlobalNetworkEndpointGroup(neg *NetworkEndpointGroup) error
+	DeleteGlobalNetworkEndpointGroup(name string) error
+	ListGlobalNetworkEndpoints(neg string) ([]*NetworkEndpoint, error)
+	AttachGlobalNetworkEndpoints(neg string, endpoints []*NetworkEndpoint) error
+	DetachGlobalNetworkEndpoints(neg string, endpoints []*NetworkEndpoint) error
+}
+
 // NEGGetter is an interface to retrieve NEG object
 type NEGGetter interface {
 	GetNetworkEndpointGroup(name string, zone string) (*computealpha.NetworkEndpointGroup, error)
@@ -0,0 +1,239 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backends
+
+import (
+	"fmt"
+	"strings"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	compute "google.golang.org/api/compute/v1"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/backendconfig"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// MultiClusterBackends registers the backends of the cluster, its instance
+// groups or NEGs, into the backend services of a config cluster. The config
+// cluster serves the Ingress through its own load balancer, with the same
+// Services and node ports, so that a single VIP fronts all the clusters. It
+// owns the url maps, backend services and health checks, the member clusters
+// only add and remove their own backends.
+type MultiClusterBackends struct {
+	cloud     BackendServices
+	negGetter NEGGetter
+	// namer names the resources of this cluster.
+	namer *utils.Namer
+	// configNamer names the backend services of the config cluster.
+	configNamer *utils.Namer
+	// registered are the backend services holding backends of this cluster,
+	// nil until they are listed from the cloud.
+	registered sets.String
+}
+
+// NewMultiClusterBackends returns the backends of the cluster of the given
+// namer in the backend services of the cluster with the given UID. Both
+// clusters must use the same resource name prefix.
+func NewMultiClusterBackends(cloud BackendServices, negGetter NEGGetter, namer *utils.Namer, configClusterUID string) *MultiClusterBackends {
+	return &MultiClusterBackends{
+		cloud:       cloud,
+		negGetter:   negGetter,
+		namer:       namer,
+		configNamer: utils.NewNamerWithPrefix(configClusterUID, "", namer.Prefix()),
+	}
+}
+
+// Register adds the given instance groups, or the NEGs in the given zones of
+// NEG enabled ports, to the backend services of the config cluster for the
+// given ports. The backends of other clusters are left untouched. Returns an
+// error if the config cluster didn't create a backend service yet.
+func (m *MultiClusterBackends) Register(svcPorts []ServicePort, igs []*compute.InstanceGroup, zones []string) error {
+	if err := m.list(); err != nil {
+		return err
+	}
+	for _, p := range svcPorts {
+		// Serverless and internet backends are not served by the cluster.
+		if !p.HasNodePort() {
+			continue
+		}
+		if err := m.register(p, igs, zones); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MultiClusterBackends) register(p ServicePort, igs []*compute.InstanceGroup, zones []string) error {
+	beName := p.BackendName(m.configNamer)
+	be, err := m.cloud.GetAlphaGlobalBackendService(beName)
+	if utils.IsNotFoundError(err) {
+		return fmt.Errorf("backend service %v of Service %v port %v does not exist, the config cluster must serve it with node port %v", beName, p.SvcName, p.SvcPort.String(), p.Port)
+	} else if err != nil {
+		return err
+	}
+
+	var wanted []*computealpha.Backend
+	if p.NEGEnabled {
+		negs, err := m.negs(p, zones)
+		if err != nil {
+			return err
+		}
+		var balancing *backendconfig.BalancingConfig
+		if p.BackendConfig != nil {
+			balancing = p.BackendConfig.Spec.Balancing
+		}
+		wanted = getBackendsForNEGs(negs, balancing)
+	} else {
+		wanted = igBackends(igs, be.Backends)
+	}
+
+	existing := sets.NewString()
+	for _, b := range be.Backends {
+		existing.Insert(groupKey(b.Group))
+	}
+	var added []string
+	for _, b := range wanted {
+		if !existing.Has(groupKey(b.Group)) {
+			be.Backends = append(be.Backends, b)
+			added = append(added, b.Group)
+		}
+	}
+	m.registered.Insert(beName)
+	if len(added) == 0 {
+		return nil
+	}
+	logging.V(2).Infof("Adding backends %v to backend service %v of the config cluster", added, beName)
+	if err := m.cloud.UpdateAlphaGlobalBackendService(be); err != nil {
+		return fmt.Errorf("failed to add backends to backend service %v of the config cluster: %v", beName, err)
+	}
+	return nil
+}
+
+// negs returns the NEGs of the given port in the given zones.
+func (m *MultiClusterBackends) negs(p ServicePort, zones []string) ([]*computealpha.NetworkEndpointGroup, error) {
+	if p.HybridNEG != nil {
+		zones = []string{p.HybridNEG.Zone}
+	}
+	negName := m.namer.NEG(p.SvcName.Namespace, p.SvcName.Name, p.SvcTargetPort)
+	var negs []*computealpha.NetworkEndpointGroup
+	for _, zone := range zones {
+		neg, err := m.negGetter.GetNetworkEndpointGroup(negName, zone)
+		if err != nil {
+			return nil, err
+		}
+		negs = append(negs, neg)
+	}
+	return negs, nil
+}
+
+// GC removes the backends of this cluster from the backend services of the
+// config cluster which are not used by the given ports anymore.
+func (m *MultiClusterBackends) GC(svcPorts []ServicePort) error {
+	if err := m.list(); err != nil {
+		return err
+	}
+	wanted := sets.NewString()
+	for _, p := range svcPorts {
+		wanted.Insert(p.BackendName(m.configNamer))
+	}
+	for _, beName := range m.registered.Difference(wanted).List() {
+		be, err := m.cloud.GetAlphaGlobalBackendService(beName)
+		if utils.IsNotFoundError(err) {
+			m.registered.Delete(beName)
+			continue
+		} else if err != nil {
+			return err
+		}
+		var kept []*computealpha.Backend
+		for _, b := range be.Backends {
+			if !m.owns(b.Group) {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) != len(be.Backends) {
+			logging.V(2).Infof("Removing the backends of the cluster from backend service %v of the config cluster", beName)
+			be.Backends = kept
+			be.ForceSendFields = append(be.ForceSendFields, "Backends")
+			if err := m.cloud.UpdateAlphaGlobalBackendService(be); err != nil {
+				return fmt.Errorf("failed to remove backends from backend service %v of the config cluster: %v", beName, err)
+			}
+		}
+		m.registered.Delete(beName)
+	}
+	return nil
+}
+
+// list finds the backend services of the config cluster holding backends of
+// this cluster, eg: registered before a restart, once.
+func (m *MultiClusterBackends) list() error {
+	if m.registered != nil {
+		return nil
+	}
+	list, err := m.cloud.ListGlobalBackendServices()
+	if err != nil {
+		return err
+	}
+	registered := sets.NewString()
+	for _, be := range list.Items {
+		for _, b := range be.Backends {
+			if m.owns(b.Group) {
+				registered.Insert(be.Name)
+				break
+			}
+		}
+	}
+	m.registered = registered
+	return nil
+}
+
+// owns returns true if the given instance group or NEG link is a backend of
+// this cluster.
+func (m *MultiClusterBackends) owns(group string) bool {
+	name := retrieveObjectName(group)
+	return name == m.namer.InstanceGroup() || m.namer.IsNEG(name)
+}
+
+// igBackends returns the backends of the given instance groups, with the
+// balancing mode of the existing instance group backends of the config
+// cluster, since GCE rejects mixed balancing modes.
+func igBackends(igs []*compute.InstanceGroup, existing []*computealpha.Backend) []*computealpha.Backend {
+	mode := Rate
+	if len(existing) > 0 && existing[0].BalancingMode != "" {
+		mode = BalancingMode(existing[0].BalancingMode)
+	}
+	var backends []*computealpha.Backend
+	for _, ig := range igs {
+		b := &computealpha.Backend{Group: ig.SelfLink, BalancingMode: string(mode)}
+		if mode == Rate {
+			b.MaxRatePerInstance = maxRPS
+		}
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+// groupKey returns the given instance group or NEG link without the API
+// version, which GCE sets to the version of the request.
+func groupKey(link string) string {
+	if i := strings.Index(link, "/projects/"); i >= 0 {
+		return link[i:]
+	}
+	return link
+}
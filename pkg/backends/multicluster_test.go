@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backends
+
+import (
+	"testing"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	compute "google.golang.org/api/compute/v1"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"k8s.io/ingress-gce/pkg/networkendpointgroup"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+func TestMultiClusterBackends(t *testing.T) {
+	configNamer := utils.NewNamer("config", "")
+	memberNamer := utils.NewNamer("member", "")
+	f := NewFakeBackendServices(noOpErrFunc)
+	fakeNEG := networkendpointgroup.NewFakeNetworkEndpointGroupCloud("test-subnetwork", "test-network")
+	mcb := NewMultiClusterBackends(f, fakeNEG, memberNamer, "config")
+
+	igPort := ServicePort{Port: 30001, Protocol: utils.ProtocolHTTP, SvcName: types.NamespacedName{Namespace: "ns", Name: "ig"}, SvcPort: intstr.FromInt(80)}
+	negPort := ServicePort{Port: 30002, Protocol: utils.ProtocolHTTP, SvcName: types.NamespacedName{Namespace: "ns", Name: "neg"}, SvcPort: intstr.FromInt(80), SvcTargetPort: "8080", NEGEnabled: true}
+	igs := []*compute.InstanceGroup{{Name: memberNamer.InstanceGroup(), SelfLink: memberNamer.InstanceGroup()}}
+	zones := []string{"zone1"}
+
+	// The config cluster didn't create the backend services yet.
+	if err := mcb.Register([]ServicePort{igPort}, igs, zones); err == nil {
+		t.Errorf("Expected an error registering into a missing backend service")
+	}
+
+	configIG := configNamer.InstanceGroup()
+	configNEG := configNamer.NEG("ns", "neg", "8080")
+	f.CreateGlobalBackendService(&compute.BackendService{
+		Name:     configNamer.Backend(igPort.Port),
+		Backends: []*compute.Backend{{Group: configIG, BalancingMode: string(Utilization)}},
+	})
+	f.CreateGlobalBackendService(&compute.BackendService{
+		Name:     configNamer.Backend(negPort.Port),
+		Backends: []*compute.Backend{{Group: configNEG, BalancingMode: string(Rate)}},
+	})
+	memberNEG := memberNamer.NEG("ns", "neg", "8080")
+	if err := fakeNEG.CreateNetworkEndpointGroup(&computealpha.NetworkEndpointGroup{Name: memberNEG}, "zone1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Registering twice adds the backends once.
+	for i := 0; i < 2; i++ {
+		if err := mcb.Register([]ServicePort{igPort, negPort}, igs, zones); err != nil {
+			t.Fatalf("Register() = %v", err)
+		}
+	}
+	be, err := f.GetAlphaGlobalBackendService(configNamer.Backend(igPort.Port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(be.Backends) != 2 || be.Backends[1].Group != memberNamer.InstanceGroup() || be.Backends[1].BalancingMode != string(Utilization) {
+		t.Errorf("Expected the instance group of the member with the balancing mode of the config cluster, got backends %+v", be.Backends)
+	}
+	be, err = f.GetAlphaGlobalBackendService(configNamer.Backend(negPort.Port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(be.Backends) != 2 || retrieveObjectName(be.Backends[1].Group) != memberNEG {
+		t.Errorf("Expected the NEG of the member, got backends %+v", be.Backends)
+	}
+
+	// Only the backends of the member are removed from the backend services
+	// it doesn't use anymore.
+	if err := mcb.GC([]ServicePort{negPort}); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	be, err = f.GetAlphaGlobalBackendService(configNamer.Backend(igPort.Port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(be.Backends) != 1 || be.Backends[0].Group != configIG {
+		t.Errorf("Expected only the instance group of the config cluster, got backends %+v", be.Backends)
+	}
+	be, err = f.GetAlphaGlobalBackendService(configNamer.Backend(negPort.Port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(be.Backends) != 2 {
+		t.Errorf("Expected the backends of the used backend service to be kept, got %+v", be.Backends)
+	}
+
+	// A restarted member finds the backend services it registered into.
+	mcb = NewMultiClusterBackends(f, fakeNEG, memberNamer, "config")
+	if err := mcb.GC(nil); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	be, err = f.GetAlphaGlobalBackendService(configNamer.Backend(negPort.Port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(be.Backends) != 1 || be.Backends[0].Group != configNEG {
+		t.Errorf("Expected only the NEG of the config cluster, got backends %+v", be.Backends)
+	}
+}
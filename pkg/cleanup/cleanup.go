@@ -0,0 +1,434 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleanup
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// Cleaner deletes the GCE resources owned by a cluster.
+type Cleaner struct {
+	cloud        Cloud
+	extended     ExtendedCloud
+	firewallPool firewalls.SingleFirewallPool
+	firewalls    firewalls.Firewall
+	namer        *utils.Namer
+	zones        []string
+	negs         bool
+	dryRun       bool
+
+	// deleted are the resources deleted by the current cleanup.
+	deleted []string
+	// errs are the errors of the current cleanup.
+	errs []error
+}
+
+// NewCleaner returns a Cleaner of the resources of the cluster of the given
+// namer.
+//   - extended: lists and deletes the resources the vendored compute API
+//     predates. May be nil if only orphans are cleaned up.
+//   - firewallPool: deletes the L7 firewall rules.
+//   - firewallProvider: lists and deletes the firewall rules of the L4 load
+//     balancers. May be nil if only orphans are cleaned up.
+//   - zones: are the zones of the instance groups and NEGs.
+//   - negs: if true, the NEGs are deleted as well. Requires the NEG alpha
+//     feature of the cloud.
+//   - dryRun: if true, the resources which would be deleted are only logged.
+func NewCleaner(cloud Cloud, extended ExtendedCloud, firewallPool firewalls.SingleFirewallPool, firewallProvider firewalls.Firewall, namer *utils.Namer, zones []string, negs bool, dryRun bool) *Cleaner {
+	return &Cleaner{
+		cloud:        cloud,
+		extended:     extended,
+		firewallPool: firewallPool,
+		firewalls:    firewallProvider,
+		namer:        namer,
+		zones:        zones,
+		negs:         negs,
+		dryRun:       dryRun,
+	}
+}
+
+// Cleanup deletes the resources of the cluster, the resources using others
+// first: PSC service attachments, forwarding rules and their static IPs,
+// target proxies, certificates, url maps, backend services, health checks,
+// global and zonal NEGs, instance groups and firewall rules. The L4 and
+// regional HTTP(S) load balancers, in the region of the cluster, are deleted
+// along. A failed deletion doesn't stop the cleanup, all the errors are
+// returned. Returns the resources deleted, or which would be in dry run.
+func (c *Cleaner) Cleanup() ([]string, error) {
+	c.deleted, c.errs = nil, nil
+	if c.namer.UID() == "" {
+		// Without a cluster UID, the L4 names of all clusters share the
+		// prefix.
+		logging.Warningf("Not deleting the L4 and regional HTTP(S) load balancers, the cluster has no UID")
+	}
+	c.cleanupForwardingRules()
+	// The service attachments use the forwarding rules of the internal L4
+	// load balancers.
+	c.cleanupRegionResources("service attachment", c.extended.ListServiceAttachments, c.extended.DeleteServiceAttachment)
+	c.cleanupRegionForwardingRules()
+	c.cleanupRegionResources("address", c.extended.ListRegionAddresses, c.extended.DeleteRegionAddress)
+	c.cleanupTargetProxies()
+	c.cleanupRegionResources("target http proxy", c.extended.ListRegionTargetHttpProxies, c.extended.DeleteRegionTargetHttpProxy)
+	c.cleanupRegionResources("target https proxy", c.extended.ListRegionTargetHttpsProxies, c.extended.DeleteRegionTargetHttpsProxy)
+	c.cleanupSslCertificates()
+	c.cleanupRegionResources("ssl certificate", c.extended.ListRegionSslCertificates, c.extended.DeleteRegionSslCertificate)
+	c.cleanupUrlMaps()
+	c.cleanupRegionResources("url map", c.extended.ListRegionUrlMaps, c.extended.DeleteRegionUrlMap)
+	c.cleanupBackendServices()
+	c.cleanupRegionBackendServices()
+	c.cleanupHealthChecks()
+	c.cleanupRegionResources("health check", c.extended.ListRegionHealthChecks, c.extended.DeleteRegionHealthCheck)
+	c.cleanupGlobalNEGs()
+	for _, zone := range c.zones {
+		if c.negs {
+			c.cleanupNEGs(zone)
+		}
+		c.cleanupInstanceGroups(zone)
+	}
+	c.cleanupFirewallRules()
+	c.cleanupL4FirewallRules()
+	return c.deleted, utilerrors.NewAggregate(c.errs)
+}
+
+// owns returns true if the given name was generated by the namer for one of
+// the given kinds of resources, eg: "fw" for forwarding rules.
+func (c *Cleaner) owns(name string, kinds ...string) bool {
+	if !c.namer.NameBelongsToCluster(name) {
+		return false
+	}
+	kind := c.namer.ParseName(name).Resource
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsL4 returns true if the given name is the name of a resource of an L4
+// load balancer, PSC service attachment or regional HTTP(S) load balancer of
+// the cluster. Names without cluster UID are never owned.
+func (c *Cleaner) ownsL4(name string) bool {
+	return c.namer.UID() != "" && strings.HasPrefix(name, c.namer.L4Prefix())
+}
+
+// ownsInstanceGroup returns true if the given name is the name of an instance
+// group of the cluster, or of one of its shards.
+func (c *Cleaner) ownsInstanceGroup(name string) bool {
+	igName := c.namer.InstanceGroup()
+	if name == igName {
+		return true
+	}
+	shard := strings.TrimPrefix(name, igName+"-")
+	if shard == name {
+		return false
+	}
+	_, err := strconv.Atoi(shard)
+	return err == nil
+}
+
+// delete deletes the given resource with the given function, unless in dry
+// run. Resources which are already gone are ignored.
+func (c *Cleaner) delete(resource string, deleteFn func() error) {
+	if c.dryRun {
+		logging.Infof("Would delete %v", resource)
+		c.deleted = append(c.deleted, resource)
+		return
+	}
+	logging.Infof("Deleting %v", resource)
+	if err := deleteFn(); err != nil {
+		if !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
+			c.errs = append(c.errs, fmt.Errorf("failed to delete %v: %v", resource, err))
+		}
+		return
+	}
+	c.deleted = append(c.deleted, resource)
+}
+
+// listFailed records the failure to list the given kind of resources.
+func (c *Cleaner) listFailed(kind string, err error) {
+	c.errs = append(c.errs, fmt.Errorf("failed to list %v: %v", kind, err))
+}
+
+// cleanupForwardingRules deletes the forwarding rules, along with the static
+// IPs the controller reserved for them. Static IPs named by users are kept.
+func (c *Cleaner) cleanupForwardingRules() {
+	list, err := c.cloud.ListGlobalForwardingRules()
+	if err != nil {
+		c.listFailed("forwarding rules", err)
+		return
+	}
+	for _, fr := range list.Items {
+		if !c.owns(fr.Name, "fw", "fws", "fw6", "fws6") {
+			continue
+		}
+		name := fr.Name
+		c.delete("forwarding rule "+name, func() error { return c.cloud.DeleteGlobalForwardingRule(name) })
+		// The static IPs are named after the HTTP forwarding rules.
+		if !c.owns(name, "fw", "fw6") {
+			continue
+		}
+		if addr, err := c.cloud.GetGlobalAddress(name); err != nil || addr == nil {
+			continue
+		}
+		c.delete("global address "+name, func() error { return c.cloud.DeleteGlobalAddress(name) })
+	}
+}
+
+// cleanupRegionResources deletes the resources of the given kind, eg: "url
+// map", of the L4 and regional HTTP(S) load balancers in the region of the
+// cluster, listed and deleted with the given functions.
+func (c *Cleaner) cleanupRegionResources(kind string, list func(region string) ([]*Resource, error), deleteFn func(name, region string) error) {
+	region := c.cloud.Region()
+	resources, err := list(region)
+	if err != nil {
+		c.listFailed(fmt.Sprintf("%v resources of region %v", kind, region), err)
+		return
+	}
+	for _, r := range resources {
+		if name := r.Name; c.ownsL4(name) {
+			c.delete(fmt.Sprintf("%v %v/%v", kind, region, name), func() error { return deleteFn(name, region) })
+		}
+	}
+}
+
+// cleanupRegionForwardingRules deletes the forwarding rules of the L4 and
+// regional HTTP(S) load balancers.
+func (c *Cleaner) cleanupRegionForwardingRules() {
+	region := c.cloud.Region()
+	list, err := c.cloud.ListRegionForwardingRules(region)
+	if err != nil {
+		c.listFailed("forwarding rules of region "+region, err)
+		return
+	}
+	for _, fr := range list.Items {
+		if name := fr.Name; c.ownsL4(name) {
+			c.delete(fmt.Sprintf("forwarding rule %v/%v", region, name), func() error { return c.cloud.DeleteRegionForwardingRule(name, region) })
+		}
+	}
+}
+
+// cleanupTargetProxies deletes the target HTTP and HTTPS proxies.
+func (c *Cleaner) cleanupTargetProxies() {
+	if list, err := c.cloud.ListTargetHttpProxies(); err != nil {
+		c.listFailed("target http proxies", err)
+	} else {
+		for _, p := range list.Items {
+			if name := p.Name; c.owns(name, "tp") {
+				c.delete("target http proxy "+name, func() error { return c.cloud.DeleteTargetHttpProxy(name) })
+			}
+		}
+	}
+	if list, err := c.cloud.ListTargetHttpsProxies(); err != nil {
+		c.listFailed("target https proxies", err)
+	} else {
+		for _, p := range list.Items {
+			if name := p.Name; c.owns(name, "tps") {
+				c.delete("target https proxy "+name, func() error { return c.cloud.DeleteTargetHttpsProxy(name) })
+			}
+		}
+	}
+}
+
+// cleanupSslCertificates deletes the certificates uploaded from the Secrets
+// of the Ingresses. Pre-shared certificates are named by users and kept.
+func (c *Cleaner) cleanupSslCertificates() {
+	list, err := c.cloud.ListSslCertificates()
+	if err != nil {
+		c.listFailed("ssl certificates", err)
+		return
+	}
+	for _, cert := range list.Items {
+		if name := cert.Name; c.owns(name, "ssl") {
+			c.delete("ssl certificate "+name, func() error { return c.cloud.DeleteSslCertificate(name) })
+		}
+	}
+}
+
+// cleanupUrlMaps deletes the url maps, and the ones redirecting to HTTPS.
+func (c *Cleaner) cleanupUrlMaps() {
+	list, err := c.cloud.ListUrlMaps()
+	if err != nil {
+		c.listFailed("url maps", err)
+		return
+	}
+	for _, um := range list.Items {
+		if name := um.Name; c.owns(name, "um", "rm") {
+			c.delete("url map "+name, func() error { return c.cloud.DeleteUrlMap(name) })
+		}
+	}
+}
+
+// cleanupBackendServices deletes the backend services, including the ones
+// named by BackendConfigs, recognized by their description.
+func (c *Cleaner) cleanupBackendServices() {
+	list, err := c.cloud.ListGlobalBackendServices()
+	if err != nil {
+		c.listFailed("backend services", err)
+		return
+	}
+	for _, be := range list.Items {
+		if name := be.Name; c.owns(name, "be") || backends.OwnedByCluster(be, c.namer) {
+			c.delete("backend service "+name, func() error { return c.cloud.DeleteGlobalBackendService(name) })
+		}
+	}
+}
+
+// cleanupRegionBackendServices deletes the backend services of the L4 and
+// regional HTTP(S) load balancers.
+func (c *Cleaner) cleanupRegionBackendServices() {
+	region := c.cloud.Region()
+	list, err := c.cloud.ListRegionBackendServices(region)
+	if err != nil {
+		c.listFailed("backend services of region "+region, err)
+		return
+	}
+	for _, be := range list.Items {
+		if name := be.Name; c.ownsL4(name) {
+			c.delete(fmt.Sprintf("backend service %v/%v", region, name), func() error { return c.cloud.DeleteRegionBackendService(name, region) })
+		}
+	}
+}
+
+// cleanupHealthChecks deletes the health checks, and the legacy HTTP health
+// checks. Both are named after the backend services. The health checks of
+// the internal L4 load balancers are global too.
+func (c *Cleaner) cleanupHealthChecks() {
+	if list, err := c.cloud.ListHealthChecks(); err != nil {
+		c.listFailed("health checks", err)
+	} else {
+		for _, hc := range list.Items {
+			if name := hc.Name; c.owns(name, "be") || c.ownsL4(name) {
+				c.delete("health check "+name, func() error { return c.cloud.DeleteHealthCheck(name) })
+			}
+		}
+	}
+	if list, err := c.cloud.ListHttpHealthChecks(); err != nil {
+		c.listFailed("http health checks", err)
+	} else {
+		for _, hc := range list.Items {
+			if name := hc.Name; c.owns(name, "be") {
+				c.delete("http health check "+name, func() error { return c.cloud.DeleteHttpHealthCheck(name) })
+			}
+		}
+	}
+}
+
+// cleanupGlobalNEGs deletes the internet NEGs of the ExternalName Services,
+// named after their backend services.
+func (c *Cleaner) cleanupGlobalNEGs() {
+	negs, err := c.extended.ListGlobalNetworkEndpointGroups()
+	if err != nil {
+		c.listFailed("global NEGs", err)
+		return
+	}
+	for _, neg := range negs {
+		if name := neg.Name; c.namer.IsInternetBackend(name) {
+			c.delete("global NEG "+name, func() error { return c.extended.DeleteGlobalNetworkEndpointGroup(name) })
+		}
+	}
+}
+
+// cleanupNEGs deletes the NEGs of the given zone, and the GCE_VM_IP NEGs of
+// the external L4 load balancers, named after them.
+func (c *Cleaner) cleanupNEGs(zone string) {
+	if c.namer.UID() == "" {
+		// Without a cluster UID, the NEGs of all clusters share the prefix.
+		logging.Warningf("Not deleting the NEGs of zone %v, the cluster has no UID", zone)
+		return
+	}
+	negs, err := c.cloud.ListNetworkEndpointGroup(zone)
+	if err != nil {
+		c.listFailed("NEGs of zone "+zone, err)
+		return
+	}
+	for _, neg := range negs {
+		if name := neg.Name; c.namer.IsNEG(name) || c.ownsL4(name) {
+			c.delete(fmt.Sprintf("NEG %v/%v", zone, name), func() error { return c.cloud.DeleteNetworkEndpointGroup(name, zone) })
+		}
+	}
+}
+
+// cleanupInstanceGroups deletes the instance groups of the given zone.
+func (c *Cleaner) cleanupInstanceGroups(zone string) {
+	list, err := c.cloud.ListInstanceGroups(zone)
+	if err != nil {
+		c.listFailed("instance groups of zone "+zone, err)
+		return
+	}
+	for _, ig := range list.Items {
+		if name := ig.Name; c.ownsInstanceGroup(name) {
+			c.delete(fmt.Sprintf("instance group %v/%v", zone, name), func() error { return c.cloud.DeleteInstanceGroup(name, zone) })
+		}
+	}
+}
+
+// cleanupFirewallRules deletes the L7 firewall rules through the firewall
+// pool. Firewall changes requiring a network admin, eg: on XPN, are logged.
+func (c *Cleaner) cleanupFirewallRules() {
+	if c.dryRun {
+		logging.Infof("Would delete the L7 firewall rules %v", c.namer.FirewallRule())
+		c.deleted = append(c.deleted, "firewall rules "+c.namer.FirewallRule())
+		return
+	}
+	if err := c.firewallPool.Shutdown(); err != nil {
+		if fwErr, ok := err.(*firewalls.FirewallSyncError); ok {
+			logging.Warningf("%v", fwErr.Message)
+			return
+		}
+		c.errs = append(c.errs, fmt.Errorf("failed to delete the L7 firewall rules: %v", err))
+		return
+	}
+	c.deleted = append(c.deleted, "firewall rules "+c.namer.FirewallRule())
+}
+
+// cleanupL4FirewallRules deletes the firewall rules of the L4 and regional
+// HTTP(S) load balancers, and the ones letting the health checks of the L4
+// load balancers reach the nodes.
+func (c *Cleaner) cleanupL4FirewallRules() {
+	if c.namer.UID() == "" {
+		return
+	}
+	list, err := c.firewalls.ListFirewalls(c.namer.L4Prefix())
+	if err != nil {
+		c.listFailed("firewall rules", err)
+		return
+	}
+	// The firewall rules are listed in no particular order.
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	for _, fw := range list {
+		name := fw.Name
+		c.delete("firewall rule "+name, func() error {
+			err := c.firewalls.DeleteFirewall(name)
+			if utils.IsForbiddenError(err) && c.firewalls.OnXPN() {
+				return fmt.Errorf("must be deleted by a network admin of project %v: %v", c.firewalls.NetworkProjectID(), err)
+			}
+			return err
+		})
+	}
+}
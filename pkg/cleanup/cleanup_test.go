@@ -0,0 +1,563 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleanup
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// fakeCloud holds the names of the resources of each kind, zonal resources
+// are named zone/name.
+type fakeCloud struct {
+	resources map[string]sets.String
+	// descriptions are the descriptions of the resources, by kind:name.
+	descriptions map[string]string
+	// uses are the resources used by url maps and backend services, by
+	// kind:name: the backend services of url maps, the health checks of
+	// backend services.
+	uses map[string][]string
+	// deleted are the deleted resources, in order, as kind:name.
+	deleted []string
+}
+
+func newFakeCloud() *fakeCloud {
+	return &fakeCloud{resources: map[string]sets.String{}, descriptions: map[string]string{}, uses: map[string][]string{}}
+}
+
+func (f *fakeCloud) add(kind string, names ...string) {
+	if f.resources[kind] == nil {
+		f.resources[kind] = sets.NewString()
+	}
+	f.resources[kind].Insert(names...)
+}
+
+func (f *fakeCloud) list(kind string) []string {
+	return f.resources[kind].List()
+}
+
+func (f *fakeCloud) delete(kind, name string) error {
+	if !f.resources[kind].Has(name) {
+		return &googleapi.Error{Code: http.StatusNotFound}
+	}
+	f.resources[kind].Delete(name)
+	f.deleted = append(f.deleted, kind+":"+name)
+	return nil
+}
+
+func (f *fakeCloud) Region() string { return "us-central1" }
+
+func (f *fakeCloud) ListGlobalForwardingRules() (*compute.ForwardingRuleList, error) {
+	list := &compute.ForwardingRuleList{}
+	for _, name := range f.list("fr") {
+		list.Items = append(list.Items, &compute.ForwardingRule{Name: name, Description: f.descriptions["fr:"+name]})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteGlobalForwardingRule(name string) error { return f.delete("fr", name) }
+
+func (f *fakeCloud) GetGlobalAddress(name string) (*compute.Address, error) {
+	if !f.resources["addr"].Has(name) {
+		return nil, &googleapi.Error{Code: http.StatusNotFound}
+	}
+	return &compute.Address{Name: name}, nil
+}
+
+func (f *fakeCloud) DeleteGlobalAddress(name string) error { return f.delete("addr", name) }
+
+func (f *fakeCloud) ListRegionForwardingRules(region string) (*compute.ForwardingRuleList, error) {
+	list := &compute.ForwardingRuleList{}
+	for _, name := range f.list("rfr/" + region) {
+		list.Items = append(list.Items, &compute.ForwardingRule{Name: name})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteRegionForwardingRule(name, region string) error {
+	return f.delete("rfr/"+region, name)
+}
+
+func (f *fakeCloud) ListTargetHttpProxies() (*compute.TargetHttpProxyList, error) {
+	list := &compute.TargetHttpProxyList{}
+	for _, name := range f.list("tp") {
+		list.Items = append(list.Items, &compute.TargetHttpProxy{Name: name, Description: f.descriptions["tp:"+name]})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteTargetHttpProxy(name string) error { return f.delete("tp", name) }
+
+func (f *fakeCloud) ListTargetHttpsProxies() (*compute.TargetHttpsProxyList, error) {
+	list := &compute.TargetHttpsProxyList{}
+	for _, name := range f.list("tps") {
+		list.Items = append(list.Items, &compute.TargetHttpsProxy{Name: name, Description: f.descriptions["tps:"+name]})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteTargetHttpsProxy(name string) error { return f.delete("tps", name) }
+
+func (f *fakeCloud) ListSslCertificates() (*compute.SslCertificateList, error) {
+	list := &compute.SslCertificateList{}
+	for _, name := range f.list("ssl") {
+		list.Items = append(list.Items, &compute.SslCertificate{Name: name, Description: f.descriptions["ssl:"+name]})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteSslCertificate(name string) error { return f.delete("ssl", name) }
+
+func (f *fakeCloud) ListUrlMaps() (*compute.UrlMapList, error) {
+	list := &compute.UrlMapList{}
+	for _, name := range f.list("um") {
+		um := &compute.UrlMap{Name: name, Description: f.descriptions["um:"+name]}
+		// The default backend is the first one, the others are path rules.
+		for i, be := range f.uses["um:"+name] {
+			link := "global/backendServices/" + be
+			if i == 0 {
+				um.DefaultService = link
+				continue
+			}
+			if len(um.PathMatchers) == 0 {
+				um.PathMatchers = []*compute.PathMatcher{{DefaultService: um.DefaultService}}
+			}
+			um.PathMatchers[0].PathRules = append(um.PathMatchers[0].PathRules, &compute.PathRule{Service: link})
+		}
+		list.Items = append(list.Items, um)
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteUrlMap(name string) error { return f.delete("um", name) }
+
+func (f *fakeCloud) ListGlobalBackendServices() (*compute.BackendServiceList, error) {
+	list := &compute.BackendServiceList{}
+	for _, name := range f.list("be") {
+		be := &compute.BackendService{Name: name, Description: f.descriptions["be:"+name]}
+		for _, hc := range f.uses["be:"+name] {
+			be.HealthChecks = append(be.HealthChecks, "global/healthChecks/"+hc)
+		}
+		list.Items = append(list.Items, be)
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteGlobalBackendService(name string) error { return f.delete("be", name) }
+
+func (f *fakeCloud) ListRegionBackendServices(region string) (*compute.BackendServiceList, error) {
+	list := &compute.BackendServiceList{}
+	for _, name := range f.list("rbe/" + region) {
+		list.Items = append(list.Items, &compute.BackendService{Name: name})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteRegionBackendService(name, region string) error {
+	return f.delete("rbe/"+region, name)
+}
+
+func (f *fakeCloud) ListHealthChecks() (*compute.HealthCheckList, error) {
+	list := &compute.HealthCheckList{}
+	for _, name := range f.list("hc") {
+		list.Items = append(list.Items, &compute.HealthCheck{Name: name})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteHealthCheck(name string) error { return f.delete("hc", name) }
+
+func (f *fakeCloud) ListHttpHealthChecks() (*compute.HttpHealthCheckList, error) {
+	list := &compute.HttpHealthCheckList{}
+	for _, name := range f.list("httphc") {
+		list.Items = append(list.Items, &compute.HttpHealthCheck{Name: name})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteHttpHealthCheck(name string) error { return f.delete("httphc", name) }
+
+func (f *fakeCloud) ListNetworkEndpointGroup(zone string) ([]*computealpha.NetworkEndpointGroup, error) {
+	var negs []*computealpha.NetworkEndpointGroup
+	for _, name := range f.list("neg/" + zone) {
+		negs = append(negs, &computealpha.NetworkEndpointGroup{Name: name})
+	}
+	return negs, nil
+}
+
+func (f *fakeCloud) DeleteNetworkEndpointGroup(name string, zone string) error {
+	return f.delete("neg/"+zone, name)
+}
+
+func (f *fakeCloud) ListGlobalNetworkEndpointGroups() ([]*Resource, error) {
+	var negs []*Resource
+	for _, name := range f.list("gneg") {
+		negs = append(negs, &Resource{Name: name})
+	}
+	return negs, nil
+}
+
+func (f *fakeCloud) DeleteGlobalNetworkEndpointGroup(name string) error {
+	return f.delete("gneg", name)
+}
+
+// listREST returns the resources of the given kind, listed through the REST
+// API.
+func (f *fakeCloud) listREST(kind string) ([]*Resource, error) {
+	var resources []*Resource
+	for _, name := range f.list(kind) {
+		resources = append(resources, &Resource{Name: name})
+	}
+	return resources, nil
+}
+
+func (f *fakeCloud) ListRegionHealthChecks(region string) ([]*Resource, error) {
+	return f.listREST("rhc/" + region)
+}
+
+func (f *fakeCloud) DeleteRegionHealthCheck(name, region string) error {
+	return f.delete("rhc/"+region, name)
+}
+
+func (f *fakeCloud) ListServiceAttachments(region string) ([]*Resource, error) {
+	return f.listREST("sa/" + region)
+}
+
+func (f *fakeCloud) DeleteServiceAttachment(name, region string) error {
+	return f.delete("sa/"+region, name)
+}
+
+func (f *fakeCloud) ListRegionAddresses(region string) ([]*Resource, error) {
+	return f.listREST("raddr/" + region)
+}
+
+func (f *fakeCloud) DeleteRegionAddress(name, region string) error {
+	return f.delete("raddr/"+region, name)
+}
+
+func (f *fakeCloud) ListRegionTargetHttpProxies(region string) ([]*Resource, error) {
+	return f.listREST("rtp/" + region)
+}
+
+func (f *fakeCloud) DeleteRegionTargetHttpProxy(name, region string) error {
+	return f.delete("rtp/"+region, name)
+}
+
+func (f *fakeCloud) ListRegionTargetHttpsProxies(region string) ([]*Resource, error) {
+	return f.listREST("rtps/" + region)
+}
+
+func (f *fakeCloud) DeleteRegionTargetHttpsProxy(name, region string) error {
+	return f.delete("rtps/"+region, name)
+}
+
+func (f *fakeCloud) ListRegionSslCertificates(region string) ([]*Resource, error) {
+	return f.listREST("rssl/" + region)
+}
+
+func (f *fakeCloud) DeleteRegionSslCertificate(name, region string) error {
+	return f.delete("rssl/"+region, name)
+}
+
+func (f *fakeCloud) ListRegionUrlMaps(region string) ([]*Resource, error) {
+	return f.listREST("rum/" + region)
+}
+
+func (f *fakeCloud) DeleteRegionUrlMap(name, region string) error {
+	return f.delete("rum/"+region, name)
+}
+
+func (f *fakeCloud) ListInstanceGroups(zone string) (*compute.InstanceGroupList, error) {
+	list := &compute.InstanceGroupList{}
+	for _, name := range f.list("ig/" + zone) {
+		list.Items = append(list.Items, &compute.InstanceGroup{Name: name})
+	}
+	return list, nil
+}
+
+func (f *fakeCloud) DeleteInstanceGroup(name string, zone string) error {
+	return f.delete("ig/"+zone, name)
+}
+
+func TestCleanup(t *testing.T) {
+	namer := utils.NewNamer("uid1", "fw1")
+	lbName := namer.LoadBalancer("default/ing")
+	otherNamer := utils.NewNamer("uid2", "fw2")
+	otherLBName := otherNamer.LoadBalancer("default/ing")
+	negName := namer.NEG("default", "svc", "80")
+	ilbName, netLBName := namer.L4("default", "ilb"), namer.L4("default", "netlb")
+	otherL4Name := otherNamer.L4("default", "ilb")
+	saName := namer.ServiceAttachment("default", "sa")
+	regional := func(kind string) string { return namer.RegionalL7(kind, "default", "ing") }
+	regionalCert := namer.RegionalL7Cert("default", "ing", "cert")
+	regionalBackend := namer.RegionalL7Backend("bi", "default", "svc", "8080")
+
+	newCloud := func() *fakeCloud {
+		f := newFakeCloud()
+		f.add("fr", namer.ForwardingRule(lbName, utils.HTTPProtocol), namer.ForwardingRule(lbName, utils.HTTPSProtocol), otherNamer.ForwardingRule(otherLBName, utils.HTTPProtocol), "user-rule")
+		f.add("addr", namer.ForwardingRule(lbName, utils.HTTPProtocol), otherNamer.ForwardingRule(otherLBName, utils.HTTPProtocol), "user-ip")
+		f.add("sa/us-central1", saName, otherNamer.ServiceAttachment("default", "sa"))
+		f.add("rfr/us-central1", ilbName, netLBName, regional("fh"), regional("fs"), otherL4Name, "user-rule")
+		f.add("raddr/us-central1", regional("ip"), "user-ip")
+		f.add("rtp/us-central1", regional("th"))
+		f.add("rtps/us-central1", regional("ts"))
+		f.add("rssl/us-central1", regionalCert, "pre-shared-cert")
+		f.add("rum/us-central1", regional("um"), otherNamer.RegionalL7("um", "default", "ing"))
+		f.add("tp", namer.TargetProxy(lbName, utils.HTTPProtocol), otherNamer.TargetProxy(otherLBName, utils.HTTPProtocol))
+		f.add("tps", namer.TargetProxy(lbName, utils.HTTPSProtocol))
+		f.add("ssl", namer.SSLCert(lbName, true), "pre-shared-cert")
+		f.add("um", namer.UrlMap(lbName), namer.RedirectUrlMap(lbName), otherNamer.UrlMap(otherLBName), otherNamer.RedirectUrlMap(otherLBName))
+		f.add("be", namer.Backend(30000), namer.ServerlessBackend("us-central1", "run"), "checkout", otherNamer.Backend(30000), "user-backend")
+		f.descriptions["be:checkout"] = utils.Description{ServiceName: "default/checkout", ServicePort: "80", ClusterUID: "uid1"}.String()
+		f.add("rbe/us-central1", ilbName, netLBName, regionalBackend, otherL4Name)
+		f.add("hc", namer.Backend(30000), otherNamer.Backend(30000), ilbName, otherL4Name)
+		f.add("httphc", namer.Backend(30001))
+		f.add("rhc/us-central1", netLBName, regionalBackend, otherL4Name)
+		f.add("gneg", namer.InternetBackend("default", "external", "443"), otherNamer.InternetBackend("default", "external", "443"), "user-neg")
+		f.add("neg/zone-a", negName, netLBName, otherNamer.NEG("default", "svc", "80"), otherL4Name)
+		f.add("ig/zone-a", namer.InstanceGroup(), namer.InstanceGroup()+"-1", otherNamer.InstanceGroup())
+		f.add("ig/zone-b", namer.InstanceGroup())
+		return f
+	}
+
+	// Dry run doesn't delete anything.
+	cloud := newCloud()
+	fwProvider := firewalls.NewFakeFirewallsProvider(false, false)
+	l4Firewalls := []string{ilbName, namer.L4HealthCheckFirewall("default", "ilb"), netLBName, namer.L4HealthCheckFirewall("default", "netlb"), regional("fw")}
+	for _, name := range append([]string{namer.FirewallRule(), otherL4Name}, l4Firewalls...) {
+		fwProvider.CreateFirewall(&firewalls.FirewallRule{Name: name})
+	}
+	fwPool := firewalls.NewFirewallPool(fwProvider, namer, firewalls.PoolOptions{Manage: true})
+	dryRun, err := NewCleaner(cloud, cloud, fwPool, fwProvider, namer, []string{"zone-a", "zone-b"}, true, true).Cleanup()
+	if err != nil {
+		t.Fatalf("Cleanup() = %v", err)
+	}
+	if len(cloud.deleted) != 0 {
+		t.Errorf("Expected nothing to be deleted in dry run, got %v", cloud.deleted)
+	}
+	for _, name := range append([]string{namer.FirewallRule()}, l4Firewalls...) {
+		if fw, _ := fwProvider.GetFirewall(name); fw == nil {
+			t.Errorf("Expected firewall rule %v to be kept in dry run", name)
+		}
+	}
+
+	deleted, err := NewCleaner(cloud, cloud, fwPool, fwProvider, namer, []string{"zone-a", "zone-b"}, true, false).Cleanup()
+	if err != nil {
+		t.Fatalf("Cleanup() = %v", err)
+	}
+	if !reflect.DeepEqual(deleted, dryRun) {
+		t.Errorf("Got deleted resources %v, want the ones of the dry run %v", deleted, dryRun)
+	}
+	want := []string{
+		"fr:" + namer.ForwardingRule(lbName, utils.HTTPProtocol),
+		"addr:" + namer.ForwardingRule(lbName, utils.HTTPProtocol),
+		"fr:" + namer.ForwardingRule(lbName, utils.HTTPSProtocol),
+		"sa/us-central1:" + saName,
+		"rfr/us-central1:" + ilbName,
+		"rfr/us-central1:" + netLBName,
+		"rfr/us-central1:" + regional("fh"),
+		"rfr/us-central1:" + regional("fs"),
+		"raddr/us-central1:" + regional("ip"),
+		"tp:" + namer.TargetProxy(lbName, utils.HTTPProtocol),
+		"tps:" + namer.TargetProxy(lbName, utils.HTTPSProtocol),
+		"rtp/us-central1:" + regional("th"),
+		"rtps/us-central1:" + regional("ts"),
+		"ssl:" + namer.SSLCert(lbName, true),
+		"rssl/us-central1:" + regionalCert,
+		"um:" + namer.UrlMap(lbName),
+		"um:" + namer.RedirectUrlMap(lbName),
+		"rum/us-central1:" + regional("um"),
+		"be:checkout",
+		"be:" + namer.Backend(30000),
+		"be:" + namer.ServerlessBackend("us-central1", "run"),
+		"rbe/us-central1:" + ilbName,
+		"rbe/us-central1:" + netLBName,
+		"rbe/us-central1:" + regionalBackend,
+		"hc:" + namer.Backend(30000),
+		"hc:" + ilbName,
+		"httphc:" + namer.Backend(30001),
+		"rhc/us-central1:" + netLBName,
+		"rhc/us-central1:" + regionalBackend,
+		"gneg:" + namer.InternetBackend("default", "external", "443"),
+		"neg/zone-a:" + negName,
+		"neg/zone-a:" + netLBName,
+		"ig/zone-a:" + namer.InstanceGroup(),
+		"ig/zone-a:" + namer.InstanceGroup() + "-1",
+		"ig/zone-b:" + namer.InstanceGroup(),
+	}
+	if got := sets.NewString(cloud.deleted...); !got.Equal(sets.NewString(want...)) {
+		t.Errorf("Got deleted resources\n%v\nwant\n%v", got.List(), want)
+	}
+	// The resources using others are deleted first.
+	kindOrder := []string{"fr", "sa/us-central1", "rfr/us-central1", "raddr/us-central1", "tp", "tps", "rtp/us-central1", "rtps/us-central1", "ssl", "rssl/us-central1", "um", "rum/us-central1", "be", "rbe/us-central1", "hc", "httphc", "rhc/us-central1", "gneg", "neg/zone-a", "ig/zone-a", "ig/zone-b"}
+	last := -1
+	for _, d := range cloud.deleted {
+		kind := strings.SplitN(d, ":", 2)[0]
+		if kind == "addr" {
+			continue
+		}
+		i := 0
+		for i < len(kindOrder) && kindOrder[i] != kind {
+			i++
+		}
+		if i < last {
+			t.Errorf("Deleted %v after the resources of a later kind: %v", d, cloud.deleted)
+		}
+		last = i
+	}
+	for _, name := range append([]string{namer.FirewallRule()}, l4Firewalls...) {
+		if fw, _ := fwProvider.GetFirewall(name); fw != nil {
+			t.Errorf("Expected firewall rule %v to be deleted", name)
+		}
+	}
+	if fw, _ := fwProvider.GetFirewall(otherL4Name); fw == nil {
+		t.Errorf("Expected firewall rule %v of another cluster to be kept", otherL4Name)
+	}
+	for kind, names := range cloud.resources {
+		for _, name := range names.List() {
+			if namer.NameBelongsToCluster(name) || name == negName || strings.HasPrefix(name, namer.L4Prefix()) {
+				t.Errorf("Expected %v %v to be deleted", kind, name)
+			}
+		}
+	}
+}
+
+func TestCleanupL4WithoutUID(t *testing.T) {
+	// Without a cluster UID, the L4 names of all clusters share the prefix.
+	namer := utils.NewNamer("", "fw1")
+	name := namer.L4("default", "ilb")
+	cloud := newFakeCloud()
+	cloud.add("rfr/us-central1", name)
+	cloud.add("rbe/us-central1", name)
+	cloud.add("hc", name)
+	cloud.add("rhc/us-central1", name)
+	fwProvider := firewalls.NewFakeFirewallsProvider(false, false)
+	fwProvider.CreateFirewall(&firewalls.FirewallRule{Name: name})
+	fwPool := firewalls.NewFirewallPool(fwProvider, namer, firewalls.PoolOptions{Manage: true})
+	if _, err := NewCleaner(cloud, cloud, fwPool, fwProvider, namer, nil, false, false).Cleanup(); err != nil {
+		t.Fatalf("Cleanup() = %v", err)
+	}
+	if len(cloud.deleted) != 0 {
+		t.Errorf("Expected nothing to be deleted, got %v", cloud.deleted)
+	}
+	if fw, _ := fwProvider.GetFirewall(name); fw == nil {
+		t.Errorf("Expected firewall rule %v to be kept", name)
+	}
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	namer := utils.NewNamer("uid1", "fw1")
+	otherNamer := utils.NewNamer("uid2", "fw2")
+	lbDescription := func(ing string) string {
+		return utils.Description{ClusterUID: "uid1", IngressName: ing}.String()
+	}
+	beDescription := func(svc string) string {
+		return utils.Description{ServiceName: svc, ServicePort: "80"}.String()
+	}
+
+	cloud := newFakeCloud()
+	// The resources of an existing Ingress, of a deleted one, and of a
+	// deleted one created before ownership metadata.
+	for _, ing := range []string{"default/live", "default/gone", "default/legacy"} {
+		lbName := namer.LoadBalancer(ing)
+		resources := map[string]string{
+			"fr":   namer.ForwardingRule(lbName, utils.HTTPProtocol),
+			"addr": namer.ForwardingRule(lbName, utils.HTTPProtocol),
+			"tp":   namer.TargetProxy(lbName, utils.HTTPProtocol),
+			"tps":  namer.TargetProxy(lbName, utils.HTTPSProtocol),
+			"ssl":  namer.SSLCert(lbName, true),
+			"um":   namer.UrlMap(lbName),
+		}
+		for kind, name := range resources {
+			cloud.add(kind, name)
+			if ing != "default/legacy" {
+				cloud.descriptions[kind+":"+name] = lbDescription(ing)
+			}
+		}
+		// The url map redirecting to HTTPS.
+		cloud.add("um", namer.RedirectUrlMap(lbName))
+		if ing != "default/legacy" {
+			cloud.descriptions["um:"+namer.RedirectUrlMap(lbName)] = lbDescription(ing)
+		}
+	}
+	// The resources of another cluster are never orphans.
+	otherLBName := otherNamer.LoadBalancer("default/gone")
+	cloud.add("um", otherNamer.UrlMap(otherLBName))
+	cloud.descriptions["um:"+otherNamer.UrlMap(otherLBName)] = utils.Description{ClusterUID: "uid2", IngressName: "default/gone"}.String()
+
+	// The live url map uses the backend of a deleted Service, kept, and the
+	// backend of an existing one.
+	cloud.uses["um:"+namer.UrlMap(namer.LoadBalancer("default/live"))] = []string{namer.Backend(30000), namer.Backend(30001)}
+	cloud.add("be", namer.Backend(30000), namer.Backend(30001), namer.Backend(30002), "checkout", otherNamer.Backend(30003))
+	cloud.descriptions["be:"+namer.Backend(30000)] = beDescription("default/svc")
+	cloud.descriptions["be:"+namer.Backend(30001)] = beDescription("default/deleted")
+	cloud.descriptions["be:"+namer.Backend(30002)] = beDescription("default/deleted")
+	cloud.descriptions["be:checkout"] = utils.Description{ServiceName: "default/checkout", ServicePort: "80", ClusterUID: "uid1"}.String()
+	cloud.descriptions["be:"+otherNamer.Backend(30003)] = beDescription("default/deleted")
+	for _, port := range []int64{30000, 30001, 30002} {
+		cloud.uses["be:"+namer.Backend(port)] = []string{namer.Backend(port)}
+	}
+	cloud.add("hc", namer.Backend(30000), namer.Backend(30001), namer.Backend(30002), namer.Backend(30004), otherNamer.Backend(30004))
+	cloud.add("httphc", namer.Backend(30005))
+
+	cleaner := NewCleaner(cloud, nil, nil, nil, namer, nil, false, false)
+	deleted, err := cleaner.CleanupOrphans(sets.NewString("default/live"), sets.NewString("default/svc"))
+	if err != nil {
+		t.Fatalf("CleanupOrphans() = %v", err)
+	}
+	goneLB := namer.LoadBalancer("default/gone")
+	want := []string{
+		"fr:" + namer.ForwardingRule(goneLB, utils.HTTPProtocol),
+		"addr:" + namer.ForwardingRule(goneLB, utils.HTTPProtocol),
+		"tp:" + namer.TargetProxy(goneLB, utils.HTTPProtocol),
+		"tps:" + namer.TargetProxy(goneLB, utils.HTTPSProtocol),
+		"ssl:" + namer.SSLCert(goneLB, true),
+		"um:" + namer.UrlMap(goneLB),
+		"um:" + namer.RedirectUrlMap(goneLB),
+		"be:" + namer.Backend(30002),
+		"be:checkout",
+		"hc:" + namer.Backend(30002),
+		"hc:" + namer.Backend(30004),
+		"httphc:" + namer.Backend(30005),
+	}
+	if got := sets.NewString(cloud.deleted...); !got.Equal(sets.NewString(want...)) {
+		t.Errorf("Got deleted resources\n%v\nwant\n%v", got.List(), want)
+	}
+	if len(deleted) != len(want) {
+		t.Errorf("Got %v deleted resources, want %v: %v", len(deleted), len(want), deleted)
+	}
+
+	// Nothing is left to collect.
+	cloud.deleted = nil
+	if _, err := cleaner.CleanupOrphans(sets.NewString("default/live"), sets.NewString("default/svc")); err != nil {
+		t.Fatalf("CleanupOrphans() = %v", err)
+	}
+	if len(cloud.deleted) != 0 {
+		t.Errorf("Expected nothing to be deleted on the second run, got %v", cloud.deleted)
+	}
+}
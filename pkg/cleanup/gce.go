@@ -0,0 +1,160 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleanup
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
+
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// gceExtendedCloud implements ExtendedCloud through the REST API.
+type gceExtendedCloud struct {
+	rest *utils.ComputeREST
+}
+
+// Ensure that gceExtendedCloud implements ExtendedCloud.
+var _ ExtendedCloud = &gceExtendedCloud{}
+
+// NewGCEExtendedCloud returns the ExtendedCloud of the project of the given
+// cloud. tokenSource, transport and apiEndpoint are those of
+// utils.NewComputeREST.
+func NewGCEExtendedCloud(cloud *gce.GCECloud, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (ExtendedCloud, error) {
+	rest, err := utils.NewComputeREST(cloud.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceExtendedCloud{rest: rest}, nil
+}
+
+// list returns all the resources of the collection of the given URL, page by
+// page.
+func (g *gceExtendedCloud) list(u string) ([]*Resource, error) {
+	var resources []*Resource
+	query := url.Values{}
+	for {
+		page := struct {
+			Items         []*Resource `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}{}
+		if err := g.rest.Do("GET", u+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		resources = append(resources, page.Items...)
+		if page.NextPageToken == "" {
+			return resources, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// ListGlobalNetworkEndpointGroups returns the global NEGs.
+func (g *gceExtendedCloud) ListGlobalNetworkEndpointGroups() ([]*Resource, error) {
+	return g.list(g.rest.GlobalURL("networkEndpointGroups", ""))
+}
+
+// DeleteGlobalNetworkEndpointGroup deletes the given global NEG, and waits
+// for it to be deleted.
+func (g *gceExtendedCloud) DeleteGlobalNetworkEndpointGroup(name string) error {
+	return g.rest.DoOp("DELETE", g.rest.GlobalURL("networkEndpointGroups", name), nil)
+}
+
+// ListRegionHealthChecks returns the regional health checks of the given
+// region.
+func (g *gceExtendedCloud) ListRegionHealthChecks(region string) ([]*Resource, error) {
+	return g.list(g.rest.RegionalURL(region, "healthChecks", ""))
+}
+
+// DeleteRegionHealthCheck deletes the given regional health check, and waits
+// for it to be deleted.
+func (g *gceExtendedCloud) DeleteRegionHealthCheck(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "healthChecks", name), nil)
+}
+
+// ListServiceAttachments returns the service attachments of the given region.
+func (g *gceExtendedCloud) ListServiceAttachments(region string) ([]*Resource, error) {
+	return g.list(g.rest.RegionalURL(region, "serviceAttachments", ""))
+}
+
+// DeleteServiceAttachment deletes the given service attachment, and waits for
+// it to be deleted.
+func (g *gceExtendedCloud) DeleteServiceAttachment(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "serviceAttachments", name), nil)
+}
+
+// ListRegionAddresses returns the regional addresses of the given region.
+func (g *gceExtendedCloud) ListRegionAddresses(region string) ([]*Resource, error) {
+	return g.list(g.rest.RegionalURL(region, "addresses", ""))
+}
+
+// DeleteRegionAddress deletes the given regional address, and waits for it to
+// be deleted.
+func (g *gceExtendedCloud) DeleteRegionAddress(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "addresses", name), nil)
+}
+
+// ListRegionTargetHttpProxies returns the regional target HTTP proxies of the
+// given region.
+func (g *gceExtendedCloud) ListRegionTargetHttpProxies(region string) ([]*Resource, error) {
+	return g.list(g.rest.RegionalURL(region, "targetHttpProxies", ""))
+}
+
+// DeleteRegionTargetHttpProxy deletes the given regional target HTTP proxy, and
+// waits for it to be deleted.
+func (g *gceExtendedCloud) DeleteRegionTargetHttpProxy(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "targetHttpProxies", name), nil)
+}
+
+// ListRegionTargetHttpsProxies returns the regional target HTTPS proxies of the
+// given region.
+func (g *gceExtendedCloud) ListRegionTargetHttpsProxies(region string) ([]*Resource, error) {
+	return g.list(g.rest.RegionalURL(region, "targetHttpsProxies", ""))
+}
+
+// DeleteRegionTargetHttpsProxy deletes the given regional target HTTPS proxy,
+// and waits for it to be deleted.
+func (g *gceExtendedCloud) DeleteRegionTargetHttpsProxy(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "targetHttpsProxies", name), nil)
+}
+
+// ListRegionSslCertificates returns the regional SSL certificates of the given
+// region.
+func (g *gceExtendedCloud) ListRegionSslCertificates(region string) ([]*Resource, error) {
+	return g.list(g.rest.RegionalURL(region, "sslCertificates", ""))
+}
+
+// DeleteRegionSslCertificate deletes the given regional SSL certificate, and
+// waits for it to be deleted.
+func (g *gceExtendedCloud) DeleteRegionSslCertificate(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "sslCertificates", name), nil)
+}
+
+// ListRegionUrlMaps returns the regional url maps of the given region.
+func (g *gceExtendedCloud) ListRegionUrlMaps(region string) ([]*Resource, error) {
+	return g.list(g.rest.RegionalURL(region, "urlMaps", ""))
+}
+
+// DeleteRegionUrlMap deletes the given regional url map, and waits for it to be
+// deleted.
+func (g *gceExtendedCloud) DeleteRegionUrlMap(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "urlMaps", name), nil)
+}
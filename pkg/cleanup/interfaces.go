@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleanup
+
+import (
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Cloud lists and deletes the GCE resources created by the controller.
+type Cloud interface {
+	// Region is the region of the cluster, and of its regional resources.
+	Region() string
+
+	ListGlobalForwardingRules() (*compute.ForwardingRuleList, error)
+	DeleteGlobalForwardingRule(name string) error
+	GetGlobalAddress(name string) (*compute.Address, error)
+	DeleteGlobalAddress(name string) error
+	ListRegionForwardingRules(region string) (*compute.ForwardingRuleList, error)
+	DeleteRegionForwardingRule(name, region string) error
+
+	ListTargetHttpProxies() (*compute.TargetHttpProxyList, error)
+	DeleteTargetHttpProxy(name string) error
+	ListTargetHttpsProxies() (*compute.TargetHttpsProxyList, error)
+	DeleteTargetHttpsProxy(name string) error
+	ListSslCertificates() (*compute.SslCertificateList, error)
+	DeleteSslCertificate(name string) error
+	ListUrlMaps() (*compute.UrlMapList, error)
+	DeleteUrlMap(name string) error
+
+	ListGlobalBackendServices() (*compute.BackendServiceList, error)
+	DeleteGlobalBackendService(name string) error
+	ListRegionBackendServices(region string) (*compute.BackendServiceList, error)
+	DeleteRegionBackendService(name, region string) error
+	ListHealthChecks() (*compute.HealthCheckList, error)
+	DeleteHealthCheck(name string) error
+	ListHttpHealthChecks() (*compute.HttpHealthCheckList, error)
+	DeleteHttpHealthCheck(name string) error
+
+	ListNetworkEndpointGroup(zone string) ([]*computealpha.NetworkEndpointGroup, error)
+	DeleteNetworkEndpointGroup(name string, zone string) error
+	ListInstanceGroups(zone string) (*compute.InstanceGroupList, error)
+	DeleteInstanceGroup(name string, zone string) error
+}
+
+// ExtendedCloud lists and deletes the GCE resources the vendored compute API
+// predates, through the REST API.
+type ExtendedCloud interface {
+	// The internet NEGs of the ExternalName Services are global.
+	ListGlobalNetworkEndpointGroups() ([]*Resource, error)
+	DeleteGlobalNetworkEndpointGroup(name string) error
+
+	// The external network load balancers are health checked by regional
+	// health checks.
+	ListRegionHealthChecks(region string) ([]*Resource, error)
+	DeleteRegionHealthCheck(name, region string) error
+
+	// The PSC service attachments publish the internal L4 load balancers.
+	ListServiceAttachments(region string) ([]*Resource, error)
+	DeleteServiceAttachment(name, region string) error
+
+	// The regional HTTP(S) load balancers.
+	ListRegionAddresses(region string) ([]*Resource, error)
+	DeleteRegionAddress(name, region string) error
+	ListRegionTargetHttpProxies(region string) ([]*Resource, error)
+	DeleteRegionTargetHttpProxy(name, region string) error
+	ListRegionTargetHttpsProxies(region string) ([]*Resource, error)
+	DeleteRegionTargetHttpsProxy(name, region string) error
+	ListRegionSslCertificates(region string) ([]*Resource, error)
+	DeleteRegionSslCertificate(name, region string) error
+	ListRegionUrlMaps(region string) ([]*Resource, error)
+	DeleteRegionUrlMap(name, region string) error
+}
+
+// Resource is a GCE resource listed through the REST API, by its name and
+// description.
+type Resource struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
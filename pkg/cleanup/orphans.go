@@ -0,0 +1,223 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleanup
+
+import (
+	"path"
+
+	compute "google.golang.org/api/compute/v1"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// CleanupOrphans deletes the resources of the cluster whose Kubernetes owner
+// no longer exists, eg: left behind by a crash of the controller while an
+// Ingress was deleted:
+//   - the loadbalancer resources recording an Ingress, namespace/name, which
+//     is not in ingresses.
+//   - the backend services recording a Service, namespace/name, which is not
+//     in services, unless a url map still uses them.
+//   - the health checks no backend service uses.
+//
+// Resources without ownership metadata, eg: created by older versions of the
+// controller, are kept. Instance groups, NEGs and firewall rules are shared by
+// the Ingresses of the cluster and are left to the regular GC. Returns the
+// resources deleted, or which would be in dry run.
+func (c *Cleaner) CleanupOrphans(ingresses, services sets.String) ([]string, error) {
+	c.deleted, c.errs = nil, nil
+	c.cleanupOrphanedLoadBalancers(ingresses)
+	if usedBackends, ok := c.usedBackendServices(); ok {
+		c.cleanupOrphanedBackendServices(services, usedBackends)
+	}
+	if usedHealthChecks, ok := c.usedHealthChecks(); ok {
+		c.cleanupOrphanedHealthChecks(usedHealthChecks)
+	}
+	return c.deleted, utilerrors.NewAggregate(c.errs)
+}
+
+// orphanedByIngress returns true if the given resource was generated for one
+// of the given kinds of resources and records an Ingress which is not in
+// ingresses.
+func (c *Cleaner) orphanedByIngress(name, description string, ingresses sets.String, kinds ...string) bool {
+	if !c.owns(name, kinds...) {
+		return false
+	}
+	desc, err := utils.ParseDescription(description)
+	if err != nil || desc.IngressName == "" || desc.ClusterUID != c.namer.UID() {
+		return false
+	}
+	return !ingresses.Has(desc.IngressName)
+}
+
+// cleanupOrphanedLoadBalancers deletes the loadbalancer resources of Ingresses
+// which no longer exist, the resources using others first.
+func (c *Cleaner) cleanupOrphanedLoadBalancers(ingresses sets.String) {
+	if list, err := c.cloud.ListGlobalForwardingRules(); err != nil {
+		c.listFailed("forwarding rules", err)
+	} else {
+		for _, fr := range list.Items {
+			if !c.orphanedByIngress(fr.Name, fr.Description, ingresses, "fw", "fws", "fw6", "fws6") {
+				continue
+			}
+			name := fr.Name
+			c.delete("forwarding rule "+name, func() error { return c.cloud.DeleteGlobalForwardingRule(name) })
+			if !c.owns(name, "fw", "fw6") {
+				continue
+			}
+			if addr, err := c.cloud.GetGlobalAddress(name); err != nil || addr == nil {
+				continue
+			}
+			c.delete("global address "+name, func() error { return c.cloud.DeleteGlobalAddress(name) })
+		}
+	}
+	if list, err := c.cloud.ListTargetHttpProxies(); err != nil {
+		c.listFailed("target http proxies", err)
+	} else {
+		for _, p := range list.Items {
+			if name := p.Name; c.orphanedByIngress(name, p.Description, ingresses, "tp") {
+				c.delete("target http proxy "+name, func() error { return c.cloud.DeleteTargetHttpProxy(name) })
+			}
+		}
+	}
+	if list, err := c.cloud.ListTargetHttpsProxies(); err != nil {
+		c.listFailed("target https proxies", err)
+	} else {
+		for _, p := range list.Items {
+			if name := p.Name; c.orphanedByIngress(name, p.Description, ingresses, "tps") {
+				c.delete("target https proxy "+name, func() error { return c.cloud.DeleteTargetHttpsProxy(name) })
+			}
+		}
+	}
+	if list, err := c.cloud.ListSslCertificates(); err != nil {
+		c.listFailed("ssl certificates", err)
+	} else {
+		for _, cert := range list.Items {
+			if name := cert.Name; c.orphanedByIngress(name, cert.Description, ingresses, "ssl") {
+				c.delete("ssl certificate "+name, func() error { return c.cloud.DeleteSslCertificate(name) })
+			}
+		}
+	}
+	if list, err := c.cloud.ListUrlMaps(); err != nil {
+		c.listFailed("url maps", err)
+	} else {
+		for _, um := range list.Items {
+			if name := um.Name; c.orphanedByIngress(name, um.Description, ingresses, "um", "rm") {
+				c.delete("url map "+name, func() error { return c.cloud.DeleteUrlMap(name) })
+			}
+		}
+	}
+}
+
+// usedBackendServices returns the names of the backend services used by the
+// remaining url maps. Returns false if the url maps can't be listed.
+func (c *Cleaner) usedBackendServices() (sets.String, bool) {
+	list, err := c.cloud.ListUrlMaps()
+	if err != nil {
+		c.listFailed("url maps", err)
+		return nil, false
+	}
+	used := sets.NewString()
+	for _, um := range list.Items {
+		used.Insert(urlMapBackendServices(um)...)
+	}
+	return used, true
+}
+
+// urlMapBackendServices returns the names of the backend services the given
+// url map routes to.
+func urlMapBackendServices(um *compute.UrlMap) []string {
+	links := []string{um.DefaultService}
+	for _, pm := range um.PathMatchers {
+		links = append(links, pm.DefaultService)
+		for _, rule := range pm.PathRules {
+			links = append(links, rule.Service)
+		}
+	}
+	var names []string
+	for _, link := range links {
+		if link != "" {
+			names = append(names, path.Base(link))
+		}
+	}
+	return names
+}
+
+// cleanupOrphanedBackendServices deletes the backend services of Services
+// which no longer exist, unless they are in use.
+func (c *Cleaner) cleanupOrphanedBackendServices(services, used sets.String) {
+	list, err := c.cloud.ListGlobalBackendServices()
+	if err != nil {
+		c.listFailed("backend services", err)
+		return
+	}
+	for _, be := range list.Items {
+		name := be.Name
+		if used.Has(name) || !(c.owns(name, "be") || backends.OwnedByCluster(be, c.namer)) {
+			continue
+		}
+		desc, err := utils.ParseDescription(be.Description)
+		if err != nil || desc.ServiceName == "" || services.Has(desc.ServiceName) {
+			continue
+		}
+		c.delete("backend service "+name, func() error { return c.cloud.DeleteGlobalBackendService(name) })
+	}
+}
+
+// usedHealthChecks returns the names of the health checks used by the
+// remaining backend services. Returns false if the backend services can't be
+// listed.
+func (c *Cleaner) usedHealthChecks() (sets.String, bool) {
+	list, err := c.cloud.ListGlobalBackendServices()
+	if err != nil {
+		c.listFailed("backend services", err)
+		return nil, false
+	}
+	used := sets.NewString()
+	for _, be := range list.Items {
+		for _, link := range be.HealthChecks {
+			used.Insert(path.Base(link))
+		}
+	}
+	return used, true
+}
+
+// cleanupOrphanedHealthChecks deletes the health checks, and the legacy HTTP
+// health checks, which are not in use.
+func (c *Cleaner) cleanupOrphanedHealthChecks(used sets.String) {
+	if list, err := c.cloud.ListHealthChecks(); err != nil {
+		c.listFailed("health checks", err)
+	} else {
+		for _, hc := range list.Items {
+			if name := hc.Name; c.owns(name, "be") && !used.Has(name) {
+				c.delete("health check "+name, func() error { return c.cloud.DeleteHealthCheck(name) })
+			}
+		}
+	}
+	if list, err := c.cloud.ListHttpHealthChecks(); err != nil {
+		c.listFailed("http health checks", err)
+	} else {
+		for _, hc := range list.Items {
+			if name := hc.Name; c.owns(name, "be") && !used.Has(name) {
+				c.delete("http health check "+name, func() error { return c.cloud.DeleteHttpHealthCheck(name) })
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2/google"
+	gcfg "gopkg.in/gcfg.v1"
+
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// AuthMetadata authenticates with the token-url of the gce config or, by
+	// default, the service account of the node from the metadata server.
+	AuthMetadata = "metadata"
+	// AuthADC authenticates with the Application Default Credentials: the
+	// key file of $GOOGLE_APPLICATION_CREDENTIALS, the gcloud credentials, or
+	// the metadata server, which serves the Google service account bound to
+	// the Kubernetes service account of the controller with Workload
+	// Identity.
+	AuthADC = "adc"
+
+	// networkSuffix is the metadata path of the network of the instance.
+	networkSuffix = "instance/network-interfaces/0/network"
+)
+
+// Overrides are the settings of the flags, which take precedence over the gce
+// config and the discovery. Empty settings are not overridden.
+type Overrides struct {
+	Project string
+	Zone    string
+	// Network is the name or the URL of the network.
+	Network string
+	// Subnetwork is the name or the URL of the subnetwork of the nodes.
+	Subnetwork string
+}
+
+// MissingSettingError is the error for a setting which is neither set nor
+// can be discovered. Retrying doesn't help.
+type MissingSettingError struct {
+	// Setting is the missing setting, eg: "network".
+	Setting string
+	// Flag is the flag which sets it.
+	Flag string
+	Err  error
+}
+
+func (e *MissingSettingError) Error() string {
+	return fmt.Sprintf("couldn't discover the %v, set it with --%v: %v", e.Setting, e.Flag, e.Err)
+}
+
+// GCEMetadata is the metadata server of the instance.
+type GCEMetadata struct{}
+
+// OnGCE implements Metadata.
+func (GCEMetadata) OnGCE() bool {
+	return metadata.OnGCE()
+}
+
+// ProjectID implements Metadata.
+func (GCEMetadata) ProjectID() (string, error) {
+	return metadata.ProjectID()
+}
+
+// Zone implements Metadata.
+func (GCEMetadata) Zone() (string, error) {
+	return metadata.Zone()
+}
+
+// Get implements Metadata.
+func (GCEMetadata) Get(suffix string) (string, error) {
+	return metadata.Get(suffix)
+}
+
+// ReadConfigFile parses the given gce config.
+func ReadConfigFile(config io.Reader) (*gce.ConfigFile, error) {
+	cfg := &gce.ConfigFile{}
+	if err := gcfg.FatalOnly(gcfg.ReadInto(cfg, config)); err != nil {
+		return nil, fmt.Errorf("couldn't read config: %v", err)
+	}
+	return cfg, nil
+}
+
+// New returns the config of the GCE client of the given gce config, nil if
+// none, the overrides of the flags and the Application Default Credentials,
+// nil to authenticate as set by the gce config. The project, zone and
+// network are the ones of the overrides, of the gce config, of the
+// credentials for the project, or else of the metadata server.
+func New(configFile *gce.ConfigFile, creds *google.DefaultCredentials, overrides Overrides, md Metadata) (*gce.CloudConfig, error) {
+	if configFile == nil {
+		configFile = &gce.ConfigFile{}
+	}
+	global := configFile.Global
+	cloudConfig := &gce.CloudConfig{
+		ApiEndpoint:        global.ApiEndpoint,
+		NetworkProjectID:   global.NetworkProjectID,
+		NodeTags:           global.NodeTags,
+		NodeInstancePrefix: global.NodeInstancePrefix,
+		SecondaryRangeName: global.SecondaryRangeName,
+		UseMetadataServer:  md.OnGCE(),
+	}
+	switch {
+	case creds != nil:
+		if global.TokenURL != "" {
+			logging.Warningf("Ignoring the token-url of the gce config, authenticating with the Application Default Credentials")
+		}
+		cloudConfig.TokenSource = creds.TokenSource
+	case global.TokenURL == "nil":
+		// The default token source, ie: the Application Default Credentials.
+	case global.TokenURL != "":
+		cloudConfig.TokenSource = gce.NewAltTokenSource(global.TokenURL, global.TokenBody)
+	case !md.OnGCE():
+		// The token of the node comes from the metadata server.
+		return nil, &MissingSettingError{Setting: "credentials", Flag: "gce-auth=adc", Err: fmt.Errorf("not running on GCE")}
+	default:
+		cloudConfig.TokenSource = google.ComputeTokenSource("")
+	}
+	alphaFeatureGate, err := gce.NewAlphaFeatureGate(global.AlphaFeatures)
+	if err != nil {
+		logging.Errorf("Encountered error for creating alpha feature gate: %v", err)
+	}
+	cloudConfig.AlphaFeatureGate = alphaFeatureGate
+
+	credsProject := ""
+	if creds != nil {
+		credsProject = creds.ProjectID
+	}
+	if cloudConfig.ProjectID, err = discover(md, "project", "gce-project", md.ProjectID, overrides.Project, global.ProjectID, credsProject); err != nil {
+		return nil, err
+	}
+	if cloudConfig.Zone, err = discover(md, "zone", "gce-zone", md.Zone, overrides.Zone, global.LocalZone); err != nil {
+		return nil, err
+	}
+	if cloudConfig.Region, err = gce.GetGCERegion(cloudConfig.Zone); err != nil {
+		return nil, err
+	}
+	cloudConfig.ManagedZones = []string{cloudConfig.Zone}
+	if global.Multizone {
+		// All the zones of the region.
+		cloudConfig.ManagedZones = nil
+	}
+
+	network, err := discover(md, "network", "gce-network", func() (string, error) {
+		// The network is of the form projects/<projNum>/networks/<name>.
+		network, err := md.Get(networkSuffix)
+		if err != nil {
+			return "", err
+		}
+		return network[strings.LastIndex(network, "/")+1:], nil
+	}, overrides.Network, global.NetworkName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(network, "/") {
+		cloudConfig.NetworkURL = network
+	} else {
+		cloudConfig.NetworkName = network
+	}
+	// The subnetwork of the region is discovered from the network if unset.
+	subnetwork := overrides.Subnetwork
+	if subnetwork == "" {
+		subnetwork = global.SubnetworkName
+	}
+	if strings.Contains(subnetwork, "/") {
+		cloudConfig.SubnetworkURL = subnetwork
+	} else {
+		cloudConfig.SubnetworkName = subnetwork
+	}
+	return cloudConfig, nil
+}
+
+// discover returns the first of the given values which is set, or else the
+// value from the metadata server. The errors of values which the metadata
+// server doesn't serve, or of a metadata server which is unreachable, are
+// MissingSettingErrors.
+func discover(md Metadata, setting, flag string, get func() (string, error), values ...string) (string, error) {
+	for _, v := range values {
+		if v != "" {
+			return v, nil
+		}
+	}
+	if !md.OnGCE() {
+		return "", &MissingSettingError{Setting: setting, Flag: flag, Err: fmt.Errorf("not running on GCE")}
+	}
+	v, err := get()
+	if _, ok := err.(metadata.NotDefinedError); ok {
+		return "", &MissingSettingError{Setting: setting, Flag: flag, Err: err}
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't discover the %v from the metadata server: %v", setting, err)
+	}
+	logging.Infof("Discovered %v %q from the metadata server", setting, v)
+	return v, nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
+)
+
+func TestNew(t *testing.T) {
+	md := &FakeMetadata{Project: "node-project", LocalZone: "us-central1-b", Network: "projects/123/networks/default"}
+	adcToken := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "adc"})
+	for _, tc := range []struct {
+		desc        string
+		config      string
+		creds       *google.DefaultCredentials
+		overrides   Overrides
+		md          *FakeMetadata
+		wantProject string
+		wantZone    string
+		wantNetwork string
+		wantURL     string
+		// wantSubnetwork is the subnetwork name or URL.
+		wantSubnetwork string
+	}{
+		{
+			desc:        "discovered from the metadata server",
+			md:          md,
+			wantProject: "node-project",
+			wantZone:    "us-central1-b",
+			wantNetwork: "default",
+		},
+		{
+			desc:        "gce config",
+			config:      "[global]\nproject-id = config-project\nlocal-zone = europe-west1-c\nnetwork-name = config-net\n",
+			md:          md,
+			wantProject: "config-project",
+			wantZone:    "europe-west1-c",
+			wantNetwork: "config-net",
+		},
+		{
+			desc:        "overrides take precedence over the gce config",
+			config:      "[global]\nproject-id = config-project\nlocal-zone = europe-west1-c\nnetwork-name = config-net\n",
+			overrides:   Overrides{Project: "flag-project", Zone: "asia-east1-a", Network: "projects/host/global/networks/shared"},
+			md:          md,
+			wantProject: "flag-project",
+			wantZone:    "asia-east1-a",
+			wantURL:     "projects/host/global/networks/shared",
+		},
+		{
+			desc:        "project of the credentials",
+			creds:       &google.DefaultCredentials{ProjectID: "key-project", TokenSource: adcToken},
+			md:          md,
+			wantProject: "key-project",
+			wantZone:    "us-central1-b",
+			wantNetwork: "default",
+		},
+		{
+			desc:        "off GCE with overrides",
+			creds:       &google.DefaultCredentials{ProjectID: "key-project", TokenSource: adcToken},
+			overrides:   Overrides{Zone: "us-east1-d", Network: "default"},
+			md:          &FakeMetadata{OffGCE: true},
+			wantProject: "key-project",
+			wantZone:    "us-east1-d",
+			wantNetwork: "default",
+		},
+		{
+			desc:           "subnetwork override",
+			config:         "[global]\nsubnetwork-name = config-subnet\n",
+			creds:          &google.DefaultCredentials{TokenSource: adcToken},
+			overrides:      Overrides{Project: "p", Zone: "us-east1-d", Network: "vpc", Subnetwork: "projects/p/regions/us-east1/subnetworks/nodes"},
+			md:             &FakeMetadata{OffGCE: true},
+			wantProject:    "p",
+			wantZone:       "us-east1-d",
+			wantNetwork:    "vpc",
+			wantSubnetwork: "projects/p/regions/us-east1/subnetworks/nodes",
+		},
+		{
+			desc:           "subnetwork of the gce config",
+			config:         "[global]\nsubnetwork-name = config-subnet\n",
+			md:             md,
+			wantProject:    "node-project",
+			wantZone:       "us-central1-b",
+			wantNetwork:    "default",
+			wantSubnetwork: "config-subnet",
+		},
+	} {
+		var configFile *gce.ConfigFile
+		if tc.config != "" {
+			var err error
+			if configFile, err = ReadConfigFile(strings.NewReader(tc.config)); err != nil {
+				t.Fatalf("%v: ReadConfigFile() = _, %v", tc.desc, err)
+			}
+		}
+		cfg, err := New(configFile, tc.creds, tc.overrides, tc.md)
+		if err != nil {
+			t.Errorf("%v: New() = _, %v", tc.desc, err)
+			continue
+		}
+		if cfg.ProjectID != tc.wantProject || cfg.Zone != tc.wantZone || cfg.NetworkName != tc.wantNetwork || cfg.NetworkURL != tc.wantURL {
+			t.Errorf("%v: got project %q, zone %q, network %q and network URL %q, want %q, %q, %q and %q", tc.desc, cfg.ProjectID, cfg.Zone, cfg.NetworkName, cfg.NetworkURL, tc.wantProject, tc.wantZone, tc.wantNetwork, tc.wantURL)
+		}
+		if subnetwork := cfg.SubnetworkName + cfg.SubnetworkURL; subnetwork != tc.wantSubnetwork {
+			t.Errorf("%v: got subnetwork %q, want %q", tc.desc, subnetwork, tc.wantSubnetwork)
+		}
+		if region, _ := gce.GetGCERegion(tc.wantZone); cfg.Region != region || len(cfg.ManagedZones) != 1 || cfg.ManagedZones[0] != tc.wantZone {
+			t.Errorf("%v: got region %q and zones %v for zone %q", tc.desc, cfg.Region, cfg.ManagedZones, tc.wantZone)
+		}
+		if cfg.UseMetadataServer != !tc.md.OffGCE {
+			t.Errorf("%v: got UseMetadataServer %v, want %v", tc.desc, cfg.UseMetadataServer, !tc.md.OffGCE)
+		}
+		if tc.creds != nil && cfg.TokenSource != tc.creds.TokenSource {
+			t.Errorf("%v: got token source %v, want the one of the credentials", tc.desc, cfg.TokenSource)
+		}
+	}
+}
+
+func TestNewTokenSource(t *testing.T) {
+	md := &FakeMetadata{Project: "p", LocalZone: "us-central1-b", Network: "projects/123/networks/default"}
+	adcToken := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "adc"})
+	altConfig, err := ReadConfigFile(strings.NewReader("[global]\ntoken-url = https://example.com/token\n"))
+	if err != nil {
+		t.Fatalf("ReadConfigFile() = _, %v", err)
+	}
+	nilConfig, err := ReadConfigFile(strings.NewReader("[global]\ntoken-url = nil\n"))
+	if err != nil {
+		t.Fatalf("ReadConfigFile() = _, %v", err)
+	}
+	for _, tc := range []struct {
+		desc   string
+		config *gce.ConfigFile
+		creds  *google.DefaultCredentials
+		check  func(oauth2.TokenSource) bool
+	}{
+		{"node service account", nil, nil, func(ts oauth2.TokenSource) bool { return ts != nil }},
+		{"token-url", altConfig, nil, func(ts oauth2.TokenSource) bool { return strings.Contains(fmt.Sprintf("%#v", ts), "AltTokenSource") }},
+		{"default token source", nilConfig, nil, func(ts oauth2.TokenSource) bool { return ts == nil }},
+		{"credentials override the token-url", altConfig, &google.DefaultCredentials{TokenSource: adcToken}, func(ts oauth2.TokenSource) bool { return ts == adcToken }},
+	} {
+		cfg, err := New(tc.config, tc.creds, Overrides{}, md)
+		if err != nil {
+			t.Fatalf("%v: New() = _, %v", tc.desc, err)
+		}
+		if !tc.check(cfg.TokenSource) {
+			t.Errorf("%v: got unexpected token source %#v", tc.desc, cfg.TokenSource)
+		}
+	}
+}
+
+func TestNewMissingSetting(t *testing.T) {
+	for _, tc := range []struct {
+		desc      string
+		overrides Overrides
+		md        *FakeMetadata
+		want      string
+	}{
+		{"off GCE", Overrides{}, &FakeMetadata{OffGCE: true}, "project"},
+		{"off GCE without zone", Overrides{Project: "p"}, &FakeMetadata{OffGCE: true}, "zone"},
+		// The GKE metadata server of Workload Identity doesn't serve the
+		// network interfaces.
+		{"network not served", Overrides{}, &FakeMetadata{Project: "p", LocalZone: "us-central1-b"}, "network"},
+	} {
+		creds := &google.DefaultCredentials{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{})}
+		_, err := New(nil, creds, tc.overrides, tc.md)
+		if missing, ok := err.(*MissingSettingError); !ok || missing.Setting != tc.want || missing.Flag != "gce-"+tc.want {
+			t.Errorf("%v: New() = _, %v, want a missing %v", tc.desc, err, tc.want)
+		}
+	}
+	// Off GCE, the token of the node isn't available.
+	overrides := Overrides{Project: "p", Zone: "us-central1-b", Network: "default"}
+	if _, err := New(nil, nil, overrides, &FakeMetadata{OffGCE: true}); err == nil {
+		t.Errorf("New() = _, nil, want an error for the missing credentials off GCE")
+	} else if missing, ok := err.(*MissingSettingError); !ok || missing.Setting != "credentials" {
+		t.Errorf("New() = _, %v, want missing credentials", err)
+	}
+}
@@ -0,0 +1,25 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cloudconfig generates the config of the GCE client of the
+// controller from the gce config, the flags and the environment. The client
+// authenticates either with the token source of the gce config, by default
+// the service account of the node, or with the Application Default
+// Credentials, eg: a Google service account bound to the Kubernetes service
+// account of the controller with Workload Identity. The project, zone and
+// network not set by the flags or the gce config are discovered from the
+// credentials and the metadata server.
+package cloudconfig
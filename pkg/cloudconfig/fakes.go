@@ -0,0 +1,63 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+import (
+	"cloud.google.com/go/compute/metadata"
+)
+
+// FakeMetadata is a metadata server serving fixed values. Empty values are
+// not defined.
+type FakeMetadata struct {
+	Project   string
+	LocalZone string
+	// Network is the path of the network of the first network interface, eg:
+	// projects/123/networks/default.
+	Network string
+	// OffGCE is true when the metadata server is unreachable.
+	OffGCE bool
+}
+
+// OnGCE implements Metadata.
+func (f *FakeMetadata) OnGCE() bool {
+	return !f.OffGCE
+}
+
+// ProjectID implements Metadata.
+func (f *FakeMetadata) ProjectID() (string, error) {
+	return f.get("project/project-id", f.Project)
+}
+
+// Zone implements Metadata.
+func (f *FakeMetadata) Zone() (string, error) {
+	return f.get("instance/zone", f.LocalZone)
+}
+
+// Get implements Metadata.
+func (f *FakeMetadata) Get(suffix string) (string, error) {
+	if suffix == networkSuffix {
+		return f.get(suffix, f.Network)
+	}
+	return f.get(suffix, "")
+}
+
+func (f *FakeMetadata) get(suffix, value string) (string, error) {
+	if f.OffGCE || value == "" {
+		return "", metadata.NotDefinedError(suffix)
+	}
+	return value, nil
+}
@@ -0,0 +1,30 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudconfig
+
+// Metadata is the GCE metadata server of the instance the controller runs
+// on. With Workload Identity, it is the GKE metadata server of the node,
+// which doesn't serve all the instance metadata.
+type Metadata interface {
+	// OnGCE returns whether the metadata server is reachable.
+	OnGCE() bool
+	ProjectID() (string, error)
+	Zone() (string, error)
+	// Get returns the metadata value of the given path, eg:
+	// instance/network-interfaces/0/network.
+	Get(suffix string) (string, error)
+}
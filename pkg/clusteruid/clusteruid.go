@@ -0,0 +1,134 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusteruid
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// maxNameLen is the maximum length of the names which aren't truncated by the
+// namer. A truncated name may have lost the end of its UID.
+const maxNameLen = 62
+
+// forwardingRuleKinds are the resource tags of the forwarding rules, which
+// carry the IPs of the Ingresses.
+var forwardingRuleKinds = []string{"fw", "fws", "fw6", "fws6"}
+
+// AmbiguousError is returned when the resources of the cluster are tagged
+// with several UIDs.
+type AmbiguousError struct {
+	// Evidence is the names of the resources tagged with each UID.
+	Evidence map[string][]string
+}
+
+func (e *AmbiguousError) Error() string {
+	var uids []string
+	for uid := range e.Evidence {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	var found []string
+	for _, uid := range uids {
+		found = append(found, fmt.Sprintf("%q (%v)", uid, strings.Join(e.Evidence[uid], ", ")))
+	}
+	return fmt.Sprintf("the load balancers of the cluster are tagged with several cluster UIDs: %v; set --cluster-uid to the UID of the cluster", strings.Join(found, ", "))
+}
+
+// Recover returns the UID of the cluster recovered from its GCE ingresses
+// with an IP: from the forwarding rule recorded in their annotations, and
+// from the forwarding rules of the given prefix serving their IPs. Returns
+// false if the cluster has no such Ingress, and an error if the UID can't be
+// recovered or is ambiguous. Note that the empty UID is a valid UID.
+func Recover(cloud Cloud, prefix string, ings []extensions.Ingress) (string, bool, error) {
+	namer := utils.NewNamerWithPrefix("", "", prefix)
+	ips := map[string]string{}
+	evidence := map[string][]string{}
+	add := func(name, source string) {
+		if !strings.HasPrefix(name, namer.Prefix()+"-") || len(name) > maxNameLen {
+			logging.V(3).Infof("Ignoring %v of %v, not a complete name of the namer", name, source)
+			return
+		}
+		c := namer.ParseName(name)
+		if !isForwardingRule(c.Resource) {
+			return
+		}
+		evidence[c.ClusterName] = append(evidence[c.ClusterName], fmt.Sprintf("%v of %v", name, source))
+	}
+	for i := range ings {
+		ing := &ings[i]
+		if !isGCEIngress(ing) || len(ing.Status.LoadBalancer.Ingress) == 0 {
+			continue
+		}
+		key := fmt.Sprintf("Ingress %v/%v", ing.Namespace, ing.Name)
+		for _, lbIng := range ing.Status.LoadBalancer.Ingress {
+			if lbIng.IP != "" {
+				ips[lbIng.IP] = key
+			}
+		}
+		if name := loadbalancers.GCEResourceName(ing.Annotations, "forwarding-rule"); name != "" {
+			add(name, key)
+		}
+	}
+	if len(ips) == 0 {
+		return "", false, nil
+	}
+
+	rules, err := cloud.ListGlobalForwardingRules()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list the forwarding rules: %v", err)
+	}
+	for _, rule := range rules.Items {
+		if key, ok := ips[rule.IPAddress]; ok {
+			add(rule.Name, fmt.Sprintf("IP %v of %v", rule.IPAddress, key))
+		}
+	}
+
+	switch len(evidence) {
+	case 0:
+		return "", false, fmt.Errorf("found Ingresses with IPs but no forwarding rule tagged with the cluster UID; set --cluster-uid to the UID of the cluster")
+	case 1:
+		for uid, found := range evidence {
+			logging.Infof("Recovered cluster uid %q from %v", uid, strings.Join(found, ", "))
+			return uid, true, nil
+		}
+	}
+	return "", false, &AmbiguousError{Evidence: evidence}
+}
+
+func isForwardingRule(kind string) bool {
+	for _, k := range forwardingRuleKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// isGCEIngress returns true if the given Ingress is handled by the
+// controller, see controller.isGCEIngress.
+func isGCEIngress(ing *extensions.Ingress) bool {
+	class := annotations.IngAnnotations(ing.ObjectMeta.Annotations).IngressClass()
+	return class == "" || class == annotations.GceIngressClass
+}
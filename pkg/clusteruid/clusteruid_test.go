@@ -0,0 +1,184 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusteruid
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+)
+
+type fakeCloud struct {
+	rules []*compute.ForwardingRule
+	err   error
+}
+
+func (f *fakeCloud) ListGlobalForwardingRules() (*compute.ForwardingRuleList, error) {
+	return &compute.ForwardingRuleList{Items: f.rules}, f.err
+}
+
+func rule(name, ip string) *compute.ForwardingRule {
+	return &compute.ForwardingRule{Name: name, IPAddress: ip}
+}
+
+func ing(name, ip, forwardingRule string, annots map[string]string) extensions.Ingress {
+	ing := extensions.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{}},
+	}
+	for k, v := range annots {
+		ing.Annotations[k] = v
+	}
+	if forwardingRule != "" {
+		ing.Annotations["ingress.kubernetes.io/forwarding-rule"] = forwardingRule
+	}
+	if ip != "" {
+		ing.Status.LoadBalancer.Ingress = []api_v1.LoadBalancerIngress{{IP: ip}}
+	}
+	return ing
+}
+
+func TestRecover(t *testing.T) {
+	otherClass := map[string]string{annotations.IngressClassKey: "nginx"}
+	// A truncated forwarding rule name, which lost the end of its uid.
+	truncated := fmt.Sprintf("k8s-fw-default-%v--uid10", strings.Repeat("a", 50))
+	for _, tc := range []struct {
+		desc      string
+		prefix    string
+		ings      []extensions.Ingress
+		rules     []*compute.ForwardingRule
+		uid       string
+		found     bool
+		wantErr   bool
+		ambiguous bool
+	}{
+		{
+			desc:  "no ingress",
+			found: false,
+		},
+		{
+			desc:  "ingresses without ip",
+			ings:  []extensions.Ingress{ing("a", "", "", nil)},
+			rules: []*compute.ForwardingRule{rule("k8s-fw-default-a--uid1", "1.2.3.4")},
+			found: false,
+		},
+		{
+			desc:  "ingress of another class",
+			ings:  []extensions.Ingress{ing("a", "1.2.3.4", "", otherClass)},
+			rules: []*compute.ForwardingRule{rule("k8s-fw-default-a--uid1", "1.2.3.4")},
+			found: false,
+		},
+		{
+			desc:  "from the annotation",
+			ings:  []extensions.Ingress{ing("a", "1.2.3.4", "k8s-fw-default-a--uid1", nil)},
+			uid:   "uid1",
+			found: true,
+		},
+		{
+			desc: "from the forwarding rules of the ips",
+			ings: []extensions.Ingress{ing("a", "1.2.3.4", "", nil), ing("b", "1.2.3.5", "", nil)},
+			rules: []*compute.ForwardingRule{
+				rule("k8s-fw-default-a--uid1", "1.2.3.4"),
+				rule("k8s-fws-default-a--uid1", "1.2.3.4"),
+				rule("k8s-fw-default-b--uid1", "1.2.3.5"),
+				// Forwarding rules of other clusters.
+				rule("k8s-fw-default-a--uid2", "1.2.3.6"),
+				rule("other-fw-default-a", "1.2.3.4"),
+			},
+			uid:   "uid1",
+			found: true,
+		},
+		{
+			desc:  "empty uid",
+			ings:  []extensions.Ingress{ing("a", "1.2.3.4", "k8s-fw-default-a", nil)},
+			rules: []*compute.ForwardingRule{rule("k8s-fw-default-a", "1.2.3.4")},
+			uid:   "",
+			found: true,
+		},
+		{
+			desc:   "custom prefix",
+			prefix: "gke",
+			ings:   []extensions.Ingress{ing("a", "1.2.3.4", "", nil)},
+			rules: []*compute.ForwardingRule{
+				rule("k8s-fw-default-a--uid2", "1.2.3.4"),
+				rule("gke-fw-default-a--uid1", "1.2.3.4"),
+			},
+			uid:   "uid1",
+			found: true,
+		},
+		{
+			desc:  "truncated names are ignored",
+			ings:  []extensions.Ingress{ing("a", "1.2.3.4", truncated, nil), ing("b", "1.2.3.5", "", nil)},
+			rules: []*compute.ForwardingRule{rule("k8s-fw-default-b--uid1", "1.2.3.5")},
+			uid:   "uid1",
+			found: true,
+		},
+		{
+			desc:    "no evidence",
+			ings:    []extensions.Ingress{ing("a", "1.2.3.4", "", nil)},
+			rules:   []*compute.ForwardingRule{rule("k8s-um-default-a--uid1", "1.2.3.4")},
+			wantErr: true,
+		},
+		{
+			desc: "ambiguous",
+			ings: []extensions.Ingress{ing("a", "1.2.3.4", "k8s-fw-default-a--uid1", nil), ing("b", "1.2.3.5", "", nil)},
+			rules: []*compute.ForwardingRule{
+				rule("k8s-fw-default-a--uid1", "1.2.3.4"),
+				rule("k8s-fw-default-b--uid2", "1.2.3.5"),
+			},
+			wantErr:   true,
+			ambiguous: true,
+		},
+		{
+			desc:      "ambiguous with the empty uid",
+			ings:      []extensions.Ingress{ing("a", "1.2.3.4", "k8s-fw-default-a", nil)},
+			rules:     []*compute.ForwardingRule{rule("k8s-fw-default-a--uid1", "1.2.3.4")},
+			wantErr:   true,
+			ambiguous: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			prefix := tc.prefix
+			if prefix == "" {
+				prefix = "k8s"
+			}
+			uid, found, err := Recover(&fakeCloud{rules: tc.rules}, prefix, tc.ings)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Recover() = %v, want error %v", err, tc.wantErr)
+			}
+			if _, ok := err.(*AmbiguousError); ok != tc.ambiguous {
+				t.Errorf("Recover() = %v, want ambiguous error %v", err, tc.ambiguous)
+			}
+			if err == nil && (uid != tc.uid || found != tc.found) {
+				t.Errorf("Recover() = %q, %v, want %q, %v", uid, found, tc.uid, tc.found)
+			}
+		})
+	}
+}
+
+func TestRecoverListError(t *testing.T) {
+	ings := []extensions.Ingress{ing("a", "1.2.3.4", "", nil)}
+	if _, _, err := Recover(&fakeCloud{err: fmt.Errorf("boom")}, "k8s", ings); err == nil {
+		t.Errorf("Recover() = nil, want the error of the cloud")
+	}
+}
@@ -0,0 +1,22 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clusteruid recovers the UID of the cluster, which tags the names of
+// all the GCE resources of the controller, when the ConfigMap recording it is
+// lost. Generating a new UID instead would orphan all the existing load
+// balancers, so the UID is recovered from the resources serving the Ingresses
+// of the cluster, and conflicting evidence is an error.
+package clusteruid
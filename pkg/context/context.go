@@ -17,11 +17,17 @@ limitations under the License.
 package context
 
 import (
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	informerv1 "k8s.io/client-go/informers/core/v1"
 	informerv1beta1 "k8s.io/client-go/informers/extensions/v1beta1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
-	"time"
 )
 
 // ControllerContext holds
@@ -31,6 +37,8 @@ type ControllerContext struct {
 	PodInformer      cache.SharedIndexInformer
 	NodeInformer     cache.SharedIndexInformer
 	EndpointInformer cache.SharedIndexInformer
+	// SecretInformer only watches the TLS Secrets.
+	SecretInformer cache.SharedIndexInformer
 	// Stop is the stop channel shared among controllers
 	StopCh chan struct{}
 }
@@ -41,6 +49,7 @@ func NewControllerContext(kubeClient kubernetes.Interface, namespace string, res
 		ServiceInformer: informerv1.NewServiceInformer(kubeClient, namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
 		PodInformer:     informerv1.NewPodInformer(kubeClient, namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
 		NodeInformer:    informerv1.NewNodeInformer(kubeClient, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+		SecretInformer:  newTLSSecretInformer(kubeClient, namespace, resyncPeriod),
 		StopCh:          make(chan struct{}),
 	}
 	if enableEndpointsInformer {
@@ -49,11 +58,33 @@ func NewControllerContext(kubeClient kubernetes.Interface, namespace string, res
 	return context
 }
 
+// newTLSSecretInformer returns an informer of the Secrets of type
+// kubernetes.io/tls, the other Secrets are not cached.
+func newTLSSecretInformer(kubeClient kubernetes.Interface, namespace string, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector("type", string(api_v1.SecretTypeTLS)).String()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return kubeClient.CoreV1().Secrets(namespace).List(options)
+			},
+			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return kubeClient.CoreV1().Secrets(namespace).Watch(options)
+			},
+		},
+		&api_v1.Secret{},
+		resyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+}
+
 func (ctx *ControllerContext) Start() {
 	go ctx.IngressInformer.Run(ctx.StopCh)
 	go ctx.ServiceInformer.Run(ctx.StopCh)
 	go ctx.PodInformer.Run(ctx.StopCh)
 	go ctx.NodeInformer.Run(ctx.StopCh)
+	go ctx.SecretInformer.Run(ctx.StopCh)
 	if ctx.EndpointInformer != nil {
 		go ctx.EndpointInformer.Run(ctx.StopCh)
 	}
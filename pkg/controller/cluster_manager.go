@@ -18,17 +18,22 @@ package controller
 
 import (
 	"net/http"
-
-	"github.com/golang/glog"
+	"reflect"
+	"sync"
+	"time"
 
 	compute "google.golang.org/api/compute/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	gce "k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
 
 	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/cleanup"
+	"k8s.io/ingress-gce/pkg/dns"
 	"k8s.io/ingress-gce/pkg/firewalls"
 	"k8s.io/ingress-gce/pkg/healthchecks"
 	"k8s.io/ingress-gce/pkg/instances"
 	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/ingress-gce/pkg/utils"
 )
 
@@ -59,7 +64,7 @@ const (
 // ClusterManager manages cluster resource pools.
 type ClusterManager struct {
 	ClusterNamer           *utils.Namer
-	defaultBackendNodePort backends.ServicePort
+	defaultBackendNodePort *backends.ServicePort
 	instancePool           instances.NodePool
 	backendPool            backends.BackendPool
 	l7Pool                 loadbalancers.LoadBalancerPool
@@ -72,12 +77,54 @@ type ClusterManager struct {
 	// backend is tied to the last/first loadbalancer not the life of the
 	// nodeport service or Ingress.
 	healthCheckers []healthchecks.HealthChecker
+
+	// orphanCleaner deletes the resources whose Kubernetes owner no longer
+	// exists. Nil if not backed by a cloud.
+	orphanCleaner *cleanup.Cleaner
+
+	// multiClusterBackends registers the backends of the multi-cluster
+	// Ingresses into the backend services of the config cluster. Nil if the
+	// cluster is not a member of a multi-cluster Ingress.
+	multiClusterBackends *backends.MultiClusterBackends
+
+	// dnsRecords manages the DNS records of the hosts of the Ingresses. Nil
+	// if the records are not managed.
+	dnsRecords *dns.Records
+
+	// fullSyncPeriod is how often all the components of the load balancers
+	// are synced. In between, Checkpoint skips the components whose inputs
+	// didn't change since their last successful sync. Zero syncs all the
+	// components every time.
+	fullSyncPeriod time.Duration
+	// syncedLock guards the fields below, also used by the node sync.
+	syncedLock sync.Mutex
+	// l4Users are the keys of the Services whose internal load balancers
+	// use the instance groups, which are kept while there are any.
+	l4Users sets.String
+	// syncedInputs are the inputs of the last successful sync of each
+	// component, by component.
+	syncedInputs map[string]interface{}
+	// lastFullSync is the time of the last successful sync of all the
+	// components.
+	lastFullSync time.Time
+	// igs are the instance groups of the last sync of the instance groups.
+	igs []*compute.InstanceGroup
+}
+
+// firewallInputs are the inputs of the sync of the firewall rules.
+type firewallInputs struct {
+	ports     sets.Int64
+	negPorts  sets.Int64
+	nodeNames sets.String
+	srcRanges sets.String
+	networks  sets.String
 }
 
 // Init initializes the cluster manager.
 func (c *ClusterManager) Init(tr *GCETranslator) {
 	c.instancePool.Init(tr)
 	c.backendPool.Init(tr)
+	c.firewallPool.Init(tr)
 	// TODO: Initialize other members as needed.
 }
 
@@ -91,7 +138,7 @@ func (c *ClusterManager) IsHealthy() (err error) {
 	// effectively useless, but it is healthy. Reporting it as unhealthy
 	// will lead to container crashlooping.
 	if utils.IsHTTPErrorCode(err, http.StatusForbidden) {
-		glog.Infof("Reporting cluster as healthy, but unable to list backends: %v", err)
+		logging.Infof("Reporting cluster as healthy, but unable to list backends: %v", err)
 		return nil
 	}
 	return
@@ -120,45 +167,191 @@ func (c *ClusterManager) shutdown() error {
 // - backendServicePorts are the ports for which we require BackendServices.
 // - namedPorts are the ports which must be opened on instance groups.
 // - firewallPorts are the ports which must be opened in the firewall rule.
+// - negFirewallPorts are the pod ports of NEG backends, which must be opened
+//   in the NEG firewall rule.
+// - firewallSrcRanges are source ranges requested by Ingresses, allowed in
+//   addition to the ranges configured for the firewall pool.
+// - firewallNetworks are networks requested by Ingresses, on which the
+//   firewall rules are created in addition to the cluster network.
 // Returns the list of all instance groups corresponding to the given loadbalancers.
 // If in performing the checkpoint the cluster manager runs out of quota, a
 // googleapi 403 is returned.
-func (c *ClusterManager) Checkpoint(lbs []*loadbalancers.L7RuntimeInfo, nodeNames []string, backendServicePorts []backends.ServicePort, namedPorts []backends.ServicePort, firewallPorts []int64) ([]*compute.InstanceGroup, error) {
-	if len(namedPorts) != 0 {
+func (c *ClusterManager) Checkpoint(lbs []*loadbalancers.L7RuntimeInfo, nodeNames []string, backendServicePorts []backends.ServicePort, namedPorts []backends.ServicePort, firewallPorts []int64, negFirewallPorts []int64, firewallSrcRanges []string, firewallNetworks []string) ([]*compute.InstanceGroup, error) {
+	if len(namedPorts) != 0 && c.defaultBackendNodePort != nil {
 		// Add the default backend node port to the list of named ports for instance groups.
-		namedPorts = append(namedPorts, c.defaultBackendNodePort)
+		namedPorts = append(namedPorts, *c.defaultBackendNodePort)
 	}
 	// Multiple ingress paths can point to the same service (and hence nodePort)
 	// but each nodePort can only have one set of cloud resources behind it. So
 	// don't waste time double validating GCE BackendServices.
 	namedPorts = uniq(namedPorts)
 	backendServicePorts = uniq(backendServicePorts)
+
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	fullSync := c.fullSyncPeriod == 0 || time.Since(c.lastFullSync) >= c.fullSyncPeriod
+	if fullSync {
+		c.syncedInputs = nil
+	}
+	start := time.Now()
+
+	// The order of the ports doesn't matter, compare them by key.
+	namedPortInputs := servicePortsByKey(namedPorts)
 	// Create Instance Groups.
-	igs, err := c.EnsureInstanceGroupsAndPorts(namedPorts)
-	if err != nil {
-		return igs, err
+	igs := c.igs
+	if c.changed(componentInstanceGroups, namedPortInputs) {
+		var err error
+		if igs, err = c.EnsureInstanceGroupsAndPorts(namedPorts); err != nil {
+			syncErrors.WithLabelValues(componentInstanceGroups).Inc()
+			return igs, err
+		}
+		c.igs = igs
+		c.setSynced(componentInstanceGroups, namedPortInputs)
 	}
-	if err := c.backendPool.Ensure(backendServicePorts, igs); err != nil {
+	// The backend services are synced again if the instance groups change.
+	backendInputs := []interface{}{servicePortsByKey(backendServicePorts), igs}
+	if c.changed(componentBackends, backendInputs) {
+		if err := c.backendPool.Ensure(backendServicePorts, igs); err != nil {
+			syncErrors.WithLabelValues(componentBackends).Inc()
+			return igs, err
+		}
+		c.setSynced(componentBackends, backendInputs)
+	}
+	if err := c.syncNodes(nodeNames); err != nil {
 		return igs, err
 	}
+	lbsByName := map[string]*loadbalancers.L7RuntimeInfo{}
+	for _, lb := range lbs {
+		lbsByName[lb.Name] = lb
+	}
+	if c.changed(componentLoadBalancers, lbsByName) {
+		if err := c.l7Pool.Sync(lbs); err != nil {
+			syncErrors.WithLabelValues(componentLoadBalancers).Inc()
+			return igs, err
+		}
+		c.setSynced(componentLoadBalancers, lbsByName)
+	}
+
+	fwInputs := firewallInputs{
+		ports:     sets.NewInt64(firewallPorts...),
+		negPorts:  sets.NewInt64(negFirewallPorts...),
+		nodeNames: sets.NewString(nodeNames...),
+		srcRanges: sets.NewString(firewallSrcRanges...),
+		networks:  sets.NewString(firewallNetworks...),
+	}
+	if c.changed(componentFirewalls, fwInputs) {
+		if err := c.firewallPool.Sync(firewallPorts, negFirewallPorts, nodeNames, firewallSrcRanges, firewallNetworks); err != nil {
+			syncErrors.WithLabelValues(componentFirewalls).Inc()
+			return igs, err
+		}
+		c.setSynced(componentFirewalls, fwInputs)
+	}
+
+	if fullSync {
+		c.lastFullSync = start
+	}
+	managedResources.WithLabelValues("load_balancers").Set(float64(len(lbs)))
+	managedResources.WithLabelValues("backend_services").Set(float64(len(backendServicePorts)))
+	managedResources.WithLabelValues("instance_groups").Set(float64(len(igs)))
+	managedResources.WithLabelValues("instances").Set(float64(len(nodeNames)))
+	return igs, nil
+}
+
+// SyncNodes syncs the instance groups with the given nodes.
+func (c *ClusterManager) SyncNodes(nodeNames []string) error {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	return c.syncNodes(nodeNames)
+}
+
+// syncNodes syncs the instance groups with the given nodes, unless they
+// were synced with the same nodes already. The caller holds syncedLock.
+func (c *ClusterManager) syncNodes(nodeNames []string) error {
+	nodes := sets.NewString(nodeNames...)
+	if !c.changed(componentInstances, nodes) {
+		return nil
+	}
 	if err := c.instancePool.Sync(nodeNames); err != nil {
-		return igs, err
+		syncErrors.WithLabelValues(componentInstances).Inc()
+		return err
 	}
-	if err := c.l7Pool.Sync(lbs); err != nil {
-		return igs, err
+	c.setSynced(componentInstances, nodes)
+	return nil
+}
+
+// changed returns true if the given inputs of the component differ from the
+// inputs of its last successful sync. The caller holds syncedLock.
+func (c *ClusterManager) changed(component string, inputs interface{}) bool {
+	synced, ok := c.syncedInputs[component]
+	if ok && reflect.DeepEqual(synced, inputs) {
+		logging.V(4).Infof("Skipping the sync of %v, its inputs didn't change", component)
+		return false
 	}
+	return true
+}
 
-	if err := c.firewallPool.Sync(firewallPorts, nodeNames); err != nil {
-		return igs, err
+// setSynced records the inputs of the successful sync of the component. The
+// caller holds syncedLock.
+func (c *ClusterManager) setSynced(component string, inputs interface{}) {
+	if c.syncedInputs == nil {
+		c.syncedInputs = map[string]interface{}{}
 	}
+	c.syncedInputs[component] = inputs
+}
+
+// resetSynced forgets the inputs of the last syncs, so that all the
+// components are synced next time, eg: after resources were deleted.
+func (c *ClusterManager) resetSynced() {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	c.syncedInputs = nil
+}
 
+// EnsureL4InstanceGroups returns the instance groups of the nodes for the
+// internal load balancer of the given Service key, creating them if needed,
+// and syncs them with the given nodes.
+func (c *ClusterManager) EnsureL4InstanceGroups(key string, nodeNames []string) ([]*compute.InstanceGroup, error) {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	if c.l4Users == nil {
+		c.l4Users = sets.NewString()
+	}
+	c.l4Users.Insert(key)
+	igs, err := instances.EnsureInstanceGroupsAndPorts(c.instancePool, c.ClusterNamer, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The instance groups may have just been created, without Ingresses.
+	if err := c.instancePool.Sync(nodeNames); err != nil {
+		syncErrors.WithLabelValues(componentInstances).Inc()
+		return nil, err
+	}
+	c.setSynced(componentInstances, sets.NewString(nodeNames...))
 	return igs, nil
 }
 
+// ReleaseL4InstanceGroups records that the internal load balancer of the
+// given Service key no longer uses the instance groups.
+func (c *ClusterManager) ReleaseL4InstanceGroups(key string) {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	c.l4Users.Delete(key)
+}
+
+// hasL4Users returns true if internal load balancers use the instance groups.
+func (c *ClusterManager) hasL4Users() bool {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	return c.l4Users.Len() > 0
+}
+
 func (c *ClusterManager) EnsureInstanceGroupsAndPorts(servicePorts []backends.ServicePort) ([]*compute.InstanceGroup, error) {
 	ports := []int64{}
 	for _, p := range servicePorts {
-		ports = append(ports, p.Port)
+		// Serverless and internet backends have no node port.
+		if p.HasNodePort() {
+			ports = append(ports, p.Port)
+		}
 	}
 	igs, err := instances.EnsureInstanceGroupsAndPorts(c.instancePool, c.ClusterNamer, ports)
 	return igs, err
@@ -168,7 +361,8 @@ func (c *ClusterManager) EnsureInstanceGroupsAndPorts(servicePorts []backends.Se
 // - lbNames are the names of L7 loadbalancers we wish to exist. Those not in
 //   this list are removed from the cloud.
 // - nodePorts are the ports for which we want BackendServies. BackendServices
-//   for ports not in this list are deleted.
+//   for ports not in this list are deleted, along with the named ports of the
+//   instance groups.
 // This method ignores googleapi 404 errors (StatusNotFound).
 func (c *ClusterManager) GC(lbNames []string, nodePorts []backends.ServicePort) error {
 
@@ -194,10 +388,24 @@ func (c *ClusterManager) GC(lbNames []string, nodePorts []backends.ServicePort)
 
 	// TODO(ingress#120): Move this to the backend pool so it mirrors creation
 	var igErr error
-	if len(lbNames) == 0 {
-		igName := c.ClusterNamer.InstanceGroup()
-		glog.Infof("Deleting instance group %v", igName)
+	igName := c.ClusterNamer.InstanceGroup()
+	if len(lbNames) == 0 && !c.hasL4Users() {
+		logging.Infof("Deleting instance group %v", igName)
 		igErr = c.instancePool.DeleteInstanceGroup(igName)
+		// The next sync recreates the instance group from scratch.
+		c.resetSynced()
+	} else {
+		// The backend services of the removed named ports are gone by now.
+		ports := []int64{}
+		for _, p := range nodePorts {
+			if p.HasNodePort() {
+				ports = append(ports, p.Port)
+			}
+		}
+		if c.defaultBackendNodePort != nil {
+			ports = append(ports, c.defaultBackendNodePort.Port)
+		}
+		igErr = c.instancePool.GCNamedPorts(igName, ports)
 	}
 	if igErr != nil {
 		return igErr
@@ -205,37 +413,165 @@ func (c *ClusterManager) GC(lbNames []string, nodePorts []backends.ServicePort)
 	return nil
 }
 
+// SetFullSyncPeriod sets how often all the components of the load balancers
+// are synced. Zero syncs all the components every time.
+func (c *ClusterManager) SetFullSyncPeriod(period time.Duration) {
+	c.syncedLock.Lock()
+	defer c.syncedLock.Unlock()
+	c.fullSyncPeriod = period
+}
+
+// SetDisruptiveBackendMigrations allows the backend services which can't
+// switch between balancing modes in place to lose their backends while they
+// are switched. See backends.BackendPool.
+func (c *ClusterManager) SetDisruptiveBackendMigrations(allow bool) {
+	c.backendPool.SetDisruptiveMigrations(allow)
+}
+
+// SetFirewallSrcRanges replaces the source ranges allowed by the L7 firewall
+// rules. Empty ranges restore the ranges the cluster manager was created
+// with.
+func (c *ClusterManager) SetFirewallSrcRanges(srcRanges []string) error {
+	return c.firewallPool.SetSrcRanges(srcRanges)
+}
+
+// CollectOrphans deletes the resources of the cluster whose owner, recorded
+// in their description, no longer exists.
+// - ingresses: are the keys, namespace/name, of all the Ingresses.
+// - services: are the keys of all the Services.
+// Unlike GC, it doesn't rely on the state of the pools, so it also catches
+// the resources leaked by a crash of the controller.
+func (c *ClusterManager) CollectOrphans(ingresses, services sets.String) error {
+	if c.orphanCleaner == nil {
+		return nil
+	}
+	deleted, err := c.orphanCleaner.CleanupOrphans(ingresses, services)
+	if len(deleted) > 0 {
+		logging.Infof("Deleted orphaned resources %v", deleted)
+		// The pools may still know the deleted resources, resync them.
+		c.resetSynced()
+	}
+	return err
+}
+
+// RegisterMultiClusterBackends adds the given instance groups, or the NEGs in
+// the given zones, to the backend services of the config cluster for the
+// given ports of a multi-cluster Ingress.
+func (c *ClusterManager) RegisterMultiClusterBackends(svcPorts []backends.ServicePort, igs []*compute.InstanceGroup, zones []string) error {
+	if c.multiClusterBackends == nil {
+		return nil
+	}
+	return c.multiClusterBackends.Register(uniq(svcPorts), igs, zones)
+}
+
+// GCMultiClusterBackends removes the backends of the cluster from the
+// backend services of the config cluster not used by the given ports of the
+// multi-cluster Ingresses anymore. It must run before GC, which deletes the
+// instance group once no Ingress is left.
+func (c *ClusterManager) GCMultiClusterBackends(svcPorts []backends.ServicePort) error {
+	if c.multiClusterBackends == nil {
+		return nil
+	}
+	return c.multiClusterBackends.GC(svcPorts)
+}
+
+// SyncDNSRecords points the DNS records of the given hosts of the Ingress with
+// the given key to the given IPs of its load balancer. Returns a
+// dns.SkippedHostsError for the hosts whose records are not managed.
+func (c *ClusterManager) SyncDNSRecords(key string, hosts, ips []string) error {
+	if c.dnsRecords == nil {
+		return nil
+	}
+	return c.dnsRecords.Sync(key, hosts, ips)
+}
+
+// GCDNSRecords deletes the DNS records of the Ingresses whose key is not in
+// the given keys.
+func (c *ClusterManager) GCDNSRecords(keys sets.String) error {
+	if c.dnsRecords == nil {
+		return nil
+	}
+	return c.dnsRecords.GC(keys)
+}
+
 // NewClusterManager creates a cluster manager for shared resources.
+// - firewallProvider: manages the L7 firewall rule.
+// - securityPolicies: attaches Cloud Armor security policies to backend
+//	 services.
+// - extendedBackendServices: manages the fields of the backend services
+//	 which the vendored compute API predates.
+// - grpcHealthChecks: manages the gRPC health checks inferred from gRPC
+//	 readiness probes.
+// - httpsProxies: sets the certificates and the SSL policies of FrontendConfigs
+//	 on target HTTPS proxies.
+// - urlMaps: manages the URL maps redirecting HTTP to HTTPS.
 // - namer: is the namer used to tag cluster wide shared resources.
 // - defaultBackendNodePort: is the node port of glbc's default backend. This is
-//	 the kubernetes Service that serves the 404 page if no urls match.
+//	 the kubernetes Service that serves the 404 page if no urls match. If nil,
+//	 Ingresses must have a default backend.
 // - defaultHealthCheckPath: is the default path used for L7 health checks, eg: "/healthz".
+// - resetHealthChecks: if true, updates of health checks reset the settings
+//	 tuned outside of the controller.
+// - firewallOptions: are the options of the L7 firewall rules.
+// - fullSyncPeriod: is how often all the components of the load balancers
+//	 are synced, even if their inputs didn't change. If zero, they are synced
+//	 every time.
+// - multiClusterConfigUID: if set, the UID of the config cluster of the
+//	 multi-cluster Ingresses, whose backend services the backends of this
+//	 cluster are registered into.
+// - sslPolicyDefaults: the SSL policy settings enforced on all the target
+//	 HTTPS proxies.
+// - dnsRecords: if set, manages the DNS records of the hosts of the
+//	 Ingresses.
 func NewClusterManager(
 	cloud *gce.GCECloud,
+	firewallProvider firewalls.Firewall,
+	securityPolicies backends.SecurityPolicies,
+	extendedBackendServices backends.ExtendedBackendServices,
+	grpcHealthChecks healthchecks.GRPCHealthChecks,
+	httpsProxies loadbalancers.TargetHttpsProxies,
+	urlMaps loadbalancers.ExtendedUrlMaps,
 	namer *utils.Namer,
-	defaultBackendNodePort backends.ServicePort,
-	defaultHealthCheckPath string) (*ClusterManager, error) {
+	defaultBackendNodePort *backends.ServicePort,
+	defaultHealthCheckPath string,
+	resetHealthChecks bool,
+	firewallOptions firewalls.PoolOptions,
+	fullSyncPeriod time.Duration,
+	multiClusterConfigUID string,
+	sslPolicyDefaults loadbalancers.SslPolicyDefaults,
+	dnsRecords *dns.Records) (*ClusterManager, error) {
 
 	// Names are fundamental to the cluster, the uid allocator makes sure names don't collide.
-	cluster := ClusterManager{ClusterNamer: namer}
+	cluster := ClusterManager{ClusterNamer: namer, fullSyncPeriod: fullSyncPeriod, dnsRecords: dnsRecords}
 
 	// NodePool stores GCE vms that are in this Kubernetes cluster.
 	cluster.instancePool = instances.NewNodePool(cloud, namer)
 
 	// BackendPool creates GCE BackendServices and associated health checks.
-	healthChecker := healthchecks.NewHealthChecker(cloud, defaultHealthCheckPath, cluster.ClusterNamer)
+	healthChecker := healthchecks.NewHealthChecker(cloud, grpcHealthChecks, defaultHealthCheckPath, cluster.ClusterNamer, resetHealthChecks)
 	// Loadbalancer pool manages the default backend and its health check.
-	defaultBackendHealthChecker := healthchecks.NewHealthChecker(cloud, "/healthz", cluster.ClusterNamer)
+	defaultBackendHealthChecker := healthchecks.NewHealthChecker(cloud, grpcHealthChecks, "/healthz", cluster.ClusterNamer, resetHealthChecks)
 
 	cluster.healthCheckers = []healthchecks.HealthChecker{healthChecker, defaultBackendHealthChecker}
 
 	// TODO: This needs to change to a consolidated management of the default backend.
-	cluster.backendPool = backends.NewBackendPool(cloud, cloud, healthChecker, cluster.instancePool, cluster.ClusterNamer, []int64{defaultBackendNodePort.Port}, true)
-	defaultBackendPool := backends.NewBackendPool(cloud, cloud, defaultBackendHealthChecker, cluster.instancePool, cluster.ClusterNamer, []int64{}, false)
+	ignorePorts := []int64{}
+	if defaultBackendNodePort != nil {
+		ignorePorts = append(ignorePorts, defaultBackendNodePort.Port)
+	}
+	cluster.backendPool = backends.NewBackendPool(cloud, cloud, securityPolicies, extendedBackendServices, healthChecker, cluster.instancePool, cluster.ClusterNamer, ignorePorts, true)
+	defaultBackendPool := backends.NewBackendPool(cloud, cloud, securityPolicies, extendedBackendServices, defaultBackendHealthChecker, cluster.instancePool, cluster.ClusterNamer, []int64{}, false)
 	cluster.defaultBackendNodePort = defaultBackendNodePort
 
 	// L7 pool creates targetHTTPProxy, ForwardingRules, UrlMaps, StaticIPs.
-	cluster.l7Pool = loadbalancers.NewLoadBalancerPool(cloud, defaultBackendPool, defaultBackendNodePort, cluster.ClusterNamer)
-	cluster.firewallPool = firewalls.NewFirewallPool(cloud, cluster.ClusterNamer)
+	cluster.l7Pool = loadbalancers.NewLoadBalancerPool(cloud, httpsProxies, urlMaps, defaultBackendPool, defaultBackendNodePort, cluster.ClusterNamer, sslPolicyDefaults)
+	cluster.firewallPool = firewalls.NewFirewallPool(firewallProvider, cluster.ClusterNamer, firewallOptions)
+	// Orphans are only searched among the resources of the loadbalancers and
+	// backends, the extended cloud, firewalls, zones and NEGs are not
+	// used.
+	cluster.orphanCleaner = cleanup.NewCleaner(cloud, nil, cluster.firewallPool, nil, cluster.ClusterNamer, nil, false, false)
+	if multiClusterConfigUID != "" {
+		cluster.multiClusterBackends = backends.NewMultiClusterBackends(cloud, cloud, cluster.ClusterNamer, multiClusterConfigUID)
+	}
 	return &cluster, nil
 }
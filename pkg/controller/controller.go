@@ -17,16 +17,25 @@ limitations under the License.
 package controller
 
 import (
+	gocontext "context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
+	compute "google.golang.org/api/compute/v1"
 
 	apiv1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	scheme "k8s.io/client-go/kubernetes/scheme"
 	unversionedcore "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -34,10 +43,19 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
+	"k8s.io/ingress-gce/pkg/backends"
 	"k8s.io/ingress-gce/pkg/context"
+	"k8s.io/ingress-gce/pkg/dns"
+	"k8s.io/ingress-gce/pkg/dynamicconfig"
 	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/frontendconfig"
+	"k8s.io/ingress-gce/pkg/ingressclass"
 	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/ingress-gce/pkg/tls"
+	"k8s.io/ingress-gce/pkg/tracing"
+	"k8s.io/ingress-gce/pkg/utils"
 )
 
 var (
@@ -71,8 +89,7 @@ type LoadBalancerController struct {
 	// Health checks are the readiness probes of containers on pods.
 	podLister StoreToPodLister
 	// endpoint lister is needed when translating service target port to real endpoint target ports.
-	endpointLister StoreToEndpointLister
-	// TODO: Watch secrets
+	endpointLister      StoreToEndpointLister
 	CloudClusterManager *ClusterManager
 	recorder            record.EventRecorder
 	nodeQueue           *taskQueue
@@ -86,21 +103,137 @@ type LoadBalancerController struct {
 	shutdown bool
 	// tlsLoader loads secrets from the Kubernetes apiserver for Ingresses.
 	tlsLoader tls.TlsLoader
+	// backendConfigGetter loads the BackendConfigs referenced by Services.
+	backendConfigGetter backendconfig.BackendConfigGetter
+	// portAppProtocols loads the appProtocol field of the Service ports.
+	portAppProtocols *portAppProtocolCache
+	// grpcProbes loads the gRPC readiness probes of the Pods.
+	grpcProbes *grpcProbeCache
+	// ingPathTypes loads the pathType of the paths of the Ingresses.
+	ingPathTypes *ingressPathTypeCache
+	// frontendConfigGetter loads the FrontendConfigs referenced by Ingresses.
+	frontendConfigGetter frontendconfig.FrontendConfigGetter
+	// referenceGrants authorize the Ingresses to join the LB groups of other
+	// namespaces.
+	referenceGrants tls.ReferenceGrantGetter
 	// hasSynced returns true if all associated sub-controllers have synced.
 	// Abstracted into a func for testing.
 	hasSynced func() bool
 	// negEnabled indicates whether NEG feature is enabled.
 	negEnabled bool
+	// firewallResyncPeriod is how often the firewall rules are checked for
+	// drift. Zero disables drift repair.
+	firewallResyncPeriod time.Duration
+	// backendHealthPeriod is how often the health of the backend services is
+	// published on the Ingresses. Zero disables it.
+	backendHealthPeriod time.Duration
+	// orphanGCPeriod is how often the resources whose Ingress or Service no
+	// longer exists are garbage collected. Zero disables it.
+	orphanGCPeriod time.Duration
+	// certExpiryWarningPeriod is how long ahead of the expiry of the
+	// certificate of a TLS secret an event is raised. Zero disables it.
+	certExpiryWarningPeriod time.Duration
+	// ingressClassSyncPeriod is how often the IngressClasses are listed.
+	// Zero disables the IngressClasses, only the annotation counts.
+	ingressClassSyncPeriod time.Duration
+	// healthLock protects backendHealth, read by the GC of the syncs.
+	healthLock sync.RWMutex
+	// backendHealth is the health of the backend services in the last round,
+	// keyed by backend service name.
+	backendHealth map[string]*backends.BackendHealth
+	// nodeExclusionSelector selects the nodes kept out of the instance
+	// groups, in addition to the nodes with the standard exclusion label.
+	// Nil or empty if none.
+	nodeExclusionSelector labels.Selector
+	// excludeWindowsNodes keeps the Windows nodes out of the instance groups.
+	excludeWindowsNodes bool
+	// configLock protects excludeUnreadyNodes and finalizerEnabled, changed
+	// by ApplyConfig.
+	configLock sync.RWMutex
+	// excludeUnreadyNodes keeps the NotReady and unschedulable nodes out of
+	// the instance groups.
+	excludeUnreadyNodes bool
+	// unreadyNodeGracePeriod is how long the readiness of a node must be
+	// stable before the node leaves or joins the instance groups.
+	unreadyNodeGracePeriod time.Duration
+	// instanceGroupNodesLock protects instanceGroupNodes.
+	instanceGroupNodesLock sync.Mutex
+	// instanceGroupNodes are the nodes of the instance groups as of the last
+	// sync.
+	instanceGroupNodes sets.String
+	// finalizerEnabled places a finalizer on the GCE Ingresses, so that their
+	// resources are deleted even if the controller is down when they are.
+	finalizerEnabled bool
+	// deletionProtection keeps the load balancers of the Ingresses being
+	// deleted which still serve traffic until the deletion is confirmed.
+	deletionProtection bool
+	// blockedDeletions are the keys of the Ingresses being deleted whose load
+	// balancer is kept until the deletion is confirmed, as of the last GC.
+	// Protected by sharedLock.
+	blockedDeletions sets.String
+	// nodeZones are the zones of the nodes as of the last node sync, nil
+	// before the first one. Only accessed by the node queue worker.
+	nodeZones sets.String
+	// sharedLock serializes the syncs of the resources shared by the load
+	// balancers, held for writing, with the syncs of the load balancers of
+	// single Ingresses, held for reading.
+	sharedLock sync.RWMutex
+	// lbLocks serialize the syncs of the load balancer of each Ingress, by
+	// Ingress key.
+	lbLocks keyLocks
+}
+
+// ControllerConfig configures a LoadBalancerController.
+type ControllerConfig struct {
+	// NEGEnabled syncs the network endpoint groups of the Services.
+	NEGEnabled bool
+	// FirewallResyncPeriod is how often the firewall rules are checked for
+	// drift. Zero disables it.
+	FirewallResyncPeriod time.Duration
+	// BackendHealthPeriod is how often the health of the backend services is
+	// published on the Ingresses. Zero disables it.
+	BackendHealthPeriod time.Duration
+	// NodeExclusionSelector selects the Nodes not added to the instance
+	// groups. May be nil.
+	NodeExclusionSelector labels.Selector
+	// ExcludeWindowsNodes keeps the Windows nodes out of the instance groups.
+	ExcludeWindowsNodes bool
+	// ExcludeUnreadyNodes removes the NotReady and unschedulable nodes from
+	// the instance groups.
+	ExcludeUnreadyNodes bool
+	// UnreadyNodeGracePeriod is how long the readiness of a node must be
+	// stable before it leaves or joins the instance groups.
+	UnreadyNodeGracePeriod time.Duration
+	// EnableFinalizer places a finalizer on the GCE Ingresses, removed once
+	// their resources are deleted. If false, the finalizer is removed.
+	EnableFinalizer bool
+	// SyncWorkers is the number of Ingresses synced concurrently.
+	SyncWorkers int
+	// OrphanGCPeriod is how often the resources whose Ingress or Service no
+	// longer exists are garbage collected. Zero disables it.
+	OrphanGCPeriod time.Duration
+	// CertExpiryWarningPeriod raises an event on the Ingresses whose TLS
+	// certificates expire within this period. Zero disables it.
+	CertExpiryWarningPeriod time.Duration
+	// IngressClassSyncPeriod is how often the IngressClasses, which pick
+	// the Ingresses of the controller, are listed. Zero disables them, only
+	// the kubernetes.io/ingress.class annotation counts.
+	IngressClassSyncPeriod time.Duration
+	// DeletionProtection keeps the load balancers of the Ingresses being
+	// deleted while they serve traffic, until the deletion is confirmed with
+	// an annotation. Places the finalizer on all the GCE Ingresses. Requires
+	// a non-zero BackendHealthPeriod.
+	DeletionProtection bool
 }
 
 // NewLoadBalancerController creates a controller for gce loadbalancers.
-// - kubeClient: A kubernetes REST client.
-// - clusterManager: A ClusterManager capable of creating all cloud resources
-//	 required for L7 loadbalancing.
-// - resyncPeriod: Watchers relist from the Kubernetes API server this often.
-func NewLoadBalancerController(kubeClient kubernetes.Interface, ctx *context.ControllerContext, clusterManager *ClusterManager, negEnabled bool) (*LoadBalancerController, error) {
+//   - kubeClient: A kubernetes REST client.
+//   - clusterManager: A ClusterManager capable of creating all cloud resources
+//     required for L7 loadbalancing.
+//   - config: The features and periods of the controller.
+func NewLoadBalancerController(kubeClient kubernetes.Interface, ctx *context.ControllerContext, clusterManager *ClusterManager, config ControllerConfig) (*LoadBalancerController, error) {
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartLogging(logging.Infof)
 	eventBroadcaster.StartRecordingToSink(&unversionedcore.EventSinkImpl{
 		Interface: kubeClient.Core().Events(""),
 	})
@@ -110,10 +243,24 @@ func NewLoadBalancerController(kubeClient kubernetes.Interface, ctx *context.Con
 		stopCh:              ctx.StopCh,
 		recorder: eventBroadcaster.NewRecorder(scheme.Scheme,
 			apiv1.EventSource{Component: "loadbalancer-controller"}),
-		negEnabled: negEnabled,
+		negEnabled:              config.NEGEnabled,
+		firewallResyncPeriod:    config.FirewallResyncPeriod,
+		backendHealthPeriod:     config.BackendHealthPeriod,
+		orphanGCPeriod:          config.OrphanGCPeriod,
+		certExpiryWarningPeriod: config.CertExpiryWarningPeriod,
+		ingressClassSyncPeriod:  config.IngressClassSyncPeriod,
+		backendHealth:           map[string]*backends.BackendHealth{},
+		nodeExclusionSelector:   config.NodeExclusionSelector,
+		excludeWindowsNodes:     config.ExcludeWindowsNodes,
+		excludeUnreadyNodes:     config.ExcludeUnreadyNodes,
+		unreadyNodeGracePeriod:  config.UnreadyNodeGracePeriod,
+		instanceGroupNodes:      sets.NewString(),
+		deletionProtection:      config.DeletionProtection,
+		blockedDeletions:        sets.NewString(),
+		finalizerEnabled:        config.EnableFinalizer,
 	}
-	lbc.nodeQueue = NewTaskQueue(lbc.syncNodes)
-	lbc.ingQueue = NewTaskQueue(lbc.sync)
+	lbc.nodeQueue = NewTaskQueue(lbc.syncNodes, "nodes", 1)
+	lbc.ingQueue = NewTaskQueue(lbc.sync, "ingresses", config.SyncWorkers)
 	lbc.hasSynced = lbc.storesSynced
 
 	lbc.ingressSynced = ctx.IngressInformer.HasSynced
@@ -126,7 +273,7 @@ func NewLoadBalancerController(kubeClient kubernetes.Interface, ctx *context.Con
 	lbc.svcLister.Indexer = ctx.ServiceInformer.GetIndexer()
 	lbc.podLister.Indexer = ctx.PodInformer.GetIndexer()
 	lbc.nodeLister.Indexer = ctx.NodeInformer.GetIndexer()
-	if negEnabled {
+	if lbc.negEnabled {
 		lbc.endpointSynced = ctx.EndpointInformer.HasSynced
 		lbc.endpointLister.Indexer = ctx.EndpointInformer.GetIndexer()
 	}
@@ -135,29 +282,42 @@ func NewLoadBalancerController(kubeClient kubernetes.Interface, ctx *context.Con
 	ctx.IngressInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			addIng := obj.(*extensions.Ingress)
-			if !isGCEIngress(addIng) && !isGCEMultiClusterIngress(addIng) {
-				glog.Infof("Ignoring add for ingress %v based on annotation %v", addIng.Name, annotations.IngressClassKey)
+			if !lbc.ingLister.isGCEIngress(addIng) && !lbc.ingLister.isGCEMultiClusterIngress(addIng) {
+				logging.ForIngress(ingressKey(addIng)).Infof("Ignoring add based on annotation %v", annotations.IngressClassKey)
 				return
 			}
 			lbc.recorder.Eventf(addIng, apiv1.EventTypeNormal, "ADD", fmt.Sprintf("%s/%s", addIng.Namespace, addIng.Name))
 			lbc.ingQueue.enqueue(obj)
+			lbc.enqueueLBGroup(addIng)
 		},
 		DeleteFunc: func(obj interface{}) {
+			lbc.ingPathTypes.forget(obj)
+			lbc.ingLister.classes.Forget(obj)
 			delIng := obj.(*extensions.Ingress)
-			if !isGCEIngress(delIng) && !isGCEMultiClusterIngress(delIng) {
-				glog.Infof("Ignoring delete for ingress %v based on annotation %v", delIng.Name, annotations.IngressClassKey)
+			if !lbc.ingLister.isGCEIngress(delIng) && !lbc.ingLister.isGCEMultiClusterIngress(delIng) {
+				logging.ForIngress(ingressKey(delIng)).Infof("Ignoring delete based on annotation %v", annotations.IngressClassKey)
 				return
 			}
-			glog.Infof("Delete notification received for Ingress %v/%v", delIng.Namespace, delIng.Name)
+			logging.ForIngress(ingressKey(delIng)).Infof("Delete notification received")
 			lbc.ingQueue.enqueue(obj)
+			lbc.enqueueLBGroup(delIng)
 		},
 		UpdateFunc: func(old, cur interface{}) {
 			curIng := cur.(*extensions.Ingress)
-			if !isGCEIngress(curIng) && !isGCEMultiClusterIngress(curIng) {
+			if !lbc.ingLister.isGCEIngress(curIng) && !lbc.ingLister.isGCEMultiClusterIngress(curIng) {
 				return
 			}
 			if !reflect.DeepEqual(old, cur) {
-				glog.V(3).Infof("Ingress %v changed, syncing", curIng.Name)
+				// The status annotations are written by the controller, at
+				// the end of syncs or by syncBackendHealth.
+				if onlyStatusChanged(old.(*extensions.Ingress), curIng) {
+					logging.ForIngress(ingressKey(curIng)).V(4).Infof("Ingress status changed, skipping sync")
+					return
+				}
+				logging.ForIngress(ingressKey(curIng)).V(3).Infof("Ingress changed, syncing")
+				// The Ingress may have left its LB group.
+				lbc.enqueueLBGroup(old.(*extensions.Ingress))
+				lbc.enqueueLBGroup(curIng)
 			}
 			lbc.ingQueue.enqueue(cur)
 		},
@@ -171,46 +331,324 @@ func NewLoadBalancerController(kubeClient kubernetes.Interface, ctx *context.Con
 				lbc.enqueueIngressForService(cur)
 			}
 		},
-		// Ingress deletes matter, service deletes don't.
+		// Ingress deletes matter, service deletes only drop the cached
+		// appProtocols of their ports.
+		DeleteFunc: func(obj interface{}) {
+			lbc.portAppProtocols.forget(obj)
+		},
+	})
+
+	// pod event handler, pod deletes only drop the cached gRPC readiness
+	// probes of their containers.
+	ctx.PodInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			lbc.grpcProbes.forget(obj)
+		},
+	})
+
+	// endpoint event handler, the ports of NEG backends are derived from the
+	// endpoints so the firewall rule needs to follow their changes.
+	if lbc.negEnabled {
+		ctx.EndpointInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: lbc.enqueueIngressForEndpoints,
+			UpdateFunc: func(old, cur interface{}) {
+				if !endpointPorts(old.(*apiv1.Endpoints)).Equal(endpointPorts(cur.(*apiv1.Endpoints))) {
+					lbc.enqueueIngressForEndpoints(cur)
+				}
+			},
+		})
+	}
+
+	// secret event handler, the certificates are rotated without touching
+	// the Ingresses, eg: by cert-manager.
+	ctx.SecretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: lbc.enqueueIngressForSecret,
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old.(*apiv1.Secret).Data, cur.(*apiv1.Secret).Data) {
+				lbc.enqueueIngressForSecret(cur)
+			}
+		},
 	})
 
 	// node event handler
 	ctx.NodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    lbc.nodeQueue.enqueue,
 		DeleteFunc: lbc.nodeQueue.enqueue,
-		// Nodes are updated every 10s and we don't care, so no update handler.
+		// Nodes are updated every 10s and we don't care, unless the node
+		// may join or leave the instance groups.
+		UpdateFunc: func(old, cur interface{}) {
+			oldNode, curNode := old.(*apiv1.Node), cur.(*apiv1.Node)
+			if lbc.isExcludedNode(oldNode) != lbc.isExcludedNode(curNode) ||
+				oldNode.Spec.Unschedulable != curNode.Spec.Unschedulable ||
+				nodeReady(oldNode) != nodeReady(curNode) {
+				lbc.nodeQueue.enqueue(cur)
+			}
+		},
 	})
 
 	lbc.Translator = &GCETranslator{&lbc}
-	lbc.tlsLoader = &tls.TLSCertsFromSecretsLoader{Client: lbc.client}
-	glog.V(3).Infof("Created new loadbalancer controller")
+	lbc.referenceGrants = &tls.APIServerReferenceGrantGetter{Client: lbc.client}
+	lbc.tlsLoader = &tls.TLSCertsFromSecretsLoader{
+		Client:          lbc.client,
+		ReferenceGrants: lbc.referenceGrants,
+	}
+	lbc.backendConfigGetter = &backendconfig.APIServerBackendConfigGetter{Client: lbc.client}
+	lbc.portAppProtocols = newPortAppProtocolCache(lbc.client)
+	lbc.grpcProbes = newGRPCProbeCache(lbc.client)
+	lbc.ingPathTypes = newIngressPathTypeCache(lbc.client)
+	lbc.frontendConfigGetter = &frontendconfig.APIServerFrontendConfigGetter{Client: lbc.client}
+	if lbc.ingressClassSyncPeriod > 0 {
+		lbc.ingLister.classes = ingressclass.NewClasses(&ingressclass.APIServerClient{Client: lbc.client})
+	}
+	logging.V(3).Infof("Created new loadbalancer controller")
 
 	return &lbc, nil
 }
 
+// enqueueLBGroup enqueues the other Ingresses of the LB group of the given
+// Ingress, whose syncs merge its rules into the url map of the group.
+func (lbc *LoadBalancerController) enqueueLBGroup(ing *extensions.Ingress) {
+	group := lbc.ingLister.lbGroup(ing)
+	if group == "" {
+		return
+	}
+	for _, member := range lbc.ingLister.ListLBGroup(group) {
+		if member.Namespace != ing.Namespace || member.Name != ing.Name {
+			lbc.ingQueue.enqueue(&member)
+		}
+	}
+}
+
+// enqueueAllIngresses enqueues all the Ingresses, once the IngressClasses
+// changed: the Ingresses no longer of the controller get their load balancer
+// deleted.
+func (lbc *LoadBalancerController) enqueueAllIngresses() {
+	for _, obj := range lbc.ingLister.Store.List() {
+		lbc.ingQueue.enqueue(obj)
+	}
+}
+
 // enqueueIngressForService enqueues all the Ingress' for a Service.
 func (lbc *LoadBalancerController) enqueueIngressForService(obj interface{}) {
 	svc := obj.(*apiv1.Service)
 	ings, err := lbc.ingLister.GetServiceIngress(svc)
 	if err != nil {
-		glog.V(5).Infof("ignoring service %v: %v", svc.Name, err)
+		logging.V(5).Infof("ignoring service %v: %v", svc.Name, err)
 		return
 	}
 	for _, ing := range ings {
-		if !isGCEIngress(&ing) {
+		if !lbc.ingLister.isGCEIngress(&ing) {
+			continue
+		}
+		lbc.ingQueue.enqueue(&ing)
+	}
+}
+
+// enqueueIngressForEndpoints enqueues all the Ingress' for the Service of the
+// given Endpoints.
+func (lbc *LoadBalancerController) enqueueIngressForEndpoints(obj interface{}) {
+	ep := obj.(*apiv1.Endpoints)
+	svc, exists, err := lbc.svcLister.Indexer.GetByKey(fmt.Sprintf("%v/%v", ep.Namespace, ep.Name))
+	if err != nil || !exists {
+		logging.V(5).Infof("ignoring endpoints %v/%v without service: %v", ep.Namespace, ep.Name, err)
+		return
+	}
+	lbc.enqueueIngressForService(svc)
+}
+
+// enqueueIngressForSecret enqueues the GCE Ingresses serving the certificate
+// of the given Secret. Their sync replaces the certificate of the target
+// HTTPS proxy if it changed, and deletes the old one.
+func (lbc *LoadBalancerController) enqueueIngressForSecret(obj interface{}) {
+	secret := obj.(*apiv1.Secret)
+	for _, ing := range lbc.ingLister.GetSecretIngresses(secret) {
+		if !lbc.ingLister.isGCEIngress(&ing) {
 			continue
 		}
+		logging.ForIngress(ingressKey(&ing)).V(3).Infof("Secret %v/%v changed, syncing", secret.Namespace, secret.Name)
 		lbc.ingQueue.enqueue(&ing)
 	}
 }
 
+// endpointPorts returns the set of ports of the given Endpoints.
+func endpointPorts(ep *apiv1.Endpoints) sets.String {
+	ports := sets.NewString()
+	for _, subset := range ep.Subsets {
+		for _, port := range subset.Ports {
+			ports.Insert(fmt.Sprintf("%v/%v/%v", port.Name, port.Protocol, port.Port))
+		}
+	}
+	return ports
+}
+
 // Run starts the loadbalancer controller.
 func (lbc *LoadBalancerController) Run() {
-	glog.Infof("Starting loadbalancer controller")
+	logging.Infof("Starting loadbalancer controller")
+	// The Ingresses are synced once their IngressClasses are known.
+	if lbc.ingLister.classes != nil {
+		if _, err := lbc.ingLister.classes.Sync(); err != nil {
+			logging.Warningf("Failed to sync IngressClasses: %v", err)
+		}
+		go lbc.ingLister.classes.Run(lbc.ingressClassSyncPeriod, lbc.stopCh, lbc.enqueueAllIngresses)
+	}
 	go lbc.ingQueue.run(time.Second, lbc.stopCh)
 	go lbc.nodeQueue.run(time.Second, lbc.stopCh)
+	if lbc.firewallResyncPeriod > 0 {
+		go wait.Until(lbc.repairFirewallDrift, lbc.firewallResyncPeriod, lbc.stopCh)
+	}
+	if lbc.backendHealthPeriod > 0 {
+		go wait.Until(lbc.syncBackendHealth, lbc.backendHealthPeriod, lbc.stopCh)
+	}
+	if lbc.orphanGCPeriod > 0 {
+		go wait.Until(lbc.collectOrphans, lbc.orphanGCPeriod, lbc.stopCh)
+	}
 	<-lbc.stopCh
-	glog.Infof("Shutting down Loadbalancer Controller")
+	logging.Infof("Shutting down Loadbalancer Controller")
+}
+
+// repairFirewallDrift repairs the firewall rules if they drifted from the
+// last sync, and raises an event describing the repair on all Ingresses.
+func (lbc *LoadBalancerController) repairFirewallDrift() {
+	repairs, err := lbc.CloudClusterManager.firewallPool.RepairDrift()
+	if err != nil {
+		logging.Warningf("Failed to repair firewall drift: %v", err)
+	}
+	if len(repairs) == 0 {
+		return
+	}
+	ings, err := lbc.ingLister.ListGCEIngresses()
+	if err != nil {
+		logging.Warningf("Failed to list Ingresses for firewall drift events: %v", err)
+		return
+	}
+	for i := range ings.Items {
+		for _, r := range repairs {
+			lbc.recorder.Eventf(&ings.Items[i], apiv1.EventTypeNormal, "FirewallDrift", "%v", r)
+		}
+	}
+}
+
+// syncBackendHealth publishes the health of the backend services of all
+// Ingresses on them, and raises an event on the Ingresses using a backend
+// service whose endpoints all became unhealthy.
+func (lbc *LoadBalancerController) syncBackendHealth() {
+	ings, err := lbc.ingLister.ListGCEIngresses()
+	if err != nil {
+		logging.Warningf("Failed to list Ingresses for backend health: %v", err)
+		return
+	}
+	// Backend services shared by Ingresses are only checked once.
+	health := map[string]*backends.BackendHealth{}
+	for i := range ings.Items {
+		ing := &ings.Items[i]
+		key, err := keyFunc(ing)
+		if err != nil {
+			continue
+		}
+		lbk, err := lbc.ingLister.lbKey(ing)
+		if err != nil {
+			continue
+		}
+		names, err := lbc.backendNames(lbk)
+		if err != nil {
+			// The load balancer is not synced yet.
+			continue
+		}
+		ingHealth := map[string]*backends.BackendHealth{}
+		complete := true
+		for _, name := range names {
+			h, ok := health[name]
+			if !ok {
+				if h, err = lbc.CloudClusterManager.backendPool.Health(name); err != nil {
+					logging.ForResource(name).Warningf("Failed to get health of backend service: %v", err)
+					// A missing backend service has no health, other
+					// errors are transient.
+					complete = complete && utils.IsHTTPErrorCode(err, http.StatusNotFound)
+					continue
+				}
+				health[name] = h
+			}
+			ingHealth[name] = h
+			if prev, ok := lbc.cachedHealth(name); h.FullyUnhealthy() && (!ok || !prev.FullyUnhealthy()) {
+				lbc.recorder.Eventf(ing, apiv1.EventTypeWarning, "BackendUnhealthy", "All %d endpoints of backend service %v are unhealthy", h.Unhealthy, name)
+			}
+		}
+		// A partial health would flip the annotation and the condition back
+		// on the next round, they are only published once all backend
+		// services were checked.
+		if !complete || len(ingHealth) == 0 {
+			continue
+		}
+		if err := lbc.updateBackendHealthAnnotation(ing, ingHealth); err != nil {
+			logging.ForIngress(key).Warningf("Failed to publish backend health: %v", err)
+		}
+		if err := lbc.updateConditions(ing, []IngressCondition{backendsHealthyCondition(ingHealth)}); err != nil {
+			logging.ForIngress(key).Warningf("Failed to publish conditions: %v", err)
+		}
+	}
+	lbc.healthLock.Lock()
+	lbc.backendHealth = health
+	lbc.healthLock.Unlock()
+}
+
+// cachedHealth returns the health of the given backend service in the last
+// round of syncBackendHealth, false if it wasn't checked.
+func (lbc *LoadBalancerController) cachedHealth(name string) (*backends.BackendHealth, bool) {
+	lbc.healthLock.RLock()
+	defer lbc.healthLock.RUnlock()
+	h, ok := lbc.backendHealth[name]
+	return h, ok
+}
+
+// backendNames returns the names of the backend services of the load
+// balancer of the given key, while no sync changes it.
+func (lbc *LoadBalancerController) backendNames(key string) ([]string, error) {
+	lbc.sharedLock.RLock()
+	defer lbc.sharedLock.RUnlock()
+	lbc.lbLocks.Lock(key)
+	defer lbc.lbLocks.Unlock(key)
+	l7, err := lbc.CloudClusterManager.l7Pool.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return l7.BackendNames(), nil
+}
+
+// backendsHealthyCondition returns the BackendsHealthy condition of an Ingress
+// with the given backend health.
+func backendsHealthyCondition(health map[string]*backends.BackendHealth) IngressCondition {
+	var unhealthy []string
+	for name, h := range health {
+		if h.FullyUnhealthy() {
+			unhealthy = append(unhealthy, name)
+		}
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return newCondition(BackendsHealthyCondition, false, "BackendsUnhealthy", fmt.Sprintf("No healthy endpoint in backend services %v", strings.Join(unhealthy, ", ")))
+	}
+	return newCondition(BackendsHealthyCondition, true, "BackendsHealthy", "")
+}
+
+// updateBackendHealthAnnotation records the given backend health on the
+// Ingress. Nothing is written if the health recorded on the given, cached,
+// Ingress didn't change.
+func (lbc *LoadBalancerController) updateBackendHealthAnnotation(ing *extensions.Ingress, health map[string]*backends.BackendHealth) error {
+	var existing map[string]*backends.BackendHealth
+	if value, ok := ing.Annotations[annotations.BackendHealthKey]; ok && json.Unmarshal([]byte(value), &existing) == nil && reflect.DeepEqual(existing, health) {
+		return nil
+	}
+	b, err := json.Marshal(health)
+	if err != nil {
+		return err
+	}
+	// Copy the annotations so we don't mutate the object in the store.
+	ingAnnotations := map[string]string{}
+	for k, v := range ing.Annotations {
+		ingAnnotations[k] = v
+	}
+	ingAnnotations[annotations.BackendHealthKey] = string(b)
+	return lbc.updateAnnotations(ing.Name, ing.Namespace, ingAnnotations)
 }
 
 // Stop stops the loadbalancer controller. It also deletes cluster resources
@@ -223,7 +661,7 @@ func (lbc *LoadBalancerController) Stop(deleteAll bool) error {
 	// Only try draining the workqueue if we haven't already.
 	if !lbc.shutdown {
 		close(lbc.stopCh)
-		glog.Infof("Shutting down controller queues.")
+		logging.Infof("Shutting down controller queues.")
 		lbc.ingQueue.shutdown()
 		lbc.nodeQueue.shutdown()
 		lbc.shutdown = true
@@ -231,7 +669,7 @@ func (lbc *LoadBalancerController) Stop(deleteAll bool) error {
 
 	// Deleting shared cluster resources is idempotent.
 	if deleteAll {
-		glog.Infof("Shutting down cluster manager.")
+		logging.Infof("Shutting down cluster manager.")
 		return lbc.CloudClusterManager.shutdown()
 	}
 	return nil
@@ -254,37 +692,71 @@ func (lbc *LoadBalancerController) storesSynced() bool {
 		lbc.endpointSynced())
 }
 
-// sync manages Ingress create/updates/deletes.
+// InformersSynced returns true if the caches of all the informers of the
+// controller have synced, so that it syncs the load balancers.
+func (lbc *LoadBalancerController) InformersSynced() bool {
+	return lbc.hasSynced()
+}
+
+// CheckSyncProgress returns an error if the Ingress or node sync loop made no
+// progress for the given timeout, eg: a sync is deadlocked. Kubernetes
+// restarts the controller through the liveness probe.
+func (lbc *LoadBalancerController) CheckSyncProgress(timeout time.Duration) error {
+	now := time.Now()
+	if err := lbc.ingQueue.checkProgress(now, timeout); err != nil {
+		return fmt.Errorf("ingress queue: %v", err)
+	}
+	if err := lbc.nodeQueue.checkProgress(now, timeout); err != nil {
+		return fmt.Errorf("node queue: %v", err)
+	}
+	return nil
+}
+
+// sync manages Ingress create/updates/deletes. The resources shared by the
+// load balancers of all Ingresses are synced and garbage collected by one
+// sync at a time, while the load balancers of different Ingresses are
+// synced concurrently.
 func (lbc *LoadBalancerController) sync(key string) (err error) {
 	if !lbc.hasSynced() {
 		time.Sleep(storeSyncPollPeriod)
 		return fmt.Errorf("waiting for stores to sync")
 	}
-	glog.V(3).Infof("Syncing %v", key)
-
-	allIngresses, err := lbc.ingLister.ListAll()
-	if err != nil {
-		return err
-	}
-	gceIngresses, err := lbc.ingLister.ListGCEIngresses()
-	if err != nil {
-		return err
-	}
+	log := logging.ForIngress(key).WithOperation("sync")
+	log.V(3).Infof("Syncing")
+	// The GCE API calls of the sync are traced as children of its span.
+	ctx, span := tracing.StartSpan(gocontext.Background(), "sync", tracing.KindInternal)
+	span.SetAttribute("ingress", key)
+	defer func() {
+		span.End(err)
+	}()
+	defer tracing.Bind(ctx)()
 
-	allNodePorts := lbc.Translator.toNodePorts(&allIngresses)
-	gceNodePorts := lbc.Translator.toNodePorts(&gceIngresses)
-	lbNames := lbc.ingLister.Store.ListKeys()
-	lbs, err := lbc.toRuntimeInfo(gceIngresses)
+	obj, ingExists, err := lbc.ingLister.Store.GetByKey(key)
 	if err != nil {
 		return err
 	}
-	nodeNames, err := lbc.getReadyNodeNames()
-	if err != nil {
-		return err
+	if ingExists && obj.(*extensions.Ingress).DeletionTimestamp != nil {
+		ingExists = false
 	}
-	obj, ingExists, err := lbc.ingLister.Store.GetByKey(key)
-	if err != nil {
-		return err
+	if ingExists && lbc.ingLister.isGCEIngress(obj.(*extensions.Ingress)) {
+		// The naming scheme is persisted before the finalizer, which makes
+		// the Ingresses without one keep the V1 scheme.
+		if err := lbc.persistNamingScheme(obj.(*extensions.Ingress)); err != nil {
+			return err
+		}
+		// The finalizer is placed before creating any resource, so that they
+		// can't leak.
+		lbc.configLock.RLock()
+		finalizerEnabled := lbc.finalizerEnabled
+		lbc.configLock.RUnlock()
+		// Deletions can only be blocked while the finalizer holds the
+		// Ingress.
+		if lbc.deletionProtection || annotations.IngAnnotations(obj.(*extensions.Ingress).Annotations).DeletionProtection() {
+			finalizerEnabled = true
+		}
+		if err := lbc.updateFinalizer(obj.(*extensions.Ingress), finalizerEnabled); err != nil {
+			return err
+		}
 	}
 
 	// This performs a 2 phase checkpoint with the cloud:
@@ -299,11 +771,21 @@ func (lbc *LoadBalancerController) sync(key string) (err error) {
 	//   don't have an associated Kubernetes Ingress/Service/Endpoint.
 
 	var syncError error
+	start := time.Now()
 	defer func() {
-		if deferErr := lbc.CloudClusterManager.GC(lbNames, allNodePorts); deferErr != nil {
+		deferErr := traced(ctx, "gc", lbc.gc)
+		if deferErr != nil {
 			err = fmt.Errorf("error during sync %v, error during GC %v", syncError, deferErr)
 		}
-		glog.V(3).Infof("Finished syncing %v", key)
+		result := "success"
+		if err != nil {
+			result = "error"
+			// The cloud resources may not be what the last syncs left, sync
+			// all of them next time.
+			lbc.CloudClusterManager.resetSynced()
+		}
+		ingressSyncDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+		log.V(3).Infof("Finished syncing in %v, result %v", time.Since(start), result)
 	}()
 
 	// TODO: Implement proper backoff for the queue.
@@ -311,13 +793,23 @@ func (lbc *LoadBalancerController) sync(key string) (err error) {
 
 	// Record any errors during sync and throw a single error at the end. This
 	// allows us to free up associated cloud resources ASAP.
-	igs, err := lbc.CloudClusterManager.Checkpoint(lbs, nodeNames, gceNodePorts, allNodePorts, lbc.Translator.gatherFirewallPorts(gceNodePorts, len(lbs) > 0))
+	var fwChange *firewalls.FirewallChange
+	var igs []*compute.InstanceGroup
+	err = traced(ctx, "checkpoint", func() (err error) {
+		igs, err = lbc.checkpoint()
+		return err
+	})
 	if err != nil {
 		if fwErr, ok := err.(*firewalls.FirewallSyncError); ok {
+			fwChange = fwErr.Change
+			reason := "XPN"
+			if fwErr.DryRun {
+				reason = "DryRun"
+			}
 			if ingExists {
-				lbc.recorder.Eventf(obj.(*extensions.Ingress), apiv1.EventTypeNormal, eventMsg, fwErr.Message)
+				lbc.recorder.Eventf(obj.(*extensions.Ingress), apiv1.EventTypeNormal, reason, "%v", fwErr.Message)
 			} else {
-				glog.Warningf("Received firewallSyncError but don't have an ingress for raising an event: %v", fwErr.Message)
+				log.Warningf("Received firewallSyncError but don't have an ingress for raising an event: %v", fwErr.Message)
 			}
 		} else {
 			if ingExists {
@@ -333,7 +825,10 @@ func (lbc *LoadBalancerController) sync(key string) (err error) {
 		return syncError
 	}
 	ing := *obj.(*extensions.Ingress)
-	if isGCEMultiClusterIngress(&ing) {
+	if err := lbc.updateFirewallChangeAnnotation(&ing, fwChange); err != nil {
+		log.Warningf("Failed to record required firewall change: %v", err)
+	}
+	if lbc.ingLister.isGCEMultiClusterIngress(&ing) {
 		// Add instance group names as annotation on the ingress.
 		if ing.Annotations == nil {
 			ing.Annotations = map[string]string{}
@@ -344,44 +839,529 @@ func (lbc *LoadBalancerController) sync(key string) (err error) {
 		if err = lbc.updateAnnotations(ing.Name, ing.Namespace, ing.Annotations); err != nil {
 			return err
 		}
+		if err = lbc.registerMultiClusterBackends(&ing, igs); err != nil {
+			lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "MultiCluster", "%v", err)
+			return err
+		}
 		return nil
 	}
 
 	if lbc.negEnabled {
-		svcPorts := lbc.Translator.toNodePorts(&extensions.IngressList{Items: []extensions.Ingress{ing}})
-		for _, svcPort := range svcPorts {
-			if svcPort.NEGEnabled {
-
-				zones, err := lbc.Translator.ListZones()
-				if err != nil {
-					return err
-				}
-				if err := lbc.CloudClusterManager.backendPool.Link(svcPort, zones); err != nil {
-					return err
-				}
-			}
+		if err := lbc.linkNEGs(&ing); err != nil {
+			return err
 		}
 	}
 
+	// The load balancer of the Ingress is synced concurrently with the load
+	// balancers of other Ingresses, but not with the shared resources. The
+	// Ingresses of an LB group share their load balancer.
+	lbk, err := lbc.ingLister.lbKey(&ing)
+	if err != nil {
+		return err
+	}
+	lbc.sharedLock.RLock()
+	defer lbc.sharedLock.RUnlock()
+	lbc.lbLocks.Lock(lbk)
+	defer lbc.lbLocks.Unlock(lbk)
+
 	// Update the UrlMap of the single loadbalancer that came through the watch.
-	l7, err := lbc.CloudClusterManager.l7Pool.Get(key)
+	l7, err := lbc.CloudClusterManager.l7Pool.Get(lbk)
 	if err != nil {
 		syncError = fmt.Errorf("%v, unable to get loadbalancer: %v", syncError, err)
 		return syncError
 	}
+	members := []extensions.Ingress{ing}
+	if group := lbc.ingLister.lbGroup(&ing); group != "" {
+		members = lbc.withoutUnprotectedDeletions(extensions.IngressList{Items: lbc.ingLister.ListLBGroup(group)}).Items
+	}
 
-	if urlMap, err := lbc.Translator.toURLMap(&ing); err != nil {
+	urlMapSynced := false
+	if urlMap, routes, err := lbc.Translator.toGroupURLMap(members, &ing); err != nil {
 		syncError = fmt.Errorf("%v, convert to url map error %v", syncError, err)
-	} else if err := l7.UpdateUrlMap(urlMap); err != nil {
+	} else if err := traced(ctx, "update url map", func() error { return l7.UpdateUrlMap(urlMap, routes) }); err != nil {
 		lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "UrlMap", err.Error())
 		syncError = fmt.Errorf("%v, update url map error: %v", syncError, err)
-	} else if err := lbc.updateIngressStatus(l7, ing); err != nil {
-		lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "Status", err.Error())
-		syncError = fmt.Errorf("%v, update ingress error: %v", syncError, err)
+	} else {
+		urlMapSynced = true
+		if err := lbc.updateIngressStatus(l7, ing); err != nil {
+			lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "Status", err.Error())
+			syncError = fmt.Errorf("%v, update ingress error: %v", syncError, err)
+		} else if err := lbc.syncDNSRecords(&ing, l7); err != nil {
+			lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "DNS", err.Error())
+			syncError = fmt.Errorf("%v, sync DNS records error: %v", syncError, err)
+		}
+	}
+	lbc.reportManagedCertificate(&ing, l7)
+	var removed []string
+	if !l7.UsesTLS() {
+		removed = append(removed, CertificateReadyCondition)
+	}
+	if err := lbc.updateConditions(&ing, syncConditions(l7, urlMapSynced, syncError), removed...); err != nil {
+		log.Warningf("Failed to publish conditions: %v", err)
 	}
 	return syncError
 }
 
+// reportManagedCertificate raises an event on the given Ingress while the
+// managed certificate of its load balancer is not provisioned.
+func (lbc *LoadBalancerController) reportManagedCertificate(ing *extensions.Ingress, l7 *loadbalancers.L7) {
+	status, domains := l7.ManagedCertificateStatus()
+	if status == "" || status == "ACTIVE" {
+		return
+	}
+	// Provisioning failures, eg: FAILED_NOT_VISIBLE if a domain does not
+	// resolve to the load balancer, are warnings.
+	eventType := apiv1.EventTypeNormal
+	if status != "PROVISIONING" {
+		eventType = apiv1.EventTypeWarning
+	}
+	var pending []string
+	for domain, domainStatus := range domains {
+		pending = append(pending, fmt.Sprintf("%v: %v", domain, domainStatus))
+		if domainStatus != "PROVISIONING" {
+			eventType = apiv1.EventTypeWarning
+		}
+	}
+	sort.Strings(pending)
+	lbc.recorder.Eventf(ing, eventType, "ManagedCertificate", "The managed certificate is %v, domains: %v", status, strings.Join(pending, ", "))
+}
+
+// syncDNSRecords points the DNS records of the hosts of the given Ingress to
+// the IPs of its load balancer, once allocated. The hosts whose records are
+// not managed, eg: owned by another cluster, are reported with an event.
+func (lbc *LoadBalancerController) syncDNSRecords(ing *extensions.Ingress, l7 *loadbalancers.L7) error {
+	ip := l7.GetIP()
+	if ip == "" {
+		return nil
+	}
+	ips := []string{ip}
+	if ipv6 := l7.GetIPv6(); ipv6 != "" {
+		ips = append(ips, ipv6)
+	}
+	err := lbc.CloudClusterManager.SyncDNSRecords(ingressKey(ing), ingressHosts(ing), ips)
+	if skipped, ok := err.(*dns.SkippedHostsError); ok {
+		lbc.recorder.Eventf(ing, apiv1.EventTypeWarning, "DNS", "%v", skipped)
+		return nil
+	}
+	return err
+}
+
+// traced runs f in a span of the given name, the child of the span of the
+// given context. The span is bound to the calling goroutine while f runs, so
+// that the GCE API calls of f are its children.
+func traced(ctx gocontext.Context, name string, f func() error) (err error) {
+	ctx, span := tracing.StartSpan(ctx, name, tracing.KindInternal)
+	defer func() {
+		span.End(err)
+	}()
+	defer tracing.Bind(ctx)()
+	return f()
+}
+
+// checkpoint syncs the resources shared by the load balancers of all
+// Ingresses, eg: the instance groups, backend services and firewall rules, and
+// returns the instance groups.
+func (lbc *LoadBalancerController) checkpoint() ([]*compute.InstanceGroup, error) {
+	lbc.sharedLock.Lock()
+	defer lbc.sharedLock.Unlock()
+
+	allIngresses, err := lbc.ingLister.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	gceIngresses, err := lbc.ingLister.ListGCEIngresses()
+	if err != nil {
+		return nil, err
+	}
+	lbc.syncLBGroupGrants(gceIngresses)
+	// The resources of the Ingresses being deleted are garbage collected,
+	// before their finalizer is removed. The load balancers of the ones whose
+	// deletion may be blocked are synced, so that GC knows them.
+	allIngresses = lbc.withoutUnprotectedDeletions(allIngresses)
+	gceIngresses = lbc.withoutUnprotectedDeletions(gceIngresses)
+
+	// The load balancers of the LB groups are always named after the V2
+	// scheme.
+	schemes := map[string]utils.NamingScheme{}
+	for _, ing := range gceIngresses.Items {
+		if key, err := lbc.ingLister.lbKey(&ing); err == nil && isLBGroupKey(key) {
+			schemes[key] = utils.NamingSchemeV2
+		} else if key, err := keyFunc(&ing); err == nil {
+			schemes[key] = namingScheme(&ing)
+		}
+	}
+	lbc.CloudClusterManager.ClusterNamer.SetNamingSchemes(schemes)
+
+	allNodePorts := lbc.Translator.toNodePorts(&allIngresses)
+	gceNodePorts := lbc.Translator.toNodePorts(&gceIngresses)
+	lbs, err := lbc.toRuntimeInfo(gceIngresses)
+	if err != nil {
+		return nil, err
+	}
+	nodeNames, err := lbc.getReadyNodeNames()
+	if err != nil {
+		return nil, err
+	}
+	fwNodePorts := gceNodePorts
+	if lbc.CloudClusterManager.multiClusterBackends != nil {
+		// The health checks of the config cluster reach the backends of the
+		// multi-cluster Ingresses through the firewall rules of this cluster.
+		fwNodePorts = allNodePorts
+	}
+	fwPorts, fwNEGPorts := lbc.Translator.gatherFirewallPorts(fwNodePorts, len(lbs) > 0)
+	fwSrcRanges := lbc.Translator.gatherFirewallSrcRanges(&gceIngresses)
+	fwNetworks := lbc.Translator.gatherFirewallNetworks(&gceIngresses)
+	return lbc.CloudClusterManager.Checkpoint(lbs, nodeNames, gceNodePorts, allNodePorts, fwPorts, fwNEGPorts, fwSrcRanges, fwNetworks)
+}
+
+// syncLBGroupGrants records which LB groups of other namespaces the given
+// Ingresses may join, from the ReferenceGrants of the namespaces of the
+// groups. The previous grants of a namespace whose ReferenceGrants can't be
+// listed are kept, so that its LB groups don't move. The caller holds
+// sharedLock for writing.
+func (lbc *LoadBalancerController) syncLBGroupGrants(ings extensions.IngressList) {
+	grants := map[string]sets.String{}
+	listed := map[string][]tls.ReferenceGrant{}
+	failed := sets.NewString()
+	for i := range ings.Items {
+		ing := &ings.Items[i]
+		namespace, group := lbGroupRef(ing)
+		if group == "" || namespace == ing.Namespace {
+			continue
+		}
+		ref := namespace + "/" + group
+		nsGrants, ok := listed[namespace]
+		if !ok && !failed.Has(namespace) {
+			var err error
+			if nsGrants, err = lbc.referenceGrants.List(namespace); err != nil {
+				logging.Warningf("Failed to list the ReferenceGrants of namespace %v, keeping its LB group grants: %v", namespace, err)
+				failed.Insert(namespace)
+			} else {
+				listed[namespace] = nsGrants
+			}
+		}
+		granted := lbc.ingLister.lbGroupGranted(ref, ing.Namespace)
+		if !failed.Has(namespace) {
+			granted = tls.IngressGranted(nsGrants, ing.Namespace, tls.LBGroupGroupName, tls.LBGroupKind, group)
+		}
+		if granted {
+			if grants[ref] == nil {
+				grants[ref] = sets.NewString()
+			}
+			grants[ref].Insert(ing.Namespace)
+		}
+	}
+	lbc.ingLister.setLBGroupGrants(grants)
+}
+
+// gc garbage collects the resources no Ingress uses anymore, and removes the
+// finalizer of the deleted Ingresses whose resources are gone. The Ingresses
+// are listed again, since other syncs may have changed the resources since
+// the checkpoint.
+func (lbc *LoadBalancerController) gc() error {
+	lbc.sharedLock.Lock()
+	defer lbc.sharedLock.Unlock()
+
+	allIngresses, err := lbc.ingLister.ListAll()
+	if err != nil {
+		return err
+	}
+	// The Ingresses whose deletion is blocked keep their resources.
+	blocked := lbc.blockDeletions()
+	activeKeys := lbc.ingLister.ListActiveKeys()
+	allIngresses = withoutDeletedIngresses(allIngresses)
+	for _, ing := range blocked {
+		if key, err := lbc.ingLister.lbKey(ing); err == nil {
+			activeKeys = append(activeKeys, key)
+		}
+		allIngresses.Items = append(allIngresses.Items, *ing)
+	}
+	multiClusterIngresses := extensions.IngressList{}
+	for _, ing := range allIngresses.Items {
+		if lbc.ingLister.isGCEMultiClusterIngress(&ing) {
+			multiClusterIngresses.Items = append(multiClusterIngresses.Items, ing)
+		}
+	}
+	if err := lbc.CloudClusterManager.GCMultiClusterBackends(lbc.Translator.toNodePorts(&multiClusterIngresses)); err != nil {
+		syncErrors.WithLabelValues(componentGC).Inc()
+		return err
+	}
+	if err := lbc.CloudClusterManager.GC(activeKeys, lbc.Translator.toNodePorts(&allIngresses)); err != nil {
+		syncErrors.WithLabelValues(componentGC).Inc()
+		return err
+	}
+	// The DNS records of the deleted Ingresses go with their load balancer.
+	dnsKeys := sets.NewString()
+	for _, ing := range allIngresses.Items {
+		if lbc.ingLister.isGCEIngress(&ing) {
+			dnsKeys.Insert(ingressKey(&ing))
+		}
+	}
+	if err := lbc.CloudClusterManager.GCDNSRecords(dnsKeys); err != nil {
+		syncErrors.WithLabelValues(componentGC).Inc()
+		return err
+	}
+	if err := lbc.removeDeletedIngressFinalizers(lbc.blockedDeletions); err != nil {
+		return fmt.Errorf("error removing finalizers %v", err)
+	}
+	return nil
+}
+
+// blockDeletions returns the Ingresses being deleted whose load balancer is
+// protected, by key, unless their deletion is confirmed: the ones with the
+// deletion protection annotation and, with deletionProtection, the ones whose
+// load balancer serves traffic and is not kept for the other Ingresses of
+// their LB group. An event asking to confirm the deletion is
+// raised on the Ingresses newly blocked. The caller holds sharedLock for
+// writing.
+func (lbc *LoadBalancerController) blockDeletions() map[string]*extensions.Ingress {
+	blocked := map[string]*extensions.Ingress{}
+	activeKeys := sets.NewString(lbc.ingLister.ListActiveKeys()...)
+	for _, m := range lbc.ingLister.Store.List() {
+		ing := m.(*extensions.Ingress)
+		key, err := keyFunc(ing)
+		if err != nil || ing.DeletionTimestamp == nil {
+			continue
+		}
+		if !lbc.mayBlockDeletion(ing) {
+			if lbc.blockedDeletions.Has(key) {
+				logging.ForIngress(key).Infof("Load balancer of the deleted Ingress no longer protected, deleting it")
+			}
+			continue
+		}
+		reason := ""
+		if annotations.IngAnnotations(ing.Annotations).DeletionProtection() {
+			reason = fmt.Sprintf("it has the %v annotation", annotations.DeletionProtectionKey)
+		} else if lbk, err := lbc.ingLister.lbKey(ing); err == nil && lbc.deletionProtection && !activeKeys.Has(lbk) {
+			// The load balancer of an LB group is kept anyway while other
+			// Ingresses of the group remain.
+			serving, err := lbc.servingBackend(lbk)
+			if err != nil {
+				reason = fmt.Sprintf("its serving status is unknown: %v", err)
+			} else if serving != "" {
+				reason = fmt.Sprintf("backend service %v still has healthy endpoints", serving)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		blocked[key] = ing
+		if !lbc.blockedDeletions.Has(key) {
+			logging.ForIngress(key).Warningf("Keeping the load balancer of the deleted Ingress, %v", reason)
+			lbc.recorder.Eventf(ing, apiv1.EventTypeWarning, "DeletionBlocked", "The load balancer is kept since %v. Annotate the Ingress with %v=true to confirm its deletion", reason, annotations.ConfirmDeletionKey)
+		}
+	}
+	lbc.blockedDeletions = sets.StringKeySet(blocked)
+	return blocked
+}
+
+// mayBlockDeletion returns true if the deletion of the given Ingress being
+// deleted may be blocked: it has the finalizer, is protected by the flag or
+// its annotation, and its deletion isn't confirmed.
+func (lbc *LoadBalancerController) mayBlockDeletion(ing *extensions.Ingress) bool {
+	ingAnnotations := annotations.IngAnnotations(ing.Annotations)
+	return hasFinalizer(ing) && !ingAnnotations.DeletionConfirmed() && (lbc.deletionProtection || ingAnnotations.DeletionProtection())
+}
+
+// withoutUnprotectedDeletions returns the Ingresses of the given list which
+// are not being deleted, or whose deletion may be blocked.
+func (lbc *LoadBalancerController) withoutUnprotectedDeletions(ings extensions.IngressList) extensions.IngressList {
+	kept := extensions.IngressList{}
+	for _, ing := range ings.Items {
+		if ing.DeletionTimestamp == nil || lbc.mayBlockDeletion(&ing) {
+			kept.Items = append(kept.Items, ing)
+		}
+	}
+	return kept
+}
+
+// servingBackend returns the name of a backend service of the load balancer
+// of the given key with healthy endpoints, empty if none or if the
+// Ingress has no load balancer. The default backend of the cluster, which
+// only answers 404s, doesn't count. The caller holds sharedLock.
+func (lbc *LoadBalancerController) servingBackend(key string) (string, error) {
+	l7, err := lbc.CloudClusterManager.l7Pool.Get(key)
+	if err != nil {
+		return "", nil
+	}
+	defaultBackend := ""
+	if port := lbc.CloudClusterManager.defaultBackendNodePort; port != nil {
+		defaultBackend = lbc.CloudClusterManager.ClusterNamer.Backend(port.Port)
+	}
+	for _, name := range l7.BackendNames() {
+		if name == defaultBackend {
+			continue
+		}
+		// GC holds the shared lock, so the health checked every
+		// backendHealthPeriod is used rather than asking GCE.
+		health, ok := lbc.cachedHealth(name)
+		if !ok {
+			return "", fmt.Errorf("the health of backend service %v wasn't checked yet", name)
+		}
+		if health.Healthy > 0 {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// collectOrphans garbage collects the resources whose Ingress or Service no
+// longer exists, eg: leaked by a crash of the controller. All the Ingresses
+// are owners, including the ones of other classes, so it never deletes the
+// resources of an Ingress GC keeps.
+func (lbc *LoadBalancerController) collectOrphans() {
+	if !lbc.hasSynced() {
+		return
+	}
+	lbc.sharedLock.Lock()
+	defer lbc.sharedLock.Unlock()
+
+	// The resources of the load balancers of the LB groups record the key
+	// of the group.
+	ingresses := sets.NewString(lbc.ingLister.Store.ListKeys()...)
+	for _, m := range lbc.ingLister.Store.List() {
+		if key, err := lbc.ingLister.lbKey(m.(*extensions.Ingress)); err == nil {
+			ingresses.Insert(key)
+		}
+	}
+	services := sets.NewString(lbc.svcLister.Indexer.ListKeys()...)
+	if err := lbc.CloudClusterManager.CollectOrphans(ingresses, services); err != nil {
+		syncErrors.WithLabelValues(componentGC).Inc()
+		logging.Warningf("Failed to garbage collect orphaned resources: %v", err)
+	}
+}
+
+// ApplyConfig applies the given runtime configuration, eg: read from the
+// ConfigMap of the controller, then resyncs all the GCE Ingresses with it.
+// The GCE rate limits are applied by the transport of the GCE clients.
+func (lbc *LoadBalancerController) ApplyConfig(config dynamicconfig.Config) {
+	lbc.configLock.Lock()
+	lbc.finalizerEnabled = config.EnableFinalizer
+	lbc.excludeUnreadyNodes = config.ExcludeUnreadyNodes
+	lbc.configLock.Unlock()
+
+	lbc.CloudClusterManager.SetFullSyncPeriod(config.FullSyncPeriod)
+	if err := lbc.CloudClusterManager.SetFirewallSrcRanges(config.FirewallSrcRanges); err != nil {
+		logging.Warningf("Failed to apply the firewall source ranges: %v", err)
+	}
+	// The components don't see the configuration as inputs, sync them all.
+	lbc.CloudClusterManager.resetSynced()
+	ings, err := lbc.ingLister.ListGCEIngresses()
+	if err != nil {
+		logging.Warningf("Failed to list Ingresses to apply the configuration: %v", err)
+		return
+	}
+	for i := range ings.Items {
+		lbc.ingQueue.enqueue(&ings.Items[i])
+	}
+}
+
+// registerMultiClusterBackends adds the instance groups, or the NEGs, of the
+// cluster to the backend services of the config cluster for the Service
+// ports of the given multi-cluster Ingress, if the cluster is a member of
+// multi-cluster Ingresses.
+func (lbc *LoadBalancerController) registerMultiClusterBackends(ing *extensions.Ingress, igs []*compute.InstanceGroup) error {
+	if lbc.CloudClusterManager.multiClusterBackends == nil {
+		return nil
+	}
+	lbc.sharedLock.Lock()
+	defer lbc.sharedLock.Unlock()
+
+	zones, err := lbc.Translator.ListZones()
+	if err != nil {
+		return err
+	}
+	svcPorts := lbc.Translator.toNodePorts(&extensions.IngressList{Items: []extensions.Ingress{*ing}})
+	return lbc.CloudClusterManager.RegisterMultiClusterBackends(svcPorts, igs, zones)
+}
+
+// linkNEGs adds the NEGs of the NEG enabled Service ports of the given
+// Ingress to their backend services. Backend services may be shared with
+// other Ingresses, so this is serialized with the shared resources.
+func (lbc *LoadBalancerController) linkNEGs(ing *extensions.Ingress) error {
+	lbc.sharedLock.Lock()
+	defer lbc.sharedLock.Unlock()
+
+	svcPorts := lbc.Translator.toNodePorts(&extensions.IngressList{Items: []extensions.Ingress{*ing}})
+	for _, svcPort := range svcPorts {
+		if !svcPort.NEGEnabled {
+			continue
+		}
+		var zones []string
+		var err error
+		if svcPort.HybridNEG != nil {
+			// Hybrid NEGs only exist in their own zone.
+			zones = []string{svcPort.HybridNEG.Zone}
+		} else if zones, err = lbc.Translator.ListZones(); err != nil {
+			return err
+		}
+		if err := lbc.CloudClusterManager.backendPool.Link(svcPort, zones); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncConditions returns the conditions of an Ingress after a sync of its
+// load balancer.
+func syncConditions(l7 *loadbalancers.L7, urlMapSynced bool, syncError error) []IngressCondition {
+	var conditions []IngressCondition
+	if syncError != nil {
+		conditions = append(conditions, newCondition(SyncedCondition, false, "SyncError", syncError.Error()))
+	} else {
+		conditions = append(conditions, newCondition(SyncedCondition, true, "Synced", ""))
+	}
+	switch ip := l7.GetIP(); {
+	case !urlMapSynced:
+		conditions = append(conditions, newCondition(FrontendProgrammedCondition, false, "UrlMapNotSynced", "The url map is not up to date"))
+	case ip == "":
+		conditions = append(conditions, newCondition(FrontendProgrammedCondition, false, "NoIP", "The forwarding rules have no IP yet"))
+	default:
+		conditions = append(conditions, newCondition(FrontendProgrammedCondition, true, "Programmed", fmt.Sprintf("ip: %v", ip)))
+	}
+	if l7.UsesTLS() {
+		if l7.CertificatesReady() {
+			conditions = append(conditions, newCondition(CertificateReadyCondition, true, "CertificatesServed", ""))
+		} else {
+			conditions = append(conditions, newCondition(CertificateReadyCondition, false, "CertificatesNotServed", "The target https proxy does not serve the certificates yet"))
+		}
+	}
+	return conditions
+}
+
+// updateConditions sets the given conditions in the conditions annotation of
+// the Ingress, and removes the conditions of the removed types. Nothing is
+// written if the conditions of the given, cached, Ingress already match,
+// transition times aside, so that syncs don't update the Ingress, and
+// trigger another sync, on every round. Otherwise the Ingress is read from the
+// apiserver, so that annotations updated since it was listed are kept.
+func (lbc *LoadBalancerController) updateConditions(ing *extensions.Ingress, conditions []IngressCondition, removed ...string) error {
+	cached := getConditions(ing.Annotations)
+	if conditionsEqual(cached, setConditions(cached, metav1.Now(), conditions, removed...)) {
+		return nil
+	}
+	ingClient := lbc.client.Extensions().Ingresses(ing.Namespace)
+	currIng, err := ingClient.Get(ing.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	existing := getConditions(currIng.Annotations)
+	updated := setConditions(existing, metav1.Now(), conditions, removed...)
+	if conditionsEqual(existing, updated) {
+		return nil
+	}
+	b, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	if currIng.Annotations == nil {
+		currIng.Annotations = map[string]string{}
+	}
+	currIng.Annotations[annotations.ConditionsKey] = string(b)
+	_, err = ingClient.Update(currIng)
+	return err
+}
+
 // updateIngressStatus updates the IP and annotations of a loadbalancer.
 // The annotations are parsed by kubectl describe.
 func (lbc *LoadBalancerController) updateIngressStatus(l7 *loadbalancers.L7, ing extensions.Ingress) error {
@@ -393,23 +1373,29 @@ func (lbc *LoadBalancerController) updateIngressStatus(l7 *loadbalancers.L7, ing
 	if err != nil {
 		return err
 	}
+	lbIngress := []apiv1.LoadBalancerIngress{{IP: ip}}
+	// The IPv6 address, if any, is published after the IPv4 one.
+	if ipv6 := l7.GetIPv6(); ipv6 != "" {
+		lbIngress = append(lbIngress, apiv1.LoadBalancerIngress{IP: ipv6})
+	}
 	currIng.Status = extensions.IngressStatus{
 		LoadBalancer: apiv1.LoadBalancerStatus{
-			Ingress: []apiv1.LoadBalancerIngress{
-				{IP: ip},
-			},
+			Ingress: lbIngress,
 		},
 	}
 	if ip != "" {
-		lbIPs := ing.Status.LoadBalancer.Ingress
-		if len(lbIPs) == 0 || lbIPs[0].IP != ip {
+		if !reflect.DeepEqual(ing.Status.LoadBalancer.Ingress, lbIngress) {
 			// TODO: If this update fails it's probably resource version related,
 			// which means it's advantageous to retry right away vs requeuing.
-			glog.Infof("Updating loadbalancer %v/%v with IP %v", ing.Namespace, ing.Name, ip)
+			logging.ForIngress(ingressKey(&ing)).WithOperation("update-status").Infof("Updating loadbalancer with IPs %v", lbIngress)
 			if _, err := ingClient.UpdateStatus(currIng); err != nil {
 				return err
 			}
-			lbc.recorder.Eventf(currIng, apiv1.EventTypeNormal, "CREATE", "ip: %v", ip)
+			if len(lbIngress) > 1 {
+				lbc.recorder.Eventf(currIng, apiv1.EventTypeNormal, "CREATE", "ip: %v, ipv6: %v", ip, lbIngress[1].IP)
+			} else {
+				lbc.recorder.Eventf(currIng, apiv1.EventTypeNormal, "CREATE", "ip: %v", ip)
+			}
 		}
 	}
 	annotations := loadbalancers.GetLBAnnotations(l7, currIng.Annotations, lbc.CloudClusterManager.backendPool)
@@ -427,7 +1413,7 @@ func (lbc *LoadBalancerController) updateAnnotations(name, namespace string, ann
 		return err
 	}
 	if !reflect.DeepEqual(currIng.Annotations, annotations) {
-		glog.V(3).Infof("Updating annotations of %v/%v", namespace, name)
+		logging.ForIngress(fmt.Sprintf("%v/%v", namespace, name)).WithOperation("update-annotations").V(3).Infof("Updating annotations")
 		currIng.Annotations = annotations
 		if _, err := ingClient.Update(currIng); err != nil {
 			return err
@@ -436,38 +1422,231 @@ func (lbc *LoadBalancerController) updateAnnotations(name, namespace string, ann
 	return nil
 }
 
-// toRuntimeInfo returns L7RuntimeInfo for the given ingresses.
+// persistNamingScheme records the naming scheme of the resources of the given
+// Ingress in its annotations, if it isn't yet.
+func (lbc *LoadBalancerController) persistNamingScheme(ing *extensions.Ingress) error {
+	if annotations.IngAnnotations(ing.Annotations).NamingScheme() != "" {
+		return nil
+	}
+	ingClient := lbc.client.Extensions().Ingresses(ing.Namespace)
+	currIng, err := ingClient.Get(ing.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if currIng.Annotations == nil {
+		currIng.Annotations = map[string]string{}
+	}
+	scheme := namingScheme(currIng)
+	logging.ForIngress(ingressKey(ing)).V(2).Infof("Using naming scheme %v", scheme)
+	currIng.Annotations[annotations.NamingSchemeKey] = string(scheme)
+	_, err = ingClient.Update(currIng)
+	return err
+}
+
+// updateFinalizer adds the finalizer of the controller to the given Ingress,
+// or removes it if add is false.
+func (lbc *LoadBalancerController) updateFinalizer(ing *extensions.Ingress, add bool) error {
+	if hasFinalizer(ing) == add {
+		return nil
+	}
+	ingClient := lbc.client.Extensions().Ingresses(ing.Namespace)
+	currIng, err := ingClient.Get(ing.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	finalizers := []string{}
+	for _, f := range currIng.Finalizers {
+		if f != annotations.IngressFinalizerKey {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if add {
+		logging.ForIngress(ingressKey(ing)).WithOperation("add-finalizer").V(2).Infof("Adding finalizer %v", annotations.IngressFinalizerKey)
+		finalizers = append(finalizers, annotations.IngressFinalizerKey)
+	} else {
+		logging.ForIngress(ingressKey(ing)).WithOperation("remove-finalizer").V(2).Infof("Removing finalizer %v", annotations.IngressFinalizerKey)
+	}
+	currIng.Finalizers = finalizers
+	_, err = ingClient.Update(currIng)
+	return err
+}
+
+// removeDeletedIngressFinalizers removes the finalizer of the Ingresses being
+// deleted, except the blocked ones, by key. It must only be called once the
+// garbage collection of their resources succeeded.
+func (lbc *LoadBalancerController) removeDeletedIngressFinalizers(blocked sets.String) error {
+	var errs []error
+	for _, m := range lbc.ingLister.Store.List() {
+		ing := m.(*extensions.Ingress)
+		if ing.DeletionTimestamp == nil || !hasFinalizer(ing) {
+			continue
+		}
+		if key, err := keyFunc(ing); err == nil && blocked.Has(key) {
+			continue
+		}
+		if err := lbc.updateFinalizer(ing, false); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// updateFirewallChangeAnnotation records the given firewall change on the
+// Ingress so automation in the network host project can apply it. If change
+// is nil, a previously recorded change is removed.
+func (lbc *LoadBalancerController) updateFirewallChangeAnnotation(ing *extensions.Ingress, change *firewalls.FirewallChange) error {
+	existing, found := ing.Annotations[annotations.FirewallChangeRequiredKey]
+	value := ""
+	if change != nil {
+		b, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	if existing == value && found == (change != nil) {
+		return nil
+	}
+	// Copy the annotations so we don't mutate the object in the store.
+	anns := map[string]string{}
+	for k, v := range ing.Annotations {
+		anns[k] = v
+	}
+	if change == nil {
+		delete(anns, annotations.FirewallChangeRequiredKey)
+	} else {
+		anns[annotations.FirewallChangeRequiredKey] = value
+	}
+	if err := lbc.updateAnnotations(ing.Name, ing.Namespace, anns); err != nil {
+		return err
+	}
+	ing.Annotations = anns
+	return nil
+}
+
+// toRuntimeInfo returns L7RuntimeInfo for the given ingresses, one per load
+// balancer. The load balancer of an LB group serves the certificates of all
+// its Ingresses, its other settings are the ones of the first Ingress by
+// precedence.
 func (lbc *LoadBalancerController) toRuntimeInfo(ingList extensions.IngressList) (lbs []*loadbalancers.L7RuntimeInfo, err error) {
 	for _, ing := range ingList.Items {
-		k, err := keyFunc(&ing)
-		if err != nil {
-			glog.Warningf("Cannot get key for Ingress %v/%v: %v", ing.Namespace, ing.Name, err)
+		value := annotations.IngAnnotations(ing.Annotations).LBGroup()
+		if value == "" || lbc.ingLister.lbGroup(&ing) != "" {
 			continue
 		}
-
-		var tls *loadbalancers.TLSCerts
+		if errs := lbGroupErrors(value); len(errs) > 0 {
+			lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "LBGroup", "Ignoring invalid LB group %q: %v", value, strings.Join(errs, ", "))
+		} else {
+			namespace, group := lbGroupRef(&ing)
+			lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "LBGroup", "Ignoring LB group %v/%v, no ReferenceGrant of namespace %v allows the Ingresses of namespace %v to join it", namespace, group, namespace, ing.Namespace)
+		}
+	}
+	keys, groups := lbc.ingLister.groupByLoadBalancer(ingList.Items)
+	for _, k := range keys {
+		ing := groups[k][0]
 
 		annotations := annotations.IngAnnotations(ing.ObjectMeta.Annotations)
-		// Load the TLS cert from the API Spec if it is not specified in the annotation.
-		// TODO: enforce this with validation.
-		if annotations.UseNamedTLS() == "" {
-			tls, err = lbc.tlsLoader.Load(&ing)
+		// The certs of the secrets are attached after the pre-shared certs of
+		// the annotation, if any.
+		var tlsCerts []*loadbalancers.TLSCerts
+		for i := range groups[k] {
+			tlsCerts = appendTLSCerts(tlsCerts, lbc.loadTLSCerts(&groups[k][i])...)
+		}
+		var managedCertDomains []string
+		if annotations.ManagedCertificates() {
+			managedCertDomains = managedCertificateDomains(groups[k])
+		}
+
+		// A FrontendConfig which can't be retrieved leaves the features of
+		// the load balancer untouched.
+		// Without annotation, the FrontendConfig is that of the
+		// parameters of the IngressClass.
+		var frontendConfig *frontendconfig.FrontendConfig
+		namespace, name := ing.Namespace, annotations.FrontendConfig()
+		if name == "" {
+			namespace, name = lbc.ingLister.classes.FrontendConfig(&ing)
+		}
+		if name != "" {
+			frontendConfig, err = lbc.frontendConfigGetter.Get(namespace, name)
+			if err != nil {
+				lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "FrontendConfig", "Ignoring FrontendConfig: %v", err)
+			}
+		}
+		// The certificate map replaces the certificates of the Ingress.
+		if frontendConfig != nil && frontendConfig.Spec.CertificateMap != nil && *frontendConfig.Spec.CertificateMap != "" &&
+			(len(tlsCerts) > 0 || annotations.UseNamedTLS() != "" || len(managedCertDomains) > 0) {
+			lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "FrontendConfig", "Ignoring the certificates of the Ingress, FrontendConfig %v attaches certificate map %v", frontendConfig.Name, *frontendConfig.Spec.CertificateMap)
+		}
+
+		// Without a cluster default backend, the Ingress must have its own.
+		var defaultBackend *backends.ServicePort
+		if lbc.CloudClusterManager.defaultBackendNodePort == nil {
+			if ing.Spec.Backend == nil {
+				lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "DefaultBackend", "Ignoring Ingress: the cluster has no default backend, set spec.backend")
+				continue
+			}
+			port, err := lbc.Translator.getServiceNodePort(*ing.Spec.Backend, ing.Namespace)
 			if err != nil {
-				glog.Warningf("Cannot get certs for Ingress %v/%v: %v", ing.Namespace, ing.Name, err)
+				lbc.recorder.Eventf(&ing, apiv1.EventTypeWarning, "DefaultBackend", "Ignoring Ingress: %v", err)
+				continue
 			}
+			defaultBackend = &port
 		}
 
 		lbs = append(lbs, &loadbalancers.L7RuntimeInfo{
-			Name:         k,
-			TLS:          tls,
-			TLSName:      annotations.UseNamedTLS(),
-			AllowHTTP:    annotations.AllowHTTP(),
-			StaticIPName: annotations.StaticIPName(),
+			Name:           k,
+			TLS:            tlsCerts,
+			TLSName:        annotations.UseNamedTLS(),
+			AllowHTTP:      annotations.AllowHTTP(),
+			StaticIPName:   annotations.StaticIPName(),
+			IPv6:           annotations.IPv6(),
+			StaticIPv6Name: annotations.StaticIPv6Name(),
+			DefaultBackend: defaultBackend,
+			FrontendConfig: frontendConfig,
+
+			ManagedCertDomains: managedCertDomains,
 		})
 	}
 	return lbs, nil
 }
 
+// loadTLSCerts returns the certificates of the secrets of the given Ingress.
+// Raises events for the ones which can't be loaded or expire soon.
+func (lbc *LoadBalancerController) loadTLSCerts(ing *extensions.Ingress) []*loadbalancers.TLSCerts {
+	tlsCerts, err := lbc.tlsLoader.Load(ing)
+	if err != nil {
+		logging.ForIngress(ingressKey(ing)).Warningf("Cannot get certs: %v", err)
+		lbc.recorder.Eventf(ing, apiv1.EventTypeWarning, "TLSCertificate", "%v", err)
+	}
+	for _, cert := range tlsCerts {
+		if expiry, ok := tls.ExpiresWithin(cert, lbc.certExpiryWarningPeriod); ok {
+			lbc.recorder.Eventf(ing, apiv1.EventTypeWarning, "TLSCertificateExpiring", "The certificate of secret %v expires on %v, rotate it", cert.Secret, expiry.Format(time.RFC3339))
+		}
+	}
+	return tlsCerts
+}
+
+// appendTLSCerts appends the given certificates which are not in certs yet,
+// eg: the secret of the same host in several Ingresses of an LB group.
+func appendTLSCerts(certs []*loadbalancers.TLSCerts, add ...*loadbalancers.TLSCerts) []*loadbalancers.TLSCerts {
+	for _, cert := range add {
+		dup := false
+		for _, c := range certs {
+			if c.Cert == cert.Cert && c.Key == cert.Key && c.Chain == cert.Chain {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
 // syncNodes manages the syncing of kubernetes nodes to gce instance groups.
 // The instancegroups are referenced by loadbalancer backends.
 func (lbc *LoadBalancerController) syncNodes(key string) error {
@@ -475,36 +1654,157 @@ func (lbc *LoadBalancerController) syncNodes(key string) error {
 	if err != nil {
 		return err
 	}
-	if err := lbc.CloudClusterManager.instancePool.Sync(nodeNames); err != nil {
+	if err := lbc.syncNodeZones(); err != nil {
+		return err
+	}
+	if err := lbc.CloudClusterManager.SyncNodes(nodeNames); err != nil {
+		return err
+	}
+	return nil
+}
+
+// syncNodeZones enqueues an Ingress if the nodes joined a new zone, so that
+// the instance group of the zone is created and added to the backend
+// services right away. A single sync does it for the backend services of all
+// Ingresses.
+func (lbc *LoadBalancerController) syncNodeZones() error {
+	zoneList, err := lbc.Translator.ListZones()
+	if err != nil {
+		return err
+	}
+	zones := sets.NewString(zoneList...)
+	defer func() { lbc.nodeZones = zones }()
+	if lbc.nodeZones == nil {
+		// The initial sync of the Ingresses takes care of the zones.
+		return nil
+	}
+	newZones := zones.Difference(lbc.nodeZones)
+	if newZones.Len() == 0 {
+		return nil
+	}
+	ings, err := lbc.ingLister.ListGCEIngresses()
+	if err != nil {
 		return err
 	}
+	if len(ings.Items) == 0 {
+		return nil
+	}
+	logging.Infof("Nodes joined zones %v, syncing Ingress %v/%v to add their instance groups", newZones.List(), ings.Items[0].Namespace, ings.Items[0].Name)
+	lbc.ingQueue.enqueue(&ings.Items[0])
 	return nil
 }
 
 func getNodeReadyPredicate() listers.NodeConditionPredicate {
-	return func(node *apiv1.Node) bool {
-		for ix := range node.Status.Conditions {
-			condition := &node.Status.Conditions[ix]
-			if condition.Type == apiv1.NodeReady {
-				return condition.Status == apiv1.ConditionTrue
-			}
+	return nodeReady
+}
+
+// nodeReady returns true if the given node is Ready.
+func nodeReady(node *apiv1.Node) bool {
+	ready, _ := nodeReadyCondition(node)
+	return ready
+}
+
+// nodeReadyCondition returns true if the given node is Ready, along with the
+// last transition time of its Ready condition.
+func nodeReadyCondition(node *apiv1.Node) (bool, time.Time) {
+	for ix := range node.Status.Conditions {
+		condition := &node.Status.Conditions[ix]
+		if condition.Type == apiv1.NodeReady {
+			return condition.Status == apiv1.ConditionTrue, condition.LastTransitionTime.Time
 		}
-		return false
 	}
+	return false, time.Time{}
+}
+
+// isExcludedNode returns true if the given node must be kept out of the
+// instance groups: it has the standard exclusion label, matches the node
+// exclusion selector, or is an excluded Windows node.
+func (lbc *LoadBalancerController) isExcludedNode(node *apiv1.Node) bool {
+	if _, ok := node.Labels[annotations.ExcludeFromLBLabelKey]; ok {
+		return true
+	}
+	if lbc.excludeWindowsNodes && isWindowsNode(node) {
+		return true
+	}
+	selector := lbc.nodeExclusionSelector
+	return selector != nil && !selector.Empty() && selector.Matches(labels.Set(node.Labels))
+}
+
+// EnsureL4InstanceGroups implements l4.InstanceGroups: the internal load
+// balancers share the instance groups of the Ingresses, with the same nodes.
+func (lbc *LoadBalancerController) EnsureL4InstanceGroups(key string) ([]*compute.InstanceGroup, error) {
+	nodeNames, err := lbc.getReadyNodeNames()
+	if err != nil {
+		return nil, err
+	}
+	return lbc.CloudClusterManager.EnsureL4InstanceGroups(key, nodeNames)
+}
+
+// ReleaseL4InstanceGroups implements l4.InstanceGroups.
+func (lbc *LoadBalancerController) ReleaseL4InstanceGroups(key string) {
+	lbc.CloudClusterManager.ReleaseL4InstanceGroups(key)
 }
 
-// getReadyNodeNames returns names of schedulable, ready nodes from the node lister.
+// IngressClass implements regional.Cluster: the regional load balancers are
+// picked through the IngressClasses like the global ones.
+func (lbc *LoadBalancerController) IngressClass(ing *extensions.Ingress) string {
+	return lbc.ingLister.classes.Class(ing)
+}
+
+// IngressPathTypes implements regional.Cluster: the regional load balancers
+// match the paths of their Ingresses like the global ones.
+func (lbc *LoadBalancerController) IngressPathTypes(ing *extensions.Ingress) (map[string]map[string]string, error) {
+	return lbc.ingPathTypes.get(ing)
+}
+
+// EndpointTargetPorts implements regional.Cluster.
+func (lbc *LoadBalancerController) EndpointTargetPorts(namespace, name, targetPort string) []int {
+	return lbc.endpointLister.ListEndpointTargetPorts(namespace, name, targetPort)
+}
+
+// getReadyNodeNames returns names of the nodes of the instance groups from
+// the node lister: the schedulable, ready nodes, unless excludeUnreadyNodes
+// is false, without the nodes excluded from the load balancers.
 func (lbc *LoadBalancerController) getReadyNodeNames() ([]string, error) {
 	nodeNames := []string{}
-	nodes, err := listers.NewNodeLister(lbc.nodeLister.Indexer).ListWithPredicate(getNodeReadyPredicate())
+	nodes, err := listers.NewNodeLister(lbc.nodeLister.Indexer).List(labels.Everything())
 	if err != nil {
 		return nodeNames, err
 	}
+
+	lbc.configLock.RLock()
+	excludeUnreadyNodes := lbc.excludeUnreadyNodes
+	lbc.configLock.RUnlock()
+	lbc.instanceGroupNodesLock.Lock()
+	defer lbc.instanceGroupNodesLock.Unlock()
+	now := time.Now()
 	for _, n := range nodes {
-		if n.Spec.Unschedulable {
+		if lbc.isExcludedNode(n) {
+			continue
+		}
+		if excludeUnreadyNodes && !lbc.isServingNode(n, now) {
 			continue
 		}
 		nodeNames = append(nodeNames, n.Name)
 	}
+	lbc.instanceGroupNodes = sets.NewString(nodeNames...)
 	return nodeNames, nil
 }
+
+// isServingNode returns true if the given node is ready and schedulable.
+// Cordoned nodes leave the instance groups right away, but a node whose
+// readiness changed within the grace period keeps its membership of the
+// instance groups, so that flapping nodes don't churn them.
+// Callers must hold instanceGroupNodesLock.
+func (lbc *LoadBalancerController) isServingNode(node *apiv1.Node, now time.Time) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	ready, since := nodeReadyCondition(node)
+	member := lbc.instanceGroupNodes.Has(node.Name)
+	if ready != member && now.Sub(since) < lbc.unreadyNodeGracePeriod {
+		logging.V(3).Infof("Node %v changed readiness at %v, within the grace period of %v, keeping it as is", node.Name, since, lbc.unreadyNodeGracePeriod)
+		return member
+	}
+	return ready
+}
@@ -17,11 +17,17 @@ limitations under the License.
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	compute "google.golang.org/api/compute/v1"
 
 	api_v1 "k8s.io/api/core/v1"
@@ -31,10 +37,13 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/kubernetes/pkg/api"
 
 	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
 	"k8s.io/ingress-gce/pkg/context"
+	"k8s.io/ingress-gce/pkg/dns"
 	"k8s.io/ingress-gce/pkg/firewalls"
 	"k8s.io/ingress-gce/pkg/loadbalancers"
 	"k8s.io/ingress-gce/pkg/tls"
@@ -57,7 +66,7 @@ func defaultBackendName(clusterName string) string {
 func newLoadBalancerController(t *testing.T, cm *fakeClusterManager) *LoadBalancerController {
 	kubeClient := fake.NewSimpleClientset()
 	ctx := context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, true)
-	lb, err := NewLoadBalancerController(kubeClient, ctx, cm.ClusterManager, true)
+	lb, err := NewLoadBalancerController(kubeClient, ctx, cm.ClusterManager, ControllerConfig{NEGEnabled: true, ExcludeUnreadyNodes: true, SyncWorkers: 1})
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
@@ -177,6 +186,9 @@ func newPortManager(st, end int) *nodePortManager {
 // with a nodePort acquired through it.
 func addIngress(lbc *LoadBalancerController, ing *extensions.Ingress, pm *nodePortManager) {
 	lbc.ingLister.Store.Add(ing)
+	// The controller persists the naming scheme through the apiserver. The
+	// Ingress may already exist.
+	lbc.client.Extensions().Ingresses(ing.Namespace).Create(ing)
 	if pm == nil {
 		return
 	}
@@ -256,8 +268,16 @@ func TestLbCreateDelete(t *testing.T) {
 		if len(firewallRule.Allowed) != 1 {
 			t.Fatalf("Expected a single firewall rule")
 		}
+		// Contiguous ports are collapsed into ranges.
 		for _, p := range firewallRule.Allowed[0].Ports {
-			firewallPorts.Insert(p)
+			var first, last int
+			if _, err := fmt.Sscanf(p, "%d-%d", &first, &last); err != nil {
+				firewallPorts.Insert(p)
+				continue
+			}
+			for port := first; port <= last; port++ {
+				firewallPorts.Insert(fmt.Sprintf("%v", port))
+			}
 		}
 	}
 
@@ -297,6 +317,852 @@ func TestLbCreateDelete(t *testing.T) {
 	}
 }
 
+func TestLbFinalizer(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	lbc.finalizerEnabled = true
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/foo1": "foo1svc",
+		},
+	}
+	pm := newPortManager(1, 65536)
+	ing := newIngress(inputMap)
+	addIngress(lbc, ing, pm)
+	ingClient := lbc.client.Extensions().Ingresses(ing.Namespace)
+	ingStoreKey := getKey(ing, t)
+	if err := lbc.sync(ingStoreKey); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	updatedIng, _ := ingClient.Get(ing.Name, meta_v1.GetOptions{})
+	if !hasFinalizer(updatedIng) {
+		t.Fatalf("Expect finalizer %v on Ingress, got %v", annotations.IngressFinalizerKey, updatedIng.Finalizers)
+	}
+	if _, err := cm.l7Pool.Get(ingStoreKey); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The Ingress is deleted, the finalizer keeps it until the resources are
+	// deleted.
+	now := meta_v1.Now()
+	updatedIng.DeletionTimestamp = &now
+	lbc.ingLister.Store.Update(updatedIng)
+	if err := lbc.sync(ingStoreKey); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if l7, err := cm.l7Pool.Get(ingStoreKey); err == nil {
+		t.Fatalf("Found unexpected loadbalancer %+v", l7)
+	}
+	if len(cm.fakeLbs.Fw) != 0 || len(cm.fakeLbs.Um) != 0 || len(cm.fakeLbs.Tp) != 0 {
+		t.Fatalf("Loadbalancer leaked resources")
+	}
+	if updatedIng, _ = ingClient.Get(ing.Name, meta_v1.GetOptions{}); hasFinalizer(updatedIng) {
+		t.Errorf("Expect finalizer to be removed from Ingress, got %v", updatedIng.Finalizers)
+	}
+}
+
+func TestLbDeletionProtection(t *testing.T) {
+	for _, tc := range []struct {
+		desc               string
+		deletionProtection bool
+		annotation         bool
+		health             string
+		wantBlocked        bool
+	}{
+		{desc: "serving", deletionProtection: true, health: "HEALTHY", wantBlocked: true},
+		{desc: "not serving", deletionProtection: true, health: "UNHEALTHY"},
+		{desc: "protection annotation", annotation: true, health: "UNHEALTHY", wantBlocked: true},
+		{desc: "disabled", health: "HEALTHY"},
+		// The health of the backends wasn't checked yet.
+		{desc: "unknown health", deletionProtection: true, wantBlocked: true},
+	} {
+		cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+		lbc := newLoadBalancerController(t, cm)
+		lbc.deletionProtection = tc.deletionProtection
+		// The deletions can only be blocked with the finalizer.
+		lbc.finalizerEnabled = true
+		nodePort := int64(30080)
+		lbc.svcLister.Indexer.Add(&api_v1.Service{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: api.NamespaceNone},
+			Spec: api_v1.ServiceSpec{
+				Ports: []api_v1.ServicePort{{Port: 80, NodePort: int32(nodePort)}},
+			},
+		})
+		ing := newIngress(map[string]utils.FakeIngressRuleValueMap{})
+		ing.Spec.Rules = []extensions.IngressRule{{
+			Host: "foo.bar.com",
+			IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+				Paths: []extensions.HTTPIngressPath{{Path: "/foo", Backend: extensions.IngressBackend{ServiceName: "svc", ServicePort: intstr.FromInt(80)}}},
+			}},
+		}}
+		if tc.annotation {
+			ing.Annotations = map[string]string{annotations.DeletionProtectionKey: "true"}
+		}
+		addIngress(lbc, ing, nil)
+		ingClient := lbc.client.Extensions().Ingresses(ing.Namespace)
+		key := getKey(ing, t)
+		if err := lbc.sync(key); err != nil {
+			t.Fatalf("%v: failed to sync: %v", tc.desc, err)
+		}
+		recorder := record.NewFakeRecorder(10)
+		lbc.recorder = recorder
+		if tc.health != "" {
+			cm.fakeBackends.SetHealthState(cm.ClusterNamer.Backend(nodePort), tc.health)
+			lbc.syncBackendHealth()
+			// Drain the events of the health check.
+			for len(recorder.Events) > 0 {
+				<-recorder.Events
+			}
+		}
+
+		deleteIng := func() *extensions.Ingress {
+			t.Helper()
+			currIng, err := ingClient.Get(ing.Name, meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("%v: %v", tc.desc, err)
+			}
+			now := meta_v1.Now()
+			currIng.DeletionTimestamp = &now
+			lbc.ingLister.Store.Update(currIng)
+			if err := lbc.sync(key); err != nil {
+				t.Fatalf("%v: failed to sync: %v", tc.desc, err)
+			}
+			currIng, _ = ingClient.Get(ing.Name, meta_v1.GetOptions{})
+			return currIng
+		}
+		currIng := deleteIng()
+		_, err := cm.l7Pool.Get(key)
+		if blocked := err == nil; blocked != tc.wantBlocked {
+			t.Errorf("%v: got load balancer kept %v, want %v", tc.desc, blocked, tc.wantBlocked)
+		}
+		if hasFinalizer(currIng) != tc.wantBlocked {
+			t.Errorf("%v: got finalizers %v, want the finalizer kept %v", tc.desc, currIng.Finalizers, tc.wantBlocked)
+		}
+		if !tc.wantBlocked {
+			continue
+		}
+		select {
+		case e := <-recorder.Events:
+			if !strings.Contains(e, "DeletionBlocked") || !strings.Contains(e, annotations.ConfirmDeletionKey) {
+				t.Errorf("%v: expected a DeletionBlocked event, got %q", tc.desc, e)
+			}
+		default:
+			t.Errorf("%v: expected a DeletionBlocked event", tc.desc)
+		}
+		// The event is only raised once.
+		deleteIng()
+		select {
+		case e := <-recorder.Events:
+			t.Errorf("%v: unexpected event %q", tc.desc, e)
+		default:
+		}
+
+		// The deletion is confirmed.
+		currIng.Annotations[annotations.ConfirmDeletionKey] = "true"
+		if _, err := ingClient.Update(currIng); err != nil {
+			t.Fatalf("%v: %v", tc.desc, err)
+		}
+		currIng = deleteIng()
+		if l7, err := cm.l7Pool.Get(key); err == nil {
+			t.Errorf("%v: found unexpected loadbalancer %+v after the confirmation", tc.desc, l7)
+		}
+		if hasFinalizer(currIng) {
+			t.Errorf("%v: expected the finalizer to be removed after the confirmation, got %v", tc.desc, currIng.Finalizers)
+		}
+	}
+}
+
+func TestLbSharedBackend(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	nodePort := int64(30080)
+	lbc.svcLister.Indexer.Add(&api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "shared", Namespace: api.NamespaceNone},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{{Name: "http", Port: 80, NodePort: int32(nodePort)}},
+		},
+	})
+
+	// Both Ingresses share the backend service, referencing the Service port
+	// by name and by number.
+	var ings []*extensions.Ingress
+	for _, svcPort := range []intstr.IntOrString{intstr.FromString("http"), intstr.FromInt(80)} {
+		ing := newIngress(map[string]utils.FakeIngressRuleValueMap{})
+		ing.Spec.Rules = []extensions.IngressRule{{
+			Host: "foo.bar.com",
+			IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+				Paths: []extensions.HTTPIngressPath{{Path: "/foo", Backend: extensions.IngressBackend{ServiceName: "shared", ServicePort: svcPort}}},
+			}},
+		}}
+		addIngress(lbc, ing, nil)
+		ings = append(ings, ing)
+	}
+
+	// The backend service is synced with the same description, whatever the
+	// order of the Ingresses.
+	var description string
+	for i := 0; i < 5; i++ {
+		for _, ing := range ings {
+			lbc.sync(getKey(ing, t))
+			be, err := cm.backendPool.Get(nodePort)
+			if err != nil {
+				t.Fatalf("Expected backend for port %v: %v", nodePort, err)
+			}
+			if description == "" {
+				description = be.Description
+			} else if be.Description != description {
+				t.Fatalf("Backend description changed from %q to %q", description, be.Description)
+			}
+		}
+	}
+
+	// The backend service is only GCed with the last Ingress.
+	lbc.ingLister.Store.Delete(ings[0])
+	lbc.sync(getKey(ings[0], t))
+	if _, err := cm.backendPool.Get(nodePort); err != nil {
+		t.Fatalf("Expected shared backend to be kept: %v", err)
+	}
+	lbc.ingLister.Store.Delete(ings[1])
+	lbc.sync(getKey(ings[1], t))
+	if be, err := cm.backendPool.Get(nodePort); err == nil {
+		t.Fatalf("Found backend %+v for port %v", be, nodePort)
+	}
+}
+
+func TestManagedCertificate(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	recorder := record.NewFakeRecorder(100)
+	lbc.recorder = recorder
+	lbc.svcLister.Indexer.Add(&api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: "default"},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{{Port: 80, NodePort: 30080}},
+		},
+	})
+	var rules []extensions.IngressRule
+	for _, host := range []string{"foo.bar.com", "*.bar.com"} {
+		rules = append(rules, extensions.IngressRule{
+			Host:             host,
+			IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{Paths: toHTTPIngressPaths(map[string]string{"/foo": "svc"})}},
+		})
+	}
+	ing := &extensions.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "ing",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.ManagedCertificatesKey: "true"},
+		},
+		Spec: extensions.IngressSpec{Rules: rules},
+	}
+	addIngress(lbc, ing, nil)
+	if err := lbc.sync(getKey(ing, t)); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	lbName := cm.ClusterNamer.LoadBalancer(getKey(ing, t))
+	tps, err := cm.fakeLbs.GetTargetHttpsProxy(cm.ClusterNamer.TargetProxy(lbName, utils.HTTPSProtocol))
+	if err != nil || len(tps.SslCertificates) != 1 || !cm.ClusterNamer.IsHashedSSLCert(tps.SslCertificates[0]) {
+		t.Fatalf("Expected the https proxy to serve the managed certificate, got %+v, %v", tps, err)
+	}
+	provisioning := false
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; strings.Contains(e, "ManagedCertificate") && strings.Contains(e, "PROVISIONING") {
+			provisioning = true
+		}
+	}
+	if !provisioning {
+		t.Errorf("Expected a ManagedCertificate event while the certificate is provisioning")
+	}
+}
+
+func TestManagedCertificateDomains(t *testing.T) {
+	ing := func(hosts ...string) extensions.Ingress {
+		var rules []extensions.IngressRule
+		for _, host := range hosts {
+			rules = append(rules, extensions.IngressRule{Host: host})
+		}
+		return extensions.Ingress{Spec: extensions.IngressSpec{Rules: rules}}
+	}
+	got := managedCertificateDomains([]extensions.Ingress{ing("foo.bar.com", "", "*.bar.com"), ing("baz.bar.com", "foo.bar.com")})
+	if want := []string{"baz.bar.com", "foo.bar.com"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("managedCertificateDomains() = %v, want %v", got, want)
+	}
+}
+
+func TestLbGroup(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	recorder := record.NewFakeRecorder(100)
+	lbc.recorder = recorder
+	grants := &tls.FakeReferenceGrantGetter{}
+	lbc.referenceGrants = grants
+	// The Ingresses of the LB group of team-a belong to different teams, the
+	// second one routes a path of the first.
+	var ings []*extensions.Ingress
+	for i, team := range []struct {
+		namespace string
+		group     string
+		paths     map[string]string
+	}{
+		{"team-a", "shared", map[string]string{"/foo": "svc"}},
+		{"team-b", "team-a/shared", map[string]string{"/foo": "svc", "/bar": "svc"}},
+	} {
+		lbc.svcLister.Indexer.Add(&api_v1.Service{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: team.namespace},
+			Spec: api_v1.ServiceSpec{
+				Ports: []api_v1.ServicePort{{Port: 80, NodePort: int32(30080 + i)}},
+			},
+		})
+		ing := &extensions.Ingress{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:              "ing",
+				Namespace:         team.namespace,
+				CreationTimestamp: meta_v1.NewTime(time.Unix(int64(i), 0)),
+				Annotations:       map[string]string{annotations.LBGroupKey: team.group},
+			},
+			Spec: extensions.IngressSpec{
+				Rules: []extensions.IngressRule{{
+					Host:             "foo.bar.com",
+					IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{Paths: toHTTPIngressPaths(team.paths)}},
+				}},
+			},
+		}
+		addIngress(lbc, ing, nil)
+		ings = append(ings, ing)
+	}
+	syncAll := func() {
+		t.Helper()
+		for _, ing := range ings {
+			if err := lbc.sync(getKey(ing, t)); err != nil {
+				t.Fatalf("Failed to sync %v: %v", getKey(ing, t), err)
+			}
+		}
+	}
+
+	// The Ingress of team-b can't join the LB group of team-a until a
+	// ReferenceGrant of team-a allows it.
+	syncAll()
+	if len(cm.fakeLbs.Fw) != 2 {
+		t.Fatalf("Expected a load balancer per Ingress, got forwarding rules %+v", cm.fakeLbs.Fw)
+	}
+	denied := false
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; strings.Contains(e, "LBGroup") && strings.Contains(e, "no ReferenceGrant of namespace team-a") {
+			denied = true
+		}
+	}
+	if !denied {
+		t.Errorf("Expected an LBGroup event for the Ingress of team-b")
+	}
+	grants.Grants = map[string][]tls.ReferenceGrant{"team-a": {{
+		Spec: tls.ReferenceGrantSpec{
+			From: []tls.ReferenceGrantFrom{{Group: "networking.k8s.io", Kind: "Ingress", Namespace: "team-b"}},
+			To:   []tls.ReferenceGrantTo{{Group: tls.LBGroupGroupName, Kind: tls.LBGroupKind}},
+		},
+	}}}
+	syncAll()
+
+	// A single load balancer serves both Ingresses.
+	if len(cm.fakeLbs.Fw) != 1 || len(cm.fakeLbs.Um) != 1 {
+		t.Fatalf("Expected a single load balancer, got forwarding rules %+v and url maps %+v", cm.fakeLbs.Fw, cm.fakeLbs.Um)
+	}
+	l7, err := cm.l7Pool.Get(lbGroupKeyPrefix + "team-a/shared")
+	if err != nil {
+		t.Fatalf("Expected the load balancer of the LB group: %v", err)
+	}
+	if err := cm.fakeLbs.CheckURLMap(l7, map[string]utils.FakeIngressRuleValueMap{
+		"foo.bar.com": {"/foo": cm.ClusterNamer.Backend(30080), "/bar": cm.ClusterNamer.Backend(30081)},
+	}); err != nil {
+		t.Errorf("%v", err)
+	}
+	for _, ing := range ings {
+		currIng, err := lbc.client.Extensions().Ingresses(ing.Namespace).Get(ing.Name, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := currIng.Status.LoadBalancer.Ingress; len(got) != 1 || got[0].IP != l7.GetIP() {
+			t.Errorf("Expected Ingress %v to get the IP %v of the LB group, got %v", getKey(ing, t), l7.GetIP(), got)
+		}
+	}
+	conflict := false
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; strings.Contains(e, "LBGroupConflict") && strings.Contains(e, "foo.bar.com/foo") {
+			conflict = true
+		}
+	}
+	if !conflict {
+		t.Errorf("Expected an LBGroupConflict event for foo.bar.com/foo")
+	}
+	// The conflict is only reported on the syncs of the Ingress it concerns.
+	for i, ing := range ings {
+		if err := lbc.sync(getKey(ing, t)); err != nil {
+			t.Fatalf("Failed to sync %v: %v", getKey(ing, t), err)
+		}
+		// The paths of the second Ingress conflict with the first one.
+		want, conflicts := i, 0
+		for len(recorder.Events) > 0 {
+			if e := <-recorder.Events; strings.Contains(e, "LBGroupConflict") {
+				conflicts++
+			}
+		}
+		if conflicts != want {
+			t.Errorf("Expected %d LBGroupConflict events on the sync of %v, got %d", want, getKey(ing, t), conflicts)
+		}
+	}
+
+	// The paths of the deleted Ingress leave the url map of the LB group,
+	// which is kept for the remaining one.
+	lbc.ingLister.Store.Delete(ings[0])
+	if err := lbc.sync(getKey(ings[0], t)); err != nil {
+		t.Fatalf("Failed to sync %v: %v", getKey(ings[0], t), err)
+	}
+	if err := lbc.sync(getKey(ings[1], t)); err != nil {
+		t.Fatalf("Failed to sync %v: %v", getKey(ings[1], t), err)
+	}
+	if l7, err = cm.l7Pool.Get(lbGroupKeyPrefix + "team-a/shared"); err != nil {
+		t.Fatalf("Expected the load balancer of the LB group to be kept: %v", err)
+	}
+	if err := cm.fakeLbs.CheckURLMap(l7, map[string]utils.FakeIngressRuleValueMap{
+		"foo.bar.com": {"/foo": cm.ClusterNamer.Backend(30081), "/bar": cm.ClusterNamer.Backend(30081)},
+	}); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	lbc.ingLister.Store.Delete(ings[1])
+	if err := lbc.sync(getKey(ings[1], t)); err != nil {
+		t.Fatalf("Failed to sync %v: %v", getKey(ings[1], t), err)
+	}
+	if l7, err := cm.l7Pool.Get(lbGroupKeyPrefix + "team-a/shared"); err == nil {
+		t.Errorf("Found unexpected load balancer %+v of the deleted LB group", l7)
+	}
+}
+
+func TestLbDNSRecords(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	zones := dns.NewFakeManagedZones(map[string]string{"zone": "example.com."})
+	cm.dnsRecords = dns.NewRecords(zones, "zone", "uid", time.Minute)
+	lbc := newLoadBalancerController(t, cm)
+	ing := newIngress(map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": testPathMap,
+		"foo.other.com":   testPathMap,
+	})
+	addIngress(lbc, ing, newPortManager(1, 65536))
+	key := getKey(ing, t)
+	recorder := record.NewFakeRecorder(100)
+	lbc.recorder = recorder
+	if err := lbc.sync(key); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	l7, err := cm.l7Pool.Get(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if rrset := zones.Get("zone", "foo.example.com.", "A"); rrset == nil || len(rrset.Rrdatas) != 1 || rrset.Rrdatas[0] != l7.GetIP() {
+		t.Errorf("Got A record %+v, want the IP %v of the load balancer", rrset, l7.GetIP())
+	}
+	// The host outside the zone is reported.
+	skipped := false
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; strings.Contains(e, "DNS") && strings.Contains(e, "foo.other.com") {
+			skipped = true
+		}
+	}
+	if !skipped {
+		t.Errorf("Expected a DNS event for the host outside the zone")
+	}
+
+	lbc.ingLister.Store.Delete(ing)
+	if err := lbc.sync(key); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if len(zones.Records["zone"]) != 0 {
+		t.Errorf("Expected the DNS records of the deleted Ingress to be deleted, got %+v", zones.Records["zone"])
+	}
+}
+
+func TestLbServerlessNEG(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	lbc.svcLister.Indexer.Add(&api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "run",
+			Namespace:   api.NamespaceNone,
+			Annotations: map[string]string{annotations.ServerlessNEGKey: `{"region": "us-central1", "name": "run-neg"}`},
+		},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{{Port: 80}},
+		},
+	})
+	ing := newIngress(map[string]utils.FakeIngressRuleValueMap{})
+	ing.Spec.Rules = []extensions.IngressRule{{
+		Host: "foo.bar.com",
+		IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+			Paths: []extensions.HTTPIngressPath{{Path: "/run", Backend: extensions.IngressBackend{ServiceName: "run", ServicePort: intstr.FromInt(80)}}},
+		}},
+	}}
+	addIngress(lbc, ing, nil)
+	ingStoreKey := getKey(ing, t)
+	lbc.sync(ingStoreKey)
+
+	beName := cm.ClusterNamer.ServerlessBackend("us-central1", "run-neg")
+	be, err := cm.fakeBackends.GetGlobalBackendService(beName)
+	if err != nil {
+		t.Fatalf("Expected backend service %v: %v", beName, err)
+	}
+	if len(be.Backends) != 1 || be.Backends[0].Group != "regions/us-central1/networkEndpointGroups/run-neg" {
+		t.Errorf("Expected the serverless NEG as only backend, got %+v", be.Backends)
+	}
+	l7, err := cm.l7Pool.Get(ingStoreKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := cm.fakeLbs.CheckURLMap(l7, map[string]utils.FakeIngressRuleValueMap{"foo.bar.com": {"/run": beName}}); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	lbc.ingLister.Store.Delete(ing)
+	lbc.sync(ingStoreKey)
+	if _, err := cm.fakeBackends.GetGlobalBackendService(beName); err == nil {
+		t.Errorf("Expected backend service %v to be GCed", beName)
+	}
+}
+
+func TestLbInternetNEG(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	lbc.svcLister.Indexer.Add(&api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "legacy", Namespace: api.NamespaceNone},
+		Spec: api_v1.ServiceSpec{
+			Type:         api_v1.ServiceTypeExternalName,
+			ExternalName: "legacy.example.com",
+			Ports:        []api_v1.ServicePort{{Port: 443}},
+		},
+	})
+	ing := newIngress(map[string]utils.FakeIngressRuleValueMap{})
+	ing.Spec.Rules = []extensions.IngressRule{{
+		Host: "foo.bar.com",
+		IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+			Paths: []extensions.HTTPIngressPath{{Path: "/legacy", Backend: extensions.IngressBackend{ServiceName: "legacy", ServicePort: intstr.FromInt(443)}}},
+		}},
+	}}
+	addIngress(lbc, ing, nil)
+	ingStoreKey := getKey(ing, t)
+	lbc.sync(ingStoreKey)
+
+	beName := cm.ClusterNamer.InternetBackend(api.NamespaceNone, "legacy", "443")
+	be, err := cm.fakeBackends.GetGlobalBackendService(beName)
+	if err != nil {
+		t.Fatalf("Expected backend service %v: %v", beName, err)
+	}
+	if len(be.Backends) != 1 || be.Backends[0].Group != "global/networkEndpointGroups/"+beName {
+		t.Errorf("Expected the internet NEG as only backend, got %+v", be.Backends)
+	}
+	if eps := cm.fakeExtendedBackends.Endpoints[beName]; len(eps) != 1 || eps[0].Fqdn != "legacy.example.com" || eps[0].Port != 443 {
+		t.Errorf("Expected the external name as only endpoint of the internet NEG, got %+v", eps)
+	}
+	l7, err := cm.l7Pool.Get(ingStoreKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := cm.fakeLbs.CheckURLMap(l7, map[string]utils.FakeIngressRuleValueMap{"foo.bar.com": {"/legacy": beName}}); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	lbc.ingLister.Store.Delete(ing)
+	lbc.sync(ingStoreKey)
+	if _, err := cm.fakeExtendedBackends.GetGlobalNetworkEndpointGroup(beName); err == nil {
+		t.Errorf("Expected internet NEG %v to be GCed", beName)
+	}
+}
+
+func TestLbBackendHealth(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	nodePort := int64(30080)
+	lbc.svcLister.Indexer.Add(&api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: api.NamespaceNone},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{{Port: 80, NodePort: int32(nodePort)}},
+		},
+	})
+	ing := newIngress(map[string]utils.FakeIngressRuleValueMap{})
+	ing.Spec.Rules = []extensions.IngressRule{{
+		Host: "foo.bar.com",
+		IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+			Paths: []extensions.HTTPIngressPath{{Path: "/foo", Backend: extensions.IngressBackend{ServiceName: "svc", ServicePort: intstr.FromInt(80)}}},
+		}},
+	}}
+	addIngress(lbc, ing, nil)
+	ingClient := lbc.client.Extensions().Ingresses(ing.Namespace)
+	lbc.sync(getKey(ing, t))
+	recorder := record.NewFakeRecorder(10)
+	lbc.recorder = recorder
+
+	beName := cm.ClusterNamer.Backend(nodePort)
+	checkHealth := func(state string, wantEvent bool) {
+		t.Helper()
+		cm.fakeBackends.SetHealthState(beName, state)
+		lbc.syncBackendHealth()
+		currIng, err := ingClient.Get(ing.Name, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		want := `"healthy":1,"unhealthy":0}`
+		if state == "UNHEALTHY" {
+			want = `"healthy":0,"unhealthy":1}`
+		}
+		if got := currIng.Annotations[annotations.BackendHealthKey]; !strings.Contains(got, fmt.Sprintf("%q:{%v", beName, want)) {
+			t.Errorf("Expected health %v of %v in annotation, got %q", want, beName, got)
+		}
+		wantStatus := api_v1.ConditionTrue
+		if state == "UNHEALTHY" {
+			wantStatus = api_v1.ConditionFalse
+		}
+		if c := findCondition(getConditions(currIng.Annotations), BackendsHealthyCondition); c == nil || c.Status != wantStatus {
+			t.Errorf("Expected %v condition %v, got %+v", BackendsHealthyCondition, wantStatus, c)
+		}
+		// Update the store like the informer would.
+		lbc.ingLister.Store.Update(currIng)
+		select {
+		case e := <-recorder.Events:
+			if !wantEvent {
+				t.Errorf("Unexpected event %q", e)
+			} else if !strings.Contains(e, "BackendUnhealthy") {
+				t.Errorf("Expected BackendUnhealthy event, got %q", e)
+			}
+		default:
+			if wantEvent {
+				t.Errorf("Expected BackendUnhealthy event for state %v", state)
+			}
+		}
+	}
+	checkHealth("HEALTHY", false)
+	checkHealth("UNHEALTHY", true)
+	// The event is only raised on the transition.
+	checkHealth("UNHEALTHY", false)
+	checkHealth("HEALTHY", false)
+	checkHealth("UNHEALTHY", true)
+
+	// The Ingress is not written while the health doesn't change.
+	client := lbc.client.(*fake.Clientset)
+	client.ClearActions()
+	lbc.syncBackendHealth()
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "ingresses" && action.GetVerb() == "update" {
+			t.Errorf("Unexpected update of the Ingress without health change")
+		}
+	}
+}
+
+// findCondition returns the condition of the given type, nil if none.
+func findCondition(conditions []IngressCondition, condType string) *IngressCondition {
+	for i := range conditions {
+		if conditions[i].Type == condType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func TestLbConditions(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/foo1": "foo1svc",
+		},
+	}
+	ing := newIngress(inputMap)
+	addIngress(lbc, ing, newPortManager(1, 65536))
+	ingClient := lbc.client.Extensions().Ingresses(ing.Namespace)
+	ingStoreKey := getKey(ing, t)
+	getIngConditions := func() []IngressCondition {
+		t.Helper()
+		if err := lbc.sync(ingStoreKey); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		currIng, err := ingClient.Get(ing.Name, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		lbc.ingLister.Store.Update(currIng)
+		return getConditions(currIng.Annotations)
+	}
+
+	conditions := getIngConditions()
+	for _, condType := range []string{SyncedCondition, FrontendProgrammedCondition} {
+		if c := findCondition(conditions, condType); c == nil || c.Status != api_v1.ConditionTrue {
+			t.Errorf("Expected %v condition True, got %+v", condType, c)
+		}
+	}
+	if c := findCondition(conditions, CertificateReadyCondition); c != nil {
+		t.Errorf("Unexpected %v condition on Ingress without TLS: %+v", CertificateReadyCondition, c)
+	}
+
+	// The transition time is kept while the status doesn't change.
+	synced := findCondition(conditions, SyncedCondition)
+	synced.LastTransitionTime = meta_v1.NewTime(synced.LastTransitionTime.Add(-time.Hour))
+	currIng, err := ingClient.Get(ing.Name, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := json.Marshal(conditions)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	currIng.Annotations[annotations.ConditionsKey] = string(b)
+	if _, err := ingClient.Update(currIng); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if c := findCondition(getIngConditions(), SyncedCondition); c == nil || !c.LastTransitionTime.Equal(&synced.LastTransitionTime) {
+		t.Errorf("Expected %v condition with transition time %v, got %+v", SyncedCondition, synced.LastTransitionTime, c)
+	}
+
+	// The Ingress is not written if its cached conditions are up to date.
+	client := lbc.client.(*fake.Clientset)
+	client.ClearActions()
+	if err := lbc.sync(ingStoreKey); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "ingresses" && action.GetVerb() == "update" {
+			t.Errorf("Unexpected %v of the Ingress on a sync without changes", action.GetVerb())
+		}
+	}
+}
+
+func TestLbSyncMetrics(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/foo1": "foo1svc",
+		},
+	}
+	ing := newIngress(inputMap)
+	addIngress(lbc, ing, newPortManager(1, 65536))
+	syncCount := func() uint64 {
+		metric := &dto.Metric{}
+		if err := ingressSyncDuration.WithLabelValues("success").(prometheus.Histogram).Write(metric); err != nil {
+			t.Fatalf("Failed to write metric: %v", err)
+		}
+		return metric.GetHistogram().GetSampleCount()
+	}
+	before := syncCount()
+	if err := lbc.sync(getKey(ing, t)); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if got := syncCount(); got != before+1 {
+		t.Errorf("Got %v successful syncs in the metrics, want %v", got, before+1)
+	}
+	metric := &dto.Metric{}
+	if err := managedResources.WithLabelValues("load_balancers").Write(metric); err != nil {
+		t.Fatalf("Failed to write metric: %v", err)
+	}
+	if got := metric.GetGauge().GetValue(); got != 1 {
+		t.Errorf("Got %v managed load balancers in the metrics, want 1", got)
+	}
+}
+
+func TestLbPartialSync(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	cm.fullSyncPeriod = time.Hour
+	lbc := newLoadBalancerController(t, cm)
+	nodePort := int64(30080)
+	lbc.svcLister.Indexer.Add(&api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: api.NamespaceNone},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{{Port: 80, NodePort: int32(nodePort)}},
+		},
+	})
+	ing := newIngress(map[string]utils.FakeIngressRuleValueMap{})
+	ing.Spec.Rules = []extensions.IngressRule{{
+		Host: "foo.bar.com",
+		IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+			Paths: []extensions.HTTPIngressPath{{Path: "/foo", Backend: extensions.IngressBackend{ServiceName: "svc", ServicePort: intstr.FromInt(80)}}},
+		}},
+	}}
+	addIngress(lbc, ing, nil)
+	ingStoreKey := getKey(ing, t)
+	if err := lbc.sync(ingStoreKey); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	// A backend service deleted behind the back of the controller isn't
+	// recreated until the next full sync, since the inputs didn't change.
+	beName := cm.ClusterNamer.Backend(nodePort)
+	if err := cm.fakeBackends.DeleteGlobalBackendService(beName); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := lbc.sync(ingStoreKey); err == nil {
+		t.Fatalf("Expected the sync to fail without the backend service")
+	}
+	if _, err := cm.fakeBackends.GetGlobalBackendService(beName); err == nil {
+		t.Errorf("Expected backend service %v to be skipped by the partial sync", beName)
+	}
+
+	// The failed sync syncs all the components next time.
+	if err := lbc.sync(ingStoreKey); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if _, err := cm.fakeBackends.GetGlobalBackendService(beName); err != nil {
+		t.Errorf("Expected backend service %v to be recreated: %v", beName, err)
+	}
+
+	cm.fakeBackends.DeleteGlobalBackendService(beName)
+	cm.lastFullSync = time.Time{}
+	if err := lbc.sync(ingStoreKey); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if _, err := cm.fakeBackends.GetGlobalBackendService(beName); err != nil {
+		t.Errorf("Expected backend service %v to be recreated by the full sync: %v", beName, err)
+	}
+}
+
+func TestNoClusterDefaultBackend(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	cm.defaultBackendNodePort = nil
+	lbc := newLoadBalancerController(t, cm)
+	recorder := record.NewFakeRecorder(10)
+	lbc.recorder = recorder
+	nodePort := int64(30080)
+	lbc.svcLister.Indexer.Add(&api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: api.NamespaceNone},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{{Port: 80, NodePort: int32(nodePort)}},
+		},
+	})
+	withBackend := &extensions.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "with-backend", Namespace: api.NamespaceNone},
+		Spec: extensions.IngressSpec{
+			Backend: &extensions.IngressBackend{ServiceName: "svc", ServicePort: intstr.FromInt(80)},
+		},
+	}
+	withoutBackend := &extensions.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "without-backend", Namespace: api.NamespaceNone},
+	}
+
+	lbs, err := lbc.toRuntimeInfo(extensions.IngressList{Items: []extensions.Ingress{*withBackend, *withoutBackend}})
+	if err != nil {
+		t.Fatalf("lbc.toRuntimeInfo() = _, %v, want nil", err)
+	}
+	if len(lbs) != 1 || lbs[0].Name != getKey(withBackend, t) {
+		t.Fatalf("lbc.toRuntimeInfo() = %v, want only %v", lbs, getKey(withBackend, t))
+	}
+	if lbs[0].DefaultBackend == nil || lbs[0].DefaultBackend.Port != nodePort {
+		t.Errorf("DefaultBackend = %+v, want node port %v", lbs[0].DefaultBackend, nodePort)
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, "DefaultBackend") {
+			t.Errorf("Expected DefaultBackend event, got %q", e)
+		}
+	default:
+		t.Errorf("Expected DefaultBackend event for Ingress without default backend")
+	}
+}
+
 func TestLbFaultyUpdate(t *testing.T) {
 	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
 	lbc := newLoadBalancerController(t, cm)
@@ -330,7 +1196,7 @@ func TestLbFaultyUpdate(t *testing.T) {
 		"foo.example.com": {
 			"/foo1": &compute.BackendService{SelfLink: "foo2svc"},
 		},
-	})
+	}, nil)
 
 	lbc.sync(ingStoreKey)
 	if err := cm.fakeLbs.CheckURLMap(l7, pm.toNodePortSvcNames(inputMap)); err != nil {
@@ -358,6 +1224,203 @@ func TestLbDefaulting(t *testing.T) {
 	}
 }
 
+func TestToURLMapRedirects(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/foo1": "foo1svc",
+		},
+	}
+	ing := newIngress(inputMap)
+	ing.Annotations = map[string]string{
+		annotations.RedirectsKey: `[{"host": "foo.example.com", "path": "/old/*", "prefixRedirect": "/foo1/"}, {"path": "/*", "httpsRedirect": true}]`,
+	}
+	pm := newPortManager(1, 65536)
+	addIngress(lbc, ing, pm)
+	lbc.sync(getKey(ing, t))
+
+	urlMap, routes, err := lbc.Translator.toURLMap(ing)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if be, ok := urlMap["foo.example.com"]["/old/*"]; !ok || be != nil {
+		t.Errorf("backend of the redirected path = %v, %v, want none", be, ok)
+	}
+	if urlMap["foo.example.com"]["/foo1"] == nil {
+		t.Errorf("backend of /foo1 missing")
+	}
+	if r := routes.Path("foo.example.com", "/old/*"); r == nil || r.Redirect == nil || r.Redirect.PrefixRedirect != "/foo1/" {
+		t.Errorf("route of /old/* = %+v, want a prefix redirect to /foo1/", r)
+	}
+	if r := routes.Path(loadbalancers.DefaultHost, "/*"); r == nil || r.Redirect == nil || !r.Redirect.HttpsRedirect {
+		t.Errorf("route of the default host = %+v, want an HTTPS redirect", r)
+	}
+
+	// Invalid redirects are ignored.
+	ing.Annotations[annotations.RedirectsKey] = `[{"path": "/old", "prefixRedirect": "/new"}]`
+	urlMap, routes, err = lbc.Translator.toURLMap(ing)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := urlMap["foo.example.com"]["/old/*"]; ok {
+		t.Errorf("invalid redirect added a path")
+	}
+	if r := routes.Path("foo.example.com", "/old/*"); r != nil {
+		t.Errorf("invalid redirect added a route %+v", r)
+	}
+}
+
+func TestToURLMapTrafficSplits(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/app/*": "foo1svc",
+			"/foo2":  "foo2svc",
+		},
+	}
+	ing := newIngress(inputMap)
+	ing.Annotations = map[string]string{
+		annotations.TrafficSplitKey: `[{"host": "foo.example.com", "path": "/app/*", "backends": [{"serviceName": "foo1svc", "servicePort": 80, "weight": 90}, {"serviceName": "foo2svc", "servicePort": 80, "weight": 10}]}]`,
+	}
+	pm := newPortManager(1, 65536)
+	addIngress(lbc, ing, pm)
+	lbc.sync(getKey(ing, t))
+
+	urlMap, routes, err := lbc.Translator.toURLMap(ing)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	r := routes.Path("foo.example.com", "/app/*")
+	if r == nil || len(r.WeightedBackends) != 2 {
+		t.Fatalf("route of /app/* = %+v, want 2 weighted backends", r)
+	}
+	for i, want := range []struct {
+		be     *compute.BackendService
+		weight int64
+	}{{urlMap["foo.example.com"]["/app/*"], 90}, {urlMap["foo.example.com"]["/foo2"], 10}} {
+		if got := r.WeightedBackends[i]; got.BackendService != want.be.SelfLink || got.Weight != want.weight {
+			t.Errorf("weighted backend %d = %+v, want %v with weight %v", i, got, want.be.SelfLink, want.weight)
+		}
+	}
+}
+
+func TestToURLMapPathTypes(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/prefix":   "foo1svc",
+			"/exact":    "foo2svc",
+			"/specific": "foo2svc",
+			"/bad/*":    "foo2svc",
+		},
+	}
+	ing := newIngress(inputMap)
+	ing.ResourceVersion = "1"
+	reads := 0
+	lbc.ingPathTypes.getRaw = func(namespace, name string) ([]byte, error) {
+		reads++
+		return []byte(`{"spec": {"rules": [{"host": "foo.example.com", "http": {"paths": [
+			{"path": "/prefix", "pathType": "Prefix"},
+			{"path": "/exact", "pathType": "Exact"},
+			{"path": "/specific", "pathType": "ImplementationSpecific"},
+			{"path": "/bad/*", "pathType": "Prefix"}
+		]}}]}}`), nil
+	}
+	pm := newPortManager(1, 65536)
+	addIngress(lbc, ing, pm)
+	lbc.sync(getKey(ing, t))
+
+	urlMap, _, err := lbc.Translator.toURLMap(ing)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var got []string
+	for path := range urlMap["foo.example.com"] {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	if want := []string{"/exact", "/prefix", "/prefix/*", "/specific"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+	if reads != 1 {
+		t.Errorf("Ingress read %d times, want once for its resource version", reads)
+	}
+}
+
+func TestToURLMapRouteRules(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/foo1": "foo1svc",
+			"/foo2": "foo2svc",
+		},
+	}
+	ing := newIngress(inputMap)
+	ing.Annotations = map[string]string{
+		annotations.RouteRulesKey: `[
+			{"host": "foo.example.com", "priority": 1, "matches": [{"prefixMatch": "/", "headerMatches": [{"headerName": "x-canary", "exactMatch": "true"}]}], "backends": [{"serviceName": "foo2svc", "servicePort": 80}]},
+			{"host": "bar.example.com", "priority": 0, "matches": [{"fullPathMatch": "/old"}], "redirect": {"pathRedirect": "/new"}}
+		]`,
+	}
+	pm := newPortManager(1, 65536)
+	addIngress(lbc, ing, pm)
+	lbc.sync(getKey(ing, t))
+
+	urlMap, routes, err := lbc.Translator.toURLMap(ing)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rules := routes.HostRules("foo.example.com")
+	if len(rules) != 1 || rules[0].Service != urlMap["foo.example.com"]["/foo2"].SelfLink || rules[0].Priority != 1 {
+		t.Errorf("route rules of foo.example.com = %+v, want one to %v", rules, urlMap["foo.example.com"]["/foo2"].SelfLink)
+	}
+	rules = routes.HostRules("bar.example.com")
+	if len(rules) != 1 || rules[0].Route.Redirect == nil || rules[0].Route.Redirect.PathRedirect != "/new" {
+		t.Errorf("route rules of bar.example.com = %+v, want a redirect to /new", rules)
+	}
+	if _, ok := urlMap["bar.example.com"]; !ok {
+		t.Errorf("url map %+v has no bar.example.com host for its route rule", urlMap)
+	}
+}
+
+func TestToURLMapRewrites(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	inputMap := map[string]utils.FakeIngressRuleValueMap{
+		"foo.example.com": {
+			"/api/*": "foo1svc",
+			"/foo2":  "foo2svc",
+		},
+	}
+	ing := newIngress(inputMap)
+	pm := newPortManager(1, 65536)
+	addIngress(lbc, ing, pm)
+	rewrite := &backendconfig.URLRewriteConfig{PathPrefixRewrite: "/", HostRewrite: "api.internal"}
+	lbc.backendConfigGetter = &backendconfig.FakeBackendConfigGetter{Configs: map[string]*backendconfig.BackendConfig{
+		ing.Namespace + "/rewrite": {Spec: backendconfig.BackendConfigSpec{UrlRewrite: rewrite}},
+	}}
+	obj, _, _ := lbc.svcLister.Indexer.Get(&api_v1.Service{ObjectMeta: meta_v1.ObjectMeta{Namespace: ing.Namespace, Name: "foo1svc"}})
+	svc := obj.(*api_v1.Service)
+	svc.Annotations = map[string]string{annotations.BackendConfigKey: `{"default": "rewrite"}`}
+	lbc.sync(getKey(ing, t))
+
+	_, routes, err := lbc.Translator.toURLMap(ing)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := &utils.URLRewrite{PathPrefixRewrite: "/", HostRewrite: "api.internal"}
+	if r := routes.Path("foo.example.com", "/api/*"); r == nil || !reflect.DeepEqual(r.Rewrite, want) {
+		t.Errorf("route of /api/* = %+v, want the rewrite %+v", r, want)
+	}
+	if r := routes.Path("foo.example.com", "/foo2"); r != nil {
+		t.Errorf("route of /foo2 = %+v, want none", r)
+	}
+}
+
 func TestLbNoService(t *testing.T) {
 	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
 	lbc := newLoadBalancerController(t, cm)
@@ -435,7 +1498,12 @@ func TestLbChangeStaticIP(t *testing.T) {
 	// First allocate a static ip, then specify a userip in annotations.
 	// The forwarding rules should contain the user ip.
 	// The static ip should get cleaned up on lb tear down.
-	oldIP := ing.Status.LoadBalancer.Ingress[0].IP
+	// The IP allocated by the fake doesn't depend on the other tests.
+	currIng, err := lbc.client.Extensions().Ingresses(ing.Namespace).Get(ing.Name, meta_v1.GetOptions{})
+	if err != nil || len(currIng.Status.LoadBalancer.Ingress) == 0 {
+		t.Fatalf("Expected the Ingress to get an IP, got %+v, %v", currIng, err)
+	}
+	oldIP := currIng.Status.LoadBalancer.Ingress[0].IP
 	oldRules := cm.fakeLbs.GetForwardingRulesWithIPs([]string{oldIP})
 	if len(oldRules) != 2 || oldRules[0].IPAddress != oldRules[1].IPAddress {
 		t.Fatalf("Expected 2 forwarding rules with the same IP.")
@@ -41,12 +41,16 @@ type fakeClusterManager struct {
 	fakeLbs      *loadbalancers.FakeLoadBalancers
 	fakeBackends *backends.FakeBackendServices
 	fakeIGs      *instances.FakeInstanceGroups
+	// fakeExtendedBackends are the fields and global NEGs of the backend
+	// services managed through the REST API.
+	fakeExtendedBackends *backends.FakeExtendedBackendServices
 }
 
 // NewFakeClusterManager creates a new fake ClusterManager.
 func NewFakeClusterManager(clusterName, firewallName string) *fakeClusterManager {
 	fakeLbs := loadbalancers.NewFakeLoadBalancers(clusterName)
 	fakeBackends := backends.NewFakeBackendServices(func(op int, be *compute.BackendService) error { return nil })
+	fakeExtendedBackends := backends.NewFakeExtendedBackendServices()
 	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
 	fakeHCP := healthchecks.NewFakeHealthCheckProvider()
 	fakeNEG := networkendpointgroup.NewFakeNetworkEndpointGroupCloud("test-subnet", "test-network")
@@ -55,26 +59,33 @@ func NewFakeClusterManager(clusterName, firewallName string) *fakeClusterManager
 	nodePool := instances.NewNodePool(fakeIGs, namer)
 	nodePool.Init(&instances.FakeZoneLister{Zones: []string{"zone-a"}})
 
-	healthChecker := healthchecks.NewHealthChecker(fakeHCP, "/", namer)
+	healthChecker := healthchecks.NewHealthChecker(fakeHCP, fakeHCP, "/", namer, false)
 
 	backendPool := backends.NewBackendPool(
 		fakeBackends,
 		fakeNEG,
+		backends.NewFakeSecurityPolicies(),
+		fakeExtendedBackends,
 		healthChecker, nodePool, namer, []int64{}, false)
+	defaultBackendNodePort := testDefaultBeNodePort
 	l7Pool := loadbalancers.NewLoadBalancerPool(
 		fakeLbs,
+		loadbalancers.NewFakeTargetHttpsProxies(fakeLbs),
+		loadbalancers.NewFakeExtendedUrlMaps(fakeLbs),
 		// TODO: change this
 		backendPool,
-		testDefaultBeNodePort,
+		&defaultBackendNodePort,
 		namer,
+		loadbalancers.SslPolicyDefaults{},
 	)
-	frPool := firewalls.NewFirewallPool(firewalls.NewFakeFirewallsProvider(false, false), namer)
+	frPool := firewalls.NewFirewallPool(firewalls.NewFakeFirewallsProvider(false, false), namer, firewalls.PoolOptions{Manage: true})
 	cm := &ClusterManager{
-		ClusterNamer: namer,
-		instancePool: nodePool,
-		backendPool:  backendPool,
-		l7Pool:       l7Pool,
-		firewallPool: frPool,
+		ClusterNamer:           namer,
+		defaultBackendNodePort: &defaultBackendNodePort,
+		instancePool:           nodePool,
+		backendPool:            backendPool,
+		l7Pool:                 l7Pool,
+		firewallPool:           frPool,
 	}
-	return &fakeClusterManager{cm, fakeLbs, fakeBackends, fakeIGs}
+	return &fakeClusterManager{cm, fakeLbs, fakeBackends, fakeIGs, fakeExtendedBackends}
 }
@@ -0,0 +1,155 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+const ingressControllerSubsystem = "ingress_controller"
+
+// Components of the sync error metrics.
+const (
+	componentInstanceGroups = "instance_groups"
+	componentBackends       = "backends"
+	componentInstances      = "instances"
+	componentLoadBalancers  = "load_balancers"
+	componentFirewalls      = "firewalls"
+	componentGC             = "gc"
+)
+
+var (
+	// ingressSyncDuration observes how long Ingress syncs take, by result.
+	ingressSyncDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: ingressControllerSubsystem,
+			Name:      "sync_duration_seconds",
+			Help:      "Time taken to sync an Ingress, including the garbage collection of unused resources.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+		[]string{"result"},
+	)
+	// syncErrors counts the failed syncs of the GCE resources, by component.
+	syncErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: ingressControllerSubsystem,
+			Name:      "sync_errors_total",
+			Help:      "Number of failed syncs of GCE resources, by component.",
+		},
+		[]string{"component"},
+	)
+	// managedResources reports the number of GCE resources managed by the
+	// controller, as of the last successful sync.
+	managedResources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: ingressControllerSubsystem,
+			Name:      "managed_resources",
+			Help:      "Number of GCE resources managed by the controller, by type.",
+		},
+		[]string{"type"},
+	)
+
+	// workqueueDepth, workqueueAdds, workqueueLatency, workqueueWorkDuration
+	// and workqueueRetries are the metrics of the work queues of the
+	// controller, by queue.
+	workqueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: "workqueue",
+			Name:      "depth",
+			Help:      "Current depth of the work queue.",
+		},
+		[]string{"queue"},
+	)
+	workqueueAdds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "workqueue",
+			Name:      "adds_total",
+			Help:      "Number of items added to the work queue.",
+		},
+		[]string{"queue"},
+	)
+	workqueueLatency = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Subsystem: "workqueue",
+			Name:      "queue_latency_microseconds",
+			Help:      "Time items stay in the work queue before being processed.",
+		},
+		[]string{"queue"},
+	)
+	workqueueWorkDuration = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Subsystem: "workqueue",
+			Name:      "work_duration_microseconds",
+			Help:      "Time taken to process an item of the work queue.",
+		},
+		[]string{"queue"},
+	)
+	workqueueRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "workqueue",
+			Name:      "retries_total",
+			Help:      "Number of items requeued after a failure.",
+		},
+		[]string{"queue"},
+	)
+
+	registerMetrics sync.Once
+)
+
+// RegisterMetrics registers the metrics of the controller, and of its work
+// queues. It must be called before the controller is created.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(ingressSyncDuration)
+		prometheus.MustRegister(syncErrors)
+		prometheus.MustRegister(managedResources)
+		prometheus.MustRegister(workqueueDepth)
+		prometheus.MustRegister(workqueueAdds)
+		prometheus.MustRegister(workqueueLatency)
+		prometheus.MustRegister(workqueueWorkDuration)
+		prometheus.MustRegister(workqueueRetries)
+		workqueue.SetProvider(workqueueMetricsProvider{})
+	})
+}
+
+// workqueueMetricsProvider provides the prometheus metrics of the named work
+// queues.
+type workqueueMetricsProvider struct{}
+
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return workqueueDepth.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return workqueueAdds.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.SummaryMetric {
+	return workqueueLatency.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.SummaryMetric {
+	return workqueueWorkDuration.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return workqueueRetries.WithLabelValues(name)
+}
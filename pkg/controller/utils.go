@@ -19,12 +19,13 @@ package controller
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/golang/glog"
-
 	compute "google.golang.org/api/compute/v1"
 
 	api_v1 "k8s.io/api/core/v1"
@@ -32,32 +33,45 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 	listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
 	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/ingressclass"
 	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/tls"
 	"k8s.io/ingress-gce/pkg/utils"
 )
 
-// isGCEIngress returns true if the given Ingress either doesn't specify the
-// ingress.class annotation, or it's set to "gce".
-func isGCEIngress(ing *extensions.Ingress) bool {
-	class := annotations.IngAnnotations(ing.ObjectMeta.Annotations).IngressClass()
+// isGCEIngress returns true if the given Ingress is of the "gce" class, or of
+// none: it names no IngressClass, and the cluster has no default one.
+func (s *StoreToIngressLister) isGCEIngress(ing *extensions.Ingress) bool {
+	class := s.classes.Class(ing)
 	return class == "" || class == annotations.GceIngressClass
 }
 
-// isGCEMultiClusterIngress returns true if the given Ingress has
-// ingress.class annotation set to "gce-multi-cluster".
-func isGCEMultiClusterIngress(ing *extensions.Ingress) bool {
-	class := annotations.IngAnnotations(ing.ObjectMeta.Annotations).IngressClass()
-	return class == annotations.GceMultiIngressClass
+// ingressKey returns the namespace/name key of the given Ingress, which
+// identifies it in the logs.
+func ingressKey(ing *extensions.Ingress) string {
+	return fmt.Sprintf("%v/%v", ing.Namespace, ing.Name)
+}
+
+// isGCEMultiClusterIngress returns true if the given Ingress is of the
+// "gce-multi-cluster" class.
+func (s *StoreToIngressLister) isGCEMultiClusterIngress(ing *extensions.Ingress) bool {
+	return s.classes.Class(ing) == annotations.GceMultiIngressClass
 }
 
 // errorNodePortNotFound is an implementation of error.
@@ -80,26 +94,43 @@ func (e errorSvcAppProtosParsing) Error() string {
 	return fmt.Sprintf("could not parse %v annotation on Service %v/%v, err: %v", annotations.ServiceApplicationProtocolKey, e.svc.Namespace, e.svc.Name, e.origErr)
 }
 
-// taskQueue manages a work queue through an independent worker that
-// invokes the given sync function for every work item inserted.
+// taskQueue manages a work queue through independent workers that invoke
+// the given sync function for every work item inserted. The work queue
+// never hands the same item to several workers at once.
 type taskQueue struct {
-	// queue is the work queue the worker polls
+	// queue is the work queue the workers poll
 	queue workqueue.RateLimitingInterface
 	// sync is called for each item in the queue
 	sync func(string) error
-	// workerDone is closed when the worker exits
-	workerDone chan struct{}
+	// workers is the number of workers syncing items concurrently
+	workers int
+	// workersDone is done when all the workers exit
+	workersDone sync.WaitGroup
+	// progressLock protects syncing and lastProgress.
+	progressLock sync.Mutex
+	// syncing are the start times of the syncs in progress, by key.
+	syncing map[string]time.Time
+	// lastProgress is the last time a worker picked or finished an item.
+	lastProgress time.Time
 }
 
+// run starts the workers and blocks until they exit.
 func (t *taskQueue) run(period time.Duration, stopCh <-chan struct{}) {
-	wait.Until(t.worker, period, stopCh)
+	for i := 0; i < t.workers; i++ {
+		t.workersDone.Add(1)
+		go func() {
+			defer t.workersDone.Done()
+			wait.Until(t.worker, period, stopCh)
+		}()
+	}
+	t.workersDone.Wait()
 }
 
 // enqueue enqueues ns/name of the given api object in the task queue.
 func (t *taskQueue) enqueue(obj interface{}) {
 	key, err := keyFunc(obj)
 	if err != nil {
-		glog.Infof("Couldn't get key for object %+v: %v", obj, err)
+		logging.Infof("Couldn't get key for object %+v: %v", obj, err)
 		return
 	}
 	t.queue.Add(key)
@@ -110,33 +141,115 @@ func (t *taskQueue) worker() {
 	for {
 		key, quit := t.queue.Get()
 		if quit {
-			close(t.workerDone)
 			return
 		}
-		glog.V(3).Infof("Syncing %v", key)
+		logging.V(3).Infof("Syncing %v", key)
+		t.startSync(key.(string))
 		if err := t.sync(key.(string)); err != nil {
-			glog.Errorf("Requeuing %v, err %v", key, err)
+			logging.Errorf("Requeuing %v, err %v", key, err)
 			t.queue.AddRateLimited(key)
 		} else {
 			t.queue.Forget(key)
 		}
+		t.endSync(key.(string))
 		t.queue.Done(key)
 	}
 }
 
-// shutdown shuts down the work queue and waits for the worker to ACK
+// startSync records the start of the sync of the given key.
+func (t *taskQueue) startSync(key string) {
+	t.progressLock.Lock()
+	defer t.progressLock.Unlock()
+	t.lastProgress = time.Now()
+	t.syncing[key] = t.lastProgress
+}
+
+// endSync records the end of the sync of the given key.
+func (t *taskQueue) endSync(key string) {
+	t.progressLock.Lock()
+	defer t.progressLock.Unlock()
+	t.lastProgress = time.Now()
+	delete(t.syncing, key)
+}
+
+// checkProgress returns an error if the queue made no progress for the given
+// timeout as of now: a sync has been running longer, eg: deadlocked, or the
+// queue has items which no worker picked.
+func (t *taskQueue) checkProgress(now time.Time, timeout time.Duration) error {
+	t.progressLock.Lock()
+	defer t.progressLock.Unlock()
+	for key, start := range t.syncing {
+		if d := now.Sub(start); d > timeout {
+			return fmt.Errorf("the sync of %v has been running for %v", key, d)
+		}
+	}
+	if len(t.syncing) == 0 && t.queue.Len() > 0 {
+		if d := now.Sub(t.lastProgress); d > timeout {
+			return fmt.Errorf("%d items have been waiting for a worker for %v", t.queue.Len(), d)
+		}
+	}
+	return nil
+}
+
+// shutdown shuts down the work queue and waits for the workers to ACK
 func (t *taskQueue) shutdown() {
 	t.queue.ShutDown()
-	<-t.workerDone
+	t.workersDone.Wait()
 }
 
 // NewTaskQueue creates a new task queue with the given sync function.
-// The sync function is called for every element inserted into the queue.
-func NewTaskQueue(syncFn func(string) error) *taskQueue {
+// The sync function is called for every element inserted into the queue,
+// by the given number of workers. The name labels the metrics of the queue.
+func NewTaskQueue(syncFn func(string) error, name string, workers int) *taskQueue {
+	if workers < 1 {
+		workers = 1
+	}
 	return &taskQueue{
-		queue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		sync:       syncFn,
-		workerDone: make(chan struct{}),
+		queue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+		sync:         syncFn,
+		workers:      workers,
+		syncing:      map[string]time.Time{},
+		lastProgress: time.Now(),
+	}
+}
+
+// keyLocks are mutexes by key, eg: to serialize the syncs of the same load
+// balancer. A mutex only exists while it's held or awaited.
+type keyLocks struct {
+	lock  sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the mutex of a key, with the number of its holders and waiters.
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks the mutex of the given key.
+func (k *keyLocks) Lock(key string) {
+	k.lock.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyLock{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.lock.Unlock()
+	l.Lock()
+}
+
+// Unlock unlocks the mutex of the given key.
+func (k *keyLocks) Unlock(key string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	l := k.locks[key]
+	l.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(k.locks, key)
 	}
 }
 
@@ -150,6 +263,15 @@ func compareLinks(l1, l2 string) bool {
 // TODO: Move this to cache/listers post 1.1.
 type StoreToIngressLister struct {
 	cache.Store
+
+	// grantsLock protects lbGroupGrants.
+	grantsLock sync.RWMutex
+	// lbGroupGrants are the other namespaces whose Ingresses may join each
+	// LB group, by namespace/group.
+	lbGroupGrants map[string]sets.String
+	// classes resolves the class of the Ingresses through the
+	// IngressClasses, only through the annotation if nil.
+	classes *ingressclass.Classes
 }
 
 // StoreToNodeLister makes a Store that lists Node.
@@ -198,18 +320,229 @@ func ListAll(store cache.Store, selector labels.Selector, appendFn cache.AppendF
 func (s *StoreToIngressLister) ListAll() (ing extensions.IngressList, err error) {
 	for _, m := range s.Store.List() {
 		newIng := m.(*extensions.Ingress)
-		if isGCEIngress(newIng) || isGCEMultiClusterIngress(newIng) {
+		if s.isGCEIngress(newIng) || s.isGCEMultiClusterIngress(newIng) {
 			ing.Items = append(ing.Items, *newIng)
 		}
 	}
 	return ing, nil
 }
 
+// ListActiveKeys lists the load balancer keys of all Ingress' in the store
+// which are not being deleted, once per LB group.
+func (s *StoreToIngressLister) ListActiveKeys() []string {
+	keys := sets.NewString()
+	for _, m := range s.Store.List() {
+		ing := m.(*extensions.Ingress)
+		if ing.DeletionTimestamp != nil {
+			continue
+		}
+		if key, err := s.lbKey(ing); err == nil {
+			keys.Insert(key)
+		}
+	}
+	return keys.List()
+}
+
+// ListLBGroup lists the GCE Ingress' in the store of the given LB group, by
+// precedence.
+func (s *StoreToIngressLister) ListLBGroup(group string) []extensions.Ingress {
+	var ings []extensions.Ingress
+	for _, m := range s.Store.List() {
+		ing := m.(*extensions.Ingress)
+		if s.isGCEIngress(ing) && s.lbGroup(ing) == group {
+			ings = append(ings, *ing)
+		}
+	}
+	sortByPrecedence(ings)
+	return ings
+}
+
+// withoutDeletedIngresses returns the Ingresses of the given list which are
+// not being deleted.
+func withoutDeletedIngresses(ings extensions.IngressList) extensions.IngressList {
+	active := extensions.IngressList{}
+	for _, ing := range ings.Items {
+		if ing.DeletionTimestamp == nil {
+			active.Items = append(active.Items, ing)
+		}
+	}
+	return active
+}
+
+// hasFinalizer returns true if the given Ingress has the finalizer of the
+// controller.
+func hasFinalizer(ing *extensions.Ingress) bool {
+	for _, f := range ing.Finalizers {
+		if f == annotations.IngressFinalizerKey {
+			return true
+		}
+	}
+	return false
+}
+
+// namingScheme returns the naming scheme of the resources of the given
+// Ingress: the persisted one, or the V1 scheme if the controller may already
+// have created resources for the Ingress, ie: it has a finalizer or an IP.
+func namingScheme(ing *extensions.Ingress) utils.NamingScheme {
+	switch scheme := utils.NamingScheme(annotations.IngAnnotations(ing.Annotations).NamingScheme()); scheme {
+	case utils.NamingSchemeV1, utils.NamingSchemeV2:
+		return scheme
+	case "":
+		if hasFinalizer(ing) || len(ing.Status.LoadBalancer.Ingress) > 0 {
+			return utils.NamingSchemeV1
+		}
+		return utils.NamingSchemeV2
+	default:
+		logging.Warningf("Ignoring invalid naming scheme %q of Ingress %v/%v", scheme, ing.Namespace, ing.Name)
+		return utils.NamingSchemeV1
+	}
+}
+
+// ingressHosts returns the hosts of the rules of the given Ingress, once
+// each.
+func ingressHosts(ing *extensions.Ingress) []string {
+	var hosts []string
+	seen := sets.NewString()
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host != "" && !seen.Has(rule.Host) {
+			seen.Insert(rule.Host)
+			hosts = append(hosts, rule.Host)
+		}
+	}
+	return hosts
+}
+
+// managedCertificateDomains returns the domains of the managed certificate of
+// the given Ingresses, sharing a load balancer: the hosts of their rules,
+// sorted. Wildcard hosts are skipped, managed certificates don't support them.
+func managedCertificateDomains(ings []extensions.Ingress) []string {
+	domains := sets.NewString()
+	for i := range ings {
+		for _, host := range ingressHosts(&ings[i]) {
+			if !strings.Contains(host, "*") {
+				domains.Insert(host)
+			}
+		}
+	}
+	return domains.List()
+}
+
+// lbGroupKeyPrefix prefixes the namespace/group of an LB group in the key of
+// its load balancer, which never collides with the key, namespace/name, of an
+// Ingress.
+const lbGroupKeyPrefix = "/"
+
+// lbGroupErrors returns why the given value of the LB group annotation is
+// invalid: group, or namespace/group for a group of another namespace.
+func lbGroupErrors(value string) []string {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) == 2 {
+		return append(validation.IsDNS1123Label(parts[0]), validation.IsDNS1123Label(parts[1])...)
+	}
+	return validation.IsDNS1123Label(value)
+}
+
+// lbGroupRef returns the namespace and name of the LB group the given Ingress
+// refers to, empty if it has none or an invalid one. A group without
+// namespace is in the namespace of the Ingress.
+func lbGroupRef(ing *extensions.Ingress) (namespace, group string) {
+	value := annotations.IngAnnotations(ing.Annotations).LBGroup()
+	if value == "" || len(lbGroupErrors(value)) > 0 {
+		return "", ""
+	}
+	if parts := strings.SplitN(value, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return ing.Namespace, value
+}
+
+// lbGroup returns the LB group, namespace/group, of the given Ingress. Empty
+// if it has none, an invalid one, or one of another namespace no
+// ReferenceGrant lets it join.
+func (s *StoreToIngressLister) lbGroup(ing *extensions.Ingress) string {
+	namespace, group := lbGroupRef(ing)
+	if group == "" {
+		return ""
+	}
+	ref := namespace + "/" + group
+	if namespace != ing.Namespace && !s.lbGroupGranted(ref, ing.Namespace) {
+		return ""
+	}
+	return ref
+}
+
+// lbGroupGranted returns true if the Ingresses of the given namespace may
+// join the given LB group, namespace/group, of another namespace.
+func (s *StoreToIngressLister) lbGroupGranted(ref, namespace string) bool {
+	s.grantsLock.RLock()
+	defer s.grantsLock.RUnlock()
+	return s.lbGroupGrants[ref].Has(namespace)
+}
+
+// setLBGroupGrants replaces the namespaces whose Ingresses may join the LB
+// groups of other namespaces.
+func (s *StoreToIngressLister) setLBGroupGrants(grants map[string]sets.String) {
+	s.grantsLock.Lock()
+	defer s.grantsLock.Unlock()
+	s.lbGroupGrants = grants
+}
+
+// lbKey returns the key of the load balancer of the given Ingress: the key of
+// its LB group, or its own key if it has none.
+func (s *StoreToIngressLister) lbKey(ing *extensions.Ingress) (string, error) {
+	if group := s.lbGroup(ing); group != "" {
+		return lbGroupKeyPrefix + group, nil
+	}
+	return keyFunc(ing)
+}
+
+// isLBGroupKey returns true if the given load balancer key is the one of an
+// LB group.
+func isLBGroupKey(key string) bool {
+	return strings.HasPrefix(key, lbGroupKeyPrefix)
+}
+
+// sortByPrecedence sorts the given Ingresses of an LB group by precedence:
+// the oldest first, then by key. The first one sets the frontend of the load
+// balancer and wins the hosts and paths routed by several of them.
+func sortByPrecedence(ings []extensions.Ingress) {
+	sort.SliceStable(ings, func(i, j int) bool {
+		a, b := ings[i].CreationTimestamp, ings[j].CreationTimestamp
+		if !a.Equal(&b) {
+			return a.Before(&b)
+		}
+		return ingressKey(&ings[i]) < ingressKey(&ings[j])
+	})
+}
+
+// groupByLoadBalancer groups the given Ingresses by the key of their load
+// balancer, the Ingresses of each LB group by precedence. The keys are in the
+// order of the first Ingress of each load balancer.
+func (s *StoreToIngressLister) groupByLoadBalancer(ings []extensions.Ingress) ([]string, map[string][]extensions.Ingress) {
+	var keys []string
+	groups := map[string][]extensions.Ingress{}
+	for _, ing := range ings {
+		key, err := s.lbKey(&ing)
+		if err != nil {
+			logging.ForIngress(ingressKey(&ing)).Warningf("Cannot get key: %v", err)
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], ing)
+	}
+	for _, key := range keys {
+		sortByPrecedence(groups[key])
+	}
+	return keys, groups
+}
+
 // ListGCEIngresses lists all GCE Ingress' in the store.
 func (s *StoreToIngressLister) ListGCEIngresses() (ing extensions.IngressList, err error) {
 	for _, m := range s.Store.List() {
 		newIng := m.(*extensions.Ingress)
-		if isGCEIngress(newIng) {
+		if s.isGCEIngress(newIng) {
 			ing.Items = append(ing.Items, *newIng)
 		}
 	}
@@ -245,6 +578,12 @@ IngressLoop:
 				}
 			}
 		}
+		for _, be := range annotations.IngAnnotations(ing.Annotations).Backends() {
+			if be.ServiceName == svc.Name {
+				ings = append(ings, ing)
+				continue IngressLoop
+			}
+		}
 	}
 	if len(ings) == 0 {
 		err = fmt.Errorf("no ingress for service %v", svc.Name)
@@ -252,6 +591,23 @@ IngressLoop:
 	return
 }
 
+// GetSecretIngresses returns the Ingresses which serve the certificate of the
+// given Secret.
+func (s *StoreToIngressLister) GetSecretIngresses(secret *api_v1.Secret) []extensions.Ingress {
+	var ings []extensions.Ingress
+	for _, m := range s.Store.List() {
+		ing := *m.(*extensions.Ingress)
+		for _, ingTLS := range ing.Spec.TLS {
+			// The secret may be in another namespace than the Ingress.
+			if namespace, name := tls.SecretRef(&ing, ingTLS.SecretName); namespace == secret.Namespace && name == secret.Name {
+				ings = append(ings, ing)
+				break
+			}
+		}
+	}
+	return ings
+}
+
 func (s *StoreToEndpointLister) ListEndpointTargetPorts(namespace, name, targetPort string) []int {
 	// if targetPort is integer, no need to translate to endpoint ports
 	if i, err := strconv.Atoi(targetPort); err == nil {
@@ -268,11 +624,11 @@ func (s *StoreToEndpointLister) ListEndpointTargetPorts(namespace, name, targetP
 	)
 
 	if !exists {
-		glog.Errorf("Endpoint object %v/%v does not exist.", namespace, name)
+		logging.Errorf("Endpoint object %v/%v does not exist.", namespace, name)
 		return []int{}
 	}
 	if err != nil {
-		glog.Errorf("Failed to retrieve endpoint object %v/%v: %v", namespace, name, err)
+		logging.Errorf("Failed to retrieve endpoint object %v/%v: %v", namespace, name, err)
 		return []int{}
 	}
 
@@ -292,39 +648,68 @@ type GCETranslator struct {
 	*LoadBalancerController
 }
 
-// toURLMap converts an ingress to a map of subdomain: url-regex: gce backend.
-func (t *GCETranslator) toURLMap(ing *extensions.Ingress) (utils.GCEURLMap, error) {
+// toURLMap converts an ingress to a map of subdomain: url-regex: gce backend,
+// and the routes of its paths other than to their backends, eg: the paths
+// answered by a redirect.
+func (t *GCETranslator) toURLMap(ing *extensions.Ingress) (utils.GCEURLMap, *utils.URLMapRoutes, error) {
+	return t.toURLMapWithRecorder(ing, t.recorder)
+}
+
+// toURLMapWithRecorder is toURLMap, raising the events on the Ingress with the
+// given recorder.
+func (t *GCETranslator) toURLMapWithRecorder(ing *extensions.Ingress, recorder record.EventRecorder) (utils.GCEURLMap, *utils.URLMapRoutes, error) {
 	hostPathBackend := utils.GCEURLMap{}
+	routes := &utils.URLMapRoutes{}
+	// An Ingress which can't be read has its paths matched as written.
+	pathTypes, err := t.ingPathTypes.get(ing)
+	if err != nil {
+		logging.ForIngress(ingressKey(ing)).Warningf("Ignoring the pathType of the paths: %v", err)
+	}
 	for _, rule := range ing.Spec.Rules {
 		if rule.HTTP == nil {
-			glog.Errorf("Ignoring non http Ingress rule")
+			logging.Errorf("Ignoring non http Ingress rule")
 			continue
 		}
 		pathToBackend := map[string]*compute.BackendService{}
+		pathToRoute := map[string]*utils.PathRoute{}
 		for _, p := range rule.HTTP.Paths {
-			backend, err := t.toGCEBackend(&p.Backend, ing.Namespace)
+			// A path GCE rejects would fail the whole url map, skip it.
+			gcePaths, err := utils.GCEPaths(p.Path, pathTypes[rule.Host][p.Path])
+			if err != nil {
+				recorder.Eventf(ing, api_v1.EventTypeWarning, "Path", "Ignoring path %q of host %q: %v", p.Path, rule.Host, err)
+				continue
+			}
+			backend, port, err := t.toGCEBackendPort(&p.Backend, ing.Namespace)
 			if err != nil {
 				// If a service doesn't have a nodeport we can still forward traffic
 				// to all other services under the assumption that the user will
 				// modify nodeport.
 				if _, ok := err.(errorNodePortNotFound); ok {
-					t.recorder.Eventf(ing, api_v1.EventTypeWarning, "Service", err.(errorNodePortNotFound).Error())
+					recorder.Eventf(ing, api_v1.EventTypeWarning, "Service", err.(errorNodePortNotFound).Error())
 					continue
 				}
 
 				// If a service doesn't have a backend, there's nothing the user
 				// can do to correct this (the admin might've limited quota).
 				// So keep requeuing the l7 till all backends exist.
-				return utils.GCEURLMap{}, err
+				return utils.GCEURLMap{}, nil, err
 			}
-			// The Ingress spec defines empty path as catch-all, so if a user
-			// asks for a single host and multiple empty paths, all traffic is
-			// sent to one of the last backend in the rules list.
-			path := p.Path
-			if path == "" {
-				path = loadbalancers.DefaultPath
+			for _, path := range gcePaths {
+				// The Ingress spec defines empty path as catch-all, so if a
+				// user asks for a single host and multiple empty paths, all
+				// traffic is sent to one of the last backend in the rules
+				// list.
+				if path == "" {
+					path = loadbalancers.DefaultPath
+				}
+				pathToBackend[path] = backend
+				if config := port.BackendConfig; config != nil && config.Spec.UrlRewrite != nil {
+					pathToRoute[path] = &utils.PathRoute{Rewrite: &utils.URLRewrite{
+						PathPrefixRewrite: config.Spec.UrlRewrite.PathPrefixRewrite,
+						HostRewrite:       config.Spec.UrlRewrite.HostRewrite,
+					}}
+				}
 			}
-			pathToBackend[path] = backend
 		}
 		// If multiple hostless rule sets are specified, last one wins
 		host := rule.Host
@@ -332,6 +717,95 @@ func (t *GCETranslator) toURLMap(ing *extensions.Ingress) (utils.GCEURLMap, erro
 			host = loadbalancers.DefaultHost
 		}
 		hostPathBackend[host] = pathToBackend
+		if routes.Paths != nil {
+			delete(routes.Paths, host)
+		}
+		for path, route := range pathToRoute {
+			routes.PutPath(host, path, route)
+		}
+	}
+	redirects, err := annotations.IngAnnotations(ing.Annotations).Redirects()
+	if err != nil {
+		recorder.Eventf(ing, api_v1.EventTypeWarning, "Redirect", "Ignoring the redirects: %v", err)
+	}
+	for _, r := range redirects {
+		host := r.Host
+		if host == "" {
+			host = loadbalancers.DefaultHost
+		}
+		if hostPathBackend[host] == nil {
+			hostPathBackend[host] = map[string]*compute.BackendService{}
+		}
+		if _, ok := hostPathBackend[host][r.Path]; !ok {
+			hostPathBackend[host][r.Path] = nil
+		}
+		redirect := r.URLRedirect
+		routes.PutPath(host, r.Path, &utils.PathRoute{Redirect: &redirect})
+	}
+	splits, err := annotations.IngAnnotations(ing.Annotations).TrafficSplits()
+	if err != nil {
+		recorder.Eventf(ing, api_v1.EventTypeWarning, "TrafficSplit", "Ignoring the traffic splits: %v", err)
+	}
+SplitLoop:
+	for _, s := range splits {
+		host := s.Host
+		if host == "" {
+			host = loadbalancers.DefaultHost
+		}
+		if route := routes.Path(host, s.Path); route != nil && route.Redirect != nil {
+			continue
+		}
+		route := &utils.PathRoute{}
+		for _, b := range s.Backends {
+			backend, err := t.toGCEBackend(&b.IngressBackend, ing.Namespace)
+			if err != nil {
+				if _, ok := err.(errorNodePortNotFound); ok {
+					recorder.Eventf(ing, api_v1.EventTypeWarning, "TrafficSplit", "Ignoring the split of %q of host %q: %v", s.Path, s.Host, err)
+					continue SplitLoop
+				}
+				return utils.GCEURLMap{}, nil, err
+			}
+			route.WeightedBackends = append(route.WeightedBackends, utils.WeightedBackend{BackendService: backend.SelfLink, Weight: b.Weight})
+		}
+		if hostPathBackend[host] == nil {
+			hostPathBackend[host] = map[string]*compute.BackendService{}
+		}
+		if _, ok := hostPathBackend[host][s.Path]; !ok {
+			hostPathBackend[host][s.Path] = nil
+		}
+		routes.PutPath(host, s.Path, route)
+	}
+	rules, err := annotations.IngAnnotations(ing.Annotations).RouteRules()
+	if err != nil {
+		recorder.Eventf(ing, api_v1.EventTypeWarning, "RouteRule", "Ignoring the route rules: %v", err)
+	}
+RuleLoop:
+	for i, r := range rules {
+		host := r.Host
+		if host == "" {
+			host = loadbalancers.DefaultHost
+		}
+		rule := &utils.RouteRule{Priority: r.Priority, Matches: r.Matches, HeaderAction: r.HeaderAction}
+		rule.Route.Redirect, rule.Route.Rewrite = r.Redirect, r.Rewrite
+		for _, b := range r.Backends {
+			backend, err := t.toGCEBackend(&b.IngressBackend, ing.Namespace)
+			if err != nil {
+				if _, ok := err.(errorNodePortNotFound); ok {
+					recorder.Eventf(ing, api_v1.EventTypeWarning, "RouteRule", "Ignoring route rule %d: %v", i, err)
+					continue RuleLoop
+				}
+				return utils.GCEURLMap{}, nil, err
+			}
+			if len(r.Backends) == 1 {
+				rule.Service = backend.SelfLink
+			} else {
+				rule.Route.WeightedBackends = append(rule.Route.WeightedBackends, utils.WeightedBackend{BackendService: backend.SelfLink, Weight: b.Weight})
+			}
+		}
+		if hostPathBackend[host] == nil {
+			hostPathBackend[host] = map[string]*compute.BackendService{}
+		}
+		routes.AddRule(host, rule)
 	}
 	var defaultBackend *compute.BackendService
 	if ing.Spec.Backend != nil {
@@ -342,30 +816,99 @@ func (t *GCETranslator) toURLMap(ing *extensions.Ingress) (utils.GCEURLMap, erro
 			if _, ok := err.(errorNodePortNotFound); ok {
 				msg = fmt.Sprintf("couldn't find nodeport for %v/%v", ing.Namespace, ing.Spec.Backend.ServiceName)
 			}
-			t.recorder.Eventf(ing, api_v1.EventTypeWarning, "Service", fmt.Sprintf("failed to identify user specified default backend, %v, using system default", msg))
+			recorder.Eventf(ing, api_v1.EventTypeWarning, "Service", fmt.Sprintf("failed to identify user specified default backend, %v, using system default", msg))
 		} else if defaultBackend != nil {
-			t.recorder.Eventf(ing, api_v1.EventTypeNormal, "Service", fmt.Sprintf("default backend set to %v:%v", ing.Spec.Backend.ServiceName, defaultBackend.Port))
+			recorder.Eventf(ing, api_v1.EventTypeNormal, "Service", fmt.Sprintf("default backend set to %v:%v", ing.Spec.Backend.ServiceName, defaultBackend.Port))
 		}
 	} else {
-		t.recorder.Eventf(ing, api_v1.EventTypeNormal, "Service", "no user specified default backend, using system default")
+		recorder.Eventf(ing, api_v1.EventTypeNormal, "Service", "no user specified default backend, using system default")
 	}
 	hostPathBackend.PutDefaultBackend(defaultBackend)
-	return hostPathBackend, nil
+	return hostPathBackend, routes, nil
+}
+
+// toGroupURLMap merges the url maps of the given Ingresses of a load
+// balancer, by precedence. The hosts and paths of an Ingress routed by a
+// previous one are ignored, with an event. The default backend is the one of
+// the first Ingress. Every member is synced on its own, so the events are only
+// raised on the synced Ingress, rather than on all members on every sync. The
+// route of a path is the one of the Ingress routing it. The route rules of a
+// host are the ones of all members, in the order of the members for equal
+// priorities.
+func (t *GCETranslator) toGroupURLMap(ings []extensions.Ingress, synced *extensions.Ingress) (utils.GCEURLMap, *utils.URLMapRoutes, error) {
+	merged := utils.GCEURLMap{}
+	mergedRoutes := &utils.URLMapRoutes{}
+	for i := range ings {
+		ing := &ings[i]
+		var recorder record.EventRecorder = discardRecorder{}
+		if ing.Namespace == synced.Namespace && ing.Name == synced.Name {
+			recorder = t.recorder
+		}
+		urlMap, routes, err := t.toURLMapWithRecorder(ing, recorder)
+		if err != nil {
+			return utils.GCEURLMap{}, nil, err
+		}
+		defaultBackend := urlMap.GetDefaultBackend()
+		if i == 0 {
+			merged.PutDefaultBackend(defaultBackend)
+		} else if ing.Spec.Backend != nil {
+			recorder.Eventf(ing, api_v1.EventTypeWarning, "LBGroupConflict", "Ignoring the default backend, the load balancer of LB group %v uses the one of Ingress %v", t.ingLister.lbGroup(ing), ingressKey(&ings[0]))
+		}
+		var conflicts []string
+		for host, paths := range urlMap {
+			if merged[host] == nil {
+				merged[host] = map[string]*compute.BackendService{}
+			}
+			for path, backend := range paths {
+				if _, ok := merged[host][path]; ok {
+					conflicts = append(conflicts, host+path)
+					continue
+				}
+				merged[host][path] = backend
+				if route := routes.Path(host, path); route != nil {
+					mergedRoutes.PutPath(host, path, route)
+				}
+			}
+			for _, rule := range routes.HostRules(host) {
+				mergedRoutes.AddRule(host, rule)
+			}
+		}
+		if len(conflicts) > 0 {
+			sort.Strings(conflicts)
+			recorder.Eventf(ing, api_v1.EventTypeWarning, "LBGroupConflict", "Ignoring %v, already routed by another Ingress of LB group %v", strings.Join(conflicts, ", "), t.ingLister.lbGroup(ing))
+		}
+	}
+	return merged, mergedRoutes, nil
+}
+
+// discardRecorder is an event recorder which drops the events.
+type discardRecorder struct{}
+
+func (discardRecorder) Event(runtime.Object, string, string, string)                  {}
+func (discardRecorder) Eventf(runtime.Object, string, string, string, ...interface{}) {}
+func (discardRecorder) PastEventf(runtime.Object, meta_v1.Time, string, string, string, ...interface{}) {
 }
 
 func (t *GCETranslator) toGCEBackend(be *extensions.IngressBackend, ns string) (*compute.BackendService, error) {
 	if be == nil {
 		return nil, nil
 	}
+	backend, _, err := t.toGCEBackendPort(be, ns)
+	return backend, err
+}
+
+// toGCEBackendPort is toGCEBackend, also returning the service port of the
+// given non nil backend.
+func (t *GCETranslator) toGCEBackendPort(be *extensions.IngressBackend, ns string) (*compute.BackendService, backends.ServicePort, error) {
 	port, err := t.getServiceNodePort(*be, ns)
 	if err != nil {
-		return nil, err
+		return nil, port, err
 	}
-	backend, err := t.CloudClusterManager.backendPool.Get(port.Port)
+	backend, err := t.CloudClusterManager.backendPool.GetServicePort(port)
 	if err != nil {
-		return nil, fmt.Errorf("no GCE backend exists for port %v, kube backend %+v", port, be)
+		return nil, port, fmt.Errorf("no GCE backend exists for port %v, kube backend %+v", port, be)
 	}
-	return backend, nil
+	return backend, port, nil
 }
 
 // getServiceNodePort looks in the svc store for a matching service:port,
@@ -385,10 +928,21 @@ func (t *GCETranslator) getServiceNodePort(be extensions.IngressBackend, namespa
 		return backends.ServicePort{}, errorNodePortNotFound{be, err}
 	}
 	svc := obj.(*api_v1.Service)
-	appProtocols, err := annotations.SvcAnnotations(svc.GetAnnotations()).ApplicationProtocols()
+	annotatedProtocols, err := annotations.SvcAnnotations(svc.GetAnnotations()).ApplicationProtocols()
 	if err != nil {
 		return backends.ServicePort{}, errorSvcAppProtosParsing{svc, err}
 	}
+	// The protocols of the annotations take precedence over the appProtocol
+	// field of the ports. A Service which can't be read leaves the field
+	// ignored, rather than dropping the backend.
+	appProtocols, err := t.portAppProtocols.get(svc)
+	if err != nil {
+		logging.Warningf("Ignoring the appProtocol of the ports of Service %v/%v: %v", svc.Namespace, svc.Name, err)
+		appProtocols = map[string]utils.AppProtocol{}
+	}
+	for name, proto := range annotatedProtocols {
+		appProtocols[name] = proto
+	}
 
 	var port *api_v1.ServicePort
 PortLoop:
@@ -417,6 +971,13 @@ PortLoop:
 		proto = utils.AppProtocol(protoStr)
 	}
 
+	// A BackendConfig which can't be retrieved leaves the features of the
+	// backend service untouched, rather than dropping the backend.
+	backendConfig, err := t.getBackendConfig(svc, port)
+	if err != nil {
+		t.recorder.Eventf(svc, api_v1.EventTypeWarning, "BackendConfig", "Ignoring BackendConfig for port %v: %v", port.Port, err)
+	}
+
 	p := backends.ServicePort{
 		Port:          int64(port.NodePort),
 		Protocol:      proto,
@@ -424,10 +985,219 @@ PortLoop:
 		SvcPort:       be.ServicePort,
 		SvcTargetPort: port.TargetPort.String(),
 		NEGEnabled:    t.negEnabled && annotations.SvcAnnotations(svc.GetAnnotations()).NEGEnabled(),
+		BackendConfig: backendConfig,
+	}
+
+	// The Service is only a reference to a serverless NEG, its node port is
+	// not used.
+	serverlessNEG, err := annotations.SvcAnnotations(svc.GetAnnotations()).ServerlessNEG()
+	if err != nil {
+		return backends.ServicePort{}, fmt.Errorf("Service %v/%v: %v", namespace, be.ServiceName, err)
+	}
+	if serverlessNEG != nil {
+		p.Port = 0
+		p.NEGEnabled = false
+		p.ServerlessNEG = serverlessNEG
+	}
+
+	// ExternalName Services point to an origin outside of the cluster, served
+	// through an internet NEG.
+	if svc.Spec.Type == api_v1.ServiceTypeExternalName && serverlessNEG == nil {
+		p.Port = 0
+		p.NEGEnabled = false
+		p.InternetNEG = &backends.InternetNEG{FQDN: svc.Spec.ExternalName, Port: int64(port.Port)}
+	}
+
+	// The endpoints of a hybrid Service are outside of GCP, they are only
+	// reachable through the NEGs the NEG controller programs.
+	hybridNEG, err := annotations.SvcAnnotations(svc.GetAnnotations()).HybridNEG()
+	if err != nil {
+		return backends.ServicePort{}, fmt.Errorf("Service %v/%v: %v", namespace, be.ServiceName, err)
+	}
+	if hybridNEG != nil && serverlessNEG == nil {
+		if !t.negEnabled {
+			return backends.ServicePort{}, fmt.Errorf("Service %v/%v: %v annotation requires NEG support to be enabled", namespace, be.ServiceName, annotations.HybridNEGKey)
+		}
+		p.NEGEnabled = true
+		p.HybridNEG = hybridNEG
 	}
 	return p, nil
 }
 
+// portAppProtocolCache caches the application protocols set by the
+// appProtocol field of the ports of the Services, which are read from the
+// apiserver since the vendored Service API doesn't expose the field. A Service
+// is only read again once its resource version changes.
+type portAppProtocolCache struct {
+	// getRaw returns the JSON of the Service of the given namespace and name.
+	getRaw func(namespace, name string) ([]byte, error)
+	lock   sync.Mutex
+	cache  map[types.NamespacedName]cachedPortAppProtocols
+}
+
+// cachedPortAppProtocols are the application protocols of the ports of a
+// Service at the given resource version.
+type cachedPortAppProtocols struct {
+	resourceVersion string
+	protocols       map[string]utils.AppProtocol
+}
+
+// newPortAppProtocolCache returns a cache reading the Services through the
+// generic REST client of the given client.
+func newPortAppProtocolCache(client kubernetes.Interface) *portAppProtocolCache {
+	return &portAppProtocolCache{
+		getRaw: func(namespace, name string) ([]byte, error) {
+			restClient := client.Discovery().RESTClient()
+			if restClient == nil {
+				return nil, fmt.Errorf("no REST client")
+			}
+			return restClient.Get().AbsPath("/api/v1/namespaces", namespace, "services", name).DoRaw()
+		},
+		cache: map[types.NamespacedName]cachedPortAppProtocols{},
+	}
+}
+
+// get returns a copy of the application protocols of the ports of the given
+// Service, keyed by port name.
+func (c *portAppProtocolCache) get(svc *api_v1.Service) (map[string]utils.AppProtocol, error) {
+	key := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	c.lock.Lock()
+	cached, ok := c.cache[key]
+	c.lock.Unlock()
+	if !ok || cached.resourceVersion != svc.ResourceVersion {
+		data, err := c.getRaw(svc.Namespace, svc.Name)
+		if err != nil {
+			return nil, err
+		}
+		protocols, err := annotations.PortApplicationProtocols(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode Service: %v", err)
+		}
+		cached = cachedPortAppProtocols{resourceVersion: svc.ResourceVersion, protocols: protocols}
+		c.lock.Lock()
+		c.cache[key] = cached
+		c.lock.Unlock()
+	}
+	protocols := map[string]utils.AppProtocol{}
+	for name, proto := range cached.protocols {
+		protocols[name] = proto
+	}
+	return protocols, nil
+}
+
+// forget drops the cached protocols of the given deleted Service.
+func (c *portAppProtocolCache) forget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	svc, ok := obj.(*api_v1.Service)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.cache, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name})
+}
+
+// ingressPathTypeCache caches the pathTypes of the paths of the Ingresses,
+// which are read from the apiserver since the vendored Ingress API doesn't
+// expose them. An Ingress is only read again once its resource version
+// changes.
+type ingressPathTypeCache struct {
+	// getRaw returns the JSON of the Ingress of the given namespace and name.
+	getRaw func(namespace, name string) ([]byte, error)
+	lock   sync.Mutex
+	cache  map[types.NamespacedName]cachedPathTypes
+}
+
+// cachedPathTypes are the pathTypes of the paths of an Ingress at the given
+// resource version, keyed by host, then path.
+type cachedPathTypes struct {
+	resourceVersion string
+	pathTypes       map[string]map[string]string
+}
+
+// newIngressPathTypeCache returns a cache reading the Ingresses through the
+// generic REST client of the given client.
+func newIngressPathTypeCache(client kubernetes.Interface) *ingressPathTypeCache {
+	return &ingressPathTypeCache{
+		getRaw: func(namespace, name string) ([]byte, error) {
+			restClient := client.Discovery().RESTClient()
+			if restClient == nil {
+				return nil, fmt.Errorf("no REST client")
+			}
+			return restClient.Get().AbsPath("/apis/extensions/v1beta1/namespaces", namespace, "ingresses", name).DoRaw()
+		},
+		cache: map[types.NamespacedName]cachedPathTypes{},
+	}
+}
+
+// get returns the pathTypes of the paths of the given Ingress, keyed by host,
+// then path. The result must not be modified.
+func (c *ingressPathTypeCache) get(ing *extensions.Ingress) (map[string]map[string]string, error) {
+	key := types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}
+	c.lock.Lock()
+	cached, ok := c.cache[key]
+	c.lock.Unlock()
+	if ok && cached.resourceVersion == ing.ResourceVersion {
+		return cached.pathTypes, nil
+	}
+	data, err := c.getRaw(ing.Namespace, ing.Name)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := annotations.IngressPaths(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Ingress: %v", err)
+	}
+	pathTypes := map[string]map[string]string{}
+	for _, p := range paths {
+		if pathTypes[p.Host] == nil {
+			pathTypes[p.Host] = map[string]string{}
+		}
+		pathTypes[p.Host][p.Path] = p.PathType
+	}
+	c.lock.Lock()
+	c.cache[key] = cachedPathTypes{resourceVersion: ing.ResourceVersion, pathTypes: pathTypes}
+	c.lock.Unlock()
+	return pathTypes, nil
+}
+
+// forget drops the cached pathTypes of the given deleted Ingress.
+func (c *ingressPathTypeCache) forget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ing, ok := obj.(*extensions.Ingress)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.cache, types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name})
+}
+
+// getBackendConfig returns the BackendConfig the given Service references for
+// the given port, or nil if it references none. Ports are matched by name,
+// then by number, before falling back to the default BackendConfig.
+func (t *GCETranslator) getBackendConfig(svc *api_v1.Service, port *api_v1.ServicePort) (*backendconfig.BackendConfig, error) {
+	configs, err := annotations.SvcAnnotations(svc.GetAnnotations()).BackendConfigs()
+	if err != nil || configs == nil {
+		return nil, err
+	}
+	name, ok := configs.Ports[port.Name]
+	if !ok || port.Name == "" {
+		name, ok = configs.Ports[strconv.Itoa(int(port.Port))]
+	}
+	if !ok {
+		name = configs.Default
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return t.backendConfigGetter.Get(svc.Namespace, name)
+}
+
 // toNodePorts is a helper method over ingressToNodePorts to process a list of ingresses.
 func (t *GCETranslator) toNodePorts(ings *extensions.IngressList) []backends.ServicePort {
 	var knownPorts []backends.ServicePort
@@ -444,25 +1214,33 @@ func (t *GCETranslator) ingressToNodePorts(ing *extensions.Ingress) []backends.S
 	if defaultBackend != nil {
 		port, err := t.getServiceNodePort(*defaultBackend, ing.Namespace)
 		if err != nil {
-			glog.Infof("%v", err)
+			logging.Infof("%v", err)
 		} else {
 			knownPorts = append(knownPorts, port)
 		}
 	}
 	for _, rule := range ing.Spec.Rules {
 		if rule.HTTP == nil {
-			glog.Errorf("ignoring non http Ingress rule")
+			logging.Errorf("ignoring non http Ingress rule")
 			continue
 		}
 		for _, path := range rule.HTTP.Paths {
 			port, err := t.getServiceNodePort(path.Backend, ing.Namespace)
 			if err != nil {
-				glog.Infof("%v", err)
+				logging.Infof("%v", err)
 				continue
 			}
 			knownPorts = append(knownPorts, port)
 		}
 	}
+	for _, be := range annotations.IngAnnotations(ing.Annotations).Backends() {
+		port, err := t.getServiceNodePort(be, ing.Namespace)
+		if err != nil {
+			logging.Infof("%v", err)
+			continue
+		}
+		knownPorts = append(knownPorts, port)
+	}
 	return knownPorts
 }
 
@@ -475,8 +1253,10 @@ func getZone(n *api_v1.Node) string {
 }
 
 // GetZoneForNode returns the zone for a given node by looking up its zone label.
+// NotReady nodes are looked up too, since they may be kept in the instance
+// groups.
 func (t *GCETranslator) GetZoneForNode(name string) (string, error) {
-	nodes, err := listers.NewNodeLister(t.nodeLister.Indexer).ListWithPredicate(getNodeReadyPredicate())
+	nodes, err := listers.NewNodeLister(t.nodeLister.Indexer).List(labels.Everything())
 	if err != nil {
 		return "", err
 	}
@@ -490,6 +1270,24 @@ func (t *GCETranslator) GetZoneForNode(name string) (string, error) {
 	return "", fmt.Errorf("node not found %v", name)
 }
 
+// IsWindowsNode returns true if the node with the given name runs Windows.
+func (t *GCETranslator) IsWindowsNode(name string) bool {
+	obj, exists, err := t.nodeLister.Indexer.GetByKey(name)
+	if err != nil || !exists {
+		return false
+	}
+	return isWindowsNode(obj.(*api_v1.Node))
+}
+
+// isWindowsNode returns true if the given node runs Windows.
+func isWindowsNode(node *api_v1.Node) bool {
+	os, ok := node.Labels[annotations.OSLabelKey]
+	if !ok {
+		os = node.Labels[annotations.BetaOSLabelKey]
+	}
+	return os == annotations.WindowsOS
+}
+
 // ListZones returns a list of zones this Kubernetes cluster spans.
 func (t *GCETranslator) ListZones() ([]string, error) {
 	zones := sets.String{}
@@ -524,7 +1322,12 @@ func (t *GCETranslator) getHTTPProbe(svc api_v1.Service, targetPort intstr.IntOr
 		}
 		logStr := fmt.Sprintf("Pod %v matching service selectors %v (targetport %+v)", pod.Name, l, targetPort)
 		for _, c := range pod.Spec.Containers {
-			if !isSimpleHTTPProbe(c.ReadinessProbe) || string(protocol) != string(c.ReadinessProbe.HTTPGet.Scheme) {
+			// HTTPS probes are also used for HTTP ports, the health check
+			// then checks the port over HTTPS.
+			if !isSimpleHTTPProbe(c.ReadinessProbe) {
+				continue
+			}
+			if scheme := c.ReadinessProbe.HTTPGet.Scheme; scheme != probeScheme(protocol) && scheme != api_v1.URISchemeHTTPS {
 				continue
 			}
 
@@ -544,46 +1347,239 @@ func (t *GCETranslator) getHTTPProbe(svc api_v1.Service, targetPort intstr.IntOr
 						}
 					}
 
-					glog.Infof("%v: found matching targetPort on container %v, but not on readinessProbe (%+v)",
+					logging.Infof("%v: found matching targetPort on container %v, but not on readinessProbe (%+v)",
 						logStr, c.Name, c.ReadinessProbe.Handler.HTTPGet.Port)
 				}
 			}
 		}
-		glog.V(4).Infof("%v: lacks a matching HTTP probe for use in health checks.", logStr)
+		logging.V(4).Infof("%v: lacks a matching HTTP probe for use in health checks.", logStr)
+	}
+	return nil, nil
+}
+
+// getGRPCProbe returns the gRPC readiness probe from the first container that
+// matches targetPort and probes it, from the set of pods matching the
+// selector of the given Service. The probes are read from the apiserver; if
+// they can't be read, the pods are considered to have none.
+func (t *GCETranslator) getGRPCProbe(svc api_v1.Service, targetPort intstr.IntOrString) (*backends.GRPCProbe, error) {
+	pl, err := t.podLister.List(labels.SelectorFromSet(labels.Set(svc.Spec.Selector)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(PodsByCreationTimestamp(pl))
+
+	for _, pod := range pl {
+		if pod.Namespace != svc.Namespace {
+			continue
+		}
+		probes, err := t.grpcProbes.get(pod)
+		if err != nil {
+			logging.Warningf("Ignoring the gRPC readiness probes of the pods of service %v/%v, failed to read pod %v: %v", svc.Namespace, svc.Name, pod.Name, err)
+			return nil, nil
+		}
+		for _, c := range pod.Spec.Containers {
+			probe, ok := probes[c.Name]
+			if !ok {
+				continue
+			}
+			for _, p := range c.Ports {
+				if ((targetPort.Type == intstr.Int && targetPort.IntVal == p.ContainerPort) ||
+					(targetPort.Type == intstr.String && targetPort.StrVal == p.Name)) && probe.port == p.ContainerPort {
+					ret := probe.probe
+					return &ret, nil
+				}
+			}
+		}
 	}
 	return nil, nil
 }
 
+// grpcProbeCache caches the gRPC readiness probes of the containers of the
+// Pods, which are read from the apiserver since the vendored Pod API doesn't
+// expose them. The probes of a Pod are immutable, so a Pod is only read once.
+type grpcProbeCache struct {
+	// getRaw returns the JSON of the Pod of the given namespace and name.
+	getRaw func(namespace, name string) ([]byte, error)
+	lock   sync.Mutex
+	cache  map[types.UID]map[string]containerGRPCProbe
+}
+
+// containerGRPCProbe is the gRPC readiness probe of a container, checking
+// the given container port.
+type containerGRPCProbe struct {
+	port  int32
+	probe backends.GRPCProbe
+}
+
+// newGRPCProbeCache returns a cache reading the Pods through the generic REST
+// client of the given client.
+func newGRPCProbeCache(client kubernetes.Interface) *grpcProbeCache {
+	return &grpcProbeCache{
+		getRaw: func(namespace, name string) ([]byte, error) {
+			restClient := client.Discovery().RESTClient()
+			if restClient == nil {
+				return nil, fmt.Errorf("no REST client")
+			}
+			return restClient.Get().AbsPath("/api/v1/namespaces", namespace, "pods", name).DoRaw()
+		},
+		cache: map[types.UID]map[string]containerGRPCProbe{},
+	}
+}
+
+// get returns the gRPC readiness probes of the containers of the given Pod,
+// keyed by container name. The result must not be modified.
+func (c *grpcProbeCache) get(pod *api_v1.Pod) (map[string]containerGRPCProbe, error) {
+	c.lock.Lock()
+	probes, ok := c.cache[pod.UID]
+	c.lock.Unlock()
+	if ok {
+		return probes, nil
+	}
+	data, err := c.getRaw(pod.Namespace, pod.Name)
+	if err != nil {
+		return nil, err
+	}
+	probes, err = decodeGRPCProbes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Pod: %v", err)
+	}
+	c.lock.Lock()
+	c.cache[pod.UID] = probes
+	c.lock.Unlock()
+	return probes, nil
+}
+
+// forget drops the cached probes of the given deleted Pod.
+func (c *grpcProbeCache) forget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*api_v1.Pod)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.cache, pod.UID)
+}
+
+// decodeGRPCProbes returns the gRPC readiness probes of the containers of the
+// given Pod JSON, keyed by container name.
+func decodeGRPCProbes(data []byte) (map[string]containerGRPCProbe, error) {
+	pod := struct {
+		Spec struct {
+			Containers []struct {
+				Name           string `json:"name"`
+				ReadinessProbe *struct {
+					GRPC *struct {
+						Port    int32   `json:"port"`
+						Service *string `json:"service"`
+					} `json:"grpc"`
+					PeriodSeconds  int64 `json:"periodSeconds"`
+					TimeoutSeconds int64 `json:"timeoutSeconds"`
+				} `json:"readinessProbe"`
+			} `json:"containers"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, err
+	}
+	probes := map[string]containerGRPCProbe{}
+	for _, c := range pod.Spec.Containers {
+		if c.ReadinessProbe == nil || c.ReadinessProbe.GRPC == nil {
+			continue
+		}
+		probe := containerGRPCProbe{
+			port: c.ReadinessProbe.GRPC.Port,
+			probe: backends.GRPCProbe{
+				PeriodSeconds:  c.ReadinessProbe.PeriodSeconds,
+				TimeoutSeconds: c.ReadinessProbe.TimeoutSeconds,
+			},
+		}
+		if s := c.ReadinessProbe.GRPC.Service; s != nil {
+			probe.probe.Service = *s
+		}
+		probes[c.Name] = probe
+	}
+	return probes, nil
+}
+
 // gatherFirewallPorts returns all ports needed for open for ingress.
-// It gathers both node ports (for IG backends) and target ports (for NEG backends).
-func (t *GCETranslator) gatherFirewallPorts(svcPorts []backends.ServicePort, includeDefaultBackend bool) []int64 {
+// It returns the node ports (for IG backends) and the target ports (for NEG
+// backends) separately, since they are opened by different firewall rules.
+func (t *GCETranslator) gatherFirewallPorts(svcPorts []backends.ServicePort, includeDefaultBackend bool) ([]int64, []int64) {
 	// TODO: Manage default backend and its firewall rule in a centralized way.
 	// DefaultBackend is managed in l7 pool, which doesn't understand instances,
 	// which the firewall rule requires.
-	if includeDefaultBackend {
-		svcPorts = append(svcPorts, t.CloudClusterManager.defaultBackendNodePort)
+	if includeDefaultBackend && t.CloudClusterManager.defaultBackendNodePort != nil {
+		svcPorts = append(svcPorts, *t.CloudClusterManager.defaultBackendNodePort)
 	}
-	portMap := map[int64]bool{}
+	nodePortMap := map[int64]bool{}
+	negPortMap := map[int64]bool{}
 	for _, p := range svcPorts {
+		if !p.HasNodePort() || p.HybridNEG != nil {
+			// Serverless, internet and hybrid backends are not served from
+			// the cluster.
+			continue
+		}
 		if p.NEGEnabled {
 			// For NEG backend, need to open firewall to all endpoint target ports
 			// TODO(mixia): refactor firewall syncing into a separate go routine with different trigger.
 			// With NEG, endpoint changes may cause firewall ports to be different if user specifies inconsistent backends.
 			endpointPorts := t.endpointLister.ListEndpointTargetPorts(p.SvcName.Namespace, p.SvcName.Name, p.SvcTargetPort)
 			for _, ep := range endpointPorts {
-				portMap[int64(ep)] = true
+				negPortMap[int64(ep)] = true
 			}
 		} else {
 			// For IG backend, need to open service node port.
-			portMap[p.Port] = true
+			nodePortMap[p.Port] = true
 		}
 	}
 
-	var np []int64
-	for p := range portMap {
+	var np, negPorts []int64
+	for p := range nodePortMap {
 		np = append(np, p)
 	}
-	return np
+	for p := range negPortMap {
+		negPorts = append(negPorts, p)
+	}
+	return np, negPorts
+}
+
+// gatherFirewallSrcRanges returns the source ranges requested by the given
+// Ingresses through the firewall source ranges annotation. Ingresses with an
+// invalid annotation are skipped and an event is raised on them.
+func (t *GCETranslator) gatherFirewallSrcRanges(ings *extensions.IngressList) []string {
+	ranges := sets.NewString()
+	for i := range ings.Items {
+		ing := &ings.Items[i]
+		r, err := annotations.IngAnnotations(ing.ObjectMeta.Annotations).FirewallSrcRanges()
+		if err != nil {
+			t.recorder.Eventf(ing, api_v1.EventTypeWarning, "Firewall", "Ignoring firewall source ranges: %v", err)
+			continue
+		}
+		ranges.Insert(r...)
+	}
+	return ranges.List()
+}
+
+// gatherFirewallNetworks returns the additional networks requested by the
+// given Ingresses through the firewall networks annotation.
+func (t *GCETranslator) gatherFirewallNetworks(ings *extensions.IngressList) []string {
+	networks := sets.NewString()
+	for _, ing := range ings.Items {
+		networks.Insert(annotations.IngAnnotations(ing.ObjectMeta.Annotations).FirewallNetworks()...)
+	}
+	return networks.List()
+}
+
+// probeScheme returns the readiness probe scheme matching the given
+// application protocol. HTTP2 backends are served over TLS.
+func probeScheme(protocol utils.AppProtocol) api_v1.URIScheme {
+	if protocol == utils.ProtocolHTTP2 {
+		return api_v1.URISchemeHTTPS
+	}
+	return api_v1.URIScheme(protocol)
 }
 
 // isSimpleHTTPProbe returns true if the given Probe is:
@@ -597,6 +1593,26 @@ func isSimpleHTTPProbe(probe *api_v1.Probe) bool {
 
 // GetProbe returns a probe that's used for the given nodeport
 func (t *GCETranslator) GetProbe(port backends.ServicePort) (*api_v1.Probe, error) {
+	service, svcPort, err := t.getNodePortService(port)
+	if err != nil {
+		return nil, err
+	}
+	return t.getHTTPProbe(service, svcPort.TargetPort, port.Protocol)
+}
+
+// GetGRPCProbe returns the gRPC readiness probe used for the given nodeport,
+// nil if none.
+func (t *GCETranslator) GetGRPCProbe(port backends.ServicePort) (*backends.GRPCProbe, error) {
+	service, svcPort, err := t.getNodePortService(port)
+	if err != nil {
+		return nil, err
+	}
+	return t.getGRPCProbe(service, svcPort.TargetPort)
+}
+
+// getNodePortService returns the Service and the Service port with the node
+// port of the given port.
+func (t *GCETranslator) getNodePortService(port backends.ServicePort) (api_v1.Service, api_v1.ServicePort, error) {
 	sl := t.svcLister.List()
 
 	// Find the label and target port of the one service with the given nodePort
@@ -618,10 +1634,9 @@ OuterLoop:
 	}
 
 	if !found {
-		return nil, fmt.Errorf("unable to find nodeport %v in any service", port)
+		return service, svcPort, fmt.Errorf("unable to find nodeport %v in any service", port)
 	}
-
-	return t.getHTTPProbe(service, svcPort.TargetPort, port.Protocol)
+	return service, svcPort, nil
 }
 
 // PodsByCreationTimestamp sorts a list of Pods by creation timestamp, using their names as a tie breaker.
@@ -656,10 +1671,19 @@ func setInstanceGroupsAnnotation(existing map[string]string, igs []*compute.Inst
 }
 
 // uniq returns an array of unique service ports from the given array.
+// Ingresses sharing a backend service may reference its Service port
+// differently, eg: by name and by number. The reference sorting first is
+// kept, whatever the order of the Ingresses, so that the shared backend
+// service is always synced with the same description. Serverless ports,
+// which have no node port, are unique by NEG.
 func uniq(nodePorts []backends.ServicePort) []backends.ServicePort {
-	portMap := map[int64]backends.ServicePort{}
+	portMap := map[string]backends.ServicePort{}
 	for _, p := range nodePorts {
-		portMap[p.Port] = p
+		key := servicePortKey(p)
+		if existing, ok := portMap[key]; ok && existing.SvcPort.String() <= p.SvcPort.String() {
+			continue
+		}
+		portMap[key] = p
 	}
 	nodePorts = make([]backends.ServicePort, 0, len(portMap))
 	for _, sp := range portMap {
@@ -667,3 +1691,144 @@ func uniq(nodePorts []backends.ServicePort) []backends.ServicePort {
 	}
 	return nodePorts
 }
+
+// servicePortKey returns the key identifying the cloud resources of the given
+// port: its node port, its serverless NEG, or its ExternalName Service port.
+func servicePortKey(p backends.ServicePort) string {
+	if neg := p.ServerlessNEG; neg != nil {
+		return neg.Region + "/" + neg.Name
+	}
+	if p.InternetNEG != nil {
+		return p.SvcName.String() + ":" + p.SvcPort.String()
+	}
+	return strconv.FormatInt(p.Port, 10)
+}
+
+// servicePortsByKey returns the given ports keyed by servicePortKey.
+func servicePortsByKey(ports []backends.ServicePort) map[string]backends.ServicePort {
+	portMap := make(map[string]backends.ServicePort, len(ports))
+	for _, p := range ports {
+		portMap[servicePortKey(p)] = p
+	}
+	return portMap
+}
+
+const (
+	// SyncedCondition is true if the last sync of the Ingress succeeded.
+	SyncedCondition = "Synced"
+	// FrontendProgrammedCondition is true once the url map of the Ingress is
+	// up to date and its forwarding rules have an IP.
+	FrontendProgrammedCondition = "FrontendProgrammed"
+	// BackendsHealthyCondition is false if one of the backend services of
+	// the Ingress has no healthy endpoint.
+	BackendsHealthyCondition = "BackendsHealthy"
+	// CertificateReadyCondition is true once the certificates of the Ingress
+	// are served by its target HTTPS proxy. Only set on Ingresses with TLS.
+	CertificateReadyCondition = "CertificateReady"
+)
+
+// IngressCondition is a condition of an Ingress, published in the
+// conditions annotation.
+type IngressCondition struct {
+	Type   string                 `json:"type"`
+	Status api_v1.ConditionStatus `json:"status"`
+	Reason string                 `json:"reason,omitempty"`
+	// Message is a human readable description of the condition.
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the last time the status changed.
+	LastTransitionTime meta_v1.Time `json:"lastTransitionTime"`
+}
+
+// newCondition returns a condition with the given type, reason and message,
+// true or false.
+func newCondition(condType string, status bool, reason, message string) IngressCondition {
+	c := IngressCondition{Type: condType, Status: api_v1.ConditionFalse, Reason: reason, Message: message}
+	if status {
+		c.Status = api_v1.ConditionTrue
+	}
+	return c
+}
+
+// getConditions returns the conditions in the given annotations. Invalid
+// conditions are ignored, they are overwritten on the next update.
+func getConditions(anns map[string]string) []IngressCondition {
+	var conditions []IngressCondition
+	value, ok := anns[annotations.ConditionsKey]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), &conditions); err != nil {
+		logging.Warningf("Ignoring invalid Ingress conditions %q: %v", value, err)
+		return nil
+	}
+	return conditions
+}
+
+// onlyStatusChanged returns true if the given Ingresses only differ by their
+// status and the status annotations published by the controller: the load
+// balancer resources, the backend health and the conditions.
+func onlyStatusChanged(old, cur *extensions.Ingress) bool {
+	strip := func(ing *extensions.Ingress) *extensions.Ingress {
+		ing = ing.DeepCopy()
+		ing.ResourceVersion = ""
+		ing.Status = extensions.IngressStatus{}
+		for k := range ing.Annotations {
+			if isStatusAnnotation(k) {
+				delete(ing.Annotations, k)
+			}
+		}
+		if len(ing.Annotations) == 0 {
+			ing.Annotations = nil
+		}
+		return ing
+	}
+	return reflect.DeepEqual(strip(old), strip(cur))
+}
+
+// isStatusAnnotation returns true if the given annotation is published by the
+// controller rather than set by users.
+func isStatusAnnotation(key string) bool {
+	return key == annotations.BackendHealthKey || key == annotations.ConditionsKey || strings.HasPrefix(key, utils.K8sAnnotationPrefix+"/")
+}
+
+// conditionsEqual returns true if both sorted lists hold the same conditions,
+// whatever their transition times.
+func conditionsEqual(a, b []IngressCondition) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		x.LastTransitionTime, y.LastTransitionTime = meta_v1.Time{}, meta_v1.Time{}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// setConditions sets the given conditions in the existing ones, and removes
+// the conditions of the removed types. The transition time of a condition is
+// kept as long as its status doesn't change. Conditions are sorted by type.
+func setConditions(existing []IngressCondition, now meta_v1.Time, updates []IngressCondition, removed ...string) []IngressCondition {
+	byType := map[string]IngressCondition{}
+	for _, c := range existing {
+		byType[c.Type] = c
+	}
+	for _, t := range removed {
+		delete(byType, t)
+	}
+	for _, c := range updates {
+		c.LastTransitionTime = now
+		if prev, ok := byType[c.Type]; ok && prev.Status == c.Status {
+			c.LastTransitionTime = prev.LastTransitionTime
+		}
+		byType[c.Type] = c
+	}
+	conditions := make([]IngressCondition, 0, len(byType))
+	for _, c := range byType {
+		conditions = append(conditions, c)
+	}
+	sort.Slice(conditions, func(i, j int) bool { return conditions[i].Type < conditions[j].Type })
+	return conditions
+}
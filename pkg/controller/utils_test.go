@@ -18,18 +18,26 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	compute "google.golang.org/api/compute/v1"
 
 	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/backendconfig"
 	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/ingressclass"
 	"k8s.io/ingress-gce/pkg/utils"
 )
 
@@ -91,6 +99,134 @@ func TestInstancesAddedToZones(t *testing.T) {
 	}
 }
 
+func TestExcludedNodes(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	selector, err := labels.Parse("pool=gpu")
+	if err != nil {
+		t.Fatalf("Failed to parse selector: %v", err)
+	}
+	lbc.nodeExclusionSelector = selector
+	addNodes(lbc, map[string][]string{"zone-1": {"n1", "n2", "n3"}})
+	for name, nodeLabels := range map[string]map[string]string{
+		"n2": {annotations.ExcludeFromLBLabelKey: ""},
+		"n3": {"pool": "gpu"},
+	} {
+		obj, _, _ := lbc.nodeLister.Indexer.GetByKey(name)
+		node := obj.(*api_v1.Node)
+		for k, v := range nodeLabels {
+			node.Labels[k] = v
+		}
+	}
+
+	nodeNames, err := lbc.getReadyNodeNames()
+	if err != nil {
+		t.Fatalf("Failed to get node names: %v", err)
+	}
+	if !reflect.DeepEqual(nodeNames, []string{"n1"}) {
+		t.Errorf("Expect node names %v, got %v", []string{"n1"}, nodeNames)
+	}
+}
+
+func TestExcludedWindowsNodes(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	addNodes(lbc, map[string][]string{"zone-1": {"n1", "n2", "n3"}})
+	for name, key := range map[string]string{"n2": annotations.OSLabelKey, "n3": annotations.BetaOSLabelKey} {
+		obj, _, _ := lbc.nodeLister.Indexer.GetByKey(name)
+		obj.(*api_v1.Node).Labels[key] = annotations.WindowsOS
+	}
+	if !lbc.Translator.IsWindowsNode("n2") || !lbc.Translator.IsWindowsNode("n3") || lbc.Translator.IsWindowsNode("n1") {
+		t.Errorf("Expect n2 and n3 to be Windows nodes")
+	}
+
+	for _, tc := range []struct {
+		excludeWindowsNodes bool
+		expected            []string
+	}{
+		{false, []string{"n1", "n2", "n3"}},
+		{true, []string{"n1"}},
+	} {
+		lbc.excludeWindowsNodes = tc.excludeWindowsNodes
+		nodeNames, err := lbc.getReadyNodeNames()
+		if err != nil {
+			t.Fatalf("Failed to get node names: %v", err)
+		}
+		if got := sets.NewString(nodeNames...); !got.Equal(sets.NewString(tc.expected...)) {
+			t.Errorf("With excludeWindowsNodes %v, expect node names %v, got %v", tc.excludeWindowsNodes, tc.expected, got.List())
+		}
+	}
+}
+
+func TestUnreadyNodes(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	lbc.unreadyNodeGracePeriod = time.Minute
+	lbc.instanceGroupNodes = sets.NewString("n1", "n2", "n3", "n4")
+	addNodes(lbc, map[string][]string{"zone-1": {"n1", "n2", "n3", "n4", "n5", "n6"}})
+	recently := meta_v1.NewTime(time.Now().Add(-10 * time.Second))
+	for name, update := range map[string]func(*api_v1.Node){
+		// NotReady within the grace period.
+		"n2": func(n *api_v1.Node) {
+			n.Status.Conditions[0] = api_v1.NodeCondition{Type: api_v1.NodeReady, Status: api_v1.ConditionFalse, LastTransitionTime: recently}
+		},
+		// NotReady for long.
+		"n3": func(n *api_v1.Node) { n.Status.Conditions[0].Status = api_v1.ConditionFalse },
+		// Cordoned.
+		"n4": func(n *api_v1.Node) { n.Spec.Unschedulable = true },
+		// Ready within the grace period.
+		"n5": func(n *api_v1.Node) { n.Status.Conditions[0].LastTransitionTime = recently },
+	} {
+		obj, _, _ := lbc.nodeLister.Indexer.GetByKey(name)
+		update(obj.(*api_v1.Node))
+	}
+
+	for _, tc := range []struct {
+		desc                string
+		excludeUnreadyNodes bool
+		expected            []string
+	}{
+		{"Unready nodes excluded", true, []string{"n1", "n2", "n6"}},
+		{"Unready nodes kept", false, []string{"n1", "n2", "n3", "n4", "n5", "n6"}},
+	} {
+		lbc.excludeUnreadyNodes = tc.excludeUnreadyNodes
+		nodeNames, err := lbc.getReadyNodeNames()
+		if err != nil {
+			t.Fatalf("%v: failed to get node names: %v", tc.desc, err)
+		}
+		if got := sets.NewString(nodeNames...); !got.Equal(sets.NewString(tc.expected...)) {
+			t.Errorf("%v: expect node names %v, got %v", tc.desc, tc.expected, got.List())
+		}
+	}
+}
+
+func TestSyncNodeZones(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	lbc.ingLister.Store.Add(&extensions.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "ing", Namespace: "default"},
+	})
+	addNodes(lbc, map[string][]string{"zone-1": {"n1"}})
+	if err := lbc.syncNodes("n1"); err != nil {
+		t.Fatalf("Failed to sync nodes: %v", err)
+	}
+	// The initial sync of the Ingresses covers the initial zones.
+	if l := lbc.ingQueue.queue.Len(); l != 0 {
+		t.Fatalf("Expect no Ingress to be enqueued, got %v", l)
+	}
+
+	addNodes(lbc, map[string][]string{"zone-1": {"n2"}, "zone-2": {"n3"}})
+	if err := lbc.syncNodes("n3"); err != nil {
+		t.Fatalf("Failed to sync nodes: %v", err)
+	}
+	if l := lbc.ingQueue.queue.Len(); l != 1 {
+		t.Fatalf("Expect an Ingress to be enqueued for the new zone, got %v", l)
+	}
+	if key, _ := lbc.ingQueue.queue.Get(); key != "default/ing" {
+		t.Errorf("Expect Ingress default/ing to be enqueued, got %v", key)
+	}
+}
+
 func TestProbeGetter(t *testing.T) {
 	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
 	lbc := newLoadBalancerController(t, cm)
@@ -98,6 +234,7 @@ func TestProbeGetter(t *testing.T) {
 	nodePortToHealthCheck := map[backends.ServicePort]string{
 		{Port: 3001, Protocol: utils.ProtocolHTTP}:  "/healthz",
 		{Port: 3002, Protocol: utils.ProtocolHTTPS}: "/foo",
+		{Port: 3003, Protocol: utils.ProtocolHTTP2}: "/grpc",
 	}
 	addPods(lbc, nodePortToHealthCheck, api_v1.NamespaceDefault)
 	for p, exp := range nodePortToHealthCheck {
@@ -110,6 +247,23 @@ func TestProbeGetter(t *testing.T) {
 	}
 }
 
+func TestProbeGetterHTTPSProbe(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	p := backends.ServicePort{Port: 3001, Protocol: utils.ProtocolHTTP}
+	addPods(lbc, map[backends.ServicePort]string{p: "/healthz"}, api_v1.NamespaceDefault)
+	for _, obj := range lbc.podLister.Indexer.List() {
+		obj.(*api_v1.Pod).Spec.Containers[0].ReadinessProbe.Handler.HTTPGet.Scheme = api_v1.URISchemeHTTPS
+	}
+	got, err := lbc.Translator.GetProbe(p)
+	if err != nil || got == nil {
+		t.Fatalf("Failed to get probe for node port %v: %v", p, err)
+	}
+	if getProbePath(got) != "/healthz" {
+		t.Errorf("Wrong path for node port %v, got %v expected /healthz", p, getProbePath(got))
+	}
+}
+
 func TestProbeGetterNamedPort(t *testing.T) {
 	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
 	lbc := newLoadBalancerController(t, cm)
@@ -219,7 +373,7 @@ func addPods(lbc *LoadBalancerController, nodePortToHealthCheck map[backends.Ser
 						ReadinessProbe: &api_v1.Probe{
 							Handler: api_v1.Handler{
 								HTTPGet: &api_v1.HTTPGetAction{
-									Scheme: api_v1.URIScheme(string(np.Protocol)),
+									Scheme: probeScheme(np.Protocol),
 									Path:   u,
 									Port: intstr.IntOrString{
 										Type:   intstr.Int,
@@ -337,26 +491,152 @@ func TestGatherFirewallPorts(t *testing.T) {
 	lbc.endpointLister.Indexer.Add(newDefaultEndpoint(ep1))
 	lbc.endpointLister.Indexer.Add(newDefaultEndpoint(ep2))
 
-	res := lbc.Translator.gatherFirewallPorts(svcPorts, true)
-	expect := map[int64]bool{
-		int64(30000): true,
-		int64(30001): true,
-		int64(30002): true,
-		int64(80):    true,
-		int64(8080):  true,
-		int64(8081):  true,
+	nodePorts, negPorts := lbc.Translator.gatherFirewallPorts(svcPorts, true)
+	for _, tc := range []struct {
+		desc   string
+		res    []int64
+		expect map[int64]bool
+	}{
+		{
+			desc: "node ports",
+			res:  nodePorts,
+			expect: map[int64]bool{
+				int64(30000): true,
+				int64(30001): true,
+				int64(30002): true,
+			},
+		},
+		{
+			desc: "neg ports",
+			res:  negPorts,
+			expect: map[int64]bool{
+				int64(80):   true,
+				int64(8080): true,
+				int64(8081): true,
+			},
+		},
+	} {
+		if len(tc.res) != len(tc.expect) {
+			t.Errorf("got firewall %v == %v, want %v", tc.desc, tc.res, tc.expect)
+		}
+
+		for _, p := range tc.res {
+			if _, ok := tc.expect[p]; !ok {
+				t.Errorf("firewall %v port %v is missing, (got %v, want %v)", tc.desc, p, tc.res, tc.expect)
+			}
+		}
 	}
-	if len(res) != len(expect) {
-		t.Errorf("got firewall ports == %v, want %v", res, expect)
+}
+
+func TestGetServiceNodePortBackendConfig(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	httpConfig := &backendconfig.BackendConfig{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "http-config"}}
+	defaultConfig := &backendconfig.BackendConfig{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "default-config"}}
+	lbc.backendConfigGetter = &backendconfig.FakeBackendConfigGetter{Configs: map[string]*backendconfig.BackendConfig{
+		"ns/http-config":    httpConfig,
+		"ns/default-config": defaultConfig,
+	}}
+	svc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				annotations.BackendConfigKey: `{"ports": {"http": "http-config", "8080": "missing-config"}, "default": "default-config"}`,
+			},
+		},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{
+				{Name: "http", Port: 80, NodePort: 30001},
+				{Name: "https", Port: 443, NodePort: 30002},
+				{Name: "alt", Port: 8080, NodePort: 30003},
+			},
+		},
 	}
+	lbc.svcLister.Indexer.Add(svc)
 
-	for _, p := range res {
-		if _, ok := expect[p]; !ok {
-			t.Errorf("firewall port %v is missing, (got %v, want %v)", p, res, expect)
+	for _, tc := range []struct {
+		port intstr.IntOrString
+		want *backendconfig.BackendConfig
+	}{
+		{port: intstr.FromString("http"), want: httpConfig},
+		{port: intstr.FromInt(443), want: defaultConfig},
+		// A missing BackendConfig leaves the port without one.
+		{port: intstr.FromInt(8080), want: nil},
+	} {
+		sp, err := lbc.Translator.getServiceNodePort(extensions.IngressBackend{ServiceName: "svc", ServicePort: tc.port}, "ns")
+		if err != nil {
+			t.Errorf("getServiceNodePort(%v) = %v", tc.port.String(), err)
+			continue
+		}
+		if sp.BackendConfig != tc.want {
+			t.Errorf("getServiceNodePort(%v).BackendConfig = %v, want %v", tc.port.String(), sp.BackendConfig, tc.want)
 		}
 	}
 }
 
+func TestGetServiceNodePortAppProtocol(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	reads := 0
+	raw := `{"spec": {"ports": [
+		{"name": "https", "port": 443, "appProtocol": "HTTPS"},
+		{"name": "grpc", "port": 8443, "appProtocol": "grpc"},
+		{"name": "h2c", "port": 8080, "appProtocol": "kubernetes.io/h2c"},
+		{"name": "annotated", "port": 9443, "appProtocol": "HTTPS"}
+	]}}`
+	lbc.portAppProtocols.getRaw = func(namespace, name string) ([]byte, error) {
+		reads++
+		return []byte(raw), nil
+	}
+	svc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:            "svc",
+			Namespace:       "ns",
+			ResourceVersion: "1",
+			Annotations:     map[string]string{annotations.GoogleServiceApplicationProtocolKey: `{"annotated": "HTTP2"}`},
+		},
+		Spec: api_v1.ServiceSpec{
+			Ports: []api_v1.ServicePort{
+				{Name: "https", Port: 443, NodePort: 30001},
+				{Name: "grpc", Port: 8443, NodePort: 30002},
+				{Name: "h2c", Port: 8080, NodePort: 30003},
+				{Name: "annotated", Port: 9443, NodePort: 30004},
+			},
+		},
+	}
+	lbc.svcLister.Indexer.Add(svc)
+
+	for port, want := range map[string]utils.AppProtocol{
+		"https":     utils.ProtocolHTTPS,
+		"grpc":      utils.ProtocolHTTP2,
+		"h2c":       utils.ProtocolHTTP,
+		"annotated": utils.ProtocolHTTP2,
+	} {
+		sp, err := lbc.Translator.getServiceNodePort(extensions.IngressBackend{ServiceName: "svc", ServicePort: intstr.FromString(port)}, "ns")
+		if err != nil {
+			t.Errorf("getServiceNodePort(%v) = %v", port, err)
+			continue
+		}
+		if sp.Protocol != want {
+			t.Errorf("getServiceNodePort(%v).Protocol = %v, want %v", port, sp.Protocol, want)
+		}
+	}
+	// The Service is only read again once it changes.
+	if reads != 1 {
+		t.Errorf("Expected the Service to be read once, got %d reads", reads)
+	}
+	updated := *svc
+	updated.ResourceVersion = "2"
+	lbc.svcLister.Indexer.Update(&updated)
+	if _, err := lbc.Translator.getServiceNodePort(extensions.IngressBackend{ServiceName: "svc", ServicePort: intstr.FromString("https")}, "ns"); err != nil {
+		t.Fatalf("getServiceNodePort(https) = %v", err)
+	}
+	if reads != 2 {
+		t.Errorf("Expected the updated Service to be read again, got %d reads", reads)
+	}
+}
+
 func newDefaultEndpoint(name string) *api_v1.Endpoints {
 	return &api_v1.Endpoints{
 		ObjectMeta: meta_v1.ObjectMeta{
@@ -399,3 +679,417 @@ func newDefaultEndpoint(name string) *api_v1.Endpoints {
 		},
 	}
 }
+
+func TestTaskQueueWorkers(t *testing.T) {
+	const workers = 3
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	synced := sets.NewString()
+	release := make(chan struct{})
+	q := NewTaskQueue(func(key string) error {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		<-release
+		lock.Lock()
+		running--
+		synced.Insert(key)
+		lock.Unlock()
+		return nil
+	}, "test", workers)
+	stopCh := make(chan struct{})
+	go q.run(time.Second, stopCh)
+	for i := 0; i < 2*workers; i++ {
+		q.queue.Add(fmt.Sprintf("ns/ing-%d", i))
+	}
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return running == workers, nil
+	}); err != nil {
+		t.Fatalf("Expected %v concurrent syncs: %v", workers, err)
+	}
+	close(release)
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return synced.Len() == 2*workers, nil
+	}); err != nil {
+		t.Fatalf("Expected %v synced keys, got %v", 2*workers, synced.List())
+	}
+	close(stopCh)
+	q.shutdown()
+	if maxRunning != workers {
+		t.Errorf("Got %v concurrent syncs, want %v", maxRunning, workers)
+	}
+}
+
+func TestTaskQueueProgress(t *testing.T) {
+	const timeout = time.Minute
+	release := make(chan struct{})
+	q := NewTaskQueue(func(key string) error {
+		<-release
+		return nil
+	}, "test", 1)
+	now := time.Now()
+
+	// Items waiting without workers.
+	q.queue.Add("ns/ing-1")
+	if err := q.checkProgress(now, timeout); err != nil {
+		t.Errorf("Expected progress before the timeout, got %v", err)
+	}
+	if err := q.checkProgress(now.Add(2*timeout), timeout); err == nil {
+		t.Errorf("Expected no progress of the waiting items")
+	}
+
+	// A sync which never returns.
+	stopCh := make(chan struct{})
+	go q.run(time.Second, stopCh)
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		q.progressLock.Lock()
+		defer q.progressLock.Unlock()
+		return len(q.syncing) == 1, nil
+	}); err != nil {
+		t.Fatalf("Expected a sync in progress: %v", err)
+	}
+	now = time.Now()
+	if err := q.checkProgress(now, timeout); err != nil {
+		t.Errorf("Expected progress before the timeout, got %v", err)
+	}
+	if err := q.checkProgress(now.Add(2*timeout), timeout); err == nil {
+		t.Errorf("Expected no progress of the stuck sync")
+	}
+
+	// An idle queue always makes progress.
+	close(release)
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		q.progressLock.Lock()
+		defer q.progressLock.Unlock()
+		return len(q.syncing) == 0, nil
+	}); err != nil {
+		t.Fatalf("Expected the sync to end: %v", err)
+	}
+	if err := q.checkProgress(time.Now().Add(2*timeout), timeout); err != nil {
+		t.Errorf("Expected progress of the idle queue, got %v", err)
+	}
+	close(stopCh)
+	q.shutdown()
+}
+
+func TestKeyLocks(t *testing.T) {
+	var locks keyLocks
+	locks.Lock("a")
+	// Other keys aren't blocked.
+	locks.Lock("b")
+	locks.Unlock("b")
+
+	locked := make(chan struct{})
+	go func() {
+		locks.Lock("a")
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("Expected the key to stay locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	locks.Unlock("a")
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the key to be unlocked")
+	}
+	locks.Unlock("a")
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected no mutex left, got %v", locks.locks)
+	}
+}
+
+func TestEnqueueIngressForSecret(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+
+	newTLSIngress := func(namespace, name, secret, class string) *extensions.Ingress {
+		return &extensions.Ingress{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Namespace:   namespace,
+				Name:        name,
+				Annotations: map[string]string{annotations.IngressClassKey: class},
+			},
+			Spec: extensions.IngressSpec{TLS: []extensions.IngressTLS{{SecretName: secret}}},
+		}
+	}
+	for _, ing := range []*extensions.Ingress{
+		newTLSIngress("ns", "served", "cert", ""),
+		newTLSIngress("ns", "other-secret", "other", ""),
+		newTLSIngress("other", "other-namespace", "cert", ""),
+		newTLSIngress("ns", "other-class", "cert", "nginx"),
+		newTLSIngress("team", "cross-namespace", "ns/cert", ""),
+	} {
+		lbc.ingLister.Store.Add(ing)
+	}
+
+	lbc.enqueueIngressForSecret(&api_v1.Secret{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cert"}})
+	if got := lbc.ingQueue.queue.Len(); got != 2 {
+		t.Fatalf("Expected 2 Ingresses to be enqueued, got %d", got)
+	}
+	got := sets.NewString()
+	for i := 0; i < 2; i++ {
+		key, _ := lbc.ingQueue.queue.Get()
+		got.Insert(key.(string))
+	}
+	if want := sets.NewString("ns/served", "team/cross-namespace"); !got.Equal(want) {
+		t.Errorf("Expected Ingresses %v to be enqueued, got %v", want.List(), got.List())
+	}
+}
+
+func TestNamingScheme(t *testing.T) {
+	served := extensions.IngressStatus{LoadBalancer: api_v1.LoadBalancerStatus{Ingress: []api_v1.LoadBalancerIngress{{IP: "1.2.3.4"}}}}
+	for _, tc := range []struct {
+		desc       string
+		scheme     string
+		finalizers []string
+		status     extensions.IngressStatus
+		want       utils.NamingScheme
+	}{
+		{desc: "new Ingress", want: utils.NamingSchemeV2},
+		{desc: "Ingress with a finalizer", finalizers: []string{annotations.IngressFinalizerKey}, want: utils.NamingSchemeV1},
+		{desc: "served Ingress", status: served, want: utils.NamingSchemeV1},
+		{desc: "persisted V1", scheme: "v1", want: utils.NamingSchemeV1},
+		{desc: "persisted V2", scheme: "v2", status: served, want: utils.NamingSchemeV2},
+		{desc: "invalid", scheme: "v3", want: utils.NamingSchemeV1},
+	} {
+		ing := &extensions.Ingress{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "ing", Finalizers: tc.finalizers},
+			Status:     tc.status,
+		}
+		if tc.scheme != "" {
+			ing.Annotations = map[string]string{annotations.NamingSchemeKey: tc.scheme}
+		}
+		if got := namingScheme(ing); got != tc.want {
+			t.Errorf("%v: namingScheme() = %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestLBKey(t *testing.T) {
+	lister := &StoreToIngressLister{}
+	lister.setLBGroupGrants(map[string]sets.String{"team/granted": sets.NewString("ns")})
+	for _, tc := range []struct {
+		desc  string
+		group string
+		want  string
+	}{
+		{desc: "no group", want: "ns/ing"},
+		{desc: "group", group: "shared", want: "/ns/shared"},
+		{desc: "group of the namespace", group: "ns/shared", want: "/ns/shared"},
+		{desc: "granted group of another namespace", group: "team/granted", want: "/team/granted"},
+		{desc: "group of another namespace", group: "team/shared", want: "ns/ing"},
+		{desc: "invalid group", group: "Shared_LB", want: "ns/ing"},
+		{desc: "invalid namespace", group: "Team/shared", want: "ns/ing"},
+	} {
+		ing := &extensions.Ingress{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "ing"}}
+		if tc.group != "" {
+			ing.Annotations = map[string]string{annotations.LBGroupKey: tc.group}
+		}
+		if got, err := lister.lbKey(ing); err != nil || got != tc.want {
+			t.Errorf("%v: lbKey() = %q, %v, want %q", tc.desc, got, err, tc.want)
+		}
+	}
+}
+
+func TestGroupByLoadBalancer(t *testing.T) {
+	newIng := func(namespace, name, group string, created int64) extensions.Ingress {
+		ing := extensions.Ingress{ObjectMeta: meta_v1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			CreationTimestamp: meta_v1.NewTime(time.Unix(created, 0)),
+		}}
+		if group != "" {
+			ing.Annotations = map[string]string{annotations.LBGroupKey: group}
+		}
+		return ing
+	}
+	lister := &StoreToIngressLister{}
+	lister.setLBGroupGrants(map[string]sets.String{"b/shared": sets.NewString("a")})
+	keys, groups := lister.groupByLoadBalancer([]extensions.Ingress{
+		newIng("b", "new", "shared", 2),
+		newIng("a", "own", "", 1),
+		newIng("b", "old", "shared", 1),
+		newIng("a", "old", "b/shared", 1),
+		newIng("a", "other", "shared", 1),
+	})
+	if want := []string{"/b/shared", "a/own", "/a/shared"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("groupByLoadBalancer() keys = %v, want %v", keys, want)
+	}
+	var got []string
+	for _, ing := range groups["/b/shared"] {
+		got = append(got, ingressKey(&ing))
+	}
+	// The oldest Ingress comes first, then by key.
+	if want := []string{"a/old", "b/old", "b/new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groupByLoadBalancer() LB group = %v, want %v", got, want)
+	}
+}
+
+func TestSyncPersistsNamingScheme(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	ing := validIngress()
+	ing.Status = extensions.IngressStatus{}
+	addIngress(lbc, ing, newPortManager(1, 65536))
+	key := getKey(ing, t)
+	lbc.sync(key)
+
+	updated, err := lbc.client.Extensions().Ingresses(ing.Namespace).Get(ing.Name, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if scheme := updated.Annotations[annotations.NamingSchemeKey]; scheme != string(utils.NamingSchemeV2) {
+		t.Errorf("Expected naming scheme %v to be persisted, got %q", utils.NamingSchemeV2, scheme)
+	}
+	l7, err := cm.l7Pool.Get(key)
+	if err != nil {
+		t.Fatalf("l7Pool.Get(%q) = %v", key, err)
+	}
+	if want := cm.ClusterNamer.LoadBalancerV2(key); l7.Name != want {
+		t.Errorf("Expected loadbalancer %v, got %v", want, l7.Name)
+	}
+}
+
+func TestOnlyStatusChanged(t *testing.T) {
+	old := &extensions.Ingress{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "ing", ResourceVersion: "1"}}
+	for _, tc := range []struct {
+		desc   string
+		mutate func(*extensions.Ingress)
+		want   bool
+	}{
+		{
+			desc:   "backend health",
+			mutate: func(ing *extensions.Ingress) { ing.Annotations = map[string]string{annotations.BackendHealthKey: "{}"} },
+			want:   true,
+		},
+		{
+			desc: "conditions and load balancer resources",
+			mutate: func(ing *extensions.Ingress) {
+				ing.Annotations = map[string]string{annotations.ConditionsKey: "[]", utils.K8sAnnotationPrefix + "/url-map": "k8s-um"}
+			},
+			want: true,
+		},
+		{
+			desc: "ip",
+			mutate: func(ing *extensions.Ingress) {
+				ing.Status.LoadBalancer.Ingress = []api_v1.LoadBalancerIngress{{IP: "1.2.3.4"}}
+			},
+			want: true,
+		},
+		{
+			desc:   "user annotation",
+			mutate: func(ing *extensions.Ingress) { ing.Annotations = map[string]string{annotations.AllowHTTPKey: "false"} },
+		},
+		{
+			desc:   "spec",
+			mutate: func(ing *extensions.Ingress) { ing.Spec.Backend = &extensions.IngressBackend{ServiceName: "svc"} },
+		},
+	} {
+		cur := old.DeepCopy()
+		cur.ResourceVersion = "2"
+		tc.mutate(cur)
+		if got := onlyStatusChanged(old, cur); got != tc.want {
+			t.Errorf("%s: onlyStatusChanged() = %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestGetGRPCProbe(t *testing.T) {
+	cm := NewFakeClusterManager(DefaultClusterUID, DefaultFirewallName)
+	lbc := newLoadBalancerController(t, cm)
+	reads := 0
+	raw := `{"spec": {"containers": [
+		{"name": "http", "readinessProbe": {"httpGet": {"port": 8080}}},
+		{"name": "grpc", "readinessProbe": {"grpc": {"port": 9000, "service": "foo"}, "periodSeconds": 5, "timeoutSeconds": 2}}
+	]}}`
+	lbc.grpcProbes.getRaw = func(namespace, name string) ([]byte, error) {
+		reads++
+		return []byte(raw), nil
+	}
+	svc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: "ns"},
+		Spec: api_v1.ServiceSpec{
+			Selector: map[string]string{"app": "grpc"},
+			Ports: []api_v1.ServicePort{
+				{Name: "grpc", Port: 443, NodePort: 30001, TargetPort: intstr.FromString("grpc")},
+				{Name: "http", Port: 80, NodePort: 30002, TargetPort: intstr.FromInt(8080)},
+			},
+		},
+	}
+	lbc.svcLister.Indexer.Add(svc)
+	pod := &api_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "pod", Namespace: "ns", UID: "uid", Labels: map[string]string{"app": "grpc"}},
+		Spec: api_v1.PodSpec{
+			Containers: []api_v1.Container{
+				{Name: "http", Ports: []api_v1.ContainerPort{{ContainerPort: 8080}}},
+				{Name: "grpc", Ports: []api_v1.ContainerPort{{Name: "grpc", ContainerPort: 9000}}},
+			},
+		},
+	}
+	lbc.podLister.Indexer.Add(pod)
+
+	got, err := lbc.Translator.GetGRPCProbe(backends.ServicePort{Port: 30001, Protocol: utils.ProtocolHTTP2})
+	want := &backends.GRPCProbe{Service: "foo", PeriodSeconds: 5, TimeoutSeconds: 2}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("GetGRPCProbe(30001) = %+v, %v, want %+v", got, err, want)
+	}
+	// The container of the other port has no gRPC probe.
+	if got, err = lbc.Translator.GetGRPCProbe(backends.ServicePort{Port: 30002, Protocol: utils.ProtocolHTTP2}); err != nil || got != nil {
+		t.Errorf("GetGRPCProbe(30002) = %+v, %v, want nil", got, err)
+	}
+	// The probes of a Pod are only read once, until it is deleted.
+	if reads != 1 {
+		t.Errorf("Expected the Pod to be read once, got %d reads", reads)
+	}
+	lbc.grpcProbes.forget(pod)
+	if _, ok := lbc.grpcProbes.cache[pod.UID]; ok {
+		t.Errorf("Expected the probes of the deleted Pod to be forgotten")
+	}
+}
+
+func TestListGCEIngressesOfIngressClasses(t *testing.T) {
+	newClass := func(name, controller string, isDefault bool) ingressclass.IngressClass {
+		class := ingressclass.IngressClass{ObjectMeta: meta_v1.ObjectMeta{Name: name}}
+		class.Spec.Controller = controller
+		if isDefault {
+			class.Annotations = map[string]string{ingressclass.DefaultClassKey: "true"}
+		}
+		return class
+	}
+	classes := ingressclass.NewClasses(&ingressclass.FakeClient{
+		Classes: []ingressclass.IngressClass{
+			newClass("nginx", "k8s.io/ingress-nginx", true),
+			newClass("external", ingressclass.ControllerGCE, false),
+		},
+		ClassNames: map[string]string{"ns/named": "external"},
+	})
+	if _, err := classes.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	lister := &StoreToIngressLister{Store: cache.NewStore(cache.MetaNamespaceKeyFunc), classes: classes}
+	for _, name := range []string{"named", "defaulted"} {
+		lister.Store.Add(&extensions.Ingress{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: name}})
+	}
+	ings, err := lister.ListGCEIngresses()
+	if err != nil {
+		t.Fatalf("ListGCEIngresses() = %v", err)
+	}
+	var got []string
+	for _, ing := range ings.Items {
+		got = append(got, ingressKey(&ing))
+	}
+	// The other Ingress is of the default class, of another controller.
+	if want := []string{"ns/named"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListGCEIngresses() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,23 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dns manages the Cloud DNS records of the hosts of the Ingresses in
+// a managed zone: an A record for the IPv4 address of the load balancer and
+// an AAAA record for its IPv6 address, if any. A TXT record next to them
+// records their owner, the controller of a cluster and an Ingress, so that
+// the controllers of several clusters sharing the zone never overwrite nor
+// delete each other's records, nor the records created by other means.
+package dns
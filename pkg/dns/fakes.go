@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+)
+
+// FakeManagedZones is an in-memory ManagedZones.
+type FakeManagedZones struct {
+	lock sync.Mutex
+	// Zones are the DNS names of the zones, by zone.
+	Zones map[string]string
+	// Records are the record sets of the zones, by zone.
+	Records map[string][]*ResourceRecordSet
+	// Changes is the number of changes applied.
+	Changes int
+}
+
+// NewFakeManagedZones returns a FakeManagedZones with the given zones,
+// DNS names by zone, without records.
+func NewFakeManagedZones(zones map[string]string) *FakeManagedZones {
+	return &FakeManagedZones{Zones: zones, Records: map[string][]*ResourceRecordSet{}}
+}
+
+// GetManagedZone returns the given zone.
+func (f *FakeManagedZones) GetManagedZone(zone string) (*ManagedZone, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	dnsName, ok := f.Zones[zone]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound}
+	}
+	return &ManagedZone{Name: zone, DnsName: dnsName}, nil
+}
+
+// ListResourceRecordSets returns copies of the record sets of the zone.
+func (f *FakeManagedZones) ListResourceRecordSets(zone string) ([]*ResourceRecordSet, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.Zones[zone]; !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound}
+	}
+	var rrsets []*ResourceRecordSet
+	for _, rrset := range f.Records[zone] {
+		c := *rrset
+		rrsets = append(rrsets, &c)
+	}
+	return rrsets, nil
+}
+
+// CreateChange applies the change like Cloud DNS: the deleted record sets
+// must match the existing ones, and the added ones must not exist.
+func (f *FakeManagedZones) CreateChange(zone string, change *Change) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.Zones[zone]; !ok {
+		return &googleapi.Error{Code: http.StatusNotFound}
+	}
+	rrsets := append([]*ResourceRecordSet{}, f.Records[zone]...)
+	for _, deletion := range change.Deletions {
+		found := false
+		for i, rrset := range rrsets {
+			if reflect.DeepEqual(rrset, deletion) {
+				rrsets = append(rrsets[:i], rrsets[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &googleapi.Error{Code: http.StatusPreconditionFailed, Message: fmt.Sprintf("record set %v %v doesn't match", deletion.Type, deletion.Name)}
+		}
+	}
+	for _, addition := range change.Additions {
+		for _, rrset := range rrsets {
+			if rrset.Name == addition.Name && rrset.Type == addition.Type {
+				return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("record set %v %v already exists", addition.Type, addition.Name)}
+			}
+		}
+		c := *addition
+		rrsets = append(rrsets, &c)
+	}
+	f.Records[zone] = rrsets
+	f.Changes++
+	return nil
+}
+
+// Get returns the record set of the given zone, name and type, nil if none.
+func (f *FakeManagedZones) Get(zone, name, t string) *ResourceRecordSet {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, rrset := range f.Records[zone] {
+		if rrset.Name == name && rrset.Type == t {
+			return rrset
+		}
+	}
+	return nil
+}
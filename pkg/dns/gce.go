@@ -0,0 +1,191 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	// defaultEndpoint is the endpoint of the Cloud DNS v1 API.
+	defaultEndpoint = "https://dns.googleapis.com/dns/v1/"
+	// readWriteScope is the OAuth scope managing the Cloud DNS records.
+	readWriteScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+	// changeStatusDone is the status of the changes applied to a zone.
+	changeStatusDone = "done"
+	// changePollPeriod and changeTimeout bound the wait for a change to be
+	// done, which usually takes seconds.
+	changePollPeriod = time.Second
+	changeTimeout    = 2 * time.Minute
+)
+
+// ManagedZone is a Cloud DNS managed zone.
+type ManagedZone struct {
+	Name string `json:"name"`
+	// DnsName is the DNS name of the zone, with a trailing dot, eg:
+	// example.com.
+	DnsName string `json:"dnsName"`
+}
+
+// ResourceRecordSet is a Cloud DNS record set: the records of a name and a
+// type.
+type ResourceRecordSet struct {
+	// Name is the fully qualified name, with a trailing dot.
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Ttl     int64    `json:"ttl,omitempty"`
+	Rrdatas []string `json:"rrdatas"`
+}
+
+// Change is an atomic change of the record sets of a Cloud DNS managed zone.
+// The deleted record sets must match the existing ones.
+type Change struct {
+	Id        string               `json:"id,omitempty"`
+	Status    string               `json:"status,omitempty"`
+	Additions []*ResourceRecordSet `json:"additions,omitempty"`
+	Deletions []*ResourceRecordSet `json:"deletions,omitempty"`
+}
+
+// gceManagedZones implements ManagedZones through the REST API of Cloud
+// DNS, which has no vendored client.
+type gceManagedZones struct {
+	project  string
+	endpoint string
+	client   *http.Client
+}
+
+// NewGCEManagedZones returns a ManagedZones that manages the zones of the
+// given project through the Cloud DNS API. tokenSource and transport are
+// those of utils.NewComputeREST, apiEndpoint is the Cloud DNS API endpoint,
+// the default endpoint if empty.
+func NewGCEManagedZones(project string, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (ManagedZones, error) {
+	if tokenSource == nil {
+		var err error
+		tokenSource, err = google.DefaultTokenSource(oauth2.NoContext, readWriteScope)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if apiEndpoint == "" {
+		apiEndpoint = defaultEndpoint
+	}
+	if !strings.HasSuffix(apiEndpoint, "/") {
+		apiEndpoint += "/"
+	}
+	client := utils.NewOAuthClient(tokenSource, transport)
+	client.Timeout = 30 * time.Second
+	return &gceManagedZones{project: project, endpoint: apiEndpoint, client: client}, nil
+}
+
+// GetManagedZone returns the given managed zone.
+func (g *gceManagedZones) GetManagedZone(zone string) (*ManagedZone, error) {
+	mz := &ManagedZone{}
+	if err := g.do("GET", g.zoneURL(zone), nil, mz); err != nil {
+		return nil, err
+	}
+	return mz, nil
+}
+
+// ListResourceRecordSets returns all the record sets of the given zone,
+// following the pages of the list.
+func (g *gceManagedZones) ListResourceRecordSets(zone string) ([]*ResourceRecordSet, error) {
+	var rrsets []*ResourceRecordSet
+	pageToken := ""
+	for {
+		u := g.zoneURL(zone) + "/rrsets"
+		if pageToken != "" {
+			u += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		page := struct {
+			Rrsets        []*ResourceRecordSet `json:"rrsets"`
+			NextPageToken string               `json:"nextPageToken"`
+		}{}
+		if err := g.do("GET", u, nil, &page); err != nil {
+			return nil, err
+		}
+		rrsets = append(rrsets, page.Rrsets...)
+		if pageToken = page.NextPageToken; pageToken == "" {
+			return rrsets, nil
+		}
+	}
+}
+
+// CreateChange applies the given change to the zone, and waits for it to be
+// done.
+func (g *gceManagedZones) CreateChange(zone string, change *Change) error {
+	created := &Change{}
+	if err := g.do("POST", g.zoneURL(zone)+"/changes", change, created); err != nil {
+		return err
+	}
+	if created.Status == changeStatusDone {
+		return nil
+	}
+	return wait.Poll(changePollPeriod, changeTimeout, func() (bool, error) {
+		current := &Change{}
+		if err := g.do("GET", g.zoneURL(zone)+"/changes/"+url.PathEscape(created.Id), nil, current); err != nil {
+			return false, err
+		}
+		return current.Status == changeStatusDone, nil
+	})
+}
+
+// zoneURL returns the URL of the given zone.
+func (g *gceManagedZones) zoneURL(zone string) string {
+	return fmt.Sprintf("%vprojects/%v/managedZones/%v", g.endpoint, url.PathEscape(g.project), url.PathEscape(zone))
+}
+
+// do sends a request with the given body, encoded in JSON if not nil, and
+// decodes the response into out. Returns a googleapi.Error for the error
+// responses.
+func (g *gceManagedZones) do(method, u string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
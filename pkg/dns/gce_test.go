@@ -0,0 +1,77 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestGCEManagedZones(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Got authorization %q", got)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /projects/p/managedZones/zone":
+			w.Write([]byte(`{"name": "zone", "dnsName": "example.com."}`))
+		case "GET /projects/p/managedZones/zone/rrsets":
+			if r.URL.Query().Get("pageToken") == "" {
+				w.Write([]byte(`{"rrsets": [{"name": "a.example.com.", "type": "A", "ttl": 300, "rrdatas": ["1.2.3.4"]}], "nextPageToken": "next"}`))
+			} else {
+				w.Write([]byte(`{"rrsets": [{"name": "b.example.com.", "type": "A", "ttl": 300, "rrdatas": ["5.6.7.8"]}]}`))
+			}
+		case "POST /projects/p/managedZones/zone/changes":
+			change := &Change{}
+			if err := json.NewDecoder(r.Body).Decode(change); err != nil || len(change.Additions) != 1 {
+				t.Errorf("Got change %+v, %v", change, err)
+			}
+			w.Write([]byte(`{"id": "1", "status": "pending"}`))
+		case "GET /projects/p/managedZones/zone/changes/1":
+			polls++
+			w.Write([]byte(`{"id": "1", "status": "done"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	zones, err := NewGCEManagedZones("p", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), nil, server.URL)
+	if err != nil {
+		t.Fatalf("NewGCEManagedZones() = %v", err)
+	}
+	if mz, err := zones.GetManagedZone("zone"); err != nil || mz.DnsName != "example.com." {
+		t.Errorf("GetManagedZone() = %+v, %v, want DNS name example.com.", mz, err)
+	}
+	if rrsets, err := zones.ListResourceRecordSets("zone"); err != nil || len(rrsets) != 2 || rrsets[1].Name != "b.example.com." {
+		t.Errorf("ListResourceRecordSets() = %+v, %v, want the record sets of both pages", rrsets, err)
+	}
+	change := &Change{Additions: []*ResourceRecordSet{{Name: "c.example.com.", Type: "A", Ttl: 300, Rrdatas: []string{"1.1.1.1"}}}}
+	if err := zones.CreateChange("zone", change); err != nil || polls != 1 {
+		t.Errorf("CreateChange() = %v after %v polls, want the change to be done", err, polls)
+	}
+	if _, err := zones.GetManagedZone("missing"); err == nil || err.(*googleapi.Error).Code != http.StatusNotFound {
+		t.Errorf("GetManagedZone() = %v, want a not found error", err)
+	}
+}
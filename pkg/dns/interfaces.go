@@ -0,0 +1,27 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+// ManagedZones manages the record sets of Cloud DNS managed zones.
+type ManagedZones interface {
+	GetManagedZone(zone string) (*ManagedZone, error)
+	// ListResourceRecordSets returns all the record sets of the given zone.
+	ListResourceRecordSets(zone string) ([]*ResourceRecordSet, error)
+	// CreateChange atomically applies the given change to the zone, and
+	// waits for it to be done.
+	CreateChange(zone string, change *Change) error
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// heritage starts the ownership TXT records of the controller.
+	heritage = "heritage=ingress-gce"
+
+	typeA    = "A"
+	typeAAAA = "AAAA"
+	typeTXT  = "TXT"
+)
+
+// Records manages the A and AAAA records of the hosts of the Ingresses in a
+// Cloud DNS managed zone, along with their ownership TXT records.
+type Records struct {
+	zones ManagedZones
+	// zone is the name of the managed zone.
+	zone string
+	// owner identifies the controller in the ownership records, eg: the
+	// cluster UID.
+	owner string
+	// ttl is the TTL of the records, in seconds.
+	ttl int64
+	// lock serializes the changes of the zone, since several Ingresses are
+	// synced concurrently. It also protects dnsName.
+	lock sync.Mutex
+	// dnsName is the DNS name of the zone, empty until it is known.
+	dnsName string
+}
+
+// NewRecords returns a Records managing the records of the given zone on
+// behalf of the given owner, with the given TTL.
+func NewRecords(zones ManagedZones, zone, owner string, ttl time.Duration) *Records {
+	return &Records{zones: zones, zone: zone, owner: owner, ttl: int64(ttl / time.Second)}
+}
+
+// SkippedHostsError is returned when the records of some hosts of an Ingress
+// are not managed, eg: the host is not in the zone, or its records are owned
+// by another Ingress or cluster.
+type SkippedHostsError struct {
+	// Reasons are the reasons the hosts are skipped, by host.
+	Reasons map[string]string
+}
+
+func (e *SkippedHostsError) Error() string {
+	var msgs []string
+	for host, reason := range e.Reasons {
+		msgs = append(msgs, fmt.Sprintf("%v: %v", host, reason))
+	}
+	sort.Strings(msgs)
+	return fmt.Sprintf("skipped the DNS records of hosts %v", strings.Join(msgs, "; "))
+}
+
+// ownership is the owner of records, recorded in a TXT record next to them.
+type ownership struct {
+	owner   string
+	ingress string
+}
+
+// parseOwnership parses the given TXT record data. Returns false if it is not
+// an ownership record of the controller.
+func parseOwnership(rrdata string) (ownership, bool) {
+	fields := strings.Split(strings.Trim(rrdata, `"`), ",")
+	if fields[0] != heritage {
+		return ownership{}, false
+	}
+	o := ownership{}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "owner":
+			o.owner = kv[1]
+		case "ingress":
+			o.ingress = kv[1]
+		}
+	}
+	return o, true
+}
+
+// String returns the TXT record data of the ownership.
+func (o ownership) String() string {
+	return fmt.Sprintf(`"%v,owner=%v,ingress=%v"`, heritage, o.owner, o.ingress)
+}
+
+// zoneRecords are the record sets of a zone, by name and type.
+type zoneRecords map[string]map[string]*ResourceRecordSet
+
+// ownership returns the ownership of the records of the given name, false if
+// the controller of no cluster owns them.
+func (z zoneRecords) ownership(name string) (ownership, bool) {
+	if txt := z[name][typeTXT]; txt != nil {
+		for _, rrdata := range txt.Rrdatas {
+			if o, ok := parseOwnership(rrdata); ok {
+				return o, true
+			}
+		}
+	}
+	return ownership{}, false
+}
+
+// otherTXT returns the data of the TXT records of the given name other than
+// the ownership one, eg: domain verifications.
+func (z zoneRecords) otherTXT(name string) []string {
+	var rrdatas []string
+	if txt := z[name][typeTXT]; txt != nil {
+		for _, rrdata := range txt.Rrdatas {
+			if _, ok := parseOwnership(rrdata); !ok {
+				rrdatas = append(rrdatas, rrdata)
+			}
+		}
+	}
+	return rrdatas
+}
+
+// Sync points the A and AAAA records of the given hosts of the Ingress with
+// the given key to the given IPs, and deletes the records of the hosts the
+// Ingress no longer has. The hosts outside the zone, and the ones with
+// records the controller didn't create for this Ingress, are skipped with a
+// SkippedHostsError.
+func (r *Records) Sync(key string, hosts, ips []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	records, err := r.list()
+	if err != nil {
+		return err
+	}
+	want := ownership{owner: r.owner, ingress: key}
+	rrdatas := map[string][]string{}
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed == nil {
+			continue
+		} else if parsed.To4() != nil {
+			rrdatas[typeA] = append(rrdatas[typeA], ip)
+		} else {
+			rrdatas[typeAAAA] = append(rrdatas[typeAAAA], ip)
+		}
+	}
+
+	change := &Change{}
+	skipped := map[string]string{}
+	names := sets.NewString()
+	for _, host := range hosts {
+		name, err := r.name(host)
+		if err != nil {
+			skipped[host] = err.Error()
+			continue
+		}
+		if o, ok := records.ownership(name); ok && o != want {
+			skipped[host] = fmt.Sprintf("owned by Ingress %v of %v", o.ingress, o.owner)
+			continue
+		} else if !ok && (records[name][typeA] != nil || records[name][typeAAAA] != nil) {
+			skipped[host] = "records not created by the controller already exist"
+			continue
+		}
+		names.Insert(name)
+		rrdatas[typeTXT] = append(records.otherTXT(name), want.String())
+		for _, t := range []string{typeA, typeAAAA, typeTXT} {
+			r.replace(change, records[name][t], name, t, rrdatas[t])
+		}
+	}
+	for name := range records {
+		if o, ok := records.ownership(name); ok && o == want && !names.Has(name) {
+			r.remove(change, records, name)
+		}
+	}
+	if err := r.apply(change); err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		return &SkippedHostsError{Reasons: skipped}
+	}
+	return nil
+}
+
+// GC deletes the records the controller created for the Ingresses whose key
+// is not in the given keys.
+func (r *Records) GC(keys sets.String) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	records, err := r.list()
+	if err != nil {
+		return err
+	}
+	change := &Change{}
+	for name := range records {
+		if o, ok := records.ownership(name); ok && o.owner == r.owner && !keys.Has(o.ingress) {
+			r.remove(change, records, name)
+		}
+	}
+	return r.apply(change)
+}
+
+// list returns the record sets of the zone, and records the DNS name of the
+// zone on the first call.
+func (r *Records) list() (zoneRecords, error) {
+	if r.dnsName == "" {
+		mz, err := r.zones.GetManagedZone(r.zone)
+		if err != nil {
+			return nil, err
+		}
+		r.dnsName = mz.DnsName
+	}
+	rrsets, err := r.zones.ListResourceRecordSets(r.zone)
+	if err != nil {
+		return nil, err
+	}
+	records := zoneRecords{}
+	for _, rrset := range rrsets {
+		if records[rrset.Name] == nil {
+			records[rrset.Name] = map[string]*ResourceRecordSet{}
+		}
+		records[rrset.Name][rrset.Type] = rrset
+	}
+	return records, nil
+}
+
+// name returns the fully qualified name of the given host, an error if it is
+// not in the zone.
+func (r *Records) name(host string) (string, error) {
+	name := strings.ToLower(strings.TrimSuffix(host, ".")) + "."
+	if name != r.dnsName && !strings.HasSuffix(name, "."+r.dnsName) {
+		return "", fmt.Errorf("not in zone %v (%v)", r.zone, r.dnsName)
+	}
+	return name, nil
+}
+
+// replace adds to the given change the replacement of the given record set,
+// nil if none, by the records of the given name and type with the given data,
+// none if empty. The TTL of the TXT records, which may not all be the
+// controller's, is kept.
+func (r *Records) replace(change *Change, existing *ResourceRecordSet, name, t string, rrdatas []string) {
+	ttl := r.ttl
+	if existing != nil && t == typeTXT {
+		ttl = existing.Ttl
+	}
+	if existing != nil && existing.Ttl == ttl && sets.NewString(existing.Rrdatas...).Equal(sets.NewString(rrdatas...)) {
+		return
+	}
+	if existing != nil {
+		change.Deletions = append(change.Deletions, existing)
+	}
+	if len(rrdatas) > 0 {
+		change.Additions = append(change.Additions, &ResourceRecordSet{Name: name, Type: t, Ttl: ttl, Rrdatas: rrdatas})
+	}
+}
+
+// remove adds to the given change the deletion of the records the controller
+// created for the given name, keeping the other TXT records.
+func (r *Records) remove(change *Change, records zoneRecords, name string) {
+	r.replace(change, records[name][typeA], name, typeA, nil)
+	r.replace(change, records[name][typeAAAA], name, typeAAAA, nil)
+	r.replace(change, records[name][typeTXT], name, typeTXT, records.otherTXT(name))
+}
+
+// apply applies the given change to the zone, unless it is empty.
+func (r *Records) apply(change *Change) error {
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return nil
+	}
+	var added, deleted []string
+	for _, rrset := range change.Additions {
+		added = append(added, rrset.Type+" "+rrset.Name)
+	}
+	for _, rrset := range change.Deletions {
+		deleted = append(deleted, rrset.Type+" "+rrset.Name)
+	}
+	logging.Infof("Changing DNS records of zone %v, adding %v, deleting %v", r.zone, added, deleted)
+	return r.zones.CreateChange(r.zone, change)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	testZone    = "zone"
+	testDnsName = "example.com."
+)
+
+func newTestRecords(owner string) (*Records, *FakeManagedZones) {
+	zones := NewFakeManagedZones(map[string]string{testZone: testDnsName})
+	return NewRecords(zones, testZone, owner, 5*time.Minute), zones
+}
+
+func rrdatas(zones *FakeManagedZones, name, t string) []string {
+	if rrset := zones.Get(testZone, name, t); rrset != nil {
+		return rrset.Rrdatas
+	}
+	return nil
+}
+
+func TestRecordsSync(t *testing.T) {
+	records, zones := newTestRecords("uid1")
+	if err := records.Sync("ns/ing", []string{"foo.example.com", "Bar.Example.com."}, []string{"1.2.3.4", "2600:1901::1"}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	for _, name := range []string{"foo.example.com.", "bar.example.com."} {
+		if got := rrdatas(zones, name, typeA); !reflect.DeepEqual(got, []string{"1.2.3.4"}) {
+			t.Errorf("Got A records %v for %v, want [1.2.3.4]", got, name)
+		}
+		if got := rrdatas(zones, name, typeAAAA); !reflect.DeepEqual(got, []string{"2600:1901::1"}) {
+			t.Errorf("Got AAAA records %v for %v, want [2600:1901::1]", got, name)
+		}
+		if got, want := rrdatas(zones, name, typeTXT), []string{`"heritage=ingress-gce,owner=uid1,ingress=ns/ing"`}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Got TXT records %v for %v, want %v", got, name, want)
+		}
+		if rrset := zones.Get(testZone, name, typeA); rrset.Ttl != 300 {
+			t.Errorf("Got TTL %v for %v, want 300", rrset.Ttl, name)
+		}
+	}
+
+	// An unchanged Ingress doesn't change the zone.
+	changes := zones.Changes
+	if err := records.Sync("ns/ing", []string{"foo.example.com", "bar.example.com"}, []string{"1.2.3.4", "2600:1901::1"}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if zones.Changes != changes {
+		t.Errorf("Expected no change of the zone, got %v", zones.Changes-changes)
+	}
+
+	// A new IP and a removed host.
+	if err := records.Sync("ns/ing", []string{"foo.example.com"}, []string{"5.6.7.8"}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if got := rrdatas(zones, "foo.example.com.", typeA); !reflect.DeepEqual(got, []string{"5.6.7.8"}) {
+		t.Errorf("Got A records %v, want [5.6.7.8]", got)
+	}
+	for _, rrset := range []*ResourceRecordSet{
+		zones.Get(testZone, "foo.example.com.", typeAAAA),
+		zones.Get(testZone, "bar.example.com.", typeA),
+		zones.Get(testZone, "bar.example.com.", typeTXT),
+	} {
+		if rrset != nil {
+			t.Errorf("Found unexpected record set %+v", rrset)
+		}
+	}
+}
+
+func TestRecordsOwnership(t *testing.T) {
+	records, zones := newTestRecords("uid1")
+	other := NewRecords(zones, testZone, "uid2", time.Minute)
+	zones.Records[testZone] = []*ResourceRecordSet{
+		{Name: "manual.example.com.", Type: typeA, Ttl: 60, Rrdatas: []string{"9.9.9.9"}},
+		{Name: "verified.example.com.", Type: typeTXT, Ttl: 60, Rrdatas: []string{`"google-site-verification=abc"`}},
+	}
+	if err := other.Sync("ns/ing", []string{"shared.example.com"}, []string{"1.1.1.1"}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+
+	err := records.Sync("ns/ing", []string{"manual.example.com", "shared.example.com", "foo.other.com", "verified.example.com"}, []string{"1.2.3.4"})
+	skipped, ok := err.(*SkippedHostsError)
+	if !ok {
+		t.Fatalf("Sync() = %v, want a SkippedHostsError", err)
+	}
+	if want := sets.NewString("manual.example.com", "shared.example.com", "foo.other.com"); !sets.StringKeySet(skipped.Reasons).Equal(want) {
+		t.Errorf("Got skipped hosts %v, want %v", skipped.Reasons, want.List())
+	}
+	if reason := skipped.Reasons["shared.example.com"]; !strings.Contains(reason, "uid2") {
+		t.Errorf("Expected the reason of the skipped host to name its owner, got %q", reason)
+	}
+	// The records of others are untouched.
+	if got := rrdatas(zones, "manual.example.com.", typeA); !reflect.DeepEqual(got, []string{"9.9.9.9"}) {
+		t.Errorf("Got A records %v, want [9.9.9.9]", got)
+	}
+	if got := rrdatas(zones, "shared.example.com.", typeA); !reflect.DeepEqual(got, []string{"1.1.1.1"}) {
+		t.Errorf("Got A records %v, want [1.1.1.1]", got)
+	}
+	// The other TXT records of a name are kept.
+	if got := rrdatas(zones, "verified.example.com.", typeTXT); len(got) != 2 || got[0] != `"google-site-verification=abc"` {
+		t.Errorf("Got TXT records %v, want the verification and the ownership", got)
+	}
+
+	// Only the records of the given owner are garbage collected.
+	if err := records.GC(sets.NewString()); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	if got := rrdatas(zones, "verified.example.com.", typeTXT); !reflect.DeepEqual(got, []string{`"google-site-verification=abc"`}) {
+		t.Errorf("Got TXT records %v after GC, want the verification only", got)
+	}
+	if rrset := zones.Get(testZone, "verified.example.com.", typeA); rrset != nil {
+		t.Errorf("Found unexpected record set %+v after GC", rrset)
+	}
+	if got := rrdatas(zones, "shared.example.com.", typeA); !reflect.DeepEqual(got, []string{"1.1.1.1"}) {
+		t.Errorf("Got A records %v after GC, want [1.1.1.1]", got)
+	}
+	if err := other.GC(sets.NewString("ns/ing")); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	if got := rrdatas(zones, "shared.example.com.", typeA); !reflect.DeepEqual(got, []string{"1.1.1.1"}) {
+		t.Errorf("Got A records %v after GC of an active Ingress, want [1.1.1.1]", got)
+	}
+}
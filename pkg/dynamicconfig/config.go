@@ -0,0 +1,130 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicconfig
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"k8s.io/ingress-gce/pkg/ratelimit"
+)
+
+const (
+	// FullSyncPeriodKey is the key of the period of the full syncs of the
+	// load balancers, eg: "5m".
+	FullSyncPeriodKey = "full-sync-period"
+	// FirewallSrcRangesKey is the key of the comma separated source ranges of
+	// the L7 firewall rules.
+	FirewallSrcRangesKey = "firewall-src-ranges"
+	// GCERateLimitsKey is the key of the rate limits of the GCE API groups,
+	// one <API group>,qps,<qps>,<burst> per line.
+	GCERateLimitsKey = "gce-ratelimits"
+	// EnableFinalizerKey is the key of the feature gate placing a finalizer
+	// on the Ingresses, "true" or "false".
+	EnableFinalizerKey = "enable-finalizer"
+	// ExcludeUnreadyNodesKey is the key of the feature gate removing the
+	// NotReady and cordoned nodes from the instance groups, "true" or
+	// "false".
+	ExcludeUnreadyNodesKey = "exclude-unready-nodes"
+)
+
+// Config is the configuration of the controller which can be changed at
+// runtime. Its defaults are the flags of the controller.
+type Config struct {
+	// FullSyncPeriod is how often all the components of the load balancers
+	// are synced.
+	FullSyncPeriod time.Duration
+	// FirewallSrcRanges are the source ranges allowed by the L7 firewall
+	// rules. Empty allows the GCE L7 source ranges.
+	FirewallSrcRanges []string
+	// GCERateLimits are the rate limits of the GCE API groups, formatted as
+	// <API group>,qps,<qps>,<burst>. A later limit of a group replaces the
+	// earlier ones.
+	GCERateLimits []string
+	// EnableFinalizer places a finalizer on the GCE Ingresses.
+	EnableFinalizer bool
+	// ExcludeUnreadyNodes removes the NotReady and cordoned nodes from the
+	// instance groups.
+	ExcludeUnreadyNodes bool
+}
+
+// Parse returns the configuration of the given ConfigMap data. The settings
+// the data doesn't set, or sets to invalid values, keep the given defaults,
+// the invalid values are returned as an error. The rate limits of the data
+// are appended to the default ones, so they replace the default limits of
+// their API groups only. Unknown keys are errors as well, they are likely
+// typos.
+func Parse(data map[string]string, defaults Config) (Config, error) {
+	config := defaults
+	var errs []error
+	for key, val := range data {
+		var err error
+		switch key {
+		case FullSyncPeriodKey:
+			var period time.Duration
+			if period, err = time.ParseDuration(val); err == nil && period < 0 {
+				err = fmt.Errorf("negative period")
+			}
+			if err == nil {
+				config.FullSyncPeriod = period
+			}
+		case FirewallSrcRangesKey:
+			var ranges []string
+			for _, r := range strings.Split(val, ",") {
+				if r = strings.TrimSpace(r); r == "" {
+					continue
+				}
+				if _, _, err = net.ParseCIDR(r); err != nil {
+					break
+				}
+				ranges = append(ranges, r)
+			}
+			if err == nil {
+				config.FirewallSrcRanges = ranges
+			}
+		case GCERateLimitsKey:
+			limits := strings.Fields(val)
+			if _, err = ratelimit.ParseRateLimits(limits); err == nil {
+				config.GCERateLimits = append(append([]string{}, defaults.GCERateLimits...), limits...)
+			}
+		case EnableFinalizerKey:
+			config.EnableFinalizer, err = parseBool(val, defaults.EnableFinalizer)
+		case ExcludeUnreadyNodesKey:
+			config.ExcludeUnreadyNodes, err = parseBool(val, defaults.ExcludeUnreadyNodes)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %v %q: %v", key, val, err))
+		}
+	}
+	return config, utilerrors.NewAggregate(errs)
+}
+
+// parseBool parses the given feature gate, returns the default if invalid.
+func parseBool(val string, def bool) (bool, error) {
+	v, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		return def, err
+	}
+	return v, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicconfig
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+)
+
+func TestParse(t *testing.T) {
+	defaults := Config{
+		FullSyncPeriod:    5 * time.Minute,
+		FirewallSrcRanges: []string{"10.0.0.0/8"},
+		GCERateLimits:     []string{"compute.backendServices,qps,5,10"},
+		EnableFinalizer:   true,
+	}
+	for _, tc := range []struct {
+		desc    string
+		data    map[string]string
+		want    Config
+		wantErr bool
+	}{
+		{
+			desc: "no settings",
+			want: defaults,
+		},
+		{
+			desc: "all settings",
+			data: map[string]string{
+				FullSyncPeriodKey:      "1m",
+				FirewallSrcRangesKey:   "10.1.0.0/16, 10.2.0.0/16",
+				GCERateLimitsKey:       "compute.backendServices,qps,1,1\ncompute.firewalls,qps,2,2",
+				EnableFinalizerKey:     "false",
+				ExcludeUnreadyNodesKey: "true",
+			},
+			want: Config{
+				FullSyncPeriod:      time.Minute,
+				FirewallSrcRanges:   []string{"10.1.0.0/16", "10.2.0.0/16"},
+				GCERateLimits:       []string{"compute.backendServices,qps,5,10", "compute.backendServices,qps,1,1", "compute.firewalls,qps,2,2"},
+				ExcludeUnreadyNodes: true,
+			},
+		},
+		{
+			desc: "invalid settings keep the defaults",
+			data: map[string]string{
+				FullSyncPeriodKey:      "soon",
+				FirewallSrcRangesKey:   "10.1.0.0/16,10.2.0.0/33",
+				GCERateLimitsKey:       "compute.firewalls,qps,-1,1",
+				EnableFinalizerKey:     "maybe",
+				ExcludeUnreadyNodesKey: "true",
+			},
+			want: Config{
+				FullSyncPeriod:      5 * time.Minute,
+				FirewallSrcRanges:   []string{"10.0.0.0/8"},
+				GCERateLimits:       []string{"compute.backendServices,qps,5,10"},
+				EnableFinalizer:     true,
+				ExcludeUnreadyNodes: true,
+			},
+			wantErr: true,
+		},
+		{
+			desc:    "unknown setting",
+			data:    map[string]string{"full-sync-periods": "1m"},
+			want:    defaults,
+			wantErr: true,
+		},
+	} {
+		got, err := Parse(tc.data, defaults)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%v: Parse() = %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: Parse() = %+v, want %+v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestWatcherSync(t *testing.T) {
+	defaults := Config{FullSyncPeriod: 5 * time.Minute}
+	var applied []Config
+	w := &Watcher{namespace: "kube-system", name: "glbc", defaults: defaults, apply: func(c Config) {
+		applied = append(applied, c)
+	}}
+
+	cm := &api_v1.ConfigMap{Data: map[string]string{FullSyncPeriodKey: "1m"}}
+	w.sync(cm)
+	w.sync(cm)
+	if len(applied) != 1 || applied[0].FullSyncPeriod != time.Minute {
+		t.Fatalf("Expected the configuration to be applied once, got %+v", applied)
+	}
+	// Settings which don't change the configuration are not applied.
+	w.sync(&api_v1.ConfigMap{Data: map[string]string{FullSyncPeriodKey: "60s"}})
+	if len(applied) != 1 {
+		t.Fatalf("Expected an unchanged configuration not to be applied, got %+v", applied)
+	}
+	// Deleting the ConfigMap restores the defaults.
+	w.sync(nil)
+	if len(applied) != 2 || !reflect.DeepEqual(applied[1], defaults) {
+		t.Fatalf("Expected the defaults to be applied, got %+v", applied)
+	}
+}
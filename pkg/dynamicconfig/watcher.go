@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamicconfig
+
+import (
+	"reflect"
+	"sync"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// Watcher watches the ConfigMap of the controller and applies its
+// configuration whenever it changes.
+type Watcher struct {
+	namespace string
+	name      string
+	defaults  Config
+	apply     func(Config)
+	informer  cache.Controller
+
+	// lock serializes the applies, and protects current.
+	lock sync.Mutex
+	// current is the configuration applied last, nil before the first
+	// apply.
+	current *Config
+}
+
+// NewWatcher returns a Watcher of the ConfigMap with the given namespace and
+// name.
+//   - defaults: is the configuration applied when the ConfigMap doesn't exist,
+//     and the defaults of the settings it doesn't set.
+//   - apply: applies the configuration, called on the first sync and whenever
+//     the configuration changes.
+func NewWatcher(client kubernetes.Interface, namespace, name string, defaults Config, apply func(Config)) *Watcher {
+	w := &Watcher{namespace: namespace, name: name, defaults: defaults, apply: apply}
+	lw := cache.NewListWatchFromClient(client.Core().RESTClient(), "configmaps", namespace, fields.OneTermEqualSelector("metadata.name", name))
+	_, w.informer = cache.NewInformer(lw, &api_v1.ConfigMap{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.sync(obj.(*api_v1.ConfigMap))
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			w.sync(cur.(*api_v1.ConfigMap))
+		},
+		DeleteFunc: func(obj interface{}) {
+			w.sync(nil)
+		},
+	})
+	return w
+}
+
+// Run watches the ConfigMap until the given channel is closed. The defaults
+// are applied if the ConfigMap doesn't exist once the watch has synced.
+// Blocks until the channel is closed.
+func (w *Watcher) Run(stopCh <-chan struct{}) {
+	logging.Infof("Watching ConfigMap %v/%v for configuration changes", w.namespace, w.name)
+	go w.informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, w.informer.HasSynced) {
+		return
+	}
+	w.lock.Lock()
+	applied := w.current != nil
+	w.lock.Unlock()
+	if !applied {
+		w.sync(nil)
+	}
+	<-stopCh
+}
+
+// sync applies the configuration of the given ConfigMap, or the defaults if
+// nil, if it changed since the last apply.
+func (w *Watcher) sync(cm *api_v1.ConfigMap) {
+	config := w.defaults
+	if cm != nil {
+		var err error
+		if config, err = Parse(cm.Data, w.defaults); err != nil {
+			logging.Warningf("Ignoring invalid settings of ConfigMap %v/%v: %v", w.namespace, w.name, err)
+		}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.current != nil && reflect.DeepEqual(*w.current, config) {
+		return
+	}
+	logging.Infof("Applying configuration %+v", config)
+	w.apply(config)
+	w.current = &config
+}
@@ -18,14 +18,13 @@ package firewalls
 
 import (
 	"fmt"
-
-	compute "google.golang.org/api/compute/v1"
+	"strings"
 
 	"k8s.io/ingress-gce/pkg/utils"
 )
 
 type fakeFirewallsProvider struct {
-	fw               map[string]*compute.Firewall
+	fw               map[string]*FirewallRule
 	networkProjectID string
 	networkURL       string
 	onXPN            bool
@@ -35,7 +34,7 @@ type fakeFirewallsProvider struct {
 // NewFakeFirewallsProvider creates a fake for firewall rules.
 func NewFakeFirewallsProvider(onXPN bool, fwReadOnly bool) *fakeFirewallsProvider {
 	return &fakeFirewallsProvider{
-		fw:               make(map[string]*compute.Firewall),
+		fw:               make(map[string]*FirewallRule),
 		networkProjectID: "test-network-project",
 		networkURL:       "/path/to/my-network",
 		onXPN:            onXPN,
@@ -43,7 +42,7 @@ func NewFakeFirewallsProvider(onXPN bool, fwReadOnly bool) *fakeFirewallsProvide
 	}
 }
 
-func (ff *fakeFirewallsProvider) GetFirewall(name string) (*compute.Firewall, error) {
+func (ff *fakeFirewallsProvider) GetFirewall(name string) (*FirewallRule, error) {
 	rule, exists := ff.fw[name]
 	if exists {
 		return rule, nil
@@ -51,7 +50,17 @@ func (ff *fakeFirewallsProvider) GetFirewall(name string) (*compute.Firewall, er
 	return nil, utils.FakeGoogleAPINotFoundErr()
 }
 
-func (ff *fakeFirewallsProvider) doCreateFirewall(f *compute.Firewall) error {
+func (ff *fakeFirewallsProvider) ListFirewalls(prefix string) ([]*FirewallRule, error) {
+	var rules []*FirewallRule
+	for name, rule := range ff.fw {
+		if strings.HasPrefix(name, prefix) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (ff *fakeFirewallsProvider) doCreateFirewall(f *FirewallRule) error {
 	if _, exists := ff.fw[f.Name]; exists {
 		return fmt.Errorf("firewall rule %v already exists", f.Name)
 	}
@@ -59,7 +68,7 @@ func (ff *fakeFirewallsProvider) doCreateFirewall(f *compute.Firewall) error {
 	return nil
 }
 
-func (ff *fakeFirewallsProvider) CreateFirewall(f *compute.Firewall) error {
+func (ff *fakeFirewallsProvider) CreateFirewall(f *FirewallRule) error {
 	if ff.fwReadOnly {
 		return utils.FakeGoogleAPIForbiddenErr()
 	}
@@ -86,7 +95,7 @@ func (ff *fakeFirewallsProvider) DeleteFirewall(name string) error {
 	return ff.doDeleteFirewall(name)
 }
 
-func (ff *fakeFirewallsProvider) doUpdateFirewall(f *compute.Firewall) error {
+func (ff *fakeFirewallsProvider) doUpdateFirewall(f *FirewallRule) error {
 	// We need the full name for the same reason as CreateFirewall.
 	_, exists := ff.fw[f.Name]
 	if !exists {
@@ -97,7 +106,7 @@ func (ff *fakeFirewallsProvider) doUpdateFirewall(f *compute.Firewall) error {
 	return nil
 }
 
-func (ff *fakeFirewallsProvider) UpdateFirewall(f *compute.Firewall) error {
+func (ff *fakeFirewallsProvider) UpdateFirewall(f *FirewallRule) error {
 	if ff.fwReadOnly {
 		return utils.FakeGoogleAPIForbiddenErr()
 	}
@@ -17,173 +17,880 @@ limitations under the License.
 package firewalls
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"net"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
-	"github.com/golang/glog"
-
-	compute "google.golang.org/api/compute/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
-	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
 	netset "k8s.io/kubernetes/pkg/util/net/sets"
 
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/ingress-gce/pkg/utils"
 )
 
+const (
+	// maxPortsPerRule is the maximum number of ports and port ranges GCE
+	// allows in a single firewall rule.
+	maxPortsPerRule = 100
+	// maxRuleNameLen is the maximum length of a firewall rule name.
+	maxRuleNameLen = 63
+	// computeAPIPrefix is the prefix of compute resource URLs.
+	computeAPIPrefix = "https://www.googleapis.com/compute/v1/"
+)
+
 // Src ranges from which the GCE L7 performs health checks.
 var l7SrcRanges = []string{"130.211.0.0/22", "35.191.0.0/16"}
 
+// HealthCheckSrcRanges returns the source ranges of the GCE health checks,
+// which also probe the nodes behind the L4 load balancers.
+func HealthCheckSrcRanges() []string {
+	return append([]string(nil), l7SrcRanges...)
+}
+
+// netLBSrcRanges are the source ranges of the health checks of the external
+// network load balancers.
+var netLBSrcRanges = []string{"35.191.0.0/16", "209.85.152.0/22", "209.85.204.0/22"}
+
+// NetLBHealthCheckSrcRanges returns the source ranges of the GCE health
+// checks of the external network load balancers.
+func NetLBHealthCheckSrcRanges() []string {
+	return append([]string(nil), netLBSrcRanges...)
+}
+
+// IPv6 src ranges from which the GCE L7 performs health checks and proxies
+// traffic on dual-stack clusters.
+var l7SrcRangesIPv6 = []string{"2600:2d00:1:1::/64", "2600:2d00:1:b029::/64"}
+
 // FirewallRules manages firewall rules.
 type FirewallRules struct {
 	cloud     Firewall
 	namer     *utils.Namer
 	srcRanges []string
+	// defaultSrcRanges are the source ranges the pool was configured with,
+	// restored by SetSrcRanges.
+	defaultSrcRanges []string
+	// targetServiceAccounts, if set, are used to select the instances the
+	// rule applies to instead of node tags.
+	targetServiceAccounts []string
+	// windowsNodeTags, if set, are the node tags of the Windows nodes, whose
+	// tags can't be derived from their instance names.
+	windowsNodeTags []string
+	// nodeOSLister tells the Windows nodes apart. Nil until Init.
+	nodeOSLister nodeOSLister
+	// enableLogging enables firewall rules logging on the rules.
+	enableLogging bool
+	// excludeLoggingMetadata excludes the metadata from the logs.
+	excludeLoggingMetadata bool
+	// dryRun disables all firewall mutations, they are only logged and
+	// reported.
+	dryRun bool
+
+	// lock protects lastSync, and serializes Sync with RepairDrift.
+	lock sync.Mutex
+	// lastSync is the request of the last Sync, the rules are repaired to
+	// match it when they drift. Nil if the rules were shut down.
+	lastSync *syncRequest
+	// networks are the URLs of the additional networks the pool created
+	// rules on, used to delete the rules once a network is no longer
+	// requested.
+	networks sets.String
+	// networksListed is true once the networks of the rules created before
+	// the controller started were added to networks.
+	networksListed bool
+}
+
+// syncRequest holds the arguments of a Sync.
+type syncRequest struct {
+	nodePorts        []int64
+	negPorts         []int64
+	nodeNames        []string
+	additionalRanges []string
+	// networks are the URLs of the additional networks.
+	networks []string
+}
+
+// PoolOptions configures the firewall rules managed by a pool.
+type PoolOptions struct {
+	// SrcRanges are the source ranges allowed by the rules. If empty, the
+	// GCE L7 source ranges are used. IPv4 and IPv6 ranges are allowed by
+	// separate rules.
+	SrcRanges []string
+	// TargetServiceAccounts are the service accounts of the nodes. If set,
+	// the rules target these service accounts instead of the node tags.
+	TargetServiceAccounts []string
+	// WindowsNodeTags are the node tags of the Windows nodes. If set, the
+	// rules target these tags for the Windows nodes instead of the tags
+	// derived from their instance names.
+	WindowsNodeTags []string
+	// Manage is false if the firewall rules are managed outside of the
+	// controller, the pool then only logs what it would do.
+	Manage bool
+	// DualStack allows the GCE L7 IPv6 source ranges as well, if SrcRanges
+	// is empty.
+	DualStack bool
+	// EnableLogging enables firewall rules logging on the rules.
+	EnableLogging bool
+	// ExcludeLoggingMetadata excludes the metadata, eg: the instance and VPC
+	// details, from the logs of the rules.
+	ExcludeLoggingMetadata bool
+	// DryRun disables all the creations, updates and deletions of rules. The
+	// changes are logged with their diff against the live rules, and
+	// returned as FirewallSyncErrors instead.
+	DryRun bool
 }
 
 // NewFirewallPool creates a new firewall rule manager.
 // cloud: the cloud object implementing Firewall.
 // namer: cluster namer.
-func NewFirewallPool(cloud Firewall, namer *utils.Namer) SingleFirewallPool {
-	_, err := netset.ParseIPNets(l7SrcRanges...)
+// opts: the options of the rules.
+func NewFirewallPool(cloud Firewall, namer *utils.Namer, opts PoolOptions) SingleFirewallPool {
+	if !opts.Manage {
+		logging.Infof("Firewall management is disabled, firewall rules need to be managed externally")
+		return &noOpFirewallPool{namer: namer}
+	}
+	srcRanges := opts.SrcRanges
+	if len(srcRanges) == 0 {
+		srcRanges = l7SrcRanges
+		if opts.DualStack {
+			srcRanges = append(append([]string{}, l7SrcRanges...), l7SrcRangesIPv6...)
+		}
+	}
+	_, err := netset.ParseIPNets(srcRanges...)
 	if err != nil {
-		glog.Fatalf("Could not parse L7 src ranges %v for firewall rule: %v", l7SrcRanges, err)
+		logging.Fatalf("Could not parse L7 src ranges %v for firewall rule: %v", srcRanges, err)
+	}
+	return &FirewallRules{
+		cloud:                  cloud,
+		namer:                  namer,
+		srcRanges:              srcRanges,
+		defaultSrcRanges:       srcRanges,
+		targetServiceAccounts:  sets.NewString(opts.TargetServiceAccounts...).List(),
+		windowsNodeTags:        opts.WindowsNodeTags,
+		enableLogging:          opts.EnableLogging,
+		excludeLoggingMetadata: opts.ExcludeLoggingMetadata,
+		dryRun:                 opts.DryRun,
+		networks:               sets.NewString(),
 	}
-	return &FirewallRules{cloud: cloud, namer: namer, srcRanges: l7SrcRanges}
 }
 
 // Sync sync firewall rules with the cloud.
-func (fr *FirewallRules) Sync(nodePorts []int64, nodeNames []string) error {
-	if len(nodePorts) == 0 {
-		return fr.Shutdown()
+// nodePorts are opened by the L7 rule, negPorts are the pod ports of NEG
+// backends and are opened by a separate rule. Each of them is paired with an
+// IPv6 rule if IPv6 source ranges are allowed.
+// additionalRanges are source ranges requested by individual Ingresses, they
+// are allowed in addition to the ranges the pool was configured with.
+// additionalNetworks are networks, other than the cluster network, requested
+// by individual Ingresses. The same rules are created on each of them. They
+// are either network names in the project of the cluster network, or network
+// URLs.
+func (fr *FirewallRules) Sync(nodePorts []int64, negPorts []int64, nodeNames []string, additionalRanges []string, additionalNetworks []string) error {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.lastSync = &syncRequest{
+		nodePorts:        nodePorts,
+		negPorts:         negPorts,
+		nodeNames:        nodeNames,
+		additionalRanges: additionalRanges,
+		networks:         fr.resolveNetworks(additionalNetworks),
+	}
+	fr.listNetworks()
+
+	// Sync all rules even if one fails, so that the errors (and required XPN
+	// changes) of all are surfaced.
+	var errs []error
+	for _, r := range fr.rules(fr.lastSync) {
+		set := ruleSet(r)
+		for _, numbered := range set {
+			errs = append(errs, fr.syncRule(numbered.name, numbered.description, numbered.network, numbered.ports, nodeNames, numbered.srcRanges))
+		}
+		errs = append(errs, fr.deleteUnusedRules(r.name, len(set)))
+	}
+
+	// Delete the rules of networks which are no longer requested.
+	networks := sets.NewString(fr.lastSync.networks...)
+	for _, network := range fr.networks.Difference(networks).List() {
+		if err := fr.deleteNetworkRules(network); err != nil {
+			// Retry on the next sync.
+			networks.Insert(network)
+			errs = append(errs, err)
+		}
+	}
+	fr.networks = networks
+	return firstError(errs)
+}
+
+// listNetworks adds the additional networks of the existing rules of the pool
+// to the networks of the pool, the first time it succeeds. This lets Sync and
+// Shutdown delete the rules on networks which stopped being requested while
+// the controller was down.
+func (fr *FirewallRules) listNetworks() {
+	if fr.networksListed {
+		return
+	}
+	rules, err := fr.cloud.ListFirewalls(fr.namer.Prefix() + "-fw-")
+	if err != nil {
+		logging.Warningf("Failed to list the firewall rules, retrying on the next sync: %v", err)
+		return
+	}
+	clusterNetwork := fr.cloud.NetworkURL()
+	for _, rule := range rules {
+		if rule.Network == "" || rule.Network == clusterNetwork || fr.networks.Has(rule.Network) {
+			continue
+		}
+		if fr.ownsNetworkRule(rule.Name, rule.Network) {
+			logging.V(3).Infof("Found firewall %v of the pool on network %v", rule.Name, rule.Network)
+			fr.networks.Insert(rule.Network)
+		}
+	}
+	fr.networksListed = true
+}
+
+// ownsNetworkRule returns true if the rule with the given name is one of the
+// rules of the pool on the given network.
+func (fr *FirewallRules) ownsNetworkRule(name, network string) bool {
+	for _, r := range fr.networkRules(&syncRequest{}, network) {
+		if name == r.name {
+			return true
+		}
+		// The numbered rules of the rule set.
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			if n, err := strconv.Atoi(name[i+1:]); err == nil && n > 0 && numberedRuleName(r.name, n) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deleteNetworkRules deletes all the rules of the pool on the given network.
+// An empty network is the cluster network.
+func (fr *FirewallRules) deleteNetworkRules(network string) error {
+	var errs []error
+	for _, r := range fr.networkRules(&syncRequest{}, network) {
+		errs = append(errs, fr.syncRule(r.name, "", r.network, nil, nil, nil))
+		errs = append(errs, fr.deleteUnusedRules(r.name, 1))
+	}
+	return firstError(errs)
+}
+
+// resolveNetworks returns the sorted URLs of the given networks, without the
+// cluster network. Networks specified by name are assumed to be in the
+// project of the cluster network, i.e the host project on Shared VPC.
+func (fr *FirewallRules) resolveNetworks(networks []string) []string {
+	clusterNetwork := fr.cloud.NetworkURL()
+	urls := sets.NewString()
+	for _, n := range networks {
+		var url string
+		switch {
+		case strings.HasPrefix(n, "https://"):
+			url = n
+		case strings.HasPrefix(n, "projects/"):
+			url = computeAPIPrefix + n
+		case strings.Contains(clusterNetwork, "/"):
+			url = clusterNetwork[:strings.LastIndex(clusterNetwork, "/")+1] + n
+		default:
+			logging.Warningf("Ignoring network %q, can't resolve it relative to the cluster network %q", n, clusterNetwork)
+			continue
+		}
+		// Firewall rules are created in the project of the cluster network.
+		if project := projectFromLink(url); project != "" && project != fr.cloud.NetworkProjectID() {
+			logging.Warningf("Ignoring network %q, it is not in the project %q of the cluster network", n, fr.cloud.NetworkProjectID())
+			continue
+		}
+		if url != clusterNetwork {
+			urls.Insert(url)
+		}
+	}
+	return urls.List()
+}
+
+// RepairDrift re-reads the firewall rules from the cloud and repairs the
+// ones which drifted from what was requested by the last Sync, eg: because
+// they were edited or deleted manually. It returns a description of each
+// repair.
+func (fr *FirewallRules) RepairDrift() ([]string, error) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	if fr.lastSync == nil {
+		return nil, nil
+	}
+
+	var repairs []string
+	var required []ruleSpec
+	for _, r := range fr.rules(fr.lastSync) {
+		required = append(required, ruleSet(r)...)
+	}
+	for _, r := range required {
+		if len(r.ports) == 0 {
+			continue
+		}
+		rule, err := fr.cloud.GetFirewall(r.name)
+		if err != nil && !utils.IsNotFoundError(err) {
+			return repairs, err
+		}
+		firewall, err := fr.createFirewallObject(r.name, r.description, r.network, r.ports, fr.lastSync.nodeNames, r.srcRanges)
+		if err != nil {
+			return repairs, err
+		}
+		if rule == nil {
+			logging.ForResource(r.name).WithOperation("create").Infof("Firewall was deleted, recreating it")
+			if err := fr.createFirewall(firewall); isDryRunError(err) {
+				repairs = append(repairs, err.Error())
+				continue
+			} else if err != nil {
+				return repairs, err
+			}
+			repairs = append(repairs, fmt.Sprintf("Recreated deleted firewall %v", r.name))
+			continue
+		}
+		diff := fr.firewallDiff(rule, firewall)
+		if len(diff) == 0 {
+			continue
+		}
+		logging.ForResource(r.name).WithOperation("update").Infof("Firewall drifted (%v), repairing it", strings.Join(diff, ", "))
+		if err := fr.updateFirewall(firewall); isDryRunError(err) {
+			repairs = append(repairs, err.Error())
+			continue
+		} else if err != nil {
+			return repairs, err
+		}
+		repairs = append(repairs, fmt.Sprintf("Repaired drifted firewall %v: %v", r.name, strings.Join(diff, ", ")))
+	}
+	return repairs, nil
+}
+
+// ruleSpec is a firewall rule managed by the pool.
+type ruleSpec struct {
+	name        string
+	description string
+	// network is the URL of the network of the rule, empty for the cluster
+	// network.
+	network   string
+	ports     []int64
+	srcRanges []string
+}
+
+// rules returns the firewall rules managed by the pool on the cluster network
+// and on the networks of the given sync request.
+func (fr *FirewallRules) rules(req *syncRequest) []ruleSpec {
+	rules := fr.networkRules(req, "")
+	for _, network := range req.networks {
+		rules = append(rules, fr.networkRules(req, network)...)
+	}
+	return rules
+}
+
+// networkRules returns the firewall rules managed by the pool on the given
+// network, with the ports and source ranges required by the given sync
+// request. Rules which are not required have no ports. An empty network is
+// the cluster network.
+func (fr *FirewallRules) networkRules(req *syncRequest, network string) []ruleSpec {
+	v4Ranges, v6Ranges := splitByFamily(fr.effectiveSrcRanges(req.additionalRanges))
+	// GCE firewall rules can't mix IPv4 and IPv6 source ranges.
+	rules := []ruleSpec{
+		{name: fr.namer.FirewallRule(), description: "GCE L7 firewall rule", ports: req.nodePorts, srcRanges: v4Ranges},
+		{name: fr.namer.NEGFirewallRule(), description: "GCE L7 firewall rule for network endpoint groups", ports: req.negPorts, srcRanges: v4Ranges},
+		{name: fr.namer.IPv6FirewallRule(), description: "GCE L7 IPv6 firewall rule", ports: req.nodePorts, srcRanges: v6Ranges},
+		{name: fr.namer.IPv6NEGFirewallRule(), description: "GCE L7 IPv6 firewall rule for network endpoint groups", ports: req.negPorts, srcRanges: v6Ranges},
+	}
+	for i := range rules {
+		if len(rules[i].srcRanges) == 0 {
+			rules[i].ports = nil
+		}
+		if network != "" {
+			// Firewall rule names are unique per project, not per network.
+			rules[i].name = networkRuleName(rules[i].name, network)
+			rules[i].description = fmt.Sprintf("%v on network %v", rules[i].description, getNameFromLink(network))
+			rules[i].network = network
+		}
+	}
+	return rules
+}
+
+// projectFromLink returns the project of the given resource URL, or an empty
+// string if it has none.
+func projectFromLink(link string) string {
+	parts := strings.Split(link, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "projects" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// networkRuleName returns the name of the given rule on the given network.
+func networkRuleName(name, network string) string {
+	suffix := fmt.Sprintf("-%x", sha256.Sum256([]byte(network)))[:9]
+	if len(name)+len(suffix) > maxRuleNameLen {
+		name = name[:maxRuleNameLen-len(suffix)]
+	}
+	return name + suffix
+}
+
+// ruleSet splits the ports of the given rule across numbered rules, so that
+// each of them is within the GCE limit of ports per rule. The first rule keeps
+// the name of the given rule. A single rule without ports is returned if the
+// given rule has no ports.
+func ruleSet(r ruleSpec) []ruleSpec {
+	chunks := splitPorts(r.ports, maxPortsPerRule)
+	if len(chunks) == 0 {
+		return []ruleSpec{r}
+	}
+	var set []ruleSpec
+	for i, ports := range chunks {
+		set = append(set, ruleSpec{
+			name:        numberedRuleName(r.name, i),
+			description: r.description,
+			network:     r.network,
+			ports:       ports,
+			srcRanges:   r.srcRanges,
+		})
+	}
+	return set
+}
+
+// numberedRuleName returns the name of the i-th rule of a rule set.
+func numberedRuleName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	suffix := fmt.Sprintf("-%d", i)
+	if len(name)+len(suffix) > maxRuleNameLen {
+		name = name[:maxRuleNameLen-len(suffix)]
+	}
+	return name + suffix
+}
+
+// deleteUnusedRules deletes the numbered rules of the rule set with the given
+// name, starting from the given index. The numbered rules are contiguous, so
+// this stops at the first rule which doesn't exist.
+func (fr *FirewallRules) deleteUnusedRules(name string, from int) error {
+	var errs []error
+	for i := from; ; i++ {
+		numbered := numberedRuleName(name, i)
+		if rule, _ := fr.cloud.GetFirewall(numbered); rule == nil {
+			break
+		}
+		logging.ForResource(numbered).WithOperation("delete").Infof("Deleting unused firewall")
+		errs = append(errs, fr.deleteFirewall(numbered))
+	}
+	return firstError(errs)
+}
+
+// splitPorts sorts the given ports and splits them into chunks which each
+// collapse into at most max ports and port ranges.
+func splitPorts(ports []int64, max int) [][]int64 {
+	sorted := append([]int64{}, ports...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var chunks [][]int64
+	var chunk []int64
+	ranges := 0
+	for i, p := range sorted {
+		if i > 0 && p == sorted[i-1] {
+			continue
+		}
+		// A port which doesn't extend the last range starts a new one.
+		if len(chunk) == 0 || p != chunk[len(chunk)-1]+1 {
+			if ranges == max {
+				chunks = append(chunks, chunk)
+				chunk, ranges = nil, 0
+			}
+			ranges++
+		}
+		chunk = append(chunk, p)
 	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// portRanges collapses the given ports into sorted ports and port ranges, eg:
+// [80, 30000, 30001, 30002] becomes ["80", "30000-30002"].
+func portRanges(ports []int64) []string {
+	var ranges []string
+	for _, chunk := range splitPorts(ports, 1) {
+		first, last := chunk[0], chunk[len(chunk)-1]
+		if first == last {
+			ranges = append(ranges, strconv.FormatInt(first, 10))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", first, last))
+		}
+	}
+	return ranges
+}
+
+// firstError returns the first non nil error of the given errors.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitByFamily splits the given source ranges into IPv4 and IPv6 ranges.
+func splitByFamily(srcRanges []string) ([]string, []string) {
+	var v4Ranges, v6Ranges []string
+	for _, r := range srcRanges {
+		if ip, _, err := net.ParseCIDR(r); err == nil && ip.To4() == nil {
+			v6Ranges = append(v6Ranges, r)
+		} else {
+			v4Ranges = append(v4Ranges, r)
+		}
+	}
+	return v4Ranges, v6Ranges
+}
+
+// syncRule syncs the firewall rule with the given name so that it opens the
+// given ports. The rule is deleted if no ports are given.
+func (fr *FirewallRules) syncRule(name, description, network string, ports []int64, nodeNames []string, srcRanges []string) error {
 	// TODO: Fix upstream gce cloudprovider lib so GET also takes the suffix
 	// instead of the whole name.
-	name := fr.namer.FirewallRule()
 	rule, _ := fr.cloud.GetFirewall(name)
+	if len(ports) == 0 {
+		if rule == nil {
+			return nil
+		}
+		logging.ForResource(name).WithOperation("delete").Infof("Deleting firewall")
+		return fr.deleteFirewall(name)
+	}
 
-	firewall, err := fr.createFirewallObject(name, "GCE L7 firewall rule", nodePorts, nodeNames)
+	firewall, err := fr.createFirewallObject(name, description, network, ports, nodeNames, srcRanges)
 	if err != nil {
 		return err
 	}
 
 	if rule == nil {
-		glog.Infof("Creating global l7 firewall rule %v", name)
+		logging.ForResource(name).WithOperation("create").Infof("Creating global l7 firewall rule")
 		return fr.createFirewall(firewall)
 	}
 
-	requiredPorts := sets.NewString()
-	for _, p := range nodePorts {
-		requiredPorts.Insert(strconv.Itoa(int(p)))
+	// Do not update if ports, source cidrs, targets and logging are not outdated.
+	if len(fr.firewallDiff(rule, firewall)) == 0 {
+		logging.V(4).Infof("Firewall %v does not need update of ports, source ranges, targets or logging", name)
+		return nil
 	}
-	existingPorts := sets.NewString()
-	for _, allowed := range rule.Allowed {
-		for _, p := range allowed.Ports {
-			existingPorts.Insert(p)
+	logging.ForResource(name).WithOperation("update").V(3).Infof("Firewall already exists, updating ports %v, source ranges %v and target service accounts %v", ports, srcRanges, fr.targetServiceAccounts)
+	return fr.updateFirewall(firewall)
+}
+
+// firewallDiff describes how the existing rule of the pool differs from the
+// desired one. It is the comparison used by both Sync and RepairDrift.
+// NOTE: We are not checking if nodeNames matches the firewall targetTags,
+// unless the Windows nodes, which may join or leave, have their own tags.
+func (fr *FirewallRules) firewallDiff(existing, desired *FirewallRule) []string {
+	return firewallDiff(existing, desired, len(fr.windowsNodeTags) > 0)
+}
+
+// firewallDiff describes how the existing rule differs from the desired one.
+// Target tags are only compared when the desired rule targets service
+// accounts, unless checkTags is true.
+func firewallDiff(existing, desired *FirewallRule, checkTags bool) []string {
+	var diff []string
+	if e, d := allowedPorts(existing), allowedPorts(desired); !e.Equal(d) {
+		diff = append(diff, fmt.Sprintf("ports %v, want %v", e.List(), d.List()))
+	}
+	if e, d := sets.NewString(existing.SourceRanges...), sets.NewString(desired.SourceRanges...); !e.Equal(d) {
+		diff = append(diff, fmt.Sprintf("source ranges %v, want %v", e.List(), d.List()))
+	}
+	if e, d := sets.NewString(existing.TargetServiceAccounts...), sets.NewString(desired.TargetServiceAccounts...); !e.Equal(d) {
+		diff = append(diff, fmt.Sprintf("target service accounts %v, want %v", e.List(), d.List()))
+	}
+	if e, d := loggingEnabled(existing), loggingEnabled(desired); e != d {
+		diff = append(diff, fmt.Sprintf("logging %v, want %v", e, d))
+	}
+	if e, d := loggingMetadata(existing), loggingMetadata(desired); e != d && loggingEnabled(desired) {
+		diff = append(diff, fmt.Sprintf("logging metadata %v, want %v", e, d))
+	}
+	if checkTags || len(desired.TargetServiceAccounts) > 0 {
+		if e, d := sets.NewString(existing.TargetTags...), sets.NewString(desired.TargetTags...); !e.Equal(d) {
+			diff = append(diff, fmt.Sprintf("target tags %v, want %v", e.List(), d.List()))
 		}
 	}
+	return diff
+}
 
-	requiredCIDRs := sets.NewString(l7SrcRanges...)
-	existingCIDRs := sets.NewString(rule.SourceRanges...)
+// allowedPorts returns the ports allowed by the given rule.
+func allowedPorts(rule *FirewallRule) sets.String {
+	ports := sets.NewString()
+	for _, allowed := range rule.Allowed {
+		ports.Insert(allowed.Ports...)
+	}
+	return ports
+}
 
-	// Do not update if ports and source cidrs are not outdated.
-	// NOTE: We are not checking if nodeNames matches the firewall targetTags
-	if requiredPorts.Equal(existingPorts) && requiredCIDRs.Equal(existingCIDRs) {
-		glog.V(4).Info("Firewall does not need update of ports or source ranges")
-		return nil
+// effectiveSrcRanges returns the sorted union of the configured source ranges
+// and the given additional ranges. Ranges which fail to parse are dropped.
+func (fr *FirewallRules) effectiveSrcRanges(additionalRanges []string) []string {
+	ranges := sets.NewString(fr.srcRanges...)
+	for _, r := range additionalRanges {
+		if _, err := netset.ParseIPNets(r); err != nil {
+			logging.Warningf("Ignoring invalid firewall source range %q: %v", r, err)
+			continue
+		}
+		ranges.Insert(r)
 	}
-	glog.V(3).Infof("Firewall %v already exists, updating nodeports %v", name, nodePorts)
-	return fr.updateFirewall(firewall)
+	return ranges.List()
+}
+
+// SetSrcRanges replaces the source ranges allowed by the rules, from the next
+// Sync. Empty ranges restore the ranges the pool was configured with.
+func (fr *FirewallRules) SetSrcRanges(srcRanges []string) error {
+	if len(srcRanges) == 0 {
+		srcRanges = fr.defaultSrcRanges
+	}
+	if _, err := netset.ParseIPNets(srcRanges...); err != nil {
+		return fmt.Errorf("invalid firewall source ranges %v: %v", srcRanges, err)
+	}
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	if !sets.NewString(fr.srcRanges...).Equal(sets.NewString(srcRanges...)) {
+		logging.Infof("Firewall source ranges changed from %v to %v", fr.srcRanges, srcRanges)
+	}
+	fr.srcRanges = srcRanges
+	return nil
 }
 
 // Shutdown shuts down this firewall rules manager.
 func (fr *FirewallRules) Shutdown() error {
-	name := fr.namer.FirewallRule()
-	glog.Infof("Deleting firewall %v", name)
-	return fr.deleteFirewall(name)
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.lastSync = nil
+	fr.listNetworks()
+
+	errs := []error{fr.deleteNetworkRules("")}
+	for _, network := range fr.networks.List() {
+		if err := fr.deleteNetworkRules(network); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fr.networks.Delete(network)
+	}
+	return firstError(errs)
 }
 
 // GetFirewall just returns the firewall object corresponding to the given name.
 // TODO: Currently only used in testing. Modify so we don't leak compute
 // objects out of this interface by returning just the (src, ports, error).
-func (fr *FirewallRules) GetFirewall(name string) (*compute.Firewall, error) {
+func (fr *FirewallRules) GetFirewall(name string) (*FirewallRule, error) {
 	return fr.cloud.GetFirewall(name)
 }
 
-func (fr *FirewallRules) createFirewallObject(firewallName, description string, nodePorts []int64, nodeNames []string) (*compute.Firewall, error) {
-	ports := make([]string, len(nodePorts))
-	for ix := range nodePorts {
-		ports[ix] = strconv.Itoa(int(nodePorts[ix]))
-	}
-	// Sorting the ports will prevent duplicate events being created despite having identical params.
-	sort.Strings(ports)
-
-	// If the node tags to be used for this cluster have been predefined in the
-	// provider config, just use them. Otherwise, invoke computeHostTags method to get the tags.
-	targetTags, err := fr.cloud.GetNodeTags(nodeNames)
-	if err != nil {
-		return nil, err
+// createFirewallObject returns the firewall rule with the given parameters.
+// An empty network is the cluster network.
+func (fr *FirewallRules) createFirewallObject(firewallName, description, network string, nodePorts []int64, nodeNames []string, srcRanges []string) (*FirewallRule, error) {
+	if network == "" {
+		network = fr.cloud.NetworkURL()
 	}
-	sort.Strings(targetTags)
+	// Contiguous ports are collapsed into ranges to stay within the GCE limit
+	// of ports per rule. The ranges are sorted, which will prevent duplicate
+	// events being created despite having identical params.
+	ports := portRanges(nodePorts)
 
-	return &compute.Firewall{
+	firewall := &FirewallRule{
 		Name:         firewallName,
 		Description:  description,
-		SourceRanges: fr.srcRanges,
-		Network:      fr.cloud.NetworkURL(),
-		Allowed: []*compute.FirewallAllowed{
+		SourceRanges: srcRanges,
+		Network:      network,
+		Allowed: []*FirewallAllowed{
 			{
 				IPProtocol: "tcp",
 				Ports:      ports,
 			},
 		},
-		TargetTags: targetTags,
-	}, nil
+		// The log config is always sent, so that disabling logging is not
+		// mistaken for leaving it unchanged.
+		LogConfig: &FirewallLogConfig{Enable: fr.enableLogging},
+	}
+	if fr.enableLogging {
+		firewall.LogConfig.Metadata = includeAllMetadata
+		if fr.excludeLoggingMetadata {
+			firewall.LogConfig.Metadata = excludeAllMetadata
+		}
+	}
+	if len(fr.targetServiceAccounts) > 0 {
+		firewall.TargetServiceAccounts = fr.targetServiceAccounts
+		return firewall, nil
+	}
+
+	targetTags, err := fr.targetTags(nodeNames)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(targetTags)
+	firewall.TargetTags = targetTags
+	return firewall, nil
+}
+
+// targetTags returns the node tags of the given nodes.
+func (fr *FirewallRules) targetTags(nodeNames []string) ([]string, error) {
+	// If the node tags to be used for this cluster have been predefined in the
+	// provider config, just use them. Otherwise, invoke computeHostTags method to get the tags.
+	if len(fr.windowsNodeTags) == 0 || fr.nodeOSLister == nil {
+		return fr.cloud.GetNodeTags(nodeNames)
+	}
+	var otherNodes []string
+	hasWindowsNodes := false
+	for _, name := range nodeNames {
+		if fr.nodeOSLister.IsWindowsNode(name) {
+			hasWindowsNodes = true
+			continue
+		}
+		otherNodes = append(otherNodes, name)
+	}
+	tags := sets.NewString()
+	if hasWindowsNodes {
+		tags.Insert(fr.windowsNodeTags...)
+	}
+	if len(otherNodes) > 0 || !hasWindowsNodes {
+		otherTags, err := fr.cloud.GetNodeTags(otherNodes)
+		if err != nil {
+			return nil, err
+		}
+		tags.Insert(otherTags...)
+	}
+	return tags.List(), nil
 }
 
-func (fr *FirewallRules) createFirewall(f *compute.Firewall) error {
+// Init sets the lister used to tell the Windows nodes apart.
+func (fr *FirewallRules) Init(nl nodeOSLister) {
+	fr.nodeOSLister = nl
+}
+
+func (fr *FirewallRules) createFirewall(f *FirewallRule) error {
+	if fr.dryRun {
+		return fr.dryRunChange(FirewallChangeCreate, f)
+	}
 	err := fr.cloud.CreateFirewall(f)
 	if utils.IsForbiddenError(err) && fr.cloud.OnXPN() {
-		gcloudCmd := gce.FirewallToGCloudCreateCmd(f, fr.cloud.NetworkProjectID())
-		glog.V(3).Infof("Could not create L7 firewall on XPN cluster. Raising event for cmd: %q", gcloudCmd)
-		return newFirewallXPNError(err, gcloudCmd)
+		change := newFirewallChange(FirewallChangeCreate, f, fr.cloud.NetworkProjectID())
+		logging.V(3).Infof("Could not create L7 firewall on XPN cluster. Raising event for cmd: %q", change.Command)
+		return newFirewallXPNError(err, change)
 	}
 	return err
 }
 
-func (fr *FirewallRules) updateFirewall(f *compute.Firewall) error {
+func (fr *FirewallRules) updateFirewall(f *FirewallRule) error {
+	if fr.dryRun {
+		return fr.dryRunChange(FirewallChangeUpdate, f)
+	}
 	err := fr.cloud.UpdateFirewall(f)
 	if utils.IsForbiddenError(err) && fr.cloud.OnXPN() {
-		gcloudCmd := gce.FirewallToGCloudUpdateCmd(f, fr.cloud.NetworkProjectID())
-		glog.V(3).Infof("Could not update L7 firewall on XPN cluster. Raising event for cmd: %q", gcloudCmd)
-		return newFirewallXPNError(err, gcloudCmd)
+		change := newFirewallChange(FirewallChangeUpdate, f, fr.cloud.NetworkProjectID())
+		logging.V(3).Infof("Could not update L7 firewall on XPN cluster. Raising event for cmd: %q", change.Command)
+		return newFirewallXPNError(err, change)
 	}
 	return err
 }
 
 func (fr *FirewallRules) deleteFirewall(name string) error {
+	if fr.dryRun {
+		return fr.dryRunChange(FirewallChangeDelete, &FirewallRule{Name: name})
+	}
 	err := fr.cloud.DeleteFirewall(name)
 	if utils.IsNotFoundError(err) {
-		glog.Infof("Firewall with name %v didn't exist when attempting delete.", name)
+		logging.Infof("Firewall with name %v didn't exist when attempting delete.", name)
 		return nil
 	} else if utils.IsForbiddenError(err) && fr.cloud.OnXPN() {
-		gcloudCmd := gce.FirewallToGCloudDeleteCmd(name, fr.cloud.NetworkProjectID())
-		glog.V(3).Infof("Could not attempt delete of L7 firewall on XPN cluster. %q needs to be ran.", gcloudCmd)
-		return newFirewallXPNError(err, gcloudCmd)
+		change := newFirewallChange(FirewallChangeDelete, &FirewallRule{Name: name}, fr.cloud.NetworkProjectID())
+		logging.V(3).Infof("Could not attempt delete of L7 firewall on XPN cluster. %q needs to be ran.", change.Command)
+		return newFirewallXPNError(err, change)
 	}
 	return err
 }
 
-func newFirewallXPNError(internal error, cmd string) *FirewallSyncError {
+// dryRunChange logs the given change with its diff against the live rule,
+// without applying it.
+func (fr *FirewallRules) dryRunChange(operation string, f *FirewallRule) error {
+	change := newFirewallChange(operation, f, fr.cloud.NetworkProjectID())
+	var diff []string
+	switch operation {
+	case FirewallChangeCreate:
+		diff = []string{"rule missing"}
+	case FirewallChangeDelete:
+		diff = []string{"rule unused"}
+	default:
+		existing, err := fr.cloud.GetFirewall(f.Name)
+		if err != nil {
+			return err
+		}
+		diff = fr.firewallDiff(existing, f)
+	}
+	logging.Infof("Dry run, not applying firewall change: firewall=%q operation=%q diff=%q command=%q", f.Name, operation, diff, change.Command)
+	return &FirewallSyncError{
+		Message: fmt.Sprintf("Dry run, firewall change not applied (%v): `%v`", strings.Join(diff, ", "), change.Command),
+		Change:  change,
+		DryRun:  true,
+	}
+}
+
+// isDryRunError returns true if the given error is a change skipped in dry
+// run mode.
+func isDryRunError(err error) bool {
+	fwErr, ok := err.(*FirewallSyncError)
+	return ok && fwErr.DryRun
+}
+
+// noOpFirewallPool is used when firewall management is disabled. It only
+// logs the changes the controller would make.
+type noOpFirewallPool struct {
+	namer *utils.Namer
+}
+
+// Init is a no-op, firewall rules are managed externally.
+func (n *noOpFirewallPool) Init(nl nodeOSLister) {}
+
+// Sync logs the ports the firewall rules would be synced to.
+func (n *noOpFirewallPool) Sync(nodePorts []int64, negPorts []int64, nodeNames []string, additionalRanges []string, additionalNetworks []string) error {
+	logging.V(3).Infof("Firewall management is disabled, not syncing firewalls %v and %v with ports %v and firewalls %v and %v with ports %v (additional source ranges %v, additional networks %v)",
+		n.namer.FirewallRule(), n.namer.IPv6FirewallRule(), nodePorts, n.namer.NEGFirewallRule(), n.namer.IPv6NEGFirewallRule(), negPorts, additionalRanges, additionalNetworks)
+	return nil
+}
+
+// RepairDrift is a no-op, firewall rules are managed externally.
+func (n *noOpFirewallPool) RepairDrift() ([]string, error) {
+	return nil, nil
+}
+
+// SetSrcRanges is a no-op, firewall rules are managed externally.
+func (n *noOpFirewallPool) SetSrcRanges(srcRanges []string) error {
+	return nil
+}
+
+// Shutdown logs the firewall rules that would be deleted.
+func (n *noOpFirewallPool) Shutdown() error {
+	logging.Infof("Firewall management is disabled, not deleting firewalls %v, %v, %v and %v",
+		n.namer.FirewallRule(), n.namer.NEGFirewallRule(), n.namer.IPv6FirewallRule(), n.namer.IPv6NEGFirewallRule())
+	return nil
+}
+
+func newFirewallXPNError(internal error, change *FirewallChange) *FirewallSyncError {
 	return &FirewallSyncError{
 		Internal: internal,
-		Message:  fmt.Sprintf("Firewall change required by network admin: `%v`", cmd),
+		Message:  fmt.Sprintf("Firewall change required by network admin: `%v`", change.Command),
+		Change:   change,
 	}
 }
 
+// FirewallSyncError is returned when the firewall rule could not be synced
+// because the controller lacks permissions, and the change has to be applied
+// by a network admin. It is also returned for the changes skipped in dry run
+// mode.
 type FirewallSyncError struct {
 	Internal error
 	Message  string
+	// Change describes the required firewall change.
+	Change *FirewallChange
+	// DryRun is true if the change was skipped because of the dry run mode.
+	DryRun bool
 }
 
 func (f *FirewallSyncError) Error() string {
@@ -17,7 +17,7 @@ limitations under the License.
 package firewalls
 
 import (
-	"strconv"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -28,13 +28,13 @@ import (
 func TestSyncFirewallPool(t *testing.T) {
 	namer := utils.NewNamer("ABC", "XYZ")
 	fwp := NewFakeFirewallsProvider(false, false)
-	fp := NewFirewallPool(fwp, namer)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
 	ruleName := namer.FirewallRule()
 
 	// Test creating a firewall rule via Sync
 	nodePorts := []int64{80, 443, 3000}
 	nodes := []string{"node-a", "node-b", "node-c"}
-	err := fp.Sync(nodePorts, nodes)
+	err := fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if err != nil {
 		t.Errorf("unexpected err when syncing firewall, err: %v", err)
 	}
@@ -42,13 +42,13 @@ func TestSyncFirewallPool(t *testing.T) {
 
 	// Sync to fewer ports
 	nodePorts = []int64{80, 443}
-	err = fp.Sync(nodePorts, nodes)
+	err = fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if err != nil {
 		t.Errorf("unexpected err when syncing firewall, err: %v", err)
 	}
 	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
 
-	firewall, err := fp.(*FirewallRules).createFirewallObject(namer.FirewallRule(), "", nodePorts, nodes)
+	firewall, err := fp.(*FirewallRules).createFirewallObject(namer.FirewallRule(), "", "", nodePorts, nodes, l7SrcRanges)
 	if err != nil {
 		t.Errorf("unexpected err when creating firewall object, err: %v", err)
 	}
@@ -60,7 +60,7 @@ func TestSyncFirewallPool(t *testing.T) {
 	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
 
 	// Run Sync and expect l7 src ranges to be returned
-	err = fp.Sync(nodePorts, nodes)
+	err = fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if err != nil {
 		t.Errorf("unexpected err when syncing firewall, err: %v", err)
 	}
@@ -69,7 +69,7 @@ func TestSyncFirewallPool(t *testing.T) {
 	// Add node and expect firewall to remain the same
 	// NOTE: See computeHostTag(..) in gce cloudprovider
 	nodes = []string{"node-a", "node-b", "node-c", "node-d"}
-	err = fp.Sync(nodePorts, nodes)
+	err = fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if err != nil {
 		t.Errorf("unexpected err when syncing firewall, err: %v", err)
 	}
@@ -77,7 +77,7 @@ func TestSyncFirewallPool(t *testing.T) {
 
 	// Remove all ports and expect firewall rule to disappear
 	nodePorts = []int64{}
-	err = fp.Sync(nodePorts, nodes)
+	err = fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if err != nil {
 		t.Errorf("unexpected err when syncing firewall, err: %v", err)
 	}
@@ -88,17 +88,545 @@ func TestSyncFirewallPool(t *testing.T) {
 	}
 }
 
+// TestSyncCustomSrcRanges tests that configured and additional source ranges
+// end up in the firewall rule and that changes to them update the rule.
+func TestSyncCustomSrcRanges(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	srcRanges := []string{"10.0.0.0/8"}
+	fp := NewFirewallPool(fwp, namer, PoolOptions{SrcRanges: srcRanges, Manage: true})
+	ruleName := namer.FirewallRule()
+
+	nodePorts := []int64{80, 443}
+	nodes := []string{"node-a", "node-b"}
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, srcRanges, t)
+
+	// Additional ranges are allowed alongside the configured ranges.
+	additional := []string{"192.168.0.0/16"}
+	if err := fp.Sync(nodePorts, nil, nodes, additional, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, append(additional, srcRanges...), t)
+
+	// Invalid ranges are dropped, and removing additional ranges shrinks the rule.
+	if err := fp.Sync(nodePorts, nil, nodes, []string{"not-a-cidr"}, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, srcRanges, t)
+
+	// The configured ranges are replaced from the next sync, and restored by
+	// empty ranges. Invalid ranges are rejected.
+	newRanges := []string{"172.16.0.0/12"}
+	if err := fp.SetSrcRanges(newRanges); err != nil {
+		t.Fatalf("SetSrcRanges(%v) = %v", newRanges, err)
+	}
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, newRanges, t)
+	if err := fp.SetSrcRanges([]string{"not-a-cidr"}); err == nil {
+		t.Errorf("Expected an error setting invalid source ranges")
+	}
+	if err := fp.SetSrcRanges(nil); err != nil {
+		t.Fatalf("SetSrcRanges(nil) = %v", err)
+	}
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, srcRanges, t)
+}
+
+// TestSyncNEGPorts tests that the pod ports of NEG backends are opened by a
+// separate rule, which is deleted once no NEG ports remain.
+func TestSyncNEGPorts(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
+	ruleName := namer.FirewallRule()
+	negRuleName := namer.NEGFirewallRule()
+
+	nodePorts := []int64{30000}
+	negPorts := []int64{80, 8080}
+	nodes := []string{"node-a", "node-b"}
+	if err := fp.Sync(nodePorts, negPorts, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
+	verifyFirewallRule(fwp, negRuleName, negPorts, nodes, l7SrcRanges, t)
+
+	// Endpoint ports change.
+	negPorts = []int64{8081}
+	if err := fp.Sync(nodePorts, negPorts, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, negRuleName, negPorts, nodes, l7SrcRanges, t)
+
+	// No more NEG backends, only the L7 rule remains.
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
+	if _, err := fwp.GetFirewall(negRuleName); err == nil {
+		t.Errorf("expected NEG firewall rule %v to be deleted", negRuleName)
+	}
+
+	// Only NEG backends, only the NEG rule remains.
+	if err := fp.Sync(nil, negPorts, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, negRuleName, negPorts, nodes, l7SrcRanges, t)
+	if _, err := fwp.GetFirewall(ruleName); err == nil {
+		t.Errorf("expected firewall rule %v to be deleted", ruleName)
+	}
+
+	if err := fp.Shutdown(); err != nil {
+		t.Errorf("unexpected err when deleting firewall, err: %v", err)
+	}
+	if _, err := fwp.GetFirewall(negRuleName); err == nil {
+		t.Errorf("expected NEG firewall rule %v to be deleted", negRuleName)
+	}
+}
+
+// TestSyncDualStack tests that IPv6 source ranges are allowed by separate
+// rules, which are kept in sync with the IPv4 rules.
+func TestSyncDualStack(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true, DualStack: true})
+
+	nodePorts := []int64{80, 443}
+	negPorts := []int64{8080}
+	nodes := []string{"node-a", "node-b"}
+	if err := fp.Sync(nodePorts, negPorts, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, namer.FirewallRule(), nodePorts, nodes, l7SrcRanges, t)
+	verifyFirewallRule(fwp, namer.NEGFirewallRule(), negPorts, nodes, l7SrcRanges, t)
+	verifyFirewallRule(fwp, namer.IPv6FirewallRule(), nodePorts, nodes, l7SrcRangesIPv6, t)
+	verifyFirewallRule(fwp, namer.IPv6NEGFirewallRule(), negPorts, nodes, l7SrcRangesIPv6, t)
+
+	// Additional IPv6 ranges only end up in the IPv6 rules.
+	additional := []string{"2001:db8::/32"}
+	if err := fp.Sync(nodePorts, negPorts, nodes, additional, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, namer.FirewallRule(), nodePorts, nodes, l7SrcRanges, t)
+	verifyFirewallRule(fwp, namer.IPv6FirewallRule(), nodePorts, nodes, append(additional, l7SrcRangesIPv6...), t)
+
+	if err := fp.Shutdown(); err != nil {
+		t.Errorf("unexpected err when deleting firewall, err: %v", err)
+	}
+	if len(fwp.fw) != 0 {
+		t.Errorf("expected all firewall rules to be deleted, got %v", fwp.fw)
+	}
+
+	// Without dual-stack, IPv6 rules are only created for IPv6 ranges.
+	fp = NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	if len(fwp.fw) != 1 {
+		t.Errorf("expected only the IPv4 firewall rule, got %v", fwp.fw)
+	}
+}
+
+// TestSyncAdditionalNetworks tests that the rules are created on requested
+// additional networks, and deleted once the networks are no longer requested.
+func TestSyncAdditionalNetworks(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
+
+	nodePorts := []int64{80, 443}
+	nodes := []string{"node-a"}
+	network := "/path/to/other-network"
+	ruleName := numberedRuleName(networkRuleName(namer.FirewallRule(), network), 0)
+	if err := fp.Sync(nodePorts, nil, nodes, nil, []string{"other-network", "my-network"}); err != nil {
+		t.Fatalf("unexpected err when syncing firewall, err: %v", err)
+	}
+	verifyFirewallRule(fwp, namer.FirewallRule(), nodePorts, nodes, l7SrcRanges, t)
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
+	if f, _ := fwp.GetFirewall(ruleName); f.Network != network {
+		t.Errorf("expected rule %v on network %v, got %v", ruleName, network, f.Network)
+	}
+	if len(fwp.fw) != 2 {
+		t.Errorf("expected rules on the cluster network and %v only, got %v", network, len(fwp.fw))
+	}
+
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Fatalf("unexpected err when syncing firewall, err: %v", err)
+	}
+	if _, err := fwp.GetFirewall(ruleName); err == nil {
+		t.Errorf("expected rule %v to be deleted with its network", ruleName)
+	}
+	verifyFirewallRule(fwp, namer.FirewallRule(), nodePorts, nodes, l7SrcRanges, t)
+}
+
+// TestSyncAdditionalNetworksAfterRestart tests that the rules on networks
+// which stopped being requested while the controller was down are deleted.
+func TestSyncAdditionalNetworksAfterRestart(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	network := "/path/to/other-network"
+	ruleName := networkRuleName(namer.FirewallRule(), network)
+	if err := NewFirewallPool(fwp, namer, PoolOptions{Manage: true}).Sync([]int64{80}, nil, []string{"node-a"}, nil, []string{network}); err != nil {
+		t.Fatalf("unexpected err when syncing firewall, err: %v", err)
+	}
+	// A rule of another cluster on the same network.
+	otherRule := networkRuleName(utils.NewNamer("DEF", "UVW").FirewallRule(), network)
+	fwp.fw[otherRule] = &FirewallRule{Name: otherRule, Network: network}
+
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
+	if err := fp.Sync([]int64{80}, nil, []string{"node-a"}, nil, nil); err != nil {
+		t.Fatalf("unexpected err when syncing firewall, err: %v", err)
+	}
+	if _, err := fwp.GetFirewall(ruleName); err == nil {
+		t.Errorf("expected rule %v to be deleted with its network", ruleName)
+	}
+	if _, err := fwp.GetFirewall(otherRule); err != nil {
+		t.Errorf("expected rule %v of another cluster to be kept, got %v", otherRule, err)
+	}
+}
+
+func TestResolveNetworks(t *testing.T) {
+	fwp := NewFakeFirewallsProvider(false, false)
+	fp := NewFirewallPool(fwp, utils.NewNamer("ABC", "XYZ"), PoolOptions{Manage: true}).(*FirewallRules)
+	got := fp.resolveNetworks([]string{
+		"other",
+		"my-network",
+		"projects/test-network-project/global/networks/a",
+		"https://www.googleapis.com/compute/v1/projects/test-network-project/global/networks/b",
+		"projects/another-project/global/networks/c",
+	})
+	want := []string{
+		"/path/to/other",
+		"https://www.googleapis.com/compute/v1/projects/test-network-project/global/networks/a",
+		"https://www.googleapis.com/compute/v1/projects/test-network-project/global/networks/b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveNetworks() = %v, want %v", got, want)
+	}
+}
+
+func TestPortRanges(t *testing.T) {
+	for _, tc := range []struct {
+		ports []int64
+		want  []string
+	}{
+		{ports: nil, want: nil},
+		{ports: []int64{80}, want: []string{"80"}},
+		{ports: []int64{443, 80, 80}, want: []string{"80", "443"}},
+		{ports: []int64{30002, 80, 30000, 30001, 30004}, want: []string{"80", "30000-30002", "30004"}},
+	} {
+		if got := portRanges(tc.ports); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("portRanges(%v) = %v, want %v", tc.ports, got, tc.want)
+		}
+	}
+}
+
+// TestSyncManyPorts tests that ports which don't fit in a single rule are
+// split across numbered rules, which are deleted once no longer needed.
+func TestSyncManyPorts(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
+	ruleName := namer.FirewallRule()
+	nodes := []string{"node-a"}
+
+	// Every other port, so that none of them can be collapsed into a range.
+	var nodePorts []int64
+	for p := int64(30000); p < 30000+2*(2*maxPortsPerRule+1); p += 2 {
+		nodePorts = append(nodePorts, p)
+	}
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	if len(fwp.fw) != 3 {
+		t.Errorf("expected 3 firewall rules, got %d", len(fwp.fw))
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts[:maxPortsPerRule], nodes, l7SrcRanges, t)
+	verifyFirewallRule(fwp, ruleName+"-1", nodePorts[maxPortsPerRule:2*maxPortsPerRule], nodes, l7SrcRanges, t)
+	verifyFirewallRule(fwp, ruleName+"-2", nodePorts[2*maxPortsPerRule:], nodes, l7SrcRanges, t)
+
+	// Contiguous ports collapse into a single rule.
+	nodePorts = nil
+	for p := int64(30000); p < 30000+2*(2*maxPortsPerRule+1); p++ {
+		nodePorts = append(nodePorts, p)
+	}
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	if len(fwp.fw) != 1 {
+		t.Errorf("expected a single firewall rule, got %d", len(fwp.fw))
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
+}
+
+// TestSyncLogging tests that toggling firewall rules logging updates the
+// existing rule.
+func TestSyncLogging(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	ruleName := namer.FirewallRule()
+	nodePorts := []int64{80}
+	nodes := []string{"node-a"}
+
+	for _, enableLogging := range []bool{false, true, false} {
+		fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true, EnableLogging: enableLogging})
+		if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+			t.Errorf("unexpected err when syncing firewall, err: %v", err)
+		}
+		f, err := fwp.GetFirewall(ruleName)
+		if err != nil {
+			t.Fatalf("could not retrieve firewall via cloud api, err %v", err)
+		}
+		if loggingEnabled(f) != enableLogging {
+			t.Errorf("loggingEnabled(f) = %v, want %v", loggingEnabled(f), enableLogging)
+		}
+	}
+
+	change := newFirewallChange(FirewallChangeUpdate, &FirewallRule{Name: ruleName, LogConfig: &FirewallLogConfig{Enable: true}}, "p")
+	if !strings.HasSuffix(change.Command, "--enable-logging --logging-metadata include-all") {
+		t.Errorf("expected command to enable logging, got %q", change.Command)
+	}
+}
+
+// TestSyncLoggingMetadata tests that excluding the metadata from the logs
+// updates the existing rule.
+func TestSyncLoggingMetadata(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	ruleName := namer.FirewallRule()
+
+	for _, tc := range []struct {
+		exclude bool
+		want    string
+	}{
+		{false, includeAllMetadata},
+		{true, excludeAllMetadata},
+		{false, includeAllMetadata},
+	} {
+		fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true, EnableLogging: true, ExcludeLoggingMetadata: tc.exclude})
+		if err := fp.Sync([]int64{80}, nil, []string{"node-a"}, nil, nil); err != nil {
+			t.Errorf("unexpected err when syncing firewall, err: %v", err)
+		}
+		f, err := fwp.GetFirewall(ruleName)
+		if err != nil {
+			t.Fatalf("could not retrieve firewall via cloud api, err %v", err)
+		}
+		if got := loggingMetadata(f); got != tc.want {
+			t.Errorf("loggingMetadata(f) = %q, want %q", got, tc.want)
+		}
+	}
+
+	// A rule logged with the default metadata needs no update.
+	fwp.fw[ruleName].LogConfig.Metadata = ""
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true, EnableLogging: true}).(*FirewallRules)
+	desired, err := fp.createFirewallObject(ruleName, "", "", []int64{80}, []string{"node-a"}, fp.srcRanges)
+	if err != nil {
+		t.Fatalf("createFirewallObject() = %v", err)
+	}
+	if diff := fp.firewallDiff(fwp.fw[ruleName], desired); len(diff) != 0 {
+		t.Errorf("firewallDiff() = %v, want no diff", diff)
+	}
+}
+
+// TestSyncTargetServiceAccounts tests that the rule targets service accounts
+// when configured, and that switching between service accounts and node tags
+// updates the existing rule.
+func TestSyncTargetServiceAccounts(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	serviceAccounts := []string{"nodes@my-project.iam.gserviceaccount.com"}
+	fp := NewFirewallPool(fwp, namer, PoolOptions{TargetServiceAccounts: serviceAccounts, Manage: true})
+	ruleName := namer.FirewallRule()
+
+	nodePorts := []int64{80, 443}
+	nodes := []string{"node-a", "node-b"}
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	f, err := fwp.GetFirewall(ruleName)
+	if err != nil {
+		t.Fatalf("could not retrieve firewall via cloud api, err %v", err)
+	}
+	if len(f.TargetTags) != 0 || !sets.NewString(f.TargetServiceAccounts...).Equal(sets.NewString(serviceAccounts...)) {
+		t.Errorf("expected rule to target service accounts %v only, got tags %v and service accounts %v", serviceAccounts, f.TargetTags, f.TargetServiceAccounts)
+	}
+
+	// Switching to node tags updates the rule in place.
+	fp = NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	f, err = fwp.GetFirewall(ruleName)
+	if err != nil {
+		t.Fatalf("could not retrieve firewall via cloud api, err %v", err)
+	}
+	if len(f.TargetServiceAccounts) != 0 {
+		t.Errorf("expected rule to stop targeting service accounts, got %v", f.TargetServiceAccounts)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
+
+	// And back to service accounts.
+	fp = NewFirewallPool(fwp, namer, PoolOptions{TargetServiceAccounts: serviceAccounts, Manage: true})
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	f, err = fwp.GetFirewall(ruleName)
+	if err != nil {
+		t.Fatalf("could not retrieve firewall via cloud api, err %v", err)
+	}
+	if len(f.TargetTags) != 0 || len(f.TargetServiceAccounts) != 1 {
+		t.Errorf("expected rule to target service accounts %v only, got tags %v and service accounts %v", serviceAccounts, f.TargetTags, f.TargetServiceAccounts)
+	}
+}
+
+// TestRepairDrift tests that manual changes to the firewall rules are
+// detected and repaired.
+func TestRepairDrift(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
+	ruleName := namer.FirewallRule()
+
+	// Nothing to repair before the first sync.
+	if repairs, err := fp.RepairDrift(); err != nil || len(repairs) != 0 {
+		t.Errorf("RepairDrift() = %v, %v; want no repairs", repairs, err)
+	}
+
+	nodePorts := []int64{80, 443}
+	nodes := []string{"node-a", "node-b"}
+	if err := fp.Sync(nodePorts, nil, nodes, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	if repairs, err := fp.RepairDrift(); err != nil || len(repairs) != 0 {
+		t.Errorf("RepairDrift() = %v, %v; want no repairs", repairs, err)
+	}
+
+	// Target tags are not compared by Sync, so they are not repaired either.
+	f, _ := fwp.GetFirewall(ruleName)
+	f.TargetTags = []string{"other-tag"}
+	if repairs, err := fp.RepairDrift(); err != nil || len(repairs) != 0 {
+		t.Errorf("RepairDrift() = %v, %v; want no repairs", repairs, err)
+	}
+
+	// Edit the rule manually.
+	f.SourceRanges = []string{"0.0.0.0/0"}
+	repairs, err := fp.RepairDrift()
+	if err != nil || len(repairs) != 1 || !strings.Contains(repairs[0], "source ranges") {
+		t.Errorf("RepairDrift() = %v, %v; want a repair of source ranges", repairs, err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
+
+	// Delete the rule manually.
+	if err := fwp.DeleteFirewall(ruleName); err != nil {
+		t.Fatalf("unexpected err when deleting firewall, err: %v", err)
+	}
+	repairs, err = fp.RepairDrift()
+	if err != nil || len(repairs) != 1 || !strings.Contains(repairs[0], "Recreated") {
+		t.Errorf("RepairDrift() = %v, %v; want the rule to be recreated", repairs, err)
+	}
+	verifyFirewallRule(fwp, ruleName, nodePorts, nodes, l7SrcRanges, t)
+
+	// Nothing to repair after shutdown.
+	if err := fp.Shutdown(); err != nil {
+		t.Errorf("unexpected err when deleting firewall, err: %v", err)
+	}
+	if repairs, err := fp.RepairDrift(); err != nil || len(repairs) != 0 {
+		t.Errorf("RepairDrift() = %v, %v; want no repairs", repairs, err)
+	}
+}
+
+// TestSyncManagementDisabled tests that no firewall rules are touched when
+// firewall management is disabled.
+func TestSyncManagementDisabled(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	existing := &FirewallRule{Name: namer.FirewallRule(), SourceRanges: []string{"10.0.0.0/8"}}
+	if err := fwp.doCreateFirewall(existing); err != nil {
+		t.Fatalf("unexpected err when creating firewall, err: %v", err)
+	}
+	fp := NewFirewallPool(fwp, namer, PoolOptions{})
+
+	if err := fp.Sync([]int64{80}, []int64{8080}, []string{"node-a"}, nil, nil); err != nil {
+		t.Errorf("unexpected err when syncing firewall, err: %v", err)
+	}
+	if len(fwp.fw) != 1 || fwp.fw[existing.Name] != existing {
+		t.Errorf("expected firewall rules to be left untouched, got %v", fwp.fw)
+	}
+	if err := fp.Shutdown(); err != nil {
+		t.Errorf("unexpected err when deleting firewall, err: %v", err)
+	}
+	if len(fwp.fw) != 1 {
+		t.Errorf("expected firewall rules to be left untouched, got %v", fwp.fw)
+	}
+}
+
+// TestSyncDryRun tests that no firewall rules are mutated in dry run mode,
+// and that the skipped changes are reported.
+func TestSyncDryRun(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	ruleName := namer.FirewallRule()
+	nodes := []string{"node-a"}
+
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true, DryRun: true})
+	err := fp.Sync([]int64{80}, nil, nodes, nil, nil)
+	if fwErr, ok := err.(*FirewallSyncError); !ok || !fwErr.DryRun {
+		t.Fatalf("expected a dry run FirewallSyncError, got %v", err)
+	} else {
+		verifyFirewallChange(fwErr.Change, FirewallChangeCreate, ruleName, t)
+	}
+	if len(fwp.fw) != 0 {
+		t.Errorf("expected no firewall rules to be created, got %v", fwp.fw)
+	}
+
+	// Create the rule, and check that updates are skipped as well.
+	if err := NewFirewallPool(fwp, namer, PoolOptions{Manage: true}).Sync([]int64{80}, nil, nodes, nil, nil); err != nil {
+		t.Fatalf("unexpected err when syncing firewall, err: %v", err)
+	}
+	err = fp.Sync([]int64{80, 443}, nil, nodes, nil, nil)
+	if fwErr, ok := err.(*FirewallSyncError); !ok || !fwErr.DryRun || !strings.Contains(fwErr.Message, "ports") {
+		t.Fatalf("expected a dry run FirewallSyncError with a ports diff, got %v", err)
+	} else {
+		verifyFirewallChange(fwErr.Change, FirewallChangeUpdate, ruleName, t)
+	}
+	verifyFirewallRule(fwp, ruleName, []int64{80}, nodes, l7SrcRanges, t)
+
+	// Drift is reported but not repaired.
+	f, _ := fwp.GetFirewall(ruleName)
+	f.SourceRanges = []string{"0.0.0.0/0"}
+	repairs, err := fp.RepairDrift()
+	if err != nil || len(repairs) != 1 || !strings.Contains(repairs[0], "Dry run") {
+		t.Errorf("RepairDrift() = %v, %v; want a dry run repair", repairs, err)
+	}
+	if f, _ := fwp.GetFirewall(ruleName); !reflect.DeepEqual(f.SourceRanges, []string{"0.0.0.0/0"}) {
+		t.Errorf("expected the drifted rule to be left untouched, got source ranges %v", f.SourceRanges)
+	}
+
+	if err := fp.Shutdown(); !isDryRunError(err) {
+		t.Errorf("expected a dry run error on shutdown, got %v", err)
+	}
+	if _, err := fwp.GetFirewall(ruleName); err != nil {
+		t.Errorf("expected rule %v not to be deleted, got %v", ruleName, err)
+	}
+}
+
 // TestSyncOnXPNWithPermission tests that firwall sync continues to work when OnXPN=true
 func TestSyncOnXPNWithPermission(t *testing.T) {
 	namer := utils.NewNamer("ABC", "XYZ")
 	fwp := NewFakeFirewallsProvider(true, false)
-	fp := NewFirewallPool(fwp, namer)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
 	ruleName := namer.FirewallRule()
 
 	// Test creating a firewall rule via Sync
 	nodePorts := []int64{80, 443, 3000}
 	nodes := []string{"node-a", "node-b", "node-c"}
-	err := fp.Sync(nodePorts, nodes)
+	err := fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if err != nil {
 		t.Errorf("unexpected err when syncing firewall, err: %v", err)
 	}
@@ -111,19 +639,24 @@ func TestSyncOnXPNWithPermission(t *testing.T) {
 func TestSyncOnXPNReadOnly(t *testing.T) {
 	namer := utils.NewNamer("ABC", "XYZ")
 	fwp := NewFakeFirewallsProvider(true, true)
-	fp := NewFirewallPool(fwp, namer)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{Manage: true})
 	ruleName := namer.FirewallRule()
 
 	// Test creating a firewall rule via Sync
 	nodePorts := []int64{80, 443, 3000}
 	nodes := []string{"node-a", "node-b", "node-c"}
-	err := fp.Sync(nodePorts, nodes)
+	err := fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if fwErr, ok := err.(*FirewallSyncError); !ok || !strings.Contains(fwErr.Message, "create") {
 		t.Errorf("Expected firewall sync error with a user message. Received err: %v", err)
+	} else {
+		verifyFirewallChange(fwErr.Change, FirewallChangeCreate, ruleName, t)
+		if !strings.Contains(fwErr.Change.Command, "--network my-network") {
+			t.Errorf("Expected create command to specify the network, got %q", fwErr.Change.Command)
+		}
 	}
 
 	// Manually create the firewall
-	firewall, err := fp.(*FirewallRules).createFirewallObject(ruleName, "", nodePorts, nodes)
+	firewall, err := fp.(*FirewallRules).createFirewallObject(ruleName, "", "", nodePorts, nodes, l7SrcRanges)
 	if err != nil {
 		t.Errorf("unexpected err when creating firewall object, err: %v", err)
 	}
@@ -133,7 +666,7 @@ func TestSyncOnXPNReadOnly(t *testing.T) {
 	}
 
 	// Run sync again with same state - expect no event
-	err = fp.Sync(nodePorts, nodes)
+	err = fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if err != nil {
 		t.Errorf("unexpected err when syncing firewall, err: %v", err)
 	}
@@ -142,17 +675,83 @@ func TestSyncOnXPNReadOnly(t *testing.T) {
 	nodePorts = append(nodePorts, 3001)
 
 	// Run sync again with same state - expect no event
-	err = fp.Sync(nodePorts, nodes)
+	err = fp.Sync(nodePorts, nil, nodes, nil, nil)
 	if fwErr, ok := err.(*FirewallSyncError); !ok || !strings.Contains(fwErr.Message, "update") {
 		t.Errorf("Expected firewall sync error with a user message. Received err: %v", err)
+	} else {
+		verifyFirewallChange(fwErr.Change, FirewallChangeUpdate, ruleName, t)
+		if !sets.NewString(fwErr.Change.Allowed...).Has("tcp:3000-3001") {
+			t.Errorf("Expected update to allow tcp:3000-3001, got %v", fwErr.Change.Allowed)
+		}
+	}
+
+	// Remove the firewall rule, expect a delete change
+	err = fp.Shutdown()
+	if fwErr, ok := err.(*FirewallSyncError); !ok || !strings.Contains(fwErr.Message, "delete") {
+		t.Errorf("Expected firewall sync error with a user message. Received err: %v", err)
+	} else {
+		verifyFirewallChange(fwErr.Change, FirewallChangeDelete, ruleName, t)
+		expectedCmd := "gcloud compute firewall-rules delete " + ruleName + " --project test-network-project"
+		if fwErr.Change.Command != expectedCmd {
+			t.Errorf("Expected command %q, got %q", expectedCmd, fwErr.Change.Command)
+		}
 	}
 }
 
-func verifyFirewallRule(fwp *fakeFirewallsProvider, ruleName string, expectedPorts []int64, expectedNodes, expectedCIDRs []string, t *testing.T) {
-	var strPorts []string
-	for _, v := range expectedPorts {
-		strPorts = append(strPorts, strconv.FormatInt(v, 10))
+func verifyFirewallChange(change *FirewallChange, operation, ruleName string, t *testing.T) {
+	if change == nil {
+		t.Fatalf("Expected a firewall change for %v, got nil", operation)
+	}
+	if change.Operation != operation || change.Name != ruleName || change.Project != "test-network-project" {
+		t.Errorf("Unexpected firewall change %+v, expected %v of %v in test-network-project", change, operation, ruleName)
+	}
+	if !strings.HasPrefix(change.Command, "gcloud compute firewall-rules "+operation+" "+ruleName) {
+		t.Errorf("Unexpected gcloud command %q", change.Command)
 	}
+}
+
+type fakeNodeOSLister struct {
+	windowsNodes sets.String
+}
+
+func (f *fakeNodeOSLister) IsWindowsNode(name string) bool {
+	return f.windowsNodes.Has(name)
+}
+
+// TestSyncWindowsNodeTags tests that the rule targets the configured tags of
+// the Windows nodes instead of their derived tags.
+func TestSyncWindowsNodeTags(t *testing.T) {
+	namer := utils.NewNamer("ABC", "XYZ")
+	fwp := NewFakeFirewallsProvider(false, false)
+	fp := NewFirewallPool(fwp, namer, PoolOptions{WindowsNodeTags: []string{"windows-node"}, Manage: true})
+	fp.Init(&fakeNodeOSLister{windowsNodes: sets.NewString("node-c")})
+	ruleName := namer.FirewallRule()
+
+	for _, tc := range []struct {
+		nodes    []string
+		expected []string
+	}{
+		// The fake derives the tags of the nodes from their names.
+		{[]string{"node-a", "node-b", "node-c"}, []string{"node-a", "node-b", "windows-node"}},
+		{[]string{"node-a"}, []string{"node-a"}},
+		{[]string{"node-c"}, []string{"windows-node"}},
+	} {
+		if err := fp.Sync([]int64{80}, nil, tc.nodes, nil, nil); err != nil {
+			t.Errorf("unexpected err when syncing firewall, err: %v", err)
+		}
+		f, err := fwp.GetFirewall(ruleName)
+		if err != nil {
+			t.Fatalf("could not retrieve firewall via cloud api, err %v", err)
+		}
+		if !sets.NewString(f.TargetTags...).Equal(sets.NewString(tc.expected...)) {
+			t.Errorf("expected rule of nodes %v to target tags %v, got %v", tc.nodes, tc.expected, f.TargetTags)
+		}
+	}
+}
+
+func verifyFirewallRule(fwp *fakeFirewallsProvider, ruleName string, expectedPorts []int64, expectedNodes, expectedCIDRs []string, t *testing.T) {
+	// Contiguous ports are collapsed into ranges.
+	strPorts := portRanges(expectedPorts)
 
 	// Verify firewall rule was created
 	f, err := fwp.GetFirewall(ruleName)
@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewalls
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// NetworkProvider is the part of the cloud provider that knows about the
+// cluster network and node tags.
+type NetworkProvider interface {
+	GetNodeTags(nodeNames []string) ([]string, error)
+	NetworkProjectID() string
+	NetworkURL() string
+	OnXPN() bool
+}
+
+// FirewallRule is a firewall rule. The vendored compute API predates the
+// service account targets and the log config of the rules, so the rules are
+// managed through the REST API.
+type FirewallRule struct {
+	Name                  string             `json:"name"`
+	Description           string             `json:"description,omitempty"`
+	Network               string             `json:"network,omitempty"`
+	SourceRanges          []string           `json:"sourceRanges,omitempty"`
+	TargetTags            []string           `json:"targetTags,omitempty"`
+	TargetServiceAccounts []string           `json:"targetServiceAccounts,omitempty"`
+	Allowed               []*FirewallAllowed `json:"allowed,omitempty"`
+	LogConfig             *FirewallLogConfig `json:"logConfig,omitempty"`
+	SelfLink              string             `json:"selfLink,omitempty"`
+}
+
+// FirewallAllowed is a protocol, and its ports, allowed by a firewall rule.
+type FirewallAllowed struct {
+	IPProtocol string   `json:"IPProtocol"`
+	Ports      []string `json:"ports,omitempty"`
+}
+
+// FirewallLogConfig is the logging of a firewall rule.
+type FirewallLogConfig struct {
+	Enable bool `json:"enable"`
+	// Metadata is INCLUDE_ALL_METADATA, the default, or
+	// EXCLUDE_ALL_METADATA. Only set if logging is enabled.
+	Metadata string `json:"metadata,omitempty"`
+}
+
+const (
+	// The metadata of the firewall rules logs.
+	includeAllMetadata = "INCLUDE_ALL_METADATA"
+	excludeAllMetadata = "EXCLUDE_ALL_METADATA"
+)
+
+// loggingEnabled returns true if the given rule is logged.
+func loggingEnabled(rule *FirewallRule) bool {
+	return rule.LogConfig != nil && rule.LogConfig.Enable
+}
+
+// loggingMetadata returns the metadata of the logs of the given rule, empty
+// if the rule isn't logged.
+func loggingMetadata(rule *FirewallRule) string {
+	if !loggingEnabled(rule) {
+		return ""
+	}
+	if rule.LogConfig.Metadata == "" {
+		return includeAllMetadata
+	}
+	return rule.LogConfig.Metadata
+}
+
+// gceFirewalls implements Firewall: the rules are managed through the REST
+// API, in the project of the network.
+type gceFirewalls struct {
+	NetworkProvider
+	rest *utils.ComputeREST
+}
+
+// NewGCEFirewallProvider returns a Firewall that manages the rules of the
+// cluster network through the REST API. tokenSource, transport and
+// apiEndpoint are those of utils.NewComputeREST.
+// network: the cloud provider, used for node tags and network details.
+func NewGCEFirewallProvider(network NetworkProvider, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (Firewall, error) {
+	rest, err := utils.NewComputeREST(network.NetworkProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceFirewalls{NetworkProvider: network, rest: rest}, nil
+}
+
+// GetFirewall returns the firewall rule with the given name.
+func (g *gceFirewalls) GetFirewall(name string) (*FirewallRule, error) {
+	rule := &FirewallRule{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("firewalls", name), nil, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// ListFirewalls returns the firewall rules whose name starts with the given
+// prefix.
+func (g *gceFirewalls) ListFirewalls(prefix string) ([]*FirewallRule, error) {
+	var rules []*FirewallRule
+	query := url.Values{"filter": {fmt.Sprintf("name eq %v.*", prefix)}}
+	for {
+		page := struct {
+			Items         []*FirewallRule `json:"items"`
+			NextPageToken string          `json:"nextPageToken"`
+		}{}
+		if err := g.rest.Do("GET", g.rest.GlobalURL("firewalls", "")+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		rules = append(rules, page.Items...)
+		if page.NextPageToken == "" {
+			return rules, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// CreateFirewall creates the given firewall rule, and waits for it to be
+// created.
+func (g *gceFirewalls) CreateFirewall(f *FirewallRule) error {
+	return g.rest.DoOp("POST", g.rest.GlobalURL("firewalls", ""), f)
+}
+
+// UpdateFirewall replaces the firewall rule with the given one, and waits for
+// it to be updated.
+func (g *gceFirewalls) UpdateFirewall(f *FirewallRule) error {
+	return g.rest.DoOp("PUT", g.rest.GlobalURL("firewalls", f.Name), f)
+}
+
+// DeleteFirewall deletes the firewall rule with the given name, and waits for
+// it to be deleted.
+func (g *gceFirewalls) DeleteFirewall(name string) error {
+	return g.rest.DoOp("DELETE", g.rest.GlobalURL("firewalls", name), nil)
+}
@@ -16,25 +16,37 @@ limitations under the License.
 
 package firewalls
 
-import (
-	compute "google.golang.org/api/compute/v1"
-)
+// nodeOSLister looks up the operating system of Kubernetes nodes.
+type nodeOSLister interface {
+	IsWindowsNode(name string) bool
+}
 
 // SingleFirewallPool syncs the firewall rule for L7 traffic.
 type SingleFirewallPool interface {
+	// Init sets the lister used to tell the Windows nodes apart.
+	Init(nl nodeOSLister)
 	// TODO: Take a list of node ports for the firewall.
-	Sync(nodePorts []int64, nodeNames []string) error
+	Sync(nodePorts []int64, negPorts []int64, nodeNames []string, additionalRanges []string, additionalNetworks []string) error
 	Shutdown() error
+	// RepairDrift repairs the firewall rules if they drifted from the last
+	// Sync, and returns a description of each repair.
+	RepairDrift() ([]string, error)
+	// SetSrcRanges replaces the source ranges the pool was configured with,
+	// from the next Sync. Empty ranges restore them.
+	SetSrcRanges(srcRanges []string) error
 }
 
 // Firewall interfaces with the GCE firewall api.
 // This interface is a little different from the rest because it dovetails into
 // the same firewall methods used by the TCPLoadBalancer.
 type Firewall interface {
-	CreateFirewall(f *compute.Firewall) error
-	GetFirewall(name string) (*compute.Firewall, error)
+	CreateFirewall(f *FirewallRule) error
+	GetFirewall(name string) (*FirewallRule, error)
+	// ListFirewalls returns the firewall rules whose name starts with the
+	// given prefix.
+	ListFirewalls(prefix string) ([]*FirewallRule, error)
 	DeleteFirewall(name string) error
-	UpdateFirewall(f *compute.Firewall) error
+	UpdateFirewall(f *FirewallRule) error
 	GetNodeTags(nodeNames []string) ([]string, error)
 	NetworkProjectID() string
 	NetworkURL() string
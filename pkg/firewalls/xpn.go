@@ -0,0 +1,142 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewalls
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// FirewallChangeCreate denotes a firewall rule that needs to be created.
+	FirewallChangeCreate = "create"
+	// FirewallChangeUpdate denotes a firewall rule that needs to be updated.
+	FirewallChangeUpdate = "update"
+	// FirewallChangeDelete denotes a firewall rule that needs to be deleted.
+	FirewallChangeDelete = "delete"
+)
+
+// FirewallChange describes a firewall change the controller is not allowed
+// to make itself, eg: on a Shared VPC (XPN) cluster without firewall
+// permissions in the host project. It is serialized as JSON so automation
+// in the host project can consume it.
+type FirewallChange struct {
+	// Operation is one of create, update or delete.
+	Operation string `json:"operation"`
+	// Project is the project owning the network, i.e the XPN host project.
+	Project string `json:"project"`
+	// Network is the name of the network the rule applies to.
+	Network string `json:"network,omitempty"`
+	// Name is the name of the firewall rule.
+	Name string `json:"name"`
+	// Description is the description of the firewall rule.
+	Description string `json:"description,omitempty"`
+	// SourceRanges are the CIDRs allowed by the rule.
+	SourceRanges []string `json:"sourceRanges,omitempty"`
+	// TargetTags are the instance tags the rule applies to.
+	TargetTags []string `json:"targetTags,omitempty"`
+	// TargetServiceAccounts are the service accounts the rule applies to.
+	TargetServiceAccounts []string `json:"targetServiceAccounts,omitempty"`
+	// Allowed is the list of protocol:port pairs allowed by the rule.
+	Allowed []string `json:"allowed,omitempty"`
+	// EnableLogging is true if firewall rules logging is enabled on the rule.
+	EnableLogging bool `json:"enableLogging,omitempty"`
+	// LoggingMetadata is INCLUDE_ALL_METADATA or EXCLUDE_ALL_METADATA if
+	// logging is enabled.
+	LoggingMetadata string `json:"loggingMetadata,omitempty"`
+	// Command is the gcloud command that applies the change.
+	Command string `json:"command"`
+}
+
+// newFirewallChange returns the change required to apply the given
+// operation for the firewall rule in the given project.
+func newFirewallChange(operation string, fw *FirewallRule, projectID string) *FirewallChange {
+	change := &FirewallChange{
+		Operation:             operation,
+		Project:               projectID,
+		Network:               getNameFromLink(fw.Network),
+		Name:                  fw.Name,
+		Description:           fw.Description,
+		SourceRanges:          fw.SourceRanges,
+		TargetTags:            fw.TargetTags,
+		TargetServiceAccounts: fw.TargetServiceAccounts,
+		EnableLogging:         loggingEnabled(fw),
+		LoggingMetadata:       loggingMetadata(fw),
+	}
+	for _, a := range fw.Allowed {
+		for _, p := range a.Ports {
+			change.Allowed = append(change.Allowed, fmt.Sprintf("%v:%v", a.IPProtocol, p))
+		}
+	}
+	if operation == FirewallChangeDelete {
+		// Only the name is relevant when deleting.
+		change.Network = ""
+		change.Description = ""
+		change.SourceRanges = nil
+		change.TargetTags = nil
+		change.TargetServiceAccounts = nil
+		change.Allowed = nil
+		change.EnableLogging = false
+		change.LoggingMetadata = ""
+	}
+	change.Command = change.gcloudCommand()
+	return change
+}
+
+// gcloudCommand returns the gcloud command a network admin needs to run to
+// apply the change.
+func (c *FirewallChange) gcloudCommand() string {
+	args := []string{"gcloud", "compute", "firewall-rules", c.Operation, c.Name, "--project", c.Project}
+	if c.Operation == FirewallChangeDelete {
+		return strings.Join(args, " ")
+	}
+	// The network of an existing rule cannot be changed.
+	if c.Operation == FirewallChangeCreate && c.Network != "" {
+		args = append(args, "--network", c.Network)
+	}
+	if c.Description != "" {
+		args = append(args, "--description", fmt.Sprintf("%q", c.Description))
+	}
+	args = append(args, "--allow", strings.Join(c.Allowed, ","))
+	args = append(args, "--source-ranges", strings.Join(c.SourceRanges, ","))
+	if len(c.TargetTags) > 0 {
+		args = append(args, "--target-tags", strings.Join(c.TargetTags, ","))
+	}
+	if len(c.TargetServiceAccounts) > 0 {
+		args = append(args, "--target-service-accounts", strings.Join(c.TargetServiceAccounts, ","))
+	}
+	if c.EnableLogging {
+		args = append(args, "--enable-logging")
+		if c.LoggingMetadata == excludeAllMetadata {
+			args = append(args, "--logging-metadata", "exclude-all")
+		} else {
+			args = append(args, "--logging-metadata", "include-all")
+		}
+	} else if c.Operation == FirewallChangeUpdate {
+		args = append(args, "--no-enable-logging")
+	}
+	return strings.Join(args, " ")
+}
+
+// getNameFromLink returns the last component of a resource link.
+func getNameFromLink(link string) string {
+	if link == "" {
+		return ""
+	}
+	parts := strings.Split(link, "/")
+	return parts[len(parts)-1]
+}
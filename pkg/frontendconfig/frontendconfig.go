@@ -0,0 +1,145 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontendconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// QuicOverrideNone lets GCE decide whether to negotiate QUIC.
+	QuicOverrideNone = "NONE"
+	// QuicOverrideEnable lets clients negotiate QUIC.
+	QuicOverrideEnable = "ENABLE"
+	// QuicOverrideDisable never negotiates QUIC.
+	QuicOverrideDisable = "DISABLE"
+)
+
+// validRedirectResponseCodes are the response codes of the HTTP to HTTPS
+// redirects.
+var validRedirectResponseCodes = sets.NewString("MOVED_PERMANENTLY_DEFAULT", "FOUND", "SEE_OTHER", "TEMPORARY_REDIRECT", "PERMANENT_REDIRECT")
+
+// gceNameRegexp matches valid GCE resource names.
+var gceNameRegexp = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// FrontendConfigGetter is the interface for retrieving FrontendConfigs.
+type FrontendConfigGetter interface {
+	// Get returns the FrontendConfig with the given namespace and name.
+	Get(namespace, name string) (*FrontendConfig, error)
+}
+
+// APIServerFrontendConfigGetter retrieves FrontendConfigs from the Kubernetes
+// apiserver.
+type APIServerFrontendConfigGetter struct {
+	Client kubernetes.Interface
+}
+
+// Ensure that APIServerFrontendConfigGetter implements FrontendConfigGetter.
+var _ FrontendConfigGetter = &APIServerFrontendConfigGetter{}
+
+// Get retrieves the FrontendConfig through the generic REST client, since
+// there is no generated client for the resource.
+// TODO: Replace this with an informer once the resource has a generated
+// client.
+func (g *APIServerFrontendConfigGetter) Get(namespace, name string) (*FrontendConfig, error) {
+	restClient := g.Client.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, &RetrievalError{Namespace: namespace, Name: name, Err: fmt.Errorf("no REST client")}
+	}
+	logging.V(3).Infof("Retrieving FrontendConfig %v/%v", namespace, name)
+	data, err := restClient.Get().AbsPath("/apis", GroupName, Version, "namespaces", namespace, Resource, name).DoRaw()
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("FrontendConfig %v/%v not found", namespace, name)
+	} else if err != nil {
+		return nil, &RetrievalError{Namespace: namespace, Name: name, Err: err}
+	}
+	config := &FrontendConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode FrontendConfig %v/%v: %v", namespace, name, err)
+	}
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate returns an error if the given FrontendConfig is invalid. Whether
+// the SSL policy exists is checked when the load balancer is synced.
+func Validate(config *FrontendConfig) error {
+	if name := config.Spec.SslPolicy; name != nil && *name != "" && !gceNameRegexp.MatchString(*name) {
+		return fmt.Errorf("FrontendConfig %v/%v: sslPolicy %q is not a valid GCE resource name", config.Namespace, config.Name, *name)
+	}
+	if name := config.Spec.CertificateMap; name != nil && *name != "" && !gceNameRegexp.MatchString(*name) {
+		return fmt.Errorf("FrontendConfig %v/%v: certificateMap %q is not a valid resource name", config.Namespace, config.Name, *name)
+	}
+	switch config.Spec.QuicOverride {
+	case "", QuicOverrideNone, QuicOverrideEnable, QuicOverrideDisable:
+	default:
+		return fmt.Errorf("FrontendConfig %v/%v: invalid quicOverride %q", config.Namespace, config.Name, config.Spec.QuicOverride)
+	}
+	if redirect := config.Spec.RedirectToHttps; redirect != nil && redirect.ResponseCodeName != "" && !validRedirectResponseCodes.Has(redirect.ResponseCodeName) {
+		return fmt.Errorf("FrontendConfig %v/%v: invalid redirectToHttps.responseCodeName %q", config.Namespace, config.Name, redirect.ResponseCodeName)
+	}
+	return nil
+}
+
+// RetrievalError is returned when a FrontendConfig can't be retrieved, eg: because
+// the apiserver is unavailable. Unlike a FrontendConfig which doesn't exist or is
+// invalid, the FrontendConfig may be fine.
+type RetrievalError struct {
+	Namespace string
+	Name      string
+	Err       error
+}
+
+func (e *RetrievalError) Error() string {
+	return fmt.Sprintf("failed to get FrontendConfig %v/%v: %v", e.Namespace, e.Name, e.Err)
+}
+
+// FakeFrontendConfigGetter fakes out FrontendConfig retrieval.
+type FakeFrontendConfigGetter struct {
+	// Configs are keyed by namespace/name.
+	Configs map[string]*FrontendConfig
+	// Errs are returned by Get instead of the Configs with the same key.
+	Errs map[string]error
+}
+
+// Ensure that FakeFrontendConfigGetter implements FrontendConfigGetter.
+var _ FrontendConfigGetter = &FakeFrontendConfigGetter{}
+
+// Get returns the fake FrontendConfig with the given namespace and name.
+func (f *FakeFrontendConfigGetter) Get(namespace, name string) (*FrontendConfig, error) {
+	if err := f.Errs[namespace+"/"+name]; err != nil {
+		return nil, err
+	}
+	config, ok := f.Configs[namespace+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("FrontendConfig %v/%v not found", namespace, name)
+	}
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontendconfig
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	policy, empty, invalid := "modern-tls", "", "Modern_TLS"
+	testCases := []struct {
+		desc    string
+		spec    FrontendConfigSpec
+		wantErr bool
+	}{
+		{
+			desc: "empty spec",
+		},
+		{
+			desc: "ssl policy",
+			spec: FrontendConfigSpec{SslPolicy: &policy},
+		},
+		{
+			desc: "detached ssl policy",
+			spec: FrontendConfigSpec{SslPolicy: &empty},
+		},
+		{
+			desc:    "invalid ssl policy name",
+			spec:    FrontendConfigSpec{SslPolicy: &invalid},
+			wantErr: true,
+		},
+		{
+			desc: "quic override",
+			spec: FrontendConfigSpec{QuicOverride: QuicOverrideEnable},
+		},
+		{
+			desc:    "invalid quic override",
+			spec:    FrontendConfigSpec{QuicOverride: "enabled"},
+			wantErr: true,
+		},
+		{
+			desc: "certificate map",
+			spec: FrontendConfigSpec{CertificateMap: &policy},
+		},
+		{
+			desc:    "invalid certificate map name",
+			spec:    FrontendConfigSpec{CertificateMap: &invalid},
+			wantErr: true,
+		},
+		{
+			desc: "https redirect",
+			spec: FrontendConfigSpec{RedirectToHttps: &HttpsRedirectConfig{Enabled: true, ResponseCodeName: "PERMANENT_REDIRECT"}},
+		},
+		{
+			desc:    "invalid https redirect response code",
+			spec:    FrontendConfigSpec{RedirectToHttps: &HttpsRedirectConfig{Enabled: true, ResponseCodeName: "308"}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		err := Validate(&FrontendConfig{Spec: tc.spec})
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+	}
+}
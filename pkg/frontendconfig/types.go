@@ -0,0 +1,73 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontendconfig
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GroupName is the API group of the FrontendConfig resource.
+	GroupName = "cloud.google.com"
+	// Version is the API version of the FrontendConfig resource.
+	Version = "v1beta1"
+	// Resource is the plural resource name of FrontendConfig.
+	Resource = "frontendconfigs"
+)
+
+// FrontendConfig is the configuration of the GCE frontend, the target proxies
+// and forwarding rules, of an Ingress, referenced through the frontend config
+// annotation on the Ingress.
+type FrontendConfig struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec FrontendConfigSpec `json:"spec,omitempty"`
+}
+
+// FrontendConfigSpec is the spec of a FrontendConfig. Features which are not
+// set are left untouched on the load balancer.
+type FrontendConfigSpec struct {
+	// SslPolicy is the name of an existing SSL policy, in the project of the
+	// cluster, attached to the target HTTPS proxy. It sets the minimum TLS
+	// version and the cipher profile. An empty name detaches the current
+	// policy.
+	SslPolicy *string `json:"sslPolicy,omitempty"`
+	// QuicOverride controls the negotiation of QUIC, and HTTP/3, by the
+	// target HTTPS proxy: one of NONE, ENABLE or DISABLE. NONE lets GCE
+	// decide. Left untouched if empty.
+	QuicOverride string `json:"quicOverride,omitempty"`
+	// CertificateMap is the name of a Certificate Manager certificate map, in
+	// the project of the cluster, attached to the target HTTPS proxy instead
+	// of the certificates of the Ingress. It is exclusive with spec.tls, the
+	// pre-shared certs and the managed certificates. An empty name detaches
+	// the current map.
+	CertificateMap *string `json:"certificateMap,omitempty"`
+	// RedirectToHttps redirects the HTTP requests to HTTPS instead of serving
+	// them from the backends. Ignored unless the Ingress allows HTTP and
+	// terminates TLS.
+	RedirectToHttps *HttpsRedirectConfig `json:"redirectToHttps,omitempty"`
+}
+
+// HttpsRedirectConfig is the HTTP to HTTPS redirect of a FrontendConfig.
+type HttpsRedirectConfig struct {
+	Enabled bool `json:"enabled"`
+	// ResponseCodeName is the status of the redirect: one of
+	// MOVED_PERMANENTLY_DEFAULT (301), FOUND (302), SEE_OTHER (303),
+	// TEMPORARY_REDIRECT (307) or PERMANENT_REDIRECT (308). 301 if empty.
+	ResponseCodeName string `json:"responseCodeName,omitempty"`
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/tls"
+)
+
+// Plural resource names of the Gateway API resources.
+const (
+	gatewayClassResource = "gatewayclasses"
+	gatewayResource      = "gateways"
+	httpRouteResource    = "httproutes"
+)
+
+// APIServerClient reads and writes the Gateway API resources in the
+// Kubernetes apiserver, through the generic REST client since there is no
+// generated client for them.
+type APIServerClient struct {
+	Client kubernetes.Interface
+}
+
+// Ensure that APIServerClient implements Client.
+var _ Client = &APIServerClient{}
+
+// path returns the path of the given resource, of the collection if name is
+// empty. namespace is empty for cluster-scoped resources, and for the
+// collections of all namespaces.
+func path(namespace, resource, name string, subresources ...string) []string {
+	segments := []string{"/apis", GroupName, Version}
+	if namespace != "" {
+		segments = append(segments, "namespaces", namespace)
+	}
+	segments = append(segments, resource)
+	if name != "" {
+		segments = append(segments, name)
+	}
+	return append(segments, subresources...)
+}
+
+// grantPath returns the path of the given ReferenceGrant, of the collection if
+// name is empty. The ReferenceGrants have their own API version.
+func grantPath(namespace, name string) []string {
+	segments := []string{"/apis", tls.ReferenceGrantGroupName, tls.ReferenceGrantVersion}
+	if namespace != "" {
+		segments = append(segments, "namespaces", namespace)
+	}
+	segments = append(segments, tls.ReferenceGrantResource)
+	if name != "" {
+		segments = append(segments, name)
+	}
+	return segments
+}
+
+func (c *APIServerClient) restClient() (rest.Interface, error) {
+	restClient := c.Client.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, fmt.Errorf("no REST client for the Gateway API")
+	}
+	return restClient, nil
+}
+
+// list decodes the given collection into list.
+func (c *APIServerClient) list(namespace, resource string, list interface{}) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	data, err := restClient.Get().AbsPath(path(namespace, resource, "")...).DoRaw()
+	if err != nil {
+		return fmt.Errorf("failed to list %v: %v", resource, err)
+	}
+	if err := json.Unmarshal(data, list); err != nil {
+		return fmt.Errorf("failed to decode %v: %v", resource, err)
+	}
+	return nil
+}
+
+// updateStatus writes the status subresource of the given object.
+func (c *APIServerClient) updateStatus(namespace, resource, name string, obj interface{}) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	logging.V(3).Infof("Updating the status of %v %v/%v", resource, namespace, name)
+	if _, err := restClient.Put().AbsPath(path(namespace, resource, name, "status")...).SetHeader("Content-Type", "application/json").Body(data).DoRaw(); err != nil {
+		return fmt.Errorf("failed to update the status of %v %v/%v: %v", resource, namespace, name, err)
+	}
+	return nil
+}
+
+// ListGatewayClasses implements Client.
+func (c *APIServerClient) ListGatewayClasses() ([]GatewayClass, error) {
+	list := &GatewayClassList{}
+	if err := c.list("", gatewayClassResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListGateways implements Client.
+func (c *APIServerClient) ListGateways(namespace string) ([]Gateway, error) {
+	list := &GatewayList{}
+	if err := c.list(namespace, gatewayResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListHTTPRoutes implements Client.
+func (c *APIServerClient) ListHTTPRoutes(namespace string) ([]HTTPRoute, error) {
+	list := &HTTPRouteList{}
+	if err := c.list(namespace, httpRouteResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// UpdateGatewayClassStatus implements Client.
+func (c *APIServerClient) UpdateGatewayClassStatus(class *GatewayClass) error {
+	return c.updateStatus("", gatewayClassResource, class.Name, class)
+}
+
+// UpdateGatewayStatus implements Client.
+func (c *APIServerClient) UpdateGatewayStatus(gw *Gateway) error {
+	return c.updateStatus(gw.Namespace, gatewayResource, gw.Name, gw)
+}
+
+// UpdateHTTPRouteStatus implements Client.
+func (c *APIServerClient) UpdateHTTPRouteStatus(route *HTTPRoute) error {
+	return c.updateStatus(route.Namespace, httpRouteResource, route.Name, route)
+}
+
+// ListReferenceGrants implements Client.
+func (c *APIServerClient) ListReferenceGrants(namespace string) ([]tls.ReferenceGrant, error) {
+	restClient, err := c.restClient()
+	if err != nil {
+		return nil, err
+	}
+	data, err := restClient.Get().AbsPath(grantPath(namespace, "")...).Param("labelSelector", ManagedByLabelKey+"=true").DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v: %v", tls.ReferenceGrantResource, err)
+	}
+	list := &tls.ReferenceGrantList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", tls.ReferenceGrantResource, err)
+	}
+	return list.Items, nil
+}
+
+// CreateReferenceGrant implements Client.
+func (c *APIServerClient) CreateReferenceGrant(grant *tls.ReferenceGrant) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	if _, err := restClient.Post().AbsPath(grantPath(grant.Namespace, "")...).SetHeader("Content-Type", "application/json").Body(data).DoRaw(); err != nil {
+		return fmt.Errorf("failed to create ReferenceGrant %v/%v: %v", grant.Namespace, grant.Name, err)
+	}
+	return nil
+}
+
+// UpdateReferenceGrant implements Client.
+func (c *APIServerClient) UpdateReferenceGrant(grant *tls.ReferenceGrant) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	if _, err := restClient.Put().AbsPath(grantPath(grant.Namespace, grant.Name)...).SetHeader("Content-Type", "application/json").Body(data).DoRaw(); err != nil {
+		return fmt.Errorf("failed to update ReferenceGrant %v/%v: %v", grant.Namespace, grant.Name, err)
+	}
+	return nil
+}
+
+// DeleteReferenceGrant implements Client.
+func (c *APIServerClient) DeleteReferenceGrant(namespace, name string) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	if _, err := restClient.Delete().AbsPath(grantPath(namespace, name)...).DoRaw(); err != nil {
+		return fmt.Errorf("failed to delete ReferenceGrant %v/%v: %v", namespace, name, err)
+	}
+	return nil
+}
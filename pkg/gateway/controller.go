@@ -0,0 +1,596 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/tls"
+)
+
+// Controller translates the Gateways of the GatewayClasses of the controller,
+// and the HTTPRoutes attached to them, into managed Ingresses, and reports
+// their status.
+type Controller struct {
+	client     Client
+	kubeClient kubernetes.Interface
+	// namespace is the namespace of the Gateways and routes, all of them
+	// if empty.
+	namespace string
+}
+
+// NewController returns a Controller of the Gateways and routes of the given
+// namespace, of all namespaces if empty.
+func NewController(client Client, kubeClient kubernetes.Interface, namespace string) *Controller {
+	return &Controller{client: client, kubeClient: kubeClient, namespace: namespace}
+}
+
+// Run syncs the Gateways every period until stopCh is closed.
+func (c *Controller) Run(period time.Duration, stopCh <-chan struct{}) {
+	logging.Infof("Starting the Gateway controller, syncing every %v", period)
+	wait.Until(func() {
+		if err := c.Sync(); err != nil {
+			logging.Errorf("Failed to sync the Gateways: %v", err)
+		}
+	}, period, stopCh)
+}
+
+// Sync translates all the Gateways and routes, creates, updates and deletes
+// the managed Ingresses accordingly, and updates the status of the Gateway API
+// resources.
+func (c *Controller) Sync() error {
+	classes, err := c.client.ListGatewayClasses()
+	if err != nil {
+		return err
+	}
+	gws, err := c.client.ListGateways(c.namespace)
+	if err != nil {
+		return err
+	}
+	routes, err := c.client.ListHTTPRoutes(c.namespace)
+	if err != nil {
+		return err
+	}
+	existing, err := c.kubeClient.ExtensionsV1beta1().Ingresses(c.namespace).List(meta_v1.ListOptions{LabelSelector: ManagedByLabelKey + "=true"})
+	if err != nil {
+		return fmt.Errorf("failed to list the managed Ingresses: %v", err)
+	}
+	current := map[string]*extensions.Ingress{}
+	for i := range existing.Items {
+		ing := &existing.Items[i]
+		current[ing.Namespace+"/"+ing.Name] = ing
+	}
+	grants, err := c.client.ListReferenceGrants(c.namespace)
+	if err != nil {
+		return err
+	}
+	currentGrants := map[string]*tls.ReferenceGrant{}
+	for i := range grants {
+		grant := &grants[i]
+		currentGrants[grant.Namespace+"/"+grant.Name] = grant
+	}
+
+	ours := map[string]bool{}
+	var errs []string
+	for i := range classes {
+		class := &classes[i]
+		if class.Spec.ControllerName != ControllerName {
+			continue
+		}
+		ours[class.Name] = true
+		status := class.Status
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionAccepted, true, class.Generation, "Accepted", ""))
+		if !reflect.DeepEqual(status, class.Status) {
+			class.Status = status
+			if err := c.client.UpdateGatewayClassStatus(class); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+
+	s := &syncState{
+		controller:  c,
+		current:     current,
+		desired:     map[string]*extensions.Ingress{},
+		grants:      map[string]*tls.ReferenceGrant{},
+		routeStatus: map[string][]RouteParentStatus{},
+		nsLabels:    map[string]map[string]string{},
+	}
+	for i := range gws {
+		gw := &gws[i]
+		if !ours[gw.Spec.GatewayClassName] || gw.DeletionTimestamp != nil {
+			continue
+		}
+		status := s.syncGateway(gw, routes)
+		if !reflect.DeepEqual(status, gw.Status) {
+			gw.Status = status
+			if err := c.client.UpdateGatewayStatus(gw); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	for i := range routes {
+		route := &routes[i]
+		if err := c.updateRouteStatus(route, s.routeStatus[route.Namespace+"/"+route.Name]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	// The Ingresses may only join the LB groups of other namespaces once
+	// granted, so the grants are created first, and deleted last.
+	if err := c.applyGrants(currentGrants, s.grants); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := c.apply(current, s.desired); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := c.deleteGrants(currentGrants, s.grants); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// syncState is the state of a sync of the Gateways.
+type syncState struct {
+	controller *Controller
+	// current and desired are the managed Ingresses, by namespace/name.
+	current, desired map[string]*extensions.Ingress
+	// grants are the desired ReferenceGrants, by namespace/name.
+	grants map[string]*tls.ReferenceGrant
+	// routeStatus are the statuses of the routes for the Gateways of the
+	// controller, by namespace/name of the route.
+	routeStatus map[string][]RouteParentStatus
+	// nsLabels caches the labels of the namespaces.
+	nsLabels map[string]map[string]string
+}
+
+func (s *syncState) namespaceLabels(namespace string) (map[string]string, error) {
+	if labels, ok := s.nsLabels[namespace]; ok {
+		return labels, nil
+	}
+	ns, err := s.controller.kubeClient.CoreV1().Namespaces().Get(namespace, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	s.nsLabels[namespace] = ns.Labels
+	return ns.Labels, nil
+}
+
+func (s *syncState) getService(namespace, name string) (*api_v1.Service, error) {
+	return s.controller.kubeClient.CoreV1().Services(namespace).Get(name, meta_v1.GetOptions{})
+}
+
+// syncGateway translates the given Gateway and the given routes attached to
+// it into the desired Ingresses, and returns its status.
+func (s *syncState) syncGateway(gw *Gateway, routes []HTTPRoute) GatewayStatus {
+	frontend := newIngress(gw, gw.Namespace, frontendName(gw), KindGateway, gw.ObjectMeta)
+	var results []*listenerResult
+	allowHTTP := false
+	for i := range gw.Spec.Listeners {
+		res := translateListener(gw, &gw.Spec.Listeners[i], &frontend.Spec.TLS)
+		results = append(results, res)
+		if res.accepted && res.listener.Protocol == ProtocolHTTP {
+			allowHTTP = true
+		}
+	}
+	if !allowHTTP {
+		frontend.Annotations[annotations.AllowHTTPKey] = "false"
+	}
+	var addressErr string
+	for _, addr := range gw.Spec.Addresses {
+		if addr.Type == nil || *addr.Type != AddressTypeNamed {
+			addressErr = "Only NamedAddress addresses are supported"
+			continue
+		}
+		frontend.Annotations[annotations.StaticIPNameKey] = addr.Value
+	}
+
+	accepted := false
+	for _, res := range results {
+		accepted = accepted || res.accepted
+	}
+	if accepted && addressErr == "" {
+		s.desired[frontend.Namespace+"/"+frontend.Name] = frontend
+	}
+	for i := range routes {
+		s.attachRoute(gw, &routes[i], results)
+	}
+	// The Ingresses of the routes of other namespaces join the LB group of
+	// the Gateway, which the Gateway grants by accepting them.
+	namespaces := sets.NewString()
+	for _, ing := range s.desired {
+		if ing.Annotations[GatewayKey] == gw.Namespace+"/"+gw.Name && ing.Namespace != gw.Namespace {
+			namespaces.Insert(ing.Namespace)
+		}
+	}
+	if namespaces.Len() > 0 {
+		grant := newReferenceGrant(gw, namespaces.List())
+		s.grants[grant.Namespace+"/"+grant.Name] = grant
+	}
+
+	status := gw.Status
+	status.Addresses = nil
+	if ing, ok := s.current[frontend.Namespace+"/"+frontend.Name]; ok && accepted {
+		for _, lb := range ing.Status.LoadBalancer.Ingress {
+			if lb.IP != "" {
+				ipType := "IPAddress"
+				status.Addresses = append(status.Addresses, GatewayStatusAddress{Type: &ipType, Value: lb.IP})
+			}
+		}
+	}
+	switch {
+	case addressErr != "":
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionAccepted, false, gw.Generation, "UnsupportedAddress", addressErr))
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionProgrammed, false, gw.Generation, "Invalid", addressErr))
+	case !accepted:
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionAccepted, false, gw.Generation, "ListenersNotValid", "None of the listeners is supported"))
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionProgrammed, false, gw.Generation, "Invalid", "None of the listeners is supported"))
+	default:
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionAccepted, true, gw.Generation, "Accepted", ""))
+		if len(status.Addresses) > 0 {
+			status.Conditions = setCondition(status.Conditions, newCondition(ConditionProgrammed, true, gw.Generation, "Programmed", ""))
+		} else {
+			status.Conditions = setCondition(status.Conditions, newCondition(ConditionProgrammed, false, gw.Generation, "Pending", "Waiting for the load balancer"))
+		}
+	}
+
+	old := map[string]ListenerStatus{}
+	for _, ls := range gw.Status.Listeners {
+		old[ls.Name] = ls
+	}
+	status.Listeners = nil
+	group := GroupName
+	for _, res := range results {
+		ls := ListenerStatus{
+			Name:           res.listener.Name,
+			SupportedKinds: []RouteGroupKind{{Group: &group, Kind: KindHTTPRoute}},
+			AttachedRoutes: res.attachedRoutes,
+			Conditions:     old[res.listener.Name].Conditions,
+		}
+		if res.accepted {
+			ls.Conditions = setCondition(ls.Conditions, newCondition(ConditionAccepted, true, gw.Generation, "Accepted", ""))
+		} else {
+			ls.SupportedKinds = nil
+			ls.Conditions = setCondition(ls.Conditions, newCondition(ConditionAccepted, false, gw.Generation, res.reason, res.message))
+		}
+		if res.resolvedRefs {
+			ls.Conditions = setCondition(ls.Conditions, newCondition(ConditionResolvedRefs, true, gw.Generation, "ResolvedRefs", ""))
+		} else {
+			ls.Conditions = setCondition(ls.Conditions, newCondition(ConditionResolvedRefs, false, gw.Generation, res.reason, res.message))
+		}
+		programmed := res.accepted && res.resolvedRefs && addressErr == ""
+		ls.Conditions = setCondition(ls.Conditions, newCondition(ConditionProgrammed, programmed, gw.Generation, map[bool]string{true: "Programmed", false: "Invalid"}[programmed], ""))
+		status.Listeners = append(status.Listeners, ls)
+	}
+	return status
+}
+
+// refersTo returns whether the given parent of the given route is the given
+// Gateway.
+func refersTo(ref *ParentReference, route *HTTPRoute, gw *Gateway) bool {
+	if ref.Group != nil && *ref.Group != GroupName || ref.Kind != nil && *ref.Kind != KindGateway {
+		return false
+	}
+	namespace := route.Namespace
+	if ref.Namespace != nil {
+		namespace = *ref.Namespace
+	}
+	return namespace == gw.Namespace && ref.Name == gw.Name
+}
+
+// attachRoute attaches the given route to the accepted listeners of the given
+// Gateway it refers to, and records the status of the route for the Gateway.
+func (s *syncState) attachRoute(gw *Gateway, route *HTTPRoute, results []*listenerResult) {
+	key := route.Namespace + "/" + route.Name
+	referenced := false
+	for i := range route.Spec.ParentRefs {
+		referenced = referenced || refersTo(&route.Spec.ParentRefs[i], route, gw)
+	}
+	if !referenced {
+		return
+	}
+	tr := translateRoute(route, s.getService)
+
+	var attached []*Listener
+	attachedRes := map[*listenerResult]bool{}
+	for i := range route.Spec.ParentRefs {
+		ref := &route.Spec.ParentRefs[i]
+		if !refersTo(ref, route, gw) {
+			continue
+		}
+		parent := RouteParentStatus{ParentRef: *ref, ControllerName: ControllerName}
+		var refAttached []*listenerResult
+		sectionFound := ref.SectionName == nil
+		reason, message := "NotAllowedByListeners", "No listener of the Gateway accepts the route"
+		for _, res := range results {
+			if ref.SectionName != nil && *ref.SectionName != res.listener.Name {
+				continue
+			}
+			sectionFound = true
+			if !res.accepted {
+				continue
+			}
+			allowed, err := allowsNamespace(gw, res.listener, route.Namespace, s.namespaceLabels)
+			if err != nil {
+				logging.Errorf("Failed to check whether listener %v of Gateway %v/%v accepts route %v: %v", res.listener.Name, gw.Namespace, gw.Name, key, err)
+				continue
+			}
+			if allowed {
+				refAttached = append(refAttached, res)
+			}
+		}
+		if !sectionFound {
+			reason, message = "NoMatchingParent", fmt.Sprintf("The Gateway has no listener %v", *ref.SectionName)
+		}
+		var listeners []*Listener
+		for _, res := range refAttached {
+			listeners = append(listeners, res.listener)
+		}
+		hosts := routeHosts(route, listeners)
+		if len(refAttached) > 0 && len(hosts) == 0 {
+			refAttached = nil
+			reason, message = "NoMatchingListenerHostname", "No hostname of the route matches the listeners"
+		}
+		// Programming the other rules of a route with unsupported rules
+		// would send the traffic of the unsupported ones to them.
+		if len(refAttached) > 0 && len(tr.unsupported) > 0 {
+			refAttached = nil
+			reason, message = "UnsupportedValue", strings.Join(tr.unsupported, "; ")
+		}
+		if len(refAttached) == 0 {
+			parent.Conditions = []Condition{newCondition(ConditionAccepted, false, route.Generation, reason, message)}
+		} else {
+			for _, res := range refAttached {
+				attachedRes[res] = true
+				attached = append(attached, res.listener)
+			}
+			parent.Conditions = []Condition{newCondition(ConditionAccepted, true, route.Generation, "Accepted", "")}
+		}
+		s.routeStatus[key] = append(s.routeStatus[key], parent)
+	}
+	for res := range attachedRes {
+		res.attachedRoutes++
+	}
+
+	resolved := newCondition(ConditionResolvedRefs, true, route.Generation, "ResolvedRefs", "")
+	if !tr.resolvedRefs {
+		resolved = newCondition(ConditionResolvedRefs, false, route.Generation, tr.reason, tr.message)
+	}
+	statuses := s.routeStatus[key]
+	for i := range statuses {
+		if !refersTo(&statuses[i].ParentRef, route, gw) {
+			continue
+		}
+		statuses[i].Conditions = append(statuses[i].Conditions, resolved)
+	}
+
+	// The routes of a Gateway whose load balancer can't be programmed are
+	// still reported, but not translated.
+	frontend, ok := s.desired[gw.Namespace+"/"+frontendName(gw)]
+	if !ok {
+		return
+	}
+	hosts := routeHosts(route, attached)
+	if len(attached) == 0 || len(tr.paths) == 0 && len(tr.rules) == 0 || len(hosts) == 0 {
+		return
+	}
+	ing := newIngress(gw, route.Namespace, routeName(route, gw), KindHTTPRoute, route.ObjectMeta)
+	ing.Spec.Rules = ingressRules(hosts, tr.paths)
+	if splits := trafficSplits(hosts, tr.splits); splits != "" {
+		ing.Annotations[annotations.TrafficSplitKey] = splits
+	}
+	if rules := routeRules(hosts, tr.rules); rules != "" {
+		ing.Annotations[annotations.RouteRulesKey] = rules
+	}
+	// Any member of the LB group may set the frontend of the load balancer.
+	for _, k := range []string{annotations.AllowHTTPKey, annotations.StaticIPNameKey} {
+		if v, ok := frontend.Annotations[k]; ok {
+			ing.Annotations[k] = v
+		}
+	}
+	s.desired[ing.Namespace+"/"+ing.Name] = ing
+}
+
+// updateRouteStatus replaces the statuses of the given route for the
+// Gateways of the controller with the given ones.
+func (c *Controller) updateRouteStatus(route *HTTPRoute, parents []RouteParentStatus) error {
+	old := map[string][]Condition{}
+	var status HTTPRouteStatus
+	for _, p := range route.Status.Parents {
+		if p.ControllerName == ControllerName {
+			old[parentKey(&p.ParentRef)] = p.Conditions
+			continue
+		}
+		status.Parents = append(status.Parents, p)
+	}
+	for _, p := range parents {
+		conditions := old[parentKey(&p.ParentRef)]
+		for _, cond := range p.Conditions {
+			conditions = setCondition(conditions, cond)
+		}
+		p.Conditions = conditions
+		status.Parents = append(status.Parents, p)
+	}
+	if reflect.DeepEqual(status, route.Status) || len(status.Parents) == 0 && len(route.Status.Parents) == 0 {
+		return nil
+	}
+	route.Status = status
+	return c.client.UpdateHTTPRouteStatus(route)
+}
+
+// parentKey identifies a parent of a route.
+func parentKey(ref *ParentReference) string {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	return strings.Join([]string{deref(ref.Namespace), ref.Name, deref(ref.SectionName)}, "/")
+}
+
+// apply creates, updates and deletes the managed Ingresses to match the
+// desired ones.
+func (c *Controller) apply(current, desired map[string]*extensions.Ingress) error {
+	var keys []string
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []string
+	for _, key := range keys {
+		want := desired[key]
+		client := c.kubeClient.ExtensionsV1beta1().Ingresses(want.Namespace)
+		have, ok := current[key]
+		if !ok {
+			logging.Infof("Creating Ingress %v for Gateway %v", key, want.Annotations[GatewayKey])
+			if _, err := client.Create(want); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to create Ingress %v: %v", key, err))
+			}
+			continue
+		}
+		updated := have.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		for _, k := range managedAnnotations {
+			delete(updated.Annotations, k)
+			if v, ok := want.Annotations[k]; ok {
+				updated.Annotations[k] = v
+			}
+		}
+		updated.Labels = want.Labels
+		updated.OwnerReferences = want.OwnerReferences
+		updated.Spec = want.Spec
+		if reflect.DeepEqual(updated, have) {
+			continue
+		}
+		logging.Infof("Updating Ingress %v for Gateway %v", key, want.Annotations[GatewayKey])
+		if _, err := client.Update(updated); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to update Ingress %v: %v", key, err))
+		}
+	}
+	for key, have := range current {
+		if _, ok := desired[key]; ok || have.DeletionTimestamp != nil {
+			continue
+		}
+		logging.Infof("Deleting Ingress %v of Gateway %v", key, have.Annotations[GatewayKey])
+		if err := c.kubeClient.ExtensionsV1beta1().Ingresses(have.Namespace).Delete(have.Name, &meta_v1.DeleteOptions{}); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to delete Ingress %v: %v", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// applyGrants creates and updates the managed ReferenceGrants to match the
+// desired ones.
+func (c *Controller) applyGrants(current, desired map[string]*tls.ReferenceGrant) error {
+	var errs []string
+	for key, want := range desired {
+		have, ok := current[key]
+		if !ok {
+			logging.Infof("Creating ReferenceGrant %v", key)
+			if err := c.client.CreateReferenceGrant(want); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		if reflect.DeepEqual(want.Spec, have.Spec) && reflect.DeepEqual(want.Labels, have.Labels) && reflect.DeepEqual(want.OwnerReferences, have.OwnerReferences) {
+			continue
+		}
+		updated := *want
+		updated.ResourceVersion = have.ResourceVersion
+		logging.Infof("Updating ReferenceGrant %v", key)
+		if err := c.client.UpdateReferenceGrant(&updated); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// deleteGrants deletes the managed ReferenceGrants which are not desired.
+func (c *Controller) deleteGrants(current, desired map[string]*tls.ReferenceGrant) error {
+	var errs []string
+	for key, have := range current {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		logging.Infof("Deleting ReferenceGrant %v", key)
+		if err := c.client.DeleteReferenceGrant(have.Namespace, have.Name); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// newCondition returns a condition of the given type, true or false.
+func newCondition(condType string, status bool, generation int64, reason, message string) Condition {
+	s := api_v1.ConditionFalse
+	if status {
+		s = api_v1.ConditionTrue
+	}
+	return Condition{
+		Type:               condType,
+		Status:             string(s),
+		ObservedGeneration: generation,
+		LastTransitionTime: meta_v1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// setCondition sets the given condition in conditions, keeping the
+// transition time of the current condition of the same type if its status is
+// unchanged.
+func setCondition(conditions []Condition, cond Condition) []Condition {
+	res := append([]Condition(nil), conditions...)
+	for i := range res {
+		if res[i].Type != cond.Type {
+			continue
+		}
+		if res[i].Status == cond.Status {
+			cond.LastTransitionTime = res[i].LastTransitionTime
+		}
+		res[i] = cond
+		return res
+	}
+	return append(res, cond)
+}
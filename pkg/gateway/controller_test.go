@@ -0,0 +1,464 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/tls"
+)
+
+func str(s string) *string { return &s }
+
+func port(p int32) *int32 { return &p }
+
+func newTestGateway() *Gateway {
+	return &Gateway{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "infra", Name: "gw", UID: "gw-uid"},
+		Spec: GatewaySpec{
+			GatewayClassName: "gce",
+			Listeners: []Listener{
+				{Name: "http", Port: 80, Protocol: ProtocolHTTP, AllowedRoutes: &AllowedRoutes{Namespaces: &RouteNamespaces{From: str(NamespacesFromAll)}}},
+				{Name: "https", Port: 443, Protocol: ProtocolHTTPS, Hostname: str("*.example.com"), TLS: &GatewayTLSConfig{CertificateRefs: []SecretReference{{Name: "cert"}}},
+					AllowedRoutes: &AllowedRoutes{Namespaces: &RouteNamespaces{From: str(NamespacesFromAll)}}},
+				{Name: "tcp", Port: 5432, Protocol: "TCP"},
+			},
+			Addresses: []GatewayAddress{{Type: str(AddressTypeNamed), Value: "gw-ip"}},
+		},
+	}
+}
+
+func newTestRoute() *HTTPRoute {
+	return &HTTPRoute{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "app", Name: "store", UID: "route-uid"},
+		Spec: HTTPRouteSpec{
+			ParentRefs: []ParentReference{{Namespace: str("infra"), Name: "gw", SectionName: str("https")}},
+			Hostnames:  []string{"store.example.com"},
+			Rules: []HTTPRouteRule{
+				{
+					Matches: []HTTPRouteMatch{
+						{Path: &HTTPPathMatch{Type: str(PathMatchPathPrefix), Value: str("/cart")}},
+						{Path: &HTTPPathMatch{Type: str(PathMatchExact), Value: str("/")}},
+					},
+					BackendRefs: []HTTPBackendRef{{Name: "cart", Port: port(8080)}},
+				},
+				{
+					BackendRefs: []HTTPBackendRef{{Name: "web", Port: port(80)}},
+				},
+			},
+		},
+	}
+}
+
+func newTestController(gws []Gateway, routes []HTTPRoute) (*Controller, *FakeClient) {
+	kubeClient := fake.NewSimpleClientset(
+		&api_v1.Service{ObjectMeta: meta_v1.ObjectMeta{Namespace: "app", Name: "cart"}},
+		&api_v1.Service{ObjectMeta: meta_v1.ObjectMeta{Namespace: "app", Name: "web"}},
+	)
+	client := &FakeClient{
+		Classes: []GatewayClass{
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "gce"}, Spec: GatewayClassSpec{ControllerName: ControllerName}},
+			{ObjectMeta: meta_v1.ObjectMeta{Name: "other"}, Spec: GatewayClassSpec{ControllerName: "example.com/other"}},
+		},
+		Gateways:   gws,
+		HTTPRoutes: routes,
+	}
+	return NewController(client, kubeClient, ""), client
+}
+
+func getIngress(t *testing.T, c *Controller, namespace, name string) *extensions.Ingress {
+	ing, err := c.kubeClient.ExtensionsV1beta1().Ingresses(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Ingress %v/%v: %v", namespace, name, err)
+	}
+	return ing
+}
+
+func findCondition(conditions []Condition, condType string) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == condType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	gw, route := newTestGateway(), newTestRoute()
+	c, client := newTestController([]Gateway{*gw}, []HTTPRoute{*route})
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+
+	if cond := findCondition(client.Classes[0].Status.Conditions, ConditionAccepted); cond == nil || cond.Status != "True" {
+		t.Errorf("GatewayClass Accepted condition = %+v, want True", cond)
+	}
+	if len(client.Classes[1].Status.Conditions) != 0 {
+		t.Errorf("GatewayClass of another controller has conditions %+v", client.Classes[1].Status.Conditions)
+	}
+
+	frontend := getIngress(t, c, "infra", frontendName(gw))
+	group := lbGroupRef(gw)
+	for k, want := range map[string]string{
+		annotations.IngressClassKey: annotations.GceIngressClass,
+		annotations.LBGroupKey:      group,
+		annotations.StaticIPNameKey: "gw-ip",
+		GatewayKey:                  "infra/gw",
+	} {
+		if got := frontend.Annotations[k]; got != want {
+			t.Errorf("frontend annotation %v = %q, want %q", k, got, want)
+		}
+	}
+	if _, ok := frontend.Annotations[annotations.AllowHTTPKey]; ok {
+		t.Errorf("frontend disallows HTTP, the Gateway has an HTTP listener")
+	}
+	if len(frontend.Spec.TLS) != 1 || frontend.Spec.TLS[0].SecretName != "cert" {
+		t.Errorf("frontend TLS = %+v, want secret cert", frontend.Spec.TLS)
+	}
+	if len(frontend.Spec.Rules) != 0 {
+		t.Errorf("frontend rules = %+v, want none", frontend.Spec.Rules)
+	}
+
+	ing := getIngress(t, c, "app", routeName(route, gw))
+	if got := ing.Annotations[annotations.LBGroupKey]; got != group {
+		t.Errorf("route Ingress LB group = %q, want %q", got, group)
+	}
+	if got := ing.Annotations[annotations.StaticIPNameKey]; got != "gw-ip" {
+		t.Errorf("route Ingress static IP = %q, want the one of the Gateway", got)
+	}
+	if len(ing.OwnerReferences) != 1 || ing.OwnerReferences[0].UID != "route-uid" {
+		t.Errorf("route Ingress owners = %+v, want the route", ing.OwnerReferences)
+	}
+	// The Gateway lets the Ingress of the route of namespace app join its LB
+	// group.
+	if len(client.Grants) != 1 {
+		t.Fatalf("ReferenceGrants = %+v, want one for the LB group", client.Grants)
+	}
+	if grant := client.Grants[0]; grant.Namespace != "infra" || !tls.IngressGranted(client.Grants, "app", tls.LBGroupGroupName, tls.LBGroupKind, lbGroup(gw)) || tls.IngressGranted(client.Grants, "other", tls.LBGroupGroupName, tls.LBGroupKind, lbGroup(gw)) {
+		t.Errorf("ReferenceGrant = %+v, want the Ingresses of namespace app to join LB group %v", grant, group)
+	}
+	if len(ing.Spec.Rules) != 1 || ing.Spec.Rules[0].Host != "store.example.com" {
+		t.Fatalf("route Ingress rules = %+v, want host store.example.com", ing.Spec.Rules)
+	}
+	var paths []string
+	for _, p := range ing.Spec.Rules[0].HTTP.Paths {
+		paths = append(paths, p.Path+"="+p.Backend.ServiceName+":"+p.Backend.ServicePort.String())
+	}
+	if want := []string{"/cart=cart:8080", "/cart/*=cart:8080", "/=cart:8080", "/*=web:80"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("route Ingress paths = %v, want %v", paths, want)
+	}
+
+	gwStatus := client.Gateways[0].Status
+	if cond := findCondition(gwStatus.Conditions, ConditionAccepted); cond == nil || cond.Status != "True" {
+		t.Errorf("Gateway Accepted condition = %+v, want True", cond)
+	}
+	if cond := findCondition(gwStatus.Conditions, ConditionProgrammed); cond == nil || cond.Status != "False" {
+		t.Errorf("Gateway Programmed condition = %+v, want False until the load balancer has an IP", cond)
+	}
+	attached := map[string]int32{}
+	for _, ls := range gwStatus.Listeners {
+		attached[ls.Name] = ls.AttachedRoutes
+		accepted := findCondition(ls.Conditions, ConditionAccepted)
+		if want := ls.Name != "tcp"; accepted == nil || (accepted.Status == "True") != want {
+			t.Errorf("listener %v Accepted condition = %+v, want %v", ls.Name, accepted, want)
+		}
+	}
+	if attached["https"] != 1 || attached["http"] != 0 {
+		t.Errorf("attached routes = %v, want 1 on https", attached)
+	}
+
+	parents := client.HTTPRoutes[0].Status.Parents
+	if len(parents) != 1 {
+		t.Fatalf("route parents = %+v, want 1", parents)
+	}
+	if cond := findCondition(parents[0].Conditions, ConditionAccepted); cond == nil || cond.Status != "True" {
+		t.Errorf("route Accepted condition = %+v, want True", cond)
+	}
+	if cond := findCondition(parents[0].Conditions, ConditionResolvedRefs); cond == nil || cond.Status != "True" {
+		t.Errorf("route ResolvedRefs condition = %+v, want True", cond)
+	}
+
+	// The load balancer gets an IP, and the Ingress controller annotates the
+	// Ingress.
+	frontend.Status.LoadBalancer.Ingress = []api_v1.LoadBalancerIngress{{IP: "1.2.3.4"}}
+	frontend.Annotations[annotations.BackendHealthKey] = "{}"
+	if _, err := c.kubeClient.ExtensionsV1beta1().Ingresses("infra").Update(frontend); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	gwStatus = client.Gateways[0].Status
+	if len(gwStatus.Addresses) != 1 || gwStatus.Addresses[0].Value != "1.2.3.4" {
+		t.Errorf("Gateway addresses = %+v, want 1.2.3.4", gwStatus.Addresses)
+	}
+	if cond := findCondition(gwStatus.Conditions, ConditionProgrammed); cond == nil || cond.Status != "True" {
+		t.Errorf("Gateway Programmed condition = %+v, want True", cond)
+	}
+	if got := getIngress(t, c, "infra", frontendName(gw)).Annotations[annotations.BackendHealthKey]; got != "{}" {
+		t.Errorf("backend health annotation = %q, want it preserved", got)
+	}
+
+	// The route moves to a Gateway of another controller.
+	client.HTTPRoutes[0].Spec.ParentRefs = []ParentReference{{Namespace: str("infra"), Name: "other"}}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if _, err := c.kubeClient.ExtensionsV1beta1().Ingresses("app").Get(routeName(route, gw), meta_v1.GetOptions{}); err == nil {
+		t.Errorf("route Ingress still exists after the route moved")
+	}
+	if len(client.Grants) != 0 {
+		t.Errorf("ReferenceGrants = %+v, want none without routes of other namespaces", client.Grants)
+	}
+	if parents := client.HTTPRoutes[0].Status.Parents; len(parents) != 0 {
+		t.Errorf("route parents = %+v, want none", parents)
+	}
+}
+
+func TestSyncWeightedBackends(t *testing.T) {
+	gw, route := newTestGateway(), newTestRoute()
+	route.Spec.Rules[1].BackendRefs = []HTTPBackendRef{
+		{Name: "web", Port: port(80), Weight: port(90)},
+		{Name: "cart", Port: port(8080), Weight: port(10)},
+		{Name: "cart", Port: port(8081), Weight: port(0)},
+	}
+	c, client := newTestController([]Gateway{*gw}, []HTTPRoute{*route})
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	ing := getIngress(t, c, "app", routeName(route, gw))
+	splits, err := annotations.IngAnnotations(ing.Annotations).TrafficSplits()
+	if err != nil {
+		t.Fatalf("TrafficSplits() = %v", err)
+	}
+	var got []string
+	for _, s := range splits {
+		for _, b := range s.Backends {
+			got = append(got, fmt.Sprintf("%v%v=%v:%v/%v", s.Host, s.Path, b.ServiceName, b.ServicePort.String(), b.Weight))
+		}
+	}
+	if want := []string{"store.example.com/*=web:80/90", "store.example.com/*=cart:8080/10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("traffic splits = %v, want %v", got, want)
+	}
+
+	// The split is removed with the weighted backend.
+	route.Spec.Rules[1].BackendRefs = route.Spec.Rules[1].BackendRefs[:1]
+	client.HTTPRoutes[0].Spec = route.Spec
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if v, ok := getIngress(t, c, "app", routeName(route, gw)).Annotations[annotations.TrafficSplitKey]; ok {
+		t.Errorf("traffic split annotation = %q, want none", v)
+	}
+}
+
+func TestSyncRouteRules(t *testing.T) {
+	gw, route := newTestGateway(), newTestRoute()
+	route.Spec.Rules = append(route.Spec.Rules, HTTPRouteRule{
+		Matches: []HTTPRouteMatch{{
+			Path:        &HTTPPathMatch{Type: str(PathMatchPathPrefix), Value: str("/cart")},
+			Headers:     []HTTPHeaderMatch{{Name: "x-canary", Value: "true"}},
+			QueryParams: []HTTPQueryParamMatch{{Type: str(MatchRegularExpression), Name: "v", Value: "2.*"}},
+			Method:      str("GET"),
+		}},
+		Filters: []HTTPRouteFilter{
+			{Type: FilterURLRewrite, URLRewrite: &HTTPURLRewriteFilter{Hostname: str("cart.internal"), Path: &HTTPPathModifier{Type: PathModifierReplacePrefixMatch, ReplacePrefixMatch: str("/v2")}}},
+			{Type: FilterRequestHeaderModifier, RequestHeaderModifier: &HTTPHeaderFilter{Set: []HTTPHeader{{Name: "x-version", Value: "2"}}, Remove: []string{"x-debug"}}},
+		},
+		BackendRefs: []HTTPBackendRef{{Name: "cart", Port: port(8081)}},
+	}, HTTPRouteRule{
+		Matches: []HTTPRouteMatch{{Path: &HTTPPathMatch{Type: str(PathMatchExact), Value: str("/old")}}},
+		Filters: []HTTPRouteFilter{{Type: FilterRequestRedirect, RequestRedirect: &HTTPRequestRedirectFilter{Scheme: str("https"), Path: &HTTPPathModifier{Type: PathModifierReplaceFullPath, ReplaceFullPath: str("/new")}, StatusCode: func(c int) *int { return &c }(302)}}},
+	})
+	c, _ := newTestController([]Gateway{*gw}, []HTTPRoute{*route})
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	ing := getIngress(t, c, "app", routeName(route, gw))
+	rules, err := annotations.IngAnnotations(ing.Annotations).RouteRules()
+	if err != nil {
+		t.Fatalf("RouteRules() = %v", err)
+	}
+	// The rules are ranked: exact paths first, then the longest paths, then
+	// the most specific matches.
+	var got []string
+	for _, r := range rules {
+		m := r.Matches[0]
+		desc := fmt.Sprintf("%v %d %v%v", r.Host, r.Priority, m.PrefixMatch, m.FullPathMatch)
+		if len(m.HeaderMatches) > 0 {
+			desc += fmt.Sprintf(" headers=%d params=%d", len(m.HeaderMatches), len(m.QueryParameterMatches))
+		}
+		switch {
+		case r.Redirect != nil:
+			desc += fmt.Sprintf(" redirect=%v,%v,%v", r.Redirect.PathRedirect, r.Redirect.HttpsRedirect, r.Redirect.RedirectResponseCode)
+		case r.Rewrite != nil:
+			desc += fmt.Sprintf(" rewrite=%v,%v", r.Rewrite.HostRewrite, r.Rewrite.PathPrefixRewrite)
+		}
+		for _, b := range r.Backends {
+			desc += " " + b.ServiceName + ":" + b.ServicePort.String()
+		}
+		got = append(got, desc)
+	}
+	want := []string{
+		"store.example.com 0 /old redirect=/new,true,FOUND",
+		"store.example.com 1 / cart:8080",
+		"store.example.com 2 /cart/ headers=2 params=1 rewrite=cart.internal,/v2/ cart:8081",
+		"store.example.com 3 /cart/ cart:8080",
+		"store.example.com 4 /cart headers=2 params=1 rewrite=cart.internal,/v2 cart:8081",
+		"store.example.com 5 /cart cart:8080",
+		"store.example.com 6 / web:80",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("route rules =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if h := rules[2].HeaderAction; h == nil || len(h.RequestHeadersToAdd) != 1 || !h.RequestHeadersToAdd[0].Replace || len(h.RequestHeadersToRemove) != 1 {
+		t.Errorf("header action = %+v, want x-version set and x-debug removed", h)
+	}
+	if len(ing.Spec.Rules) != 0 {
+		t.Errorf("route Ingress rules = %+v, want none with route rules", ing.Spec.Rules)
+	}
+}
+
+func TestSyncInvalidRoutes(t *testing.T) {
+	gw := newTestGateway()
+	gw.Spec.Listeners[0].AllowedRoutes = nil
+	testCases := []struct {
+		desc       string
+		mutate     func(*HTTPRoute)
+		condType   string
+		wantReason string
+	}{
+		{
+			desc: "namespace not allowed",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.ParentRefs[0].SectionName = str("http")
+			},
+			condType:   ConditionAccepted,
+			wantReason: "NotAllowedByListeners",
+		},
+		{
+			desc: "unknown listener",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.ParentRefs[0].SectionName = str("grpc")
+			},
+			condType:   ConditionAccepted,
+			wantReason: "NoMatchingParent",
+		},
+		{
+			desc: "hostname not served",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Hostnames = []string{"store.example.org"}
+			},
+			condType:   ConditionAccepted,
+			wantReason: "NoMatchingListenerHostname",
+		},
+		{
+			desc: "header match type",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[1].Matches = []HTTPRouteMatch{{Headers: []HTTPHeaderMatch{{Type: str("Prefix"), Name: "canary", Value: "true"}}}}
+			},
+			condType:   ConditionAccepted,
+			wantReason: "UnsupportedValue",
+		},
+		{
+			desc: "filter",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[1].Filters = []HTTPRouteFilter{{Type: "RequestMirror"}}
+			},
+			condType:   ConditionAccepted,
+			wantReason: "UnsupportedValue",
+		},
+		{
+			desc: "redirect to a port",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[1].Filters = []HTTPRouteFilter{{Type: FilterRequestRedirect, RequestRedirect: &HTTPRequestRedirectFilter{Port: port(8443)}}}
+			},
+			condType:   ConditionAccepted,
+			wantReason: "UnsupportedValue",
+		},
+		{
+			desc: "full path rewrite of a prefix",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[1].Filters = []HTTPRouteFilter{{Type: FilterURLRewrite, URLRewrite: &HTTPURLRewriteFilter{Path: &HTTPPathModifier{Type: PathModifierReplaceFullPath, ReplaceFullPath: str("/")}}}}
+			},
+			condType:   ConditionAccepted,
+			wantReason: "UnsupportedValue",
+		},
+		{
+			desc: "weight above the GCE maximum",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[1].BackendRefs = append(r.Spec.Rules[1].BackendRefs, HTTPBackendRef{Name: "cart", Port: port(8080), Weight: port(maxWeight + 1)})
+			},
+			condType:   ConditionAccepted,
+			wantReason: "UnsupportedValue",
+		},
+		{
+			desc: "unsupported path",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[0].Matches[0].Path.Type = str("RegularExpression")
+			},
+			condType:   ConditionAccepted,
+			wantReason: "UnsupportedValue",
+		},
+		{
+			desc: "cross-namespace backend",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[1].BackendRefs[0].Namespace = str("infra")
+			},
+			condType:   ConditionResolvedRefs,
+			wantReason: "RefNotPermitted",
+		},
+		{
+			desc: "missing Service",
+			mutate: func(r *HTTPRoute) {
+				r.Spec.Rules[1].BackendRefs[0].Name = "missing"
+			},
+			condType:   ConditionResolvedRefs,
+			wantReason: "BackendNotFound",
+		},
+	}
+	for _, tc := range testCases {
+		route := newTestRoute()
+		tc.mutate(route)
+		c, client := newTestController([]Gateway{*gw}, []HTTPRoute{*route})
+		if err := c.Sync(); err != nil {
+			t.Fatalf("%s: Sync() = %v", tc.desc, err)
+		}
+		parents := client.HTTPRoutes[0].Status.Parents
+		if len(parents) != 1 {
+			t.Errorf("%s: route parents = %+v, want 1", tc.desc, parents)
+			continue
+		}
+		if cond := findCondition(parents[0].Conditions, tc.condType); cond == nil || cond.Status != "False" || cond.Reason != tc.wantReason {
+			t.Errorf("%s: route %v condition = %+v, want False with reason %v", tc.desc, tc.condType, cond, tc.wantReason)
+		}
+		// None of the rules of a route which isn't accepted is programmed.
+		_, err := c.kubeClient.ExtensionsV1beta1().Ingresses("app").Get(routeName(route, gw), meta_v1.GetOptions{})
+		if programmed := err == nil; tc.condType == ConditionAccepted && programmed {
+			t.Errorf("%s: route Ingress exists, want the route not programmed", tc.desc)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gateway implements the Gateway API, GatewayClasses, Gateways and
+// HTTPRoutes, on top of the Ingress controller. Each Gateway of a
+// GatewayClass of the controller is served by the load balancer of an LB
+// group, made of managed Ingresses: one in the namespace of the Gateway
+// carrying its listeners, and one per attached HTTPRoute carrying its rules.
+// The Ingress controller then syncs them like any other Ingress, sharing the
+// backend services, NEGs and instance groups of the cluster, and their status
+// is reported back on the Gateway API resources.
+package gateway
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"sync"
+
+	"k8s.io/ingress-gce/pkg/tls"
+)
+
+// FakeClient is a fake Client, keeping the resources in memory.
+type FakeClient struct {
+	mu         sync.Mutex
+	Classes    []GatewayClass
+	Gateways   []Gateway
+	HTTPRoutes []HTTPRoute
+	Grants     []tls.ReferenceGrant
+}
+
+// Ensure that FakeClient implements Client.
+var _ Client = &FakeClient{}
+
+// ListGatewayClasses implements Client.
+func (f *FakeClient) ListGatewayClasses() ([]GatewayClass, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]GatewayClass(nil), f.Classes...), nil
+}
+
+// ListGateways implements Client.
+func (f *FakeClient) ListGateways(namespace string) ([]Gateway, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var gws []Gateway
+	for _, gw := range f.Gateways {
+		if namespace == "" || gw.Namespace == namespace {
+			gws = append(gws, gw)
+		}
+	}
+	return gws, nil
+}
+
+// ListHTTPRoutes implements Client.
+func (f *FakeClient) ListHTTPRoutes(namespace string) ([]HTTPRoute, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var routes []HTTPRoute
+	for _, route := range f.HTTPRoutes {
+		if namespace == "" || route.Namespace == namespace {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+// UpdateGatewayClassStatus implements Client.
+func (f *FakeClient) UpdateGatewayClassStatus(class *GatewayClass) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.Classes {
+		if f.Classes[i].Name == class.Name {
+			f.Classes[i].Status = class.Status
+		}
+	}
+	return nil
+}
+
+// UpdateGatewayStatus implements Client.
+func (f *FakeClient) UpdateGatewayStatus(gw *Gateway) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.Gateways {
+		if f.Gateways[i].Namespace == gw.Namespace && f.Gateways[i].Name == gw.Name {
+			f.Gateways[i].Status = gw.Status
+		}
+	}
+	return nil
+}
+
+// UpdateHTTPRouteStatus implements Client.
+func (f *FakeClient) UpdateHTTPRouteStatus(route *HTTPRoute) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.HTTPRoutes {
+		if f.HTTPRoutes[i].Namespace == route.Namespace && f.HTTPRoutes[i].Name == route.Name {
+			f.HTTPRoutes[i].Status = route.Status
+		}
+	}
+	return nil
+}
+
+// ListReferenceGrants implements Client.
+func (f *FakeClient) ListReferenceGrants(namespace string) ([]tls.ReferenceGrant, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var grants []tls.ReferenceGrant
+	for _, grant := range f.Grants {
+		if namespace == "" || grant.Namespace == namespace {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+// CreateReferenceGrant implements Client.
+func (f *FakeClient) CreateReferenceGrant(grant *tls.ReferenceGrant) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Grants = append(f.Grants, *grant)
+	return nil
+}
+
+// UpdateReferenceGrant implements Client.
+func (f *FakeClient) UpdateReferenceGrant(grant *tls.ReferenceGrant) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.Grants {
+		if f.Grants[i].Namespace == grant.Namespace && f.Grants[i].Name == grant.Name {
+			f.Grants[i] = *grant
+		}
+	}
+	return nil
+}
+
+// DeleteReferenceGrant implements Client.
+func (f *FakeClient) DeleteReferenceGrant(namespace, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.Grants {
+		if f.Grants[i].Namespace == namespace && f.Grants[i].Name == name {
+			f.Grants = append(f.Grants[:i], f.Grants[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"k8s.io/ingress-gce/pkg/tls"
+)
+
+// Client lists the Gateway API resources, and updates their status.
+type Client interface {
+	// ListGatewayClasses returns all the GatewayClasses.
+	ListGatewayClasses() ([]GatewayClass, error)
+	// ListGateways returns the Gateways of the given namespace, of all
+	// namespaces if empty.
+	ListGateways(namespace string) ([]Gateway, error)
+	// ListHTTPRoutes returns the HTTPRoutes of the given namespace, of all
+	// namespaces if empty.
+	ListHTTPRoutes(namespace string) ([]HTTPRoute, error)
+	// UpdateGatewayClassStatus writes the status of the given GatewayClass.
+	UpdateGatewayClassStatus(class *GatewayClass) error
+	// UpdateGatewayStatus writes the status of the given Gateway.
+	UpdateGatewayStatus(gw *Gateway) error
+	// UpdateHTTPRouteStatus writes the status of the given HTTPRoute.
+	UpdateHTTPRouteStatus(route *HTTPRoute) error
+	// ListReferenceGrants returns the ReferenceGrants managed by the
+	// controller of the given namespace, of all namespaces if empty.
+	ListReferenceGrants(namespace string) ([]tls.ReferenceGrant, error)
+	// CreateReferenceGrant creates the given ReferenceGrant.
+	CreateReferenceGrant(grant *tls.ReferenceGrant) error
+	// UpdateReferenceGrant replaces the given ReferenceGrant.
+	UpdateReferenceGrant(grant *tls.ReferenceGrant) error
+	// DeleteReferenceGrant deletes the ReferenceGrant of the given namespace
+	// and name.
+	DeleteReferenceGrant(namespace, name string) error
+}
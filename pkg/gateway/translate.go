@@ -0,0 +1,757 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/tls"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	// ManagedByLabelKey labels the Ingresses managed by the controller for
+	// the Gateways.
+	ManagedByLabelKey = "ingress.gcp.kubernetes.io/managed-by-gateway"
+	// GatewayKey is the annotation of the managed Ingresses holding the
+	// namespace/name of their Gateway.
+	GatewayKey = "ingress.gcp.kubernetes.io/gateway"
+
+	// maxNameLength is the maximum length of the names of the managed
+	// Ingresses.
+	maxNameLength = 253
+	// maxLabelLength is the maximum length of the LB groups.
+	maxLabelLength = 63
+	// hashLength is the length of the hashes suffixing the generated names.
+	hashLength = 8
+	// maxWeight is the maximum weight of a backend of a traffic split allowed
+	// by GCE.
+	maxWeight = 1000
+)
+
+// managedAnnotations are the annotations of the managed Ingresses set by the
+// controller. The other annotations, such as the ones the Ingress controller
+// writes, are preserved when they are updated.
+var managedAnnotations = []string{
+	annotations.IngressClassKey,
+	annotations.LBGroupKey,
+	annotations.AllowHTTPKey,
+	annotations.StaticIPNameKey,
+	annotations.TrafficSplitKey,
+	annotations.RouteRulesKey,
+	GatewayKey,
+}
+
+// hash returns a short hash of the given strings.
+func hash(s ...string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(s, "/"))))[:hashLength]
+}
+
+// generateName returns prefix-readable-hash, truncating readable to fit in
+// maxLength. The hash keeps the names of distinct objects distinct.
+func generateName(maxLength int, prefix, readable string, hashed ...string) string {
+	readable = strings.Replace(readable, ".", "-", -1)
+	if max := maxLength - len(prefix) - hashLength - 2; len(readable) > max {
+		readable = readable[:max]
+	}
+	readable = strings.TrimRight(readable, "-")
+	return fmt.Sprintf("%v-%v-%v", prefix, readable, hash(hashed...))
+}
+
+// lbGroup returns the LB group of the Ingresses of the given Gateway.
+func lbGroup(gw *Gateway) string {
+	return generateName(maxLabelLength, "gw", gw.Namespace+"-"+gw.Name, gw.Namespace, gw.Name)
+}
+
+// lbGroupRef returns the LB group annotation of the Ingresses of the given
+// Gateway: its LB group, in the namespace of the Gateway.
+func lbGroupRef(gw *Gateway) string {
+	return gw.Namespace + "/" + lbGroup(gw)
+}
+
+// frontendName returns the name of the Ingress carrying the listeners of the
+// given Gateway, in its namespace. It also names the ReferenceGrant letting
+// the Ingresses of the routes of other namespaces join its LB group.
+func frontendName(gw *Gateway) string {
+	return generateName(maxNameLength, "gateway", gw.Name, gw.Name)
+}
+
+// routeName returns the name of the Ingress carrying the rules of the given
+// HTTPRoute for the given Gateway, in the namespace of the route.
+func routeName(route *HTTPRoute, gw *Gateway) string {
+	return generateName(maxNameLength, "httproute", route.Name+"-"+gw.Name, route.Name, gw.Namespace, gw.Name)
+}
+
+// newIngress returns a managed Ingress of the given Gateway, owned by the
+// given object.
+func newIngress(gw *Gateway, namespace, name, ownerKind string, owner meta_v1.ObjectMeta) *extensions.Ingress {
+	return &extensions.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{ManagedByLabelKey: "true"},
+			Annotations: map[string]string{
+				annotations.IngressClassKey: annotations.GceIngressClass,
+				annotations.LBGroupKey:      lbGroupRef(gw),
+				GatewayKey:                  gw.Namespace + "/" + gw.Name,
+			},
+			OwnerReferences: []meta_v1.OwnerReference{{
+				APIVersion: GroupName + "/" + Version,
+				Kind:       ownerKind,
+				Name:       owner.Name,
+				UID:        owner.UID,
+			}},
+		},
+	}
+}
+
+// newReferenceGrant returns the ReferenceGrant letting the managed Ingresses
+// of the given namespaces join the LB group of the given Gateway, owned by the
+// Gateway.
+func newReferenceGrant(gw *Gateway, namespaces []string) *tls.ReferenceGrant {
+	group := lbGroup(gw)
+	grant := &tls.ReferenceGrant{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace: gw.Namespace,
+			Name:      frontendName(gw),
+			Labels:    map[string]string{ManagedByLabelKey: "true"},
+			OwnerReferences: []meta_v1.OwnerReference{{
+				APIVersion: GroupName + "/" + Version,
+				Kind:       KindGateway,
+				Name:       gw.Name,
+				UID:        gw.UID,
+			}},
+		},
+		Spec: tls.ReferenceGrantSpec{
+			To: []tls.ReferenceGrantTo{{Group: tls.LBGroupGroupName, Kind: tls.LBGroupKind, Name: &group}},
+		},
+	}
+	for _, namespace := range namespaces {
+		grant.Spec.From = append(grant.Spec.From, tls.ReferenceGrantFrom{Group: "networking.k8s.io", Kind: "Ingress", Namespace: namespace})
+	}
+	return grant
+}
+
+// listenerResult is the translation of a listener.
+type listenerResult struct {
+	listener *Listener
+	// accepted is false if the load balancer can't serve the listener.
+	accepted bool
+	// reason and message explain why the listener isn't accepted, or why
+	// its references aren't resolved.
+	reason, message string
+	// resolvedRefs is false if some certificates of the listener are
+	// invalid.
+	resolvedRefs bool
+	// attachedRoutes counts the routes attached to the listener.
+	attachedRoutes int32
+}
+
+// translateListener validates the given listener of the given Gateway, and
+// adds its certificates to tls.
+func translateListener(gw *Gateway, l *Listener, tls *[]extensions.IngressTLS) *listenerResult {
+	res := &listenerResult{listener: l, accepted: true, resolvedRefs: true}
+	switch {
+	case l.Protocol == ProtocolHTTP && l.Port == 80:
+	case l.Protocol == ProtocolHTTPS && l.Port == 443:
+	case l.Protocol == ProtocolHTTP || l.Protocol == ProtocolHTTPS:
+		res.accepted, res.reason = false, "PortUnavailable"
+		res.message = fmt.Sprintf("Protocol %v is only served on port %v", l.Protocol, map[string]int{ProtocolHTTP: 80, ProtocolHTTPS: 443}[l.Protocol])
+		return res
+	default:
+		res.accepted, res.reason = false, "UnsupportedProtocol"
+		res.message = fmt.Sprintf("Protocol %v is not supported, only HTTP and HTTPS are", l.Protocol)
+		return res
+	}
+	if l.Protocol == ProtocolHTTP {
+		return res
+	}
+	if l.TLS == nil || len(l.TLS.CertificateRefs) == 0 {
+		res.resolvedRefs, res.reason, res.message = false, "InvalidCertificateRef", "HTTPS listeners need certificateRefs"
+		return res
+	}
+	var hosts []string
+	if l.Hostname != nil && *l.Hostname != "" {
+		hosts = []string{*l.Hostname}
+	}
+	for _, ref := range l.TLS.CertificateRefs {
+		switch {
+		case ref.Group != nil && *ref.Group != "" || ref.Kind != nil && *ref.Kind != "Secret":
+			res.resolvedRefs, res.reason = false, "InvalidCertificateRef"
+			res.message = fmt.Sprintf("Certificate %v is not a Secret", ref.Name)
+		case ref.Namespace != nil && *ref.Namespace != gw.Namespace:
+			res.resolvedRefs, res.reason = false, "RefNotPermitted"
+			res.message = fmt.Sprintf("Certificate %v/%v is not in the namespace of the Gateway", *ref.Namespace, ref.Name)
+		default:
+			*tls = append(*tls, extensions.IngressTLS{Hosts: hosts, SecretName: ref.Name})
+		}
+	}
+	return res
+}
+
+// allowsNamespace returns whether the given listener of the given Gateway
+// accepts the routes of the given namespace. nsLabels returns the labels of a
+// namespace.
+func allowsNamespace(gw *Gateway, l *Listener, namespace string, nsLabels func(string) (map[string]string, error)) (bool, error) {
+	from := NamespacesFromSame
+	var selector *meta_v1.LabelSelector
+	if l.AllowedRoutes != nil && l.AllowedRoutes.Namespaces != nil {
+		if l.AllowedRoutes.Namespaces.From != nil {
+			from = *l.AllowedRoutes.Namespaces.From
+		}
+		selector = l.AllowedRoutes.Namespaces.Selector
+	}
+	switch from {
+	case NamespacesFromAll:
+		return true, nil
+	case NamespacesFromSame:
+		return namespace == gw.Namespace, nil
+	case NamespacesFromSelector:
+		if selector == nil {
+			return false, nil
+		}
+		s, err := meta_v1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return false, err
+		}
+		labels, err := nsLabels(namespace)
+		if err != nil {
+			return false, err
+		}
+		return s.Matches(labelSet(labels)), nil
+	}
+	return false, nil
+}
+
+// labelSet implements labels.Labels for a map of labels.
+type labelSet map[string]string
+
+func (l labelSet) Has(key string) bool {
+	_, ok := l[key]
+	return ok
+}
+
+func (l labelSet) Get(key string) string {
+	return l[key]
+}
+
+// hostnameMatches returns whether the given hostname of a listener, with an
+// optional leading wildcard label, matches the given host.
+func hostnameMatches(hostname, host string) bool {
+	if hostname == host {
+		return true
+	}
+	if strings.HasPrefix(hostname, "*.") {
+		suffix := hostname[1:]
+		if strings.HasPrefix(host, "*.") {
+			return strings.HasSuffix(host[1:], suffix)
+		}
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return strings.HasPrefix(host, "*.") && strings.HasSuffix(hostname, host[1:])
+}
+
+// routeHosts returns the hosts of the given route served by the given
+// listeners, the empty host matching all of them.
+func routeHosts(route *HTTPRoute, listeners []*Listener) []string {
+	hosts := map[string]bool{}
+	for _, l := range listeners {
+		if l.Hostname == nil || *l.Hostname == "" {
+			if len(route.Spec.Hostnames) == 0 {
+				hosts[""] = true
+			}
+			for _, h := range route.Spec.Hostnames {
+				hosts[h] = true
+			}
+			continue
+		}
+		if len(route.Spec.Hostnames) == 0 {
+			hosts[*l.Hostname] = true
+		}
+		for _, h := range route.Spec.Hostnames {
+			if hostnameMatches(*l.Hostname, h) {
+				// The more specific of the two is served.
+				if strings.HasPrefix(h, "*.") && !strings.HasPrefix(*l.Hostname, "*.") {
+					h = *l.Hostname
+				}
+				hosts[h] = true
+			}
+		}
+	}
+	var res []string
+	for h := range hosts {
+		res = append(res, h)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// translatePath returns the Ingress paths of the given path match, or an
+// error if it isn't supported.
+func translatePath(match *HTTPPathMatch) ([]string, error) {
+	matchType, value := PathMatchPathPrefix, "/"
+	if match != nil {
+		if match.Type != nil {
+			matchType = *match.Type
+		}
+		if match.Value != nil {
+			value = *match.Value
+		}
+	}
+	if !strings.HasPrefix(value, "/") || strings.Contains(value, "*") {
+		return nil, fmt.Errorf("path %q is not supported", value)
+	}
+	switch matchType {
+	case PathMatchExact:
+		return []string{value}, nil
+	case PathMatchPathPrefix:
+		value = strings.TrimRight(value, "/")
+		if value == "" {
+			return []string{"/*"}, nil
+		}
+		return []string{value, value + "/*"}, nil
+	}
+	return nil, fmt.Errorf("path match type %v is not supported", matchType)
+}
+
+// routeResult is the translation of a route.
+type routeResult struct {
+	paths []extensions.HTTPIngressPath
+	// splits are the weighted backends of the paths split between several
+	// backends, by path.
+	splits map[string][]annotations.WeightedBackend
+	// rules are the route rules of the route, without hosts, instead of its
+	// paths if some of its rules can't be served by Ingress paths.
+	rules []annotations.RouteRule
+	// resolvedRefs is false if some backends of the route are invalid,
+	// explained by reason and message.
+	resolvedRefs    bool
+	reason, message string
+	// unsupported lists the rules the load balancer can't serve.
+	unsupported []string
+}
+
+// pathOnly returns whether the given rule can be served by Ingress paths: it
+// only matches paths, and has no filters.
+func pathOnly(rule *HTTPRouteRule) bool {
+	if len(rule.Filters) > 0 {
+		return false
+	}
+	for _, match := range rule.Matches {
+		if len(match.Headers) > 0 || len(match.QueryParams) > 0 || match.Method != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// translateRoute translates the rules of the given route into Ingress paths,
+// or into route rules if some of them can't be served by paths. The rules the
+// load balancer can't serve are listed as unsupported. getService returns the
+// Service of the given namespace and name.
+func translateRoute(route *HTTPRoute, getService func(namespace, name string) (*api_v1.Service, error)) *routeResult {
+	res := &routeResult{resolvedRefs: true, splits: map[string][]annotations.WeightedBackend{}}
+	useRules := false
+	for i := range route.Spec.Rules {
+		if !pathOnly(&route.Spec.Rules[i]) {
+			useRules = true
+		}
+	}
+	var ranked []rankedRule
+	for i, rule := range route.Spec.Rules {
+		if err := unsupportedRule(&rule); err != nil {
+			res.unsupported = append(res.unsupported, fmt.Sprintf("rule %d: %v", i, err))
+			continue
+		}
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []HTTPRouteMatch{{}}
+		}
+		var matchPaths [][]string
+		for _, match := range matches {
+			paths, err := translatePath(match.Path)
+			if err != nil {
+				res.unsupported = append(res.unsupported, fmt.Sprintf("rule %d: %v", i, err))
+				matchPaths = nil
+				break
+			}
+			matchPaths = append(matchPaths, paths)
+		}
+		if len(matchPaths) == 0 {
+			continue
+		}
+		var backends []annotations.WeightedBackend
+		redirect := ruleFilter(&rule, FilterRequestRedirect)
+		if redirect == nil {
+			var err *refError
+			backends, err = translateBackends(route, rule.BackendRefs, getService)
+			if err != nil {
+				res.resolvedRefs, res.reason, res.message = false, err.reason, fmt.Sprintf("rule %d: %v", i, err.message)
+				continue
+			}
+		}
+		if useRules {
+			for j, match := range matches {
+				for _, p := range matchPaths[j] {
+					ranked = append(ranked, rankedRule{
+						RouteRule: translateRouteRule(&rule, &match, p, backends),
+						exact:     match.Path != nil && match.Path.Type != nil && *match.Path.Type == PathMatchExact,
+						pathLen:   len(strings.TrimSuffix(p, "*")),
+						method:    match.Method != nil,
+						headers:   len(match.Headers),
+						params:    len(match.QueryParams),
+					})
+				}
+			}
+			continue
+		}
+		// The Ingress path needs a backend, even when the traffic split takes
+		// precedence over it.
+		for _, paths := range matchPaths {
+			for _, p := range paths {
+				res.paths = append(res.paths, extensions.HTTPIngressPath{Path: p, Backend: backends[0].IngressBackend})
+				if len(backends) > 1 {
+					res.splits[p] = backends
+				} else {
+					delete(res.splits, p)
+				}
+			}
+		}
+	}
+	// The rules follow the precedence of the Gateway API, the order of the
+	// rules breaking ties.
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		switch {
+		case a.exact != b.exact:
+			return a.exact
+		case a.pathLen != b.pathLen:
+			return a.pathLen > b.pathLen
+		case a.method != b.method:
+			return a.method
+		case a.headers != b.headers:
+			return a.headers > b.headers
+		}
+		return a.params > b.params
+	})
+	for i, r := range ranked {
+		r.Priority = int64(i)
+		res.rules = append(res.rules, r.RouteRule)
+	}
+	return res
+}
+
+// rankedRule is a route rule, with the properties of its match ranking it.
+type rankedRule struct {
+	annotations.RouteRule
+	exact           bool
+	pathLen         int
+	method          bool
+	headers, params int
+}
+
+// translateRouteRule returns the route rule of the given Ingress path of the
+// given match of the given rule, routing to the given backends.
+func translateRouteRule(rule *HTTPRouteRule, match *HTTPRouteMatch, path string, backends []annotations.WeightedBackend) annotations.RouteRule {
+	prefix := strings.HasSuffix(path, "*")
+	m := utils.RouteMatch{FullPathMatch: path}
+	if prefix {
+		m = utils.RouteMatch{PrefixMatch: strings.TrimSuffix(path, "*")}
+	}
+	if match.Method != nil {
+		m.HeaderMatches = append(m.HeaderMatches, utils.HeaderMatch{HeaderName: ":method", ExactMatch: *match.Method})
+	}
+	for _, h := range match.Headers {
+		hm := utils.HeaderMatch{HeaderName: h.Name, ExactMatch: h.Value}
+		if h.Type != nil && *h.Type == MatchRegularExpression {
+			hm = utils.HeaderMatch{HeaderName: h.Name, RegexMatch: h.Value}
+		}
+		m.HeaderMatches = append(m.HeaderMatches, hm)
+	}
+	for _, q := range match.QueryParams {
+		qm := utils.QueryParameterMatch{Name: q.Name, ExactMatch: q.Value}
+		if q.Type != nil && *q.Type == MatchRegularExpression {
+			qm = utils.QueryParameterMatch{Name: q.Name, RegexMatch: q.Value}
+		}
+		m.QueryParameterMatches = append(m.QueryParameterMatches, qm)
+	}
+	res := annotations.RouteRule{Matches: []utils.RouteMatch{m}, Backends: backends}
+	// replacePath returns the replacement of the path matched by the rule
+	// for the given modifier.
+	replacePath := func(mod *HTTPPathModifier) string {
+		if mod.Type == PathModifierReplaceFullPath {
+			return *mod.ReplaceFullPath
+		}
+		if prefix {
+			return strings.TrimRight(*mod.ReplacePrefixMatch, "/") + "/"
+		}
+		return *mod.ReplacePrefixMatch
+	}
+	if f := ruleFilter(rule, FilterRequestRedirect); f != nil {
+		r := f.RequestRedirect
+		res.Redirect = &utils.URLRedirect{RedirectResponseCode: "MOVED_PERMANENTLY_DEFAULT"}
+		if r.Scheme != nil {
+			res.Redirect.HttpsRedirect = *r.Scheme == "https"
+		}
+		if r.Hostname != nil {
+			res.Redirect.HostRedirect = *r.Hostname
+		}
+		if r.StatusCode != nil {
+			res.Redirect.RedirectResponseCode = redirectCodes[*r.StatusCode]
+		}
+		if r.Path != nil {
+			if prefix && r.Path.Type == PathModifierReplacePrefixMatch {
+				res.Redirect.PrefixRedirect = replacePath(r.Path)
+			} else {
+				res.Redirect.PathRedirect = replacePath(r.Path)
+			}
+		}
+	}
+	if f := ruleFilter(rule, FilterURLRewrite); f != nil {
+		r := f.URLRewrite
+		res.Rewrite = &utils.URLRewrite{}
+		if r.Hostname != nil {
+			res.Rewrite.HostRewrite = *r.Hostname
+		}
+		if r.Path != nil {
+			res.Rewrite.PathPrefixRewrite = replacePath(r.Path)
+		}
+	}
+	addHeaders := func(f *HTTPHeaderFilter, add *[]utils.HeaderValue, remove *[]string) {
+		for _, h := range f.Set {
+			*add = append(*add, utils.HeaderValue{HeaderName: h.Name, HeaderValue: h.Value, Replace: true})
+		}
+		for _, h := range f.Add {
+			*add = append(*add, utils.HeaderValue{HeaderName: h.Name, HeaderValue: h.Value})
+		}
+		*remove = append(*remove, f.Remove...)
+	}
+	action := &utils.HeaderAction{}
+	if f := ruleFilter(rule, FilterRequestHeaderModifier); f != nil {
+		addHeaders(f.RequestHeaderModifier, &action.RequestHeadersToAdd, &action.RequestHeadersToRemove)
+	}
+	if f := ruleFilter(rule, FilterResponseHeaderModifier); f != nil {
+		addHeaders(f.ResponseHeaderModifier, &action.ResponseHeadersToAdd, &action.ResponseHeadersToRemove)
+	}
+	if !reflect.DeepEqual(action, &utils.HeaderAction{}) {
+		res.HeaderAction = action
+	}
+	return res
+}
+
+// ruleFilter returns the filter of the given type of the given rule, nil if
+// none.
+func ruleFilter(rule *HTTPRouteRule, filterType string) *HTTPRouteFilter {
+	for i := range rule.Filters {
+		if rule.Filters[i].Type == filterType {
+			return &rule.Filters[i]
+		}
+	}
+	return nil
+}
+
+// redirectCodes are the GCE redirect response codes of the status codes of
+// redirect filters.
+var redirectCodes = map[int]string{
+	301: "MOVED_PERMANENTLY_DEFAULT",
+	302: "FOUND",
+	303: "SEE_OTHER",
+	307: "TEMPORARY_REDIRECT",
+	308: "PERMANENT_REDIRECT",
+}
+
+// unsupportedRule returns an error if the given rule uses features the load
+// balancer can't serve: header or query parameter matches other than exact or
+// regular expression ones, filters other than header modifiers, redirects and
+// rewrites, the parts of these filters GCE lacks, and weights above the GCE
+// maximum.
+func unsupportedRule(rule *HTTPRouteRule) error {
+	prefixMatch := len(rule.Matches) == 0
+	for _, match := range rule.Matches {
+		if match.Path == nil || match.Path.Type == nil || *match.Path.Type == PathMatchPathPrefix {
+			prefixMatch = true
+		}
+		for _, h := range match.Headers {
+			if h.Type != nil && *h.Type != MatchExact && *h.Type != MatchRegularExpression {
+				return fmt.Errorf("header match type %v is not supported", *h.Type)
+			}
+		}
+		for _, q := range match.QueryParams {
+			if q.Type != nil && *q.Type != MatchExact && *q.Type != MatchRegularExpression {
+				return fmt.Errorf("query parameter match type %v is not supported", *q.Type)
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, f := range rule.Filters {
+		if seen[f.Type] {
+			return fmt.Errorf("several %v filters are not supported", f.Type)
+		}
+		seen[f.Type] = true
+		var path *HTTPPathModifier
+		switch {
+		case f.Type == FilterRequestHeaderModifier && f.RequestHeaderModifier != nil:
+		case f.Type == FilterResponseHeaderModifier && f.ResponseHeaderModifier != nil:
+		case f.Type == FilterRequestRedirect && f.RequestRedirect != nil:
+			r := f.RequestRedirect
+			if r.Scheme != nil && *r.Scheme != "https" {
+				return fmt.Errorf("redirects to scheme %v are not supported", *r.Scheme)
+			}
+			if r.Port != nil {
+				return fmt.Errorf("redirects to a port are not supported")
+			}
+			if r.StatusCode != nil && redirectCodes[*r.StatusCode] == "" {
+				return fmt.Errorf("redirect status code %v is not supported", *r.StatusCode)
+			}
+			path = r.Path
+		case f.Type == FilterURLRewrite && f.URLRewrite != nil:
+			path = f.URLRewrite.Path
+			if path != nil && path.Type == PathModifierReplaceFullPath && prefixMatch {
+				return fmt.Errorf("rewriting the full path of prefix matches is not supported")
+			}
+		default:
+			return fmt.Errorf("filter %v is not supported", f.Type)
+		}
+		if path == nil {
+			continue
+		}
+		switch {
+		case path.Type == PathModifierReplaceFullPath && path.ReplaceFullPath != nil:
+		case path.Type == PathModifierReplacePrefixMatch && path.ReplacePrefixMatch != nil:
+		default:
+			return fmt.Errorf("path modifier %v is not supported", path.Type)
+		}
+	}
+	if seen[FilterRequestRedirect] && seen[FilterURLRewrite] {
+		return fmt.Errorf("a redirect can't be rewritten")
+	}
+	for _, ref := range rule.BackendRefs {
+		if ref.Weight != nil && *ref.Weight > maxWeight {
+			return fmt.Errorf("weights above %v are not supported", maxWeight)
+		}
+	}
+	return nil
+}
+
+// refError is an invalid reference of a route.
+type refError struct {
+	reason, message string
+}
+
+// translateBackends returns the Ingress backends of the given backends of a
+// rule of the given route, with their weights: its backends with a non-zero
+// weight, 1 by default.
+func translateBackends(route *HTTPRoute, refs []HTTPBackendRef, getService func(namespace, name string) (*api_v1.Service, error)) ([]annotations.WeightedBackend, *refError) {
+	var backends []annotations.WeightedBackend
+	for _, ref := range refs {
+		weight := int64(1)
+		if ref.Weight != nil {
+			weight = int64(*ref.Weight)
+		}
+		if weight == 0 {
+			continue
+		}
+		if ref.Group != nil && *ref.Group != "" || ref.Kind != nil && *ref.Kind != KindService {
+			return nil, &refError{"InvalidKind", fmt.Sprintf("backend %v is not a Service", ref.Name)}
+		}
+		if ref.Namespace != nil && *ref.Namespace != route.Namespace {
+			return nil, &refError{"RefNotPermitted", fmt.Sprintf("backend %v/%v is not in the namespace of the route", *ref.Namespace, ref.Name)}
+		}
+		if ref.Port == nil {
+			return nil, &refError{"UnsupportedValue", fmt.Sprintf("backend %v has no port", ref.Name)}
+		}
+		if _, err := getService(route.Namespace, ref.Name); err != nil {
+			return nil, &refError{"BackendNotFound", fmt.Sprintf("backend %v: %v", ref.Name, err)}
+		}
+		backends = append(backends, annotations.WeightedBackend{
+			IngressBackend: extensions.IngressBackend{ServiceName: ref.Name, ServicePort: intstr.FromInt(int(*ref.Port))},
+			Weight:         weight,
+		})
+	}
+	if len(backends) == 0 {
+		return nil, &refError{"BackendNotFound", "no backend with a non-zero weight"}
+	}
+	return backends, nil
+}
+
+// trafficSplits returns the traffic split annotation of the given splits of
+// paths, for each of the given hosts. Empty if there are no splits.
+func trafficSplits(hosts []string, splits map[string][]annotations.WeightedBackend) string {
+	if len(splits) == 0 {
+		return ""
+	}
+	var paths []string
+	for p := range splits {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var res []annotations.TrafficSplit
+	for _, host := range hosts {
+		for _, p := range paths {
+			res = append(res, annotations.TrafficSplit{Host: host, Path: p, Backends: splits[p]})
+		}
+	}
+	data, _ := json.Marshal(res)
+	return string(data)
+}
+
+// routeRules returns the route rules annotation of the given rules, for each
+// of the given hosts. Empty if there are no rules.
+func routeRules(hosts []string, rules []annotations.RouteRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	var res []annotations.RouteRule
+	for _, host := range hosts {
+		for _, r := range rules {
+			r.Host = host
+			res = append(res, r)
+		}
+	}
+	data, _ := json.Marshal(res)
+	return string(data)
+}
+
+// ingressRules returns the rules of the given hosts, all routing the given
+// paths. There are none without paths, the hosts are then only served by
+// route rules.
+func ingressRules(hosts []string, paths []extensions.HTTPIngressPath) []extensions.IngressRule {
+	if len(paths) == 0 {
+		return nil
+	}
+	var rules []extensions.IngressRule
+	for _, host := range hosts {
+		rules = append(rules, extensions.IngressRule{
+			Host: host,
+			IngressRuleValue: extensions.IngressRuleValue{
+				HTTP: &extensions.HTTPIngressRuleValue{Paths: paths},
+			},
+		})
+	}
+	return rules
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"reflect"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestTranslatePath(t *testing.T) {
+	exact, prefix, regex := PathMatchExact, PathMatchPathPrefix, "RegularExpression"
+	str := func(s string) *string { return &s }
+	testCases := []struct {
+		desc    string
+		match   *HTTPPathMatch
+		want    []string
+		wantErr bool
+	}{
+		{desc: "default", want: []string{"/*"}},
+		{desc: "root prefix", match: &HTTPPathMatch{Type: &prefix, Value: str("/")}, want: []string{"/*"}},
+		{desc: "prefix", match: &HTTPPathMatch{Type: &prefix, Value: str("/foo")}, want: []string{"/foo", "/foo/*"}},
+		{desc: "prefix with trailing slash", match: &HTTPPathMatch{Type: &prefix, Value: str("/foo/")}, want: []string{"/foo", "/foo/*"}},
+		{desc: "exact", match: &HTTPPathMatch{Type: &exact, Value: str("/foo")}, want: []string{"/foo"}},
+		{desc: "regular expression", match: &HTTPPathMatch{Type: &regex, Value: str("/foo.*")}, wantErr: true},
+		{desc: "wildcard", match: &HTTPPathMatch{Type: &exact, Value: str("/foo/*")}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := translatePath(tc.match)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: translatePath() = %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: translatePath() = %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestRouteHosts(t *testing.T) {
+	str := func(s string) *string { return &s }
+	testCases := []struct {
+		desc      string
+		hostnames []string
+		listeners []*Listener
+		want      []string
+	}{
+		{desc: "no hostnames", listeners: []*Listener{{}}, want: []string{""}},
+		{desc: "route hostnames", hostnames: []string{"foo.com"}, listeners: []*Listener{{}}, want: []string{"foo.com"}},
+		{desc: "listener hostname", listeners: []*Listener{{Hostname: str("foo.com")}}, want: []string{"foo.com"}},
+		{desc: "wildcard listener", hostnames: []string{"a.foo.com", "bar.com"}, listeners: []*Listener{{Hostname: str("*.foo.com")}}, want: []string{"a.foo.com"}},
+		{desc: "wildcard route", hostnames: []string{"*.foo.com"}, listeners: []*Listener{{Hostname: str("a.foo.com")}}, want: []string{"a.foo.com"}},
+		{desc: "no matching hostname", hostnames: []string{"bar.com"}, listeners: []*Listener{{Hostname: str("foo.com")}}},
+	}
+	for _, tc := range testCases {
+		route := &HTTPRoute{Spec: HTTPRouteSpec{Hostnames: tc.hostnames}}
+		if got := routeHosts(route, tc.listeners); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: routeHosts() = %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestLBGroup(t *testing.T) {
+	long := "a-very-long-name-which-does-not-fit-in-a-label-once-prefixed-and-hashed"
+	testCases := []struct {
+		namespace, name string
+	}{
+		{"default", "gw"},
+		{"default", "gw.example.com"},
+		{"default", long},
+		{"default-" + long, "gw"},
+	}
+	groups := map[string]bool{}
+	for _, tc := range testCases {
+		group := lbGroup(&Gateway{ObjectMeta: meta_v1.ObjectMeta{Namespace: tc.namespace, Name: tc.name}})
+		if errs := validation.IsDNS1123Label(group); len(errs) > 0 {
+			t.Errorf("lbGroup(%v/%v) = %q, not a DNS-1123 label: %v", tc.namespace, tc.name, group, errs)
+		}
+		if groups[group] {
+			t.Errorf("lbGroup(%v/%v) = %q, used by another Gateway", tc.namespace, tc.name, group)
+		}
+		groups[group] = true
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GroupName is the API group of the Gateway API.
+	GroupName = "gateway.networking.k8s.io"
+	// Version is the API version of the Gateway API resources.
+	Version = "v1"
+	// ControllerName is the controllerName of the GatewayClasses of the
+	// controller.
+	ControllerName = "networking.gke.io/ingress-gce"
+
+	// Kinds of the Gateway API resources, and of the Services referenced by
+	// the HTTPRoutes.
+	KindGateway   = "Gateway"
+	KindHTTPRoute = "HTTPRoute"
+	KindService   = "Service"
+
+	// Protocols of the listeners served by the load balancers.
+	ProtocolHTTP  = "HTTP"
+	ProtocolHTTPS = "HTTPS"
+
+	// Path match types of the HTTPRoutes.
+	PathMatchExact      = "Exact"
+	PathMatchPathPrefix = "PathPrefix"
+
+	// Header and query parameter match types of the HTTPRoutes.
+	MatchExact             = "Exact"
+	MatchRegularExpression = "RegularExpression"
+
+	// Filter types of the HTTPRoutes served by the load balancers.
+	FilterRequestHeaderModifier  = "RequestHeaderModifier"
+	FilterResponseHeaderModifier = "ResponseHeaderModifier"
+	FilterRequestRedirect        = "RequestRedirect"
+	FilterURLRewrite             = "URLRewrite"
+
+	// Path modifier types of the redirect and rewrite filters.
+	PathModifierReplaceFullPath    = "ReplaceFullPath"
+	PathModifierReplacePrefixMatch = "ReplacePrefixMatch"
+
+	// Namespaces from which the listeners accept routes.
+	NamespacesFromAll      = "All"
+	NamespacesFromSame     = "Same"
+	NamespacesFromSelector = "Selector"
+
+	// AddressTypeNamed is the address type of the static IPs reserved by
+	// name.
+	AddressTypeNamed = "NamedAddress"
+
+	// Types of the conditions, and their reasons.
+	ConditionAccepted     = "Accepted"
+	ConditionProgrammed   = "Programmed"
+	ConditionResolvedRefs = "ResolvedRefs"
+)
+
+// Condition is a condition of the status of a Gateway API resource.
+type Condition struct {
+	Type               string       `json:"type"`
+	Status             string       `json:"status"`
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+	LastTransitionTime meta_v1.Time `json:"lastTransitionTime"`
+	Reason             string       `json:"reason"`
+	Message            string       `json:"message"`
+}
+
+// GatewayClass is a class of Gateways, served by the controller of its
+// controllerName. Only the fields the controller uses are decoded.
+type GatewayClass struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GatewayClassSpec   `json:"spec"`
+	Status GatewayClassStatus `json:"status,omitempty"`
+}
+
+// GatewayClassSpec is the spec of a GatewayClass.
+type GatewayClassSpec struct {
+	ControllerName string `json:"controllerName"`
+}
+
+// GatewayClassStatus is the status of a GatewayClass.
+type GatewayClassStatus struct {
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// GatewayClassList is a list of GatewayClasses.
+type GatewayClassList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GatewayClass `json:"items"`
+}
+
+// Gateway is a load balancer of a GatewayClass, listening on the given
+// listeners.
+type Gateway struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GatewaySpec   `json:"spec"`
+	Status GatewayStatus `json:"status,omitempty"`
+}
+
+// GatewaySpec is the spec of a Gateway.
+type GatewaySpec struct {
+	GatewayClassName string           `json:"gatewayClassName"`
+	Listeners        []Listener       `json:"listeners"`
+	Addresses        []GatewayAddress `json:"addresses,omitempty"`
+}
+
+// Listener is a port and protocol of a Gateway, for the given hostname if
+// set.
+type Listener struct {
+	Name          string            `json:"name"`
+	Hostname      *string           `json:"hostname,omitempty"`
+	Port          int32             `json:"port"`
+	Protocol      string            `json:"protocol"`
+	TLS           *GatewayTLSConfig `json:"tls,omitempty"`
+	AllowedRoutes *AllowedRoutes    `json:"allowedRoutes,omitempty"`
+}
+
+// GatewayTLSConfig is the TLS configuration of a listener.
+type GatewayTLSConfig struct {
+	Mode            *string           `json:"mode,omitempty"`
+	CertificateRefs []SecretReference `json:"certificateRefs,omitempty"`
+}
+
+// SecretReference references a Secret, in the namespace of the Gateway if
+// Namespace is nil.
+type SecretReference struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+}
+
+// AllowedRoutes are the namespaces whose routes may attach to a listener.
+type AllowedRoutes struct {
+	Namespaces *RouteNamespaces `json:"namespaces,omitempty"`
+}
+
+// RouteNamespaces selects the namespaces of the routes, the namespace of the
+// Gateway by default.
+type RouteNamespaces struct {
+	From     *string                `json:"from,omitempty"`
+	Selector *meta_v1.LabelSelector `json:"selector,omitempty"`
+}
+
+// GatewayAddress is an address requested for a Gateway.
+type GatewayAddress struct {
+	Type  *string `json:"type,omitempty"`
+	Value string  `json:"value"`
+}
+
+// GatewayStatus is the status of a Gateway.
+type GatewayStatus struct {
+	Addresses  []GatewayStatusAddress `json:"addresses,omitempty"`
+	Conditions []Condition            `json:"conditions,omitempty"`
+	Listeners  []ListenerStatus       `json:"listeners,omitempty"`
+}
+
+// GatewayStatusAddress is an address of a Gateway.
+type GatewayStatusAddress struct {
+	Type  *string `json:"type,omitempty"`
+	Value string  `json:"value"`
+}
+
+// ListenerStatus is the status of a listener.
+type ListenerStatus struct {
+	Name           string           `json:"name"`
+	SupportedKinds []RouteGroupKind `json:"supportedKinds"`
+	AttachedRoutes int32            `json:"attachedRoutes"`
+	Conditions     []Condition      `json:"conditions"`
+}
+
+// RouteGroupKind is a kind of route.
+type RouteGroupKind struct {
+	Group *string `json:"group,omitempty"`
+	Kind  string  `json:"kind"`
+}
+
+// GatewayList is a list of Gateways.
+type GatewayList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Gateway `json:"items"`
+}
+
+// HTTPRoute routes HTTP requests of the Gateways it attaches to.
+type HTTPRoute struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HTTPRouteSpec   `json:"spec"`
+	Status HTTPRouteStatus `json:"status,omitempty"`
+}
+
+// HTTPRouteSpec is the spec of an HTTPRoute.
+type HTTPRouteSpec struct {
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+	Rules      []HTTPRouteRule   `json:"rules,omitempty"`
+}
+
+// ParentReference references a Gateway, in the namespace of the route if
+// Namespace is nil, and one of its listeners if SectionName is set.
+type ParentReference struct {
+	Group       *string `json:"group,omitempty"`
+	Kind        *string `json:"kind,omitempty"`
+	Namespace   *string `json:"namespace,omitempty"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName,omitempty"`
+}
+
+// HTTPRouteRule routes the requests matching one of its matches to its
+// backends.
+type HTTPRouteRule struct {
+	Matches     []HTTPRouteMatch  `json:"matches,omitempty"`
+	Filters     []HTTPRouteFilter `json:"filters,omitempty"`
+	BackendRefs []HTTPBackendRef  `json:"backendRefs,omitempty"`
+}
+
+// HTTPRouteMatch matches requests. All its matches must match.
+type HTTPRouteMatch struct {
+	Path        *HTTPPathMatch        `json:"path,omitempty"`
+	Headers     []HTTPHeaderMatch     `json:"headers,omitempty"`
+	QueryParams []HTTPQueryParamMatch `json:"queryParams,omitempty"`
+	Method      *string               `json:"method,omitempty"`
+}
+
+// HTTPHeaderMatch matches a request header, exactly by default.
+type HTTPHeaderMatch struct {
+	Type  *string `json:"type,omitempty"`
+	Name  string  `json:"name"`
+	Value string  `json:"value"`
+}
+
+// HTTPQueryParamMatch matches a query parameter, exactly by default.
+type HTTPQueryParamMatch struct {
+	Type  *string `json:"type,omitempty"`
+	Name  string  `json:"name"`
+	Value string  `json:"value"`
+}
+
+// HTTPPathMatch matches the path of requests, by prefix "/" by default.
+type HTTPPathMatch struct {
+	Type  *string `json:"type,omitempty"`
+	Value *string `json:"value,omitempty"`
+}
+
+// HTTPRouteFilter modifies the requests or responses. Only the filters the
+// load balancers serve are decoded, the routes with other filters are not
+// accepted.
+type HTTPRouteFilter struct {
+	Type                   string                     `json:"type"`
+	RequestHeaderModifier  *HTTPHeaderFilter          `json:"requestHeaderModifier,omitempty"`
+	ResponseHeaderModifier *HTTPHeaderFilter          `json:"responseHeaderModifier,omitempty"`
+	RequestRedirect        *HTTPRequestRedirectFilter `json:"requestRedirect,omitempty"`
+	URLRewrite             *HTTPURLRewriteFilter      `json:"urlRewrite,omitempty"`
+}
+
+// HTTPHeaderFilter sets, adds or removes headers.
+type HTTPHeaderFilter struct {
+	Set    []HTTPHeader `json:"set,omitempty"`
+	Add    []HTTPHeader `json:"add,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+// HTTPHeader is a header set or added by a filter.
+type HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HTTPRequestRedirectFilter answers the requests with a redirect. The parts
+// which are not set are kept.
+type HTTPRequestRedirectFilter struct {
+	Scheme     *string           `json:"scheme,omitempty"`
+	Hostname   *string           `json:"hostname,omitempty"`
+	Path       *HTTPPathModifier `json:"path,omitempty"`
+	Port       *int32            `json:"port,omitempty"`
+	StatusCode *int              `json:"statusCode,omitempty"`
+}
+
+// HTTPURLRewriteFilter rewrites the requests before they are forwarded.
+type HTTPURLRewriteFilter struct {
+	Hostname *string           `json:"hostname,omitempty"`
+	Path     *HTTPPathModifier `json:"path,omitempty"`
+}
+
+// HTTPPathModifier replaces the path, or the prefix matched by a PathPrefix
+// match.
+type HTTPPathModifier struct {
+	Type               string  `json:"type"`
+	ReplaceFullPath    *string `json:"replaceFullPath,omitempty"`
+	ReplacePrefixMatch *string `json:"replacePrefixMatch,omitempty"`
+}
+
+// HTTPBackendRef references a backend of a rule.
+type HTTPBackendRef struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+	Port      *int32  `json:"port,omitempty"`
+	Weight    *int32  `json:"weight,omitempty"`
+}
+
+// HTTPRouteStatus is the status of an HTTPRoute, by parent.
+type HTTPRouteStatus struct {
+	Parents []RouteParentStatus `json:"parents,omitempty"`
+}
+
+// RouteParentStatus is the status of a route for one of its parents.
+type RouteParentStatus struct {
+	ParentRef      ParentReference `json:"parentRef"`
+	ControllerName string          `json:"controllerName"`
+	Conditions     []Condition     `json:"conditions,omitempty"`
+}
+
+// HTTPRouteList is a list of HTTPRoutes.
+type HTTPRouteList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []HTTPRoute `json:"items"`
+}
@@ -28,6 +28,7 @@ func NewFakeHealthCheckProvider() *FakeHealthCheckProvider {
 	return &FakeHealthCheckProvider{
 		http:    make(map[string]compute.HttpHealthCheck),
 		generic: make(map[string]computealpha.HealthCheck),
+		grpc:    make(map[string]GRPCHealthCheckSettings),
 	}
 }
 
@@ -35,6 +36,9 @@ func NewFakeHealthCheckProvider() *FakeHealthCheckProvider {
 type FakeHealthCheckProvider struct {
 	http    map[string]compute.HttpHealthCheck
 	generic map[string]computealpha.HealthCheck
+	// grpc holds the gRPC settings of the gRPC health checks of generic,
+	// which the alpha API lacks.
+	grpc map[string]GRPCHealthCheckSettings
 }
 
 // CreateHttpHealthCheck fakes out http health check creation.
@@ -116,6 +120,7 @@ func (f *FakeHealthCheckProvider) DeleteHealthCheck(name string) error {
 	}
 
 	delete(f.generic, name)
+	delete(f.grpc, name)
 	return nil
 }
 
@@ -126,6 +131,7 @@ func (f *FakeHealthCheckProvider) UpdateHealthCheck(hc *compute.HealthCheck) err
 	}
 	alphaHC, _ := toAlphaHealthCheck(hc)
 	f.generic[hc.Name] = *alphaHC
+	delete(f.grpc, hc.Name)
 	return nil
 }
 
@@ -135,5 +141,61 @@ func (f *FakeHealthCheckProvider) UpdateAlphaHealthCheck(hc *computealpha.Health
 	}
 
 	f.generic[hc.Name] = *hc
+	delete(f.grpc, hc.Name)
 	return nil
 }
+
+// GetGRPCHealthCheck fakes out getting a health check through the REST API.
+func (f *FakeHealthCheckProvider) GetGRPCHealthCheck(name string) (*GRPCHealthCheck, error) {
+	hc, found := f.generic[name]
+	if !found {
+		return nil, utils.FakeGoogleAPINotFoundErr()
+	}
+	ret := &GRPCHealthCheck{
+		Name:               hc.Name,
+		Description:        hc.Description,
+		Type:               hc.Type,
+		CheckIntervalSec:   hc.CheckIntervalSec,
+		TimeoutSec:         hc.TimeoutSec,
+		HealthyThreshold:   hc.HealthyThreshold,
+		UnhealthyThreshold: hc.UnhealthyThreshold,
+		SelfLink:           hc.SelfLink,
+	}
+	if settings, ok := f.grpc[name]; ok {
+		ret.GrpcHealthCheck = &settings
+	}
+	return ret, nil
+}
+
+// CreateGRPCHealthCheck fakes out gRPC health check creation.
+func (f *FakeHealthCheckProvider) CreateGRPCHealthCheck(hc *GRPCHealthCheck) error {
+	f.putGRPC(hc)
+	return nil
+}
+
+// UpdateGRPCHealthCheck fakes out replacing a health check by a gRPC health
+// check.
+func (f *FakeHealthCheckProvider) UpdateGRPCHealthCheck(hc *GRPCHealthCheck) error {
+	if _, exists := f.generic[hc.Name]; !exists {
+		return utils.FakeGoogleAPINotFoundErr()
+	}
+	f.putGRPC(hc)
+	return nil
+}
+
+func (f *FakeHealthCheckProvider) putGRPC(hc *GRPCHealthCheck) {
+	f.generic[hc.Name] = computealpha.HealthCheck{
+		Name:               hc.Name,
+		Description:        hc.Description,
+		Type:               hc.Type,
+		CheckIntervalSec:   hc.CheckIntervalSec,
+		TimeoutSec:         hc.TimeoutSec,
+		HealthyThreshold:   hc.HealthyThreshold,
+		UnhealthyThreshold: hc.UnhealthyThreshold,
+		SelfLink:           "https://fake.google.com/compute/healthChecks/" + hc.Name,
+	}
+	delete(f.grpc, hc.Name)
+	if hc.GrpcHealthCheck != nil {
+		f.grpc[hc.Name] = *hc.GrpcHealthCheck
+	}
+}
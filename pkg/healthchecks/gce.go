@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthchecks
+
+import (
+	"net/http"
+
+	"golang.org/x/oauth2"
+
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// ProjectProvider is the part of the cloud provider that knows about the
+// project of the cluster.
+type ProjectProvider interface {
+	ProjectID() string
+}
+
+// GRPCHealthCheck is a gRPC health check, which the vendored compute API
+// predates.
+type GRPCHealthCheck struct {
+	Name               string                   `json:"name"`
+	Description        string                   `json:"description,omitempty"`
+	Type               string                   `json:"type"`
+	CheckIntervalSec   int64                    `json:"checkIntervalSec,omitempty"`
+	TimeoutSec         int64                    `json:"timeoutSec,omitempty"`
+	HealthyThreshold   int64                    `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int64                    `json:"unhealthyThreshold,omitempty"`
+	GrpcHealthCheck    *GRPCHealthCheckSettings `json:"grpcHealthCheck,omitempty"`
+	SelfLink           string                   `json:"selfLink,omitempty"`
+}
+
+// GRPCHealthCheckSettings are the gRPC settings of a health check. An empty
+// service name checks the overall health of the server.
+type GRPCHealthCheckSettings struct {
+	Port              int64  `json:"port,omitempty"`
+	PortSpecification string `json:"portSpecification,omitempty"`
+	GrpcServiceName   string `json:"grpcServiceName,omitempty"`
+}
+
+// gceGRPCHealthChecks implements GRPCHealthChecks through the REST API.
+type gceGRPCHealthChecks struct {
+	rest *utils.ComputeREST
+}
+
+// NewGCEGRPCHealthChecks returns a GRPCHealthChecks managing the health
+// checks of the project through the REST API. tokenSource, transport and
+// apiEndpoint are those of utils.NewComputeREST.
+// project: the cloud provider, used for the project of the cluster.
+func NewGCEGRPCHealthChecks(project ProjectProvider, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (GRPCHealthChecks, error) {
+	rest, err := utils.NewComputeREST(project.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceGRPCHealthChecks{rest: rest}, nil
+}
+
+// GetGRPCHealthCheck returns the given health check. Its gRPC settings are
+// nil unless it is a gRPC health check.
+func (g *gceGRPCHealthChecks) GetGRPCHealthCheck(name string) (*GRPCHealthCheck, error) {
+	hc := &GRPCHealthCheck{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("healthChecks", name), nil, hc); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+// CreateGRPCHealthCheck creates the given health check, and waits for it to
+// be created.
+func (g *gceGRPCHealthChecks) CreateGRPCHealthCheck(hc *GRPCHealthCheck) error {
+	return g.rest.DoOp("POST", g.rest.GlobalURL("healthChecks", ""), hc)
+}
+
+// UpdateGRPCHealthCheck replaces the given health check, whatever its current
+// type, and waits for it to be updated.
+func (g *gceGRPCHealthChecks) UpdateGRPCHealthCheck(hc *GRPCHealthCheck) error {
+	return g.rest.DoOp("PUT", g.rest.GlobalURL("healthChecks", hc.Name), hc)
+}
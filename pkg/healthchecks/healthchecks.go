@@ -17,15 +17,16 @@ limitations under the License.
 package healthchecks
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
 	computealpha "google.golang.org/api/compute/v0.alpha"
 	compute "google.golang.org/api/compute/v1"
 
-	"github.com/golang/glog"
-
 	"encoding/json"
+	"k8s.io/ingress-gce/pkg/backendconfig"
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/ingress-gce/pkg/utils"
 )
 
@@ -58,20 +59,38 @@ const (
 	// backends, the port or named port specified in the Backend Service is
 	// used for health checking.
 	UseServingPortSpecification = "USE_SERVING_PORT"
+	// USE_FIXED_PORT: The port number in the health check is used for
+	// health checking.
+	UseFixedPortSpecification = "USE_FIXED_PORT"
+
+	// GRPCType is the type of the health checks checking the gRPC health
+	// checking protocol of the backends, inferred from gRPC readiness
+	// probes. They are managed through GRPCHealthChecks.
+	GRPCType = "GRPC"
 )
 
 // HealthChecks manages health checks.
 type HealthChecks struct {
-	cloud       HealthCheckProvider
+	cloud HealthCheckProvider
+	// grpc manages the gRPC health checks, nil if unsupported.
+	grpc        GRPCHealthChecks
 	defaultPath string
 	namer       *utils.Namer
+	// resetTunedSettings resets the settings tuned outside of the controller
+	// whenever a health check is updated.
+	resetTunedSettings bool
 }
 
 // NewHealthChecker creates a new health checker.
 // cloud: the cloud object implementing SingleHealthCheck.
+// grpc: manages the gRPC health checks. If nil, syncing a gRPC health check
+// fails.
 // defaultHealthCheckPath: is the HTTP path to use for health checks.
-func NewHealthChecker(cloud HealthCheckProvider, defaultHealthCheckPath string, namer *utils.Namer) HealthChecker {
-	return &HealthChecks{cloud, defaultHealthCheckPath, namer}
+// resetTunedSettings: if true, updates of health checks reset the settings
+// tuned outside of the controller, eg: the check interval, to the settings of
+// the controller.
+func NewHealthChecker(cloud HealthCheckProvider, grpc GRPCHealthChecks, defaultHealthCheckPath string, namer *utils.Namer, resetTunedSettings bool) HealthChecker {
+	return &HealthChecks{cloud, grpc, defaultHealthCheckPath, namer, resetTunedSettings}
 }
 
 // New returns a *HealthCheck with default settings and specified port/protocol
@@ -92,14 +111,20 @@ func (h *HealthChecks) New(port int64, protocol utils.AppProtocol, enableNEG boo
 // Sync retrieves a health check based on port, checks type and settings and updates/creates if necessary.
 // Sync is only called by the backends.Add func - it's not a pool like other resources.
 func (h *HealthChecks) Sync(hc *HealthCheck) (string, error) {
+	if hc.Type == GRPCType {
+		return h.syncGRPC(hc)
+	}
+
 	// Verify default path
 	if hc.RequestPath == "" {
 		hc.RequestPath = h.defaultPath
 	}
 
-	nodePort := hc.Port
-	// only use alpha API when PORT_SPECIFICATION field is specified
-	existingHC, err := h.Get(nodePort, hc.ForNEG)
+	// Health checks are retrieved by name, since a BackendConfig may change
+	// the port of the health check.
+	// only use alpha API when PORT_SPECIFICATION field is specified, or for
+	// HTTP2 health checks
+	existingHC, err := h.get(hc.Name, hc.isAlpha())
 	if err != nil {
 		if !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
 			return "", err
@@ -109,10 +134,13 @@ func (h *HealthChecks) Sync(hc *HealthCheck) (string, error) {
 			return "", err
 		}
 
-		return h.getHealthCheckLink(nodePort)
+		return h.getHealthCheckLink(hc.Name)
 	}
 
-	if needToUpdate(existingHC, hc) {
+	if needToUpdate(existingHC, hc) || !hc.hasConfig(existingHC) {
+		if !h.resetTunedSettings {
+			hc.keepTunedSettings(existingHC)
+		}
 		err = h.update(existingHC, hc)
 		return existingHC.SelfLink, err
 	}
@@ -121,20 +149,50 @@ func (h *HealthChecks) Sync(hc *HealthCheck) (string, error) {
 		// TODO: reconcile health checks, and compare headers interval etc.
 		// Currently Ingress doesn't expose all the health check params
 		// natively, so some users prefer to hand modify the check.
-		glog.V(2).Infof("Unexpected request path on health check %v, has %v want %v, NOT reconciling", hc.Name, existingHC.RequestPath, hc.RequestPath)
+		logging.V(2).Infof("Unexpected request path on health check %v, has %v want %v, NOT reconciling", hc.Name, existingHC.RequestPath, hc.RequestPath)
 	} else {
-		glog.V(2).Infof("Health check %v already exists and has the expected path %v", hc.Name, hc.RequestPath)
+		logging.V(2).Infof("Health check %v already exists and has the expected path %v", hc.Name, hc.RequestPath)
+	}
+
+	return existingHC.SelfLink, nil
+}
+
+// syncGRPC syncs the given gRPC health check. The gRPC settings are not
+// known to the vendored compute API, so the health check is created and
+// replaced through the REST API.
+func (h *HealthChecks) syncGRPC(hc *HealthCheck) (string, error) {
+	if h.grpc == nil {
+		return "", fmt.Errorf("health check %v: gRPC health checks are not supported", hc.Name)
+	}
+	existingHC, err := h.get(hc.Name, false)
+	if err != nil {
+		if !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
+			return "", err
+		}
+		logging.V(2).Infof("Creating gRPC health check %v", hc.Name)
+		if err = h.grpc.CreateGRPCHealthCheck(hc.toGRPCHealthCheck()); err != nil {
+			return "", err
+		}
+		return h.getHealthCheckLink(hc.Name)
 	}
 
+	if needToUpdate(existingHC, hc) || existingHC.GRPCServiceName != hc.GRPCServiceName || !hc.hasConfig(existingHC) {
+		if !h.resetTunedSettings {
+			hc.keepTunedSettings(existingHC)
+		}
+		logging.V(2).Infof("Updating health check %v to a gRPC health check of service %q", hc.Name, hc.GRPCServiceName)
+		return existingHC.SelfLink, h.grpc.UpdateGRPCHealthCheck(hc.toGRPCHealthCheck())
+	}
+	logging.V(2).Infof("gRPC health check %v already exists and checks service %q", hc.Name, hc.GRPCServiceName)
 	return existingHC.SelfLink, nil
 }
 
 func (h *HealthChecks) create(hc *HealthCheck) error {
-	if hc.ForNEG {
-		glog.V(2).Infof("Creating health check with protocol %v", hc.Type)
+	if hc.isAlpha() {
+		logging.V(2).Infof("Creating health check with protocol %v", hc.Type)
 		return h.cloud.CreateAlphaHealthCheck(hc.ToAlphaComputeHealthCheck())
 	} else {
-		glog.V(2).Infof("Creating health check for port %v with protocol %v", hc.Port, hc.Type)
+		logging.V(2).Infof("Creating health check for port %v with protocol %v", hc.Port, hc.Type)
 		v1hc, err := hc.ToComputeHealthCheck()
 		if err != nil {
 			return err
@@ -145,10 +203,13 @@ func (h *HealthChecks) create(hc *HealthCheck) error {
 
 func (h *HealthChecks) update(oldHC, newHC *HealthCheck) error {
 	if newHC.ForNEG {
-		glog.V(2).Infof("Updating health check with protocol %v", newHC.Type)
+		logging.V(2).Infof("Updating health check with protocol %v", newHC.Type)
 		return h.cloud.UpdateAlphaHealthCheck(mergeHealthcheckForNEG(oldHC, newHC).ToAlphaComputeHealthCheck())
+	} else if newHC.isAlpha() {
+		logging.V(2).Infof("Updating health check for port %v with protocol %v", newHC.Port, newHC.Type)
+		return h.cloud.UpdateAlphaHealthCheck(newHC.ToAlphaComputeHealthCheck())
 	} else {
-		glog.V(2).Infof("Updating health check for port %v with protocol %v", newHC.Port, newHC.Type)
+		logging.V(2).Infof("Updating health check for port %v with protocol %v", newHC.Port, newHC.Type)
 		v1hc, err := newHC.ToComputeHealthCheck()
 		if err != nil {
 			return err
@@ -161,16 +222,23 @@ func (h *HealthChecks) update(oldHC, newHC *HealthCheck) error {
 // This is to preserve the existing health check setting as much as possible.
 // WARNING: if a service backend is converted from IG mode to NEG mode,
 // the existing health check setting will be preserve, although it may not suit the customer needs.
+// The settings of the BackendConfig of the new health check still take
+// precedence.
 func mergeHealthcheckForNEG(oldHC, newHC *HealthCheck) *HealthCheck {
+	if oldHC.Type == GRPCType {
+		// A gRPC health check has no HTTP settings to preserve.
+		return newHC
+	}
 	portSpec := newHC.PortSpecification
 	newHC.HTTPHealthCheck = oldHC.HTTPHealthCheck
 	newHC.Port = 0
 	newHC.PortSpecification = portSpec
+	newHC.applyConfig()
 	return newHC
 }
 
-func (h *HealthChecks) getHealthCheckLink(port int64) (string, error) {
-	hc, err := h.Get(port, false)
+func (h *HealthChecks) getHealthCheckLink(name string) (string, error) {
+	hc, err := h.get(name, false)
 	if err != nil {
 		return "", err
 	}
@@ -180,15 +248,18 @@ func (h *HealthChecks) getHealthCheckLink(port int64) (string, error) {
 // Delete deletes the health check by port.
 func (h *HealthChecks) Delete(port int64) error {
 	name := h.namer.Backend(port)
-	glog.V(2).Infof("Deleting health check %v", name)
+	logging.V(2).Infof("Deleting health check %v", name)
 	return h.cloud.DeleteHealthCheck(name)
 }
 
 // Get returns the health check by port
 func (h *HealthChecks) Get(port int64, alpha bool) (*HealthCheck, error) {
+	return h.get(h.namer.Backend(port), alpha)
+}
+
+func (h *HealthChecks) get(name string, alpha bool) (*HealthCheck, error) {
 	var hc *computealpha.HealthCheck
 	var err error
-	name := h.namer.Backend(port)
 	if alpha {
 		hc, err = h.cloud.GetAlphaHealthCheck(name)
 	} else {
@@ -198,7 +269,21 @@ func (h *HealthChecks) Get(port int64, alpha bool) (*HealthCheck, error) {
 		}
 		hc, err = toAlphaHealthCheck(v1hc)
 	}
-	return NewHealthCheck(hc), err
+	if err != nil || hc.Type != GRPCType || h.grpc == nil {
+		return NewHealthCheck(hc), err
+	}
+	// The gRPC settings are only known to the REST API.
+	grpcHC, err := h.grpc.GetGRPCHealthCheck(name)
+	if err != nil {
+		return nil, err
+	}
+	ret := NewHealthCheck(hc)
+	if s := grpcHC.GrpcHealthCheck; s != nil {
+		ret.Port = s.Port
+		ret.PortSpecification = s.PortSpecification
+		ret.GRPCServiceName = s.GrpcServiceName
+	}
+	return ret, nil
 }
 
 // GetLegacy deletes legacy HTTP health checks
@@ -210,7 +295,7 @@ func (h *HealthChecks) GetLegacy(port int64) (*compute.HttpHealthCheck, error) {
 // DeleteLegacy deletes legacy HTTP health checks
 func (h *HealthChecks) DeleteLegacy(port int64) error {
 	name := h.namer.Backend(port)
-	glog.V(2).Infof("Deleting legacy HTTP health check %v", name)
+	logging.V(2).Infof("Deleting legacy HTTP health check %v", name)
 	return h.cloud.DeleteHttpHealthCheck(name)
 }
 
@@ -281,6 +366,14 @@ type HealthCheck struct {
 	computealpha.HTTPHealthCheck
 	computealpha.HealthCheck
 	ForNEG bool
+	// GRPCServiceName is the service checked by a gRPC health check, empty
+	// for the overall health of the server.
+	GRPCServiceName string
+
+	// config holds the settings of the BackendConfig of the backend, nil if
+	// none. They take precedence over the defaults and the readiness probe,
+	// and are reconciled on every sync.
+	config *backendconfig.HealthCheckConfig
 }
 
 // NewHealthCheck creates a HealthCheck which abstracts nested structs away
@@ -296,21 +389,148 @@ func NewHealthCheck(hc *computealpha.HealthCheck) *HealthCheck {
 	case utils.ProtocolHTTPS:
 		// HTTPHealthCheck and HTTPSHealthChecks have identical fields
 		v.HTTPHealthCheck = computealpha.HTTPHealthCheck(*hc.HttpsHealthCheck)
+	case utils.ProtocolHTTP2:
+		// HTTP2HealthCheck is only returned by the alpha API.
+		if hc.Http2HealthCheck != nil {
+			v.HTTPHealthCheck = computealpha.HTTPHealthCheck(*hc.Http2HealthCheck)
+		}
 	}
 
 	// Users should be modifying HTTP(S) specific settings on the embedded
 	// HTTPHealthCheck. Setting these to nil for preventing confusion.
 	v.HealthCheck.HttpHealthCheck = nil
 	v.HealthCheck.HttpsHealthCheck = nil
+	v.HealthCheck.Http2HealthCheck = nil
 
 	return v
 }
 
+// ApplyBackendConfig applies the given health check settings of a
+// BackendConfig to the health check. Settings which are not set are left
+// untouched.
+func (hc *HealthCheck) ApplyBackendConfig(config *backendconfig.HealthCheckConfig) {
+	if config == nil {
+		return
+	}
+	hc.config = config
+	hc.applyConfig()
+}
+
+func (hc *HealthCheck) applyConfig() {
+	c := hc.config
+	if c == nil {
+		return
+	}
+	if c.Protocol != "" {
+		hc.Type = c.Protocol
+	}
+	if c.RequestPath != "" {
+		hc.RequestPath = c.RequestPath
+	}
+	if c.Port != nil {
+		hc.Port = *c.Port
+		if hc.ForNEG {
+			// Check the given port of the endpoints rather than their
+			// serving port.
+			hc.PortSpecification = UseFixedPortSpecification
+		}
+	}
+	if c.CheckIntervalSec != nil {
+		hc.CheckIntervalSec = *c.CheckIntervalSec
+	}
+	if c.TimeoutSec != nil {
+		hc.TimeoutSec = *c.TimeoutSec
+	}
+	if c.HealthyThreshold != nil {
+		hc.HealthyThreshold = *c.HealthyThreshold
+	}
+	if c.UnhealthyThreshold != nil {
+		hc.UnhealthyThreshold = *c.UnhealthyThreshold
+	}
+}
+
+// keepTunedSettings copies the settings of the given existing health check
+// which were tuned outside of the controller to the health check. The
+// controller only owns the protocol, the port and the settings of the
+// BackendConfig. The other settings are considered tuned if they differ from
+// the defaults of the controller for the existing health check, so that
+// untuned health checks still get the defaults of a new backend type, eg:
+// when switching to NEGs. The request path and host are always kept.
+func (hc *HealthCheck) keepTunedSettings(existing *HealthCheck) {
+	defaults := DefaultHealthCheck(existing.Port, existing.Protocol())
+	if existing.PortSpecification != "" {
+		defaults = DefaultNEGHealthCheck(existing.Protocol())
+	}
+	if existing.CheckIntervalSec != defaults.CheckIntervalSec {
+		hc.CheckIntervalSec = existing.CheckIntervalSec
+	}
+	if existing.TimeoutSec != defaults.TimeoutSec {
+		hc.TimeoutSec = existing.TimeoutSec
+	}
+	if existing.HealthyThreshold != defaults.HealthyThreshold {
+		hc.HealthyThreshold = existing.HealthyThreshold
+	}
+	if existing.UnhealthyThreshold != defaults.UnhealthyThreshold {
+		hc.UnhealthyThreshold = existing.UnhealthyThreshold
+	}
+	if existing.RequestPath != "" {
+		hc.RequestPath = existing.RequestPath
+	}
+	hc.Host = existing.Host
+	hc.applyConfig()
+}
+
+// hasConfig returns true if the given existing health check has the settings
+// of the BackendConfig of hc.
+func (hc *HealthCheck) hasConfig(existing *HealthCheck) bool {
+	c := hc.config
+	if c == nil {
+		return true
+	}
+	// gRPC health checks have no request path.
+	return (c.RequestPath == "" || hc.Type == GRPCType || existing.RequestPath == c.RequestPath) &&
+		(c.Port == nil || existing.Port == *c.Port) &&
+		(c.CheckIntervalSec == nil || existing.CheckIntervalSec == *c.CheckIntervalSec) &&
+		(c.TimeoutSec == nil || existing.TimeoutSec == *c.TimeoutSec) &&
+		(c.HealthyThreshold == nil || existing.HealthyThreshold == *c.HealthyThreshold) &&
+		(c.UnhealthyThreshold == nil || existing.UnhealthyThreshold == *c.UnhealthyThreshold)
+}
+
 // Protocol returns the type cased to AppProtocol
 func (hc *HealthCheck) Protocol() utils.AppProtocol {
 	return utils.AppProtocol(hc.Type)
 }
 
+// isAlpha returns true if the health check can only be managed through the
+// alpha API: NEG health checks need the port specification, and the v1 API
+// has no HTTP2 health checks.
+func (hc *HealthCheck) isAlpha() bool {
+	return hc.ForNEG || hc.Protocol() == utils.ProtocolHTTP2
+}
+
+// toGRPCHealthCheck returns the gRPC health check of the REST API.
+func (hc *HealthCheck) toGRPCHealthCheck() *GRPCHealthCheck {
+	settings := &GRPCHealthCheckSettings{
+		Port:              hc.Port,
+		PortSpecification: hc.PortSpecification,
+		GrpcServiceName:   hc.GRPCServiceName,
+	}
+	// Cannot specify both the serving port and port field.
+	if settings.PortSpecification == UseServingPortSpecification {
+		settings.Port = 0
+	}
+	return &GRPCHealthCheck{
+		Name:               hc.Name,
+		Description:        hc.Description,
+		Type:               GRPCType,
+		CheckIntervalSec:   hc.CheckIntervalSec,
+		TimeoutSec:         hc.TimeoutSec,
+		HealthyThreshold:   hc.HealthyThreshold,
+		UnhealthyThreshold: hc.UnhealthyThreshold,
+		GrpcHealthCheck:    settings,
+	}
+}
+
 // ToComputeHealthCheck returns a valid compute.HealthCheck object
 func (hc *HealthCheck) ToComputeHealthCheck() (*compute.HealthCheck, error) {
 	hc.merge()
@@ -319,8 +539,8 @@ func (hc *HealthCheck) ToComputeHealthCheck() (*compute.HealthCheck, error) {
 
 // ToComputeHealthCheck returns a valid compute.HealthCheck object
 func (hc *HealthCheck) ToAlphaComputeHealthCheck() *computealpha.HealthCheck {
-	// Cannot specify both portSpecification and port field.
-	if len(hc.PortSpecification) > 0 {
+	// Cannot specify both the serving port and port field.
+	if hc.PortSpecification == UseServingPortSpecification {
 		hc.Port = 0
 	}
 	hc.merge()
@@ -332,6 +552,7 @@ func (hc *HealthCheck) merge() {
 	// if the wrong child struct is set.
 	hc.HealthCheck.HttpsHealthCheck = nil
 	hc.HealthCheck.HttpHealthCheck = nil
+	hc.HealthCheck.Http2HealthCheck = nil
 
 	switch hc.Protocol() {
 	case utils.ProtocolHTTP:
@@ -339,17 +560,20 @@ func (hc *HealthCheck) merge() {
 	case utils.ProtocolHTTPS:
 		https := computealpha.HTTPSHealthCheck(hc.HTTPHealthCheck)
 		hc.HealthCheck.HttpsHealthCheck = &https
+	case utils.ProtocolHTTP2:
+		http2 := computealpha.HTTP2HealthCheck(hc.HTTPHealthCheck)
+		hc.HealthCheck.Http2HealthCheck = &http2
 	}
 }
 
 func needToUpdate(old, new *HealthCheck) bool {
 	if old.Protocol() != new.Protocol() {
-		glog.V(2).Infof("Updating health check %v because it has protocol %v but need %v", old.Name, old.Type, new.Type)
+		logging.V(2).Infof("Updating health check %v because it has protocol %v but need %v", old.Name, old.Type, new.Type)
 		return true
 	}
 
 	if old.PortSpecification != new.PortSpecification {
-		glog.V(2).Infof("Updating health check %v because it has port specification %q but need %q", old.Name, old.PortSpecification, new.PortSpecification)
+		logging.V(2).Infof("Updating health check %v because it has port specification %q but need %q", old.Name, old.PortSpecification, new.PortSpecification)
 		return true
 	}
 	return false
@@ -22,13 +22,14 @@ import (
 
 	compute "google.golang.org/api/compute/v1"
 
+	"k8s.io/ingress-gce/pkg/backendconfig"
 	"k8s.io/ingress-gce/pkg/utils"
 )
 
 func TestHealthCheckAdd(t *testing.T) {
 	namer := utils.NewNamer("ABC", "XYZ")
 	hcp := NewFakeHealthCheckProvider()
-	healthChecks := NewHealthChecker(hcp, "/", namer)
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
 
 	hc := healthChecks.New(80, utils.ProtocolHTTP, false)
 	_, err := healthChecks.Sync(hc)
@@ -56,7 +57,7 @@ func TestHealthCheckAdd(t *testing.T) {
 func TestHealthCheckAddExisting(t *testing.T) {
 	namer := &utils.Namer{}
 	hcp := NewFakeHealthCheckProvider()
-	healthChecks := NewHealthChecker(hcp, "/", namer)
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
 
 	// HTTP
 	// Manually insert a health check
@@ -107,7 +108,7 @@ func TestHealthCheckAddExisting(t *testing.T) {
 func TestHealthCheckDelete(t *testing.T) {
 	namer := &utils.Namer{}
 	hcp := NewFakeHealthCheckProvider()
-	healthChecks := NewHealthChecker(hcp, "/", namer)
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
 
 	// Create HTTP HC for 1234
 	hc := DefaultHealthCheck(1234, utils.ProtocolHTTP)
@@ -144,7 +145,7 @@ func TestHealthCheckDelete(t *testing.T) {
 func TestHealthCheckUpdate(t *testing.T) {
 	namer := &utils.Namer{}
 	hcp := NewFakeHealthCheckProvider()
-	healthChecks := NewHealthChecker(hcp, "/", namer)
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
 
 	// HTTP
 	// Manually insert a health check
@@ -185,7 +186,7 @@ func TestHealthCheckUpdate(t *testing.T) {
 func TestHealthCheckDeleteLegacy(t *testing.T) {
 	namer := &utils.Namer{}
 	hcp := NewFakeHealthCheckProvider()
-	healthChecks := NewHealthChecker(hcp, "/", namer)
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
 
 	err := hcp.CreateHttpHealthCheck(&compute.HttpHealthCheck{
 		Name: namer.Backend(80),
@@ -204,7 +205,7 @@ func TestHealthCheckDeleteLegacy(t *testing.T) {
 func TestAlphaHealthCheck(t *testing.T) {
 	namer := &utils.Namer{}
 	hcp := NewFakeHealthCheckProvider()
-	healthChecks := NewHealthChecker(hcp, "/", namer)
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
 	hc := healthChecks.New(8000, utils.ProtocolHTTP, true)
 	_, err := healthChecks.Sync(hc)
 	if err != nil {
@@ -222,3 +223,186 @@ func TestAlphaHealthCheck(t *testing.T) {
 		t.Errorf("got ret.PortSpecification = %q, want %q", UseServingPortSpecification, ret.PortSpecification)
 	}
 }
+
+func TestHTTP2HealthCheck(t *testing.T) {
+	namer := &utils.Namer{}
+	hcp := NewFakeHealthCheckProvider()
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
+	hc := healthChecks.New(8000, utils.ProtocolHTTP2, false)
+	hc.RequestPath = "/healthz"
+	if _, err := healthChecks.Sync(hc); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	// HTTP2 health checks are only visible through the alpha API.
+	alphaHC, err := hcp.GetAlphaHealthCheck(namer.Backend(8000))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if alphaHC.Type != string(utils.ProtocolHTTP2) || alphaHC.Http2HealthCheck == nil {
+		t.Fatalf("got health check %+v, want an HTTP2 health check", alphaHC)
+	}
+	if alphaHC.Http2HealthCheck.Port != 8000 || alphaHC.Http2HealthCheck.RequestPath != "/healthz" {
+		t.Errorf("got HTTP2 settings %+v, want port 8000 and path /healthz", alphaHC.Http2HealthCheck)
+	}
+
+	ret, err := healthChecks.Get(8000, true)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if ret.RequestPath != "/healthz" {
+		t.Errorf("got ret.RequestPath = %q, want /healthz", ret.RequestPath)
+	}
+
+	// Switching back to HTTP updates the health check.
+	hc = healthChecks.New(8000, utils.ProtocolHTTP, false)
+	if _, err := healthChecks.Sync(hc); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if ret, _ := healthChecks.Get(8000, false); ret.Protocol() != utils.ProtocolHTTP {
+		t.Errorf("got protocol %v, want %v", ret.Protocol(), utils.ProtocolHTTP)
+	}
+}
+
+func TestHealthCheckBackendConfig(t *testing.T) {
+	namer := &utils.Namer{}
+	hcp := NewFakeHealthCheckProvider()
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
+	interval, threshold := int64(5), int64(3)
+	config := &backendconfig.HealthCheckConfig{RequestPath: "/healthz", CheckIntervalSec: &interval, UnhealthyThreshold: &threshold}
+	hc := healthChecks.New(8000, utils.ProtocolHTTP, false)
+	hc.ApplyBackendConfig(config)
+	if _, err := healthChecks.Sync(hc); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	ret, err := healthChecks.Get(8000, false)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if ret.RequestPath != "/healthz" || ret.CheckIntervalSec != interval || ret.UnhealthyThreshold != threshold || ret.Port != 8000 {
+		t.Errorf("got health check %+v, want the settings of the BackendConfig", ret)
+	}
+
+	// Settings the BackendConfig does not set are kept when it changes.
+	tuned := ret.ToAlphaComputeHealthCheck()
+	tuned.HealthyThreshold = 4
+	hcp.UpdateAlphaHealthCheck(tuned)
+	interval = 7
+	hc = healthChecks.New(8000, utils.ProtocolHTTP, false)
+	hc.ApplyBackendConfig(config)
+	if _, err := healthChecks.Sync(hc); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	ret, _ = healthChecks.Get(8000, false)
+	if ret.CheckIntervalSec != 7 || ret.HealthyThreshold != 4 {
+		t.Errorf("got health check %+v, want interval 7 and healthy threshold 4", ret)
+	}
+
+	// NEG health checks check the configured port of the endpoints.
+	port := int64(9000)
+	hc = healthChecks.New(8001, utils.ProtocolHTTP, true)
+	hc.ApplyBackendConfig(&backendconfig.HealthCheckConfig{Port: &port, Protocol: "HTTPS"})
+	if _, err := healthChecks.Sync(hc); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	ret, _ = healthChecks.Get(8001, true)
+	if ret.PortSpecification != UseFixedPortSpecification || ret.Port != port || ret.Protocol() != utils.ProtocolHTTPS {
+		t.Errorf("got health check %+v, want an HTTPS health check of fixed port %v", ret, port)
+	}
+}
+
+func TestHealthCheckKeepTunedSettings(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		namer := &utils.Namer{}
+		hcp := NewFakeHealthCheckProvider()
+		healthChecks := NewHealthChecker(hcp, hcp, "/", namer, reset)
+		if _, err := healthChecks.Sync(healthChecks.New(8000, utils.ProtocolHTTP, false)); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if _, err := healthChecks.Sync(healthChecks.New(8001, utils.ProtocolHTTP, false)); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		// Tune the first health check in the console.
+		tuned, _ := healthChecks.Get(8000, false)
+		tuned.CheckIntervalSec = 30
+		tuned.RequestPath = "/ready"
+		v1hc, _ := tuned.ToComputeHealthCheck()
+		hcp.UpdateHealthCheck(v1hc)
+
+		// Switch both health checks to HTTPS, the second one to NEG.
+		if _, err := healthChecks.Sync(healthChecks.New(8000, utils.ProtocolHTTPS, false)); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if _, err := healthChecks.Sync(healthChecks.New(8001, utils.ProtocolHTTPS, true)); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		ret, _ := healthChecks.Get(8000, false)
+		wantInterval, wantPath := int64(30), "/ready"
+		if reset {
+			wantInterval, wantPath = int64(DefaultHealthCheckInterval.Seconds()), "/"
+		}
+		if ret.Protocol() != utils.ProtocolHTTPS || ret.CheckIntervalSec != wantInterval || ret.RequestPath != wantPath {
+			t.Errorf("reset %v: got health check %+v, want HTTPS, interval %v and path %q", reset, ret, wantInterval, wantPath)
+		}
+		// Untuned settings get the defaults of NEGs.
+		ret, _ = healthChecks.Get(8001, true)
+		if ret.CheckIntervalSec != int64(DefaultNEGHealthCheckInterval.Seconds()) || ret.UnhealthyThreshold != DefaultNEGUnhealthyThreshold {
+			t.Errorf("reset %v: got health check %+v, want the NEG defaults", reset, ret)
+		}
+	}
+}
+
+func TestHealthCheckGRPC(t *testing.T) {
+	namer := &utils.Namer{}
+	hcp := NewFakeHealthCheckProvider()
+	healthChecks := NewHealthChecker(hcp, hcp, "/", namer, false)
+
+	// An HTTP2 health check becomes a gRPC health check of the serving port.
+	if _, err := healthChecks.Sync(healthChecks.New(8000, utils.ProtocolHTTP2, true)); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	hc := healthChecks.New(8000, utils.ProtocolHTTP2, true)
+	hc.Type = GRPCType
+	hc.GRPCServiceName = "foo"
+	if _, err := healthChecks.Sync(hc); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	grpcHC, _ := hcp.GetGRPCHealthCheck(namer.Backend(8000))
+	want := GRPCHealthCheckSettings{PortSpecification: UseServingPortSpecification, GrpcServiceName: "foo"}
+	if grpcHC.Type != GRPCType || grpcHC.GrpcHealthCheck == nil || *grpcHC.GrpcHealthCheck != want {
+		t.Errorf("got health check %+v, want a gRPC health check with %+v", grpcHC, want)
+	}
+	ret, _ := healthChecks.Get(8000, true)
+	if ret.GRPCServiceName != "foo" || ret.PortSpecification != UseServingPortSpecification {
+		t.Errorf("got health check %+v, want service foo of the serving port", ret)
+	}
+
+	// A change of the service updates the health check.
+	hc = healthChecks.New(8000, utils.ProtocolHTTP2, true)
+	hc.Type = GRPCType
+	hc.GRPCServiceName = "bar"
+	if _, err := healthChecks.Sync(hc); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if grpcHC, _ = hcp.GetGRPCHealthCheck(namer.Backend(8000)); grpcHC.GrpcHealthCheck.GrpcServiceName != "bar" {
+		t.Errorf("got health check %+v, want service bar", grpcHC.GrpcHealthCheck)
+	}
+
+	// Without the gRPC probe, the health check is an HTTP2 one again.
+	if _, err := healthChecks.Sync(healthChecks.New(8000, utils.ProtocolHTTP2, true)); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	ret, _ = healthChecks.Get(8000, true)
+	if ret.Protocol() != utils.ProtocolHTTP2 || ret.RequestPath != "/" {
+		t.Errorf("got health check %+v, want an HTTP2 health check of path /", ret)
+	}
+
+	// gRPC health checks need the REST API.
+	hc = NewHealthChecker(hcp, nil, "/", namer, false).New(8001, utils.ProtocolHTTP2, false)
+	hc.Type = GRPCType
+	if _, err := NewHealthChecker(hcp, nil, "/", namer, false).Sync(hc); err == nil {
+		t.Errorf("got nil, want an error without gRPC health checks")
+	}
+}
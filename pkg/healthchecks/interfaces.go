@@ -39,6 +39,14 @@ type HealthCheckProvider interface {
 	GetHealthCheck(name string) (*compute.HealthCheck, error)
 }
 
+// GRPCHealthChecks is an interface to manage the gRPC health checks, which
+// the vendored compute API predates.
+type GRPCHealthChecks interface {
+	GetGRPCHealthCheck(name string) (*GRPCHealthCheck, error)
+	CreateGRPCHealthCheck(hc *GRPCHealthCheck) error
+	UpdateGRPCHealthCheck(hc *GRPCHealthCheck) error
+}
+
 // HealthChecker is an interface to manage cloud HTTPHealthChecks.
 type HealthChecker interface {
 	New(port int64, protocol utils.AppProtocol, enableNEG bool) *HealthCheck
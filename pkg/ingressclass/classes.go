@@ -0,0 +1,234 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingressclass
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// controllerClasses are the classes of the Ingresses, in the values of the
+// kubernetes.io/ingress.class annotation, of the IngressClasses of the
+// controller, keyed by controller name.
+var controllerClasses = map[string]string{
+	ControllerGCE:              annotations.GceIngressClass,
+	ControllerInternal:         annotations.GceInternalIngressClass,
+	ControllerRegionalExternal: annotations.GceRegionalExternalIngressClass,
+}
+
+// Classes resolves the class of the Ingresses through the IngressClasses,
+// which are listed every sync. A nil Classes only knows the
+// kubernetes.io/ingress.class annotation.
+type Classes struct {
+	client Client
+
+	lock    sync.RWMutex
+	classes map[string]*IngressClass
+	// names caches the ingressClassName of the Ingresses, read again once
+	// their resource version changes.
+	names map[types.NamespacedName]cachedName
+}
+
+// cachedName is the ingressClassName of an Ingress at the given resource
+// version.
+type cachedName struct {
+	resourceVersion string
+	className       string
+}
+
+// NewClasses returns the Classes of the IngressClasses of the given client.
+func NewClasses(client Client) *Classes {
+	return &Classes{
+		client:  client,
+		classes: map[string]*IngressClass{},
+		names:   map[types.NamespacedName]cachedName{},
+	}
+}
+
+// Run syncs the IngressClasses every period until stopCh is closed, and calls
+// onChange after the syncs which changed them.
+func (c *Classes) Run(period time.Duration, stopCh <-chan struct{}, onChange func()) {
+	wait.Until(func() {
+		changed, err := c.Sync()
+		if err != nil {
+			logging.Warningf("Failed to sync IngressClasses: %v", err)
+			return
+		}
+		if changed {
+			onChange()
+		}
+	}, period, stopCh)
+}
+
+// Sync lists the IngressClasses, and returns true if they changed.
+func (c *Classes) Sync() (bool, error) {
+	list, err := c.client.ListIngressClasses()
+	if err != nil {
+		return false, err
+	}
+	classes := map[string]*IngressClass{}
+	for i := range list {
+		classes[list[i].Name] = &list[i]
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	changed := len(classes) != len(c.classes)
+	for name, class := range classes {
+		if old, ok := c.classes[name]; !ok || old.ResourceVersion != class.ResourceVersion {
+			changed = true
+		}
+	}
+	c.classes = classes
+	return changed, nil
+}
+
+// Class returns the class of the given Ingress, in the values of the
+// kubernetes.io/ingress.class annotation, eg: "gce". The IngressClass of the
+// Ingress is named by the annotation, or else by its ingressClassName, or
+// else is the default IngressClass:
+// * an IngressClass of the controller gives the class of its controller
+// name, eg: "gce" for k8s.io/ingress-gce.
+// * an IngressClass of another controller gives the name of the controller,
+// which isn't a class of this one.
+// * a missing IngressClass gives its name, as the annotation always did.
+// Without any IngressClass in the cluster, only the annotation is read.
+func (c *Classes) Class(ing *extensions.Ingress) string {
+	class := c.ingressClass(ing)
+	if class == nil {
+		return c.className(ing)
+	}
+	if cls, ok := controllerClasses[class.Spec.Controller]; ok {
+		return cls
+	}
+	return class.Spec.Controller
+}
+
+// FrontendConfig returns the namespace and the name of the FrontendConfig of
+// the parameters of the IngressClass of the given Ingress, if it's of the
+// controller, empty if none. The parameters of the Cluster scope, the
+// default, reference the FrontendConfig of the namespace of each Ingress.
+func (c *Classes) FrontendConfig(ing *extensions.Ingress) (string, string) {
+	class := c.ingressClass(ing)
+	if class == nil || class.Spec.Parameters == nil {
+		return "", ""
+	}
+	if _, ok := controllerClasses[class.Spec.Controller]; !ok {
+		return "", ""
+	}
+	params := class.Spec.Parameters
+	if params.APIGroup == nil || *params.APIGroup != ParametersGroupName || params.Kind != ParametersKindFrontendConfig {
+		logging.V(3).Infof("Ignoring the parameters of IngressClass %v, they aren't a %v.%v", class.Name, ParametersKindFrontendConfig, ParametersGroupName)
+		return "", ""
+	}
+	if params.Scope != nil && *params.Scope == ScopeNamespace && params.Namespace != nil {
+		return *params.Namespace, params.Name
+	}
+	return ing.Namespace, params.Name
+}
+
+// ingressClass returns the IngressClass of the given Ingress, nil if it's
+// missing, or if the cluster has none.
+func (c *Classes) ingressClass(ing *extensions.Ingress) *IngressClass {
+	if c == nil {
+		return nil
+	}
+	c.lock.RLock()
+	none := len(c.classes) == 0
+	c.lock.RUnlock()
+	if none {
+		return nil
+	}
+	name := c.className(ing)
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if name != "" {
+		return c.classes[name]
+	}
+	return c.defaultClass()
+}
+
+// className returns the name of the IngressClass of the given Ingress, from
+// its annotation or its ingressClassName, empty if it names none. The
+// ingressClassName is only read if the cluster has IngressClasses.
+func (c *Classes) className(ing *extensions.Ingress) string {
+	if name := annotations.IngAnnotations(ing.Annotations).IngressClass(); name != "" || c == nil {
+		return name
+	}
+	c.lock.RLock()
+	none := len(c.classes) == 0
+	key := types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}
+	cached, ok := c.names[key]
+	c.lock.RUnlock()
+	if none {
+		return ""
+	}
+	if ok && cached.resourceVersion == ing.ResourceVersion {
+		return cached.className
+	}
+	name, err := c.client.IngressClassName(ing.Namespace, ing.Name)
+	if err != nil {
+		// The Ingress keeps its previous class.
+		logging.ForIngress(key.String()).Warningf("Failed to read the ingressClassName: %v", err)
+		return cached.className
+	}
+	c.lock.Lock()
+	c.names[key] = cachedName{resourceVersion: ing.ResourceVersion, className: name}
+	c.lock.Unlock()
+	return name
+}
+
+// defaultClass returns the default IngressClass, the first by name if
+// several are marked as default, nil if none. The lock must be held.
+func (c *Classes) defaultClass() *IngressClass {
+	var names []string
+	for name, class := range c.classes {
+		if class.Annotations[DefaultClassKey] == "true" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return c.classes[names[0]]
+}
+
+// Forget drops the cached ingressClassName of the given deleted Ingress.
+func (c *Classes) Forget(obj interface{}) {
+	if c == nil {
+		return
+	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ing, ok := obj.(*extensions.Ingress)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.names, types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name})
+}
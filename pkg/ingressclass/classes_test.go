@@ -0,0 +1,158 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingressclass
+
+import (
+	"testing"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+)
+
+func newIngressClass(name, controller string, isDefault bool) IngressClass {
+	class := IngressClass{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, ResourceVersion: "1"},
+		Spec:       IngressClassSpec{Controller: controller},
+	}
+	if isDefault {
+		class.Annotations = map[string]string{DefaultClassKey: "true"}
+	}
+	return class
+}
+
+func newIngress(name, class string) *extensions.Ingress {
+	ing := &extensions.Ingress{ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: "1"}}
+	if class != "" {
+		ing.Annotations = map[string]string{annotations.IngressClassKey: class}
+	}
+	return ing
+}
+
+func TestClass(t *testing.T) {
+	client := &FakeClient{ClassNames: map[string]string{
+		"default/gce":      "gce-class",
+		"default/nginx":    "nginx",
+		"default/internal": "internal",
+		"default/missing":  "gce-internal",
+	}}
+	classes := NewClasses(client)
+
+	// Without IngressClasses, only the annotation counts.
+	for _, tc := range []struct {
+		ing  *extensions.Ingress
+		want string
+	}{
+		{newIngress("nginx", ""), ""},
+		{newIngress("annotated", "gce-internal"), "gce-internal"},
+	} {
+		if got := classes.Class(tc.ing); got != tc.want {
+			t.Errorf("Class(%v) = %q, want %q", tc.ing.Name, got, tc.want)
+		}
+	}
+	if client.Reads != 0 {
+		t.Errorf("%d reads of ingressClassName, want none without IngressClasses", client.Reads)
+	}
+
+	client.Classes = []IngressClass{
+		newIngressClass("gce-class", ControllerGCE, false),
+		newIngressClass("internal", ControllerInternal, false),
+		newIngressClass("nginx", "k8s.io/ingress-nginx", true),
+	}
+	if changed, err := classes.Sync(); err != nil || !changed {
+		t.Fatalf("Sync() = %v, %v, want changed", changed, err)
+	}
+	if changed, err := classes.Sync(); err != nil || changed {
+		t.Fatalf("Sync() = %v, %v, want unchanged", changed, err)
+	}
+	for _, tc := range []struct {
+		ing  *extensions.Ingress
+		want string
+	}{
+		{newIngress("gce", ""), annotations.GceIngressClass},
+		{newIngress("internal", ""), annotations.GceInternalIngressClass},
+		{newIngress("nginx", ""), "k8s.io/ingress-nginx"},
+		// A missing IngressClass is named as the annotation.
+		{newIngress("missing", ""), annotations.GceInternalIngressClass},
+		// The Ingresses naming no class get the default IngressClass.
+		{newIngress("unnamed", ""), "k8s.io/ingress-nginx"},
+		// The annotation names an IngressClass too.
+		{newIngress("annotated", "internal"), annotations.GceInternalIngressClass},
+		{newIngress("annotated", "gce"), annotations.GceIngressClass},
+	} {
+		if got := classes.Class(tc.ing); got != tc.want {
+			t.Errorf("Class(%v) = %q, want %q", tc.ing.Name, got, tc.want)
+		}
+	}
+
+	// The ingressClassName is cached until the Ingress changes.
+	reads := client.Reads
+	classes.Class(newIngress("gce", ""))
+	if client.Reads != reads {
+		t.Errorf("ingressClassName read again for the same resource version")
+	}
+	client.ClassNames["default/gce"] = "nginx"
+	ing := newIngress("gce", "")
+	ing.ResourceVersion = "2"
+	if got := classes.Class(ing); got != "k8s.io/ingress-nginx" {
+		t.Errorf("Class() = %q after the change of ingressClassName, want k8s.io/ingress-nginx", got)
+	}
+
+	// Without default IngressClass, the Ingresses naming none are of the
+	// controller.
+	client.Classes = client.Classes[:2]
+	classes.Sync()
+	if got := classes.Class(newIngress("unnamed", "")); got != "" {
+		t.Errorf("Class() = %q, want the default class", got)
+	}
+
+	var none *Classes
+	if got := none.Class(newIngress("annotated", "gce-internal")); got != "gce-internal" {
+		t.Errorf("Class() of nil Classes = %q, want the annotation", got)
+	}
+}
+
+func TestFrontendConfig(t *testing.T) {
+	group, kind := ParametersGroupName, ParametersKindFrontendConfig
+	scope, namespace := ScopeNamespace, "config"
+	gce := newIngressClass("gce", ControllerGCE, true)
+	gce.Spec.Parameters = &IngressClassParametersReference{APIGroup: &group, Kind: kind, Name: "fc"}
+	shared := newIngressClass("shared", ControllerGCE, false)
+	shared.Spec.Parameters = &IngressClassParametersReference{APIGroup: &group, Kind: kind, Name: "shared-fc", Scope: &scope, Namespace: &namespace}
+	other := newIngressClass("other", ControllerGCE, false)
+	other.Spec.Parameters = &IngressClassParametersReference{Kind: "ConfigMap", Name: "cm"}
+	classes := NewClasses(&FakeClient{Classes: []IngressClass{gce, shared, other}})
+	if _, err := classes.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	for _, tc := range []struct {
+		class         string
+		wantNamespace string
+		wantName      string
+	}{
+		{"", "default", "fc"},
+		{"shared", "config", "shared-fc"},
+		{"other", "", ""},
+		{"missing", "", ""},
+	} {
+		namespace, name := classes.FrontendConfig(newIngress("ing", tc.class))
+		if namespace != tc.wantNamespace || name != tc.wantName {
+			t.Errorf("FrontendConfig() of class %q = %q, %q, want %q, %q", tc.class, namespace, name, tc.wantNamespace, tc.wantName)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingressclass
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ingressClassResource is the plural resource name of the IngressClasses.
+const ingressClassResource = "ingressclasses"
+
+// APIServerClient reads the IngressClasses and the Ingresses in the
+// Kubernetes apiserver, through the generic REST client since the vendored
+// Kubernetes API has neither the IngressClasses nor the ingressClassName of
+// the Ingresses.
+type APIServerClient struct {
+	Client kubernetes.Interface
+}
+
+// Ensure that APIServerClient implements Client.
+var _ Client = &APIServerClient{}
+
+func (c *APIServerClient) restClient() (rest.Interface, error) {
+	restClient := c.Client.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, fmt.Errorf("no REST client for the IngressClasses")
+	}
+	return restClient, nil
+}
+
+// ListIngressClasses implements Client. An apiserver without IngressClasses
+// has none.
+func (c *APIServerClient) ListIngressClasses() ([]IngressClass, error) {
+	restClient, err := c.restClient()
+	if err != nil {
+		return nil, err
+	}
+	data, err := restClient.Get().AbsPath("/apis", GroupName, Version, ingressClassResource).DoRaw()
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v: %v", ingressClassResource, err)
+	}
+	list := &IngressClassList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", ingressClassResource, err)
+	}
+	return list.Items, nil
+}
+
+// IngressClassName implements Client.
+func (c *APIServerClient) IngressClassName(namespace, name string) (string, error) {
+	restClient, err := c.restClient()
+	if err != nil {
+		return "", err
+	}
+	data, err := restClient.Get().AbsPath("/apis/extensions/v1beta1/namespaces", namespace, "ingresses", name).DoRaw()
+	if err != nil {
+		return "", err
+	}
+	ing := struct {
+		Spec struct {
+			IngressClassName string `json:"ingressClassName"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(data, &ing); err != nil {
+		return "", fmt.Errorf("failed to decode Ingress %v/%v: %v", namespace, name, err)
+	}
+	return ing.Spec.IngressClassName, nil
+}
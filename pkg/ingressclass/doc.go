@@ -0,0 +1,23 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ingressclass resolves the class of the Ingresses through the
+// networking.k8s.io/v1 IngressClasses: the class named by the
+// kubernetes.io/ingress.class annotation or the spec.ingressClassName of an
+// Ingress, or else the default IngressClass, is claimed by the controller if
+// its spec.controller is one of the controller, and its parameters may
+// reference a FrontendConfig applied to the Ingresses of the class.
+package ingressclass
@@ -0,0 +1,52 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingressclass
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FakeClient is a fake Client, keeping the IngressClasses and the
+// ingressClassName of the Ingresses in memory.
+type FakeClient struct {
+	mu      sync.Mutex
+	Classes []IngressClass
+	// ClassNames are the ingressClassName of the Ingresses, keyed by
+	// namespace/name.
+	ClassNames map[string]string
+	// Reads counts the reads of ingressClassName.
+	Reads int
+}
+
+// Ensure that FakeClient implements Client.
+var _ Client = &FakeClient{}
+
+// ListIngressClasses implements Client.
+func (f *FakeClient) ListIngressClasses() ([]IngressClass, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]IngressClass(nil), f.Classes...), nil
+}
+
+// IngressClassName implements Client.
+func (f *FakeClient) IngressClassName(namespace, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Reads++
+	return f.ClassNames[fmt.Sprintf("%v/%v", namespace, name)], nil
+}
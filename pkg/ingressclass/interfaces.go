@@ -0,0 +1,27 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingressclass
+
+// Client lists the IngressClasses, and reads the ingressClassName of the
+// Ingresses.
+type Client interface {
+	// ListIngressClasses returns all the IngressClasses.
+	ListIngressClasses() ([]IngressClass, error)
+	// IngressClassName returns the spec.ingressClassName of the Ingress of
+	// the given namespace and name, empty if unset.
+	IngressClassName(namespace, name string) (string, error)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingressclass
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GroupName is the API group of the IngressClasses.
+	GroupName = "networking.k8s.io"
+	// Version is the API version of the IngressClasses.
+	Version = "v1"
+
+	// DefaultClassKey marks the default IngressClass of the cluster, whose
+	// class is that of the Ingresses naming none.
+	DefaultClassKey = "ingressclass.kubernetes.io/is-default-class"
+
+	// Controller names of the IngressClasses of the controller, one per
+	// class of load balancer.
+	ControllerGCE              = "k8s.io/ingress-gce"
+	ControllerInternal         = "k8s.io/ingress-gce-internal"
+	ControllerRegionalExternal = "k8s.io/ingress-gce-regional-external"
+
+	// ParametersGroupName and ParametersKindFrontendConfig are the
+	// parameters of the IngressClasses of the controller: a FrontendConfig
+	// applied to the Ingresses of the class which reference none.
+	ParametersGroupName          = "networking.gke.io"
+	ParametersKindFrontendConfig = "FrontendConfig"
+	// ScopeNamespace is the scope of the parameters of a given namespace.
+	ScopeNamespace = "Namespace"
+)
+
+// IngressClass is a networking.k8s.io/v1 IngressClass, which the vendored
+// Kubernetes API predates.
+type IngressClass struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IngressClassSpec `json:"spec,omitempty"`
+}
+
+// IngressClassSpec is the spec of an IngressClass.
+type IngressClassSpec struct {
+	// Controller is the name of the controller of the class.
+	Controller string                           `json:"controller,omitempty"`
+	Parameters *IngressClassParametersReference `json:"parameters,omitempty"`
+}
+
+// IngressClassParametersReference references the parameters of an
+// IngressClass. Namespace is only set with the Namespace scope.
+type IngressClassParametersReference struct {
+	APIGroup  *string `json:"apiGroup,omitempty"`
+	Kind      string  `json:"kind"`
+	Name      string  `json:"name"`
+	Scope     *string `json:"scope,omitempty"`
+	Namespace *string `json:"namespace,omitempty"`
+}
+
+// IngressClassList is a list of IngressClasses.
+type IngressClassList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []IngressClass `json:"items"`
+}
@@ -30,7 +30,7 @@ import (
 func NewFakeInstanceGroups(nodes sets.String) *FakeInstanceGroups {
 	return &FakeInstanceGroups{
 		instances:        nodes,
-		listResult:       getInstanceList(nodes),
+		groupInstances:   map[string]sets.String{},
 		namer:            utils.Namer{},
 		zonesToInstances: map[string][]string{},
 	}
@@ -58,10 +58,12 @@ func (z *FakeZoneLister) GetZoneForNode(name string) (string, error) {
 
 // FakeInstanceGroups fakes out the instance groups api.
 type FakeInstanceGroups struct {
-	instances        sets.String
-	instanceGroups   []*compute.InstanceGroup
-	getResult        *compute.InstanceGroup
-	listResult       *compute.InstanceGroupsListInstances
+	instances      sets.String
+	instanceGroups []*compute.InstanceGroup
+	getResult      *compute.InstanceGroup
+	// groupInstances are the instances of each instance group, keyed by
+	// zone/name.
+	groupInstances   map[string]sets.String
 	calls            []int
 	namer            utils.Namer
 	zonesToInstances map[string][]string
@@ -84,6 +86,15 @@ func (f *FakeInstanceGroups) CreateInstanceGroup(ig *compute.InstanceGroup, zone
 	ig.SelfLink = ig.Name
 	ig.Zone = zone
 	f.instanceGroups = append(f.instanceGroups, ig)
+	// The instances not in any other instance group of the zone start in
+	// the new instance group.
+	instances := sets.NewString(f.instances.List()...)
+	for key, groupInstances := range f.groupInstances {
+		if strings.HasPrefix(key, zone+"/") {
+			instances = instances.Difference(groupInstances)
+		}
+	}
+	f.groupInstances[groupKey(ig.Name, zone)] = instances
 	return nil
 }
 
@@ -92,22 +103,43 @@ func (f *FakeInstanceGroups) DeleteInstanceGroup(name, zone string) error {
 	newGroups := []*compute.InstanceGroup{}
 	found := false
 	for _, ig := range f.instanceGroups {
-		if ig.Name == name {
+		if ig.Name == name && ig.Zone == zone {
 			found = true
 			continue
 		}
 		newGroups = append(newGroups, ig)
 	}
 	if !found {
-		return fmt.Errorf("instance group %v not found", name)
+		return utils.FakeGoogleAPINotFoundErr()
 	}
 	f.instanceGroups = newGroups
+	delete(f.groupInstances, groupKey(name, zone))
 	return nil
 }
 
+// ListInstanceGroups fakes listing the instance groups of a zone.
+func (f *FakeInstanceGroups) ListInstanceGroups(zone string) (*compute.InstanceGroupList, error) {
+	list := &compute.InstanceGroupList{}
+	for _, ig := range f.instanceGroups {
+		if ig.Zone == zone {
+			list.Items = append(list.Items, ig)
+		}
+	}
+	return list, nil
+}
+
 // ListInstancesInInstanceGroup fakes listing instances in an instance group.
 func (f *FakeInstanceGroups) ListInstancesInInstanceGroup(name, zone string, state string) (*compute.InstanceGroupsListInstances, error) {
-	return f.listResult, nil
+	instances, ok := f.groupInstances[groupKey(name, zone)]
+	if !ok {
+		return nil, utils.FakeGoogleAPINotFoundErr()
+	}
+	return getInstanceList(instances), nil
+}
+
+// GetInstancesOfGroup returns the instances of the given instance group.
+func (f *FakeInstanceGroups) GetInstancesOfGroup(name, zone string) sets.String {
+	return f.groupInstances[groupKey(name, zone)]
 }
 
 // AddInstancesToInstanceGroup fakes adding instances to an instance group.
@@ -115,6 +147,9 @@ func (f *FakeInstanceGroups) AddInstancesToInstanceGroup(name, zone string, inst
 	instanceNames := toInstanceNames(instanceRefs)
 	f.calls = append(f.calls, utils.AddInstances)
 	f.instances.Insert(instanceNames...)
+	if instances, ok := f.groupInstances[groupKey(name, zone)]; ok {
+		instances.Insert(instanceNames...)
+	}
 	if _, ok := f.zonesToInstances[zone]; !ok {
 		f.zonesToInstances[zone] = []string{}
 	}
@@ -132,6 +167,9 @@ func (f *FakeInstanceGroups) RemoveInstancesFromInstanceGroup(name, zone string,
 	instanceNames := toInstanceNames(instanceRefs)
 	f.calls = append(f.calls, utils.RemoveInstances)
 	f.instances.Delete(instanceNames...)
+	if instances, ok := f.groupInstances[groupKey(name, zone)]; ok {
+		instances.Delete(instanceNames...)
+	}
 	l, ok := f.zonesToInstances[zone]
 	if !ok {
 		return nil
@@ -188,6 +226,10 @@ func (f *FakeInstanceGroups) ToInstanceReferences(zone string, instanceNames []s
 	return refs
 }
 
+func groupKey(name, zone string) string {
+	return zone + "/" + name
+}
+
 func getInstanceUrl(instanceName string) string {
 	return fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s",
 		"project", "zone", instanceName)
@@ -19,13 +19,14 @@ package instances
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
-
-	"github.com/golang/glog"
+	"sync"
 
 	compute "google.golang.org/api/compute/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/ingress-gce/pkg/storage"
 	"k8s.io/ingress-gce/pkg/utils"
 )
@@ -33,6 +34,10 @@ import (
 const (
 	// State string required by gce library to list all instances.
 	allInstances = "ALL"
+	// maxInstancesPerGroup is the maximum number of instances of an unmanaged
+	// instance group. The nodes of zones with more nodes are spread over
+	// several instance groups.
+	maxInstancesPerGroup = 1000
 )
 
 // Instances implements NodePool.
@@ -43,6 +48,14 @@ type Instances struct {
 	snapshotter storage.Snapshotter
 	zoneLister
 	namer *utils.Namer
+
+	shardsLock sync.Mutex
+	// shards is the number of instance groups the nodes of each zone are
+	// spread over, keyed by zone. Zones which are not listed have 1.
+	shards map[string]int
+	// discovered are the zones whose shards created before a restart of the
+	// controller were listed.
+	discovered sets.String
 }
 
 // NewNodePool creates a new node pool.
@@ -53,19 +66,24 @@ func NewNodePool(cloud InstanceGroups, namer *utils.Namer) NodePool {
 		cloud:       cloud,
 		snapshotter: storage.NewInMemoryPool(),
 		namer:       namer,
+		shards:      map[string]int{},
+		discovered:  sets.NewString(),
 	}
 }
 
 // Init initializes the instance pool. The given zoneLister is used to list
 // all zones that require an instance group, and to lookup which zone a
 // given Kubernetes node is in so we can add it to the right instance group.
+// The zones are not known yet, the shards created before a restart are
+// listed on the first sync of each zone, see discoverShards.
 func (i *Instances) Init(zl zoneLister) {
 	i.zoneLister = zl
 }
 
 // EnsureInstanceGroupsAndPorts creates or gets an instance group if it doesn't exist
-// and adds the given ports to it. Returns a list of one instance group per zone,
-// all of which have the exact same named ports.
+// and adds the given ports to it. Returns a list of the instance groups of all
+// zones, all of which have the exact same named ports. Zones with more than
+// maxInstancesPerGroup nodes have several instance groups, see Sync.
 func (i *Instances) EnsureInstanceGroupsAndPorts(name string, ports []int64) (igs []*compute.InstanceGroup, err error) {
 	zones, err := i.ListZones()
 	if err != nil {
@@ -74,42 +92,134 @@ func (i *Instances) EnsureInstanceGroupsAndPorts(name string, ports []int64) (ig
 
 	defer i.snapshotter.Add(name, struct{}{})
 	for _, zone := range zones {
-		ig, err := i.ensureInstanceGroupAndPorts(name, zone, ports)
-		if err != nil {
+		if err := i.discoverShards(name, zone); err != nil {
 			return nil, err
 		}
-
-		igs = append(igs, ig)
+		for shard := 0; shard < i.numShards(zone); shard++ {
+			ig, err := i.ensureInstanceGroupAndPorts(shardName(name, shard), zone, ports)
+			if err != nil {
+				return nil, err
+			}
+			igs = append(igs, ig)
+		}
 	}
 	return igs, nil
 }
 
+// discoverShards picks up the shards of the given instance group created in
+// the given zone before a restart of the controller, by listing the instance
+// groups of the zone. The zones are those of the nodes, which are only known
+// once the nodes are synced, after Init, so each zone is listed on its first
+// sync only.
+func (i *Instances) discoverShards(name, zone string) error {
+	i.shardsLock.Lock()
+	discovered := i.discovered.Has(zone)
+	i.shardsLock.Unlock()
+	if discovered {
+		return nil
+	}
+	shards, err := i.listShards(name, zone)
+	if err != nil {
+		return err
+	}
+	for shard := range shards {
+		if shard+1 > i.numShards(zone) {
+			logging.V(3).Infof("Found instance group %v/%v, spreading nodes over %v instance groups.", zone, shards[shard], shard+1)
+			i.setNumShards(zone, shard+1)
+		}
+	}
+	i.shardsLock.Lock()
+	i.discovered.Insert(zone)
+	i.shardsLock.Unlock()
+	return nil
+}
+
+// listShards returns the names of the existing shards of the given instance
+// group in the given zone, keyed by shard.
+func (i *Instances) listShards(name, zone string) (map[int]string, error) {
+	list, err := i.cloud.ListInstanceGroups(zone)
+	if err != nil {
+		return nil, err
+	}
+	shards := map[int]string{}
+	for _, ig := range list.Items {
+		if shard, ok := shardOf(name, ig.Name); ok {
+			shards[shard] = ig.Name
+		}
+	}
+	return shards, nil
+}
+
+// numShards returns the number of instance groups of the given zone.
+func (i *Instances) numShards(zone string) int {
+	i.shardsLock.Lock()
+	defer i.shardsLock.Unlock()
+	if n, ok := i.shards[zone]; ok {
+		return n
+	}
+	return 1
+}
+
+// setNumShards sets the number of instance groups of the given zone.
+func (i *Instances) setNumShards(zone string, n int) {
+	i.shardsLock.Lock()
+	defer i.shardsLock.Unlock()
+	i.shards[zone] = n
+}
+
+// shardName returns the name of the given shard of an instance group. The
+// first shard keeps the name of the instance group, so that clusters which
+// never outgrow it keep a single instance group per zone.
+func shardName(name string, shard int) string {
+	if shard == 0 {
+		return name
+	}
+	return fmt.Sprintf("%v-%d", name, shard)
+}
+
+// shardOf returns the shard of the given instance group whose name is igName,
+// false if igName is not a shard of it.
+func shardOf(name, igName string) (int, bool) {
+	if igName == name {
+		return 0, true
+	}
+	if !strings.HasPrefix(igName, name+"-") {
+		return 0, false
+	}
+	shard, err := strconv.Atoi(strings.TrimPrefix(igName, name+"-"))
+	// The name is rebuilt to reject eg: leading zeros.
+	if err != nil || shard < 1 || shardName(name, shard) != igName {
+		return 0, false
+	}
+	return shard, true
+}
+
 func (i *Instances) ensureInstanceGroupAndPorts(name, zone string, ports []int64) (*compute.InstanceGroup, error) {
-	ig, err := i.Get(name, zone)
+	ig, err := i.cloud.GetInstanceGroup(name, zone)
 	if err != nil && !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
-		glog.Errorf("Failed to get instance group %v/%v, err: %v", zone, name, err)
+		logging.Errorf("Failed to get instance group %v/%v, err: %v", zone, name, err)
 		return nil, err
 	}
 
 	if ig == nil {
-		glog.V(3).Infof("Creating instance group %v/%v.", zone, name)
+		logging.ForResource(name).WithOperation("create").V(3).Infof("Creating instance group in zone %v", zone)
 		if err = i.cloud.CreateInstanceGroup(&compute.InstanceGroup{Name: name}, zone); err != nil {
 			// Error may come back with StatusConflict meaning the instance group was created by another controller
 			// possibly the Service Controller for internal load balancers.
 			if utils.IsHTTPErrorCode(err, http.StatusConflict) {
-				glog.Warningf("Failed to create instance group %v/%v due to conflict status, but continuing sync. err: %v", zone, name, err)
+				logging.Warningf("Failed to create instance group %v/%v due to conflict status, but continuing sync. err: %v", zone, name, err)
 			} else {
-				glog.Errorf("Failed to create instance group %v/%v, err: %v", zone, name, err)
+				logging.Errorf("Failed to create instance group %v/%v, err: %v", zone, name, err)
 				return nil, err
 			}
 		}
 		ig, err = i.cloud.GetInstanceGroup(name, zone)
 		if err != nil {
-			glog.Errorf("Failed to get instance group %v/%v after ensuring existence, err: %v", zone, name, err)
+			logging.Errorf("Failed to get instance group %v/%v after ensuring existence, err: %v", zone, name, err)
 			return nil, err
 		}
 	} else {
-		glog.V(5).Infof("Instance group %v/%v already exists.", zone, name)
+		logging.V(5).Infof("Instance group %v/%v already exists.", zone, name)
 	}
 
 	// Build map of existing ports
@@ -122,7 +232,7 @@ func (i *Instances) ensureInstanceGroupAndPorts(name, zone string, ports []int64
 	var newPorts []int64
 	for _, p := range ports {
 		if existingPorts[p] {
-			glog.V(5).Infof("Instance group %v/%v already has named port %v", zone, ig.Name, p)
+			logging.V(5).Infof("Instance group %v/%v already has named port %v", zone, ig.Name, p)
 			continue
 		}
 		newPorts = append(newPorts, p)
@@ -135,7 +245,7 @@ func (i *Instances) ensureInstanceGroupAndPorts(name, zone string, ports []int64
 	}
 
 	if len(newNamedPorts) > 0 {
-		glog.V(3).Infof("Instance group %v/%v does not have ports %+v, adding them now.", zone, name, newPorts)
+		logging.V(3).Infof("Instance group %v/%v does not have ports %+v, adding them now.", zone, name, newPorts)
 		if err := i.cloud.SetNamedPortsOfInstanceGroup(ig.Name, zone, append(ig.NamedPorts, newNamedPorts...)); err != nil {
 			return nil, err
 		}
@@ -144,6 +254,54 @@ func (i *Instances) ensureInstanceGroupAndPorts(name, zone string, ports []int64
 	return ig, nil
 }
 
+// GCNamedPorts removes the named ports of the given IG, in all zones, which
+// are not in the given ports. Only the named ports named by the controller are
+// removed, the named ports of other controllers are kept.
+func (i *Instances) GCNamedPorts(name string, ports []int64) error {
+	zones, err := i.ListZones()
+	if err != nil {
+		return err
+	}
+	keep := map[int64]bool{}
+	for _, p := range ports {
+		keep[p] = true
+	}
+
+	errs := []error{}
+	for _, zone := range zones {
+		for shard := 0; shard < i.numShards(zone); shard++ {
+			igName := shardName(name, shard)
+			ig, err := i.cloud.GetInstanceGroup(igName, zone)
+			if err != nil {
+				if !utils.IsHTTPErrorCode(err, http.StatusNotFound) {
+					errs = append(errs, err)
+				}
+				continue
+			}
+			var namedPorts []*compute.NamedPort
+			var removePorts []int64
+			for _, np := range ig.NamedPorts {
+				if np.Name == i.namer.NamedPort(np.Port) && !keep[np.Port] {
+					removePorts = append(removePorts, np.Port)
+					continue
+				}
+				namedPorts = append(namedPorts, np)
+			}
+			if len(removePorts) == 0 {
+				continue
+			}
+			logging.V(2).Infof("Removing unused named ports %v from instance group %v/%v", removePorts, zone, igName)
+			if err := i.cloud.SetNamedPortsOfInstanceGroup(igName, zone, namedPorts); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v", errs)
+}
+
 // DeleteInstanceGroup deletes the given IG by name, from all zones.
 func (i *Instances) DeleteInstanceGroup(name string) error {
 	defer i.snapshotter.Delete(name)
@@ -154,16 +312,24 @@ func (i *Instances) DeleteInstanceGroup(name string) error {
 		return err
 	}
 	for _, zone := range zones {
-		if err := i.cloud.DeleteInstanceGroup(name, zone); err != nil {
-			if utils.IsNotFoundError(err) {
-				glog.V(3).Infof("Instance group %v in zone %v did not exist", name, zone)
+		// The shards are listed, to also delete the shards created before a
+		// restart, whichever shards are missing.
+		shards, err := i.listShards(name, zone)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, igName := range shards {
+			err := i.cloud.DeleteInstanceGroup(igName, zone)
+			if err == nil {
+				logging.ForResource(igName).WithOperation("delete").V(3).Infof("Deleted instance group in zone %v", zone)
+			} else if utils.IsNotFoundError(err) {
+				logging.V(3).Infof("Instance group %v in zone %v did not exist", igName, zone)
 			} else if utils.IsInUsedByError(err) {
-				glog.V(3).Infof("Could not delete instance group %v in zone %v because it's still in use. Ignoring: %v", name, zone, err)
+				logging.V(3).Infof("Could not delete instance group %v in zone %v because it's still in use. Ignoring: %v", igName, zone, err)
 			} else {
 				errs = append(errs, err)
 			}
-		} else {
-			glog.V(3).Infof("Deleted instance group %v in zone %v", name, zone)
 		}
 	}
 	if len(errs) == 0 {
@@ -172,26 +338,19 @@ func (i *Instances) DeleteInstanceGroup(name string) error {
 	return fmt.Errorf("%v", errs)
 }
 
-// list lists all instances in all zones.
-func (i *Instances) list(name string) (sets.String, error) {
+// list lists all instances of the given instance group in the given zone.
+func (i *Instances) list(name, zone string) (sets.String, error) {
 	nodeNames := sets.NewString()
-	zones, err := i.ListZones()
+	instances, err := i.cloud.ListInstancesInInstanceGroup(
+		name, zone, allInstances)
 	if err != nil {
 		return nodeNames, err
 	}
-
-	for _, zone := range zones {
-		instances, err := i.cloud.ListInstancesInInstanceGroup(
-			name, zone, allInstances)
-		if err != nil {
-			return nodeNames, err
-		}
-		for _, ins := range instances.Items {
-			// TODO: If round trips weren't so slow one would be inclided
-			// to GetInstance using this url and get the name.
-			parts := strings.Split(ins.Instance, "/")
-			nodeNames.Insert(parts[len(parts)-1])
-		}
+	for _, ins := range instances.Items {
+		// TODO: If round trips weren't so slow one would be inclided
+		// to GetInstance using this url and get the name.
+		parts := strings.Split(ins.Instance, "/")
+		nodeNames.Insert(parts[len(parts)-1])
 	}
 	return nodeNames, nil
 }
@@ -213,7 +372,7 @@ func (i *Instances) splitNodesByZone(names []string) map[string][]string {
 	for _, name := range names {
 		zone, err := i.GetZoneForNode(name)
 		if err != nil {
-			glog.Errorf("Failed to get zones for %v: %v, skipping", name, err)
+			logging.Errorf("Failed to get zones for %v: %v, skipping", name, err)
 			continue
 		}
 		if _, ok := nodesByZone[zone]; !ok {
@@ -228,7 +387,7 @@ func (i *Instances) splitNodesByZone(names []string) map[string][]string {
 func (i *Instances) Add(groupName string, names []string) error {
 	errs := []error{}
 	for zone, nodeNames := range i.splitNodesByZone(names) {
-		glog.V(1).Infof("Adding nodes %v to %v in zone %v", nodeNames, groupName, zone)
+		logging.V(1).Infof("Adding nodes %v to %v in zone %v", nodeNames, groupName, zone)
 		if err := i.cloud.AddInstancesToInstanceGroup(groupName, zone, i.cloud.ToInstanceReferences(zone, nodeNames)); err != nil {
 			errs = append(errs, err)
 		}
@@ -243,7 +402,7 @@ func (i *Instances) Add(groupName string, names []string) error {
 func (i *Instances) Remove(groupName string, names []string) error {
 	errs := []error{}
 	for zone, nodeNames := range i.splitNodesByZone(names) {
-		glog.V(1).Infof("Removing nodes %v from %v in zone %v", nodeNames, groupName, zone)
+		logging.V(1).Infof("Removing nodes %v from %v in zone %v", nodeNames, groupName, zone)
 		if err := i.cloud.RemoveInstancesFromInstanceGroup(groupName, zone, i.cloud.ToInstanceReferences(zone, nodeNames)); err != nil {
 			errs = append(errs, err)
 		}
@@ -254,51 +413,123 @@ func (i *Instances) Remove(groupName string, names []string) error {
 	return fmt.Errorf("%v", errs)
 }
 
-// Sync syncs kubernetes instances with the instances in the instance group.
+// Sync syncs kubernetes instances with the instances in the instance groups.
+// The nodes of a zone are spread over several instance groups if they don't
+// fit in one.
 func (i *Instances) Sync(nodes []string) (err error) {
-	glog.V(4).Infof("Syncing nodes %v", nodes)
+	logging.V(4).Infof("Syncing nodes %v", nodes)
 
 	defer func() {
 		// The node pool is only responsible for syncing nodes to instance
-		// groups. It never creates/deletes, so if an instance groups is
-		// not found there's nothing it can do about it anyway. Most cases
-		// this will happen because the backend pool has deleted the instance
+		// groups. It only creates the instance groups of new shards, so if
+		// an instance groups is not found there's nothing it can do about it
+		// anyway. Most cases this will happen because the backend pool has deleted the instance
 		// group, however if it happens because a user deletes the IG by mistake
 		// we should just wait till the backend pool fixes it.
 		if utils.IsHTTPErrorCode(err, http.StatusNotFound) {
-			glog.Infof("Node pool encountered a 404, ignoring: %v", err)
+			logging.Infof("Node pool encountered a 404, ignoring: %v", err)
 			err = nil
 		}
 	}()
 
+	zones, err := i.ListZones()
+	if err != nil {
+		return err
+	}
+	nodesByZone := i.splitNodesByZone(nodes)
+	allZones := sets.NewString(zones...)
+	for zone := range nodesByZone {
+		allZones.Insert(zone)
+	}
+
 	pool := i.snapshotter.Snapshot()
 	for igName := range pool {
-		gceNodes := sets.NewString()
-		gceNodes, err = i.list(igName)
-		if err != nil {
-			return err
+		for _, zone := range allZones.List() {
+			if err = i.syncZone(igName, zone, nodesByZone[zone]); err != nil {
+				return err
+			}
 		}
-		kubeNodes := sets.NewString(nodes...)
+	}
+	return nil
+}
 
-		// A node deleted via kubernetes could still exist as a gce vm. We don't
-		// want to route requests to it. Similarly, a node added to kubernetes
-		// needs to get added to the instance group so we do route requests to it.
+// syncZone syncs the given nodes of a zone with the instances of the
+// instance groups of the zone. Nodes stay in the instance group they're in,
+// new nodes are added to the first instance groups with room. Instance groups
+// are created if the nodes outgrow them, they're added to the backend services
+// on the next sync.
+func (i *Instances) syncZone(name, zone string, nodes []string) error {
+	kubeNodes := sets.NewString(nodes...)
+	// Instance groups are kept when nodes go away, since the backend services
+	// still use them.
+	if needed := (kubeNodes.Len() + maxInstancesPerGroup - 1) / maxInstancesPerGroup; needed > i.numShards(zone) {
+		if err := i.addShards(name, zone, needed); err != nil {
+			return err
+		}
+	}
 
-		removeNodes := gceNodes.Difference(kubeNodes).List()
-		addNodes := kubeNodes.Difference(gceNodes).List()
+	// A node deleted via kubernetes could still exist as a gce vm. We don't
+	// want to route requests to it. Similarly, a node added to kubernetes
+	// needs to get added to the instance group so we do route requests to it.
+	shards := make([]sets.String, i.numShards(zone))
+	gceNodes := sets.NewString()
+	for shard := range shards {
+		igName := shardName(name, shard)
+		members, err := i.list(igName, zone)
+		if err != nil {
+			return err
+		}
+		removeNodes := members.Difference(kubeNodes).List()
 		if len(removeNodes) != 0 {
-			glog.V(4).Infof("Removing nodes from IG: %v", removeNodes)
-			if err = i.Remove(igName, removeNodes); err != nil {
+			logging.V(1).Infof("Removing nodes %v from %v in zone %v", removeNodes, igName, zone)
+			if err := i.cloud.RemoveInstancesFromInstanceGroup(igName, zone, i.cloud.ToInstanceReferences(zone, removeNodes)); err != nil {
 				return err
 			}
+			members.Delete(removeNodes...)
 		}
+		shards[shard] = members
+		gceNodes = gceNodes.Union(members)
+	}
 
-		if len(addNodes) != 0 {
-			glog.V(4).Infof("Adding nodes to IG: %v", addNodes)
-			if err = i.Add(igName, addNodes); err != nil {
-				return err
-			}
+	addNodes := kubeNodes.Difference(gceNodes).List()
+	for shard, members := range shards {
+		if len(addNodes) == 0 {
+			break
+		}
+		room := maxInstancesPerGroup - members.Len()
+		if room <= 0 {
+			continue
+		}
+		if room > len(addNodes) {
+			room = len(addNodes)
+		}
+		igName := shardName(name, shard)
+		logging.V(1).Infof("Adding nodes %v to %v in zone %v", addNodes[:room], igName, zone)
+		if err := i.cloud.AddInstancesToInstanceGroup(igName, zone, i.cloud.ToInstanceReferences(zone, addNodes[:room])); err != nil {
+			return err
+		}
+		addNodes = addNodes[room:]
+	}
+	return nil
+}
+
+// addShards creates the instance groups of the given zone up to the given
+// number of shards, with the named ports of the first instance group.
+func (i *Instances) addShards(name, zone string, n int) error {
+	ig, err := i.cloud.GetInstanceGroup(name, zone)
+	if err != nil {
+		return err
+	}
+	var ports []int64
+	for _, np := range ig.NamedPorts {
+		ports = append(ports, np.Port)
+	}
+	logging.Infof("Spreading the nodes of zone %v over %v instance groups", zone, n)
+	for shard := i.numShards(zone); shard < n; shard++ {
+		if _, err := i.ensureInstanceGroupAndPorts(shardName(name, shard), zone, ports); err != nil {
+			return err
 		}
+		i.setNumShards(zone, shard+1)
 	}
 	return nil
 }
@@ -17,8 +17,10 @@ limitations under the License.
 package instances
 
 import (
+	"fmt"
 	"testing"
 
+	compute "google.golang.org/api/compute/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/ingress-gce/pkg/utils"
 )
@@ -81,6 +83,99 @@ func TestNodePoolSync(t *testing.T) {
 	}
 }
 
+func TestNodePoolSyncShards(t *testing.T) {
+	f := NewFakeInstanceGroups(sets.NewString())
+	pool := newNodePool(f, defaultZone)
+	if _, err := pool.EnsureInstanceGroupsAndPorts("test", []int64{80}); err != nil {
+		t.Fatalf("Failed to ensure instance groups: %v", err)
+	}
+
+	var nodes []string
+	for i := 0; i < 2500; i++ {
+		nodes = append(nodes, fmt.Sprintf("n%d", i))
+	}
+	if err := pool.Sync(nodes); err != nil {
+		t.Fatalf("Failed to sync nodes: %v", err)
+	}
+	for name, size := range map[string]int{"test": 1000, "test-1": 1000, "test-2": 500} {
+		if got := f.GetInstancesOfGroup(name, defaultZone).Len(); got != size {
+			t.Errorf("Expect %v instances in instance group %v, got %v", size, name, got)
+		}
+	}
+	if f.instances.Len() != len(nodes) {
+		t.Errorf("Expect %v instances, got %v", len(nodes), f.instances.Len())
+	}
+
+	// The shards are found again after a restart.
+	pool = newNodePool(f, defaultZone)
+	igs, err := pool.EnsureInstanceGroupsAndPorts("test", []int64{80})
+	if err != nil {
+		t.Fatalf("Failed to ensure instance groups: %v", err)
+	}
+	if len(igs) != 3 {
+		t.Fatalf("Expect 3 instance groups, got %v", len(igs))
+	}
+	for _, ig := range igs {
+		if len(ig.NamedPorts) != 1 || ig.NamedPorts[0].Port != 80 {
+			t.Errorf("Expect named port 80 on instance group %v, got %+v", ig.Name, ig.NamedPorts)
+		}
+	}
+	// Only the known shards are read on the next syncs.
+	f.calls = nil
+	if _, err := pool.EnsureInstanceGroupsAndPorts("test", []int64{80}); err != nil {
+		t.Fatalf("Failed to ensure instance groups: %v", err)
+	}
+	gets := 0
+	for _, call := range f.calls {
+		if call == utils.Get {
+			gets++
+		}
+	}
+	if gets != 3 {
+		t.Errorf("Expect 3 instance groups to be read, got %v", gets)
+	}
+
+	// Removed nodes leave room for new nodes in the same instance group.
+	nodes = append(nodes[1:], "n2500")
+	if err := pool.Sync(nodes); err != nil {
+		t.Fatalf("Failed to sync nodes: %v", err)
+	}
+	if instances := f.GetInstancesOfGroup("test", defaultZone); instances.Has("n0") || !instances.Has("n2500") {
+		t.Errorf("Expect n2500 to replace n0 in instance group test, got %v", instances.List())
+	}
+
+	// A missing shard doesn't stop the deletion of the next ones.
+	if err := f.DeleteInstanceGroup("test-1", defaultZone); err != nil {
+		t.Fatalf("Failed to delete instance group test-1: %v", err)
+	}
+	if err := pool.DeleteInstanceGroup("test"); err != nil {
+		t.Fatalf("Failed to delete instance groups: %v", err)
+	}
+	if len(f.instanceGroups) != 0 {
+		t.Errorf("Expect all instance groups to be deleted, got %v", f.instanceGroups)
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	for _, tc := range []struct {
+		igName string
+		shard  int
+		ok     bool
+	}{
+		{igName: "test", shard: 0, ok: true},
+		{igName: "test-2", shard: 2, ok: true},
+		{igName: "test-0"},
+		{igName: "test-02"},
+		{igName: "test-a"},
+		{igName: "test2"},
+		{igName: "other-1"},
+	} {
+		if shard, ok := shardOf("test", tc.igName); shard != tc.shard || ok != tc.ok {
+			t.Errorf("shardOf(%q) = %v, %v, want %v, %v", tc.igName, shard, ok, tc.shard, tc.ok)
+		}
+	}
+}
+
 func TestSetNamedPorts(t *testing.T) {
 	f := NewFakeInstanceGroups(sets.NewString(
 		[]string{"ig"}...))
@@ -131,3 +226,33 @@ func TestSetNamedPorts(t *testing.T) {
 		}
 	}
 }
+
+func TestGCNamedPorts(t *testing.T) {
+	f := NewFakeInstanceGroups(sets.NewString())
+	pool := newNodePool(f, defaultZone)
+	igs, err := pool.EnsureInstanceGroupsAndPorts("ig", []int64{80, 81, 82})
+	if err != nil {
+		t.Fatalf("Failed to ensure instance groups: %v", err)
+	}
+	// A named port of another controller.
+	namedPorts := append(igs[0].NamedPorts, &compute.NamedPort{Name: "http", Port: 81})
+	if err := f.SetNamedPortsOfInstanceGroup("ig", defaultZone, namedPorts); err != nil {
+		t.Fatalf("Failed to set named ports: %v", err)
+	}
+
+	if err := pool.GCNamedPorts("ig", []int64{80}); err != nil {
+		t.Fatalf("Failed to GC named ports: %v", err)
+	}
+	ig, err := f.GetInstanceGroup("ig", defaultZone)
+	if err != nil {
+		t.Fatalf("Failed to get instance group: %v", err)
+	}
+	var got []string
+	for _, np := range ig.NamedPorts {
+		got = append(got, fmt.Sprintf("%v:%v", np.Name, np.Port))
+	}
+	expected := []string{"port80:80", "http:81"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expect named ports %v, got %v", expected, got)
+	}
+}
@@ -31,9 +31,10 @@ type zoneLister interface {
 type NodePool interface {
 	Init(zl zoneLister)
 
-	// The following 2 methods operate on instance groups.
+	// The following 3 methods operate on instance groups.
 	EnsureInstanceGroupsAndPorts(name string, ports []int64) ([]*compute.InstanceGroup, error)
 	DeleteInstanceGroup(name string) error
+	GCNamedPorts(name string, ports []int64) error
 
 	// TODO: Refactor for modularity
 	Add(groupName string, nodeNames []string) error
@@ -47,6 +48,7 @@ type InstanceGroups interface {
 	GetInstanceGroup(name, zone string) (*compute.InstanceGroup, error)
 	CreateInstanceGroup(ig *compute.InstanceGroup, zone string) error
 	DeleteInstanceGroup(name, zone string) error
+	ListInstanceGroups(zone string) (*compute.InstanceGroupList, error)
 
 	// TODO: Refactor for modulatiry.
 	ListInstancesInInstanceGroup(name, zone string, state string) (*compute.InstanceGroupsListInstances, error)
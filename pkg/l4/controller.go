@@ -0,0 +1,416 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	unversionedcore "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/context"
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// For each service, only retries 15 times to process it.
+	// This is a convention in kube-controller-manager.
+	maxRetries = 15
+)
+
+// Controller syncs the internal load balancers of the Services of type
+// LoadBalancer with the internal annotation, and the external network load
+// balancers of the others, and publishes their IP in the status of the
+// Services.
+type Controller struct {
+	client   kubernetes.Interface
+	pool     *Pool
+	recorder record.EventRecorder
+	// ilb and netLB enable the internal and the external load balancers.
+	ilb   bool
+	netLB bool
+	// ilbSubsetting backs the internal load balancers with NEGs of subsets
+	// of the nodes, rather than with the instance groups.
+	ilbSubsetting bool
+
+	serviceSynced  cache.InformerSynced
+	nodeSynced     cache.InformerSynced
+	endpointSynced cache.InformerSynced
+	serviceLister  cache.Indexer
+	nodeLister     cache.Indexer
+	endpointLister cache.Indexer
+
+	// serviceQueue takes service key as work item. Service key with format "namespace/name".
+	serviceQueue workqueue.RateLimitingInterface
+}
+
+// NewController returns a controller of the load balancers of the given pool,
+// internal if ilb is set, external if netLB is set. With ilbSubsetting, the
+// internal load balancers are backed by NEGs of subsets of the nodes, which
+// requires the Endpoints informer of the context.
+func NewController(kubeClient kubernetes.Interface, ctx *context.ControllerContext, pool *Pool, ilb, netLB, ilbSubsetting bool) *Controller {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(logging.Infof)
+	eventBroadcaster.StartRecordingToSink(&unversionedcore.EventSinkImpl{
+		Interface: kubeClient.Core().Events(""),
+	})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme,
+		api_v1.EventSource{Component: "l4-controller"})
+
+	c := &Controller{
+		client:        kubeClient,
+		pool:          pool,
+		recorder:      recorder,
+		ilb:           ilb,
+		netLB:         netLB,
+		ilbSubsetting: ilbSubsetting,
+		serviceSynced: ctx.ServiceInformer.HasSynced,
+		nodeSynced:    ctx.NodeInformer.HasSynced,
+		serviceLister: ctx.ServiceInformer.GetIndexer(),
+		nodeLister:    ctx.NodeInformer.GetIndexer(),
+		serviceQueue:  workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	if ilbSubsetting {
+		c.endpointSynced = ctx.EndpointInformer.HasSynced
+		c.endpointLister = ctx.EndpointInformer.GetIndexer()
+		// The subsets of the Services with the Local external traffic
+		// policy follow the nodes of their endpoints.
+		ctx.EndpointInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.enqueueEndpointsService,
+			DeleteFunc: c.enqueueEndpointsService,
+			UpdateFunc: func(old, cur interface{}) {
+				if !reflect.DeepEqual(old.(*api_v1.Endpoints).Subsets, cur.(*api_v1.Endpoints).Subsets) {
+					c.enqueueEndpointsService(cur)
+				}
+			},
+		})
+	}
+
+	ctx.ServiceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueService,
+		DeleteFunc: c.enqueueService,
+		UpdateFunc: func(old, cur interface{}) {
+			c.enqueueService(cur)
+		},
+	})
+	// The load balancers are synced when nodes come and go, so that the
+	// instance groups or NEGs of new zones are added to their backend
+	// services, and their firewall rules target the tags of the new nodes.
+	ctx.NodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.enqueueAllServices() },
+		DeleteFunc: func(obj interface{}) { c.enqueueAllServices() },
+	})
+	return c
+}
+
+// Run syncs the load balancers until stopCh is closed.
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	wait.PollUntil(5*time.Second, func() (bool, error) {
+		logging.V(2).Infof("Waiting for initial sync")
+		return c.synced(), nil
+	}, stopCh)
+
+	logging.V(2).Infof("Starting L4 controller")
+	defer func() {
+		logging.V(2).Infof("Shutting down L4 controller")
+		c.serviceQueue.ShutDown()
+	}()
+
+	go wait.Until(c.serviceWorker, time.Second, stopCh)
+
+	<-stopCh
+}
+
+func (c *Controller) synced() bool {
+	return c.serviceSynced() && c.nodeSynced() && (c.endpointSynced == nil || c.endpointSynced())
+}
+
+func (c *Controller) serviceWorker() {
+	for {
+		func() {
+			key, quit := c.serviceQueue.Get()
+			if quit {
+				return
+			}
+			defer c.serviceQueue.Done(key)
+			err := c.processService(key.(string))
+			c.handleErr(err, key)
+		}()
+	}
+}
+
+// loadBalancer is a kind of load balancer of the Services.
+type loadBalancer struct {
+	// kind is the kind of the load balancer in the events.
+	kind string
+	// finalizer is on the Services whose load balancer may exist.
+	finalizer string
+	ensure    func(svc *api_v1.Service, nodes []*api_v1.Node) (*api_v1.LoadBalancerStatus, error)
+	delete    func(svc *api_v1.Service, nodes []*api_v1.Node) error
+}
+
+// ilbLoadBalancer returns the internal load balancers of the pool.
+func (c *Controller) ilbLoadBalancer() *loadBalancer {
+	return &loadBalancer{
+		kind:      "internal",
+		finalizer: annotations.ILBFinalizerKey,
+		ensure: func(svc *api_v1.Service, nodes []*api_v1.Node) (*api_v1.LoadBalancerStatus, error) {
+			if c.ilbSubsetting {
+				return c.pool.EnsureILBSubsetting(svc, nodes, c.endpointNodes(svc))
+			}
+			var nodeNames []string
+			for _, node := range nodes {
+				nodeNames = append(nodeNames, node.Name)
+			}
+			return c.pool.Ensure(svc, nodeNames)
+		},
+		delete: func(svc *api_v1.Service, nodes []*api_v1.Node) error { return c.pool.Delete(svc) },
+	}
+}
+
+// netLBLoadBalancer returns the external network load balancers of the
+// pool.
+func (c *Controller) netLBLoadBalancer() *loadBalancer {
+	return &loadBalancer{
+		kind:      "external network",
+		finalizer: annotations.NetLBFinalizerKey,
+		ensure:    c.pool.EnsureNetLB,
+		delete:    c.pool.DeleteNetLB,
+	}
+}
+
+// endpointNodes returns the names of the nodes of the ready endpoints of the
+// given Service.
+func (c *Controller) endpointNodes(svc *api_v1.Service) sets.String {
+	nodes := sets.NewString()
+	obj, exists, err := c.endpointLister.GetByKey(svc.Namespace + "/" + svc.Name)
+	if err != nil || !exists {
+		return nodes
+	}
+	for _, subset := range obj.(*api_v1.Endpoints).Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.NodeName != nil {
+				nodes.Insert(*addr.NodeName)
+			}
+		}
+	}
+	return nodes
+}
+
+// wantsILB returns true if the given Service asks for an internal load
+// balancer.
+func wantsILB(svc *api_v1.Service) bool {
+	return svc.Spec.Type == api_v1.ServiceTypeLoadBalancer && svc.DeletionTimestamp == nil && annotations.SvcAnnotations(svc.Annotations).ILB()
+}
+
+// wantsNetLB returns true if the given Service asks for an external load
+// balancer.
+func wantsNetLB(svc *api_v1.Service) bool {
+	return svc.Spec.Type == api_v1.ServiceTypeLoadBalancer && svc.DeletionTimestamp == nil && !annotations.SvcAnnotations(svc.Annotations).ILB()
+}
+
+// hasFinalizer returns true if the given Service has the given finalizer.
+func hasFinalizer(svc *api_v1.Service, finalizer string) bool {
+	for _, f := range svc.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// wanted returns the load balancer the given Service asks for, nil if none
+// or if its kind is disabled.
+func (c *Controller) wanted(svc *api_v1.Service) *loadBalancer {
+	switch {
+	case c.ilb && wantsILB(svc):
+		return c.ilbLoadBalancer()
+	case c.netLB && wantsNetLB(svc):
+		return c.netLBLoadBalancer()
+	}
+	return nil
+}
+
+// processService ensures the load balancer of the given Service, after
+// deleting the load balancer of the other kind if it switched between
+// internal and external, or deletes them if the Service no longer asks for
+// one.
+func (c *Controller) processService(key string) error {
+	obj, exists, err := c.serviceLister.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	svc := obj.(*api_v1.Service)
+	nodes := c.listNodes()
+	wanted := c.wanted(svc)
+	for _, lb := range []*loadBalancer{c.ilbLoadBalancer(), c.netLBLoadBalancer()} {
+		if hasFinalizer(svc, lb.finalizer) && (wanted == nil || wanted.finalizer != lb.finalizer) {
+			if svc, err = c.delete(svc, lb, nodes); err != nil {
+				return err
+			}
+		}
+	}
+	if wanted != nil {
+		return c.ensure(svc, wanted, nodes)
+	}
+	return nil
+}
+
+// listNodes returns the nodes of the cluster.
+func (c *Controller) listNodes() []*api_v1.Node {
+	var nodes []*api_v1.Node
+	for _, obj := range c.nodeLister.List() {
+		nodes = append(nodes, obj.(*api_v1.Node))
+	}
+	return nodes
+}
+
+// ensure ensures the given load balancer of the given Service, and publishes
+// its IP. The finalizer is placed first, so that the load balancer is
+// deleted with the Service.
+func (c *Controller) ensure(svc *api_v1.Service, lb *loadBalancer, nodes []*api_v1.Node) error {
+	if !hasFinalizer(svc, lb.finalizer) {
+		updated := svc.DeepCopy()
+		updated.Finalizers = append(updated.Finalizers, lb.finalizer)
+		var err error
+		if svc, err = c.client.CoreV1().Services(svc.Namespace).Update(updated); err != nil {
+			return err
+		}
+	}
+	status, err := lb.ensure(svc, nodes)
+	if err != nil {
+		c.recorder.Eventf(svc, api_v1.EventTypeWarning, "SyncLoadBalancerFailed", "Error syncing %v load balancer: %v", lb.kind, err)
+		return err
+	}
+	if reflect.DeepEqual(svc.Status.LoadBalancer, *status) {
+		return nil
+	}
+	updated := svc.DeepCopy()
+	updated.Status.LoadBalancer = *status
+	if _, err := c.client.CoreV1().Services(svc.Namespace).UpdateStatus(updated); err != nil {
+		return err
+	}
+	c.recorder.Eventf(svc, api_v1.EventTypeNormal, "EnsuredLoadBalancer", "%v load balancer %v", strings.Title(lb.kind), status.Ingress[0].IP)
+	return nil
+}
+
+// delete deletes the given load balancer of the given Service, clears its
+// status, and removes the finalizer. Returns the updated Service.
+func (c *Controller) delete(svc *api_v1.Service, lb *loadBalancer, nodes []*api_v1.Node) (*api_v1.Service, error) {
+	if err := lb.delete(svc, nodes); err != nil {
+		c.recorder.Eventf(svc, api_v1.EventTypeWarning, "DeleteLoadBalancerFailed", "Error deleting %v load balancer: %v", lb.kind, err)
+		return nil, err
+	}
+	updated := svc.DeepCopy()
+	if len(updated.Status.LoadBalancer.Ingress) > 0 {
+		updated.Status.LoadBalancer = api_v1.LoadBalancerStatus{}
+		var err error
+		if updated, err = c.client.CoreV1().Services(svc.Namespace).UpdateStatus(updated); err != nil {
+			return nil, err
+		}
+	}
+	var finalizers []string
+	for _, f := range updated.Finalizers {
+		if f != lb.finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	updated.Finalizers = finalizers
+	updated, err := c.client.CoreV1().Services(svc.Namespace).Update(updated)
+	if err != nil {
+		return nil, err
+	}
+	c.recorder.Eventf(svc, api_v1.EventTypeNormal, "DeletedLoadBalancer", "Deleted %v load balancer", lb.kind)
+	return updated, nil
+}
+
+func (c *Controller) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.serviceQueue.Forget(key)
+		return
+	}
+
+	logging.Errorf("Error processing service %q: %v", key, err)
+	if c.serviceQueue.NumRequeues(key) < maxRetries {
+		c.serviceQueue.AddRateLimited(key)
+		return
+	}
+
+	defer c.serviceQueue.Forget(key)
+	service, exists, err := c.serviceLister.GetByKey(key.(string))
+	if err != nil {
+		logging.Warningf("Failed to retrieve service %q from store: %v", key.(string), err)
+		return
+	}
+	if exists {
+		c.recorder.Eventf(service.(*api_v1.Service), api_v1.EventTypeWarning, "ProcessServiceFailed", "Service %q dropped from queue (requeued %v times)", key, c.serviceQueue.NumRequeues(key))
+	}
+}
+
+// enqueueService enqueues the given Service if it has, or asks for, a load
+// balancer.
+func (c *Controller) enqueueService(obj interface{}) {
+	if svc, ok := obj.(*api_v1.Service); ok && c.wanted(svc) == nil &&
+		!hasFinalizer(svc, annotations.ILBFinalizerKey) && !hasFinalizer(svc, annotations.NetLBFinalizerKey) {
+		return
+	}
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		logging.Errorf("Failed to generate service key: %v", err)
+		return
+	}
+	c.serviceQueue.Add(key)
+}
+
+// enqueueEndpointsService enqueues the Service of the given Endpoints if its
+// internal load balancer is backed by the nodes of its endpoints.
+func (c *Controller) enqueueEndpointsService(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ep, ok := obj.(*api_v1.Endpoints)
+	if !ok {
+		return
+	}
+	svc, exists, err := c.serviceLister.GetByKey(ep.Namespace + "/" + ep.Name)
+	if err != nil || !exists {
+		return
+	}
+	if svc.(*api_v1.Service).Spec.ExternalTrafficPolicy == api_v1.ServiceExternalTrafficPolicyTypeLocal && c.ilb && wantsILB(svc.(*api_v1.Service)) {
+		c.enqueueService(svc)
+	}
+}
+
+// enqueueAllServices enqueues all the Services with load balancers.
+func (c *Controller) enqueueAllServices() {
+	for _, obj := range c.serviceLister.List() {
+		c.enqueueService(obj)
+	}
+}
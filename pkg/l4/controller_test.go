@@ -0,0 +1,160 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"testing"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/context"
+)
+
+func TestControllerEnsureDelete(t *testing.T) {
+	pool, cloud, _, igs := newTestPool()
+	svc := newILBService(api_v1.ServicePort{Port: 80})
+	kubeClient := fake.NewSimpleClientset(svc)
+	ctx := context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, false)
+	c := NewController(kubeClient, ctx, pool, true, false, false)
+	c.serviceLister.Add(svc)
+	c.nodeLister.Add(&api_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "node-1"}})
+
+	if err := c.processService("default/svc"); err != nil {
+		t.Fatalf("processService() = %v", err)
+	}
+	got, err := kubeClient.CoreV1().Services("default").Get("svc", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if !hasFinalizer(got, annotations.ILBFinalizerKey) {
+		t.Errorf("finalizers = %v, want the finalizer of the load balancer", got.Finalizers)
+	}
+	if ingress := got.Status.LoadBalancer.Ingress; len(ingress) != 1 || ingress[0].IP != "10.0.0.1" {
+		t.Errorf("status = %+v, want IP 10.0.0.1", got.Status.LoadBalancer)
+	}
+
+	// The Service no longer asks for an internal load balancer.
+	got.Spec.Type = api_v1.ServiceTypeClusterIP
+	c.serviceLister.Update(got)
+	if err := c.processService("default/svc"); err != nil {
+		t.Fatalf("processService() = %v", err)
+	}
+	got, err = kubeClient.CoreV1().Services("default").Get("svc", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if hasFinalizer(got, annotations.ILBFinalizerKey) || len(got.Status.LoadBalancer.Ingress) != 0 {
+		t.Errorf("Service = %+v, want no finalizer nor status", got)
+	}
+	if len(cloud.ForwardingRules) != 0 || igs.Users.Len() != 0 {
+		t.Errorf("forwarding rules %v and instance groups users %v left", cloud.ForwardingRules, igs.Users.List())
+	}
+}
+
+func TestEnqueueService(t *testing.T) {
+	pool, _, _, _ := newTestPool()
+	kubeClient := fake.NewSimpleClientset()
+	c := NewController(kubeClient, context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, false), pool, true, false, false)
+	external := newILBService(api_v1.ServicePort{Port: 80})
+	external.Annotations = nil
+	c.enqueueService(external)
+	if c.serviceQueue.Len() != 0 {
+		t.Errorf("queue length = %v, want the external Service ignored", c.serviceQueue.Len())
+	}
+	c.enqueueService(newILBService(api_v1.ServicePort{Port: 80}))
+	if c.serviceQueue.Len() != 1 {
+		t.Errorf("queue length = %v, want the internal Service enqueued", c.serviceQueue.Len())
+	}
+}
+
+func TestControllerSwitchToNetLB(t *testing.T) {
+	pool, cloud, _, igs := newTestPool()
+	svc := newILBService(api_v1.ServicePort{Port: 80})
+	kubeClient := fake.NewSimpleClientset(svc)
+	ctx := context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, false)
+	c := NewController(kubeClient, ctx, pool, true, true, false)
+	c.serviceLister.Add(svc)
+	c.nodeLister.Add(newTestNode("node-1", "us-central1-a", "10.128.0.2"))
+	if err := c.processService("default/svc"); err != nil {
+		t.Fatalf("processService() = %v", err)
+	}
+
+	// The Service becomes external.
+	got, err := kubeClient.CoreV1().Services("default").Get("svc", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	got.Annotations = nil
+	c.serviceLister.Update(got)
+	if err := c.processService("default/svc"); err != nil {
+		t.Fatalf("processService() = %v", err)
+	}
+	got, err = kubeClient.CoreV1().Services("default").Get("svc", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if hasFinalizer(got, annotations.ILBFinalizerKey) || !hasFinalizer(got, annotations.NetLBFinalizerKey) {
+		t.Errorf("finalizers = %v, want the finalizer of the external load balancer only", got.Finalizers)
+	}
+	if len(cloud.BackendServices) != 0 || igs.Users.Len() != 0 {
+		t.Errorf("internal backend services %v and instance groups users %v left", cloud.BackendServices, igs.Users.List())
+	}
+	name := pool.namer.L4("default", "svc")
+	if rule := cloud.ForwardingRules[name]; rule == nil || rule.LoadBalancingScheme != schemeExternal {
+		t.Errorf("forwarding rule %v = %+v, want an external forwarding rule", name, rule)
+	}
+	if ingress := got.Status.LoadBalancer.Ingress; len(ingress) != 1 || ingress[0].IP != cloud.ForwardingRules[name].IPAddress {
+		t.Errorf("status = %+v, want the IP of the external forwarding rule", got.Status.LoadBalancer)
+	}
+}
+
+func TestControllerDisabledNetLB(t *testing.T) {
+	pool, _, _, _ := newTestPool()
+	kubeClient := fake.NewSimpleClientset()
+	c := NewController(kubeClient, context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, false), pool, true, false, false)
+	external := newILBService(api_v1.ServicePort{Port: 80})
+	external.Annotations = nil
+	if c.wanted(external) != nil {
+		t.Errorf("wanted() = %+v, want nil with the external load balancers disabled", c.wanted(external))
+	}
+	c.netLB = true
+	if lb := c.wanted(external); lb == nil || lb.finalizer != annotations.NetLBFinalizerKey {
+		t.Errorf("wanted() = %+v, want the external load balancer", lb)
+	}
+}
+
+func TestEnqueueEndpointsService(t *testing.T) {
+	pool, _, _, _ := newTestPool()
+	kubeClient := fake.NewSimpleClientset()
+	c := NewController(kubeClient, context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, true), pool, true, false, true)
+	svc := newILBService(api_v1.ServicePort{Port: 80})
+	c.serviceLister.Add(svc)
+	ep := &api_v1.Endpoints{ObjectMeta: meta_v1.ObjectMeta{Name: "svc", Namespace: "default"}}
+	c.enqueueEndpointsService(ep)
+	if c.serviceQueue.Len() != 0 {
+		t.Errorf("queue length = %v, want the Service of the Cluster policy ignored", c.serviceQueue.Len())
+	}
+	svc.Spec.ExternalTrafficPolicy = api_v1.ServiceExternalTrafficPolicyTypeLocal
+	c.enqueueEndpointsService(ep)
+	if c.serviceQueue.Len() != 1 {
+		t.Errorf("queue length = %v, want the Service of the Local policy enqueued", c.serviceQueue.Len())
+	}
+}
@@ -0,0 +1,22 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package l4 manages the internal TCP/UDP load balancers of the Services of
+// type LoadBalancer annotated with cloud.google.com/load-balancer-type:
+// Internal, in place of the cloud provider. Each of them gets a regional
+// forwarding rule, an internal backend service of the instance groups shared
+// with the Ingresses, a health check, and firewall rules.
+package l4
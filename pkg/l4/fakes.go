@@ -0,0 +1,485 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// FakeLoadBalancers is a fake LoadBalancers, keeping the resources in
+// memory. The forwarding rules without IP get the next IP of 10.0.0.0/24.
+type FakeLoadBalancers struct {
+	mu              sync.Mutex
+	region          string
+	network         string
+	subnetwork      string
+	nextIP          int
+	BackendServices map[string]*compute.BackendService
+	HealthChecks    map[string]*compute.HealthCheck
+	ForwardingRules map[string]*ForwardingRule
+	// ExternalBackendServices share the names of BackendServices, as the
+	// regional backend services of GCE.
+	ExternalBackendServices map[string]*BackendService
+	RegionHealthChecks      map[string]*compute.HealthCheck
+	// NEGs and Endpoints are keyed by zone/name, the endpoints by instance.
+	NEGs      map[string]*computealpha.NetworkEndpointGroup
+	Endpoints map[string]map[string]*computealpha.NetworkEndpoint
+	// ServiceAttachments get a new fingerprint on every change.
+	ServiceAttachments map[string]*ServiceAttachment
+	fingerprint        int
+}
+
+// Ensure that FakeLoadBalancers implements LoadBalancers.
+var _ LoadBalancers = &FakeLoadBalancers{}
+
+// NewFakeLoadBalancers returns a FakeLoadBalancers of the given region,
+// network and subnet.
+func NewFakeLoadBalancers(region, network, subnetwork string) *FakeLoadBalancers {
+	return &FakeLoadBalancers{
+		region:                  region,
+		network:                 network,
+		subnetwork:              subnetwork,
+		BackendServices:         map[string]*compute.BackendService{},
+		HealthChecks:            map[string]*compute.HealthCheck{},
+		ForwardingRules:         map[string]*ForwardingRule{},
+		ExternalBackendServices: map[string]*BackendService{},
+		RegionHealthChecks:      map[string]*compute.HealthCheck{},
+		NEGs:                    map[string]*computealpha.NetworkEndpointGroup{},
+		Endpoints:               map[string]map[string]*computealpha.NetworkEndpoint{},
+		ServiceAttachments:      map[string]*ServiceAttachment{},
+	}
+}
+
+func notFound(kind, name string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%v %v not found", kind, name)}
+}
+
+func alreadyExists(kind, name string) error {
+	return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("%v %v already exists", kind, name)}
+}
+
+func (f *FakeLoadBalancers) Region() string           { return f.region }
+func (f *FakeLoadBalancers) NetworkURL() string       { return f.network }
+func (f *FakeLoadBalancers) SubnetworkURL() string    { return f.subnetwork }
+func (f *FakeLoadBalancers) NetworkProjectID() string { return "test-project" }
+
+func (f *FakeLoadBalancers) GetRegionBackendService(name, region string) (*compute.BackendService, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bs, ok := f.BackendServices[name]
+	if !ok {
+		return nil, notFound("backend service", name)
+	}
+	copy := *bs
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateRegionBackendService(bs *compute.BackendService, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.BackendServices[bs.Name]; ok {
+		return alreadyExists("backend service", bs.Name)
+	}
+	if _, ok := f.ExternalBackendServices[bs.Name]; ok {
+		return alreadyExists("backend service", bs.Name)
+	}
+	copy := *bs
+	copy.SelfLink = fmt.Sprintf("regions/%v/backendServices/%v", region, bs.Name)
+	f.BackendServices[bs.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) UpdateRegionBackendService(bs *compute.BackendService, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.BackendServices[bs.Name]; !ok {
+		return notFound("backend service", bs.Name)
+	}
+	copy := *bs
+	f.BackendServices[bs.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteRegionBackendService(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, internal := f.BackendServices[name]
+	_, external := f.ExternalBackendServices[name]
+	if !internal && !external {
+		return notFound("backend service", name)
+	}
+	delete(f.BackendServices, name)
+	delete(f.ExternalBackendServices, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetHealthCheck(name string) (*compute.HealthCheck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hc, ok := f.HealthChecks[name]
+	if !ok {
+		return nil, notFound("health check", name)
+	}
+	copy := *hc
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateHealthCheck(hc *compute.HealthCheck) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.HealthChecks[hc.Name]; ok {
+		return alreadyExists("health check", hc.Name)
+	}
+	copy := *hc
+	copy.SelfLink = "global/healthChecks/" + hc.Name
+	f.HealthChecks[hc.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) UpdateHealthCheck(hc *compute.HealthCheck) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.HealthChecks[hc.Name]; !ok {
+		return notFound("health check", hc.Name)
+	}
+	copy := *hc
+	f.HealthChecks[hc.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteHealthCheck(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.HealthChecks[name]; !ok {
+		return notFound("health check", name)
+	}
+	delete(f.HealthChecks, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetForwardingRule(name, region string) (*ForwardingRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rule, ok := f.ForwardingRules[name]
+	if !ok {
+		return nil, notFound("forwarding rule", name)
+	}
+	copy := *rule
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateForwardingRule(rule *ForwardingRule, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.ForwardingRules[rule.Name]; ok {
+		return alreadyExists("forwarding rule", rule.Name)
+	}
+	copy := *rule
+	if copy.IPAddress == "" {
+		f.nextIP++
+		copy.IPAddress = fmt.Sprintf("10.0.0.%d", f.nextIP)
+	}
+	copy.SelfLink = fmt.Sprintf("regions/%v/forwardingRules/%v", region, rule.Name)
+	f.ForwardingRules[rule.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteForwardingRule(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.ForwardingRules[name]; !ok {
+		return notFound("forwarding rule", name)
+	}
+	delete(f.ForwardingRules, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) SetForwardingRuleGlobalAccess(name, region string, allow bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rule, ok := f.ForwardingRules[name]
+	if !ok {
+		return notFound("forwarding rule", name)
+	}
+	rule.AllowGlobalAccess = allow
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetExternalBackendService(name, region string) (*BackendService, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bs, ok := f.ExternalBackendServices[name]
+	if !ok {
+		return nil, notFound("backend service", name)
+	}
+	copy := *bs
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateExternalBackendService(bs *BackendService, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.BackendServices[bs.Name]; ok {
+		return alreadyExists("backend service", bs.Name)
+	}
+	if _, ok := f.ExternalBackendServices[bs.Name]; ok {
+		return alreadyExists("backend service", bs.Name)
+	}
+	copy := *bs
+	copy.SelfLink = fmt.Sprintf("regions/%v/backendServices/%v", region, bs.Name)
+	f.ExternalBackendServices[bs.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) UpdateExternalBackendService(bs *BackendService, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.ExternalBackendServices[bs.Name]
+	if !ok {
+		return notFound("backend service", bs.Name)
+	}
+	copy := *bs
+	copy.SelfLink = existing.SelfLink
+	f.ExternalBackendServices[bs.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetRegionHealthCheck(name, region string) (*compute.HealthCheck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hc, ok := f.RegionHealthChecks[name]
+	if !ok {
+		return nil, notFound("health check", name)
+	}
+	copy := *hc
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateRegionHealthCheck(hc *compute.HealthCheck, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.RegionHealthChecks[hc.Name]; ok {
+		return alreadyExists("health check", hc.Name)
+	}
+	copy := *hc
+	copy.SelfLink = fmt.Sprintf("regions/%v/healthChecks/%v", region, hc.Name)
+	f.RegionHealthChecks[hc.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) UpdateRegionHealthCheck(hc *compute.HealthCheck, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.RegionHealthChecks[hc.Name]
+	if !ok {
+		return notFound("health check", hc.Name)
+	}
+	copy := *hc
+	copy.SelfLink = existing.SelfLink
+	f.RegionHealthChecks[hc.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteRegionHealthCheck(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.RegionHealthChecks[name]; !ok {
+		return notFound("health check", name)
+	}
+	delete(f.RegionHealthChecks, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetNetworkEndpointGroup(name string, zone string) (*computealpha.NetworkEndpointGroup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	neg, ok := f.NEGs[zone+"/"+name]
+	if !ok {
+		return nil, notFound("network endpoint group", name)
+	}
+	copy := *neg
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateNetworkEndpointGroup(neg *computealpha.NetworkEndpointGroup, zone string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := zone + "/" + neg.Name
+	if _, ok := f.NEGs[key]; ok {
+		return alreadyExists("network endpoint group", neg.Name)
+	}
+	copy := *neg
+	copy.SelfLink = fmt.Sprintf("zones/%v/networkEndpointGroups/%v", zone, neg.Name)
+	f.NEGs[key] = &copy
+	f.Endpoints[key] = map[string]*computealpha.NetworkEndpoint{}
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteNetworkEndpointGroup(name string, zone string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := zone + "/" + name
+	if _, ok := f.NEGs[key]; !ok {
+		return notFound("network endpoint group", name)
+	}
+	delete(f.NEGs, key)
+	delete(f.Endpoints, key)
+	return nil
+}
+
+func (f *FakeLoadBalancers) AttachNetworkEndpoints(name, zone string, endpoints []*computealpha.NetworkEndpoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	eps, ok := f.Endpoints[zone+"/"+name]
+	if !ok {
+		return notFound("network endpoint group", name)
+	}
+	for _, ep := range endpoints {
+		eps[ep.Instance] = ep
+	}
+	return nil
+}
+
+func (f *FakeLoadBalancers) DetachNetworkEndpoints(name, zone string, endpoints []*computealpha.NetworkEndpoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	eps, ok := f.Endpoints[zone+"/"+name]
+	if !ok {
+		return notFound("network endpoint group", name)
+	}
+	for _, ep := range endpoints {
+		delete(eps, ep.Instance)
+	}
+	return nil
+}
+
+func (f *FakeLoadBalancers) ListNetworkEndpoints(name, zone string, showHealthStatus bool) ([]*computealpha.NetworkEndpointWithHealthStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	eps, ok := f.Endpoints[zone+"/"+name]
+	if !ok {
+		return nil, notFound("network endpoint group", name)
+	}
+	var list []*computealpha.NetworkEndpointWithHealthStatus
+	for _, ep := range eps {
+		list = append(list, &computealpha.NetworkEndpointWithHealthStatus{NetworkEndpoint: ep})
+	}
+	return list, nil
+}
+
+func (f *FakeLoadBalancers) GetServiceAttachment(name, region string) (*ServiceAttachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sa, ok := f.ServiceAttachments[name]
+	if !ok {
+		return nil, notFound("service attachment", name)
+	}
+	copy := *sa
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateServiceAttachment(sa *ServiceAttachment, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.ServiceAttachments[sa.Name]; ok {
+		return alreadyExists("service attachment", sa.Name)
+	}
+	copy := *sa
+	copy.SelfLink = fmt.Sprintf("regions/%v/serviceAttachments/%v", region, sa.Name)
+	f.fingerprint++
+	copy.Fingerprint = fmt.Sprintf("%d", f.fingerprint)
+	f.ServiceAttachments[sa.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) PatchServiceAttachment(sa *ServiceAttachment, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.ServiceAttachments[sa.Name]
+	if !ok {
+		return notFound("service attachment", sa.Name)
+	}
+	if existing.Fingerprint != sa.Fingerprint {
+		return &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "fingerprint mismatch"}
+	}
+	copy := *existing
+	copy.ConnectionPreference = sa.ConnectionPreference
+	copy.NatSubnets = sa.NatSubnets
+	copy.ConsumerAcceptLists = sa.ConsumerAcceptLists
+	copy.ConsumerRejectLists = sa.ConsumerRejectLists
+	f.fingerprint++
+	copy.Fingerprint = fmt.Sprintf("%d", f.fingerprint)
+	f.ServiceAttachments[sa.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteServiceAttachment(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.ServiceAttachments[name]; !ok {
+		return notFound("service attachment", name)
+	}
+	delete(f.ServiceAttachments, name)
+	return nil
+}
+
+// FakeInstanceGroups is a fake InstanceGroups, with one instance group per
+// zone.
+type FakeInstanceGroups struct {
+	mu     sync.Mutex
+	Groups []*compute.InstanceGroup
+	// Users are the Service keys using the instance groups.
+	Users sets.String
+}
+
+// Ensure that FakeInstanceGroups implements InstanceGroups.
+var _ InstanceGroups = &FakeInstanceGroups{}
+
+// NewFakeInstanceGroups returns a FakeInstanceGroups of the given instance
+// group in each of the given zones.
+func NewFakeInstanceGroups(name string, zones ...string) *FakeInstanceGroups {
+	f := &FakeInstanceGroups{Users: sets.NewString()}
+	for _, zone := range zones {
+		f.Groups = append(f.Groups, &compute.InstanceGroup{
+			Name:     name,
+			Zone:     zone,
+			SelfLink: fmt.Sprintf("zones/%v/instanceGroups/%v", zone, name),
+		})
+	}
+	return f
+}
+
+func (f *FakeInstanceGroups) EnsureL4InstanceGroups(key string) ([]*compute.InstanceGroup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Users.Insert(key)
+	return f.Groups, nil
+}
+
+func (f *FakeInstanceGroups) ReleaseL4InstanceGroups(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Users.Delete(key)
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"net/http"
+
+	"golang.org/x/oauth2"
+	compute "google.golang.org/api/compute/v1"
+
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// ForwardingRule is a regional forwarding rule. The vendored compute API
+// predates global access, so the forwarding rules are managed through the
+// REST API.
+type ForwardingRule struct {
+	Name                string   `json:"name"`
+	Description         string   `json:"description,omitempty"`
+	IPAddress           string   `json:"IPAddress,omitempty"`
+	IPProtocol          string   `json:"IPProtocol,omitempty"`
+	Ports               []string `json:"ports,omitempty"`
+	AllPorts            bool     `json:"allPorts,omitempty"`
+	LoadBalancingScheme string   `json:"loadBalancingScheme,omitempty"`
+	Network             string   `json:"network,omitempty"`
+	Subnetwork          string   `json:"subnetwork,omitempty"`
+	BackendService      string   `json:"backendService,omitempty"`
+	AllowGlobalAccess   bool     `json:"allowGlobalAccess,omitempty"`
+	Fingerprint         string   `json:"fingerprint,omitempty"`
+	SelfLink            string   `json:"selfLink,omitempty"`
+}
+
+// BackendService is a regional backend service of an external network load
+// balancer. The vendored compute API predates the connection tracking, so
+// these backend services are managed through the REST API.
+type BackendService struct {
+	Name                     string                                `json:"name"`
+	Description              string                                `json:"description,omitempty"`
+	Protocol                 string                                `json:"protocol,omitempty"`
+	LoadBalancingScheme      string                                `json:"loadBalancingScheme,omitempty"`
+	SessionAffinity          string                                `json:"sessionAffinity,omitempty"`
+	HealthChecks             []string                              `json:"healthChecks,omitempty"`
+	Backends                 []*compute.Backend                    `json:"backends,omitempty"`
+	ConnectionTrackingPolicy *annotations.ConnectionTrackingPolicy `json:"connectionTrackingPolicy,omitempty"`
+	FailoverPolicy           *annotations.FailoverPolicy           `json:"failoverPolicy,omitempty"`
+	Fingerprint              string                                `json:"fingerprint,omitempty"`
+	SelfLink                 string                                `json:"selfLink,omitempty"`
+}
+
+// ServiceAttachment is a PSC service attachment, publishing an internal load
+// balancer to the consumer projects. The vendored compute API predates PSC,
+// so the service attachments are managed through the REST API.
+type ServiceAttachment struct {
+	Name                 string                                   `json:"name"`
+	Description          string                                   `json:"description,omitempty"`
+	ConnectionPreference string                                   `json:"connectionPreference,omitempty"`
+	NatSubnets           []string                                 `json:"natSubnets,omitempty"`
+	TargetService        string                                   `json:"targetService,omitempty"`
+	EnableProxyProtocol  bool                                     `json:"enableProxyProtocol,omitempty"`
+	ConsumerAcceptLists  []*ServiceAttachmentConsumerProjectLimit `json:"consumerAcceptLists,omitempty"`
+	ConsumerRejectLists  []string                                 `json:"consumerRejectLists,omitempty"`
+	ConnectedEndpoints   []*ServiceAttachmentConnectedEndpoint    `json:"connectedEndpoints,omitempty"`
+	Fingerprint          string                                   `json:"fingerprint,omitempty"`
+	SelfLink             string                                   `json:"selfLink,omitempty"`
+}
+
+// ServiceAttachmentConsumerProjectLimit is a consumer project accepted by a
+// service attachment, with its maximum number of connections.
+type ServiceAttachmentConsumerProjectLimit struct {
+	ProjectIdOrNum  string `json:"projectIdOrNum"`
+	ConnectionLimit int64  `json:"connectionLimit,omitempty"`
+}
+
+// ServiceAttachmentConnectedEndpoint is a consumer endpoint connected to a
+// service attachment.
+type ServiceAttachmentConnectedEndpoint struct {
+	// Endpoint is the URL of the forwarding rule of the consumer.
+	Endpoint        string `json:"endpoint,omitempty"`
+	PscConnectionId uint64 `json:"pscConnectionId,omitempty,string"`
+	// Status is PENDING, ACCEPTED, REJECTED or CLOSED.
+	Status string `json:"status,omitempty"`
+}
+
+// gceLoadBalancers implements LoadBalancers: the internal backend services,
+// global health checks and NEGs through the cloud provider, the other
+// resources through the REST API.
+type gceLoadBalancers struct {
+	*gce.GCECloud
+	rest *utils.ComputeREST
+}
+
+// Ensure that gceLoadBalancers implements LoadBalancers.
+var _ LoadBalancers = &gceLoadBalancers{}
+
+// NewGCELoadBalancers returns the LoadBalancers of the project of the given
+// cloud. tokenSource, transport and apiEndpoint are those of
+// utils.NewComputeREST.
+func NewGCELoadBalancers(cloud *gce.GCECloud, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (LoadBalancers, error) {
+	rest, err := utils.NewComputeREST(cloud.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceLoadBalancers{GCECloud: cloud, rest: rest}, nil
+}
+
+// GetForwardingRule returns the given forwarding rule.
+func (g *gceLoadBalancers) GetForwardingRule(name, region string) (*ForwardingRule, error) {
+	rule := &ForwardingRule{}
+	if err := g.rest.Do("GET", g.forwardingRuleURL(region, name), nil, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// CreateForwardingRule creates the given forwarding rule, and waits for it to
+// be created.
+func (g *gceLoadBalancers) CreateForwardingRule(rule *ForwardingRule, region string) error {
+	return g.rest.DoOp("POST", g.forwardingRuleURL(region, ""), rule)
+}
+
+// DeleteForwardingRule deletes the given forwarding rule, and waits for it to
+// be deleted.
+func (g *gceLoadBalancers) DeleteForwardingRule(name, region string) error {
+	return g.rest.DoOp("DELETE", g.forwardingRuleURL(region, name), nil)
+}
+
+// SetForwardingRuleGlobalAccess patches the global access of the given
+// forwarding rule.
+func (g *gceLoadBalancers) SetForwardingRuleGlobalAccess(name, region string, allow bool) error {
+	rule, err := g.GetForwardingRule(name, region)
+	if err != nil {
+		return err
+	}
+	patch := map[string]interface{}{"allowGlobalAccess": allow, "fingerprint": rule.Fingerprint}
+	return g.rest.DoOp("PATCH", g.forwardingRuleURL(region, name), patch)
+}
+
+// forwardingRuleURL returns the URL of the given forwarding rule, of the
+// collection if name is empty.
+func (g *gceLoadBalancers) forwardingRuleURL(region, name string) string {
+	return g.rest.RegionalURL(region, "forwardingRules", name)
+}
+
+// GetExternalBackendService returns the given backend service.
+func (g *gceLoadBalancers) GetExternalBackendService(name, region string) (*BackendService, error) {
+	bs := &BackendService{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "backendServices", name), nil, bs); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// CreateExternalBackendService creates the given backend service, and waits
+// for it to be created.
+func (g *gceLoadBalancers) CreateExternalBackendService(bs *BackendService, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "backendServices", ""), bs)
+}
+
+// UpdateExternalBackendService updates the given backend service, whose
+// fingerprint must be the current one, and waits for it to be updated.
+func (g *gceLoadBalancers) UpdateExternalBackendService(bs *BackendService, region string) error {
+	return g.rest.DoOp("PUT", g.rest.RegionalURL(region, "backendServices", bs.Name), bs)
+}
+
+// GetRegionHealthCheck returns the given regional health check.
+func (g *gceLoadBalancers) GetRegionHealthCheck(name, region string) (*compute.HealthCheck, error) {
+	hc := &compute.HealthCheck{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "healthChecks", name), nil, hc); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+// CreateRegionHealthCheck creates the given regional health check, and waits
+// for it to be created.
+func (g *gceLoadBalancers) CreateRegionHealthCheck(hc *compute.HealthCheck, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "healthChecks", ""), hc)
+}
+
+// UpdateRegionHealthCheck updates the given regional health check, and waits
+// for it to be updated.
+func (g *gceLoadBalancers) UpdateRegionHealthCheck(hc *compute.HealthCheck, region string) error {
+	return g.rest.DoOp("PUT", g.rest.RegionalURL(region, "healthChecks", hc.Name), hc)
+}
+
+// DeleteRegionHealthCheck deletes the given regional health check, and waits
+// for it to be deleted.
+func (g *gceLoadBalancers) DeleteRegionHealthCheck(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "healthChecks", name), nil)
+}
+
+// GetServiceAttachment returns the given service attachment.
+func (g *gceLoadBalancers) GetServiceAttachment(name, region string) (*ServiceAttachment, error) {
+	sa := &ServiceAttachment{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "serviceAttachments", name), nil, sa); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// CreateServiceAttachment creates the given service attachment, and waits for
+// it to be created.
+func (g *gceLoadBalancers) CreateServiceAttachment(sa *ServiceAttachment, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "serviceAttachments", ""), sa)
+}
+
+// PatchServiceAttachment patches the given service attachment, and waits for
+// it to be patched. The lists are always sent, so that emptied lists are
+// cleared.
+func (g *gceLoadBalancers) PatchServiceAttachment(sa *ServiceAttachment, region string) error {
+	patch := map[string]interface{}{
+		"connectionPreference": sa.ConnectionPreference,
+		"natSubnets":           append([]string{}, sa.NatSubnets...),
+		"consumerAcceptLists":  append([]*ServiceAttachmentConsumerProjectLimit{}, sa.ConsumerAcceptLists...),
+		"consumerRejectLists":  append([]string{}, sa.ConsumerRejectLists...),
+		"fingerprint":          sa.Fingerprint,
+	}
+	return g.rest.DoOp("PATCH", g.rest.RegionalURL(region, "serviceAttachments", sa.Name), patch)
+}
+
+// DeleteServiceAttachment deletes the given service attachment, and waits for
+// it to be deleted.
+func (g *gceLoadBalancers) DeleteServiceAttachment(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "serviceAttachments", name), nil)
+}
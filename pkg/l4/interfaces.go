@@ -0,0 +1,93 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// LoadBalancers is the GCE API of the internal and external load balancers.
+type LoadBalancers interface {
+	// Region is the region of the cluster, and of the load balancers.
+	Region() string
+	// NetworkURL and SubnetworkURL are the network and default subnet of
+	// the cluster.
+	NetworkURL() string
+	SubnetworkURL() string
+	NetworkProjectID() string
+
+	GetRegionBackendService(name, region string) (*compute.BackendService, error)
+	CreateRegionBackendService(bs *compute.BackendService, region string) error
+	UpdateRegionBackendService(bs *compute.BackendService, region string) error
+	DeleteRegionBackendService(name, region string) error
+
+	GetHealthCheck(name string) (*compute.HealthCheck, error)
+	CreateHealthCheck(hc *compute.HealthCheck) error
+	UpdateHealthCheck(hc *compute.HealthCheck) error
+	DeleteHealthCheck(name string) error
+
+	GetForwardingRule(name, region string) (*ForwardingRule, error)
+	CreateForwardingRule(rule *ForwardingRule, region string) error
+	DeleteForwardingRule(name, region string) error
+	// SetForwardingRuleGlobalAccess sets whether the clients of all the
+	// regions reach the given forwarding rule.
+	SetForwardingRuleGlobalAccess(name, region string, allow bool) error
+
+	// The backend services of the external network load balancers carry
+	// a connection tracking and a failover policy.
+	GetExternalBackendService(name, region string) (*BackendService, error)
+	CreateExternalBackendService(bs *BackendService, region string) error
+	UpdateExternalBackendService(bs *BackendService, region string) error
+
+	// The external network load balancers are health checked by regional
+	// health checks.
+	GetRegionHealthCheck(name, region string) (*compute.HealthCheck, error)
+	CreateRegionHealthCheck(hc *compute.HealthCheck, region string) error
+	UpdateRegionHealthCheck(hc *compute.HealthCheck, region string) error
+	DeleteRegionHealthCheck(name, region string) error
+
+	// The backends of the external network load balancers are GCE_VM_IP
+	// NEGs of the nodes.
+	GetNetworkEndpointGroup(name string, zone string) (*computealpha.NetworkEndpointGroup, error)
+	CreateNetworkEndpointGroup(neg *computealpha.NetworkEndpointGroup, zone string) error
+	DeleteNetworkEndpointGroup(name string, zone string) error
+	AttachNetworkEndpoints(name, zone string, endpoints []*computealpha.NetworkEndpoint) error
+	DetachNetworkEndpoints(name, zone string, endpoints []*computealpha.NetworkEndpoint) error
+	ListNetworkEndpoints(name, zone string, showHealthStatus bool) ([]*computealpha.NetworkEndpointWithHealthStatus, error)
+
+	// The PSC service attachments publish the internal load balancers.
+	GetServiceAttachment(name, region string) (*ServiceAttachment, error)
+	CreateServiceAttachment(sa *ServiceAttachment, region string) error
+	// PatchServiceAttachment patches the connection preference, NAT
+	// subnets and consumer lists of the given service attachment, whose
+	// fingerprint must be the current one.
+	PatchServiceAttachment(sa *ServiceAttachment, region string) error
+	DeleteServiceAttachment(name, region string) error
+}
+
+// InstanceGroups are the instance groups of the nodes of the cluster, shared
+// with the Ingresses.
+type InstanceGroups interface {
+	// EnsureL4InstanceGroups returns the instance groups of the nodes for
+	// the load balancer of the given Service key, creating them and adding
+	// the nodes if needed.
+	EnsureL4InstanceGroups(key string) ([]*compute.InstanceGroup, error)
+	// ReleaseL4InstanceGroups records that the load balancer of the given
+	// Service key no longer uses the instance groups.
+	ReleaseL4InstanceGroups(key string)
+}
@@ -0,0 +1,471 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	// schemeInternal is the load balancing scheme of the internal load
+	// balancers.
+	schemeInternal = "INTERNAL"
+	// maxForwardingRulePorts is the maximum number of ports of a forwarding
+	// rule. Services with more ports forward all the ports.
+	maxForwardingRulePorts = 5
+	// nodesHealthCheckPort and nodesHealthCheckPath are the health check
+	// of kube-proxy, probed for the Services whose traffic may go through
+	// any node.
+	nodesHealthCheckPort = 10256
+	nodesHealthCheckPath = "/healthz"
+	// Health check timings, those of the cloud provider.
+	healthCheckInterval           = 8
+	healthCheckTimeout            = 1
+	healthCheckHealthyThreshold   = 1
+	healthCheckUnhealthyThreshold = 3
+	// allSourceRanges is the source range of the Services without
+	// loadBalancerSourceRanges.
+	allSourceRanges = "0.0.0.0/0"
+)
+
+// Pool manages the internal load balancers of the Services.
+type Pool struct {
+	cloud          LoadBalancers
+	firewalls      firewalls.Firewall
+	instanceGroups InstanceGroups
+	namer          *utils.Namer
+}
+
+// NewPool returns a Pool of the load balancers of the given cloud, whose
+// firewall rules are managed through the given provider, and whose backends
+// are the given instance groups.
+func NewPool(cloud LoadBalancers, firewallProvider firewalls.Firewall, instanceGroups InstanceGroups, namer *utils.Namer) *Pool {
+	return &Pool{cloud: cloud, firewalls: firewallProvider, instanceGroups: instanceGroups, namer: namer}
+}
+
+// Ensure creates or updates the internal load balancer of the given Service,
+// whose backends are the instance groups of the nodes, and whose firewall
+// rules target the given nodes, and returns its status.
+func (p *Pool) Ensure(svc *api_v1.Service, nodeNames []string) (*api_v1.LoadBalancerStatus, error) {
+	key := svc.Namespace + "/" + svc.Name
+	igs, err := p.instanceGroups.EnsureL4InstanceGroups(key)
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, ig := range igs {
+		groups = append(groups, ig.SelfLink)
+	}
+	return p.ensureILB(svc, nodeNames, groups)
+}
+
+// ensureILB creates or updates the internal load balancer of the given
+// Service, whose backends are the given groups, and whose firewall rules
+// target the given nodes, and returns its status. The NEGs no longer in the
+// backend service, eg: when subsetting is disabled, are deleted.
+func (p *Pool) ensureILB(svc *api_v1.Service, nodeNames []string, groups []string) (*api_v1.LoadBalancerStatus, error) {
+	key := svc.Namespace + "/" + svc.Name
+	name := p.namer.L4(svc.Namespace, svc.Name)
+	region := p.cloud.Region()
+	protocol, ports, err := servicePorts(svc)
+	if err != nil {
+		return nil, err
+	}
+	sourceRanges, err := serviceSourceRanges(svc)
+	if err != nil {
+		return nil, err
+	}
+	desc := utils.Description{ServiceName: key, ClusterUID: p.namer.UID()}.String()
+	logging.V(2).Infof("Ensuring internal load balancer %v of Service %v", name, key)
+
+	hcPort := healthCheckPort(svc)
+	hc, err := p.ensureHealthCheck(name, desc, "", hcPort)
+	if err != nil {
+		return nil, err
+	}
+	// The protocol of the backend service can't change under its
+	// forwarding rule.
+	if rule, err := p.cloud.GetForwardingRule(name, region); err == nil && rule.IPProtocol != protocol {
+		if err := p.cloud.DeleteForwardingRule(name, region); err != nil {
+			return nil, err
+		}
+	}
+	bs, removed, err := p.ensureBackendService(name, desc, protocol, sessionAffinity(svc), hc.SelfLink, groups)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.deleteNEGs(name, removed); err != nil {
+		return nil, err
+	}
+	if err := p.ensureFirewall(name, desc, sourceRanges, protocol, ports, nodeNames); err != nil {
+		return nil, err
+	}
+	if err := p.ensureFirewall(p.namer.L4HealthCheckFirewall(svc.Namespace, svc.Name), desc, firewalls.HealthCheckSrcRanges(), "TCP", []string{strconv.FormatInt(hcPort, 10)}, nodeNames); err != nil {
+		return nil, err
+	}
+
+	subnet := p.cloud.SubnetworkURL()
+	if subnetName := annotations.SvcAnnotations(svc.Annotations).ILBSubnet(); subnetName != "" {
+		subnet = subnetworkURL(p.cloud.NetworkURL(), p.cloud.NetworkProjectID(), region, subnetName)
+	}
+	desired := &ForwardingRule{
+		Name:                name,
+		Description:         desc,
+		IPAddress:           svc.Spec.LoadBalancerIP,
+		IPProtocol:          protocol,
+		LoadBalancingScheme: schemeInternal,
+		Network:             p.cloud.NetworkURL(),
+		Subnetwork:          subnet,
+		BackendService:      bs.SelfLink,
+		AllowGlobalAccess:   annotations.SvcAnnotations(svc.Annotations).ILBAllowGlobalAccess(),
+	}
+	if len(ports) > maxForwardingRulePorts {
+		desired.AllPorts = true
+	} else {
+		desired.Ports = ports
+	}
+	rule, err := p.ensureForwardingRule(desired)
+	if err != nil {
+		return nil, err
+	}
+	return &api_v1.LoadBalancerStatus{Ingress: []api_v1.LoadBalancerIngress{{IP: rule.IPAddress}}}, nil
+}
+
+// Delete deletes the internal load balancer of the given Service, and the
+// NEGs of its backend service. The resources which are already gone are
+// ignored.
+func (p *Pool) Delete(svc *api_v1.Service) error {
+	key := svc.Namespace + "/" + svc.Name
+	name := p.namer.L4(svc.Namespace, svc.Name)
+	region := p.cloud.Region()
+	logging.V(2).Infof("Deleting internal load balancer %v of Service %v", name, key)
+	var groups []string
+	bs, err := p.cloud.GetRegionBackendService(name, region)
+	if err != nil && !utils.IsNotFoundError(err) {
+		return err
+	}
+	if err == nil {
+		for _, be := range bs.Backends {
+			groups = append(groups, be.Group)
+		}
+	}
+	// The forwarding rule goes first, it uses the backend service, which
+	// uses the health check.
+	for _, del := range []func() error{
+		func() error { return p.cloud.DeleteForwardingRule(name, region) },
+		func() error { return p.cloud.DeleteRegionBackendService(name, region) },
+		func() error { return p.cloud.DeleteHealthCheck(name) },
+		func() error { return p.deleteFirewall(name) },
+		func() error { return p.deleteFirewall(p.namer.L4HealthCheckFirewall(svc.Namespace, svc.Name)) },
+		func() error { return p.deleteNEGs(name, groups) },
+	} {
+		if err := utils.IgnoreHTTPNotFound(del()); err != nil {
+			return err
+		}
+	}
+	p.instanceGroups.ReleaseL4InstanceGroups(key)
+	return nil
+}
+
+// servicePorts returns the protocol and the ports of the given Service. All
+// the ports must share the same protocol, TCP or UDP.
+func servicePorts(svc *api_v1.Service) (string, []string, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return "", nil, fmt.Errorf("the Service has no ports")
+	}
+	protocol := svc.Spec.Ports[0].Protocol
+	if protocol == "" {
+		protocol = api_v1.ProtocolTCP
+	}
+	ports := sets.NewString()
+	for _, port := range svc.Spec.Ports {
+		p := port.Protocol
+		if p == "" {
+			p = api_v1.ProtocolTCP
+		}
+		if p != protocol {
+			return "", nil, fmt.Errorf("the ports of the Service mix protocols %v and %v, internal load balancers serve a single protocol", protocol, p)
+		}
+		if p != api_v1.ProtocolTCP && p != api_v1.ProtocolUDP {
+			return "", nil, fmt.Errorf("protocol %v is not supported, only TCP and UDP are", p)
+		}
+		ports.Insert(strconv.Itoa(int(port.Port)))
+	}
+	list := ports.List()
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := strconv.Atoi(list[i])
+		b, _ := strconv.Atoi(list[j])
+		return a < b
+	})
+	return string(protocol), list, nil
+}
+
+// healthCheckPort returns the node port probed by the health check of the
+// given Service: the port of kube-proxy, or the health check node port of the
+// Services with the Local external traffic policy, whose nodes without
+// endpoints fail it.
+func healthCheckPort(svc *api_v1.Service) int64 {
+	if svc.Spec.ExternalTrafficPolicy == api_v1.ServiceExternalTrafficPolicyTypeLocal && svc.Spec.HealthCheckNodePort != 0 {
+		return int64(svc.Spec.HealthCheckNodePort)
+	}
+	return nodesHealthCheckPort
+}
+
+// sessionAffinity returns the session affinity of the backend service of the
+// given Service.
+func sessionAffinity(svc *api_v1.Service) string {
+	if svc.Spec.SessionAffinity == api_v1.ServiceAffinityClientIP {
+		return "CLIENT_IP"
+	}
+	return "NONE"
+}
+
+// serviceSourceRanges returns the loadBalancerSourceRanges of the given
+// Service, all IPs if empty.
+func serviceSourceRanges(svc *api_v1.Service) ([]string, error) {
+	if len(svc.Spec.LoadBalancerSourceRanges) == 0 {
+		return []string{allSourceRanges}, nil
+	}
+	var ranges []string
+	for _, r := range svc.Spec.LoadBalancerSourceRanges {
+		r = strings.TrimSpace(r)
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return nil, fmt.Errorf("invalid loadBalancerSourceRanges %q: %v", r, err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// subnetworkURL returns the URL of the given subnet of the given region, in
+// the project of the given network.
+func subnetworkURL(networkURL, networkProject, region, subnet string) string {
+	if i := strings.Index(networkURL, "/global/networks/"); i >= 0 {
+		return fmt.Sprintf("%v/regions/%v/subnetworks/%v", networkURL[:i], region, subnet)
+	}
+	return fmt.Sprintf("projects/%v/regions/%v/subnetworks/%v", networkProject, region, subnet)
+}
+
+// sameResource returns true if the given links refer to the same resource,
+// eg: a full URL and a partial one.
+func sameResource(a, b string) bool {
+	return resourcePath(a) == resourcePath(b)
+}
+
+// resourcePath returns the path of the resource of the given link from its
+// project, without the API endpoint and version.
+func resourcePath(link string) string {
+	if i := strings.Index(link, "projects/"); i >= 0 {
+		return link[i:]
+	}
+	return link
+}
+
+// ensureHealthCheck creates or updates the HTTP health check of the given
+// name, probing the given node port. The health check is regional if region
+// is set, global otherwise.
+func (p *Pool) ensureHealthCheck(name, desc, region string, port int64) (*compute.HealthCheck, error) {
+	desired := &compute.HealthCheck{
+		Name:               name,
+		Description:        desc,
+		Type:               "HTTP",
+		CheckIntervalSec:   healthCheckInterval,
+		TimeoutSec:         healthCheckTimeout,
+		HealthyThreshold:   healthCheckHealthyThreshold,
+		UnhealthyThreshold: healthCheckUnhealthyThreshold,
+		HttpHealthCheck: &compute.HTTPHealthCheck{
+			Port:        port,
+			RequestPath: nodesHealthCheckPath,
+		},
+	}
+	get, create, update := p.cloud.GetHealthCheck, p.cloud.CreateHealthCheck, p.cloud.UpdateHealthCheck
+	if region != "" {
+		get = func(name string) (*compute.HealthCheck, error) { return p.cloud.GetRegionHealthCheck(name, region) }
+		create = func(hc *compute.HealthCheck) error { return p.cloud.CreateRegionHealthCheck(hc, region) }
+		update = func(hc *compute.HealthCheck) error { return p.cloud.UpdateRegionHealthCheck(hc, region) }
+	}
+	existing, err := get(name)
+	switch {
+	case utils.IsNotFoundError(err):
+		logging.V(2).Infof("Creating health check %v", name)
+		if err := create(desired); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case existing.HttpHealthCheck == nil || existing.HttpHealthCheck.Port != port || existing.HttpHealthCheck.RequestPath != nodesHealthCheckPath:
+		logging.V(2).Infof("Updating health check %v", name)
+		if err := update(desired); err != nil {
+			return nil, err
+		}
+	default:
+		return existing, nil
+	}
+	return get(name)
+}
+
+// ensureBackendService creates or updates the internal backend service of
+// the given name, balancing the connections to the given instance groups or
+// NEGs. Returns the backend service, and the groups removed from its
+// backends.
+func (p *Pool) ensureBackendService(name, desc, protocol, affinity, hcLink string, groupLinks []string) (*compute.BackendService, []string, error) {
+	region := p.cloud.Region()
+	var backends []*compute.Backend
+	groups := sets.NewString()
+	for _, link := range groupLinks {
+		backends = append(backends, &compute.Backend{Group: link, BalancingMode: "CONNECTION"})
+		groups.Insert(link)
+	}
+	desired := &compute.BackendService{
+		Name:                name,
+		Description:         desc,
+		Protocol:            protocol,
+		LoadBalancingScheme: schemeInternal,
+		SessionAffinity:     affinity,
+		HealthChecks:        []string{hcLink},
+		Backends:            backends,
+	}
+	existing, err := p.cloud.GetRegionBackendService(name, region)
+	if utils.IsNotFoundError(err) {
+		logging.V(2).Infof("Creating backend service %v", name)
+		if err := p.cloud.CreateRegionBackendService(desired, region); err != nil {
+			return nil, nil, err
+		}
+		bs, err := p.cloud.GetRegionBackendService(name, region)
+		return bs, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	existingGroups := sets.NewString()
+	for _, be := range existing.Backends {
+		existingGroups.Insert(be.Group)
+	}
+	if existing.Protocol == protocol && existing.SessionAffinity == affinity && len(existing.HealthChecks) == 1 && sameResource(existing.HealthChecks[0], hcLink) && existingGroups.Equal(groups) {
+		return existing, nil, nil
+	}
+	logging.V(2).Infof("Updating backend service %v", name)
+	desired.Fingerprint = existing.Fingerprint
+	if err := p.cloud.UpdateRegionBackendService(desired, region); err != nil {
+		return nil, nil, err
+	}
+	bs, err := p.cloud.GetRegionBackendService(name, region)
+	return bs, existingGroups.Difference(groups).List(), err
+}
+
+// ensureFirewall creates or updates the firewall rule of the given name,
+// allowing the given source ranges to reach the given ports of the nodes.
+func (p *Pool) ensureFirewall(name, desc string, sourceRanges []string, protocol string, ports []string, nodeNames []string) error {
+	tags, err := p.firewalls.GetNodeTags(nodeNames)
+	if err != nil {
+		return err
+	}
+	desired := &firewalls.FirewallRule{
+		Name:         name,
+		Description:  desc,
+		Network:      p.firewalls.NetworkURL(),
+		SourceRanges: sourceRanges,
+		TargetTags:   tags,
+		Allowed:      []*firewalls.FirewallAllowed{{IPProtocol: strings.ToLower(protocol), Ports: ports}},
+	}
+	existing, err := p.firewalls.GetFirewall(name)
+	if utils.IsNotFoundError(err) {
+		logging.V(2).Infof("Creating firewall rule %v", name)
+		return p.firewallError(name, p.firewalls.CreateFirewall(desired))
+	}
+	if err != nil {
+		return err
+	}
+	if sets.NewString(existing.SourceRanges...).Equal(sets.NewString(sourceRanges...)) &&
+		sets.NewString(existing.TargetTags...).Equal(sets.NewString(tags...)) &&
+		len(existing.Allowed) == 1 && reflect.DeepEqual(existing.Allowed[0].Ports, ports) && strings.EqualFold(existing.Allowed[0].IPProtocol, protocol) {
+		return nil
+	}
+	logging.V(2).Infof("Updating firewall rule %v", name)
+	return p.firewallError(name, p.firewalls.UpdateFirewall(desired))
+}
+
+// deleteFirewall deletes the firewall rule of the given name.
+func (p *Pool) deleteFirewall(name string) error {
+	return p.firewallError(name, p.firewalls.DeleteFirewall(name))
+}
+
+// firewallError explains the forbidden changes to the firewall rules of the
+// given name on a shared VPC, which a network admin of the host project
+// makes.
+func (p *Pool) firewallError(name string, err error) error {
+	if utils.IsForbiddenError(err) && p.firewalls.OnXPN() {
+		return fmt.Errorf("firewall rule %v must be changed by a network admin of project %v: %v", name, p.firewalls.NetworkProjectID(), err)
+	}
+	return err
+}
+
+// ensureForwardingRule creates the given forwarding rule, or recreates it if
+// it changed. The forwarding rules of the Services without loadBalancerIP
+// keep their IP while they stay in the same subnet.
+func (p *Pool) ensureForwardingRule(desired *ForwardingRule) (*ForwardingRule, error) {
+	region := p.cloud.Region()
+	existing, err := p.cloud.GetForwardingRule(desired.Name, region)
+	if err != nil && !utils.IsNotFoundError(err) {
+		return nil, err
+	}
+	if err == nil {
+		if desired.IPAddress == "" && sameResource(existing.Subnetwork, desired.Subnetwork) {
+			desired.IPAddress = existing.IPAddress
+		}
+		if existing.IPAddress == desired.IPAddress &&
+			existing.IPProtocol == desired.IPProtocol &&
+			existing.AllPorts == desired.AllPorts &&
+			reflect.DeepEqual(existing.Ports, desired.Ports) &&
+			sameResource(existing.Subnetwork, desired.Subnetwork) &&
+			sameResource(existing.BackendService, desired.BackendService) {
+			if existing.AllowGlobalAccess != desired.AllowGlobalAccess {
+				logging.V(2).Infof("Setting the global access of forwarding rule %v to %v", desired.Name, desired.AllowGlobalAccess)
+				if err := p.cloud.SetForwardingRuleGlobalAccess(desired.Name, region, desired.AllowGlobalAccess); err != nil {
+					return nil, err
+				}
+				return p.cloud.GetForwardingRule(desired.Name, region)
+			}
+			return existing, nil
+		}
+		logging.V(2).Infof("Recreating forwarding rule %v", desired.Name)
+		if err := p.cloud.DeleteForwardingRule(desired.Name, region); err != nil {
+			return nil, err
+		}
+	} else {
+		logging.V(2).Infof("Creating forwarding rule %v", desired.Name)
+	}
+	if err := p.cloud.CreateForwardingRule(desired, region); err != nil {
+		return nil, err
+	}
+	return p.cloud.GetForwardingRule(desired.Name, region)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"testing"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const testRegion = "us-central1"
+
+func newTestPool() (*Pool, *FakeLoadBalancers, firewalls.Firewall, *FakeInstanceGroups) {
+	cloud := NewFakeLoadBalancers(testRegion, "projects/p/global/networks/net", "projects/p/regions/us-central1/subnetworks/default")
+	fw := firewalls.NewFakeFirewallsProvider(false, false)
+	igs := NewFakeInstanceGroups("k8s-ig--uid", "us-central1-a", "us-central1-b")
+	return NewPool(cloud, fw, igs, utils.NewNamer("uid", "fw")), cloud, fw, igs
+}
+
+func newILBService(ports ...api_v1.ServicePort) *api_v1.Service {
+	return &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "svc",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.ILBTypeKey: annotations.ILBTypeInternal},
+		},
+		Spec: api_v1.ServiceSpec{Type: api_v1.ServiceTypeLoadBalancer, Ports: ports},
+	}
+}
+
+func TestEnsureDelete(t *testing.T) {
+	pool, cloud, fw, igs := newTestPool()
+	svc := newILBService(api_v1.ServicePort{Port: 80}, api_v1.ServicePort{Port: 443})
+	name := pool.namer.L4(svc.Namespace, svc.Name)
+
+	status, err := pool.Ensure(svc, []string{"node-1"})
+	if err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != "10.0.0.1" {
+		t.Errorf("Ensure() = %+v, want IP 10.0.0.1", status)
+	}
+	hc, ok := cloud.HealthChecks[name]
+	if !ok || hc.HttpHealthCheck.Port != nodesHealthCheckPort {
+		t.Errorf("health check %v = %+v, want port %v", name, hc, nodesHealthCheckPort)
+	}
+	bs, ok := cloud.BackendServices[name]
+	if !ok || bs.LoadBalancingScheme != schemeInternal || len(bs.Backends) != 2 {
+		t.Errorf("backend service %v = %+v, want an internal backend service of 2 instance groups", name, bs)
+	}
+	rule := cloud.ForwardingRules[name]
+	if rule == nil || rule.IPProtocol != "TCP" || len(rule.Ports) != 2 || rule.AllPorts {
+		t.Errorf("forwarding rule %v = %+v, want TCP ports 80 and 443", name, rule)
+	}
+	for _, fwName := range []string{name, pool.namer.L4HealthCheckFirewall(svc.Namespace, svc.Name)} {
+		if _, err := fw.GetFirewall(fwName); err != nil {
+			t.Errorf("GetFirewall(%v) = %v", fwName, err)
+		}
+	}
+	if !igs.Users.Has("default/svc") {
+		t.Errorf("instance groups users = %v, want default/svc", igs.Users.List())
+	}
+
+	if err := pool.Delete(svc); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if len(cloud.ForwardingRules)+len(cloud.BackendServices)+len(cloud.HealthChecks) != 0 {
+		t.Errorf("resources left after Delete(): %v %v %v", cloud.ForwardingRules, cloud.BackendServices, cloud.HealthChecks)
+	}
+	if _, err := fw.GetFirewall(name); !utils.IsNotFoundError(err) {
+		t.Errorf("GetFirewall(%v) = %v, want not found", name, err)
+	}
+	if igs.Users.Len() != 0 {
+		t.Errorf("instance groups users = %v, want none", igs.Users.List())
+	}
+	// Deleting again ignores the missing resources.
+	if err := pool.Delete(svc); err != nil {
+		t.Errorf("second Delete() = %v", err)
+	}
+}
+
+func TestEnsureUpdates(t *testing.T) {
+	pool, cloud, _, _ := newTestPool()
+	svc := newILBService(api_v1.ServicePort{Port: 80})
+	name := pool.namer.L4(svc.Namespace, svc.Name)
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+
+	// Global access is patched.
+	svc.Annotations[annotations.ILBAllowGlobalAccessKey] = "true"
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if rule := cloud.ForwardingRules[name]; !rule.AllowGlobalAccess {
+		t.Errorf("forwarding rule %v = %+v, want global access", name, rule)
+	}
+
+	// New ports recreate the forwarding rule, which keeps its IP.
+	svc.Spec.Ports = append(svc.Spec.Ports, api_v1.ServicePort{Port: 8080})
+	status, err := pool.Ensure(svc, nil)
+	if err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if status.Ingress[0].IP != "10.0.0.1" {
+		t.Errorf("Ensure() = %+v, want IP 10.0.0.1 kept", status)
+	}
+	if rule := cloud.ForwardingRules[name]; len(rule.Ports) != 2 || !rule.AllowGlobalAccess {
+		t.Errorf("forwarding rule %v = %+v, want ports 80 and 8080 with global access", name, rule)
+	}
+
+	// A new subnet changes the IP.
+	svc.Annotations[annotations.ILBSubnetKey] = "other"
+	status, err = pool.Ensure(svc, nil)
+	if err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if rule := cloud.ForwardingRules[name]; rule.Subnetwork != "projects/p/regions/us-central1/subnetworks/other" || status.Ingress[0].IP == "10.0.0.1" {
+		t.Errorf("forwarding rule %v = %+v, want a new IP of subnet other", name, rule)
+	}
+
+	// The Local traffic policy is health checked on the node port of the
+	// Service.
+	svc.Spec.ExternalTrafficPolicy = api_v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = 30123
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if hc := cloud.HealthChecks[name]; hc.HttpHealthCheck.Port != 30123 {
+		t.Errorf("health check %v = %+v, want port 30123", name, hc)
+	}
+}
+
+func TestServicePorts(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		ports    []api_v1.ServicePort
+		protocol string
+		want     []string
+		wantErr  bool
+	}{
+		{"no ports", nil, "", nil, true},
+		{"default protocol", []api_v1.ServicePort{{Port: 443}, {Port: 80}}, "TCP", []string{"80", "443"}, false},
+		{"udp", []api_v1.ServicePort{{Port: 53, Protocol: api_v1.ProtocolUDP}}, "UDP", []string{"53"}, false},
+		{"mixed protocols", []api_v1.ServicePort{{Port: 53, Protocol: api_v1.ProtocolUDP}, {Port: 53}}, "", nil, true},
+		{"sctp", []api_v1.ServicePort{{Port: 9, Protocol: "SCTP"}}, "", nil, true},
+	} {
+		protocol, ports, err := servicePorts(newILBService(tc.ports...))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%v: servicePorts() = %v, want error %v", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if protocol != tc.protocol || len(ports) != len(tc.want) {
+			t.Errorf("%v: servicePorts() = %v %v, want %v %v", tc.desc, protocol, ports, tc.protocol, tc.want)
+			continue
+		}
+		for i := range ports {
+			if ports[i] != tc.want[i] {
+				t.Errorf("%v: servicePorts() = %v, want %v", tc.desc, ports, tc.want)
+			}
+		}
+	}
+}
+
+func TestEnsureAllPorts(t *testing.T) {
+	pool, cloud, _, _ := newTestPool()
+	svc := newILBService()
+	for port := int32(1); port <= maxForwardingRulePorts+1; port++ {
+		svc.Spec.Ports = append(svc.Spec.Ports, api_v1.ServicePort{Port: port})
+	}
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if rule := cloud.ForwardingRules[pool.namer.L4(svc.Namespace, svc.Name)]; !rule.AllPorts || len(rule.Ports) != 0 {
+		t.Errorf("forwarding rule = %+v, want all ports", rule)
+	}
+}
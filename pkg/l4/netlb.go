@@ -0,0 +1,417 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	compute "google.golang.org/api/compute/v1"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	// schemeExternal is the load balancing scheme of the external network
+	// load balancers.
+	schemeExternal = "EXTERNAL"
+	// vmIPNetworkEndpointType is the endpoint type of the NEGs of the
+	// nodes, whose endpoints are the primary IPs of the instances.
+	vmIPNetworkEndpointType = "GCE_VM_IP"
+	// negLoadBalancerType is the type of the NEGs of the load balancers.
+	negLoadBalancerType = "LOAD_BALANCING"
+	// maxEndpointsPerBatch is the maximum number of endpoints attached or
+	// detached by a single call.
+	maxEndpointsPerBatch = 500
+)
+
+// EnsureNetLB creates or updates the external network load balancer of the
+// given Service, whose backends are GCE_VM_IP NEGs of the given nodes in
+// each of their zones, and returns its status.
+func (p *Pool) EnsureNetLB(svc *api_v1.Service, nodes []*api_v1.Node) (*api_v1.LoadBalancerStatus, error) {
+	key := svc.Namespace + "/" + svc.Name
+	name := p.namer.L4(svc.Namespace, svc.Name)
+	region := p.cloud.Region()
+	protocol, ports, err := servicePorts(svc)
+	if err != nil {
+		return nil, err
+	}
+	sourceRanges, err := serviceSourceRanges(svc)
+	if err != nil {
+		return nil, err
+	}
+	tracking, err := annotations.SvcAnnotations(svc.Annotations).ConnectionTrackingPolicy()
+	if err != nil {
+		return nil, err
+	}
+	failover, err := annotations.SvcAnnotations(svc.Annotations).FailoverPolicy()
+	if err != nil {
+		return nil, err
+	}
+	desc := utils.Description{ServiceName: key, ClusterUID: p.namer.UID()}.String()
+	logging.V(2).Infof("Ensuring external network load balancer %v of Service %v", name, key)
+
+	hcPort := healthCheckPort(svc)
+	hc, err := p.ensureHealthCheck(name, desc, region, hcPort)
+	if err != nil {
+		return nil, err
+	}
+	negs, err := p.ensureNEGs(name, desc, nodeEndpoints(nodes))
+	if err != nil {
+		return nil, err
+	}
+	// The protocol of the backend service can't change under its
+	// forwarding rule.
+	if rule, err := p.cloud.GetForwardingRule(name, region); err == nil && rule.IPProtocol != protocol {
+		if err := p.cloud.DeleteForwardingRule(name, region); err != nil {
+			return nil, err
+		}
+	}
+	desiredBS := &BackendService{
+		Name:                     name,
+		Description:              desc,
+		Protocol:                 protocol,
+		LoadBalancingScheme:      schemeExternal,
+		SessionAffinity:          sessionAffinity(svc),
+		HealthChecks:             []string{hc.SelfLink},
+		ConnectionTrackingPolicy: tracking,
+		FailoverPolicy:           failover,
+	}
+	for _, neg := range negs {
+		desiredBS.Backends = append(desiredBS.Backends, &compute.Backend{Group: neg, BalancingMode: "CONNECTION"})
+	}
+	bs, removed, err := p.ensureExternalBackendService(desiredBS)
+	if err != nil {
+		return nil, err
+	}
+	// The NEGs of the zones without nodes can go once out of the backend
+	// service.
+	if err := p.deleteNEGs(name, removed); err != nil {
+		return nil, err
+	}
+	var nodeNames []string
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	if err := p.ensureFirewall(name, desc, sourceRanges, protocol, ports, nodeNames); err != nil {
+		return nil, err
+	}
+	if err := p.ensureFirewall(p.namer.L4HealthCheckFirewall(svc.Namespace, svc.Name), desc, firewalls.NetLBHealthCheckSrcRanges(), "TCP", []string{strconv.FormatInt(hcPort, 10)}, nodeNames); err != nil {
+		return nil, err
+	}
+
+	desired := &ForwardingRule{
+		Name:                name,
+		Description:         desc,
+		IPAddress:           svc.Spec.LoadBalancerIP,
+		IPProtocol:          protocol,
+		LoadBalancingScheme: schemeExternal,
+		BackendService:      bs.SelfLink,
+	}
+	if len(ports) > maxForwardingRulePorts {
+		desired.AllPorts = true
+	} else {
+		desired.Ports = ports
+	}
+	rule, err := p.ensureForwardingRule(desired)
+	if err != nil {
+		return nil, err
+	}
+	return &api_v1.LoadBalancerStatus{Ingress: []api_v1.LoadBalancerIngress{{IP: rule.IPAddress}}}, nil
+}
+
+// DeleteNetLB deletes the external network load balancer of the given
+// Service, and its NEGs in the zones of its backend service and of the given
+// nodes. The resources which are already gone are ignored.
+func (p *Pool) DeleteNetLB(svc *api_v1.Service, nodes []*api_v1.Node) error {
+	key := svc.Namespace + "/" + svc.Name
+	name := p.namer.L4(svc.Namespace, svc.Name)
+	region := p.cloud.Region()
+	logging.V(2).Infof("Deleting external network load balancer %v of Service %v", name, key)
+	zones := sets.NewString()
+	for zone := range nodeEndpoints(nodes) {
+		zones.Insert(zone)
+	}
+	if err := utils.IgnoreHTTPNotFound(p.cloud.DeleteForwardingRule(name, region)); err != nil {
+		return err
+	}
+	bs, err := p.cloud.GetExternalBackendService(name, region)
+	if err != nil && !utils.IsNotFoundError(err) {
+		return err
+	}
+	if err == nil {
+		for _, be := range bs.Backends {
+			if zone := negZone(be.Group); zone != "" {
+				zones.Insert(zone)
+			}
+		}
+	}
+	// The backend service uses the health check and the NEGs.
+	dels := []func() error{
+		func() error { return p.cloud.DeleteRegionBackendService(name, region) },
+		func() error { return p.cloud.DeleteRegionHealthCheck(name, region) },
+		func() error { return p.deleteFirewall(name) },
+		func() error { return p.deleteFirewall(p.namer.L4HealthCheckFirewall(svc.Namespace, svc.Name)) },
+	}
+	for _, zone := range zones.List() {
+		zone := zone
+		dels = append(dels, func() error { return p.cloud.DeleteNetworkEndpointGroup(name, zone) })
+	}
+	for _, del := range dels {
+		if err := utils.IgnoreHTTPNotFound(del()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeEndpoints returns the GCE_VM_IP endpoints of the given nodes, by zone
+// and instance. The nodes without zone or internal IP are skipped.
+func nodeEndpoints(nodes []*api_v1.Node) map[string]map[string]*computealpha.NetworkEndpoint {
+	endpoints := map[string]map[string]*computealpha.NetworkEndpoint{}
+	for _, node := range nodes {
+		zone := node.Labels[annotations.ZoneKey]
+		ip := ""
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == api_v1.NodeInternalIP {
+				ip = addr.Address
+				break
+			}
+		}
+		if zone == "" || ip == "" {
+			logging.V(4).Infof("Skipping node %v without zone or internal IP", node.Name)
+			continue
+		}
+		if endpoints[zone] == nil {
+			endpoints[zone] = map[string]*computealpha.NetworkEndpoint{}
+		}
+		endpoints[zone][node.Name] = &computealpha.NetworkEndpoint{Instance: node.Name, IpAddress: ip}
+	}
+	return endpoints
+}
+
+// negZone returns the zone of the NEG of the given link, empty if the link
+// isn't a zonal NEG.
+func negZone(link string) string {
+	parts := strings.Split(link, "/")
+	for i := 0; i+3 < len(parts); i++ {
+		if parts[i] == "zones" && parts[i+2] == "networkEndpointGroups" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// deleteNEGs deletes the NEGs of the given name among the given groups of a
+// backend service, the instance groups are skipped.
+func (p *Pool) deleteNEGs(name string, groups []string) error {
+	for _, link := range groups {
+		if zone := negZone(link); zone != "" {
+			logging.V(2).Infof("Deleting NEG %v in %v", name, zone)
+			if err := utils.IgnoreHTTPNotFound(p.cloud.DeleteNetworkEndpointGroup(name, zone)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureNEGs creates the GCE_VM_IP NEGs of the given name in the zones of the
+// given endpoints, and syncs their endpoints. Returns the links of the NEGs,
+// sorted by zone.
+func (p *Pool) ensureNEGs(name, desc string, endpoints map[string]map[string]*computealpha.NetworkEndpoint) ([]string, error) {
+	var zones []string
+	for zone := range endpoints {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	var links []string
+	for _, zone := range zones {
+		neg, err := p.cloud.GetNetworkEndpointGroup(name, zone)
+		if utils.IsNotFoundError(err) {
+			logging.V(2).Infof("Creating NEG %v in %v", name, zone)
+			err = p.cloud.CreateNetworkEndpointGroup(&computealpha.NetworkEndpointGroup{
+				Name:                name,
+				Description:         desc,
+				Type:                negLoadBalancerType,
+				NetworkEndpointType: vmIPNetworkEndpointType,
+				LoadBalancer: &computealpha.NetworkEndpointGroupLbNetworkEndpointGroup{
+					Network:    p.cloud.NetworkURL(),
+					Subnetwork: p.cloud.SubnetworkURL(),
+				},
+			}, zone)
+			if err != nil {
+				return nil, err
+			}
+			neg, err = p.cloud.GetNetworkEndpointGroup(name, zone)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if neg.NetworkEndpointType != vmIPNetworkEndpointType {
+			return nil, fmt.Errorf("NEG %v in %v has endpoint type %v, want %v", name, zone, neg.NetworkEndpointType, vmIPNetworkEndpointType)
+		}
+		if err := p.syncEndpoints(name, zone, endpoints[zone]); err != nil {
+			return nil, err
+		}
+		links = append(links, neg.SelfLink)
+	}
+	return links, nil
+}
+
+// syncEndpoints attaches the given endpoints to the NEG of the given name and
+// zone, and detaches the others.
+func (p *Pool) syncEndpoints(name, zone string, desired map[string]*computealpha.NetworkEndpoint) error {
+	existing, err := p.cloud.ListNetworkEndpoints(name, zone, false)
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	var detach []*computealpha.NetworkEndpoint
+	for _, ep := range existing {
+		if ep.NetworkEndpoint == nil {
+			continue
+		}
+		if want, ok := desired[ep.NetworkEndpoint.Instance]; ok && want.IpAddress == ep.NetworkEndpoint.IpAddress {
+			current[ep.NetworkEndpoint.Instance] = true
+			continue
+		}
+		detach = append(detach, ep.NetworkEndpoint)
+	}
+	var attach []*computealpha.NetworkEndpoint
+	for instance, ep := range desired {
+		if !current[instance] {
+			attach = append(attach, ep)
+		}
+	}
+	// An instance whose IP changed is detached first.
+	for start := 0; start < len(detach); start += maxEndpointsPerBatch {
+		batch := detach[start:minInt(start+maxEndpointsPerBatch, len(detach))]
+		logging.V(2).Infof("Detaching %d endpoints from NEG %v in %v", len(batch), name, zone)
+		if err := p.cloud.DetachNetworkEndpoints(name, zone, batch); err != nil {
+			return err
+		}
+	}
+	sort.Slice(attach, func(i, j int) bool { return attach[i].Instance < attach[j].Instance })
+	for start := 0; start < len(attach); start += maxEndpointsPerBatch {
+		batch := attach[start:minInt(start+maxEndpointsPerBatch, len(attach))]
+		logging.V(2).Infof("Attaching %d endpoints to NEG %v in %v", len(batch), name, zone)
+		if err := p.cloud.AttachNetworkEndpoints(name, zone, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// ensureExternalBackendService creates or updates the given backend service
+// of an external network load balancer. Returns the backend service, and the
+// groups removed from its backends.
+func (p *Pool) ensureExternalBackendService(desired *BackendService) (*BackendService, []string, error) {
+	region := p.cloud.Region()
+	existing, err := p.cloud.GetExternalBackendService(desired.Name, region)
+	if utils.IsNotFoundError(err) {
+		logging.V(2).Infof("Creating backend service %v", desired.Name)
+		if err := p.cloud.CreateExternalBackendService(desired, region); err != nil {
+			return nil, nil, err
+		}
+		bs, err := p.cloud.GetExternalBackendService(desired.Name, region)
+		return bs, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := sets.NewString()
+	for _, be := range desired.Backends {
+		groups.Insert(resourcePath(be.Group))
+	}
+	var removed []string
+	existingGroups := sets.NewString()
+	for _, be := range existing.Backends {
+		existingGroups.Insert(resourcePath(be.Group))
+		if !groups.Has(resourcePath(be.Group)) {
+			removed = append(removed, be.Group)
+		}
+	}
+	if existing.Protocol == desired.Protocol &&
+		existing.SessionAffinity == desired.SessionAffinity &&
+		len(existing.HealthChecks) == 1 && sameResource(existing.HealthChecks[0], desired.HealthChecks[0]) &&
+		existingGroups.Equal(groups) &&
+		sameTrackingPolicy(existing.ConnectionTrackingPolicy, desired.ConnectionTrackingPolicy) &&
+		sameFailoverPolicy(existing.FailoverPolicy, desired.FailoverPolicy) {
+		return existing, nil, nil
+	}
+	logging.V(2).Infof("Updating backend service %v", desired.Name)
+	desired.Fingerprint = existing.Fingerprint
+	if err := p.cloud.UpdateExternalBackendService(desired, region); err != nil {
+		return nil, nil, err
+	}
+	bs, err := p.cloud.GetExternalBackendService(desired.Name, region)
+	return bs, removed, err
+}
+
+// sameTrackingPolicy returns true if the existing connection tracking
+// matches the desired one. GCE fills the unset fields with their defaults.
+func sameTrackingPolicy(existing, desired *annotations.ConnectionTrackingPolicy) bool {
+	withDefaults := func(policy *annotations.ConnectionTrackingPolicy) annotations.ConnectionTrackingPolicy {
+		p := annotations.ConnectionTrackingPolicy{}
+		if policy != nil {
+			p = *policy
+		}
+		if p.TrackingMode == "" {
+			p.TrackingMode = "PER_CONNECTION"
+		}
+		if p.ConnectionPersistenceOnUnhealthyBackends == "" {
+			p.ConnectionPersistenceOnUnhealthyBackends = "DEFAULT_FOR_PROTOCOL"
+		}
+		return p
+	}
+	e, d := withDefaults(existing), withDefaults(desired)
+	// The default idle timeout depends on the tracking mode.
+	if d.IdleTimeoutSec == 0 {
+		e.IdleTimeoutSec = 0
+	}
+	return e == d
+}
+
+// sameFailoverPolicy returns true if the existing failover policy matches
+// the desired one, unset if nil.
+func sameFailoverPolicy(existing, desired *annotations.FailoverPolicy) bool {
+	if existing == nil {
+		existing = &annotations.FailoverPolicy{}
+	}
+	if desired == nil {
+		desired = &annotations.FailoverPolicy{}
+	}
+	return reflect.DeepEqual(existing, desired)
+}
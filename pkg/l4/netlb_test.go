@@ -0,0 +1,164 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"testing"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+func newTestNode(name, zone, ip string) *api_v1.Node {
+	return &api_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: map[string]string{annotations.ZoneKey: zone}},
+		Status: api_v1.NodeStatus{
+			Addresses: []api_v1.NodeAddress{{Type: api_v1.NodeInternalIP, Address: ip}},
+		},
+	}
+}
+
+func TestEnsureDeleteNetLB(t *testing.T) {
+	pool, cloud, fw, _ := newTestPool()
+	svc := newILBService(api_v1.ServicePort{Port: 80, Protocol: api_v1.ProtocolUDP})
+	svc.Annotations = map[string]string{
+		annotations.ConnectionTrackingPolicyKey: `{"trackingMode": "PER_SESSION", "idleTimeoutSec": 300}`,
+		annotations.FailoverPolicyKey:           `{"dropTrafficIfUnhealthy": true}`,
+	}
+	name := pool.namer.L4(svc.Namespace, svc.Name)
+	nodes := []*api_v1.Node{
+		newTestNode("node-1", "us-central1-a", "10.128.0.2"),
+		newTestNode("node-2", "us-central1-b", "10.128.0.3"),
+		newTestNode("node-3", "us-central1-b", "10.128.0.4"),
+	}
+
+	status, err := pool.EnsureNetLB(svc, nodes)
+	if err != nil {
+		t.Fatalf("EnsureNetLB() = %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != "10.0.0.1" {
+		t.Errorf("EnsureNetLB() = %+v, want IP 10.0.0.1", status)
+	}
+	if _, ok := cloud.RegionHealthChecks[name]; !ok {
+		t.Errorf("regional health check %v not created", name)
+	}
+	bs := cloud.ExternalBackendServices[name]
+	if bs == nil || bs.LoadBalancingScheme != schemeExternal || bs.Protocol != "UDP" || len(bs.Backends) != 2 {
+		t.Fatalf("backend service %v = %+v, want an external UDP backend service of 2 NEGs", name, bs)
+	}
+	if bs.ConnectionTrackingPolicy == nil || bs.ConnectionTrackingPolicy.TrackingMode != "PER_SESSION" || bs.ConnectionTrackingPolicy.IdleTimeoutSec != 300 {
+		t.Errorf("connection tracking = %+v, want PER_SESSION with idle timeout 300", bs.ConnectionTrackingPolicy)
+	}
+	if bs.FailoverPolicy == nil || !bs.FailoverPolicy.DropTrafficIfUnhealthy {
+		t.Errorf("failover policy = %+v, want dropTrafficIfUnhealthy", bs.FailoverPolicy)
+	}
+	if neg := cloud.NEGs["us-central1-b/"+name]; neg == nil || neg.NetworkEndpointType != vmIPNetworkEndpointType {
+		t.Errorf("NEG %v = %+v, want a GCE_VM_IP NEG", name, neg)
+	}
+	if eps := cloud.Endpoints["us-central1-b/"+name]; len(eps) != 2 || eps["node-3"].IpAddress != "10.128.0.4" {
+		t.Errorf("endpoints of NEG %v = %v, want node-2 and node-3", name, eps)
+	}
+	if rule := cloud.ForwardingRules[name]; rule == nil || rule.LoadBalancingScheme != schemeExternal || rule.Subnetwork != "" {
+		t.Errorf("forwarding rule %v = %+v, want an external forwarding rule", name, rule)
+	}
+	hcFirewall, err := fw.GetFirewall(pool.namer.L4HealthCheckFirewall(svc.Namespace, svc.Name))
+	if err != nil || len(hcFirewall.SourceRanges) != 3 {
+		t.Errorf("health check firewall = %+v, %v, want the 3 ranges of the network load balancer health checks", hcFirewall, err)
+	}
+
+	// The nodes of zone a are gone, node-3 changed its IP.
+	nodes = []*api_v1.Node{
+		newTestNode("node-2", "us-central1-b", "10.128.0.3"),
+		newTestNode("node-3", "us-central1-b", "10.128.0.5"),
+	}
+	if _, err := pool.EnsureNetLB(svc, nodes); err != nil {
+		t.Fatalf("EnsureNetLB() = %v", err)
+	}
+	if bs := cloud.ExternalBackendServices[name]; len(bs.Backends) != 1 {
+		t.Errorf("backends = %v, want the NEG of zone b only", bs.Backends)
+	}
+	if _, ok := cloud.NEGs["us-central1-a/"+name]; ok {
+		t.Errorf("NEG %v of zone a not deleted", name)
+	}
+	if eps := cloud.Endpoints["us-central1-b/"+name]; eps["node-3"].IpAddress != "10.128.0.5" {
+		t.Errorf("endpoint of node-3 = %+v, want IP 10.128.0.5", eps["node-3"])
+	}
+
+	if err := pool.DeleteNetLB(svc, nil); err != nil {
+		t.Fatalf("DeleteNetLB() = %v", err)
+	}
+	if len(cloud.ForwardingRules)+len(cloud.ExternalBackendServices)+len(cloud.RegionHealthChecks)+len(cloud.NEGs) != 0 {
+		t.Errorf("resources left after DeleteNetLB(): %v %v %v %v", cloud.ForwardingRules, cloud.ExternalBackendServices, cloud.RegionHealthChecks, cloud.NEGs)
+	}
+	if _, err := fw.GetFirewall(name); !utils.IsNotFoundError(err) {
+		t.Errorf("GetFirewall(%v) = %v, want not found", name, err)
+	}
+	// Deleting again ignores the missing resources.
+	if err := pool.DeleteNetLB(svc, nodes); err != nil {
+		t.Errorf("second DeleteNetLB() = %v", err)
+	}
+}
+
+func TestEnsureNetLBInvalidPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		key, value string
+	}{
+		{annotations.ConnectionTrackingPolicyKey, `{"trackingMode": "PER_PACKET"}`},
+		{annotations.ConnectionTrackingPolicyKey, `{"connectionPersistenceOnUnhealthyBackends": "SOMETIMES"}`},
+		{annotations.ConnectionTrackingPolicyKey, `not json`},
+		{annotations.FailoverPolicyKey, `{"failoverRatio": 2}`},
+	} {
+		pool, _, _, _ := newTestPool()
+		svc := newILBService(api_v1.ServicePort{Port: 80})
+		svc.Annotations = map[string]string{tc.key: tc.value}
+		if _, err := pool.EnsureNetLB(svc, nil); err == nil {
+			t.Errorf("EnsureNetLB() with %v: %v = nil, want error", tc.key, tc.value)
+		}
+	}
+}
+
+func TestSameTrackingPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		desc              string
+		existing, desired *annotations.ConnectionTrackingPolicy
+		want              bool
+	}{
+		{"unset", nil, nil, true},
+		{"defaults filled by GCE", &annotations.ConnectionTrackingPolicy{TrackingMode: "PER_CONNECTION", ConnectionPersistenceOnUnhealthyBackends: "DEFAULT_FOR_PROTOCOL", IdleTimeoutSec: 600}, nil, true},
+		{"mode changed", &annotations.ConnectionTrackingPolicy{TrackingMode: "PER_CONNECTION"}, &annotations.ConnectionTrackingPolicy{TrackingMode: "PER_SESSION"}, false},
+		{"timeout changed", &annotations.ConnectionTrackingPolicy{IdleTimeoutSec: 600}, &annotations.ConnectionTrackingPolicy{IdleTimeoutSec: 300}, false},
+	} {
+		if got := sameTrackingPolicy(tc.existing, tc.desired); got != tc.want {
+			t.Errorf("%v: sameTrackingPolicy() = %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestNEGZone(t *testing.T) {
+	for link, want := range map[string]string{
+		"https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/networkEndpointGroups/neg": "us-central1-a",
+		"zones/us-central1-b/networkEndpointGroups/neg":                                                  "us-central1-b",
+		"zones/us-central1-b/instanceGroups/ig":                                                          "",
+	} {
+		if got := negZone(link); got != want {
+			t.Errorf("negZone(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// EnsureServiceAttachment creates or updates the given PSC service
+// attachment, publishing the internal load balancer of the given Service. The
+// NAT subnets of the service attachment are names of PSC subnets of the
+// region, in the network of the cluster. Returns the service attachment.
+func (p *Pool) EnsureServiceAttachment(desired *ServiceAttachment, svc *api_v1.Service) (*ServiceAttachment, error) {
+	region := p.cloud.Region()
+	rule, err := p.cloud.GetForwardingRule(p.namer.L4(svc.Namespace, svc.Name), region)
+	if utils.IsNotFoundError(err) {
+		return nil, fmt.Errorf("no internal load balancer for Service %v/%v", svc.Namespace, svc.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rule.LoadBalancingScheme != schemeInternal {
+		return nil, fmt.Errorf("the load balancer of Service %v/%v is not internal", svc.Namespace, svc.Name)
+	}
+	desired.TargetService = rule.SelfLink
+	var subnets []string
+	for _, subnet := range desired.NatSubnets {
+		subnets = append(subnets, subnetworkURL(p.cloud.NetworkURL(), p.cloud.NetworkProjectID(), region, subnet))
+	}
+	desired.NatSubnets = subnets
+	sort.Slice(desired.ConsumerAcceptLists, func(i, j int) bool {
+		return desired.ConsumerAcceptLists[i].ProjectIdOrNum < desired.ConsumerAcceptLists[j].ProjectIdOrNum
+	})
+	sort.Strings(desired.ConsumerRejectLists)
+
+	existing, err := p.cloud.GetServiceAttachment(desired.Name, region)
+	if err != nil && !utils.IsNotFoundError(err) {
+		return nil, err
+	}
+	if err == nil {
+		// The target service and the proxy protocol can't change, the
+		// consumers reconnect to the new service attachment.
+		if sameResource(existing.TargetService, desired.TargetService) && existing.EnableProxyProtocol == desired.EnableProxyProtocol {
+			if sameServiceAttachment(existing, desired) {
+				return existing, nil
+			}
+			logging.V(2).Infof("Updating service attachment %v", desired.Name)
+			desired.Fingerprint = existing.Fingerprint
+			if err := p.cloud.PatchServiceAttachment(desired, region); err != nil {
+				return nil, err
+			}
+			return p.cloud.GetServiceAttachment(desired.Name, region)
+		}
+		logging.V(2).Infof("Recreating service attachment %v", desired.Name)
+		if err := p.cloud.DeleteServiceAttachment(desired.Name, region); err != nil {
+			return nil, err
+		}
+	} else {
+		logging.V(2).Infof("Creating service attachment %v", desired.Name)
+	}
+	if err := p.cloud.CreateServiceAttachment(desired, region); err != nil {
+		return nil, err
+	}
+	return p.cloud.GetServiceAttachment(desired.Name, region)
+}
+
+// DeleteServiceAttachment deletes the PSC service attachment of the given
+// name, if it exists.
+func (p *Pool) DeleteServiceAttachment(name string) error {
+	logging.V(2).Infof("Deleting service attachment %v", name)
+	return utils.IgnoreHTTPNotFound(p.cloud.DeleteServiceAttachment(name, p.cloud.Region()))
+}
+
+// sameServiceAttachment returns true if the patchable fields of the given
+// service attachments are the same.
+func sameServiceAttachment(existing, desired *ServiceAttachment) bool {
+	subnets := func(links []string) sets.String {
+		s := sets.NewString()
+		for _, link := range links {
+			s.Insert(resourcePath(link))
+		}
+		return s
+	}
+	accepted := func(lists []*ServiceAttachmentConsumerProjectLimit) map[string]int64 {
+		m := map[string]int64{}
+		for _, l := range lists {
+			m[l.ProjectIdOrNum] = l.ConnectionLimit
+		}
+		return m
+	}
+	return existing.ConnectionPreference == desired.ConnectionPreference &&
+		subnets(existing.NatSubnets).Equal(subnets(desired.NatSubnets)) &&
+		reflect.DeepEqual(accepted(existing.ConsumerAcceptLists), accepted(desired.ConsumerAcceptLists)) &&
+		sets.NewString(existing.ConsumerRejectLists...).Equal(sets.NewString(desired.ConsumerRejectLists...))
+}
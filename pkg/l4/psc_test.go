@@ -0,0 +1,63 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"testing"
+
+	api_v1 "k8s.io/api/core/v1"
+)
+
+func TestEnsureServiceAttachment(t *testing.T) {
+	pool, cloud, _, _ := newTestPool()
+	svc := newILBService(api_v1.ServicePort{Port: 80})
+	desired := func() *ServiceAttachment {
+		return &ServiceAttachment{Name: "sa", ConnectionPreference: "ACCEPT_AUTOMATIC", NatSubnets: []string{"psc"}}
+	}
+	if _, err := pool.EnsureServiceAttachment(desired(), svc); err == nil {
+		t.Errorf("EnsureServiceAttachment() = nil, want error without internal load balancer")
+	}
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	sa, err := pool.EnsureServiceAttachment(desired(), svc)
+	if err != nil {
+		t.Fatalf("EnsureServiceAttachment() = %v", err)
+	}
+	fingerprint := sa.Fingerprint
+
+	// Nothing changed.
+	if sa, err = pool.EnsureServiceAttachment(desired(), svc); err != nil || sa.Fingerprint != fingerprint {
+		t.Errorf("EnsureServiceAttachment() = %+v, %v, want the unchanged service attachment", sa, err)
+	}
+	// The proxy protocol recreates the service attachment.
+	withProxy := desired()
+	withProxy.EnableProxyProtocol = true
+	if sa, err = pool.EnsureServiceAttachment(withProxy, svc); err != nil || !sa.EnableProxyProtocol {
+		t.Errorf("EnsureServiceAttachment() = %+v, %v, want the proxy protocol enabled", sa, err)
+	}
+
+	if err := pool.DeleteServiceAttachment("sa"); err != nil {
+		t.Fatalf("DeleteServiceAttachment() = %v", err)
+	}
+	if len(cloud.ServiceAttachments) != 0 {
+		t.Errorf("service attachments = %v, want none", cloud.ServiceAttachments)
+	}
+	if err := pool.DeleteServiceAttachment("sa"); err != nil {
+		t.Errorf("second DeleteServiceAttachment() = %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	// maxSubsetSizeCluster is the number of nodes in the NEGs of the
+	// internal load balancers of the Services whose traffic may go through
+	// any node.
+	maxSubsetSizeCluster = 25
+	// maxSubsetSizeLocal is the maximum number of nodes in the NEGs of the
+	// internal load balancers of the Services with the Local external
+	// traffic policy, which hold the nodes of their endpoints.
+	maxSubsetSizeLocal = 250
+)
+
+// EnsureILBSubsetting creates or updates the internal load balancer of the
+// given Service, whose backends are GCE_VM_IP NEGs of a subset of the given
+// nodes in each of their zones, and returns its status. The subset of the
+// Services with the Local external traffic policy is picked among the given
+// nodes of their endpoints. An internal load balancer of instance groups is
+// moved to NEGs, and the instance groups are released.
+func (p *Pool) EnsureILBSubsetting(svc *api_v1.Service, nodes []*api_v1.Node, endpointNodes sets.String) (*api_v1.LoadBalancerStatus, error) {
+	key := svc.Namespace + "/" + svc.Name
+	name := p.namer.L4(svc.Namespace, svc.Name)
+	desc := utils.Description{ServiceName: key, ClusterUID: p.namer.UID()}.String()
+	local := svc.Spec.ExternalTrafficPolicy == api_v1.ServiceExternalTrafficPolicyTypeLocal
+	endpoints := subsetEndpoints(key, nodeEndpoints(nodes), local, endpointNodes)
+	negs, err := p.ensureNEGs(name, desc, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	var nodeNames []string
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	status, err := p.ensureILB(svc, nodeNames, negs)
+	if err != nil {
+		return nil, err
+	}
+	p.instanceGroups.ReleaseL4InstanceGroups(key)
+	return status, nil
+}
+
+// subsetEndpoints returns the subset of the given endpoints, by zone and
+// instance, of the internal load balancer of the Service of the given key:
+// at most maxSubsetSizeCluster of them, or, if local, at most
+// maxSubsetSizeLocal of those of the given nodes. The endpoints are ranked by
+// a hash of the Service and the instance, so the subset is stable, a node
+// added or removed changes at most one node of the subset, and the Services
+// pick different subsets.
+func subsetEndpoints(key string, endpoints map[string]map[string]*computealpha.NetworkEndpoint, local bool, endpointNodes sets.String) map[string]map[string]*computealpha.NetworkEndpoint {
+	type ranked struct {
+		zone string
+		ep   *computealpha.NetworkEndpoint
+		rank string
+	}
+	var candidates []ranked
+	for zone, eps := range endpoints {
+		for instance, ep := range eps {
+			if local && !endpointNodes.Has(instance) {
+				continue
+			}
+			sum := sha256.Sum256([]byte(key + "/" + instance))
+			candidates = append(candidates, ranked{zone: zone, ep: ep, rank: hex.EncodeToString(sum[:])})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].rank < candidates[j].rank })
+	size := maxSubsetSizeCluster
+	if local {
+		size = maxSubsetSizeLocal
+	}
+	if len(candidates) > size {
+		candidates = candidates[:size]
+	}
+	subset := map[string]map[string]*computealpha.NetworkEndpoint{}
+	for _, c := range candidates {
+		if subset[c.zone] == nil {
+			subset[c.zone] = map[string]*computealpha.NetworkEndpoint{}
+		}
+		subset[c.zone][c.ep.Instance] = c.ep
+	}
+	return subset
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package l4
+
+import (
+	"fmt"
+	"testing"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestEnsureDeleteILBSubsetting(t *testing.T) {
+	pool, cloud, _, igs := newTestPool()
+	svc := newILBService(api_v1.ServicePort{Port: 80})
+	name := pool.namer.L4(svc.Namespace, svc.Name)
+	var nodes []*api_v1.Node
+	for i := 0; i < 40; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprintf("node-%d", i), fmt.Sprintf("us-central1-%c", 'a'+i%2), fmt.Sprintf("10.128.0.%d", i+2)))
+	}
+
+	// The Service starts on the instance groups.
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if _, err := pool.EnsureILBSubsetting(svc, nodes, nil); err != nil {
+		t.Fatalf("EnsureILBSubsetting() = %v", err)
+	}
+	bs := cloud.BackendServices[name]
+	if len(bs.Backends) != 2 || negZone(bs.Backends[0].Group) != "us-central1-a" || negZone(bs.Backends[1].Group) != "us-central1-b" {
+		t.Errorf("backends = %+v, want the NEGs of both zones", bs.Backends)
+	}
+	if igs.Users.Len() != 0 {
+		t.Errorf("instance groups users = %v, want the instance groups released", igs.Users.List())
+	}
+	subset := sets.NewString()
+	for _, zone := range []string{"us-central1-a", "us-central1-b"} {
+		if neg := cloud.NEGs[zone+"/"+name]; neg == nil || neg.NetworkEndpointType != vmIPNetworkEndpointType {
+			t.Errorf("NEG %v in %v = %+v, want a GCE_VM_IP NEG", name, zone, neg)
+		}
+		for instance := range cloud.Endpoints[zone+"/"+name] {
+			subset.Insert(instance)
+		}
+	}
+	if subset.Len() != maxSubsetSizeCluster {
+		t.Errorf("subset = %v, want %d nodes", subset.List(), maxSubsetSizeCluster)
+	}
+
+	// A node removed out of the subset changes one node of the subset.
+	var remaining []*api_v1.Node
+	for _, node := range nodes {
+		if node.Name != subset.List()[0] {
+			remaining = append(remaining, node)
+		}
+	}
+	if _, err := pool.EnsureILBSubsetting(svc, remaining, nil); err != nil {
+		t.Fatalf("EnsureILBSubsetting() = %v", err)
+	}
+	updated := sets.NewString()
+	for _, zone := range []string{"us-central1-a", "us-central1-b"} {
+		for instance := range cloud.Endpoints[zone+"/"+name] {
+			updated.Insert(instance)
+		}
+	}
+	if updated.Len() != maxSubsetSizeCluster || updated.Difference(subset).Len() != 1 {
+		t.Errorf("subset = %v, want one node replaced in %v", updated.List(), subset.List())
+	}
+
+	// The Service moves back to the instance groups.
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if len(cloud.NEGs) != 0 {
+		t.Errorf("NEGs = %v, want them deleted", cloud.NEGs)
+	}
+	if _, err := pool.EnsureILBSubsetting(svc, nodes, nil); err != nil {
+		t.Fatalf("EnsureILBSubsetting() = %v", err)
+	}
+	if err := pool.Delete(svc); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if len(cloud.NEGs)+len(cloud.BackendServices)+len(cloud.ForwardingRules) != 0 {
+		t.Errorf("NEGs %v, backend services %v and forwarding rules %v left", cloud.NEGs, cloud.BackendServices, cloud.ForwardingRules)
+	}
+}
+
+func TestSubsetEndpointsLocal(t *testing.T) {
+	var nodes []*api_v1.Node
+	for i := 0; i < 300; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprintf("node-%d", i), "us-central1-a", fmt.Sprintf("10.128.%d.%d", i/200, i%200+2)))
+	}
+	endpoints := nodeEndpoints(nodes)
+	if got := subsetEndpoints("default/svc", endpoints, true, sets.NewString("node-1", "node-2", "node-unknown")); len(got["us-central1-a"]) != 2 ||
+		got["us-central1-a"]["node-1"] == nil || got["us-central1-a"]["node-2"] == nil {
+		t.Errorf("subsetEndpoints() = %v, want the nodes of the endpoints", got)
+	}
+	all := sets.NewString()
+	for _, node := range nodes {
+		all.Insert(node.Name)
+	}
+	if got := subsetEndpoints("default/svc", endpoints, true, all); len(got["us-central1-a"]) != maxSubsetSizeLocal {
+		t.Errorf("subsetEndpoints() has %d nodes, want %d", len(got["us-central1-a"]), maxSubsetSizeLocal)
+	}
+	// The Services pick different subsets.
+	a := subsetEndpoints("default/a", endpoints, false, nil)["us-central1-a"]
+	b := subsetEndpoints("default/b", endpoints, false, nil)["us-central1-a"]
+	same := 0
+	for instance := range a {
+		if b[instance] != nil {
+			same++
+		}
+	}
+	if same == maxSubsetSizeCluster {
+		t.Errorf("subsetEndpoints() = %v for both Services, want different subsets", a)
+	}
+}
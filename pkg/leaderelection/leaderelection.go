@@ -0,0 +1,170 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderelection
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// LeaderElector acquires and renews a lease, so that a single candidate
+// leads at a time. The lease of another candidate expires once its record
+// did not change for the lease duration, as observed by the local clock, so
+// that clock skew between candidates doesn't matter.
+type LeaderElector struct {
+	resourceLock Lock
+	// leaseDuration is how long candidates wait before taking over a lease
+	// which is not renewed.
+	leaseDuration time.Duration
+	// renewDeadline is how long the leader retries renewing the lease
+	// before it gives up leading.
+	renewDeadline time.Duration
+	// retryPeriod is how long candidates wait between attempts.
+	retryPeriod time.Duration
+
+	recordLock sync.Mutex
+	// observedRecord is the last record read, observedTime when it was
+	// first read.
+	observedRecord LeaderElectionRecord
+	observedTime   time.Time
+	// released is true once the lease was released, it's never acquired
+	// again.
+	released bool
+	// now returns the current time, faked in tests.
+	now func() time.Time
+}
+
+// NewLeaderElector returns a LeaderElector using the given lock. The lease
+// duration must be greater than the renew deadline, itself greater than the
+// retry period.
+func NewLeaderElector(resourceLock Lock, leaseDuration, renewDeadline, retryPeriod time.Duration) (*LeaderElector, error) {
+	if retryPeriod <= 0 || renewDeadline <= retryPeriod || leaseDuration <= renewDeadline {
+		return nil, fmt.Errorf("leader election requires lease duration %v > renew deadline %v > retry period %v > 0", leaseDuration, renewDeadline, retryPeriod)
+	}
+	return &LeaderElector{
+		resourceLock:  resourceLock,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+		now:           time.Now,
+	}, nil
+}
+
+// Acquire blocks until the lease is acquired.
+func (le *LeaderElector) Acquire() {
+	logging.Infof("Attempting to acquire leader lease %v as %v", le.resourceLock.Describe(), le.resourceLock.Identity())
+	wait.PollImmediateInfinite(le.retryPeriod, func() (bool, error) {
+		return le.tryAcquireOrRenew(), nil
+	})
+	logging.Infof("Acquired leader lease %v", le.resourceLock.Describe())
+}
+
+// Renew renews the lease every retry period. It blocks until the lease
+// could not be renewed within the renew deadline, after which the candidate
+// must stop leading.
+func (le *LeaderElector) Renew() {
+	for {
+		err := wait.Poll(le.retryPeriod, le.renewDeadline, func() (bool, error) {
+			return le.tryAcquireOrRenew(), nil
+		})
+		if err != nil {
+			logging.Errorf("Failed to renew leader lease %v: %v", le.resourceLock.Describe(), err)
+			return
+		}
+	}
+}
+
+// Release gives up the lease if it is held, so that another candidate can
+// take over without waiting for the lease to expire.
+func (le *LeaderElector) Release() error {
+	le.recordLock.Lock()
+	defer le.recordLock.Unlock()
+	le.released = true
+	if le.observedRecord.HolderIdentity != le.resourceLock.Identity() {
+		return nil
+	}
+	record := le.observedRecord
+	record.HolderIdentity = ""
+	if err := le.resourceLock.Update(record); err != nil {
+		return err
+	}
+	le.observedRecord = record
+	logging.Infof("Released leader lease %v", le.resourceLock.Describe())
+	return nil
+}
+
+// tryAcquireOrRenew creates or updates the lease record, if it is held by
+// this candidate or expired. Returns true if this candidate holds the lease.
+func (le *LeaderElector) tryAcquireOrRenew() bool {
+	le.recordLock.Lock()
+	defer le.recordLock.Unlock()
+	if le.released {
+		return false
+	}
+	now := le.now()
+	identity := le.resourceLock.Identity()
+	// Records are serialized with a precision of a second.
+	recordTime := metav1.NewTime(now.Truncate(time.Second))
+	record := LeaderElectionRecord{
+		HolderIdentity:       identity,
+		LeaseDurationSeconds: int(le.leaseDuration / time.Second),
+		AcquireTime:          recordTime,
+		RenewTime:            recordTime,
+	}
+
+	existing, err := le.resourceLock.Get()
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			logging.Errorf("Failed to get leader lease %v: %v", le.resourceLock.Describe(), err)
+			return false
+		}
+		if err := le.resourceLock.Create(record); err != nil {
+			logging.Errorf("Failed to create leader lease %v: %v", le.resourceLock.Describe(), err)
+			return false
+		}
+		le.observedRecord, le.observedTime = record, now
+		return true
+	}
+
+	if !reflect.DeepEqual(le.observedRecord, *existing) {
+		le.observedRecord, le.observedTime = *existing, now
+	}
+	if existing.HolderIdentity != "" && existing.HolderIdentity != identity && le.observedTime.Add(le.leaseDuration).After(now) {
+		logging.V(4).Infof("Leader lease %v is held by %v", le.resourceLock.Describe(), existing.HolderIdentity)
+		return false
+	}
+	if existing.HolderIdentity == identity {
+		record.AcquireTime = existing.AcquireTime
+		record.LeaderTransitions = existing.LeaderTransitions
+	} else {
+		record.LeaderTransitions = existing.LeaderTransitions + 1
+	}
+	if err := le.resourceLock.Update(record); err != nil {
+		logging.Errorf("Failed to update leader lease %v: %v", le.resourceLock.Describe(), err)
+		return false
+	}
+	le.observedRecord, le.observedTime = record, now
+	return true
+}
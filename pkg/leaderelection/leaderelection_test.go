@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderelection
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElection(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	newCandidate := func(identity string) *LeaderElector {
+		le, err := NewLeaderElector(NewConfigMapLock(client, "kube-system", "ingress-gce-lock", identity), 15*time.Second, 10*time.Second, 2*time.Second)
+		if err != nil {
+			t.Fatalf("NewLeaderElector() = _, %v", err)
+		}
+		le.now = clock
+		return le
+	}
+	a, b := newCandidate("a"), newCandidate("b")
+	check := func(le *LeaderElector, want bool) {
+		t.Helper()
+		if got := le.tryAcquireOrRenew(); got != want {
+			t.Errorf("%v: tryAcquireOrRenew() = %v, want %v", le.resourceLock.Identity(), got, want)
+		}
+	}
+
+	// a creates the lease, b waits for it to expire.
+	check(a, true)
+	check(b, false)
+	now = now.Add(5 * time.Second)
+	check(a, true)
+	now = now.Add(time.Second)
+	check(b, false)
+	now = now.Add(14 * time.Second)
+	check(b, false)
+
+	// a stopped renewing, b takes over once the lease expired.
+	now = now.Add(2 * time.Second)
+	check(b, true)
+	check(a, false)
+	record, err := b.resourceLock.Get()
+	if err != nil {
+		t.Fatalf("Get() = _, %v", err)
+	}
+	if record.HolderIdentity != "b" || record.LeaderTransitions != 1 {
+		t.Errorf("Got record %+v, want holder b after 1 transition", record)
+	}
+
+	// A released lease is taken over right away, and never acquired again by
+	// the candidate which released it.
+	if err := b.Release(); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	check(a, true)
+	now = now.Add(time.Minute)
+	check(b, false)
+}
+
+func TestNewLeaderElectorDurations(t *testing.T) {
+	lock := NewConfigMapLock(fake.NewSimpleClientset(), "kube-system", "ingress-gce-lock", "a")
+	if _, err := NewLeaderElector(lock, 10*time.Second, 10*time.Second, 2*time.Second); err == nil {
+		t.Errorf("Expected an error for a renew deadline equal to the lease duration")
+	}
+	if _, err := NewLeaderElector(lock, 15*time.Second, 10*time.Second, 0); err == nil {
+		t.Errorf("Expected an error for a zero retry period")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderelection
+
+import (
+	"encoding/json"
+	"fmt"
+
+	api_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LeaderAnnotationKey is the annotation holding the leader election record on
+// the lock resource.
+const LeaderAnnotationKey = "control-plane.alpha.kubernetes.io/leader"
+
+// LeaderElectionRecord is the record of the current holder of the lease.
+type LeaderElectionRecord struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaderTransitions    int         `json:"leaderTransitions"`
+}
+
+// Lock is the interface of the resource holding the lease.
+type Lock interface {
+	// Get returns the current record, a NotFound error if the resource
+	// doesn't exist.
+	Get() (*LeaderElectionRecord, error)
+	// Create creates the resource with the given record.
+	Create(record LeaderElectionRecord) error
+	// Update replaces the record of the resource. It fails if the resource
+	// changed since the last Get.
+	Update(record LeaderElectionRecord) error
+	// Identity is the identity of this candidate.
+	Identity() string
+	// Describe describes the resource in logs.
+	Describe() string
+}
+
+// ConfigMapLock holds the lease in the annotation of a ConfigMap.
+type ConfigMapLock struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	identity  string
+	// cm is the ConfigMap read by the last Get. Its resource version guards
+	// Update against concurrent changes.
+	cm *api_v1.ConfigMap
+}
+
+// Ensure that ConfigMapLock implements Lock.
+var _ Lock = &ConfigMapLock{}
+
+// NewConfigMapLock returns a lock on the ConfigMap with the given namespace
+// and name, for the candidate with the given identity.
+func NewConfigMapLock(client kubernetes.Interface, namespace, name, identity string) *ConfigMapLock {
+	return &ConfigMapLock{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+	}
+}
+
+// Get returns the record of the ConfigMap.
+func (l *ConfigMapLock) Get() (*LeaderElectionRecord, error) {
+	cm, err := l.client.Core().ConfigMaps(l.namespace).Get(l.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	l.cm = cm
+	record := &LeaderElectionRecord{}
+	if value, ok := cm.Annotations[LeaderAnnotationKey]; ok {
+		if err := json.Unmarshal([]byte(value), record); err != nil {
+			return nil, fmt.Errorf("invalid leader election record %q on %v: %v", value, l.Describe(), err)
+		}
+	}
+	return record, nil
+}
+
+// Create creates the ConfigMap with the given record.
+func (l *ConfigMapLock) Create(record LeaderElectionRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	cm, err := l.client.Core().ConfigMaps(l.namespace).Create(&api_v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        l.name,
+			Namespace:   l.namespace,
+			Annotations: map[string]string{LeaderAnnotationKey: string(b)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	l.cm = cm
+	return nil
+}
+
+// Update replaces the record of the ConfigMap read by the last Get.
+func (l *ConfigMapLock) Update(record LeaderElectionRecord) error {
+	if l.cm == nil {
+		return errors.NewNotFound(api_v1.Resource("configmaps"), l.name)
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	cm := l.cm.DeepCopy()
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Annotations[LeaderAnnotationKey] = string(b)
+	if cm, err = l.client.Core().ConfigMaps(l.namespace).Update(cm); err != nil {
+		return err
+	}
+	l.cm = cm
+	return nil
+}
+
+// Identity returns the identity of the candidate.
+func (l *ConfigMapLock) Identity() string {
+	return l.identity
+}
+
+// Describe returns the namespace and name of the ConfigMap.
+func (l *ConfigMapLock) Describe() string {
+	return fmt.Sprintf("ConfigMap %v/%v", l.namespace, l.name)
+}
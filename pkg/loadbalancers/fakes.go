@@ -17,9 +17,12 @@ limitations under the License.
 package loadbalancers
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 
 	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	"k8s.io/ingress-gce/pkg/utils"
@@ -36,6 +39,11 @@ func (t *testIP) ip() string {
 	return fmt.Sprintf("0.0.0.%v", t.start)
 }
 
+func (t *testIP) ipv6() string {
+	t.start++
+	return fmt.Sprintf("2600:1901::%x", t.start)
+}
+
 // Loadbalancer fakes
 
 // FakeLoadBalancers is a type that fakes out the loadbalancer interface.
@@ -46,8 +54,10 @@ type FakeLoadBalancers struct {
 	Tps   []*compute.TargetHttpsProxy
 	IP    []*compute.Address
 	Certs []*compute.SslCertificate
-	name  string
-	calls []string // list of calls that were made
+	// RegionalIP are the static IPs of the fake region.
+	RegionalIP []*compute.Address
+	name       string
+	calls      []string // list of calls that were made
 
 	namer *utils.Namer
 }
@@ -288,6 +298,12 @@ func (f *FakeLoadBalancers) DeleteTargetHttpsProxy(name string) error {
 	return nil
 }
 
+// ListTargetHttpsProxies fakes out listing target https proxies.
+func (f *FakeLoadBalancers) ListTargetHttpsProxies() (*compute.TargetHttpsProxyList, error) {
+	f.calls = append(f.calls, "ListTargetHttpsProxies")
+	return &compute.TargetHttpsProxyList{Items: f.Tps}, nil
+}
+
 // SetUrlMapForTargetHttpsProxy fakes setting an url-map for a target http proxy.
 func (f *FakeLoadBalancers) SetUrlMapForTargetHttpsProxy(proxy *compute.TargetHttpsProxy, urlMap *compute.UrlMap) error {
 	f.calls = append(f.calls, "SetUrlMapForTargetHttpsProxy")
@@ -299,13 +315,13 @@ func (f *FakeLoadBalancers) SetUrlMapForTargetHttpsProxy(proxy *compute.TargetHt
 	return nil
 }
 
-// SetSslCertificateForTargetHttpsProxy fakes out setting certificates.
-func (f *FakeLoadBalancers) SetSslCertificateForTargetHttpsProxy(proxy *compute.TargetHttpsProxy, SSLCert *compute.SslCertificate) error {
-	f.calls = append(f.calls, "SetSslCertificateForTargetHttpsProxy")
+// setSslCertificatesForTargetHttpsProxy fakes out setting certificates.
+func (f *FakeLoadBalancers) setSslCertificatesForTargetHttpsProxy(proxy string, certLinks []string) error {
+	f.calls = append(f.calls, "SetSslCertificatesForTargetHttpsProxy")
 	found := false
 	for i := range f.Tps {
-		if f.Tps[i].Name == proxy.Name {
-			f.Tps[i].SslCertificates = []string{SSLCert.SelfLink}
+		if f.Tps[i].Name == proxy {
+			f.Tps[i].SslCertificates = certLinks
 			found = true
 		}
 	}
@@ -384,6 +400,9 @@ func (f *FakeLoadBalancers) CheckURLMap(l7 *L7, expectedMap map[string]utils.Fak
 // ReserveGlobalAddress fakes out static IP reservation.
 func (f *FakeLoadBalancers) ReserveGlobalAddress(addr *compute.Address) error {
 	f.calls = append(f.calls, "ReserveGlobalAddress")
+	if addr.Address == "" && addr.IpVersion == ipVersionIPv6 {
+		addr.Address = testIPManager.ipv6()
+	}
 	f.IP = append(f.IP, addr)
 	return nil
 }
@@ -399,6 +418,22 @@ func (f *FakeLoadBalancers) GetGlobalAddress(name string) (*compute.Address, err
 	return nil, utils.FakeGoogleAPINotFoundErr()
 }
 
+// GetRegionAddress fakes out regional static IP retrieval.
+func (f *FakeLoadBalancers) GetRegionAddress(name, region string) (*compute.Address, error) {
+	f.calls = append(f.calls, "GetRegionAddress")
+	for i := range f.RegionalIP {
+		if f.RegionalIP[i].Name == name && region == f.Region() {
+			return f.RegionalIP[i], nil
+		}
+	}
+	return nil, utils.FakeGoogleAPINotFoundErr()
+}
+
+// Region returns the region of the fake load balancers.
+func (f *FakeLoadBalancers) Region() string {
+	return "us-central1"
+}
+
 // DeleteGlobalAddress fakes out static IP deletion.
 func (f *FakeLoadBalancers) DeleteGlobalAddress(name string) error {
 	f.calls = append(f.calls, "DeleteGlobalAddress")
@@ -433,6 +468,12 @@ func (f *FakeLoadBalancers) CreateSslCertificate(cert *compute.SslCertificate) (
 	return cert, nil
 }
 
+// ListSslCertificates fakes out listing certificates.
+func (f *FakeLoadBalancers) ListSslCertificates() (*compute.SslCertificateList, error) {
+	f.calls = append(f.calls, "ListSslCertificates")
+	return &compute.SslCertificateList{Items: f.Certs}, nil
+}
+
 // DeleteSslCertificate fakes out certificate deletion.
 func (f *FakeLoadBalancers) DeleteSslCertificate(name string) error {
 	f.calls = append(f.calls, "DeleteSslCertificate")
@@ -456,3 +497,209 @@ func NewFakeLoadBalancers(name string) *FakeLoadBalancers {
 		namer: utils.NewNamer("fake-cluster", "fake-fw"),
 	}
 }
+
+// NewFakeTargetHttpsProxies returns fake target HTTPS proxy settings for the
+// proxies of the given fake load balancers, with the given existing SSL
+// policy names.
+func NewFakeTargetHttpsProxies(lbs *FakeLoadBalancers, names ...string) *FakeTargetHttpsProxies {
+	f := &FakeTargetHttpsProxies{
+		lbs:      lbs,
+		policies: map[string]*SslPolicy{},
+		attached: map[string]string{},
+		quic:     map[string]string{},
+		managed:  map[string]*SslCertificate{},
+		certMaps: map[string]string{},
+	}
+	for _, name := range names {
+		f.policies[name] = &SslPolicy{Name: name, SelfLink: "global/sslPolicies/" + name}
+	}
+	return f
+}
+
+// FakeTargetHttpsProxies fakes out the GCE target HTTPS proxy settings not
+// supported by FakeLoadBalancers.
+type FakeTargetHttpsProxies struct {
+	lbs      *FakeLoadBalancers
+	policies map[string]*SslPolicy
+	// attached maps target HTTPS proxy names to SSL policy links.
+	attached map[string]string
+	// quic maps target HTTPS proxy names to QUIC overrides.
+	quic map[string]string
+	// managed are the Google-managed certificates, by name. They are also in
+	// the fake load balancers, so that they are deleted through them.
+	managed map[string]*SslCertificate
+	// certMaps maps target HTTPS proxy names to certificate map names.
+	certMaps map[string]string
+}
+
+// GetSslPolicy fakes getting an SSL policy.
+func (f *FakeTargetHttpsProxies) GetSslPolicy(name string) (*SslPolicy, error) {
+	policy, ok := f.policies[name]
+	if !ok {
+		return nil, utils.FakeGoogleAPINotFoundErr()
+	}
+	return policy, nil
+}
+
+// GetTargetHttpsProxySslPolicy fakes getting the SSL policy of a target HTTPS
+// proxy.
+func (f *FakeTargetHttpsProxies) GetTargetHttpsProxySslPolicy(proxy string) (string, error) {
+	return f.attached[proxy], nil
+}
+
+// SetTargetHttpsProxySslPolicy fakes setting the SSL policy of a target HTTPS
+// proxy.
+func (f *FakeTargetHttpsProxies) SetTargetHttpsProxySslPolicy(proxy, policyLink string) error {
+	if policyLink == "" {
+		delete(f.attached, proxy)
+		return nil
+	}
+	f.attached[proxy] = policyLink
+	return nil
+}
+
+// SetTargetHttpsProxySslCertificates fakes setting the certificates of a
+// target HTTPS proxy.
+func (f *FakeTargetHttpsProxies) SetTargetHttpsProxySslCertificates(proxy string, certLinks []string) error {
+	return f.lbs.setSslCertificatesForTargetHttpsProxy(proxy, certLinks)
+}
+
+// GetTargetHttpsProxyQuicOverride fakes getting the QUIC override of a target
+// HTTPS proxy.
+func (f *FakeTargetHttpsProxies) GetTargetHttpsProxyQuicOverride(proxy string) (string, error) {
+	return f.quic[proxy], nil
+}
+
+// SetTargetHttpsProxyQuicOverride fakes setting the QUIC override of a target
+// HTTPS proxy.
+func (f *FakeTargetHttpsProxies) SetTargetHttpsProxyQuicOverride(proxy, quicOverride string) error {
+	f.quic[proxy] = quicOverride
+	return nil
+}
+
+// NewFakeExtendedUrlMaps returns fake URL maps, in the given fake load
+// balancers.
+func NewFakeExtendedUrlMaps(lbs *FakeLoadBalancers) *FakeExtendedUrlMaps {
+	return &FakeExtendedUrlMaps{lbs: lbs, UrlMaps: map[string]*UrlMap{}}
+}
+
+// FakeExtendedUrlMaps fakes out the URL maps using features not supported by
+// FakeLoadBalancers. The URL maps are also in the fake load balancers, without
+// those features, so that they are deleted through the fake load balancers.
+type FakeExtendedUrlMaps struct {
+	lbs *FakeLoadBalancers
+	// UrlMaps are the URL maps created or updated through the fake, by name.
+	UrlMaps map[string]*UrlMap
+}
+
+// GetExtendedUrlMap fakes getting a URL map.
+func (f *FakeExtendedUrlMaps) GetExtendedUrlMap(name string) (*UrlMap, error) {
+	um, err := f.lbs.GetUrlMap(name)
+	if err != nil {
+		delete(f.UrlMaps, name)
+		return nil, err
+	}
+	if existing, ok := f.UrlMaps[name]; ok {
+		copy := *existing
+		return &copy, nil
+	}
+	// The URL maps created through the fake load balancers have none of the
+	// features of the fake.
+	ret := &UrlMap{}
+	data, _ := um.MarshalJSON()
+	json.Unmarshal(data, ret)
+	return ret, nil
+}
+
+// CreateExtendedUrlMap fakes creating a URL map.
+func (f *FakeExtendedUrlMaps) CreateExtendedUrlMap(urlMap *UrlMap) error {
+	if um, _ := f.lbs.GetUrlMap(urlMap.Name); um != nil {
+		return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("url map %v already exists", urlMap.Name)}
+	}
+	copy := *urlMap
+	copy.SelfLink = urlMap.Name
+	f.UrlMaps[urlMap.Name] = &copy
+	f.lbs.Um = append(f.lbs.Um, &compute.UrlMap{Name: copy.Name, Description: copy.Description, DefaultService: copy.DefaultService, SelfLink: copy.SelfLink})
+	return nil
+}
+
+// UpdateExtendedUrlMap fakes updating a URL map.
+func (f *FakeExtendedUrlMaps) UpdateExtendedUrlMap(urlMap *UrlMap) error {
+	um, err := f.lbs.GetUrlMap(urlMap.Name)
+	if err != nil {
+		return err
+	}
+	f.lbs.calls = append(f.lbs.calls, "UpdateUrlMap")
+	copy := *urlMap
+	copy.SelfLink = um.SelfLink
+	f.UrlMaps[urlMap.Name] = &copy
+	// The fake load balancers get the URL map without those features.
+	vum := &compute.UrlMap{}
+	data, _ := json.Marshal(&copy)
+	json.Unmarshal(data, vum)
+	*um = *vum
+	return nil
+}
+
+// CreateTargetHttpsProxyWithCertificateMap fakes creating a target HTTPS
+// proxy serving a certificate map.
+func (f *FakeTargetHttpsProxies) CreateTargetHttpsProxyWithCertificateMap(proxy *TargetHttpsProxy) error {
+	if err := f.lbs.CreateTargetHttpsProxy(&compute.TargetHttpsProxy{Name: proxy.Name, Description: proxy.Description, UrlMap: proxy.UrlMap}); err != nil {
+		return err
+	}
+	f.certMaps[proxy.Name] = proxy.CertificateMap
+	return nil
+}
+
+// GetTargetHttpsProxyCertificateMap fakes getting the certificate map of a
+// target HTTPS proxy.
+func (f *FakeTargetHttpsProxies) GetTargetHttpsProxyCertificateMap(proxy string) (string, error) {
+	return f.certMaps[proxy], nil
+}
+
+// SetTargetHttpsProxyCertificateMap fakes setting the certificate map of a
+// target HTTPS proxy.
+func (f *FakeTargetHttpsProxies) SetTargetHttpsProxyCertificateMap(proxy, certificateMap string) error {
+	if certificateMap == "" {
+		delete(f.certMaps, proxy)
+		return nil
+	}
+	f.certMaps[proxy] = certificateMap
+	return nil
+}
+
+// GetManagedSslCertificate fakes getting a Google-managed certificate.
+func (f *FakeTargetHttpsProxies) GetManagedSslCertificate(name string) (*SslCertificate, error) {
+	if _, err := f.lbs.GetSslCertificate(name); err != nil {
+		delete(f.managed, name)
+		return nil, err
+	}
+	cert, ok := f.managed[name]
+	if !ok {
+		return nil, utils.FakeGoogleAPINotFoundErr()
+	}
+	return cert, nil
+}
+
+// CreateManagedSslCertificate fakes creating a Google-managed certificate,
+// which starts provisioning.
+func (f *FakeTargetHttpsProxies) CreateManagedSslCertificate(cert *SslCertificate) error {
+	if _, ok := f.managed[cert.Name]; ok {
+		return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("ssl certificate %v already exists", cert.Name)}
+	}
+	copy := *cert
+	copy.Managed = &ManagedSslCertificate{Domains: cert.Managed.Domains, Status: "PROVISIONING"}
+	f.managed[cert.Name] = &copy
+	_, err := f.lbs.CreateSslCertificate(&compute.SslCertificate{Name: copy.Name, Description: copy.Description})
+	copy.SelfLink = copy.Name
+	return err
+}
+
+// SetManagedSslCertificateStatus sets the provisioning status of the given
+// fake Google-managed certificate.
+func (f *FakeTargetHttpsProxies) SetManagedSslCertificateStatus(name, status string, domainStatus map[string]string) {
+	if cert, ok := f.managed[name]; ok {
+		cert.Managed.Status = status
+		cert.Managed.DomainStatus = domainStatus
+	}
+}
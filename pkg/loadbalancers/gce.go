@@ -0,0 +1,331 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"golang.org/x/oauth2"
+
+	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// UrlMap is the part of a URL map which the vendored compute API predates,
+// managed through the REST API.
+type UrlMap struct {
+	Name           string `json:"name"`
+	Description    string `json:"description,omitempty"`
+	DefaultService string `json:"defaultService,omitempty"`
+	// DefaultUrlRedirect redirects the requests instead of serving them from
+	// DefaultService.
+	DefaultUrlRedirect *HttpRedirectAction `json:"defaultUrlRedirect,omitempty"`
+	HostRules          []*HostRule         `json:"hostRules,omitempty"`
+	PathMatchers       []*PathMatcher      `json:"pathMatchers,omitempty"`
+	Fingerprint        string              `json:"fingerprint,omitempty"`
+	SelfLink           string              `json:"selfLink,omitempty"`
+}
+
+// HostRule routes the requests of the given hosts with a path matcher.
+type HostRule struct {
+	Hosts       []string `json:"hosts"`
+	PathMatcher string   `json:"pathMatcher"`
+}
+
+// PathMatcher routes the requests of a host rule by path, with either path
+// rules or route rules.
+type PathMatcher struct {
+	Name           string           `json:"name"`
+	DefaultService string           `json:"defaultService,omitempty"`
+	PathRules      []*PathRule      `json:"pathRules,omitempty"`
+	RouteRules     []*HttpRouteRule `json:"routeRules,omitempty"`
+}
+
+// PathRule routes the requests of the given paths, to a backend service or
+// with a redirect.
+type PathRule struct {
+	Paths       []string            `json:"paths"`
+	Service     string              `json:"service,omitempty"`
+	RouteAction *HttpRouteAction    `json:"routeAction,omitempty"`
+	UrlRedirect *HttpRedirectAction `json:"urlRedirect,omitempty"`
+}
+
+// HttpRouteRule routes the requests matching any of its match rules, to a
+// backend service or with a redirect. The rule with the lowest priority wins.
+type HttpRouteRule struct {
+	Priority     int64               `json:"priority"`
+	MatchRules   []utils.RouteMatch  `json:"matchRules,omitempty"`
+	Service      string              `json:"service,omitempty"`
+	RouteAction  *HttpRouteAction    `json:"routeAction,omitempty"`
+	UrlRedirect  *HttpRedirectAction `json:"urlRedirect,omitempty"`
+	HeaderAction *utils.HeaderAction `json:"headerAction,omitempty"`
+}
+
+// HttpRouteAction is what a URL map does with the requests it forwards.
+type HttpRouteAction struct {
+	// WeightedBackendServices split the requests between backend services,
+	// instead of the service of the path rule.
+	WeightedBackendServices []*WeightedBackendService `json:"weightedBackendServices,omitempty"`
+	UrlRewrite              *UrlRewrite               `json:"urlRewrite,omitempty"`
+}
+
+// WeightedBackendService is a backend service getting a share of the requests
+// proportional to its weight.
+type WeightedBackendService struct {
+	BackendService string `json:"backendService"`
+	Weight         int64  `json:"weight"`
+}
+
+// UrlRewrite rewrites the requests forwarded by a URL map.
+type UrlRewrite struct {
+	PathPrefixRewrite string `json:"pathPrefixRewrite,omitempty"`
+	HostRewrite       string `json:"hostRewrite,omitempty"`
+}
+
+// HttpRedirectAction is a redirect of a URL map.
+type HttpRedirectAction struct {
+	HostRedirect   string `json:"hostRedirect,omitempty"`
+	PathRedirect   string `json:"pathRedirect,omitempty"`
+	PrefixRedirect string `json:"prefixRedirect,omitempty"`
+	HttpsRedirect  bool   `json:"httpsRedirect,omitempty"`
+	StripQuery     bool   `json:"stripQuery,omitempty"`
+	// RedirectResponseCode is one of MOVED_PERMANENTLY_DEFAULT, FOUND,
+	// SEE_OTHER, TEMPORARY_REDIRECT or PERMANENT_REDIRECT.
+	RedirectResponseCode string `json:"redirectResponseCode,omitempty"`
+}
+
+// TargetHttpsProxy is a target HTTPS proxy, with the SSL policy, QUIC
+// override and certificate map which the vendored compute API predates,
+// managed through the REST API.
+type TargetHttpsProxy struct {
+	Name            string   `json:"name"`
+	Description     string   `json:"description,omitempty"`
+	UrlMap          string   `json:"urlMap,omitempty"`
+	SslCertificates []string `json:"sslCertificates,omitempty"`
+	// SslPolicy is the link of the attached SSL policy, empty if none.
+	SslPolicy string `json:"sslPolicy,omitempty"`
+	// QuicOverride is NONE, ENABLE or DISABLE.
+	QuicOverride string `json:"quicOverride,omitempty"`
+	// CertificateMap is the link of the certificate map, eg:
+	// //certificatemanager.googleapis.com/projects/p/locations/global/certificateMaps/m.
+	// Through the TargetHttpsProxies interface, it is the name of the map.
+	CertificateMap string `json:"certificateMap,omitempty"`
+	SelfLink       string `json:"selfLink,omitempty"`
+}
+
+// SslPolicy is an SSL policy of target HTTPS proxies.
+type SslPolicy struct {
+	Name string `json:"name"`
+	// MinTlsVersion is TLS_1_0, TLS_1_1 or TLS_1_2.
+	MinTlsVersion string `json:"minTlsVersion,omitempty"`
+	SelfLink      string `json:"selfLink,omitempty"`
+}
+
+// SslCertificate is a Google-managed SSL certificate, which the vendored
+// compute API predates, managed through the REST API.
+type SslCertificate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Type is MANAGED for Google-managed certificates.
+	Type     string                 `json:"type,omitempty"`
+	Managed  *ManagedSslCertificate `json:"managed,omitempty"`
+	SelfLink string                 `json:"selfLink,omitempty"`
+}
+
+// ManagedSslCertificate is the configuration and the status of a
+// Google-managed certificate.
+type ManagedSslCertificate struct {
+	Domains []string `json:"domains,omitempty"`
+	// Status is the provisioning status of the certificate, eg:
+	// PROVISIONING or ACTIVE.
+	Status string `json:"status,omitempty"`
+	// DomainStatus is the provisioning status of each domain, eg:
+	// FAILED_NOT_VISIBLE if the domain does not resolve to the load
+	// balancer.
+	DomainStatus map[string]string `json:"domainStatus,omitempty"`
+}
+
+// gceTargetHttpsProxies implements TargetHttpsProxies through the REST API.
+type gceTargetHttpsProxies struct {
+	backends.ProjectProvider
+	rest *utils.ComputeREST
+}
+
+// NewGCETargetHttpsProxies returns a TargetHttpsProxies that manages the
+// proxies of the project through the REST API. tokenSource, transport and
+// apiEndpoint are those of utils.NewComputeREST.
+// project: the cloud provider, used for the project of the cluster.
+func NewGCETargetHttpsProxies(project backends.ProjectProvider, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (TargetHttpsProxies, error) {
+	rest, err := utils.NewComputeREST(project.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceTargetHttpsProxies{ProjectProvider: project, rest: rest}, nil
+}
+
+// GetSslPolicy returns the SSL policy with the given name.
+func (g *gceTargetHttpsProxies) GetSslPolicy(name string) (*SslPolicy, error) {
+	policy := &SslPolicy{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("sslPolicies", name), nil, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// getTargetHttpsProxy returns the given target HTTPS proxy.
+func (g *gceTargetHttpsProxies) getTargetHttpsProxy(proxy string) (*TargetHttpsProxy, error) {
+	tps := &TargetHttpsProxy{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("targetHttpsProxies", proxy), nil, tps); err != nil {
+		return nil, err
+	}
+	return tps, nil
+}
+
+// GetTargetHttpsProxySslPolicy returns the link of the SSL policy attached to
+// the given target HTTPS proxy, empty if none.
+func (g *gceTargetHttpsProxies) GetTargetHttpsProxySslPolicy(proxy string) (string, error) {
+	tps, err := g.getTargetHttpsProxy(proxy)
+	if err != nil {
+		return "", err
+	}
+	return tps.SslPolicy, nil
+}
+
+// SetTargetHttpsProxySslPolicy attaches the SSL policy with the given link to
+// the target HTTPS proxy. An empty link detaches the current policy.
+func (g *gceTargetHttpsProxies) SetTargetHttpsProxySslPolicy(proxy, policyLink string) error {
+	req := map[string]interface{}{"sslPolicy": nil}
+	if policyLink != "" {
+		req["sslPolicy"] = policyLink
+	}
+	return g.rest.DoOp("POST", g.rest.GlobalURL("targetHttpsProxies", proxy)+"/setSslPolicy", req)
+}
+
+// SetTargetHttpsProxySslCertificates sets the certificates with the given
+// links, in order, on the target HTTPS proxy.
+func (g *gceTargetHttpsProxies) SetTargetHttpsProxySslCertificates(proxy string, certLinks []string) error {
+	req := map[string]interface{}{"sslCertificates": certLinks}
+	return g.rest.DoOp("POST", g.rest.GlobalURL("targetHttpsProxies", proxy)+"/setSslCertificates", req)
+}
+
+// GetTargetHttpsProxyQuicOverride returns the QUIC override of the given target
+// HTTPS proxy.
+func (g *gceTargetHttpsProxies) GetTargetHttpsProxyQuicOverride(proxy string) (string, error) {
+	tps, err := g.getTargetHttpsProxy(proxy)
+	if err != nil {
+		return "", err
+	}
+	return tps.QuicOverride, nil
+}
+
+// SetTargetHttpsProxyQuicOverride sets the QUIC override of the target HTTPS
+// proxy.
+func (g *gceTargetHttpsProxies) SetTargetHttpsProxyQuicOverride(proxy, quicOverride string) error {
+	req := map[string]interface{}{"quicOverride": quicOverride}
+	return g.rest.DoOp("POST", g.rest.GlobalURL("targetHttpsProxies", proxy)+"/setQuicOverride", req)
+}
+
+// certificateMapLink returns the link of the certificate map with the given
+// name, in the project of the cluster.
+func (g *gceTargetHttpsProxies) certificateMapLink(name string) string {
+	return fmt.Sprintf("//certificatemanager.googleapis.com/projects/%v/locations/global/certificateMaps/%v", g.ProjectID(), name)
+}
+
+// CreateTargetHttpsProxyWithCertificateMap creates the given target HTTPS
+// proxy, serving the certificate map of the given name instead of SSL
+// certificates.
+func (g *gceTargetHttpsProxies) CreateTargetHttpsProxyWithCertificateMap(proxy *TargetHttpsProxy) error {
+	req := *proxy
+	req.CertificateMap = g.certificateMapLink(proxy.CertificateMap)
+	return g.rest.DoOp("POST", g.rest.GlobalURL("targetHttpsProxies", ""), &req)
+}
+
+// GetTargetHttpsProxyCertificateMap returns the name of the certificate map
+// of the given target HTTPS proxy, empty if none.
+func (g *gceTargetHttpsProxies) GetTargetHttpsProxyCertificateMap(proxy string) (string, error) {
+	tps, err := g.getTargetHttpsProxy(proxy)
+	if err != nil {
+		return "", err
+	}
+	if tps.CertificateMap == "" {
+		return "", nil
+	}
+	return path.Base(tps.CertificateMap), nil
+}
+
+// SetTargetHttpsProxyCertificateMap attaches the certificate map of the given
+// name to the target HTTPS proxy. An empty name detaches the current map.
+func (g *gceTargetHttpsProxies) SetTargetHttpsProxyCertificateMap(proxy, certificateMap string) error {
+	req := map[string]string{}
+	if certificateMap != "" {
+		req["certificateMap"] = g.certificateMapLink(certificateMap)
+	}
+	return g.rest.DoOp("POST", g.rest.GlobalURL("targetHttpsProxies", proxy)+"/setCertificateMap", req)
+}
+
+// GetManagedSslCertificate returns the given Google-managed certificate.
+func (g *gceTargetHttpsProxies) GetManagedSslCertificate(name string) (*SslCertificate, error) {
+	cert := &SslCertificate{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("sslCertificates", name), nil, cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// CreateManagedSslCertificate creates the given Google-managed certificate.
+func (g *gceTargetHttpsProxies) CreateManagedSslCertificate(cert *SslCertificate) error {
+	return g.rest.DoOp("POST", g.rest.GlobalURL("sslCertificates", ""), cert)
+}
+
+// gceExtendedUrlMaps implements ExtendedUrlMaps through the REST API.
+type gceExtendedUrlMaps struct {
+	rest *utils.ComputeREST
+}
+
+// NewGCEExtendedUrlMaps returns an ExtendedUrlMaps managing the URL maps of
+// the project through the REST API. tokenSource, transport and apiEndpoint
+// are those of utils.NewComputeREST.
+// project: the cloud provider, used for the project of the cluster.
+func NewGCEExtendedUrlMaps(project backends.ProjectProvider, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (ExtendedUrlMaps, error) {
+	rest, err := utils.NewComputeREST(project.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceExtendedUrlMaps{rest: rest}, nil
+}
+
+// GetExtendedUrlMap returns the given URL map.
+func (g *gceExtendedUrlMaps) GetExtendedUrlMap(name string) (*UrlMap, error) {
+	um := &UrlMap{}
+	if err := g.rest.Do("GET", g.rest.GlobalURL("urlMaps", name), nil, um); err != nil {
+		return nil, err
+	}
+	return um, nil
+}
+
+// CreateExtendedUrlMap creates the given URL map.
+func (g *gceExtendedUrlMaps) CreateExtendedUrlMap(urlMap *UrlMap) error {
+	return g.rest.DoOp("POST", g.rest.GlobalURL("urlMaps", ""), urlMap)
+}
+
+// UpdateExtendedUrlMap replaces the URL map of the same name with the given
+// one. Its fingerprint must be the one of the existing URL map.
+func (g *gceExtendedUrlMaps) UpdateExtendedUrlMap(urlMap *UrlMap) error {
+	return g.rest.DoOp("PUT", g.rest.GlobalURL("urlMaps", urlMap.Name), urlMap)
+}
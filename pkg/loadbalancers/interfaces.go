@@ -25,6 +25,8 @@ import (
 // because none of them are usable (or acquirable) stand-alone, unlinke backends
 // and instance groups. The dependency graph:
 // ForwardingRule -> UrlMaps -> TargetProxies
+// The regional load balancers, internal or external, are managed by
+// pkg/regional.
 type LoadBalancers interface {
 	// Forwarding Rules
 	GetGlobalForwardingRule(name string) (*compute.ForwardingRule, error)
@@ -49,18 +51,51 @@ type LoadBalancers interface {
 	CreateTargetHttpsProxy(proxy *compute.TargetHttpsProxy) error
 	DeleteTargetHttpsProxy(name string) error
 	SetUrlMapForTargetHttpsProxy(proxy *compute.TargetHttpsProxy, urlMap *compute.UrlMap) error
-	SetSslCertificateForTargetHttpsProxy(proxy *compute.TargetHttpsProxy, SSLCerts *compute.SslCertificate) error
+	ListTargetHttpsProxies() (*compute.TargetHttpsProxyList, error)
 
 	// SslCertificates
 	GetSslCertificate(name string) (*compute.SslCertificate, error)
 	CreateSslCertificate(certs *compute.SslCertificate) (*compute.SslCertificate, error)
 	DeleteSslCertificate(name string) error
+	ListSslCertificates() (*compute.SslCertificateList, error)
 
 	// Static IP
 
 	ReserveGlobalAddress(addr *compute.Address) error
 	GetGlobalAddress(name string) (*compute.Address, error)
 	DeleteGlobalAddress(name string) error
+	// GetRegionAddress is only used to tell a regional static IP apart from
+	// a missing one, since regional IPs can't be used by L7 loadbalancers.
+	GetRegionAddress(name, region string) (*compute.Address, error)
+	Region() string
+}
+
+// TargetHttpsProxies is an interface for the settings of target HTTPS
+// proxies the LoadBalancers interface does not support: SSL policies, QUIC,
+// several certificates per proxy, certificate maps and Google-managed
+// certificates. The proxies and the managed certificates are deleted through
+// LoadBalancers.
+type TargetHttpsProxies interface {
+	GetSslPolicy(name string) (*SslPolicy, error)
+	GetTargetHttpsProxySslPolicy(proxy string) (string, error)
+	SetTargetHttpsProxySslPolicy(proxy, policyLink string) error
+	SetTargetHttpsProxySslCertificates(proxy string, certLinks []string) error
+	GetTargetHttpsProxyQuicOverride(proxy string) (string, error)
+	SetTargetHttpsProxyQuicOverride(proxy, quicOverride string) error
+	CreateTargetHttpsProxyWithCertificateMap(proxy *TargetHttpsProxy) error
+	GetTargetHttpsProxyCertificateMap(proxy string) (string, error)
+	SetTargetHttpsProxyCertificateMap(proxy, certificateMap string) error
+	GetManagedSslCertificate(name string) (*SslCertificate, error)
+	CreateManagedSslCertificate(cert *SslCertificate) error
+}
+
+// ExtendedUrlMaps is an interface for the URL maps using features the
+// LoadBalancers interface does not support, eg: redirects. The URL maps are
+// deleted through LoadBalancers.
+type ExtendedUrlMaps interface {
+	GetExtendedUrlMap(name string) (*UrlMap, error)
+	CreateExtendedUrlMap(urlMap *UrlMap) error
+	UpdateExtendedUrlMap(urlMap *UrlMap) error
 }
 
 // LoadBalancerPool is an interface to manage the cloud resources associated
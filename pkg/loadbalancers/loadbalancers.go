@@ -18,19 +18,21 @@ package loadbalancers
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 
-	"github.com/golang/glog"
-
 	compute "google.golang.org/api/compute/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/frontendconfig"
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/ingress-gce/pkg/storage"
 	"k8s.io/ingress-gce/pkg/utils"
 )
@@ -50,44 +52,117 @@ const (
 
 	httpDefaultPortRange  = "80-80"
 	httpsDefaultPortRange = "443-443"
+
+	// ipVersionIPv6 is the IP version of IPv6 addresses.
+	ipVersionIPv6 = "IPV6"
+
+	// defaultRedirectResponseCode is the response code of the redirects to
+	// HTTPS, 301.
+	defaultRedirectResponseCode = "MOVED_PERMANENTLY_DEFAULT"
+
+	// MaxSSLCerts is the maximum number of certificates GCE allows on a
+	// target HTTPS proxy.
+	MaxSSLCerts = 15
+
+	// managedCertType is the type of Google-managed certificates.
+	managedCertType = "MANAGED"
+	// managedCertStatusActive is the status of provisioned managed
+	// certificates, and of their domains.
+	managedCertStatusActive = "ACTIVE"
+	// maxManagedCertDomains is the maximum number of domains GCE allows on
+	// a managed certificate.
+	maxManagedCertDomains = 100
+)
+
+// The minimum TLS versions of SSL policies.
+const (
+	MinTLSVersion10 = "TLS_1_0"
+	MinTLSVersion11 = "TLS_1_1"
+	MinTLSVersion12 = "TLS_1_2"
 )
 
+// CheckMinTLSVersion returns an error if the minimum TLS version of the given
+// SSL policy is below the given version, if any.
+func CheckMinTLSVersion(policy *SslPolicy, minVersion string) error {
+	version := policy.MinTlsVersion
+	if version == "" {
+		version = MinTLSVersion10
+	}
+	// The versions sort in lexical order.
+	if minVersion != "" && version < minVersion {
+		return fmt.Errorf("SSL policy %v accepts %v, below the minimum %v", policy.Name, version, minVersion)
+	}
+	return nil
+}
+
 // L7s implements LoadBalancerPool.
 type L7s struct {
-	cloud       LoadBalancers
-	snapshotter storage.Snapshotter
+	cloud        LoadBalancers
+	httpsProxies TargetHttpsProxies
+	urlMaps      ExtendedUrlMaps
+	snapshotter  storage.Snapshotter
 	// TODO: Remove this field and always ask the BackendPool using the NodePort.
-	glbcDefaultBackend     *compute.BackendService
-	defaultBackendPool     backends.BackendPool
-	defaultBackendNodePort backends.ServicePort
+	glbcDefaultBackend *compute.BackendService
+	defaultBackendPool backends.BackendPool
+	// defaultBackendNodePort is nil if the cluster has no default backend,
+	// loadbalancers then use the default backend of their Ingress.
+	defaultBackendNodePort *backends.ServicePort
 	namer                  *utils.Namer
+	// releasedSSLCerts are the certificates named after their contents which
+	// loadbalancers stopped using. They may still be used by other
+	// loadbalancers, GC deletes the ones no target HTTPS proxy uses. Nil
+	// until the certificates leaked before a restart are listed.
+	releasedSSLCerts  sets.String
+	sslPolicyDefaults SslPolicyDefaults
+}
+
+// SslPolicyDefaults are the cluster-wide SSL policy settings of the target
+// HTTPS proxies.
+type SslPolicyDefaults struct {
+	// Name is the SSL policy attached to the target HTTPS proxies whose
+	// FrontendConfig doesn't set one. None if empty.
+	Name string
+	// MinTLSVersion is the lowest minimum TLS version allowed for the SSL
+	// policies of the target HTTPS proxies, eg: TLS_1_2. Proxies without SSL
+	// policy accept TLS 1.0. Any if empty.
+	MinTLSVersion string
 }
 
 // NewLoadBalancerPool returns a new loadbalancer pool.
 // - cloud: implements LoadBalancers. Used to sync L7 loadbalancer resources
 //	 with the cloud.
+// - httpsProxies: used to set the certificates and the SSL policies of
+//	 FrontendConfigs on the target HTTPS proxies.
+// - urlMaps: used to manage the URL maps redirecting HTTP to HTTPS.
 // - defaultBackendPool: a BackendPool used to manage the GCE BackendService for
 //   the default backend.
 // - defaultBackendNodePort: The nodePort of the Kubernetes service representing
-//   the default backend.
+//   the default backend. If nil, the cluster has no default backend.
+// - sslPolicyDefaults: the SSL policy settings enforced on all the target
+//   HTTPS proxies.
 func NewLoadBalancerPool(
 	cloud LoadBalancers,
+	httpsProxies TargetHttpsProxies,
+	urlMaps ExtendedUrlMaps,
 	defaultBackendPool backends.BackendPool,
-	defaultBackendNodePort backends.ServicePort, namer *utils.Namer) LoadBalancerPool {
-	return &L7s{cloud, storage.NewInMemoryPool(), nil, defaultBackendPool, defaultBackendNodePort, namer}
+	defaultBackendNodePort *backends.ServicePort, namer *utils.Namer,
+	sslPolicyDefaults SslPolicyDefaults) LoadBalancerPool {
+	return &L7s{cloud, httpsProxies, urlMaps, storage.NewInMemoryPool(), nil, defaultBackendPool, defaultBackendNodePort, namer, nil, sslPolicyDefaults}
 }
 
 func (l *L7s) create(ri *L7RuntimeInfo) (*L7, error) {
-	if l.glbcDefaultBackend == nil {
-		glog.Warningf("Creating l7 without a default backend")
+	if l.glbcDefaultBackend == nil && l.defaultBackendNodePort != nil {
+		logging.Warningf("Creating l7 without a default backend")
 	}
 	return &L7{
 		runtimeInfo:        ri,
 		Name:               l.namer.LoadBalancer(ri.Name),
 		cloud:              l.cloud,
+		httpsProxies:       l.httpsProxies,
+		urlMaps:            l.urlMaps,
 		glbcDefaultBackend: l.glbcDefaultBackend,
 		namer:              l.namer,
-		sslCert:            nil,
+		sslPolicyDefaults:  l.sslPolicyDefaults,
 	}, nil
 }
 
@@ -108,21 +183,32 @@ func (l *L7s) Add(ri *L7RuntimeInfo) (err error) {
 
 	lb, _ := l.Get(name)
 	if lb == nil {
-		glog.Infof("Creating l7 %v", name)
+		logging.ForIngress(ri.Name).WithOperation("create").Infof("Creating l7 %v", name)
 		lb, err = l.create(ri)
 		if err != nil {
 			return err
 		}
 	} else {
 		if !reflect.DeepEqual(lb.runtimeInfo, ri) {
-			glog.Infof("LB %v runtime info changed, old %+v new %+v", lb.Name, lb.runtimeInfo, ri)
+			logging.Infof("LB %v runtime info changed, old %+v new %+v", lb.Name, lb.runtimeInfo, ri)
 			lb.runtimeInfo = ri
 		}
 	}
+	// Without a cluster default backend, the url map is created with the
+	// default backend of the Ingress.
+	if l.defaultBackendNodePort == nil {
+		if ri.DefaultBackend == nil {
+			return fmt.Errorf("loadbalancer %v has no default backend", name)
+		}
+		if lb.glbcDefaultBackend, err = l.defaultBackendPool.GetServicePort(*ri.DefaultBackend); err != nil {
+			return err
+		}
+	}
 	// Add the lb to the pool, in case we create an UrlMap but run out
 	// of quota in creating the ForwardingRule we still need to cleanup
 	// the UrlMap during GC.
 	defer l.snapshotter.Add(name, lb)
+	defer l.collectReleasedSSLCerts(lb)
 
 	// Why edge hop for the create?
 	// The loadbalancer is a fictitious resource, it doesn't exist in gce. To
@@ -142,8 +228,10 @@ func (l *L7s) Delete(name string) error {
 	if err != nil {
 		return err
 	}
-	glog.Infof("Deleting lb %v", name)
-	if err := lb.Cleanup(); err != nil {
+	logging.ForIngress(lb.runtimeInfo.Name).WithOperation("delete").Infof("Deleting lb %v", name)
+	err = lb.Cleanup()
+	l.collectReleasedSSLCerts(lb)
+	if err != nil {
 		return err
 	}
 	l.snapshotter.Delete(name)
@@ -152,13 +240,13 @@ func (l *L7s) Delete(name string) error {
 
 // Sync loadbalancers with the given runtime info from the controller.
 func (l *L7s) Sync(lbs []*L7RuntimeInfo) error {
-	glog.V(3).Infof("Syncing loadbalancers %v", lbs)
+	logging.V(3).Infof("Syncing loadbalancers %v", lbs)
 
-	if len(lbs) != 0 {
+	if len(lbs) != 0 && l.defaultBackendNodePort != nil {
 		// Lazily create a default backend so we don't tax users who don't care
 		// about Ingress by consuming 1 of their 3 GCE BackendServices. This
 		// BackendService is GC'd when there are no more Ingresses.
-		if err := l.defaultBackendPool.Ensure([]backends.ServicePort{l.defaultBackendNodePort}, nil); err != nil {
+		if err := l.defaultBackendPool.Ensure([]backends.ServicePort{*l.defaultBackendNodePort}, nil); err != nil {
 			return err
 		}
 		defaultBackend, err := l.defaultBackendPool.Get(l.defaultBackendNodePort.Port)
@@ -189,7 +277,7 @@ func (l *L7s) GC(names []string) error {
 		if knownLoadBalancers.Has(name) {
 			continue
 		}
-		glog.V(3).Infof("GCing loadbalancer %v", name)
+		logging.V(3).Infof("GCing loadbalancer %v", name)
 		if err := l.Delete(name); err != nil {
 			return err
 		}
@@ -197,12 +285,66 @@ func (l *L7s) GC(names []string) error {
 	// Tear down the default backend when there are no more loadbalancers.
 	// This needs to happen after we've deleted all url-maps that might be
 	// using it.
-	if len(names) == 0 {
+	if len(names) == 0 && l.defaultBackendNodePort != nil {
 		if err := l.defaultBackendPool.Delete(l.defaultBackendNodePort.Port); err != nil {
 			return err
 		}
 		l.glbcDefaultBackend = nil
 	}
+	return l.gcSSLCerts()
+}
+
+// collectReleasedSSLCerts records the certificates the given loadbalancer
+// stopped using, for GC.
+func (l *L7s) collectReleasedSSLCerts(lb *L7) {
+	// Before the first GC, all the certificates are listed by the GC.
+	if l.releasedSSLCerts != nil {
+		l.releasedSSLCerts.Insert(lb.releasedSSLCerts...)
+	}
+	lb.releasedSSLCerts = nil
+}
+
+// gcSSLCerts deletes the released certificates named after their contents
+// which no target HTTPS proxy uses. The first GC also considers all such
+// certificates of the cluster, eg: released before a restart.
+func (l *L7s) gcSSLCerts() error {
+	if l.releasedSSLCerts == nil {
+		list, err := l.cloud.ListSslCertificates()
+		if err != nil {
+			return err
+		}
+		released := sets.NewString()
+		for _, cert := range list.Items {
+			if l.namer.IsHashedSSLCert(cert.Name) {
+				released.Insert(cert.Name)
+			}
+		}
+		l.releasedSSLCerts = released
+	}
+	if l.releasedSSLCerts.Len() == 0 {
+		return nil
+	}
+
+	list, err := l.cloud.ListTargetHttpsProxies()
+	if err != nil {
+		return err
+	}
+	inUse := sets.NewString()
+	for _, proxy := range list.Items {
+		for _, link := range proxy.SslCertificates {
+			inUse.Insert(getResourceNameFromLink(link))
+		}
+	}
+	for _, name := range l.releasedSSLCerts.List() {
+		// A certificate still in use is released again by its last user.
+		if !inUse.Has(name) {
+			logging.ForResource(name).WithOperation("delete").Infof("Deleting unused SSL certificate")
+			if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteSslCertificate(name)); err != nil {
+				return err
+			}
+		}
+		l.releasedSSLCerts.Delete(name)
+	}
 	return nil
 }
 
@@ -214,7 +356,7 @@ func (l *L7s) Shutdown() error {
 	if err := l.defaultBackendPool.Shutdown(); err != nil {
 		return err
 	}
-	glog.Infof("Loadbalancer pool shutdown.")
+	logging.Infof("Loadbalancer pool shutdown.")
 	return nil
 }
 
@@ -226,6 +368,16 @@ type TLSCerts struct {
 	Cert string
 	// Chain is a certificate chain.
 	Chain string
+	// Secret is the name of the secret the cert was loaded from, if any.
+	Secret string
+}
+
+// hash returns the hash of the contents of the certificate, which names its
+// GCE certificate. The private key can't be read back from GCE, so the
+// contents are compared through their hash.
+func (c *TLSCerts) hash() string {
+	sum := sha256.Sum256([]byte(c.Cert + "\n" + c.Key + "\n" + c.Chain))
+	return hex.EncodeToString(sum[:])[:utils.SSLCertHashLen]
 }
 
 // L7RuntimeInfo is info passed to this module from the controller runtime.
@@ -234,9 +386,11 @@ type L7RuntimeInfo struct {
 	Name string
 	// IP is the desired ip of the loadbalancer, eg from a staticIP.
 	IP string
-	// TLS are the tls certs to use in termination.
-	TLS *TLSCerts
-	// TLSName is the name of/for the tls cert to use.
+	// TLS are the tls certs to use in termination, in order. The first cert
+	// is served to clients without SNI.
+	TLS []*TLSCerts
+	// TLSName is the comma separated list of names of/for the tls certs to
+	// use.
 	TLSName string
 	// AllowHTTP will not setup :80, if TLS is nil and AllowHTTP is set,
 	// no loadbalancer is created.
@@ -244,6 +398,21 @@ type L7RuntimeInfo struct {
 	// The name of a Global Static IP. If specified, the IP associated with
 	// this name is used in the Forwarding Rules for this loadbalancer.
 	StaticIPName string
+	// IPv6 adds IPv6 forwarding rules to the loadbalancer, next to the IPv4
+	// ones.
+	IPv6 bool
+	// StaticIPv6Name is the name of a global IPv6 address used by the IPv6
+	// forwarding rules. If empty, the controller reserves one.
+	StaticIPv6Name string
+	// DefaultBackend is the default backend of the Ingress, nil if none. It
+	// is used to create the UrlMap if the cluster has no default backend.
+	DefaultBackend *backends.ServicePort
+	// FrontendConfig is the FrontendConfig referenced by the Ingress, nil if
+	// none.
+	FrontendConfig *frontendconfig.FrontendConfig
+	// ManagedCertDomains are the domains of the Google-managed certificate
+	// served after the pre-shared certs. None if empty.
+	ManagedCertDomains []string
 }
 
 // String returns the load balancer name
@@ -258,8 +427,17 @@ type L7 struct {
 	runtimeInfo *L7RuntimeInfo
 	// cloud is an interface to manage loadbalancers in the GCE cloud.
 	cloud LoadBalancers
+	// httpsProxies is an interface to set the certificates and the SSL policy
+	// of the targetHTTPSProxy.
+	httpsProxies      TargetHttpsProxies
+	sslPolicyDefaults SslPolicyDefaults
+	// urlMaps is an interface to manage the redirect UrlMap.
+	urlMaps ExtendedUrlMaps
 	// um is the UrlMap associated with this L7.
 	um *compute.UrlMap
+	// redirectUm is the UrlMap redirecting the requests of the
+	// TargetHTTPProxy to HTTPS, nil unless the FrontendConfig asks for it.
+	redirectUm *UrlMap
 	// tp is the TargetHTTPProxy associated with this L7.
 	tp *compute.TargetHttpProxy
 	// tps is the TargetHTTPSProxy associated with this L7.
@@ -270,14 +448,30 @@ type L7 struct {
 	fws *compute.ForwardingRule
 	// ip is the static-ip associated with both GlobalForwardingRules.
 	ip *compute.Address
-	// sslCert is the ssl cert associated with the targetHTTPSProxy.
+	// fw6 is the IPv6 GlobalForwardingRule that points to the
+	// TargetHTTPProxy.
+	fw6 *compute.ForwardingRule
+	// fws6 is the IPv6 GlobalForwardingRule that points to the
+	// TargetHTTPSProxy.
+	fws6 *compute.ForwardingRule
+	// ipv6 is the IPv6 address reserved by the controller for the IPv6
+	// GlobalForwardingRules, nil if the user named one.
+	ipv6 *compute.Address
+	// sslCerts are the ssl certs associated with the targetHTTPSProxy, in
+	// order.
 	// TODO: Make this a custom type that contains crt+key
-	sslCert *compute.SslCertificate
-	// oldSSLCert is the certificate that used to be hooked up to the
+	sslCerts []*compute.SslCertificate
+	// oldSSLCerts are the certificates that used to be hooked up to the
 	// targetHTTPSProxy. We can't update a cert in place, so we need
-	// to create - update - delete and storing the old cert in a field
+	// to create - update - delete and storing the old certs in a field
 	// prevents leakage if there's a failure along the way.
-	oldSSLCert *compute.SslCertificate
+	oldSSLCerts []*compute.SslCertificate
+	// releasedSSLCerts are the names of the certificates named after their
+	// contents that the loadbalancer stopped using, collected by the pool.
+	releasedSSLCerts []string
+	// managedCert is the Google-managed certificate of the targetHTTPSProxy,
+	// with its provisioning status as of the last sync.
+	managedCert *SslCertificate
 	// glbcDefaultBacked is the backend to use if no path rules match.
 	// TODO: Expose this to users.
 	glbcDefaultBackend *compute.BackendService
@@ -285,6 +479,18 @@ type L7 struct {
 	namer *utils.Namer
 }
 
+// description returns the ownership metadata recorded in the description of
+// the resources of the loadbalancer: the cluster and the Ingress.
+func (l *L7) description() string {
+	return utils.Description{ClusterUID: l.namer.UID(), IngressName: l.runtimeInfo.Name}.String()
+}
+
+// log returns a Logger of the operation on the resource of the given name of
+// the loadbalancer.
+func (l *L7) log(resource, op string) logging.Logger {
+	return logging.ForIngress(l.runtimeInfo.Name).WithResource(resource).WithOperation(op)
+}
+
 func (l *L7) checkUrlMap(backend *compute.BackendService) (err error) {
 	if l.glbcDefaultBackend == nil {
 		return fmt.Errorf("cannot create urlmap without default backend")
@@ -292,14 +498,15 @@ func (l *L7) checkUrlMap(backend *compute.BackendService) (err error) {
 	urlMapName := l.namer.UrlMap(l.Name)
 	urlMap, _ := l.cloud.GetUrlMap(urlMapName)
 	if urlMap != nil {
-		glog.V(3).Infof("Url map %v already exists", urlMap.Name)
+		logging.V(3).Infof("Url map %v already exists", urlMap.Name)
 		l.um = urlMap
 		return nil
 	}
 
-	glog.Infof("Creating url map %v for backend %v", urlMapName, l.glbcDefaultBackend.Name)
+	l.log(urlMapName, "create").Infof("Creating url map for backend %v", l.glbcDefaultBackend.Name)
 	newUrlMap := &compute.UrlMap{
 		Name:           urlMapName,
+		Description:    l.description(),
 		DefaultService: l.glbcDefaultBackend.SelfLink,
 	}
 	if err = l.cloud.CreateUrlMap(newUrlMap); err != nil {
@@ -317,13 +524,19 @@ func (l *L7) checkProxy() (err error) {
 	if l.um == nil {
 		return fmt.Errorf("cannot create proxy without urlmap")
 	}
+	// The HTTP requests are served by the redirect UrlMap, if any.
+	urlMap := l.um
+	if l.redirectUm != nil {
+		urlMap = &compute.UrlMap{Name: l.redirectUm.Name, SelfLink: l.redirectUm.SelfLink}
+	}
 	proxyName := l.namer.TargetProxy(l.Name, utils.HTTPProtocol)
 	proxy, _ := l.cloud.GetTargetHttpProxy(proxyName)
 	if proxy == nil {
-		glog.Infof("Creating new http proxy for urlmap %v", l.um.Name)
+		l.log(proxyName, "create").Infof("Creating new http proxy for urlmap %v", urlMap.Name)
 		newProxy := &compute.TargetHttpProxy{
-			Name:   proxyName,
-			UrlMap: l.um.SelfLink,
+			Name:        proxyName,
+			Description: l.description(),
+			UrlMap:      urlMap.SelfLink,
 		}
 		if err = l.cloud.CreateTargetHttpProxy(newProxy); err != nil {
 			return err
@@ -335,10 +548,10 @@ func (l *L7) checkProxy() (err error) {
 		l.tp = proxy
 		return nil
 	}
-	if !utils.CompareLinks(proxy.UrlMap, l.um.SelfLink) {
-		glog.Infof("Proxy %v has the wrong url map, setting %v overwriting %v",
-			proxy.Name, l.um, proxy.UrlMap)
-		if err := l.cloud.SetUrlMapForTargetHttpProxy(proxy, l.um); err != nil {
+	if !utils.CompareLinks(proxy.UrlMap, urlMap.SelfLink) {
+		l.log(proxy.Name, "update").Infof("Proxy %v has the wrong url map, setting %v overwriting %v",
+			proxy.Name, urlMap.SelfLink, proxy.UrlMap)
+		if err := l.cloud.SetUrlMapForTargetHttpProxy(proxy, urlMap); err != nil {
 			return err
 		}
 	}
@@ -346,15 +559,94 @@ func (l *L7) checkProxy() (err error) {
 	return nil
 }
 
-func (l *L7) deleteOldSSLCert() (err error) {
-	if l.oldSSLCert == nil || l.sslCert == nil || l.oldSSLCert.Name == l.sslCert.Name || !l.namer.IsSSLCert(l.oldSSLCert.Name) {
+// redirectsToHttps returns true if the FrontendConfig of the loadbalancer
+// redirects its HTTP requests to HTTPS, which requires both.
+func (l *L7) redirectsToHttps() bool {
+	config := l.runtimeInfo.FrontendConfig
+	if config == nil || config.Spec.RedirectToHttps == nil || !config.Spec.RedirectToHttps.Enabled {
+		return false
+	}
+	return l.runtimeInfo.AllowHTTP && l.UsesTLS()
+}
+
+// checkRedirectUrlMap creates or updates the UrlMap redirecting the HTTP
+// requests to HTTPS, with the response code of the FrontendConfig.
+func (l *L7) checkRedirectUrlMap() error {
+	redirect := &HttpRedirectAction{
+		HttpsRedirect:        true,
+		RedirectResponseCode: l.runtimeInfo.FrontendConfig.Spec.RedirectToHttps.ResponseCodeName,
+	}
+	if redirect.RedirectResponseCode == "" {
+		redirect.RedirectResponseCode = defaultRedirectResponseCode
+	}
+	name := l.namer.RedirectUrlMap(l.Name)
+	urlMap, _ := l.urlMaps.GetExtendedUrlMap(name)
+	if urlMap != nil && reflect.DeepEqual(urlMap.DefaultUrlRedirect, redirect) {
+		l.redirectUm = urlMap
 		return nil
 	}
-	glog.Infof("Cleaning up old SSL Certificate %v, current name %v", l.oldSSLCert.Name, l.sslCert.Name)
-	if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteSslCertificate(l.oldSSLCert.Name)); err != nil {
+	if urlMap == nil {
+		l.log(name, "create").Infof("Creating url map redirecting to https with %v", redirect.RedirectResponseCode)
+		err := l.urlMaps.CreateExtendedUrlMap(&UrlMap{Name: name, Description: l.description(), DefaultUrlRedirect: redirect})
+		if err != nil {
+			return err
+		}
+	} else {
+		l.log(name, "update").Infof("Updating url map redirecting to https with %v", redirect.RedirectResponseCode)
+		urlMap.DefaultUrlRedirect = redirect
+		if err := l.urlMaps.UpdateExtendedUrlMap(urlMap); err != nil {
+			return err
+		}
+	}
+	urlMap, err := l.urlMaps.GetExtendedUrlMap(name)
+	if err != nil {
 		return err
 	}
-	l.oldSSLCert = nil
+	l.redirectUm = urlMap
+	return nil
+}
+
+// deleteRedirectUrlMap deletes the UrlMap redirecting to HTTPS, once the
+// TargetHTTPProxy no longer uses it. It is looked up by name, since it may
+// have been created before the controller restarted.
+func (l *L7) deleteRedirectUrlMap() error {
+	name := l.namer.RedirectUrlMap(l.Name)
+	if urlMap, _ := l.cloud.GetUrlMap(name); urlMap != nil {
+		l.log(name, "delete").Infof("Deleting url map, https redirect is disabled")
+		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteUrlMap(name)); err != nil {
+			return err
+		}
+	}
+	l.redirectUm = nil
+	return nil
+}
+
+// deleteOldSSLCerts deletes the certificates created by the controller that
+// were used by the targetHTTPSProxy before the last update, and are no longer
+// used.
+func (l *L7) deleteOldSSLCerts() (err error) {
+	if name, _ := l.certificateMap(); len(l.oldSSLCerts) == 0 || len(l.sslCerts) == 0 && name == "" {
+		return nil
+	}
+	certsInUse := sets.NewString()
+	for _, cert := range l.sslCerts {
+		certsInUse.Insert(cert.Name)
+	}
+	for _, cert := range l.oldSSLCerts {
+		if certsInUse.Has(cert.Name) || !l.namer.IsSSLCert(cert.Name) {
+			continue
+		}
+		if l.namer.IsHashedSSLCert(cert.Name) {
+			// Other loadbalancers may serve the same certificate.
+			l.releasedSSLCerts = append(l.releasedSSLCerts, cert.Name)
+			continue
+		}
+		l.log(cert.Name, "delete").Infof("Cleaning up old SSL Certificate, current names %v", certsInUse.List())
+		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteSslCertificate(cert.Name)); err != nil {
+			return err
+		}
+	}
+	l.oldSSLCerts = nil
 	return nil
 }
 
@@ -367,125 +659,272 @@ func getResourceNameFromLink(link string) string {
 	return s[len(s)-1]
 }
 
-func (l *L7) usePreSharedCert() (bool, error) {
-	// Use the named GCE cert when it is specified by the annotation.
-	preSharedCertName := l.runtimeInfo.TLSName
-	if preSharedCertName == "" {
-		return false, nil
+// preSharedCertNames returns the names of the pre-shared certificates of the
+// load balancer, in order and without duplicates.
+func (l *L7) preSharedCertNames() []string {
+	var names []string
+	seen := sets.NewString()
+	for _, name := range strings.Split(l.runtimeInfo.TLSName, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen.Has(name) {
+			continue
+		}
+		seen.Insert(name)
+		names = append(names, name)
 	}
+	return names
+}
 
-	// Ask GCE for the cert, checking for problems and existence.
-	cert, err := l.cloud.GetSslCertificate(preSharedCertName)
-	if err != nil {
-		return true, err
-	}
-	if cert == nil {
-		return true, fmt.Errorf("cannot find existing sslCertificate %v for %v", preSharedCertName, l.Name)
+// preSharedCerts returns the pre-shared certificates of the load balancer, in
+// the order of the annotation, up to the GCE limit.
+func (l *L7) preSharedCerts() ([]*compute.SslCertificate, error) {
+	names := l.preSharedCertNames()
+	if len(names) > MaxSSLCerts {
+		logging.Warningf("Ignoring %d pre-shared certs of %v, GCE allows at most %d certs per proxy",
+			len(names)-MaxSSLCerts, l.Name, MaxSSLCerts)
+		names = names[:MaxSSLCerts]
 	}
 
-	glog.V(2).Infof("Using existing sslCertificate %v for %v", preSharedCertName, l.Name)
-	l.sslCert = cert
-	return true, nil
+	// Ask GCE for the certs, checking for problems and existence.
+	var certs []*compute.SslCertificate
+	for _, name := range names {
+		cert, err := l.cloud.GetSslCertificate(name)
+		if err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			return nil, fmt.Errorf("cannot find existing sslCertificate %v for %v", name, l.Name)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		logging.V(2).Infof("Using existing sslCertificates %v for %v", names, l.Name)
+	}
+	return certs, nil
 }
 
-func (l *L7) populateSSLCert() error {
-	// Determine what certificate name is being used
-	var expectedCertName string
-	if l.sslCert != nil {
-		expectedCertName = l.sslCert.Name
+func (l *L7) populateSSLCerts() error {
+	// Determine what certificate names are being used
+	var expectedCertNames []string
+	if l.sslCerts != nil {
+		for _, cert := range l.sslCerts {
+			expectedCertNames = append(expectedCertNames, cert.Name)
+		}
 	} else {
-		// Retrieve the ssl certificate in use by the expected target proxy (if exists)
-		expectedCertName = getResourceNameFromLink(l.getSslCertLinkInUse())
+		// Retrieve the ssl certificates in use by the expected target proxy (if exists)
+		for _, link := range l.getSslCertLinksInUse() {
+			expectedCertNames = append(expectedCertNames, getResourceNameFromLink(link))
+		}
 	}
 
-	var err error
-	if expectedCertName != "" {
-		// Retrieve the certificate and ignore error if certificate wasn't found
-		l.sslCert, err = l.cloud.GetSslCertificate(expectedCertName)
+	// Retrieve the certificates and ignore the ones that weren't found
+	var certs []*compute.SslCertificate
+	for _, name := range expectedCertNames {
+		cert, err := l.cloud.GetSslCertificate(name)
 		if err != nil {
-			return utils.IgnoreHTTPNotFound(err)
+			if err = utils.IgnoreHTTPNotFound(err); err != nil {
+				return err
+			}
+			continue
 		}
+		certs = append(certs, cert)
 	}
+	l.sslCerts = certs
 	return nil
 }
 
-func (l *L7) nextCertificateName() string {
-	// The name of the cert for this lb flip-flops between these 2 on
-	// every certificate update. We don't append the index at the end so we're
-	// sure it isn't truncated.
-	// TODO: Clean this code up into a ring buffer.
-	primaryCertName := l.namer.SSLCert(l.Name, true)
-	secondaryCertName := l.namer.SSLCert(l.Name, false)
+// ensureSSLCert returns the certificate with the given name and contents,
+// created if it doesn't exist yet. The certificate may already be used by
+// other loadbalancers serving the same contents.
+func (l *L7) ensureSSLCert(name string, tlsCert *TLSCerts) (*compute.SslCertificate, error) {
+	cert, err := l.cloud.GetSslCertificate(name)
+	if err == nil && cert != nil {
+		return cert, nil
+	}
+	if err := utils.IgnoreHTTPNotFound(err); err != nil {
+		return nil, err
+	}
+	l.log(name, "create").V(2).Infof("Creating new sslCertificate for %v", l.Name)
+	return l.cloud.CreateSslCertificate(&compute.SslCertificate{
+		Name: name,
+		// The certificate is not owned by a single Ingress.
+		Description: utils.Description{ClusterUID: l.namer.UID()}.String(),
+		Certificate: tlsCert.Cert,
+		PrivateKey:  tlsCert.Key,
+	})
+}
 
-	if l.sslCert != nil && l.sslCert.Name == primaryCertName {
-		return secondaryCertName
+// ensureManagedSSLCert returns the Google-managed certificate of the given
+// domains, created if it doesn't exist yet. Like the certificates of the
+// secrets, it is named after its domains, and may already be used by other
+// loadbalancers serving the same domains.
+func (l *L7) ensureManagedSSLCert(domains []string) (*compute.SslCertificate, error) {
+	if len(domains) > maxManagedCertDomains {
+		logging.Warningf("Ignoring %d domains of the managed cert of %v, GCE allows at most %d domains per cert",
+			len(domains)-maxManagedCertDomains, l.Name, maxManagedCertDomains)
+		domains = domains[:maxManagedCertDomains]
+	}
+	sum := sha256.Sum256([]byte("managed\n" + strings.Join(domains, "\n")))
+	name := l.namer.HashedSSLCert(hex.EncodeToString(sum[:])[:utils.SSLCertHashLen])
+	cert, err := l.httpsProxies.GetManagedSslCertificate(name)
+	if err != nil {
+		if err := utils.IgnoreHTTPNotFound(err); err != nil {
+			return nil, err
+		}
+		l.log(name, "create").V(2).Infof("Creating managed sslCertificate for %v", domains)
+		err := l.httpsProxies.CreateManagedSslCertificate(&SslCertificate{
+			Name: name,
+			// The certificate is not owned by a single Ingress.
+			Description: utils.Description{ClusterUID: l.namer.UID()}.String(),
+			Type:        managedCertType,
+			Managed:     &ManagedSslCertificate{Domains: domains},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if cert, err = l.httpsProxies.GetManagedSslCertificate(name); err != nil {
+			return nil, err
+		}
 	}
-	return primaryCertName
+	l.managedCert = cert
+	return &compute.SslCertificate{Name: cert.Name, SelfLink: cert.SelfLink}, nil
 }
 
-func (l *L7) checkSSLCert() error {
-	// Handle Pre-Shared cert and early return if used
-	if used, err := l.usePreSharedCert(); used {
-		return err
+// activeManagedSSLCert returns the active Google-managed certificate of the
+// targetHTTPSProxy other than the given one, nil if there is none.
+func (l *L7) activeManagedSSLCert(exclude string) (*compute.SslCertificate, error) {
+	for _, cert := range l.sslCerts {
+		if cert.Name == exclude || !l.namer.IsHashedSSLCert(cert.Name) {
+			continue
+		}
+		managed, err := l.httpsProxies.GetManagedSslCertificate(cert.Name)
+		if err != nil {
+			if err := utils.IgnoreHTTPNotFound(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if managed.Type == managedCertType && managed.Managed != nil && managed.Managed.Status == managedCertStatusActive {
+			return cert, nil
+		}
 	}
+	return nil, nil
+}
 
-	// Get updated value of certificate for comparison
-	if err := l.populateSSLCert(); err != nil {
+func (l *L7) checkSSLCerts() error {
+	// Get updated value of certificates for comparison
+	if err := l.populateSSLCerts(); err != nil {
 		return err
 	}
-
-	// TODO: Currently, GCE only supports a single certificate per static IP
-	// so we don't need to bother with disambiguation. Naming the cert after
-	// the loadbalancer is a simplification.
-	ingCert := l.runtimeInfo.TLS.Cert
-	ingKey := l.runtimeInfo.TLS.Key
-
-	// PrivateKey is write only, so compare certs alone. We're assuming that
-	// no one will change just the key. We can remember the key and compare,
-	// but a bug could end up leaking it, which feels worse.
-	if l.sslCert != nil && ingCert == l.sslCert.Certificate {
+	// The certificate map replaces the certificates, which are detached
+	// once the map is attached.
+	l.managedCert = nil
+	if name, _ := l.certificateMap(); name != "" {
+		if len(l.sslCerts) > 0 {
+			l.oldSSLCerts = l.sslCerts
+			l.sslCerts = nil
+		}
 		return nil
 	}
 
-	// Controller needs to create or update the certificate.
-	// Generate the next certificate name to use.
-	newCertName := l.nextCertificateName()
-
-	// Perform a delete in case a certificate exists with the exact name
-	// This certificate should be unused since we check the target proxy's certificate prior
-	// to this point. Although, it's possible an actor pointed a target proxy to this certificate.
-	if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteSslCertificate(newCertName)); err != nil {
-		return fmt.Errorf("unable to delete ssl certificate with name %q, expected it to be unused. err: %v", newCertName, err)
-	}
-
-	glog.V(2).Infof("Creating new sslCertificate %v for %v", newCertName, l.Name)
-	cert, err := l.cloud.CreateSslCertificate(&compute.SslCertificate{
-		Name:        newCertName,
-		Certificate: ingCert,
-		PrivateKey:  ingKey,
-	})
+	// The pre-shared certs come first, the first one is served to clients
+	// without SNI. The certs of the secrets follow, in the order of the
+	// Ingress, up to the GCE limit.
+	certs, err := l.preSharedCerts()
 	if err != nil {
 		return err
 	}
-	// Save the current cert for cleanup after we update the target proxy.
-	l.oldSSLCert = l.sslCert
-	l.sslCert = cert
-
+	// The managed certificate follows the pre-shared certs.
+	if domains := l.runtimeInfo.ManagedCertDomains; len(domains) > 0 {
+		if len(certs) == MaxSSLCerts {
+			logging.Warningf("Ignoring the managed cert of %v, GCE allows at most %d certs per proxy", l.Name, MaxSSLCerts)
+		} else {
+			cert, err := l.ensureManagedSSLCert(domains)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+			// A new managed cert, eg: for changed domains, takes a while to
+			// provision: the previous one keeps serving the domains until
+			// then.
+			if l.managedCert.Managed == nil || l.managedCert.Managed.Status != managedCertStatusActive {
+				previous, err := l.activeManagedSSLCert(cert.Name)
+				if err != nil {
+					return err
+				}
+				if previous != nil && len(certs) < MaxSSLCerts {
+					logging.V(2).Infof("Keeping managed cert %v of %v until %v is active", previous.Name, l.Name, cert.Name)
+					certs = append(certs, previous)
+				}
+			}
+		}
+	}
+	tlsCerts := l.runtimeInfo.TLS
+	if limit := MaxSSLCerts - len(certs); len(tlsCerts) > limit {
+		logging.Warningf("Ignoring %d certs of %v, GCE allows at most %d certs per proxy",
+			len(tlsCerts)-limit, l.Name, MaxSSLCerts)
+		tlsCerts = tlsCerts[:limit]
+	}
+	for _, tlsCert := range tlsCerts {
+		// The certificates are named after their contents: a changed
+		// secret gets a new certificate, swapped onto the proxy before the
+		// old one is released.
+		name := l.namer.HashedSSLCert(tlsCert.hash())
+		if i := len(certs); i < len(l.sslCerts) && l.sslCerts[i].Name == name {
+			certs = append(certs, l.sslCerts[i])
+			continue
+		}
+		cert, err := l.ensureSSLCert(name, tlsCert)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	if sameSSLCerts(certs, l.sslCerts) {
+		return nil
+	}
+	// Save the current certs for cleanup after we update the target proxy.
+	l.oldSSLCerts = l.sslCerts
+	l.sslCerts = certs
 	return nil
 }
 
-func (l *L7) getSslCertLinkInUse() string {
+// sameSSLCerts returns true if the given lists hold the same certificates, in
+// the same order.
+func sameSSLCerts(a, b []*compute.SslCertificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *L7) getSslCertLinksInUse() []string {
 	proxyName := l.namer.TargetProxy(l.Name, utils.HTTPSProtocol)
 	proxy, _ := l.cloud.GetTargetHttpsProxy(proxyName)
-	if proxy != nil && len(proxy.SslCertificates) > 0 {
-		return proxy.SslCertificates[0]
+	if proxy != nil {
+		return proxy.SslCertificates
 	}
-	return ""
+	return nil
+}
+
+// sslCertLinks returns the links of the certificates of the targetHTTPSProxy.
+func (l *L7) sslCertLinks() []string {
+	var links []string
+	for _, cert := range l.sslCerts {
+		links = append(links, cert.SelfLink)
+	}
+	return links
 }
 
 func (l *L7) checkHttpsProxy() (err error) {
-	if l.sslCert == nil {
-		glog.V(3).Infof("No SSL certificates for %v, will not create HTTPS proxy.", l.Name)
+	certMap, _ := l.certificateMap()
+	if len(l.sslCerts) == 0 && certMap == "" {
+		logging.V(3).Infof("No SSL certificates for %v, will not create HTTPS proxy.", l.Name)
 		return nil
 	}
 	if l.um == nil {
@@ -494,13 +933,23 @@ func (l *L7) checkHttpsProxy() (err error) {
 	proxyName := l.namer.TargetProxy(l.Name, utils.HTTPSProtocol)
 	proxy, _ := l.cloud.GetTargetHttpsProxy(proxyName)
 	if proxy == nil {
-		glog.Infof("Creating new https proxy for urlmap %v", l.um.Name)
-		newProxy := &compute.TargetHttpsProxy{
-			Name:            proxyName,
-			UrlMap:          l.um.SelfLink,
-			SslCertificates: []string{l.sslCert.SelfLink},
+		l.log(proxyName, "create").Infof("Creating new https proxy for urlmap %v", l.um.Name)
+		if certMap != "" {
+			err = l.httpsProxies.CreateTargetHttpsProxyWithCertificateMap(&TargetHttpsProxy{
+				Name:           proxyName,
+				Description:    l.description(),
+				UrlMap:         l.um.SelfLink,
+				CertificateMap: certMap,
+			})
+		} else {
+			err = l.cloud.CreateTargetHttpsProxy(&compute.TargetHttpsProxy{
+				Name:            proxyName,
+				Description:     l.description(),
+				UrlMap:          l.um.SelfLink,
+				SslCertificates: l.sslCertLinks(),
+			})
 		}
-		if err = l.cloud.CreateTargetHttpsProxy(newProxy); err != nil {
+		if err != nil {
 			return err
 		}
 
@@ -513,29 +962,180 @@ func (l *L7) checkHttpsProxy() (err error) {
 		return nil
 	}
 	if !utils.CompareLinks(proxy.UrlMap, l.um.SelfLink) {
-		glog.Infof("Https proxy %v has the wrong url map, setting %v overwriting %v",
+		l.log(proxy.Name, "update").Infof("Https proxy %v has the wrong url map, setting %v overwriting %v",
 			proxy.Name, l.um, proxy.UrlMap)
 		if err := l.cloud.SetUrlMapForTargetHttpsProxy(proxy, l.um); err != nil {
 			return err
 		}
 	}
-	cert := proxy.SslCertificates[0]
-	if !utils.CompareLinks(cert, l.sslCert.SelfLink) {
-		glog.Infof("Https proxy %v has the wrong ssl certs, setting %v overwriting %v",
-			proxy.Name, l.sslCert.SelfLink, cert)
-		if err := l.cloud.SetSslCertificateForTargetHttpsProxy(proxy, l.sslCert); err != nil {
+	// A proxy always serves a certificate map or certificates: the map is
+	// attached before the certificates are detached, and detached after
+	// they are attached.
+	if certMap != "" {
+		if err := l.ensureCertificateMap(proxy.Name); err != nil {
+			return err
+		}
+	}
+	certLinks := l.sslCertLinks()
+	if !reflect.DeepEqual(proxy.SslCertificates, certLinks) {
+		l.log(proxy.Name, "update").Infof("Https proxy %v has the wrong ssl certs, setting %v overwriting %v",
+			proxy.Name, certLinks, proxy.SslCertificates)
+		if err := l.httpsProxies.SetTargetHttpsProxySslCertificates(proxy.Name, certLinks); err != nil {
 			return err
 		}
 	}
-	glog.V(3).Infof("Created target https proxy %v", proxy.Name)
+	if certMap == "" {
+		if err := l.ensureCertificateMap(proxy.Name); err != nil {
+			return err
+		}
+	}
+	logging.V(3).Infof("Created target https proxy %v", proxy.Name)
 	l.tps = proxy
 	return nil
 }
 
+// certificateMap returns the name of the certificate map requested by the
+// FrontendConfig of the load balancer, and whether it configures one. An
+// empty name detaches the current map.
+func (l *L7) certificateMap() (string, bool) {
+	config := l.runtimeInfo.FrontendConfig
+	if config == nil || config.Spec.CertificateMap == nil {
+		return "", false
+	}
+	return *config.Spec.CertificateMap, true
+}
+
+// ensureCertificateMap attaches the certificate map requested by the
+// FrontendConfig of the load balancer to the given target HTTPS proxy. The
+// map is left untouched if the FrontendConfig does not configure one.
+func (l *L7) ensureCertificateMap(proxy string) error {
+	want, ok := l.certificateMap()
+	if !ok {
+		return nil
+	}
+	existing, err := l.httpsProxies.GetTargetHttpsProxyCertificateMap(proxy)
+	if err != nil {
+		return err
+	}
+	if existing == want {
+		return nil
+	}
+	l.log(proxy, "update").V(2).Infof("Setting certificate map of https proxy %v to %q (was %q)", proxy, want, existing)
+	if err := l.httpsProxies.SetTargetHttpsProxyCertificateMap(proxy, want); err != nil {
+		return fmt.Errorf("failed to set certificate map %q of https proxy %v: %v", want, proxy, err)
+	}
+	return nil
+}
+
+// ensureSslPolicy attaches the SSL policy requested by the FrontendConfig of
+// the load balancer, or else the default SSL policy, to the target HTTPS
+// proxy, and resets proxies which drifted from it. An empty policy name
+// detaches the current policy, the policy is left untouched if neither
+// configures one. A policy below the minimum TLS version isn't attached, the
+// default policy is attached instead, if any.
+func (l *L7) ensureSslPolicy() error {
+	if l.tps == nil {
+		return nil
+	}
+	defaults := l.sslPolicyDefaults
+	config := l.runtimeInfo.FrontendConfig
+	if (config == nil || config.Spec.SslPolicy == nil) && defaults.Name == "" {
+		return nil
+	}
+	existingLink, err := l.httpsProxies.GetTargetHttpsProxySslPolicy(l.tps.Name)
+	if err != nil {
+		return err
+	}
+	existing := existingLink[strings.LastIndex(existingLink, "/")+1:]
+
+	var policyErr error
+	name, link := defaults.Name, ""
+	if config != nil && config.Spec.SslPolicy != nil {
+		name = *config.Spec.SslPolicy
+		link, policyErr = l.checkSslPolicy(name, existing)
+		if policyErr != nil {
+			policyErr = fmt.Errorf("SSL policy %q of FrontendConfig %v/%v: %v", name, config.Namespace, config.Name, policyErr)
+			if defaults.Name == "" {
+				return policyErr
+			}
+			name = defaults.Name
+		}
+	}
+	if name == defaults.Name {
+		if link, err = l.checkSslPolicy(name, existing); err != nil {
+			return fmt.Errorf("default SSL policy %q: %v", name, err)
+		}
+	}
+	if existing != name {
+		if existing != "" {
+			l.log(l.tps.Name, "update").Warningf("SSL policy of https proxy %v drifted to %q, resetting it to %q", l.tps.Name, existing, name)
+		} else {
+			l.log(l.tps.Name, "update").V(2).Infof("Setting SSL policy of https proxy %v to %q", l.tps.Name, name)
+		}
+		if err := l.httpsProxies.SetTargetHttpsProxySslPolicy(l.tps.Name, link); err != nil {
+			return fmt.Errorf("failed to set SSL policy %q of https proxy %v: %v", name, l.tps.Name, err)
+		}
+	}
+	return policyErr
+}
+
+// checkSslPolicy returns the link of the SSL policy with the given name, empty
+// if no name, or an error if it doesn't exist or its minimum TLS version is
+// below the minimum of the cluster. The policy is only fetched if it must be
+// attached or checked.
+func (l *L7) checkSslPolicy(name, existing string) (string, error) {
+	minVersion := l.sslPolicyDefaults.MinTLSVersion
+	if name == "" {
+		if minVersion != "" && minVersion != MinTLSVersion10 {
+			return "", fmt.Errorf("a proxy without SSL policy accepts %v, below the minimum %v", MinTLSVersion10, minVersion)
+		}
+		return "", nil
+	}
+	if name == existing && minVersion == "" {
+		return "", nil
+	}
+	policy, err := l.httpsProxies.GetSslPolicy(name)
+	if utils.IsNotFoundError(err) {
+		return "", fmt.Errorf("SSL policy %v does not exist", name)
+	} else if err != nil {
+		return "", err
+	}
+	if err := CheckMinTLSVersion(policy, minVersion); err != nil {
+		return "", err
+	}
+	return policy.SelfLink, nil
+}
+
+// ensureQuicOverride sets the QUIC override requested by the FrontendConfig of
+// the load balancer on the target HTTPS proxy. The override is left untouched
+// if the FrontendConfig does not configure one.
+func (l *L7) ensureQuicOverride() error {
+	config := l.runtimeInfo.FrontendConfig
+	if l.tps == nil || config == nil || config.Spec.QuicOverride == "" {
+		return nil
+	}
+	existing, err := l.httpsProxies.GetTargetHttpsProxyQuicOverride(l.tps.Name)
+	if err != nil {
+		return err
+	}
+	// GCE omits the default override.
+	if existing == "" {
+		existing = frontendconfig.QuicOverrideNone
+	}
+	if existing == config.Spec.QuicOverride {
+		return nil
+	}
+	l.log(l.tps.Name, "update").V(2).Infof("Setting QUIC override of https proxy %v to %v (was %v)", l.tps.Name, config.Spec.QuicOverride, existing)
+	if err := l.httpsProxies.SetTargetHttpsProxyQuicOverride(l.tps.Name, config.Spec.QuicOverride); err != nil {
+		return fmt.Errorf("failed to set QUIC override %v of https proxy %v: %v", config.Spec.QuicOverride, l.tps.Name, err)
+	}
+	return nil
+}
+
 func (l *L7) checkForwardingRule(name, proxyLink, ip, portRange string) (fw *compute.ForwardingRule, err error) {
 	fw, _ = l.cloud.GetGlobalForwardingRule(name)
 	if fw != nil && (ip != "" && fw.IPAddress != ip || fw.PortRange != portRange) {
-		glog.Warningf("Recreating forwarding rule %v(%v), so it has %v(%v)",
+		l.log(name, "recreate").Warningf("Recreating forwarding rule %v(%v), so it has %v(%v)",
 			fw.IPAddress, fw.PortRange, ip, portRange)
 		if err = utils.IgnoreHTTPNotFound(l.cloud.DeleteGlobalForwardingRule(name)); err != nil {
 			return nil, err
@@ -544,13 +1144,14 @@ func (l *L7) checkForwardingRule(name, proxyLink, ip, portRange string) (fw *com
 	}
 	if fw == nil {
 		parts := strings.Split(proxyLink, "/")
-		glog.Infof("Creating forwarding rule for proxy %v and ip %v:%v", parts[len(parts)-1:], ip, portRange)
+		l.log(name, "create").Infof("Creating forwarding rule for proxy %v and ip %v:%v", parts[len(parts)-1:], ip, portRange)
 		rule := &compute.ForwardingRule{
-			Name:       name,
-			IPAddress:  ip,
-			Target:     proxyLink,
-			PortRange:  portRange,
-			IPProtocol: "TCP",
+			Name:        name,
+			Description: l.description(),
+			IPAddress:   ip,
+			Target:      proxyLink,
+			PortRange:   portRange,
+			IPProtocol:  "TCP",
 		}
 		if err = l.cloud.CreateGlobalForwardingRule(rule); err != nil {
 			return nil, err
@@ -562,9 +1163,9 @@ func (l *L7) checkForwardingRule(name, proxyLink, ip, portRange string) (fw *com
 	}
 	// TODO: If the port range and protocol don't match, recreate the rule
 	if utils.CompareLinks(fw.Target, proxyLink) {
-		glog.V(3).Infof("Forwarding rule %v already exists", fw.Name)
+		logging.V(3).Infof("Forwarding rule %v already exists", fw.Name)
 	} else {
-		glog.Infof("Forwarding rule %v has the wrong proxy, setting %v overwriting %v",
+		l.log(fw.Name, "update").Infof("Forwarding rule %v has the wrong proxy, setting %v overwriting %v",
 			fw.Name, fw.Target, proxyLink)
 		if err := l.cloud.SetProxyForGlobalForwardingRule(fw.Name, proxyLink); err != nil {
 			return nil, err
@@ -575,14 +1176,17 @@ func (l *L7) checkForwardingRule(name, proxyLink, ip, portRange string) (fw *com
 
 // getEffectiveIP returns a string with the IP to use in the HTTP and HTTPS
 // forwarding rules, and a boolean indicating if this is an IP the controller
-// should manage or not.
-func (l *L7) getEffectiveIP() (string, bool) {
+// should manage or not. Returns an error if the static IP named by the user is
+// a regional IP, which the global forwarding rules can't use.
+func (l *L7) getEffectiveIP() (string, bool, error) {
 
 	// A note on IP management:
 	// User specifies a different IP on startup:
 	//	- We create a forwarding rule with the given IP.
 	//		- If this ip doesn't exist in GCE, we create another one in the hope
 	//		  that they will rectify it later on.
+	//		- If this ip is a regional ip, we refuse to use it, since GCE would
+	//		  reject the forwarding rules anyway.
 	//	- In the happy case, no static ip is created or deleted by this controller.
 	// Controller allocates a staticIP/ephemeralIP, but user changes it:
 	//  - We still delete the old static IP, but only when we tear down the
@@ -595,20 +1199,23 @@ func (l *L7) getEffectiveIP() (string, bool) {
 	//    or deletes/modifies the Ingress.
 	// TODO: Handle the last case better.
 
-	if l.runtimeInfo.StaticIPName != "" {
+	if name := l.runtimeInfo.StaticIPName; name != "" {
 		// Existing static IPs allocated to forwarding rules will get orphaned
 		// till the Ingress is torn down.
-		if ip, err := l.cloud.GetGlobalAddress(l.runtimeInfo.StaticIPName); err != nil || ip == nil {
-			glog.Warningf("The given static IP name %v doesn't translate to an existing global static IP, ignoring it and allocating a new IP: %v",
-				l.runtimeInfo.StaticIPName, err)
-		} else {
-			return ip.Address, false
+		ip, err := l.cloud.GetGlobalAddress(name)
+		if err == nil && ip != nil {
+			return ip.Address, false, nil
+		}
+		if regionalIP, regionErr := l.cloud.GetRegionAddress(name, l.cloud.Region()); regionErr == nil && regionalIP != nil {
+			return "", false, fmt.Errorf("static IP %v(%v) is a regional IP of %v, the load balancer of an Ingress requires a global static IP", name, regionalIP.Address, l.cloud.Region())
 		}
+		logging.Warningf("The given static IP name %v doesn't translate to an existing global static IP, ignoring it and allocating a new IP: %v",
+			name, err)
 	}
 	if l.ip != nil {
-		return l.ip.Address, true
+		return l.ip.Address, true, nil
 	}
-	return "", true
+	return "", true, nil
 }
 
 func (l *L7) checkHttpForwardingRule() (err error) {
@@ -616,7 +1223,10 @@ func (l *L7) checkHttpForwardingRule() (err error) {
 		return fmt.Errorf("cannot create forwarding rule without proxy")
 	}
 	name := l.namer.ForwardingRule(l.Name, utils.HTTPProtocol)
-	address, _ := l.getEffectiveIP()
+	address, _, err := l.getEffectiveIP()
+	if err != nil {
+		return err
+	}
 	fw, err := l.checkForwardingRule(name, l.tp.SelfLink, address, httpDefaultPortRange)
 	if err != nil {
 		return err
@@ -627,11 +1237,14 @@ func (l *L7) checkHttpForwardingRule() (err error) {
 
 func (l *L7) checkHttpsForwardingRule() (err error) {
 	if l.tps == nil {
-		glog.V(3).Infof("No https target proxy for %v, not created https forwarding rule", l.Name)
+		logging.V(3).Infof("No https target proxy for %v, not created https forwarding rule", l.Name)
 		return nil
 	}
 	name := l.namer.ForwardingRule(l.Name, utils.HTTPSProtocol)
-	address, _ := l.getEffectiveIP()
+	address, _, err := l.getEffectiveIP()
+	if err != nil {
+		return err
+	}
 	fws, err := l.checkForwardingRule(name, l.tps.SelfLink, address, httpsDefaultPortRange)
 	if err != nil {
 		return err
@@ -640,25 +1253,101 @@ func (l *L7) checkHttpsForwardingRule() (err error) {
 	return nil
 }
 
+// getIPv6Address returns the IPv6 address to use in the IPv6 forwarding rules,
+// the address named by the user or one reserved by the controller.
+func (l *L7) getIPv6Address() (string, error) {
+	if name := l.runtimeInfo.StaticIPv6Name; name != "" {
+		ip, err := l.cloud.GetGlobalAddress(name)
+		if err != nil || ip == nil {
+			return "", fmt.Errorf("static IPv6 address %v does not exist: %v", name, err)
+		}
+		if ip.IpVersion != ipVersionIPv6 {
+			return "", fmt.Errorf("static IP %v(%v) is not an IPv6 address", name, ip.Address)
+		}
+		return ip.Address, nil
+	}
+	name := l.namer.IPv6ForwardingRule(l.Name, utils.HTTPProtocol)
+	ip, _ := l.cloud.GetGlobalAddress(name)
+	if ip == nil {
+		l.log(name, "create").Infof("Reserving IPv6 address")
+		if err := l.cloud.ReserveGlobalAddress(&compute.Address{Name: name, Description: l.description(), IpVersion: ipVersionIPv6}); err != nil {
+			return "", err
+		}
+		var err error
+		if ip, err = l.cloud.GetGlobalAddress(name); err != nil {
+			return "", err
+		}
+	}
+	l.ipv6 = ip
+	return ip.Address, nil
+}
+
+// checkIPv6ForwardingRules creates the IPv6 forwarding rules pointing to the
+// existing target proxies.
+func (l *L7) checkIPv6ForwardingRules() error {
+	address, err := l.getIPv6Address()
+	if err != nil {
+		return err
+	}
+	if l.tp != nil && l.runtimeInfo.AllowHTTP {
+		name := l.namer.IPv6ForwardingRule(l.Name, utils.HTTPProtocol)
+		if l.fw6, err = l.checkForwardingRule(name, l.tp.SelfLink, address, httpDefaultPortRange); err != nil {
+			return err
+		}
+	}
+	if l.tps != nil {
+		name := l.namer.IPv6ForwardingRule(l.Name, utils.HTTPSProtocol)
+		if l.fws6, err = l.checkForwardingRule(name, l.tps.SelfLink, address, httpsDefaultPortRange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIPv6ForwardingRules deletes the IPv6 forwarding rules, and the IPv6
+// address reserved by the controller, once IPv6 is disabled.
+func (l *L7) deleteIPv6ForwardingRules() error {
+	for _, fw := range []**compute.ForwardingRule{&l.fw6, &l.fws6} {
+		if *fw == nil {
+			continue
+		}
+		l.log((*fw).Name, "delete").V(2).Infof("Deleting IPv6 global forwarding rule")
+		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteGlobalForwardingRule((*fw).Name)); err != nil {
+			return err
+		}
+		*fw = nil
+	}
+	if l.ipv6 != nil {
+		l.log(l.ipv6.Name, "delete").V(2).Infof("Deleting IPv6 address %v", l.ipv6.Address)
+		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteGlobalAddress(l.ipv6.Name)); err != nil {
+			return err
+		}
+		l.ipv6 = nil
+	}
+	return nil
+}
+
 // checkStaticIP reserves a static IP allocated to the Forwarding Rule.
 func (l *L7) checkStaticIP() (err error) {
 	if l.fw == nil || l.fw.IPAddress == "" {
 		return fmt.Errorf("will not create static IP without a forwarding rule")
 	}
 	// Don't manage staticIPs if the user has specified an IP.
-	if address, manageStaticIP := l.getEffectiveIP(); !manageStaticIP {
-		glog.V(3).Infof("Not managing user specified static IP %v", address)
+	if address, manageStaticIP, err := l.getEffectiveIP(); err != nil {
+		return err
+	} else if !manageStaticIP {
+		logging.V(3).Infof("Not managing user specified static IP %v", address)
 		return nil
 	}
 	staticIPName := l.namer.ForwardingRule(l.Name, utils.HTTPProtocol)
 	ip, _ := l.cloud.GetGlobalAddress(staticIPName)
 	if ip == nil {
-		glog.Infof("Creating static ip %v", staticIPName)
-		err = l.cloud.ReserveGlobalAddress(&compute.Address{Name: staticIPName, Address: l.fw.IPAddress})
+		l.log(staticIPName, "create").Infof("Creating static ip")
+		err = l.cloud.ReserveGlobalAddress(&compute.Address{Name: staticIPName, Description: l.description(), Address: l.fw.IPAddress})
 		if err != nil {
 			if utils.IsHTTPErrorCode(err, http.StatusConflict) ||
 				utils.IsHTTPErrorCode(err, http.StatusBadRequest) {
-				glog.V(3).Infof("IP %v(%v) is already reserved, assuming it is OK to use.",
+				logging.V(3).Infof("IP %v(%v) is already reserved, assuming it is OK to use.",
 					l.fw.IPAddress, staticIPName)
 				return nil
 			}
@@ -677,25 +1366,44 @@ func (l *L7) edgeHop() error {
 	if err := l.checkUrlMap(l.glbcDefaultBackend); err != nil {
 		return err
 	}
+	if l.redirectsToHttps() {
+		if err := l.checkRedirectUrlMap(); err != nil {
+			return err
+		}
+	} else {
+		l.redirectUm = nil
+	}
 	if l.runtimeInfo.AllowHTTP {
 		if err := l.edgeHopHttp(); err != nil {
 			return err
 		}
+	} else if err := l.deleteHttpFrontend(); err != nil {
+		return err
+	}
+	// The TargetHTTPProxy no longer uses the redirect UrlMap, if it exists.
+	if l.redirectUm == nil {
+		if err := l.deleteRedirectUrlMap(); err != nil {
+			return err
+		}
 	}
 	// Defer promoting an ephemeral to a static IP until it's really needed.
-	if l.runtimeInfo.AllowHTTP && (l.runtimeInfo.TLS != nil || l.runtimeInfo.TLSName != "") {
-		glog.V(3).Infof("checking static ip for %v", l.Name)
+	if l.runtimeInfo.AllowHTTP && l.UsesTLS() {
+		logging.V(3).Infof("checking static ip for %v", l.Name)
 		if err := l.checkStaticIP(); err != nil {
 			return err
 		}
 	}
-	if l.runtimeInfo.TLS != nil || l.runtimeInfo.TLSName != "" {
-		glog.V(3).Infof("validating https for %v", l.Name)
+	if l.UsesTLS() {
+		logging.V(3).Infof("validating https for %v", l.Name)
 		if err := l.edgeHopHttps(); err != nil {
 			return err
 		}
 	}
-	return nil
+	if l.runtimeInfo.IPv6 {
+		logging.V(3).Infof("validating ipv6 for %v", l.Name)
+		return l.checkIPv6ForwardingRules()
+	}
+	return l.deleteIPv6ForwardingRules()
 }
 
 func (l *L7) edgeHopHttp() error {
@@ -708,17 +1416,51 @@ func (l *L7) edgeHopHttp() error {
 	return nil
 }
 
+// deleteHttpFrontend deletes the HTTP forwarding rules and target proxy once
+// HTTP is disallowed. They are looked up by name, since they may have been
+// created before the controller restarted. The static IP is kept, it is
+// shared with the HTTPS forwarding rule.
+func (l *L7) deleteHttpFrontend() error {
+	for _, name := range []string{
+		l.namer.ForwardingRule(l.Name, utils.HTTPProtocol),
+		l.namer.IPv6ForwardingRule(l.Name, utils.HTTPProtocol),
+	} {
+		if fw, _ := l.cloud.GetGlobalForwardingRule(name); fw != nil {
+			l.log(name, "delete").Infof("Deleting global forwarding rule, http is not allowed")
+			if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteGlobalForwardingRule(name)); err != nil {
+				return err
+			}
+		}
+	}
+	l.fw, l.fw6 = nil, nil
+	proxyName := l.namer.TargetProxy(l.Name, utils.HTTPProtocol)
+	if proxy, _ := l.cloud.GetTargetHttpProxy(proxyName); proxy != nil {
+		l.log(proxyName, "delete").Infof("Deleting target http proxy, http is not allowed")
+		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteTargetHttpProxy(proxyName)); err != nil {
+			return err
+		}
+	}
+	l.tp = nil
+	return nil
+}
+
 func (l *L7) edgeHopHttps() error {
-	if err := l.checkSSLCert(); err != nil {
+	if err := l.checkSSLCerts(); err != nil {
 		return err
 	}
 	if err := l.checkHttpsProxy(); err != nil {
 		return err
 	}
+	if err := l.ensureSslPolicy(); err != nil {
+		return err
+	}
+	if err := l.ensureQuicOverride(); err != nil {
+		return err
+	}
 	if err := l.checkHttpsForwardingRule(); err != nil {
 		return err
 	}
-	if err := l.deleteOldSSLCert(); err != nil {
+	if err := l.deleteOldSSLCerts(); err != nil {
 		return err
 	}
 	return nil
@@ -735,6 +1477,54 @@ func (l *L7) GetIP() string {
 	return ""
 }
 
+// GetIPv6 returns the ip associated with the IPv6 forwarding rules for this
+// l7, empty if IPv6 is disabled.
+func (l *L7) GetIPv6() string {
+	if l.fw6 != nil {
+		return l.fw6.IPAddress
+	}
+	if l.fws6 != nil {
+		return l.fws6.IPAddress
+	}
+	return ""
+}
+
+// UsesTLS returns true if the l7 terminates TLS.
+func (l *L7) UsesTLS() bool {
+	if name, _ := l.certificateMap(); name != "" {
+		return true
+	}
+	return len(l.runtimeInfo.TLS) > 0 || l.runtimeInfo.TLSName != "" || len(l.runtimeInfo.ManagedCertDomains) > 0
+}
+
+// CertificatesReady returns true if the targetHTTPSProxy of the l7 serves its
+// certificates, or its certificate map, through a forwarding rule, and its
+// managed certificate, if any, is provisioned.
+func (l *L7) CertificatesReady() bool {
+	if status, _ := l.ManagedCertificateStatus(); status != "" && status != managedCertStatusActive {
+		return false
+	}
+	certMap, _ := l.certificateMap()
+	return l.tps != nil && l.fws != nil && (len(l.sslCerts) > 0 || certMap != "")
+}
+
+// ManagedCertificateStatus returns the provisioning status of the managed
+// certificate of the l7, eg: PROVISIONING, and the status of its domains
+// which are not provisioned yet, eg: FAILED_NOT_VISIBLE. Empty if the l7 has
+// no managed certificate.
+func (l *L7) ManagedCertificateStatus() (string, map[string]string) {
+	if l.managedCert == nil || l.managedCert.Managed == nil {
+		return "", nil
+	}
+	domains := map[string]string{}
+	for domain, status := range l.managedCert.Managed.DomainStatus {
+		if status != managedCertStatusActive {
+			domains[domain] = status
+		}
+	}
+	return l.managedCert.Managed.Status, domains
+}
+
 // getNameForPathMatcher returns a name for a pathMatcher based on the given host rule.
 // The host rule can be a regex, the path matcher name used to associate the 2 cannot.
 func getNameForPathMatcher(hostRule string) string {
@@ -744,6 +1534,9 @@ func getNameForPathMatcher(hostRule string) string {
 }
 
 // UpdateUrlMap translates the given hostname: endpoint->port mapping into a gce url map.
+// The given routes of the paths, if any, answer their requests with a redirect
+// or split them between weighted backends instead of their backends, and may
+// rewrite them before they reach the backends.
 //
 // HostRule: Conceptually contains all PathRules for a given host.
 // PathMatcher: Associates a path rule with a host rule. Mostly an optimization.
@@ -784,7 +1577,7 @@ func getNameForPathMatcher(hostRule string) string {
 // and remove the mapping. When a new path is added to a host (happens
 // more frequently than service deletion) we just need to lookup the 1
 // pathmatcher of the host.
-func (l *L7) UpdateUrlMap(ingressRules utils.GCEURLMap) error {
+func (l *L7) UpdateUrlMap(ingressRules utils.GCEURLMap, routes *utils.URLMapRoutes) error {
 	if l.um == nil {
 		return fmt.Errorf("cannot add url without an urlmap")
 	}
@@ -793,11 +1586,14 @@ func (l *L7) UpdateUrlMap(ingressRules utils.GCEURLMap) error {
 	// backend, it applies to all host rules as well as to the urlmap itself.
 	// If it doesn't the urlmap might have a stale default, so replace it with
 	// glbc's default backend.
+	defaultService := ""
 	defaultBackend := ingressRules.GetDefaultBackend()
 	if defaultBackend != nil {
-		l.um.DefaultService = defaultBackend.SelfLink
+		defaultService = defaultBackend.SelfLink
+	} else if l.glbcDefaultBackend != nil {
+		defaultService = l.glbcDefaultBackend.SelfLink
 	} else {
-		l.um.DefaultService = l.glbcDefaultBackend.SelfLink
+		return fmt.Errorf("cannot update url map %v without a default backend", l.um.Name)
 	}
 
 	// Every update replaces the entire urlmap.
@@ -805,164 +1601,259 @@ func (l *L7) UpdateUrlMap(ingressRules utils.GCEURLMap) error {
 	// this needs modification. For now, there is a 1:1 mapping of urlmaps to
 	// Ingresses, so if the given Ingress doesn't have a host rule we should
 	// delete the path to that backend.
-	l.um.HostRules = []*compute.HostRule{}
-	l.um.PathMatchers = []*compute.PathMatcher{}
-
-	for hostname, urlToBackend := range ingressRules {
+	// The url map is written through the REST API, the routes being unknown
+	// to the vendored compute API. Hosts and paths are sorted, so that an
+	// unchanged url map isn't updated.
+	um := &UrlMap{Name: l.um.Name, Description: l.um.Description, DefaultService: defaultService}
+	hosts := []string{}
+	for hostname := range ingressRules {
+		hosts = append(hosts, hostname)
+	}
+	sort.Strings(hosts)
+	for _, hostname := range hosts {
+		urlToBackend := ingressRules[hostname]
 		// Create a host rule
 		// Create a path matcher
 		// Add all given endpoint:backends to pathRules in path matcher
 		pmName := getNameForPathMatcher(hostname)
-		l.um.HostRules = append(l.um.HostRules, &compute.HostRule{
+		um.HostRules = append(um.HostRules, &HostRule{
 			Hosts:       []string{hostname},
 			PathMatcher: pmName,
 		})
 
-		pathMatcher := &compute.PathMatcher{
+		pathMatcher := &PathMatcher{
 			Name:           pmName,
-			DefaultService: l.um.DefaultService,
-			PathRules:      []*compute.PathRule{},
+			DefaultService: defaultService,
 		}
 
+		if rules := routes.HostRules(hostname); len(rules) > 0 {
+			pathMatcher.RouteRules = toRouteRules(hostname, rules, urlToBackend, routes)
+			um.PathMatchers = append(um.PathMatchers, pathMatcher)
+			continue
+		}
 		// Longest prefix wins. For equal rules, first hit wins, i.e the second
 		// /foo rule when the first is deleted.
-		for expr, be := range urlToBackend {
-			pathMatcher.PathRules = append(
-				pathMatcher.PathRules, &compute.PathRule{Paths: []string{expr}, Service: be.SelfLink})
+		paths := []string{}
+		for expr := range urlToBackend {
+			paths = append(paths, expr)
 		}
-		l.um.PathMatchers = append(l.um.PathMatchers, pathMatcher)
+		sort.Strings(paths)
+		for _, expr := range paths {
+			a, ok := toPathAction(urlToBackend[expr], routes.Path(hostname, expr))
+			if !ok {
+				continue
+			}
+			pathMatcher.PathRules = append(pathMatcher.PathRules, &PathRule{
+				Paths:       []string{expr},
+				Service:     a.service,
+				RouteAction: a.routeAction,
+				UrlRedirect: a.urlRedirect,
+			})
+		}
+		um.PathMatchers = append(um.PathMatchers, pathMatcher)
 	}
-	oldMap, _ := l.cloud.GetUrlMap(l.um.Name)
-	if oldMap != nil && mapsEqual(oldMap, l.um) {
-		glog.Infof("UrlMap for l7 %v is unchanged", l.Name)
+	oldMap, err := l.urlMaps.GetExtendedUrlMap(l.um.Name)
+	if err != nil {
+		return err
+	}
+	if routingEqual(oldMap, um) {
+		l.log(l.um.Name, "update").Infof("UrlMap is unchanged")
 		return nil
 	}
 
-	glog.V(3).Infof("Updating URLMap: %q", l.Name)
-	if err := l.cloud.UpdateUrlMap(l.um); err != nil {
+	l.log(l.um.Name, "update").V(3).Infof("Updating URLMap")
+	um.Fingerprint = oldMap.Fingerprint
+	if err := l.urlMaps.UpdateExtendedUrlMap(um); err != nil {
 		return err
 	}
 
-	um, err := l.cloud.GetUrlMap(l.um.Name)
+	vum, err := l.cloud.GetUrlMap(l.um.Name)
 	if err != nil {
 		return err
 	}
 
-	l.um = um
+	l.um = vum
 	return nil
 }
 
-func mapsEqual(a, b *compute.UrlMap) bool {
-	if a.DefaultService != b.DefaultService {
-		return false
-	}
-	if len(a.HostRules) != len(b.HostRules) {
-		return false
-	}
-	for i := range a.HostRules {
-		a := a.HostRules[i]
-		b := b.HostRules[i]
-		if a.Description != b.Description {
-			return false
+// pathAction is what a path rule or a route rule of a url map does with the
+// requests: forward them to a backend service, or split them between weighted
+// backend services, or answer them with a redirect.
+type pathAction struct {
+	service     string
+	routeAction *HttpRouteAction
+	urlRedirect *HttpRedirectAction
+}
+
+// toPathAction returns the action of the given route, or of the given backend
+// if the route doesn't answer or split the requests. ok is false if there is
+// neither.
+func toPathAction(be *compute.BackendService, route *utils.PathRoute) (a pathAction, ok bool) {
+	if route == nil {
+		route = &utils.PathRoute{}
+	}
+	switch {
+	case route.Redirect != nil:
+		redirect := HttpRedirectAction(*route.Redirect)
+		if redirect.RedirectResponseCode == "" {
+			redirect.RedirectResponseCode = "MOVED_PERMANENTLY_DEFAULT"
 		}
-		if len(a.Hosts) != len(b.Hosts) {
-			return false
+		a.urlRedirect = &redirect
+		return a, true
+	case len(route.WeightedBackends) > 0:
+		a.routeAction = &HttpRouteAction{}
+		for _, wb := range route.WeightedBackends {
+			a.routeAction.WeightedBackendServices = append(a.routeAction.WeightedBackendServices, &WeightedBackendService{BackendService: wb.BackendService, Weight: wb.Weight})
 		}
-		for i := range a.Hosts {
-			if a.Hosts[i] != b.Hosts[i] {
-				return false
-			}
-		}
-		if a.PathMatcher != b.PathMatcher {
-			return false
+	case be != nil:
+		a.service = be.SelfLink
+	default:
+		return a, false
+	}
+	if route.Rewrite != nil {
+		if a.routeAction == nil {
+			a.routeAction = &HttpRouteAction{}
 		}
+		rewrite := UrlRewrite(*route.Rewrite)
+		a.routeAction.UrlRewrite = &rewrite
 	}
-	if len(a.PathMatchers) != len(b.PathMatchers) {
-		return false
+	return a, true
+}
+
+// toRouteRules returns the route rules of the given host with the given rules,
+// in priority order, followed by its paths, since a path matcher can't have
+// both path rules and route rules. The paths keep the precedence of path
+// rules: the exact paths first, then the longest prefixes.
+func toRouteRules(hostname string, rules []*utils.RouteRule, urlToBackend map[string]*compute.BackendService, routes *utils.URLMapRoutes) []*HttpRouteRule {
+	var res []*HttpRouteRule
+	add := func(matches []utils.RouteMatch, a pathAction, headerAction *utils.HeaderAction) {
+		res = append(res, &HttpRouteRule{
+			// GCE requires distinct priorities.
+			Priority:     int64(len(res)),
+			MatchRules:   matches,
+			Service:      a.service,
+			RouteAction:  a.routeAction,
+			UrlRedirect:  a.urlRedirect,
+			HeaderAction: headerAction,
+		})
 	}
-	for i := range a.PathMatchers {
-		a := a.PathMatchers[i]
-		b := b.PathMatchers[i]
-		if a.DefaultService != b.DefaultService {
-			return false
+	sorted := append([]*utils.RouteRule{}, rules...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	for _, r := range sorted {
+		var be *compute.BackendService
+		if r.Service != "" {
+			be = &compute.BackendService{SelfLink: r.Service}
 		}
-		if a.Description != b.Description {
-			return false
+		if a, ok := toPathAction(be, &r.Route); ok {
+			add(r.Matches, a, r.HeaderAction)
 		}
-		if a.Name != b.Name {
-			return false
+	}
+	paths := []string{}
+	for expr := range urlToBackend {
+		paths = append(paths, expr)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		pi, pj := strings.HasSuffix(paths[i], "*"), strings.HasSuffix(paths[j], "*")
+		if pi != pj {
+			return pj
 		}
-		if len(a.PathRules) != len(b.PathRules) {
-			return false
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) > len(paths[j])
 		}
-		for i := range a.PathRules {
-			a := a.PathRules[i]
-			b := b.PathRules[i]
-			if len(a.Paths) != len(b.Paths) {
-				return false
-			}
-			for i := range a.Paths {
-				if a.Paths[i] != b.Paths[i] {
-					return false
-				}
-			}
-			if a.Service != b.Service {
-				return false
-			}
+		return paths[i] < paths[j]
+	})
+	for _, expr := range paths {
+		a, ok := toPathAction(urlToBackend[expr], routes.Path(hostname, expr))
+		if !ok {
+			continue
+		}
+		match := utils.RouteMatch{FullPathMatch: expr}
+		if strings.HasSuffix(expr, "*") {
+			match = utils.RouteMatch{PrefixMatch: strings.TrimSuffix(expr, "*")}
 		}
+		add([]utils.RouteMatch{match}, a, nil)
 	}
-	return true
+	return res
+}
+
+// routingEqual returns true if the given url maps route the requests the same
+// way.
+func routingEqual(a, b *UrlMap) bool {
+	return a.DefaultService == b.DefaultService &&
+		reflect.DeepEqual(a.HostRules, b.HostRules) &&
+		reflect.DeepEqual(a.PathMatchers, b.PathMatchers)
 }
 
 // Cleanup deletes resources specific to this l7 in the right order.
 // forwarding rule -> target proxy -> url map
 // This leaves backends and health checks, which are shared across loadbalancers.
 func (l *L7) Cleanup() error {
+	if err := l.deleteIPv6ForwardingRules(); err != nil {
+		return err
+	}
 	if l.fw != nil {
-		glog.V(2).Infof("Deleting global forwarding rule %v", l.fw.Name)
+		l.log(l.fw.Name, "delete").V(2).Infof("Deleting global forwarding rule")
 		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteGlobalForwardingRule(l.fw.Name)); err != nil {
 			return err
 		}
 		l.fw = nil
 	}
 	if l.fws != nil {
-		glog.V(2).Infof("Deleting global forwarding rule %v", l.fws.Name)
+		l.log(l.fws.Name, "delete").V(2).Infof("Deleting global forwarding rule")
 		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteGlobalForwardingRule(l.fws.Name)); err != nil {
 			return err
 		}
 		l.fws = nil
 	}
 	if l.ip != nil {
-		glog.V(2).Infof("Deleting static IP %v(%v)", l.ip.Name, l.ip.Address)
+		l.log(l.ip.Name, "delete").V(2).Infof("Deleting static IP %v", l.ip.Address)
 		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteGlobalAddress(l.ip.Name)); err != nil {
 			return err
 		}
 		l.ip = nil
 	}
 	if l.tps != nil {
-		glog.V(2).Infof("Deleting target https proxy %v", l.tps.Name)
+		l.log(l.tps.Name, "delete").V(2).Infof("Deleting target https proxy")
 		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteTargetHttpsProxy(l.tps.Name)); err != nil {
 			return err
 		}
 		l.tps = nil
 	}
-	// Delete the SSL cert if it is from a secret, not referencing a pre-created GCE cert.
-	if l.sslCert != nil && l.runtimeInfo.TLSName == "" {
-		glog.V(2).Infof("Deleting sslcert %v", l.sslCert.Name)
-		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteSslCertificate(l.sslCert.Name)); err != nil {
-			return err
+	// Delete the SSL certs created from secrets, the pre-shared certs are
+	// kept.
+	if len(l.sslCerts) > 0 {
+		for _, cert := range l.sslCerts {
+			if !l.namer.IsSSLCert(cert.Name) {
+				continue
+			}
+			if l.namer.IsHashedSSLCert(cert.Name) {
+				// Other loadbalancers may serve the same certificate.
+				l.releasedSSLCerts = append(l.releasedSSLCerts, cert.Name)
+				continue
+			}
+			l.log(cert.Name, "delete").V(2).Infof("Deleting sslcert")
+			if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteSslCertificate(cert.Name)); err != nil {
+				return err
+			}
 		}
-		l.sslCert = nil
+		l.sslCerts = nil
 	}
 	if l.tp != nil {
-		glog.V(2).Infof("Deleting target http proxy %v", l.tp.Name)
+		l.log(l.tp.Name, "delete").V(2).Infof("Deleting target http proxy")
 		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteTargetHttpProxy(l.tp.Name)); err != nil {
 			return err
 		}
 		l.tp = nil
 	}
+	if l.redirectUm != nil {
+		l.log(l.redirectUm.Name, "delete").V(2).Infof("Deleting redirect url map")
+		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteUrlMap(l.redirectUm.Name)); err != nil {
+			return err
+		}
+		l.redirectUm = nil
+	}
 	if l.um != nil {
-		glog.V(2).Infof("Deleting url map %v", l.um.Name)
+		l.log(l.um.Name, "delete").V(2).Infof("Deleting url map")
 		if err := utils.IgnoreHTTPNotFound(l.cloud.DeleteUrlMap(l.um.Name)); err != nil {
 			return err
 		}
@@ -971,8 +1862,8 @@ func (l *L7) Cleanup() error {
 	return nil
 }
 
-// getBackendNames returns the names of backends in this L7 urlmap.
-func (l *L7) getBackendNames() []string {
+// BackendNames returns the names of backends in this L7 urlmap.
+func (l *L7) BackendNames() []string {
 	if l.um == nil {
 		return []string{}
 	}
@@ -1003,7 +1894,7 @@ func GetLBAnnotations(l7 *L7, existing map[string]string, backendPool backends.B
 	if existing == nil {
 		existing = map[string]string{}
 	}
-	backends := l7.getBackendNames()
+	backends := l7.BackendNames()
 	backendState := map[string]string{}
 	for _, beName := range backends {
 		backendState[beName] = backendPool.Status(beName)
@@ -1014,12 +1905,17 @@ func GetLBAnnotations(l7 *L7, existing map[string]string, backendPool backends.B
 		jsonBackendState = string(b)
 	}
 	existing[fmt.Sprintf("%v/url-map", utils.K8sAnnotationPrefix)] = l7.um.Name
-	// Forwarding rule and target proxy might not exist if allowHTTP == false
+	// Forwarding rule and target proxy might not exist if allowHTTP == false,
+	// or no longer exist if it was flipped after creation.
 	if l7.fw != nil {
 		existing[fmt.Sprintf("%v/forwarding-rule", utils.K8sAnnotationPrefix)] = l7.fw.Name
+	} else {
+		delete(existing, fmt.Sprintf("%v/forwarding-rule", utils.K8sAnnotationPrefix))
 	}
 	if l7.tp != nil {
 		existing[fmt.Sprintf("%v/target-proxy", utils.K8sAnnotationPrefix)] = l7.tp.Name
+	} else {
+		delete(existing, fmt.Sprintf("%v/target-proxy", utils.K8sAnnotationPrefix))
 	}
 	// HTTPs resources might not exist if TLS == nil
 	if l7.fws != nil {
@@ -1031,8 +1927,24 @@ func GetLBAnnotations(l7 *L7, existing map[string]string, backendPool backends.B
 	if l7.ip != nil {
 		existing[fmt.Sprintf("%v/static-ip", utils.K8sAnnotationPrefix)] = l7.ip.Name
 	}
-	if l7.sslCert != nil {
-		existing[fmt.Sprintf("%v/ssl-cert", utils.K8sAnnotationPrefix)] = l7.sslCert.Name
+	// IPv6 resources only exist if requested by the Ingress.
+	if l7.fw6 != nil {
+		existing[fmt.Sprintf("%v/ipv6-forwarding-rule", utils.K8sAnnotationPrefix)] = l7.fw6.Name
+	} else {
+		delete(existing, fmt.Sprintf("%v/ipv6-forwarding-rule", utils.K8sAnnotationPrefix))
+	}
+	if l7.fws6 != nil {
+		existing[fmt.Sprintf("%v/ipv6-https-forwarding-rule", utils.K8sAnnotationPrefix)] = l7.fws6.Name
+	}
+	if l7.ipv6 != nil {
+		existing[fmt.Sprintf("%v/static-ipv6", utils.K8sAnnotationPrefix)] = l7.ipv6.Name
+	}
+	if len(l7.sslCerts) > 0 {
+		var names []string
+		for _, cert := range l7.sslCerts {
+			names = append(names, cert.Name)
+		}
+		existing[fmt.Sprintf("%v/ssl-cert", utils.K8sAnnotationPrefix)] = strings.Join(names, ",")
 	}
 	// TODO: We really want to know *when* a backend flipped states.
 	existing[fmt.Sprintf("%v/backends", utils.K8sAnnotationPrefix)] = jsonBackendState
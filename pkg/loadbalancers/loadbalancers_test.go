@@ -18,12 +18,15 @@ package loadbalancers
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	compute "google.golang.org/api/compute/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	"k8s.io/ingress-gce/pkg/backends"
+	"k8s.io/ingress-gce/pkg/frontendconfig"
 	"k8s.io/ingress-gce/pkg/healthchecks"
 	"k8s.io/ingress-gce/pkg/instances"
 	"k8s.io/ingress-gce/pkg/networkendpointgroup"
@@ -38,18 +41,18 @@ var (
 	testDefaultBeNodePort = backends.ServicePort{Port: 3000, Protocol: utils.ProtocolHTTP}
 )
 
-func newFakeLoadBalancerPool(f LoadBalancers, t *testing.T) LoadBalancerPool {
+func newFakeLoadBalancerPool(f *FakeLoadBalancers, t *testing.T) LoadBalancerPool {
 	fakeBackends := backends.NewFakeBackendServices(func(op int, be *compute.BackendService) error { return nil })
 	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
 	fakeHCP := healthchecks.NewFakeHealthCheckProvider()
 	fakeNEG := networkendpointgroup.NewFakeNetworkEndpointGroupCloud("test-subnet", "test-network")
 	namer := &utils.Namer{}
-	healthChecker := healthchecks.NewHealthChecker(fakeHCP, "/", namer)
+	healthChecker := healthchecks.NewHealthChecker(fakeHCP, fakeHCP, "/", namer, false)
 	nodePool := instances.NewNodePool(fakeIGs, namer)
 	nodePool.Init(&instances.FakeZoneLister{Zones: []string{defaultZone}})
 	backendPool := backends.NewBackendPool(
-		fakeBackends, fakeNEG, healthChecker, nodePool, namer, []int64{}, false)
-	return NewLoadBalancerPool(f, backendPool, testDefaultBeNodePort, namer)
+		fakeBackends, fakeNEG, backends.NewFakeSecurityPolicies(), backends.NewFakeExtendedBackendServices(), healthChecker, nodePool, namer, []int64{}, false)
+	return NewLoadBalancerPool(f, NewFakeTargetHttpsProxies(f), NewFakeExtendedUrlMaps(f), backendPool, &testDefaultBeNodePort, namer, SslPolicyDefaults{})
 }
 
 func TestCreateHTTPLoadBalancer(t *testing.T) {
@@ -92,7 +95,7 @@ func TestCreateHTTPSLoadBalancer(t *testing.T) {
 	lbInfo := &L7RuntimeInfo{
 		Name:      "test",
 		AllowHTTP: false,
-		TLS:       &TLSCerts{Key: "key", Cert: "cert"},
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}},
 	}
 	f := NewFakeLoadBalancers(lbInfo.Name)
 	pool := newFakeLoadBalancerPool(f, t)
@@ -116,15 +119,383 @@ func TestCreateHTTPSLoadBalancer(t *testing.T) {
 	}
 }
 
+func TestSslPolicy(t *testing.T) {
+	policy, other, missing, none := "modern-tls", "compatible-tls", "missing", ""
+	lbInfo := &L7RuntimeInfo{
+		Name:           "test",
+		AllowHTTP:      false,
+		TLS:            []*TLSCerts{{Key: "key", Cert: "cert"}},
+		FrontendConfig: &frontendconfig.FrontendConfig{},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	httpsProxies := NewFakeTargetHttpsProxies(f, policy, other)
+	pool := newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).httpsProxies = httpsProxies
+
+	for _, tc := range []struct {
+		desc     string
+		policy   *string
+		wantLink string
+		wantErr  bool
+	}{
+		{desc: "no ssl policy"},
+		{desc: "attach", policy: &policy, wantLink: "global/sslPolicies/" + policy},
+		{desc: "missing policy", policy: &missing, wantLink: "global/sslPolicies/" + policy, wantErr: true},
+		{desc: "unset policy is untouched", wantLink: "global/sslPolicies/" + policy},
+		{desc: "switch", policy: &other, wantLink: "global/sslPolicies/" + other},
+		{desc: "detach", policy: &none},
+	} {
+		lbInfo.FrontendConfig.Spec.SslPolicy = tc.policy
+		if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); (err != nil) != tc.wantErr {
+			t.Errorf("%v: Sync() = %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		link, _ := httpsProxies.GetTargetHttpsProxySslPolicy(f.tpName(true))
+		if link != tc.wantLink {
+			t.Errorf("%v: got SSL policy %q, want %q", tc.desc, link, tc.wantLink)
+		}
+	}
+}
+
+func TestSslPolicyDefaults(t *testing.T) {
+	modern, compatible, none := "modern-tls", "compatible-tls", ""
+	lbInfo := &L7RuntimeInfo{
+		Name:           "test",
+		AllowHTTP:      false,
+		TLS:            []*TLSCerts{{Key: "key", Cert: "cert"}},
+		FrontendConfig: &frontendconfig.FrontendConfig{},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	httpsProxies := NewFakeTargetHttpsProxies(f, modern, compatible)
+	httpsProxies.policies[modern].MinTlsVersion = MinTLSVersion12
+	newPool := func(defaults SslPolicyDefaults) LoadBalancerPool {
+		pool := newFakeLoadBalancerPool(f, t)
+		pool.(*L7s).httpsProxies = httpsProxies
+		pool.(*L7s).sslPolicyDefaults = defaults
+		return pool
+	}
+	pool := newPool(SslPolicyDefaults{Name: modern, MinTLSVersion: MinTLSVersion12})
+
+	for _, tc := range []struct {
+		desc    string
+		policy  *string
+		drift   *string
+		want    string
+		wantErr bool
+	}{
+		{desc: "default policy", want: modern},
+		{desc: "drift is reset", drift: &compatible, want: modern},
+		{desc: "detached policy is reset", drift: &none, want: modern},
+		{desc: "FrontendConfig overrides", policy: &modern, want: modern},
+		{desc: "FrontendConfig below the minimum", policy: &compatible, want: modern, wantErr: true},
+		{desc: "FrontendConfig detach below the minimum", policy: &none, want: modern, wantErr: true},
+	} {
+		tpName := f.tpName(true)
+		if tc.drift != nil {
+			link := ""
+			if *tc.drift != "" {
+				link = "global/sslPolicies/" + *tc.drift
+			}
+			httpsProxies.SetTargetHttpsProxySslPolicy(tpName, link)
+		}
+		lbInfo.FrontendConfig.Spec.SslPolicy = tc.policy
+		if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); (err != nil) != tc.wantErr {
+			t.Errorf("%v: Sync() = %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if link, _ := httpsProxies.GetTargetHttpsProxySslPolicy(tpName); link != "global/sslPolicies/"+tc.want {
+			t.Errorf("%v: got SSL policy %q, want %q", tc.desc, link, tc.want)
+		}
+	}
+
+	// Without a minimum, FrontendConfigs may detach the default policy.
+	pool = newPool(SslPolicyDefaults{Name: modern})
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if link, _ := httpsProxies.GetTargetHttpsProxySslPolicy(f.tpName(true)); link != "" {
+		t.Errorf("Got SSL policy %q, want none", link)
+	}
+}
+
+func TestQuicOverride(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:           "test",
+		AllowHTTP:      false,
+		TLS:            []*TLSCerts{{Key: "key", Cert: "cert"}},
+		FrontendConfig: &frontendconfig.FrontendConfig{},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	httpsProxies := NewFakeTargetHttpsProxies(f)
+	pool := newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).httpsProxies = httpsProxies
+
+	for _, tc := range []struct {
+		desc     string
+		override string
+		want     string
+	}{
+		{desc: "no override"},
+		{desc: "enable", override: frontendconfig.QuicOverrideEnable, want: frontendconfig.QuicOverrideEnable},
+		{desc: "unset override is untouched", want: frontendconfig.QuicOverrideEnable},
+		{desc: "disable", override: frontendconfig.QuicOverrideDisable, want: frontendconfig.QuicOverrideDisable},
+		{desc: "reset", override: frontendconfig.QuicOverrideNone, want: frontendconfig.QuicOverrideNone},
+	} {
+		lbInfo.FrontendConfig.Spec.QuicOverride = tc.override
+		if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+			t.Errorf("%v: Sync() = %v, want nil", tc.desc, err)
+		}
+		got, _ := httpsProxies.GetTargetHttpsProxyQuicOverride(f.tpName(true))
+		if got != tc.want {
+			t.Errorf("%v: got QUIC override %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestHttpsRedirect(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:           "test",
+		AllowHTTP:      true,
+		TLS:            []*TLSCerts{{Key: "key", Cert: "cert"}},
+		FrontendConfig: &frontendconfig.FrontendConfig{},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	urlMaps := NewFakeExtendedUrlMaps(f)
+	pool := newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).urlMaps = urlMaps
+
+	for _, tc := range []struct {
+		desc     string
+		redirect *frontendconfig.HttpsRedirectConfig
+		want     string
+	}{
+		{desc: "no redirect"},
+		{desc: "default redirect", redirect: &frontendconfig.HttpsRedirectConfig{Enabled: true}, want: "MOVED_PERMANENTLY_DEFAULT"},
+		{desc: "response code", redirect: &frontendconfig.HttpsRedirectConfig{Enabled: true, ResponseCodeName: "FOUND"}, want: "FOUND"},
+		{desc: "disabled redirect", redirect: &frontendconfig.HttpsRedirectConfig{Enabled: false}},
+	} {
+		lbInfo.FrontendConfig.Spec.RedirectToHttps = tc.redirect
+		if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+			t.Fatalf("%v: Sync() = %v, want nil", tc.desc, err)
+		}
+		l7, err := pool.Get(lbInfo.Name)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.desc, err)
+		}
+		redirectName := l7.namer.RedirectUrlMap(l7.Name)
+		tp, err := f.GetTargetHttpProxy(f.tpName(false))
+		if err != nil {
+			t.Fatalf("%v: %v", tc.desc, err)
+		}
+		um, _ := urlMaps.GetExtendedUrlMap(redirectName)
+		if tc.want == "" {
+			if um != nil {
+				t.Errorf("%v: got redirect url map %+v, want none", tc.desc, um)
+			}
+			if tp.UrlMap != l7.um.SelfLink {
+				t.Errorf("%v: got http proxy url map %q, want %q", tc.desc, tp.UrlMap, l7.um.SelfLink)
+			}
+			continue
+		}
+		if um == nil || um.DefaultUrlRedirect == nil || !um.DefaultUrlRedirect.HttpsRedirect || um.DefaultUrlRedirect.RedirectResponseCode != tc.want {
+			t.Errorf("%v: got redirect url map %+v, want a redirect to https with %v", tc.desc, um, tc.want)
+			continue
+		}
+		if tp.UrlMap != um.SelfLink {
+			t.Errorf("%v: got http proxy url map %q, want %q", tc.desc, tp.UrlMap, um.SelfLink)
+		}
+	}
+
+	// The redirect url map is deleted with the load balancer.
+	lbInfo.FrontendConfig.Spec.RedirectToHttps = &frontendconfig.HttpsRedirectConfig{Enabled: true}
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("Sync() = %v, want nil", err)
+	}
+	if err := pool.Delete(lbInfo.Name); err != nil {
+		t.Fatalf("Delete() = %v, want nil", err)
+	}
+	if len(f.Um) != 0 {
+		t.Errorf("Got url maps %v after deleting the load balancer, want none", f.Um)
+	}
+}
+
+// Tests that the managed certificate follows the pre-shared certs, reports
+// its provisioning status, and is replaced when the domains change once the
+// new one is active.
+func TestManagedCertificate(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:               "test",
+		AllowHTTP:          false,
+		TLSName:            "pre-shared-1",
+		ManagedCertDomains: []string{"a.example.com", "b.example.com"},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	f.CreateSslCertificate(&compute.SslCertificate{Name: "pre-shared-1"})
+	httpsProxies := NewFakeTargetHttpsProxies(f)
+	pool := newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).httpsProxies = httpsProxies
+
+	sync := func() *L7 {
+		t.Helper()
+		if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+			t.Fatalf("pool.Sync() = %v", err)
+		}
+		if err := pool.GC([]string{lbInfo.Name}); err != nil {
+			t.Fatalf("pool.GC() = %v", err)
+		}
+		l7, err := pool.Get(lbInfo.Name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return l7
+	}
+	managedCertName := func() string {
+		t.Helper()
+		tps, err := f.GetTargetHttpsProxy(f.tpName(true))
+		if err != nil {
+			t.Fatalf("expected https proxy to exist: %v", err)
+		}
+		if len(tps.SslCertificates) != 2 || tps.SslCertificates[0] != "pre-shared-1" {
+			t.Fatalf("expected target proxy certs [pre-shared-1, <managed cert>], got %v", tps.SslCertificates)
+		}
+		return tps.SslCertificates[1]
+	}
+
+	l7 := sync()
+	name := managedCertName()
+	cert, err := httpsProxies.GetManagedSslCertificate(name)
+	if err != nil {
+		t.Fatalf("expected managed cert %v to exist: %v", name, err)
+	}
+	if cert.Type != "MANAGED" || !reflect.DeepEqual(cert.Managed.Domains, lbInfo.ManagedCertDomains) {
+		t.Errorf("got managed cert %+v, want the domains %v", cert, lbInfo.ManagedCertDomains)
+	}
+	if status, _ := l7.ManagedCertificateStatus(); status != "PROVISIONING" || l7.CertificatesReady() {
+		t.Errorf("got managed cert status %q, ready %v, want PROVISIONING and not ready", status, l7.CertificatesReady())
+	}
+
+	httpsProxies.SetManagedSslCertificateStatus(name, "PROVISIONING", map[string]string{"a.example.com": "ACTIVE", "b.example.com": "FAILED_NOT_VISIBLE"})
+	l7 = sync()
+	if _, domains := l7.ManagedCertificateStatus(); !reflect.DeepEqual(domains, map[string]string{"b.example.com": "FAILED_NOT_VISIBLE"}) {
+		t.Errorf("got pending domains %v, want b.example.com FAILED_NOT_VISIBLE", domains)
+	}
+	httpsProxies.SetManagedSslCertificateStatus(name, "ACTIVE", map[string]string{"a.example.com": "ACTIVE", "b.example.com": "ACTIVE"})
+	if l7 = sync(); !l7.CertificatesReady() {
+		t.Errorf("expected the certificates to be ready once the managed cert is active")
+	}
+
+	// A new managed cert replaces the old one when the domains change, once
+	// it is active: the old one stays on the proxy while it provisions.
+	lbInfo.ManagedCertDomains = []string{"a.example.com"}
+	sync()
+	tps, err := f.GetTargetHttpsProxy(f.tpName(true))
+	if err != nil {
+		t.Fatalf("expected https proxy to exist: %v", err)
+	}
+	if len(tps.SslCertificates) != 3 || tps.SslCertificates[0] != "pre-shared-1" || tps.SslCertificates[1] == name || tps.SslCertificates[2] != name {
+		t.Fatalf("expected target proxy certs [pre-shared-1, <new managed cert>, %v], got %v", name, tps.SslCertificates)
+	}
+	newName := tps.SslCertificates[1]
+	sync()
+	if tps, _ := f.GetTargetHttpsProxy(f.tpName(true)); len(tps.SslCertificates) != 3 {
+		t.Errorf("expected the old managed cert to stay while the new one provisions, got %v", tps.SslCertificates)
+	}
+	if _, err := f.GetSslCertificate(name); err != nil {
+		t.Errorf("expected managed cert %v to exist while the new one provisions: %v", name, err)
+	}
+
+	httpsProxies.SetManagedSslCertificateStatus(newName, "ACTIVE", map[string]string{"a.example.com": "ACTIVE"})
+	sync()
+	if got := managedCertName(); got != newName {
+		t.Errorf("expected the new managed cert %v, got %v", newName, got)
+	}
+	if _, err := f.GetSslCertificate(name); err == nil {
+		t.Errorf("expected replaced managed cert %v to be deleted", name)
+	}
+}
+
+// Tests that a certificate map replaces the certificates of the Ingress, and
+// that the certificates are attached again once the map is detached.
+func TestCertificateMap(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:           "test",
+		AllowHTTP:      false,
+		TLS:            []*TLSCerts{{Key: "key", Cert: "cert"}},
+		FrontendConfig: &frontendconfig.FrontendConfig{},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	httpsProxies := NewFakeTargetHttpsProxies(f)
+	pool := newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).httpsProxies = httpsProxies
+	certName := hashedCertName(lbInfo.TLS[0])
+	certMap, none := "my-map", ""
+
+	for _, tc := range []struct {
+		desc      string
+		certMap   *string
+		wantMap   string
+		wantCerts []string
+	}{
+		{desc: "no certificate map", wantCerts: []string{certName}},
+		{desc: "certificate map", certMap: &certMap, wantMap: "my-map"},
+		{desc: "unset certificate map is untouched", wantMap: "my-map", wantCerts: []string{certName}},
+		{desc: "detached certificate map", certMap: &none, wantCerts: []string{certName}},
+	} {
+		lbInfo.FrontendConfig.Spec.CertificateMap = tc.certMap
+		if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+			t.Fatalf("%v: Sync() = %v, want nil", tc.desc, err)
+		}
+		if err := pool.GC([]string{lbInfo.Name}); err != nil {
+			t.Fatalf("%v: GC() = %v, want nil", tc.desc, err)
+		}
+		tps, err := f.GetTargetHttpsProxy(f.tpName(true))
+		if err != nil {
+			t.Fatalf("%v: expected https proxy to exist: %v", tc.desc, err)
+		}
+		if got, _ := httpsProxies.GetTargetHttpsProxyCertificateMap(tps.Name); got != tc.wantMap {
+			t.Errorf("%v: got certificate map %q, want %q", tc.desc, got, tc.wantMap)
+		}
+		if len(tps.SslCertificates) != len(tc.wantCerts) || len(tc.wantCerts) > 0 && tps.SslCertificates[0] != tc.wantCerts[0] {
+			t.Errorf("%v: got certs %v, want %v", tc.desc, tps.SslCertificates, tc.wantCerts)
+		}
+		if len(tc.wantCerts) == 0 {
+			if _, err := f.GetSslCertificate(certName); err == nil {
+				t.Errorf("%v: expected detached cert %v to be deleted", tc.desc, certName)
+			}
+		}
+	}
+
+	// A new load balancer only serving a certificate map gets a proxy.
+	lbInfo = &L7RuntimeInfo{
+		Name:           "test",
+		AllowHTTP:      false,
+		FrontendConfig: &frontendconfig.FrontendConfig{Spec: frontendconfig.FrontendConfigSpec{CertificateMap: &certMap}},
+	}
+	f = NewFakeLoadBalancers(lbInfo.Name)
+	httpsProxies = NewFakeTargetHttpsProxies(f)
+	pool = newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).httpsProxies = httpsProxies
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("Sync() = %v, want nil", err)
+	}
+	if got, _ := httpsProxies.GetTargetHttpsProxyCertificateMap(f.tpName(true)); got != certMap {
+		t.Errorf("got certificate map %q, want %q", got, certMap)
+	}
+	if l7, _ := pool.Get(lbInfo.Name); l7 == nil || !l7.CertificatesReady() {
+		t.Errorf("expected the certificate map to be served")
+	}
+}
+
+// hashedCertName returns the name of the certificate of the given contents.
+func hashedCertName(tlsCert *TLSCerts) string {
+	return (&utils.Namer{}).HashedSSLCert(tlsCert.hash())
+}
+
 // Tests that a certificate is created from the provided Key/Cert combo
 // and the proxy is updated to another cert when the provided cert changes
 func TestCertUpdate(t *testing.T) {
-	primaryCertName := "k8s-ssl-test"
-	secondaryCertName := "k8s-ssl-1-test"
 	lbInfo := &L7RuntimeInfo{
 		Name:      "test",
 		AllowHTTP: false,
-		TLS:       &TLSCerts{Key: "key", Cert: "cert"},
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}},
 	}
 
 	f := NewFakeLoadBalancers(lbInfo.Name)
@@ -132,80 +503,271 @@ func TestCertUpdate(t *testing.T) {
 
 	// Sync first cert
 	pool.Sync([]*L7RuntimeInfo{lbInfo})
-	verifyCertAndProxyLink(primaryCertName, lbInfo.TLS.Cert, f, t)
+	firstCertName := hashedCertName(lbInfo.TLS[0])
+	verifyCertAndProxyLink(firstCertName, lbInfo.TLS[0].Cert, f, t)
 
 	// Sync with different cert
-	lbInfo.TLS = &TLSCerts{Key: "key2", Cert: "cert2"}
+	lbInfo.TLS = []*TLSCerts{{Key: "key2", Cert: "cert2"}}
 	pool.Sync([]*L7RuntimeInfo{lbInfo})
-	verifyCertAndProxyLink(secondaryCertName, lbInfo.TLS.Cert, f, t)
+	verifyCertAndProxyLink(hashedCertName(lbInfo.TLS[0]), lbInfo.TLS[0].Cert, f, t)
+
+	// The old cert is deleted by the next GC.
+	if err := pool.GC([]string{lbInfo.Name}); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	if _, err := f.GetSslCertificate(firstCertName); err == nil {
+		t.Errorf("Expected replaced cert %v to be deleted", firstCertName)
+	}
 }
 
-// Tests that controller can overwrite existing, unused certificates
-func TestCertCreationWithCollision(t *testing.T) {
-	primaryCertName := "k8s-ssl-test"
-	secondaryCertName := "k8s-ssl-1-test"
+// Tests that the certificates with the legacy names, which don't depend on
+// their contents, are replaced.
+func TestCertMigration(t *testing.T) {
+	legacyCertName := "k8s-ssl-test"
 	lbInfo := &L7RuntimeInfo{
 		Name:      "test",
 		AllowHTTP: false,
-		TLS:       &TLSCerts{Key: "key", Cert: "cert"},
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}},
 	}
 
 	f := NewFakeLoadBalancers(lbInfo.Name)
-	pool := newFakeLoadBalancerPool(f, t)
+	newFakeLoadBalancerPool(f, t).Sync([]*L7RuntimeInfo{lbInfo})
+	legacyCert, _ := f.CreateSslCertificate(&compute.SslCertificate{Name: legacyCertName, Certificate: "cert"})
+	f.setSslCertificatesForTargetHttpsProxy(f.tpName(true), []string{legacyCert.SelfLink})
 
-	// Have both names already used by orphaned certs
-	f.CreateSslCertificate(&compute.SslCertificate{
-		Name:        primaryCertName,
-		Certificate: "abc",
-		SelfLink:    "existing",
-	})
-	f.CreateSslCertificate(&compute.SslCertificate{
-		Name:        secondaryCertName,
-		Certificate: "xyz",
-		SelfLink:    "existing",
-	})
-
-	// Sync first cert
-	pool.Sync([]*L7RuntimeInfo{lbInfo})
-	verifyCertAndProxyLink(primaryCertName, lbInfo.TLS.Cert, f, t)
-
-	// Sync with different cert
-	lbInfo.TLS = &TLSCerts{Key: "key2", Cert: "cert2"}
-	pool.Sync([]*L7RuntimeInfo{lbInfo})
-	verifyCertAndProxyLink(secondaryCertName, lbInfo.TLS.Cert, f, t)
+	// Restart of controller represented by a new pool
+	newFakeLoadBalancerPool(f, t).Sync([]*L7RuntimeInfo{lbInfo})
+	verifyCertAndProxyLink(hashedCertName(lbInfo.TLS[0]), lbInfo.TLS[0].Cert, f, t)
+	if _, err := f.GetSslCertificate(legacyCertName); err == nil {
+		t.Errorf("Expected legacy cert %v to be deleted", legacyCertName)
+	}
 }
 
 func TestCertRetentionAfterRestart(t *testing.T) {
-	primaryCertName := "k8s-ssl-test"
-	secondaryCertName := "k8s-ssl-1-test"
 	lbInfo := &L7RuntimeInfo{
 		Name:      "test",
 		AllowHTTP: false,
-		TLS:       &TLSCerts{Key: "key", Cert: "cert"},
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}},
 	}
+	certName := hashedCertName(lbInfo.TLS[0])
 
 	f := NewFakeLoadBalancers(lbInfo.Name)
 	firstPool := newFakeLoadBalancerPool(f, t)
-
-	// Sync twice so the expected certificate uses the secondary name
-	firstPool.Sync([]*L7RuntimeInfo{lbInfo})
-	verifyCertAndProxyLink(primaryCertName, lbInfo.TLS.Cert, f, t)
-	lbInfo.TLS = &TLSCerts{Key: "key2", Cert: "cert2"}
 	firstPool.Sync([]*L7RuntimeInfo{lbInfo})
-	verifyCertAndProxyLink(secondaryCertName, lbInfo.TLS.Cert, f, t)
+	verifyCertAndProxyLink(certName, lbInfo.TLS[0].Cert, f, t)
+
+	// A cert released right before the restart leaks.
+	leaked := &TLSCerts{Key: "leaked", Cert: "leaked"}
+	f.CreateSslCertificate(&compute.SslCertificate{Name: hashedCertName(leaked)})
 
 	// Restart of controller represented by a new pool
 	secondPool := newFakeLoadBalancerPool(f, t)
 	secondPool.Sync([]*L7RuntimeInfo{lbInfo})
+	verifyCertAndProxyLink(certName, lbInfo.TLS[0].Cert, f, t)
+	if len(f.Certs) != 2 {
+		t.Errorf("Expected the cert to be reused after a restart, got certs %+v", f.Certs)
+	}
 
-	// Verify second name is still used
-	verifyCertAndProxyLink(secondaryCertName, lbInfo.TLS.Cert, f, t)
+	// The first GC deletes the leaked cert.
+	if err := secondPool.GC([]string{lbInfo.Name}); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	verifyCertAndProxyLink(certName, lbInfo.TLS[0].Cert, f, t)
+	if _, err := f.GetSslCertificate(hashedCertName(leaked)); err == nil {
+		t.Errorf("Expected leaked cert to be deleted")
+	}
+}
 
-	// Update cert one more time to verify loop
-	lbInfo.TLS = &TLSCerts{Key: "key3", Cert: "cert3"}
-	secondPool.Sync([]*L7RuntimeInfo{lbInfo})
-	verifyCertAndProxyLink(primaryCertName, lbInfo.TLS.Cert, f, t)
+// Tests that the loadbalancers serving the same contents share a cert,
+// deleted once none of them uses it.
+func TestSharedCerts(t *testing.T) {
+	tlsCert := &TLSCerts{Key: "key", Cert: "cert"}
+	lbInfos := []*L7RuntimeInfo{
+		{Name: "test", TLS: []*TLSCerts{tlsCert}},
+		{Name: "other", TLS: []*TLSCerts{tlsCert}},
+	}
+	certName := hashedCertName(tlsCert)
+
+	f := NewFakeLoadBalancers(lbInfos[0].Name)
+	pool := newFakeLoadBalancerPool(f, t)
+	if err := pool.Sync(lbInfos); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if len(f.Certs) != 1 || f.Certs[0].Name != certName {
+		t.Fatalf("Expected a single cert %v, got %+v", certName, f.Certs)
+	}
+	for _, tps := range f.Tps {
+		if !reflect.DeepEqual(tps.SslCertificates, []string{f.Certs[0].SelfLink}) {
+			t.Errorf("Expected proxy %v to use cert %v, got %v", tps.Name, certName, tps.SslCertificates)
+		}
+	}
+
+	if err := pool.GC([]string{lbInfos[1].Name}); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	if _, err := f.GetSslCertificate(certName); err != nil {
+		t.Errorf("Expected cert %v still in use to be kept, got %v", certName, err)
+	}
+	if err := pool.GC(nil); err != nil {
+		t.Fatalf("GC() = %v", err)
+	}
+	if _, err := f.GetSslCertificate(certName); err == nil {
+		t.Errorf("Expected unused cert %v to be deleted", certName)
+	}
+}
+
+// Tests that several certificates are attached in order, and that the
+// certificates no longer referenced are deleted.
+func TestMultipleCerts(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:      "test",
+		AllowHTTP: false,
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}, {Key: "key2", Cert: "cert2"}},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	pool := newFakeLoadBalancerPool(f, t)
+	names := func() []string {
+		var names []string
+		for _, tlsCert := range lbInfo.TLS {
+			names = append(names, hashedCertName(tlsCert))
+		}
+		return names
+	}
+
+	pool.Sync([]*L7RuntimeInfo{lbInfo})
+	verifyCertsAndProxyLinks(names(), lbInfo, f, t)
+
+	// Only the changed cert is replaced.
+	replaced := hashedCertName(lbInfo.TLS[1])
+	lbInfo.TLS = []*TLSCerts{{Key: "key", Cert: "cert"}, {Key: "key3", Cert: "cert3"}}
+	pool.Sync([]*L7RuntimeInfo{lbInfo})
+	pool.GC([]string{lbInfo.Name})
+	verifyCertsAndProxyLinks(names(), lbInfo, f, t)
+	if _, err := f.GetSslCertificate(replaced); err == nil {
+		t.Errorf("Expected replaced cert %v to be deleted", replaced)
+	}
+
+	removed := hashedCertName(lbInfo.TLS[1])
+	lbInfo.TLS = lbInfo.TLS[:1]
+	pool.Sync([]*L7RuntimeInfo{lbInfo})
+	pool.GC([]string{lbInfo.Name})
+	verifyCertsAndProxyLinks(names(), lbInfo, f, t)
+	if _, err := f.GetSslCertificate(removed); err == nil {
+		t.Errorf("Expected removed cert %v to be deleted", removed)
+	}
+
+	// The certs created from secrets are deleted when switching to pre-shared
+	// certs.
+	for _, name := range []string{"pre-shared-1", "pre-shared-2"} {
+		f.CreateSslCertificate(&compute.SslCertificate{Name: name})
+	}
+	last := hashedCertName(lbInfo.TLS[0])
+	lbInfo.TLS = nil
+	lbInfo.TLSName = "pre-shared-2, pre-shared-1"
+	pool.Sync([]*L7RuntimeInfo{lbInfo})
+	pool.GC([]string{lbInfo.Name})
+	tps, err := f.GetTargetHttpsProxy(f.tpName(true))
+	if err != nil {
+		t.Fatalf("expected https proxy to exist: %v", err)
+	}
+	if want := []string{"pre-shared-2", "pre-shared-1"}; !reflect.DeepEqual(tps.SslCertificates, want) {
+		t.Errorf("expected target proxy certs %v, got %v", want, tps.SslCertificates)
+	}
+	if _, err := f.GetSslCertificate(last); err == nil {
+		t.Errorf("Expected cert %v to be deleted", last)
+	}
+}
+
+// Tests that the pre-shared certs and the certs of the secrets are both
+// attached, the pre-shared ones first, up to the GCE limit.
+func TestMixedCerts(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:      "test",
+		AllowHTTP: false,
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}, {Key: "key2", Cert: "cert2"}},
+		TLSName:   "pre-shared-2,pre-shared-1",
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	for _, name := range []string{"pre-shared-1", "pre-shared-2"} {
+		f.CreateSslCertificate(&compute.SslCertificate{Name: name})
+	}
+	pool := newFakeLoadBalancerPool(f, t)
+	links := func(names ...string) []string {
+		var links []string
+		for _, name := range names {
+			cert, err := f.GetSslCertificate(name)
+			if err != nil {
+				t.Fatalf("expected cert %v to exist: %v", name, err)
+			}
+			links = append(links, cert.SelfLink)
+		}
+		return links
+	}
+	verify := func(want []string) {
+		t.Helper()
+		tps, err := f.GetTargetHttpsProxy(f.tpName(true))
+		if err != nil {
+			t.Fatalf("expected https proxy to exist: %v", err)
+		}
+		if !reflect.DeepEqual(tps.SslCertificates, want) {
+			t.Errorf("expected target proxy certs %v, got %v", want, tps.SslCertificates)
+		}
+	}
+
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v", err)
+	}
+	verify(links("pre-shared-2", "pre-shared-1", hashedCertName(lbInfo.TLS[0]), hashedCertName(lbInfo.TLS[1])))
 
+	// The certs of the secrets beyond the limit are ignored.
+	names := []string{"pre-shared-2", "pre-shared-1"}
+	for i := len(names); i < MaxSSLCerts-1; i++ {
+		name := fmt.Sprintf("pre-shared-%d", i+1)
+		f.CreateSslCertificate(&compute.SslCertificate{Name: name})
+		names = append(names, name)
+	}
+	lbInfo.TLSName = strings.Join(names, ",")
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v", err)
+	}
+	verify(links(append(names, hashedCertName(lbInfo.TLS[0]))...))
+
+	// Deleting the loadbalancer keeps the pre-shared certs.
+	if err := pool.GC(nil); err != nil {
+		t.Fatalf("pool.GC() = %v", err)
+	}
+	for _, name := range names {
+		if _, err := f.GetSslCertificate(name); err != nil {
+			t.Errorf("expected pre-shared cert %v to be kept: %v", name, err)
+		}
+	}
+	for _, tlsCert := range lbInfo.TLS {
+		if _, err := f.GetSslCertificate(hashedCertName(tlsCert)); err == nil {
+			t.Errorf("expected cert %v to be deleted", hashedCertName(tlsCert))
+		}
+	}
+}
+
+func verifyCertsAndProxyLinks(certNames []string, lbInfo *L7RuntimeInfo, f *FakeLoadBalancers, t *testing.T) {
+	t.Helper()
+	var links []string
+	for i, name := range certNames {
+		cert, err := f.GetSslCertificate(name)
+		if err != nil {
+			t.Fatalf("expected ssl certificate to exist: %v, err: %v", name, err)
+		}
+		if cert.Certificate != lbInfo.TLS[i].Cert {
+			t.Errorf("unexpected certificate value of %v; expected %v, actual %v", name, lbInfo.TLS[i].Cert, cert.Certificate)
+		}
+		links = append(links, cert.SelfLink)
+	}
+	tps, err := f.GetTargetHttpsProxy(f.tpName(true))
+	if err != nil {
+		t.Fatalf("expected https proxy to exist: %v", err)
+	}
+	if !reflect.DeepEqual(tps.SslCertificates, links) {
+		t.Errorf("expected target proxy certs %v, got %v", links, tps.SslCertificates)
+	}
 }
 
 func verifyCertAndProxyLink(certName, certValue string, f *FakeLoadBalancers, t *testing.T) {
@@ -228,6 +790,120 @@ func verifyCertAndProxyLink(certName, certValue string, f *FakeLoadBalancers, t
 	}
 }
 
+func TestRegionalStaticIP(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{Name: "test", AllowHTTP: true, StaticIPName: "regional-ip"}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	f.RegionalIP = []*compute.Address{{Name: "regional-ip", Address: "10.0.0.1"}}
+	pool := newFakeLoadBalancerPool(f, t)
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err == nil {
+		t.Fatalf("pool.Sync() = nil, want an error for regional static IP %v", lbInfo.StaticIPName)
+	}
+	if _, err := f.GetGlobalForwardingRule(f.fwName(false)); !utils.IsNotFoundError(err) {
+		t.Errorf("f.GetGlobalForwardingRule(%q) = _, %v, want not found error", f.fwName(false), err)
+	}
+
+	// A global static IP with the same name takes precedence.
+	f.ReserveGlobalAddress(&compute.Address{Name: "regional-ip", Address: "1.2.3.4"})
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	fw, err := f.GetGlobalForwardingRule(f.fwName(false))
+	if err != nil {
+		t.Fatalf("f.GetGlobalForwardingRule(%q) = _, %v, want nil", f.fwName(false), err)
+	}
+	if fw.IPAddress != "1.2.3.4" {
+		t.Errorf("fw.IPAddress = %v, want 1.2.3.4", fw.IPAddress)
+	}
+}
+
+func TestIPv6ForwardingRules(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:      "test",
+		AllowHTTP: true,
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}},
+		IPv6:      true,
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	pool := newFakeLoadBalancerPool(f, t)
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	l7, err := pool.Get(lbInfo.Name)
+	if err != nil {
+		t.Fatalf("pool.Get(%q) = _, %v, want nil", lbInfo.Name, err)
+	}
+	namer := utils.Namer{}
+	ip, err := f.GetGlobalAddress(namer.IPv6ForwardingRule(l7.Name, utils.HTTPProtocol))
+	if err != nil {
+		t.Fatalf("IPv6 address not reserved: %v", err)
+	}
+	if ip.IpVersion != "IPV6" {
+		t.Errorf("ip.IpVersion = %q, want IPV6", ip.IpVersion)
+	}
+	for _, tc := range []struct {
+		protocol utils.NamerProtocol
+		target   string
+	}{
+		{utils.HTTPProtocol, l7.tp.SelfLink},
+		{utils.HTTPSProtocol, l7.tps.SelfLink},
+	} {
+		name := namer.IPv6ForwardingRule(l7.Name, tc.protocol)
+		fw, err := f.GetGlobalForwardingRule(name)
+		if err != nil {
+			t.Fatalf("f.GetGlobalForwardingRule(%q) = _, %v, want nil", name, err)
+		}
+		if fw.IPAddress != ip.Address || fw.Target != tc.target {
+			t.Errorf("forwarding rule %v has ip %v and target %v, want %v and %v", name, fw.IPAddress, fw.Target, ip.Address, tc.target)
+		}
+	}
+	if l7.GetIPv6() != ip.Address {
+		t.Errorf("l7.GetIPv6() = %q, want %q", l7.GetIPv6(), ip.Address)
+	}
+
+	// Disabling IPv6 deletes the IPv6 rules and address, not the IPv4 ones.
+	lbInfo.IPv6 = false
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	for _, name := range []string{namer.IPv6ForwardingRule(l7.Name, utils.HTTPProtocol), namer.IPv6ForwardingRule(l7.Name, utils.HTTPSProtocol)} {
+		if _, err := f.GetGlobalForwardingRule(name); !utils.IsNotFoundError(err) {
+			t.Errorf("f.GetGlobalForwardingRule(%q) = _, %v, want not found error", name, err)
+		}
+	}
+	if _, err := f.GetGlobalAddress(ip.Name); !utils.IsNotFoundError(err) {
+		t.Errorf("f.GetGlobalAddress(%q) = _, %v, want not found error", ip.Name, err)
+	}
+	if _, err := f.GetGlobalForwardingRule(f.fwName(false)); err != nil {
+		t.Errorf("f.GetGlobalForwardingRule(%q) = _, %v, want nil", f.fwName(false), err)
+	}
+}
+
+func TestStaticIPv6Name(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{Name: "test", AllowHTTP: true, IPv6: true, StaticIPv6Name: "my-ipv6"}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	f.ReserveGlobalAddress(&compute.Address{Name: "my-ipv6", Address: "1.2.3.4"})
+	pool := newFakeLoadBalancerPool(f, t)
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err == nil {
+		t.Fatalf("pool.Sync() = nil, want an error for IPv4 address %v", lbInfo.StaticIPv6Name)
+	}
+
+	f.DeleteGlobalAddress("my-ipv6")
+	f.ReserveGlobalAddress(&compute.Address{Name: "my-ipv6", Address: "2600:1901::1", IpVersion: "IPV6"})
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	l7, err := pool.Get(lbInfo.Name)
+	if err != nil {
+		t.Fatalf("pool.Get(%q) = _, %v, want nil", lbInfo.Name, err)
+	}
+	if l7.GetIPv6() != "2600:1901::1" {
+		t.Errorf("l7.GetIPv6() = %q, want 2600:1901::1", l7.GetIPv6())
+	}
+	if l7.ipv6 != nil {
+		t.Errorf("l7.ipv6 = %v, want nil for an address named by the user", l7.ipv6)
+	}
+}
+
 func TestCreateHTTPSLoadBalancerAnnotationCert(t *testing.T) {
 	// This should NOT create the forwarding rule and target proxy
 	// associated with the HTTP branch of this loadbalancer.
@@ -269,7 +945,7 @@ func TestCreateBothLoadBalancers(t *testing.T) {
 	lbInfo := &L7RuntimeInfo{
 		Name:      "test",
 		AllowHTTP: true,
-		TLS:       &TLSCerts{Key: "key", Cert: "cert"},
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}},
 	}
 	f := NewFakeLoadBalancers(lbInfo.Name)
 	pool := newFakeLoadBalancerPool(f, t)
@@ -305,6 +981,97 @@ func TestCreateBothLoadBalancers(t *testing.T) {
 	}
 }
 
+func TestToggleAllowHTTP(t *testing.T) {
+	lbInfo := &L7RuntimeInfo{
+		Name:      "test",
+		AllowHTTP: true,
+		TLS:       []*TLSCerts{{Key: "key", Cert: "cert"}},
+	}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	pool := newFakeLoadBalancerPool(f, t)
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	l7, err := pool.Get(lbInfo.Name)
+	if err != nil {
+		t.Fatalf("pool.Get(%q) = _, %v, want nil", lbInfo.Name, err)
+	}
+	ip := l7.GetIP()
+
+	// Disallowing HTTP deletes the HTTP frontend, and keeps the IP.
+	lbInfo.AllowHTTP = false
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	if _, err := f.GetGlobalForwardingRule(f.fwName(false)); !utils.IsNotFoundError(err) {
+		t.Errorf("f.GetGlobalForwardingRule(%q) = _, %v, want not found error", f.fwName(false), err)
+	}
+	if _, err := f.GetTargetHttpProxy(f.tpName(false)); !utils.IsNotFoundError(err) {
+		t.Errorf("f.GetTargetHttpProxy(%q) = _, %v, want not found error", f.tpName(false), err)
+	}
+	if _, err := f.GetGlobalForwardingRule(f.fwName(true)); err != nil {
+		t.Errorf("f.GetGlobalForwardingRule(%q) = _, %v, want nil", f.fwName(true), err)
+	}
+	if got := l7.GetIP(); got != ip {
+		t.Errorf("l7.GetIP() = %q, want %q", got, ip)
+	}
+
+	// Allowing HTTP again recreates the HTTP frontend.
+	lbInfo.AllowHTTP = true
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	tp, err := f.GetTargetHttpProxy(f.tpName(false))
+	if err != nil {
+		t.Fatalf("f.GetTargetHttpProxy(%q) = _, %v, want nil", f.tpName(false), err)
+	}
+	fw, err := f.GetGlobalForwardingRule(f.fwName(false))
+	if err != nil {
+		t.Fatalf("f.GetGlobalForwardingRule(%q) = _, %v, want nil", f.fwName(false), err)
+	}
+	if fw.Target != tp.SelfLink || fw.IPAddress != ip {
+		t.Errorf("forwarding rule %v has target %v and ip %v, want %v and %v", fw.Name, fw.Target, fw.IPAddress, tp.SelfLink, ip)
+	}
+}
+
+func TestNoClusterDefaultBackend(t *testing.T) {
+	f := NewFakeLoadBalancers("test")
+	fakeBackends := backends.NewFakeBackendServices(func(op int, be *compute.BackendService) error { return nil })
+	fakeIGs := instances.NewFakeInstanceGroups(sets.NewString())
+	fakeNEG := networkendpointgroup.NewFakeNetworkEndpointGroupCloud("test-subnet", "test-network")
+	namer := &utils.Namer{}
+	healthChecker := healthchecks.NewHealthChecker(healthchecks.NewFakeHealthCheckProvider(), nil, "/", namer, false)
+	nodePool := instances.NewNodePool(fakeIGs, namer)
+	nodePool.Init(&instances.FakeZoneLister{Zones: []string{defaultZone}})
+	backendPool := backends.NewBackendPool(
+		fakeBackends, fakeNEG, backends.NewFakeSecurityPolicies(), backends.NewFakeExtendedBackendServices(), healthChecker, nodePool, namer, []int64{}, false)
+	pool := NewLoadBalancerPool(f, NewFakeTargetHttpsProxies(f), NewFakeExtendedUrlMaps(f), backendPool, nil, namer, SslPolicyDefaults{})
+
+	lbInfo := &L7RuntimeInfo{Name: "test", AllowHTTP: true}
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err == nil {
+		t.Fatalf("pool.Sync() = nil, want an error without any default backend")
+	}
+
+	ingressDefault := backends.ServicePort{Port: 3001, Protocol: utils.ProtocolHTTP}
+	if err := backendPool.Ensure([]backends.ServicePort{ingressDefault}, nil); err != nil {
+		t.Fatalf("backendPool.Ensure() = %v, want nil", err)
+	}
+	lbInfo.DefaultBackend = &ingressDefault
+	if err := pool.Sync([]*L7RuntimeInfo{lbInfo}); err != nil {
+		t.Fatalf("pool.Sync() = %v, want nil", err)
+	}
+	um, err := f.GetUrlMap(f.umName())
+	if err != nil {
+		t.Fatalf("f.GetUrlMap(%q) = _, %v, want nil", f.umName(), err)
+	}
+	if want := namer.Backend(ingressDefault.Port); !strings.HasSuffix(um.DefaultService, want) {
+		t.Errorf("um.DefaultService = %v, want %v", um.DefaultService, want)
+	}
+	if _, err := fakeBackends.GetGlobalBackendService(namer.Backend(testDefaultBeNodePort.Port)); err == nil {
+		t.Errorf("cluster default backend %v created, want none", namer.Backend(testDefaultBeNodePort.Port))
+	}
+}
+
 func TestUpdateUrlMap(t *testing.T) {
 	um1 := utils.GCEURLMap{
 		"bar.example.com": {
@@ -331,7 +1098,7 @@ func TestUpdateUrlMap(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 	for _, ir := range []utils.GCEURLMap{um1, um2} {
-		if err := l7.UpdateUrlMap(ir); err != nil {
+		if err := l7.UpdateUrlMap(ir, nil); err != nil {
 			t.Fatalf("%v", err)
 		}
 	}
@@ -383,10 +1150,13 @@ func TestUpdateUrlMapNoChanges(t *testing.T) {
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
-	for _, ir := range []utils.GCEURLMap{um1, um2} {
-		if err := l7.UpdateUrlMap(ir); err != nil {
-			t.Fatalf("%v", err)
-		}
+	if err := l7.UpdateUrlMap(um1, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Only the update to an equal map must be skipped.
+	f.calls = []string{}
+	if err := l7.UpdateUrlMap(um2, nil); err != nil {
+		t.Fatalf("%v", err)
 	}
 	for _, call := range f.calls {
 		if call == "UpdateUrlMap" {
@@ -395,6 +1165,120 @@ func TestUpdateUrlMapNoChanges(t *testing.T) {
 	}
 }
 
+func TestUpdateUrlMapRoutes(t *testing.T) {
+	um := utils.GCEURLMap{
+		"foo.example.com": {
+			"/foo":   &compute.BackendService{SelfLink: "foosvc"},
+			"/old":   nil,
+			"/api/*": &compute.BackendService{SelfLink: "apisvc"},
+			"/app/*": &compute.BackendService{SelfLink: "appsvc"},
+		},
+	}
+	um.PutDefaultBackend(&compute.BackendService{SelfLink: "default"})
+	routes := &utils.URLMapRoutes{}
+	routes.PutPath("foo.example.com", "/old", &utils.PathRoute{Redirect: &utils.URLRedirect{PathRedirect: "/new", StripQuery: true}})
+	routes.PutPath("foo.example.com", "/api/*", &utils.PathRoute{Rewrite: &utils.URLRewrite{PathPrefixRewrite: "/", HostRewrite: "api.internal"}})
+	routes.PutPath("foo.example.com", "/app/*", &utils.PathRoute{WeightedBackends: []utils.WeightedBackend{{BackendService: "appsvc", Weight: 90}, {BackendService: "canarysvc", Weight: 10}}})
+
+	lbInfo := &L7RuntimeInfo{Name: "test", AllowHTTP: true}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	urlMaps := NewFakeExtendedUrlMaps(f)
+	pool := newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).urlMaps = urlMaps
+	pool.Sync([]*L7RuntimeInfo{lbInfo})
+	l7, err := pool.Get(lbInfo.Name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := l7.UpdateUrlMap(um, routes); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	got := urlMaps.UrlMaps[l7.um.Name]
+	if got == nil {
+		t.Fatalf("url map %v not updated through the REST API", l7.um.Name)
+	}
+	want := []*PathRule{
+		{Paths: []string{"/api/*"}, Service: "apisvc", RouteAction: &HttpRouteAction{UrlRewrite: &UrlRewrite{PathPrefixRewrite: "/", HostRewrite: "api.internal"}}},
+		{Paths: []string{"/app/*"}, RouteAction: &HttpRouteAction{WeightedBackendServices: []*WeightedBackendService{{BackendService: "appsvc", Weight: 90}, {BackendService: "canarysvc", Weight: 10}}}},
+		{Paths: []string{"/foo"}, Service: "foosvc"},
+		{Paths: []string{"/old"}, UrlRedirect: &HttpRedirectAction{PathRedirect: "/new", StripQuery: true, RedirectResponseCode: "MOVED_PERMANENTLY_DEFAULT"}},
+	}
+	for _, pm := range got.PathMatchers {
+		if pm.Name != getNameForPathMatcher("foo.example.com") {
+			continue
+		}
+		if !reflect.DeepEqual(pm.PathRules, want) {
+			t.Errorf("path rules = %+v, want %+v", pm.PathRules, want)
+		}
+		return
+	}
+	t.Errorf("no path matcher for foo.example.com in %+v", got.PathMatchers)
+}
+
+func TestUpdateUrlMapRouteRules(t *testing.T) {
+	um := utils.GCEURLMap{
+		"foo.example.com": {
+			"/foo":      &compute.BackendService{SelfLink: "foosvc"},
+			"/api/*":    &compute.BackendService{SelfLink: "apisvc"},
+			"/api/v1/*": &compute.BackendService{SelfLink: "v1svc"},
+		},
+		"bar.example.com": {
+			"/bar": &compute.BackendService{SelfLink: "barsvc"},
+		},
+	}
+	um.PutDefaultBackend(&compute.BackendService{SelfLink: "default"})
+	canary := utils.RouteMatch{PrefixMatch: "/", HeaderMatches: []utils.HeaderMatch{{HeaderName: "x-canary", ExactMatch: "true"}}}
+	beta := utils.RouteMatch{PrefixMatch: "/api/", QueryParameterMatches: []utils.QueryParameterMatch{{Name: "beta", PresentMatch: true}}}
+	headers := &utils.HeaderAction{RequestHeadersToAdd: []utils.HeaderValue{{HeaderName: "x-beta", HeaderValue: "1", Replace: true}}}
+	routes := &utils.URLMapRoutes{}
+	routes.AddRule("foo.example.com", &utils.RouteRule{Priority: 20, Matches: []utils.RouteMatch{canary}, Route: utils.PathRoute{WeightedBackends: []utils.WeightedBackend{{BackendService: "foosvc", Weight: 1}, {BackendService: "canarysvc", Weight: 1}}}})
+	routes.AddRule("foo.example.com", &utils.RouteRule{Priority: 10, Matches: []utils.RouteMatch{beta}, Service: "betasvc", HeaderAction: headers})
+
+	lbInfo := &L7RuntimeInfo{Name: "test", AllowHTTP: true}
+	f := NewFakeLoadBalancers(lbInfo.Name)
+	urlMaps := NewFakeExtendedUrlMaps(f)
+	pool := newFakeLoadBalancerPool(f, t)
+	pool.(*L7s).urlMaps = urlMaps
+	pool.Sync([]*L7RuntimeInfo{lbInfo})
+	l7, err := pool.Get(lbInfo.Name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := l7.UpdateUrlMap(um, routes); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	got := urlMaps.UrlMaps[l7.um.Name]
+	if got == nil {
+		t.Fatalf("url map %v not updated through the REST API", l7.um.Name)
+	}
+	// The rules come first, in priority order, then the paths: the exact
+	// paths first, then the longest prefixes.
+	want := []*HttpRouteRule{
+		{Priority: 0, MatchRules: []utils.RouteMatch{beta}, Service: "betasvc", HeaderAction: headers},
+		{Priority: 1, MatchRules: []utils.RouteMatch{canary}, RouteAction: &HttpRouteAction{WeightedBackendServices: []*WeightedBackendService{{BackendService: "foosvc", Weight: 1}, {BackendService: "canarysvc", Weight: 1}}}},
+		{Priority: 2, MatchRules: []utils.RouteMatch{{FullPathMatch: "/foo"}}, Service: "foosvc"},
+		{Priority: 3, MatchRules: []utils.RouteMatch{{PrefixMatch: "/api/v1/"}}, Service: "v1svc"},
+		{Priority: 4, MatchRules: []utils.RouteMatch{{PrefixMatch: "/api/"}}, Service: "apisvc"},
+	}
+	for _, pm := range got.PathMatchers {
+		switch pm.Name {
+		case getNameForPathMatcher("foo.example.com"):
+			if len(pm.PathRules) != 0 {
+				t.Errorf("path rules of foo.example.com = %+v, want none with route rules", pm.PathRules)
+			}
+			if !reflect.DeepEqual(pm.RouteRules, want) {
+				t.Errorf("route rules = %+v, want %+v", pm.RouteRules, want)
+			}
+		case getNameForPathMatcher("bar.example.com"):
+			if len(pm.RouteRules) != 0 || len(pm.PathRules) != 1 {
+				t.Errorf("path matcher of bar.example.com = %+v, want a single path rule", pm)
+			}
+		}
+	}
+}
+
 func TestNameParsing(t *testing.T) {
 	clusterName := "123"
 	firewallName := clusterName
@@ -417,7 +1301,7 @@ func TestNameParsing(t *testing.T) {
 func TestClusterNameChange(t *testing.T) {
 	lbInfo := &L7RuntimeInfo{
 		Name: "test",
-		TLS:  &TLSCerts{Key: "key", Cert: "cert"},
+		TLS:  []*TLSCerts{{Key: "key", Cert: "cert"}},
 	}
 	f := NewFakeLoadBalancers(lbInfo.Name)
 	pool := newFakeLoadBalancerPool(f, t)
@@ -0,0 +1,26 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging logs the activity of the controller, in the text format of
+// glog, or as JSON lines for log pipelines to index, see SetFormat. Entries
+// carry the Ingress, GCE resource and operation they are about, when known,
+// as fields of a Logger, eg:
+//
+//	log := logging.ForIngress("default/foo").WithResource("k8s-um-default-foo--uid").WithOperation("create")
+//	log.Infof("Creating url map")
+//
+// The package level functions log entries without fields, like glog.
+package logging
@@ -0,0 +1,229 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// TextFormat logs through glog, with the fields appended to the message
+	// as key="value" pairs.
+	TextFormat = "text"
+	// JSONFormat logs an object per line, with the fields as keys.
+	JSONFormat = "json"
+)
+
+// severity is the severity of an entry, named as in glog.
+type severity string
+
+const (
+	infoSeverity    severity = "INFO"
+	warningSeverity severity = "WARNING"
+	errorSeverity   severity = "ERROR"
+	fatalSeverity   severity = "FATAL"
+)
+
+var (
+	// outputLock protects jsonFormat and out.
+	outputLock sync.Mutex
+	// jsonFormat is true if the entries are logged in JSONFormat.
+	jsonFormat bool
+	// out is where the JSON entries are written.
+	out io.Writer = os.Stderr
+	// exit exits the process after a fatal entry.
+	exit = os.Exit
+)
+
+// SetFormat sets the format of the entries logged from now on: TextFormat
+// or JSONFormat. Libraries logging through glog directly keep its format.
+func SetFormat(format string) error {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	switch format {
+	case TextFormat:
+		jsonFormat = false
+	case JSONFormat:
+		jsonFormat = true
+	default:
+		return fmt.Errorf("invalid log format %q, expected %q or %q", format, TextFormat, JSONFormat)
+	}
+	return nil
+}
+
+// Logger logs entries about an Ingress, a GCE resource and an operation, any
+// of which may be empty. The zero Logger logs entries without fields.
+type Logger struct {
+	ingress   string
+	resource  string
+	operation string
+}
+
+// ForIngress returns a Logger of the Ingress of the given namespace/name key.
+func ForIngress(key string) Logger {
+	return Logger{ingress: key}
+}
+
+// ForResource returns a Logger of the GCE resource of the given name.
+func ForResource(name string) Logger {
+	return Logger{resource: name}
+}
+
+// WithIngress returns a copy of the Logger for the Ingress of the given
+// namespace/name key.
+func (l Logger) WithIngress(key string) Logger {
+	l.ingress = key
+	return l
+}
+
+// WithResource returns a copy of the Logger for the GCE resource of the
+// given name.
+func (l Logger) WithResource(name string) Logger {
+	l.resource = name
+	return l
+}
+
+// WithOperation returns a copy of the Logger for the given operation, eg:
+// "create" or "delete".
+func (l Logger) WithOperation(op string) Logger {
+	l.operation = op
+	return l
+}
+
+// Infof logs an info entry.
+func (l Logger) Infof(format string, args ...interface{}) {
+	l.output(infoSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs a warning entry.
+func (l Logger) Warningf(format string, args ...interface{}) {
+	l.output(warningSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs an error entry.
+func (l Logger) Errorf(format string, args ...interface{}) {
+	l.output(errorSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs a fatal entry, and exits.
+func (l Logger) Fatalf(format string, args ...interface{}) {
+	l.output(fatalSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// V returns a Verbose logging the info entries of the Logger if the
+// verbosity of glog is at least the given level.
+func (l Logger) V(level glog.Level) Verbose {
+	return Verbose{logger: l, enabled: bool(glog.V(level))}
+}
+
+// Verbose logs the info entries of a Logger only if they are enabled, see
+// Logger.V.
+type Verbose struct {
+	logger  Logger
+	enabled bool
+}
+
+// Infof logs an info entry, if enabled.
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		v.logger.output(infoSeverity, 1, fmt.Sprintf(format, args...))
+	}
+}
+
+// Infoln logs an info entry of the given values separated by spaces, if
+// enabled.
+func (v Verbose) Infoln(args ...interface{}) {
+	if v.enabled {
+		v.logger.output(infoSeverity, 1, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+	}
+}
+
+// entry is a JSON entry.
+type entry struct {
+	Time      string `json:"time"`
+	Severity  string `json:"severity"`
+	Caller    string `json:"caller"`
+	Message   string `json:"msg"`
+	Ingress   string `json:"ingress,omitempty"`
+	Resource  string `json:"resource,omitempty"`
+	Operation string `json:"operation,omitempty"`
+}
+
+// output logs the given message, called depth frames below the caller whose
+// location is logged.
+func (l Logger) output(s severity, depth int, msg string) {
+	outputLock.Lock()
+	if !jsonFormat {
+		outputLock.Unlock()
+		l.outputText(s, depth+1, msg)
+		return
+	}
+	defer outputLock.Unlock()
+	e := entry{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Severity:  string(s),
+		Message:   msg,
+		Ingress:   l.ingress,
+		Resource:  l.resource,
+		Operation: l.operation,
+	}
+	if _, file, line, ok := runtime.Caller(depth + 1); ok {
+		e.Caller = fmt.Sprintf("%v:%d", filepath.Base(file), line)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"severity":"ERROR","msg":%q}`, fmt.Sprintf("failed to marshal log entry %+v: %v", e, err)))
+	}
+	out.Write(append(b, '\n'))
+	if s == fatalSeverity {
+		exit(255)
+	}
+}
+
+// outputText logs the given message through glog, followed by the fields.
+func (l Logger) outputText(s severity, depth int, msg string) {
+	for _, f := range [...]struct{ key, value string }{
+		{"ingress", l.ingress},
+		{"resource", l.resource},
+		{"operation", l.operation},
+	} {
+		if f.value != "" {
+			msg += fmt.Sprintf(" %v=%q", f.key, f.value)
+		}
+	}
+	depth++
+	switch s {
+	case infoSeverity:
+		glog.InfoDepth(depth, msg)
+	case warningSeverity:
+		glog.WarningDepth(depth, msg)
+	case errorSeverity:
+		glog.ErrorDepth(depth, msg)
+	case fatalSeverity:
+		glog.FatalDepth(depth, msg)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// captureJSON logs the entries of fn in JSONFormat, and returns them.
+func captureJSON(t *testing.T, fn func()) []map[string]string {
+	var buf bytes.Buffer
+	oldOut, oldExit := out, exit
+	out, exit = &buf, func(int) {}
+	if err := SetFormat(JSONFormat); err != nil {
+		t.Fatalf("SetFormat(%q) = %v", JSONFormat, err)
+	}
+	defer func() {
+		SetFormat(TextFormat)
+		out, exit = oldOut, oldExit
+	}()
+	fn()
+	var entries []map[string]string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		e := map[string]string{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Invalid JSON entry %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestJSONFormat(t *testing.T) {
+	entries := captureJSON(t, func() {
+		Infof("plain %d", 1)
+		ForIngress("default/foo").WithResource("k8s-um-default-foo--uid").WithOperation("create").Warningf("creating %v", "url map")
+		ForResource("k8s-be-30000--uid").Errorf("failed")
+		ForIngress("default/foo").Fatalf("fatal")
+		// Disabled entries aren't logged.
+		V(100).Infof("verbose")
+	})
+	want := []map[string]string{
+		{"severity": "INFO", "msg": "plain 1"},
+		{"severity": "WARNING", "msg": "creating url map", "ingress": "default/foo", "resource": "k8s-um-default-foo--uid", "operation": "create"},
+		{"severity": "ERROR", "msg": "failed", "resource": "k8s-be-30000--uid"},
+		{"severity": "FATAL", "msg": "fatal", "ingress": "default/foo"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Got %d entries %v, want %d", len(entries), entries, len(want))
+	}
+	for i, e := range entries {
+		if !strings.HasPrefix(e["caller"], "logging_test.go:") {
+			t.Errorf("Entry %d has caller %q, want logging_test.go", i, e["caller"])
+		}
+		if e["time"] == "" {
+			t.Errorf("Entry %d has no time", i)
+		}
+		delete(e, "caller")
+		delete(e, "time")
+		if len(e) != len(want[i]) {
+			t.Errorf("Entry %d = %v, want %v", i, e, want[i])
+			continue
+		}
+		for k, v := range want[i] {
+			if e[k] != v {
+				t.Errorf("Entry %d = %v, want %v", i, e, want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	for _, format := range []string{TextFormat, JSONFormat} {
+		if err := SetFormat(format); err != nil {
+			t.Errorf("SetFormat(%q) = %v, want nil", format, err)
+		}
+	}
+	if err := SetFormat("xml"); err == nil {
+		t.Errorf("SetFormat(%q) = nil, want error", "xml")
+	}
+	SetFormat(TextFormat)
+}
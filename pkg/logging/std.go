@@ -0,0 +1,63 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// std logs the entries without fields of the package level functions.
+var std Logger
+
+// Infof logs an info entry.
+func Infof(format string, args ...interface{}) {
+	std.output(infoSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs a warning entry.
+func Warningf(format string, args ...interface{}) {
+	std.output(warningSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs an error entry.
+func Errorf(format string, args ...interface{}) {
+	std.output(errorSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs a fatal entry of the given values, formatted as by fmt.Sprint,
+// and exits.
+func Fatal(args ...interface{}) {
+	std.output(fatalSeverity, 1, fmt.Sprint(args...))
+}
+
+// Fatalf logs a fatal entry, and exits.
+func Fatalf(format string, args ...interface{}) {
+	std.output(fatalSeverity, 1, fmt.Sprintf(format, args...))
+}
+
+// V returns a Verbose logging info entries without fields if the verbosity
+// of glog is at least the given level.
+func V(level glog.Level) Verbose {
+	return std.V(level)
+}
+
+// Flush flushes the entries buffered by glog.
+func Flush() {
+	glog.Flush()
+}
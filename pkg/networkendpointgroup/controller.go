@@ -17,14 +17,16 @@ limitations under the License.
 package networkendpointgroup
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
-	"github.com/golang/glog"
 	apiv1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -35,6 +37,8 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/ingress-gce/pkg/annotations"
 	"k8s.io/ingress-gce/pkg/context"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/svcneg"
 )
 
 const (
@@ -46,13 +50,20 @@ const (
 // Controller is network endpoint group controller.
 // It determines whether NEG for a service port is needed, then signals negSyncerManager to sync it.
 type Controller struct {
+	client       kubernetes.Interface
 	manager      negSyncerManager
+	namer        networkEndpointGroupNamer
+	zoneGetter   zoneGetter
 	resyncPeriod time.Duration
 	recorder     record.EventRecorder
+	// reflector sets the readiness gate of the Pods of the NEGs, nil if
+	// readiness gates are ignored.
+	reflector *readinessReflector
 
 	ingressSynced  cache.InformerSynced
 	serviceSynced  cache.InformerSynced
 	endpointSynced cache.InformerSynced
+	podSynced      cache.InformerSynced
 	ingressLister  cache.Indexer
 	serviceLister  cache.Indexer
 
@@ -60,7 +71,12 @@ type Controller struct {
 	serviceQueue workqueue.RateLimitingInterface
 }
 
-// NewController returns a network endpoint group controller.
+// NewController returns a network endpoint group controller. With
+// enableReadinessReflector, the readiness gate of the Pods of the NEGs is set once
+// their endpoints are healthy. endpointsSource is the source of the endpoints
+// of the NEGs, EndpointsSourceEndpoints by default. dualStack, if not nil,
+// attaches the IPv6 addresses of the dual-stack Pods along their IPv4
+// addresses when the subnetwork of the cluster has IPv6 addresses.
 func NewController(
 	kubeClient kubernetes.Interface,
 	cloud networkEndpointGroupCloud,
@@ -68,31 +84,70 @@ func NewController(
 	zoneGetter zoneGetter,
 	namer networkEndpointGroupNamer,
 	resyncPeriod time.Duration,
+	maxEndpointsPerZone int,
+	enableReadinessReflector bool,
+	endpointsSource string,
+	dualStack DualStackEndpoints,
 ) (*Controller, error) {
+	endpointSlices, err := newEndpointSliceSource(&apiServerEndpointSliceClient{client: kubeClient}, endpointsSource, dualStack)
+	if err != nil {
+		return nil, err
+	}
+	if dualStack != nil {
+		subnetDualStack, err := dualStack.SubnetworkDualStack()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the stack type of the subnetwork: %v", err)
+		}
+		if !subnetDualStack {
+			logging.Warningf("The subnetwork of the cluster has no IPv6 addresses, only the IPv4 addresses of the Pods are attached to the NEGs")
+			endpointSlices.dualStack = nil
+		}
+	}
+
 	// init event recorder
 	// TODO: move event recorder initializer to main. Reuse it among controllers.
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartLogging(logging.Infof)
 	eventBroadcaster.StartRecordingToSink(&unversionedcore.EventSinkImpl{
 		Interface: kubeClient.Core().Events(""),
 	})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme,
 		apiv1.EventSource{Component: "networkendpointgroup-controller"})
 
+	var reflector *readinessReflector
+	if enableReadinessReflector {
+		reflector = newReadinessReflector(&apiServerPodClient{client: kubeClient}, cloud, ctx.ServiceInformer.GetIndexer(), ctx.PodInformer.GetIndexer())
+		ctx.PodInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: reflector.processPod,
+			UpdateFunc: func(old, cur interface{}) {
+				reflector.processPod(cur)
+			},
+		})
+	}
 	manager := newSyncerManager(namer,
 		recorder,
 		cloud,
 		zoneGetter,
+		&svcneg.APIServerClient{Client: kubeClient},
 		ctx.ServiceInformer.GetIndexer(),
-		ctx.EndpointInformer.GetIndexer())
+		ctx.EndpointInformer.GetIndexer(),
+		ctx.PodInformer.GetIndexer(),
+		maxEndpointsPerZone,
+		reflector,
+		endpointSlices)
 
 	negController := &Controller{
+		client:         kubeClient,
 		manager:        manager,
+		reflector:      reflector,
+		namer:          namer,
+		zoneGetter:     zoneGetter,
 		resyncPeriod:   resyncPeriod,
 		recorder:       recorder,
 		ingressSynced:  ctx.IngressInformer.HasSynced,
 		serviceSynced:  ctx.ServiceInformer.HasSynced,
 		endpointSynced: ctx.EndpointInformer.HasSynced,
+		podSynced:      ctx.PodInformer.HasSynced,
 		ingressLister:  ctx.IngressInformer.GetIndexer(),
 		serviceLister:  ctx.ServiceInformer.GetIndexer(),
 		serviceQueue:   workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
@@ -130,24 +185,27 @@ func NewController(
 
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	wait.PollUntil(5*time.Second, func() (bool, error) {
-		glog.V(2).Infof("Waiting for initial sync")
+		logging.V(2).Infof("Waiting for initial sync")
 		return c.synced(), nil
 	}, stopCh)
 
-	glog.V(2).Infof("Starting network endpoint group controller")
+	logging.V(2).Infof("Starting network endpoint group controller")
 	defer func() {
-		glog.V(2).Infof("Shutting down network endpoint group controller")
+		logging.V(2).Infof("Shutting down network endpoint group controller")
 		c.stop()
 	}()
 
 	go wait.Until(c.serviceWorker, time.Second, stopCh)
 	go wait.Until(c.gc, c.resyncPeriod, stopCh)
+	if c.reflector != nil {
+		go c.reflector.Run(stopCh)
+	}
 
 	<-stopCh
 }
 
 func (c *Controller) stop() {
-	glog.V(2).Infof("Shutting down network endpoint group controller")
+	logging.V(2).Infof("Shutting down network endpoint group controller")
 	c.serviceQueue.ShutDown()
 	c.manager.ShutDown()
 }
@@ -156,7 +214,7 @@ func (c *Controller) stop() {
 func (c *Controller) processEndpoint(obj interface{}) {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
-		glog.Errorf("Failed to generate endpoint key: %v", err)
+		logging.Errorf("Failed to generate endpoint key: %v", err)
 		return
 	}
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
@@ -196,22 +254,115 @@ func (c *Controller) processService(key string) error {
 		return nil
 	}
 
-	var service *apiv1.Service
-	var enabled bool
-	if exists {
-		service = svc.(*apiv1.Service)
-		enabled = annotations.SvcAnnotations(service.GetAnnotations()).NEGEnabled()
+	service := svc.(*apiv1.Service)
+	svcAnnotations := annotations.SvcAnnotations(service.GetAnnotations())
+	hybridNEG, err := svcAnnotations.HybridNEG()
+	if err != nil {
+		c.recorder.Eventf(service, apiv1.EventTypeWarning, "NEG", "Ignoring hybrid NEGs: %v", err)
 	}
+	ports := portNameMap{}
+	// The endpoints of hybrid services are only reachable through NEGs.
+	if svcAnnotations.NEGEnabled() || hybridNEG != nil {
+		// Only service ports referenced by ingress are synced for NEG
+		ings := getIngressServicesFromStore(c.ingressLister, service)
+		for _, port := range gatherSerivceTargetPortUsedByIngress(ings, service).List() {
+			ports[port] = ""
+		}
+	}
+	exposedPorts := c.gatherExposedTargetPorts(service, ports)
 
-	if !enabled {
+	if len(ports) == 0 {
 		c.manager.StopSyncer(namespace, name)
+		return c.syncNEGStatus(service, nil)
+	}
+
+	logging.V(2).Infof("Syncing service %q", key)
+	if err := c.manager.EnsureSyncers(namespace, name, ports); err != nil {
+		return err
+	}
+	negNames := map[int32]string{}
+	for port, targetPort := range exposedPorts {
+		negNames[port] = resolveNEGName(c.namer, namespace, name, targetPort, ports[targetPort])
+	}
+	return c.syncNEGStatus(service, negNames)
+}
+
+// gatherExposedTargetPorts adds the target ports of the Service ports exposed
+// as standalone NEGs to the given ports, with their custom NEG names. Returns
+// the target ports of the exposed ports, keyed by Service port. Ports also
+// used by Ingresses keep their generated NEG name, which the backend services
+// of the Ingresses reference.
+func (c *Controller) gatherExposedTargetPorts(service *apiv1.Service, ports portNameMap) map[int32]string {
+	negAnnotation, err := annotations.SvcAnnotations(service.GetAnnotations()).NEGAnnotation()
+	if err != nil {
+		c.recorder.Eventf(service, apiv1.EventTypeWarning, "NEG", "Ignoring standalone NEGs: %v", err)
 		return nil
 	}
+	if negAnnotation == nil {
+		return nil
+	}
+	ingressPorts := sets.StringKeySet(ports)
+	exposedPorts := map[int32]string{}
+	for port, attrs := range negAnnotation.ExposedPorts {
+		targetPort, ok := getTargetPort(service, port)
+		if !ok {
+			c.recorder.Eventf(service, apiv1.EventTypeWarning, "NEG", "Ignoring exposed port %v: not a port of the Service", port)
+			continue
+		}
+		exposedPorts[port] = targetPort
+		if ingressPorts.Has(targetPort) {
+			if attrs.Name != "" {
+				c.recorder.Eventf(service, apiv1.EventTypeWarning, "NEG", "Ignoring NEG name %q of port %v: the port is used by an Ingress", attrs.Name, port)
+			}
+			continue
+		}
+		ports[targetPort] = attrs.Name
+	}
+	return exposedPorts
+}
 
-	glog.V(2).Infof("Syncing service %q", key)
-	// Only service ports referenced by ingress are synced for NEG
-	ings := getIngressServicesFromStore(c.ingressLister, service)
-	return c.manager.EnsureSyncers(namespace, name, gatherSerivceTargetPortUsedByIngress(ings, service))
+// syncNEGStatus publishes the given standalone NEG names, keyed by Service
+// port, in the NEG status annotation of the Service. The annotation is
+// removed if there are none.
+func (c *Controller) syncNEGStatus(service *apiv1.Service, negNames map[int32]string) error {
+	value := ""
+	if len(negNames) > 0 {
+		zones, err := c.zoneGetter.ListZones()
+		if err != nil {
+			return err
+		}
+		if hybridZone, err := getHybridZone(service); err == nil && hybridZone != "" {
+			zones = []string{hybridZone}
+		}
+		// Sort the zones, so the status only changes with the NEGs.
+		sort.Strings(zones)
+		status, err := json.Marshal(annotations.NEGStatus{NetworkEndpointGroups: negNames, Zones: zones})
+		if err != nil {
+			return err
+		}
+		value = string(status)
+	}
+	current, ok := service.Annotations[annotations.NEGStatusKey]
+	if (value == "" && !ok) || (value != "" && current == value) {
+		return nil
+	}
+
+	svcClient := c.client.Core().Services(service.Namespace)
+	currSvc, err := svcClient.Get(service.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		delete(currSvc.Annotations, annotations.NEGStatusKey)
+	} else {
+		if currSvc.Annotations == nil {
+			currSvc.Annotations = map[string]string{}
+		}
+		currSvc.Annotations[annotations.NEGStatusKey] = value
+	}
+	logging.V(3).Infof("Updating NEG status of service %s/%s to %q", service.Namespace, service.Name, value)
+	_, err = svcClient.Update(currSvc)
+	return err
 }
 
 func (c *Controller) handleErr(err error, key interface{}) {
@@ -220,7 +371,7 @@ func (c *Controller) handleErr(err error, key interface{}) {
 		return
 	}
 
-	glog.Errorf("Error processing service %q: %v", key, err)
+	logging.Errorf("Error processing service %q: %v", key, err)
 	if c.serviceQueue.NumRequeues(key) < maxRetries {
 		c.serviceQueue.AddRateLimited(key)
 		return
@@ -229,7 +380,7 @@ func (c *Controller) handleErr(err error, key interface{}) {
 	defer c.serviceQueue.Forget(key)
 	service, exists, err := c.serviceLister.GetByKey(key.(string))
 	if err != nil {
-		glog.Warning("Failed to retrieve service %q from store: %v", key.(string), err)
+		logging.Warningf("Failed to retrieve service %q from store: %v", key.(string), err)
 		return
 	}
 	if exists {
@@ -240,7 +391,7 @@ func (c *Controller) handleErr(err error, key interface{}) {
 func (c *Controller) enqueueService(obj interface{}) {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
-		glog.Errorf("Failed to generate service key: %v", err)
+		logging.Errorf("Failed to generate service key: %v", err)
 		return
 	}
 	c.serviceQueue.Add(key)
@@ -255,12 +406,13 @@ func (c *Controller) enqueueIngressServices(obj interface{}) {
 
 func (c *Controller) gc() {
 	if err := c.manager.GC(); err != nil {
-		glog.Errorf("NEG controller garbage collection failed: %v", err)
+		logging.Errorf("NEG controller garbage collection failed: %v", err)
 	}
 }
 
 func (c *Controller) synced() bool {
 	return c.endpointSynced() &&
+		c.podSynced() &&
 		c.serviceSynced() &&
 		c.ingressSynced()
 }
@@ -282,6 +434,11 @@ func gatherSerivceTargetPortUsedByIngress(ings []extensions.Ingress, svc *apiv1.
 				}
 			}
 		}
+		for _, be := range annotations.IngAnnotations(ing.Annotations).Backends() {
+			if be.ServiceName == svc.Name {
+				servicePorts.Insert(be.ServicePort.String())
+			}
+		}
 	}
 
 	for _, svcPort := range svc.Spec.Ports {
@@ -310,12 +467,22 @@ func gatherSerivceTargetPortUsedByIngress(ings []extensions.Ingress, svc *apiv1.
 	return targetPorts
 }
 
+// getTargetPort returns the target port of the given Service port.
+func getTargetPort(svc *apiv1.Service, port int32) (string, bool) {
+	for _, svcPort := range svc.Spec.Ports {
+		if svcPort.Port == port {
+			return svcPort.TargetPort.String(), true
+		}
+	}
+	return "", false
+}
+
 // gatherIngressServiceKeys returns all service key (formatted as namespace/name) referenced in the ingress
 func gatherIngressServiceKeys(obj interface{}) sets.String {
 	set := sets.NewString()
 	ing, ok := obj.(*extensions.Ingress)
 	if !ok {
-		glog.Errorf("Expecting ingress type but got: %T", reflect.TypeOf(ing))
+		logging.Errorf("Expecting ingress type but got: %T", reflect.TypeOf(ing))
 		return set
 	}
 
@@ -328,6 +495,9 @@ func gatherIngressServiceKeys(obj interface{}) sets.String {
 			set.Insert(serviceKeyFunc(ing.Namespace, path.Backend.ServiceName))
 		}
 	}
+	for _, be := range annotations.IngAnnotations(ing.Annotations).Backends() {
+		set.Insert(serviceKeyFunc(ing.Namespace, be.ServiceName))
+	}
 	return set
 }
 
@@ -363,6 +533,12 @@ IngressLoop:
 				}
 			}
 		}
+		for _, be := range annotations.IngAnnotations(ing.Annotations).Backends() {
+			if be.ServiceName == svc.Name {
+				ings = append(ings, ing)
+				continue IngressLoop
+			}
+		}
 	}
 	return
 }
@@ -27,6 +27,7 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/ingress-gce/pkg/annotations"
 	"k8s.io/ingress-gce/pkg/context"
+	"k8s.io/ingress-gce/pkg/svcneg"
 	"k8s.io/ingress-gce/pkg/utils"
 
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -41,7 +42,12 @@ func newTestController(kubeClient kubernetes.Interface) *Controller {
 		NewFakeZoneGetter(),
 		utils.NewNamer(CluseterID, ""),
 		1*time.Second,
+		0,
+		false,
+		EndpointsSourceEndpoints,
+		nil,
 	)
+	controller.manager.(*syncerManager).svcNegClient = svcneg.NewFakeClient()
 	return controller
 }
 
@@ -109,6 +115,61 @@ func TestDisableNEGService(t *testing.T) {
 	validateSyncers(t, controller, 3, true)
 }
 
+func TestStandaloneNEGService(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	controller := newTestController(kubeClient)
+	defer controller.stop()
+	svc := newTestService(false)
+	svc.Annotations[annotations.NEGKey] = `{"exposed_ports": {"80": {"name": "custom-neg"}, "8081": {}}}`
+	if _, err := kubeClient.Core().Services(ServiceNamespace).Create(svc); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	controller.serviceLister.Add(svc)
+	if err := controller.processService(serviceKeyFunc(ServiceNamespace, ServiceName)); err != nil {
+		t.Fatalf("Failed to process service: %v", err)
+	}
+	validateSyncers(t, controller, 2, false)
+
+	wantNames := map[string]string{
+		"8080": "custom-neg",
+		"8081": controller.namer.NEG(ServiceNamespace, ServiceName, "8081"),
+	}
+	for port, name := range wantNames {
+		syncer, ok := controller.manager.(*syncerManager).syncerMap[getSyncerKey(ServiceNamespace, ServiceName, port)]
+		if !ok {
+			t.Errorf("Expect a syncer for target port %v", port)
+			continue
+		}
+		if syncer.NEGName() != name {
+			t.Errorf("got NEG name %q for target port %v, want %q", syncer.NEGName(), port, name)
+		}
+	}
+
+	updated, err := kubeClient.Core().Services(ServiceNamespace).Get(ServiceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	wantStatus := `{"network_endpoint_groups":{"80":"custom-neg","8081":"` + wantNames["8081"] + `"},"zones":["` + TestZone1 + `","` + TestZone2 + `"]}`
+	if got := updated.Annotations[annotations.NEGStatusKey]; got != wantStatus {
+		t.Errorf("got NEG status %q, want %q", got, wantStatus)
+	}
+
+	// Removing the annotation stops the syncers and clears the status.
+	delete(updated.Annotations, annotations.NEGKey)
+	controller.serviceLister.Update(updated)
+	if err := controller.processService(serviceKeyFunc(ServiceNamespace, ServiceName)); err != nil {
+		t.Fatalf("Failed to process service: %v", err)
+	}
+	validateSyncers(t, controller, 2, true)
+	updated, err = kubeClient.Core().Services(ServiceNamespace).Get(ServiceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	if got, ok := updated.Annotations[annotations.NEGStatusKey]; ok {
+		t.Errorf("got NEG status %q, want none", got)
+	}
+}
+
 func TestGatherServiceTargetPortUsedByIngress(t *testing.T) {
 	testCases := []struct {
 		ings   []extensions.Ingress
@@ -0,0 +1,210 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	// dualStackType is the stack type of the subnetworks with IPv6
+	// addresses.
+	dualStackType = "IPV4_IPV6"
+	// ipFamilySeparator separates the IPv4 and IPv6 addresses of the
+	// endpoints of dual-stack Pods in their encoding.
+	ipFamilySeparator = ","
+	addressTypeIPv6   = "IPv6"
+)
+
+// NetworkEndpoint is a network endpoint of a NEG with its IPv6 address,
+// which the vendored compute API predates.
+type NetworkEndpoint struct {
+	Instance    string `json:"instance,omitempty"`
+	IpAddress   string `json:"ipAddress,omitempty"`
+	Ipv6Address string `json:"ipv6Address,omitempty"`
+	Port        int64  `json:"port,omitempty"`
+}
+
+// toAlpha returns the endpoint in the vendored compute API, without its
+// IPv6 address.
+func (e *NetworkEndpoint) toAlpha() *computealpha.NetworkEndpoint {
+	return &computealpha.NetworkEndpoint{Instance: e.Instance, IpAddress: e.IpAddress, Port: e.Port}
+}
+
+// toAlphaEndpoints returns the given endpoints in the vendored compute API,
+// without their IPv6 address.
+func toAlphaEndpoints(endpoints []*NetworkEndpoint) []*computealpha.NetworkEndpoint {
+	ret := make([]*computealpha.NetworkEndpoint, len(endpoints))
+	for i, e := range endpoints {
+		ret[i] = e.toAlpha()
+	}
+	return ret
+}
+
+// networkEndpointsRequest is the body of the calls attaching and detaching
+// network endpoints.
+type networkEndpointsRequest struct {
+	NetworkEndpoints []*NetworkEndpoint `json:"networkEndpoints"`
+}
+
+// networkEndpointList is a page of the network endpoints of a NEG.
+type networkEndpointList struct {
+	Items []struct {
+		NetworkEndpoint *NetworkEndpoint `json:"networkEndpoint"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// subnetwork is the part of a subnetwork telling whether it has IPv6
+// addresses.
+type subnetwork struct {
+	StackType string `json:"stackType"`
+}
+
+// SubnetworkProvider is the part of the cloud provider that knows about the
+// project and the subnetwork of the cluster.
+type SubnetworkProvider interface {
+	ProjectID() string
+	SubnetworkURL() string
+}
+
+// gceDualStackEndpoints implements DualStackEndpoints through the REST API.
+type gceDualStackEndpoints struct {
+	rest          *utils.ComputeREST
+	subnetworkURL string
+}
+
+// NewGCEDualStackEndpoints returns the DualStackEndpoints of the NEGs of the
+// project of the given cloud. tokenSource, transport and apiEndpoint are
+// those of utils.NewComputeREST.
+func NewGCEDualStackEndpoints(cloud SubnetworkProvider, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (DualStackEndpoints, error) {
+	rest, err := utils.NewComputeREST(cloud.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceDualStackEndpoints{rest: rest, subnetworkURL: cloud.SubnetworkURL()}, nil
+}
+
+// AttachDualStackNetworkEndpoints implements DualStackEndpoints.
+func (g *gceDualStackEndpoints) AttachDualStackNetworkEndpoints(name, zone string, endpoints []*NetworkEndpoint) error {
+	return g.rest.DoOp("POST", g.rest.ZonalURL(zone, "networkEndpointGroups", name)+"/attachNetworkEndpoints", &networkEndpointsRequest{NetworkEndpoints: endpoints})
+}
+
+// DetachDualStackNetworkEndpoints implements DualStackEndpoints.
+func (g *gceDualStackEndpoints) DetachDualStackNetworkEndpoints(name, zone string, endpoints []*NetworkEndpoint) error {
+	return g.rest.DoOp("POST", g.rest.ZonalURL(zone, "networkEndpointGroups", name)+"/detachNetworkEndpoints", &networkEndpointsRequest{NetworkEndpoints: endpoints})
+}
+
+// ListDualStackNetworkEndpoints implements DualStackEndpoints.
+func (g *gceDualStackEndpoints) ListDualStackNetworkEndpoints(name, zone string) ([]*NetworkEndpoint, error) {
+	var endpoints []*NetworkEndpoint
+	pageToken := ""
+	for {
+		u := g.rest.ZonalURL(zone, "networkEndpointGroups", name) + "/listNetworkEndpoints"
+		if pageToken != "" {
+			u += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		page := &networkEndpointList{}
+		if err := g.rest.Do("POST", u, nil, page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if item.NetworkEndpoint != nil {
+				endpoints = append(endpoints, item.NetworkEndpoint)
+			}
+		}
+		if page.NextPageToken == "" {
+			return endpoints, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// SubnetworkDualStack implements DualStackEndpoints. Clusters without a
+// subnetwork, in legacy or auto mode networks, are IPv4 only.
+func (g *gceDualStackEndpoints) SubnetworkDualStack() (bool, error) {
+	if g.subnetworkURL == "" {
+		return false, nil
+	}
+	subnet := &subnetwork{}
+	if err := g.rest.Do("GET", g.subnetworkURL, nil, subnet); err != nil {
+		return false, err
+	}
+	return subnet.StackType == dualStackType, nil
+}
+
+// joinIPs returns the IP of the encoding of an endpoint with the given IPv4
+// and IPv6 addresses, the IPv4 address alone if ipv6 is empty.
+func joinIPs(ipv4, ipv6 string) string {
+	if ipv6 == "" {
+		return ipv4
+	}
+	return ipv4 + ipFamilySeparator + ipv6
+}
+
+// splitIPs returns the IPv4 and IPv6 addresses of the given IP of the
+// encoding of an endpoint, the IPv6 address empty for IPv4 only endpoints.
+func splitIPs(ip string) (string, string) {
+	if i := strings.Index(ip, ipFamilySeparator); i >= 0 {
+		return ip[:i], ip[i+len(ipFamilySeparator):]
+	}
+	return ip, ""
+}
+
+// ipv4Endpoint returns the given encoded endpoint without its IPv6 address.
+func ipv4Endpoint(endpoint string) string {
+	ip, instance, port := decodeEndpoint(endpoint)
+	ipv4, _ := splitIPs(ip)
+	return encodeEndpoint(ipv4, instance, port)
+}
+
+// migratedEndpoints moves the endpoints of removeEndpoints attached again
+// with addEndpoints with other IP families, e.g. the IPv4 only endpoints of
+// the NEGs of dual-stack Pods, to the returned zone and endpoints map. GCE
+// rejects attaching an endpoint whose IPv4 address is already attached, so
+// these are detached before the others are synced.
+func migratedEndpoints(addEndpoints, removeEndpoints map[string]sets.String) map[string]sets.String {
+	migrated := map[string]sets.String{}
+	for zone, removeSet := range removeEndpoints {
+		added := sets.NewString()
+		for endpoint := range addEndpoints[zone] {
+			added.Insert(ipv4Endpoint(endpoint))
+		}
+		for endpoint := range removeSet {
+			if !added.Has(ipv4Endpoint(endpoint)) {
+				continue
+			}
+			if migrated[zone] == nil {
+				migrated[zone] = sets.NewString()
+			}
+			migrated[zone].Insert(endpoint)
+			removeSet.Delete(endpoint)
+		}
+		if removeSet.Len() == 0 {
+			delete(removeEndpoints, zone)
+		}
+	}
+	return migrated
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// withPod sets the Pod of the given EndpointSlice endpoint.
+func withPod(endpoint sliceEndpoint, name string) sliceEndpoint {
+	endpoint.TargetRef = &apiv1.ObjectReference{Kind: "Pod", Namespace: ServiceNamespace, Name: name}
+	return endpoint
+}
+
+func TestEndpointsFromDualStackSlices(t *testing.T) {
+	slices := []endpointSlice{
+		newTestSlice(addressTypeIPv4, 80,
+			withPod(newTestSliceEndpoint("10.100.1.1", TestInstance1, nil), "pod1"),
+			withPod(newTestSliceEndpoint("10.100.1.2", TestInstance1, nil), "pod2"),
+			newTestSliceEndpoint("10.100.1.3", TestInstance1, nil),
+		),
+		newTestSlice(addressTypeIPv6, 80, withPod(newTestSliceEndpoint("fd00::1", TestInstance1, nil), "pod1")),
+	}
+	for _, tc := range []struct {
+		dualStack bool
+		want      []string
+	}{
+		{dualStack: false, want: []string{"10.100.1.1", "10.100.1.2", "10.100.1.3"}},
+		// pod2 is IPv4 only, the third endpoint has no Pod.
+		{dualStack: true, want: []string{"10.100.1.1,fd00::1", "10.100.1.2", "10.100.1.3"}},
+	} {
+		ep := endpointsFromSlices(ServiceNamespace, ServiceName, slices, tc.dualStack)
+		var got []string
+		for _, subset := range ep.Subsets {
+			for _, address := range subset.Addresses {
+				got = append(got, address.IP)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("endpointsFromSlices(dualStack: %v) IPs = %v, want %v", tc.dualStack, got, tc.want)
+		}
+	}
+}
+
+func TestMigratedEndpoints(t *testing.T) {
+	addEndpoints := map[string]sets.String{
+		TestZone1: sets.NewString("10.100.1.1,fd00::1||instance1||80", "10.100.1.2||instance1||80"),
+	}
+	removeEndpoints := map[string]sets.String{
+		TestZone1: sets.NewString("10.100.1.1||instance1||80", "10.100.1.3||instance1||80"),
+		TestZone2: sets.NewString("10.100.3.1,fd00::3||instance3||80"),
+	}
+	migrated := migratedEndpoints(addEndpoints, removeEndpoints)
+	if want := map[string]sets.String{TestZone1: sets.NewString("10.100.1.1||instance1||80")}; !reflect.DeepEqual(migrated, want) {
+		t.Errorf("migratedEndpoints() = %v, want %v", migrated, want)
+	}
+	wantRemove := map[string]sets.String{
+		TestZone1: sets.NewString("10.100.1.3||instance1||80"),
+		TestZone2: sets.NewString("10.100.3.1,fd00::3||instance3||80"),
+	}
+	if !reflect.DeepEqual(removeEndpoints, wantRemove) {
+		t.Errorf("removeEndpoints = %v, want %v", removeEndpoints, wantRemove)
+	}
+}
+
+func TestSyncMigratesIPv4OnlyEndpoints(t *testing.T) {
+	syncer := NewTestSyncer()
+	slices := []endpointSlice{
+		newTestSlice(addressTypeIPv4, 80,
+			withPod(newTestSliceEndpoint("10.100.1.1", TestInstance1, nil), "pod1"),
+			withPod(newTestSliceEndpoint("10.100.1.2", TestInstance1, nil), "pod2"),
+		),
+		newTestSlice(addressTypeIPv6, 80,
+			withPod(newTestSliceEndpoint("fd00::1", TestInstance1, nil), "pod1"),
+			withPod(newTestSliceEndpoint("fd00::2", TestInstance1, nil), "pod2"),
+		),
+	}
+	client := &fakeEndpointSliceClient{slices: map[string][]endpointSlice{serviceKeyFunc(ServiceNamespace, ServiceName): slices}}
+	dualStack := newFakeDualStackEndpoints(true)
+	// The NEG was synced before the Pods were dual-stack.
+	dualStack.networkEndpoints[networkEndpointKey(NegName, TestZone1)] = []*NetworkEndpoint{
+		{Instance: TestInstance1, IpAddress: "10.100.1.1", Port: 80},
+		{Instance: TestInstance1, IpAddress: "10.100.1.9", Port: 80},
+	}
+	var err error
+	if syncer.endpointSlices, err = newEndpointSliceSource(client, EndpointsSourceEndpointSlices, dualStack); err != nil {
+		t.Fatalf("newEndpointSliceSource() = %v", err)
+	}
+
+	syncer.init()
+	if _, err := syncer.sync(); err != nil {
+		t.Fatalf("sync() = %v", err)
+	}
+	endpoints, _ := dualStack.ListDualStackNetworkEndpoints(NegName, TestZone1)
+	var got []NetworkEndpoint
+	for _, ne := range endpoints {
+		got = append(got, *ne)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].IpAddress < got[j].IpAddress })
+	want := []NetworkEndpoint{
+		{Instance: TestInstance1, IpAddress: "10.100.1.1", Ipv6Address: "fd00::1", Port: 80},
+		{Instance: TestInstance1, IpAddress: "10.100.1.2", Ipv6Address: "fd00::2", Port: 80},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NEG endpoints = %+v, want %+v", got, want)
+	}
+
+	if _, err := newEndpointSliceSource(client, EndpointsSourceEndpoints, dualStack); err == nil {
+		t.Errorf("newEndpointSliceSource() of the Endpoints with dual-stack endpoints = nil, want an error")
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// Sources of the endpoints of the NEGs.
+const (
+	// EndpointsSourceEndpoints computes the endpoints of the NEGs from the
+	// Endpoints of the services, truncated at 1000 addresses.
+	EndpointsSourceEndpoints = "endpoints"
+	// EndpointsSourceDual computes the endpoints of the NEGs from the
+	// EndpointSlices of the services, falling back to their Endpoints if the
+	// EndpointSlices can't be read, and reports the differences between both.
+	EndpointsSourceDual = "dual"
+	// EndpointsSourceEndpointSlices computes the endpoints of the NEGs from
+	// the EndpointSlices of the services.
+	EndpointsSourceEndpointSlices = "endpointslices"
+)
+
+const (
+	// endpointSliceResyncPeriod is how often the NEGs are synced when their
+	// endpoints are read from EndpointSlices, which aren't watched: the
+	// Endpoints of large services stop changing once truncated.
+	endpointSliceResyncPeriod = time.Minute
+	// serviceNameLabel is the label of the EndpointSlices naming their
+	// service.
+	serviceNameLabel = "kubernetes.io/service-name"
+	// overCapacityAnnotation is set on the Endpoints truncated at 1000
+	// addresses.
+	overCapacityAnnotation = "endpoints.kubernetes.io/over-capacity"
+	addressTypeIPv4        = "IPv4"
+)
+
+// endpointSlice is a discovery.k8s.io/v1 EndpointSlice, which the vendored
+// Kubernetes API predates.
+type endpointSlice struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	AddressType       string              `json:"addressType"`
+	Endpoints         []sliceEndpoint     `json:"endpoints"`
+	Ports             []sliceEndpointPort `json:"ports"`
+}
+
+// sliceEndpoint is an endpoint of an EndpointSlice.
+type sliceEndpoint struct {
+	Addresses  []string `json:"addresses"`
+	Conditions struct {
+		// Ready is nil if unknown, which is taken as ready.
+		Ready *bool `json:"ready,omitempty"`
+	} `json:"conditions,omitempty"`
+	NodeName  *string                `json:"nodeName,omitempty"`
+	TargetRef *apiv1.ObjectReference `json:"targetRef,omitempty"`
+}
+
+// sliceEndpointPort is a port of an EndpointSlice.
+type sliceEndpointPort struct {
+	Name     *string         `json:"name,omitempty"`
+	Port     *int32          `json:"port,omitempty"`
+	Protocol *apiv1.Protocol `json:"protocol,omitempty"`
+}
+
+// endpointSliceList is a list of EndpointSlices.
+type endpointSliceList struct {
+	Items []endpointSlice `json:"items"`
+}
+
+// apiServerEndpointSliceClient implements endpointSliceClient through the
+// apiserver.
+type apiServerEndpointSliceClient struct {
+	client kubernetes.Interface
+}
+
+// ListEndpointSlices implements endpointSliceClient.
+func (c *apiServerEndpointSliceClient) ListEndpointSlices(namespace, name string) ([]endpointSlice, error) {
+	data, err := c.client.Discovery().RESTClient().Get().
+		AbsPath("/apis/discovery.k8s.io/v1/namespaces", namespace, "endpointslices").
+		Param("labelSelector", serviceNameLabel+"="+name).
+		DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the EndpointSlices of service %v/%v: %v", namespace, name, err)
+	}
+	list := &endpointSliceList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("failed to decode the EndpointSlices of service %v/%v: %v", namespace, name, err)
+	}
+	return list.Items, nil
+}
+
+// endpointSliceSource reads the endpoints of the services from their
+// EndpointSlices. A nil endpointSliceSource reads them from the Endpoints
+// only.
+type endpointSliceSource struct {
+	client endpointSliceClient
+	// dual falls back to the Endpoints if the EndpointSlices can't be read,
+	// and reports the differences between both.
+	dual bool
+	// dualStack attaches the IPv6 addresses of the dual-stack Pods, read
+	// from the IPv6 EndpointSlices, along their IPv4 addresses. nil if only
+	// the IPv4 addresses are attached.
+	dualStack DualStackEndpoints
+}
+
+// newEndpointSliceSource returns the endpointSliceSource of the given source
+// of the endpoints, nil for EndpointsSourceEndpoints. dualStack, if not nil,
+// attaches the IPv6 addresses of the dual-stack Pods, which are only read
+// from the EndpointSlices.
+func newEndpointSliceSource(client endpointSliceClient, source string, dualStack DualStackEndpoints) (*endpointSliceSource, error) {
+	switch source {
+	case "", EndpointsSourceEndpoints:
+		if dualStack != nil {
+			return nil, fmt.Errorf("attaching the IPv6 addresses of the Pods requires the %v or %v source of the NEG endpoints", EndpointsSourceDual, EndpointsSourceEndpointSlices)
+		}
+		return nil, nil
+	case EndpointsSourceDual, EndpointsSourceEndpointSlices:
+		return &endpointSliceSource{client: client, dual: source == EndpointsSourceDual, dualStack: dualStack}, nil
+	}
+	return nil, fmt.Errorf("invalid source of the NEG endpoints %q, must be %v, %v or %v", source, EndpointsSourceEndpoints, EndpointsSourceDual, EndpointsSourceEndpointSlices)
+}
+
+// dualStackEndpoints returns the DualStackEndpoints attaching the IPv6
+// addresses of the Pods, nil if only their IPv4 addresses are attached.
+func (e *endpointSliceSource) dualStackEndpoints() DualStackEndpoints {
+	if e == nil {
+		return nil
+	}
+	return e.dualStack
+}
+
+// endpointsFromSlices returns the Endpoints of the given service equivalent
+// to its IPv4 EndpointSlices: a subset by EndpointSlice, with the first
+// address of each endpoint. If dualStack is set, the IPs of the endpoints of
+// the Pods also in the IPv6 EndpointSlices, of dual-stack services, are the
+// IPv4 and IPv6 addresses of the Pods joined by joinIPs. The EndpointSlices of
+// other address types are ignored.
+func endpointsFromSlices(namespace, name string, slices []endpointSlice, dualStack bool) *apiv1.Endpoints {
+	ipv6Addresses := map[string]string{}
+	if dualStack {
+		ipv6Addresses = podIPv6Addresses(slices)
+	}
+	ep := &apiv1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	for _, slice := range slices {
+		if slice.AddressType != addressTypeIPv4 {
+			continue
+		}
+		subset := apiv1.EndpointSubset{}
+		for _, port := range slice.Ports {
+			if port.Port == nil {
+				continue
+			}
+			epPort := apiv1.EndpointPort{Port: *port.Port, Protocol: apiv1.ProtocolTCP}
+			if port.Name != nil {
+				epPort.Name = *port.Name
+			}
+			if port.Protocol != nil {
+				epPort.Protocol = *port.Protocol
+			}
+			subset.Ports = append(subset.Ports, epPort)
+		}
+		for _, endpoint := range slice.Endpoints {
+			if len(endpoint.Addresses) == 0 {
+				continue
+			}
+			address := apiv1.EndpointAddress{IP: endpoint.Addresses[0], NodeName: endpoint.NodeName, TargetRef: endpoint.TargetRef}
+			if pod := slicePod(endpoint); pod != "" {
+				address.IP = joinIPs(address.IP, ipv6Addresses[pod])
+			}
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				subset.Addresses = append(subset.Addresses, address)
+			} else {
+				subset.NotReadyAddresses = append(subset.NotReadyAddresses, address)
+			}
+		}
+		ep.Subsets = append(ep.Subsets, subset)
+	}
+	return ep
+}
+
+// podIPv6Addresses returns the first IPv6 address of the Pods of the IPv6
+// EndpointSlices of the given EndpointSlices, by namespace/name.
+func podIPv6Addresses(slices []endpointSlice) map[string]string {
+	addresses := map[string]string{}
+	for _, slice := range slices {
+		if slice.AddressType != addressTypeIPv6 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if pod := slicePod(endpoint); pod != "" && len(endpoint.Addresses) > 0 {
+				addresses[pod] = endpoint.Addresses[0]
+			}
+		}
+	}
+	return addresses
+}
+
+// slicePod returns the namespace/name of the Pod of the given endpoint, "" if
+// it isn't a Pod.
+func slicePod(endpoint sliceEndpoint) string {
+	if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" {
+		return ""
+	}
+	return serviceKeyFunc(endpoint.TargetRef.Namespace, endpoint.TargetRef.Name)
+}
+
+// serviceEndpoints returns the endpoints of the service of the syncer, from its EndpointSlices if
+// enabled, else from its Endpoints. The endpoints of hybrid NEGs are always read from the Endpoints.
+// Returns nil if the service has no endpoints object yet.
+func (s *syncer) serviceEndpoints(hybridZone string) (*apiv1.Endpoints, error) {
+	obj, exists, err := s.endpointLister.GetByKey(serviceKeyFunc(s.namespace, s.name))
+	if err != nil {
+		return nil, err
+	}
+	var ep *apiv1.Endpoints
+	if exists {
+		ep = obj.(*apiv1.Endpoints)
+	}
+	if s.endpointSlices == nil || hybridZone != "" {
+		return ep, nil
+	}
+
+	slices, err := s.endpointSlices.client.ListEndpointSlices(s.namespace, s.name)
+	if err != nil {
+		if s.endpointSlices.dual && ep != nil {
+			logging.Warningf("Reading the Endpoints of %s/%s instead of their EndpointSlices: %v", s.namespace, s.name, err)
+			return ep, nil
+		}
+		return nil, err
+	}
+	if len(slices) == 0 {
+		if s.endpointSlices.dual {
+			return ep, nil
+		}
+		return nil, nil
+	}
+	sliceEp := endpointsFromSlices(s.namespace, s.name, slices, s.endpointSlices.dualStack != nil)
+	if s.endpointSlices.dual && ep != nil && ep.Annotations[overCapacityAnnotation] == "" {
+		fromEndpoints, fromSlices := s.endpointSet(ep), s.endpointSet(sliceEp)
+		if !fromEndpoints.Equal(fromSlices) {
+			endpointSliceMismatches.Inc()
+			logging.V(2).Infof("The EndpointSlices of %s/%s-%s differ from its Endpoints: %d endpoints only in the EndpointSlices, %d only in the Endpoints",
+				s.namespace, s.name, s.targetPort, fromSlices.Difference(fromEndpoints).Len(), fromEndpoints.Difference(fromSlices).Len())
+		}
+	}
+	return sliceEp, nil
+}
+
+// endpointSet returns the endpoints of the given endpoints object matching the target port,
+// regardless of their zone, without their IPv6 addresses, which the Endpoints lack.
+func (s *syncer) endpointSet(endpoints *apiv1.Endpoints) sets.String {
+	set := sets.NewString()
+	portNames := s.endpointPortNames()
+	for _, subset := range endpoints.Subsets {
+		matchPort := s.matchPort(subset, portNames)
+		if len(matchPort) == 0 {
+			continue
+		}
+		for _, address := range s.endpointAddresses(subset) {
+			instance := ""
+			if address.NodeName != nil {
+				instance = *address.NodeName
+			}
+			ipv4, _ := splitIPs(address.IP)
+			set.Insert(encodeEndpoint(ipv4, instance, matchPort))
+		}
+	}
+	return set
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestSlice(addressType string, port int32, endpoints ...sliceEndpoint) endpointSlice {
+	name := ""
+	return endpointSlice{
+		AddressType: addressType,
+		Endpoints:   endpoints,
+		Ports:       []sliceEndpointPort{{Name: &name, Port: &port}},
+	}
+}
+
+func newTestSliceEndpoint(ip, node string, ready *bool) sliceEndpoint {
+	endpoint := sliceEndpoint{Addresses: []string{ip}, NodeName: &node}
+	endpoint.Conditions.Ready = ready
+	return endpoint
+}
+
+func TestEndpointsFromSlices(t *testing.T) {
+	ready, notReady := true, false
+	instance1, instance2 := TestInstance1, TestInstance2
+	got := endpointsFromSlices(ServiceNamespace, ServiceName, []endpointSlice{
+		newTestSlice(addressTypeIPv4, 80,
+			newTestSliceEndpoint("10.100.1.1", TestInstance1, &ready),
+			newTestSliceEndpoint("10.100.1.2", TestInstance1, nil),
+			newTestSliceEndpoint("10.100.2.1", TestInstance2, &notReady),
+		),
+		newTestSlice("IPv6", 80, newTestSliceEndpoint("fd00::1", TestInstance1, &ready)),
+	}, false)
+	want := &apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ServiceNamespace, Name: ServiceName},
+		Subsets: []apiv1.EndpointSubset{{
+			Addresses: []apiv1.EndpointAddress{
+				{IP: "10.100.1.1", NodeName: &instance1},
+				{IP: "10.100.1.2", NodeName: &instance1},
+			},
+			NotReadyAddresses: []apiv1.EndpointAddress{{IP: "10.100.2.1", NodeName: &instance2}},
+			Ports:             []apiv1.EndpointPort{{Port: 80, Protocol: apiv1.ProtocolTCP}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("endpointsFromSlices() = %+v, want %+v", got, want)
+	}
+}
+
+func TestServiceEndpoints(t *testing.T) {
+	ready := true
+	slice := newTestSlice(addressTypeIPv4, 80, newTestSliceEndpoint("10.100.1.1", TestInstance1, &ready))
+	fromSlices := endpointsFromSlices(ServiceNamespace, ServiceName, []endpointSlice{slice}, false)
+	fromEndpoints := getDefaultEndpoint()
+	for _, tc := range []struct {
+		desc       string
+		source     string
+		slices     []endpointSlice
+		err        error
+		hybridZone string
+		want       *apiv1.Endpoints
+		wantErr    bool
+	}{
+		{desc: "endpoints", source: EndpointsSourceEndpoints, slices: []endpointSlice{slice}, want: fromEndpoints},
+		{desc: "endpointslices", source: EndpointsSourceEndpointSlices, slices: []endpointSlice{slice}, want: fromSlices},
+		{desc: "endpointslices of a hybrid service", source: EndpointsSourceEndpointSlices, slices: []endpointSlice{slice}, hybridZone: TestZone1, want: fromEndpoints},
+		{desc: "no endpointslices", source: EndpointsSourceEndpointSlices},
+		{desc: "endpointslices failing", source: EndpointsSourceEndpointSlices, err: fmt.Errorf("unavailable"), wantErr: true},
+		{desc: "dual", source: EndpointsSourceDual, slices: []endpointSlice{slice}, want: fromSlices},
+		{desc: "dual without endpointslices", source: EndpointsSourceDual, want: fromEndpoints},
+		{desc: "dual with endpointslices failing", source: EndpointsSourceDual, err: fmt.Errorf("unavailable"), want: fromEndpoints},
+	} {
+		syncer := NewTestSyncer()
+		syncer.endpointLister.Add(fromEndpoints)
+		var err error
+		client := &fakeEndpointSliceClient{slices: map[string][]endpointSlice{serviceKeyFunc(ServiceNamespace, ServiceName): tc.slices}, err: tc.err}
+		if syncer.endpointSlices, err = newEndpointSliceSource(client, tc.source, nil); err != nil {
+			t.Fatalf("%v: newEndpointSliceSource() = %v", tc.desc, err)
+		}
+		got, err := syncer.serviceEndpoints(tc.hybridZone)
+		if (err != nil) != tc.wantErr || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: serviceEndpoints() = %+v, %v, want %+v, error %v", tc.desc, got, err, tc.want, tc.wantErr)
+		}
+	}
+	if _, err := newEndpointSliceSource(&fakeEndpointSliceClient{}, "invalid", nil); err == nil {
+		t.Errorf("newEndpointSliceSource() with an invalid source = nil, want an error")
+	}
+}
+
+func TestSyncLargeServiceFromEndpointSlices(t *testing.T) {
+	syncer := NewTestSyncer()
+	// The Endpoints of the service are truncated at 1000 addresses.
+	truncated := &apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   ServiceNamespace,
+			Name:        ServiceName,
+			Annotations: map[string]string{overCapacityAnnotation: "truncated"},
+		},
+	}
+	syncer.endpointLister.Add(truncated)
+	var slices []endpointSlice
+	for i := 0; i < 3; i++ {
+		slice := newTestSlice(addressTypeIPv4, 80)
+		for j := 0; j < 500; j++ {
+			slice.Endpoints = append(slice.Endpoints, newTestSliceEndpoint(fmt.Sprintf("10.%d.%d.%d", i, j/250, j%250), TestInstance1, nil))
+		}
+		slices = append(slices, slice)
+	}
+	client := &fakeEndpointSliceClient{slices: map[string][]endpointSlice{serviceKeyFunc(ServiceNamespace, ServiceName): slices}}
+	syncer.endpointSlices, _ = newEndpointSliceSource(client, EndpointsSourceDual, nil)
+
+	syncer.init()
+	counts, err := syncer.sync()
+	if err != nil {
+		t.Fatalf("sync() = %v", err)
+	}
+	if want := map[string]int{TestZone1: 1500, TestZone2: 0}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("sync() = %v, want %v", counts, want)
+	}
+	endpoints, _ := syncer.cloud.ListNetworkEndpoints(NegName, TestZone1, false)
+	if len(endpoints) != 1500 {
+		t.Errorf("NEG has %d endpoints, want 1500", len(endpoints))
+	}
+}
@@ -19,9 +19,11 @@ package networkendpointgroup
 import (
 	"fmt"
 	computealpha "google.golang.org/api/compute/v0.alpha"
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"reflect"
 	"sync"
+	"time"
 )
 
 const (
@@ -67,7 +69,10 @@ type FakeNetworkEndpointGroupCloud struct {
 	NetworkEndpoints      map[string][]*computealpha.NetworkEndpoint
 	Subnetwork            string
 	Network               string
-	mu                    sync.Mutex
+	// HealthStates are the health states of the endpoints, by IP, listed
+	// with their health status.
+	HealthStates map[string]string
+	mu           sync.Mutex
 }
 
 func NewFakeNetworkEndpointGroupCloud(subnetwork, network string) networkEndpointGroupCloud {
@@ -181,7 +186,11 @@ func (cloud *FakeNetworkEndpointGroupCloud) ListNetworkEndpoints(name, zone stri
 		return nil, NotFoundError
 	}
 	for _, ne := range nes {
-		ret = append(ret, &computealpha.NetworkEndpointWithHealthStatus{NetworkEndpoint: ne})
+		withStatus := &computealpha.NetworkEndpointWithHealthStatus{NetworkEndpoint: ne}
+		if state, ok := cloud.HealthStates[ne.IpAddress]; ok && showHealthStatus {
+			withStatus.Healths = []*computealpha.HealthStatusForNetworkEndpoint{{HealthState: state}}
+		}
+		ret = append(ret, withStatus)
 	}
 	return ret, nil
 }
@@ -193,3 +202,124 @@ func (cloud *FakeNetworkEndpointGroupCloud) NetworkURL() string {
 func (cloud *FakeNetworkEndpointGroupCloud) SubnetworkURL() string {
 	return cloud.Subnetwork
 }
+
+// fakeStatusRecorder records the NEG syncs reported by syncers.
+type fakeStatusRecorder struct {
+	mu    sync.Mutex
+	syncs []servicePort
+}
+
+func (f *fakeStatusRecorder) RecordSync(port servicePort, negName string, endpointCounts map[string]int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncs = append(f.syncs, port)
+}
+
+// fakePodConditionClient keeps the readiness gates and the conditions of
+// the Pods in memory, by namespace/name.
+type fakePodConditionClient struct {
+	mu         sync.Mutex
+	gates      map[string][]string
+	conditions map[string]apiv1.PodCondition
+}
+
+func newFakePodConditionClient(gates map[string][]string) *fakePodConditionClient {
+	return &fakePodConditionClient{gates: gates, conditions: map[string]apiv1.PodCondition{}}
+}
+
+func (f *fakePodConditionClient) ReadinessGates(namespace, name string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.gates[serviceKeyFunc(namespace, name)], nil
+}
+
+func (f *fakePodConditionClient) SetCondition(namespace, name string, condition apiv1.PodCondition) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conditions[serviceKeyFunc(namespace, name)] = condition
+	return nil
+}
+
+// condition returns the condition set on the given Pod, if any.
+func (f *fakePodConditionClient) condition(key string) (apiv1.PodCondition, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.conditions[key]
+	return c, ok
+}
+
+// fakeEndpointSliceClient keeps the EndpointSlices of the services in
+// memory, by namespace/name.
+type fakeEndpointSliceClient struct {
+	slices map[string][]endpointSlice
+	err    error
+}
+
+func (f *fakeEndpointSliceClient) ListEndpointSlices(namespace, name string) ([]endpointSlice, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.slices[serviceKeyFunc(namespace, name)], nil
+}
+
+// fakeDualStackEndpoints keeps the network endpoints of the NEGs with their
+// IPv6 addresses in memory, by zone and name. Like GCE, it rejects attaching
+// an endpoint whose IPv4 address and port are already attached.
+type fakeDualStackEndpoints struct {
+	mu               sync.Mutex
+	networkEndpoints map[string][]*NetworkEndpoint
+	dualStack        bool
+}
+
+func newFakeDualStackEndpoints(dualStack bool) *fakeDualStackEndpoints {
+	return &fakeDualStackEndpoints{networkEndpoints: map[string][]*NetworkEndpoint{}, dualStack: dualStack}
+}
+
+func (f *fakeDualStackEndpoints) AttachDualStackNetworkEndpoints(name, zone string, endpoints []*NetworkEndpoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := networkEndpointKey(name, zone)
+	for _, add := range endpoints {
+		for _, ne := range f.networkEndpoints[key] {
+			if ne.IpAddress == add.IpAddress && ne.Port == add.Port {
+				return fmt.Errorf("endpoint %v:%v already exists", add.IpAddress, add.Port)
+			}
+		}
+	}
+	f.networkEndpoints[key] = append(f.networkEndpoints[key], endpoints...)
+	return nil
+}
+
+func (f *fakeDualStackEndpoints) DetachDualStackNetworkEndpoints(name, zone string, endpoints []*NetworkEndpoint) error {
+	// Detaching takes a while, so that concurrent attach calls find the
+	// endpoints still attached.
+	time.Sleep(10 * time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := networkEndpointKey(name, zone)
+	newList := []*NetworkEndpoint{}
+	for _, ne := range f.networkEndpoints[key] {
+		found := false
+		for _, remove := range endpoints {
+			if reflect.DeepEqual(*ne, *remove) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			newList = append(newList, ne)
+		}
+	}
+	f.networkEndpoints[key] = newList
+	return nil
+}
+
+func (f *fakeDualStackEndpoints) ListDualStackNetworkEndpoints(name, zone string) ([]*NetworkEndpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*NetworkEndpoint{}, f.networkEndpoints[networkEndpointKey(name, zone)]...), nil
+}
+
+func (f *fakeDualStackEndpoints) SubnetworkDualStack() (bool, error) {
+	return f.dualStack, nil
+}
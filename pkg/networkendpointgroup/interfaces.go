@@ -18,7 +18,7 @@ package networkendpointgroup
 
 import (
 	computealpha "google.golang.org/api/compute/v0.alpha"
-	"k8s.io/apimachinery/pkg/util/sets"
+	apiv1 "k8s.io/api/core/v1"
 )
 
 // networkEndpointGroupCloud is an interface for managing gce network endpoint group.
@@ -39,6 +39,7 @@ type networkEndpointGroupCloud interface {
 type networkEndpointGroupNamer interface {
 	NEG(namespace, name, port string) string
 	IsNEG(name string) bool
+	UID() string
 }
 
 // zoneGetter is an interface for retrieve zone related information
@@ -59,12 +60,22 @@ type negSyncer interface {
 	IsStopped() bool
 	// IsShuttingDown returns true if syncer is shutting down
 	IsShuttingDown() bool
+	// NEGName returns the name of the NEGs synced by the syncer
+	NEGName() string
+}
+
+// negStatusRecorder is an interface for syncers to report the status of NEGs
+type negStatusRecorder interface {
+	// RecordSync records the result of a sync of the NEGs of a service port. endpointCounts are the number
+	// of endpoints of the NEGs by zone, nil if the sync failed before the endpoints were known.
+	RecordSync(port servicePort, negName string, endpointCounts map[string]int, err error)
 }
 
 // negSyncerManager is an interface for controllers to manage syncer
 type negSyncerManager interface {
-	// EnsureSyncer ensures corresponding syncers are started and stops any unnecessary syncer
-	EnsureSyncers(namespace, name string, targetPorts sets.String) error
+	// EnsureSyncer ensures corresponding syncers are started and stops any unnecessary syncer.
+	// Ports are target ports mapped to the names of their NEGs, empty names are generated.
+	EnsureSyncers(namespace, name string, ports portNameMap) error
 	// StopSyncer stops all syncers related to the service. This call is asynchronous. It will not wait for all syncers to stop.
 	StopSyncer(namespace, name string)
 	// Sync signals all syncers related to the service to sync. This call is asynchronous.
@@ -74,3 +85,29 @@ type negSyncerManager interface {
 	// ShutDown shuts down the manager
 	ShutDown()
 }
+
+// podConditionClient reads the readiness gates of Pods and sets their
+// conditions, which the vendored Pod doesn't fully expose.
+type podConditionClient interface {
+	// ReadinessGates returns the condition types of the readiness gates of the given Pod.
+	ReadinessGates(namespace, name string) ([]string, error)
+	// SetCondition sets the given condition of the given Pod.
+	SetCondition(namespace, name string, condition apiv1.PodCondition) error
+}
+
+// endpointSliceClient lists the EndpointSlices of services, which the vendored Kubernetes API
+// predates.
+type endpointSliceClient interface {
+	// ListEndpointSlices returns the EndpointSlices of the given service.
+	ListEndpointSlices(namespace, name string) ([]endpointSlice, error)
+}
+
+// DualStackEndpoints attaches, detaches and lists the network endpoints of NEGs with their IPv6
+// address, which the vendored compute API predates.
+type DualStackEndpoints interface {
+	AttachDualStackNetworkEndpoints(name, zone string, endpoints []*NetworkEndpoint) error
+	DetachDualStackNetworkEndpoints(name, zone string, endpoints []*NetworkEndpoint) error
+	ListDualStackNetworkEndpoints(name, zone string) ([]*NetworkEndpoint, error)
+	// SubnetworkDualStack returns true if the subnetwork of the cluster has IPv6 addresses.
+	SubnetworkDualStack() (bool, error)
+}
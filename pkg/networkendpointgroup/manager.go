@@ -18,13 +18,21 @@ package networkendpointgroup
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/golang/glog"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/svcneg"
+	"k8s.io/ingress-gce/pkg/utils"
 )
 
 type serviceKey struct {
@@ -32,56 +40,98 @@ type serviceKey struct {
 	name      string
 }
 
+// portNameMap maps target ports to the names of their NEGs.
+type portNameMap map[string]string
+
+// difference returns the ports of p which are not in other, or have another
+// NEG name in other.
+func (p portNameMap) difference(other portNameMap) portNameMap {
+	result := portNameMap{}
+	for port, name := range p {
+		if otherName, ok := other[port]; !ok || otherName != name {
+			result[port] = name
+		}
+	}
+	return result
+}
+
 // syncerManager contains all the active syncer goroutines and manage their lifecycle.
 type syncerManager struct {
 	namer      networkEndpointGroupNamer
 	recorder   record.EventRecorder
 	cloud      networkEndpointGroupCloud
 	zoneGetter zoneGetter
+	// svcNegClient manages the ServiceNetworkEndpointGroups publishing the
+	// status of the NEGs of each service.
+	svcNegClient svcneg.Client
 
 	serviceLister  cache.Indexer
 	endpointLister cache.Indexer
+	podLister      cache.Indexer
+	// maxEndpointsPerZone caps the endpoints of each NEG, zero if unlimited.
+	maxEndpointsPerZone int
+	// reflector sets the readiness gate of the Pods of the NEGs, nil if
+	// readiness gates are ignored.
+	reflector *readinessReflector
+	// endpointSlices reads the endpoints of the NEGs from the EndpointSlices
+	// of the services, nil if they are read from their Endpoints.
+	endpointSlices *endpointSliceSource
+
+	// statusLock serializes the updates of ServiceNetworkEndpointGroups.
+	statusLock sync.Mutex
 
 	// TODO: lock per service instead of global lock
 	mu sync.Mutex
 	// svcPortMap is the canonical indicator for whether a service needs NEG.
-	// key consists of service namespace and name. Value is the list of target ports that requires NEG
-	svcPortMap map[serviceKey]sets.String
+	// key consists of service namespace and name. Value maps the target ports that requires NEG to the NEG names.
+	svcPortMap map[serviceKey]portNameMap
 	// syncerMap stores the NEG syncer
 	// key consists of service namespace, name and targetPort. Value is the corresponding syncer.
 	syncerMap map[servicePort]negSyncer
+	// orphanNEGs are the NEGs found orphaned by the last garbage collection,
+	// keyed by zone and name. Only accessed by GC.
+	orphanNEGs sets.String
 }
 
-func newSyncerManager(namer networkEndpointGroupNamer, recorder record.EventRecorder, cloud networkEndpointGroupCloud, zoneGetter zoneGetter, serviceLister cache.Indexer, endpointLister cache.Indexer) *syncerManager {
+func newSyncerManager(namer networkEndpointGroupNamer, recorder record.EventRecorder, cloud networkEndpointGroupCloud, zoneGetter zoneGetter, svcNegClient svcneg.Client, serviceLister cache.Indexer, endpointLister cache.Indexer, podLister cache.Indexer, maxEndpointsPerZone int, reflector *readinessReflector, endpointSlices *endpointSliceSource) *syncerManager {
 	return &syncerManager{
-		namer:          namer,
-		recorder:       recorder,
-		cloud:          cloud,
-		zoneGetter:     zoneGetter,
-		serviceLister:  serviceLister,
-		endpointLister: endpointLister,
-		svcPortMap:     make(map[serviceKey]sets.String),
-		syncerMap:      make(map[servicePort]negSyncer),
+		namer:               namer,
+		recorder:            recorder,
+		cloud:               cloud,
+		zoneGetter:          zoneGetter,
+		svcNegClient:        svcNegClient,
+		serviceLister:       serviceLister,
+		endpointLister:      endpointLister,
+		podLister:           podLister,
+		maxEndpointsPerZone: maxEndpointsPerZone,
+		reflector:           reflector,
+		endpointSlices:      endpointSlices,
+		svcPortMap:          make(map[serviceKey]portNameMap),
+		syncerMap:           make(map[servicePort]negSyncer),
 	}
 }
 
 // EnsureSyncer starts and stops syncers based on the input service ports.
-func (manager *syncerManager) EnsureSyncers(namespace, name string, targetPorts sets.String) error {
+func (manager *syncerManager) EnsureSyncers(namespace, name string, ports portNameMap) error {
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 	key := getServiceKey(namespace, name)
 	currentPorts, ok := manager.svcPortMap[key]
 	if !ok {
-		currentPorts = sets.NewString()
+		currentPorts = portNameMap{}
 	}
 
-	removes := currentPorts.Difference(targetPorts).List()
-	adds := targetPorts.Difference(currentPorts).List()
+	targetPorts := portNameMap{}
+	for port, negName := range ports {
+		targetPorts[port] = resolveNEGName(manager.namer, namespace, name, port, negName)
+	}
+	removes := currentPorts.difference(targetPorts)
+	adds := targetPorts.difference(currentPorts)
 	manager.svcPortMap[key] = targetPorts
-	glog.V(3).Infof("EnsureSyncer %v/%v: removing %v ports, adding %v ports", namespace, name, removes, adds)
+	logging.V(3).Infof("EnsureSyncer %v/%v: removing %v ports, adding %v ports", namespace, name, removes, adds)
 
 	// Stop syncer for removed ports
-	for _, port := range removes {
+	for port := range removes {
 		syncer, ok := manager.syncerMap[getSyncerKey(namespace, name, port)]
 		if ok {
 			syncer.Stop()
@@ -90,21 +140,28 @@ func (manager *syncerManager) EnsureSyncers(namespace, name string, targetPorts
 
 	errList := []error{}
 	// Ensure a syncer is running for each port that is being added.
-	for _, port := range adds {
+	for port, negName := range adds {
 		syncer, ok := manager.syncerMap[getSyncerKey(namespace, name, port)]
-		if !ok {
+		// A syncer of the previous NEG name of the port stops on its own.
+		if !ok || syncer.NEGName() != negName {
 			syncer = newSyncer(
 				servicePort{
 					namespace:  namespace,
 					name:       name,
 					targetPort: port,
 				},
-				manager.namer.NEG(namespace, name, port),
+				negName,
+				negDescription(manager.namer.UID(), namespace, name, port),
 				manager.recorder,
+				manager,
 				manager.cloud,
 				manager.zoneGetter,
 				manager.serviceLister,
 				manager.endpointLister,
+				manager.podLister,
+				manager.maxEndpointsPerZone,
+				manager.reflector,
+				manager.endpointSlices,
 			)
 			manager.syncerMap[getSyncerKey(namespace, name, port)] = syncer
 		}
@@ -115,6 +172,13 @@ func (manager *syncerManager) EnsureSyncers(namespace, name string, targetPorts
 			}
 		}
 	}
+	if len(removes) > 0 {
+		if err := manager.updateSvcNEG(namespace, name, func(status *svcneg.ServiceNetworkEndpointGroupStatus) {
+			status.Ports = prunePortStatuses(status.Ports, targetPorts)
+		}); err != nil {
+			errList = append(errList, err)
+		}
+	}
 	return utilerrors.NewAggregate(errList)
 }
 
@@ -124,23 +188,144 @@ func (manager *syncerManager) StopSyncer(namespace, name string) {
 	defer manager.mu.Unlock()
 	key := getServiceKey(namespace, name)
 	if ports, ok := manager.svcPortMap[key]; ok {
-		for _, port := range ports.List() {
+		for port := range ports {
 			if syncer, ok := manager.syncerMap[getSyncerKey(namespace, name, port)]; ok {
 				syncer.Stop()
 			}
 		}
 		delete(manager.svcPortMap, key)
+		manager.statusLock.Lock()
+		defer manager.statusLock.Unlock()
+		if err := manager.svcNegClient.Delete(namespace, name); err != nil {
+			logging.Errorf("Failed to delete NEG status of service %s/%s: %v", namespace, name, err)
+		}
 	}
 	return
 }
 
+// RecordSync records the result of a sync of the NEGs of the service port in
+// the ServiceNetworkEndpointGroup of the service. Syncs of syncers which were
+// stopped meanwhile are ignored.
+func (manager *syncerManager) RecordSync(port servicePort, negName string, endpointCounts map[string]int, syncErr error) {
+	manager.mu.Lock()
+	ports, ok := manager.svcPortMap[getServiceKey(port.namespace, port.name)]
+	current := ok && ports[port.targetPort] == negName
+	manager.mu.Unlock()
+	if !current {
+		return
+	}
+	now := metav1.NewTime(time.Now())
+	if err := manager.updateSvcNEG(port.namespace, port.name, func(status *svcneg.ServiceNetworkEndpointGroupStatus) {
+		status.Ports = setPortStatus(status.Ports, port.targetPort, negName, endpointCounts, syncErr, now)
+	}); err != nil {
+		logging.Errorf("Failed to record NEG status of service %s/%s: %v", port.namespace, port.name, err)
+	}
+}
+
+// updateSvcNEG applies the given update to the status of the
+// ServiceNetworkEndpointGroup of the service. The ServiceNetworkEndpointGroup
+// is created, owned by the service, if it does not exist, and deleted if it
+// has no port left.
+func (manager *syncerManager) updateSvcNEG(namespace, name string, update func(*svcneg.ServiceNetworkEndpointGroupStatus)) error {
+	manager.statusLock.Lock()
+	defer manager.statusLock.Unlock()
+	svcNEG, err := manager.svcNegClient.Get(namespace, name)
+	if err != nil {
+		return err
+	}
+	exists := svcNEG != nil
+	if !exists {
+		svc := getService(manager.serviceLister, namespace, name)
+		if svc == nil {
+			return nil
+		}
+		svcNEG = &svcneg.ServiceNetworkEndpointGroup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(svc, apiv1.SchemeGroupVersion.WithKind("Service"))},
+			},
+		}
+	}
+	oldPorts := append([]svcneg.PortStatus{}, svcNEG.Status.Ports...)
+	update(&svcNEG.Status)
+	switch {
+	case len(svcNEG.Status.Ports) == 0:
+		if !exists {
+			return nil
+		}
+		return manager.svcNegClient.Delete(namespace, name)
+	case !exists:
+		return manager.svcNegClient.Create(svcNEG)
+	case !reflect.DeepEqual(oldPorts, svcNEG.Status.Ports):
+		return manager.svcNegClient.Update(svcNEG)
+	}
+	return nil
+}
+
+// setPortStatus returns the given port statuses with the status of the given
+// target port updated with the result of a sync. The NEGs are only updated
+// if the number of endpoints is known.
+func setPortStatus(statuses []svcneg.PortStatus, targetPort, negName string, endpointCounts map[string]int, syncErr error, now metav1.Time) []svcneg.PortStatus {
+	portStatus := svcneg.PortStatus{TargetPort: targetPort}
+	ret := []svcneg.PortStatus{}
+	for _, ps := range statuses {
+		if ps.TargetPort == targetPort {
+			portStatus = ps
+			continue
+		}
+		ret = append(ret, ps)
+	}
+
+	if endpointCounts != nil {
+		zones := []string{}
+		for zone := range endpointCounts {
+			zones = append(zones, zone)
+		}
+		sort.Strings(zones)
+		portStatus.NetworkEndpointGroups = nil
+		for _, zone := range zones {
+			portStatus.NetworkEndpointGroups = append(portStatus.NetworkEndpointGroups, svcneg.NetworkEndpointGroup{Name: negName, Zone: zone, Endpoints: endpointCounts[zone]})
+		}
+	}
+
+	condition := svcneg.Condition{Type: svcneg.ConditionSynced, Status: svcneg.ConditionTrue, LastTransitionTime: now}
+	if syncErr != nil {
+		condition.Status = svcneg.ConditionFalse
+		condition.Message = syncErr.Error()
+	}
+	for _, c := range portStatus.Conditions {
+		if c.Type == svcneg.ConditionSynced && c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+	portStatus.Conditions = []svcneg.Condition{condition}
+	portStatus.LastSyncTime = now
+
+	ret = append(ret, portStatus)
+	sort.Slice(ret, func(i, j int) bool { return ret[i].TargetPort < ret[j].TargetPort })
+	return ret
+}
+
+// prunePortStatuses returns the given port statuses without the target ports
+// which are no longer synced.
+func prunePortStatuses(statuses []svcneg.PortStatus, ports portNameMap) []svcneg.PortStatus {
+	ret := []svcneg.PortStatus{}
+	for _, ps := range statuses {
+		if _, ok := ports[ps.TargetPort]; ok {
+			ret = append(ret, ps)
+		}
+	}
+	return ret
+}
+
 // Sync signals all syncers related to the service to sync.
 func (manager *syncerManager) Sync(namespace, name string) {
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 	key := getServiceKey(namespace, name)
 	if portList, ok := manager.svcPortMap[key]; ok {
-		for _, port := range portList.List() {
+		for port := range portList {
 			if syncer, ok := manager.syncerMap[getSyncerKey(namespace, name, port)]; ok {
 				if !syncer.IsStopped() {
 					syncer.Sync()
@@ -161,8 +346,8 @@ func (manager *syncerManager) ShutDown() {
 
 // GC garbage collects syncers and NEGs.
 func (manager *syncerManager) GC() error {
-	glog.V(2).Infof("Start NEG garbage collection.")
-	defer glog.V(2).Infof("NEG garbage collection finished.")
+	logging.V(2).Infof("Start NEG garbage collection.")
+	defer logging.V(2).Infof("NEG garbage collection finished.")
 	for _, key := range manager.getAllStoppedSyncerKeys() {
 		manager.garbageCollectSyncer(key)
 	}
@@ -194,46 +379,92 @@ func (manager *syncerManager) getAllStoppedSyncerKeys() []servicePort {
 	return ret
 }
 
+// garbageCollectNEG deletes the NEGs owned by the cluster which no service port needs, e.g. of
+// services deleted while the controller was down, and the NEGs left in zones which are no longer
+// zones of the NEGs, e.g. zones removed from the cluster. A NEG is only deleted if it was already
+// orphaned in the previous pass, so services are processed after a restart before their NEGs are
+// collected, and NEGs still used by a backend service are left for a later pass.
 func (manager *syncerManager) garbageCollectNEG() error {
 	// Retrieve aggregated NEG list from cloud
 	// Compare against svcPortMap and Remove unintended NEGs by best effort
 	zoneNEGList, err := manager.cloud.AggregatedListNetworkEndpointGroup()
 	if err != nil {
+		apiErrors.WithLabelValues(operationList).Inc()
 		return fmt.Errorf("failed to retrieve aggregated NEG list: %v", err)
 	}
+	negZones, err := manager.expectedNEGZones()
+	if err != nil {
+		return err
+	}
 
-	negNames := sets.String{}
-	for _, list := range zoneNEGList {
+	orphans := sets.String{}
+	for zone, list := range zoneNEGList {
 		for _, neg := range list {
-			if manager.namer.IsNEG(neg.Name) {
-				negNames.Insert(neg.Name)
+			if !manager.namer.IsNEG(neg.Name) && !isClusterNEG(neg.Description, manager.namer.UID()) {
+				continue
 			}
-		}
-	}
-
-	func() {
-		manager.mu.Lock()
-		defer manager.mu.Unlock()
-		for key, ports := range manager.svcPortMap {
-			for _, port := range ports.List() {
-				name := manager.namer.NEG(key.namespace, key.name, port)
-				negNames.Delete(name)
+			if zones, ok := negZones[neg.Name]; !ok || !zones.Has(zone) {
+				orphans.Insert(zonedNEGKey(zone, neg.Name))
 			}
 		}
-	}()
+	}
+	candidates := manager.orphanNEGs
+	manager.orphanNEGs = orphans
 
 	// This section includes a potential race condition between deleting neg here and users adds the neg annotation.
 	// The worst outcome of the race condition is that neg is deleted in the end but user actually specifies a neg.
 	// This would be resolved (sync neg) when the next endpoint update or resync arrives.
 	// TODO: avoid race condition here
-	for zone := range zoneNEGList {
-		for _, name := range negNames.List() {
-			if err := manager.ensureDeleteNetworkEndpointGroup(name, zone); err != nil {
-				return fmt.Errorf("failed to delete NEG %q in %q: %v", name, zone, err)
+	var errList []error
+	for _, key := range orphans.Intersection(candidates).List() {
+		zone, name := splitZonedNEGKey(key)
+		err := manager.ensureDeleteNetworkEndpointGroup(name, zone)
+		switch {
+		case err == nil:
+			manager.orphanNEGs.Delete(key)
+		case utils.IsInUsedByError(err):
+			logging.V(2).Infof("Not deleting NEG %q in %q: still used by a backend service", name, zone)
+		default:
+			apiErrors.WithLabelValues(operationDelete).Inc()
+			errList = append(errList, fmt.Errorf("failed to delete NEG %q in %q: %v", name, zone, err))
+		}
+	}
+	return utilerrors.NewAggregate(errList)
+}
+
+// expectedNEGZones returns the zones of the NEGs of the synced service ports, by NEG name: the
+// zone of the hybrid NEGs of hybrid services, else the zones of the cluster.
+func (manager *syncerManager) expectedNEGZones() (map[string]sets.String, error) {
+	clusterZones, err := manager.zoneGetter.ListZones()
+	if err != nil {
+		return nil, err
+	}
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	negZones := map[string]sets.String{}
+	for key, ports := range manager.svcPortMap {
+		zones := sets.NewString(clusterZones...)
+		if svc := getService(manager.serviceLister, key.namespace, key.name); svc != nil {
+			if hybridZone, err := getHybridZone(svc); err == nil && hybridZone != "" {
+				zones = sets.NewString(hybridZone)
 			}
 		}
+		for _, name := range ports {
+			negZones[name] = zones
+		}
 	}
-	return nil
+	return negZones, nil
+}
+
+// zonedNEGKey encodes the zone and name of a NEG into a string key.
+func zonedNEGKey(zone, name string) string {
+	return zone + "/" + name
+}
+
+// splitZonedNEGKey decodes the zone and name of a NEG from a key of zonedNEGKey.
+func splitZonedNEGKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	return parts[0], parts[1]
 }
 
 // ensureDeleteNetworkEndpointGroup ensures neg is delete from zone
@@ -243,10 +474,19 @@ func (manager *syncerManager) ensureDeleteNetworkEndpointGroup(name, zone string
 		// Assume error is caused by not existing
 		return nil
 	}
-	glog.V(2).Infof("Deleting NEG %q in %q.", name, zone)
+	logging.V(2).Infof("Deleting NEG %q in %q.", name, zone)
 	return manager.cloud.DeleteNetworkEndpointGroup(name, zone)
 }
 
+// resolveNEGName returns the name of the NEGs of the given service port, the
+// generated one unless a custom name is given.
+func resolveNEGName(namer networkEndpointGroupNamer, namespace, name, port, customName string) string {
+	if customName != "" {
+		return customName
+	}
+	return namer.NEG(namespace, name, port)
+}
+
 // getSyncerKey encodes a service namespace, name and targetPort into a string key
 func getSyncerKey(namespace, name, port string) servicePort {
 	return servicePort{
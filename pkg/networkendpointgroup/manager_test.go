@@ -17,14 +17,16 @@ limitations under the License.
 package networkendpointgroup
 
 import (
+	"fmt"
+
 	compute "google.golang.org/api/compute/v0.alpha"
 	apiv1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/ingress-gce/pkg/context"
+	"k8s.io/ingress-gce/pkg/svcneg"
 	"k8s.io/ingress-gce/pkg/utils"
 	"testing"
 	"time"
@@ -41,8 +43,13 @@ func NewTestSyncerManager(kubeClient kubernetes.Interface) *syncerManager {
 		record.NewFakeRecorder(100),
 		NewFakeNetworkEndpointGroupCloud("test-subnetwork", "test-network"),
 		NewFakeZoneGetter(),
+		svcneg.NewFakeClient(),
 		context.ServiceInformer.GetIndexer(),
 		context.EndpointInformer.GetIndexer(),
+		context.PodInformer.GetIndexer(),
+		0,
+		nil,
+		nil,
 	)
 	return manager
 }
@@ -51,14 +58,14 @@ func TestEnsureAndStopSyncer(t *testing.T) {
 	testCases := []struct {
 		namespace string
 		name      string
-		ports     sets.String
+		ports     portNameMap
 		stop      bool
 		expect    []servicePort // keys of running syncers
 	}{
 		{
 			"ns1",
 			"n1",
-			sets.NewString("80", "443"),
+			portNameMap{"80": "", "443": ""},
 			false,
 			[]servicePort{
 				getSyncerKey("ns1", "n1", "80"),
@@ -68,7 +75,7 @@ func TestEnsureAndStopSyncer(t *testing.T) {
 		{
 			"ns1",
 			"n1",
-			sets.NewString("80", "namedport"),
+			portNameMap{"80": "", "namedport": ""},
 			false,
 			[]servicePort{
 				getSyncerKey("ns1", "n1", "80"),
@@ -78,7 +85,7 @@ func TestEnsureAndStopSyncer(t *testing.T) {
 		{
 			"ns2",
 			"n1",
-			sets.NewString("80"),
+			portNameMap{"80": ""},
 			false,
 			[]servicePort{
 				getSyncerKey("ns1", "n1", "80"),
@@ -89,7 +96,7 @@ func TestEnsureAndStopSyncer(t *testing.T) {
 		{
 			"ns1",
 			"n1",
-			sets.NewString(),
+			portNameMap{},
 			true,
 			[]servicePort{
 				getSyncerKey("ns2", "n1", "80"),
@@ -141,7 +148,7 @@ func TestEnsureAndStopSyncer(t *testing.T) {
 
 func TestGarbageCollectionSyncer(t *testing.T) {
 	manager := NewTestSyncerManager(fake.NewSimpleClientset())
-	if err := manager.EnsureSyncers("ns1", "n1", sets.NewString("80", "namedport")); err != nil {
+	if err := manager.EnsureSyncers("ns1", "n1", portNameMap{"80": "", "namedport": ""}); err != nil {
 		t.Fatalf("Failed to ensure syncer: %v", err)
 	}
 	manager.StopSyncer("ns1", "n1")
@@ -170,7 +177,7 @@ func TestGarbageCollectionNEG(t *testing.T) {
 		t.Fatalf("Failed to create endpoint: %v", err)
 	}
 	manager := NewTestSyncerManager(kubeClient)
-	if err := manager.EnsureSyncers(ServiceNamespace, ServiceName, sets.NewString("80")); err != nil {
+	if err := manager.EnsureSyncers(ServiceNamespace, ServiceName, portNameMap{"80": ""}); err != nil {
 		t.Fatalf("Failed to ensure syncer: %v", err)
 	}
 
@@ -179,8 +186,11 @@ func TestGarbageCollectionNEG(t *testing.T) {
 		Name: negName,
 	}, TestZone1)
 
-	if err := manager.GC(); err != nil {
-		t.Fatalf("Failed to GC: %v", err)
+	// Orphaned NEGs are only deleted by the second pass.
+	for i := 0; i < 2; i++ {
+		if err := manager.GC(); err != nil {
+			t.Fatalf("Failed to GC: %v", err)
+		}
 	}
 
 	negs, _ := manager.cloud.ListNetworkEndpointGroup(TestZone1)
@@ -193,3 +203,147 @@ func TestGarbageCollectionNEG(t *testing.T) {
 	// make sure there is no leaking go routine
 	manager.StopSyncer(ServiceNamespace, ServiceName)
 }
+
+func TestGarbageCollectionCustomNEG(t *testing.T) {
+	manager := NewTestSyncerManager(fake.NewSimpleClientset())
+	if err := manager.EnsureSyncers(ServiceNamespace, ServiceName, portNameMap{"80": "custom-neg"}); err != nil {
+		t.Fatalf("Failed to ensure syncer: %v", err)
+	}
+	defer manager.StopSyncer(ServiceNamespace, ServiceName)
+
+	for _, neg := range []*compute.NetworkEndpointGroup{
+		{Name: "custom-neg", Description: negDescription(CluseterID, ServiceNamespace, ServiceName, "80")},
+		{Name: "stale-neg", Description: negDescription(CluseterID, ServiceNamespace, ServiceName, "8080")},
+		{Name: "foreign-neg", Description: negDescription("other-cluster", ServiceNamespace, ServiceName, "80")},
+		{Name: "unowned-neg"},
+	} {
+		manager.cloud.CreateNetworkEndpointGroup(neg, TestZone1)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := manager.GC(); err != nil {
+			t.Fatalf("Failed to GC: %v", err)
+		}
+	}
+
+	negs, _ := manager.cloud.ListNetworkEndpointGroup(TestZone1)
+	remaining := map[string]bool{}
+	for _, neg := range negs {
+		remaining[neg.Name] = true
+	}
+	for name, want := range map[string]bool{"custom-neg": true, "stale-neg": false, "foreign-neg": true, "unowned-neg": true} {
+		if remaining[name] != want {
+			t.Errorf("got NEG %q present == %v, want %v", name, remaining[name], want)
+		}
+	}
+}
+
+func TestGarbageCollectionOrphanNEG(t *testing.T) {
+	manager := NewTestSyncerManager(fake.NewSimpleClientset())
+	if err := manager.EnsureSyncers(ServiceNamespace, ServiceName, portNameMap{"80": "custom-neg"}); err != nil {
+		t.Fatalf("Failed to ensure syncer: %v", err)
+	}
+	defer manager.StopSyncer(ServiceNamespace, ServiceName)
+
+	description := negDescription(CluseterID, ServiceNamespace, ServiceName, "80")
+	removedZone := "removed-zone"
+	manager.cloud.CreateNetworkEndpointGroup(&compute.NetworkEndpointGroup{Name: "custom-neg", Description: description}, TestZone1)
+	// The NEG of a zone removed from the cluster.
+	manager.cloud.CreateNetworkEndpointGroup(&compute.NetworkEndpointGroup{Name: "custom-neg", Description: description}, removedZone)
+	// The NEG of a service deleted while the controller was down.
+	manager.cloud.CreateNetworkEndpointGroup(&compute.NetworkEndpointGroup{Name: "deleted-svc-neg", Description: negDescription(CluseterID, ServiceNamespace, "deleted", "80")}, TestZone2)
+
+	exists := func(name, zone string) bool {
+		neg, err := manager.cloud.GetNetworkEndpointGroup(name, zone)
+		return err == nil && neg != nil
+	}
+	if err := manager.GC(); err != nil {
+		t.Fatalf("Failed to GC: %v", err)
+	}
+	if !exists("custom-neg", removedZone) || !exists("deleted-svc-neg", TestZone2) {
+		t.Errorf("Expect orphaned NEGs to survive the first GC pass")
+	}
+	if err := manager.GC(); err != nil {
+		t.Fatalf("Failed to GC: %v", err)
+	}
+	if !exists("custom-neg", TestZone1) {
+		t.Errorf("Expect NEG %q in %q to be kept", "custom-neg", TestZone1)
+	}
+	if exists("custom-neg", removedZone) || exists("deleted-svc-neg", TestZone2) {
+		t.Errorf("Expect orphaned NEGs to be deleted by the second GC pass")
+	}
+}
+
+func TestRecordSync(t *testing.T) {
+	manager := NewTestSyncerManager(fake.NewSimpleClientset())
+	svcNegClient := manager.svcNegClient.(*svcneg.FakeClient)
+	manager.serviceLister.Add(newTestService(true))
+	if err := manager.EnsureSyncers(ServiceNamespace, ServiceName, portNameMap{"8080": "", "8081": ""}); err != nil {
+		t.Fatalf("Failed to ensure syncer: %v", err)
+	}
+	defer manager.StopSyncer(ServiceNamespace, ServiceName)
+	negName := manager.namer.NEG(ServiceNamespace, ServiceName, "8080")
+
+	getPortStatus := func(targetPort string) *svcneg.PortStatus {
+		svcNEG, _ := svcNegClient.Get(ServiceNamespace, ServiceName)
+		if svcNEG == nil {
+			t.Fatalf("Expect ServiceNetworkEndpointGroup %s/%s to exist", ServiceNamespace, ServiceName)
+		}
+		if len(svcNEG.OwnerReferences) != 1 || svcNEG.OwnerReferences[0].Kind != "Service" || svcNEG.OwnerReferences[0].Name != ServiceName {
+			t.Errorf("got owner references %+v, want the service", svcNEG.OwnerReferences)
+		}
+		for i := range svcNEG.Status.Ports {
+			if svcNEG.Status.Ports[i].TargetPort == targetPort {
+				return &svcNEG.Status.Ports[i]
+			}
+		}
+		return nil
+	}
+
+	manager.RecordSync(getSyncerKey(ServiceNamespace, ServiceName, "8080"), negName, map[string]int{TestZone2: 0, TestZone1: 2}, nil)
+	ps := getPortStatus("8080")
+	if ps == nil {
+		t.Fatalf("Expect a status for target port 8080")
+	}
+	wantNEGs := []svcneg.NetworkEndpointGroup{
+		{Name: negName, Zone: TestZone1, Endpoints: 2},
+		{Name: negName, Zone: TestZone2, Endpoints: 0},
+	}
+	if fmt.Sprintf("%+v", ps.NetworkEndpointGroups) != fmt.Sprintf("%+v", wantNEGs) {
+		t.Errorf("got NEGs %+v, want %+v", ps.NetworkEndpointGroups, wantNEGs)
+	}
+	if len(ps.Conditions) != 1 || ps.Conditions[0].Status != svcneg.ConditionTrue {
+		t.Errorf("got conditions %+v, want Synced True", ps.Conditions)
+	}
+
+	// A failed sync keeps the NEGs and flips the condition.
+	manager.RecordSync(getSyncerKey(ServiceNamespace, ServiceName, "8080"), negName, nil, fmt.Errorf("quota exceeded"))
+	ps = getPortStatus("8080")
+	if len(ps.NetworkEndpointGroups) != 2 {
+		t.Errorf("got NEGs %+v, want the NEGs of the last successful sync", ps.NetworkEndpointGroups)
+	}
+	if len(ps.Conditions) != 1 || ps.Conditions[0].Status != svcneg.ConditionFalse || ps.Conditions[0].Message != "quota exceeded" {
+		t.Errorf("got conditions %+v, want Synced False", ps.Conditions)
+	}
+
+	// Syncs of NEGs no longer synced are ignored.
+	manager.RecordSync(getSyncerKey(ServiceNamespace, ServiceName, "8888"), "stale-neg", map[string]int{TestZone1: 1}, nil)
+	if ps := getPortStatus("8888"); ps != nil {
+		t.Errorf("got status %+v for target port 8888, want none", ps)
+	}
+
+	// Removed ports are pruned.
+	if err := manager.EnsureSyncers(ServiceNamespace, ServiceName, portNameMap{"8081": ""}); err != nil {
+		t.Fatalf("Failed to ensure syncer: %v", err)
+	}
+	manager.RecordSync(getSyncerKey(ServiceNamespace, ServiceName, "8081"), manager.namer.NEG(ServiceNamespace, ServiceName, "8081"), map[string]int{TestZone1: 1}, nil)
+	if ps := getPortStatus("8080"); ps != nil {
+		t.Errorf("got status %+v for removed target port 8080, want none", ps)
+	}
+
+	// Stopping the syncers of the service deletes its status.
+	manager.StopSyncer(ServiceNamespace, ServiceName)
+	if svcNEG, _ := svcNegClient.Get(ServiceNamespace, ServiceName); svcNEG != nil {
+		t.Errorf("got ServiceNetworkEndpointGroup %+v, want none", svcNEG)
+	}
+}
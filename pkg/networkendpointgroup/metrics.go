@@ -0,0 +1,165 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const negControllerSubsystem = "neg_controller"
+
+// Operations of the metrics of NEG API calls.
+const (
+	operationAttach = "attach"
+	operationDetach = "detach"
+	operationCreate = "create"
+	operationDelete = "delete"
+	operationList   = "list"
+)
+
+var (
+	// degradedModeSyncs counts the syncs of NEG syncers in degraded mode.
+	degradedModeSyncs = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: negControllerSubsystem,
+			Name:      "degraded_mode_syncs_total",
+			Help:      "Number of NEG syncs in degraded mode, following a failed sync.",
+		},
+	)
+	// skippedEndpoints counts the invalid endpoints skipped by syncs in
+	// degraded mode, by reason.
+	skippedEndpoints = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: negControllerSubsystem,
+			Name:      "skipped_endpoints_total",
+			Help:      "Number of invalid endpoints skipped by NEG syncs in degraded mode.",
+		},
+		[]string{"reason"},
+	)
+	// endpointChanges observes the number of endpoints attached and detached
+	// by each sync.
+	endpointChanges = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: negControllerSubsystem,
+			Name:      "endpoint_changes_per_sync",
+			Help:      "Number of network endpoints attached or detached by a NEG sync.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		},
+		[]string{"operation"},
+	)
+	// zoneSyncLatency observes how long the endpoint changes of a sync take
+	// in each zone.
+	zoneSyncLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: negControllerSubsystem,
+			Name:      "zone_sync_duration_seconds",
+			Help:      "Time taken by a NEG sync to attach and detach the network endpoints of a zone.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+		},
+		[]string{"zone"},
+	)
+	// apiErrors counts the failed NEG API calls by operation.
+	apiErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: negControllerSubsystem,
+			Name:      "api_errors_total",
+			Help:      "Number of failed NEG API calls, by operation.",
+		},
+		[]string{"operation"},
+	)
+	// endpointSliceMismatches counts the syncs reading both the Endpoints
+	// and the EndpointSlices of a service which found different endpoints.
+	endpointSliceMismatches = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: negControllerSubsystem,
+			Name:      "endpointslice_mismatches_total",
+			Help:      "Number of NEG syncs whose EndpointSlices differ from their Endpoints, reading both.",
+		},
+	)
+	// syncStaleness reports the time since the last successful sync of each
+	// NEG.
+	syncStaleness = newStalenessCollector()
+
+	registerMetrics sync.Once
+)
+
+// RegisterMetrics registers the metrics of the NEG controller, served with
+// the other metrics of the controller.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(degradedModeSyncs)
+		prometheus.MustRegister(skippedEndpoints)
+		prometheus.MustRegister(endpointChanges)
+		prometheus.MustRegister(zoneSyncLatency)
+		prometheus.MustRegister(apiErrors)
+		prometheus.MustRegister(endpointSliceMismatches)
+		prometheus.MustRegister(syncStaleness)
+	})
+}
+
+// stalenessCollector reports the time since the last successful sync of each
+// NEG, computed when the metrics are collected so it grows while syncs fail.
+type stalenessCollector struct {
+	desc *prometheus.Desc
+
+	mu       sync.Mutex
+	lastSync map[string]time.Time
+}
+
+func newStalenessCollector() *stalenessCollector {
+	return &stalenessCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName("", negControllerSubsystem, "sync_staleness_seconds"),
+			"Time since the last successful sync of the NEG, or since its syncer started.",
+			[]string{"neg"},
+			nil,
+		),
+		lastSync: map[string]time.Time{},
+	}
+}
+
+// observeSync records a successful sync of the given NEG at the given time.
+func (c *stalenessCollector) observeSync(negName string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSync[negName] = t
+}
+
+// forget stops reporting the given NEG.
+func (c *stalenessCollector) forget(negName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.lastSync, negName)
+}
+
+// Describe implements prometheus.Collector.
+func (c *stalenessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *stalenessCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for negName, t := range c.lastSync {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(t).Seconds(), negName)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestStalenessCollector(t *testing.T) {
+	collector := newStalenessCollector()
+	collector.observeSync("neg-1", time.Now().Add(-time.Minute))
+	collector.observeSync("neg-2", time.Now())
+	collector.forget("neg-2")
+
+	ch := make(chan prometheus.Metric, 10)
+	collector.Collect(ch)
+	close(ch)
+	var metrics []*dto.Metric
+	for m := range ch {
+		metric := &dto.Metric{}
+		if err := m.Write(metric); err != nil {
+			t.Fatalf("Failed to write metric: %v", err)
+		}
+		metrics = append(metrics, metric)
+	}
+	if len(metrics) != 1 {
+		t.Fatalf("Expect the staleness of a single NEG, but got %v", metrics)
+	}
+	if label := metrics[0].GetLabel()[0]; label.GetName() != "neg" || label.GetValue() != "neg-1" {
+		t.Errorf("Expect the staleness of NEG %q, but got %v", "neg-1", label)
+	}
+	if staleness := metrics[0].GetGauge().GetValue(); staleness < 60 {
+		t.Errorf("Expect a staleness of at least 60s, but got %v", staleness)
+	}
+}
@@ -0,0 +1,415 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// ReadinessGate is the condition type of the readiness gate of the Pods
+// served through NEGs. The controller sets it once the endpoint of the Pod is
+// healthy in its NEG, so that rolling updates don't outpace the load
+// balancer.
+const ReadinessGate = "cloud.google.com/load-balancer-neg-ready"
+
+const (
+	// readinessPollInterval is how often the health of the endpoints of the
+	// Pods waiting for their readiness gate is listed.
+	readinessPollInterval = 5 * time.Second
+	// noHealthCheckDelay is how long an attached endpoint may have no health
+	// status before its NEG is taken as not health checked, e.g. not used by
+	// any backend service.
+	noHealthCheckDelay = time.Minute
+	// unattachedDelay is how long a Pod selected by no service with NEGs
+	// waits for its readiness gate, so that the services are processed
+	// after a restart before their Pods are taken as served without NEG.
+	unattachedDelay = 30 * time.Second
+	healthyState    = "HEALTHY"
+)
+
+// Reasons of the readiness gate condition.
+const (
+	negReadyReason              = "LoadBalancerNegReady"
+	negWithoutHealthCheckReason = "LoadBalancerNegWithoutHealthCheck"
+	negNotAttachedReason        = "LoadBalancerNegNotAttached"
+	notInNEGReason              = "LoadBalancerNegNotInNeg"
+)
+
+// pendingPod is a Pod waiting for its readiness gate.
+type pendingPod struct {
+	// negName, zone and endpoint are the NEG endpoint of the Pod, empty if
+	// it is not attached to a NEG.
+	negName  string
+	zone     string
+	endpoint string
+	// excluded is set if the NEG left the Pod out, e.g. above the cap on the
+	// endpoints of each zone.
+	excluded bool
+	since    time.Time
+}
+
+// readinessReflector reflects the health of the endpoints of the Pods in
+// their NEGs into the readiness gate condition of the Pods. Pods without
+// the readiness gate are ignored. Pods selected by no service with NEGs, or
+// left out of their NEG, are ready right away. A nil readinessReflector
+// ignores all Pods.
+type readinessReflector struct {
+	client        podConditionClient
+	cloud         networkEndpointGroupCloud
+	serviceLister cache.Indexer
+	podLister     cache.Indexer
+	clock         clock.Clock
+
+	mu sync.Mutex
+	// gates caches whether the Pods, by UID, have the readiness gate, which
+	// can't change.
+	gates map[types.UID]bool
+	// negServices are the services of the NEGs, by NEG name.
+	negServices map[string]serviceKey
+	// pending are the Pods waiting for their readiness gate, by
+	// namespace/name.
+	pending map[string]*pendingPod
+}
+
+func newReadinessReflector(client podConditionClient, cloud networkEndpointGroupCloud, serviceLister, podLister cache.Indexer) *readinessReflector {
+	return &readinessReflector{
+		client:        client,
+		cloud:         cloud,
+		serviceLister: serviceLister,
+		podLister:     podLister,
+		clock:         clock.RealClock{},
+		gates:         map[types.UID]bool{},
+		negServices:   map[string]serviceKey{},
+		pending:       map[string]*pendingPod{},
+	}
+}
+
+// Run polls the health of the endpoints of the pending Pods until stopCh is
+// closed.
+func (r *readinessReflector) Run(stopCh <-chan struct{}) {
+	wait.Until(r.poll, readinessPollInterval, stopCh)
+}
+
+// AddNEG registers the NEGs of the given name as NEGs of the given service.
+func (r *readinessReflector) AddNEG(namespace, name, negName string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.negServices[negName] = getServiceKey(namespace, name)
+}
+
+// Forget unregisters the NEGs of the given name. Their pending Pods are no
+// longer attached.
+func (r *readinessReflector) Forget(negName string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.negServices, negName)
+	for key, p := range r.pending {
+		if p.negName == negName {
+			r.pending[key] = &pendingPod{since: r.clock.Now()}
+		}
+	}
+}
+
+// SyncNEG records the endpoints of the NEGs of the given name after a
+// successful sync, by zone. endpointPods are the Pods of the endpoints of
+// the service, by endpoint, including those left out of the NEGs.
+func (r *readinessReflector) SyncNEG(negName string, endpoints map[string]sets.String, endpointPods map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	attached := sets.NewString()
+	for zone, zoneEndpoints := range endpoints {
+		for endpoint := range zoneEndpoints {
+			key, ok := endpointPods[endpoint]
+			if !ok {
+				continue
+			}
+			attached.Insert(key)
+			p, ok := r.pending[key]
+			if !ok && !r.podMayWaitForGate(key) {
+				continue
+			}
+			if !ok || p.negName != negName || p.zone != zone || p.endpoint != endpoint {
+				r.pending[key] = &pendingPod{negName: negName, zone: zone, endpoint: endpoint, since: now}
+			}
+		}
+	}
+	for _, key := range endpointPods {
+		// Pods attached to the NEGs of another service stay attached.
+		if p, ok := r.pending[key]; ok && !attached.Has(key) && !p.excluded && (p.negName == "" || p.negName == negName) {
+			r.pending[key] = &pendingPod{excluded: true, since: now}
+		}
+	}
+	for key, p := range r.pending {
+		if p.negName == negName && !attached.Has(key) {
+			r.pending[key] = &pendingPod{since: now}
+		}
+	}
+}
+
+// processPod adds the given Pod to the pending Pods if it may be waiting for
+// its readiness gate.
+func (r *readinessReflector) processPod(obj interface{}) {
+	pod, ok := obj.(*apiv1.Pod)
+	if !ok || !mayWaitForGate(pod) {
+		return
+	}
+	key := serviceKeyFunc(pod.Namespace, pod.Name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pending[key]; !ok {
+		r.pending[key] = &pendingPod{since: r.clock.Now()}
+	}
+}
+
+// podMayWaitForGate returns true if the Pod of the given namespace/name key
+// may only wait for its readiness gate.
+func (r *readinessReflector) podMayWaitForGate(key string) bool {
+	obj, exists, err := r.podLister.GetByKey(key)
+	return err == nil && exists && mayWaitForGate(obj.(*apiv1.Pod))
+}
+
+// waitsForGate returns true if the Pod of the given endpoint address only
+// waits for its readiness gate, so that its endpoint is attached to the NEGs
+// although the Pod is not ready.
+func (r *readinessReflector) waitsForGate(address apiv1.EndpointAddress) bool {
+	if r == nil || address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+		return false
+	}
+	obj, exists, err := r.podLister.GetByKey(serviceKeyFunc(address.TargetRef.Namespace, address.TargetRef.Name))
+	if err != nil || !exists {
+		return false
+	}
+	pod := obj.(*apiv1.Pod)
+	return mayWaitForGate(pod) && r.hasGate(pod)
+}
+
+// hasGate returns true if the given Pod has the readiness gate. The vendored
+// Pod has no readiness gates, they are read from the apiserver once per Pod.
+func (r *readinessReflector) hasGate(pod *apiv1.Pod) bool {
+	r.mu.Lock()
+	hasGate, ok := r.gates[pod.UID]
+	r.mu.Unlock()
+	if ok {
+		return hasGate
+	}
+	gates, err := r.client.ReadinessGates(pod.Namespace, pod.Name)
+	if err != nil {
+		logging.Errorf("Failed to get the readiness gates of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return false
+	}
+	hasGate = sets.NewString(gates...).Has(ReadinessGate)
+	r.mu.Lock()
+	r.gates[pod.UID] = hasGate
+	r.mu.Unlock()
+	return hasGate
+}
+
+// poll sets the readiness gate condition of the pending Pods which are
+// healthy in their NEG, or not served through a NEG.
+func (r *readinessReflector) poll() {
+	r.mu.Lock()
+	pending := map[string]*pendingPod{}
+	for key, p := range r.pending {
+		pending[key] = p
+	}
+	r.mu.Unlock()
+
+	// The endpoints of each NEG are listed once, with their health states, by
+	// their IPv4 address: the vendored compute API lacks their IPv6 address.
+	healths := map[string]map[string][]string{}
+	for _, p := range pending {
+		negKey := zonedNEGKey(p.zone, p.negName)
+		if p.negName == "" || healths[negKey] != nil {
+			continue
+		}
+		endpoints, err := r.cloud.ListNetworkEndpoints(p.negName, p.zone, true)
+		if err != nil {
+			apiErrors.WithLabelValues(operationList).Inc()
+			logging.Errorf("Failed to list the endpoints of NEG %q in %q: %v", p.negName, p.zone, err)
+			continue
+		}
+		healths[negKey] = map[string][]string{}
+		for _, ne := range endpoints {
+			states := []string{}
+			for _, h := range ne.Healths {
+				states = append(states, h.HealthState)
+			}
+			healths[negKey][encodeEndpoint(ne.NetworkEndpoint.IpAddress, ne.NetworkEndpoint.Instance, fmt.Sprint(ne.NetworkEndpoint.Port))] = states
+		}
+	}
+
+	for key, p := range pending {
+		obj, exists, err := r.podLister.GetByKey(key)
+		if err != nil || !exists || !mayWaitForGate(obj.(*apiv1.Pod)) || !r.hasGate(obj.(*apiv1.Pod)) {
+			r.done(key, p)
+			continue
+		}
+		pod := obj.(*apiv1.Pod)
+		reason, message := "", ""
+		switch {
+		case p.excluded:
+			reason, message = negNotAttachedReason, "Pod is left out of its NEG"
+		case p.negName != "":
+			states, ok := healths[zonedNEGKey(p.zone, p.negName)][ipv4Endpoint(p.endpoint)]
+			switch {
+			case sets.NewString(states...).Has(healthyState):
+				reason, message = negReadyReason, fmt.Sprintf("Pod has become Healthy in NEG %q in %q", p.negName, p.zone)
+			case ok && len(states) == 0 && r.clock.Since(p.since) >= noHealthCheckDelay:
+				reason, message = negWithoutHealthCheckReason, fmt.Sprintf("NEG %q in %q is not health checked", p.negName, p.zone)
+			}
+		case r.clock.Since(p.since) >= unattachedDelay && !r.servedByNEG(pod):
+			reason, message = notInNEGReason, "Pod does not belong to any NEG"
+		}
+		if reason == "" {
+			continue
+		}
+		if err := r.setReady(pod, reason, message); err != nil {
+			logging.Errorf("Failed to set the readiness gate of pod %v: %v", key, err)
+			continue
+		}
+		r.done(key, p)
+	}
+}
+
+// done removes the given pending Pod, unless it changed meanwhile.
+func (r *readinessReflector) done(key string, p *pendingPod) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[key] == p {
+		delete(r.pending, key)
+	}
+}
+
+// servedByNEG returns true if the given Pod is selected by a service with
+// NEGs.
+func (r *readinessReflector) servedByNEG(pod *apiv1.Pod) bool {
+	r.mu.Lock()
+	keys := []serviceKey{}
+	for _, key := range r.negServices {
+		keys = append(keys, key)
+	}
+	r.mu.Unlock()
+	for _, key := range keys {
+		if key.namespace != pod.Namespace {
+			continue
+		}
+		svc := getService(r.serviceLister, key.namespace, key.name)
+		if svc != nil && len(svc.Spec.Selector) > 0 && labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// setReady sets the readiness gate condition of the given Pod.
+func (r *readinessReflector) setReady(pod *apiv1.Pod, reason, message string) error {
+	logging.V(2).Infof("Setting the readiness gate of pod %s/%s: %s", pod.Namespace, pod.Name, message)
+	return r.client.SetCondition(pod.Namespace, pod.Name, apiv1.PodCondition{
+		Type:               ReadinessGate,
+		Status:             apiv1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.NewTime(r.clock.Now()),
+	})
+}
+
+// mayWaitForGate returns true if the given Pod may only wait for its
+// readiness gate: its containers are ready, the Pod isn't, and the readiness
+// gate condition isn't set.
+func mayWaitForGate(pod *apiv1.Pod) bool {
+	return pod.DeletionTimestamp == nil &&
+		podConditionStatus(pod, "ContainersReady") == apiv1.ConditionTrue &&
+		podConditionStatus(pod, apiv1.PodReady) != apiv1.ConditionTrue &&
+		podConditionStatus(pod, ReadinessGate) != apiv1.ConditionTrue
+}
+
+// podConditionStatus returns the status of the given condition of the Pod,
+// empty if it has none.
+func podConditionStatus(pod *apiv1.Pod, conditionType apiv1.PodConditionType) apiv1.ConditionStatus {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == conditionType {
+			return c.Status
+		}
+	}
+	return ""
+}
+
+// apiServerPodClient implements podConditionClient through the apiserver.
+type apiServerPodClient struct {
+	client kubernetes.Interface
+}
+
+// ReadinessGates implements podConditionClient. The Pod is read raw, since
+// the vendored Pod has no readiness gates.
+func (c *apiServerPodClient) ReadinessGates(namespace, name string) ([]string, error) {
+	data, err := c.client.Core().RESTClient().Get().Namespace(namespace).Resource("pods").Name(name).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	pod := struct {
+		Spec struct {
+			ReadinessGates []struct {
+				ConditionType string `json:"conditionType"`
+			} `json:"readinessGates"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, fmt.Errorf("failed to decode pod %v/%v: %v", namespace, name, err)
+	}
+	gates := []string{}
+	for _, gate := range pod.Spec.ReadinessGates {
+		gates = append(gates, gate.ConditionType)
+	}
+	return gates, nil
+}
+
+// SetCondition implements podConditionClient. The condition is patched, so
+// that the status fields the vendored Pod lacks are kept.
+func (c *apiServerPodClient) SetCondition(namespace, name string, condition apiv1.PodCondition) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []apiv1.PodCondition{condition}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Core().Pods(namespace).Patch(name, types.StrategicMergePatchType, patch, "status")
+	return err
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newReadinessTestPod(name, ip, node string, ready bool) *apiv1.Pod {
+	readyStatus := apiv1.ConditionFalse
+	if ready {
+		readyStatus = apiv1.ConditionTrue
+	}
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ServiceNamespace,
+			Name:      name,
+			UID:       "uid-" + types.UID(name),
+			Labels:    map[string]string{"app": name},
+		},
+		Spec: apiv1.PodSpec{NodeName: node},
+		Status: apiv1.PodStatus{
+			PodIP: ip,
+			Conditions: []apiv1.PodCondition{
+				{Type: "ContainersReady", Status: apiv1.ConditionTrue},
+				{Type: apiv1.PodReady, Status: readyStatus},
+			},
+		},
+	}
+}
+
+func TestReadinessReflector(t *testing.T) {
+	syncer := NewTestSyncer()
+	cloud := syncer.cloud.(*FakeNetworkEndpointGroupCloud)
+	cloud.HealthStates = map[string]string{"10.100.1.1": healthyState, "10.100.1.2": "UNHEALTHY"}
+	// The Pods of the service are those of its endpoints, the orphan Pod is
+	// selected by no service with NEGs.
+	pods := []*apiv1.Pod{
+		newReadinessTestPod("healthy", "10.100.1.1", TestInstance1, false),
+		newReadinessTestPod("unhealthy", "10.100.1.2", TestInstance1, false),
+		newReadinessTestPod("unchecked", "10.100.2.1", TestInstance2, false),
+		newReadinessTestPod("no-gate", "10.100.2.2", TestInstance2, false),
+		newReadinessTestPod("ready", "10.100.2.3", TestInstance2, true),
+		newReadinessTestPod("orphan", "10.100.3.1", TestInstance3, false),
+	}
+	client := newFakePodConditionClient(map[string][]string{})
+	for _, name := range []string{"healthy", "unhealthy", "unchecked", "ready", "orphan"} {
+		client.gates[serviceKeyFunc(ServiceNamespace, name)] = []string{ReadinessGate}
+	}
+	fakeClock := clock.NewFakeClock(time.Now())
+	syncer.reflector = newReadinessReflector(client, cloud, syncer.serviceLister, syncer.podLister)
+	syncer.reflector.clock = fakeClock
+
+	svc := &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ServiceNamespace, Name: ServiceName},
+		Spec: apiv1.ServiceSpec{
+			Selector: map[string]string{"app": "healthy"},
+			Ports:    []apiv1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(80)}},
+		},
+	}
+	syncer.serviceLister.Add(svc)
+	endpoints := &apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ServiceNamespace, Name: ServiceName},
+		Subsets:    []apiv1.EndpointSubset{{Ports: []apiv1.EndpointPort{{Port: 80, Protocol: apiv1.ProtocolTCP}}}},
+	}
+	for _, pod := range pods {
+		syncer.podLister.Add(pod)
+		syncer.reflector.processPod(pod)
+		if pod.Name == "orphan" {
+			continue
+		}
+		node := pod.Spec.NodeName
+		address := apiv1.EndpointAddress{
+			IP:        pod.Status.PodIP,
+			NodeName:  &node,
+			TargetRef: &apiv1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name},
+		}
+		if pod.Name == "ready" {
+			endpoints.Subsets[0].Addresses = append(endpoints.Subsets[0].Addresses, address)
+		} else {
+			endpoints.Subsets[0].NotReadyAddresses = append(endpoints.Subsets[0].NotReadyAddresses, address)
+		}
+	}
+	syncer.endpointLister.Add(endpoints)
+
+	syncer.init()
+	syncer.reflector.AddNEG(ServiceNamespace, ServiceName, NegName)
+	if _, err := syncer.sync(); err != nil {
+		t.Fatalf("sync() = %v", err)
+	}
+	// Only the Pods waiting for their readiness gate, and the ready ones,
+	// are attached.
+	attached := map[string]bool{}
+	for _, zone := range []string{TestZone1, TestZone2} {
+		endpoints, _ := cloud.ListNetworkEndpoints(NegName, zone, false)
+		for _, ne := range endpoints {
+			attached[ne.NetworkEndpoint.IpAddress] = true
+		}
+	}
+	for ip, want := range map[string]bool{"10.100.1.1": true, "10.100.1.2": true, "10.100.2.1": true, "10.100.2.2": false, "10.100.2.3": true} {
+		if attached[ip] != want {
+			t.Errorf("endpoint %v attached = %v, want %v", ip, attached[ip], want)
+		}
+	}
+
+	for _, step := range []struct {
+		desc  string
+		delay time.Duration
+		want  map[string]string
+	}{
+		{
+			desc: "right after the sync",
+			want: map[string]string{"healthy": negReadyReason},
+		},
+		{
+			desc:  "once the delays elapsed",
+			delay: noHealthCheckDelay,
+			want: map[string]string{
+				"healthy":   negReadyReason,
+				"unchecked": negWithoutHealthCheckReason,
+				"orphan":    notInNEGReason,
+			},
+		},
+	} {
+		fakeClock.Step(step.delay)
+		syncer.reflector.poll()
+		for _, pod := range pods {
+			got, ok := client.condition(serviceKeyFunc(pod.Namespace, pod.Name))
+			want, wantOK := step.want[pod.Name]
+			if ok != wantOK || got.Reason != want {
+				t.Errorf("%v: condition of pod %v = %+v, %v, want reason %q", step.desc, pod.Name, got, ok, want)
+			}
+			if ok && (got.Type != ReadinessGate || got.Status != apiv1.ConditionTrue) {
+				t.Errorf("%v: condition of pod %v = %+v, want a true %v condition", step.desc, pod.Name, got, ReadinessGate)
+			}
+		}
+	}
+}
+
+func TestReadinessReflectorExcludedPods(t *testing.T) {
+	syncer := NewTestSyncer()
+	client := newFakePodConditionClient(map[string][]string{serviceKeyFunc(ServiceNamespace, "pod"): {ReadinessGate}})
+	reflector := newReadinessReflector(client, syncer.cloud, syncer.serviceLister, syncer.podLister)
+	pod := newReadinessTestPod("pod", "10.100.1.1", TestInstance1, false)
+	syncer.podLister.Add(pod)
+	reflector.processPod(pod)
+
+	// The Pod is left out of the NEG, e.g. above the cap on its endpoints.
+	endpoint := encodeEndpoint("10.100.1.1", TestInstance1, "80")
+	reflector.SyncNEG(NegName, nil, map[string]string{endpoint: serviceKeyFunc(ServiceNamespace, "pod")})
+	reflector.poll()
+	if got, ok := client.condition(serviceKeyFunc(ServiceNamespace, "pod")); !ok || got.Reason != negNotAttachedReason {
+		t.Errorf("condition of the excluded pod = %+v, %v, want reason %q", got, ok, negNotAttachedReason)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// subsetZoneNetworkEndpointMap returns the given zone and endpoints map with at most
+// maxEndpoints endpoints in each zone. Endpoints are picked by rendezvous hashing: the endpoints
+// with the lowest hash of the NEG name and the endpoint are kept. The subset of a NEG only
+// depends on its endpoints, and adding or removing an endpoint changes at most one other
+// endpoint of the subset, so the churn of the NEGs stays minimal.
+func subsetZoneNetworkEndpointMap(negName string, zoneEndpoints map[string]sets.String, maxEndpoints int) map[string]sets.String {
+	ret := map[string]sets.String{}
+	for zone, endpoints := range zoneEndpoints {
+		if endpoints.Len() <= maxEndpoints {
+			ret[zone] = endpoints
+			continue
+		}
+		list := endpoints.List()
+		hashes := make(map[string]uint64, len(list))
+		for _, endpoint := range list {
+			hashes[endpoint] = endpointHash(negName, endpoint)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if hashes[list[i]] != hashes[list[j]] {
+				return hashes[list[i]] < hashes[list[j]]
+			}
+			return list[i] < list[j]
+		})
+		ret[zone] = sets.NewString(list[:maxEndpoints]...)
+	}
+	return ret
+}
+
+// endpointHash returns the rendezvous hash of the given endpoint for the given NEG. Hashing the
+// NEG name spreads the subsets of different NEGs over different endpoints.
+func endpointHash(negName, endpoint string) uint64 {
+	sum := sha256.Sum256([]byte(negName + "/" + endpoint))
+	return binary.BigEndian.Uint64(sum[:8])
+}
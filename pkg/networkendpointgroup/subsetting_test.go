@@ -0,0 +1,55 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkendpointgroup
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestSubsetZoneNetworkEndpointMap(t *testing.T) {
+	endpoints := sets.NewString()
+	for i := 0; i < 100; i++ {
+		endpoints.Insert(encodeEndpoint(fmt.Sprintf("10.100.1.%d", i), TestInstance1, "80"))
+	}
+	small := sets.NewString("10.100.2.1||instance3||80")
+	subset := subsetZoneNetworkEndpointMap(NegName, map[string]sets.String{TestZone1: endpoints, TestZone2: small}, 10)
+	if subset[TestZone1].Len() != 10 || !endpoints.IsSuperset(subset[TestZone1]) {
+		t.Errorf("Expect a subset of 10 endpoints, but got %v", subset[TestZone1])
+	}
+	if !subset[TestZone2].Equal(small) {
+		t.Errorf("Expect zones under the limit to keep all endpoints, but got %v", subset[TestZone2])
+	}
+
+	// Removing an endpoint outside of the subset keeps the subset, removing one of the subset
+	// replaces it with a single endpoint.
+	for _, removed := range []string{endpoints.Difference(subset[TestZone1]).List()[0], subset[TestZone1].List()[0]} {
+		remaining := sets.NewString(endpoints.List()...)
+		remaining.Delete(removed)
+		newSubset := subsetZoneNetworkEndpointMap(NegName, map[string]sets.String{TestZone1: remaining}, 10)[TestZone1]
+		if newSubset.Len() != 10 || newSubset.Difference(subset[TestZone1]).Len() > 1 {
+			t.Errorf("Expect removing %q to change at most one endpoint of %v, but got %v", removed, subset[TestZone1], newSubset)
+		}
+	}
+
+	other := subsetZoneNetworkEndpointMap("other-neg", map[string]sets.String{TestZone1: endpoints}, 10)[TestZone1]
+	if other.Equal(subset[TestZone1]) {
+		t.Errorf("Expect NEGs to pick different subsets, but both got %v", other)
+	}
+}
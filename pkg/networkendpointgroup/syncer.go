@@ -17,29 +17,51 @@ limitations under the License.
 package networkendpointgroup
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	compute "google.golang.org/api/compute/v0.alpha"
 	apiv1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/clock"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/logging"
 	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
 )
 
 const (
 	MAX_NETWORK_ENDPOINTS_PER_BATCH = 500
-	minRetryDelay                   = 5 * time.Second
-	maxRetryDelay                   = 300 * time.Second
+	// maxConcurrentOperations is the maximum number of attach and detach
+	// calls a sync runs in parallel, across all zones.
+	maxConcurrentOperations = 10
+	minRetryDelay           = 5 * time.Second
+	maxRetryDelay           = 300 * time.Second
+	// nonGCPNetworkEndpointType is the endpoint type of hybrid NEGs, whose
+	// endpoints are addresses outside of GCP without an instance.
+	nonGCPNetworkEndpointType = "NON_GCP_PRIVATE_IP_PORT"
+	// endpointCacheTTL is how long the endpoints of the NEGs are trusted
+	// after a successful sync, before they are listed from GCE again.
+	endpointCacheTTL = 10 * time.Minute
+)
+
+// Reasons of the endpoints skipped in degraded mode.
+const (
+	skipReasonNoPod         = "no-pod"
+	skipReasonPodNotFound   = "pod-not-found"
+	skipReasonPodNotReady   = "pod-without-ip-or-node"
+	skipReasonUnknownZone   = "unknown-zone"
+	skipReasonInvalidIP     = "invalid-ip"
+	degradedModeEventReason = "DegradedMode"
 )
 
 // servicePort includes information to uniquely identify a NEG
@@ -51,16 +73,40 @@ type servicePort struct {
 }
 
 // syncer handles synchorizing NEGs for one service port. It handles sync, resync and retry on error.
+// The GCE_VM_IP NEGs of the nodes, backing the L4 load balancers, are synced by pkg/l4.
 type syncer struct {
 	servicePort
 	negName string
+	// negDescription identifies the NEGs as owned by the cluster, since
+	// custom NEG names carry no cluster UID.
+	negDescription string
 
 	serviceLister  cache.Indexer
 	endpointLister cache.Indexer
-
-	recorder   record.EventRecorder
-	cloud      networkEndpointGroupCloud
-	zoneGetter zoneGetter
+	podLister      cache.Indexer
+	// maxEndpointsPerZone caps the endpoints of each NEG, zero if unlimited.
+	maxEndpointsPerZone int
+	// reflector sets the readiness gate of the Pods of the endpoints, nil if
+	// readiness gates are ignored.
+	reflector *readinessReflector
+	// endpointSlices reads the endpoints from the EndpointSlices of the
+	// service, nil if they are read from its Endpoints.
+	endpointSlices *endpointSliceSource
+
+	// degraded is set after a failed sync. Syncs in degraded mode compute
+	// the endpoints from the Pods, skipping invalid endpoints rather than
+	// failing, and list the endpoints of the NEGs from GCE, until a sync
+	// succeeds.
+	degraded bool
+	// endpointCache are the endpoints of the NEGs by zone after the last
+	// successful sync, nil if they must be listed from GCE.
+	endpointCache     map[string]sets.String
+	endpointCacheTime time.Time
+
+	recorder       record.EventRecorder
+	statusRecorder negStatusRecorder
+	cloud          networkEndpointGroupCloud
+	zoneGetter     zoneGetter
 
 	stateLock    sync.Mutex
 	stopped      bool
@@ -72,21 +118,27 @@ type syncer struct {
 	retryCount     int
 }
 
-func newSyncer(svcPort servicePort, networkEndpointGroupName string, recorder record.EventRecorder, cloud networkEndpointGroupCloud, zoneGetter zoneGetter, serviceLister cache.Indexer, endpointLister cache.Indexer) *syncer {
-	glog.V(2).Infof("New syncer for service %s/%s port %s NEG %q", svcPort.namespace, svcPort.name, svcPort.targetPort, networkEndpointGroupName)
+func newSyncer(svcPort servicePort, networkEndpointGroupName, description string, recorder record.EventRecorder, statusRecorder negStatusRecorder, cloud networkEndpointGroupCloud, zoneGetter zoneGetter, serviceLister cache.Indexer, endpointLister cache.Indexer, podLister cache.Indexer, maxEndpointsPerZone int, reflector *readinessReflector, endpointSlices *endpointSliceSource) *syncer {
+	logging.V(2).Infof("New syncer for service %s/%s port %s NEG %q", svcPort.namespace, svcPort.name, svcPort.targetPort, networkEndpointGroupName)
 	return &syncer{
-		servicePort:    svcPort,
-		negName:        networkEndpointGroupName,
-		recorder:       recorder,
-		serviceLister:  serviceLister,
-		cloud:          cloud,
-		endpointLister: endpointLister,
-		zoneGetter:     zoneGetter,
-		stopped:        true,
-		shuttingDown:   false,
-		clock:          clock.RealClock{},
-		lastRetryDelay: time.Duration(0),
-		retryCount:     0,
+		servicePort:         svcPort,
+		negName:             networkEndpointGroupName,
+		negDescription:      description,
+		recorder:            recorder,
+		statusRecorder:      statusRecorder,
+		serviceLister:       serviceLister,
+		cloud:               cloud,
+		endpointLister:      endpointLister,
+		podLister:           podLister,
+		maxEndpointsPerZone: maxEndpointsPerZone,
+		reflector:           reflector,
+		endpointSlices:      endpointSlices,
+		zoneGetter:          zoneGetter,
+		stopped:             true,
+		shuttingDown:        false,
+		clock:               clock.RealClock{},
+		lastRetryDelay:      time.Duration(0),
+		retryCount:          0,
 	}
 }
 
@@ -106,14 +158,23 @@ func (s *syncer) Start() error {
 		return fmt.Errorf("NEG syncer for %s/%s-%s is shutting down. ", s.namespace, s.name, s.targetPort)
 	}
 
-	glog.V(2).Infof("Starting NEG syncer for service port %s/%s-%s", s.namespace, s.name, s.targetPort)
+	logging.V(2).Infof("Starting NEG syncer for service port %s/%s-%s", s.namespace, s.name, s.targetPort)
 	s.init()
+	s.reflector.AddNEG(s.namespace, s.name, s.negName)
+	syncStaleness.observeSync(s.negName, s.clock.Now())
 	go func() {
 		for {
 			// equivalent to never retry
 			retryCh := make(<-chan time.Time)
-			err := s.sync()
+			endpointCounts, err := s.sync()
+			if endpointCounts != nil || err != nil {
+				s.statusRecorder.RecordSync(s.servicePort, s.negName, endpointCounts, err)
+			}
 			if err != nil {
+				// The NEGs may not be in the expected state anymore, and the endpoints object may
+				// be the cause of the error.
+				s.degraded = true
+				s.endpointCache = nil
 				retryMesg := ""
 				if s.retryCount > maxRetries {
 					retryMesg = "(will not retry)"
@@ -126,7 +187,14 @@ func (s *syncer) Start() error {
 					s.recorder.Eventf(svc, apiv1.EventTypeWarning, "SyncNetworkEndpiontGroupFailed", "Failed to sync NEG %q %s: %v", s.negName, retryMesg, err)
 				}
 			} else {
+				s.degraded = false
 				s.resetRetryDelay()
+				if endpointCounts != nil && !s.IsStopped() {
+					syncStaleness.observeSync(s.negName, s.clock.Now())
+				}
+				if s.endpointSlices != nil {
+					retryCh = s.clock.After(endpointSliceResyncPeriod)
+				}
 			}
 
 			select {
@@ -135,7 +203,7 @@ func (s *syncer) Start() error {
 					s.stateLock.Lock()
 					s.shuttingDown = false
 					s.stateLock.Unlock()
-					glog.V(2).Infof("Stopping NEG syncer for %s/%s-%s", s.namespace, s.name, s.targetPort)
+					logging.V(2).Infof("Stopping NEG syncer for %s/%s-%s", s.namespace, s.name, s.targetPort)
 					return
 				}
 			case <-retryCh:
@@ -151,17 +219,19 @@ func (s *syncer) Stop() {
 	s.stateLock.Lock()
 	defer s.stateLock.Unlock()
 	if !s.stopped {
-		glog.V(2).Infof("Stopping NEG syncer for service port %s/%s-%s", s.namespace, s.name, s.targetPort)
+		logging.V(2).Infof("Stopping NEG syncer for service port %s/%s-%s", s.namespace, s.name, s.targetPort)
 		s.stopped = true
 		s.shuttingDown = true
 		close(s.syncCh)
+		syncStaleness.forget(s.negName)
+		s.reflector.Forget(s.negName)
 	}
 }
 
 // Sync informs syncer to run sync loop as soon as possible.
 func (s *syncer) Sync() bool {
 	if s.IsStopped() {
-		glog.Warningf("NEG syncer for %s/%s-%s is already stopped.", s.namespace, s.name, s.targetPort)
+		logging.Warningf("NEG syncer for %s/%s-%s is already stopped.", s.namespace, s.name, s.targetPort)
 		return false
 	}
 	select {
@@ -184,61 +254,112 @@ func (s *syncer) IsShuttingDown() bool {
 	return s.shuttingDown
 }
 
-func (s *syncer) sync() error {
+// NEGName returns the name of the NEGs synced by the syncer.
+func (s *syncer) NEGName() string {
+	return s.negName
+}
+
+// sync syncs the endpoints of the NEGs. Returns the number of endpoints of
+// the NEGs by zone, or nil if the NEGs were not synced.
+func (s *syncer) sync() (map[string]int, error) {
 	if s.IsStopped() || s.IsShuttingDown() {
-		glog.V(4).Infof("Skip syncing NEG %q for %s/%s-%s.", s.negName, s.namespace, s.name, s.targetPort)
-		return nil
-	}
-
-	glog.V(2).Infof("Sync NEG %q for %s/%s-%s", s.negName, s.namespace, s.name, s.targetPort)
-	ep, exists, err := s.endpointLister.Get(
-		&apiv1.Endpoints{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      s.name,
-				Namespace: s.namespace,
-			},
-		},
-	)
-	if err != nil {
-		return err
+		logging.V(4).Infof("Skip syncing NEG %q for %s/%s-%s.", s.negName, s.namespace, s.name, s.targetPort)
+		return nil, nil
 	}
 
-	if !exists {
-		glog.Warningf("Endpoint %s/%s does not exists. Skipping NEG sync", s.namespace, s.name)
-		return nil
+	logging.V(2).Infof("Sync NEG %q for %s/%s-%s", s.negName, s.namespace, s.name, s.targetPort)
+	hybridZone := ""
+	if svc := getService(s.serviceLister, s.namespace, s.name); svc != nil {
+		var err error
+		if hybridZone, err = getHybridZone(svc); err != nil {
+			return nil, err
+		}
 	}
 
-	err = s.ensureNetworkEndpointGroups()
+	ep, err := s.serviceEndpoints(hybridZone)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if ep == nil {
+		logging.Warningf("Endpoint %s/%s does not exists. Skipping NEG sync", s.namespace, s.name)
+		return nil, nil
 	}
 
-	targetMap, err := s.toZoneNetworkEndpointMap(ep.(*apiv1.Endpoints))
+	err = s.ensureNetworkEndpointGroups(hybridZone)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var targetMap map[string]sets.String
+	if s.degraded {
+		degradedModeSyncs.Inc()
+		targetMap = s.toDegradedZoneNetworkEndpointMap(ep, hybridZone)
+	} else if targetMap, err = s.toZoneNetworkEndpointMap(ep, hybridZone); err != nil {
+		return nil, err
 	}
 
-	currentMap, err := s.retrieveExistingZoneNetworkEndpointMap()
+	if s.maxEndpointsPerZone > 0 {
+		targetMap = subsetZoneNetworkEndpointMap(s.negName, targetMap, s.maxEndpointsPerZone)
+	}
+
+	currentMap, err := s.currentZoneNetworkEndpointMap(hybridZone)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	addEndpoints, removeEndpoints := calculateDifference(targetMap, currentMap)
+	if migrated := migratedEndpoints(addEndpoints, removeEndpoints); len(migrated) > 0 {
+		logging.V(2).Infof("Detaching the endpoints of NEG %q changing IP families before attaching them again", s.negName)
+		if err := s.syncNetworkEndpoints(nil, migrated); err != nil {
+			return nil, err
+		}
+	}
 	if len(addEndpoints) == 0 && len(removeEndpoints) == 0 {
-		glog.V(4).Infof("No endpoint change for %s/%s, skip syncing NEG. ", s.namespace, s.name)
-		return nil
+		logging.V(4).Infof("No endpoint change for %s/%s, skip syncing NEG. ", s.namespace, s.name)
+	} else if err := s.syncNetworkEndpoints(addEndpoints, removeEndpoints); err != nil {
+		return nil, err
+	}
+	if s.endpointCache == nil {
+		s.endpointCacheTime = s.clock.Now()
+	}
+	s.endpointCache = targetMap
+	if hybridZone == "" {
+		s.reflector.SyncNEG(s.negName, targetMap, s.endpointPods(ep))
 	}
 
-	return s.syncNetworkEndpoints(addEndpoints, removeEndpoints)
+	zones, err := s.listZones(hybridZone)
+	if err != nil {
+		return nil, err
+	}
+	endpointCounts := map[string]int{}
+	for _, zone := range zones {
+		endpointCounts[zone] = targetMap[zone].Len()
+	}
+	return endpointCounts, nil
+}
+
+// listZones returns the zones of the NEGs: the zone of the hybrid NEGs if
+// hybridZone is set, else the zones of the cluster.
+func (s *syncer) listZones(hybridZone string) ([]string, error) {
+	if hybridZone != "" {
+		return []string{hybridZone}, nil
+	}
+	return s.zoneGetter.ListZones()
 }
 
 // ensureNetworkEndpointGroups ensures negs are created in the related zones.
-func (s *syncer) ensureNetworkEndpointGroups() error {
+// Hybrid NEGs, in hybridZone if set, are only bound to the network of the
+// cluster, since their endpoints are outside of GCP.
+func (s *syncer) ensureNetworkEndpointGroups(hybridZone string) error {
 	var err error
-	zones, err := s.zoneGetter.ListZones()
+	zones, err := s.listZones(hybridZone)
 	if err != nil {
 		return err
 	}
+	endpointType, subnetwork := gce.NEGIPPortNetworkEndpointType, s.cloud.SubnetworkURL()
+	if hybridZone != "" {
+		endpointType, subnetwork = nonGCPNetworkEndpointType, ""
+	}
 
 	var errList []error
 	for _, zone := range zones {
@@ -246,20 +367,22 @@ func (s *syncer) ensureNetworkEndpointGroups() error {
 		neg, err := s.cloud.GetNetworkEndpointGroup(s.negName, zone)
 		if err != nil {
 			// Most likely to be caused by non-existed NEG
-			glog.V(4).Infof("Error while retriving %q in zone %q: %v", s.negName, zone, err)
+			logging.V(4).Infof("Error while retriving %q in zone %q: %v", s.negName, zone, err)
 		}
 
 		needToCreate := false
 		if neg == nil {
 			needToCreate = true
 		} else if retrieveName(neg.LoadBalancer.Network) != retrieveName(s.cloud.NetworkURL()) ||
-			retrieveName(neg.LoadBalancer.Subnetwork) != retrieveName(s.cloud.SubnetworkURL()) {
+			retrieveName(neg.LoadBalancer.Subnetwork) != retrieveName(subnetwork) ||
+			neg.NetworkEndpointType != endpointType {
 			// Only compare network and subnetwork names to avoid api endpoint differences that cause deleting NEG accidentally.
 			// TODO: change to compare network/subnetwork url instead of name when NEG API reach GA.
 			needToCreate = true
-			glog.V(2).Infof("NEG %q in %q does not match network and subnetwork of the cluster. Deleting NEG.", s.negName, zone)
+			logging.V(2).Infof("NEG %q in %q does not match network, subnetwork or endpoint type of the service. Deleting NEG.", s.negName, zone)
 			err = s.cloud.DeleteNetworkEndpointGroup(s.negName, zone)
 			if err != nil {
+				apiErrors.WithLabelValues(operationDelete).Inc()
 				errList = append(errList, err)
 			} else {
 				if svc := getService(s.serviceLister, s.namespace, s.name); svc != nil {
@@ -269,17 +392,21 @@ func (s *syncer) ensureNetworkEndpointGroups() error {
 		}
 
 		if needToCreate {
-			glog.V(2).Infof("Creating NEG %q for %s/%s in %q.", s.negName, s.namespace, s.name, zone)
+			// The cached endpoints of a (re)created NEG are stale.
+			s.endpointCache = nil
+			logging.V(2).Infof("Creating NEG %q for %s/%s in %q.", s.negName, s.namespace, s.name, zone)
 			err = s.cloud.CreateNetworkEndpointGroup(&compute.NetworkEndpointGroup{
 				Name:                s.negName,
+				Description:         s.negDescription,
 				Type:                gce.NEGLoadBalancerType,
-				NetworkEndpointType: gce.NEGIPPortNetworkEndpointType,
+				NetworkEndpointType: endpointType,
 				LoadBalancer: &compute.NetworkEndpointGroupLbNetworkEndpointGroup{
 					Network:    s.cloud.NetworkURL(),
-					Subnetwork: s.cloud.SubnetworkURL(),
+					Subnetwork: subnetwork,
 				},
 			}, zone)
 			if err != nil {
+				apiErrors.WithLabelValues(operationCreate).Inc()
 				errList = append(errList, err)
 			} else {
 				if svc := getService(s.serviceLister, s.namespace, s.name); svc != nil {
@@ -291,52 +418,192 @@ func (s *syncer) ensureNetworkEndpointGroups() error {
 	return utilerrors.NewAggregate(errList)
 }
 
-// toZoneNetworkEndpointMap translates addresses in endpoints object into zone and endpoints map
-func (s *syncer) toZoneNetworkEndpointMap(endpoints *apiv1.Endpoints) (map[string]sets.String, error) {
+// toZoneNetworkEndpointMap translates addresses in endpoints object into zone and endpoints map.
+// If hybridZone is set, all addresses are outside of GCP, in hybridZone without an instance.
+func (s *syncer) toZoneNetworkEndpointMap(endpoints *apiv1.Endpoints, hybridZone string) (map[string]sets.String, error) {
 	zoneNetworkEndpointMap := map[string]sets.String{}
-	targetPort, _ := strconv.Atoi(s.targetPort)
+	portNames := s.endpointPortNames()
 	for _, subset := range endpoints.Subsets {
-		matchPort := ""
-		// service spec allows target port to be a named port.
-		// support both explicit port and named port.
-		for _, port := range subset.Ports {
-			if targetPort != 0 {
-				// targetPort is int
-				if int(port.Port) == targetPort {
-					matchPort = s.targetPort
+		matchPort := s.matchPort(subset, portNames)
+		// subset does not contain target port
+		if len(matchPort) == 0 {
+			continue
+		}
+		for _, address := range s.endpointAddresses(subset) {
+			zone, instance := hybridZone, ""
+			if hybridZone == "" {
+				if address.NodeName == nil {
+					return nil, fmt.Errorf("endpoint %v of %s/%s has no node", address.IP, s.namespace, s.name)
 				}
-			} else {
-				// targetPort is string
-				if port.Name == s.targetPort {
-					matchPort = strconv.Itoa(int(port.Port))
+				var err error
+				instance = *address.NodeName
+				if zone, err = s.zoneGetter.GetZoneForNode(instance); err != nil {
+					return nil, err
 				}
 			}
-			if len(matchPort) > 0 {
-				break
+			if zoneNetworkEndpointMap[zone] == nil {
+				zoneNetworkEndpointMap[zone] = sets.String{}
 			}
+			zoneNetworkEndpointMap[zone].Insert(encodeEndpoint(address.IP, instance, matchPort))
 		}
+	}
+	return zoneNetworkEndpointMap, nil
+}
 
-		// subset does not contain target port
+// endpointAddresses returns the addresses of the given subset attached to the NEGs: the ready
+// addresses, and the not ready addresses of the Pods only waiting for their readiness gate.
+func (s *syncer) endpointAddresses(subset apiv1.EndpointSubset) []apiv1.EndpointAddress {
+	addresses := append([]apiv1.EndpointAddress{}, subset.Addresses...)
+	for _, address := range subset.NotReadyAddresses {
+		if s.reflector.waitsForGate(address) {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// endpointPods returns the Pods of the endpoints of the given endpoints object, by endpoint, keyed
+// by namespace/name.
+func (s *syncer) endpointPods(endpoints *apiv1.Endpoints) map[string]string {
+	pods := map[string]string{}
+	portNames := s.endpointPortNames()
+	for _, subset := range endpoints.Subsets {
+		matchPort := s.matchPort(subset, portNames)
 		if len(matchPort) == 0 {
 			continue
 		}
-		for _, address := range subset.Addresses {
-			zone, err := s.zoneGetter.GetZoneForNode(*address.NodeName)
-			if err != nil {
-				return nil, err
+		for _, address := range s.endpointAddresses(subset) {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" || address.NodeName == nil {
+				continue
+			}
+			pods[encodeEndpoint(address.IP, *address.NodeName, matchPort)] = serviceKeyFunc(address.TargetRef.Namespace, address.TargetRef.Name)
+		}
+	}
+	return pods
+}
+
+// endpointPortNames returns the names of the ports of the endpoints object which match a named
+// target port. The endpoints controller names the ports of the endpoints after the service ports,
+// so these are the names of the service ports targeting the port, e.g. of headless services whose
+// ports are named differently from the container ports. The target port name itself is used if
+// the service is unknown.
+func (s *syncer) endpointPortNames() sets.String {
+	svc := getService(s.serviceLister, s.namespace, s.name)
+	if svc == nil {
+		return sets.NewString(s.targetPort)
+	}
+	names := sets.NewString()
+	for _, port := range svc.Spec.Ports {
+		if port.TargetPort.Type == intstr.String && port.TargetPort.StrVal == s.targetPort {
+			names.Insert(port.Name)
+		}
+	}
+	return names
+}
+
+// matchPort returns the port of the given subset matching the target port, or "" if none does.
+// Named target ports match the ports of the subset named after portNames.
+func (s *syncer) matchPort(subset apiv1.EndpointSubset, portNames sets.String) string {
+	targetPort, _ := strconv.Atoi(s.targetPort)
+	// service spec allows target port to be a named port.
+	// support both explicit port and named port.
+	for _, port := range subset.Ports {
+		if targetPort != 0 {
+			// targetPort is int
+			if int(port.Port) == targetPort {
+				return s.targetPort
+			}
+		} else {
+			// targetPort is string
+			if portNames.Has(port.Name) {
+				return strconv.Itoa(int(port.Port))
+			}
+		}
+	}
+	return ""
+}
+
+// toDegradedZoneNetworkEndpointMap translates the addresses of the endpoints object into the
+// zone and endpoints map like toZoneNetworkEndpointMap, but takes the IP and node of each endpoint
+// from its Pod in the informer cache. Endpoints which can't be resolved are skipped, counted by
+// reason in the metrics and reported in an event, instead of failing the sync.
+func (s *syncer) toDegradedZoneNetworkEndpointMap(endpoints *apiv1.Endpoints, hybridZone string) map[string]sets.String {
+	zoneNetworkEndpointMap := map[string]sets.String{}
+	skipped := map[string]int{}
+	portNames := s.endpointPortNames()
+	for _, subset := range endpoints.Subsets {
+		matchPort := s.matchPort(subset, portNames)
+		if len(matchPort) == 0 {
+			continue
+		}
+		for _, address := range s.endpointAddresses(subset) {
+			ip, instance, zone, reason := s.resolveEndpoint(address, hybridZone)
+			if reason != "" {
+				logging.V(2).Infof("Skipping endpoint %v of %s/%s-%s in degraded mode: %s", address.IP, s.namespace, s.name, s.targetPort, reason)
+				skipped[reason]++
+				skippedEndpoints.WithLabelValues(reason).Inc()
+				continue
 			}
 			if zoneNetworkEndpointMap[zone] == nil {
 				zoneNetworkEndpointMap[zone] = sets.String{}
 			}
-			zoneNetworkEndpointMap[zone].Insert(encodeEndpoint(address.IP, *address.NodeName, matchPort))
+			zoneNetworkEndpointMap[zone].Insert(encodeEndpoint(ip, instance, matchPort))
 		}
 	}
-	return zoneNetworkEndpointMap, nil
+	if len(skipped) > 0 {
+		if svc := getService(s.serviceLister, s.namespace, s.name); svc != nil {
+			s.recorder.Eventf(svc, apiv1.EventTypeWarning, degradedModeEventReason, "Skipped invalid endpoints of NEG %q by reason: %v", s.negName, skipped)
+		}
+	}
+	return zoneNetworkEndpointMap
+}
+
+// resolveEndpoint returns the IP, instance and zone of the given address, or the reason the
+// address is invalid. Hybrid endpoints are outside of GCP, they have no Pod and no instance. The
+// IPv6 address of the address is kept as long as its IPv4 address is still the IP of the Pod.
+func (s *syncer) resolveEndpoint(address apiv1.EndpointAddress, hybridZone string) (string, string, string, string) {
+	if hybridZone != "" {
+		if net.ParseIP(address.IP) == nil {
+			return "", "", "", skipReasonInvalidIP
+		}
+		return address.IP, "", hybridZone, ""
+	}
+	if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+		return "", "", "", skipReasonNoPod
+	}
+	obj, exists, err := s.podLister.GetByKey(address.TargetRef.Namespace + "/" + address.TargetRef.Name)
+	if err != nil || !exists {
+		return "", "", "", skipReasonPodNotFound
+	}
+	pod := obj.(*apiv1.Pod)
+	if pod.Status.PodIP == "" || pod.Spec.NodeName == "" {
+		return "", "", "", skipReasonPodNotReady
+	}
+	zone, err := s.zoneGetter.GetZoneForNode(pod.Spec.NodeName)
+	if err != nil {
+		return "", "", "", skipReasonUnknownZone
+	}
+	ip := pod.Status.PodIP
+	if ipv4, ipv6 := splitIPs(address.IP); ipv4 == ip {
+		ip = joinIPs(ip, ipv6)
+	}
+	return ip, pod.Spec.NodeName, zone, ""
+}
+
+// currentZoneNetworkEndpointMap returns the zone and endpoints map of the NEGs: the endpoints
+// cached after the last successful syncs, or else the endpoints listed from GCE. The cache is
+// refreshed from GCE every endpointCacheTTL, to catch changes made outside of the controller.
+func (s *syncer) currentZoneNetworkEndpointMap(hybridZone string) (map[string]sets.String, error) {
+	if s.endpointCache != nil && s.clock.Since(s.endpointCacheTime) < endpointCacheTTL {
+		return s.endpointCache, nil
+	}
+	s.endpointCache = nil
+	return s.retrieveExistingZoneNetworkEndpointMap(hybridZone)
 }
 
 // retrieveExistingZoneNetworkEndpointMap lists existing network endpoints in the neg and return the zone and endpoints map
-func (s *syncer) retrieveExistingZoneNetworkEndpointMap() (map[string]sets.String, error) {
-	zones, err := s.zoneGetter.ListZones()
+func (s *syncer) retrieveExistingZoneNetworkEndpointMap(hybridZone string) (map[string]sets.String, error) {
+	zones, err := s.listZones(hybridZone)
 	if err != nil {
 		return nil, err
 	}
@@ -344,17 +611,35 @@ func (s *syncer) retrieveExistingZoneNetworkEndpointMap() (map[string]sets.Strin
 	zoneNetworkEndpointMap := map[string]sets.String{}
 	for _, zone := range zones {
 		zoneNetworkEndpointMap[zone] = sets.String{}
-		networkEndpointsWithHealthStatus, err := s.cloud.ListNetworkEndpoints(s.negName, zone, false)
+		networkEndpoints, err := s.listNetworkEndpoints(zone)
 		if err != nil {
+			apiErrors.WithLabelValues(operationList).Inc()
 			return nil, err
 		}
-		for _, ne := range networkEndpointsWithHealthStatus {
-			zoneNetworkEndpointMap[zone].Insert(encodeEndpoint(ne.NetworkEndpoint.IpAddress, ne.NetworkEndpoint.Instance, strconv.FormatInt(ne.NetworkEndpoint.Port, 10)))
+		for _, ne := range networkEndpoints {
+			zoneNetworkEndpointMap[zone].Insert(encodeEndpoint(joinIPs(ne.IpAddress, ne.Ipv6Address), ne.Instance, strconv.FormatInt(ne.Port, 10)))
 		}
 	}
 	return zoneNetworkEndpointMap, nil
 }
 
+// listNetworkEndpoints lists the network endpoints of the NEG in the given zone, with their IPv6
+// addresses if these are attached.
+func (s *syncer) listNetworkEndpoints(zone string) ([]*NetworkEndpoint, error) {
+	if dualStack := s.endpointSlices.dualStackEndpoints(); dualStack != nil {
+		return dualStack.ListDualStackNetworkEndpoints(s.negName, zone)
+	}
+	networkEndpointsWithHealthStatus, err := s.cloud.ListNetworkEndpoints(s.negName, zone, false)
+	if err != nil {
+		return nil, err
+	}
+	networkEndpoints := make([]*NetworkEndpoint, len(networkEndpointsWithHealthStatus))
+	for i, ne := range networkEndpointsWithHealthStatus {
+		networkEndpoints[i] = &NetworkEndpoint{Instance: ne.NetworkEndpoint.Instance, IpAddress: ne.NetworkEndpoint.IpAddress, Port: ne.NetworkEndpoint.Port}
+	}
+	return networkEndpoints, nil
+}
+
 type ErrorList struct {
 	errList []error
 	lock    sync.Mutex
@@ -372,10 +657,24 @@ func (e *ErrorList) List() []error {
 	return e.errList
 }
 
-// syncNetworkEndpoints adds and removes endpoints for negs
+// syncNetworkEndpoints adds and removes endpoints for negs. Endpoints are sent in batches of up to
+// MAX_NETWORK_ENDPOINTS_PER_BATCH per call, and at most maxConcurrentOperations calls run at the same
+// time. The errors of all failed batches are aggregated.
 func (s *syncer) syncNetworkEndpoints(addEndpoints, removeEndpoints map[string]sets.String) error {
-	var wg sync.WaitGroup
 	errList := &ErrorList{}
+	sem := make(chan struct{}, maxConcurrentOperations)
+	observeEndpointChanges(operationAttach, addEndpoints)
+	observeEndpointChanges(operationDetach, removeEndpoints)
+
+	// The operations of each zone are tracked separately to observe the latency of each zone.
+	start := s.clock.Now()
+	zoneWaitGroups := map[string]*sync.WaitGroup{}
+	zoneWaitGroup := func(zone string) *sync.WaitGroup {
+		if zoneWaitGroups[zone] == nil {
+			zoneWaitGroups[zone] = &sync.WaitGroup{}
+		}
+		return zoneWaitGroups[zone]
+	}
 
 	// Detach Endpoints
 	for zone, endpointSet := range removeEndpoints {
@@ -385,9 +684,11 @@ func (s *syncer) syncNetworkEndpoints(addEndpoints, removeEndpoints map[string]s
 			}
 			networkEndpoints, err := s.toNetworkEndpointBatch(endpointSet)
 			if err != nil {
-				return err
+				// Wait for the batches already sent.
+				errList.Add(err)
+				break
 			}
-			s.detachNetworkEndpoints(&wg, zone, networkEndpoints, errList)
+			s.detachNetworkEndpoints(zoneWaitGroup(zone), sem, zone, networkEndpoints, errList)
 		}
 	}
 
@@ -399,17 +700,39 @@ func (s *syncer) syncNetworkEndpoints(addEndpoints, removeEndpoints map[string]s
 			}
 			networkEndpoints, err := s.toNetworkEndpointBatch(endpointSet)
 			if err != nil {
-				return err
+				errList.Add(err)
+				break
 			}
-			s.attachNetworkEndpoints(&wg, zone, networkEndpoints, errList)
+			s.attachNetworkEndpoints(zoneWaitGroup(zone), sem, zone, networkEndpoints, errList)
 		}
 	}
+
+	var wg sync.WaitGroup
+	for zone, zoneWg := range zoneWaitGroups {
+		wg.Add(1)
+		go func(zone string, zoneWg *sync.WaitGroup) {
+			defer wg.Done()
+			zoneWg.Wait()
+			zoneSyncLatency.WithLabelValues(zone).Observe(s.clock.Since(start).Seconds())
+		}(zone, zoneWg)
+	}
 	wg.Wait()
 	return utilerrors.NewAggregate(errList.List())
 }
 
+// observeEndpointChanges observes the number of endpoints of the given operation in a sync, if any.
+func observeEndpointChanges(operation string, zoneEndpoints map[string]sets.String) {
+	count := 0
+	for _, endpoints := range zoneEndpoints {
+		count += endpoints.Len()
+	}
+	if count > 0 {
+		endpointChanges.WithLabelValues(operation).Observe(float64(count))
+	}
+}
+
 // translate a endpoints set to a batch of network endpoints object
-func (s *syncer) toNetworkEndpointBatch(endpoints sets.String) ([]*compute.NetworkEndpoint, error) {
+func (s *syncer) toNetworkEndpointBatch(endpoints sets.String) ([]*NetworkEndpoint, error) {
 	var ok bool
 	list := make([]string, int(math.Min(float64(endpoints.Len()), float64(MAX_NETWORK_ENDPOINTS_PER_BATCH))))
 	for i := range list {
@@ -418,39 +741,57 @@ func (s *syncer) toNetworkEndpointBatch(endpoints sets.String) ([]*compute.Netwo
 			break
 		}
 	}
-	networkEndpointList := make([]*compute.NetworkEndpoint, len(list))
+	networkEndpointList := make([]*NetworkEndpoint, len(list))
 	for i, enc := range list {
 		ip, instance, port := decodeEndpoint(enc)
 		portNum, err := strconv.Atoi(port)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to decode endpoint %q: %v", enc, err)
 		}
-		networkEndpointList[i] = &compute.NetworkEndpoint{
-			Instance:  instance,
-			IpAddress: ip,
-			Port:      int64(portNum),
+		ipv4, ipv6 := splitIPs(ip)
+		networkEndpointList[i] = &NetworkEndpoint{
+			Instance:    instance,
+			IpAddress:   ipv4,
+			Ipv6Address: ipv6,
+			Port:        int64(portNum),
 		}
 	}
 	return networkEndpointList, nil
 }
 
-func (s *syncer) attachNetworkEndpoints(wg *sync.WaitGroup, zone string, networkEndpoints []*compute.NetworkEndpoint, errList *ErrorList) {
+func (s *syncer) attachNetworkEndpoints(wg *sync.WaitGroup, sem chan struct{}, zone string, networkEndpoints []*NetworkEndpoint, errList *ErrorList) {
 	wg.Add(1)
-	glog.V(2).Infof("Attaching %d endpoints for %s/%s-%s into NEG %s in %s.", len(networkEndpoints), s.namespace, s.name, s.targetPort, s.negName, zone)
-	go s.operationInternal(wg, zone, networkEndpoints, errList, s.cloud.AttachNetworkEndpoints, "Attach")
+	logging.V(2).Infof("Attaching %d endpoints for %s/%s-%s into NEG %s in %s.", len(networkEndpoints), s.namespace, s.name, s.targetPort, s.negName, zone)
+	syncFunc := func(name, zone string, endpoints []*NetworkEndpoint) error {
+		return s.cloud.AttachNetworkEndpoints(name, zone, toAlphaEndpoints(endpoints))
+	}
+	if dualStack := s.endpointSlices.dualStackEndpoints(); dualStack != nil {
+		syncFunc = dualStack.AttachDualStackNetworkEndpoints
+	}
+	go s.operationInternal(wg, sem, zone, networkEndpoints, errList, syncFunc, "Attach")
 }
 
-func (s *syncer) detachNetworkEndpoints(wg *sync.WaitGroup, zone string, networkEndpoints []*compute.NetworkEndpoint, errList *ErrorList) {
+func (s *syncer) detachNetworkEndpoints(wg *sync.WaitGroup, sem chan struct{}, zone string, networkEndpoints []*NetworkEndpoint, errList *ErrorList) {
 	wg.Add(1)
-	glog.V(2).Infof("Detaching %d endpoints for %s/%s-%s into NEG %s in %s.", len(networkEndpoints), s.namespace, s.name, s.targetPort, s.negName, zone)
-	go s.operationInternal(wg, zone, networkEndpoints, errList, s.cloud.DetachNetworkEndpoints, "Detach")
+	logging.V(2).Infof("Detaching %d endpoints for %s/%s-%s into NEG %s in %s.", len(networkEndpoints), s.namespace, s.name, s.targetPort, s.negName, zone)
+	syncFunc := func(name, zone string, endpoints []*NetworkEndpoint) error {
+		return s.cloud.DetachNetworkEndpoints(name, zone, toAlphaEndpoints(endpoints))
+	}
+	if dualStack := s.endpointSlices.dualStackEndpoints(); dualStack != nil {
+		syncFunc = dualStack.DetachDualStackNetworkEndpoints
+	}
+	go s.operationInternal(wg, sem, zone, networkEndpoints, errList, syncFunc, "Detach")
 }
 
-func (s *syncer) operationInternal(wg *sync.WaitGroup, zone string, networkEndpoints []*compute.NetworkEndpoint, errList *ErrorList, syncFunc func(name, zone string, endpoints []*compute.NetworkEndpoint) error, operationName string) {
+// operationInternal runs syncFunc on a batch of network endpoints once a slot of sem is free.
+func (s *syncer) operationInternal(wg *sync.WaitGroup, sem chan struct{}, zone string, networkEndpoints []*NetworkEndpoint, errList *ErrorList, syncFunc func(name, zone string, endpoints []*NetworkEndpoint) error, operationName string) {
 	defer wg.Done()
+	sem <- struct{}{}
 	err := syncFunc(s.negName, zone, networkEndpoints)
+	<-sem
 	if err != nil {
-		errList.Add(err)
+		apiErrors.WithLabelValues(strings.ToLower(operationName)).Inc()
+		errList.Add(fmt.Errorf("%s of %d network endpoints in NEG %q in %q failed: %v", operationName, len(networkEndpoints), s.negName, zone, err))
 	}
 	if svc := getService(s.serviceLister, s.namespace, s.name); svc != nil {
 		if err == nil {
@@ -513,6 +854,45 @@ func retrieveName(url string) string {
 	return strs[len(strs)-1]
 }
 
+// negOwner is the description of the NEGs created by the controller.
+type negOwner struct {
+	ClusterUID  string `json:"cluster-uid"`
+	Namespace   string `json:"namespace"`
+	ServiceName string `json:"service-name"`
+	Port        string `json:"port"`
+}
+
+// negDescription returns the description of the NEGs of the given service
+// port in the given cluster.
+func negDescription(clusterUID, namespace, name, port string) string {
+	desc, _ := json.Marshal(negOwner{ClusterUID: clusterUID, Namespace: namespace, ServiceName: name, Port: port})
+	return string(desc)
+}
+
+// isClusterNEG returns true if the given NEG description was set by the
+// controller of the given cluster.
+func isClusterNEG(description, clusterUID string) bool {
+	owner := negOwner{}
+	if err := json.Unmarshal([]byte(description), &owner); err != nil {
+		return false
+	}
+	return owner.ClusterUID != "" && owner.ClusterUID == clusterUID
+}
+
+// getHybridZone returns the zone of the hybrid NEGs of the given service, or
+// "" if its endpoints are in the cluster. The endpoints of services with a
+// selector are Pods, so hybrid NEGs require a service without selector.
+func getHybridZone(service *apiv1.Service) (string, error) {
+	hybridNEG, err := annotations.SvcAnnotations(service.GetAnnotations()).HybridNEG()
+	if err != nil || hybridNEG == nil {
+		return "", err
+	}
+	if len(service.Spec.Selector) > 0 {
+		return "", fmt.Errorf("%v annotation requires a service without selector", annotations.HybridNEGKey)
+	}
+	return hybridNEG.Zone, nil
+}
+
 // getService retrieves service object from serviceLister based on the input namespace and name
 func getService(serviceLister cache.Indexer, namespace, name string) *apiv1.Service {
 	service, exists, err := serviceLister.GetByKey(serviceKeyFunc(namespace, name))
@@ -520,7 +900,7 @@ func getService(serviceLister cache.Indexer, namespace, name string) *apiv1.Serv
 		return service.(*apiv1.Service)
 	}
 	if err != nil {
-		glog.Errorf("Failed to retrieve service %s/%s from store: %v", namespace, name, err)
+		logging.Errorf("Failed to retrieve service %s/%s from store: %v", namespace, name, err)
 	}
 	return nil
 }
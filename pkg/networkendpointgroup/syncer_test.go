@@ -1,16 +1,24 @@
 package networkendpointgroup
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v0.alpha"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/ingress-gce/pkg/annotations"
 	"k8s.io/ingress-gce/pkg/context"
-	"reflect"
-	"testing"
-	"time"
 )
 
 const (
@@ -31,11 +39,17 @@ func NewTestSyncer() *syncer {
 
 	return newSyncer(svcPort,
 		NegName,
+		negDescription(CluseterID, ServiceNamespace, ServiceName, "80"),
 		record.NewFakeRecorder(100),
+		&fakeStatusRecorder{},
 		NewFakeNetworkEndpointGroupCloud("test-subnetwork", "test-newtork"),
 		NewFakeZoneGetter(),
 		context.ServiceInformer.GetIndexer(),
-		context.EndpointInformer.GetIndexer())
+		context.EndpointInformer.GetIndexer(),
+		context.PodInformer.GetIndexer(),
+		0,
+		nil,
+		nil)
 }
 
 func TestStartAndStopSyncer(t *testing.T) {
@@ -86,7 +100,7 @@ func TestStartAndStopSyncer(t *testing.T) {
 
 func TestEnsureNetworkEndpointGroups(t *testing.T) {
 	syncer := NewTestSyncer()
-	if err := syncer.ensureNetworkEndpointGroups(); err != nil {
+	if err := syncer.ensureNetworkEndpointGroups(""); err != nil {
 		t.Errorf("Failed to ensure NEGs: %v", err)
 	}
 
@@ -133,7 +147,7 @@ func TestToZoneNetworkEndpointMap(t *testing.T) {
 
 	for _, tc := range testCases {
 		syncer.targetPort = tc.targetPort
-		res, _ := syncer.toZoneNetworkEndpointMap(getDefaultEndpoint())
+		res, _ := syncer.toZoneNetworkEndpointMap(getDefaultEndpoint(), "")
 
 		if !reflect.DeepEqual(res, tc.expect) {
 			t.Errorf("Expect %v, but got %v.", tc.expect, res)
@@ -141,6 +155,184 @@ func TestToZoneNetworkEndpointMap(t *testing.T) {
 	}
 }
 
+func TestToZoneNetworkEndpointMapHeadlessService(t *testing.T) {
+	syncer := NewTestSyncer()
+	// The endpoints controller names the endpoint ports after the service ports, not the container ports.
+	syncer.serviceLister.Add(&apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ServiceNamespace, Name: ServiceName},
+		Spec: apiv1.ServiceSpec{
+			ClusterIP: apiv1.ClusterIPNone,
+			Ports: []apiv1.ServicePort{
+				{Name: "web", Port: 80, TargetPort: intstr.FromString("http")},
+				{Name: NamedPort, Port: 81, TargetPort: intstr.FromString("metrics")},
+			},
+		},
+	})
+	syncer.targetPort = "http"
+	endpoints := getDefaultEndpoint()
+	for i := range endpoints.Subsets {
+		for j := range endpoints.Subsets[i].Ports {
+			if endpoints.Subsets[i].Ports[j].Name == NamedPort {
+				endpoints.Subsets[i].Ports[j].Name = "web"
+			}
+		}
+	}
+
+	expect := map[string]sets.String{
+		TestZone1: sets.NewString("10.100.2.2||instance2||81"),
+		TestZone2: sets.NewString("10.100.4.1||instance4||81", "10.100.3.2||instance3||8081", "10.100.4.2||instance4||8081"),
+	}
+	res, err := syncer.toZoneNetworkEndpointMap(endpoints, "")
+	if err != nil {
+		t.Fatalf("Failed to compute network endpoints: %v", err)
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Errorf("Expect %v, but got %v.", expect, res)
+	}
+}
+
+func TestHybridNetworkEndpointGroups(t *testing.T) {
+	syncer := NewTestSyncer()
+	// The hybrid zone is not a zone of the cluster, e.g. the zone closest to the on-premises network.
+	hybridZone := "zone3"
+	if err := syncer.ensureNetworkEndpointGroups(hybridZone); err != nil {
+		t.Fatalf("Failed to ensure NEGs: %v", err)
+	}
+	ret, _ := syncer.cloud.AggregatedListNetworkEndpointGroup()
+	if len(ret) != 1 || len(ret[hybridZone]) != 1 {
+		t.Fatalf("Expect a single NEG in zone %q, but got %v", hybridZone, ret)
+	}
+	if neg := ret[hybridZone][0]; neg.NetworkEndpointType != nonGCPNetworkEndpointType || neg.LoadBalancer.Subnetwork != "" {
+		t.Errorf("Unexpected hybrid NEG %+v", neg)
+	}
+
+	res, err := syncer.toZoneNetworkEndpointMap(getDefaultEndpoint(), hybridZone)
+	if err != nil {
+		t.Fatalf("Failed to compute network endpoints: %v", err)
+	}
+	expect := map[string]sets.String{
+		hybridZone: sets.NewString("10.100.1.1||||80", "10.100.1.2||||80", "10.100.2.1||||80", "10.100.3.1||||80"),
+	}
+	if !reflect.DeepEqual(res, expect) {
+		t.Errorf("Expect %v, but got %v.", expect, res)
+	}
+}
+
+func TestDegradedZoneNetworkEndpointMap(t *testing.T) {
+	syncer := NewTestSyncer()
+	instance1, unknownInstance := TestInstance1, "unknown-instance"
+	newPod := func(name, ip, node string) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ServiceNamespace, Name: name},
+			Spec:       apiv1.PodSpec{NodeName: node},
+			Status:     apiv1.PodStatus{PodIP: ip},
+		}
+	}
+	for _, pod := range []*apiv1.Pod{
+		newPod("pod1", "10.100.1.1", TestInstance1),
+		newPod("pod2", "10.100.3.1", TestInstance3),
+		newPod("unscheduled", "", ""),
+		newPod("unknown-zone", "10.100.9.1", unknownInstance),
+	} {
+		syncer.podLister.Add(pod)
+	}
+	podRef := func(name string) *apiv1.ObjectReference {
+		return &apiv1.ObjectReference{Kind: "Pod", Namespace: ServiceNamespace, Name: name}
+	}
+	endpoints := &apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ServiceNamespace, Name: ServiceName},
+		Subsets: []apiv1.EndpointSubset{
+			{
+				Addresses: []apiv1.EndpointAddress{
+					// The node of the endpoint is taken from the Pod.
+					{IP: "10.100.1.1", TargetRef: podRef("pod1")},
+					{IP: "10.100.3.1", NodeName: &instance1, TargetRef: podRef("pod2")},
+					{IP: "10.100.5.1", NodeName: &instance1},
+					{IP: "10.100.6.1", TargetRef: podRef("deleted")},
+					{IP: "10.100.7.1", TargetRef: podRef("unscheduled")},
+					{IP: "10.100.9.1", NodeName: &unknownInstance, TargetRef: podRef("unknown-zone")},
+				},
+				Ports: []apiv1.EndpointPort{{Port: 80, Protocol: apiv1.ProtocolTCP}},
+			},
+		},
+	}
+
+	if _, err := syncer.toZoneNetworkEndpointMap(endpoints, ""); err == nil {
+		t.Errorf("Expect an error computing invalid endpoints outside of degraded mode")
+	}
+	expect := map[string]sets.String{
+		TestZone1: sets.NewString("10.100.1.1||instance1||80"),
+		TestZone2: sets.NewString("10.100.3.1||instance3||80"),
+	}
+	if res := syncer.toDegradedZoneNetworkEndpointMap(endpoints, ""); !reflect.DeepEqual(res, expect) {
+		t.Errorf("Expect %v, but got %v.", expect, res)
+	}
+}
+
+func TestEndpointCache(t *testing.T) {
+	syncer := NewTestSyncer()
+	fakeClock := clock.NewFakeClock(time.Now())
+	syncer.clock = fakeClock
+	if err := syncer.ensureNetworkEndpointGroups(""); err != nil {
+		t.Fatalf("Failed to ensure NEGs: %v", err)
+	}
+	cached := map[string]sets.String{TestZone1: sets.NewString("10.100.1.1||instance1||80")}
+	syncer.endpointCache = cached
+	syncer.endpointCacheTime = fakeClock.Now()
+
+	if current, _ := syncer.currentZoneNetworkEndpointMap(""); !reflect.DeepEqual(current, cached) {
+		t.Errorf("Expect the cached endpoints %v, but got %v", cached, current)
+	}
+	fakeClock.Step(endpointCacheTTL)
+	expect := map[string]sets.String{TestZone1: sets.NewString(), TestZone2: sets.NewString()}
+	if current, _ := syncer.currentZoneNetworkEndpointMap(""); !reflect.DeepEqual(current, expect) {
+		t.Errorf("Expect the endpoints listed from GCE %v, but got %v", expect, current)
+	}
+	if syncer.endpointCache != nil {
+		t.Errorf("Expect the expired cache to be dropped")
+	}
+}
+
+func TestGetHybridZone(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		selector    map[string]string
+		expectZone  string
+		expectErr   bool
+	}{
+		{
+			desc: "no annotation",
+		},
+		{
+			desc:        "hybrid service",
+			annotations: map[string]string{annotations.HybridNEGKey: `{"zone": "zone3"}`},
+			expectZone:  "zone3",
+		},
+		{
+			desc:        "hybrid service with selector",
+			annotations: map[string]string{annotations.HybridNEGKey: `{"zone": "zone3"}`},
+			selector:    map[string]string{"app": "foo"},
+			expectErr:   true,
+		},
+		{
+			desc:        "no zone",
+			annotations: map[string]string{annotations.HybridNEGKey: `{}`},
+			expectErr:   true,
+		},
+	}
+	for _, tc := range testCases {
+		svc := &apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+			Spec:       apiv1.ServiceSpec{Selector: tc.selector},
+		}
+		zone, err := getHybridZone(svc)
+		if (err != nil) != tc.expectErr || zone != tc.expectZone {
+			t.Errorf("%s: getHybridZone() = %q, %v; want %q, error %v", tc.desc, zone, err, tc.expectZone, tc.expectErr)
+		}
+	}
+}
+
 func TestEncodeDecodeEndpoint(t *testing.T) {
 	ip := "10.0.0.10"
 	instance := "somehost"
@@ -277,7 +469,7 @@ func TestCalculateDifference(t *testing.T) {
 
 func TestSyncNetworkEndpoints(t *testing.T) {
 	syncer := NewTestSyncer()
-	if err := syncer.ensureNetworkEndpointGroups(); err != nil {
+	if err := syncer.ensureNetworkEndpointGroups(""); err != nil {
 		t.Fatalf("Failed to ensure NEG: %v", err)
 	}
 
@@ -330,6 +522,70 @@ func TestSyncNetworkEndpoints(t *testing.T) {
 	}
 }
 
+// batchCheckingCloud records the largest batch and the largest number of concurrent attach calls,
+// and fails the attach calls in failZone.
+type batchCheckingCloud struct {
+	networkEndpointGroupCloud
+	failZone string
+
+	mu            sync.Mutex
+	current       int
+	maxConcurrent int
+	maxBatch      int
+}
+
+func (c *batchCheckingCloud) AttachNetworkEndpoints(name, zone string, endpoints []*compute.NetworkEndpoint) error {
+	c.mu.Lock()
+	c.current++
+	if c.current > c.maxConcurrent {
+		c.maxConcurrent = c.current
+	}
+	if len(endpoints) > c.maxBatch {
+		c.maxBatch = len(endpoints)
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.current--
+		c.mu.Unlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if zone == c.failZone {
+		return fmt.Errorf("injected error")
+	}
+	return c.networkEndpointGroupCloud.AttachNetworkEndpoints(name, zone, endpoints)
+}
+
+func TestSyncNetworkEndpointsBatches(t *testing.T) {
+	syncer := NewTestSyncer()
+	cloud := &batchCheckingCloud{networkEndpointGroupCloud: syncer.cloud, failZone: TestZone2}
+	syncer.cloud = cloud
+	if err := syncer.ensureNetworkEndpointGroups(""); err != nil {
+		t.Fatalf("Failed to ensure NEG: %v", err)
+	}
+
+	addSet := map[string]sets.String{TestZone1: sets.NewString(), TestZone2: sets.NewString()}
+	for i := 0; i < 20*MAX_NETWORK_ENDPOINTS_PER_BATCH; i++ {
+		addSet[TestZone1].Insert(encodeEndpoint(fmt.Sprintf("10.%d.%d.%d", i/65536, i/256%256, i%256), TestInstance1, "80"))
+	}
+	addSet[TestZone2].Insert("10.200.0.1||instance3||80", "10.200.0.2||instance3||80")
+	expectCount := addSet[TestZone1].Len()
+
+	err := syncer.syncNetworkEndpoints(addSet, map[string]sets.String{})
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("in NEG %q in %q failed: injected error", NegName, TestZone2)) {
+		t.Errorf("Expect the error of the batch in %q, but got %v", TestZone2, err)
+	}
+	if cloud.maxBatch > MAX_NETWORK_ENDPOINTS_PER_BATCH {
+		t.Errorf("Expect batches of at most %v endpoints, but got %v", MAX_NETWORK_ENDPOINTS_PER_BATCH, cloud.maxBatch)
+	}
+	if cloud.maxConcurrent > maxConcurrentOperations {
+		t.Errorf("Expect at most %v concurrent calls, but got %v", maxConcurrentOperations, cloud.maxConcurrent)
+	}
+	if endpoints, _ := cloud.ListNetworkEndpoints(NegName, TestZone1, false); len(endpoints) != expectCount {
+		t.Errorf("Expect %v endpoints in %q, but got %v", expectCount, TestZone1, len(endpoints))
+	}
+}
+
 func examineNetworkEndpoints(expectSet map[string]sets.String, syncer *syncer, t *testing.T) {
 	for zone, endpoints := range expectSet {
 		expectEndpoints, err := syncer.toNetworkEndpointBatch(endpoints)
@@ -343,7 +599,7 @@ func examineNetworkEndpoints(expectSet map[string]sets.String, syncer *syncer, t
 			for _, expectEp := range expectEndpoints {
 				found := false
 				for _, cloudEp := range cloudEndpoints {
-					if reflect.DeepEqual(*expectEp, *cloudEp.NetworkEndpoint) {
+					if reflect.DeepEqual(*expectEp.toAlpha(), *cloudEp.NetworkEndpoint) {
 						found = true
 						break
 					}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// waitDuration observes how long GCE requests wait for the rate limiter
+	// of their API group.
+	waitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "gce",
+			Name:      "ratelimit_wait_seconds",
+			Help:      "Time GCE API requests waited for the rate limiter of their API group.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+		[]string{"group"},
+	)
+	// apiRequests counts the GCE API requests, by API group, HTTP method and
+	// status code, "error" if no response was received.
+	apiRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "gce",
+			Name:      "api_requests_total",
+			Help:      "Number of GCE API requests, by API group, method and status code.",
+		},
+		[]string{"group", "method", "code"},
+	)
+	// apiRequestDuration observes the latency of the GCE API requests.
+	apiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "gce",
+			Name:      "api_request_duration_seconds",
+			Help:      "Latency of GCE API requests, by API group and method.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		},
+		[]string{"group", "method"},
+	)
+
+	registerMetrics sync.Once
+)
+
+// RegisterMetrics registers the metrics of the GCE API requests, served with
+// the other metrics of the controller.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(waitDuration)
+		prometheus.MustRegister(apiRequests)
+		prometheus.MustRegister(apiRequestDuration)
+	})
+}
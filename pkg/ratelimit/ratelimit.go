@@ -0,0 +1,288 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jujuratelimit "github.com/juju/ratelimit"
+
+	"k8s.io/client-go/util/flowcontrol"
+
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/tracing"
+)
+
+// Transport is an http.RoundTripper which waits for the rate limiter of the
+// API group of each GCE request, and records the metrics of the request.
+// Requests of groups without rate limiter are not limited. Requests which
+// are not GCE API calls, eg: for tokens, are sent as is.
+type Transport struct {
+	base http.RoundTripper
+	// lock protects limiters, replaced by SetLimiters.
+	lock     sync.RWMutex
+	limiters map[string]flowcontrol.RateLimiter
+}
+
+// NewTransport returns a Transport sending the requests through the given
+// base, with the given rate limiters by API group.
+func NewTransport(base http.RoundTripper, limiters map[string]flowcontrol.RateLimiter) *Transport {
+	return &Transport{base: base, limiters: limiters}
+}
+
+// RoundTrip waits for the rate limiter of the API group of the request, if
+// any, then sends it. Returns the error of the context of the request if it
+// is done while waiting.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	group := APIGroup(req.URL)
+	if group == "" {
+		return t.base.RoundTrip(req)
+	}
+	// The calls are traced as part of the trace of the request context, or
+	// else of the calling goroutine, eg: of the sync of an Ingress.
+	span := tracing.StartChildSpan(req.Context(), fmt.Sprintf("%v %v", req.Method, group), tracing.KindClient)
+	span.SetAttribute("gce.api_group", group)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Path)
+	t.lock.RLock()
+	limiter, ok := t.limiters[group]
+	t.lock.RUnlock()
+	if ok {
+		start := time.Now()
+		err := wait(req.Context(), limiter)
+		waitDuration.WithLabelValues(group).Observe(time.Since(start).Seconds())
+		span.SetAttribute("gce.ratelimit_wait_seconds", time.Since(start))
+		if err != nil {
+			span.End(err)
+			apiRequests.WithLabelValues(group, req.Method, "error").Inc()
+			// RoundTrip closes the body of the request, even on errors.
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	apiRequestDuration.WithLabelValues(group, req.Method).Observe(time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		span.SetAttribute("http.status_code", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			span.End(fmt.Errorf("%v", resp.Status))
+		} else {
+			// Only mutations and operation polls return operations.
+			if req.Method != http.MethodGet || strings.HasSuffix(group, ".operations") {
+				traceOperation(span, resp)
+			}
+			span.End(nil)
+		}
+	} else {
+		span.End(err)
+	}
+	apiRequests.WithLabelValues(group, req.Method, code).Inc()
+	return resp, err
+}
+
+// contextLimiter is a rate limiter whose waits can be cancelled, which the
+// vendored flowcontrol.RateLimiter predates.
+type contextLimiter interface {
+	// Wait returns once a token becomes available, or with the error of
+	// the given context if it is done first.
+	Wait(ctx context.Context) error
+}
+
+// wait waits for a token of the given limiter, or for the given context to be
+// done. The waits of limiters which can't be cancelled are abandoned, their
+// token is still taken once available.
+func wait(ctx context.Context, limiter flowcontrol.RateLimiter) error {
+	if l, ok := limiter.(contextLimiter); ok {
+		return l.Wait(ctx)
+	}
+	if ctx.Done() == nil {
+		limiter.Accept()
+		return nil
+	}
+	accepted := make(chan struct{})
+	go func() {
+		limiter.Accept()
+		close(accepted)
+	}()
+	select {
+	case <-accepted:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tokenBucket is a token bucket flowcontrol.RateLimiter whose waits can be
+// cancelled. A cancelled wait still takes its token, so the requests
+// cancelled while waiting count towards the rate.
+type tokenBucket struct {
+	bucket *jujuratelimit.Bucket
+	qps    float32
+}
+
+// newTokenBucket returns a tokenBucket allowing bursts of up to burst
+// requests over the given qps. The bucket starts full.
+func newTokenBucket(qps float32, burst int) *tokenBucket {
+	return &tokenBucket{bucket: jujuratelimit.NewBucketWithRate(float64(qps), int64(burst)), qps: qps}
+}
+
+func (t *tokenBucket) TryAccept() bool {
+	return t.bucket.TakeAvailable(1) == 1
+}
+
+func (t *tokenBucket) Accept() {
+	t.bucket.Wait(1)
+}
+
+// Wait implements contextLimiter.
+func (t *tokenBucket) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d := t.bucket.Take(1)
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *tokenBucket) Stop() {}
+
+func (t *tokenBucket) Saturation() float64 {
+	capacity := t.bucket.Capacity()
+	return float64(capacity-t.bucket.Available()) / float64(capacity)
+}
+
+func (t *tokenBucket) QPS() float32 {
+	return t.qps
+}
+
+// maxOperationLen is the maximum length of the responses parsed as GCE
+// operations. Operations are much shorter.
+const maxOperationLen = 64 * 1024
+
+// traceOperation records the GCE operation returned by the traced call of the
+// given response, if any, so that the time waiting for it is traced. The
+// body of the response is restored.
+func traceOperation(span *tracing.Span, resp *http.Response) {
+	if span == nil || resp.Body == nil || resp.ContentLength > maxOperationLen {
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOperationLen+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil || len(body) > maxOperationLen {
+		return
+	}
+	var op struct {
+		Kind   string `json:"kind"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	if json.Unmarshal(body, &op) != nil || op.Kind != "compute#operation" {
+		return
+	}
+	span.SetAttribute("gce.operation", op.Name)
+	span.Operation(op.Name, op.Status == "DONE")
+}
+
+// SetLimiters replaces the rate limiters by API group. Requests waiting for
+// the previous limiters are not affected.
+func (t *Transport) SetLimiters(limiters map[string]flowcontrol.RateLimiter) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.limiters = limiters
+}
+
+// APIGroup returns the API group of the given GCE API URL: the service and
+// the resource collection, eg: compute.backendServices for
+// https://www.googleapis.com/compute/v1/projects/p/global/backendServices/be.
+// The aggregated lists, eg: .../projects/p/aggregated/backendServices, are in
+// the group of their collection. Returns an empty string for other URLs.
+func APIGroup(u *url.URL) string {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	// Paths are /<service>/<version>/projects/<project>/...
+	i := 0
+	for i < len(segments) && segments[i] != "projects" {
+		i++
+	}
+	if i < 2 || i == len(segments) {
+		return ""
+	}
+	service := segments[i-2]
+	rest := segments[i+2:]
+	collection := "projects"
+	switch {
+	case len(rest) == 0:
+	case (rest[0] == "global" || rest[0] == "aggregated") && len(rest) > 1:
+		collection = rest[1]
+	case (rest[0] == "zones" || rest[0] == "regions") && len(rest) > 2:
+		collection = rest[2]
+	default:
+		collection = rest[0]
+	}
+	return service + "." + collection
+}
+
+// ParseRateLimits returns the rate limiters of the given specs, formatted as
+// "<API group>,qps,<qps>,<burst>", eg: "compute.backendServices,qps,5,10".
+// A later spec of a group replaces the earlier ones.
+func ParseRateLimits(specs []string) (map[string]flowcontrol.RateLimiter, error) {
+	limiters := map[string]flowcontrol.RateLimiter{}
+	for _, spec := range specs {
+		parts := strings.Split(spec, ",")
+		if len(parts) != 4 || parts[0] == "" || parts[1] != "qps" {
+			return nil, fmt.Errorf("invalid rate limit %q, expected <API group>,qps,<qps>,<burst>", spec)
+		}
+		qps, err := strconv.ParseFloat(parts[2], 32)
+		if err != nil || qps <= 0 {
+			return nil, fmt.Errorf("invalid qps %q of rate limit %q", parts[2], spec)
+		}
+		burst, err := strconv.Atoi(parts[3])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst %q of rate limit %q", parts[3], spec)
+		}
+		logging.Infof("Limiting GCE API group %v to %v qps, bursts of %v", parts[0], qps, burst)
+		limiters[parts[0]] = newTokenBucket(float32(qps), burst)
+	}
+	return limiters, nil
+}
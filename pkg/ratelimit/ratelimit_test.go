@@ -0,0 +1,237 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"k8s.io/client-go/util/flowcontrol"
+
+	"k8s.io/ingress-gce/pkg/tracing"
+)
+
+func TestAPIGroup(t *testing.T) {
+	for _, tc := range []struct {
+		url  string
+		want string
+	}{
+		{"https://www.googleapis.com/compute/v1/projects/p/global/backendServices/be", "compute.backendServices"},
+		{"https://www.googleapis.com/compute/v1/projects/p/global/backendServices", "compute.backendServices"},
+		{"https://www.googleapis.com/compute/alpha/projects/p/zones/us-central1-a/networkEndpointGroups/neg/attachNetworkEndpoints", "compute.networkEndpointGroups"},
+		{"https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/operations/op", "compute.operations"},
+		{"https://www.googleapis.com/compute/v1/projects/p/regions/us-central1/addresses", "compute.addresses"},
+		{"https://www.googleapis.com/compute/v1/projects/p/aggregated/instanceGroups", "compute.instanceGroups"},
+		{"https://www.googleapis.com/compute/v1/projects/p/aggregated/forwardingRules", "compute.forwardingRules"},
+		{"https://www.googleapis.com/compute/v1/projects/p/aggregated/operations", "compute.operations"},
+		{"https://www.googleapis.com/compute/v1/projects/p/zones", "compute.zones"},
+		{"https://www.googleapis.com/compute/v1/projects/p", "compute.projects"},
+		{"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", ""},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) = _, %v", tc.url, err)
+		}
+		if got := APIGroup(u); got != tc.want {
+			t.Errorf("APIGroup(%q) = %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limiters, err := ParseRateLimits([]string{"compute.backendServices,qps,5,10", "compute.firewalls,qps,1,1", "compute.backendServices,qps,2,4"})
+	if err != nil {
+		t.Fatalf("ParseRateLimits() = _, %v", err)
+	}
+	if len(limiters) != 2 {
+		t.Errorf("Got %d limiters, want 2", len(limiters))
+	}
+	if qps := limiters["compute.backendServices"].QPS(); qps != 2 {
+		t.Errorf("Got %v qps for compute.backendServices, want the last spec, 2", qps)
+	}
+	for _, spec := range []string{"compute.firewalls", "compute.firewalls,5,10", ",qps,5,10", "compute.firewalls,qps,0,10", "compute.firewalls,qps,5,0", "compute.firewalls,qps,x,10"} {
+		if _, err := ParseRateLimits([]string{spec}); err == nil {
+			t.Errorf("Expected an error for rate limit %q", spec)
+		}
+	}
+}
+
+// countingLimiter counts the accepted requests.
+type countingLimiter struct {
+	flowcontrol.RateLimiter
+	accepted int
+}
+
+func (l *countingLimiter) Accept() {
+	l.accepted++
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransport(t *testing.T) {
+	sent := 0
+	base := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	limiter := &countingLimiter{RateLimiter: flowcontrol.NewFakeAlwaysRateLimiter()}
+	transport := NewTransport(base, map[string]flowcontrol.RateLimiter{"compute.firewalls": limiter})
+	for _, u := range []string{
+		"https://www.googleapis.com/compute/v1/projects/p/global/firewalls/fw",
+		"https://www.googleapis.com/compute/v1/projects/p/global/backendServices/be",
+		"https://www.googleapis.com/compute/beta/projects/p/global/firewalls",
+	} {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatalf("RoundTrip(%v) = _, %v", u, err)
+		}
+	}
+	if sent != 3 || limiter.accepted != 2 {
+		t.Errorf("Sent %d requests, %d through the limiter, want 3 and 2", sent, limiter.accepted)
+	}
+	metric := &dto.Metric{}
+	if err := apiRequests.WithLabelValues("compute.firewalls", "GET", "200").Write(metric); err != nil {
+		t.Fatalf("Failed to write metric: %v", err)
+	}
+	if count := metric.GetCounter().GetValue(); count != 2 {
+		t.Errorf("Got %v compute.firewalls requests in the metrics, want 2", count)
+	}
+}
+
+func TestTransportCancelledWait(t *testing.T) {
+	sent := 0
+	base := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	limiters, err := ParseRateLimits([]string{"compute.firewalls,qps,0.001,1"})
+	if err != nil {
+		t.Fatalf("ParseRateLimits() = _, %v", err)
+	}
+	// A limiter which never accepts can't be cancelled, the wait is
+	// abandoned.
+	limiters["compute.backendServices"] = flowcontrol.NewFakeNeverRateLimiter()
+	transport := NewTransport(base, limiters)
+	newRequest := func(ctx context.Context, u string) *http.Request {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return req.WithContext(ctx)
+	}
+
+	// The first request takes the burst.
+	if _, err := transport.RoundTrip(newRequest(context.Background(), "https://www.googleapis.com/compute/v1/projects/p/global/firewalls")); err != nil {
+		t.Fatalf("RoundTrip() = _, %v", err)
+	}
+	for _, u := range []string{
+		"https://www.googleapis.com/compute/v1/projects/p/global/firewalls",
+		"https://www.googleapis.com/compute/v1/projects/p/global/backendServices",
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := transport.RoundTrip(newRequest(ctx, u))
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("RoundTrip(%v) = _, %v, want %v", u, err, context.DeadlineExceeded)
+		}
+	}
+	if sent != 1 {
+		t.Errorf("Sent %d requests, want only the first one", sent)
+	}
+
+	// A done context fails before waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := transport.RoundTrip(newRequest(ctx, "https://www.googleapis.com/compute/v1/projects/p/global/firewalls")); err != context.Canceled {
+		t.Errorf("RoundTrip() = _, %v, want %v", err, context.Canceled)
+	}
+}
+
+// spanRecorder records the exported spans.
+type spanRecorder []*tracing.Span
+
+func (r *spanRecorder) Export(span *tracing.Span) {
+	*r = append(*r, span)
+}
+
+func TestTransportTracing(t *testing.T) {
+	status := "PENDING"
+	base := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		body := fmt.Sprintf(`{"kind":"compute#operation","name":"op","status":%q}`, status)
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	transport := NewTransport(base, nil)
+	spans := &spanRecorder{}
+	tracing.SetExporter(spans)
+	defer tracing.SetExporter(nil)
+
+	ctx, sync := tracing.StartSpan(context.Background(), "sync", tracing.KindInternal)
+	unbind := tracing.Bind(ctx)
+	for _, tc := range []struct {
+		method string
+		url    string
+	}{
+		{"POST", "https://www.googleapis.com/compute/v1/projects/p/global/urlMaps"},
+		{"GET", "https://www.googleapis.com/compute/v1/projects/p/global/operations/op"},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip(%v) = _, %v", tc.url, err)
+		}
+		if body, _ := ioutil.ReadAll(resp.Body); !strings.Contains(string(body), `"name":"op"`) {
+			t.Errorf("Got body %q for %v, want the operation", body, tc.url)
+		}
+		status = "DONE"
+	}
+	unbind()
+	sync.End(nil)
+
+	var names []string
+	for _, s := range *spans {
+		names = append(names, s.Name)
+		if s.TraceID != sync.TraceID {
+			t.Errorf("Span %v isn't part of the trace of %v", s, sync)
+		}
+	}
+	want := []string{"POST compute.urlMaps", "wait operation", "GET compute.operations", "sync"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Got spans %v, want %v", names, want)
+	}
+	if _, ok := sync.Attributes[tracing.OperationWaitAttribute]; !ok {
+		t.Errorf("Got sync attributes %v, want the time waiting for operations", sync.Attributes)
+	}
+}
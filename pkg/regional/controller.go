@@ -0,0 +1,332 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package regional
+
+import (
+	"reflect"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	unversionedcore "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/context"
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// For each ingress, only retries 15 times to process it.
+	// This is a convention in kube-controller-manager.
+	maxRetries = 15
+)
+
+// Controller syncs the regional load balancers of the Ingresses of the
+// regional classes, and publishes their IP in the status of the Ingresses.
+type Controller struct {
+	client   kubernetes.Interface
+	pool     *Pool
+	recorder record.EventRecorder
+
+	ingressSynced cache.InformerSynced
+	serviceSynced cache.InformerSynced
+	nodeSynced    cache.InformerSynced
+	ingressLister cache.Indexer
+	nodeLister    cache.Indexer
+
+	// ingressQueue takes ingress key as work item. Ingress key with format "namespace/name".
+	ingressQueue workqueue.RateLimitingInterface
+}
+
+// NewController returns a controller of the load balancers of the given pool.
+func NewController(kubeClient kubernetes.Interface, ctx *context.ControllerContext, pool *Pool) *Controller {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(logging.Infof)
+	eventBroadcaster.StartRecordingToSink(&unversionedcore.EventSinkImpl{
+		Interface: kubeClient.Core().Events(""),
+	})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme,
+		api_v1.EventSource{Component: "regional-l7-controller"})
+
+	c := &Controller{
+		client:        kubeClient,
+		pool:          pool,
+		recorder:      recorder,
+		ingressSynced: ctx.IngressInformer.HasSynced,
+		serviceSynced: ctx.ServiceInformer.HasSynced,
+		nodeSynced:    ctx.NodeInformer.HasSynced,
+		ingressLister: ctx.IngressInformer.GetIndexer(),
+		nodeLister:    ctx.NodeInformer.GetIndexer(),
+		ingressQueue:  workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	ctx.IngressInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueIngress,
+		DeleteFunc: c.enqueueIngress,
+		UpdateFunc: func(old, cur interface{}) {
+			c.enqueueIngress(cur)
+		},
+	})
+	// The load balancers are synced when their Services change, eg: their
+	// ports, and when nodes come and go, so that the NEGs of new zones are
+	// added to their backend services, and their firewall rules target the
+	// tags of the new nodes.
+	ctx.ServiceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueServiceIngresses,
+		DeleteFunc: c.enqueueServiceIngresses,
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old.(*api_v1.Service).Spec, cur.(*api_v1.Service).Spec) {
+				c.enqueueServiceIngresses(cur)
+			}
+		},
+	})
+	ctx.NodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.enqueueAllIngresses() },
+		DeleteFunc: func(obj interface{}) { c.enqueueAllIngresses() },
+	})
+	return c
+}
+
+// Run syncs the load balancers until stopCh is closed.
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	wait.PollUntil(5*time.Second, func() (bool, error) {
+		logging.V(2).Infof("Waiting for initial sync")
+		return c.synced(), nil
+	}, stopCh)
+
+	logging.V(2).Infof("Starting regional L7 controller")
+	defer func() {
+		logging.V(2).Infof("Shutting down regional L7 controller")
+		c.ingressQueue.ShutDown()
+	}()
+
+	go wait.Until(c.ingressWorker, time.Second, stopCh)
+
+	<-stopCh
+}
+
+func (c *Controller) synced() bool {
+	return c.ingressSynced() && c.serviceSynced() && c.nodeSynced()
+}
+
+func (c *Controller) ingressWorker() {
+	for {
+		func() {
+			key, quit := c.ingressQueue.Get()
+			if quit {
+				return
+			}
+			defer c.ingressQueue.Done(key)
+			err := c.processIngress(key.(string))
+			c.handleErr(err, key)
+		}()
+	}
+}
+
+// wants returns true if the given Ingress asks for a regional load balancer.
+func (c *Controller) wants(ing *extensions.Ingress) bool {
+	return ing.DeletionTimestamp == nil && c.pool.ingressClass(ing) != nil
+}
+
+// hasFinalizer returns true if the given Ingress has the finalizer of the
+// regional load balancers.
+func hasFinalizer(ing *extensions.Ingress) bool {
+	for _, f := range ing.Finalizers {
+		if f == annotations.RegionalIngressFinalizerKey {
+			return true
+		}
+	}
+	return false
+}
+
+// processIngress ensures the regional load balancer of the given Ingress, or
+// deletes it if the Ingress no longer asks for one, then collects the backend
+// services no Ingress uses.
+func (c *Controller) processIngress(key string) error {
+	obj, exists, err := c.ingressLister.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		ing := obj.(*extensions.Ingress)
+		switch {
+		case c.wants(ing):
+			err = c.ensure(ing)
+		case hasFinalizer(ing):
+			err = c.delete(ing)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return c.pool.GCBackends(c.listIngresses())
+}
+
+// listIngresses returns the Ingresses of the cluster.
+func (c *Controller) listIngresses() []*extensions.Ingress {
+	var ings []*extensions.Ingress
+	for _, obj := range c.ingressLister.List() {
+		ings = append(ings, obj.(*extensions.Ingress))
+	}
+	return ings
+}
+
+// listNodeNames returns the names of the nodes of the cluster.
+func (c *Controller) listNodeNames() []string {
+	var names []string
+	for _, obj := range c.nodeLister.List() {
+		names = append(names, obj.(*api_v1.Node).Name)
+	}
+	return names
+}
+
+// ensure ensures the regional load balancer of the given Ingress, and
+// publishes its IP. The finalizer is placed first, so that the load balancer
+// is deleted with the Ingress.
+func (c *Controller) ensure(ing *extensions.Ingress) error {
+	ingClient := c.client.Extensions().Ingresses(ing.Namespace)
+	if !hasFinalizer(ing) {
+		updated := ing.DeepCopy()
+		updated.Finalizers = append(updated.Finalizers, annotations.RegionalIngressFinalizerKey)
+		var err error
+		if ing, err = ingClient.Update(updated); err != nil {
+			return err
+		}
+	}
+	kind := c.pool.ingressClass(ing).kind
+	status, err := c.pool.Ensure(ing, c.listNodeNames())
+	if err != nil {
+		c.recorder.Eventf(ing, api_v1.EventTypeWarning, "Sync", "Error syncing regional %v load balancer: %v", kind, err)
+		return err
+	}
+	if reflect.DeepEqual(ing.Status.LoadBalancer, *status) {
+		return nil
+	}
+	current, err := ingClient.Get(ing.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	current.Status.LoadBalancer = *status
+	if _, err := ingClient.UpdateStatus(current); err != nil {
+		return err
+	}
+	c.recorder.Eventf(ing, api_v1.EventTypeNormal, "CREATE", "Regional %v load balancer ip: %v", kind, status.Ingress[0].IP)
+	return nil
+}
+
+// delete deletes the regional load balancer of the given Ingress, clears its
+// status, and removes the finalizer.
+func (c *Controller) delete(ing *extensions.Ingress) error {
+	if err := c.pool.Delete(ing); err != nil {
+		c.recorder.Eventf(ing, api_v1.EventTypeWarning, "Delete", "Error deleting regional load balancer: %v", err)
+		return err
+	}
+	ingClient := c.client.Extensions().Ingresses(ing.Namespace)
+	updated := ing.DeepCopy()
+	// An Ingress being deleted keeps its status until it's gone.
+	if ing.DeletionTimestamp == nil && len(updated.Status.LoadBalancer.Ingress) > 0 {
+		updated.Status.LoadBalancer = api_v1.LoadBalancerStatus{}
+		var err error
+		if updated, err = ingClient.UpdateStatus(updated); err != nil {
+			return err
+		}
+	}
+	var finalizers []string
+	for _, f := range updated.Finalizers {
+		if f != annotations.RegionalIngressFinalizerKey {
+			finalizers = append(finalizers, f)
+		}
+	}
+	updated.Finalizers = finalizers
+	if _, err := ingClient.Update(updated); err != nil {
+		return err
+	}
+	c.recorder.Eventf(ing, api_v1.EventTypeNormal, "DELETE", "Deleted regional load balancer")
+	return nil
+}
+
+func (c *Controller) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.ingressQueue.Forget(key)
+		return
+	}
+
+	logging.Errorf("Error processing ingress %q: %v", key, err)
+	if c.ingressQueue.NumRequeues(key) < maxRetries {
+		c.ingressQueue.AddRateLimited(key)
+		return
+	}
+
+	defer c.ingressQueue.Forget(key)
+	ing, exists, err := c.ingressLister.GetByKey(key.(string))
+	if err != nil {
+		logging.Warningf("Failed to retrieve ingress %q from store: %v", key.(string), err)
+		return
+	}
+	if exists {
+		c.recorder.Eventf(ing.(*extensions.Ingress), api_v1.EventTypeWarning, "ProcessIngressFailed", "Ingress %q dropped from queue (requeued %v times)", key, c.ingressQueue.NumRequeues(key))
+	}
+}
+
+// enqueueIngress enqueues the given Ingress if it has, or asks for, a
+// regional load balancer.
+func (c *Controller) enqueueIngress(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ing, ok := obj.(*extensions.Ingress)
+	if !ok || (c.pool.ingressClass(ing) == nil && !hasFinalizer(ing)) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(ing)
+	if err != nil {
+		logging.Errorf("Failed to generate ingress key: %v", err)
+		return
+	}
+	c.ingressQueue.Add(key)
+}
+
+// enqueueServiceIngresses enqueues the regional Ingresses of the namespace
+// of the given Service.
+func (c *Controller) enqueueServiceIngresses(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	svc, ok := obj.(*api_v1.Service)
+	if !ok {
+		return
+	}
+	for _, ing := range c.listIngresses() {
+		if ing.Namespace == svc.Namespace {
+			c.enqueueIngress(ing)
+		}
+	}
+}
+
+// enqueueAllIngresses enqueues all the regional Ingresses.
+func (c *Controller) enqueueAllIngresses() {
+	for _, ing := range c.listIngresses() {
+		c.enqueueIngress(ing)
+	}
+}
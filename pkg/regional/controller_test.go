@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package regional
+
+import (
+	"testing"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/context"
+)
+
+func TestControllerEnsureDelete(t *testing.T) {
+	pool, cloud, _ := newTestPool()
+	ing := newInternalIngress()
+	kubeClient := fake.NewSimpleClientset(ing)
+	c := NewController(kubeClient, context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, false), pool)
+	c.ingressLister.Add(ing)
+	c.nodeLister.Add(&api_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "node-1"}})
+
+	if err := c.processIngress("default/ing"); err != nil {
+		t.Fatalf("processIngress() = %v", err)
+	}
+	got, err := kubeClient.Extensions().Ingresses("default").Get("ing", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if !hasFinalizer(got) {
+		t.Errorf("finalizers = %v, want the finalizer of the regional load balancer", got.Finalizers)
+	}
+	if ingress := got.Status.LoadBalancer.Ingress; len(ingress) != 1 || ingress[0].IP != "10.0.0.1" {
+		t.Errorf("status = %+v, want IP 10.0.0.1", got.Status.LoadBalancer)
+	}
+
+	// The Ingress moves to the global class.
+	got.Annotations[annotations.IngressClassKey] = annotations.GceIngressClass
+	c.ingressLister.Update(got)
+	if err := c.processIngress("default/ing"); err != nil {
+		t.Fatalf("processIngress() = %v", err)
+	}
+	got, err = kubeClient.Extensions().Ingresses("default").Get("ing", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if hasFinalizer(got) || len(got.Status.LoadBalancer.Ingress) != 0 {
+		t.Errorf("Ingress = %+v, want no finalizer nor status", got)
+	}
+	if len(cloud.ForwardingRules)+len(cloud.UrlMaps)+len(cloud.BackendServices) != 0 {
+		t.Errorf("resources left: %+v", cloud)
+	}
+}
+
+func TestEnqueueIngress(t *testing.T) {
+	pool, _, _ := newTestPool()
+	kubeClient := fake.NewSimpleClientset()
+	c := NewController(kubeClient, context.NewControllerContext(kubeClient, api_v1.NamespaceAll, 1*time.Second, false), pool)
+	global := newInternalIngress()
+	delete(global.Annotations, annotations.IngressClassKey)
+	c.enqueueIngress(global)
+	if c.ingressQueue.Len() != 0 {
+		t.Errorf("queue length = %v, want the global Ingress ignored", c.ingressQueue.Len())
+	}
+	global.Finalizers = []string{annotations.RegionalIngressFinalizerKey}
+	c.enqueueIngress(global)
+	if c.ingressQueue.Len() != 1 {
+		t.Errorf("queue length = %v, want the Ingress with the finalizer enqueued", c.ingressQueue.Len())
+	}
+}
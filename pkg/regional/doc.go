@@ -0,0 +1,24 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package regional manages the regional HTTP(S) load balancers of the
+// Ingresses of the gce-internal class, internal to the network of the
+// cluster, and of the gce-regional-external class, external. Each of them
+// gets a regional URL map, target proxies and forwarding rules sharing a
+// regional address, and a firewall rule letting the proxy-only subnet of the
+// region reach the pods. Their backends are regional backend services of the
+// NEGs of the Services, shared by the Ingresses.
+package regional
@@ -0,0 +1,563 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package regional
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	"google.golang.org/api/googleapi"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+)
+
+// FakeLoadBalancers is a fake LoadBalancers, keeping the resources in
+// memory. The addresses without IP get the next IP of 10.0.0.0/24.
+type FakeLoadBalancers struct {
+	mu                 sync.Mutex
+	region             string
+	network            string
+	subnetwork         string
+	nextIP             int
+	Addresses          map[string]*Address
+	HealthChecks       map[string]*HealthCheck
+	BackendServices    map[string]*BackendService
+	UrlMaps            map[string]*loadbalancers.UrlMap
+	TargetHttpProxies  map[string]*TargetHttpProxy
+	TargetHttpsProxies map[string]*TargetHttpsProxy
+	SslCertificates    map[string]*SslCertificate
+	ForwardingRules    map[string]*ForwardingRule
+	Subnetworks        []*Subnetwork
+	// NEGs are keyed by zone/name.
+	NEGs map[string]*computealpha.NetworkEndpointGroup
+	// Backend services get a new fingerprint on every change.
+	fingerprint int
+}
+
+// Ensure that FakeLoadBalancers implements LoadBalancers.
+var _ LoadBalancers = &FakeLoadBalancers{}
+
+// NewFakeLoadBalancers returns a FakeLoadBalancers of the given region,
+// network and subnet.
+func NewFakeLoadBalancers(region, network, subnetwork string) *FakeLoadBalancers {
+	return &FakeLoadBalancers{
+		region:             region,
+		network:            network,
+		subnetwork:         subnetwork,
+		Addresses:          map[string]*Address{},
+		HealthChecks:       map[string]*HealthCheck{},
+		BackendServices:    map[string]*BackendService{},
+		UrlMaps:            map[string]*loadbalancers.UrlMap{},
+		TargetHttpProxies:  map[string]*TargetHttpProxy{},
+		TargetHttpsProxies: map[string]*TargetHttpsProxy{},
+		SslCertificates:    map[string]*SslCertificate{},
+		ForwardingRules:    map[string]*ForwardingRule{},
+		NEGs:               map[string]*computealpha.NetworkEndpointGroup{},
+	}
+}
+
+func notFound(kind, name string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("%v %v not found", kind, name)}
+}
+
+func alreadyExists(kind, name string) error {
+	return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("%v %v already exists", kind, name)}
+}
+
+func inUse(kind, name, user string) error {
+	return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("%v %v is already being used by %v", kind, name, user)}
+}
+
+func selfLink(region, collection, name string) string {
+	return fmt.Sprintf("regions/%v/%v/%v", region, collection, name)
+}
+
+func (f *FakeLoadBalancers) Region() string           { return f.region }
+func (f *FakeLoadBalancers) NetworkURL() string       { return f.network }
+func (f *FakeLoadBalancers) SubnetworkURL() string    { return f.subnetwork }
+func (f *FakeLoadBalancers) NetworkProjectID() string { return "test-project" }
+
+func (f *FakeLoadBalancers) GetAddress(name, region string) (*Address, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	addr, ok := f.Addresses[name]
+	if !ok {
+		return nil, notFound("address", name)
+	}
+	copy := *addr
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateAddress(addr *Address, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Addresses[addr.Name]; ok {
+		return alreadyExists("address", addr.Name)
+	}
+	copy := *addr
+	if copy.Address == "" {
+		f.nextIP++
+		copy.Address = fmt.Sprintf("10.0.0.%d", f.nextIP)
+	}
+	copy.SelfLink = selfLink(region, "addresses", addr.Name)
+	f.Addresses[addr.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteAddress(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Addresses[name]; !ok {
+		return notFound("address", name)
+	}
+	delete(f.Addresses, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetHealthCheck(name, region string) (*HealthCheck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hc, ok := f.HealthChecks[name]
+	if !ok {
+		return nil, notFound("health check", name)
+	}
+	copy := *hc
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateHealthCheck(hc *HealthCheck, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.HealthChecks[hc.Name]; ok {
+		return alreadyExists("health check", hc.Name)
+	}
+	copy := *hc
+	copy.SelfLink = selfLink(region, "healthChecks", hc.Name)
+	f.HealthChecks[hc.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) UpdateHealthCheck(hc *HealthCheck, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.HealthChecks[hc.Name]
+	if !ok {
+		return notFound("health check", hc.Name)
+	}
+	copy := *hc
+	copy.SelfLink = existing.SelfLink
+	f.HealthChecks[hc.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteHealthCheck(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.HealthChecks[name]; !ok {
+		return notFound("health check", name)
+	}
+	delete(f.HealthChecks, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetBackendService(name, region string) (*BackendService, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bs, ok := f.BackendServices[name]
+	if !ok {
+		return nil, notFound("backend service", name)
+	}
+	copy := *bs
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) ListBackendServices(region string) ([]*BackendService, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var services []*BackendService
+	for _, bs := range f.BackendServices {
+		copy := *bs
+		services = append(services, &copy)
+	}
+	return services, nil
+}
+
+func (f *FakeLoadBalancers) CreateBackendService(bs *BackendService, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.BackendServices[bs.Name]; ok {
+		return alreadyExists("backend service", bs.Name)
+	}
+	copy := *bs
+	copy.SelfLink = selfLink(region, "backendServices", bs.Name)
+	f.fingerprint++
+	copy.Fingerprint = fmt.Sprintf("%d", f.fingerprint)
+	f.BackendServices[bs.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) UpdateBackendService(bs *BackendService, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.BackendServices[bs.Name]
+	if !ok {
+		return notFound("backend service", bs.Name)
+	}
+	if bs.Fingerprint != existing.Fingerprint {
+		return &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "fingerprint mismatch"}
+	}
+	copy := *bs
+	copy.SelfLink = existing.SelfLink
+	f.fingerprint++
+	copy.Fingerprint = fmt.Sprintf("%d", f.fingerprint)
+	f.BackendServices[bs.Name] = &copy
+	return nil
+}
+
+// DeleteBackendService refuses to delete the backend services of the url
+// maps, as GCE.
+func (f *FakeLoadBalancers) DeleteBackendService(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bs, ok := f.BackendServices[name]
+	if !ok {
+		return notFound("backend service", name)
+	}
+	for _, um := range f.UrlMaps {
+		if urlMapUses(um, bs.SelfLink) {
+			return inUse("backend service", name, um.Name)
+		}
+	}
+	delete(f.BackendServices, name)
+	return nil
+}
+
+// urlMapUses returns true if the given url map routes requests to the given
+// backend service.
+func urlMapUses(um *loadbalancers.UrlMap, link string) bool {
+	if um.DefaultService == link {
+		return true
+	}
+	for _, pm := range um.PathMatchers {
+		if pm.DefaultService == link {
+			return true
+		}
+		for _, rule := range pm.PathRules {
+			if rule.Service == link {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *FakeLoadBalancers) GetUrlMap(name, region string) (*loadbalancers.UrlMap, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	um, ok := f.UrlMaps[name]
+	if !ok {
+		return nil, notFound("url map", name)
+	}
+	copy := *um
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateUrlMap(um *loadbalancers.UrlMap, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.UrlMaps[um.Name]; ok {
+		return alreadyExists("url map", um.Name)
+	}
+	copy := *um
+	copy.SelfLink = selfLink(region, "urlMaps", um.Name)
+	f.UrlMaps[um.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) UpdateUrlMap(um *loadbalancers.UrlMap, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, ok := f.UrlMaps[um.Name]
+	if !ok {
+		return notFound("url map", um.Name)
+	}
+	copy := *um
+	copy.SelfLink = existing.SelfLink
+	f.UrlMaps[um.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteUrlMap(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.UrlMaps[name]; !ok {
+		return notFound("url map", name)
+	}
+	delete(f.UrlMaps, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetTargetHttpProxy(name, region string) (*TargetHttpProxy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proxy, ok := f.TargetHttpProxies[name]
+	if !ok {
+		return nil, notFound("target HTTP proxy", name)
+	}
+	copy := *proxy
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateTargetHttpProxy(proxy *TargetHttpProxy, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.TargetHttpProxies[proxy.Name]; ok {
+		return alreadyExists("target HTTP proxy", proxy.Name)
+	}
+	copy := *proxy
+	copy.SelfLink = selfLink(region, "targetHttpProxies", proxy.Name)
+	f.TargetHttpProxies[proxy.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) SetUrlMapForTargetHttpProxy(name, region, urlMap string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proxy, ok := f.TargetHttpProxies[name]
+	if !ok {
+		return notFound("target HTTP proxy", name)
+	}
+	proxy.UrlMap = urlMap
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteTargetHttpProxy(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.TargetHttpProxies[name]; !ok {
+		return notFound("target HTTP proxy", name)
+	}
+	delete(f.TargetHttpProxies, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetTargetHttpsProxy(name, region string) (*TargetHttpsProxy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proxy, ok := f.TargetHttpsProxies[name]
+	if !ok {
+		return nil, notFound("target HTTPS proxy", name)
+	}
+	copy := *proxy
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateTargetHttpsProxy(proxy *TargetHttpsProxy, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.TargetHttpsProxies[proxy.Name]; ok {
+		return alreadyExists("target HTTPS proxy", proxy.Name)
+	}
+	copy := *proxy
+	copy.SelfLink = selfLink(region, "targetHttpsProxies", proxy.Name)
+	f.TargetHttpsProxies[proxy.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) SetUrlMapForTargetHttpsProxy(name, region, urlMap string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proxy, ok := f.TargetHttpsProxies[name]
+	if !ok {
+		return notFound("target HTTPS proxy", name)
+	}
+	proxy.UrlMap = urlMap
+	return nil
+}
+
+func (f *FakeLoadBalancers) SetSslCertificatesForTargetHttpsProxy(name, region string, certs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proxy, ok := f.TargetHttpsProxies[name]
+	if !ok {
+		return notFound("target HTTPS proxy", name)
+	}
+	proxy.SslCertificates = append([]string{}, certs...)
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteTargetHttpsProxy(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.TargetHttpsProxies[name]; !ok {
+		return notFound("target HTTPS proxy", name)
+	}
+	delete(f.TargetHttpsProxies, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetSslCertificate(name, region string) (*SslCertificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cert, ok := f.SslCertificates[name]
+	if !ok {
+		return nil, notFound("SSL certificate", name)
+	}
+	copy := *cert
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) ListSslCertificates(region string) ([]*SslCertificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var certs []*SslCertificate
+	for _, cert := range f.SslCertificates {
+		copy := *cert
+		certs = append(certs, &copy)
+	}
+	return certs, nil
+}
+
+// CreateSslCertificate keeps the certificate without its private key, which
+// GCE never returns.
+func (f *FakeLoadBalancers) CreateSslCertificate(cert *SslCertificate, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.SslCertificates[cert.Name]; ok {
+		return alreadyExists("SSL certificate", cert.Name)
+	}
+	copy := *cert
+	copy.PrivateKey = ""
+	copy.SelfLink = selfLink(region, "sslCertificates", cert.Name)
+	f.SslCertificates[cert.Name] = &copy
+	return nil
+}
+
+// DeleteSslCertificate refuses to delete the certificates of the target
+// proxies, as GCE.
+func (f *FakeLoadBalancers) DeleteSslCertificate(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cert, ok := f.SslCertificates[name]
+	if !ok {
+		return notFound("SSL certificate", name)
+	}
+	for _, proxy := range f.TargetHttpsProxies {
+		for _, link := range proxy.SslCertificates {
+			if link == cert.SelfLink {
+				return inUse("SSL certificate", name, proxy.Name)
+			}
+		}
+	}
+	delete(f.SslCertificates, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) GetForwardingRule(name, region string) (*ForwardingRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rule, ok := f.ForwardingRules[name]
+	if !ok {
+		return nil, notFound("forwarding rule", name)
+	}
+	copy := *rule
+	return &copy, nil
+}
+
+func (f *FakeLoadBalancers) CreateForwardingRule(rule *ForwardingRule, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.ForwardingRules[rule.Name]; ok {
+		return alreadyExists("forwarding rule", rule.Name)
+	}
+	copy := *rule
+	copy.SelfLink = selfLink(region, "forwardingRules", rule.Name)
+	f.ForwardingRules[rule.Name] = &copy
+	return nil
+}
+
+func (f *FakeLoadBalancers) DeleteForwardingRule(name, region string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.ForwardingRules[name]; !ok {
+		return notFound("forwarding rule", name)
+	}
+	delete(f.ForwardingRules, name)
+	return nil
+}
+
+func (f *FakeLoadBalancers) ListSubnetworks(region string) ([]*Subnetwork, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*Subnetwork{}, f.Subnetworks...), nil
+}
+
+// AddNEG adds a NEG of the given name to the given zone.
+func (f *FakeLoadBalancers) AddNEG(name, zone string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.NEGs[zone+"/"+name] = &computealpha.NetworkEndpointGroup{
+		Name:     name,
+		SelfLink: fmt.Sprintf("zones/%v/networkEndpointGroups/%v", zone, name),
+	}
+}
+
+func (f *FakeLoadBalancers) GetNetworkEndpointGroup(name string, zone string) (*computealpha.NetworkEndpointGroup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	neg, ok := f.NEGs[zone+"/"+name]
+	if !ok {
+		return nil, notFound("NEG", name)
+	}
+	copy := *neg
+	return &copy, nil
+}
+
+// FakeCluster is a fake Cluster, of the given zones, whose Ingresses have
+// the given pathTypes and whose numeric target ports are their endpoints
+// ports.
+type FakeCluster struct {
+	Zones []string
+	// PathTypes are keyed by Ingress namespace/name, then host and path.
+	PathTypes map[string]map[string]map[string]string
+}
+
+// Ensure that FakeCluster implements Cluster.
+var _ Cluster = &FakeCluster{}
+
+func (f *FakeCluster) ListZones() ([]string, error) { return f.Zones, nil }
+
+func (f *FakeCluster) IngressClass(ing *extensions.Ingress) string {
+	return annotations.IngAnnotations(ing.Annotations).IngressClass()
+}
+
+func (f *FakeCluster) IngressPathTypes(ing *extensions.Ingress) (map[string]map[string]string, error) {
+	return f.PathTypes[ing.Namespace+"/"+ing.Name], nil
+}
+
+func (f *FakeCluster) EndpointTargetPorts(namespace, name, targetPort string) []int {
+	if port, err := strconv.Atoi(targetPort); err == nil {
+		return []int{port}
+	}
+	return nil
+}
@@ -0,0 +1,437 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package regional
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+	computealpha "google.golang.org/api/compute/v0.alpha"
+
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
+
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// The vendored compute API predates the regional HTTP(S) load balancers, so
+// all their resources are managed through the REST API.
+
+// Address is a regional address, shared by the forwarding rules of a load
+// balancer.
+type Address struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Address     string `json:"address,omitempty"`
+	AddressType string `json:"addressType,omitempty"`
+	// Purpose is SHARED_LOADBALANCER_VIP for the internal addresses of
+	// several forwarding rules.
+	Purpose    string `json:"purpose,omitempty"`
+	Subnetwork string `json:"subnetwork,omitempty"`
+	SelfLink   string `json:"selfLink,omitempty"`
+}
+
+// HealthCheck is a regional HTTP health check of NEGs.
+type HealthCheck struct {
+	Name               string           `json:"name"`
+	Description        string           `json:"description,omitempty"`
+	Type               string           `json:"type"`
+	CheckIntervalSec   int64            `json:"checkIntervalSec,omitempty"`
+	TimeoutSec         int64            `json:"timeoutSec,omitempty"`
+	HealthyThreshold   int64            `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int64            `json:"unhealthyThreshold,omitempty"`
+	HttpHealthCheck    *HTTPHealthCheck `json:"httpHealthCheck,omitempty"`
+	SelfLink           string           `json:"selfLink,omitempty"`
+}
+
+// HTTPHealthCheck are the HTTP settings of a health check.
+type HTTPHealthCheck struct {
+	PortSpecification string `json:"portSpecification,omitempty"`
+	RequestPath       string `json:"requestPath,omitempty"`
+}
+
+// BackendService is a regional backend service of NEGs.
+type BackendService struct {
+	Name                string                  `json:"name"`
+	Description         string                  `json:"description,omitempty"`
+	Protocol            string                  `json:"protocol,omitempty"`
+	LoadBalancingScheme string                  `json:"loadBalancingScheme,omitempty"`
+	HealthChecks        []string                `json:"healthChecks,omitempty"`
+	Backends            []*computealpha.Backend `json:"backends,omitempty"`
+	Fingerprint         string                  `json:"fingerprint,omitempty"`
+	SelfLink            string                  `json:"selfLink,omitempty"`
+}
+
+// TargetHttpProxy is a regional target HTTP proxy.
+type TargetHttpProxy struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	UrlMap      string `json:"urlMap,omitempty"`
+	SelfLink    string `json:"selfLink,omitempty"`
+}
+
+// TargetHttpsProxy is a regional target HTTPS proxy.
+type TargetHttpsProxy struct {
+	Name            string   `json:"name"`
+	Description     string   `json:"description,omitempty"`
+	UrlMap          string   `json:"urlMap,omitempty"`
+	SslCertificates []string `json:"sslCertificates,omitempty"`
+	SelfLink        string   `json:"selfLink,omitempty"`
+}
+
+// SslCertificate is a regional SSL certificate. Its private key is never
+// returned.
+type SslCertificate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	PrivateKey  string `json:"privateKey,omitempty"`
+	SelfLink    string `json:"selfLink,omitempty"`
+}
+
+// ForwardingRule is a regional forwarding rule of a target proxy.
+type ForwardingRule struct {
+	Name                string `json:"name"`
+	Description         string `json:"description,omitempty"`
+	IPAddress           string `json:"IPAddress,omitempty"`
+	IPProtocol          string `json:"IPProtocol,omitempty"`
+	PortRange           string `json:"portRange,omitempty"`
+	LoadBalancingScheme string `json:"loadBalancingScheme,omitempty"`
+	Network             string `json:"network,omitempty"`
+	Subnetwork          string `json:"subnetwork,omitempty"`
+	Target              string `json:"target,omitempty"`
+	SelfLink            string `json:"selfLink,omitempty"`
+}
+
+// Subnetwork is a subnet of the network, eg: the proxy-only subnet of the
+// regional load balancers.
+type Subnetwork struct {
+	Name        string `json:"name"`
+	Network     string `json:"network,omitempty"`
+	IpCidrRange string `json:"ipCidrRange,omitempty"`
+	// Purpose is REGIONAL_MANAGED_PROXY, or the deprecated
+	// INTERNAL_HTTPS_LOAD_BALANCER, for the proxy-only subnets.
+	Purpose string `json:"purpose,omitempty"`
+	// Role is ACTIVE or BACKUP for the proxy-only subnets.
+	Role     string `json:"role,omitempty"`
+	SelfLink string `json:"selfLink,omitempty"`
+}
+
+// gceLoadBalancers implements LoadBalancers: the NEGs through the cloud
+// provider, the other resources through the REST API.
+type gceLoadBalancers struct {
+	cloud *gce.GCECloud
+	rest  *utils.ComputeREST
+	// networkREST lists the subnets, in the project of the network.
+	networkREST *utils.ComputeREST
+}
+
+// Ensure that gceLoadBalancers implements LoadBalancers.
+var _ LoadBalancers = &gceLoadBalancers{}
+
+// NewGCELoadBalancers returns the LoadBalancers of the project of the given
+// cloud. tokenSource, transport and apiEndpoint are those of
+// utils.NewComputeREST.
+func NewGCELoadBalancers(cloud *gce.GCECloud, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (LoadBalancers, error) {
+	rest, err := utils.NewComputeREST(cloud.ProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	networkREST, err := utils.NewComputeREST(cloud.NetworkProjectID(), tokenSource, transport, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &gceLoadBalancers{cloud: cloud, rest: rest, networkREST: networkREST}, nil
+}
+
+func (g *gceLoadBalancers) Region() string           { return g.cloud.Region() }
+func (g *gceLoadBalancers) NetworkURL() string       { return g.cloud.NetworkURL() }
+func (g *gceLoadBalancers) SubnetworkURL() string    { return g.cloud.SubnetworkURL() }
+func (g *gceLoadBalancers) NetworkProjectID() string { return g.cloud.NetworkProjectID() }
+
+// GetNetworkEndpointGroup returns the given NEG.
+func (g *gceLoadBalancers) GetNetworkEndpointGroup(name string, zone string) (*computealpha.NetworkEndpointGroup, error) {
+	return g.cloud.GetNetworkEndpointGroup(name, zone)
+}
+
+// GetAddress returns the given regional address.
+func (g *gceLoadBalancers) GetAddress(name, region string) (*Address, error) {
+	addr := &Address{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "addresses", name), nil, addr); err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// CreateAddress reserves the given regional address, and waits for it to be
+// reserved.
+func (g *gceLoadBalancers) CreateAddress(addr *Address, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "addresses", ""), addr)
+}
+
+// DeleteAddress releases the given regional address, and waits for it to be
+// released.
+func (g *gceLoadBalancers) DeleteAddress(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "addresses", name), nil)
+}
+
+// GetHealthCheck returns the given regional health check.
+func (g *gceLoadBalancers) GetHealthCheck(name, region string) (*HealthCheck, error) {
+	hc := &HealthCheck{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "healthChecks", name), nil, hc); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+// CreateHealthCheck creates the given regional health check, and waits for it
+// to be created.
+func (g *gceLoadBalancers) CreateHealthCheck(hc *HealthCheck, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "healthChecks", ""), hc)
+}
+
+// UpdateHealthCheck updates the given regional health check, and waits for it
+// to be updated.
+func (g *gceLoadBalancers) UpdateHealthCheck(hc *HealthCheck, region string) error {
+	return g.rest.DoOp("PUT", g.rest.RegionalURL(region, "healthChecks", hc.Name), hc)
+}
+
+// DeleteHealthCheck deletes the given regional health check, and waits for it
+// to be deleted.
+func (g *gceLoadBalancers) DeleteHealthCheck(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "healthChecks", name), nil)
+}
+
+// GetBackendService returns the given regional backend service.
+func (g *gceLoadBalancers) GetBackendService(name, region string) (*BackendService, error) {
+	bs := &BackendService{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "backendServices", name), nil, bs); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// ListBackendServices returns the regional backend services of the given
+// region.
+func (g *gceLoadBalancers) ListBackendServices(region string) ([]*BackendService, error) {
+	var services []*BackendService
+	query := url.Values{}
+	for {
+		page := struct {
+			Items         []*BackendService `json:"items"`
+			NextPageToken string            `json:"nextPageToken"`
+		}{}
+		if err := g.rest.Do("GET", g.rest.RegionalURL(region, "backendServices", "")+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		services = append(services, page.Items...)
+		if page.NextPageToken == "" {
+			return services, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// CreateBackendService creates the given regional backend service, and waits
+// for it to be created.
+func (g *gceLoadBalancers) CreateBackendService(bs *BackendService, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "backendServices", ""), bs)
+}
+
+// UpdateBackendService updates the given regional backend service, and waits
+// for it to be updated.
+func (g *gceLoadBalancers) UpdateBackendService(bs *BackendService, region string) error {
+	return g.rest.DoOp("PUT", g.rest.RegionalURL(region, "backendServices", bs.Name), bs)
+}
+
+// DeleteBackendService deletes the given regional backend service, and waits
+// for it to be deleted.
+func (g *gceLoadBalancers) DeleteBackendService(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "backendServices", name), nil)
+}
+
+// GetUrlMap returns the given regional url map.
+func (g *gceLoadBalancers) GetUrlMap(name, region string) (*loadbalancers.UrlMap, error) {
+	um := &loadbalancers.UrlMap{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "urlMaps", name), nil, um); err != nil {
+		return nil, err
+	}
+	return um, nil
+}
+
+// CreateUrlMap creates the given regional url map, and waits for it to be
+// created.
+func (g *gceLoadBalancers) CreateUrlMap(um *loadbalancers.UrlMap, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "urlMaps", ""), um)
+}
+
+// UpdateUrlMap updates the given regional url map, and waits for it to be
+// updated.
+func (g *gceLoadBalancers) UpdateUrlMap(um *loadbalancers.UrlMap, region string) error {
+	return g.rest.DoOp("PUT", g.rest.RegionalURL(region, "urlMaps", um.Name), um)
+}
+
+// DeleteUrlMap deletes the given regional url map, and waits for it to be
+// deleted.
+func (g *gceLoadBalancers) DeleteUrlMap(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "urlMaps", name), nil)
+}
+
+// GetTargetHttpProxy returns the given regional target HTTP proxy.
+func (g *gceLoadBalancers) GetTargetHttpProxy(name, region string) (*TargetHttpProxy, error) {
+	proxy := &TargetHttpProxy{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "targetHttpProxies", name), nil, proxy); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// CreateTargetHttpProxy creates the given regional target HTTP proxy, and
+// waits for it to be created.
+func (g *gceLoadBalancers) CreateTargetHttpProxy(proxy *TargetHttpProxy, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "targetHttpProxies", ""), proxy)
+}
+
+// SetUrlMapForTargetHttpProxy points the given regional target HTTP proxy to
+// the given url map, and waits for it to be updated.
+func (g *gceLoadBalancers) SetUrlMapForTargetHttpProxy(name, region, urlMap string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "targetHttpProxies", name)+"/setUrlMap", map[string]string{"urlMap": urlMap})
+}
+
+// DeleteTargetHttpProxy deletes the given regional target HTTP proxy, and
+// waits for it to be deleted.
+func (g *gceLoadBalancers) DeleteTargetHttpProxy(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "targetHttpProxies", name), nil)
+}
+
+// GetTargetHttpsProxy returns the given regional target HTTPS proxy.
+func (g *gceLoadBalancers) GetTargetHttpsProxy(name, region string) (*TargetHttpsProxy, error) {
+	proxy := &TargetHttpsProxy{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "targetHttpsProxies", name), nil, proxy); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// CreateTargetHttpsProxy creates the given regional target HTTPS proxy, and
+// waits for it to be created.
+func (g *gceLoadBalancers) CreateTargetHttpsProxy(proxy *TargetHttpsProxy, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "targetHttpsProxies", ""), proxy)
+}
+
+// SetUrlMapForTargetHttpsProxy points the given regional target HTTPS proxy
+// to the given url map, and waits for it to be updated.
+func (g *gceLoadBalancers) SetUrlMapForTargetHttpsProxy(name, region, urlMap string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "targetHttpsProxies", name)+"/setUrlMap", map[string]string{"urlMap": urlMap})
+}
+
+// SetSslCertificatesForTargetHttpsProxy sets the certificates of the given
+// regional target HTTPS proxy, in order, and waits for it to be updated.
+func (g *gceLoadBalancers) SetSslCertificatesForTargetHttpsProxy(name, region string, certs []string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "targetHttpsProxies", name)+"/setSslCertificates", map[string][]string{"sslCertificates": certs})
+}
+
+// DeleteTargetHttpsProxy deletes the given regional target HTTPS proxy, and
+// waits for it to be deleted.
+func (g *gceLoadBalancers) DeleteTargetHttpsProxy(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "targetHttpsProxies", name), nil)
+}
+
+// GetSslCertificate returns the given regional SSL certificate.
+func (g *gceLoadBalancers) GetSslCertificate(name, region string) (*SslCertificate, error) {
+	cert := &SslCertificate{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "sslCertificates", name), nil, cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// ListSslCertificates returns the regional SSL certificates of the given
+// region.
+func (g *gceLoadBalancers) ListSslCertificates(region string) ([]*SslCertificate, error) {
+	var certs []*SslCertificate
+	query := url.Values{}
+	for {
+		page := struct {
+			Items         []*SslCertificate `json:"items"`
+			NextPageToken string            `json:"nextPageToken"`
+		}{}
+		if err := g.rest.Do("GET", g.rest.RegionalURL(region, "sslCertificates", "")+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		certs = append(certs, page.Items...)
+		if page.NextPageToken == "" {
+			return certs, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// CreateSslCertificate creates the given regional SSL certificate, and waits
+// for it to be created.
+func (g *gceLoadBalancers) CreateSslCertificate(cert *SslCertificate, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "sslCertificates", ""), cert)
+}
+
+// DeleteSslCertificate deletes the given regional SSL certificate, and waits
+// for it to be deleted.
+func (g *gceLoadBalancers) DeleteSslCertificate(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "sslCertificates", name), nil)
+}
+
+// GetForwardingRule returns the given regional forwarding rule.
+func (g *gceLoadBalancers) GetForwardingRule(name, region string) (*ForwardingRule, error) {
+	rule := &ForwardingRule{}
+	if err := g.rest.Do("GET", g.rest.RegionalURL(region, "forwardingRules", name), nil, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// CreateForwardingRule creates the given regional forwarding rule, and waits
+// for it to be created.
+func (g *gceLoadBalancers) CreateForwardingRule(rule *ForwardingRule, region string) error {
+	return g.rest.DoOp("POST", g.rest.RegionalURL(region, "forwardingRules", ""), rule)
+}
+
+// DeleteForwardingRule deletes the given regional forwarding rule, and waits
+// for it to be deleted.
+func (g *gceLoadBalancers) DeleteForwardingRule(name, region string) error {
+	return g.rest.DoOp("DELETE", g.rest.RegionalURL(region, "forwardingRules", name), nil)
+}
+
+// ListSubnetworks returns the subnets of the given region of the project of
+// the network.
+func (g *gceLoadBalancers) ListSubnetworks(region string) ([]*Subnetwork, error) {
+	var subnets []*Subnetwork
+	query := url.Values{}
+	for {
+		page := struct {
+			Items         []*Subnetwork `json:"items"`
+			NextPageToken string        `json:"nextPageToken"`
+		}{}
+		if err := g.networkREST.Do("GET", g.networkREST.RegionalURL(region, "subnetworks", "")+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, page.Items...)
+		if page.NextPageToken == "" {
+			return subnets, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
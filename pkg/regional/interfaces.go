@@ -0,0 +1,104 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package regional
+
+import (
+	computealpha "google.golang.org/api/compute/v0.alpha"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+)
+
+// LoadBalancers is the GCE API of the regional HTTP(S) load balancers.
+type LoadBalancers interface {
+	// Region is the region of the cluster, and of the load balancers.
+	Region() string
+	// NetworkURL and SubnetworkURL are the network and default subnet of
+	// the cluster.
+	NetworkURL() string
+	SubnetworkURL() string
+	NetworkProjectID() string
+
+	GetAddress(name, region string) (*Address, error)
+	CreateAddress(addr *Address, region string) error
+	DeleteAddress(name, region string) error
+
+	GetHealthCheck(name, region string) (*HealthCheck, error)
+	CreateHealthCheck(hc *HealthCheck, region string) error
+	UpdateHealthCheck(hc *HealthCheck, region string) error
+	DeleteHealthCheck(name, region string) error
+
+	GetBackendService(name, region string) (*BackendService, error)
+	ListBackendServices(region string) ([]*BackendService, error)
+	CreateBackendService(bs *BackendService, region string) error
+	// UpdateBackendService updates the given backend service, whose
+	// fingerprint must be the current one.
+	UpdateBackendService(bs *BackendService, region string) error
+	DeleteBackendService(name, region string) error
+
+	GetUrlMap(name, region string) (*loadbalancers.UrlMap, error)
+	CreateUrlMap(um *loadbalancers.UrlMap, region string) error
+	// UpdateUrlMap updates the given url map, whose fingerprint must be
+	// the current one.
+	UpdateUrlMap(um *loadbalancers.UrlMap, region string) error
+	DeleteUrlMap(name, region string) error
+
+	GetTargetHttpProxy(name, region string) (*TargetHttpProxy, error)
+	CreateTargetHttpProxy(proxy *TargetHttpProxy, region string) error
+	SetUrlMapForTargetHttpProxy(name, region, urlMap string) error
+	DeleteTargetHttpProxy(name, region string) error
+
+	GetTargetHttpsProxy(name, region string) (*TargetHttpsProxy, error)
+	CreateTargetHttpsProxy(proxy *TargetHttpsProxy, region string) error
+	SetUrlMapForTargetHttpsProxy(name, region, urlMap string) error
+	SetSslCertificatesForTargetHttpsProxy(name, region string, certs []string) error
+	DeleteTargetHttpsProxy(name, region string) error
+
+	GetSslCertificate(name, region string) (*SslCertificate, error)
+	ListSslCertificates(region string) ([]*SslCertificate, error)
+	CreateSslCertificate(cert *SslCertificate, region string) error
+	DeleteSslCertificate(name, region string) error
+
+	GetForwardingRule(name, region string) (*ForwardingRule, error)
+	CreateForwardingRule(rule *ForwardingRule, region string) error
+	DeleteForwardingRule(name, region string) error
+
+	// ListSubnetworks returns the subnets of the given region, in the
+	// project of the network, eg: the host project of a shared VPC.
+	ListSubnetworks(region string) ([]*Subnetwork, error)
+
+	// The backends are the NEGs of the Services, synced by the NEG
+	// controller.
+	GetNetworkEndpointGroup(name string, zone string) (*computealpha.NetworkEndpointGroup, error)
+}
+
+// Cluster is what the load balancers know of the cluster, shared with the
+// Ingress controller.
+type Cluster interface {
+	// ListZones returns the zones of the nodes, where the NEGs are.
+	ListZones() ([]string, error)
+	// IngressClass returns the class of the given Ingress, in the values
+	// of the kubernetes.io/ingress.class annotation, from its IngressClass.
+	IngressClass(ing *extensions.Ingress) string
+	// IngressPathTypes returns the pathTypes of the paths of the given
+	// Ingress, keyed by host, then path.
+	IngressPathTypes(ing *extensions.Ingress) (map[string]map[string]string, error)
+	// EndpointTargetPorts returns the ports of the endpoints of the given
+	// target port of the given Service.
+	EndpointTargetPorts(namespace, name, targetPort string) []int
+}
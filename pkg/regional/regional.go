@@ -0,0 +1,903 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package regional
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/healthchecks"
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/tls"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	// Kinds of the names of the resources of a load balancer, see
+	// utils.Namer.RegionalL7.
+	urlMapKind              = "um"
+	httpProxyKind           = "th"
+	httpsProxyKind          = "ts"
+	httpForwardingRuleKind  = "fh"
+	httpsForwardingRuleKind = "fs"
+	firewallKind            = "fw"
+	addressKind             = "ip"
+
+	// proxyOnlyPurpose is the purpose of the proxy-only subnets, whose
+	// proxies connect to the backends of the regional load balancers.
+	// internalHTTPSPurpose is its deprecated spelling.
+	proxyOnlyPurpose     = "REGIONAL_MANAGED_PROXY"
+	internalHTTPSPurpose = "INTERNAL_HTTPS_LOAD_BALANCER"
+	// activeRole is the role of the proxy-only subnet in use, the other
+	// one being a BACKUP to migrate to.
+	activeRole = "ACTIVE"
+	// sharedVIPPurpose lets the HTTP and HTTPS forwarding rules share an
+	// internal address.
+	sharedVIPPurpose = "SHARED_LOADBALANCER_VIP"
+
+	// maxRatePerEndpoint is the rate of the NEG backends. Like for the
+	// Ingresses of the gce class, the value doesn't matter as long as it's
+	// the same for all the backends.
+	maxRatePerEndpoint = 1
+	// healthCheckPath is the path probed on the serving port of the
+	// endpoints.
+	healthCheckPath = "/"
+)
+
+// class is a class of the Ingresses served by regional load balancers.
+type class struct {
+	// kind is the kind of the load balancer in the events.
+	kind string
+	// scheme is the load balancing scheme of the load balancers.
+	scheme string
+	// backendKind is the kind of the names of the backend services, which
+	// have a single scheme.
+	backendKind string
+	// internal load balancers get an internal address of the subnet of
+	// the cluster.
+	internal bool
+}
+
+// classes are the regional load balancers, keyed by Ingress class.
+var classes = map[string]*class{
+	annotations.GceInternalIngressClass:         {kind: "internal", scheme: "INTERNAL_MANAGED", backendKind: "bi", internal: true},
+	annotations.GceRegionalExternalIngressClass: {kind: "external", scheme: "EXTERNAL_MANAGED", backendKind: "be"},
+}
+
+// ingressClass returns the regional class of the given Ingress, nil if it
+// isn't served by a regional load balancer.
+func (p *Pool) ingressClass(ing *extensions.Ingress) *class {
+	return classes[p.cluster.IngressClass(ing)]
+}
+
+// Pool manages the regional load balancers of the Ingresses.
+type Pool struct {
+	cloud         LoadBalancers
+	firewalls     firewalls.Firewall
+	cluster       Cluster
+	tlsLoader     tls.TlsLoader
+	serviceLister cache.Indexer
+	namer         *utils.Namer
+}
+
+// NewPool returns a Pool of the load balancers of the given cloud, whose
+// firewall rules are managed through the given provider, whose certificates
+// are loaded by the given loader, and whose backends are the NEGs of the
+// Services of the given lister.
+func NewPool(cloud LoadBalancers, firewallProvider firewalls.Firewall, cluster Cluster, tlsLoader tls.TlsLoader, serviceLister cache.Indexer, namer *utils.Namer) *Pool {
+	return &Pool{cloud: cloud, firewalls: firewallProvider, cluster: cluster, tlsLoader: tlsLoader, serviceLister: serviceLister, namer: namer}
+}
+
+// backend is a target port of a Service, whose endpoints are in the NEGs of
+// the port.
+type backend struct {
+	namespace  string
+	service    string
+	targetPort string
+}
+
+// Ensure creates or updates the regional load balancer of the given Ingress,
+// whose firewall rule targets the given nodes, and returns its status.
+func (p *Pool) Ensure(ing *extensions.Ingress, nodeNames []string) (*api_v1.LoadBalancerStatus, error) {
+	cls := p.ingressClass(ing)
+	if cls == nil {
+		return nil, fmt.Errorf("the Ingress class %q isn't regional", p.cluster.IngressClass(ing))
+	}
+	if ing.Spec.Backend == nil {
+		return nil, fmt.Errorf("regional load balancers require a default backend, set spec.backend")
+	}
+	key := ing.Namespace + "/" + ing.Name
+	desc := utils.Description{IngressName: key, ClusterUID: p.namer.UID()}.String()
+	region := p.cloud.Region()
+	logging.ForIngress(key).V(2).Infof("Ensuring regional %v load balancer", cls.kind)
+
+	proxyRanges, err := p.proxyOnlyRanges()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.deleteOtherScheme(cls, ing); err != nil {
+		return nil, err
+	}
+	links := map[backend]string{}
+	backendLink := func(be extensions.IngressBackend) (string, error) {
+		b, err := p.resolveBackend(ing.Namespace, be)
+		if err != nil {
+			return "", err
+		}
+		if link, ok := links[b]; ok {
+			return link, nil
+		}
+		bs, err := p.ensureBackendService(cls, b)
+		if err != nil {
+			return "", err
+		}
+		links[b] = bs.SelfLink
+		return bs.SelfLink, nil
+	}
+	um, err := p.toUrlMap(ing, backendLink)
+	if err != nil {
+		return nil, err
+	}
+	um.Name = p.namer.RegionalL7(urlMapKind, ing.Namespace, ing.Name)
+	um.Description = desc
+	umLink, err := p.ensureUrlMap(um)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := sets.NewInt()
+	for b := range links {
+		ports.Insert(p.cluster.EndpointTargetPorts(b.namespace, b.service, b.targetPort)...)
+	}
+	fwName := p.namer.RegionalL7(firewallKind, ing.Namespace, ing.Name)
+	if ports.Len() == 0 {
+		// Without endpoints there is no port to open yet.
+		if err := utils.IgnoreHTTPNotFound(p.deleteFirewall(fwName)); err != nil {
+			return nil, err
+		}
+	} else if err := p.ensureFirewall(fwName, desc, append(firewalls.HealthCheckSrcRanges(), proxyRanges...), ports.List(), nodeNames); err != nil {
+		return nil, err
+	}
+
+	ip, err := p.ensureAddress(cls, ing, desc)
+	if err != nil {
+		return nil, err
+	}
+	httpName := p.namer.RegionalL7(httpForwardingRuleKind, ing.Namespace, ing.Name)
+	httpProxyName := p.namer.RegionalL7(httpProxyKind, ing.Namespace, ing.Name)
+	if annotations.IngAnnotations(ing.Annotations).AllowHTTP() {
+		proxy, err := p.ensureHTTPProxy(httpProxyName, desc, umLink)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.ensureForwardingRule(cls, httpName, desc, ip, "80", proxy.SelfLink); err != nil {
+			return nil, err
+		}
+	} else if err := p.deleteFrontend(httpName, httpProxyName, p.cloud.DeleteTargetHttpProxy); err != nil {
+		return nil, err
+	}
+	certs, err := p.ensureCertificates(ing, desc)
+	if err != nil {
+		return nil, err
+	}
+	httpsName := p.namer.RegionalL7(httpsForwardingRuleKind, ing.Namespace, ing.Name)
+	httpsProxyName := p.namer.RegionalL7(httpsProxyKind, ing.Namespace, ing.Name)
+	if len(certs) > 0 {
+		proxy, err := p.ensureHTTPSProxy(httpsProxyName, desc, umLink, certs)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.ensureForwardingRule(cls, httpsName, desc, ip, "443", proxy.SelfLink); err != nil {
+			return nil, err
+		}
+	} else if err := p.deleteFrontend(httpsName, httpsProxyName, p.cloud.DeleteTargetHttpsProxy); err != nil {
+		return nil, err
+	}
+	if err := p.deleteCertificates(key, certs); err != nil {
+		return nil, err
+	}
+	// The address of the controller is released once the forwarding rules
+	// moved to the static IP.
+	if annotations.IngAnnotations(ing.Annotations).RegionalStaticIPName() != "" {
+		if err := utils.IgnoreHTTPNotFound(p.cloud.DeleteAddress(p.namer.RegionalL7(addressKind, ing.Namespace, ing.Name), region)); err != nil {
+			return nil, err
+		}
+	}
+	return &api_v1.LoadBalancerStatus{Ingress: []api_v1.LoadBalancerIngress{{IP: ip}}}, nil
+}
+
+// Delete deletes the regional load balancer of the given Ingress, but its
+// backend services, shared with the other Ingresses and collected by
+// GCBackends. The resources which are already gone are ignored.
+func (p *Pool) Delete(ing *extensions.Ingress) error {
+	key := ing.Namespace + "/" + ing.Name
+	region := p.cloud.Region()
+	name := func(kind string) string { return p.namer.RegionalL7(kind, ing.Namespace, ing.Name) }
+	logging.ForIngress(key).V(2).Infof("Deleting regional load balancer")
+	// The forwarding rules go first, they use the target proxies, which
+	// use the url map and the certificates.
+	if err := p.deleteFrontend(name(httpForwardingRuleKind), name(httpProxyKind), p.cloud.DeleteTargetHttpProxy); err != nil {
+		return err
+	}
+	if err := p.deleteFrontend(name(httpsForwardingRuleKind), name(httpsProxyKind), p.cloud.DeleteTargetHttpsProxy); err != nil {
+		return err
+	}
+	for _, del := range []func() error{
+		func() error { return p.cloud.DeleteUrlMap(name(urlMapKind), region) },
+		func() error { return p.deleteCertificates(key, nil) },
+		func() error { return p.cloud.DeleteAddress(name(addressKind), region) },
+		func() error { return p.deleteFirewall(name(firewallKind)) },
+	} {
+		if err := utils.IgnoreHTTPNotFound(del()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GCBackends deletes the regional backend services of the cluster, and their
+// health checks, which none of the given Ingresses uses. The backend services
+// still used by a url map, eg: of an Ingress whose last sync failed, are
+// collected later.
+func (p *Pool) GCBackends(ings []*extensions.Ingress) error {
+	region := p.cloud.Region()
+	wanted := sets.NewString()
+	for _, ing := range ings {
+		cls := p.ingressClass(ing)
+		if cls == nil || ing.DeletionTimestamp != nil {
+			continue
+		}
+		for _, be := range ingressBackends(ing) {
+			if b, err := p.resolveBackend(ing.Namespace, be); err == nil {
+				wanted.Insert(p.namer.RegionalL7Backend(cls.backendKind, b.namespace, b.service, b.targetPort))
+			}
+		}
+	}
+	services, err := p.cloud.ListBackendServices(region)
+	if err != nil {
+		return err
+	}
+	for _, bs := range services {
+		if wanted.Has(bs.Name) || !p.isBackend(bs.Name) {
+			continue
+		}
+		logging.V(2).Infof("Deleting regional backend service %v", bs.Name)
+		err := p.cloud.DeleteBackendService(bs.Name, region)
+		if utils.IsInUsedByError(err) {
+			logging.V(2).Infof("Keeping regional backend service %v: %v", bs.Name, err)
+			continue
+		}
+		if err := utils.IgnoreHTTPNotFound(err); err != nil {
+			return err
+		}
+		if err := utils.IgnoreHTTPNotFound(p.cloud.DeleteHealthCheck(bs.Name, region)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isBackend returns true if the given name is a regional backend service of
+// one of the classes.
+func (p *Pool) isBackend(name string) bool {
+	for _, cls := range classes {
+		if p.namer.IsRegionalL7Backend(cls.backendKind, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ingressBackends returns the backends of the given Ingress, its default
+// backend first.
+func ingressBackends(ing *extensions.Ingress) []extensions.IngressBackend {
+	var backends []extensions.IngressBackend
+	if ing.Spec.Backend != nil {
+		backends = append(backends, *ing.Spec.Backend)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			backends = append(backends, path.Backend)
+		}
+	}
+	return backends
+}
+
+// resolveBackend returns the target port of the Service port of the given
+// backend of an Ingress of the given namespace.
+func (p *Pool) resolveBackend(namespace string, be extensions.IngressBackend) (backend, error) {
+	obj, exists, err := p.serviceLister.GetByKey(namespace + "/" + be.ServiceName)
+	if err != nil {
+		return backend{}, err
+	}
+	if !exists {
+		return backend{}, fmt.Errorf("Service %v/%v not found", namespace, be.ServiceName)
+	}
+	svc := obj.(*api_v1.Service)
+	for _, port := range svc.Spec.Ports {
+		if (be.ServicePort.Type == intstr.Int && port.Port == be.ServicePort.IntVal) ||
+			(be.ServicePort.Type == intstr.String && port.Name == be.ServicePort.StrVal) {
+			return backend{namespace: namespace, service: be.ServiceName, targetPort: port.TargetPort.String()}, nil
+		}
+	}
+	return backend{}, fmt.Errorf("Service %v/%v has no port %v", namespace, be.ServiceName, be.ServicePort.String())
+}
+
+// toUrlMap returns the url map of the rules of the given Ingress, whose
+// backends are linked by backendLink. Its default service is the default
+// backend of the Ingress. The hosts and paths are sorted, so that an
+// unchanged url map isn't updated.
+func (p *Pool) toUrlMap(ing *extensions.Ingress, backendLink func(extensions.IngressBackend) (string, error)) (*loadbalancers.UrlMap, error) {
+	defaultService, err := backendLink(*ing.Spec.Backend)
+	if err != nil {
+		return nil, err
+	}
+	// An Ingress which can't be read has its paths matched as written.
+	pathTypes, err := p.cluster.IngressPathTypes(ing)
+	if err != nil {
+		logging.ForIngress(ing.Namespace+"/"+ing.Name).Warningf("Ignoring the pathType of the paths: %v", err)
+	}
+	hostPaths := map[string]map[string]string{}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		host := rule.Host
+		if host == "" {
+			host = loadbalancers.DefaultHost
+		}
+		// If multiple rules share a host, the last one wins.
+		paths := map[string]string{}
+		for _, path := range rule.HTTP.Paths {
+			gcePaths, err := utils.GCEPaths(path.Path, pathTypes[rule.Host][path.Path])
+			if err != nil {
+				return nil, fmt.Errorf("path %q of host %q: %v", path.Path, rule.Host, err)
+			}
+			link, err := backendLink(path.Backend)
+			if err != nil {
+				return nil, err
+			}
+			for _, gcePath := range gcePaths {
+				if gcePath == "" {
+					gcePath = loadbalancers.DefaultPath
+				}
+				paths[gcePath] = link
+			}
+		}
+		hostPaths[host] = paths
+	}
+	um := &loadbalancers.UrlMap{DefaultService: defaultService}
+	var hosts []string
+	for host := range hostPaths {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		pm := &loadbalancers.PathMatcher{Name: pathMatcherName(host), DefaultService: defaultService}
+		var paths []string
+		for path := range hostPaths[host] {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			pm.PathRules = append(pm.PathRules, &loadbalancers.PathRule{Paths: []string{path}, Service: hostPaths[host][path]})
+		}
+		um.HostRules = append(um.HostRules, &loadbalancers.HostRule{Hosts: []string{host}, PathMatcher: pm.Name})
+		um.PathMatchers = append(um.PathMatchers, pm)
+	}
+	return um, nil
+}
+
+// pathMatcherName returns the name of the path matcher of the given host,
+// which may be a wildcard a name can't contain.
+func pathMatcherName(host string) string {
+	sum := md5.Sum([]byte(host))
+	return "host" + hex.EncodeToString(sum[:])
+}
+
+// ensureUrlMap creates or updates the given url map, and returns its link.
+func (p *Pool) ensureUrlMap(desired *loadbalancers.UrlMap) (string, error) {
+	region := p.cloud.Region()
+	existing, err := p.cloud.GetUrlMap(desired.Name, region)
+	if utils.IsNotFoundError(err) {
+		logging.V(2).Infof("Creating regional url map %v", desired.Name)
+		if err := p.cloud.CreateUrlMap(desired, region); err != nil {
+			return "", err
+		}
+		existing, err = p.cloud.GetUrlMap(desired.Name, region)
+	}
+	if err != nil {
+		return "", err
+	}
+	if existing.DefaultService == desired.DefaultService &&
+		reflect.DeepEqual(existing.HostRules, desired.HostRules) &&
+		reflect.DeepEqual(existing.PathMatchers, desired.PathMatchers) {
+		return existing.SelfLink, nil
+	}
+	logging.V(2).Infof("Updating regional url map %v", desired.Name)
+	desired.Fingerprint = existing.Fingerprint
+	if err := p.cloud.UpdateUrlMap(desired, region); err != nil {
+		return "", err
+	}
+	return existing.SelfLink, nil
+}
+
+// proxyOnlyRanges returns the ranges of the active proxy-only subnets of the
+// network of the cluster in its region, whose proxies connect to the
+// endpoints. One is required.
+func (p *Pool) proxyOnlyRanges() ([]string, error) {
+	region := p.cloud.Region()
+	subnets, err := p.cloud.ListSubnetworks(region)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []string
+	for _, subnet := range subnets {
+		if (subnet.Purpose == proxyOnlyPurpose || subnet.Purpose == internalHTTPSPurpose) && subnet.Role == activeRole && sameResource(subnet.Network, p.cloud.NetworkURL()) {
+			ranges = append(ranges, subnet.IpCidrRange)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no active proxy-only subnet in region %v of network %v, create a subnet with purpose %v", region, p.cloud.NetworkURL(), proxyOnlyPurpose)
+	}
+	return ranges, nil
+}
+
+// ensureHealthCheck creates or updates the regional health check of the
+// given name, probing the serving port of the endpoints.
+func (p *Pool) ensureHealthCheck(name, desc string) (*HealthCheck, error) {
+	region := p.cloud.Region()
+	desired := &HealthCheck{
+		Name:               name,
+		Description:        desc,
+		Type:               "HTTP",
+		CheckIntervalSec:   int64(healthchecks.DefaultNEGHealthCheckInterval.Seconds()),
+		TimeoutSec:         int64(healthchecks.DefaultNEGTimeout.Seconds()),
+		HealthyThreshold:   healthchecks.DefaultHealthyThreshold,
+		UnhealthyThreshold: healthchecks.DefaultNEGUnhealthyThreshold,
+		HttpHealthCheck: &HTTPHealthCheck{
+			PortSpecification: healthchecks.UseServingPortSpecification,
+			RequestPath:       healthCheckPath,
+		},
+	}
+	existing, err := p.cloud.GetHealthCheck(name, region)
+	switch {
+	case utils.IsNotFoundError(err):
+		logging.V(2).Infof("Creating regional health check %v", name)
+		if err := p.cloud.CreateHealthCheck(desired, region); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case existing.HttpHealthCheck == nil || *existing.HttpHealthCheck != *desired.HttpHealthCheck:
+		logging.V(2).Infof("Updating regional health check %v", name)
+		if err := p.cloud.UpdateHealthCheck(desired, region); err != nil {
+			return nil, err
+		}
+	default:
+		return existing, nil
+	}
+	return p.cloud.GetHealthCheck(name, region)
+}
+
+// ensureBackendService creates or updates the regional backend service of
+// the given class of the given backend, balancing the requests between the
+// NEGs of the backend in the zones of the cluster.
+func (p *Pool) ensureBackendService(cls *class, b backend) (*BackendService, error) {
+	region := p.cloud.Region()
+	name := p.namer.RegionalL7Backend(cls.backendKind, b.namespace, b.service, b.targetPort)
+	desc := utils.Description{ServiceName: b.namespace + "/" + b.service, ServicePort: b.targetPort, ClusterUID: p.namer.UID()}.String()
+	negName := p.namer.NEG(b.namespace, b.service, b.targetPort)
+	zones, err := p.cluster.ListZones()
+	if err != nil {
+		return nil, err
+	}
+	var backends []*computealpha.Backend
+	groups := sets.NewString()
+	for _, zone := range zones {
+		neg, err := p.cloud.GetNetworkEndpointGroup(negName, zone)
+		if utils.IsNotFoundError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, &computealpha.Backend{
+			Group:              neg.SelfLink,
+			BalancingMode:      "RATE",
+			MaxRatePerEndpoint: maxRatePerEndpoint,
+			// Always send the scaler, GCE defaults it to 1 and 0 drains
+			// the NEG.
+			CapacityScaler:  1,
+			ForceSendFields: []string{"CapacityScaler"},
+		})
+		groups.Insert(neg.SelfLink)
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no NEG %v of target port %v of Service %v/%v, the Service needs the %v: \"true\" annotation", negName, b.targetPort, b.namespace, b.service, annotations.NetworkEndpointGroupAlphaAnnotation)
+	}
+	hc, err := p.ensureHealthCheck(name, desc)
+	if err != nil {
+		return nil, err
+	}
+	desired := &BackendService{
+		Name:                name,
+		Description:         desc,
+		Protocol:            "HTTP",
+		LoadBalancingScheme: cls.scheme,
+		HealthChecks:        []string{hc.SelfLink},
+		Backends:            backends,
+	}
+	existing, err := p.cloud.GetBackendService(name, region)
+	if utils.IsNotFoundError(err) {
+		logging.V(2).Infof("Creating regional backend service %v", name)
+		if err := p.cloud.CreateBackendService(desired, region); err != nil {
+			return nil, err
+		}
+		return p.cloud.GetBackendService(name, region)
+	}
+	if err != nil {
+		return nil, err
+	}
+	existingGroups := sets.NewString()
+	for _, be := range existing.Backends {
+		existingGroups.Insert(be.Group)
+	}
+	if existing.LoadBalancingScheme == cls.scheme && existing.Protocol == desired.Protocol &&
+		len(existing.HealthChecks) == 1 && sameResource(existing.HealthChecks[0], hc.SelfLink) && existingGroups.Equal(groups) {
+		return existing, nil
+	}
+	logging.V(2).Infof("Updating regional backend service %v", name)
+	desired.Fingerprint = existing.Fingerprint
+	if err := p.cloud.UpdateBackendService(desired, region); err != nil {
+		return nil, err
+	}
+	return p.cloud.GetBackendService(name, region)
+}
+
+// ensureFirewall creates or updates the firewall rule of the given name,
+// allowing the given source ranges to reach the given ports of the pods on
+// the given nodes.
+func (p *Pool) ensureFirewall(name, desc string, sourceRanges []string, ports []int, nodeNames []string) error {
+	tags, err := p.firewalls.GetNodeTags(nodeNames)
+	if err != nil {
+		return err
+	}
+	var portStrings []string
+	for _, port := range ports {
+		portStrings = append(portStrings, strconv.Itoa(port))
+	}
+	desired := &firewalls.FirewallRule{
+		Name:         name,
+		Description:  desc,
+		Network:      p.firewalls.NetworkURL(),
+		SourceRanges: sourceRanges,
+		TargetTags:   tags,
+		Allowed:      []*firewalls.FirewallAllowed{{IPProtocol: "tcp", Ports: portStrings}},
+	}
+	existing, err := p.firewalls.GetFirewall(name)
+	if utils.IsNotFoundError(err) {
+		logging.V(2).Infof("Creating firewall rule %v", name)
+		return p.firewallError(name, p.firewalls.CreateFirewall(desired))
+	}
+	if err != nil {
+		return err
+	}
+	if sets.NewString(existing.SourceRanges...).Equal(sets.NewString(sourceRanges...)) &&
+		sets.NewString(existing.TargetTags...).Equal(sets.NewString(tags...)) &&
+		len(existing.Allowed) == 1 && sets.NewString(existing.Allowed[0].Ports...).Equal(sets.NewString(portStrings...)) {
+		return nil
+	}
+	logging.V(2).Infof("Updating firewall rule %v", name)
+	return p.firewallError(name, p.firewalls.UpdateFirewall(desired))
+}
+
+// deleteFirewall deletes the firewall rule of the given name.
+func (p *Pool) deleteFirewall(name string) error {
+	return p.firewallError(name, p.firewalls.DeleteFirewall(name))
+}
+
+// firewallError explains the forbidden changes to the firewall rules of the
+// given name on a shared VPC, which a network admin of the host project
+// makes.
+func (p *Pool) firewallError(name string, err error) error {
+	if utils.IsForbiddenError(err) && p.firewalls.OnXPN() {
+		return fmt.Errorf("firewall rule %v must be changed by a network admin of project %v: %v", name, p.firewalls.NetworkProjectID(), err)
+	}
+	return err
+}
+
+// ensureAddress returns the IP of the forwarding rules of the given Ingress:
+// the regional static IP of its annotation, or an address reserved by the
+// controller.
+func (p *Pool) ensureAddress(cls *class, ing *extensions.Ingress, desc string) (string, error) {
+	region := p.cloud.Region()
+	if static := annotations.IngAnnotations(ing.Annotations).RegionalStaticIPName(); static != "" {
+		addr, err := p.cloud.GetAddress(static, region)
+		if err != nil {
+			return "", fmt.Errorf("failed to get regional static IP %v: %v", static, err)
+		}
+		return addr.Address, nil
+	}
+	name := p.namer.RegionalL7(addressKind, ing.Namespace, ing.Name)
+	existing, err := p.cloud.GetAddress(name, region)
+	if err == nil {
+		return existing.Address, nil
+	}
+	if !utils.IsNotFoundError(err) {
+		return "", err
+	}
+	desired := &Address{Name: name, Description: desc}
+	if cls.internal {
+		desired.AddressType = "INTERNAL"
+		desired.Purpose = sharedVIPPurpose
+		desired.Subnetwork = p.cloud.SubnetworkURL()
+	}
+	logging.V(2).Infof("Reserving regional address %v", name)
+	if err := p.cloud.CreateAddress(desired, region); err != nil {
+		return "", err
+	}
+	addr, err := p.cloud.GetAddress(name, region)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
+
+// ensureHTTPProxy creates the regional target HTTP proxy of the given name,
+// or points it to the given url map.
+func (p *Pool) ensureHTTPProxy(name, desc, umLink string) (*TargetHttpProxy, error) {
+	region := p.cloud.Region()
+	existing, err := p.cloud.GetTargetHttpProxy(name, region)
+	switch {
+	case utils.IsNotFoundError(err):
+		logging.V(2).Infof("Creating regional target HTTP proxy %v", name)
+		if err := p.cloud.CreateTargetHttpProxy(&TargetHttpProxy{Name: name, Description: desc, UrlMap: umLink}, region); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case !sameResource(existing.UrlMap, umLink):
+		logging.V(2).Infof("Setting the url map of regional target HTTP proxy %v", name)
+		if err := p.cloud.SetUrlMapForTargetHttpProxy(name, region, umLink); err != nil {
+			return nil, err
+		}
+	default:
+		return existing, nil
+	}
+	return p.cloud.GetTargetHttpProxy(name, region)
+}
+
+// ensureHTTPSProxy creates the regional target HTTPS proxy of the given name,
+// or points it to the given url map and certificates.
+func (p *Pool) ensureHTTPSProxy(name, desc, umLink string, certs []string) (*TargetHttpsProxy, error) {
+	region := p.cloud.Region()
+	existing, err := p.cloud.GetTargetHttpsProxy(name, region)
+	if utils.IsNotFoundError(err) {
+		logging.V(2).Infof("Creating regional target HTTPS proxy %v", name)
+		if err := p.cloud.CreateTargetHttpsProxy(&TargetHttpsProxy{Name: name, Description: desc, UrlMap: umLink, SslCertificates: certs}, region); err != nil {
+			return nil, err
+		}
+		return p.cloud.GetTargetHttpsProxy(name, region)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !sameResource(existing.UrlMap, umLink) {
+		logging.V(2).Infof("Setting the url map of regional target HTTPS proxy %v", name)
+		if err := p.cloud.SetUrlMapForTargetHttpsProxy(name, region, umLink); err != nil {
+			return nil, err
+		}
+	}
+	if !sameResources(existing.SslCertificates, certs) {
+		logging.V(2).Infof("Setting the certificates of regional target HTTPS proxy %v", name)
+		if err := p.cloud.SetSslCertificatesForTargetHttpsProxy(name, region, certs); err != nil {
+			return nil, err
+		}
+	}
+	return p.cloud.GetTargetHttpsProxy(name, region)
+}
+
+// ensureForwardingRule creates the regional forwarding rule of the given
+// name, forwarding the given port of the given IP to the given target proxy,
+// or recreates it if it changed.
+func (p *Pool) ensureForwardingRule(cls *class, name, desc, ip, port, target string) error {
+	region := p.cloud.Region()
+	desired := &ForwardingRule{
+		Name:                name,
+		Description:         desc,
+		IPAddress:           ip,
+		IPProtocol:          "TCP",
+		PortRange:           port + "-" + port,
+		LoadBalancingScheme: cls.scheme,
+		Network:             p.cloud.NetworkURL(),
+		Target:              target,
+	}
+	if cls.internal {
+		desired.Subnetwork = p.cloud.SubnetworkURL()
+	}
+	existing, err := p.cloud.GetForwardingRule(name, region)
+	if err != nil && !utils.IsNotFoundError(err) {
+		return err
+	}
+	if err == nil {
+		if existing.IPAddress == desired.IPAddress && existing.PortRange == desired.PortRange &&
+			existing.LoadBalancingScheme == desired.LoadBalancingScheme && sameResource(existing.Target, desired.Target) {
+			return nil
+		}
+		logging.V(2).Infof("Recreating regional forwarding rule %v", name)
+		if err := p.cloud.DeleteForwardingRule(name, region); err != nil {
+			return err
+		}
+	} else {
+		logging.V(2).Infof("Creating regional forwarding rule %v", name)
+	}
+	return p.cloud.CreateForwardingRule(desired, region)
+}
+
+// deleteOtherScheme deletes the load balancer of the given Ingress if it was
+// created for another class, whose forwarding rules and address can't be
+// reused: the Ingress switched between internal and external.
+func (p *Pool) deleteOtherScheme(cls *class, ing *extensions.Ingress) error {
+	region := p.cloud.Region()
+	other := false
+	for _, kind := range []string{httpForwardingRuleKind, httpsForwardingRuleKind} {
+		rule, err := p.cloud.GetForwardingRule(p.namer.RegionalL7(kind, ing.Namespace, ing.Name), region)
+		if err != nil && !utils.IsNotFoundError(err) {
+			return err
+		}
+		if err == nil && rule.LoadBalancingScheme != cls.scheme {
+			other = true
+		}
+	}
+	addr, err := p.cloud.GetAddress(p.namer.RegionalL7(addressKind, ing.Namespace, ing.Name), region)
+	if err != nil && !utils.IsNotFoundError(err) {
+		return err
+	}
+	if err == nil && (addr.AddressType == "INTERNAL") != cls.internal {
+		other = true
+	}
+	if !other {
+		return nil
+	}
+	logging.ForIngress(ing.Namespace+"/"+ing.Name).V(2).Infof("Deleting the regional load balancer of another scheme than %v", cls.scheme)
+	return p.Delete(ing)
+}
+
+// deleteFrontend deletes the given forwarding rule, then the given target
+// proxy, through deleteProxy.
+func (p *Pool) deleteFrontend(ruleName, proxyName string, deleteProxy func(name, region string) error) error {
+	region := p.cloud.Region()
+	if err := utils.IgnoreHTTPNotFound(p.cloud.DeleteForwardingRule(ruleName, region)); err != nil {
+		return err
+	}
+	return utils.IgnoreHTTPNotFound(deleteProxy(proxyName, region))
+}
+
+// ensureCertificates returns the links of the regional SSL certificates of
+// the given Ingress, in order: those of its TLS Secrets, created if needed,
+// then its pre-shared certificates.
+func (p *Pool) ensureCertificates(ing *extensions.Ingress, desc string) ([]string, error) {
+	region := p.cloud.Region()
+	certs, err := p.tlsLoader.Load(ing)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, c := range certs {
+		name := p.namer.RegionalL7Cert(ing.Namespace, ing.Name, c.Cert+"\n"+c.Key+"\n"+c.Chain)
+		existing, err := p.cloud.GetSslCertificate(name, region)
+		if utils.IsNotFoundError(err) {
+			logging.V(2).Infof("Creating regional SSL certificate %v of Secret %v", name, c.Secret)
+			if err := p.cloud.CreateSslCertificate(&SslCertificate{Name: name, Description: desc, Certificate: c.Cert, PrivateKey: c.Key}, region); err != nil {
+				return nil, err
+			}
+			existing, err = p.cloud.GetSslCertificate(name, region)
+		}
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, existing.SelfLink)
+	}
+	for _, name := range strings.Split(annotations.IngAnnotations(ing.Annotations).UseNamedTLS(), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		cert, err := p.cloud.GetSslCertificate(name, region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get regional pre-shared certificate %v: %v", name, err)
+		}
+		links = append(links, cert.SelfLink)
+	}
+	return links, nil
+}
+
+// deleteCertificates deletes the regional SSL certificates created for the
+// Ingress of the given key, but those of the given links.
+func (p *Pool) deleteCertificates(key string, keep []string) error {
+	region := p.cloud.Region()
+	certs, err := p.cloud.ListSslCertificates(region)
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		desc, err := utils.ParseDescription(cert.Description)
+		if err != nil || desc.IngressName != key || desc.ClusterUID != p.namer.UID() {
+			continue
+		}
+		kept := false
+		for _, link := range keep {
+			kept = kept || sameResource(link, cert.SelfLink)
+		}
+		if kept {
+			continue
+		}
+		logging.V(2).Infof("Deleting regional SSL certificate %v", cert.Name)
+		if err := utils.IgnoreHTTPNotFound(p.cloud.DeleteSslCertificate(cert.Name, region)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameResource returns true if the given links refer to the same resource,
+// eg: a full URL and a partial one.
+func sameResource(a, b string) bool {
+	return resourcePath(a) == resourcePath(b)
+}
+
+// sameResources returns true if the given lists of links refer to the same
+// resources, in the same order.
+func sameResources(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameResource(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// resourcePath returns the path of the resource of the given link from its
+// project, without the API endpoint and version.
+func resourcePath(link string) string {
+	if i := strings.Index(link, "projects/"); i >= 0 {
+		return link[i:]
+	}
+	return link
+}
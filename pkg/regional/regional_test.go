@@ -0,0 +1,297 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package regional
+
+import (
+	"strings"
+	"testing"
+
+	computealpha "google.golang.org/api/compute/v0.alpha"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/tls"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+const (
+	testRegion  = "us-central1"
+	testNetwork = "projects/p/global/networks/net"
+)
+
+func newTestPool() (*Pool, *FakeLoadBalancers, firewalls.Firewall) {
+	cloud := NewFakeLoadBalancers(testRegion, testNetwork, "projects/p/regions/us-central1/subnetworks/default")
+	cloud.Subnetworks = []*Subnetwork{
+		{Name: "default", Network: testNetwork, IpCidrRange: "10.128.0.0/20"},
+		{Name: "proxy-only", Network: testNetwork, IpCidrRange: "10.129.0.0/23", Purpose: proxyOnlyPurpose, Role: activeRole},
+		{Name: "backup", Network: testNetwork, IpCidrRange: "10.130.0.0/23", Purpose: proxyOnlyPurpose, Role: "BACKUP"},
+	}
+	fw := firewalls.NewFakeFirewallsProvider(false, false)
+	cluster := &FakeCluster{Zones: []string{"us-central1-a", "us-central1-b"}}
+	tlsLoader := &tls.FakeTLSSecretLoader{FakeCerts: map[string]*loadbalancers.TLSCerts{
+		"secret": {Cert: "cert", Key: "key", Secret: "secret"},
+	}}
+	services := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"default", "app"} {
+		services.Add(&api_v1.Service{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: api_v1.ServiceSpec{Ports: []api_v1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+			}},
+		})
+	}
+	pool := NewPool(cloud, fw, cluster, tlsLoader, services, utils.NewNamer("uid", "fw"))
+	for _, name := range []string{"default", "app"} {
+		for _, zone := range cluster.Zones {
+			cloud.AddNEG(pool.namer.NEG("default", name, "8080"), zone)
+		}
+	}
+	return pool, cloud, fw
+}
+
+func newInternalIngress() *extensions.Ingress {
+	return &extensions.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "ing",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.IngressClassKey: annotations.GceInternalIngressClass},
+		},
+		Spec: extensions.IngressSpec{
+			Backend: &extensions.IngressBackend{ServiceName: "default", ServicePort: intstr.FromInt(80)},
+			Rules: []extensions.IngressRule{{
+				Host: "foo.example.com",
+				IngressRuleValue: extensions.IngressRuleValue{HTTP: &extensions.HTTPIngressRuleValue{
+					Paths: []extensions.HTTPIngressPath{
+						{Path: "/app", Backend: extensions.IngressBackend{ServiceName: "app", ServicePort: intstr.FromString("http")}},
+					},
+				}},
+			}},
+		},
+	}
+}
+
+func TestEnsureDelete(t *testing.T) {
+	pool, cloud, fw := newTestPool()
+	ing := newInternalIngress()
+	ing.Spec.TLS = []extensions.IngressTLS{{SecretName: "secret"}}
+	name := func(kind string) string { return pool.namer.RegionalL7(kind, ing.Namespace, ing.Name) }
+
+	status, err := pool.Ensure(ing, []string{"node-1"})
+	if err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != "10.0.0.1" {
+		t.Errorf("Ensure() = %+v, want IP 10.0.0.1", status)
+	}
+	addr := cloud.Addresses[name(addressKind)]
+	if addr == nil || addr.AddressType != "INTERNAL" || addr.Purpose != sharedVIPPurpose {
+		t.Errorf("address = %+v, want a shared internal address", addr)
+	}
+	appBackend := pool.namer.RegionalL7Backend("bi", "default", "app", "8080")
+	bs := cloud.BackendServices[appBackend]
+	if bs == nil || bs.LoadBalancingScheme != "INTERNAL_MANAGED" || len(bs.Backends) != 2 || bs.Backends[0].BalancingMode != "RATE" {
+		t.Errorf("backend service %v = %+v, want an INTERNAL_MANAGED backend service of 2 NEGs", appBackend, bs)
+	}
+	if hc := cloud.HealthChecks[appBackend]; hc == nil || hc.HttpHealthCheck.PortSpecification != "USE_SERVING_PORT" {
+		t.Errorf("health check %v = %+v, want a health check of the serving port", appBackend, hc)
+	}
+	um := cloud.UrlMaps[name(urlMapKind)]
+	if um == nil || um.DefaultService != cloud.BackendServices[pool.namer.RegionalL7Backend("bi", "default", "default", "8080")].SelfLink ||
+		len(um.HostRules) != 1 || um.HostRules[0].Hosts[0] != "foo.example.com" ||
+		len(um.PathMatchers[0].PathRules) != 1 || um.PathMatchers[0].PathRules[0].Service != bs.SelfLink {
+		t.Errorf("url map = %+v, want the default backend and /app of foo.example.com", um)
+	}
+	for _, kind := range []string{httpForwardingRuleKind, httpsForwardingRuleKind} {
+		rule := cloud.ForwardingRules[name(kind)]
+		if rule == nil || rule.IPAddress != "10.0.0.1" || rule.LoadBalancingScheme != "INTERNAL_MANAGED" || rule.Subnetwork != cloud.SubnetworkURL() {
+			t.Errorf("forwarding rule %v = %+v, want an INTERNAL_MANAGED rule of 10.0.0.1 in the subnet of the cluster", name(kind), rule)
+		}
+	}
+	proxy := cloud.TargetHttpsProxies[name(httpsProxyKind)]
+	if proxy == nil || len(proxy.SslCertificates) != 1 || cloud.SslCertificates[pool.namer.RegionalL7Cert("default", "ing", "cert\nkey\n")] == nil {
+		t.Errorf("target HTTPS proxy = %+v, want the certificate of the secret", proxy)
+	}
+	rule, err := fw.GetFirewall(name(firewallKind))
+	if err != nil {
+		t.Fatalf("GetFirewall() = %v", err)
+	}
+	if ranges := strings.Join(rule.SourceRanges, ","); !strings.Contains(ranges, "10.129.0.0/23") || strings.Contains(ranges, "10.130.0.0/23") {
+		t.Errorf("firewall source ranges = %v, want the active proxy-only subnet", rule.SourceRanges)
+	}
+	if ports := rule.Allowed[0].Ports; len(ports) != 1 || ports[0] != "8080" {
+		t.Errorf("firewall ports = %v, want 8080", ports)
+	}
+
+	// A new certificate replaces the old one.
+	pool.tlsLoader.(*tls.FakeTLSSecretLoader).FakeCerts["secret"] = &loadbalancers.TLSCerts{Cert: "cert2", Key: "key2"}
+	if _, err := pool.Ensure(ing, []string{"node-1"}); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if len(cloud.SslCertificates) != 1 || cloud.SslCertificates[pool.namer.RegionalL7Cert("default", "ing", "cert2\nkey2\n")] == nil {
+		t.Errorf("SSL certificates = %v, want the new certificate only", cloud.SslCertificates)
+	}
+
+	if err := pool.Delete(ing); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if len(cloud.ForwardingRules)+len(cloud.TargetHttpProxies)+len(cloud.TargetHttpsProxies)+len(cloud.UrlMaps)+len(cloud.SslCertificates)+len(cloud.Addresses) != 0 {
+		t.Errorf("resources left after Delete(): %+v", cloud)
+	}
+	if _, err := fw.GetFirewall(name(firewallKind)); !utils.IsNotFoundError(err) {
+		t.Errorf("GetFirewall() = %v, want not found", err)
+	}
+	// The backend services are collected once no Ingress uses them.
+	if err := pool.GCBackends(nil); err != nil {
+		t.Fatalf("GCBackends() = %v", err)
+	}
+	if len(cloud.BackendServices)+len(cloud.HealthChecks) != 0 {
+		t.Errorf("backend services %v and health checks %v left", cloud.BackendServices, cloud.HealthChecks)
+	}
+}
+
+func TestEnsureExternal(t *testing.T) {
+	pool, cloud, _ := newTestPool()
+	ing := newInternalIngress()
+	ing.Annotations[annotations.IngressClassKey] = annotations.GceRegionalExternalIngressClass
+	if _, err := pool.Ensure(ing, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	addr := cloud.Addresses[pool.namer.RegionalL7(addressKind, "default", "ing")]
+	if addr == nil || addr.AddressType != "" || addr.Subnetwork != "" {
+		t.Errorf("address = %+v, want an external address", addr)
+	}
+	rule := cloud.ForwardingRules[pool.namer.RegionalL7(httpForwardingRuleKind, "default", "ing")]
+	if rule == nil || rule.LoadBalancingScheme != "EXTERNAL_MANAGED" || rule.Subnetwork != "" || rule.Network != testNetwork {
+		t.Errorf("forwarding rule = %+v, want an EXTERNAL_MANAGED rule of the network", rule)
+	}
+	bs := cloud.BackendServices[pool.namer.RegionalL7Backend("be", "default", "app", "8080")]
+	if bs == nil || bs.LoadBalancingScheme != "EXTERNAL_MANAGED" {
+		t.Errorf("backend service = %+v, want an EXTERNAL_MANAGED backend service", bs)
+	}
+
+	// The Ingress moves to the internal class: the backend services of
+	// both schemes coexist until the external ones are collected.
+	ing.Annotations[annotations.IngressClassKey] = annotations.GceInternalIngressClass
+	if _, err := pool.Ensure(ing, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if rule := cloud.ForwardingRules[pool.namer.RegionalL7(httpForwardingRuleKind, "default", "ing")]; rule.LoadBalancingScheme != "INTERNAL_MANAGED" {
+		t.Errorf("forwarding rule = %+v, want an INTERNAL_MANAGED rule", rule)
+	}
+	if addr := cloud.Addresses[pool.namer.RegionalL7(addressKind, "default", "ing")]; addr.AddressType != "INTERNAL" {
+		t.Errorf("address = %+v, want an internal address", addr)
+	}
+	if err := pool.GCBackends([]*extensions.Ingress{ing}); err != nil {
+		t.Fatalf("GCBackends() = %v", err)
+	}
+	if len(cloud.BackendServices) != 2 || cloud.BackendServices[pool.namer.RegionalL7Backend("bi", "default", "app", "8080")] == nil {
+		t.Errorf("backend services = %v, want the internal ones only", cloud.BackendServices)
+	}
+}
+
+func TestEnsureErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		modify func(*FakeLoadBalancers, *extensions.Ingress)
+		want   string
+	}{
+		{
+			desc:   "no default backend",
+			modify: func(_ *FakeLoadBalancers, ing *extensions.Ingress) { ing.Spec.Backend = nil },
+			want:   "default backend",
+		},
+		{
+			desc:   "no proxy-only subnet",
+			modify: func(cloud *FakeLoadBalancers, _ *extensions.Ingress) { cloud.Subnetworks = cloud.Subnetworks[:1] },
+			want:   "proxy-only subnet",
+		},
+		{
+			desc: "no NEG",
+			modify: func(cloud *FakeLoadBalancers, _ *extensions.Ingress) {
+				cloud.NEGs = map[string]*computealpha.NetworkEndpointGroup{}
+			},
+			want: annotations.NetworkEndpointGroupAlphaAnnotation,
+		},
+		{
+			desc: "unknown Service port",
+			modify: func(_ *FakeLoadBalancers, ing *extensions.Ingress) {
+				ing.Spec.Backend.ServicePort = intstr.FromInt(443)
+			},
+			want: "has no port 443",
+		},
+	} {
+		pool, cloud, _ := newTestPool()
+		ing := newInternalIngress()
+		tc.modify(cloud, ing)
+		if _, err := pool.Ensure(ing, nil); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Ensure() = %v, want an error with %q", tc.desc, err, tc.want)
+		}
+	}
+}
+
+func TestEnsureStaticIPAndHTTPOnly(t *testing.T) {
+	pool, cloud, _ := newTestPool()
+	ing := newInternalIngress()
+	if _, err := pool.Ensure(ing, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if len(cloud.TargetHttpsProxies) != 0 || len(cloud.TargetHttpProxies) != 1 {
+		t.Errorf("target proxies = %v and %v, want the HTTP proxy only", cloud.TargetHttpProxies, cloud.TargetHttpsProxies)
+	}
+
+	// The Ingress moves to a static IP, the address of the controller is
+	// released.
+	cloud.CreateAddress(&Address{Name: "static", Address: "10.0.0.100"}, testRegion)
+	ing.Annotations[annotations.RegionalStaticIPNameKey] = "static"
+	status, err := pool.Ensure(ing, nil)
+	if err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	if status.Ingress[0].IP != "10.0.0.100" || cloud.ForwardingRules[pool.namer.RegionalL7(httpForwardingRuleKind, "default", "ing")].IPAddress != "10.0.0.100" {
+		t.Errorf("Ensure() = %+v, want the static IP", status)
+	}
+	if len(cloud.Addresses) != 1 {
+		t.Errorf("addresses = %v, want the static one only", cloud.Addresses)
+	}
+}
+
+func TestToUrlMapPathTypes(t *testing.T) {
+	pool, _, _ := newTestPool()
+	ing := newInternalIngress()
+	ing.Spec.Rules[0].HTTP.Paths[0].Path = "/app/"
+	pool.cluster.(*FakeCluster).PathTypes = map[string]map[string]map[string]string{
+		"default/ing": {"foo.example.com": {"/app/": utils.PathTypePrefix}},
+	}
+	um, err := pool.toUrlMap(ing, func(be extensions.IngressBackend) (string, error) { return be.ServiceName, nil })
+	if err != nil {
+		t.Fatalf("toUrlMap() = %v", err)
+	}
+	var paths []string
+	for _, rule := range um.PathMatchers[0].PathRules {
+		paths = append(paths, rule.Paths...)
+	}
+	if got := strings.Join(paths, ","); got != "/app,/app/*" {
+		t.Errorf("paths = %v, want /app,/app/*", got)
+	}
+}
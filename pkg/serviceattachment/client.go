@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serviceattachment
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// APIServerClient reads and writes the ServiceAttachments in the Kubernetes
+// apiserver, through the generic REST client since there is no generated
+// client for them.
+type APIServerClient struct {
+	Client kubernetes.Interface
+}
+
+// Ensure that APIServerClient implements Client.
+var _ Client = &APIServerClient{}
+
+// path returns the path of the given ServiceAttachment, of the collection if
+// name is empty. namespace is empty for the collection of all namespaces.
+func path(namespace, name string, subresources ...string) []string {
+	segments := []string{"/apis", GroupName, Version}
+	if namespace != "" {
+		segments = append(segments, "namespaces", namespace)
+	}
+	segments = append(segments, Resource)
+	if name != "" {
+		segments = append(segments, name)
+	}
+	return append(segments, subresources...)
+}
+
+func (c *APIServerClient) restClient() (rest.Interface, error) {
+	restClient := c.Client.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, fmt.Errorf("no REST client for %v", Resource)
+	}
+	return restClient, nil
+}
+
+// List implements Client.
+func (c *APIServerClient) List(namespace string) ([]ServiceAttachment, error) {
+	restClient, err := c.restClient()
+	if err != nil {
+		return nil, err
+	}
+	data, err := restClient.Get().AbsPath(path(namespace, "")...).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v: %v", Resource, err)
+	}
+	list := &ServiceAttachmentList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("failed to decode %v: %v", Resource, err)
+	}
+	return list.Items, nil
+}
+
+// Update implements Client.
+func (c *APIServerClient) Update(sa *ServiceAttachment) (*ServiceAttachment, error) {
+	restClient, err := c.restClient()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(withTypeMeta(sa))
+	if err != nil {
+		return nil, err
+	}
+	logging.V(3).Infof("Updating %v %v/%v", Kind, sa.Namespace, sa.Name)
+	data, err = restClient.Put().AbsPath(path(sa.Namespace, sa.Name)...).SetHeader("Content-Type", "application/json").Body(data).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update %v %v/%v: %v", Kind, sa.Namespace, sa.Name, err)
+	}
+	updated := &ServiceAttachment{}
+	if err := json.Unmarshal(data, updated); err != nil {
+		return nil, fmt.Errorf("failed to decode %v %v/%v: %v", Kind, sa.Namespace, sa.Name, err)
+	}
+	return updated, nil
+}
+
+// UpdateStatus implements Client.
+func (c *APIServerClient) UpdateStatus(sa *ServiceAttachment) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(withTypeMeta(sa))
+	if err != nil {
+		return err
+	}
+	logging.V(3).Infof("Updating the status of %v %v/%v", Kind, sa.Namespace, sa.Name)
+	if _, err := restClient.Put().AbsPath(path(sa.Namespace, sa.Name, "status")...).SetHeader("Content-Type", "application/json").Body(data).DoRaw(); err != nil {
+		return fmt.Errorf("failed to update the status of %v %v/%v: %v", Kind, sa.Namespace, sa.Name, err)
+	}
+	return nil
+}
+
+// withTypeMeta returns a copy of the given ServiceAttachment with its kind and
+// API version, which the apiserver requires.
+func withTypeMeta(sa *ServiceAttachment) *ServiceAttachment {
+	ret := *sa
+	ret.APIVersion = schema.GroupVersion{Group: GroupName, Version: Version}.String()
+	ret.Kind = Kind
+	return &ret
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serviceattachment
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/l4"
+	"k8s.io/ingress-gce/pkg/logging"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+// Controller reconciles the ServiceAttachments into PSC service attachments
+// of the internal load balancers of the pool, and reports their status.
+type Controller struct {
+	client     Client
+	kubeClient kubernetes.Interface
+	pool       *l4.Pool
+	namer      *utils.Namer
+	// namespace is the namespace of the ServiceAttachments, all of them if
+	// empty.
+	namespace string
+}
+
+// NewController returns a Controller of the ServiceAttachments of the given
+// namespace, of all namespaces if empty.
+func NewController(client Client, kubeClient kubernetes.Interface, pool *l4.Pool, namer *utils.Namer, namespace string) *Controller {
+	return &Controller{client: client, kubeClient: kubeClient, pool: pool, namer: namer, namespace: namespace}
+}
+
+// Run syncs the ServiceAttachments every period until stopCh is closed.
+func (c *Controller) Run(period time.Duration, stopCh <-chan struct{}) {
+	logging.Infof("Starting the ServiceAttachment controller, syncing every %v", period)
+	wait.Until(func() {
+		if err := c.Sync(); err != nil {
+			logging.Errorf("Failed to sync the ServiceAttachments: %v", err)
+		}
+	}, period, stopCh)
+}
+
+// Sync creates, updates and deletes the service attachments of all the
+// ServiceAttachments, and updates their status.
+func (c *Controller) Sync() error {
+	sas, err := c.client.List(c.namespace)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for i := range sas {
+		if err := c.sync(&sas[i]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// sync syncs the service attachment of the given ServiceAttachment. The
+// finalizer is placed first, so that the service attachment is deleted with
+// the ServiceAttachment.
+func (c *Controller) sync(sa *ServiceAttachment) error {
+	key := sa.Namespace + "/" + sa.Name
+	name := c.namer.ServiceAttachment(sa.Namespace, sa.Name)
+	if sa.DeletionTimestamp != nil {
+		if !hasFinalizer(sa) {
+			return nil
+		}
+		if err := c.pool.DeleteServiceAttachment(name); err != nil {
+			return fmt.Errorf("failed to delete the service attachment of %v %v: %v", Kind, key, err)
+		}
+		var finalizers []string
+		for _, f := range sa.Finalizers {
+			if f != FinalizerKey {
+				finalizers = append(finalizers, f)
+			}
+		}
+		sa.Finalizers = finalizers
+		_, err := c.client.Update(sa)
+		return err
+	}
+	if !hasFinalizer(sa) {
+		sa.Finalizers = append(sa.Finalizers, FinalizerKey)
+		var err error
+		if sa, err = c.client.Update(sa); err != nil {
+			return err
+		}
+	}
+
+	status := sa.Status
+	gceSA, err := c.ensure(name, sa)
+	if err != nil {
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionReady, false, sa.Generation, "SyncFailed", err.Error()))
+		err = fmt.Errorf("failed to sync %v %v: %v", Kind, key, err)
+	} else {
+		status.ServiceAttachmentURL = gceSA.SelfLink
+		status.ForwardingRuleURL = gceSA.TargetService
+		status.ConsumerForwardingRules = nil
+		for _, ep := range gceSA.ConnectedEndpoints {
+			status.ConsumerForwardingRules = append(status.ConsumerForwardingRules, ConsumerForwardingRule{
+				ForwardingRuleURL: ep.Endpoint,
+				PSCConnectionID:   ep.PscConnectionId,
+				Status:            ep.Status,
+			})
+		}
+		status.Conditions = setCondition(status.Conditions, newCondition(ConditionReady, true, sa.Generation, "Synced", ""))
+	}
+	if !reflect.DeepEqual(status, sa.Status) {
+		status.LastModifiedTimestamp = meta_v1.Now()
+		sa.Status = status
+		if updateErr := c.client.UpdateStatus(sa); updateErr != nil && err == nil {
+			err = updateErr
+		}
+	}
+	return err
+}
+
+// ensure validates the spec of the given ServiceAttachment, and ensures the
+// service attachment of the given name.
+func (c *Controller) ensure(name string, sa *ServiceAttachment) (*l4.ServiceAttachment, error) {
+	desired, err := translate(name, sa)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := c.kubeClient.CoreV1().Services(sa.Namespace).Get(sa.Spec.ResourceRef.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if svc.Spec.Type != api_v1.ServiceTypeLoadBalancer || !annotations.SvcAnnotations(svc.Annotations).ILB() {
+		return nil, fmt.Errorf("no internal load balancer requested by Service %v/%v", svc.Namespace, svc.Name)
+	}
+	return c.pool.EnsureServiceAttachment(desired, svc)
+}
+
+// translate returns the service attachment of the given name of the given
+// ServiceAttachment, with the names of its NAT subnets.
+func translate(name string, sa *ServiceAttachment) (*l4.ServiceAttachment, error) {
+	spec := sa.Spec
+	if spec.ResourceRef.Name == "" || (spec.ResourceRef.Kind != "" && spec.ResourceRef.Kind != "Service") || spec.ResourceRef.APIGroup != "" {
+		return nil, fmt.Errorf("resourceRef must reference a Service by name")
+	}
+	if len(spec.NATSubnets) == 0 {
+		return nil, fmt.Errorf("natSubnets must list at least one subnet")
+	}
+	pref := spec.ConnectionPreference
+	switch pref {
+	case "":
+		pref = AcceptAutomatic
+	case AcceptAutomatic, AcceptManual:
+	default:
+		return nil, fmt.Errorf("connectionPreference must be %v or %v", AcceptAutomatic, AcceptManual)
+	}
+	if pref != AcceptManual && (len(spec.ConsumerAllowList) > 0 || len(spec.ConsumerRejectList) > 0) {
+		return nil, fmt.Errorf("consumerAllowList and consumerRejectList require connectionPreference %v", AcceptManual)
+	}
+	desired := &l4.ServiceAttachment{
+		Name:                 name,
+		Description:          utils.Description{ServiceName: sa.Namespace + "/" + sa.Spec.ResourceRef.Name}.String(),
+		ConnectionPreference: pref,
+		NatSubnets:           append([]string(nil), spec.NATSubnets...),
+		EnableProxyProtocol:  spec.ProxyProtocol,
+		ConsumerRejectLists:  append([]string(nil), spec.ConsumerRejectList...),
+	}
+	for _, consumer := range spec.ConsumerAllowList {
+		if consumer.Project == "" {
+			return nil, fmt.Errorf("the projects of consumerAllowList must be set")
+		}
+		if consumer.ConnectionLimit < 0 {
+			return nil, fmt.Errorf("the connectionLimit of project %v must not be negative", consumer.Project)
+		}
+		desired.ConsumerAcceptLists = append(desired.ConsumerAcceptLists, &l4.ServiceAttachmentConsumerProjectLimit{
+			ProjectIdOrNum:  consumer.Project,
+			ConnectionLimit: consumer.ConnectionLimit,
+		})
+	}
+	return desired, nil
+}
+
+// hasFinalizer returns true if the service attachment of the given
+// ServiceAttachment may exist.
+func hasFinalizer(sa *ServiceAttachment) bool {
+	for _, f := range sa.Finalizers {
+		if f == FinalizerKey {
+			return true
+		}
+	}
+	return false
+}
+
+// newCondition returns a condition of the given type and status, as of now.
+func newCondition(condType string, status bool, generation int64, reason, message string) Condition {
+	s := api_v1.ConditionFalse
+	if status {
+		s = api_v1.ConditionTrue
+	}
+	return Condition{
+		Type:               condType,
+		Status:             string(s),
+		ObservedGeneration: generation,
+		LastTransitionTime: meta_v1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// setCondition sets the given condition in conditions, keeping the
+// transition time of the current condition of the same type if its status is
+// unchanged.
+func setCondition(conditions []Condition, cond Condition) []Condition {
+	res := append([]Condition(nil), conditions...)
+	for i := range res {
+		if res[i].Type != cond.Type {
+			continue
+		}
+		if res[i].Status == cond.Status {
+			cond.LastTransitionTime = res[i].LastTransitionTime
+		}
+		res[i] = cond
+		return res
+	}
+	return append(res, cond)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serviceattachment
+
+import (
+	"testing"
+
+	api_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"k8s.io/ingress-gce/pkg/annotations"
+	"k8s.io/ingress-gce/pkg/firewalls"
+	"k8s.io/ingress-gce/pkg/l4"
+	"k8s.io/ingress-gce/pkg/utils"
+)
+
+func newTestController(t *testing.T, sas ...ServiceAttachment) (*Controller, *FakeClient, *l4.FakeLoadBalancers) {
+	svc := &api_v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "my-ilb",
+			Namespace:   "default",
+			Annotations: map[string]string{annotations.ILBTypeKey: annotations.ILBTypeInternal},
+		},
+		Spec: api_v1.ServiceSpec{Type: api_v1.ServiceTypeLoadBalancer, Ports: []api_v1.ServicePort{{Port: 80}}},
+	}
+	cloud := l4.NewFakeLoadBalancers("us-central1", "projects/p/global/networks/net", "projects/p/regions/us-central1/subnetworks/default")
+	namer := utils.NewNamer("uid", "fw")
+	pool := l4.NewPool(cloud, firewalls.NewFakeFirewallsProvider(false, false), l4.NewFakeInstanceGroups("ig", "us-central1-a"), namer)
+	if _, err := pool.Ensure(svc, nil); err != nil {
+		t.Fatalf("Ensure() = %v", err)
+	}
+	client := &FakeClient{ServiceAttachments: sas}
+	return NewController(client, fake.NewSimpleClientset(svc), pool, namer, ""), client, cloud
+}
+
+func newServiceAttachment(spec ServiceAttachmentSpec) ServiceAttachment {
+	return ServiceAttachment{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "my-sa", Namespace: "default", Generation: 1},
+		Spec:       spec,
+	}
+}
+
+func TestSync(t *testing.T) {
+	c, client, cloud := newTestController(t, newServiceAttachment(ServiceAttachmentSpec{
+		ConnectionPreference: AcceptManual,
+		NATSubnets:           []string{"psc-subnet"},
+		ResourceRef:          ResourceRef{Kind: "Service", Name: "my-ilb"},
+		ConsumerAllowList:    []ConsumerProject{{Project: "consumer-b", ConnectionLimit: 10}, {Project: "consumer-a"}},
+		ConsumerRejectList:   []string{"rejected"},
+	}))
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	name := c.namer.ServiceAttachment("default", "my-sa")
+	gceSA, ok := cloud.ServiceAttachments[name]
+	if !ok {
+		t.Fatalf("service attachment %v not created", name)
+	}
+	if gceSA.TargetService != cloud.ForwardingRules[c.namer.L4("default", "my-ilb")].SelfLink {
+		t.Errorf("target service = %v, want the forwarding rule of the internal load balancer", gceSA.TargetService)
+	}
+	if len(gceSA.NatSubnets) != 1 || gceSA.NatSubnets[0] != "projects/p/regions/us-central1/subnetworks/psc-subnet" {
+		t.Errorf("NAT subnets = %v, want psc-subnet of the region", gceSA.NatSubnets)
+	}
+	if len(gceSA.ConsumerAcceptLists) != 2 || gceSA.ConsumerAcceptLists[0].ProjectIdOrNum != "consumer-a" || gceSA.ConsumerAcceptLists[1].ConnectionLimit != 10 {
+		t.Errorf("consumer accept lists = %+v, want consumer-a and consumer-b with 10 connections", gceSA.ConsumerAcceptLists)
+	}
+	sa := client.ServiceAttachments[0]
+	if !hasFinalizer(&sa) {
+		t.Errorf("finalizers = %v, want %v", sa.Finalizers, FinalizerKey)
+	}
+	if sa.Status.ServiceAttachmentURL != gceSA.SelfLink || sa.Status.ForwardingRuleURL != gceSA.TargetService {
+		t.Errorf("status = %+v, want the URLs of the service attachment and of the forwarding rule", sa.Status)
+	}
+	if len(sa.Status.Conditions) != 1 || sa.Status.Conditions[0].Status != "True" {
+		t.Errorf("conditions = %+v, want Ready", sa.Status.Conditions)
+	}
+
+	// A consumer connects, and a project is no longer accepted.
+	cloud.ServiceAttachments[name].ConnectedEndpoints = []*l4.ServiceAttachmentConnectedEndpoint{
+		{Endpoint: "projects/consumer-a/regions/us-central1/forwardingRules/psc", PscConnectionId: 42, Status: "ACCEPTED"},
+	}
+	client.ServiceAttachments[0].Spec.ConsumerAllowList = []ConsumerProject{{Project: "consumer-a"}}
+	fingerprint := gceSA.Fingerprint
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if gceSA := cloud.ServiceAttachments[name]; gceSA.Fingerprint == fingerprint || len(gceSA.ConsumerAcceptLists) != 1 {
+		t.Errorf("service attachment = %+v, want consumer-a only accepted", gceSA)
+	}
+	if rules := client.ServiceAttachments[0].Status.ConsumerForwardingRules; len(rules) != 1 || rules[0].PSCConnectionID != 42 {
+		t.Errorf("consumer forwarding rules = %+v, want the connected consumer", rules)
+	}
+
+	// The ServiceAttachment is deleted.
+	now := meta_v1.Now()
+	client.ServiceAttachments[0].DeletionTimestamp = &now
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if _, ok := cloud.ServiceAttachments[name]; ok {
+		t.Errorf("service attachment %v not deleted", name)
+	}
+	if len(client.ServiceAttachments) != 0 {
+		t.Errorf("ServiceAttachments = %+v, want the finalizer removed", client.ServiceAttachments)
+	}
+}
+
+func TestSyncErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		spec ServiceAttachmentSpec
+	}{
+		{"no subnet", ServiceAttachmentSpec{ResourceRef: ResourceRef{Name: "my-ilb"}}},
+		{"not a Service", ServiceAttachmentSpec{NATSubnets: []string{"psc"}, ResourceRef: ResourceRef{Kind: "Ingress", Name: "my-ilb"}}},
+		{"missing Service", ServiceAttachmentSpec{NATSubnets: []string{"psc"}, ResourceRef: ResourceRef{Name: "missing"}}},
+		{"lists without manual accept", ServiceAttachmentSpec{NATSubnets: []string{"psc"}, ResourceRef: ResourceRef{Name: "my-ilb"}, ConsumerRejectList: []string{"p"}}},
+		{"invalid preference", ServiceAttachmentSpec{ConnectionPreference: "ACCEPT_SOME", NATSubnets: []string{"psc"}, ResourceRef: ResourceRef{Name: "my-ilb"}}},
+	} {
+		c, client, cloud := newTestController(t, newServiceAttachment(tc.spec))
+		if err := c.Sync(); err == nil {
+			t.Errorf("%v: Sync() = nil, want error", tc.desc)
+		}
+		if len(cloud.ServiceAttachments) != 0 {
+			t.Errorf("%v: service attachments = %v, want none", tc.desc, cloud.ServiceAttachments)
+		}
+		if conds := client.ServiceAttachments[0].Status.Conditions; len(conds) != 1 || conds[0].Status != "False" || conds[0].Message == "" {
+			t.Errorf("%v: conditions = %+v, want Ready false with the error", tc.desc, conds)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package serviceattachment publishes the internal load balancers of the
+// Services over Private Service Connect. Each ServiceAttachment resource is
+// reconciled into a PSC service attachment pointing at the forwarding rule of
+// the internal load balancer of its Service, managed by the L4 controller,
+// and its status reports the consumer forwarding rules connected to it.
+package serviceattachment
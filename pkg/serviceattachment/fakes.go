@@ -0,0 +1,93 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serviceattachment
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// FakeClient is a fake Client, keeping the ServiceAttachments in memory.
+type FakeClient struct {
+	mu                 sync.Mutex
+	ServiceAttachments []ServiceAttachment
+}
+
+// Ensure that FakeClient implements Client.
+var _ Client = &FakeClient{}
+
+// List implements Client.
+func (f *FakeClient) List(namespace string) ([]ServiceAttachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []ServiceAttachment
+	for _, sa := range f.ServiceAttachments {
+		if namespace == "" || sa.Namespace == namespace {
+			list = append(list, *copyOf(&sa))
+		}
+	}
+	return list, nil
+}
+
+// Update implements Client. A ServiceAttachment being deleted is gone once
+// it has no finalizer left.
+func (f *FakeClient) Update(sa *ServiceAttachment) (*ServiceAttachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.ServiceAttachments {
+		existing := &f.ServiceAttachments[i]
+		if existing.Namespace != sa.Namespace || existing.Name != sa.Name {
+			continue
+		}
+		if sa.DeletionTimestamp != nil && len(sa.Finalizers) == 0 {
+			f.ServiceAttachments = append(f.ServiceAttachments[:i], f.ServiceAttachments[i+1:]...)
+			return copyOf(sa), nil
+		}
+		status := existing.Status
+		*existing = *copyOf(sa)
+		existing.Status = status
+		return copyOf(existing), nil
+	}
+	return nil, fmt.Errorf("%v %v/%v not found", Kind, sa.Namespace, sa.Name)
+}
+
+// UpdateStatus implements Client.
+func (f *FakeClient) UpdateStatus(sa *ServiceAttachment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.ServiceAttachments {
+		if f.ServiceAttachments[i].Namespace == sa.Namespace && f.ServiceAttachments[i].Name == sa.Name {
+			f.ServiceAttachments[i].Status = copyOf(sa).Status
+			return nil
+		}
+	}
+	return fmt.Errorf("%v %v/%v not found", Kind, sa.Namespace, sa.Name)
+}
+
+// copyOf returns a deep copy of the given ServiceAttachment.
+func copyOf(sa *ServiceAttachment) *ServiceAttachment {
+	data, err := json.Marshal(sa)
+	if err != nil {
+		panic(err)
+	}
+	ret := &ServiceAttachment{}
+	if err := json.Unmarshal(data, ret); err != nil {
+		panic(err)
+	}
+	return ret
+}
@@ -0,0 +1,30 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serviceattachment
+
+// Client lists the ServiceAttachments, and updates them.
+type Client interface {
+	// List returns the ServiceAttachments of the given namespace, of all
+	// namespaces if empty.
+	List(namespace string) ([]ServiceAttachment, error)
+	// Update writes the metadata and spec of the given ServiceAttachment,
+	// which must have the resource version of the current one, and returns
+	// the updated one.
+	Update(sa *ServiceAttachment) (*ServiceAttachment, error)
+	// UpdateStatus writes the status of the given ServiceAttachment.
+	UpdateStatus(sa *ServiceAttachment) error
+}
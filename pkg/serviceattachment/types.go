@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serviceattachment
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GroupName is the API group of the ServiceAttachments.
+	GroupName = "networking.gke.io"
+	// Version is the API version of the ServiceAttachments.
+	Version = "v1"
+	// Kind is the kind of the ServiceAttachments.
+	Kind = "ServiceAttachment"
+	// Resource is the plural resource name of the ServiceAttachments.
+	Resource = "serviceattachments"
+	// FinalizerKey is the finalizer of the ServiceAttachments whose PSC
+	// service attachment may exist, removed once it is deleted.
+	FinalizerKey = "networking.gke.io/service-attachment-finalizer"
+
+	// Connection preferences of the service attachments.
+	AcceptAutomatic = "ACCEPT_AUTOMATIC"
+	AcceptManual    = "ACCEPT_MANUAL"
+
+	// ConditionReady is the condition of the ServiceAttachments whose
+	// service attachment is in sync with their spec.
+	ConditionReady = "Ready"
+)
+
+// ServiceAttachment publishes the internal load balancer of a Service over
+// Private Service Connect.
+type ServiceAttachment struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceAttachmentSpec   `json:"spec"`
+	Status ServiceAttachmentStatus `json:"status,omitempty"`
+}
+
+// ServiceAttachmentSpec is the spec of a ServiceAttachment.
+type ServiceAttachmentSpec struct {
+	// ConnectionPreference is ACCEPT_AUTOMATIC, the default, which accepts
+	// all the consumers, or ACCEPT_MANUAL, which only accepts the projects
+	// of ConsumerAllowList.
+	ConnectionPreference string `json:"connectionPreference,omitempty"`
+	// NATSubnets are the names of the PSC subnets of the region, whose IPs
+	// are the sources of the connections of the consumers.
+	NATSubnets []string `json:"natSubnets"`
+	// ResourceRef is the Service with an internal load balancer, in the
+	// namespace of the ServiceAttachment.
+	ResourceRef ResourceRef `json:"resourceRef"`
+	// ProxyProtocol prepends the PROXY protocol header with the consumer
+	// address to the connections.
+	ProxyProtocol bool `json:"proxyProtocol,omitempty"`
+	// ConsumerAllowList and ConsumerRejectList are the consumer projects
+	// accepted and rejected with ACCEPT_MANUAL.
+	ConsumerAllowList  []ConsumerProject `json:"consumerAllowList,omitempty"`
+	ConsumerRejectList []string          `json:"consumerRejectList,omitempty"`
+}
+
+// ResourceRef references a resource in the namespace of the
+// ServiceAttachment.
+type ResourceRef struct {
+	APIGroup string `json:"apiGroup,omitempty"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+// ConsumerProject is a consumer project, by ID or number, with its maximum
+// number of connections, unlimited if zero.
+type ConsumerProject struct {
+	Project         string `json:"project"`
+	ConnectionLimit int64  `json:"connectionLimit,omitempty"`
+}
+
+// ServiceAttachmentStatus is the status of a ServiceAttachment.
+type ServiceAttachmentStatus struct {
+	// ServiceAttachmentURL is the URL of the service attachment, which the
+	// consumers connect to.
+	ServiceAttachmentURL string `json:"serviceAttachmentURL,omitempty"`
+	// ForwardingRuleURL is the URL of the forwarding rule of the internal
+	// load balancer.
+	ForwardingRuleURL string `json:"forwardingRuleURL,omitempty"`
+	// ConsumerForwardingRules are the connected consumer forwarding rules.
+	ConsumerForwardingRules []ConsumerForwardingRule `json:"consumerForwardingRules,omitempty"`
+	// LastModifiedTimestamp is the time of the last change of the status.
+	LastModifiedTimestamp meta_v1.Time `json:"lastModifiedTimestamp,omitempty"`
+	Conditions            []Condition  `json:"conditions,omitempty"`
+}
+
+// ConsumerForwardingRule is a consumer forwarding rule connected to the
+// service attachment.
+type ConsumerForwardingRule struct {
+	ForwardingRuleURL string `json:"forwardingRuleURL"`
+	PSCConnectionID   uint64 `json:"pscConnectionID,omitempty"`
+	// Status is PENDING, ACCEPTED, REJECTED or CLOSED.
+	Status string `json:"status"`
+}
+
+// Condition is a condition of the status of a ServiceAttachment.
+type Condition struct {
+	Type               string       `json:"type"`
+	Status             string       `json:"status"`
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+	LastTransitionTime meta_v1.Time `json:"lastTransitionTime"`
+	Reason             string       `json:"reason"`
+	Message            string       `json:"message"`
+}
+
+// ServiceAttachmentList is a list of ServiceAttachments.
+type ServiceAttachmentList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ServiceAttachment `json:"items"`
+}
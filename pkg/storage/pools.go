@@ -20,10 +20,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
-
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/ingress-gce/pkg/logging"
 )
 
 // Snapshotter is an interface capable of providing a consistent snapshot of
@@ -88,7 +88,7 @@ type CloudListingPool struct {
 func (c *CloudListingPool) ReplenishPool() {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	glog.V(4).Infof("Replenishing pool")
+	logging.V(4).Infof("Replenishing pool")
 
 	// We must list with the lock, because the controller also lists through
 	// Snapshot(). It's ok if the controller takes a snpshot, we list, we
@@ -97,14 +97,14 @@ func (c *CloudListingPool) ReplenishPool() {
 	// creates a backend, and we delete that backend based on stale state.
 	items, err := c.lister.List()
 	if err != nil {
-		glog.Warningf("Failed to list: %v", err)
+		logging.Warningf("Failed to list: %v", err)
 		return
 	}
 
 	for i := range items {
 		key, err := c.keyGetter(items[i])
 		if err != nil {
-			glog.V(5).Infof("CloudListingPool: %v", err)
+			logging.V(5).Infof("CloudListingPool: %v", err)
 			continue
 		}
 		c.InMemoryPool.Add(key, items[i])
@@ -140,7 +140,7 @@ func NewCloudListingPool(k keyFunc, lister cloudLister, relistPeriod time.Durati
 		lister:       lister,
 		keyGetter:    k,
 	}
-	glog.V(4).Infof("Starting pool replenish goroutine")
+	logging.V(4).Infof("Starting pool replenish goroutine")
 	go wait.Until(cl.ReplenishPool, relistPeriod, make(chan struct{}))
 	return cl
 }
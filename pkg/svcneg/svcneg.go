@@ -0,0 +1,206 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package svcneg
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+// Client is the interface for managing ServiceNetworkEndpointGroups.
+type Client interface {
+	// Get returns the ServiceNetworkEndpointGroup with the given namespace
+	// and name, or nil if it does not exist.
+	Get(namespace, name string) (*ServiceNetworkEndpointGroup, error)
+	// Create creates the given ServiceNetworkEndpointGroup.
+	Create(svcNEG *ServiceNetworkEndpointGroup) error
+	// Update updates the given ServiceNetworkEndpointGroup, which must have
+	// the resource version of the current one.
+	Update(svcNEG *ServiceNetworkEndpointGroup) error
+	// Delete deletes the ServiceNetworkEndpointGroup with the given
+	// namespace and name, if it exists.
+	Delete(namespace, name string) error
+}
+
+// APIServerClient manages ServiceNetworkEndpointGroups in the Kubernetes
+// apiserver.
+type APIServerClient struct {
+	Client kubernetes.Interface
+}
+
+// Ensure that APIServerClient implements Client.
+var _ Client = &APIServerClient{}
+
+// restClient returns the generic REST client, since there is no generated
+// client for the resource.
+// TODO: Replace this with a generated client.
+func (c *APIServerClient) restClient() (rest.Interface, error) {
+	restClient := c.Client.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, fmt.Errorf("no REST client for %v", Resource)
+	}
+	return restClient, nil
+}
+
+// Get retrieves the ServiceNetworkEndpointGroup through the generic REST
+// client.
+func (c *APIServerClient) Get(namespace, name string) (*ServiceNetworkEndpointGroup, error) {
+	restClient, err := c.restClient()
+	if err != nil {
+		return nil, err
+	}
+	data, err := restClient.Get().AbsPath("/apis", GroupName, Version, "namespaces", namespace, Resource, name).DoRaw()
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %v %v/%v: %v", Kind, namespace, name, err)
+	}
+	svcNEG := &ServiceNetworkEndpointGroup{}
+	if err := json.Unmarshal(data, svcNEG); err != nil {
+		return nil, fmt.Errorf("failed to decode %v %v/%v: %v", Kind, namespace, name, err)
+	}
+	return svcNEG, nil
+}
+
+// Create creates the ServiceNetworkEndpointGroup through the generic REST
+// client.
+func (c *APIServerClient) Create(svcNEG *ServiceNetworkEndpointGroup) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(withTypeMeta(svcNEG))
+	if err != nil {
+		return err
+	}
+	logging.V(3).Infof("Creating %v %v/%v", Kind, svcNEG.Namespace, svcNEG.Name)
+	if _, err := restClient.Post().AbsPath("/apis", GroupName, Version, "namespaces", svcNEG.Namespace, Resource).Body(data).DoRaw(); err != nil {
+		return fmt.Errorf("failed to create %v %v/%v: %v", Kind, svcNEG.Namespace, svcNEG.Name, err)
+	}
+	return nil
+}
+
+// Update updates the ServiceNetworkEndpointGroup through the generic REST
+// client.
+func (c *APIServerClient) Update(svcNEG *ServiceNetworkEndpointGroup) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(withTypeMeta(svcNEG))
+	if err != nil {
+		return err
+	}
+	logging.V(3).Infof("Updating %v %v/%v", Kind, svcNEG.Namespace, svcNEG.Name)
+	if _, err := restClient.Put().AbsPath("/apis", GroupName, Version, "namespaces", svcNEG.Namespace, Resource, svcNEG.Name).Body(data).DoRaw(); err != nil {
+		return fmt.Errorf("failed to update %v %v/%v: %v", Kind, svcNEG.Namespace, svcNEG.Name, err)
+	}
+	return nil
+}
+
+// Delete deletes the ServiceNetworkEndpointGroup through the generic REST
+// client.
+func (c *APIServerClient) Delete(namespace, name string) error {
+	restClient, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	logging.V(3).Infof("Deleting %v %v/%v", Kind, namespace, name)
+	_, err = restClient.Delete().AbsPath("/apis", GroupName, Version, "namespaces", namespace, Resource, name).DoRaw()
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %v %v/%v: %v", Kind, namespace, name, err)
+	}
+	return nil
+}
+
+// withTypeMeta returns a copy of the given ServiceNetworkEndpointGroup with
+// its kind and API version, which the apiserver requires.
+func withTypeMeta(svcNEG *ServiceNetworkEndpointGroup) *ServiceNetworkEndpointGroup {
+	ret := *svcNEG
+	ret.APIVersion = schema.GroupVersion{Group: GroupName, Version: Version}.String()
+	ret.Kind = Kind
+	return &ret
+}
+
+// FakeClient fakes out ServiceNetworkEndpointGroup management.
+type FakeClient struct {
+	mu sync.Mutex
+	// SvcNEGs are keyed by namespace/name.
+	SvcNEGs map[string]*ServiceNetworkEndpointGroup
+}
+
+// Ensure that FakeClient implements Client.
+var _ Client = &FakeClient{}
+
+// NewFakeClient returns a FakeClient without ServiceNetworkEndpointGroups.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{SvcNEGs: map[string]*ServiceNetworkEndpointGroup{}}
+}
+
+// Get returns a copy of the fake ServiceNetworkEndpointGroup.
+func (f *FakeClient) Get(namespace, name string) (*ServiceNetworkEndpointGroup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	svcNEG, ok := f.SvcNEGs[namespace+"/"+name]
+	if !ok {
+		return nil, nil
+	}
+	ret := *svcNEG
+	ret.Status.Ports = append([]PortStatus{}, svcNEG.Status.Ports...)
+	return &ret, nil
+}
+
+// Create stores the fake ServiceNetworkEndpointGroup.
+func (f *FakeClient) Create(svcNEG *ServiceNetworkEndpointGroup) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := svcNEG.Namespace + "/" + svcNEG.Name
+	if _, ok := f.SvcNEGs[key]; ok {
+		return fmt.Errorf("%v %v already exists", Kind, key)
+	}
+	f.SvcNEGs[key] = withTypeMeta(svcNEG)
+	return nil
+}
+
+// Update replaces the fake ServiceNetworkEndpointGroup.
+func (f *FakeClient) Update(svcNEG *ServiceNetworkEndpointGroup) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := svcNEG.Namespace + "/" + svcNEG.Name
+	if _, ok := f.SvcNEGs[key]; !ok {
+		return fmt.Errorf("%v %v not found", Kind, key)
+	}
+	f.SvcNEGs[key] = withTypeMeta(svcNEG)
+	return nil
+}
+
+// Delete deletes the fake ServiceNetworkEndpointGroup.
+func (f *FakeClient) Delete(namespace, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.SvcNEGs, namespace+"/"+name)
+	return nil
+}
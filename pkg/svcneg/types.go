@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package svcneg
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GroupName is the API group of the ServiceNetworkEndpointGroup resource.
+	GroupName = "cloud.google.com"
+	// Version is the API version of the ServiceNetworkEndpointGroup resource.
+	Version = "v1beta1"
+	// Resource is the plural resource name of ServiceNetworkEndpointGroup.
+	Resource = "servicenetworkendpointgroups"
+	// Kind is the kind of the ServiceNetworkEndpointGroup resource.
+	Kind = "ServiceNetworkEndpointGroup"
+
+	// ConditionSynced is the condition type of the last sync of the NEGs of
+	// a port.
+	ConditionSynced = "Synced"
+	// ConditionTrue means the condition holds.
+	ConditionTrue = "True"
+	// ConditionFalse means the condition does not hold.
+	ConditionFalse = "False"
+)
+
+// ServiceNetworkEndpointGroup is the status of the network endpoint groups
+// of a Service, maintained by the NEG controller. It has the name and the
+// namespace of the Service, and is owned by the Service.
+type ServiceNetworkEndpointGroup struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status ServiceNetworkEndpointGroupStatus `json:"status,omitempty"`
+}
+
+// ServiceNetworkEndpointGroupStatus is the status of a
+// ServiceNetworkEndpointGroup.
+type ServiceNetworkEndpointGroupStatus struct {
+	// Ports are the NEGs of the target ports of the Service, sorted by
+	// target port.
+	Ports []PortStatus `json:"ports,omitempty"`
+}
+
+// PortStatus is the status of the NEGs of a target port.
+type PortStatus struct {
+	// TargetPort is the target port, a number or a name.
+	TargetPort string `json:"targetPort"`
+	// NetworkEndpointGroups are the NEGs of the port, one per zone.
+	NetworkEndpointGroups []NetworkEndpointGroup `json:"networkEndpointGroups,omitempty"`
+	// Conditions are the conditions of the port, the Synced condition being
+	// the result of the last sync.
+	Conditions []Condition `json:"conditions,omitempty"`
+	// LastSyncTime is the time of the last sync of the NEGs.
+	LastSyncTime meta_v1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// NetworkEndpointGroup is the status of a NEG.
+type NetworkEndpointGroup struct {
+	// Name is the name of the NEG.
+	Name string `json:"name"`
+	// Zone is the zone of the NEG.
+	Zone string `json:"zone"`
+	// Endpoints is the number of endpoints of the NEG after the last
+	// successful sync.
+	Endpoints int `json:"endpoints"`
+}
+
+// Condition is a condition of the NEGs of a port.
+type Condition struct {
+	// Type is the type of the condition, eg: Synced.
+	Type string `json:"type"`
+	// Status is True or False.
+	Status string `json:"status"`
+	// Message is the error of the last sync, if it failed.
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the time of the last change of Status.
+	LastTransitionTime meta_v1.Time `json:"lastTransitionTime,omitempty"`
+}
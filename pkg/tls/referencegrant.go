@@ -0,0 +1,173 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// ReferenceGrantGroupName is the API group of the Gateway API
+	// ReferenceGrant resource.
+	ReferenceGrantGroupName = "gateway.networking.k8s.io"
+	// ReferenceGrantVersion is the API version of the ReferenceGrant
+	// resource.
+	ReferenceGrantVersion = "v1beta1"
+	// ReferenceGrantResource is the plural resource name of ReferenceGrant.
+	ReferenceGrantResource = "referencegrants"
+
+	// LBGroupGroupName and LBGroupKind identify the LB groups of Ingresses in
+	// the To of ReferenceGrants, which have no resource of their own.
+	LBGroupGroupName = "ingress.gcp.kubernetes.io"
+	LBGroupKind      = "LBGroup"
+)
+
+// ReferenceGrant allows the objects of other namespaces to reference objects
+// of its namespace. Only the fields the controller uses are decoded.
+type ReferenceGrant struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReferenceGrantSpec `json:"spec,omitempty"`
+}
+
+// ReferenceGrantSpec lists the referencing objects, and the objects they may
+// reference.
+type ReferenceGrantSpec struct {
+	From []ReferenceGrantFrom `json:"from"`
+	To   []ReferenceGrantTo   `json:"to"`
+}
+
+// ReferenceGrantFrom is a kind of referencing object, in a namespace.
+type ReferenceGrantFrom struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+}
+
+// ReferenceGrantTo is a kind of referenced object, all of them if Name is
+// nil.
+type ReferenceGrantTo struct {
+	Group string  `json:"group"`
+	Kind  string  `json:"kind"`
+	Name  *string `json:"name,omitempty"`
+}
+
+// ReferenceGrantList is a list of ReferenceGrants.
+type ReferenceGrantList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ReferenceGrant `json:"items"`
+}
+
+// ReferenceGrantGetter is the interface for listing ReferenceGrants.
+type ReferenceGrantGetter interface {
+	// List returns the ReferenceGrants of the given namespace.
+	List(namespace string) ([]ReferenceGrant, error)
+}
+
+// APIServerReferenceGrantGetter retrieves ReferenceGrants from the Kubernetes
+// apiserver.
+type APIServerReferenceGrantGetter struct {
+	Client kubernetes.Interface
+}
+
+// Ensure that APIServerReferenceGrantGetter implements ReferenceGrantGetter.
+var _ ReferenceGrantGetter = &APIServerReferenceGrantGetter{}
+
+// List retrieves the ReferenceGrants through the generic REST client, since
+// the Gateway API client isn't vendored.
+func (g *APIServerReferenceGrantGetter) List(namespace string) ([]ReferenceGrant, error) {
+	restClient := g.Client.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, fmt.Errorf("no REST client to list the ReferenceGrants of namespace %v", namespace)
+	}
+	logging.V(3).Infof("Listing the ReferenceGrants of namespace %v", namespace)
+	data, err := restClient.Get().AbsPath("/apis", ReferenceGrantGroupName, ReferenceGrantVersion, "namespaces", namespace, ReferenceGrantResource).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the ReferenceGrants of namespace %v: %v", namespace, err)
+	}
+	list := &ReferenceGrantList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("failed to decode the ReferenceGrants of namespace %v: %v", namespace, err)
+	}
+	return list.Items, nil
+}
+
+// FakeReferenceGrantGetter fakes out ReferenceGrant retrieval.
+type FakeReferenceGrantGetter struct {
+	// Grants are keyed by namespace.
+	Grants map[string][]ReferenceGrant
+}
+
+// Ensure that FakeReferenceGrantGetter implements ReferenceGrantGetter.
+var _ ReferenceGrantGetter = &FakeReferenceGrantGetter{}
+
+// List returns the fake ReferenceGrants of the given namespace.
+func (f *FakeReferenceGrantGetter) List(namespace string) ([]ReferenceGrant, error) {
+	return f.Grants[namespace], nil
+}
+
+// SecretRef returns the namespace and name of the secret referenced by the
+// given secret name of the Ingress. A secret of another namespace is
+// referenced as "namespace/name".
+func SecretRef(ing *extensions.Ingress, secretName string) (namespace, name string) {
+	if parts := strings.SplitN(secretName, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return ing.Namespace, secretName
+}
+
+// secretGranted returns true if one of the given ReferenceGrants allows the
+// Ingresses of the given namespace to reference the secret with the given
+// name.
+func secretGranted(grants []ReferenceGrant, ingNamespace, secretName string) bool {
+	return IngressGranted(grants, ingNamespace, "", "Secret", secretName)
+}
+
+// IngressGranted returns true if one of the given ReferenceGrants allows the
+// Ingresses of the given namespace to reference the object of the given
+// group, kind and name.
+func IngressGranted(grants []ReferenceGrant, ingNamespace, group, kind, name string) bool {
+	for _, grant := range grants {
+		from := false
+		for _, f := range grant.Spec.From {
+			if (f.Group == "networking.k8s.io" || f.Group == "extensions") && f.Kind == "Ingress" && f.Namespace == ingNamespace {
+				from = true
+				break
+			}
+		}
+		if !from {
+			continue
+		}
+		for _, t := range grant.Spec.To {
+			if t.Group == group && t.Kind == kind && (t.Name == nil || *t.Name == name) {
+				return true
+			}
+		}
+	}
+	return false
+}
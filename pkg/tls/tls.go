@@ -19,25 +19,24 @@ package tls
 import (
 	"fmt"
 
-	"github.com/golang/glog"
-
 	api_v1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 
 	"k8s.io/ingress-gce/pkg/loadbalancers"
+	"k8s.io/ingress-gce/pkg/logging"
 )
 
 // TlsLoader is the interface for loading the relevant TLSCerts for a given ingress.
 type TlsLoader interface {
-	// Load loads the relevant TLSCerts based on ing.Spec.TLS
-	Load(ing *extensions.Ingress) (*loadbalancers.TLSCerts, error)
+	// Load loads the relevant TLSCerts based on ing.Spec.TLS, in order.
+	Load(ing *extensions.Ingress) ([]*loadbalancers.TLSCerts, error)
 	// Validate validates the given TLSCerts and returns an error if they are invalid.
 	Validate(certs *loadbalancers.TLSCerts) error
 }
 
-// TODO: Add better cert validation.
 type noOPValidator struct{}
 
 func (n *noOPValidator) Validate(certs *loadbalancers.TLSCerts) error {
@@ -46,26 +45,46 @@ func (n *noOPValidator) Validate(certs *loadbalancers.TLSCerts) error {
 
 // TLSCertsFromSecretsLoader loads TLS certs from kubernetes secrets.
 type TLSCertsFromSecretsLoader struct {
-	noOPValidator
+	certValidator
 	Client kubernetes.Interface
+	// ReferenceGrants authorize the secrets of other namespaces. Such
+	// secrets are refused if nil.
+	ReferenceGrants ReferenceGrantGetter
 }
 
 // Ensure that TLSCertsFromSecretsLoader implements TlsLoader interface.
 var _ TlsLoader = &TLSCertsFromSecretsLoader{}
 
-func (t *TLSCertsFromSecretsLoader) Load(ing *extensions.Ingress) (*loadbalancers.TLSCerts, error) {
-	if len(ing.Spec.TLS) == 0 {
-		return nil, nil
-	}
-	// GCE L7s currently only support a single cert.
-	if len(ing.Spec.TLS) > 1 {
-		glog.Warningf("Ignoring %d certs and taking the first for ingress %v/%v",
-			len(ing.Spec.TLS)-1, ing.Namespace, ing.Name)
+func (t *TLSCertsFromSecretsLoader) Load(ing *extensions.Ingress) ([]*loadbalancers.TLSCerts, error) {
+	var certs []*loadbalancers.TLSCerts
+	loaded := sets.NewString()
+	for _, tls := range ing.Spec.TLS {
+		// Secrets referenced more than once, eg: for several hosts, are
+		// only loaded once.
+		if loaded.Has(tls.SecretName) {
+			continue
+		}
+		loaded.Insert(tls.SecretName)
+		cert, err := t.load(ing, tls.SecretName)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
 	}
-	secretName := ing.Spec.TLS[0].SecretName
+	return certs, nil
+}
+
+// load loads the TLSCerts from the secret with the given name.
+func (t *TLSCertsFromSecretsLoader) load(ing *extensions.Ingress, secretName string) (*loadbalancers.TLSCerts, error) {
 	// TODO: Replace this for a secret watcher.
-	glog.V(3).Infof("Retrieving secret for ing %v with name %v", ing.Name, secretName)
-	secret, err := t.Client.Core().Secrets(ing.Namespace).Get(secretName, meta_v1.GetOptions{})
+	logging.V(3).Infof("Retrieving secret for ing %v with name %v", ing.Name, secretName)
+	namespace, name := SecretRef(ing, secretName)
+	if namespace != ing.Namespace {
+		if err := t.checkGranted(ing, namespace, name); err != nil {
+			return nil, err
+		}
+	}
+	secret, err := t.Client.Core().Secrets(namespace).Get(name, meta_v1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -77,13 +96,29 @@ func (t *TLSCertsFromSecretsLoader) Load(ing *extensions.Ingress) (*loadbalancer
 	if !ok {
 		return nil, fmt.Errorf("secret %v has no 'tls.key'", secretName)
 	}
-	certs := &loadbalancers.TLSCerts{Key: string(key), Cert: string(cert)}
+	certs := &loadbalancers.TLSCerts{Key: string(key), Cert: string(cert), Secret: secretName}
 	if err := t.Validate(certs); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid certificate in secret %v: %v", secretName, err)
 	}
 	return certs, nil
 }
 
+// checkGranted returns an error unless a ReferenceGrant of the given
+// namespace allows the Ingress to reference the secret with the given name.
+func (t *TLSCertsFromSecretsLoader) checkGranted(ing *extensions.Ingress, namespace, name string) error {
+	if t.ReferenceGrants == nil {
+		return fmt.Errorf("secret %v/%v is in another namespace than Ingress %v/%v", namespace, name, ing.Namespace, ing.Name)
+	}
+	grants, err := t.ReferenceGrants.List(namespace)
+	if err != nil {
+		return err
+	}
+	if !secretGranted(grants, ing.Namespace, name) {
+		return fmt.Errorf("no ReferenceGrant of namespace %v allows the Ingresses of namespace %v to reference secret %v", namespace, ing.Namespace, name)
+	}
+	return nil
+}
+
 // TODO: Add support for file loading so we can support HTTPS default backends.
 
 // fakeTLSSecretLoader fakes out TLS loading.
@@ -95,14 +130,14 @@ type FakeTLSSecretLoader struct {
 // Ensure that FakeTLSSecretLoader implements TlsLoader interface.
 var _ TlsLoader = &FakeTLSSecretLoader{}
 
-func (f *FakeTLSSecretLoader) Load(ing *extensions.Ingress) (*loadbalancers.TLSCerts, error) {
-	if len(ing.Spec.TLS) == 0 {
-		return nil, nil
-	}
-	for name, cert := range f.FakeCerts {
-		if ing.Spec.TLS[0].SecretName == name {
-			return cert, nil
+func (f *FakeTLSSecretLoader) Load(ing *extensions.Ingress) ([]*loadbalancers.TLSCerts, error) {
+	var certs []*loadbalancers.TLSCerts
+	for _, tls := range ing.Spec.TLS {
+		cert, ok := f.FakeCerts[tls.SecretName]
+		if !ok {
+			return nil, fmt.Errorf("couldn't find secret %v for ingress %v", tls.SecretName, ing.Name)
 		}
+		certs = append(certs, cert)
 	}
-	return nil, fmt.Errorf("couldn't find secret for ingress %v", ing.Name)
+	return certs, nil
 }
@@ -0,0 +1,99 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	api_v1 "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLoadCrossNamespaceSecret(t *testing.T) {
+	now := time.Now()
+	_, _, certPEM, key := newCert(t, "wildcard", now.Add(-time.Hour), now.Add(time.Hour), nil, nil)
+	client := fake.NewSimpleClientset(&api_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "certs", Name: "wildcard"},
+		Data:       map[string][]byte{api_v1.TLSCertKey: []byte(certPEM), api_v1.TLSPrivateKeyKey: []byte(key)},
+	})
+	ing := &extensions.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "team", Name: "ing"},
+		Spec:       extensions.IngressSpec{TLS: []extensions.IngressTLS{{SecretName: "certs/wildcard"}}},
+	}
+	name := "wildcard"
+	other := "other"
+	grant := func(fromNamespace string, secretName *string) ReferenceGrant {
+		return ReferenceGrant{Spec: ReferenceGrantSpec{
+			From: []ReferenceGrantFrom{{Group: "networking.k8s.io", Kind: "Ingress", Namespace: fromNamespace}},
+			To:   []ReferenceGrantTo{{Group: "", Kind: "Secret", Name: secretName}},
+		}}
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		grants  ReferenceGrantGetter
+		wantErr string
+	}{
+		{
+			desc:    "no ReferenceGrant support",
+			wantErr: "another namespace",
+		},
+		{
+			desc:    "no ReferenceGrant",
+			grants:  &FakeReferenceGrantGetter{},
+			wantErr: "no ReferenceGrant",
+		},
+		{
+			desc:    "ReferenceGrant of another namespace",
+			grants:  &FakeReferenceGrantGetter{Grants: map[string][]ReferenceGrant{"certs": {grant("other", nil)}}},
+			wantErr: "no ReferenceGrant",
+		},
+		{
+			desc:    "ReferenceGrant of another secret",
+			grants:  &FakeReferenceGrantGetter{Grants: map[string][]ReferenceGrant{"certs": {grant("team", &other)}}},
+			wantErr: "no ReferenceGrant",
+		},
+		{
+			desc:   "ReferenceGrant of the secret",
+			grants: &FakeReferenceGrantGetter{Grants: map[string][]ReferenceGrant{"certs": {grant("team", &name)}}},
+		},
+		{
+			desc:   "ReferenceGrant of all secrets",
+			grants: &FakeReferenceGrantGetter{Grants: map[string][]ReferenceGrant{"certs": {grant("team", nil)}}},
+		},
+	} {
+		loader := &TLSCertsFromSecretsLoader{Client: client, ReferenceGrants: tc.grants}
+		certs, err := loader.Load(ing)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%v: Load() = %v, want error containing %q", tc.desc, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: Load() = %v, want nil", tc.desc, err)
+			continue
+		}
+		if len(certs) != 1 || certs[0].Cert != certPEM || certs[0].Secret != "certs/wildcard" {
+			t.Errorf("%v: Load() = %+v, want the cert of secret certs/wildcard", tc.desc, certs)
+		}
+	}
+}
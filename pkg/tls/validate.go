@@ -0,0 +1,107 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+)
+
+// certValidator validates the certificates of secrets before they are
+// uploaded to GCE, so that their problems are reported on the Ingress rather
+// than as an opaque GCE error.
+type certValidator struct{}
+
+func (c *certValidator) Validate(certs *loadbalancers.TLSCerts) error {
+	return validate(certs, time.Now())
+}
+
+// validate returns an error if the certificate chain can't be parsed, doesn't
+// match the private key, or if its first certificate isn't valid at the given
+// time.
+func validate(certs *loadbalancers.TLSCerts, now time.Time) error {
+	chain, err := parseCertificates(certs.Cert + certs.Chain)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode([]byte(certs.Key)); block == nil {
+		return fmt.Errorf("tls.key holds no PEM encoded private key")
+	}
+	if _, err := cryptotls.X509KeyPair([]byte(certs.Cert), []byte(certs.Key)); err != nil {
+		return fmt.Errorf("the private key doesn't match the certificate: %v", err)
+	}
+	leaf := chain[0]
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %q expired on %v", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate %q is not valid before %v", leaf.Subject.CommonName, leaf.NotBefore.Format(time.RFC3339))
+	}
+	// Each certificate of the chain must be signed by the next one.
+	for i := 1; i < len(chain); i++ {
+		if err := chain[i-1].CheckSignatureFrom(chain[i]); err != nil {
+			return fmt.Errorf("malformed chain: certificate %q is not signed by the next certificate %q: %v",
+				chain[i-1].Subject.CommonName, chain[i].Subject.CommonName, err)
+		}
+	}
+	return nil
+}
+
+// parseCertificates parses the PEM encoded certificates of the given chain.
+func parseCertificates(data string) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("tls.crt holds an unexpected %v PEM block", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d of tls.crt is malformed: %v", len(chain)+1, err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("tls.crt holds no PEM encoded certificate")
+	}
+	return chain, nil
+}
+
+// ExpiresWithin returns the expiry of the certificate if it expires within
+// the given period, eg: to warn before it has to be rotated. A period of 0
+// never warns.
+func ExpiresWithin(certs *loadbalancers.TLSCerts, period time.Duration) (time.Time, bool) {
+	if period <= 0 {
+		return time.Time{}, false
+	}
+	chain, err := parseCertificates(certs.Cert)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expiry := chain[0].NotAfter
+	return expiry, time.Now().Add(period).After(expiry)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/ingress-gce/pkg/loadbalancers"
+)
+
+// newCert returns a PEM encoded certificate and private key, signed by the
+// given parent, or self signed if nil.
+func newCert(t *testing.T, name string, notBefore, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key,
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	notBefore, notAfter := now.Add(-time.Hour), now.Add(time.Hour)
+	ca, caKey, caPEM, _ := newCert(t, "ca", notBefore, notAfter, nil, nil)
+	_, _, leafPEM, leafKey := newCert(t, "leaf", notBefore, notAfter, ca, caKey)
+	_, _, otherPEM, otherKey := newCert(t, "other", notBefore, notAfter, nil, nil)
+	_, _, expiredPEM, expiredKey := newCert(t, "expired", now.Add(-2*time.Hour), now.Add(-time.Hour), nil, nil)
+
+	for _, tc := range []struct {
+		desc    string
+		certs   *loadbalancers.TLSCerts
+		wantErr string
+	}{
+		{
+			desc:  "valid chain",
+			certs: &loadbalancers.TLSCerts{Cert: leafPEM + caPEM, Key: leafKey},
+		},
+		{
+			desc:    "no certificate",
+			certs:   &loadbalancers.TLSCerts{Cert: "cert", Key: leafKey},
+			wantErr: "no PEM encoded certificate",
+		},
+		{
+			desc:    "no private key",
+			certs:   &loadbalancers.TLSCerts{Cert: leafPEM, Key: "key"},
+			wantErr: "no PEM encoded private key",
+		},
+		{
+			desc:    "mismatched key",
+			certs:   &loadbalancers.TLSCerts{Cert: leafPEM, Key: otherKey},
+			wantErr: "doesn't match the certificate",
+		},
+		{
+			desc:    "expired",
+			certs:   &loadbalancers.TLSCerts{Cert: expiredPEM, Key: expiredKey},
+			wantErr: `certificate "expired" expired`,
+		},
+		{
+			desc:    "malformed chain",
+			certs:   &loadbalancers.TLSCerts{Cert: leafPEM + otherPEM, Key: leafKey},
+			wantErr: "malformed chain",
+		},
+		{
+			desc:    "unexpected block",
+			certs:   &loadbalancers.TLSCerts{Cert: leafPEM + leafKey, Key: leafKey},
+			wantErr: "unexpected EC PRIVATE KEY PEM block",
+		},
+		{
+			desc:  "self signed",
+			certs: &loadbalancers.TLSCerts{Cert: otherPEM, Key: otherKey},
+		},
+	} {
+		err := validate(tc.certs, now)
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%v: validate() = %v, want nil", tc.desc, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%v: validate() = %v, want error containing %q", tc.desc, err, tc.wantErr)
+		}
+	}
+}
+
+func TestExpiresWithin(t *testing.T) {
+	now := time.Now()
+	_, _, certPEM, key := newCert(t, "cert", now.Add(-time.Hour), now.Add(48*time.Hour), nil, nil)
+	certs := &loadbalancers.TLSCerts{Cert: certPEM, Key: key}
+
+	if _, ok := ExpiresWithin(certs, 24*time.Hour); ok {
+		t.Errorf("ExpiresWithin(24h) = true, want false for a certificate expiring in 48h")
+	}
+	if expiry, ok := ExpiresWithin(certs, 72*time.Hour); !ok || expiry.Before(now) {
+		t.Errorf("ExpiresWithin(72h) = %v, %v, want the expiry and true", expiry, ok)
+	}
+	if _, ok := ExpiresWithin(certs, 0); ok {
+		t.Errorf("ExpiresWithin(0) = true, want false")
+	}
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing traces the syncs of the controller and the GCE API calls
+// they make, following the OpenTelemetry data model, and exports the spans
+// to an OpenTelemetry collector over OTLP/HTTP, with the JSON encoding.
+//
+// Spans are carried in contexts, the spans started with a context are the
+// children of its span, and so are the GCE API calls whose request carries
+// it. The vendored GCE client takes no context, so its calls are the children
+// of the span bound to the calling goroutine with Bind, if any.
+// Eg: the span of the sync of an Ingress is bound to the worker running the
+// sync, and the GCE API calls of the sync, recorded by the transport of the
+// GCE client, are its children. Tracing is disabled, and spans are nil, until
+// SetExporter is called.
+//
+// The OTLP exporter is written against net/http rather than taken from the
+// OpenTelemetry SDK, which needs Go modules and newer grpc and protobuf
+// libraries than the vendored ones. It only batches the spans, and encodes the
+// fields of Span.
+package tracing
@@ -0,0 +1,253 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// maxBatch is the maximum number of spans exported at once.
+	maxBatch = 512
+	// maxQueue is the maximum number of spans waiting for their export.
+	// Spans ended while the queue is full are dropped.
+	maxQueue = 8 * maxBatch
+	// otlpStatusError is the status code of failed spans in OTLP.
+	otlpStatusError = 2
+)
+
+// OTLPExporter exports the spans to an OpenTelemetry collector over
+// OTLP/HTTP, with the JSON encoding, in batches.
+type OTLPExporter struct {
+	// endpoint is the URL of the traces of the collector, eg:
+	// http://otel-collector:4318/v1/traces.
+	endpoint string
+	// service is the service.name of the resource of the spans.
+	service string
+	client  *http.Client
+	// lock protects queue and dropped.
+	lock    sync.Mutex
+	queue   []*Span
+	dropped int
+}
+
+// NewOTLPExporter returns an exporter of the spans of the given service to
+// the given OTLP/HTTP traces endpoint. The spans are exported by Run.
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export queues the given span for its export.
+func (e *OTLPExporter) Export(span *Span) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.queue) >= maxQueue {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, span)
+}
+
+// Run exports the queued spans every period, until the given channel is
+// closed.
+func (e *OTLPExporter) Run(period time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.flush()
+		case <-stopCh:
+			e.flush()
+			return
+		}
+	}
+}
+
+// flush exports the queued spans.
+func (e *OTLPExporter) flush() {
+	for {
+		e.lock.Lock()
+		batch := e.queue
+		if len(batch) > maxBatch {
+			batch = batch[:maxBatch]
+		}
+		e.queue = e.queue[len(batch):]
+		dropped := e.dropped
+		e.dropped = 0
+		e.lock.Unlock()
+		if dropped > 0 {
+			logging.Warningf("Dropped %d spans, the export queue was full", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			logging.Warningf("Failed to export %d spans to %v: %v", len(batch), e.endpoint, err)
+			return
+		}
+	}
+}
+
+// export sends the given spans to the collector.
+func (e *OTLPExporter) export(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%v: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// The types below are the JSON encoding of the ExportTraceServiceRequest of
+// OTLP.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// request returns the export request of the given spans.
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "k8s.io/ingress-gce"}}
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		}
+		if s.ParentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		s.attrLock.Lock()
+		o.Attributes = attributes(s.Attributes)
+		s.attrLock.Unlock()
+		if s.Err != nil {
+			o.Status = &otlpStatus{Code: otlpStatusError, Message: s.Err.Error()}
+		}
+		scope.Spans = append(scope.Spans, o)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: attributes(map[string]interface{}{"service.name": e.service})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+// attributes returns the OTLP attributes of the given values, sorted by key.
+// Durations are recorded in seconds.
+func attributes(values map[string]interface{}) []otlpAttribute {
+	var attrs []otlpAttribute
+	for k, v := range values {
+		var value otlpValue
+		switch v := v.(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int:
+			i := strconv.Itoa(v)
+			value.IntValue = &i
+		case int64:
+			i := strconv.FormatInt(v, 10)
+			value.IntValue = &i
+		case float64:
+			value.DoubleValue = &v
+		case time.Duration:
+			f := v.Seconds()
+			value.DoubleValue = &f
+		default:
+			str := fmt.Sprint(v)
+			value.StringValue = &str
+		}
+		attrs = append(attrs, otlpAttribute{Key: k, Value: value})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
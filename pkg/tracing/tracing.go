@@ -0,0 +1,281 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the kind of a span, as in OpenTelemetry.
+type Kind int
+
+const (
+	// KindInternal is the kind of the spans of the work of the controller.
+	KindInternal Kind = 1
+	// KindClient is the kind of the spans of the calls to other services,
+	// eg: the GCE API.
+	KindClient Kind = 3
+)
+
+// Exporter exports the ended spans.
+type Exporter interface {
+	Export(span *Span)
+}
+
+var (
+	// enabled is 1 while tracing is enabled, so that the calls of the
+	// controller skip the lookup of their goroutine otherwise.
+	enabled int32
+	// lock protects exporter and bound.
+	lock sync.Mutex
+	// exporter exports the ended spans, nil if tracing is disabled.
+	exporter Exporter
+	// bound are the spans bound to the goroutines by Bind, by goroutine id.
+	bound = map[uint64]*Span{}
+)
+
+// spanKey is the key of the span of a context.
+type spanKey struct{}
+
+// SetExporter enables tracing, exporting the spans through the given
+// exporter. A nil exporter disables tracing.
+func SetExporter(e Exporter) {
+	lock.Lock()
+	defer lock.Unlock()
+	exporter = e
+	if e == nil {
+		atomic.StoreInt32(&enabled, 0)
+	} else {
+		atomic.StoreInt32(&enabled, 1)
+	}
+}
+
+// Enabled returns true if tracing is enabled.
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Span is an operation of a trace. The methods of a nil Span do nothing, so
+// that callers don't check whether tracing is enabled.
+type Span struct {
+	TraceID   [16]byte
+	SpanID    [8]byte
+	ParentID  [8]byte
+	Name      string
+	Kind      Kind
+	StartTime time.Time
+	EndTime   time.Time
+	// Err is the error the operation failed with, nil if it succeeded.
+	Err error
+
+	exporter Exporter
+	root     *Span
+	// attrLock protects Attributes, which children may add to the root, and
+	// operations.
+	attrLock   sync.Mutex
+	Attributes map[string]interface{}
+	// operations are the start times of the GCE operations of the trace
+	// which are not done yet, by name. Only set on the root.
+	operations map[string]time.Time
+}
+
+// StartSpan starts a span of the given name, the child of the span of the
+// given context if any, and returns the context of the span. Returns a nil
+// span if tracing is disabled.
+func StartSpan(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	s := start(FromContext(ctx), name, kind)
+	if s == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span of the given context, nil if none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Bind binds the span of the given context to the calling goroutine, for the
+// calls which take no context, eg: those of the GCE client, until the
+// returned function is called, which restores the previous binding. It must
+// be deferred by the goroutine, so that the span is unbound even if it
+// panics: defer tracing.Bind(ctx)().
+func Bind(ctx context.Context) func() {
+	s := FromContext(ctx)
+	if s == nil || !Enabled() {
+		return func() {}
+	}
+	g := goroutineID()
+	lock.Lock()
+	previous := bound[g]
+	bound[g] = s
+	lock.Unlock()
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if previous == nil {
+			delete(bound, g)
+		} else {
+			bound[g] = previous
+		}
+	}
+}
+
+// StartChildSpan starts a span of the given name, the child of the span of
+// the given context, eg: of an HTTP request, or else of the span bound to the
+// calling goroutine. Returns nil if there is no parent span, or tracing is
+// disabled. The goroutine is only looked up if tracing is enabled and spans
+// are bound.
+func StartChildSpan(ctx context.Context, name string, kind Kind) *Span {
+	if !Enabled() {
+		return nil
+	}
+	parent := FromContext(ctx)
+	if parent == nil {
+		lock.Lock()
+		if len(bound) > 0 {
+			parent = bound[goroutineID()]
+		}
+		lock.Unlock()
+	}
+	if parent == nil {
+		return nil
+	}
+	return start(parent, name, kind)
+}
+
+// start starts a span of the given name, the child of the given parent if
+// not nil. Returns nil if tracing is disabled.
+func start(parent *Span, name string, kind Kind) *Span {
+	lock.Lock()
+	e := exporter
+	lock.Unlock()
+	if e == nil {
+		return nil
+	}
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now(),
+		exporter:   e,
+		Attributes: map[string]interface{}{},
+	}
+	rand.Read(s.SpanID[:])
+	if parent != nil {
+		s.TraceID, s.ParentID, s.root = parent.TraceID, parent.SpanID, parent.root
+	} else {
+		rand.Read(s.TraceID[:])
+		s.root = s
+	}
+	return s
+}
+
+// SetAttribute sets the attribute of the given key of the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrLock.Lock()
+	defer s.attrLock.Unlock()
+	s.Attributes[key] = value
+}
+
+// OperationWaitAttribute is the attribute of the root span summing the time
+// spent waiting for GCE operations.
+const OperationWaitAttribute = "gce.operation_wait_seconds"
+
+// Operation records the state of the GCE operation of the given name
+// returned by the call of the span, eg: by its creation or a poll. The time
+// the trace waits for an operation, from the first call returning it until
+// it is done, is exported as a "wait operation" span, a sibling of the span
+// of the call, and summed in the OperationWaitAttribute of the root.
+func (s *Span) Operation(name string, done bool) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	root := s.root
+	root.attrLock.Lock()
+	if root.operations == nil {
+		root.operations = map[string]time.Time{}
+	}
+	start, pending := root.operations[name]
+	if !done {
+		if !pending {
+			root.operations[name] = now
+		}
+		root.attrLock.Unlock()
+		return
+	}
+	delete(root.operations, name)
+	if pending {
+		total, _ := root.Attributes[OperationWaitAttribute].(time.Duration)
+		root.Attributes[OperationWaitAttribute] = total + now.Sub(start)
+	}
+	root.attrLock.Unlock()
+	if !pending {
+		return
+	}
+	wait := &Span{
+		TraceID:    s.TraceID,
+		ParentID:   s.ParentID,
+		Name:       "wait operation",
+		Kind:       KindInternal,
+		StartTime:  start,
+		EndTime:    now,
+		Attributes: map[string]interface{}{"gce.operation": name},
+	}
+	rand.Read(wait.SpanID[:])
+	s.exporter.Export(wait)
+}
+
+// End ends the span, failed with the given error if not nil, and exports it.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.EndTime, s.Err = time.Now(), err
+	s.exporter.Export(s)
+}
+
+// String returns the name and ids of the span.
+func (s *Span) String() string {
+	return fmt.Sprintf("%v(trace %x, span %x, parent %x)", s.Name, s.TraceID, s.SpanID, s.ParentID)
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the
+// header of its stack: "goroutine 42 [running]:". Goroutine ids are never
+// reused, and Go offers no other goroutine identity.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
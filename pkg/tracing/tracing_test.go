@@ -0,0 +1,240 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExporter records the exported spans.
+type fakeExporter struct {
+	lock  sync.Mutex
+	spans []*Span
+}
+
+func (e *fakeExporter) Export(span *Span) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func TestDisabled(t *testing.T) {
+	SetExporter(nil)
+	ctx, span := StartSpan(context.Background(), "sync", KindInternal)
+	if span != nil {
+		t.Fatalf("StartSpan() = %v, want nil while tracing is disabled", span)
+	}
+	// The methods of nil spans do nothing.
+	span.SetAttribute("k", "v")
+	span.Operation("op", true)
+	span.End(nil)
+	defer Bind(ctx)()
+	if child := StartChildSpan(context.Background(), "GET compute.urlMaps", KindClient); child != nil {
+		t.Errorf("StartChildSpan() = %v, want nil while tracing is disabled", child)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	e := &fakeExporter{}
+	SetExporter(e)
+	defer SetExporter(nil)
+
+	if span := StartChildSpan(context.Background(), "GET compute.urlMaps", KindClient); span != nil {
+		t.Errorf("StartChildSpan() = %v, want nil without a parent", span)
+	}
+	ctx, root := StartSpan(context.Background(), "sync", KindInternal)
+	unbind := Bind(ctx)
+	child := StartChildSpan(context.Background(), "GET compute.urlMaps", KindClient)
+	child.End(nil)
+	// The calls of other goroutines are not part of the trace.
+	done := make(chan *Span)
+	go func() {
+		done <- StartChildSpan(context.Background(), "GET compute.urlMaps", KindClient)
+	}()
+	other := <-done
+	gcCtx, gc := StartSpan(ctx, "gc", KindInternal)
+	unbindGC := Bind(gcCtx)
+	gcChild := StartChildSpan(context.Background(), "GET compute.backendServices", KindClient)
+	gcChild.End(nil)
+	unbindGC()
+	gc.End(fmt.Errorf("failed"))
+	second := StartChildSpan(context.Background(), "GET compute.urlMaps", KindClient)
+	second.End(nil)
+	unbind()
+	root.End(nil)
+
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Errorf("Span %v is not a child of %v", child, root)
+	}
+	if other != nil {
+		t.Errorf("StartChildSpan() = %v in another goroutine, want nil", other)
+	}
+	if gc.TraceID != root.TraceID || gc.ParentID != root.SpanID || gcChild.ParentID != gc.SpanID {
+		t.Errorf("Spans %v and %v are not the descendants of %v", gc, gcChild, root)
+	}
+	if second.ParentID != root.SpanID {
+		t.Errorf("Span %v is not a child of %v, once %v is unbound", second, root, gc)
+	}
+	if len(e.spans) != 5 {
+		t.Errorf("Got %d exported spans, want 5", len(e.spans))
+	}
+	if len(bound) != 0 {
+		t.Errorf("Got spans still bound to goroutines after they were unbound: %v", bound)
+	}
+}
+
+// TestContextSpans tests that the span of the given context is the parent of
+// the child spans, whichever span is bound to the goroutine.
+func TestContextSpans(t *testing.T) {
+	SetExporter(&fakeExporter{})
+	defer SetExporter(nil)
+
+	ctx, root := StartSpan(context.Background(), "sync", KindInternal)
+	reqCtx, req := StartSpan(context.Background(), "request", KindInternal)
+	defer Bind(ctx)()
+	if child := StartChildSpan(reqCtx, "GET compute.urlMaps", KindClient); child == nil || child.ParentID != req.SpanID {
+		t.Errorf("StartChildSpan() = %v, want a child of %v rather than %v", child, req, root)
+	}
+	done := make(chan *Span)
+	go func() {
+		done <- StartChildSpan(reqCtx, "GET compute.urlMaps", KindClient)
+	}()
+	if child := <-done; child == nil || child.ParentID != req.SpanID {
+		t.Errorf("StartChildSpan() = %v in another goroutine, want a child of %v", child, req)
+	}
+}
+
+// TestBindPanic tests that the spans are unbound from goroutines which panic.
+func TestBindPanic(t *testing.T) {
+	SetExporter(&fakeExporter{})
+	defer SetExporter(nil)
+
+	func() {
+		defer func() { recover() }()
+		ctx, span := StartSpan(context.Background(), "sync", KindInternal)
+		defer span.End(nil)
+		defer Bind(ctx)()
+		panic("sync failed")
+	}()
+	if span := StartChildSpan(context.Background(), "GET compute.urlMaps", KindClient); span != nil {
+		t.Errorf("StartChildSpan() = %v, want nil once the span of the panicking sync is unbound", span)
+	}
+}
+
+func TestOperation(t *testing.T) {
+	e := &fakeExporter{}
+	SetExporter(e)
+	defer SetExporter(nil)
+
+	ctx, root := StartSpan(context.Background(), "sync", KindInternal)
+	defer Bind(ctx)()
+	insert := StartChildSpan(context.Background(), "POST compute.urlMaps", KindClient)
+	insert.Operation("op-1", false)
+	insert.End(nil)
+	// An operation done right away isn't waited for.
+	insert = StartChildSpan(context.Background(), "POST compute.firewalls", KindClient)
+	insert.Operation("op-2", true)
+	insert.End(nil)
+	time.Sleep(10 * time.Millisecond)
+	poll := StartChildSpan(context.Background(), "GET compute.operations", KindClient)
+	poll.Operation("op-1", false)
+	poll.End(nil)
+	poll = StartChildSpan(context.Background(), "GET compute.operations", KindClient)
+	poll.Operation("op-1", true)
+	poll.End(nil)
+	root.End(nil)
+
+	var waits []*Span
+	for _, s := range e.spans {
+		if s.Name == "wait operation" {
+			waits = append(waits, s)
+		}
+	}
+	if len(waits) != 1 {
+		t.Fatalf("Got %d operation wait spans, want 1", len(waits))
+	}
+	w := waits[0]
+	if w.Attributes["gce.operation"] != "op-1" || w.TraceID != root.TraceID || w.ParentID != root.SpanID {
+		t.Errorf("Got wait span %v with attributes %v, want the wait for op-1 in the sync", w, w.Attributes)
+	}
+	wait, _ := root.Attributes[OperationWaitAttribute].(time.Duration)
+	if wait < 10*time.Millisecond || wait != w.EndTime.Sub(w.StartTime) {
+		t.Errorf("Got an operation wait of %v in the sync, want the %v of the wait span", wait, w.EndTime.Sub(w.StartTime))
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var lock sync.Mutex
+	var requests []otlpRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(body, &req); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Invalid export request %s: %v", body, err)
+		}
+		lock.Lock()
+		requests = append(requests, req)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	e := NewOTLPExporter(server.URL, "glbc")
+	SetExporter(e)
+	defer SetExporter(nil)
+	ctx, root := StartSpan(context.Background(), "sync", KindInternal)
+	root.SetAttribute("ingress", "default/foo")
+	_, child := StartSpan(ctx, "GET compute.urlMaps", KindClient)
+	child.SetAttribute("http.status_code", 404)
+	child.End(fmt.Errorf("404 Not Found"))
+	root.End(nil)
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+	e.Run(time.Hour, stopCh)
+
+	if len(requests) != 1 {
+		t.Fatalf("Got %d export requests, want 1", len(requests))
+	}
+	rs := requests[0].ResourceSpans
+	if len(rs) != 1 || len(rs[0].ScopeSpans) != 1 || len(rs[0].ScopeSpans[0].Spans) != 2 {
+		t.Fatalf("Got export request %+v, want 2 spans", requests[0])
+	}
+	if attrs := rs[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != "glbc" {
+		t.Errorf("Got resource attributes %+v, want the service name", attrs)
+	}
+	gotChild, gotRoot := rs[0].ScopeSpans[0].Spans[0], rs[0].ScopeSpans[0].Spans[1]
+	if gotChild.TraceID != gotRoot.TraceID || gotChild.ParentSpanID != gotRoot.SpanID || gotRoot.ParentSpanID != "" || len(gotRoot.TraceID) != 32 || len(gotRoot.SpanID) != 16 {
+		t.Errorf("Got spans %+v and %+v, want the child of the root", gotChild, gotRoot)
+	}
+	if gotChild.Status == nil || gotChild.Status.Code != otlpStatusError || gotChild.Kind != KindClient {
+		t.Errorf("Got child span %+v, want a failed client span", gotChild)
+	}
+	if len(gotChild.Attributes) != 1 || *gotChild.Attributes[0].Value.IntValue != "404" {
+		t.Errorf("Got child attributes %+v, want the status code", gotChild.Attributes)
+	}
+	if gotRoot.Status != nil || len(gotRoot.Attributes) != 1 || *gotRoot.Attributes[0].Value.StringValue != "default/foo" {
+		t.Errorf("Got root span %+v, want a successful span of the Ingress", gotRoot)
+	}
+}
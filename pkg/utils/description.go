@@ -0,0 +1,54 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+)
+
+// Description is the ownership metadata the controller records, as JSON, in
+// the description of the GCE resources it creates. It ties resources to the
+// cluster and to the Kubernetes object they were created for, so resources
+// left behind by a crash can be found and garbage collected.
+type Description struct {
+	// ServiceName and ServicePort identify the Service port of backend
+	// services, as namespace/name and port.
+	ServiceName string `json:"kubernetes.io/service-name,omitempty"`
+	ServicePort string `json:"kubernetes.io/service-port,omitempty"`
+	// ClusterUID is the UID of the cluster owning the resource.
+	ClusterUID string `json:"kubernetes.io/cluster-uid"`
+	// IngressName is the namespace/name of the Ingress of load balancer
+	// resources.
+	IngressName string `json:"kubernetes.io/ingress-name,omitempty"`
+}
+
+// String returns the description as JSON.
+func (d Description) String() string {
+	desc, _ := json.Marshal(d)
+	return string(desc)
+}
+
+// ParseDescription parses the given resource description. Returns an error if
+// the description is not ownership metadata, eg: resources created by older
+// versions of the controller or manually.
+func ParseDescription(s string) (*Description, error) {
+	d := &Description{}
+	if err := json.Unmarshal([]byte(s), d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
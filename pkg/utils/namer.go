@@ -18,32 +18,50 @@ package utils
 
 import (
 	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 
-	"github.com/golang/glog"
+	"k8s.io/ingress-gce/pkg/logging"
 )
 
 const (
+	// DefaultPrefix is the default prefix of the names of all the resources
+	// created by the namer.
+	DefaultPrefix = "k8s"
+	// MaxPrefixLen is the maximum length of a custom prefix. Longer prefixes
+	// would leave too little room for the namespace and name of the Ingress
+	// in the resource names.
+	MaxPrefixLen = 8
+
+	// The resource prefixes below follow the prefix of the namer, eg:
+	// "k8s-tp".
+
 	// A single target proxy/urlmap/forwarding rule is created per loadbalancer.
 	// Tagged with the namespace/name of the Ingress.
-	targetHTTPProxyPrefix  = "k8s-tp"
-	targetHTTPSProxyPrefix = "k8s-tps"
-	sslCertPrefix          = "k8s-ssl"
+	targetHTTPProxyPrefix  = "tp"
+	targetHTTPSProxyPrefix = "tps"
+	sslCertPrefix          = "ssl"
+	// SSLCertHashLen is the length of the hash of the contents of the
+	// certificates named by HashedSSLCert.
+	SSLCertHashLen = 16
 	// TODO: this should really be "fr" and "frs".
-	forwardingRulePrefix      = "k8s-fw"
-	httpsForwardingRulePrefix = "k8s-fws"
-	urlMapPrefix              = "k8s-um"
+	forwardingRulePrefix          = "fw"
+	httpsForwardingRulePrefix     = "fws"
+	ipv6ForwardingRulePrefix      = "fw6"
+	ipv6HTTPSForwardingRulePrefix = "fws6"
+	urlMapPrefix                  = "um"
+	redirectUrlMapPrefix          = "rm"
 
 	// This allows sharing of backends across loadbalancers.
-	backendPrefix = "k8s-be"
-	backendRegex  = "k8s-be-([0-9]+).*"
+	backendPrefix = "be"
+	backendRegex  = "%v-be-([0-9]+).*"
 
 	// Prefix used for instance groups involved in L7 balancing.
-	igPrefix = "k8s-ig"
+	igPrefix = "ig"
 
 	// Suffix used in the l7 firewall rule. There is currently only one.
 	// Note that this name is used by the cloudprovider lib that inserts
@@ -72,12 +90,43 @@ const (
 	DefaultBackendKey = "DefaultBackend"
 
 	// maxNEGDescriptiveLabel is the max length for namespace, name and
-	// port for neg name.  63 - 5 (k8s and naming schema version prefix)
+	// port for neg name, with the default prefix.  63 - 5 (k8s and naming
+	// schema version prefix)
 	// - 16 (cluster id) - 8 (suffix hash) - 4 (hyphen connector) = 30
 	maxNEGDescriptiveLabel = 30
 
 	// schemaVersionV1 is the version 1 naming scheme for NEG
 	schemaVersionV1 = "1"
+
+	// schemaVersionL4 is the naming scheme of the L4 load balancers.
+	schemaVersionL4 = "2"
+	// l4HealthCheckFirewallSuffix suffixes the firewall rule letting the
+	// health checks reach the nodes of an L4 load balancer.
+	l4HealthCheckFirewallSuffix = "-hc"
+	// maxL4DescriptiveLabel is the max length for namespace and name in the
+	// L4 load balancer names, with the default prefix. 63 - 5 (k8s and
+	// naming schema version prefix) - 16 (cluster id) - 8 (suffix hash) - 3
+	// (hyphen connector) - 3 (health check firewall suffix) = 28
+	maxL4DescriptiveLabel = 28
+
+	// lbHashLen is the length of the hash of the Ingress key in the load
+	// balancer names of the V2 scheme.
+	lbHashLen = 8
+)
+
+// NamingScheme is the scheme of the names of the GCE resources of a load
+// balancer.
+type NamingScheme string
+
+const (
+	// NamingSchemeV1 names the resources after the namespace and name of the
+	// Ingress, truncated to the GCE limit, so that Ingresses with long names
+	// may collide.
+	NamingSchemeV1 NamingScheme = "v1"
+	// NamingSchemeV2 names the resources after the trimmed namespace and
+	// name of the Ingress, followed by a hash of both, so that the names are
+	// never truncated.
+	NamingSchemeV2 NamingScheme = "v2"
 )
 
 // NamerProtocol is an enum for the different protocols given as
@@ -95,17 +144,58 @@ type Namer struct {
 	nameLock     sync.Mutex
 	clusterName  string
 	firewallName string
+	// prefix of the names of all the resources, DefaultPrefix if empty. It
+	// never changes once the namer is created.
+	prefix string
+	// namingSchemes are the naming schemes of the load balancers, keyed by
+	// Ingress key. Load balancers default to NamingSchemeV1.
+	namingSchemes map[string]NamingScheme
 }
 
 // NewNamer creates a new namer with a Cluster and Firewall name.
 func NewNamer(clusterName, firewallName string) *Namer {
-	namer := &Namer{}
+	return NewNamerWithPrefix(clusterName, firewallName, DefaultPrefix)
+}
+
+// NewNamerWithPrefix creates a new namer with a Cluster and Firewall name,
+// naming the resources with the given prefix instead of DefaultPrefix. The
+// prefix must be valid, see ValidatePrefix.
+func NewNamerWithPrefix(clusterName, firewallName, prefix string) *Namer {
+	namer := &Namer{prefix: prefix}
 	namer.SetUID(clusterName)
 	namer.SetFirewall(firewallName)
 
 	return namer
 }
 
+// prefixRegexp matches the valid prefixes: GCE names must start with a
+// letter, and hyphens would be confused with the separators of the names.
+var prefixRegexp = regexp.MustCompile(fmt.Sprintf("^[a-z][a-z0-9]{0,%d}$", MaxPrefixLen-1))
+
+// ValidatePrefix returns an error if the given prefix of resource names is
+// invalid: it must be a lowercase letter followed by at most MaxPrefixLen-1
+// lowercase letters or digits, so that the names fit the GCE limits.
+func ValidatePrefix(prefix string) error {
+	if !prefixRegexp.MatchString(prefix) {
+		return fmt.Errorf("invalid resource name prefix %q, must be a lowercase letter followed by at most %d lowercase letters or digits", prefix, MaxPrefixLen-1)
+	}
+	return nil
+}
+
+// Prefix returns the prefix of the names of the resources.
+func (n *Namer) Prefix() string {
+	if n.prefix == "" {
+		return DefaultPrefix
+	}
+	return n.prefix
+}
+
+// withPrefix returns the given resource prefix following the prefix of the
+// namer, eg: "k8s-um".
+func (n *Namer) withPrefix(resourcePrefix string) string {
+	return n.Prefix() + "-" + resourcePrefix
+}
+
 // NameComponents is a struct representing the components of a a GCE
 // resource name constructed by the namer. The format of such a name
 // is: k8s-resource-<metadata, eg port>--uid
@@ -120,10 +210,10 @@ func (n *Namer) SetUID(name string) {
 
 	if strings.Contains(name, clusterNameDelimiter) {
 		tokens := strings.Split(name, clusterNameDelimiter)
-		glog.Warningf("Given name %v contains %v, taking last token in: %+v", name, clusterNameDelimiter, tokens)
+		logging.Warningf("Given name %v contains %v, taking last token in: %+v", name, clusterNameDelimiter, tokens)
 		name = tokens[len(tokens)-1]
 	}
-	glog.Infof("Changing cluster name from %v to %v", n.clusterName, name)
+	logging.Infof("Changing cluster name from %v to %v", n.clusterName, name)
 	n.clusterName = name
 }
 
@@ -133,7 +223,7 @@ func (n *Namer) SetFirewall(name string) {
 	defer n.nameLock.Unlock()
 
 	if n.firewallName != name {
-		glog.Infof("Changing firewall name from %v to %v", n.firewallName, name)
+		logging.Infof("Changing firewall name from %v to %v", n.firewallName, name)
 		n.firewallName = name
 	}
 }
@@ -145,6 +235,25 @@ func (n *Namer) UID() string {
 	return n.clusterName
 }
 
+// SetNamingSchemes sets the naming schemes of the load balancers, keyed by
+// Ingress key. The load balancers of other keys use NamingSchemeV1.
+func (n *Namer) SetNamingSchemes(schemes map[string]NamingScheme) {
+	n.nameLock.Lock()
+	defer n.nameLock.Unlock()
+	n.namingSchemes = schemes
+}
+
+// namingScheme returns the naming scheme of the load balancer of the given
+// key.
+func (n *Namer) namingScheme(key string) NamingScheme {
+	n.nameLock.Lock()
+	defer n.nameLock.Unlock()
+	if scheme, ok := n.namingSchemes[key]; ok {
+		return scheme
+	}
+	return NamingSchemeV1
+}
+
 // GetFirewallName returns the firewall name of this cluster.
 func (n *Namer) Firewall() string {
 	n.nameLock.Lock()
@@ -201,7 +310,7 @@ func (n *Namer) ParseName(name string) *NameComponents {
 // NameBelongsToCluster checks if a given name is tagged with this
 // cluster's UID.
 func (n *Namer) NameBelongsToCluster(name string) bool {
-	if !strings.HasPrefix(name, "k8s-") {
+	if !strings.HasPrefix(name, n.Prefix()+"-") {
 		return false
 	}
 
@@ -220,12 +329,32 @@ func (n *Namer) NameBelongsToCluster(name string) bool {
 
 // BeName constructs the name for a backend.
 func (n *Namer) Backend(port int64) string {
-	return n.decorateName(fmt.Sprintf("%v-%d", backendPrefix, port))
+	return n.decorateName(fmt.Sprintf("%v-%d", n.withPrefix(backendPrefix), port))
+}
+
+// ServerlessBackend constructs the name of the backend service of the
+// serverless NEG with the given region and name. The name does not contain a
+// port, since serverless backends have no node port.
+func (n *Namer) ServerlessBackend(region, neg string) string {
+	return n.decorateName(fmt.Sprintf("%v-sneg-%v", n.withPrefix(backendPrefix), negSuffix(region, neg, "")))
+}
+
+// InternetBackend constructs the name of the backend service, and of the
+// internet NEG, of the given port of an ExternalName Service. The name does
+// not contain a port, since internet backends have no node port.
+func (n *Namer) InternetBackend(namespace, name, port string) string {
+	return n.decorateName(fmt.Sprintf("%v-ineg-%v", n.withPrefix(backendPrefix), negSuffix(namespace, name, port)))
+}
+
+// IsInternetBackend returns true if the given backend service name is the
+// name of an internet backend of this cluster.
+func (n *Namer) IsInternetBackend(name string) bool {
+	return strings.HasPrefix(name, n.withPrefix(backendPrefix)+"-ineg-") && n.NameBelongsToCluster(name)
 }
 
 // BackendPort retrieves the port from the given backend name.
 func (n *Namer) BackendPort(beName string) (string, error) {
-	r, err := regexp.Compile(backendRegex)
+	r, err := regexp.Compile(fmt.Sprintf(backendRegex, regexp.QuoteMeta(n.Prefix())))
 	if err != nil {
 		return "", err
 	}
@@ -242,7 +371,7 @@ func (n *Namer) BackendPort(beName string) (string, error) {
 
 // InstanceGroup constructs the name for an Instance Group.
 func (n *Namer) InstanceGroup() string {
-	return n.decorateName(igPrefix)
+	return n.decorateName(n.withPrefix(igPrefix))
 }
 
 // firewallRuleSuffix constructs the glbc specific suffix for the FirewallRule.
@@ -259,12 +388,36 @@ func (n *Namer) firewallRuleSuffix() string {
 // assigned by the cloudprovider lib + suffix from glbc, so we don't
 // mix this rule with a rule created for L4 loadbalancing.
 func (n *Namer) FirewallRule() string {
-	return fmt.Sprintf("k8s-fw-%s", n.firewallRuleSuffix())
+	return fmt.Sprintf("%s-fw-%s", n.Prefix(), n.firewallRuleSuffix())
+}
+
+// NEGFirewallRule constructs the name of the firewall rule that opens the
+// pod ports of NEG backends, as opposed to the node ports opened by the rule
+// returned by FirewallRule.
+func (n *Namer) NEGFirewallRule() string {
+	return truncate(fmt.Sprintf("%s-fw-neg-%s", n.Prefix(), n.firewallRuleSuffix()))
 }
 
-// LoadBalancer constructs a loadbalancer name from the given key. The key
-// is usually the namespace/name of a Kubernetes Ingress.
+// IPv6FirewallRule constructs the name of the firewall rule that opens the
+// node ports to IPv6 source ranges. GCE firewall rules can't mix IPv4 and
+// IPv6 source ranges.
+func (n *Namer) IPv6FirewallRule() string {
+	return truncate(fmt.Sprintf("%s-fw-ipv6-%s", n.Prefix(), n.firewallRuleSuffix()))
+}
+
+// IPv6NEGFirewallRule constructs the name of the firewall rule that opens the
+// pod ports of NEG backends to IPv6 source ranges.
+func (n *Namer) IPv6NEGFirewallRule() string {
+	return truncate(fmt.Sprintf("%s-fw-neg-ipv6-%s", n.Prefix(), n.firewallRuleSuffix()))
+}
+
+// LoadBalancer constructs a loadbalancer name from the given key, following
+// the naming scheme of the key. The key is usually the namespace/name of a
+// Kubernetes Ingress. A loadbalancer name is returned as is.
 func (n *Namer) LoadBalancer(key string) string {
+	if n.namingScheme(key) == NamingSchemeV2 {
+		return n.LoadBalancerV2(key)
+	}
 	// TODO: Pipe the clusterName through, for now it saves code churn
 	// to just grab it globally, especially since we haven't decided how
 	// to handle namespace conflicts in the Ubernetes context.
@@ -277,48 +430,149 @@ func (n *Namer) LoadBalancer(key string) string {
 	return truncate(fmt.Sprintf("%v%v%v", scrubbedName, clusterNameDelimiter, clusterName))
 }
 
+// LoadBalancerV2 constructs the loadbalancer name of the given key following
+// NamingSchemeV2: {namespace}-{name}-{hash}--{cluster uid}. The namespace and
+// name are trimmed so that the names of all the resources of the loadbalancer
+// fit the GCE limit, the hash of the key keeps them unique.
+func (n *Namer) LoadBalancerV2(key string) string {
+	// The keys of LB groups are /namespace/group, or /group.
+	namespace, name := "", strings.TrimPrefix(key, "/")
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		namespace, name = parts[0], parts[1]
+	}
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(key)))[:lbHashLen]
+	suffix := ""
+	if clusterName := n.UID(); clusterName != "" {
+		suffix = clusterNameDelimiter + clusterName
+	}
+	// The longest resource prefix, followed by a hyphen, is the one of the
+	// IPv6 HTTPS forwarding rule. Two hyphens separate the namespace, name
+	// and hash.
+	maxLabel := nameLenLimit - len(n.withPrefix(ipv6HTTPSForwardingRulePrefix)) - 1 - len(suffix) - lbHashLen - 2
+	if maxLabel < 2 {
+		maxLabel = 2
+	}
+	// The keys without a namespace only have a name.
+	fields := []string{namespace, name}
+	if namespace == "" {
+		fields = fields[1:]
+	}
+	fields = trimFieldsEvenly(maxLabel, fields...)
+	for i := range fields {
+		fields[i] = scrubDelimiter(fields[i])
+	}
+	return fmt.Sprintf("%v-%v%v", strings.Join(fields, "-"), hash, suffix)
+}
+
+// scrubDelimiter removes the consecutive and trailing hyphens of the given
+// trimmed field, which would be confused with clusterNameDelimiter.
+func scrubDelimiter(field string) string {
+	for strings.Contains(field, clusterNameDelimiter) {
+		field = strings.Replace(field, clusterNameDelimiter, "-", -1)
+	}
+	return strings.Trim(field, "-")
+}
+
 // TargetProxy returns the name for target proxy given the load
 // balancer name and the protocol.
 func (n *Namer) TargetProxy(lbName string, protocol NamerProtocol) string {
 	switch protocol {
 	case HTTPProtocol:
-		return truncate(fmt.Sprintf("%v-%v", targetHTTPProxyPrefix, lbName))
+		return truncate(fmt.Sprintf("%v-%v", n.withPrefix(targetHTTPProxyPrefix), lbName))
 	case HTTPSProtocol:
-		return truncate(fmt.Sprintf("%v-%v", targetHTTPSProxyPrefix, lbName))
+		return truncate(fmt.Sprintf("%v-%v", n.withPrefix(targetHTTPSProxyPrefix), lbName))
 	}
-	glog.Fatalf("Invalid TargetProxy protocol: %v", protocol)
+	logging.Fatalf("Invalid TargetProxy protocol: %v", protocol)
 	return "invalid"
 }
 
 // IsSSLCert returns true if certName is an Ingress managed name.
 func (n *Namer) IsSSLCert(name string) bool {
-	return strings.HasPrefix(name, sslCertPrefix)
+	return strings.HasPrefix(name, n.withPrefix(sslCertPrefix))
 }
 
 // SSLCert returns the name of the certificate. isPrimary denotes
 // whether the name is for a primary certificate or secondary.
 func (n *Namer) SSLCert(lbName string, isPrimary bool) string {
 	if isPrimary {
-		return truncate(fmt.Sprintf("%v-%v", sslCertPrefix, lbName))
+		return truncate(fmt.Sprintf("%v-%v", n.withPrefix(sslCertPrefix), lbName))
+	}
+	return truncate(fmt.Sprintf("%v-%d-%v", n.withPrefix(sslCertPrefix), 1, lbName))
+}
+
+// SSLCertAt returns the name of the certificate at the given index on the
+// target HTTPS proxy. The first certificate keeps the names of SSLCert.
+func (n *Namer) SSLCertAt(lbName string, index int, isPrimary bool) string {
+	if index == 0 {
+		return n.SSLCert(lbName, isPrimary)
+	}
+	slot := 2 * index
+	if !isPrimary {
+		slot++
 	}
-	return truncate(fmt.Sprintf("%v-%d-%v", sslCertPrefix, 1, lbName))
+	return truncate(fmt.Sprintf("%v-%d-%v", n.withPrefix(sslCertPrefix), slot, lbName))
+}
+
+// HashedSSLCert returns the name of the certificate with the given hash of
+// its contents. The loadbalancers serving the same contents share the
+// certificate.
+func (n *Namer) HashedSSLCert(hash string) string {
+	return n.decorateName(fmt.Sprintf("%v-%v", n.withPrefix(sslCertPrefix), hash))
+}
+
+// IsHashedSSLCert returns true if the name is a certificate of the cluster
+// named by HashedSSLCert.
+func (n *Namer) IsHashedSSLCert(name string) bool {
+	if !n.NameBelongsToCluster(name) {
+		return false
+	}
+	hash := strings.Split(name, clusterNameDelimiter)[0]
+	if !strings.HasPrefix(hash, n.withPrefix(sslCertPrefix)+"-") {
+		return false
+	}
+	hash = strings.TrimPrefix(hash, n.withPrefix(sslCertPrefix)+"-")
+	if len(hash) != SSLCertHashLen {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
 }
 
 // ForwardingRule returns the name of the forwarding rule prefix.
 func (n *Namer) ForwardingRule(lbName string, protocol NamerProtocol) string {
 	switch protocol {
 	case HTTPProtocol:
-		return truncate(fmt.Sprintf("%v-%v", forwardingRulePrefix, lbName))
+		return truncate(fmt.Sprintf("%v-%v", n.withPrefix(forwardingRulePrefix), lbName))
 	case HTTPSProtocol:
-		return truncate(fmt.Sprintf("%v-%v", httpsForwardingRulePrefix, lbName))
+		return truncate(fmt.Sprintf("%v-%v", n.withPrefix(httpsForwardingRulePrefix), lbName))
 	}
-	glog.Fatalf("invalid ForwardingRule protocol: %q", protocol)
+	logging.Fatalf("invalid ForwardingRule protocol: %q", protocol)
+	return "invalid"
+}
+
+// IPv6ForwardingRule returns the name of the IPv6 forwarding rule of the
+// given load balancer and protocol. The IPv6 address reserved for the load
+// balancer shares the name of the HTTP rule.
+func (n *Namer) IPv6ForwardingRule(lbName string, protocol NamerProtocol) string {
+	switch protocol {
+	case HTTPProtocol:
+		return truncate(fmt.Sprintf("%v-%v", n.withPrefix(ipv6ForwardingRulePrefix), lbName))
+	case HTTPSProtocol:
+		return truncate(fmt.Sprintf("%v-%v", n.withPrefix(ipv6HTTPSForwardingRulePrefix), lbName))
+	}
+	logging.Fatalf("invalid IPv6ForwardingRule protocol: %q", protocol)
 	return "invalid"
 }
 
 // UrlMap returns the name for the UrlMap for a given load balancer.
 func (n *Namer) UrlMap(lbName string) string {
-	return truncate(fmt.Sprintf("%v-%v", urlMapPrefix, lbName))
+	return truncate(fmt.Sprintf("%v-%v", n.withPrefix(urlMapPrefix), lbName))
+}
+
+// RedirectUrlMap returns the name of the UrlMap redirecting the HTTP requests
+// of the given load balancer to HTTPS.
+func (n *Namer) RedirectUrlMap(lbName string) string {
+	return truncate(fmt.Sprintf("%v-%v", n.withPrefix(redirectUrlMapPrefix), lbName))
 }
 
 // NamedPort returns the name for a named port.
@@ -338,7 +592,9 @@ func (n *Namer) NamedPort(port int64) string {
 // of all NEGs associated with the current cluster. Any modifications
 // must be backward compatible.
 func (n *Namer) NEG(namespace, name, port string) string {
-	trimmedFields := trimFieldsEvenly(maxNEGDescriptiveLabel, namespace, name, port)
+	// Longer prefixes leave less room, the names of the default prefix
+	// never change.
+	trimmedFields := trimFieldsEvenly(maxNEGDescriptiveLabel-(len(n.Prefix())-len(DefaultPrefix)), namespace, name, port)
 	trimedNamespace := trimmedFields[0]
 	trimedName := trimmedFields[1]
 	trimedPort := trimmedFields[2]
@@ -350,8 +606,82 @@ func (n *Namer) IsNEG(name string) bool {
 	return strings.HasPrefix(name, n.negPrefix())
 }
 
+// IsForeignNEG returns true if the name is a NEG owned by another cluster,
+// eg: a member of a multi-cluster Ingress.
+func (n *Namer) IsForeignNEG(name string) bool {
+	return strings.HasPrefix(name, fmt.Sprintf("%s%s-", n.Prefix(), schemaVersionV1)) && !n.IsNEG(name)
+}
+
 func (n *Namer) negPrefix() string {
-	return fmt.Sprintf("k8s%s-%s", schemaVersionV1, n.UID())
+	return fmt.Sprintf("%s%s-%s", n.Prefix(), schemaVersionV1, n.UID())
+}
+
+// L4 returns the name of the forwarding rule, backend service, health check
+// and firewall rule of the L4 load balancer of the given Service:
+// {prefix}2-{cluster uid}-{namespace}-{name}-{hash}, at most 60 characters.
+func (n *Namer) L4(namespace, name string) string {
+	trimmedFields := trimFieldsEvenly(maxL4DescriptiveLabel-(len(n.Prefix())-len(DefaultPrefix)), namespace, name)
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(namespace+"/"+name)))[:8]
+	return fmt.Sprintf("%s%s-%s-%s-%s-%s", n.Prefix(), schemaVersionL4, n.UID(), trimmedFields[0], trimmedFields[1], hash)
+}
+
+// L4Prefix returns the prefix of the names of the L4 load balancers of the
+// cluster, {prefix}2-{cluster uid}-, shared by the PSC service attachments
+// and the regional HTTP(S) load balancers.
+func (n *Namer) L4Prefix() string {
+	return fmt.Sprintf("%s%s-%s-", n.Prefix(), schemaVersionL4, n.UID())
+}
+
+// L4HealthCheckFirewall returns the name of the firewall rule letting the
+// health checks reach the nodes of the L4 load balancer of the given Service.
+func (n *Namer) L4HealthCheckFirewall(namespace, name string) string {
+	return n.L4(namespace, name) + l4HealthCheckFirewallSuffix
+}
+
+// ServiceAttachment returns the name of the PSC service attachment of the
+// given ServiceAttachment resource:
+// {prefix}2-{cluster uid}-sa-{namespace}-{name}-{hash}, at most 63 characters.
+func (n *Namer) ServiceAttachment(namespace, name string) string {
+	trimmedFields := trimFieldsEvenly(maxL4DescriptiveLabel-(len(n.Prefix())-len(DefaultPrefix)), namespace, name)
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(namespace+"/"+name)))[:8]
+	return fmt.Sprintf("%s%s-%s-sa-%s-%s-%s", n.Prefix(), schemaVersionL4, n.UID(), trimmedFields[0], trimmedFields[1], hash)
+}
+
+// RegionalL7 returns the name of the GCE resource of the given kind of the
+// regional HTTP(S) load balancer of the given Ingress:
+// {prefix}2-{cluster uid}-{kind}-{namespace}-{name}-{hash}, at most 63
+// characters with a 2 characters kind.
+func (n *Namer) RegionalL7(kind, namespace, name string) string {
+	trimmedFields := trimFieldsEvenly(maxL4DescriptiveLabel-(len(n.Prefix())-len(DefaultPrefix)), namespace, name)
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(namespace+"/"+name)))[:8]
+	return fmt.Sprintf("%s%s-%s-%s-%s-%s-%s", n.Prefix(), schemaVersionL4, n.UID(), kind, trimmedFields[0], trimmedFields[1], hash)
+}
+
+// RegionalL7Cert returns the name of the regional SSL certificate of the
+// given Ingress with the given certificate. Regional SSL certificates can't
+// be changed, so their name changes with the certificate.
+func (n *Namer) RegionalL7Cert(namespace, name, cert string) string {
+	trimmedFields := trimFieldsEvenly(maxL4DescriptiveLabel-(len(n.Prefix())-len(DefaultPrefix)), namespace, name)
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(namespace+"/"+name+"/"+cert)))[:8]
+	return fmt.Sprintf("%s%s-%s-cr-%s-%s-%s", n.Prefix(), schemaVersionL4, n.UID(), trimmedFields[0], trimmedFields[1], hash)
+}
+
+// RegionalL7Backend returns the name of the regional backend service and
+// health check of the given kind of the given target port of the given
+// Service, shared by the regional HTTP(S) load balancers:
+// {prefix}2-{cluster uid}-{kind}-{namespace}-{name}-{port}-{hash}, at most
+// 63 characters with a 2 characters kind.
+func (n *Namer) RegionalL7Backend(kind, namespace, name, port string) string {
+	// One less character, for the hyphen before the port.
+	trimmedFields := trimFieldsEvenly(maxL4DescriptiveLabel-1-(len(n.Prefix())-len(DefaultPrefix)), namespace, name, port)
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(namespace+"/"+name+"/"+port)))[:8]
+	return fmt.Sprintf("%s%s-%s-%s-%s-%s-%s-%s", n.Prefix(), schemaVersionL4, n.UID(), kind, trimmedFields[0], trimmedFields[1], trimmedFields[2], hash)
+}
+
+// IsRegionalL7Backend returns true if the given name is a regional backend
+// service of the given kind owned by this cluster.
+func (n *Namer) IsRegionalL7Backend(kind, name string) bool {
+	return strings.HasPrefix(name, fmt.Sprintf("%s%s-%s-%s-", n.Prefix(), schemaVersionL4, n.UID(), kind))
 }
 
 // negSuffix returns hash code with 8 characters
@@ -16,7 +16,10 @@ limitations under the License.
 
 package utils
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 const (
 	longString = "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"
@@ -86,9 +89,14 @@ func TestNamerParseName(t *testing.T) {
 		{namer.TargetProxy(lbName, HTTPSProtocol), &NameComponents{uid, "tps", ""}},
 		{namer.SSLCert(lbName, true), &NameComponents{uid, "ssl", ""}},
 		{namer.SSLCert(lbName, false), &NameComponents{uid, "ssl", ""}},
+		{namer.SSLCertAt(lbName, 1, true), &NameComponents{uid, "ssl", ""}},
+		{namer.SSLCertAt(lbName, 1, false), &NameComponents{uid, "ssl", ""}},
 		{namer.ForwardingRule(lbName, HTTPProtocol), &NameComponents{uid, "fw", ""}},
 		{namer.ForwardingRule(lbName, HTTPSProtocol), &NameComponents{uid, "fws", ""}},
+		{namer.IPv6ForwardingRule(lbName, HTTPProtocol), &NameComponents{uid, "fw6", ""}},
+		{namer.IPv6ForwardingRule(lbName, HTTPSProtocol), &NameComponents{uid, "fws6", ""}},
 		{namer.UrlMap(lbName), &NameComponents{uid, "um", ""}},
+		{namer.RedirectUrlMap(lbName), &NameComponents{uid, "rm", ""}},
 	} {
 		nc := namer.ParseName(tc.in)
 		if *nc != *tc.want {
@@ -110,9 +118,14 @@ func TestNameBelongsToCluster(t *testing.T) {
 		namer.TargetProxy(lbName, HTTPSProtocol),
 		namer.SSLCert(lbName, true),
 		namer.SSLCert(lbName, false),
+		namer.SSLCertAt(lbName, 1, true),
+		namer.SSLCertAt(lbName, 1, false),
 		namer.ForwardingRule(lbName, HTTPProtocol),
 		namer.ForwardingRule(lbName, HTTPSProtocol),
+		namer.IPv6ForwardingRule(lbName, HTTPProtocol),
+		namer.IPv6ForwardingRule(lbName, HTTPSProtocol),
 		namer.UrlMap(lbName),
+		namer.RedirectUrlMap(lbName),
 	} {
 		if !namer.NameBelongsToCluster(tc) {
 			t.Errorf("namer.NameBelongsToCluster(%q) = false, want true", tc)
@@ -154,6 +167,20 @@ func TestNamerBackend(t *testing.T) {
 	}
 }
 
+func TestNamerServerlessBackend(t *testing.T) {
+	namer := NewNamer("uid1", "fw1")
+	name := namer.ServerlessBackend("us-central1", "neg1")
+	if !strings.HasPrefix(name, "k8s-be-sneg-") || !strings.HasSuffix(name, "--uid1") {
+		t.Errorf("namer.ServerlessBackend() = %q, want k8s-be-sneg-<hash>--uid1", name)
+	}
+	if _, err := namer.BackendPort(name); err == nil {
+		t.Errorf("namer.BackendPort(%q) = nil error, want error", name)
+	}
+	if other := namer.ServerlessBackend("us-east1", "neg1"); other == name {
+		t.Errorf("namer.ServerlessBackend() = %q for NEGs in different regions", name)
+	}
+}
+
 func TestNamerInstanceGroup(t *testing.T) {
 	namer := NewNamer("uid1", "fw1")
 	name := namer.InstanceGroup()
@@ -170,6 +197,153 @@ func TestNamerFirewallRule(t *testing.T) {
 	}
 }
 
+func TestNamerNEGFirewallRule(t *testing.T) {
+	namer := NewNamer("uid1", "fw1")
+	name := namer.NEGFirewallRule()
+	if name != "k8s-fw-neg-l7--fw1" {
+		t.Errorf("namer.NEGFirewallRule() = %q, want %q", name, "k8s-fw-neg-l7--fw1")
+	}
+}
+
+func TestNamerIPv6FirewallRules(t *testing.T) {
+	namer := NewNamer("uid1", "fw1")
+	if name := namer.IPv6FirewallRule(); name != "k8s-fw-ipv6-l7--fw1" {
+		t.Errorf("namer.IPv6FirewallRule() = %q, want %q", name, "k8s-fw-ipv6-l7--fw1")
+	}
+	if name := namer.IPv6NEGFirewallRule(); name != "k8s-fw-neg-ipv6-l7--fw1" {
+		t.Errorf("namer.IPv6NEGFirewallRule() = %q, want %q", name, "k8s-fw-neg-ipv6-l7--fw1")
+	}
+}
+
+func TestNamerHashedSSLCert(t *testing.T) {
+	namer := NewNamer("uid1", "fw1")
+	name := namer.HashedSSLCert("0123456789abcdef")
+	if name != "k8s-ssl-0123456789abcdef--uid1" {
+		t.Errorf("namer.HashedSSLCert() = %q, want %q", name, "k8s-ssl-0123456789abcdef--uid1")
+	}
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{name, true},
+		{"k8s-ssl-0123456789abcdef--uid2", false},
+		{"k8s-ssl-foo--uid1", false},
+		{"k8s-ssl-1-foo--uid1", false},
+		{"k8s-ssl-0123456789abcdeg--uid1", false},
+	} {
+		if got := namer.IsHashedSSLCert(tc.name); got != tc.want {
+			t.Errorf("namer.IsHashedSSLCert(%q) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNamerLoadBalancerV2(t *testing.T) {
+	longstring := "01234567890123456789012345678901234567890123456789"
+	namer := NewNamer("0123456789abcdef", "fw1")
+	keys := []string{
+		"namespace/name",
+		longstring + "/" + longstring + "-a",
+		longstring + "/" + longstring + "-b",
+		"ns/double--dash-",
+		"/group",
+		"/namespace/name",
+	}
+	names := map[string]string{}
+	for _, key := range keys {
+		name := namer.LoadBalancerV2(key)
+		if other, ok := names[name]; ok {
+			t.Errorf("namer.LoadBalancerV2(%q) = namer.LoadBalancerV2(%q) = %q", key, other, name)
+		}
+		names[name] = key
+		if !namer.NameBelongsToCluster(namer.UrlMap(name)) {
+			t.Errorf("Expected url map of %q to belong to the cluster, got %q", key, namer.UrlMap(name))
+		}
+		// The names of the resources are never truncated.
+		if frName := namer.IPv6ForwardingRule(name, HTTPSProtocol); len(frName) > nameLenLimit || !strings.HasSuffix(frName, "--0123456789abcdef") {
+			t.Errorf("Expected forwarding rule of %q to keep the cluster UID, got %q", key, frName)
+		}
+	}
+	if name := namer.LoadBalancerV2("namespace/name"); name != "namespace-name-b8b9a6c0--0123456789abcdef" {
+		t.Errorf("namer.LoadBalancerV2(%q) = %q, want %q", "namespace/name", name, "namespace-name-b8b9a6c0--0123456789abcdef")
+	}
+	if name := namer.LoadBalancerV2("/group"); !strings.HasPrefix(name, "group-") {
+		t.Errorf("namer.LoadBalancerV2(%q) = %q, want the name of the group without namespace", "/group", name)
+	}
+	if name := namer.LoadBalancerV2("/namespace/name"); !strings.HasPrefix(name, "namespace-name-") {
+		t.Errorf("namer.LoadBalancerV2(%q) = %q, want the namespace and name of the group", "/namespace/name", name)
+	}
+
+	// LoadBalancer follows the naming scheme of the key, and returns the
+	// names as is.
+	namer.SetNamingSchemes(map[string]NamingScheme{keys[1]: NamingSchemeV2})
+	for _, key := range keys[1:3] {
+		name := namer.LoadBalancer(key)
+		want := namer.LoadBalancerV2(key)
+		if key != keys[1] {
+			want = truncate(strings.Replace(key, "/", "-", -1) + "--0123456789abcdef")
+		}
+		if name != want {
+			t.Errorf("namer.LoadBalancer(%q) = %q, want %q", key, name, want)
+		}
+		if got := namer.LoadBalancer(name); got != name {
+			t.Errorf("namer.LoadBalancer(%q) = %q, want it unchanged", name, got)
+		}
+	}
+}
+
+func TestNamerPrefix(t *testing.T) {
+	for _, prefix := range []string{"k8s", "a", "acme2", "abcdefgh"} {
+		if err := ValidatePrefix(prefix); err != nil {
+			t.Errorf("ValidatePrefix(%q) = %v, want nil", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"", "K8s", "1k8s", "k8s-a", "abcdefghi"} {
+		if err := ValidatePrefix(prefix); err == nil {
+			t.Errorf("ValidatePrefix(%q) = nil, want error", prefix)
+		}
+	}
+
+	namer := NewNamerWithPrefix("uid1", "fw1", "acme")
+	lbName := namer.LoadBalancer("key1")
+	for _, tc := range []struct {
+		desc string
+		got  string
+		want string
+	}{
+		{"backend", namer.Backend(80), "acme-be-80--uid1"},
+		{"instance group", namer.InstanceGroup(), "acme-ig--uid1"},
+		{"firewall rule", namer.FirewallRule(), "acme-fw-l7--fw1"},
+		{"url map", namer.UrlMap(lbName), "acme-um-key1--uid1"},
+		{"target proxy", namer.TargetProxy(lbName, HTTPSProtocol), "acme-tps-key1--uid1"},
+		{"forwarding rule", namer.ForwardingRule(lbName, HTTPProtocol), "acme-fw-key1--uid1"},
+		{"ssl cert", namer.HashedSSLCert("0123456789abcdef"), "acme-ssl-0123456789abcdef--uid1"},
+		{"neg", namer.NEG("ns", "name", "80"), "acme1-uid1-ns-name-80-" + negSuffix("ns", "name", "80")},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.desc, tc.got, tc.want)
+		}
+	}
+	if port, err := namer.BackendPort(namer.Backend(80)); err != nil || port != "80" {
+		t.Errorf("namer.BackendPort() = %q, %v, want 80", port, err)
+	}
+	if !namer.NameBelongsToCluster(namer.UrlMap(lbName)) || !namer.IsHashedSSLCert(namer.HashedSSLCert("0123456789abcdef")) {
+		t.Errorf("Expected the resources named with the prefix to belong to the cluster")
+	}
+	if NewNamer("uid1", "fw1").NameBelongsToCluster(namer.UrlMap(lbName)) {
+		t.Errorf("Expected the resources of another prefix not to belong to the cluster")
+	}
+
+	// The names of long NEGs and V2 loadbalancers still fit the GCE limit.
+	longstring := "01234567890123456789012345678901234567890123456789"
+	namer = NewNamerWithPrefix("0123456789abcdef", "fw1", "abcdefgh")
+	if neg := namer.NEG(longstring, longstring, longstring); len(neg) > 63 {
+		t.Errorf("namer.NEG() = %q, longer than 63 characters", neg)
+	}
+	if fr := namer.IPv6ForwardingRule(namer.LoadBalancerV2(longstring+"/"+longstring), HTTPSProtocol); len(fr) > nameLenLimit {
+		t.Errorf("namer.IPv6ForwardingRule() = %q, truncated", fr)
+	}
+}
+
 func TestNamerLoadBalancer(t *testing.T) {
 	// TODO: check names for all of the resources
 }
@@ -232,3 +406,103 @@ func TestNamerNEG(t *testing.T) {
 		}
 	}
 }
+
+func TestNamerL4(t *testing.T) {
+	longstring := "01234567890123456789012345678901234567890123456789"
+	testCases := []struct {
+		desc      string
+		namespace string
+		name      string
+		expect    string
+	}{
+		{
+			"simple case",
+			"namespace",
+			"name",
+			"k8s2-0123456789abcdef-namespace-name-b8b9a6c0",
+		},
+		{
+			"long name and namespace",
+			longstring,
+			longstring,
+			"k8s2-0123456789abcdef-01234567890123-01234567890123-e5e3d48e",
+		},
+	}
+
+	namer := NewNamer(clusterId, "")
+	for _, tc := range testCases {
+		res := namer.L4(tc.namespace, tc.name)
+		if !strings.HasPrefix(res, namer.L4Prefix()) {
+			t.Errorf("%s: got %q, want prefix %q", tc.desc, res, namer.L4Prefix())
+		}
+		if fw := namer.L4HealthCheckFirewall(tc.namespace, tc.name); len(fw) > 63 {
+			t.Errorf("%s: got len(%q) == %v, want <= 63", tc.desc, fw, len(fw))
+		}
+		if res != tc.expect {
+			t.Errorf("%s: got %q, want %q", tc.desc, res, tc.expect)
+		}
+	}
+}
+
+func TestNamerServiceAttachment(t *testing.T) {
+	longstring := "01234567890123456789012345678901234567890123456789"
+	testCases := []struct {
+		desc      string
+		namespace string
+		name      string
+		expect    string
+	}{
+		{
+			"simple case",
+			"namespace",
+			"name",
+			"k8s2-0123456789abcdef-sa-namespace-name-b8b9a6c0",
+		},
+		{
+			"long name and namespace",
+			longstring,
+			longstring,
+			"k8s2-0123456789abcdef-sa-01234567890123-01234567890123-e5e3d48e",
+		},
+	}
+
+	namer := NewNamer(clusterId, "")
+	for _, tc := range testCases {
+		res := namer.ServiceAttachment(tc.namespace, tc.name)
+		if len(res) > 63 {
+			t.Errorf("%s: got len(%q) == %v, want <= 63", tc.desc, res, len(res))
+		}
+		if res != tc.expect {
+			t.Errorf("%s: got %q, want %q", tc.desc, res, tc.expect)
+		}
+	}
+}
+
+func TestNamerRegionalL7(t *testing.T) {
+	longstring := "01234567890123456789012345678901234567890123456789"
+	namer := NewNamer(clusterId, "")
+	for _, tc := range []struct {
+		desc   string
+		got    string
+		expect string
+	}{
+		{"url map", namer.RegionalL7("um", "namespace", "name"), "k8s2-0123456789abcdef-um-namespace-name-b8b9a6c0"},
+		{"long url map", namer.RegionalL7("um", longstring, longstring), "k8s2-0123456789abcdef-um-01234567890123-01234567890123-e5e3d48e"},
+		{"certificate", namer.RegionalL7Cert("namespace", "name", "cert"), "k8s2-0123456789abcdef-cr-namespace-name-ae61a4ca"},
+		{"backend", namer.RegionalL7Backend("bi", "namespace", "name", "8080"), "k8s2-0123456789abcdef-bi-namespace-name-8080-bb411501"},
+		{"long backend", namer.RegionalL7Backend("bi", longstring, longstring, longstring), "k8s2-0123456789abcdef-bi-012345678-012345678-012345678-211a1621"},
+	} {
+		if len(tc.got) > 63 {
+			t.Errorf("%s: got len(%q) == %v, want <= 63", tc.desc, tc.got, len(tc.got))
+		}
+		if tc.got != tc.expect {
+			t.Errorf("%s: got %q, want %q", tc.desc, tc.got, tc.expect)
+		}
+	}
+	if !namer.IsRegionalL7Backend("bi", namer.RegionalL7Backend("bi", "namespace", "name", "8080")) {
+		t.Errorf("IsRegionalL7Backend() = false, want true")
+	}
+	if namer.IsRegionalL7Backend("bi", namer.NEG("namespace", "name", "8080")) {
+		t.Errorf("IsRegionalL7Backend() = true for a NEG, want false")
+	}
+}
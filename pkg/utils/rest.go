@@ -0,0 +1,182 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"k8s.io/ingress-gce/pkg/logging"
+)
+
+const (
+	// defaultComputeEndpoint is the endpoint of the compute v1 API.
+	defaultComputeEndpoint = "https://www.googleapis.com/compute/v1/"
+	// OperationPollInterval and OperationPollTimeout bound the wait for the
+	// compute operations.
+	OperationPollInterval = 3 * time.Second
+	OperationPollTimeout  = 30 * time.Minute
+)
+
+// ComputeREST sends requests to the v1 REST API of compute, for the resources
+// and fields the vendored compute API predates. The resources are hand-rolled
+// JSON structs of the packages managing them. The constructors of these
+// packages take the tokenSource, transport and apiEndpoint of
+// NewComputeREST.
+type ComputeREST struct {
+	project  string
+	endpoint string
+	client   *http.Client
+}
+
+// NewComputeREST returns a ComputeREST for the given project.
+// tokenSource: the token source used to authenticate. If nil, the default
+// token source is used.
+// transport: the transport of the requests, eg: to rate limit them. If nil,
+// the default transport is used.
+// apiEndpoint: the v1 compute API endpoint. If empty, the default endpoint
+// is used.
+func NewComputeREST(project string, tokenSource oauth2.TokenSource, transport http.RoundTripper, apiEndpoint string) (*ComputeREST, error) {
+	if tokenSource == nil {
+		var err error
+		tokenSource, err = google.DefaultTokenSource(oauth2.NoContext, compute.CloudPlatformScope, compute.ComputeScope)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if apiEndpoint == "" {
+		apiEndpoint = defaultComputeEndpoint
+	}
+	if !strings.HasSuffix(apiEndpoint, "/") {
+		apiEndpoint += "/"
+	}
+	client := NewOAuthClient(tokenSource, transport)
+	client.Timeout = 30 * time.Second
+	return &ComputeREST{project: project, endpoint: apiEndpoint, client: client}, nil
+}
+
+// NewOAuthClient returns an HTTP client authenticating its requests with the
+// given token source, and sending them through the given transport. A nil
+// transport is the default transport.
+func NewOAuthClient(tokenSource oauth2.TokenSource, transport http.RoundTripper) *http.Client {
+	ctx := context.Background()
+	if transport != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+	}
+	return oauth2.NewClient(ctx, tokenSource)
+}
+
+// GlobalURL returns the URL of the given resource of the given global
+// collection, of the collection if name is empty.
+func (c *ComputeREST) GlobalURL(collection, name string) string {
+	return c.url(fmt.Sprintf("global/%v", collection), name)
+}
+
+// RegionalURL returns the URL of the given resource of the given regional
+// collection, of the collection if name is empty.
+func (c *ComputeREST) RegionalURL(region, collection, name string) string {
+	return c.url(fmt.Sprintf("regions/%v/%v", url.PathEscape(region), collection), name)
+}
+
+// ZonalURL returns the URL of the given resource of the given zonal
+// collection, of the collection if name is empty.
+func (c *ComputeREST) ZonalURL(zone, collection, name string) string {
+	return c.url(fmt.Sprintf("zones/%v/%v", url.PathEscape(zone), collection), name)
+}
+
+func (c *ComputeREST) url(collection, name string) string {
+	u := fmt.Sprintf("%vprojects/%v/%v", c.endpoint, url.PathEscape(c.project), collection)
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	return u
+}
+
+// DoOp sends a request returning an operation, global, regional or zonal,
+// and waits for the operation to be done.
+func (c *ComputeREST) DoOp(method, u string, in interface{}) error {
+	op := &compute.Operation{}
+	if err := c.Do(method, u, in, op); err != nil {
+		return err
+	}
+	return wait.PollImmediate(OperationPollInterval, OperationPollTimeout, func() (bool, error) {
+		if op.Status == "DONE" {
+			return true, computeOperationError(op)
+		}
+		current := &compute.Operation{}
+		if err := c.Do("GET", op.SelfLink, nil, current); err != nil {
+			// The operation goes on, retry on the next poll.
+			logging.Warningf("Failed to poll operation %v: %v", op.Name, err)
+			return false, nil
+		}
+		op = current
+		return op.Status == "DONE", computeOperationError(op)
+	})
+}
+
+// computeOperationError returns the error of the given operation, nil if it
+// succeeded.
+func computeOperationError(op *compute.Operation) error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	code := int(op.HttpErrorStatusCode)
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	return &googleapi.Error{Code: code, Message: op.Error.Errors[0].Message}
+}
+
+// Do sends a request with the given body, encoded in JSON if not nil, and
+// decodes the response into out. Returns a googleapi.Error for the error
+// responses.
+func (c *ComputeREST) Do(method, u string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
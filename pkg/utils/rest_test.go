@@ -0,0 +1,70 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestComputeREST(t *testing.T) {
+	polls := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Got authorization %q", got)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /projects/p/global/backendServices/be":
+			w.Write([]byte(`{"name": "be"}`))
+		case "PATCH /projects/p/global/backendServices/be":
+			fmt.Fprintf(w, `{"name": "op", "status": "RUNNING", "selfLink": "%v/projects/p/global/operations/op"}`, server.URL)
+		case "GET /projects/p/global/operations/op":
+			polls++
+			w.Write([]byte(`{"name": "op", "status": "DONE"}`))
+		case "DELETE /projects/p/zones/z/networkEndpointGroups/neg":
+			w.Write([]byte(`{"name": "op", "status": "DONE", "error": {"errors": [{"message": "in use"}]}, "httpErrorStatusCode": 400}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	rest, err := NewComputeREST("p", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), nil, server.URL)
+	if err != nil {
+		t.Fatalf("NewComputeREST() = %v", err)
+	}
+	be := struct{ Name string }{}
+	if err := rest.Do("GET", rest.GlobalURL("backendServices", "be"), nil, &be); err != nil || be.Name != "be" {
+		t.Errorf("Do() = %+v, %v, want the backend service be", be, err)
+	}
+	if err := rest.DoOp("PATCH", rest.GlobalURL("backendServices", "be"), map[string]string{"name": "be"}); err != nil || polls != 1 {
+		t.Errorf("DoOp() = %v after %v polls, want the operation to be done", err, polls)
+	}
+	if err := rest.DoOp("DELETE", rest.ZonalURL("z", "networkEndpointGroups", "neg"), nil); err == nil || err.(*googleapi.Error).Code != http.StatusBadRequest {
+		t.Errorf("DoOp() = %v, want the error of the operation", err)
+	}
+	if err := rest.Do("GET", rest.RegionalURL("r", "urlMaps", "missing"), nil, &be); err == nil || err.(*googleapi.Error).Code != http.StatusNotFound {
+		t.Errorf("Do() = %v, want a not found error", err)
+	}
+}
@@ -53,6 +53,9 @@ const (
 	ProtocolHTTP AppProtocol = "HTTP"
 	// ProtocolHTTPS protocol for a service
 	ProtocolHTTPS AppProtocol = "HTTPS"
+	// ProtocolHTTP2 protocol for a service, HTTP/2 over TLS. Also used for
+	// gRPC services.
+	ProtocolHTTP2 AppProtocol = "HTTP2"
 )
 
 type AppProtocol string
@@ -99,6 +102,158 @@ func (g GCEURLMap) PutDefaultBackend(d *compute.BackendService) {
 	}
 }
 
+// URLMapRoutes are the routes of the paths of a GCEURLMap other than to
+// their backend service, and the route rules of its hosts, which the vendored
+// compute API predates. A path of the GCEURLMap with a nil backend is only
+// served by its route.
+type URLMapRoutes struct {
+	// Paths are the routes of the paths, by host and path.
+	Paths map[string]map[string]*PathRoute
+	// Rules are the route rules of the hosts, by host. They take precedence
+	// over the paths of their host.
+	Rules map[string][]*RouteRule
+}
+
+// HostRules returns the route rules of the given host, nil if none.
+func (r *URLMapRoutes) HostRules(host string) []*RouteRule {
+	if r == nil {
+		return nil
+	}
+	return r.Rules[host]
+}
+
+// AddRule adds the given route rule to the given host.
+func (r *URLMapRoutes) AddRule(host string, rule *RouteRule) {
+	if r.Rules == nil {
+		r.Rules = map[string][]*RouteRule{}
+	}
+	r.Rules[host] = append(r.Rules[host], rule)
+}
+
+// Path returns the route of the given path of the given host, nil if none.
+func (r *URLMapRoutes) Path(host, path string) *PathRoute {
+	if r == nil {
+		return nil
+	}
+	return r.Paths[host][path]
+}
+
+// PutPath sets the route of the given path of the given host.
+func (r *URLMapRoutes) PutPath(host, path string, route *PathRoute) {
+	if r.Paths == nil {
+		r.Paths = map[string]map[string]*PathRoute{}
+	}
+	if r.Paths[host] == nil {
+		r.Paths[host] = map[string]*PathRoute{}
+	}
+	r.Paths[host][path] = route
+}
+
+// PathRoute is the route of a url map path.
+type PathRoute struct {
+	// Redirect, if set, answers the requests of the path with a redirect
+	// instead of serving them from a backend service.
+	Redirect *URLRedirect
+	// Rewrite, if set, rewrites the requests of the path before they are
+	// forwarded to its backend service.
+	Rewrite *URLRewrite
+	// WeightedBackends, if set, split the requests of the path between
+	// several backend services instead of its backend service.
+	WeightedBackends []WeightedBackend
+}
+
+// WeightedBackend is a backend service getting a share of the requests of a
+// url map path, proportional to its weight.
+type WeightedBackend struct {
+	// BackendService is the link of the backend service.
+	BackendService string
+	Weight         int64
+}
+
+// URLRedirect is a redirect of the requests of a url map path, with the
+// fields of the GCE API. The parts of the URL without a redirect are kept.
+type URLRedirect struct {
+	HostRedirect string `json:"hostRedirect,omitempty"`
+	PathRedirect string `json:"pathRedirect,omitempty"`
+	// PrefixRedirect replaces the prefix matched by a "/*" path.
+	PrefixRedirect string `json:"prefixRedirect,omitempty"`
+	HttpsRedirect  bool   `json:"httpsRedirect,omitempty"`
+	StripQuery     bool   `json:"stripQuery,omitempty"`
+	// RedirectResponseCode is one of MOVED_PERMANENTLY_DEFAULT, FOUND,
+	// SEE_OTHER, TEMPORARY_REDIRECT or PERMANENT_REDIRECT.
+	RedirectResponseCode string `json:"redirectResponseCode,omitempty"`
+}
+
+// RouteRule is a route rule of a url map host, routing the requests matching
+// any of its matches.
+type RouteRule struct {
+	// Priority orders the rules of a host, the lowest first. Rules with the
+	// same priority keep their order.
+	Priority int64
+	Matches  []RouteMatch
+	// Service is the link of the backend service of the rule, unless its
+	// route answers or splits the requests.
+	Service      string
+	Route        PathRoute
+	HeaderAction *HeaderAction
+}
+
+// RouteMatch matches requests on their path, and optionally their headers and
+// query parameters, with the fields of the GCE API. Exactly one of
+// PrefixMatch and FullPathMatch is set.
+type RouteMatch struct {
+	PrefixMatch           string                `json:"prefixMatch,omitempty"`
+	FullPathMatch         string                `json:"fullPathMatch,omitempty"`
+	HeaderMatches         []HeaderMatch         `json:"headerMatches,omitempty"`
+	QueryParameterMatches []QueryParameterMatch `json:"queryParameterMatches,omitempty"`
+}
+
+// HeaderMatch matches a request header, the method being the ":method"
+// header. Exactly one of the matches is set.
+type HeaderMatch struct {
+	HeaderName   string `json:"headerName"`
+	ExactMatch   string `json:"exactMatch,omitempty"`
+	PrefixMatch  string `json:"prefixMatch,omitempty"`
+	SuffixMatch  string `json:"suffixMatch,omitempty"`
+	RegexMatch   string `json:"regexMatch,omitempty"`
+	PresentMatch bool   `json:"presentMatch,omitempty"`
+	// InvertMatch matches the requests not matching the header.
+	InvertMatch bool `json:"invertMatch,omitempty"`
+}
+
+// QueryParameterMatch matches a query parameter. Exactly one of the matches
+// is set.
+type QueryParameterMatch struct {
+	Name         string `json:"name"`
+	ExactMatch   string `json:"exactMatch,omitempty"`
+	RegexMatch   string `json:"regexMatch,omitempty"`
+	PresentMatch bool   `json:"presentMatch,omitempty"`
+}
+
+// HeaderAction adds or removes headers of the requests routed by a url map,
+// or of their responses, with the fields of the GCE API.
+type HeaderAction struct {
+	RequestHeadersToAdd     []HeaderValue `json:"requestHeadersToAdd,omitempty"`
+	RequestHeadersToRemove  []string      `json:"requestHeadersToRemove,omitempty"`
+	ResponseHeadersToAdd    []HeaderValue `json:"responseHeadersToAdd,omitempty"`
+	ResponseHeadersToRemove []string      `json:"responseHeadersToRemove,omitempty"`
+}
+
+// HeaderValue is a header added by a HeaderAction. Replace replaces the
+// values of the header instead of appending to them.
+type HeaderValue struct {
+	HeaderName  string `json:"headerName"`
+	HeaderValue string `json:"headerValue"`
+	Replace     bool   `json:"replace,omitempty"`
+}
+
+// URLRewrite is a rewrite of the requests of a url map path, with the fields
+// of the GCE API.
+type URLRewrite struct {
+	PathPrefixRewrite string `json:"pathPrefixRewrite,omitempty"`
+	HostRewrite       string `json:"hostRewrite,omitempty"`
+}
+
 // FakeGoogleAPIForbiddenErr creates a Forbidden error with type googleapi.Error
 func FakeGoogleAPIForbiddenErr() *googleapi.Error {
 	return &googleapi.Error{Code: http.StatusForbidden}
@@ -190,3 +345,65 @@ func trimFieldsEvenly(max int, fields ...string) []string {
 
 	return ret
 }
+
+// The pathTypes of Ingress paths.
+const (
+	// PathTypeImplementationSpecific paths are GCE paths, see ValidatePath.
+	// It is the default.
+	PathTypeImplementationSpecific = "ImplementationSpecific"
+	// PathTypeExact paths match the path of the requests exactly.
+	PathTypeExact = "Exact"
+	// PathTypePrefix paths match the path of the requests element-wise, eg:
+	// /foo matches /foo and /foo/bar but not /foobar.
+	PathTypePrefix = "Prefix"
+)
+
+// GCEPaths returns the GCE url map paths matching the requests of the given
+// Ingress path with the given pathType: the path itself for Exact paths, and
+// both /foo and /foo/* for Prefix /foo, or /foo/, paths.
+func GCEPaths(path, pathType string) ([]string, error) {
+	switch pathType {
+	case "", PathTypeImplementationSpecific:
+		if err := ValidatePath(path); err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	case PathTypeExact, PathTypePrefix:
+	default:
+		return nil, fmt.Errorf("unknown pathType %v", pathType)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must start with /")
+	}
+	// GCE would match a trailing /* as a prefix.
+	if strings.ContainsAny(path, "*?#") {
+		return nil, fmt.Errorf("%v paths can't contain *, ? or #", pathType)
+	}
+	if pathType == PathTypeExact {
+		return []string{path}, nil
+	}
+	prefix := strings.TrimRight(path, "/")
+	if prefix == "" {
+		return []string{"/*"}, nil
+	}
+	return []string{prefix, prefix + "/*"}, nil
+}
+
+// ValidatePath returns an error if the given Ingress path can't be translated
+// to a GCE url map path rule. GCE paths are matched exactly, or as a prefix if
+// they end with "/*", they are not regexes. The empty path is the catch-all.
+func ValidatePath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	if strings.ContainsAny(path, "?#") {
+		return fmt.Errorf("path can't contain ? or #")
+	}
+	if i := strings.Index(path, "*"); i >= 0 && (i != len(path)-1 || !strings.HasSuffix(path, "/*")) {
+		return fmt.Errorf("* is only allowed at the end of the path, after a /, regexes are not supported")
+	}
+	return nil
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package utils
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -95,3 +96,56 @@ func TestTrimFieldsEvenly(t *testing.T) {
 		}
 	}
 }
+
+func TestGCEPaths(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		pathType string
+		want     []string
+		wantErr  bool
+	}{
+		{path: "/foo/*", want: []string{"/foo/*"}},
+		{path: "/foo/*", pathType: PathTypeImplementationSpecific, want: []string{"/foo/*"}},
+		{path: "/foo*", pathType: PathTypeImplementationSpecific, wantErr: true},
+		{path: "/foo", pathType: PathTypeExact, want: []string{"/foo"}},
+		{path: "/foo/", pathType: PathTypeExact, want: []string{"/foo/"}},
+		{path: "/foo", pathType: PathTypePrefix, want: []string{"/foo", "/foo/*"}},
+		{path: "/foo/", pathType: PathTypePrefix, want: []string{"/foo", "/foo/*"}},
+		{path: "/", pathType: PathTypePrefix, want: []string{"/*"}},
+		{path: "/foo/*", pathType: PathTypePrefix, wantErr: true},
+		{path: "/foo/*", pathType: PathTypeExact, wantErr: true},
+		{path: "foo", pathType: PathTypeExact, wantErr: true},
+		{path: "/foo", pathType: "Regex", wantErr: true},
+	} {
+		got, err := GCEPaths(tc.path, tc.pathType)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("GCEPaths(%q, %q) = %v, want error %v", tc.path, tc.pathType, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GCEPaths(%q, %q) = %v, want %v", tc.path, tc.pathType, got, tc.want)
+		}
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	for _, tc := range []struct {
+		path    string
+		wantErr bool
+	}{
+		{path: ""},
+		{path: "/"},
+		{path: "/*"},
+		{path: "/foo"},
+		{path: "/foo/*"},
+		{path: "foo", wantErr: true},
+		{path: "/foo*", wantErr: true},
+		{path: "/foo/*/bar", wantErr: true},
+		{path: "/foo/.*", wantErr: true},
+		{path: "/foo?bar=baz", wantErr: true},
+	} {
+		if err := ValidatePath(tc.path); (err != nil) != tc.wantErr {
+			t.Errorf("ValidatePath(%q) = %v, want error %v", tc.path, err, tc.wantErr)
+		}
+	}
+}